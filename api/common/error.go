@@ -38,6 +38,9 @@ var (
 	// ErrMsgFailedToJSONMarshalResponseBody respresents error message for failure to marshal
 	// response body into json.
 	ErrMsgFailedToJSONMarshalResponseBody = "Failed to marshal the response body into json"
+	// ErrMsgFailedToProtoMarshalResponseBody respresents error message for failure to marshal
+	// response body into protobuf.
+	ErrMsgFailedToProtoMarshalResponseBody = "Failed to marshal the response body into protobuf"
 	// ErrMissingParameter represents api error for missing parameter
 	ErrMissingParameter = utils.APIError{
 		Code:    http.StatusBadRequest,
@@ -74,4 +77,10 @@ var (
 		Code:    http.StatusInternalServerError,
 		Message: ErrMsgFailedToJSONMarshalResponseBody,
 	}
+	// ErrFailedToProtoMarshalResponseBody represents the api error for failure to marshal
+	// response body into protobuf.
+	ErrFailedToProtoMarshalResponseBody = utils.APIError{
+		Code:    http.StatusInternalServerError,
+		Message: ErrMsgFailedToProtoMarshalResponseBody,
+	}
 )
@@ -0,0 +1,126 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/uber/aresdb/utils"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const (
+	// ShutdownComponent is the component reported as not ready during graceful shutdown.
+	ShutdownComponent = "shutdown"
+)
+
+// ReadinessCheck checks whether a component is ready to serve, returns the reason otherwise.
+type ReadinessCheck func() error
+
+// ComponentFailure describes a component failing the readiness check.
+type ComponentFailure struct {
+	Component string `json:"component"`
+	Error     string `json:"error"`
+}
+
+// ReadinessReport is the result of readiness checks of all components.
+type ReadinessReport struct {
+	Ready    bool               `json:"ready"`
+	Failures []ComponentFailure `json:"failures,omitempty"`
+}
+
+type namedReadinessCheck struct {
+	component string
+	check     ReadinessCheck
+}
+
+// HealthChecker serves liveness and readiness of the server. The server is live as long as the process
+// can serve http requests, and is ready when all components pass their readiness checks and the server
+// is not shutting down.
+type HealthChecker struct {
+	sync.RWMutex
+	checks       []namedReadinessCheck
+	shuttingDown bool
+}
+
+// NewHealthChecker creates a new HealthChecker without any readiness checks.
+func NewHealthChecker() *HealthChecker {
+	return &HealthChecker{}
+}
+
+// AddReadinessCheck adds the readiness check of the component, components are checked in the order
+// they are added.
+func (c *HealthChecker) AddReadinessCheck(component string, check ReadinessCheck) {
+	c.Lock()
+	defer c.Unlock()
+	c.checks = append(c.checks, namedReadinessCheck{component: component, check: check})
+}
+
+// Shutdown marks the server as shutting down, readiness will fail from now on so that load balancers
+// stop routing new requests to the server.
+func (c *HealthChecker) Shutdown() {
+	c.Lock()
+	defer c.Unlock()
+	c.shuttingDown = true
+}
+
+// CheckReadiness runs readiness checks of all components and reports the failing ones.
+func (c *HealthChecker) CheckReadiness() ReadinessReport {
+	c.RLock()
+	checks := c.checks
+	shuttingDown := c.shuttingDown
+	c.RUnlock()
+
+	report := ReadinessReport{Ready: true}
+	if shuttingDown {
+		report.Failures = append(report.Failures, ComponentFailure{
+			Component: ShutdownComponent,
+			Error:     "server is shutting down",
+		})
+	}
+	for _, check := range checks {
+		if err := check.check(); err != nil {
+			report.Failures = append(report.Failures, ComponentFailure{
+				Component: check.component,
+				Error:     err.Error(),
+			})
+		}
+	}
+	report.Ready = len(report.Failures) == 0
+	return report
+}
+
+// Register registers liveness and readiness endpoints on the router.
+func (c *HealthChecker) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/health/live", utils.ApplyHTTPWrappers(c.Live, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/health/ready", utils.ApplyHTTPWrappers(c.Ready, wrappers)).Methods(http.MethodGet)
+}
+
+// Live responds 200 as long as the server is up.
+func (c *HealthChecker) Live(w http.ResponseWriter, r *http.Request) {
+	setCommonHeaders(w)
+	io.WriteString(w, "OK")
+}
+
+// Ready responds 200 if all components are ready, otherwise 503 with failing components.
+func (c *HealthChecker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.CheckReadiness()
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	RespondJSONObjectWithCode(w, code, report)
+}
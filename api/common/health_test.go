@@ -0,0 +1,91 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = ginkgo.Describe("health checker", func() {
+	var checker *HealthChecker
+	var router *mux.Router
+
+	ginkgo.BeforeEach(func() {
+		checker = NewHealthChecker()
+		router = mux.NewRouter()
+		checker.Register(router)
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	ginkgo.It("should be live and ready without checks", func() {
+		w := get("/health/live")
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(Equal("OK"))
+
+		w = get("/health/ready")
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(MatchJSON(`{"ready": true}`))
+	})
+
+	ginkgo.It("should report failing components", func() {
+		var schemaErr error
+		checker.AddReadinessCheck("schema", func() error {
+			return schemaErr
+		})
+		checker.AddReadinessCheck("disk", func() error {
+			return errors.New("disk space critical")
+		})
+
+		Ω(checker.CheckReadiness()).Should(Equal(ReadinessReport{
+			Failures: []ComponentFailure{{Component: "disk", Error: "disk space critical"}},
+		}))
+
+		schemaErr = errors.New("schema not loaded")
+		w := get("/health/ready")
+		Ω(w.Code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(w.Body.String()).Should(MatchJSON(`{
+			"ready": false,
+			"failures": [
+				{"component": "schema", "error": "schema not loaded"},
+				{"component": "disk", "error": "disk space critical"}
+			]
+		}`))
+
+		// liveness does not depend on readiness.
+		Ω(get("/health/live").Code).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should not be ready during shutdown", func() {
+		Ω(checker.CheckReadiness().Ready).Should(BeTrue())
+		checker.Shutdown()
+		w := get("/health/ready")
+		Ω(w.Code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(w.Body.String()).Should(MatchJSON(`{
+			"ready": false,
+			"failures": [{"component": "shutdown", "error": "server is shutting down"}]
+		}`))
+		Ω(get("/health/live").Code).Should(Equal(http.StatusOK))
+	})
+})
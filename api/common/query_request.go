@@ -71,6 +71,9 @@ type SQLRequest struct {
 	Profiling string `query:"profiling,optional" json:"profiling"`
 	// in: query
 	DeviceChoosingTimeout int `query:"timeout,optional" json:"timeout"`
+	// Whether queries can reference deprecated columns, which are hidden by default.
+	// in: query
+	IncludeDeprecatedColumns int `query:"includeDeprecatedColumns,optional" json:"includeDeprecatedColumns"`
 	// in: header
 	Accept string `header:"Accept,optional" json:"accept"`
 	// in: header
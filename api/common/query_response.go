@@ -24,3 +24,10 @@ type AQLResponse struct {
 	//in: body
 	Body queryCom.AQLResponse
 }
+
+// QueryV2Response represents v2 query response.
+// swagger:response queryResponseV2
+type QueryV2Response struct {
+	//in: body
+	Body QueryResponseV2
+}
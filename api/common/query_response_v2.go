@@ -0,0 +1,264 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	queryCom "github.com/uber/aresdb/query/common"
+	queryProto "github.com/uber/aresdb/query/generated/proto"
+	"github.com/uber/aresdb/utils"
+)
+
+// QueryErrorV2 is the structured error of v2 query responses.
+type QueryErrorV2 struct {
+	Code      utils.ErrorCode   `json:"code"`
+	Message   string            `json:"message"`
+	Retriable bool              `json:"retriable"`
+	Hosts     []utils.HostError `json:"hosts,omitempty"`
+}
+
+// NewQueryErrorV2 creates the structured error of err.
+func NewQueryErrorV2(err error) *QueryErrorV2 {
+	code := utils.GetErrorCode(err)
+	queryErr := &QueryErrorV2{
+		Code:      code,
+		Message:   utils.GetErrorMessage(err),
+		Retriable: utils.GetErrorCodeInfo(code).Retriable,
+	}
+	if codedErr, ok := err.(*utils.CodedError); ok {
+		queryErr.Hosts = codedErr.HostErrors
+	}
+	return queryErr
+}
+
+// Error returns the message of the error, so that it can be returned as an error by clients.
+func (e *QueryErrorV2) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// ToCodedError converts the error back to a CodedError, e.g. received by a client.
+func (e *QueryErrorV2) ToCodedError() *utils.CodedError {
+	return &utils.CodedError{
+		Code:       e.Code,
+		Message:    e.Message,
+		HostErrors: e.Hosts,
+	}
+}
+
+// ToProto converts the error to its protobuf message.
+func (e *QueryErrorV2) ToProto() *queryProto.QueryError {
+	p := &queryProto.QueryError{
+		Code:      string(e.Code),
+		Message:   e.Message,
+		Retriable: e.Retriable,
+	}
+	for _, hostErr := range e.Hosts {
+		p.Hosts = append(p.Hosts, &queryProto.HostError{
+			Host:      hostErr.Host,
+			Code:      string(hostErr.Code),
+			Message:   hostErr.Message,
+			Retriable: hostErr.Retriable,
+		})
+	}
+	return p
+}
+
+// PartialErrorsV2 is the errors of datanodes failed by queries returning partial results, broker only.
+type PartialErrorsV2 struct {
+	Hosts []utils.HostError `json:"hosts"`
+	// fraction of shards of the cluster covered by results.
+	ShardCoverage float64 `json:"shardCoverage"`
+}
+
+// QueryStatsV2 is the execution stats of v2 query responses.
+type QueryStatsV2 struct {
+	LatencyMillis float64 `json:"latencyMillis"`
+	// number of queries sent to datanodes, broker only.
+	DataNodeQueries int `json:"dataNodeQueries,omitempty"`
+	// number of rows scanned on datanodes, unknown (0) for eager flushed responses whose headers are written
+	// before scanning.
+	RowsScanned int64 `json:"rowsScanned,omitempty"`
+}
+
+// QueryMetadataV2 is the metadata of v2 query responses.
+type QueryMetadataV2 struct {
+	RequestID string `json:"requestID"`
+	// unix seconds of the latest event ingested into the stalest queried shard, 0 if unknown.
+	DataFreshness int64 `json:"dataFreshness,omitempty"`
+	// whether results miss data of some shards, see warnings for details.
+	Partial  bool         `json:"partial"`
+	Warnings []string     `json:"warnings,omitempty"`
+	Stats    QueryStatsV2 `json:"stats"`
+	// whether each top level time bucket ends before data freshness by bucket keys, only for aggregation
+	// queries grouped by time buckets first with bucketCompleteness requested, broker only.
+	BucketCompleteness map[string]bool `json:"bucketCompleteness,omitempty"`
+	// errors of datanodes failed by queries returning partial results, not part of protobuf responses,
+	// broker only.
+	Errors *PartialErrorsV2 `json:"errors,omitempty"`
+	// schema version (incarnation.version) of the main table queries were evaluated against, of the first
+	// query of multi-query requests, datanode only.
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+}
+
+// DataNodeStatsV2 is the stats of queries sent to a datanode by a broker query.
+type DataNodeStatsV2 struct {
+	Host string `json:"host"`
+	// max latency of scans of shards assigned to the datanode, including retries on replicas, 0 if the
+	// datanode was only queried for retries or hedges of other datanodes.
+	LatencyMillis float64 `json:"latencyMillis"`
+	// number of queries sent to the datanode, including retries and hedged queries.
+	Queries int `json:"queries"`
+	// number of bytes of responses read from the datanode.
+	Bytes int64 `json:"bytes"`
+	// number of rows scanned by the datanode, 0 if not reported.
+	RowsScanned int64 `json:"rowsScanned"`
+	// number of retries of scans of shards assigned to the datanode.
+	Retries int `json:"retries"`
+}
+
+// QueryMetaV2 is the verbose metadata of v2 query responses requested by verbose, broker only.
+type QueryMetaV2 struct {
+	// id assigned to the query by broker.
+	QueryID string `json:"queryId,omitempty"`
+	// stats of datanodes queried sorted by host.
+	DataNodes []DataNodeStatsV2 `json:"dataNodes"`
+}
+
+// QueryResponseV2 is the response envelope of the v2 query api. Error is set if the request failed, or
+// the most severe error if any query of a multi-query request failed. Errors are set by query index for
+// multi-query requests with failed queries.
+type QueryResponseV2 struct {
+	Results  []interface{}   `json:"results,omitempty"`
+	Errors   []*QueryErrorV2 `json:"errors,omitempty"`
+	Error    *QueryErrorV2   `json:"error,omitempty"`
+	Metadata QueryMetadataV2 `json:"metadata"`
+	// verbose metadata, not part of protobuf responses.
+	Meta *QueryMetaV2 `json:"meta,omitempty"`
+}
+
+// NewQueryMetadataV2 creates the metadata of the request with the request id of the request, see utils.GetRequestID.
+func NewQueryMetadataV2(r *http.Request) QueryMetadataV2 {
+	return QueryMetadataV2{RequestID: utils.GetRequestID(r)}
+}
+
+// SetLatency sets the latency of the request started at start.
+func (m *QueryMetadataV2) SetLatency(start time.Time) {
+	m.Stats.LatencyMillis = float64(utils.Now().Sub(start)) / float64(time.Millisecond)
+}
+
+// WriteHeaders writes metadata carried in headers, for responses not in the envelope (e.g. application/hll).
+func (m *QueryMetadataV2) WriteHeaders(w http.ResponseWriter) {
+	w.Header().Set(utils.HTTPRequestIDHeaderKey, m.RequestID)
+	if m.DataFreshness > 0 {
+		w.Header().Set(utils.HTTPDataFreshnessHeaderKey, strconv.FormatInt(m.DataFreshness, 10))
+	}
+	if m.Stats.RowsScanned > 0 {
+		w.Header().Set(utils.HTTPRowsScannedHeaderKey, strconv.FormatInt(m.Stats.RowsScanned, 10))
+	}
+	if m.SchemaVersion != "" {
+		w.Header().Set(utils.HTTPSchemaVersionHeaderKey, m.SchemaVersion)
+	}
+}
+
+// RespondV2 writes the v2 response, with the status code of the error if any.
+func RespondV2(w http.ResponseWriter, response QueryResponseV2) {
+	code := http.StatusOK
+	if response.Error != nil {
+		code = utils.GetErrorCodeInfo(response.Error.Code).HTTPStatus
+	}
+	response.Metadata.WriteHeaders(w)
+	RespondJSONObjectWithCode(w, code, response)
+}
+
+// RespondV2Proto writes the v2 response in protobuf, with the status code of the error if any. Results
+// can be either AQLQueryResult or its json, errors by query index of multi-query requests are not part
+// of protobuf responses.
+func RespondV2Proto(w http.ResponseWriter, response QueryResponseV2) {
+	p := &queryProto.QueryResponse{
+		Metadata: response.Metadata.ToProto(),
+	}
+	if response.Error == nil {
+		for _, result := range response.Results {
+			resultProto, err := queryResultToProto(result)
+			if err != nil {
+				response.Error = NewQueryErrorV2(err)
+				p.Results = nil
+				break
+			}
+			p.Results = append(p.Results, resultProto)
+		}
+	}
+
+	code := http.StatusOK
+	if response.Error != nil {
+		code = utils.GetErrorCodeInfo(response.Error.Code).HTTPStatus
+		p.Error = response.Error.ToProto()
+	}
+	response.Metadata.WriteHeaders(w)
+	RespondProtoWithCode(w, code, p)
+}
+
+// queryResultToProto converts the query result or its json to protobuf.
+func queryResultToProto(result interface{}) (*queryProto.QueryResult, error) {
+	var aqlResult queryCom.AQLQueryResult
+	switch r := result.(type) {
+	case queryCom.AQLQueryResult:
+		aqlResult = r
+	case json.RawMessage:
+		if err := json.Unmarshal(r, &aqlResult); err != nil {
+			return nil, utils.StackError(err, "failed to decode query result")
+		}
+	case nil:
+	default:
+		return nil, utils.StackError(nil, "unsupported query result type %T", result)
+	}
+	return queryCom.AQLQueryResultToProto(aqlResult)
+}
+
+// RespondWithV2Error writes the v2 response of the failed request.
+func RespondWithV2Error(w http.ResponseWriter, metadata QueryMetadataV2, err error) {
+	RespondV2(w, QueryResponseV2{
+		Error:    NewQueryErrorV2(err),
+		Metadata: metadata,
+	})
+}
+
+// ToProto converts the metadata to its protobuf message.
+func (m *QueryMetadataV2) ToProto() *queryProto.QueryMetadata {
+	return &queryProto.QueryMetadata{
+		RequestID:     m.RequestID,
+		DataFreshness: m.DataFreshness,
+		Partial:       m.Partial,
+		Warnings:      m.Warnings,
+		Stats: &queryProto.QueryStats{
+			LatencyMillis:   m.Stats.LatencyMillis,
+			DataNodeQueries: int64(m.Stats.DataNodeQueries),
+			RowsScanned:     m.Stats.RowsScanned,
+		},
+		BucketCompleteness: m.BucketCompleteness,
+	}
+}
+
+// UpdateDataFreshness updates data freshness with the freshness of a query or a host, the stalest
+// known freshness is kept.
+func (m *QueryMetadataV2) UpdateDataFreshness(freshness int64) {
+	if freshness > 0 && (m.DataFreshness == 0 || freshness < m.DataFreshness) {
+		m.DataFreshness = freshness
+	}
+}
@@ -0,0 +1,74 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("v2 query response", func() {
+	ginkgo.It("NewQueryMetadataV2 should work", func() {
+		r := httptest.NewRequest(http.MethodPost, "/v2/query/aql", nil)
+		Ω(NewQueryMetadataV2(r).RequestID).Should(HaveLen(32))
+		r.Header.Set(utils.HTTPRequestIDHeaderKey, "request1")
+		Ω(NewQueryMetadataV2(r).RequestID).Should(Equal("request1"))
+	})
+
+	ginkgo.It("UpdateDataFreshness should keep the stalest freshness", func() {
+		var metadata QueryMetadataV2
+		for _, freshness := range []int64{0, 200, 100, 0, 300} {
+			metadata.UpdateDataFreshness(freshness)
+		}
+		Ω(metadata.DataFreshness).Should(BeEquivalentTo(100))
+	})
+
+	ginkgo.It("RespondV2 should work", func() {
+		w := httptest.NewRecorder()
+		RespondV2(w, QueryResponseV2{
+			Results:  []interface{}{map[string]int{"foo": 1}},
+			Metadata: QueryMetadataV2{RequestID: "request1", DataFreshness: 100, Stats: QueryStatsV2{RowsScanned: 10}},
+		})
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Header().Get(utils.HTTPRequestIDHeaderKey)).Should(Equal("request1"))
+		Ω(w.Header().Get(utils.HTTPDataFreshnessHeaderKey)).Should(Equal("100"))
+		Ω(w.Header().Get(utils.HTTPRowsScannedHeaderKey)).Should(Equal("10"))
+		Ω(w.Body.String()).Should(MatchJSON(`{"results": [{"foo": 1}], "metadata": {"requestID": "request1",
+			"dataFreshness": 100, "partial": false, "stats": {"latencyMillis": 0, "rowsScanned": 10}}}`))
+	})
+
+	ginkgo.It("RespondWithV2Error should work", func() {
+		err := utils.NewCodedError(utils.ErrCodeDataNodeFailure, nil, "1 errors happened executing merge node")
+		err.HostErrors = []utils.HostError{{Host: "host1", Code: utils.ErrCodeResourceExhausted, Message: "no device", Retriable: true}}
+
+		w := httptest.NewRecorder()
+		RespondWithV2Error(w, QueryMetadataV2{RequestID: "request1"}, err)
+		Ω(w.Code).Should(Equal(http.StatusBadGateway))
+		Ω(w.Body.String()).Should(MatchJSON(`{"error": {"code": "DATANODE_FAILURE",
+			"message": "1 errors happened executing merge node", "retriable": true,
+			"hosts": [{"host": "host1", "code": "RESOURCE_EXHAUSTED", "message": "no device", "retriable": true}]},
+			"metadata": {"requestID": "request1", "partial": false, "stats": {"latencyMillis": 0}}}`))
+
+		w = httptest.NewRecorder()
+		RespondWithV2Error(w, QueryMetadataV2{RequestID: "request1"}, utils.APIError{Code: http.StatusBadRequest, Message: "bad request"})
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(NewQueryErrorV2(utils.APIError{Code: http.StatusBadRequest, Message: "bad request"}).ToCodedError()).
+			Should(Equal(utils.NewCodedError(utils.ErrCodeBadRequest, nil, "bad request")))
+	})
+})
@@ -26,12 +26,19 @@ import (
 	"github.com/uber/aresdb/utils"
 )
 
+// ProtoRequestBody is implemented by request bodies accepting application/x-protobuf.
+type ProtoRequestBody interface {
+	// UnmarshalProto unmarshals the protobuf encoded request body.
+	UnmarshalProto(data []byte) error
+}
+
 // ReadRequest reads request.
 // obj passed into this method has to be a pointer to a struct of request object
 // Each request object will have path params tagged as `path:""` if needed
 // and post body tagged as `body:""` if needed
 // path tag must have parameter name, which will be used to read path param
-// body tag field has to be a struct.
+// body tag field has to be a struct, which is unmarshalled from protobuf if it implements ProtoRequestBody
+// and the content type of the request is application/x-protobuf.
 // eg.
 //      type AddEnumCaseRequest struct {
 //      	TableName string `path:"table"`
@@ -128,6 +135,18 @@ func ReadRequest(r *http.Request, obj interface{}) error {
 				}
 			}
 
+			protoBody, isProtoBody := valueField.Addr().Interface().(ProtoRequestBody)
+			if isProtoBody && strings.HasPrefix(r.Header.Get(utils.HTTPContentTypeHeaderKey), utils.HTTPContentTypeProtobuf) {
+				if err = protoBody.UnmarshalProto(requestBody); err != nil {
+					return utils.APIError{
+						Code:    http.StatusBadRequest,
+						Message: ErrMsgFailedToUnmarshalRequest,
+						Cause:   err,
+					}
+				}
+				continue
+			}
+
 			switch valueField.Addr().Interface().(type) {
 			case *[]byte:
 				valueField.SetBytes(requestBody)
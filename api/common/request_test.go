@@ -16,6 +16,7 @@ package common
 
 import (
 	"bytes"
+	"errors"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/uber/aresdb/utils"
@@ -64,4 +65,41 @@ var _ = ginkgo.Describe("api request", func() {
 		Ω(aqlR.Accept).Should(Equal(utils.HTTPContentTypeHyperLogLog))
 	})
 
+	ginkgo.It("ReadRequest should read protobuf body", func() {
+		var request struct {
+			Body testProtoBody `body:""`
+		}
+		r, err := http.NewRequest(http.MethodPost, "localhost:19374", bytes.NewBufferString("proto"))
+		Ω(err).Should(BeNil())
+		r.Header.Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeProtobuf)
+		Ω(ReadRequest(r, &request)).Should(BeNil())
+		Ω(request.Body.Data).Should(Equal("proto"))
+
+		// json body is still accepted.
+		r, err = http.NewRequest(http.MethodPost, "localhost:19374", bytes.NewBufferString(`{"data": "json"}`))
+		Ω(err).Should(BeNil())
+		r.Header.Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+		Ω(ReadRequest(r, &request)).Should(BeNil())
+		Ω(request.Body.Data).Should(Equal("json"))
+
+		r, err = http.NewRequest(http.MethodPost, "localhost:19374", bytes.NewBufferString(""))
+		Ω(err).Should(BeNil())
+		r.Header.Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeProtobuf)
+		err = ReadRequest(r, &request)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.(utils.APIError).Code).Should(Equal(http.StatusBadRequest))
+	})
+
 })
+
+type testProtoBody struct {
+	Data string `json:"data"`
+}
+
+func (b *testProtoBody) UnmarshalProto(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty body")
+	}
+	b.Data = string(data)
+	return nil
+}
@@ -17,7 +17,9 @@ package common
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/uber/aresdb/utils"
 )
 
@@ -71,6 +73,24 @@ func RespondBytesWithCode(w http.ResponseWriter, code int, bs []byte) {
 	}
 }
 
+// RespondProtoWithCode with specified code and protobuf message.
+func RespondProtoWithCode(w http.ResponseWriter, code int, msg proto.Message) {
+	bs, err := proto.Marshal(msg)
+	if err != nil {
+		RespondWithError(w, ErrFailedToProtoMarshalResponseBody)
+		return
+	}
+	setCommonHeaders(w)
+	w.Header().Set("Content-Type", utils.HTTPContentTypeProtobuf)
+	w.WriteHeader(code)
+	w.Write(bs)
+}
+
+// AcceptsProtobuf tells whether the request asks for protobuf responses.
+func AcceptsProtobuf(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(utils.HTTPAcceptTypeHeaderKey), utils.HTTPContentTypeProtobuf)
+}
+
 // writeJSONBytes write jsonBytes to response if err is nil otherwise respond
 // with a ErrFailedToJSONMarshalResponseBody.
 func writeJSONBytes(w http.ResponseWriter, jsonBytes []byte, err error, code int) {
@@ -25,6 +25,9 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// seconds clients should wait before retrying when ingestion is paused due to low disk space or disk quota.
+const diskSpaceRetryAfterSeconds = "10"
+
 // DataHandler handles data ingestion requests from the ingestion pipeline.
 type DataHandler struct {
 	memStore memstore.MemStore
@@ -66,6 +69,9 @@ func (handler *DataHandler) PostData(w http.ResponseWriter, r *http.Request) {
 
 	err = handler.memStore.HandleIngestion(postDataRequest.TableName, postDataRequest.Shard, upsertBatch)
 	if err != nil {
+		if apiErr, ok := err.(utils.APIError); ok && apiErr.Code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", diskSpaceRetryAfterSeconds)
+		}
 		common.RespondWithError(w, err)
 		return
 	}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
@@ -41,6 +42,12 @@ type DebugHandler struct {
 	metaStore          metaCom.MetaStore
 	queryHandler       *QueryHandler
 	healthCheckHandler *HealthCheckHandler
+	// For running parquet exports in background.
+	parquetExportManager *memstore.ParquetExportManager
+	// For running parquet imports in background.
+	parquetImportManager *memstore.ParquetImportManager
+	// For running jobs triggered on demand by admin.
+	adminJobManager *memstore.AdminJobManager
 }
 
 // NewDebugHandler returns a new DebugHandler.
@@ -51,11 +58,14 @@ func NewDebugHandler(memStore memstore.MemStore,
 	shardOwner topology.ShardOwner,
 ) *DebugHandler {
 	return &DebugHandler{
-		shardOwner:         shardOwner,
-		memStore:           memStore,
-		metaStore:          metaStore,
-		queryHandler:       queryHandler,
-		healthCheckHandler: healthCheckHandler,
+		shardOwner:           shardOwner,
+		memStore:             memStore,
+		metaStore:            metaStore,
+		queryHandler:         queryHandler,
+		healthCheckHandler:   healthCheckHandler,
+		parquetExportManager: memstore.NewParquetExportManager(memStore),
+		parquetImportManager: memstore.NewParquetImportManager(memStore, metaStore),
+		adminJobManager:      memstore.NewAdminJobManager(memStore),
 	}
 }
 
@@ -65,13 +75,23 @@ func (handler *DebugHandler) Register(router *mux.Router) {
 	router.HandleFunc("/health/{onOrOff}", handler.HealthSwitch).Methods(http.MethodPost)
 	router.HandleFunc("/jobs/{jobType}", handler.ShowJobStatus).Methods(http.MethodGet)
 	router.HandleFunc("/devices", handler.ShowDeviceStatus).Methods(http.MethodGet)
+	router.HandleFunc("/queries", handler.ShowQueries).Methods(http.MethodGet)
 	router.HandleFunc("/host-memory", handler.ShowHostMemory).Methods(http.MethodGet)
+	router.HandleFunc("/disk-io", handler.ShowDiskIO).Methods(http.MethodGet)
+	router.HandleFunc("/disk-usage", handler.ShowDiskUsage).Methods(http.MethodGet)
 	router.HandleFunc("/shards", handler.ShowShardSet).Methods(http.MethodGet)
+	router.HandleFunc("/parquet-exports", handler.ShowParquetExports).Methods(http.MethodGet)
+	router.HandleFunc("/parquet-imports", handler.ShowParquetImports).Methods(http.MethodGet)
+	router.HandleFunc("/{table}/parquet-import", handler.ImportParquet).Methods(http.MethodPost)
+	router.HandleFunc("/admin-jobs", handler.ShowAdminJobs).Methods(http.MethodGet)
+	router.HandleFunc("/admin-jobs/{id}", handler.ShowAdminJob).Methods(http.MethodGet)
 	router.HandleFunc("/{table}/{shard}", handler.ShowShardMeta).Methods(http.MethodGet)
 	router.HandleFunc("/{table}/{shard}/archive", handler.Archive).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/backfill", handler.Backfill).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/snapshot", handler.Snapshot).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/purge", handler.Purge).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}/admin-jobs/{jobType}", handler.TriggerAdminJob).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}/parquet-export", handler.ExportParquet).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/batches/{batch}", handler.ShowBatch).Methods(http.MethodGet)
 	router.HandleFunc("/{table}/{shard}/batches/{batch}/vector-parties/{column}", handler.LoadVectorParty).Methods(http.MethodGet)
 	router.HandleFunc("/{table}/{shard}/batches/{batch}/vector-parties/{column}", handler.EvictVectorParty).Methods(http.MethodDelete)
@@ -405,10 +425,11 @@ func (handler *DebugHandler) Purge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !request.Body.SafePurge && (request.Body.BatchIDStart < 0 || request.Body.BatchIDEnd < 0 || request.Body.BatchIDStart > request.Body.BatchIDEnd) {
-		common.RespondWithBadRequest(w, fmt.Errorf("invalid batch range, expects both to be > 0, got [%d, %d)",
-			request.Body.BatchIDStart, request.Body.BatchIDEnd))
-		return
+	if !request.Body.SafePurge {
+		if err = validatePurgeBatchRange(request.Body.BatchIDStart, request.Body.BatchIDEnd); err != nil {
+			common.RespondWithBadRequest(w, err)
+			return
+		}
 	}
 
 	shard, err := handler.memStore.GetTableShard(request.TableName, request.ShardID)
@@ -419,13 +440,9 @@ func (handler *DebugHandler) Purge(w http.ResponseWriter, r *http.Request) {
 	defer shard.Users.Done()
 
 	if request.Body.SafePurge {
-		retentionDays := shard.Schema.Schema.Config.RecordRetentionInDays
-		if retentionDays > 0 {
-			nowInDay := int(utils.Now().Unix() / 86400)
-			request.Body.BatchIDStart = 0
-			request.Body.BatchIDEnd = nowInDay - retentionDays
-		} else {
-			common.RespondWithBadRequest(w, utils.APIError{Message: "safe purge attempted on table with infinite retention"})
+		request.Body.BatchIDStart, request.Body.BatchIDEnd, err = getSafePurgeBatchRange(shard)
+		if err != nil {
+			common.RespondWithBadRequest(w, err)
 			return
 		}
 	}
@@ -442,6 +459,227 @@ func (handler *DebugHandler) Purge(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// validatePurgeBatchRange validates the batch range to purge.
+func validatePurgeBatchRange(batchIDStart, batchIDEnd int) error {
+	if batchIDStart < 0 || batchIDEnd < 0 || batchIDStart > batchIDEnd {
+		return fmt.Errorf("invalid batch range, expects both to be > 0, got [%d, %d)", batchIDStart, batchIDEnd)
+	}
+	return nil
+}
+
+// getSafePurgeBatchRange returns the range of batches out of retention of the shard.
+func getSafePurgeBatchRange(shard *memstore.TableShard) (int, int, error) {
+	retentionDays := shard.Schema.Schema.Config.RecordRetentionInDays
+	if retentionDays <= 0 {
+		return 0, 0, utils.APIError{Message: "safe purge attempted on table with infinite retention"}
+	}
+	nowInDay := int(utils.Now().Unix() / 86400)
+	return 0, nowInDay - retentionDays, nil
+}
+
+// TriggerAdminJob starts an archiving, backfill, snapshot or purge job of a shard on demand in background
+// and returns the job for looking up its status. Only admin is allowed to trigger jobs.
+func (handler *DebugHandler) TriggerAdminJob(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(utils.GetCallerRoles(r)) {
+		common.RespondWithError(w, ErrAdminJobNotAllowed)
+		return
+	}
+
+	var request AdminJobRequest
+	err := common.ReadRequest(r, &request)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	shard, err := handler.memStore.GetTableShard(request.TableName, request.ShardID)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+	defer shard.Users.Done()
+
+	jobType := memCom.JobType(request.JobType)
+	shard.Schema.RLock()
+	isFactTable := shard.Schema.Schema.IsFactTable
+	archivingDelay := shard.Schema.Schema.Config.ArchivingDelayMinutes * 60
+	shard.Schema.RUnlock()
+
+	scheduler := handler.memStore.GetScheduler()
+	var job memstore.Job
+	switch jobType {
+	case memCom.ArchivingJobType:
+		cutoff := request.Body.Cutoff
+		if cutoff == 0 {
+			cutoff = uint32(utils.Now().Unix()) - archivingDelay
+		}
+		job = scheduler.NewArchivingJob(request.TableName, request.ShardID, cutoff)
+	case memCom.BackfillJobType:
+		job = scheduler.NewBackfillJob(request.TableName, request.ShardID)
+	case memCom.SnapshotJobType:
+		job = scheduler.NewSnapshotJob(request.TableName, request.ShardID)
+	case memCom.PurgeJobType:
+		batchIDStart, batchIDEnd := request.Body.BatchIDStart, request.Body.BatchIDEnd
+		if request.Body.SafePurge {
+			batchIDStart, batchIDEnd, err = getSafePurgeBatchRange(shard)
+		} else {
+			err = validatePurgeBatchRange(batchIDStart, batchIDEnd)
+		}
+		if err != nil {
+			common.RespondWithBadRequest(w, err)
+			return
+		}
+		job = scheduler.NewPurgeJob(request.TableName, request.ShardID, batchIDStart, batchIDEnd)
+	default:
+		common.RespondWithBadRequest(w, fmt.Errorf("unknown job type %s", request.JobType))
+		return
+	}
+	// snapshot is for dimension tables while the others are for fact tables.
+	if isFactTable == (jobType == memCom.SnapshotJobType) {
+		common.RespondWithBadRequest(w, fmt.Errorf("%s job is not applicable to table %s", jobType, request.TableName))
+		return
+	}
+
+	jobDetail, err := handler.adminJobManager.Submit(request.TableName, request.ShardID, job)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondJSONObjectWithCode(w, http.StatusAccepted, jobDetail)
+}
+
+// ShowAdminJobs shows status of jobs triggered on demand by admin.
+func (handler *DebugHandler) ShowAdminJobs(w http.ResponseWriter, r *http.Request) {
+	common.RespondWithJSONObject(w, handler.adminJobManager.GetJobs())
+}
+
+// ShowAdminJob shows status of a job triggered on demand by admin.
+func (handler *DebugHandler) ShowAdminJob(w http.ResponseWriter, r *http.Request) {
+	var request ShowAdminJobRequest
+	err := common.ReadRequest(r, &request)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	jobDetail, found := handler.adminJobManager.GetJob(request.JobID)
+	if !found {
+		common.RespondWithError(w, ErrAdminJobDoesNotExist)
+		return
+	}
+	common.RespondWithJSONObject(w, jobDetail)
+}
+
+// ExportParquet starts exporting archive batches of a shard to parquet files in background.
+func (handler *DebugHandler) ExportParquet(w http.ResponseWriter, r *http.Request) {
+	var request ParquetExportRequest
+	err := common.ReadRequest(r, &request)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	if request.Body.BatchIDStart < 0 || request.Body.BatchIDStart >= request.Body.BatchIDEnd {
+		common.RespondWithBadRequest(w, fmt.Errorf("invalid batch range, expects 0 <= start < end, got [%d, %d)",
+			request.Body.BatchIDStart, request.Body.BatchIDEnd))
+		return
+	}
+	if request.Body.Path == "" {
+		common.RespondWithBadRequest(w, errors.New("path to export into is required"))
+		return
+	}
+
+	// Just check table and shard existence.
+	shard, err := handler.memStore.GetTableShard(request.TableName, request.ShardID)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+	shard.Users.Done()
+
+	var destination memstore.ExportDestination
+	switch request.Body.DestinationType {
+	case "", diskstore.DiskStoreTypeLocal:
+		destination = memstore.NewLocalExportDestination(request.Body.Path)
+	case diskstore.DiskStoreTypeObjectStore:
+		objectStore, err := diskstore.NewS3ObjectStore(utils.GetConfig().DiskStore.ObjectStore)
+		if err != nil {
+			common.RespondWithError(w, err)
+			return
+		}
+		destination = memstore.NewObjectStoreExportDestination(objectStore, request.Body.Path)
+	default:
+		common.RespondWithBadRequest(w, fmt.Errorf("unknown destination type %s", request.Body.DestinationType))
+		return
+	}
+
+	err = handler.parquetExportManager.StartExport(request.TableName, request.ShardID, memstore.ParquetExportOptions{
+		BatchIDStart: request.Body.BatchIDStart,
+		BatchIDEnd:   request.Body.BatchIDEnd,
+		Columns:      request.Body.Columns,
+		BytesPerSec:  request.Body.BytesPerSec,
+	}, destination)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondJSONObjectWithCode(w, http.StatusOK, "Parquet export job submitted")
+}
+
+// ShowParquetExports shows progress of parquet exports.
+func (handler *DebugHandler) ShowParquetExports(w http.ResponseWriter, r *http.Request) {
+	common.RespondWithJSONObject(w, handler.parquetExportManager.GetJobDetails())
+}
+
+// ImportParquet starts importing parquet files into a table in background.
+func (handler *DebugHandler) ImportParquet(w http.ResponseWriter, r *http.Request) {
+	var request ParquetImportRequest
+	err := common.ReadRequest(r, &request)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	if len(request.Body.Files) == 0 {
+		common.RespondWithBadRequest(w, errors.New("files to import are required"))
+		return
+	}
+
+	var source memstore.ImportSource
+	switch request.Body.SourceType {
+	case "", diskstore.DiskStoreTypeLocal:
+		source = memstore.NewLocalImportSource(request.Body.Path)
+	case diskstore.DiskStoreTypeObjectStore:
+		objectStore, err := diskstore.NewS3ObjectStore(utils.GetConfig().DiskStore.ObjectStore)
+		if err != nil {
+			common.RespondWithError(w, err)
+			return
+		}
+		source = memstore.NewObjectStoreImportSource(objectStore, request.Body.Path)
+	default:
+		common.RespondWithBadRequest(w, fmt.Errorf("unknown source type %s", request.Body.SourceType))
+		return
+	}
+
+	err = handler.parquetImportManager.StartImport(request.TableName, memstore.ParquetImportOptions{
+		Files:         request.Body.Files,
+		ColumnMapping: request.Body.ColumnMapping,
+		NumShards:     request.Body.NumShards,
+		Archive:       request.Body.Archive,
+		BatchSize:     request.Body.BatchSize,
+	}, source)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondJSONObjectWithCode(w, http.StatusOK, "Parquet import job submitted")
+}
+
+// ShowParquetImports shows progress of parquet imports.
+func (handler *DebugHandler) ShowParquetImports(w http.ResponseWriter, r *http.Request) {
+	common.RespondWithJSONObject(w, handler.parquetImportManager.GetJobDetails())
+}
+
 // ShowShardMeta shows the metadata for a table shard. It won't show the underlying data.
 func (handler *DebugHandler) ShowShardMeta(w http.ResponseWriter, r *http.Request) {
 	var request ShowShardMetaRequest
@@ -664,6 +902,12 @@ func (handler *DebugHandler) ShowDeviceStatus(w http.ResponseWriter, r *http.Req
 	return
 }
 
+// ShowQueries shows running queries sorted by elapsed time, longest first unless order=asc, with
+// recently finished queries.
+func (handler *DebugHandler) ShowQueries(w http.ResponseWriter, r *http.Request) {
+	common.RespondWithJSONObject(w, handler.queryHandler.GetQueryRegistry().List(r.URL.Query().Get("order") == "asc"))
+}
+
 // ShowHostMemory shows the current host memory usage
 func (handler *DebugHandler) ShowHostMemory(w http.ResponseWriter, r *http.Request) {
 	memoryUsageByTableShard, err := handler.memStore.GetMemoryUsageDetails()
@@ -674,6 +918,16 @@ func (handler *DebugHandler) ShowHostMemory(w http.ResponseWriter, r *http.Reque
 	common.RespondWithJSONObject(w, memoryUsageByTableShard)
 }
 
+// ShowDiskIO shows the disk I/O accumulated since start by table and operation.
+func (handler *DebugHandler) ShowDiskIO(w http.ResponseWriter, r *http.Request) {
+	common.RespondWithJSONObject(w, diskstore.GetIOStats().Snapshot())
+}
+
+// ShowDiskUsage shows the bytes used on local disk by table and shard.
+func (handler *DebugHandler) ShowDiskUsage(w http.ResponseWriter, r *http.Request) {
+	common.RespondWithJSONObject(w, diskstore.GetDiskUsage().Snapshot())
+}
+
 // ReadBackfillQueueUpsertBatch reads upsert batch inside backfill manager backfill queue
 func (handler *DebugHandler) ReadBackfillQueueUpsertBatch(w http.ResponseWriter, r *http.Request) {
 	var request ReadBackfillQueueUpsertBatchRequest
@@ -225,6 +225,9 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		mockDiskStore.On(
 			"OpenVectorPartyFileForWrite", mock.Anything,
 			mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(writer, nil)
+		mockDiskStore.On(
+			"CommitBatchVersion", mock.Anything, mock.Anything,
+			mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 		queryHandler := NewQueryHandler(
 			memStore,
@@ -384,6 +387,67 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		Ω(string(bs)).Should(ContainSubstring("Failed to get shard"))
 	})
 
+	ginkgo.It("TriggerAdminJob should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		job := new(memMocks.Job)
+		job.On("JobType").Return(memCom.BackfillJobType)
+		errChan := make(chan error, 1)
+		errChan <- nil
+		scheduler.On("NewBackfillJob", testTableName, testTableShardID).Return(job)
+		scheduler.On("NewArchivingJob", testTableName, testTableShardID, mock.Anything).Return(new(memMocks.Job))
+		scheduler.On("IsJobTypeEnabled", mock.Anything).Return(true)
+		scheduler.On("GetJobDetail", testTableName, testTableShardID, mock.Anything).Return(memstore.JobDetail{}, false)
+		scheduler.On("SubmitJob", mock.Anything).Return(nil, errChan)
+
+		trigger := func(jobType string, roles string) (int, []byte) {
+			req, err := http.NewRequest(http.MethodPost,
+				fmt.Sprintf("http://%s/debug/%s/%d/admin-jobs/%s", hostPort, testTableName, testTableShardID, jobType),
+				bytes.NewBufferString("{}"))
+			Ω(err).Should(BeNil())
+			req.Header.Set(utils.HTTPCallerRoleHeaderKey, roles)
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			bs, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode, bs
+		}
+
+		code, _ := trigger("backfill", "reader")
+		Ω(code).Should(Equal(http.StatusForbidden))
+		code, _ = trigger("unknown", metaCom.AdminRole)
+		Ω(code).Should(Equal(http.StatusBadRequest))
+		// snapshot is not applicable to fact tables.
+		code, _ = trigger("snapshot", metaCom.AdminRole)
+		Ω(code).Should(Equal(http.StatusBadRequest))
+
+		code, bs := trigger("backfill", metaCom.AdminRole)
+		Ω(code).Should(Equal(http.StatusAccepted))
+		var jobDetail memstore.AdminJobDetail
+		Ω(json.Unmarshal(bs, &jobDetail)).Should(BeNil())
+		Ω(jobDetail.ID).ShouldNot(BeEmpty())
+		Ω(jobDetail.JobType).Should(Equal(memCom.BackfillJobType))
+		Ω(jobDetail.Status).Should(Equal(memstore.JobQueued))
+
+		// archiving conflicts with the queued backfill job.
+		code, bs = trigger("archiving", metaCom.AdminRole)
+		Ω(code).Should(Equal(http.StatusConflict))
+		Ω(string(bs)).Should(ContainSubstring(jobDetail.ID))
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/debug/admin-jobs/%s", hostPort, jobDetail.ID))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/admin-jobs/unknown", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/admin-jobs", hostPort))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		var jobDetails []memstore.AdminJobDetail
+		Ω(json.Unmarshal(bs, &jobDetails)).Should(BeNil())
+		Ω(jobDetails).Should(HaveLen(1))
+	})
+
 	ginkgo.It("ListRedoLogs should work", func() {
 		hostPort := testServer.Listener.Addr().String()
 		resp, err := http.Get(
@@ -68,6 +68,65 @@ type PurgeRequest struct {
 	} `body:""`
 }
 
+// AdminJobRequest represents request to trigger an archiving, backfill, snapshot or purge job
+// of a shard on demand.
+type AdminJobRequest struct {
+	ShardRequest
+	JobType string `path:"jobType" json:"jobType"`
+	Body    struct {
+		// Archiving cutoff, defaults to now minus the archiving delay of the table.
+		Cutoff uint32 `json:"cutoff"`
+		// Batch range and whether to purge batches out of retention for purge jobs.
+		BatchIDStart int  `json:"batchIDStart"`
+		BatchIDEnd   int  `json:"batchIDEnd"`
+		SafePurge    bool `json:"safePurge"`
+	} `body:""`
+}
+
+// ShowAdminJobRequest represents request to show status of an admin job.
+type ShowAdminJobRequest struct {
+	JobID string `path:"id" json:"id"`
+}
+
+// ParquetExportRequest represents request to export archive batches of a shard to parquet files.
+type ParquetExportRequest struct {
+	ShardRequest
+	Body struct {
+		// Inclusive start and exclusive end of batch ids (days since epoch) to export.
+		BatchIDStart int `json:"batchIDStart"`
+		BatchIDEnd   int `json:"batchIDEnd"`
+		// Columns to export, default to all columns.
+		Columns []string `json:"columns"`
+		// Exported bytes per second, not throttled if not positive.
+		BytesPerSec int64 `json:"bytesPerSec"`
+		// Either local (default) or object_store.
+		DestinationType string `json:"destinationType"`
+		// Local directory or object key prefix to export into.
+		Path string `json:"path"`
+	} `body:""`
+}
+
+// ParquetImportRequest represents request to import parquet files into a table.
+type ParquetImportRequest struct {
+	TableName string `path:"table" json:"table"`
+	Body      struct {
+		// Either local (default) or object_store.
+		SourceType string `json:"sourceType"`
+		// Local directory or object key prefix to import from.
+		Path string `json:"path"`
+		// Files to import, relative to path.
+		Files []string `json:"files"`
+		// Maps parquet column names to table column names, default to columns with the same names.
+		ColumnMapping map[string]string `json:"columnMapping"`
+		// Number of shards of the table rows are routed to, default to 1.
+		NumShards int `json:"numShards"`
+		// Whether to write rows into archive batches directly.
+		Archive bool `json:"archive"`
+		// Max number of rows per upsert batch.
+		BatchSize int `json:"batchSize"`
+	} `body:""`
+}
+
 // LoadVectorPartyRequest represents a load request for vector party
 type LoadVectorPartyRequest struct {
 	ShardRequest
@@ -42,6 +42,7 @@ func NewEnumHandler(memStore memstore.MemStore, metastore metaCom.MetaStore) *En
 func (handler *EnumHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
 	router.HandleFunc("/tables/{table}/columns/{column}/enum-cases", utils.ApplyHTTPWrappers(handler.ListEnumCases, wrappers)).Methods(http.MethodGet)
 	router.HandleFunc("/tables/{table}/columns/{column}/enum-cases", utils.ApplyHTTPWrappers(handler.AddEnumCase, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/tables/{table}/enum-cases", utils.ApplyHTTPWrappers(handler.AddEnumCasesBulk, wrappers)).Methods(http.MethodPost)
 }
 
 // ListEnumCases swagger:route GET /schema/tables/{table}/columns/{column}/enum-cases listEnumCases
@@ -107,3 +108,55 @@ func (handler *EnumHandler) AddEnumCase(w http.ResponseWriter, r *http.Request)
 
 	common.RespondWithJSONObject(w, addEnumCaseResponse.Body)
 }
+
+// AddEnumCasesBulk swagger:route POST /schema/tables/{table}/enum-cases addEnumCasesBulk
+// add enum cases to multiple columns of given table at once
+// return the ids of the enum cases by column, existing enum cases keep their ids
+//
+// Responses:
+//    default: errorResponse
+//        200: addEnumCasesBulkResponse
+func (handler *EnumHandler) AddEnumCasesBulk(w http.ResponseWriter, r *http.Request) {
+	var addEnumCasesBulkRequest AddEnumCasesBulkRequest
+	var addEnumCasesBulkResponse AddEnumCasesBulkResponse
+
+	err := common.ReadRequest(r, &addEnumCasesBulkRequest)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	if addEnumCasesBulkRequest.Body.Count() > metaCom.MaxBulkEnumCases {
+		common.RespondWithError(w, ErrTooManyEnumCases)
+		return
+	}
+
+	// validate all columns before extending any of them.
+	tableSchema, err := handler.memStore.GetSchema(addEnumCasesBulkRequest.TableName)
+	if err != nil {
+		common.RespondWithError(w, ErrTableDoesNotExist)
+		return
+	}
+	tableSchema.RLock()
+	for columnName := range addEnumCasesBulkRequest.Body.EnumCases {
+		if _, columnExist := tableSchema.EnumDicts[columnName]; !columnExist {
+			tableSchema.RUnlock()
+			common.RespondWithError(w, ErrColumnDoesNotExist)
+			return
+		}
+	}
+	tableSchema.RUnlock()
+
+	addEnumCasesBulkResponse.Body = make(map[string][]int, len(addEnumCasesBulkRequest.Body.EnumCases))
+	for columnName, enumCases := range addEnumCasesBulkRequest.Body.EnumCases {
+		// existing enum cases are deduplicated by metastore, so retrying after partial failures
+		// resolves the same ids.
+		addEnumCasesBulkResponse.Body[columnName], err = handler.metastore.ExtendEnumDict(addEnumCasesBulkRequest.TableName, columnName, enumCases)
+		if err != nil {
+			common.RespondWithError(w, err)
+			return
+		}
+	}
+
+	common.RespondWithJSONObject(w, addEnumCasesBulkResponse.Body)
+}
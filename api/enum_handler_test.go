@@ -111,4 +111,30 @@ var _ = ginkgo.Describe("EnumHandler", func() {
 		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/enum-cases", hostPort, "testTable", "testColumn"), "application/json", bytes.NewBuffer(enumCases))
 		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
 	})
+
+	ginkgo.It("AddEnumCasesBulk should work", func() {
+		testMetastore.On("ExtendEnumDict", "testTable", "testColumn", []string{"a", "d"}).Return([]int{0, 3}, nil).Once()
+		resp, _ := http.Post(fmt.Sprintf("http://%s/schema/tables/%s/enum-cases", hostPort, "testTable"), "application/json",
+			bytes.NewBufferString(`{"enumCases": {"testColumn": ["a", "d"]}}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		respBody, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(respBody).Should(MatchJSON(`{"testColumn": [0, 3]}`))
+
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/enum-cases", hostPort, "unknown"), "application/json",
+			bytes.NewBufferString(`{"enumCases": {"testColumn": ["a"]}}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		// no enum cases are added if any column is unknown.
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/enum-cases", hostPort, "testTable"), "application/json",
+			bytes.NewBufferString(`{"enumCases": {"testColumn": ["a"], "unknown": ["a"]}}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		tooManyEnumCases := make([]string, metaCom.MaxBulkEnumCases+1)
+		requestBytes, _ := json.Marshal(metaCom.BulkEnumCases{EnumCases: map[string][]string{"testColumn": tooManyEnumCases}})
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/enum-cases", hostPort, "testTable"), "application/json",
+			bytes.NewBuffer(requestBytes))
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		testMetastore.AssertExpectations(ginkgo.GinkgoT())
+	})
 })
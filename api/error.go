@@ -15,8 +15,11 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 )
 
@@ -68,6 +71,37 @@ var (
 		Code:    http.StatusBadRequest,
 		Message: "Bad request: batch does not exist",
 	}
+	// ErrForceSchemaChangeNotAllowed represents api error for forcing schema changes without admin role.
+	ErrForceSchemaChangeNotAllowed = utils.APIError{
+		Code:    http.StatusForbidden,
+		Message: "Forbidden: schema changes can only be forced by admin",
+	}
+	// ErrAdminJobNotAllowed represents api error for triggering jobs on demand without admin role.
+	ErrAdminJobNotAllowed = utils.APIError{
+		Code:    http.StatusForbidden,
+		Message: "Forbidden: jobs can only be triggered on demand by admin",
+	}
+	// ErrAdminJobDoesNotExist represents api error for looking up an unknown admin job.
+	ErrAdminJobDoesNotExist = utils.APIError{
+		Code:    http.StatusNotFound,
+		Message: "Not found: admin job does not exist",
+	}
+	// ErrSchemaVersionConflict represents api error for schema changes based on a stale table version.
+	ErrSchemaVersionConflict = utils.APIError{
+		Code:    http.StatusConflict,
+		Message: metastore.ErrSchemaVersionConflict.Error(),
+	}
+	// ErrTooManyEnumCases represents api error for bulk enum cases requests over the limit.
+	ErrTooManyEnumCases = utils.APIError{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("Bad request: at most %d enum cases can be resolved at once", metaCom.MaxBulkEnumCases),
+	}
+	// ErrSchemaDriftDetectionDisabled represents api error for detecting schema drift where schema is not
+	// synced from controller.
+	ErrSchemaDriftDetectionDisabled = utils.APIError{
+		Code:    http.StatusNotImplemented,
+		Message: "Schema drift detection is only enabled in cluster mode",
+	}
 	// ErrFailedToJSONMarshalResponseBody represents the api error for failure to marshal
 	// response body into json.
 	ErrFailedToJSONMarshalResponseBody = utils.APIError{
@@ -36,6 +36,7 @@ type QueryHandler struct {
 	shardOwner    topology.ShardOwner
 	memStore      memstore.MemStore
 	deviceManager *query.DeviceManager
+	queryRegistry *queryCom.QueryRegistry
 }
 
 // NewQueryHandler creates a new QueryHandler.
@@ -44,6 +45,7 @@ func NewQueryHandler(memStore memstore.MemStore, shardOwner topology.ShardOwner,
 		memStore:      memStore,
 		shardOwner:    shardOwner,
 		deviceManager: query.NewDeviceManager(cfg),
+		queryRegistry: queryCom.NewQueryRegistry(queryCom.DefaultQueryHistorySize),
 	}
 }
 
@@ -52,6 +54,11 @@ func (handler *QueryHandler) GetDeviceManager() *query.DeviceManager {
 	return handler.deviceManager
 }
 
+// GetQueryRegistry returns the registry of queries running on the datanode.
+func (handler *QueryHandler) GetQueryRegistry() *queryCom.QueryRegistry {
+	return handler.queryRegistry
+}
+
 // Register registers http handlers.
 func (handler *QueryHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
 	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.HandleAQL, wrappers)).Methods(http.MethodGet, http.MethodPost)
@@ -85,10 +92,10 @@ func (handler *QueryHandler) HandleAQL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handler.handleAQLInternal(aqlRequest, w, r)
+	handler.handleAQLInternal(aqlRequest, w, r, v1QueryResponseShaper{})
 }
 
-func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w http.ResponseWriter, r *http.Request) {
+func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w http.ResponseWriter, r *http.Request, shaper queryResponseShaper) {
 	var err error
 	var duration time.Duration
 	var qcs []*query.AQLQueryContext
@@ -102,6 +109,8 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w h
 
 		l := utils.GetQueryLogger().With(
 			"error", errStr,
+			// forwarded by broker to correlate queries of datanodes with the request of broker.
+			"requestID", r.Header.Get(utils.HTTPRequestIDHeaderKey),
 			"request", aqlRequest,
 			"queries_enabled_", aqlRequest.Body.Queries,
 			"duration", duration,
@@ -122,7 +131,7 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w h
 		err = json.Unmarshal([]byte(aqlRequest.Query), &aqlRequest.Body)
 		if err != nil {
 			statusCode = http.StatusBadRequest
-			apiCom.RespondWithBadRequest(w, utils.APIError{
+			shaper.respondRequestError(w, utils.APIError{
 				Code:    http.StatusBadRequest,
 				Message: ErrMsgFailedToUnmarshalRequest,
 				Cause:   err,
@@ -133,13 +142,20 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w h
 
 	if aqlRequest.Body.Queries == nil {
 		statusCode = http.StatusBadRequest
-		apiCom.RespondWithBadRequest(w, utils.APIError{
+		shaper.respondRequestError(w, utils.APIError{
 			Code:    http.StatusBadRequest,
 			Message: ErrMsgMissingParameter,
 		})
 		return
 	}
 
+	// columns are access controlled by roles of the caller.
+	caller, callerRoles := utils.GetOrigin(r), utils.GetCallerRoles(r)
+	for i := range aqlRequest.Body.Queries {
+		aqlRequest.Body.Queries[i].Caller = caller
+		aqlRequest.Body.Queries[i].CallerRoles = callerRoles
+	}
+
 	returnHLL := aqlRequest.Accept == utils.HTTPContentTypeHyperLogLog
 	if aqlRequest.DeviceChoosingTimeout <= 0 {
 		aqlRequest.DeviceChoosingTimeout = -1
@@ -149,34 +165,44 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w h
 	start := utils.Now()
 	var requestResponseWriter QueryResponseWriter
 
-	if !returnHLL && canEagerFlush(aqlRequest.Body.Queries) {
+	if !returnHLL && shaper.canEagerFlush(aqlRequest) {
 		aqlQuery := aqlRequest.Body.Queries[0]
+		runningQuery := handler.queryRegistry.Register(&aqlQuery, caller)
+		defer func() {
+			handler.queryRegistry.Finish(runningQuery, err)
+		}()
 		qc := &query.AQLQueryContext{
 			Query:         &aqlQuery,
 			ReturnHLLData: false,
 			DataOnly:      aqlRequest.DataOnly != 0,
 		}
 		qc.Compile(handler.memStore, handler.shardOwner)
+		shaper.recordSchemaVersion(qc)
 		qc.ResponseWriter = w
 		if qc.Error != nil {
 			err = qc.Error
 			statusCode = http.StatusBadRequest
-			w.WriteHeader(statusCode)
+			shaper.respondQueryError(w, err, statusCode)
 			return
 		}
 		// for logging purpose only
 		qcs = append(qcs, qc)
 
+		runningQuery.SetPhase(queryCom.QueryPhaseWaitingForDevice)
 		qc.FindDeviceForQuery(handler.memStore, aqlRequest.Device, handler.deviceManager, aqlRequest.DeviceChoosingTimeout)
 		if qc.Error != nil {
 			err = qc.Error
 			statusCode = http.StatusServiceUnavailable
-			w.WriteHeader(statusCode)
+			shaper.respondQueryError(w, err, statusCode)
 			return
 		}
 		defer handler.deviceManager.ReleaseReservedMemory(qc.Device, qc.Query)
+		runningQuery.SetMemory(qc.OOPK.DeviceMemoryRequirement)
+		shaper.beforeEagerFlush(w, qc)
 
+		runningQuery.SetPhase(queryCom.QueryPhaseStreaming)
 		qc.ProcessQuery(handler.memStore)
+		runningQuery.AddRows(qc.OOPK.ResultSize)
 		if qc.Error != nil {
 			err = qc.Error
 			utils.GetQueryLogger().With(
@@ -201,11 +227,14 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w h
 		}
 
 	} else {
-		requestResponseWriter = getReponseWriter(returnHLL, len(aqlRequest.Body.Queries))
+		requestResponseWriter = shaper.newQueryResponseWriter(returnHLL, len(aqlRequest.Body.Queries))
 
 		var qc *query.AQLQueryContext
 		for i, aqlQuery := range aqlRequest.Body.Queries {
-			qc, statusCode = handleQuery(handler.memStore, handler.shardOwner, handler.deviceManager, aqlRequest, aqlQuery)
+			runningQuery := handler.queryRegistry.Register(&aqlQuery, caller)
+			qc, statusCode = handleQuery(handler.memStore, handler.shardOwner, handler.deviceManager, aqlRequest, aqlQuery, runningQuery)
+			handler.queryRegistry.Finish(runningQuery, qc.Error)
+			shaper.recordSchemaVersion(qc)
 			if aqlRequest.Verbose > 0 {
 				requestResponseWriter.ReportQueryContext(qc)
 			}
@@ -231,7 +260,8 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest apiCom.AQLRequest, w h
 	return
 }
 
-func handleQuery(memStore memstore.MemStore, shardOwner topology.ShardOwner, deviceManager *query.DeviceManager, aqlRequest apiCom.AQLRequest, aqlQuery queryCom.AQLQuery) (qc *query.AQLQueryContext, statusCode int) {
+func handleQuery(memStore memstore.MemStore, shardOwner topology.ShardOwner, deviceManager *query.DeviceManager, aqlRequest apiCom.AQLRequest, aqlQuery queryCom.AQLQuery,
+	runningQuery *queryCom.RunningQuery) (qc *query.AQLQueryContext, statusCode int) {
 	qc = &query.AQLQueryContext{
 		Query:         &aqlQuery,
 		ReturnHLLData: aqlRequest.Accept == utils.HTTPContentTypeHyperLogLog,
@@ -259,6 +289,7 @@ func handleQuery(memStore memstore.MemStore, shardOwner topology.ShardOwner, dev
 
 	// Find a device that meets the resource requirement of this query
 	// Use query specified device as hint
+	runningQuery.SetPhase(queryCom.QueryPhaseWaitingForDevice)
 	qc.FindDeviceForQuery(memStore, aqlRequest.Device, deviceManager, aqlRequest.DeviceChoosingTimeout)
 	// Unable to find a device for the query.
 	if qc.Error != nil {
@@ -267,7 +298,9 @@ func handleQuery(memStore memstore.MemStore, shardOwner topology.ShardOwner, dev
 		return
 	}
 	defer deviceManager.ReleaseReservedMemory(qc.Device, qc.Query)
+	runningQuery.SetMemory(qc.OOPK.DeviceMemoryRequirement)
 	// Execute.
+	runningQuery.SetPhase(queryCom.QueryPhaseProcessing)
 	qc.ProcessQuery(memStore)
 	if qc.Error != nil {
 		utils.GetQueryLogger().With(
@@ -281,6 +314,7 @@ func handleQuery(memStore memstore.MemStore, shardOwner topology.ShardOwner, dev
 		utils.GetRootReporter().GetChildCounter(map[string]string{
 			"table": aqlQuery.Table,
 		}, utils.QueryRowsReturned).Inc(int64(qc.OOPK.ResultSize))
+		runningQuery.AddRows(qc.OOPK.ResultSize)
 	}
 	return
 }
@@ -344,6 +378,12 @@ func (w *JSONQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQuer
 	if qc.Error != nil {
 		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
 	}
+	if len(qc.Warnings) > 0 {
+		if w.response.Warnings == nil {
+			w.response.Warnings = make([][]string, len(w.response.Results))
+		}
+		w.response.Warnings[queryIndex] = qc.Warnings
+	}
 	w.response.Results[queryIndex] = qc.Results
 }
 
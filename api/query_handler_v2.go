@@ -0,0 +1,329 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/memstore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/utils"
+)
+
+// queryResponseShaper shapes responses of the query api versions, while query execution is shared.
+type queryResponseShaper interface {
+	// respondRequestError responds the error of a malformed request.
+	respondRequestError(w http.ResponseWriter, err error)
+	// respondQueryError responds the error of a query failed before eager flushing.
+	respondQueryError(w http.ResponseWriter, err error, statusCode int)
+	// canEagerFlush tells whether results of the request can be flushed to response while processing.
+	canEagerFlush(aqlRequest apiCom.AQLRequest) bool
+	// beforeEagerFlush is called before results are flushed to response.
+	beforeEagerFlush(w http.ResponseWriter, qc *query.AQLQueryContext)
+	// newQueryResponseWriter creates the QueryResponseWriter for non eager flushed requests.
+	newQueryResponseWriter(returnHLL bool, nQueries int) QueryResponseWriter
+	// recordSchemaVersion records the schema version of the main table the query was compiled against, for
+	// brokers to tell datanodes behind schema changes.
+	recordSchemaVersion(qc *query.AQLQueryContext)
+}
+
+// v1QueryResponseShaper shapes responses of the v1 query api.
+type v1QueryResponseShaper struct{}
+
+func (v1QueryResponseShaper) respondRequestError(w http.ResponseWriter, err error) {
+	apiCom.RespondWithBadRequest(w, err)
+}
+
+func (v1QueryResponseShaper) respondQueryError(w http.ResponseWriter, err error, statusCode int) {
+	w.WriteHeader(statusCode)
+}
+
+func (v1QueryResponseShaper) canEagerFlush(aqlRequest apiCom.AQLRequest) bool {
+	return canEagerFlush(aqlRequest.Body.Queries)
+}
+
+func (v1QueryResponseShaper) beforeEagerFlush(w http.ResponseWriter, qc *query.AQLQueryContext) {
+}
+
+func (v1QueryResponseShaper) newQueryResponseWriter(returnHLL bool, nQueries int) QueryResponseWriter {
+	return getReponseWriter(returnHLL, nQueries)
+}
+
+func (v1QueryResponseShaper) recordSchemaVersion(qc *query.AQLQueryContext) {
+}
+
+// v2QueryResponseShaper shapes responses of the v2 query api into QueryResponseV2, metadata is written
+// in headers for eager flushed and application/hll responses.
+type v2QueryResponseShaper struct {
+	memStore memstore.MemStore
+	metadata apiCom.QueryMetadataV2
+	start    time.Time
+}
+
+func newV2QueryResponseShaper(memStore memstore.MemStore, r *http.Request) *v2QueryResponseShaper {
+	return &v2QueryResponseShaper{
+		memStore: memStore,
+		metadata: apiCom.NewQueryMetadataV2(r),
+		start:    utils.Now(),
+	}
+}
+
+func (s *v2QueryResponseShaper) respondRequestError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*utils.CodedError); !ok {
+		err = utils.WithCode(utils.ErrCodeBadRequest, err)
+	}
+	s.metadata.SetLatency(s.start)
+	apiCom.RespondWithV2Error(w, s.metadata, err)
+}
+
+func (s *v2QueryResponseShaper) respondQueryError(w http.ResponseWriter, err error, statusCode int) {
+	s.metadata.SetLatency(s.start)
+	apiCom.RespondWithV2Error(w, s.metadata, withQueryErrorCode(err, statusCode))
+}
+
+// canEagerFlush only eager flushes data only requests (e.g. from brokers), since results of other requests
+// are wrapped in the response envelope.
+func (s *v2QueryResponseShaper) canEagerFlush(aqlRequest apiCom.AQLRequest) bool {
+	return aqlRequest.DataOnly != 0 && canEagerFlush(aqlRequest.Body.Queries)
+}
+
+func (s *v2QueryResponseShaper) beforeEagerFlush(w http.ResponseWriter, qc *query.AQLQueryContext) {
+	s.metadata.UpdateDataFreshness(getDataFreshness(s.memStore, qc))
+	s.metadata.WriteHeaders(w)
+}
+
+// recordSchemaVersion records the schema version of the main table of the first query of the request.
+func (s *v2QueryResponseShaper) recordSchemaVersion(qc *query.AQLQueryContext) {
+	schema := qc.TableSchemaByName[qc.Query.Table]
+	if s.metadata.SchemaVersion != "" || schema == nil {
+		return
+	}
+	schema.RLock()
+	version := metaCom.TableSchemaVersion{Incarnation: schema.Schema.Incarnation, Version: schema.Schema.Version}
+	schema.RUnlock()
+	s.metadata.SchemaVersion = version.String()
+}
+
+func (s *v2QueryResponseShaper) newQueryResponseWriter(returnHLL bool, nQueries int) QueryResponseWriter {
+	if returnHLL {
+		return &v2HLLQueryResponseWriter{
+			QueryResponseWriter: NewHLLQueryResponseWriter(),
+			shaper:              s,
+		}
+	}
+	return &v2JSONQueryResponseWriter{
+		shaper: s,
+		response: apiCom.QueryResponseV2{
+			Results: make([]interface{}, nQueries),
+		},
+		statusCode: http.StatusOK,
+	}
+}
+
+// v2JSONQueryResponseWriter writes query results in the v2 response envelope.
+type v2JSONQueryResponseWriter struct {
+	shaper     *v2QueryResponseShaper
+	response   apiCom.QueryResponseV2
+	errors     []*apiCom.QueryErrorV2
+	statusCode int
+}
+
+// ReportError writes the error of the query to the response.
+func (w *v2JSONQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	if w.errors == nil {
+		w.errors = make([]*apiCom.QueryErrorV2, len(w.response.Results))
+	}
+	w.errors[queryIndex] = apiCom.NewQueryErrorV2(withQueryErrorCode(err, statusCode))
+	// Usually larger status code means more severe problem.
+	if statusCode > w.statusCode {
+		w.statusCode = statusCode
+		w.response.Error = w.errors[queryIndex]
+	}
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext ignores query contexts, since they are not part of the v2 response.
+func (w *v2JSONQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+}
+
+// ReportResult writes the query result to the response.
+func (w *v2JSONQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	qc.Postprocess()
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+	}
+	w.shaper.metadata.Warnings = append(w.shaper.metadata.Warnings, qc.Warnings...)
+	w.shaper.metadata.UpdateDataFreshness(getDataFreshness(w.shaper.memStore, qc))
+	w.shaper.metadata.Stats.RowsScanned += int64(qc.OOPK.RowsScanned)
+	w.response.Results[queryIndex] = qc.Results
+}
+
+// Respond writes the final response into ResponseWriter.
+func (w *v2JSONQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	// errors by query index are only needed to tell which query failed for multi-query requests.
+	if len(w.response.Results) > 1 {
+		w.response.Errors = w.errors
+	}
+	w.shaper.metadata.SetLatency(w.shaper.start)
+	w.response.Metadata = w.shaper.metadata
+	apiCom.RespondV2(rw, w.response)
+}
+
+// GetStatusCode returns the status code written into response.
+func (w *v2JSONQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// v2HLLQueryResponseWriter writes query results as application/hll, with metadata in headers.
+type v2HLLQueryResponseWriter struct {
+	QueryResponseWriter
+	shaper *v2QueryResponseShaper
+}
+
+// ReportResult writes the query result to the response.
+func (w *v2HLLQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	w.shaper.metadata.UpdateDataFreshness(getDataFreshness(w.shaper.memStore, qc))
+	w.shaper.metadata.Stats.RowsScanned += int64(qc.OOPK.RowsScanned)
+	w.QueryResponseWriter.ReportResult(queryIndex, qc)
+}
+
+// Respond writes the final response into ResponseWriter.
+func (w *v2HLLQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	w.shaper.metadata.WriteHeaders(rw)
+	w.QueryResponseWriter.Respond(rw)
+}
+
+// queryErrorCode returns the error code of query errors reported with status codes by handleQuery.
+func queryErrorCode(statusCode int) utils.ErrorCode {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return utils.ErrCodeInvalidQuery
+	case http.StatusServiceUnavailable:
+		return utils.ErrCodeResourceExhausted
+	default:
+		return utils.ErrCodeInternal
+	}
+}
+
+// withQueryErrorCode codes the query error by the status code, unless it is already coded, e.g. schema
+// mismatches reported by the compiler.
+func withQueryErrorCode(err error, statusCode int) error {
+	if _, ok := err.(*utils.CodedError); ok {
+		return err
+	}
+	return utils.WithCode(queryErrorCode(statusCode), err)
+}
+
+// getDataFreshness returns the last modified time of the stalest live store of fact table shards scanned
+// by the query, 0 if unknown.
+func getDataFreshness(memStore memstore.MemStore, qc *query.AQLQueryContext) (freshness int64) {
+	if len(qc.TableScanners) == 0 || qc.TableScanners[0].Schema == nil {
+		return
+	}
+	scanner := qc.TableScanners[0]
+	if !scanner.Schema.Schema.IsFactTable {
+		return
+	}
+	for _, shardID := range scanner.Shards {
+		tableShard, err := memStore.GetTableShard(scanner.Schema.Schema.Name, shardID)
+		if err != nil {
+			continue
+		}
+		lastModifiedTime := int64(tableShard.LiveStore.GetLastModifiedTime())
+		tableShard.Users.Done()
+		if lastModifiedTime > 0 && (freshness == 0 || lastModifiedTime < freshness) {
+			freshness = lastModifiedTime
+		}
+	}
+	return
+}
+
+// RegisterV2 registers http handlers of the v2 query api.
+func (handler *QueryHandler) RegisterV2(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.HandleAQLV2, wrappers)).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/sql", utils.ApplyHTTPWrappers(handler.HandleSQLV2, wrappers)).Methods(http.MethodGet, http.MethodPost)
+}
+
+// HandleAQLV2 swagger:route POST /v2/query/aql queryAQLV2
+// query in AQL with v2 response
+//
+// Consumes:
+//    - application/json
+//    - application/hll
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: queryResponseV2
+//        400: queryResponseV2
+func (handler *QueryHandler) HandleAQLV2(w http.ResponseWriter, r *http.Request) {
+	shaper := newV2QueryResponseShaper(handler.memStore, r)
+	// default device to negative value to differentiate 0 from empty
+	aqlRequest := apiCom.AQLRequest{Device: -1}
+
+	if err := apiCom.ReadRequest(r, &aqlRequest); err != nil {
+		shaper.respondRequestError(w, err)
+		utils.GetLogger().With(
+			"error", err,
+			"statusCode", http.StatusBadRequest,
+		).Error("failed to parse query")
+		return
+	}
+
+	handler.handleAQLInternal(aqlRequest, w, r, shaper)
+}
+
+// HandleSQLV2 swagger:route POST /v2/query/sql querySQLV2
+// query in SQL with v2 response
+//
+// Consumes:
+//    - application/json
+//    - application/hll
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: queryResponseV2
+//        400: queryResponseV2
+func (handler *QueryHandler) HandleSQLV2(w http.ResponseWriter, r *http.Request) {
+	shaper := newV2QueryResponseShaper(handler.memStore, r)
+	sqlRequest := apiCom.SQLRequest{Device: -1}
+
+	if err := apiCom.ReadRequest(r, &sqlRequest); err != nil {
+		shaper.respondRequestError(w, err)
+		utils.GetLogger().With(
+			"error", err,
+			"statusCode", http.StatusBadRequest,
+		).Error("failed to parse query")
+		return
+	}
+
+	aqlRequest, err := convertSQLRequest(sqlRequest)
+	if err != nil {
+		shaper.respondRequestError(w, utils.WithCode(utils.ErrCodeInvalidQuery, err))
+		return
+	}
+	handler.handleAQLInternal(aqlRequest, w, r, shaper)
+}
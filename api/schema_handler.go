@@ -16,8 +16,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/uber/aresdb/metastore"
 	"net/http"
+	"strconv"
 
 	"github.com/uber/aresdb/api/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
@@ -26,19 +28,46 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// number of days the old name of a renamed column is still accepted if not specified.
+const defaultColumnAliasWindowDays = 30
+
+// number of days deprecated columns are kept before purged if not specified.
+const defaultColumnDeprecationGracePeriodDays = 30
+
+// header of the caller recorded as the actor of schema changes.
+const schemaChangeActorHeader = "Rpc-Caller"
+
+// only admin can force schema changes with the force query param to override warnings from schema
+// change rules.
+const schemaChangeForceParam = "force"
+
+// query param of the table version the change is based on, the change is rejected with a conflict
+// if the table was changed in the meantime.
+const schemaChangeExpectedVersionParam = "expectedVersion"
+
 // SchemaHandler handles schema http requests.
 type SchemaHandler struct {
 	// all write requests will go to metaStore.
 	metaStore metaCom.MetaStore
+	// cluster namespace recorded in schema exports.
+	namespace string
+	// detects drift of local schema from controller, nil if schema is not synced from controller.
+	schemaDriftDetector *metastore.SchemaDriftDetector
 }
 
-// NewSchemaHandler will create a new SchemaHandler with memStore and metaStore.
-func NewSchemaHandler(metaStore metaCom.MetaStore) *SchemaHandler {
+// NewSchemaHandler will create a new SchemaHandler with metaStore of the cluster namespace.
+func NewSchemaHandler(metaStore metaCom.MetaStore, namespace string) *SchemaHandler {
 	return &SchemaHandler{
 		metaStore: metaStore,
+		namespace: namespace,
 	}
 }
 
+// SetSchemaDriftDetector sets the detector of drift of local schema from controller.
+func (handler *SchemaHandler) SetSchemaDriftDetector(detector *metastore.SchemaDriftDetector) {
+	handler.schemaDriftDetector = detector
+}
+
 // Register registers http handlers.
 func (handler *SchemaHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
 	router.HandleFunc("/tables", utils.ApplyHTTPWrappers(handler.ListTables, wrappers)).Methods(http.MethodGet)
@@ -46,9 +75,21 @@ func (handler *SchemaHandler) Register(router *mux.Router, wrappers ...utils.HTT
 	router.HandleFunc("/tables/{table}", utils.ApplyHTTPWrappers(handler.GetTable, wrappers)).Methods(http.MethodGet)
 	router.HandleFunc("/tables/{table}", utils.ApplyHTTPWrappers(handler.DeleteTable, wrappers)).Methods(http.MethodDelete)
 	router.HandleFunc("/tables/{table}", utils.ApplyHTTPWrappers(handler.UpdateTableConfig, wrappers)).Methods(http.MethodPut)
+	router.HandleFunc("/tables/{table}/metadata", utils.ApplyHTTPWrappers(handler.UpdateTableMetadata, wrappers)).Methods(http.MethodPut)
 	router.HandleFunc("/tables/{table}/columns", utils.ApplyHTTPWrappers(handler.AddColumn, wrappers)).Methods(http.MethodPost)
 	router.HandleFunc("/tables/{table}/columns/{column}", utils.ApplyHTTPWrappers(handler.UpdateColumn, wrappers)).Methods(http.MethodPut)
 	router.HandleFunc("/tables/{table}/columns/{column}", utils.ApplyHTTPWrappers(handler.DeleteColumn, wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/tables/{table}/columns/{column}/metadata", utils.ApplyHTTPWrappers(handler.UpdateColumnMetadata, wrappers)).Methods(http.MethodPut)
+	router.HandleFunc("/tables/{table}/columns/{column}/rename", utils.ApplyHTTPWrappers(handler.RenameColumn, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/tables/{table}/columns/{column}/aliases/{alias}", utils.ApplyHTTPWrappers(handler.DeleteColumnAlias, wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/tables/{table}/columns/{column}/deprecate", utils.ApplyHTTPWrappers(handler.DeprecateColumn, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/tables/{table}/history", utils.ApplyHTTPWrappers(handler.GetSchemaHistory, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/tables/{table}/versions/{version}", utils.ApplyHTTPWrappers(handler.GetTableAtVersion, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/tables/{table}/diff", utils.ApplyHTTPWrappers(handler.DiffTableVersions, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/versions", utils.ApplyHTTPWrappers(handler.GetTableSchemaVersions, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/drift", utils.ApplyHTTPWrappers(handler.DetectSchemaDrift, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/export", utils.ApplyHTTPWrappers(handler.ExportSchemas, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/import", utils.ApplyHTTPWrappers(handler.ImportSchemas, wrappers)).Methods(http.MethodPost)
 }
 
 // RegisterForDebug register handlers for debug port
@@ -57,6 +98,50 @@ func (handler *SchemaHandler) RegisterForDebug(router *mux.Router, wrappers ...u
 	router.HandleFunc("/tables/{table}", utils.ApplyHTTPWrappers(handler.GetTable, wrappers)).Methods(http.MethodGet)
 }
 
+// schemaMutator returns the mutator recording the caller of the request in schema history, which
+// overrides warnings from schema change rules if the request is forced by admin.
+func (handler *SchemaHandler) schemaMutator(r *http.Request) (metaCom.TableSchemaMutator, error) {
+	actor := r.Header.Get(schemaChangeActorHeader)
+	query := r.URL.Query()
+
+	force := false
+	if forceParam := query.Get(schemaChangeForceParam); forceParam != "" {
+		var err error
+		if force, err = strconv.ParseBool(forceParam); err != nil {
+			return nil, ErrMissingParameter
+		}
+	}
+
+	var mutator metaCom.TableSchemaMutator
+	if force {
+		if !isAdmin(utils.GetCallerRoles(r)) {
+			return nil, ErrForceSchemaChangeNotAllowed
+		}
+		mutator = handler.metaStore.ForceWithActor(actor)
+	} else {
+		mutator = handler.metaStore.WithActor(actor)
+	}
+
+	if versionParam := query.Get(schemaChangeExpectedVersionParam); versionParam != "" {
+		expectedVersion, err := strconv.Atoi(versionParam)
+		if err != nil {
+			return nil, ErrMissingParameter
+		}
+		mutator = mutator.ExpectVersion(expectedVersion)
+	}
+	return mutator, nil
+}
+
+// isAdmin checks whether the caller of the roles is admin.
+func isAdmin(roles []string) bool {
+	for _, role := range roles {
+		if role == metaCom.AdminRole {
+			return true
+		}
+	}
+	return false
+}
+
 // ListTables swagger:route GET /schema/tables listTables
 // List all table schemas
 // Consumes:
@@ -113,6 +198,11 @@ func (handler *SchemaHandler) GetTable(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if table != nil {
+		// columns the caller is not allowed to query are hidden.
+		redactedTable := table.RedactColumns(utils.GetCallerRoles(r))
+		table = &redactedTable
+	}
 	getTableResponse.JSONBuffer, err = json.Marshal(table)
 
 	common.RespondWithJSONBytes(w, getTableResponse.JSONBuffer, err)
@@ -138,12 +228,18 @@ func (handler *SchemaHandler) AddTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newTable := addTableRequest.Body
-	err = handler.metaStore.CreateTable(&newTable)
+	schemaMutator, err := handler.schemaMutator(r)
 	if err != nil {
 		common.RespondWithError(w, err)
 		return
 	}
+
+	newTable := addTableRequest.Body
+	err = schemaMutator.CreateTable(&newTable)
+	if err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
 	common.RespondWithJSONObject(w, nil)
 }
 
@@ -155,7 +251,7 @@ func (handler *SchemaHandler) AddTable(w http.ResponseWriter, r *http.Request) {
 //
 // Responses:
 //    default: errorResponse
-//        200: noContentResponse
+//        200: tableConfigResponse
 func (handler *SchemaHandler) UpdateTableConfig(w http.ResponseWriter, r *http.Request) {
 	var request UpdateTableConfigRequest
 	err := common.ReadRequest(r, &request)
@@ -164,12 +260,56 @@ func (handler *SchemaHandler) UpdateTableConfig(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = handler.metaStore.UpdateTableConfig(request.TableName, request.Body)
+	// unset configs fall back to defaults.
+	effectiveConfig := metastore.EffectiveTableConfig(request.Body)
+	if err = metastore.ValidateTableConfig(effectiveConfig); err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	err = schemaMutator.UpdateTableConfig(request.TableName, request.Body)
+	if err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
+	common.RespondWithJSONObject(w, effectiveConfig)
+}
+
+// UpdateTableMetadata swagger:route PUT /schema/tables/{table}/metadata updateTableMetadata
+// replace metadata of the specified table
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) UpdateTableMetadata(w http.ResponseWriter, r *http.Request) {
+	var request UpdateTableMetadataRequest
+	err := common.ReadRequest(r, &request)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	schemaMutator, err := handler.schemaMutator(r)
 	if err != nil {
 		common.RespondWithError(w, err)
 		return
 	}
 
+	if err = schemaMutator.UpdateTableMetadata(request.TableName, request.Body); err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
 	common.RespondWithJSONObject(w, nil)
 }
 
@@ -199,7 +339,9 @@ func (handler *SchemaHandler) DeleteTable(w http.ResponseWriter, r *http.Request
 }
 
 // AddColumn swagger:route POST /schema/tables/{table}/columns addColumn
-// add a single column to existing table
+// add a single column to existing table. Existing rows read the default value of the column.
+// If backfillDefault is set, the default value is also materialized into existing archive
+// batches in background, see the column_materialize job.
 //
 // Consumes:
 //    - application/json
@@ -215,13 +357,19 @@ func (handler *SchemaHandler) AddColumn(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = handler.metaStore.AddColumn(addColumnRequest.TableName, addColumnRequest.Body.Column, addColumnRequest.Body.AddToArchivingSortOrder)
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	err = schemaMutator.AddColumn(addColumnRequest.TableName, addColumnRequest.Body.Column, addColumnRequest.Body.AddToArchivingSortOrder)
 	// TODO: validate column
 	// might better do in metaStore and here needs to return either user error or server error
 	if err != nil {
 		// TODO: need mapping from metaStore error to api error
 		/// for metaStore error might also be user error
-		common.RespondWithError(w, err)
+		respondWithSchemaChangeError(w, err)
 		return
 	}
 
@@ -246,14 +394,51 @@ func (handler *SchemaHandler) UpdateColumn(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err = handler.metaStore.UpdateColumn(updateColumnRequest.TableName,
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	if err = schemaMutator.UpdateColumn(updateColumnRequest.TableName,
 		updateColumnRequest.ColumnName, updateColumnRequest.Body); err != nil {
 		// TODO: need mapping from metaStore error to api error
 		// for metaStore error might also be user error
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
+	common.RespondWithJSONObject(w, nil)
+}
+
+// UpdateColumnMetadata swagger:route PUT /schema/tables/{table}/columns/{column}/metadata updateColumnMetadata
+// replace metadata of the specified column
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) UpdateColumnMetadata(w http.ResponseWriter, r *http.Request) {
+	var request UpdateColumnMetadataRequest
+	err := common.ReadRequest(r, &request)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
 		common.RespondWithError(w, err)
 		return
 	}
 
+	if err = schemaMutator.UpdateColumnMetadata(request.TableName, request.ColumnName, request.Body); err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
 	common.RespondWithJSONObject(w, nil)
 }
 
@@ -272,15 +457,368 @@ func (handler *SchemaHandler) DeleteColumn(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = handler.metaStore.DeleteColumn(deleteColumnRequest.TableName, deleteColumnRequest.ColumnName)
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	err = schemaMutator.DeleteColumn(deleteColumnRequest.TableName, deleteColumnRequest.ColumnName)
 	// TODO: validate whether table exists and specified columns does not belong to primary key or time column
 	// might be better for metaStore to do this and return specified error type
 	if err != nil {
 		// TODO: need mapping from metaStore error to api error
 		// for metaStore error might also be user error
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
+	common.RespondWithJSONObject(w, nil)
+}
+
+// RenameColumn swagger:route POST /schema/tables/{table}/columns/{column}/rename renameColumn
+// rename a column, the old name is kept as an alias accepted by queries and ingestion
+// during the alias window
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) RenameColumn(w http.ResponseWriter, r *http.Request) {
+	var renameColumnRequest RenameColumnRequest
+
+	err := common.ReadRequest(r, &renameColumnRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	if renameColumnRequest.Body.NewName == "" {
+		common.RespondWithBadRequest(w, errors.New("New column name is not specified"))
+		return
+	}
+
+	aliasWindowDays := renameColumnRequest.Body.AliasWindowDays
+	if aliasWindowDays <= 0 {
+		aliasWindowDays = defaultColumnAliasWindowDays
+	}
+	aliasExpiresAt := utils.Now().Unix() + int64(aliasWindowDays)*86400
+
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	if err = schemaMutator.RenameColumn(renameColumnRequest.TableName, renameColumnRequest.ColumnName,
+		renameColumnRequest.Body.NewName, aliasExpiresAt); err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
+	common.RespondWithJSONObject(w, nil)
+}
+
+// DeleteColumnAlias swagger:route DELETE /schema/tables/{table}/columns/{column}/aliases/{alias} deleteColumnAlias
+// delete an alias of a renamed column so that the old name is no longer accepted
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) DeleteColumnAlias(w http.ResponseWriter, r *http.Request) {
+	var deleteColumnAliasRequest DeleteColumnAliasRequest
+
+	err := common.ReadRequest(r, &deleteColumnAliasRequest)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+
+	if err = schemaMutator.DeleteColumnAlias(deleteColumnAliasRequest.TableName,
+		deleteColumnAliasRequest.ColumnName, deleteColumnAliasRequest.Alias); err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
+	common.RespondWithJSONObject(w, nil)
+}
+
+// DeprecateColumn swagger:route POST /schema/tables/{table}/columns/{column}/deprecate deprecateColumn
+// deprecate a column, which is hidden from queries and purged after the grace period
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) DeprecateColumn(w http.ResponseWriter, r *http.Request) {
+	var deprecateColumnRequest DeprecateColumnRequest
+
+	err := common.ReadRequest(r, &deprecateColumnRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	gracePeriodDays := deprecateColumnRequest.Body.GracePeriodDays
+	if gracePeriodDays <= 0 {
+		gracePeriodDays = defaultColumnDeprecationGracePeriodDays
+	}
+	purgeAt := utils.Now().Unix() + int64(gracePeriodDays)*86400
+
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
 		common.RespondWithError(w, err)
 		return
 	}
 
+	if err = schemaMutator.DeprecateColumn(deprecateColumnRequest.TableName, deprecateColumnRequest.ColumnName, purgeAt); err != nil {
+		respondWithSchemaChangeError(w, err)
+		return
+	}
+
 	common.RespondWithJSONObject(w, nil)
 }
+
+// GetSchemaHistory swagger:route GET /schema/tables/{table}/history getSchemaHistory
+// list the schema changes of the table ordered by version
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: getSchemaHistoryResponse
+func (handler *SchemaHandler) GetSchemaHistory(w http.ResponseWriter, r *http.Request) {
+	var getSchemaHistoryRequest GetSchemaHistoryRequest
+	var getSchemaHistoryResponse GetSchemaHistoryResponse
+
+	err := common.ReadRequest(r, &getSchemaHistoryRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	getSchemaHistoryResponse.Body, err = handler.metaStore.GetSchemaHistory(getSchemaHistoryRequest.TableName)
+	if err != nil {
+		respondWithSchemaReadError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, getSchemaHistoryResponse.Body)
+}
+
+// GetTableAtVersion swagger:route GET /schema/tables/{table}/versions/{version} getTableAtVersion
+// get the table schema as of the version
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: getTableResponse
+func (handler *SchemaHandler) GetTableAtVersion(w http.ResponseWriter, r *http.Request) {
+	var getTableAtVersionRequest GetTableAtVersionRequest
+
+	err := common.ReadRequest(r, &getTableAtVersionRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	table, err := handler.metaStore.GetTableAtVersion(getTableAtVersionRequest.TableName, getTableAtVersionRequest.Version)
+	if err != nil {
+		respondWithSchemaReadError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, table.RedactColumns(utils.GetCallerRoles(r)))
+}
+
+// DiffTableVersions swagger:route GET /schema/tables/{table}/diff diffTableVersions
+// compare the table schema between two versions
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: diffTableVersionsResponse
+func (handler *SchemaHandler) DiffTableVersions(w http.ResponseWriter, r *http.Request) {
+	var diffTableVersionsRequest DiffTableVersionsRequest
+	var diffTableVersionsResponse DiffTableVersionsResponse
+
+	err := common.ReadRequest(r, &diffTableVersionsRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	fromTable, err := handler.metaStore.GetTableAtVersion(diffTableVersionsRequest.TableName, diffTableVersionsRequest.FromVersion)
+	if err != nil {
+		respondWithSchemaReadError(w, err)
+		return
+	}
+	toTable, err := handler.metaStore.GetTableAtVersion(diffTableVersionsRequest.TableName, diffTableVersionsRequest.ToVersion)
+	if err != nil {
+		respondWithSchemaReadError(w, err)
+		return
+	}
+
+	diffTableVersionsResponse.Body, err = metastore.DiffTables(fromTable, toTable)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, diffTableVersionsResponse.Body)
+}
+
+// ExportSchemas swagger:route GET /schema/export exportSchemas
+// export schemas, configs and enum cases of all tables as a single document
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: exportSchemasResponse
+func (handler *SchemaHandler) ExportSchemas(w http.ResponseWriter, r *http.Request) {
+	export, err := metastore.ExportSchemas(handler.metaStore, handler.namespace)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, export)
+}
+
+// ImportSchemas swagger:route POST /schema/import importSchemas
+// import tables of a schema export document, tables are validated the same way as other schema changes
+//
+// Consumes:
+//    - application/json
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: importSchemasResponse
+func (handler *SchemaHandler) ImportSchemas(w http.ResponseWriter, r *http.Request) {
+	var importSchemasRequest ImportSchemasRequest
+	var importSchemasResponse ImportSchemasResponse
+
+	err := common.ReadRequest(r, &importSchemasRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	schemaMutator, err := handler.schemaMutator(r)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	force, _ := strconv.ParseBool(r.URL.Query().Get(schemaChangeForceParam))
+
+	importSchemasResponse.Body, err = metastore.ImportSchemas(handler.metaStore, schemaMutator, importSchemasRequest.Body, metastore.SchemaImportOptions{
+		Mode:    importSchemasRequest.Mode,
+		EnumIDs: importSchemasRequest.EnumIDs,
+		Force:   force,
+	})
+	if err == metastore.ErrUnsupportedSchemaExportFormat || err == metastore.ErrInvalidSchemaImportMode {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, importSchemasResponse.Body)
+}
+
+// GetTableSchemaVersions swagger:route GET /schema/versions getTableSchemaVersions
+// get schema versions of all tables, used by brokers to skip hosts with stale schemas
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: getTableSchemaVersionsResponse
+func (handler *SchemaHandler) GetTableSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	var getTableSchemaVersionsResponse GetTableSchemaVersionsResponse
+
+	var err error
+	getTableSchemaVersionsResponse.Body, err = metastore.GetTableSchemaVersions(handler.metaStore)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, getTableSchemaVersionsResponse.Body)
+}
+
+// DetectSchemaDrift swagger:route GET /schema/drift detectSchemaDrift
+// compare local table schemas against controller and report tables with different schemas
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: detectSchemaDriftResponse
+func (handler *SchemaHandler) DetectSchemaDrift(w http.ResponseWriter, r *http.Request) {
+	var detectSchemaDriftRequest DetectSchemaDriftRequest
+	var detectSchemaDriftResponse DetectSchemaDriftResponse
+
+	err := common.ReadRequest(r, &detectSchemaDriftRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+
+	if handler.schemaDriftDetector == nil {
+		common.RespondWithError(w, ErrSchemaDriftDetectionDisabled)
+		return
+	}
+
+	detectSchemaDriftResponse.Body, err = handler.schemaDriftDetector.DetectDrift(detectSchemaDriftRequest.Resync != 0)
+	if err != nil {
+		common.RespondWithError(w, err)
+		return
+	}
+	common.RespondWithJSONObject(w, detectSchemaDriftResponse.Body)
+}
+
+// respondWithSchemaChangeError responds bad request with the violations for schema changes rejected by
+// schema change rules.
+func respondWithSchemaChangeError(w http.ResponseWriter, err error) {
+	if violationsErr, ok := err.(*metastore.SchemaViolationsError); ok {
+		common.RespondWithError(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: violationsErr.Error(),
+			Cause:   violationsErr,
+		})
+		return
+	}
+	if err == metastore.ErrSchemaVersionConflict {
+		common.RespondWithError(w, ErrSchemaVersionConflict)
+		return
+	}
+	common.RespondWithError(w, err)
+}
+
+// respondWithSchemaReadError responds not found for unknown tables or schema versions.
+func respondWithSchemaReadError(w http.ResponseWriter, err error) {
+	if err == metastore.ErrTableDoesNotExist || err == metastore.ErrSchemaVersionDoesNotExist {
+		common.RespondBytesWithCode(w, http.StatusNotFound, []byte(err.Error()))
+		return
+	}
+	common.RespondWithError(w, err)
+}
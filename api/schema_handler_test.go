@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	memCom "github.com/uber/aresdb/memstore/common"
 	memMocks "github.com/uber/aresdb/memstore/mocks"
@@ -70,7 +71,8 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 
 	ginkgo.BeforeEach(func() {
 		testMemStore = CreateMemStore(&testTableSchema, 0, nil, nil)
-		schemaHandler = NewSchemaHandler(testMetaStore)
+		schemaHandler = NewSchemaHandler(testMetaStore, "ns1")
+		testMetaStore.On("WithActor", mock.Anything).Return(testMetaStore)
 		testRouter := mux.NewRouter()
 		schemaHandler.Register(testRouter.PathPrefix("/schema").Subrouter())
 		testServer = httptest.NewUnstartedServer(WithPanicHandling(testRouter))
@@ -113,6 +115,36 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
 	})
 
+	ginkgo.It("GetTable should hide columns the caller is not allowed to query", func() {
+		restrictedTable := testTable
+		restrictedTable.Columns = append([]metaCom.Column(nil), testTable.Columns...)
+		restrictedTable.Columns = append(restrictedTable.Columns, metaCom.Column{Name: "col2", Type: "Int32"})
+		restrictedTable.Columns[0].Metadata = &metaCom.SchemaMetadata{AllowedRoles: []string{"finance"}}
+		testMetaStore.On("GetTable", "restrictedTable").Return(&restrictedTable, nil)
+
+		getTable := func(roles string) metaCom.Table {
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/schema/tables/%s", hostPort, "restrictedTable"), nil)
+			req.Header.Set(utils.HTTPCallerRoleHeaderKey, roles)
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+			respBody, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			respTable := metaCom.Table{}
+			Ω(json.Unmarshal(respBody, &respTable)).Should(BeNil())
+			return respTable
+		}
+
+		// column IDs of other columns are kept.
+		respTable := getTable("analyst")
+		Ω(respTable.Columns).Should(HaveLen(len(restrictedTable.Columns)))
+		Ω(respTable.Columns[0]).Should(Equal(metaCom.Column{Deleted: true}))
+		Ω(respTable.Columns[1:]).Should(Equal(restrictedTable.Columns[1:]))
+
+		Ω(getTable("analyst,finance")).Should(Equal(restrictedTable))
+		Ω(getTable(metaCom.AdminRole)).Should(Equal(restrictedTable))
+	})
+
 	ginkgo.It("AddTable should work", func() {
 
 		tableSchemaBytes, _ := json.Marshal(testTableSchema.Schema)
@@ -151,6 +183,22 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 		resp, _ = http.DefaultClient.Do(req)
 		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
 
+		// effective config is responded.
+		testMetaStore.On("UpdateTableConfig", "testTable", metaCom.TableConfig{BatchSize: 10}).Return(nil).Once()
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/schema/tables/%s", hostPort, testTableSchema.Schema.Name), bytes.NewBufferString(`{"batchSize": 10}`))
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		var effectiveConfig metaCom.TableConfig
+		Ω(json.NewDecoder(resp.Body).Decode(&effectiveConfig)).Should(BeNil())
+		expectedConfig := metastore.DefaultTableConfig
+		expectedConfig.BatchSize = 10
+		Ω(effectiveConfig).Should(Equal(expectedConfig))
+
+		// retention shorter than archiving delay.
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/schema/tables/%s", hostPort, testTableSchema.Schema.Name), bytes.NewBufferString(`{"recordRetentionInDays": 1, "archivingDelayMinutes": 2880}`))
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
 		testMetaStore.On("UpdateTableConfig", mock.Anything, mock.Anything).Return(errors.New("Failed to create table")).Once()
 		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/schema/tables/%s", hostPort, testTableSchema.Schema.Name), bytes.NewBuffer(tableSchemaBytes))
 		resp, _ = http.DefaultClient.Do(req)
@@ -214,6 +262,121 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
 	})
 
+	ginkgo.It("schema changes should only be forced by admin", func() {
+		testMetaStore.On("ForceWithActor", "alice").Return(testMetaStore)
+		testMetaStore.On("DeleteColumn", "testTable", "testColumn").Return(nil).Once()
+
+		url := fmt.Sprintf("http://%s/schema/tables/%s/columns/%s?force=true", hostPort, "testTable", "testColumn")
+		req, _ := http.NewRequest(http.MethodDelete, url, &bytes.Buffer{})
+		req.Header.Set(schemaChangeActorHeader, "alice")
+		resp, _ := http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusForbidden))
+
+		req, _ = http.NewRequest(http.MethodDelete, url, &bytes.Buffer{})
+		req.Header.Set(schemaChangeActorHeader, "alice")
+		req.Header.Set(utils.HTTPCallerRoleHeaderKey, metaCom.AdminRole)
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		testMetaStore.AssertCalled(ginkgo.GinkgoT(), "ForceWithActor", "alice")
+
+		req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s?force=abc", hostPort, "testTable", "testColumn"), &bytes.Buffer{})
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("schema changes rejected by schema change rules should respond violations", func() {
+		violationsErr := &metastore.SchemaViolationsError{
+			Violations: []metaCom.SchemaViolation{
+				{Rule: "dashboardColumn", Severity: metaCom.SchemaViolationWarning, Column: "testColumn", Message: "column testColumn is used by dashboards"},
+			},
+		}
+		testMetaStore.On("DeleteColumn", "testTable", "testColumn").Return(violationsErr).Once()
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s", hostPort, "testTable", "testColumn"), &bytes.Buffer{})
+		resp, _ := http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		respBody, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(string(respBody)).Should(MatchJSON(`{
+			"message": "Schema change rejected: [warning] dashboardColumn: column testColumn is used by dashboards",
+			"cause": {
+				"violations": [
+					{"rule": "dashboardColumn", "severity": "warning", "column": "testColumn", "message": "column testColumn is used by dashboards"}
+				]
+			}
+		}`))
+	})
+
+	ginkgo.It("schema changes based on stale versions should respond conflict", func() {
+		testMetaStore.On("ExpectVersion", 3).Return(testMetaStore)
+		testMetaStore.On("DeleteColumn", "testTable", "testColumn").Return(metastore.ErrSchemaVersionConflict).Once()
+
+		url := fmt.Sprintf("http://%s/schema/tables/%s/columns/%s?expectedVersion=3", hostPort, "testTable", "testColumn")
+		req, _ := http.NewRequest(http.MethodDelete, url, &bytes.Buffer{})
+		resp, _ := http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusConflict))
+		testMetaStore.AssertCalled(ginkgo.GinkgoT(), "ExpectVersion", 3)
+
+		req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s?expectedVersion=abc", hostPort, "testTable", "testColumn"), &bytes.Buffer{})
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("RenameColumn should work", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		defer utils.ResetClockImplementation()
+
+		testMetaStore.On("RenameColumn", "testTable", "testColumn", "newColumn", int64(1000+2*86400)).Return(nil).Once()
+		resp, _ := http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/rename", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{"newName": "newColumn", "aliasWindowDays": 2}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("RenameColumn", "testTable", "testColumn", "newColumn", int64(1000+30*86400)).Return(nil).Once()
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/rename", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{"newName": "newColumn"}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/rename", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		testMetaStore.On("RenameColumn", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("Failed to rename column")).Once()
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/rename", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{"newName": "newColumn"}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+	})
+
+	ginkgo.It("DeleteColumnAlias should work", func() {
+		testMetaStore.On("DeleteColumnAlias", "testTable", "testColumn", "oldColumn").Return(nil).Once()
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/aliases/%s", hostPort, "testTable", "testColumn", "oldColumn"), &bytes.Buffer{})
+		resp, _ := http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("DeleteColumnAlias", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("Failed to delete alias")).Once()
+		req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/aliases/%s", hostPort, "testTable", "testColumn", "oldColumn"), &bytes.Buffer{})
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+	})
+
+	ginkgo.It("DeprecateColumn should work", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		defer utils.ResetClockImplementation()
+
+		testMetaStore.On("DeprecateColumn", "testTable", "testColumn", int64(1000+7*86400)).Return(nil).Once()
+		resp, _ := http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/deprecate", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{"gracePeriodDays": 7}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("DeprecateColumn", "testTable", "testColumn", int64(1000+30*86400)).Return(nil).Once()
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/deprecate", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("DeprecateColumn", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("Failed to deprecate column")).Once()
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/deprecate", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+	})
+
 	ginkgo.It("UpdateColumn should work", func() {
 		testColumnConfig1 := metaCom.ColumnConfig{
 			PreloadingDays: 2,
@@ -238,4 +401,104 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 		resp, _ = http.DefaultClient.Do(req)
 		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
 	})
+
+	ginkgo.It("UpdateTableMetadata and UpdateColumnMetadata should work", func() {
+		testMetaStore.On("UpdateTableMetadata", "testTable", metaCom.SchemaMetadata{Owner: "marketplace"}).Return(nil).Once()
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/schema/tables/%s/metadata", hostPort, "testTable"),
+			bytes.NewBufferString(`{"owner": "marketplace"}`))
+		resp, _ := http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("UpdateColumnMetadata", "testTable", "testColumn", metaCom.SchemaMetadata{Description: "email", PII: true}).Return(nil).Once()
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/metadata", hostPort, "testTable", "testColumn"),
+			bytes.NewBufferString(`{"description": "email", "pii": true}`))
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("UpdateColumnMetadata", mock.Anything, mock.Anything, mock.Anything).Return(metastore.ErrColumnDoesNotExist).Once()
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/metadata", hostPort, "testTable", "unknown"),
+			bytes.NewBufferString(`{}`))
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+	})
+
+	ginkgo.It("GetSchemaHistory should work", func() {
+		history := []metaCom.SchemaChange{
+			{Version: 0, Timestamp: 100, Actor: "alice", Mutation: metaCom.SchemaMutationCreateTable},
+			{Version: 1, Timestamp: 200, Mutation: metaCom.SchemaMutationAddColumn, Column: "col2"},
+		}
+		testMetaStore.On("GetSchemaHistory", "testTable").Return(history, nil).Once()
+		testMetaStore.On("GetSchemaHistory", "unknown").Return(nil, metastore.ErrTableDoesNotExist).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/schema/tables/%s/history", hostPort, "testTable"))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		respBody, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		var respHistory []metaCom.SchemaChange
+		Ω(json.Unmarshal(respBody, &respHistory)).Should(BeNil())
+		Ω(respHistory).Should(Equal(history))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/schema/tables/%s/history", hostPort, "unknown"))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	ginkgo.It("ExportSchemas and ImportSchemas should work", func() {
+		testMetaStore.On("ListTables").Return([]string{"testTable"}, nil)
+		testMetaStore.On("GetTable", "testTable").Return(&testTable, nil)
+		resp, err := http.Get(fmt.Sprintf("http://%s/schema/export", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		var export metaCom.SchemaExport
+		Ω(json.NewDecoder(resp.Body).Decode(&export)).Should(BeNil())
+		Ω(export.Namespace).Should(Equal("ns1"))
+		Ω(export.Tables).Should(Equal([]metaCom.TableExport{{Table: testTable}}))
+
+		exportBytes, _ := json.Marshal(export)
+		resp, err = http.Post(fmt.Sprintf("http://%s/schema/import?mode=dryRun&enumIDs=preserve", hostPort), "application/json", bytes.NewBuffer(exportBytes))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		var results []metaCom.TableImportResult
+		Ω(json.NewDecoder(resp.Body).Decode(&results)).Should(BeNil())
+		Ω(results).Should(Equal([]metaCom.TableImportResult{{Table: "testTable", Action: metaCom.TableImportUnchanged}}))
+
+		// enum ID mode has to be chosen explicitly.
+		resp, err = http.Post(fmt.Sprintf("http://%s/schema/import?mode=upsert", hostPort), "application/json", bytes.NewBuffer(exportBytes))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("GetTableAtVersion and DiffTableVersions should work", func() {
+		oldTable := testTable
+		newTable := testTable
+		newTable.Version = 1
+		testMetaStore.On("GetTableAtVersion", "testTable", 0).Return(&oldTable, nil)
+		testMetaStore.On("GetTableAtVersion", "testTable", 1).Return(&newTable, nil)
+		testMetaStore.On("GetTableAtVersion", "testTable", 2).Return(nil, metastore.ErrSchemaVersionDoesNotExist)
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/schema/tables/%s/versions/%d", hostPort, "testTable", 1))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		respBody, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		respTable := metaCom.Table{}
+		Ω(json.Unmarshal(respBody, &respTable)).Should(BeNil())
+		Ω(respTable).Should(Equal(newTable))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/schema/tables/%s/versions/%d", hostPort, "testTable", 2))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/schema/tables/%s/diff?from=0&to=1", hostPort, "testTable"))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		respBody, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(string(respBody)).Should(MatchJSON(`[{"field": "version", "type": "changed", "oldValue": 0, "newValue": 1}]`))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/schema/tables/%s/diff?from=0&to=2", hostPort, "testTable"))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+	})
 })
@@ -54,6 +54,15 @@ type UpdateTableConfigRequest struct {
 	Body metaCom.TableConfig `body:""`
 }
 
+// UpdateTableMetadataRequest represents UpdateTableMetadata request.
+// swagger:parameters updateTableMetadata
+type UpdateTableMetadataRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: body
+	Body metaCom.SchemaMetadata `body:""`
+}
+
 // DeleteTableRequest represents DeleteTable request.
 // swagger:parameters deleteTable
 type DeleteTableRequest struct {
@@ -70,6 +79,46 @@ type DeleteColumnRequest struct {
 	ColumnName string `path:"column" json:"column"`
 }
 
+// RenameColumnRequest represents RenameColumn request.
+// swagger:parameters renameColumn
+type RenameColumnRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	ColumnName string `path:"column" json:"column"`
+	// in: body
+	Body struct {
+		NewName string `json:"newName"`
+		// Number of days the old name is still accepted, 30 days if not specified.
+		AliasWindowDays int `json:"aliasWindowDays,omitempty"`
+	} `body:""`
+}
+
+// DeprecateColumnRequest represents DeprecateColumn request.
+// swagger:parameters deprecateColumn
+type DeprecateColumnRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	ColumnName string `path:"column" json:"column"`
+	// in: body
+	Body struct {
+		// Number of days the column is still readable before it's purged, 30 days if not specified.
+		GracePeriodDays int `json:"gracePeriodDays,omitempty"`
+	} `body:""`
+}
+
+// DeleteColumnAliasRequest represents DeleteColumnAlias request.
+// swagger:parameters deleteColumnAlias
+type DeleteColumnAliasRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	ColumnName string `path:"column" json:"column"`
+	// in: path
+	Alias string `path:"alias" json:"alias"`
+}
+
 // ListEnumCasesRequest represents ListEnumCases request.
 // swagger:parameters listEnumCases
 type ListEnumCasesRequest struct {
@@ -93,6 +142,17 @@ type UpdateColumnRequest struct {
 	Body metaCom.ColumnConfig `body:""`
 }
 
+// UpdateColumnMetadataRequest represents UpdateColumnMetadata request.
+// swagger:parameters updateColumnMetadata
+type UpdateColumnMetadataRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	ColumnName string `path:"column" json:"column"`
+	// in: body
+	Body metaCom.SchemaMetadata `body:""`
+}
+
 // AddEnumCaseRequest represents AddEnumCase request.
 // swagger:parameters addEnumCase
 type AddEnumCaseRequest struct {
@@ -105,3 +165,61 @@ type AddEnumCaseRequest struct {
 		EnumCases []string `json:"enumCases"`
 	} `body:""`
 }
+
+// AddEnumCasesBulkRequest represents AddEnumCasesBulk request.
+// swagger:parameters addEnumCasesBulk
+type AddEnumCasesBulkRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: body
+	Body metaCom.BulkEnumCases `body:""`
+}
+
+// GetSchemaHistoryRequest represents GetSchemaHistory request.
+// swagger:parameters getSchemaHistory
+type GetSchemaHistoryRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+}
+
+// GetTableAtVersionRequest represents GetTableAtVersion request.
+// swagger:parameters getTableAtVersion
+type GetTableAtVersionRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	Version int `path:"version" json:"version"`
+}
+
+// DiffTableVersionsRequest represents DiffTableVersions request.
+// swagger:parameters diffTableVersions
+type DiffTableVersionsRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: query
+	FromVersion int `query:"from" json:"from"`
+	// in: query
+	ToVersion int `query:"to" json:"to"`
+}
+
+// ImportSchemasRequest represents ImportSchemas request.
+// swagger:parameters importSchemas
+type ImportSchemasRequest struct {
+	// One of dryRun, createOnly and upsert.
+	// in: query
+	Mode string `query:"mode" json:"mode"`
+	// How enum cases get their IDs, one of preserve and reassign. It has to be chosen explicitly
+	// since enum IDs are stored in existing data.
+	// in: query
+	EnumIDs string `query:"enumIDs" json:"enumIDs"`
+	// in: body
+	Body metaCom.SchemaExport `body:""`
+}
+
+// DetectSchemaDriftRequest represents DetectSchemaDrift request.
+// swagger:parameters detectSchemaDrift
+type DetectSchemaDriftRequest struct {
+	// Whether to resync schema from controller if drift is detected, 1 to resync.
+	// in: query
+	Resync int `query:"resync,optional" json:"resync"`
+}
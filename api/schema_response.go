@@ -26,6 +26,13 @@ type GetTableResponse struct {
 	JSONBuffer []byte `json:"-"`
 }
 
+// TableConfigResponse represents the effective table config after UpdateTableConfig.
+// swagger:response tableConfigResponse
+type TableConfigResponse struct {
+	//in: body
+	Body metaCom.TableConfig
+}
+
 // AddEnumCaseResponse represents AddEnumCase response.
 // swagger:response addEnumCaseResponse
 type AddEnumCaseResponse struct {
@@ -33,6 +40,13 @@ type AddEnumCaseResponse struct {
 	Body []int
 }
 
+// AddEnumCasesBulkResponse represents AddEnumCasesBulk response.
+// swagger:response addEnumCasesBulkResponse
+type AddEnumCasesBulkResponse struct {
+	//in: body
+	Body map[string][]int
+}
+
 // ListEnumCasesResponse represents ListEnumCases response.
 // swagger:response listEnumCasesResponse
 type ListEnumCasesResponse struct {
@@ -40,3 +54,45 @@ type ListEnumCasesResponse struct {
 	EnumCases  []string
 	JSONBuffer []byte `json:"-"`
 }
+
+// GetSchemaHistoryResponse represents GetSchemaHistory response.
+// swagger:response getSchemaHistoryResponse
+type GetSchemaHistoryResponse struct {
+	//in: body
+	Body []metaCom.SchemaChange
+}
+
+// DiffTableVersionsResponse represents DiffTableVersions response.
+// swagger:response diffTableVersionsResponse
+type DiffTableVersionsResponse struct {
+	//in: body
+	Body []metaCom.SchemaFieldDiff
+}
+
+// ExportSchemasResponse represents ExportSchemas response.
+// swagger:response exportSchemasResponse
+type ExportSchemasResponse struct {
+	//in: body
+	Body metaCom.SchemaExport
+}
+
+// ImportSchemasResponse represents ImportSchemas response.
+// swagger:response importSchemasResponse
+type ImportSchemasResponse struct {
+	//in: body
+	Body []metaCom.TableImportResult
+}
+
+// GetTableSchemaVersionsResponse represents GetTableSchemaVersions response.
+// swagger:response getTableSchemaVersionsResponse
+type GetTableSchemaVersionsResponse struct {
+	//in: body
+	Body map[string]metaCom.TableSchemaVersion
+}
+
+// DetectSchemaDriftResponse represents DetectSchemaDrift response.
+// swagger:response detectSchemaDriftResponse
+type DetectSchemaDriftResponse struct {
+	//in: body
+	Body []metaCom.SchemaDrift
+}
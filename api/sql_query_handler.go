@@ -48,16 +48,27 @@ func (handler *QueryHandler) HandleSQL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	aqlRequest, err := convertSQLRequest(sqlRequest)
+	if err != nil {
+		common.RespondWithBadRequest(w, err)
+		return
+	}
+	handler.handleAQLInternal(aqlRequest, w, r, v1QueryResponseShaper{})
+}
+
+// convertSQLRequest parses sql queries of the request into an aql request.
+func convertSQLRequest(sqlRequest common.SQLRequest) (aqlRequest common.AQLRequest, err error) {
 	var aqlQueries []queryCom.AQLQuery
 	if sqlRequest.Body.Queries != nil {
 		aqlQueries = make([]queryCom.AQLQuery, len(sqlRequest.Body.Queries))
 		startTs := utils.Now()
 		for i, sqlQuery := range sqlRequest.Body.Queries {
-			parsedAQLQuery, err := sql.Parse(sqlQuery, utils.GetLogger())
+			var parsedAQLQuery *queryCom.AQLQuery
+			parsedAQLQuery, err = sql.Parse(sqlQuery, utils.GetLogger())
 			if err != nil {
-				common.RespondWithBadRequest(w, err)
 				return
 			}
+			parsedAQLQuery.IncludeDeprecatedColumns = sqlRequest.IncludeDeprecatedColumns != 0
 			aqlQueries[i] = *parsedAQLQuery
 		}
 		sqlParseTimer := utils.GetRootReporter().GetTimer(utils.QuerySQLParsingLatency)
@@ -66,7 +77,7 @@ func (handler *QueryHandler) HandleSQL(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	aqlRequest := common.AQLRequest{
+	aqlRequest = common.AQLRequest{
 		Device:                sqlRequest.Device,
 		Verbose:               sqlRequest.Verbose + sqlRequest.Debug,
 		Debug:                 sqlRequest.Debug,
@@ -78,5 +89,5 @@ func (handler *QueryHandler) HandleSQL(w http.ResponseWriter, r *http.Request) {
 			Queries: aqlQueries,
 		},
 	}
-	handler.handleAQLInternal(aqlRequest, w, r)
+	return
 }
@@ -0,0 +1,109 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const defaultRetryAfterSec = 1
+
+// admissionController caps the number of queries running concurrently on broker, queries beyond the cap wait
+// in a queue of limited depth for running queries to finish, and queries beyond the queue are rejected.
+type admissionController struct {
+	sync.Mutex
+	// slots of running queries.
+	slots chan struct{}
+	// number of queries waiting for slots.
+	queued        int
+	maxQueueDepth int
+	// seconds rejected queries are told to retry after.
+	retryAfterSec int
+}
+
+// newAdmissionController creates the admission controller of the config, nil if not enabled.
+func newAdmissionController(cfg config.AdmissionConfig) *admissionController {
+	if cfg.MaxConcurrentQueries <= 0 {
+		return nil
+	}
+	retryAfterSec := cfg.RetryAfterSec
+	if retryAfterSec <= 0 {
+		retryAfterSec = defaultRetryAfterSec
+	}
+	return &admissionController{
+		slots:         make(chan struct{}, cfg.MaxConcurrentQueries),
+		maxQueueDepth: cfg.MaxQueueDepth,
+		retryAfterSec: retryAfterSec,
+	}
+}
+
+// admit admits the query to run, waiting in the queue for a slot until ctx is done or the deadline if not zero.
+// Queries are rejected with ErrCodeTooManyRequests if the queue is full, and the Retry-After header is set to
+// w. release must be called once the admitted query finishes.
+func (a *admissionController) admit(ctx context.Context, deadline time.Time, w http.ResponseWriter) (release func(), err error) {
+	if a == nil {
+		return func() {}, nil
+	}
+	release = func() { <-a.slots }
+	select {
+	case a.slots <- struct{}{}:
+		return
+	default:
+	}
+
+	a.Lock()
+	if a.queued >= a.maxQueueDepth {
+		a.Unlock()
+		utils.GetRootReporter().GetCounter(utils.BrokerQueriesRejected).Inc(1)
+		w.Header().Set(utils.HTTPRetryAfterHeaderKey, strconv.Itoa(a.retryAfterSec))
+		return nil, utils.NewCodedError(utils.ErrCodeTooManyRequests, nil,
+			"too many queries, %d running and %d queued", cap(a.slots), a.maxQueueDepth)
+	}
+	a.queued++
+	a.Unlock()
+	runningQuery := queryCom.GetRunningQuery(ctx)
+	runningQuery.SetPhase(queryCom.QueryPhaseQueued)
+	defer func() {
+		a.Lock()
+		a.queued--
+		a.Unlock()
+		runningQuery.SetPhase(queryCom.QueryPhaseCompiling)
+	}()
+
+	start := utils.Now()
+	defer func() {
+		utils.GetRootReporter().GetTimer(utils.BrokerAdmissionWait).Record(utils.Now().Sub(start))
+	}()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	select {
+	case a.slots <- struct{}{}:
+		return
+	case <-ctx.Done():
+		return nil, utils.NewCodedError(utils.ErrCodeTimeout, ctx.Err(),
+			"query timed out after waiting %v for admission", utils.Now().Sub(start))
+	}
+}
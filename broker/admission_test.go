@@ -0,0 +1,98 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("admission controller", func() {
+	ginkgo.It("should not cap queries when disabled", func() {
+		Ω(newAdmissionController(config.AdmissionConfig{})).Should(BeNil())
+		release, err := (*admissionController)(nil).admit(context.Background(), time.Time{}, httptest.NewRecorder())
+		Ω(err).Should(BeNil())
+		release()
+	})
+
+	ginkgo.It("should queue queries beyond the cap and reject queries beyond the queue", func() {
+		a := newAdmissionController(config.AdmissionConfig{MaxConcurrentQueries: 1, MaxQueueDepth: 1})
+		release, err := a.admit(context.Background(), time.Time{}, httptest.NewRecorder())
+		Ω(err).Should(BeNil())
+
+		admitted := make(chan func())
+		go func() {
+			queuedRelease, queuedErr := a.admit(context.Background(), time.Time{}, httptest.NewRecorder())
+			Ω(queuedErr).Should(BeNil())
+			admitted <- queuedRelease
+		}()
+		Eventually(func() int {
+			a.Lock()
+			defer a.Unlock()
+			return a.queued
+		}).Should(Equal(1))
+
+		w := httptest.NewRecorder()
+		_, err = a.admit(context.Background(), time.Time{}, w)
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeTooManyRequests))
+		Ω(err.Error()).Should(Equal("too many queries, 1 running and 1 queued"))
+		Ω(w.Header().Get(utils.HTTPRetryAfterHeaderKey)).Should(Equal("1"))
+
+		release()
+		(<-admitted)()
+		Ω(a.queued).Should(Equal(0))
+		Ω(a.slots).Should(BeEmpty())
+	})
+
+	ginkgo.It("should honor deadlines and contexts of queued queries", func() {
+		a := newAdmissionController(config.AdmissionConfig{MaxConcurrentQueries: 1, MaxQueueDepth: 2})
+		release, err := a.admit(context.Background(), time.Time{}, httptest.NewRecorder())
+		Ω(err).Should(BeNil())
+		defer release()
+
+		_, err = a.admit(context.Background(), utils.Now().Add(10*time.Millisecond), httptest.NewRecorder())
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeTimeout))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = a.admit(ctx, time.Time{}, httptest.NewRecorder())
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeTimeout))
+		Ω(a.queued).Should(Equal(0))
+	})
+
+	ginkgo.It("should admit queries of batches together", func() {
+		a := newAdmissionController(config.AdmissionConfig{MaxConcurrentQueries: 1})
+		batch := newQueryBatch(context.Background(), 2)
+		admits := 0
+		admit := func() (func(), error) {
+			admits++
+			return a.admit(context.Background(), time.Time{}, httptest.NewRecorder())
+		}
+		Ω(batch.admit(admit)).Should(BeNil())
+		Ω(batch.admit(admit)).Should(BeNil())
+		Ω(admits).Should(Equal(1))
+
+		batch.done(0)
+		Ω(a.slots).Should(HaveLen(1))
+		batch.done(1)
+		Ω(a.slots).Should(BeEmpty())
+	})
+})
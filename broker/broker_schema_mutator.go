@@ -28,6 +28,8 @@ type BrokerSchemaMutator struct {
 	sync.RWMutex
 
 	tables map[string]*memCom.TableSchema
+	// listeners of table changes applied by the schema fetch job.
+	changeListeners []func(table string)
 }
 
 func NewBrokerSchemaMutator() *BrokerSchemaMutator {
@@ -36,6 +38,18 @@ func NewBrokerSchemaMutator() *BrokerSchemaMutator {
 	}
 }
 
+// RegisterChangeListener registers a listener called with the table name when a table is created,
+// updated or deleted by the schema fetch job. It should be called before the schema fetch job runs.
+func (b *BrokerSchemaMutator) RegisterChangeListener(listener func(table string)) {
+	b.changeListeners = append(b.changeListeners, listener)
+}
+
+func (b *BrokerSchemaMutator) notifyChange(table string) {
+	for _, listener := range b.changeListeners {
+		listener(table)
+	}
+}
+
 // ====  metastore/common.TableSchemaMutator ====
 func (b *BrokerSchemaMutator) ListTables() (tables []string, err error) {
 	tables = make([]string, len(b.tables))
@@ -59,10 +73,12 @@ func (b *BrokerSchemaMutator) GetTable(name string) (table *common.Table, err er
 
 func (b *BrokerSchemaMutator) CreateTable(table *common.Table) (err error) {
 	b.tables[table.Name] = memCom.NewTableSchema(table)
+	b.notifyChange(table.Name)
 	return
 }
 func (b *BrokerSchemaMutator) DeleteTable(name string) (err error) {
 	delete(b.tables, name)
+	b.notifyChange(name)
 	return
 }
 func (b *BrokerSchemaMutator) UpdateTableConfig(table string, config common.TableConfig) (err error) {
@@ -71,6 +87,13 @@ func (b *BrokerSchemaMutator) UpdateTableConfig(table string, config common.Tabl
 }
 func (b *BrokerSchemaMutator) UpdateTable(table common.Table) (err error) {
 	b.tables[table.Name] = memCom.NewTableSchema(&table)
+	b.notifyChange(table.Name)
+	return
+}
+func (b *BrokerSchemaMutator) UpdateTableMetadata(table string, metadata common.SchemaMetadata) (err error) {
+	oldSchema := b.tables[table].Schema
+	oldSchema.Metadata = &metadata
+	b.tables[table] = memCom.NewTableSchema(&oldSchema)
 	return
 }
 func (b *BrokerSchemaMutator) AddColumn(table string, column common.Column, appendToArchivingSortOrder bool) (err error) {
@@ -100,6 +123,20 @@ func (b *BrokerSchemaMutator) UpdateColumn(table string, column string, config c
 	return
 }
 
+func (b *BrokerSchemaMutator) UpdateColumnMetadata(table string, column string, metadata common.SchemaMetadata) (err error) {
+	oldSchema := b.tables[table].Schema
+	oldSchema.Columns = append([]common.Column{}, oldSchema.Columns...)
+	for i, col := range oldSchema.Columns {
+		if col.Name == column && !col.Deleted {
+			oldSchema.Columns[i].Metadata = &metadata
+			b.tables[table] = memCom.NewTableSchema(&oldSchema)
+			return
+		}
+	}
+	err = errors.New(fmt.Sprintf("column %s not found", column))
+	return
+}
+
 func (b *BrokerSchemaMutator) DeleteColumn(table string, column string) (err error) {
 	oldSchema := b.tables[table].Schema
 	target := -1
@@ -117,3 +154,64 @@ func (b *BrokerSchemaMutator) DeleteColumn(table string, column string) (err err
 	b.tables[table] = memCom.NewTableSchema(&oldSchema)
 	return
 }
+
+func (b *BrokerSchemaMutator) RenameColumn(table string, column string, newName string, aliasExpiresAt int64) (err error) {
+	oldSchema := b.tables[table].Schema
+	target := -1
+	for i, col := range oldSchema.Columns {
+		if col.Name == column && !col.Deleted {
+			target = i
+			break
+		}
+	}
+	if target == -1 {
+		err = errors.New(fmt.Sprintf("column %s not found", column))
+		return
+	}
+	oldSchema.Columns = append([]common.Column{}, oldSchema.Columns...)
+	oldSchema.Columns[target].Name = newName
+	oldSchema.Columns[target].Aliases = append(append([]common.ColumnAlias{}, oldSchema.Columns[target].Aliases...),
+		common.ColumnAlias{Name: column, ExpiresAt: aliasExpiresAt})
+	b.tables[table] = memCom.NewTableSchema(&oldSchema)
+	return
+}
+
+func (b *BrokerSchemaMutator) DeleteColumnAlias(table string, column string, alias string) (err error) {
+	oldSchema := b.tables[table].Schema
+	oldSchema.Columns = append([]common.Column{}, oldSchema.Columns...)
+	for i, col := range oldSchema.Columns {
+		if col.Name != column || col.Deleted {
+			continue
+		}
+		for j, columnAlias := range col.Aliases {
+			if columnAlias.Name == alias {
+				oldSchema.Columns[i].Aliases = append(append([]common.ColumnAlias{}, col.Aliases[:j]...), col.Aliases[j+1:]...)
+				b.tables[table] = memCom.NewTableSchema(&oldSchema)
+				return
+			}
+		}
+		return metastore.ErrColumnAliasDoesNotExist
+	}
+	err = errors.New(fmt.Sprintf("column %s not found", column))
+	return
+}
+
+func (b *BrokerSchemaMutator) DeprecateColumn(table string, column string, purgeAt int64) (err error) {
+	oldSchema := b.tables[table].Schema
+	oldSchema.Columns = append([]common.Column{}, oldSchema.Columns...)
+	for i, col := range oldSchema.Columns {
+		if col.Name == column && !col.Deleted {
+			oldSchema.Columns[i].Deprecated, oldSchema.Columns[i].PurgeAt = true, purgeAt
+			b.tables[table] = memCom.NewTableSchema(&oldSchema)
+			return
+		}
+	}
+	err = errors.New(fmt.Sprintf("column %s not found", column))
+	return
+}
+
+// ExpectVersion returns the mutator itself, broker schemas are only changed by the schema fetch job
+// so there are no concurrent changes to detect.
+func (b *BrokerSchemaMutator) ExpectVersion(expectedVersion int) common.TableSchemaMutator {
+	return b
+}
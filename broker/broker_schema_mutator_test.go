@@ -71,6 +71,18 @@ var _ = ginkgo.Describe("broker schema mutator", func() {
 		assertTableListLen(mutator, 0)
 	})
 
+	ginkgo.It("should notify table changes", func() {
+		mutator := NewBrokerSchemaMutator()
+		var changedTables []string
+		mutator.RegisterChangeListener(func(table string) {
+			changedTables = append(changedTables, table)
+		})
+
+		Ω(mutator.CreateTable(&testTable)).Should(BeNil())
+		Ω(mutator.UpdateTable(testTableOneMoreCol)).Should(BeNil())
+		Ω(mutator.DeleteTable("t1")).Should(BeNil())
+		Ω(changedTables).Should(Equal([]string{"t1", "t1", "t1"}))
+	})
 })
 
 func assertTableListLen(mutator *BrokerSchemaMutator, length int) {
@@ -0,0 +1,270 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = 10 * time.Second
+)
+
+// breakerState is the state of the circuit breaker of a datanode, exported as the value of the gauge.
+type breakerState int
+
+const (
+	// queries are sent to the datanode.
+	breakerClosed breakerState = iota
+	// queries fail right away without being sent to the datanode.
+	breakerOpen
+	// a single probe query is sent to the datanode to tell whether it recovered.
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// hostBreaker is the circuit breaker state of a datanode.
+type hostBreaker struct {
+	state breakerState
+	// consecutive failed queries while closed.
+	failures int
+	// when the breaker was opened.
+	openedAt time.Time
+}
+
+// CircuitBreaker fails queries of datanodes failing consecutively right away, so that scans fail over to
+// replicas without spending retries on datanodes that are down. Breakers of datanodes are opened after the
+// failure threshold, and let a probe query through once open for the open duration, which closes the breaker
+// if succeeded or opens it again otherwise. Breakers of datanodes removed from the topology are reset.
+type CircuitBreaker struct {
+	sync.Mutex
+
+	topo         topology.Topology
+	threshold    int
+	openDuration time.Duration
+	stopChan     chan struct{}
+	// breakers by host id, datanodes without breakers are closed.
+	hosts map[string]*hostBreaker
+}
+
+// NewCircuitBreaker creates the circuit breaker of datanodes of the topology, nil if not enabled.
+func NewCircuitBreaker(cfg config.CircuitBreakerConfig, topo topology.Topology) *CircuitBreaker {
+	if !cfg.Enable {
+		return nil
+	}
+	threshold := cfg.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultBreakerFailureThreshold
+	}
+	openDuration := time.Duration(cfg.OpenDurationMillis) * time.Millisecond
+	if openDuration <= 0 {
+		openDuration = defaultBreakerOpenDuration
+	}
+	return &CircuitBreaker{
+		topo:         topo,
+		threshold:    threshold,
+		openDuration: openDuration,
+		stopChan:     make(chan struct{}),
+		hosts:        make(map[string]*hostBreaker),
+	}
+}
+
+// Run watches placement changes to reset breakers of datanodes removed from the topology.
+func (b *CircuitBreaker) Run() {
+	if b == nil {
+		return
+	}
+	watch, err := b.topo.Watch()
+	if err != nil {
+		utils.GetLogger().With("error", err.Error()).Error("Failed to watch placement changes")
+		return
+	}
+	defer watch.Close()
+
+	for {
+		select {
+		case <-watch.C():
+			b.OnPlacementChange()
+		case <-b.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops watching placement changes.
+func (b *CircuitBreaker) Stop() {
+	if b == nil {
+		return
+	}
+	close(b.stopChan)
+}
+
+// OnPlacementChange resets breakers of datanodes no longer in the topology, so that datanodes replaced under
+// the same id start closed.
+func (b *CircuitBreaker) OnPlacementChange() {
+	topoMap := b.topo.Get()
+	if topoMap == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	for hostID := range b.hosts {
+		if _, exist := topoMap.LookupHostShardSet(hostID); !exist {
+			if hb := b.hosts[hostID]; hb.state != breakerClosed {
+				b.transition(hostID, hb, breakerClosed)
+			}
+			delete(b.hosts, hostID)
+		}
+	}
+}
+
+// call calls the datanode unless its breaker is open, and records the outcome of the call. Calls to datanodes
+// with open breakers fail with ErrCodeUnavailable right away.
+func (b *CircuitBreaker) call(ctx context.Context, host topology.Host,
+	call func(ctx context.Context, host topology.Host) (interface{}, error)) (interface{}, error) {
+	if b == nil {
+		return call(ctx, host)
+	}
+	if !b.allow(host.ID()) {
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"host": host.ID(),
+		}, utils.DataNodeCircuitBreakerRejections).Inc(1)
+		return nil, utils.NewCodedError(utils.ErrCodeUnavailable, nil,
+			"shards of datanode %s are unavailable with its circuit breaker open", host.ID())
+	}
+	value, err := call(ctx, host)
+	if record, outcome := callOutcome(ctx, err); record {
+		b.record(host.ID(), outcome)
+	} else {
+		b.cancelProbe(host.ID())
+	}
+	return value, err
+}
+
+// callOutcome returns the outcome of a call to the datanode to record, and whether to record it at all. Calls
+// canceled by broker, e.g. hedged calls not taken, or ended with their queries running out of deadlines tell
+// nothing about the datanode, while calls running out of the datanode timeout count as failures since hung
+// datanodes only fail by the timeout.
+func callOutcome(ctx context.Context, err error) (record bool, outcome error) {
+	if ctx.Err() == nil {
+		return true, err
+	}
+	if callTimedOut(ctx) {
+		return true, utils.NewCodedError(utils.ErrCodeTimeout, err, "call to datanode timed out")
+	}
+	return false, nil
+}
+
+// allow tells whether a query can be sent to the datanode, the breaker open for the open duration turns
+// half-open to let the query through as the probe.
+func (b *CircuitBreaker) allow(hostID string) bool {
+	b.Lock()
+	defer b.Unlock()
+	hb := b.hosts[hostID]
+	if hb == nil {
+		return true
+	}
+	switch hb.state {
+	case breakerOpen:
+		if utils.Now().Sub(hb.openedAt) < b.openDuration {
+			return false
+		}
+		b.transition(hostID, hb, breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// only the probe is let through.
+		return false
+	default:
+		return true
+	}
+}
+
+// record records the outcome of a query of the datanode. Only failures of datanodes being unavailable count,
+// queries failed by themselves, e.g. invalid queries, do not.
+func (b *CircuitBreaker) record(hostID string, err error) {
+	b.Lock()
+	defer b.Unlock()
+	hb := b.hosts[hostID]
+	if err == nil || !utils.GetErrorCodeInfo(utils.GetErrorCode(err)).Retriable {
+		if hb != nil && hb.state != breakerClosed {
+			b.transition(hostID, hb, breakerClosed)
+		} else if hb != nil {
+			hb.failures = 0
+		}
+		return
+	}
+	if hb == nil {
+		hb = &hostBreaker{}
+		b.hosts[hostID] = hb
+	}
+	hb.failures++
+	if hb.state == breakerHalfOpen || (hb.state == breakerClosed && hb.failures >= b.threshold) {
+		hb.openedAt = utils.Now()
+		b.transition(hostID, hb, breakerOpen)
+	}
+}
+
+// cancelProbe opens the half-open breaker of the datanode again if the probe was canceled, so that the next
+// query is let through as the probe.
+func (b *CircuitBreaker) cancelProbe(hostID string) {
+	b.Lock()
+	defer b.Unlock()
+	if hb := b.hosts[hostID]; hb != nil && hb.state == breakerHalfOpen {
+		b.transition(hostID, hb, breakerOpen)
+	}
+}
+
+// transition moves the breaker of the datanode to the state, the lock must be held.
+func (b *CircuitBreaker) transition(hostID string, hb *hostBreaker, state breakerState) {
+	utils.GetLogger().With(
+		"host", hostID,
+		"from", hb.state.String(),
+		"to", state.String(),
+		"failures", hb.failures).Info("datanode circuit breaker state changed")
+	hb.state = state
+	if state == breakerClosed {
+		hb.failures = 0
+	}
+	utils.GetRootReporter().GetChildGauge(map[string]string{
+		"host": hostID,
+	}, utils.DataNodeCircuitBreakerState).Update(float64(state))
+}
+
+// state returns the breaker state of the datanode.
+func (b *CircuitBreaker) state(hostID string) breakerState {
+	b.Lock()
+	defer b.Unlock()
+	if hb := b.hosts[hostID]; hb != nil {
+		return hb.state
+	}
+	return breakerClosed
+}
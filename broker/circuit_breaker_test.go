@@ -0,0 +1,168 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("circuit breaker", func() {
+	var mockTopo topoMock.Topology
+	var mockMap topoMock.Map
+	var host topology.Host
+	var breaker *CircuitBreaker
+
+	unavailable := func(ctx context.Context, host topology.Host) (interface{}, error) {
+		return nil, utils.NewCodedError(utils.ErrCodeUnavailable, nil, "connection refused")
+	}
+	succeeded := func(ctx context.Context, host topology.Host) (interface{}, error) {
+		return "ok", nil
+	}
+
+	ginkgo.BeforeEach(func() {
+		mockTopo = topoMock.Topology{}
+		mockMap = topoMock.Map{}
+		mockTopo.On("Get").Return(&mockMap)
+		host = topology.NewHost("host0", "host0:9374")
+		breaker = NewCircuitBreaker(config.CircuitBreakerConfig{
+			Enable:             true,
+			FailureThreshold:   2,
+			OpenDurationMillis: 1000,
+		}, &mockTopo)
+		utils.SetCurrentTime(time.Unix(1000, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should not be created if not enabled", func() {
+		Ω(NewCircuitBreaker(config.CircuitBreakerConfig{}, &mockTopo)).Should(BeNil())
+		var nilBreaker *CircuitBreaker
+		value, err := nilBreaker.call(context.Background(), host, succeeded)
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal("ok"))
+	})
+
+	ginkgo.It("should open after consecutive failures and close after a succeeded probe", func() {
+		_, err := breaker.call(context.Background(), host, unavailable)
+		Ω(err).ShouldNot(BeNil())
+		Ω(breaker.state("host0")).Should(Equal(breakerClosed))
+		// queries failed by themselves do not count and reset failures.
+		breaker.call(context.Background(), host, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			return nil, utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "invalid query")
+		})
+		breaker.call(context.Background(), host, unavailable)
+		Ω(breaker.state("host0")).Should(Equal(breakerClosed))
+		breaker.call(context.Background(), host, unavailable)
+		Ω(breaker.state("host0")).Should(Equal(breakerOpen))
+
+		// open breakers fail right away.
+		called := false
+		_, err = breaker.call(context.Background(), host, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			called = true
+			return nil, nil
+		})
+		Ω(called).Should(BeFalse())
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeUnavailable))
+		Ω(err.Error()).Should(ContainSubstring("circuit breaker open"))
+
+		// a failed probe opens the breaker again.
+		utils.SetCurrentTime(time.Unix(1001, 0))
+		breaker.call(context.Background(), host, unavailable)
+		Ω(breaker.state("host0")).Should(Equal(breakerOpen))
+		Ω(breaker.allow("host0")).Should(BeFalse())
+
+		// only the probe is let through while half-open.
+		utils.SetCurrentTime(time.Unix(1002, 0))
+		Ω(breaker.allow("host0")).Should(BeTrue())
+		Ω(breaker.state("host0")).Should(Equal(breakerHalfOpen))
+		Ω(breaker.allow("host0")).Should(BeFalse())
+		breaker.record("host0", nil)
+		Ω(breaker.state("host0")).Should(Equal(breakerClosed))
+		_, err = breaker.call(context.Background(), host, succeeded)
+		Ω(err).Should(BeNil())
+	})
+
+	ginkgo.It("should let the next query probe if the probe was canceled", func() {
+		breaker.call(context.Background(), host, unavailable)
+		breaker.call(context.Background(), host, unavailable)
+		utils.SetCurrentTime(time.Unix(1001, 0))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		breaker.call(ctx, host, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			cancel()
+			return nil, ctx.Err()
+		})
+		Ω(breaker.state("host0")).Should(Equal(breakerOpen))
+		Ω(breaker.allow("host0")).Should(BeTrue())
+	})
+
+	ginkgo.It("should count calls timed out as failures", func() {
+		timedOut := func(ctx context.Context, host topology.Host) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		for i := 0; i < 2; i++ {
+			ctx, cancel := withCallTimeout(context.Background(), time.Millisecond)
+			breaker.call(ctx, host, timedOut)
+			cancel()
+		}
+		Ω(breaker.state("host0")).Should(Equal(breakerOpen))
+	})
+
+	ginkgo.It("should not count calls of queries running out of deadlines", func() {
+		timedOut := func(ctx context.Context, host topology.Host) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		for i := 0; i < 2; i++ {
+			queryCtx, cancelQuery := context.WithTimeout(context.Background(), time.Millisecond)
+			ctx, cancel := withCallTimeout(queryCtx, time.Minute)
+			breaker.call(ctx, host, timedOut)
+			cancel()
+			cancelQuery()
+
+			// calls without the datanode timeout are bounded by the query only.
+			queryCtx, cancelQuery = context.WithTimeout(context.Background(), time.Millisecond)
+			breaker.call(queryCtx, host, timedOut)
+			cancelQuery()
+		}
+		Ω(breaker.state("host0")).Should(Equal(breakerClosed))
+	})
+
+	ginkgo.It("should reset breakers of hosts removed from the topology", func() {
+		breaker.call(context.Background(), host, unavailable)
+		breaker.call(context.Background(), host, unavailable)
+		Ω(breaker.state("host0")).Should(Equal(breakerOpen))
+
+		mockMap.On("LookupHostShardSet", "host0").Return(nil, true).Once()
+		breaker.OnPlacementChange()
+		Ω(breaker.state("host0")).Should(Equal(breakerOpen))
+
+		mockMap.On("LookupHostShardSet", "host0").Return(nil, false).Once()
+		breaker.OnPlacementChange()
+		Ω(breaker.state("host0")).Should(Equal(breakerClosed))
+		Ω(breaker.allow("host0")).Should(BeTrue())
+	})
+})
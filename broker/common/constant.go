@@ -25,13 +25,31 @@ const (
 	Min
 	Avg
 	Hll
+	Var
+	Stddev
+	CountDistinct
+	Percentile
 )
 
+// String returns the call name of the aggregation, e.g. for tags of metrics.
+func (t AggType) String() string {
+	for name, agg := range CallNameToAggType {
+		if agg == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
 var CallNameToAggType = map[string]AggType{
-	expr.CountCallName: Count,
-	expr.SumCallName:   Sum,
-	expr.AvgCallName:   Avg,
-	expr.MaxCallName:   Max,
-	expr.MinCallName:   Min,
-	expr.HllCallName:   Hll,
+	expr.CountCallName:         Count,
+	expr.SumCallName:           Sum,
+	expr.AvgCallName:           Avg,
+	expr.MaxCallName:           Max,
+	expr.MinCallName:           Min,
+	expr.HllCallName:           Hll,
+	expr.VarCallName:           Var,
+	expr.StddevCallName:        Stddev,
+	expr.CountDistinctCallName: CountDistinct,
+	expr.PercentileCallName:    Percentile,
 }
@@ -27,4 +27,230 @@ type BrokerConfig struct {
 	HTTP             common.HTTPConfig        `yaml:"http"`
 	Etcd             etcd.Configuration       `yaml:"etcd"`
 	Cluster          common.ClusterConfig     `yaml:"cluster"`
+
+	SchemaVersionCheck SchemaVersionCheckConfig `yaml:"schema_version_check"`
+	SchemaValidation   SchemaValidationConfig   `yaml:"schema_validation"`
+	HLLUnion           HLLUnionConfig           `yaml:"hll_union"`
+	Health             HealthConfig             `yaml:"health"`
+	Pagination         PaginationConfig         `yaml:"pagination"`
+	WebSocket          WebSocketConfig          `yaml:"websocket"`
+	QueryStats         QueryStatsConfig         `yaml:"query_stats"`
+	CountDistinct      CountDistinctConfig      `yaml:"count_distinct"`
+	Dedup              DedupConfig              `yaml:"dedup"`
+	Sort               SortConfig               `yaml:"sort"`
+	PartialResults     PartialResultsConfig     `yaml:"partial_results"`
+	QueryTimeout       QueryTimeoutConfig       `yaml:"query_timeout"`
+	Hedge              HedgeConfig              `yaml:"hedge"`
+	ResultCache        ResultCacheConfig        `yaml:"result_cache"`
+	Compression        CompressionConfig        `yaml:"compression"`
+	ShardAssignment    ShardAssignmentConfig    `yaml:"shard_assignment"`
+	Consistency        ConsistencyConfig        `yaml:"consistency"`
+	Admission          AdmissionConfig          `yaml:"admission"`
+	SchemaSkew         SchemaSkewConfig         `yaml:"schema_skew"`
+	CircuitBreaker     CircuitBreakerConfig     `yaml:"circuit_breaker"`
+	RetryBudget        RetryBudgetConfig        `yaml:"retry_budget"`
+	HostHealth         HostHealthConfig         `yaml:"host_health"`
+}
+
+// SchemaVersionCheckConfig is the config for excluding datanodes with stale schemas from queries
+type SchemaVersionCheckConfig struct {
+	// whether to check schema versions of datanodes before querying them.
+	Enable bool `yaml:"enable"`
+	// max number of versions the schema of a datanode can be behind broker for the queried table.
+	MaxVersionLag int `yaml:"max_version_lag"`
+	// seconds to cache schema versions of datanodes.
+	CacheTTLSec int `yaml:"cache_ttl"`
+}
+
+// SchemaValidationConfig is the config for validating queries against table schemas of broker
+type SchemaValidationConfig struct {
+	// whether columns referenced by queries are validated by broker before querying datanodes.
+	Enable bool `yaml:"enable"`
+}
+
+// HLLUnionConfig is the config for unioning serialized hll query results
+type HLLUnionConfig struct {
+	// max bytes of a request body, 0 means the default.
+	MaxRequestBytes int64 `yaml:"max_request_bytes"`
+}
+
+// HealthConfig is the config for broker readiness checks
+type HealthConfig struct {
+	// min fraction of shards with at least one available datanode for the broker to be ready,
+	// 0 means all shards are required.
+	MinShardCoverage float64 `yaml:"min_shard_coverage"`
+}
+
+// PaginationConfig is the config for cursor pagination of non aggregation queries
+type PaginationConfig struct {
+	// max number of rows of a page, 0 means the default.
+	MaxPageSize int `yaml:"max_page_size"`
+	// seconds a cursor stays valid after its page is returned, 0 means the default.
+	CursorTTLSec int `yaml:"cursor_ttl"`
+}
+
+// WebSocketConfig is the config for streaming query results over websockets
+type WebSocketConfig struct {
+	// seconds between pings sent to keep idle connections alive, 0 means the default.
+	PingIntervalSec int `yaml:"ping_interval"`
+	// max number of messages sent but not acknowledged by the client, 0 means the default.
+	MaxInFlightMessages int `yaml:"max_in_flight_messages"`
+	// max number of rows of a message, 0 means the default.
+	MaxRowsPerMessage int `yaml:"max_rows_per_message"`
+}
+
+// QueryStatsConfig is the config for rolling statistics of queries by fingerprint
+type QueryStatsConfig struct {
+	// max number of fingerprints tracked, least recently seen ones are evicted, 0 means the default.
+	MaxFingerprints int `yaml:"max_fingerprints"`
+	// seconds of the sliding window of statistics, 0 means the default.
+	WindowSec int `yaml:"window"`
+	// number of top fingerprints by query count reported as tagged metrics, 0 means the default.
+	TopN int `yaml:"top_n"`
+	// seconds between reports of metrics of top fingerprints, 0 means the default.
+	ReportIntervalSec int `yaml:"report_interval"`
+}
+
+// CountDistinctConfig is the config for exact distinct counts merged by brokers
+type CountDistinctConfig struct {
+	// max number of distinct values of a bucket of countdistinct queries, 0 means the default.
+	MaxValuesPerBucket int `yaml:"max_values_per_bucket"`
+}
+
+// DedupConfig is the config for dropping duplicate rows of non aggregation queries across datanodes
+type DedupConfig struct {
+	// max bytes of hashes of rows tracked by a query, dedup is disabled for the rest of the query beyond it,
+	// 0 means the default.
+	MaxMemoryBytes int `yaml:"max_memory_bytes"`
+}
+
+// SortConfig is the config for merging rows of sorted non aggregation queries across datanodes
+type SortConfig struct {
+	// max bytes of rows of datanodes buffered by a sorted query, queries fail beyond it, 0 means the default.
+	MaxMemoryBytes int `yaml:"max_memory_bytes"`
+}
+
+// PartialResultsConfig is the config for returning results of datanodes succeeded when other datanodes fail
+type PartialResultsConfig struct {
+	// whether queries return partial results by default, queries can override it by the partialResults
+	// request parameter.
+	Enable bool `yaml:"enable"`
+}
+
+// QueryTimeoutConfig is the config for bounding how long broker queries run
+type QueryTimeoutConfig struct {
+	// milliseconds a query can run by default, queries can override it by the timeout request parameter,
+	// 0 means no timeout.
+	DefaultTimeoutMillis int `yaml:"default_timeout_millis"`
+	// milliseconds a call to a datanode can run, datanodes timed out count as failed by circuit breakers and
+	// health tracking, 0 means calls are only bounded by their queries.
+	DataNodeTimeoutMillis int `yaml:"datanode_timeout_millis"`
+}
+
+// HedgeConfig is the config for hedging queries of slow datanodes to replicas of their shards
+type HedgeConfig struct {
+	// whether queries of datanodes not responded in time are sent to replicas owning the same shards as well,
+	// whichever responds first is taken.
+	Enable bool `yaml:"enable"`
+	// milliseconds to wait for datanodes before hedging, 0 means the latency percentile of the datanode.
+	DelayMillis int `yaml:"delay_millis"`
+	// percentile of recent latencies of a datanode to wait before hedging, 0 means the default.
+	LatencyPercentile float64 `yaml:"latency_percentile"`
+	// number of recent latencies tracked per datanode, 0 means the default.
+	LatencyWindow int `yaml:"latency_window"`
+	// min milliseconds to wait before hedging by the latency percentile.
+	MinDelayMillis int `yaml:"min_delay_millis"`
+}
+
+// ResultCacheConfig is the config for caching results of aggregation queries in broker
+type ResultCacheConfig struct {
+	// whether results of identical aggregation queries are served from the cache of broker.
+	Enable bool `yaml:"enable"`
+	// max number of results cached, 0 means the default.
+	MaxEntries int `yaml:"max_entries"`
+	// max bytes of results cached, 0 means the default.
+	MaxBytes int64 `yaml:"max_bytes"`
+	// seconds results are cached, 0 means the default.
+	TTLSec int `yaml:"ttl_sec"`
+	// seconds of buckets now of queries is normalized to, relative time filters finer than buckets are not
+	// cached. 0 means the default.
+	BucketSec int `yaml:"bucket_sec"`
+}
+
+// CompressionConfig is the config for compressing query responses of broker
+type CompressionConfig struct {
+	// whether responses of requests accepting gzip are compressed.
+	Enable bool `yaml:"enable"`
+	// gzip compression level from 1 (best speed) to 9 (best compression), 0 means the default.
+	Level int `yaml:"level"`
+	// min bytes of responses to compress, smaller responses are not compressed.
+	MinBytes int `yaml:"min_bytes"`
+}
+
+// ShardAssignmentConfig is the config for assigning shards of queries to replicas
+type ShardAssignmentConfig struct {
+	// whether replicas of shards are shuffled per query before picking the least loaded one, so that
+	// queries spread over all replicas, otherwise shards are assigned in the order of the topology.
+	SpreadReplicas bool `yaml:"spread_replicas"`
+}
+
+// ConsistencyConfig is the config for how many replicas of each shard answer queries
+type ConsistencyConfig struct {
+	// consistency level of queries by default, queries can override it by the consistency request parameter.
+	// all (the default) reads each shard from its assigned replica with failover, quorum and one read each
+	// shard from all of its replicas and return once a majority or one of them responded.
+	DefaultLevel string `yaml:"default_level"`
+}
+
+// AdmissionConfig is the config for capping queries running concurrently on broker
+type AdmissionConfig struct {
+	// max number of queries running concurrently, 0 means no cap. Queries of a batch request count as one.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+	// max number of queries waiting for running queries to finish, queries beyond it are rejected.
+	MaxQueueDepth int `yaml:"max_queue_depth"`
+	// seconds rejected queries are told to retry after, 0 means the default.
+	RetryAfterSec int `yaml:"retry_after_sec"`
+}
+
+// SchemaSkewConfig is the config for handling datanodes lagging behind schemas of broker during rolling
+// schema changes
+type SchemaSkewConfig struct {
+	// milliseconds scans failed with schema mismatches wait for datanodes to refresh schemas before they are
+	// retried, 0 means retried right away.
+	RetryDelayMillis int `yaml:"retry_delay_millis"`
+}
+
+// CircuitBreakerConfig is the config for failing fast on datanodes failing queries consecutively
+type CircuitBreakerConfig struct {
+	Enable bool `yaml:"enable"`
+	// number of consecutive failed queries opening the breaker of a datanode, 0 means the default.
+	FailureThreshold int `yaml:"failure_threshold"`
+	// milliseconds the breaker stays open before a probe query is let through, 0 means the default.
+	OpenDurationMillis int `yaml:"open_duration_millis"`
+}
+
+// RetryBudgetConfig is the config for capping retries of scans of datanodes across queries of the broker
+type RetryBudgetConfig struct {
+	Enable bool `yaml:"enable"`
+	// number of retries allowed per scan of recent scans, 0 means the default.
+	Ratio float64 `yaml:"ratio"`
+	// number of retries allowed per second regardless of scans, so that retries of low traffic are not
+	// skipped, 0 means none.
+	MinRetriesPerSecond int `yaml:"min_retries_per_second"`
+	// seconds scans and retries count towards the budget, 0 means the default.
+	WindowSec int `yaml:"window_sec"`
+}
+
+// HostHealthConfig is the config for tracking health of datanodes by outcomes of their queries, shards are
+// assigned to the healthiest replicas
+type HostHealthConfig struct {
+	Enable bool `yaml:"enable"`
+	// milliseconds for outcomes of queries to decay to half of their weight, 0 means the default.
+	HalfLifeMillis int `yaml:"half_life_millis"`
+	// ratio of failed queries marking a datanode degraded, 0 means the default.
+	DegradedRatio float64 `yaml:"degraded_ratio"`
+	// ratio of failed queries marking a datanode down, 0 means the default.
+	DownRatio float64 `yaml:"down_ratio"`
+	// min number of recent failed queries marking a datanode down, 0 means the default.
+	DownFailures int `yaml:"down_failures"`
 }
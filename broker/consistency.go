@@ -0,0 +1,176 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/uber/aresdb/broker/util"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/utils"
+)
+
+// ConsistencyLevel is the consistency level of reads of shards by broker queries.
+type ConsistencyLevel string
+
+const (
+	// ConsistencyOne reads each shard from all of its replicas, with the result of the first replica responded.
+	ConsistencyOne ConsistencyLevel = "one"
+	// ConsistencyQuorum reads each shard from all of its replicas until a majority of them responded, with the
+	// result of the first replica responded.
+	ConsistencyQuorum ConsistencyLevel = "quorum"
+	// ConsistencyAll reads each shard from its assigned replica, failing over to other replicas.
+	ConsistencyAll ConsistencyLevel = "all"
+)
+
+// parseConsistencyLevel parses the consistency level case insensitively, empty means ConsistencyAll.
+func parseConsistencyLevel(level string) (ConsistencyLevel, error) {
+	switch l := ConsistencyLevel(strings.ToLower(level)); l {
+	case "", ConsistencyAll:
+		return ConsistencyAll, nil
+	case ConsistencyOne, ConsistencyQuorum:
+		return l, nil
+	}
+	return "", utils.StackError(nil, "invalid consistency %s, expects one, quorum or all", level)
+}
+
+// requiredReplicas returns the number of replicas of each shard required to respond by the consistency level
+// of shards of the replication factor, 0 for reading shards from their assigned replicas.
+func (l ConsistencyLevel) requiredReplicas(replicationFactor int) int {
+	switch l {
+	case ConsistencyOne:
+		return 1
+	case ConsistencyQuorum:
+		return replicationFactor/2 + 1
+	}
+	return 0
+}
+
+// shardGroupHost keys the scan of a group of shards owned by the same replicas in shard assignments by the
+// first replica of the group, groups sharing their first replica are keyed separately. Scans of groups query
+// the replicas of the group rather than the key.
+type shardGroupHost struct {
+	topology.Host
+}
+
+// assignScans assigns shards of the query to scans by its consistency level, with replicas of the assigned
+// hosts and the number of replicas required to respond. For ConsistencyAll shards are assigned to hosts
+// failing over to replicas, see calculateShardAssignment. Otherwise shards are grouped by their replicas, all
+// replicas of each group are queried and the required number of them must respond, so that results of each
+// shard are taken from one replica exactly once.
+func assignScans(qc *QueryContext, topo topology.Topology) (assignment map[topology.Host][]uint32,
+	replicas map[topology.Host][]topology.Host, required int, err error) {
+	if qc.consistency == "" || qc.consistency == ConsistencyAll {
+		if assignment, err = calculateShardAssignment(qc, topo); err != nil {
+			return
+		}
+		replicas, err = assignedReplicas(qc, topo, assignment)
+		return
+	}
+
+	replicationFactor := topo.Get().Replicas()
+	if replicationFactor <= 1 {
+		err = utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil,
+			"consistency %s requires replicated shards, but the replication factor of the topology is %d",
+			qc.consistency, replicationFactor))
+		return
+	}
+	required = qc.consistency.requiredReplicas(replicationFactor)
+
+	var groups []util.ShardGroup
+	var unavailable []uint32
+	groups, unavailable, err = util.CalculateShardGroups(topo, qc.ExcludedHosts)
+	if err != nil {
+		err = utils.WithCode(utils.ErrCodeClusterDegraded, err)
+		return
+	}
+	assignment = make(map[topology.Host][]uint32, len(groups))
+	replicas = make(map[topology.Host][]topology.Host, len(groups))
+	for _, group := range groups {
+		if len(group.Replicas) < required {
+			unavailable = append(unavailable, group.ShardIDs...)
+			continue
+		}
+		key := &shardGroupHost{Host: group.Replicas[0]}
+		assignment[key] = group.ShardIDs
+		replicas[key] = group.Replicas
+	}
+	if len(unavailable) > 0 {
+		err = utils.WithCode(utils.ErrCodeClusterDegraded, utils.StackError(nil,
+			"shards %v have fewer than %d replicas available required by consistency %s",
+			unavailable, required, qc.consistency))
+		return
+	}
+	if qc.allowPartialResults {
+		qc.partial = newPartialResults(assignment, nil)
+	}
+	return
+}
+
+// readReplicas queries all replicas concurrently, and returns the value of the first replica succeeded once
+// the required number of replicas succeeded, queries still running are canceled and waited for. The read fails once too many
+// replicas failed for the rest to reach the required number, with errors of all failed replicas. The number of
+// failed replicas is returned either way.
+func readReplicas(ctx context.Context, replicas []topology.Host, required int,
+	query func(ctx context.Context, replica topology.Host) (interface{}, error)) (value interface{}, failures int, err error) {
+	var wg sync.WaitGroup
+	// canceled before waiting for the queries, so that no query outlives the read.
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type response struct {
+		replica topology.Host
+		value   interface{}
+		err     error
+	}
+	responses := make(chan response, len(replicas))
+	wg.Add(len(replicas))
+	for _, replica := range replicas {
+		go func(replica topology.Host) {
+			defer wg.Done()
+			value, err := query(ctx, replica)
+			responses <- response{replica: replica, value: value, err: err}
+		}(replica)
+	}
+
+	var readErr *utils.CodedError
+	succeeded := 0
+	for range replicas {
+		res := <-responses
+		if res.err != nil {
+			failures++
+			replicaErr := newDataNodeError(res.replica, res.err).(*utils.CodedError)
+			if readErr == nil {
+				readErr = replicaErr
+			} else {
+				readErr.HostErrors = append(readErr.HostErrors, replicaErr.HostErrors...)
+			}
+			if len(replicas)-failures < required {
+				return nil, failures, readErr
+			}
+			continue
+		}
+		if succeeded == 0 {
+			value = res.value
+		}
+		if succeeded++; succeeded >= required {
+			return value, failures, nil
+		}
+	}
+	return nil, failures, readErr
+}
@@ -0,0 +1,159 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	shardMock "github.com/uber/aresdb/cluster/shard/mocks"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("consistency", func() {
+	var mockTopo topoMock.Topology
+	var mockMap topoMock.Map
+	var hosts []topology.Host
+	var replicas []topology.Host
+
+	ginkgo.BeforeEach(func() {
+		// host0, host1, host2: 0,1
+		mockTopo = topoMock.Topology{}
+		mockMap = topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		// hosts are not mocked since mocks record calls of replicas read concurrently.
+		hosts = nil
+		for i := 0; i < 3; i++ {
+			hosts = append(hosts, topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i)))
+		}
+		replicas = hosts
+		mockMap.On("Hosts").Return(replicas)
+		mockMap.On("RouteShard", mock.Anything).Return(replicas, nil)
+	})
+
+	ginkgo.It("should parse consistency levels", func() {
+		for level, expected := range map[string]ConsistencyLevel{
+			"":       ConsistencyAll,
+			"all":    ConsistencyAll,
+			"QUORUM": ConsistencyQuorum,
+			"one":    ConsistencyOne,
+		} {
+			parsed, err := parseConsistencyLevel(level)
+			Ω(err).Should(BeNil())
+			Ω(parsed).Should(Equal(expected))
+		}
+		_, err := parseConsistencyLevel("two")
+		Ω(err).ShouldNot(BeNil())
+
+		Ω(ConsistencyOne.requiredReplicas(3)).Should(Equal(1))
+		Ω(ConsistencyQuorum.requiredReplicas(3)).Should(Equal(2))
+		Ω(ConsistencyQuorum.requiredReplicas(2)).Should(Equal(2))
+		Ω(ConsistencyAll.requiredReplicas(3)).Should(BeZero())
+	})
+
+	ginkgo.It("should read replicas until the required number of them succeed", func() {
+		query := func(failed ...int) func(ctx context.Context, replica topology.Host) (interface{}, error) {
+			return func(ctx context.Context, replica topology.Host) (interface{}, error) {
+				for _, i := range failed {
+					if replica == hosts[i] {
+						return nil, errors.New(replica.ID() + " failed")
+					}
+				}
+				if replica != hosts[0] {
+					// the first replica responds first if it succeeds.
+					time.Sleep(10 * time.Millisecond)
+				}
+				return replica.ID(), nil
+			}
+		}
+
+		value, failures, err := readReplicas(context.Background(), replicas, 1, query())
+		Ω(err).Should(BeNil())
+		Ω(failures).Should(BeZero())
+		Ω(value).Should(Equal("host0"))
+
+		value, failures, err = readReplicas(context.Background(), replicas, 2, query(0))
+		Ω(err).Should(BeNil())
+		Ω(failures).Should(Equal(1))
+		Ω(value).ShouldNot(Equal("host0"))
+
+		_, failures, err = readReplicas(context.Background(), replicas, 2, query(0, 2))
+		Ω(failures).Should(Equal(2))
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(err.(*utils.CodedError).HostErrors).Should(HaveLen(2))
+	})
+
+	ginkgo.It("should assign shards of consistent reads to groups of replicas", func() {
+		qc := &QueryContext{consistency: ConsistencyQuorum}
+		mockMap.On("Replicas").Return(3).Once()
+		assignment, groupReplicas, required, err := assignScans(qc, &mockTopo)
+		Ω(err).Should(BeNil())
+		Ω(required).Should(Equal(2))
+		Ω(assignment).Should(HaveLen(1))
+		for key, shardIDs := range assignment {
+			Ω(key.ID()).Should(Equal("host0"))
+			Ω(shardIDs).Should(Equal([]uint32{0, 1}))
+			Ω(groupReplicas[key]).Should(Equal(replicas))
+		}
+
+		// shards without enough replicas fail the query.
+		qc.ExcludedHosts = map[string]bool{"host0": true, "host1": true}
+		mockMap.On("Replicas").Return(3).Once()
+		_, _, _, err = assignScans(qc, &mockTopo)
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeClusterDegraded))
+
+		// consistent reads require replicated shards.
+		qc.ExcludedHosts = nil
+		mockMap.On("Replicas").Return(1).Once()
+		_, _, _, err = assignScans(qc, &mockTopo)
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+	})
+
+	ginkgo.It("should merge results of each shard group once", func() {
+		mockMap.On("Replicas").Return(3)
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("Query", mock.Anything, hosts[0], mock.Anything, false).Return(nil, errors.New("host0 failed"))
+		mockDatanodeCli.On("Query", mock.Anything, hosts[1], mock.Anything, false).Return(queryCom.AQLQueryResult{"1": float64(5)}, nil)
+		mockDatanodeCli.On("Query", mock.Anything, hosts[2], mock.Anything, false).Return(queryCom.AQLQueryResult{"1": float64(5)}, nil)
+
+		qc := QueryContext{
+			AQLQuery: &queryCom.AQLQuery{
+				Table:      "table1",
+				Measures:   []queryCom.Measure{{Expr: "count(*)", ExprParsed: &expr.Call{Name: "count"}}},
+				Dimensions: []queryCom.Dimension{{Expr: "1"}},
+			},
+			consistency: ConsistencyQuorum,
+		}
+		plan, err := NewAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli)
+		Ω(err).Should(BeNil())
+		result, err := plan.Execute(context.Background())
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(queryCom.AQLQueryResult{"1": float64(5)}))
+	})
+})
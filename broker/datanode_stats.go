@@ -0,0 +1,77 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	"github.com/uber/aresdb/utils"
+)
+
+// recordScan records the latency and retries of the scan of shards assigned to the host into metadata of
+// datanodes collected by the context, if any. Trials beyond the first are retries.
+func recordScan(ctx context.Context, host topology.Host, start time.Time, trials int) {
+	metadata := dataCli.GetQueryMetadata(ctx)
+	if metadata == nil {
+		return
+	}
+	retries := trials - 1
+	if retries < 0 {
+		retries = 0
+	}
+	metadata.RecordScan(host.ID(), utils.Now().Sub(start), retries)
+}
+
+// reportDataNodeStats reports stats of datanodes queried by the query as metrics tagged by host.
+func reportDataNodeStats(metadata *dataCli.QueryMetadata) {
+	metadata.Lock()
+	defer metadata.Unlock()
+	reporter := utils.GetRootReporter()
+	for hostID, host := range metadata.Hosts {
+		tags := map[string]string{"host": hostID}
+		if host.Latency > 0 {
+			reporter.GetChildTimer(tags, utils.DataNodeScanLatency).Record(host.Latency)
+		}
+		reporter.GetChildCounter(tags, utils.DataNodeScanBytes).Inc(host.Bytes)
+		reporter.GetChildCounter(tags, utils.DataNodeScanRowsScanned).Inc(host.RowsScanned)
+		reporter.GetChildCounter(tags, utils.DataNodeScanRetries).Inc(int64(host.Retries))
+	}
+}
+
+// newQueryMeta returns the verbose metadata of v2 responses with stats of datanodes queried sorted by host id.
+func newQueryMeta(metadata *dataCli.QueryMetadata) *apiCom.QueryMetaV2 {
+	metadata.Lock()
+	defer metadata.Unlock()
+	meta := &apiCom.QueryMetaV2{DataNodes: []apiCom.DataNodeStatsV2{}}
+	for hostID, host := range metadata.Hosts {
+		meta.DataNodes = append(meta.DataNodes, apiCom.DataNodeStatsV2{
+			Host:          hostID,
+			LatencyMillis: float64(host.Latency) / float64(time.Millisecond),
+			Queries:       host.NumQueries,
+			Bytes:         host.Bytes,
+			RowsScanned:   host.RowsScanned,
+			Retries:       host.Retries,
+		})
+	}
+	sort.Slice(meta.DataNodes, func(i, j int) bool {
+		return meta.DataNodes[i].Host < meta.DataNodes[j].Host
+	})
+	return meta
+}
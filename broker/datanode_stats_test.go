@@ -0,0 +1,88 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("datanode stats", func() {
+	ginkgo.It("should record and report stats of scans of datanodes", func() {
+		mockHosts := []topology.Host{topology.NewHost("host0", "host0:9374"), topology.NewHost("host1", "host1:9374")}
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mockHosts[0], mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery) []byte {
+				time.Sleep(time.Millisecond)
+				return nil
+			}, errors.New("host0 failed"))
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mockHosts[1], mock.Anything).Return([]byte(`["a"],`), nil)
+
+		ctx, metadata := dataCli.WithQueryMetadata(context.Background())
+		sn := &StreamingScanNode{
+			host:           mockHosts[0],
+			replicas:       []topology.Host{mockHosts[1]},
+			dataNodeClient: &mockDatanodeCli,
+		}
+		_, err := sn.Execute(ctx)
+		Ω(err).Should(BeNil())
+		sn = &StreamingScanNode{host: mockHosts[1], dataNodeClient: &mockDatanodeCli}
+		_, err = sn.Execute(ctx)
+		Ω(err).Should(BeNil())
+
+		Ω(metadata.Hosts).Should(HaveLen(2))
+		Ω(metadata.Hosts["host0"].Retries).Should(Equal(1))
+		Ω(metadata.Hosts["host0"].Latency).Should(BeNumerically(">=", time.Millisecond))
+		Ω(metadata.Hosts["host1"].Retries).Should(BeZero())
+
+		metadata.Hosts["host1"].Bytes, metadata.Hosts["host1"].RowsScanned = 6, 10
+		meta := newQueryMeta(metadata)
+		Ω(meta.DataNodes).Should(HaveLen(2))
+		Ω(meta.DataNodes[0].Host).Should(Equal("host0"))
+		Ω(meta.DataNodes[0].LatencyMillis).Should(BeNumerically(">=", 1))
+		Ω(meta.DataNodes[0].Retries).Should(Equal(1))
+		Ω(meta.DataNodes[1]).Should(Equal(apiCom.DataNodeStatsV2{
+			Host:          "host1",
+			LatencyMillis: float64(metadata.Hosts["host1"].Latency) / float64(time.Millisecond),
+			Bytes:         6,
+			RowsScanned:   10,
+		}))
+
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		counter := func(name, host string) int64 {
+			if c, exist := testScope.Snapshot().Counters()["test."+name+"+component=query,host="+host]; exist {
+				return c.Value()
+			}
+			return 0
+		}
+		retries, rowsScanned := counter("datanode_scan_retries", "host0"), counter("datanode_scan_rows_scanned", "host1")
+		reportDataNodeStats(metadata)
+		Ω(testScope.Snapshot().Timers()).Should(HaveKey("test.datanode_scan_latency+component=query,host=host0"))
+		Ω(counter("datanode_scan_retries", "host0") - retries).Should(BeEquivalentTo(1))
+		Ω(counter("datanode_scan_rows_scanned", "host1") - rowsScanned).Should(BeEquivalentTo(10))
+	})
+})
@@ -0,0 +1,130 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// DebugHandler serves debug requests of broker
+type DebugHandler struct {
+	schemaVersionChecker *SchemaVersionChecker
+	tableSchemaReader    metaCom.TableSchemaReader
+	topo                 topology.Topology
+	dataNodeClient       dataCli.DataNodeQueryClient
+	queryRegistry        *queryCom.QueryRegistry
+	queryStats           *QueryStatsTracker
+	healthTracker        *HealthTracker
+}
+
+// NewDebugHandler creates a new DebugHandler
+func NewDebugHandler(schemaVersionChecker *SchemaVersionChecker, tsr metaCom.TableSchemaReader,
+	topo topology.Topology, client dataCli.DataNodeQueryClient, registry *queryCom.QueryRegistry,
+	queryStats *QueryStatsTracker, healthTracker *HealthTracker) DebugHandler {
+	return DebugHandler{
+		schemaVersionChecker: schemaVersionChecker,
+		tableSchemaReader:    tsr,
+		topo:                 topo,
+		dataNodeClient:       client,
+		queryRegistry:        registry,
+		queryStats:           queryStats,
+		healthTracker:        healthTracker,
+	}
+}
+
+// Register registers http handlers.
+func (handler *DebugHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/cache", utils.ApplyHTTPWrappers(handler.GetCache, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/shards", utils.ApplyHTTPWrappers(handler.GetShards, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/queries", utils.ApplyHTTPWrappers(handler.GetQueries, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/queries/top", utils.ApplyHTTPWrappers(handler.GetTopQueries, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/hosts/health", utils.ApplyHTTPWrappers(handler.GetHostHealth, wrappers)).Methods(http.MethodGet)
+}
+
+// GetCache shows cached schema versions of datanodes with the schema and placement versions
+// they were fetched at.
+func (handler *DebugHandler) GetCache(w http.ResponseWriter, r *http.Request) {
+	apiCom.RespondWithJSONObject(w, handler.schemaVersionChecker.GetCacheEntries())
+}
+
+// GetShards shows owning hosts and states of each shard in the topology map. If table is given, the
+// schema version of the table on each host is fetched to show replica skew.
+func (handler *DebugHandler) GetShards(w http.ResponseWriter, r *http.Request) {
+	topoMap := handler.topo.Get()
+	if topoMap == nil {
+		apiCom.RespondWithError(w, utils.APIError{
+			Code:    http.StatusServiceUnavailable,
+			Message: "topology map not available",
+		})
+		return
+	}
+
+	ownership := getShardOwnership(topoMap)
+	if table := r.URL.Query().Get("table"); table != "" {
+		var brokerVersion *metaCom.TableSchemaVersion
+		if schema, err := handler.tableSchemaReader.GetTable(table); err == nil {
+			brokerVersion = &metaCom.TableSchemaVersion{
+				Incarnation: schema.Incarnation,
+				Version:     schema.Version,
+			}
+		}
+		fetchTableStatus(r.Context(), &ownership, topoMap, handler.dataNodeClient, table, brokerVersion)
+	}
+	apiCom.RespondWithJSONObject(w, ownership)
+}
+
+// GetQueries lists running queries sorted by elapsed time, longest first unless order=asc, with
+// recently finished queries.
+func (handler *DebugHandler) GetQueries(w http.ResponseWriter, r *http.Request) {
+	apiCom.RespondWithJSONObject(w, handler.queryRegistry.List(r.URL.Query().Get("order") == "asc"))
+}
+
+// GetTopQueries lists rolling stats of query fingerprints over the sliding window, sorted descending by the
+// metric given by sort (count by default), limited to n fingerprints if given.
+func (handler *DebugHandler) GetTopQueries(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		var err error
+		if n, err = strconv.Atoi(nStr); err != nil {
+			apiCom.RespondWithBadRequest(w, utils.StackError(err, "invalid n: %s", nStr))
+			return
+		}
+	}
+	stats, err := handler.queryStats.Top(r.URL.Query().Get("sort"), n)
+	if err != nil {
+		apiCom.RespondWithBadRequest(w, err)
+		return
+	}
+	apiCom.RespondWithJSONObject(w, stats)
+}
+
+// GetHostHealth lists health of datanodes of the topology map by outcomes of their recent queries, all datanodes
+// are healthy if health is not tracked.
+func (handler *DebugHandler) GetHostHealth(w http.ResponseWriter, r *http.Request) {
+	var topoMap topology.Map
+	if handler.topo != nil {
+		topoMap = handler.topo.Get()
+	}
+	apiCom.RespondWithJSONObject(w, handler.healthTracker.Entries(topoMap))
+}
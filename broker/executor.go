@@ -18,19 +18,90 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/broker/config"
 	"github.com/uber/aresdb/cluster/topology"
 	dataCli "github.com/uber/aresdb/datanode/client"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"math"
+	"math/rand"
 	"net/http"
+	"strings"
+	"time"
 )
 
-// NewQueryExecutor creates a new QueryExecutor
-func NewQueryExecutor(tsr metaCom.TableSchemaReader, topo topology.Topology, client dataCli.DataNodeQueryClient) common.QueryExecutor {
+// SchemaRefresher refreshes schemas of broker synchronously, e.g. metastore.SchemaFetchJob.
+type SchemaRefresher interface {
+	RefreshSchema(ctx context.Context) error
+}
+
+// QueryExecutorOptions are the optional dependencies and configs of the query executor, zero values disable
+// the corresponding features.
+type QueryExecutorOptions struct {
+	// Config of the broker, the executor reads pagination, count distinct, dedup, partial results, query timeout,
+	// hedge, result cache, shard assignment, consistency, admission and schema skew configs from it.
+	Config config.BrokerConfig
+	// excludes datanodes with stale schemas from queries.
+	SchemaVersionChecker *SchemaVersionChecker
+	// validates queries before querying datanodes if not nil.
+	SchemaValidator *SchemaValidator
+	// refreshes schemas before retrying queries failed with schema mismatches, not retried if nil.
+	SchemaRefresher SchemaRefresher
+	// fails queries of datanodes failing consecutively right away if not nil.
+	Breaker *CircuitBreaker
+	// assigns shards to the healthiest replicas if not nil.
+	HealthTracker *HealthTracker
+	// caps retries of failed scans if not nil.
+	RetryBudget *RetryBudget
+	// tracks running queries.
+	Registry *queryCom.QueryRegistry
+	// records stats of queries by fingerprint if not nil.
+	QueryStats *QueryStatsTracker
+}
+
+// NewQueryExecutor creates a new QueryExecutor with the options.
+func NewQueryExecutor(tsr metaCom.TableSchemaReader, topo topology.Topology, client dataCli.DataNodeQueryClient, opts QueryExecutorOptions) common.QueryExecutor {
+	cfg := opts.Config
+	maxPageSize := cfg.Pagination.MaxPageSize
+	if maxPageSize <= 0 {
+		maxPageSize = defaultMaxPageSize
+	}
+	cursorTTLSec := cfg.Pagination.CursorTTLSec
+	if cursorTTLSec <= 0 {
+		cursorTTLSec = defaultCursorTTLSec
+	}
+	defaultConsistency, err := parseConsistencyLevel(cfg.Consistency.DefaultLevel)
+	if err != nil {
+		utils.GetLogger().With("error", err).Warn("Invalid default consistency level, reading all shards by assignment")
+		defaultConsistency = ConsistencyAll
+	}
 	return &queryExecutorImpl{
-		tableSchemaReader: tsr,
-		topo:              topo,
-		dataNodeClient:    client,
+		tableSchemaReader:    tsr,
+		topo:                 topo,
+		dataNodeClient:       client,
+		schemaVersionChecker: opts.SchemaVersionChecker,
+		schemaValidator:      opts.SchemaValidator,
+		schemaRefresher:      opts.SchemaRefresher,
+		breaker:              opts.Breaker,
+		healthTracker:        opts.HealthTracker,
+		retryBudget:          opts.RetryBudget,
+		registry:             opts.Registry,
+		queryStats:           opts.QueryStats,
+		maxPageSize:          maxPageSize,
+		cursorTTL:            time.Duration(cursorTTLSec) * time.Second,
+		maxDistinctValues:    cfg.CountDistinct.MaxValuesPerBucket,
+		maxDedupBytes:        cfg.Dedup.MaxMemoryBytes,
+		maxSortBytes:         cfg.Sort.MaxMemoryBytes,
+		allowPartialResults:  cfg.PartialResults.Enable,
+		defaultTimeout:       time.Duration(cfg.QueryTimeout.DefaultTimeoutMillis) * time.Millisecond,
+		dataNodeTimeout:      time.Duration(cfg.QueryTimeout.DataNodeTimeoutMillis) * time.Millisecond,
+		hedger:               newHedger(cfg.Hedge),
+		resultCache:          newResultCache(cfg.ResultCache),
+		spreadReplicas:       cfg.ShardAssignment.SpreadReplicas,
+		defaultConsistency:   defaultConsistency,
+		admission:            newAdmissionController(cfg.Admission),
+		schemaRetryDelay:     time.Duration(cfg.SchemaSkew.RetryDelayMillis) * time.Millisecond,
 	}
 }
 
@@ -39,24 +110,292 @@ type queryExecutorImpl struct {
 	tableSchemaReader metaCom.TableSchemaReader
 	topo              topology.Topology
 	dataNodeClient    dataCli.DataNodeQueryClient
+
+	schemaVersionChecker *SchemaVersionChecker
+	schemaValidator      *SchemaValidator
+	schemaRefresher      SchemaRefresher
+	breaker              *CircuitBreaker
+	healthTracker        *HealthTracker
+	retryBudget          *RetryBudget
+	registry             *queryCom.QueryRegistry
+	queryStats           *QueryStatsTracker
+
+	maxPageSize int
+	cursorTTL   time.Duration
+	// max number of distinct values of a bucket of countdistinct queries, 0 means the default.
+	maxDistinctValues int
+	// max bytes of hashes of rows tracked to drop duplicate rows, 0 means the default.
+	maxDedupBytes int
+	// max bytes of rows of datanodes buffered by sorted non aggregation queries, 0 means the default.
+	maxSortBytes int
+	// whether queries return partial results by default.
+	allowPartialResults bool
+	// how long queries without timeout can run, 0 means no timeout.
+	defaultTimeout time.Duration
+	// how long calls to datanodes can run, 0 means calls are only bounded by their queries.
+	dataNodeTimeout time.Duration
+	// hedges queries of slow datanodes to replicas, nil if not enabled.
+	hedger *hedger
+	// caches results of aggregation queries, nil if not enabled.
+	resultCache *resultCache
+	// whether shards of queries are spread over replicas by random seeds of shard assignments.
+	spreadReplicas bool
+	// consistency level of queries not specifying one.
+	defaultConsistency ConsistencyLevel
+	// caps queries running concurrently, nil if not enabled.
+	admission *admissionController
+	// how long scans failed with schema mismatches wait for datanodes to refresh schemas before retries.
+	schemaRetryDelay time.Duration
 }
 
 func (qe *queryExecutorImpl) Execute(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) (err error) {
-	// TODO: add timeout
+	// the deadline is set before anything else so retries and pagination are bounded by it as well.
+	var deadline time.Time
+	timeout := time.Duration(aql.TimeoutMillis) * time.Millisecond
+	if timeout <= 0 {
+		timeout = qe.defaultTimeout
+	}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	// fingerprinted before the query is mutated by pagination and compilation.
+	var statsRecord *queryStatsRecord
+	if !aql.Explain {
+		statsRecord = qe.queryStats.start(aql)
+	}
+	dataNodeMetadata := dataCli.GetQueryMetadata(ctx)
+	if dataNodeMetadata == nil {
+		ctx, dataNodeMetadata = dataCli.WithQueryMetadata(ctx)
+	}
+	tracker := &writeTracker{ResponseWriter: w}
+
+	// queries are canceled by their contexts, which are propagated to requests to datanodes.
+	runningQuery := qe.registry.Register(aql, aql.Caller)
+	ctx, cancel := context.WithCancel(ctx)
+	runningQuery.SetCancel(cancel)
+	w.Header().Set(utils.HTTPQueryIDHeaderKey, runningQuery.ID())
+	defer func() {
+		cancel()
+		if err != nil && runningQuery.Canceled() {
+			err = utils.NewCodedError(utils.ErrCodeCanceled, nil, "query %s was canceled", runningQuery.ID())
+		}
+		qe.registry.Finish(runningQuery, err)
+		// queries to datanodes not needed by the result may still be running.
+		dataNodeMetadata.Lock()
+		rowsScanned := dataNodeMetadata.RowsScanned
+		dataNodeMetadata.Unlock()
+		statsRecord.finish(rowsScanned, tracker.bytes, err)
+		reportDataNodeStats(dataNodeMetadata)
+	}()
+	ctx = queryCom.WithRunningQuery(ctx, runningQuery)
+
+	// queries wait for admission within their deadline, queries of a batch are admitted together.
+	var release func()
+	if batch, _ := getQueryBatch(ctx); batch != nil {
+		err = batch.admit(func() (func(), error) {
+			return qe.admission.admit(ctx, deadline, w)
+		})
+	} else {
+		release, err = qe.admission.admit(ctx, deadline, w)
+	}
+	if err != nil {
+		return
+	}
+	if release != nil {
+		defer release()
+	}
+
+	var cursor *queryCursor
+	if aql.PageSize > 0 {
+		cursor, err = qe.startPagination(aql)
+		if err != nil {
+			return
+		}
+	}
+
+	// the query is mutated by compilation, so the original query is kept to be compiled again for the retry.
+	retryAQL := copyAQLQuery(aql)
+	var qc *QueryContext
+	qc, err = qe.compileAndExecute(ctx, aql, tracker, cursor, deadline, nil)
+	if err == nil || tracker.written || !isSchemaMismatch(err) {
+		return
+	}
+
+	// datanodes may lag behind schemas of broker during rolling schema changes, they are already retried by
+	// scans, so the query either fails or drops columns unknown to them if requested.
+	if skew := detectSchemaSkew(qc, err, dataNodeMetadata); skew != nil {
+		err = qe.handleSchemaSkew(ctx, skew, retryAQL, tracker, cursor, deadline)
+		return
+	}
+	if qe.schemaRefresher == nil {
+		return
+	}
+
+	// datanodes may have picked up schema changes before broker, the query is retried once if broker gets
+	// newer schemas of the queried tables, otherwise it is an invalid query.
+	oldVersions := qc.tableVersions()
+	if refreshErr := qe.schemaRefresher.RefreshSchema(ctx); refreshErr != nil {
+		utils.GetContextLogger(ctx).With("error", refreshErr, "table", aql.Table).Warn("Failed to refresh schema on schema mismatch")
+		return
+	}
+	if !qe.hasNewerSchema(oldVersions) {
+		utils.GetRootReporter().GetCounter(utils.SchemaMismatchRetriesSkipped).Inc(1)
+		return
+	}
+	utils.GetRootReporter().GetCounter(utils.SchemaMismatchRetries).Inc(1)
+	utils.GetContextLogger(ctx).With("error", err, "table", aql.Table).Info("Retrying query with refreshed schema")
+	_, err = qe.compileAndExecute(ctx, retryAQL, tracker, cursor, deadline, nil)
+	return
+}
 
+// handleSchemaSkew retries the query failed by the schema skew without columns unknown to lagging datanodes
+// if the query allows dropping them, otherwise the query fails with the lagging datanodes.
+func (qe *queryExecutorImpl) handleSchemaSkew(ctx context.Context, skew *schemaSkew, aql *queryCom.AQLQuery, w http.ResponseWriter, cursor *queryCursor, deadline time.Time) error {
+	// pages of paginated queries must keep their columns.
+	if !aql.DropSkewedColumns || cursor != nil {
+		return skew.error()
+	}
+	degraded, ok := skew.dropColumns(aql)
+	if !ok {
+		return skew.error()
+	}
+	utils.GetRootReporter().GetCounter(utils.SchemaSkewDegradedQueries).Inc(1)
+	utils.GetContextLogger(ctx).With("error", skew.cause, "table", aql.Table, "columns", skew.columns).Info("Retrying query without columns unknown to lagging datanodes")
+	_, err := qe.compileAndExecute(ctx, degraded, w, cursor, deadline, []string{skew.warning()})
+	return err
+}
+
+// compileAndExecute compiles the query against schemas of broker and executes it before the deadline, with
+// the warnings returned in the response. The query context is returned if compiled.
+func (qe *queryExecutorImpl) compileAndExecute(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter, cursor *queryCursor, deadline time.Time, warnings []string) (qc *QueryContext, err error) {
 	// compile
-	qc := NewQueryContext(aql, w)
+	qc = NewQueryContext(aql, w)
+	qc.Warnings = warnings
+	qc.schemaRetryDelay = qe.schemaRetryDelay
+	qc.breaker = qe.breaker
+	qc.healthTracker = qe.healthTracker
+	qc.retryBudget = qe.retryBudget
+	qc.maxDistinctValues = qe.maxDistinctValues
+	qc.maxDedupBytes = qe.maxDedupBytes
+	qc.maxSortBytes = qe.maxSortBytes
+	qc.allowPartialResults = qe.allowPartialResults
+	qc.deadline = deadline
+	qc.dataNodeTimeout = qe.dataNodeTimeout
+	qc.hedger = qe.hedger
+	if qe.spreadReplicas {
+		qc.assignmentSeed = 1 + rand.Int63n(math.MaxInt64)
+	}
 	qc.Compile(qe.tableSchemaReader)
 	if qc.Error != nil {
-		err = qc.Error
+		err = utils.WithCode(utils.ErrCodeInvalidQuery, qc.Error)
+		return
+	}
+	if err = qe.schemaValidator.Validate(qc); err != nil {
+		return
+	}
+	qc.consistency = qe.defaultConsistency
+	if aql.Consistency != "" {
+		if qc.consistency, err = parseConsistencyLevel(aql.Consistency); err != nil {
+			err = utils.WithCode(utils.ErrCodeInvalidQuery, err)
+			return
+		}
+	}
+	// progressive results are streamed as they are merged, cached results are in json.
+	if cursor == nil && !qc.IsNonAggregationQuery && aql.ProgressiveInterval == 0 && !aql.HLLBinary {
+		qc.resultCacheKey = qe.resultCache.prepare(aql)
+	}
+	qe.schemaVersionChecker.Check(ctx, qc)
+	if aql.Explain {
+		err = qe.explain(qc, w, cursor)
 		return
 	}
 
 	// execute
-	if qc.IsNonAggregationQuery {
-		return qe.executeNonAggQuery(ctx, qc, w)
+	if cursor != nil || qc.IsNonAggregationQuery {
+		// non aggregation queries of batches are not batched.
+		batch, index := getQueryBatch(ctx)
+		batch.ready(index)
+	}
+	if cursor != nil {
+		err = qe.executePaginatedNonAggQuery(ctx, qc, w, cursor)
+	} else if qc.IsNonAggregationQuery {
+		err = qe.executeNonAggQuery(ctx, qc, w)
+	} else {
+		err = qe.executeAggQuery(ctx, qc, w)
+	}
+	return
+}
+
+// hasNewerSchema tells whether schema of any of the tables is newer than the versions.
+func (qe *queryExecutorImpl) hasNewerSchema(versions map[string]metaCom.TableSchemaVersion) bool {
+	for name, version := range versions {
+		table, err := qe.tableSchemaReader.GetTable(name)
+		if err != nil {
+			// table deleted or recreated.
+			return true
+		}
+		newVersion := metaCom.TableSchemaVersion{Incarnation: table.Incarnation, Version: table.Version}
+		if version.IsBehind(newVersion, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSchemaMismatch tells whether the query failed on any datanode since the query references tables or
+// columns unknown to the datanode.
+func isSchemaMismatch(err error) bool {
+	codedErr, ok := err.(*utils.CodedError)
+	if !ok {
+		return false
+	}
+	if codedErr.Code == utils.ErrCodeSchemaMismatch {
+		return true
+	}
+	for _, hostErr := range codedErr.HostErrors {
+		if hostErr.Code == utils.ErrCodeSchemaMismatch {
+			return true
+		}
+	}
+	return false
+}
+
+// copyAQLQuery copies the query with fields mutated by compilation.
+func copyAQLQuery(aql *queryCom.AQLQuery) *queryCom.AQLQuery {
+	aqlCopy := *aql
+	aqlCopy.Dimensions = append([]queryCom.Dimension(nil), aql.Dimensions...)
+	aqlCopy.Measures = append([]queryCom.Measure(nil), aql.Measures...)
+	return &aqlCopy
+}
+
+// writeTracker tracks whether anything is written to the response, the query can only be retried if not.
+// Bytes written are counted for query stats.
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+	bytes   int64
+}
+
+// Write writes the data to the response.
+func (t *writeTracker) Write(data []byte) (int, error) {
+	t.written = true
+	n, err := t.ResponseWriter.Write(data)
+	t.bytes += int64(n)
+	return n, err
+}
+
+// WriteHeader writes the status code to the response.
+func (t *writeTracker) WriteHeader(statusCode int) {
+	t.written = true
+	t.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush flushes data written to the client if supported by the response.
+func (t *writeTracker) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
-	return qe.executeAggQuery(ctx, qc, w)
 }
 
 func (qe *queryExecutorImpl) executeNonAggQuery(ctx context.Context, qc *QueryContext, w http.ResponseWriter) (err error) {
@@ -65,22 +404,128 @@ func (qe *queryExecutorImpl) executeNonAggQuery(ctx context.Context, qc *QueryCo
 	if err != nil {
 		return
 	}
+	writeWarnings(qc, w)
+	if err = plan.Execute(ctx); err != nil {
+		return
+	}
+	// rows are flushed while executing, so warnings of failed datanodes only reach buffered responses (e.g.
+	// v2 responses), errors of failed datanodes are written after rows for flushed responses.
+	collectPartialErrors(ctx, qc)
+	writeWarnings(qc, w)
+	return
+}
+
+// startPagination returns the cursor of the page requested by the paginated query, either decoded from
+// the request or created for the first page. Now of the query is frozen by the cursor.
+func (qe *queryExecutorImpl) startPagination(aql *queryCom.AQLQuery) (cursor *queryCursor, err error) {
+	if aql.PageSize > qe.maxPageSize {
+		err = utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil, "pageSize %d exceeds max page size %d", aql.PageSize, qe.maxPageSize))
+		return
+	}
+
+	var fingerprint string
+	fingerprint, err = queryFingerprint(aql)
+	if err != nil {
+		return
+	}
+	if aql.Cursor == "" {
+		cursor = &queryCursor{Fingerprint: fingerprint, Now: aql.Now}
+		if cursor.Now == 0 {
+			cursor.Now = utils.Now().Unix()
+		}
+	} else {
+		cursor, err = decodeQueryCursor(aql.Cursor, fingerprint)
+		if err != nil {
+			return
+		}
+	}
+	aql.Now = cursor.Now
+	cursor.ExpiresAt = utils.Now().Add(qe.cursorTTL).Unix()
+	return
+}
+
+func (qe *queryExecutorImpl) executePaginatedNonAggQuery(ctx context.Context, qc *QueryContext, w http.ResponseWriter, cursor *queryCursor) (err error) {
+	var plan PaginatedNonAggQueryPlan
+	plan, err = NewPaginatedNonAggQueryPlan(qc, qe.topo, qe.dataNodeClient, w, cursor)
+	if err != nil {
+		return
+	}
+	writeWarnings(qc, w)
 	return plan.Execute(ctx)
 }
 
 func (qe *queryExecutorImpl) executeAggQuery(ctx context.Context, qc *QueryContext, w http.ResponseWriter) (err error) {
+	if cached, metadata := qe.resultCache.get(qc.resultCacheKey); cached != nil {
+		// datanodes are not queried, the metadata of the cached result is reported instead.
+		metadata.restore(ctx)
+		writeWarnings(qc, w)
+		queryCom.GetRunningQuery(ctx).SetPhase(queryCom.QueryPhaseStreaming)
+		_, err = w.Write(cached)
+		return
+	}
+
+	if qc.AQLQuery.ProgressiveInterval > 0 {
+		qc.progress = newProgressiveResults(w, qc.AQLQuery.ProgressiveInterval)
+	}
+	// queries to datanodes are shared with other queries of the batch if any.
+	batch, index := getQueryBatch(ctx)
 	var plan AggQueryPlan
-	plan, err = NewAggQueryPlan(qc, qe.topo, qe.dataNodeClient)
+	plan, err = NewAggQueryPlan(qc, qe.topo, batch.client(qe.dataNodeClient))
 	if err != nil {
 		return
 	}
+	batch.expect(index, &plan)
 	var result queryCom.AQLQueryResult
 	result, err = plan.Execute(ctx)
 	if err != nil {
+		if qc.progress != nil {
+			qc.progress.fail(err)
+		}
 		return
 	}
+	collectPartialErrors(ctx, qc)
+	if qc.progress != nil {
+		// headers are written with the first record, warnings are returned in the complete record instead.
+		queryCom.GetRunningQuery(ctx).SetPhase(queryCom.QueryPhaseStreaming)
+		return qc.progress.complete(result, qc.Warnings)
+	}
+	writeWarnings(qc, w)
+	collectTimeBuckets(ctx, qc.AQLQuery, result)
+	if qc.AQLQuery.HLLBinary {
+		queryCom.GetRunningQuery(ctx).SetPhase(queryCom.QueryPhaseStreaming)
+		return writeHLLBinary(w, result)
+	}
+	if qc.HLLSketch != nil {
+		result, err = exportHLLSketches(result, qc.HLLSketch)
+		if err != nil {
+			return
+		}
+	}
+	queryCom.GetRunningQuery(ctx).SetPhase(queryCom.QueryPhaseStreaming)
 	var bs []byte
 	bs, err = json.Marshal(result)
+	// partial results are not cached.
+	if err == nil && len(qc.Warnings) == 0 {
+		qe.resultCache.put(qc.resultCacheKey, bs, getResultMetadata(ctx))
+	}
 	w.Write([]byte(bs))
 	return
 }
+
+// writeHLLBinary writes the merged hll sketches in the binary format of datanode responses.
+func writeHLLBinary(w http.ResponseWriter, result queryCom.AQLQueryResult) error {
+	bs, err := queryCom.SerializeHLLQueryResults([]queryCom.AQLQueryResult{result}, nil)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeHyperLogLog)
+	w.Write(bs)
+	return nil
+}
+
+// writeWarnings writes warnings of partial results to response header, must be called before writing body.
+func writeWarnings(qc *QueryContext, w http.ResponseWriter) {
+	if len(qc.Warnings) > 0 {
+		w.Header().Set(utils.HTTPQueryWarningHeaderKey, strings.Join(qc.Warnings, "; "))
+	}
+}
@@ -0,0 +1,308 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker/config"
+	shardMock "github.com/uber/aresdb/cluster/shard/mocks"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// funcSchemaRefresher is a SchemaRefresher calling the function.
+type funcSchemaRefresher func(ctx context.Context) error
+
+func (f funcSchemaRefresher) RefreshSchema(ctx context.Context) error {
+	return f(ctx)
+}
+
+var _ = ginkgo.Describe("query executor", func() {
+	table := metaCom.Table{
+		Name:        "trips",
+		IsFactTable: true,
+		Columns: []metaCom.Column{
+			{Name: "request_at", Type: metaCom.Uint32},
+			{Name: "city_id", Type: metaCom.Uint32},
+		},
+		Version: 1,
+	}
+	schemaMismatchErr := utils.NewCodedError(utils.ErrCodeSchemaMismatch, nil, "unknown column fare for table alias trips")
+
+	var schemaMutator *BrokerSchemaMutator
+	var mockTopo topoMock.Topology
+	var mockDatanodeCli dataCliMock.DataNodeQueryClient
+	var numRefreshes int
+
+	newExecutor := func(refresh func() error) *queryExecutorImpl {
+		refresher := funcSchemaRefresher(func(ctx context.Context) error {
+			numRefreshes++
+			return refresh()
+		})
+		return NewQueryExecutor(schemaMutator, &mockTopo, &mockDatanodeCli, QueryExecutorOptions{
+			SchemaVersionChecker: NewSchemaVersionChecker(config.SchemaVersionCheckConfig{}, &mockTopo, &mockDatanodeCli),
+			SchemaRefresher:      refresher,
+			Registry:             queryCom.NewQueryRegistry(10),
+		}).(*queryExecutorImpl)
+	}
+
+	updateSchema := func() error {
+		newTable := table
+		newTable.Columns = append(append([]metaCom.Column{}, table.Columns...), metaCom.Column{Name: "fare", Type: metaCom.Float32})
+		newTable.Version = table.Version + 1
+		return schemaMutator.UpdateTable(newTable)
+	}
+
+	ginkgo.BeforeEach(func() {
+		numRefreshes = 0
+		schemaMutator = NewBrokerSchemaMutator()
+		tableCopy := table
+		schemaMutator.CreateTable(&tableCopy)
+
+		mockTopo = topoMock.Topology{}
+		mockMap := &topoMock.Map{}
+		mockShardSet := &shardMock.ShardSet{}
+		mockHost := topology.NewHost("host1", "host1:9374")
+		mockTopo.On("Get").Return(mockMap)
+		mockMap.On("ShardSet").Return(mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0})
+		mockMap.On("Hosts").Return([]topology.Host{mockHost})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost}, nil)
+		mockDatanodeCli = dataCliMock.DataNodeQueryClient{}
+	})
+
+	ginkgo.It("should retry once with refreshed schema on schema mismatches", func() {
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, false).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) queryCom.AQLQueryResult {
+				if numRefreshes == 0 {
+					return nil
+				}
+				return queryCom.AQLQueryResult{"NULL": 10.0}
+			},
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) error {
+				if numRefreshes == 0 {
+					return schemaMismatchErr
+				}
+				return nil
+			})
+
+		w := httptest.NewRecorder()
+		err := newExecutor(updateSchema).Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "sum(fare)"}},
+		}, w)
+		Ω(err).Should(BeNil())
+		Ω(numRefreshes).Should(Equal(1))
+		Ω(w.Body.String()).Should(MatchJSON(`{"NULL": 10}`))
+	})
+
+	ginkgo.It("should not retry without newer schema", func() {
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, false).Return(nil, schemaMismatchErr)
+
+		w := httptest.NewRecorder()
+		err := newExecutor(func() error { return nil }).Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "sum(fare)"}},
+		}, w)
+		Ω(isSchemaMismatch(err)).Should(BeTrue())
+		Ω(numRefreshes).Should(Equal(1))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", rpcRetries)
+		Ω(w.Body.Len()).Should(Equal(0))
+
+		// retried at most once.
+		err = newExecutor(updateSchema).Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "sum(fare)"}},
+		}, w)
+		Ω(isSchemaMismatch(err)).Should(BeTrue())
+		Ω(numRefreshes).Should(Equal(2))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", 3*rpcRetries)
+	})
+
+	ginkgo.It("should serve identical aggregation queries from the result cache", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		defer utils.ResetClockImplementation()
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, false).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) queryCom.AQLQueryResult {
+				return queryCom.AQLQueryResult{"NULL": float64(query.Now)}
+			}, nil)
+		exec := newExecutor(updateSchema)
+		exec.resultCache = newResultCache(config.ResultCacheConfig{Enable: true, TTLSec: 60, BucketSec: 60})
+		query := func(from string, filters ...string) string {
+			w := httptest.NewRecorder()
+			err := exec.Execute(context.TODO(), &queryCom.AQLQuery{
+				Table:      "trips",
+				Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+				Filters:    filters,
+				TimeFilter: queryCom.TimeFilter{Column: "request_at", From: from, To: "now"},
+			}, w)
+			Ω(err).Should(BeNil())
+			return w.Body.String()
+		}
+
+		// now is normalized to the start of the bucket.
+		Ω(query("-1h", "city_id = 1", "city_id < 3")).Should(MatchJSON(`{"NULL": 960}`))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", 1)
+		utils.SetCurrentTime(time.Unix(1010, 0))
+		Ω(query("-1h", "city_id < 3", "city_id = 1")).Should(MatchJSON(`{"NULL": 960}`))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", 1)
+
+		// queries of the next bucket are not served by results of the previous bucket.
+		utils.SetCurrentTime(time.Unix(1030, 0))
+		Ω(query("-1h", "city_id = 1", "city_id < 3")).Should(MatchJSON(`{"NULL": 1020}`))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", 2)
+
+		// time filters finer than buckets bypass the cache without normalizing now.
+		Ω(query("-30s")).Should(MatchJSON(`{"NULL": 0}`))
+		Ω(query("-30s")).Should(MatchJSON(`{"NULL": 0}`))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", 4)
+	})
+
+	ginkgo.It("should report metadata of cached results in v2 responses", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		defer utils.ResetClockImplementation()
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, false).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) queryCom.AQLQueryResult {
+				dataCli.GetQueryMetadata(ctx).RecordDataFreshness(900)
+				return queryCom.AQLQueryResult{"1970-01-01 00:00": map[string]interface{}{"NULL": 1.0}}
+			}, nil)
+		exec := newExecutor(updateSchema)
+		exec.resultCache = newResultCache(config.ResultCacheConfig{Enable: true, TTLSec: 60, BucketSec: 60})
+		handler := NewQueryHandler(exec, config.CompressionConfig{})
+		query := func() apiCom.QueryResponseV2 {
+			r := httptest.NewRequest(http.MethodPost, "/v2/query/aql?bucketCompleteness=1", bytes.NewBufferString(
+				`{"query": {"table": "trips", "measures": [{"sqlExpression": "count(*)"}], `+
+					`"dimensions": [{"sqlExpression": "request_at", "timeBucketizer": "hour"}], `+
+					`"timeFilter": {"column": "request_at", "from": "-1h", "to": "now"}}}`))
+			w := httptest.NewRecorder()
+			handler.HandleAQLV2(w, r)
+			var response apiCom.QueryResponseV2
+			Ω(json.Unmarshal(w.Body.Bytes(), &response)).Should(BeNil())
+			Ω(response.Error).Should(BeNil())
+			return response
+		}
+
+		for i := 0; i < 2; i++ {
+			response := query()
+			Ω(response.Results).Should(HaveLen(1))
+			Ω(response.Metadata.DataFreshness).Should(Equal(int64(900)))
+			Ω(response.Metadata.BucketCompleteness).Should(Equal(map[string]bool{"1970-01-01 00:00": false}))
+		}
+		// the second query is served from the cache.
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "Query", 1)
+	})
+
+	ginkgo.It("should respond merged hll sketches in the binary format if requested", func() {
+		data, err := ioutil.ReadFile("../testing/data/query/hll_query_results")
+		Ω(err).Should(BeNil())
+		results, _, err := queryCom.ParseHLLQueryResults(data)
+		Ω(err).Should(BeNil())
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, true).Return(results[0], nil)
+		exec := newExecutor(updateSchema)
+		exec.resultCache = newResultCache(config.ResultCacheConfig{Enable: true, TTLSec: 60, BucketSec: 60})
+		query := func(hllBinary bool) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			Ω(exec.Execute(context.TODO(), &queryCom.AQLQuery{
+				Table:      "trips",
+				Measures:   []queryCom.Measure{{Expr: "hll(city_id)"}},
+				Dimensions: []queryCom.Dimension{{Expr: "city_id"}, {Expr: "city_id"}, {Expr: "city_id"}},
+				HLLBinary:  hllBinary,
+			}, w)).Should(BeNil())
+			return w
+		}
+
+		// json results cached are not served to hll binary queries.
+		Ω(query(false).Header().Get(utils.HTTPContentTypeHeaderKey)).ShouldNot(Equal(utils.HTTPContentTypeHyperLogLog))
+		w := query(true)
+		Ω(w.Header().Get(utils.HTTPContentTypeHeaderKey)).Should(Equal(utils.HTTPContentTypeHyperLogLog))
+		merged, errs, err := queryCom.ParseHLLQueryResults(w.Body.Bytes())
+		Ω(err).Should(BeNil())
+		Ω(errs).Should(Equal([]error{nil}))
+		Ω(merged).Should(HaveLen(1))
+		Ω(queryCom.ComputeHLLResult(merged[0])).Should(Equal(queryCom.ComputeHLLResult(results[0])))
+
+		w = httptest.NewRecorder()
+		err = exec.Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:     "trips",
+			Measures:  []queryCom.Measure{{Expr: "count(*)"}},
+			HLLBinary: true,
+		}, w)
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(err.Error()).Should(ContainSubstring("only supported by hll aggregation"))
+	})
+
+	ginkgo.It("should not refresh schema on other errors", func() {
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, false).Return(nil,
+			utils.NewCodedError(utils.ErrCodeResourceExhausted, nil, "no device"))
+
+		err := newExecutor(updateSchema).Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+		}, httptest.NewRecorder())
+		Ω(err).ShouldNot(BeNil())
+		Ω(numRefreshes).Should(Equal(0))
+
+		err = newExecutor(func() error { return errors.New("controller unavailable") }).Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:    "unknown",
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+		}, httptest.NewRecorder())
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(numRefreshes).Should(Equal(0))
+	})
+
+	ginkgo.It("should retry non aggregation queries before rows are written", func() {
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery) []byte {
+				if numRefreshes == 0 {
+					return nil
+				}
+				return []byte(`[1, 2.5]`)
+			},
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery) error {
+				if numRefreshes == 0 {
+					return schemaMismatchErr
+				}
+				return nil
+			})
+
+		w := httptest.NewRecorder()
+		err := newExecutor(updateSchema).Execute(context.TODO(), &queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "city_id"}, {Expr: "fare"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+		}, w)
+		Ω(err).Should(BeNil())
+		Ω(numRefreshes).Should(Equal(1))
+		Ω(w.Body.String()).Should(MatchJSON(`{"headers": ["city_id", "fare"], "matrixData": [[1, 2.5]]}`))
+	})
+})
@@ -0,0 +1,225 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/cluster/topology"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	explainedPlanAgg         = "aggregation"
+	explainedPlanNonAgg      = "nonAggregation"
+	explainedPlanPaginated   = "paginatedNonAggregation"
+	explainedNodeMerge       = "merge"
+	explainedNodeVariance    = "varianceMoments"
+	explainedNodeDistinct    = "distinctValues"
+	explainedNodeTDigest     = "tdigest"
+	explainedNodeMeasures    = "measures"
+	explainedNodeScan        = "scan"
+	explainedNodeStreamMerge = "streamMerge"
+	explainedNodeStreamScan  = "streamingScan"
+	explainedNodePage        = "page"
+	explainedNodeUnknown     = "unknown"
+)
+
+// QueryExplanation is the plan of a query built by broker without executing it.
+type QueryExplanation struct {
+	// aggregation, nonAggregation or paginatedNonAggregation.
+	Plan string `json:"plan"`
+	// consistency level of reads of shards.
+	Consistency ConsistencyLevel `json:"consistency"`
+	// warnings of the plan, e.g. hosts excluded for stale schemas or shards left unassigned.
+	Warnings []string            `json:"warnings,omitempty"`
+	Root     PlanNodeExplanation `json:"root"`
+}
+
+// PlanNodeExplanation is a node of the explained plan, with the queries sent to datanodes by scan nodes.
+type PlanNodeExplanation struct {
+	Node string `json:"node"`
+	// aggregations merged by merge nodes, one per measure.
+	AggTypes []string `json:"aggTypes,omitempty"`
+	// host the shards of scan nodes are assigned to, with replicas they fail over or fan out to.
+	Host     string   `json:"host,omitempty"`
+	Shards   []int    `json:"shards,omitempty"`
+	Replicas []string `json:"replicas,omitempty"`
+	// number of replicas required to respond by consistent reads, 0 for reads of assigned hosts.
+	Required int `json:"required,omitempty"`
+	// whether failures of the node are tolerated by partial results.
+	Partial bool `json:"partial,omitempty"`
+	// query sent to the datanode by scan nodes.
+	Query    *queryCom.AQLQuery    `json:"query,omitempty"`
+	Children []PlanNodeExplanation `json:"children,omitempty"`
+}
+
+// explain builds the plan of the compiled query like executing it and writes the explained plan instead of
+// executing it.
+func (qe *queryExecutorImpl) explain(qc *QueryContext, w http.ResponseWriter, cursor *queryCursor) (err error) {
+	var explanation QueryExplanation
+	if cursor != nil {
+		var plan PaginatedNonAggQueryPlan
+		if plan, err = NewPaginatedNonAggQueryPlan(qc, qe.topo, qe.dataNodeClient, w, cursor); err != nil {
+			return
+		}
+		explanation = explainPaginatedNonAggPlan(qc, &plan)
+	} else if qc.IsNonAggregationQuery {
+		var plan NonAggQueryPlan
+		if plan, err = NewNonAggQueryPlan(qc, qe.topo, qe.dataNodeClient, w); err != nil {
+			return
+		}
+		explanation = explainNonAggPlan(qc, &plan)
+	} else {
+		var plan AggQueryPlan
+		if plan, err = NewAggQueryPlan(qc, qe.topo, qe.dataNodeClient); err != nil {
+			return
+		}
+		explanation = explainAggPlan(qc, &plan)
+	}
+	var bs []byte
+	if bs, err = json.Marshal(explanation); err != nil {
+		return
+	}
+	w.Header().Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+	_, err = w.Write(bs)
+	return
+}
+
+// explainAggPlan explains the plan of an aggregation query.
+func explainAggPlan(qc *QueryContext, plan *AggQueryPlan) QueryExplanation {
+	return QueryExplanation{
+		Plan:        explainedPlanAgg,
+		Consistency: qc.consistency,
+		Warnings:    qc.Warnings,
+		Root:        explainBlockingNode(plan.root),
+	}
+}
+
+// explainNonAggPlan explains the plan of a non aggregation query.
+func explainNonAggPlan(qc *QueryContext, plan *NonAggQueryPlan) QueryExplanation {
+	root := PlanNodeExplanation{
+		Node:    explainedNodeStreamMerge,
+		Partial: plan.partial != nil,
+	}
+	for _, node := range plan.nodes {
+		root.Children = append(root.Children, explainStreamingScanNode(node))
+	}
+	sortExplainedNodes(root.Children)
+	return QueryExplanation{
+		Plan:        explainedPlanNonAgg,
+		Consistency: qc.consistency,
+		Warnings:    qc.Warnings,
+		Root:        root,
+	}
+}
+
+// explainPaginatedNonAggPlan explains the plan of a page of a paginated non aggregation query, datanodes are
+// scanned in the order of children.
+func explainPaginatedNonAggPlan(qc *QueryContext, plan *PaginatedNonAggQueryPlan) QueryExplanation {
+	root := PlanNodeExplanation{Node: explainedNodePage}
+	for _, node := range plan.nodes {
+		root.Children = append(root.Children, explainStreamingScanNode(node))
+	}
+	return QueryExplanation{
+		Plan:        explainedPlanPaginated,
+		Consistency: ConsistencyAll,
+		Warnings:    qc.Warnings,
+		Root:        root,
+	}
+}
+
+// explainBlockingNode explains the node of aggregation plans and its children.
+func explainBlockingNode(node common.BlockingPlanNode) PlanNodeExplanation {
+	var explained PlanNodeExplanation
+	// children of merge nodes are sorted by hosts except for avg, whose children are its sum and count.
+	sortChildren := false
+	switch n := node.(type) {
+	case *BlockingScanNode:
+		explained = explainScan(explainedNodeScan, n.query, n.host, n.replicas, n.required)
+	case *mergeNodeImpl:
+		explained.Node = explainedNodeMerge
+		for _, aggType := range n.aggTypes {
+			explained.AggTypes = append(explained.AggTypes, aggType.String())
+		}
+		explained.Partial = n.partial != nil
+		sortChildren = n.aggType != common.Avg
+	case *varianceMomentsNode:
+		explained.Node = explainedNodeVariance
+	case *distinctValuesNode:
+		explained.Node = explainedNodeDistinct
+	case *tdigestNode:
+		explained.Node = explainedNodeTDigest
+	case *measuresNode:
+		explained.Node = explainedNodeMeasures
+	default:
+		explained.Node = explainedNodeUnknown
+	}
+	for _, child := range node.Children() {
+		explained.Children = append(explained.Children, explainBlockingNode(child))
+	}
+	if sortChildren {
+		sortExplainedNodes(explained.Children)
+	}
+	return explained
+}
+
+// explainStreamingScanNode explains the scan node of non aggregation plans.
+func explainStreamingScanNode(node *StreamingScanNode) PlanNodeExplanation {
+	return explainScan(explainedNodeStreamScan, node.query, node.host, node.replicas, node.required)
+}
+
+// explainScan explains the scan of the shards of the host by the query.
+func explainScan(nodeType string, query queryCom.AQLQuery, host topology.Host, replicas []topology.Host, required int) PlanNodeExplanation {
+	explained := PlanNodeExplanation{
+		Node:     nodeType,
+		Shards:   query.Shards,
+		Required: required,
+		Query:    &query,
+	}
+	// hosts of consistent reads are only the keys of shards of the replicas.
+	if required == 0 {
+		explained.Host = host.ID()
+	}
+	for _, replica := range replicas {
+		explained.Replicas = append(explained.Replicas, replica.ID())
+	}
+	return explained
+}
+
+// sortExplainedNodes sorts nodes by the first host or replica of their scans, since plans are built from
+// shard assignments in random order.
+func sortExplainedNodes(nodes []PlanNodeExplanation) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return explainedNodeKey(nodes[i]) < explainedNodeKey(nodes[j])
+	})
+}
+
+func explainedNodeKey(node PlanNodeExplanation) string {
+	if node.Host != "" {
+		return node.Host
+	}
+	if len(node.Replicas) > 0 {
+		return node.Replicas[0]
+	}
+	if len(node.Children) > 0 {
+		return explainedNodeKey(node.Children[0])
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/utils"
+)
+
+// replicaHosts returns hosts other than the host owning all of the shards in the order of the topology,
+// excluded hosts skipped.
+func replicaHosts(topo topology.Topology, host topology.Host, shardIDs []uint32, excludedHosts map[string]bool) ([]topology.Host, error) {
+	m := topo.Get()
+	var replicas []topology.Host
+	for i, shardID := range shardIDs {
+		shardHosts, err := m.RouteShard(shardID)
+		if err != nil {
+			return nil, utils.StackError(err, "failed to route shard %d", shardID)
+		}
+		if i == 0 {
+			for _, shardHost := range shardHosts {
+				if shardHost != host && (len(excludedHosts) == 0 || !excludedHosts[shardHost.ID()]) {
+					replicas = append(replicas, shardHost)
+				}
+			}
+			continue
+		}
+		owned := replicas[:0]
+		for _, replica := range replicas {
+			for _, shardHost := range shardHosts {
+				if shardHost == replica {
+					owned = append(owned, replica)
+					break
+				}
+			}
+		}
+		replicas = owned
+	}
+	return replicas, nil
+}
+
+// assignedReplicas returns replicas of hosts of the shard assignment, which scans of the hosts fail over and
+// are hedged to.
+func assignedReplicas(qc *QueryContext, topo topology.Topology, assignment map[topology.Host][]uint32) (map[topology.Host][]topology.Host, error) {
+	replicas := make(map[topology.Host][]topology.Host, len(assignment))
+	for host, shardIDs := range assignment {
+		if len(shardIDs) == 0 {
+			continue
+		}
+		hostReplicas, err := replicaHosts(topo, host, shardIDs, qc.ExcludedHosts)
+		if err != nil {
+			return nil, utils.WithCode(utils.ErrCodeClusterDegraded, err)
+		}
+		replicas[host] = hostReplicas
+	}
+	return replicas, nil
+}
+
+// setScanReplicas sets replicas of hosts of scan nodes of the plan and the number of replicas required to
+// respond, with the hedger, the schema retry delay, the circuit breaker, the retry budget and the datanode
+// timeout of the query.
+func setScanReplicas(node common.BlockingPlanNode, replicas map[topology.Host][]topology.Host, required int, qc *QueryContext) {
+	if scanNode, ok := node.(*BlockingScanNode); ok {
+		scanNode.replicas, scanNode.required, scanNode.hedger = replicas[scanNode.host], required, qc.hedger
+		scanNode.schemaRetryDelay, scanNode.breaker, scanNode.retryBudget = qc.schemaRetryDelay, qc.breaker, qc.retryBudget
+		scanNode.callTimeout = qc.dataNodeTimeout
+		return
+	}
+	for _, child := range node.Children() {
+		setScanReplicas(child, replicas, required, qc)
+	}
+}
+
+// trialHosts returns candidate hosts of a trial of a scan, trials rotate through the host and its replicas so
+// that failed trials are retried on the next replica. The first host is queried, and the rest are hedged to.
+func trialHosts(host topology.Host, replicas []topology.Host, trial int) []topology.Host {
+	candidates := append([]topology.Host{host}, replicas...)
+	n := trial % len(candidates)
+	hosts := make([]topology.Host, 0, len(candidates))
+	hosts = append(hosts, candidates[n:]...)
+	return append(hosts, candidates[:n]...)
+}
+
+// reportScanFailures reports failed trials of a scan, tagged by whether the scan succeeded on retries.
+func reportScanFailures(failures int, succeeded bool) {
+	if failures == 0 {
+		return
+	}
+	failover := "failed"
+	if succeeded {
+		failover = "succeeded"
+	}
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"failover": failover,
+	}, utils.DataNodeQueryFailures).Inc(int64(failures))
+}
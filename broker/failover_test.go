@@ -0,0 +1,144 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	shardMock "github.com/uber/aresdb/cluster/shard/mocks"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("failover", func() {
+	var mockTopo topoMock.Topology
+	var hosts []topology.Host
+
+	ginkgo.BeforeEach(func() {
+		// host0: 0,1
+		// host1: 0,1,2
+		// host2: 1,2
+		mockTopo = topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1, 2})
+		// hosts are not mocked since mocks record calls of hosts queried concurrently.
+		hosts = nil
+		for i := 0; i < 3; i++ {
+			hosts = append(hosts, topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i)))
+		}
+		mockMap.On("Hosts").Return([]topology.Host{hosts[0], hosts[1], hosts[2]})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{hosts[0], hosts[1]}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{hosts[0], hosts[1], hosts[2]}, nil)
+		mockMap.On("RouteShard", uint32(2)).Return([]topology.Host{hosts[1], hosts[2]}, nil)
+	})
+
+	failures := func(failover string) int64 {
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		key := "test.datanode_query_failures+component=query,failover=" + failover
+		if c, exist := testScope.Snapshot().Counters()[key]; exist {
+			return c.Value()
+		}
+		return 0
+	}
+
+	ginkgo.It("should find replicas owning all shards of hosts", func() {
+		replicas, err := replicaHosts(&mockTopo, hosts[0], []uint32{0, 1}, nil)
+		Ω(err).Should(BeNil())
+		Ω(replicas).Should(Equal([]topology.Host{hosts[1]}))
+		replicas, err = replicaHosts(&mockTopo, hosts[2], []uint32{1, 2}, nil)
+		Ω(err).Should(BeNil())
+		Ω(replicas).Should(Equal([]topology.Host{hosts[1]}))
+		replicas, err = replicaHosts(&mockTopo, hosts[1], []uint32{0, 1, 2}, nil)
+		Ω(err).Should(BeNil())
+		Ω(replicas).Should(BeEmpty())
+		replicas, err = replicaHosts(&mockTopo, hosts[1], []uint32{1}, map[string]bool{"host0": true})
+		Ω(err).Should(BeNil())
+		Ω(replicas).Should(Equal([]topology.Host{hosts[2]}))
+	})
+
+	ginkgo.It("should rotate trials through hosts and their replicas", func() {
+		replicas := []topology.Host{hosts[1], hosts[2]}
+		Ω(trialHosts(hosts[0], replicas, 0)).Should(Equal([]topology.Host{hosts[0], hosts[1], hosts[2]}))
+		Ω(trialHosts(hosts[0], replicas, 1)).Should(Equal([]topology.Host{hosts[1], hosts[2], hosts[0]}))
+		Ω(trialHosts(hosts[0], replicas, 3)).Should(Equal([]topology.Host{hosts[0], hosts[1], hosts[2]}))
+		// trials without replicas stay on the host.
+		Ω(trialHosts(hosts[0], nil, 1)).Should(Equal([]topology.Host{hosts[0]}))
+	})
+
+	ginkgo.It("should retry scans of failed datanodes on replicas", func() {
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, hosts[0], mock.Anything).Return(nil, errors.New("host0 failed"))
+		mockDatanodeCli.On("QueryRaw", mock.Anything, hosts[1], mock.Anything).Return([]byte(`["1"],`), nil)
+		mockDatanodeCli.On("QueryRaw", mock.Anything, hosts[2], mock.Anything).Return(nil, errors.New("host2 failed"))
+
+		succeeded, failed := failures("succeeded"), failures("failed")
+		node := &StreamingScanNode{
+			query:          queryCom.AQLQuery{Table: "table1"},
+			host:           hosts[0],
+			dataNodeClient: &mockDatanodeCli,
+			replicas:       []topology.Host{hosts[1]},
+		}
+		bs, err := node.Execute(context.Background())
+		Ω(err).Should(BeNil())
+		Ω(string(bs)).Should(Equal(`["1"],`))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "QueryRaw", 2)
+		Ω(failures("succeeded")).Should(Equal(succeeded + 1))
+
+		// errors are reported for the host the shards are assigned to.
+		node.replicas = []topology.Host{hosts[2]}
+		_, err = node.Execute(context.Background())
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.(*utils.CodedError).HostErrors).Should(Equal([]utils.HostError{{
+			Host:    "host0",
+			Code:    utils.ErrCodeInternal,
+			Message: "host2 failed",
+		}}))
+		Ω(failures("failed")).Should(Equal(failed + int64(rpcRetries)))
+
+		// retried on the same host without replicas.
+		mockDatanodeCli = dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("Query", mock.Anything, hosts[0], mock.Anything, false).
+			Return(nil, errors.New("host0 failed")).Once()
+		mockDatanodeCli.On("Query", mock.Anything, hosts[0], mock.Anything, false).
+			Return(queryCom.AQLQueryResult{"1": float64(2)}, nil).Once()
+		aggNode := &BlockingScanNode{
+			query: queryCom.AQLQuery{
+				Table:    "table1",
+				Measures: []queryCom.Measure{{Expr: "count(*)"}},
+			},
+			host:           hosts[0],
+			dataNodeClient: &mockDatanodeCli,
+		}
+		aggNode.query.Measures[0].ExprParsed, _ = expr.ParseExpr("count(*)")
+		result, err := aggNode.Execute(context.Background())
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(queryCom.AQLQueryResult{"1": float64(2)}))
+		Ω(failures("succeeded")).Should(Equal(succeeded + 2))
+	})
+})
@@ -15,23 +15,38 @@
 package broker
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/mux"
 	apiCom "github.com/uber/aresdb/api/common"
 	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/broker/config"
+	dataCli "github.com/uber/aresdb/datanode/client"
 	queryCom "github.com/uber/aresdb/query/common"
+	queryProto "github.com/uber/aresdb/query/generated/proto"
 	"github.com/uber/aresdb/query/sql"
 	"github.com/uber/aresdb/utils"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type QueryHandler struct {
-	exec common.QueryExecutor
+	exec        common.QueryExecutor
+	compression config.CompressionConfig
 }
 
-func NewQueryHandler(executor common.QueryExecutor) QueryHandler {
+// NewQueryHandler creates a new QueryHandler, responses of requests accepting gzip are compressed by
+// compressionCfg.
+func NewQueryHandler(executor common.QueryExecutor, compressionCfg config.CompressionConfig) QueryHandler {
 	return QueryHandler{
-		exec: executor,
+		exec:        executor,
+		compression: compressionCfg,
 	}
 }
 
@@ -40,88 +55,564 @@ func (handler *QueryHandler) Register(router *mux.Router, wrappers ...utils.HTTP
 	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.HandleAQL, wrappers)).Methods(http.MethodPost)
 }
 
+// RegisterV2 registers http handlers of the v2 query api.
+func (handler *QueryHandler) RegisterV2(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/sql", utils.ApplyHTTPWrappers(handler.HandleSQLV2, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.HandleAQLV2, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/aql/batch", utils.ApplyHTTPWrappers(handler.HandleAQLBatchV2, wrappers)).Methods(http.MethodPost)
+}
+
 func (handler *QueryHandler) HandleSQL(w http.ResponseWriter, r *http.Request) {
 	utils.GetRootReporter().GetCounter(utils.SQLQueryReceivedBroker).Inc(1)
 	var queryReqeust BrokerSQLRequest
+	handler.handleQuery(w, r, &queryReqeust)
+}
 
-	start := utils.Now()
+func (handler *QueryHandler) HandleAQL(w http.ResponseWriter, r *http.Request) {
+	utils.GetRootReporter().GetCounter(utils.AQLQueryReceivedBroker).Inc(1)
+	var queryReqeust BrokerAQLRequest
+	handler.handleQuery(w, r, &queryReqeust)
+}
+
+// HandleSQLV2 handles sql queries with v2 responses.
+func (handler *QueryHandler) HandleSQLV2(w http.ResponseWriter, r *http.Request) {
+	utils.GetRootReporter().GetCounter(utils.SQLQueryReceivedBroker).Inc(1)
+	var queryReqeust BrokerSQLRequest
+	handler.handleQueryV2(w, r, &queryReqeust)
+}
+
+// HandleAQLV2 handles aql queries with v2 responses.
+func (handler *QueryHandler) HandleAQLV2(w http.ResponseWriter, r *http.Request) {
+	utils.GetRootReporter().GetCounter(utils.AQLQueryReceivedBroker).Inc(1)
+	var queryReqeust BrokerAQLRequest
+	handler.handleQueryV2(w, r, &queryReqeust)
+}
+
+// handleQuery handles the query with v1 responses, results are flushed to the connection while executing,
+// or buffered to be converted for protobuf responses. The request id is returned in the header.
+func (handler *QueryHandler) handleQuery(w http.ResponseWriter, r *http.Request, queryReqeust brokerQueryRequest) {
+	w, finish := compressResponse(w, r, handler.compression)
+	defer finish()
+	requestID := utils.GetRequestID(r)
+	w.Header().Set(utils.HTTPRequestIDHeaderKey, requestID)
+	ctx, span := startRequestSpan(r.Context(), r, requestID)
 	var err error
 	defer func() {
-		duration := utils.Now().Sub(start)
-		utils.GetRootReporter().GetTimer(utils.QueryLatencyBroker).Record(duration)
-		if err != nil {
-			utils.GetRootReporter().GetCounter(utils.QueryFailedBroker).Inc(1)
-			utils.GetLogger().With(
-				"error", err,
-				"request", queryReqeust).Error("Error happened when processing request")
+		finishSpan(span, err)
+	}()
+	if apiCom.AcceptsProtobuf(r) {
+		err = handler.handleQueryProto(ctx, w, r, queryReqeust)
+		return
+	}
+	// errors of queries failed after results are streamed are written after the results by query plans.
+	tracker := &writeTracker{ResponseWriter: w}
+	if err = handler.execute(ctx, tracker, r, queryReqeust, true); err != nil && !tracker.written {
+		if acceptsHLL(r) {
+			respondHLLError(w, err)
 		} else {
-			utils.GetRootReporter().GetCounter(utils.QuerySucceededBroker).Inc(1)
-			utils.GetLogger().With("request", queryReqeust).Info("Request succeeded")
+			respondV1Error(w, err)
 		}
-	}()
+	}
+}
+
+// acceptsHLL tells whether the request asks for hll binary responses, i.e. the format of datanode responses
+// to hll queries, so that clients of datanodes can query broker as they are.
+func acceptsHLL(r *http.Request) bool {
+	return r.Header.Get(utils.HTTPAcceptTypeHeaderKey) == utils.HTTPContentTypeHyperLogLog
+}
 
-	err = apiCom.ReadRequest(r, &queryReqeust)
+// respondHLLError responds the error as the failed query of the hll binary response, with the http status of
+// the error code as datanodes do.
+func respondHLLError(w http.ResponseWriter, err error) {
+	statusCode := utils.GetErrorCodeInfo(utils.GetErrorCode(err)).HTTPStatus
+	if codedErr, ok := err.(*utils.CodedError); ok && codedErr.Message == "" {
+		err = codedErr.Cause
+	}
+	bs, _ := queryCom.SerializeHLLQueryResults([]queryCom.AQLQueryResult{nil}, []error{err})
+	w.Header().Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeHyperLogLog)
+	apiCom.RespondBytesWithCode(w, statusCode, bs)
+}
+
+// handleQueryProto handles the query with v1 protobuf responses, errors are still responded in json.
+func (handler *QueryHandler) handleQueryProto(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	queryReqeust brokerQueryRequest) (err error) {
+	buffer := newResponseBuffer()
+	err = handler.execute(ctx, buffer, r, queryReqeust, false)
+	copyResponseHeaders(w, buffer)
 	if err != nil {
-		apiCom.RespondWithError(w, err)
+		respondV1Error(w, err)
 		return
 	}
 
-	sqlParseStart := utils.Now()
-	var aql *queryCom.AQLQuery
-	aql, err = sql.Parse(queryReqeust.Body.Query, utils.GetLogger())
-	utils.GetRootReporter().GetTimer(utils.SQLParsingLatencyBroker).Record(utils.Now().Sub(sqlParseStart))
+	var result queryCom.AQLQueryResult
+	if err = json.Unmarshal(buffer.Bytes(), &result); err != nil {
+		respondV1Error(w, utils.StackError(err, "failed to decode query result"))
+		return
+	}
+	resultProto, err := queryCom.AQLQueryResultToProto(result)
 	if err != nil {
-		apiCom.RespondWithError(w, err)
+		respondV1Error(w, err)
 		return
 	}
+	if warnings := buffer.Header().Get(utils.HTTPQueryWarningHeaderKey); warnings != "" {
+		w.Header().Set(utils.HTTPQueryWarningHeaderKey, warnings)
+	}
+	apiCom.RespondProtoWithCode(w, http.StatusOK, resultProto)
+	return
+}
 
-	err = handler.exec.Execute(context.TODO(), aql, w)
+// respondV1Error responds the error without error codes, which are not part of v1 responses.
+func respondV1Error(w http.ResponseWriter, err error) {
+	if codedErr, ok := err.(*utils.CodedError); ok && codedErr.Message == "" {
+		err = codedErr.Cause
+	}
+	apiCom.RespondWithError(w, err)
+}
+
+// copyResponseHeaders copies the query id and the Retry-After header of rejected queries from the buffered
+// response.
+func copyResponseHeaders(w http.ResponseWriter, buffer *responseBuffer) {
+	for _, key := range []string{utils.HTTPQueryIDHeaderKey, utils.HTTPRetryAfterHeaderKey} {
+		if value := buffer.Header().Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+}
+
+// handleQueryV2 handles the query with v2 responses, results are buffered to be wrapped in the response
+// envelope, in either json or protobuf as accepted by the request.
+func (handler *QueryHandler) handleQueryV2(w http.ResponseWriter, r *http.Request, queryReqeust brokerQueryRequest) {
+	w, finish := compressResponse(w, r, handler.compression)
+	defer finish()
+	respond := apiCom.RespondV2
+	if apiCom.AcceptsProtobuf(r) {
+		respond = apiCom.RespondV2Proto
+	}
+
+	start := utils.Now()
+	metadata := apiCom.NewQueryMetadataV2(r)
+	ctx, span := startRequestSpan(r.Context(), r, metadata.RequestID)
+	ctx, dataNodeMetadata := dataCli.WithQueryMetadata(ctx)
+	ctx, buckets := withTimeBuckets(ctx)
+	ctx, partialErrors := withPartialErrors(ctx)
+	buffer := newResponseBuffer()
+
+	err := handler.execute(ctx, buffer, r, queryReqeust, false)
+	finishSpan(span, err)
+
+	// queries to datanodes not needed by the result may still be running.
+	dataNodeMetadata.Lock()
+	metadata.UpdateDataFreshness(dataNodeMetadata.DataFreshness)
+	metadata.Stats.DataNodeQueries = dataNodeMetadata.NumQueries
+	metadata.Stats.RowsScanned = dataNodeMetadata.RowsScanned
+	dataNodeMetadata.Unlock()
+	metadata.SetLatency(start)
+	var meta *apiCom.QueryMetaV2
+	if queryReqeust.verbose() {
+		meta = newQueryMeta(dataNodeMetadata)
+		meta.QueryID = buffer.Header().Get(utils.HTTPQueryIDHeaderKey)
+	}
+	copyResponseHeaders(w, buffer)
 	if err != nil {
-		apiCom.RespondWithError(w, err)
+		respond(w, apiCom.QueryResponseV2{
+			Error:    apiCom.NewQueryErrorV2(err),
+			Metadata: metadata,
+			Meta:     meta,
+		})
 		return
 	}
-	return
+	if warnings := buffer.Header().Get(utils.HTTPQueryWarningHeaderKey); warnings != "" {
+		metadata.Partial = true
+		metadata.Warnings = strings.Split(warnings, "; ")
+	}
+	metadata.BucketCompleteness = buckets.completeness(metadata.DataFreshness)
+	metadata.Errors = partialErrors.errors
+	respond(w, apiCom.QueryResponseV2{
+		Results:  []interface{}{json.RawMessage(buffer.Bytes())},
+		Metadata: metadata,
+		Meta:     meta,
+	})
 }
 
-func (handler *QueryHandler) HandleAQL(w http.ResponseWriter, r *http.Request) {
-	var queryReqeust BrokerAQLRequest
-	utils.GetRootReporter().GetCounter(utils.AQLQueryReceivedBroker).Inc(1)
+// HandleAQLBatchV2 handles batches of aql queries, each query is planned on its own while queries to the same
+// datanode share a single request. Results are returned by query index in the v2 envelope, failed queries
+// are reported by query index in errors instead of failing the batch.
+func (handler *QueryHandler) HandleAQLBatchV2(w http.ResponseWriter, r *http.Request) {
+	w, finish := compressResponse(w, r, handler.compression)
+	defer finish()
 
 	start := utils.Now()
+	metadata := apiCom.NewQueryMetadataV2(r)
+	ctx, span := startRequestSpan(r.Context(), r, metadata.RequestID)
+	// failed queries are tagged in spans of their plans, the batch is tagged if it failed as a whole.
 	var err error
 	defer func() {
-		duration := utils.Now().Sub(start)
-		utils.GetRootReporter().GetTimer(utils.QueryLatencyBroker).Record(duration)
-		if err != nil {
-			utils.GetRootReporter().GetCounter(utils.QueryFailedBroker).Inc(1)
-			utils.GetLogger().With(
-				"error", err,
-				"request", queryReqeust).Error("Error happened when processing request")
-		} else {
-			utils.GetRootReporter().GetCounter(utils.QuerySucceededBroker).Inc(1)
-			utils.GetLogger().With("request", queryReqeust).Info("Request succeeded")
+		finishSpan(span, err)
+	}()
+	var batchRequest BrokerAQLBatchRequest
+	err = apiCom.ReadRequest(r, &batchRequest)
+	if err == nil && len(batchRequest.Body.Queries) == 0 {
+		err = utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "queries are required")
+	}
+	queries := batchRequest.Body.Queries
+	for i := 0; err == nil && i < len(queries); i++ {
+		err = applyQueryParams(&queries[i], r, &batchRequest)
+	}
+	if err != nil {
+		reportRequest(ctx, &batchRequest, start, err)
+		apiCom.RespondWithV2Error(w, metadata, err)
+		return
+	}
+
+	ctx, batchMetadata := dataCli.WithQueryMetadata(ctx)
+	batch := newQueryBatch(ctx, len(queries))
+	results := make([]interface{}, len(queries))
+	errs := make([]*apiCom.QueryErrorV2, len(queries))
+	warnings := make([]string, len(queries))
+	dataNodeMetadata := make([]*dataCli.QueryMetadata, len(queries))
+	var wg sync.WaitGroup
+	for i := range queries {
+		var queryCtx context.Context
+		queryCtx, dataNodeMetadata[i] = dataCli.WithQueryMetadata(withQueryBatch(ctx, batch, i))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			queryStart := utils.Now()
+			buffer := newResponseBuffer()
+			queryErr := handler.exec.Execute(queryCtx, &queries[i], buffer)
+			batch.done(i)
+			reportRequest(queryCtx, &queries[i], queryStart, queryErr)
+			if queryErr != nil {
+				errs[i] = apiCom.NewQueryErrorV2(queryErr)
+				return
+			}
+			results[i] = json.RawMessage(buffer.Bytes())
+			warnings[i] = buffer.Header().Get(utils.HTTPQueryWarningHeaderKey)
+		}(i)
+	}
+	wg.Wait()
+
+	// queries to datanodes not needed by results may still be running.
+	for _, queryMetadata := range append(dataNodeMetadata, batchMetadata) {
+		queryMetadata.Lock()
+		metadata.UpdateDataFreshness(queryMetadata.DataFreshness)
+		metadata.Stats.DataNodeQueries += queryMetadata.NumQueries
+		metadata.Stats.RowsScanned += queryMetadata.RowsScanned
+		queryMetadata.Unlock()
+	}
+	for i, warning := range warnings {
+		if warning == "" {
+			continue
+		}
+		metadata.Partial = true
+		for _, w := range strings.Split(warning, "; ") {
+			metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("query %d: %s", i, w))
+		}
+	}
+	metadata.SetLatency(start)
+	response := apiCom.QueryResponseV2{
+		Results:  results,
+		Metadata: metadata,
+	}
+	for _, queryErr := range errs {
+		if queryErr != nil {
+			response.Errors = errs
+			break
 		}
+	}
+	apiCom.RespondV2(w, response)
+}
+
+// execute reads the request and executes its query, results are flushed to w. Progressive results are only
+// streamed by v1 json responses, other responses buffer results to wrap or convert them.
+func (handler *QueryHandler) execute(ctx context.Context, w http.ResponseWriter, r *http.Request, queryReqeust brokerQueryRequest,
+	streaming bool) (err error) {
+	start := utils.Now()
+	defer func() {
+		reportRequest(ctx, queryReqeust, start, err)
 	}()
 
-	err = apiCom.ReadRequest(r, &queryReqeust)
+	err = apiCom.ReadRequest(r, queryReqeust)
 	if err != nil {
-		apiCom.RespondWithError(w, err)
 		return
 	}
 
-	err = handler.exec.Execute(context.TODO(), &queryReqeust.Body.Query, w)
+	var aql *queryCom.AQLQuery
+	aql, err = queryReqeust.aqlQuery()
+	if err != nil {
+		return
+	}
+
+	aql.PageSize, aql.Cursor = queryReqeust.pagination()
+	aql.BucketCompleteness = queryReqeust.bucketCompleteness()
+	aql.Explain = queryReqeust.explain()
+	aql.ProgressiveInterval, err = queryReqeust.progressive()
+	if err != nil {
+		return
+	}
+	if aql.ProgressiveInterval > 0 && !streaming {
+		err = utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "progressive results are only supported by v1 json responses")
+		return
+	}
+	aql.HLLBinary = acceptsHLL(r)
+	if aql.HLLBinary && !streaming {
+		err = utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "hll binary responses are only supported by v1 responses")
+		return
+	}
+	err = applyQueryParams(aql, r, queryReqeust)
 	if err != nil {
-		apiCom.RespondWithError(w, err)
 		return
 	}
+	return handler.exec.Execute(ctx, aql, w)
+}
+
+// applyQueryParams sets the caller and parameters of the request into the query.
+func applyQueryParams(aql *queryCom.AQLQuery, r *http.Request, params queryParams) (err error) {
+	aql.Caller, aql.CallerRoles = utils.GetOrigin(r), utils.GetCallerRoles(r)
+	aql.FillTimeBuckets = params.fillTimeBuckets()
+	aql.DedupRows = params.dedupRows()
+	aql.DropSkewedColumns = params.dropSkewedColumns()
+	aql.PartialResults, err = params.partialResults()
+	if err != nil {
+		return
+	}
+	aql.TimeoutMillis, err = params.timeout()
+	if err != nil {
+		return
+	}
+	aql.Consistency = params.consistency()
 	return
 }
 
+// reportRequest reports latency and the outcome of the request started at start.
+func reportRequest(ctx context.Context, request interface{}, start time.Time, err error) {
+	duration := utils.Now().Sub(start)
+	utils.GetRootReporter().GetTimer(utils.QueryLatencyBroker).Record(duration)
+	if err != nil {
+		utils.GetRootReporter().GetCounter(utils.QueryFailedBroker).Inc(1)
+		utils.GetContextLogger(ctx).With(
+			"error", err,
+			"request", request).Error("Error happened when processing request")
+	} else {
+		utils.GetRootReporter().GetCounter(utils.QuerySucceededBroker).Inc(1)
+		utils.GetContextLogger(ctx).With("request", request).Info("Request succeeded")
+	}
+}
+
+// queryParams are the parameters of requests applied to each of their queries.
+type queryParams interface {
+	// fillTimeBuckets tells whether missing time buckets of aggregation results are filled.
+	fillTimeBuckets() bool
+	// dedupRows tells whether duplicate rows of non aggregation results across datanodes are dropped.
+	dedupRows() bool
+	// dropSkewedColumns tells whether columns unknown to datanodes lagging behind schemas of broker are
+	// dropped from the query instead of failing it.
+	dropSkewedColumns() bool
+	// partialResults tells whether results of datanodes succeeded are returned when other datanodes fail,
+	// nil for the default of broker.
+	partialResults() (*bool, error)
+	// timeout returns the milliseconds the query can run, 0 for the default of broker.
+	timeout() (int, error)
+	// consistency returns the consistency level of reads of shards, empty for the default of broker.
+	consistency() string
+}
+
+// brokerQueryRequest is the request of a query in either sql or aql.
+type brokerQueryRequest interface {
+	// aqlQuery returns the query of the request in aql.
+	aqlQuery() (*queryCom.AQLQuery, error)
+	// pagination returns the page size and the cursor of paginated non aggregation queries.
+	pagination() (pageSize int, cursor string)
+	// bucketCompleteness tells whether completeness of time buckets is requested in the response metadata.
+	bucketCompleteness() bool
+	queryParams
+	// explain tells whether the plan of the query is returned instead of executing it.
+	explain() bool
+	// progressive returns the number of results of datanodes merged between partial results of aggregation
+	// queries streamed as newline delimited json, 0 for results returned once fully merged.
+	progressive() (int, error)
+	// verbose tells whether stats of datanodes are requested in the verbose metadata of v2 responses.
+	verbose() bool
+}
+
+// PaginationParams are the parameters of paginated non aggregation queries. The first page is requested
+// with pageSize only, following pages are requested with the same query and the cursor of the previous
+// page, until a page is returned without cursor.
+type PaginationParams struct {
+	// in: query
+	PageSize int `query:"pageSize,optional" json:"pageSize,omitempty"`
+	// in: query
+	Cursor string `query:"cursor,optional" json:"cursor,omitempty"`
+}
+
+func (params *PaginationParams) pagination() (int, string) {
+	return params.PageSize, params.Cursor
+}
+
+// MetadataParams are the parameters of optional metadata of v2 responses. BucketCompleteness reports
+// whether each top level time bucket of aggregation queries grouped by time buckets first is complete.
+type MetadataParams struct {
+	// in: query
+	BucketCompleteness int `query:"bucketCompleteness,optional" json:"bucketCompleteness,omitempty"`
+}
+
+func (params *MetadataParams) bucketCompleteness() bool {
+	return params.BucketCompleteness != 0
+}
+
+// ResultParams are the parameters of shaping results. FillTimeBuckets fills missing top level time buckets
+// of aggregation queries grouped by time buckets first, with 0 for count and sum and null for others.
+// DedupRows drops rows of non aggregation queries duplicated across datanodes. PartialResults (true or false)
+// overrides whether results of datanodes succeeded are returned when other datanodes fail. DropSkewedColumns
+// drops dimensions and measures of columns unknown to datanodes lagging behind schemas of broker during
+// rolling schema changes, with a warning, instead of failing the query.
+type ResultParams struct {
+	// in: query
+	FillTimeBuckets int `query:"fillTimeBuckets,optional" json:"fillTimeBuckets,omitempty"`
+	// in: query
+	DedupRows int `query:"dedupRows,optional" json:"dedupRows,omitempty"`
+	// in: query
+	PartialResults string `query:"partialResults,optional" json:"partialResults,omitempty"`
+	// in: query
+	DropSkewedColumns int `query:"dropSkewedColumns,optional" json:"dropSkewedColumns,omitempty"`
+}
+
+func (params *ResultParams) fillTimeBuckets() bool {
+	return params.FillTimeBuckets != 0
+}
+
+func (params *ResultParams) dedupRows() bool {
+	return params.DedupRows != 0
+}
+
+func (params *ResultParams) dropSkewedColumns() bool {
+	return params.DropSkewedColumns != 0
+}
+
+func (params *ResultParams) partialResults() (*bool, error) {
+	if params.PartialResults == "" {
+		return nil, nil
+	}
+	allow, err := strconv.ParseBool(params.PartialResults)
+	if err != nil {
+		return nil, utils.WithCode(utils.ErrCodeInvalidQuery,
+			utils.StackError(nil, "invalid partialResults %s, expects true or false", params.PartialResults))
+	}
+	return &allow, nil
+}
+
+// TimeoutParams are the parameters of bounding how long a query runs. Timeout is in milliseconds, the query
+// fails with the datanodes not responded once it is exceeded.
+type TimeoutParams struct {
+	// in: query
+	Timeout int `query:"timeout,optional" json:"timeout,omitempty"`
+}
+
+func (params *TimeoutParams) timeout() (int, error) {
+	if params.Timeout < 0 {
+		return 0, utils.WithCode(utils.ErrCodeInvalidQuery,
+			utils.StackError(nil, "invalid timeout %d, expects non negative milliseconds", params.Timeout))
+	}
+	return params.Timeout, nil
+}
+
+// ConsistencyParams are the parameters of how many replicas of each shard answer the query. Consistency is
+// one of one, quorum or all. All reads each shard from its assigned replica with failover, quorum and one
+// read each shard from all of its replicas and return once a majority or one of them responded, which
+// requires replicated shards. Pages of paginated queries are always read from assigned replicas.
+type ConsistencyParams struct {
+	// in: query
+	Consistency string `query:"consistency,optional" json:"consistency,omitempty"`
+}
+
+func (params *ConsistencyParams) consistency() string {
+	return params.Consistency
+}
+
+// ExplainParams are the parameters of explaining queries. Explain returns the plan broker builds for the
+// query instead of executing it, with hosts, shards and queries of scans of datanodes.
+type ExplainParams struct {
+	// in: query
+	Explain int `query:"explain,optional" json:"explain,omitempty"`
+}
+
+func (params *ExplainParams) explain() bool {
+	return params.Explain != 0
+}
+
+// ProgressiveParams are the parameters of streaming partial results of long running aggregation queries of v1
+// json responses. Progressive streams a newline delimited json record of the result merged so far every
+// Progressive results of datanodes merged with complete false, followed by the record of the fully merged
+// result with complete true, which is the same as the result of the query not streamed. Queries failed after
+// records are streamed end with the record of the error. Only sum, count, min and max are supported.
+type ProgressiveParams struct {
+	// in: query
+	Progressive int `query:"progressive,optional" json:"progressive,omitempty"`
+}
+
+func (params *ProgressiveParams) progressive() (int, error) {
+	if params.Progressive < 0 {
+		return 0, utils.WithCode(utils.ErrCodeInvalidQuery,
+			utils.StackError(nil, "invalid progressive %d, expects positive number of results", params.Progressive))
+	}
+	return params.Progressive, nil
+}
+
+func (queryReqeust *BrokerSQLRequest) verbose() bool {
+	return queryReqeust.Verbose != 0
+}
+
+func (queryReqeust *BrokerAQLRequest) verbose() bool {
+	return queryReqeust.Verbose != 0
+}
+
+func (queryReqeust *BrokerSQLRequest) aqlQuery() (aql *queryCom.AQLQuery, err error) {
+	sqlParseStart := utils.Now()
+	aql, err = sql.Parse(queryReqeust.Body.Query, utils.GetLogger())
+	utils.GetRootReporter().GetTimer(utils.SQLParsingLatencyBroker).Record(utils.Now().Sub(sqlParseStart))
+	if err != nil {
+		err = utils.WithCode(utils.ErrCodeInvalidQuery, err)
+	}
+	return
+}
+
+func (queryReqeust *BrokerAQLRequest) aqlQuery() (*queryCom.AQLQuery, error) {
+	return &queryReqeust.Body.Query, nil
+}
+
+// responseBuffer is a http.ResponseWriter buffering the response written by query executors.
+type responseBuffer struct {
+	bytes.Buffer
+	header http.Header
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{
+		header: http.Header{},
+	}
+}
+
+// Header returns the header of the response.
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader ignores status codes, which are decided by errors returned by executors.
+func (b *responseBuffer) WriteHeader(statusCode int) {
+}
+
 // BrokerSQLRequest represents SQL query request. Debug mode will
 // run **each batch** in synchronized mode and report time
 // for each step.
 // swagger:parameters querySQL
 type BrokerSQLRequest struct {
+	PaginationParams
+	MetadataParams
+	ResultParams
+	TimeoutParams
+	ConsistencyParams
+	ExplainParams
+	ProgressiveParams
 	// in: query
 	Verbose int `query:"verbose,optional" json:"verbose"`
 	// in: query
@@ -131,9 +622,26 @@ type BrokerSQLRequest struct {
 	// in: header
 	Origin string `header:"Rpc-Caller,optional" json:"origin"`
 	// in: body
-	Body struct {
-		Query string `json:"query"`
-	} `body:""`
+	Body BrokerSQLRequestBody `body:""`
+}
+
+// BrokerSQLRequestBody is the body of SQL query requests, in json or as the sql field of the
+// protobuf AQLRequest.
+type BrokerSQLRequestBody struct {
+	Query string `json:"query"`
+}
+
+// UnmarshalProto unmarshals the body from the protobuf AQLRequest.
+func (body *BrokerSQLRequestBody) UnmarshalProto(data []byte) error {
+	var request queryProto.AQLRequest
+	if err := proto.Unmarshal(data, &request); err != nil {
+		return err
+	}
+	if request.Query == nil || request.Query.Sql == "" {
+		return utils.StackError(nil, "query.sql is required")
+	}
+	body.Query = request.Query.Sql
+	return nil
 }
 
 // BrokerAQLRequest represents AQL query request. Debug mode will
@@ -141,6 +649,13 @@ type BrokerSQLRequest struct {
 // for each step.
 // swagger:parameters querySQL
 type BrokerAQLRequest struct {
+	PaginationParams
+	MetadataParams
+	ResultParams
+	TimeoutParams
+	ConsistencyParams
+	ExplainParams
+	ProgressiveParams
 	// in: query
 	Verbose int `query:"verbose,optional" json:"verbose"`
 	// in: query
@@ -150,7 +665,45 @@ type BrokerAQLRequest struct {
 	// in: header
 	Origin string `header:"Rpc-Caller,optional" json:"origin"`
 	// in: body
-	Body struct {
-		Query queryCom.AQLQuery `json:"query"`
-	} `body:""`
+	Body BrokerAQLRequestBody `body:""`
+}
+
+// BrokerAQLBatchRequest represents requests of batches of AQL queries, parameters apply to all queries of
+// the batch.
+// swagger:parameters queryAQLBatch
+type BrokerAQLBatchRequest struct {
+	ResultParams
+	TimeoutParams
+	ConsistencyParams
+	// in: header
+	Origin string `header:"Rpc-Caller,optional" json:"origin"`
+	// in: body
+	Body BrokerAQLBatchRequestBody `body:""`
+}
+
+// BrokerAQLBatchRequestBody is the body of AQL batch requests in json.
+type BrokerAQLBatchRequestBody struct {
+	Queries []queryCom.AQLQuery `json:"queries"`
+}
+
+// BrokerAQLRequestBody is the body of AQL query requests, in json or protobuf.
+type BrokerAQLRequestBody struct {
+	Query queryCom.AQLQuery `json:"query"`
+}
+
+// UnmarshalProto unmarshals the body from the protobuf AQLRequest.
+func (body *BrokerAQLRequestBody) UnmarshalProto(data []byte) error {
+	var request queryProto.AQLRequest
+	if err := proto.Unmarshal(data, &request); err != nil {
+		return err
+	}
+	if request.Query == nil {
+		return utils.StackError(nil, "query is required")
+	}
+	query, err := queryCom.AQLQueryFromProto(request.Query)
+	if err != nil {
+		return err
+	}
+	body.Query = *query
+	return nil
 }
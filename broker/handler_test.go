@@ -0,0 +1,329 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	queryProto "github.com/uber/aresdb/query/generated/proto"
+	"github.com/uber/aresdb/utils"
+)
+
+// funcQueryExecutor executes queries with the function.
+type funcQueryExecutor func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error
+
+func (f funcQueryExecutor) Execute(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+	return f(ctx, aql, w)
+}
+
+var _ = ginkgo.Describe("query handler", func() {
+	aqlBody := `{"query": {"table": "trips", "measures": [{"sqlExpression": "count(*)"}]}}`
+
+	query := func(handle http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		r.Header.Set(utils.HTTPRequestIDHeaderKey, "request1")
+		w := httptest.NewRecorder()
+		handle(w, r)
+		return w
+	}
+
+	parse := func(w *httptest.ResponseRecorder) apiCom.QueryResponseV2 {
+		var response apiCom.QueryResponseV2
+		Ω(json.Unmarshal(w.Body.Bytes(), &response)).Should(BeNil())
+		return response
+	}
+
+	ginkgo.It("HandleAQLV2 should wrap results in the envelope", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.Table).Should(Equal("trips"))
+			Ω(utils.RequestIDFromContext(ctx)).Should(Equal("request1"))
+			w.Header().Set(utils.HTTPQueryWarningHeaderKey, "partial results without shards [1]")
+			w.Write([]byte(`{"foo": 1}`))
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQLV2, "/v2/query/aql", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Header().Get(utils.HTTPRequestIDHeaderKey)).Should(Equal("request1"))
+
+		response := parse(w)
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Results).Should(Equal([]interface{}{map[string]interface{}{"foo": float64(1)}}))
+		Ω(response.Metadata.RequestID).Should(Equal("request1"))
+		Ω(response.Metadata.Partial).Should(BeTrue())
+		Ω(response.Metadata.Warnings).Should(Equal([]string{"partial results without shards [1]"}))
+	})
+
+	ginkgo.It("HandleAQLV2 should respond structured errors", func() {
+		hostErrors := []utils.HostError{{Host: "host1", Code: utils.ErrCodeUnavailable, Message: "timeout", Retriable: true}}
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			w.Write([]byte(`{"headers":[],"matrixData":[`))
+			err := utils.WithCode(utils.ErrCodeDataNodeFailure, utils.StackError(nil, "1 errors happened executing merge node"))
+			err.HostErrors = hostErrors
+			return err
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQLV2, "/v2/query/aql", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusBadGateway))
+
+		response := parse(w)
+		Ω(response.Results).Should(BeNil())
+		Ω(*response.Error).Should(Equal(apiCom.QueryErrorV2{
+			Code:      utils.ErrCodeDataNodeFailure,
+			Message:   "1 errors happened executing merge node",
+			Retriable: true,
+			Hosts:     hostErrors,
+		}))
+
+		w = query(handler.HandleAQLV2, "/v2/query/aql", "{")
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(parse(w).Error.Code).Should(Equal(utils.ErrCodeBadRequest))
+	})
+
+	ginkgo.It("HandleSQLV2 should respond invalid queries", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleSQLV2, "/v2/query/sql", `{"query": "select from"}`)
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		response := parse(w)
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Error.Retriable).Should(BeFalse())
+	})
+
+	ginkgo.It("HandleAQLBatchV2 should respond results and errors by query index", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.TimeoutMillis).Should(Equal(100))
+			if aql.Table == "unknown" {
+				return utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "unknown table")
+			}
+			if aql.Table == "partial" {
+				w.Header().Set(utils.HTTPQueryWarningHeaderKey, "partial results without shards [1]")
+			}
+			w.Write([]byte(`{"table": "` + aql.Table + `"}`))
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQLBatchV2, "/v2/query/aql/batch?timeout=100",
+			`{"queries": [{"table": "trips"}, {"table": "unknown"}, {"table": "partial"}]}`)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		response := parse(w)
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Results).Should(Equal([]interface{}{
+			map[string]interface{}{"table": "trips"},
+			nil,
+			map[string]interface{}{"table": "partial"},
+		}))
+		Ω(response.Errors).Should(HaveLen(3))
+		Ω(response.Errors[0]).Should(BeNil())
+		Ω(response.Errors[1].Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Errors[2]).Should(BeNil())
+		Ω(response.Metadata.Partial).Should(BeTrue())
+		Ω(response.Metadata.Warnings).Should(Equal([]string{"query 2: partial results without shards [1]"}))
+
+		w = query(handler.HandleAQLBatchV2, "/v2/query/aql/batch", `{"queries": []}`)
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(parse(w).Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+	})
+
+	ginkgo.It("HandleAQL should respond errors without codes", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			return utils.WithCode(utils.ErrCodeInvalidQuery, utils.APIError{Code: http.StatusBadRequest, Message: "unknown table"})
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQL, "/query/aql", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(w.Body.String()).Should(ContainSubstring("unknown table"))
+		Ω(w.Body.String()).ShouldNot(ContainSubstring(string(utils.ErrCodeInvalidQuery)))
+	})
+
+	ginkgo.It("HandleAQL should not respond errors of queries failed after results are streamed", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			w.Write([]byte(`{"headers":["a"],"matrixData":[],"error":{"message":"datanode failed"}}`))
+			return utils.StackError(nil, "datanode failed")
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQL, "/query/aql", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(MatchJSON(`{"headers":["a"],"matrixData":[],"error":{"message":"datanode failed"}}`))
+		Ω(w.Header().Get(utils.HTTPRequestIDHeaderKey)).Should(Equal("request1"))
+	})
+
+	ginkgo.It("should accept and respond protobuf", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.Table).Should(Equal("trips"))
+			Ω(aql.Measures).Should(Equal([]queryCom.Measure{{Expr: "count(*)"}}))
+			w.Write([]byte(`{"1": 2}`))
+			return nil
+		}), config.CompressionConfig{})
+		request, err := proto.Marshal(&queryProto.AQLRequest{Query: &queryProto.AQLQuery{
+			Table:    "trips",
+			Measures: []*queryProto.Measure{{SqlExpression: "count(*)"}},
+		}})
+		Ω(err).Should(BeNil())
+
+		queryProtobuf := func(handle http.HandlerFunc, path string, body []byte) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+			r.Header.Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeProtobuf)
+			r.Header.Set(utils.HTTPAcceptTypeHeaderKey, utils.HTTPContentTypeProtobuf)
+			r.Header.Set(utils.HTTPRequestIDHeaderKey, "request1")
+			w := httptest.NewRecorder()
+			handle(w, r)
+			return w
+		}
+		expectedResult := &queryProto.QueryResult{Result: &queryProto.QueryResult_Aggregate{
+			Aggregate: &queryProto.ResultMap{Entries: map[string]*queryProto.ResultNode{
+				"1": {Value: &queryProto.ResultNode_Measure{Measure: 2}},
+			}},
+		}}
+
+		w := queryProtobuf(handler.HandleAQL, "/query/aql", request)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Header().Get("Content-Type")).Should(Equal(utils.HTTPContentTypeProtobuf))
+		var result queryProto.QueryResult
+		Ω(proto.Unmarshal(w.Body.Bytes(), &result)).Should(BeNil())
+		Ω(proto.Equal(&result, expectedResult)).Should(BeTrue())
+
+		w = queryProtobuf(handler.HandleAQLV2, "/v2/query/aql", request)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		var response queryProto.QueryResponse
+		Ω(proto.Unmarshal(w.Body.Bytes(), &response)).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Results).Should(HaveLen(1))
+		Ω(proto.Equal(response.Results[0], expectedResult)).Should(BeTrue())
+		Ω(response.Metadata.RequestID).Should(Equal("request1"))
+
+		// malformed requests.
+		w = queryProtobuf(handler.HandleAQLV2, "/v2/query/aql", []byte{})
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		response = queryProto.QueryResponse{}
+		Ω(proto.Unmarshal(w.Body.Bytes(), &response)).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(string(utils.ErrCodeBadRequest)))
+
+		w = queryProtobuf(handler.HandleAQL, "/query/aql", []byte{})
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(w.Body.String()).Should(ContainSubstring(apiCom.ErrMsgFailedToUnmarshalRequest))
+	})
+
+	ginkgo.It("should respond protobuf for json requests", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			w.Write([]byte(`{"headers": ["a"], "matrixData": [["x"]]}`))
+			return nil
+		}), config.CompressionConfig{})
+		r := httptest.NewRequest(http.MethodPost, "/v2/query/aql", bytes.NewBufferString(aqlBody))
+		r.Header.Set(utils.HTTPAcceptTypeHeaderKey, utils.HTTPContentTypeProtobuf)
+		w := httptest.NewRecorder()
+		handler.HandleAQLV2(w, r)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+
+		var response queryProto.QueryResponse
+		Ω(proto.Unmarshal(w.Body.Bytes(), &response)).Should(BeNil())
+		nonAgg := response.Results[0].GetNonAggregate()
+		Ω(nonAgg.Headers).Should(Equal([]string{"a"}))
+		Ω(nonAgg.MatrixData[0].Values[0].GetStringValue()).Should(Equal("x"))
+	})
+
+	ginkgo.It("should pass pagination parameters", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.PageSize).Should(Equal(100))
+			Ω(aql.Cursor).Should(Equal("abc"))
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQL, "/query/aql?pageSize=100&cursor=abc", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should pass fill time buckets parameter", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.FillTimeBuckets).Should(BeTrue())
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQL, "/query/aql?fillTimeBuckets=1", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should pass dedup rows parameter", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.DedupRows).Should(BeTrue())
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQL, "/query/aql?dedupRows=1", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should pass progressive parameter of v1 json responses only", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.ProgressiveInterval).Should(Equal(2))
+			return nil
+		}), config.CompressionConfig{})
+		w := query(handler.HandleAQL, "/query/aql?progressive=2", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+
+		w = query(handler.HandleAQL, "/query/aql?progressive=-1", aqlBody)
+		Ω(w.Body.String()).Should(ContainSubstring("invalid progressive -1"))
+		w = query(handler.HandleAQLV2, "/v2/query/aql?progressive=2", aqlBody)
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(parse(w).Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(parse(w).Error.Message).Should(ContainSubstring("only supported by v1 json responses"))
+	})
+
+	ginkgo.It("should pass hll binary of v1 responses and respond errors in the binary format", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.HLLBinary).Should(BeTrue())
+			return utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "not hll")
+		}), config.CompressionConfig{})
+		queryHLL := func(handle http.HandlerFunc, path string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(aqlBody))
+			r.Header.Set(utils.HTTPAcceptTypeHeaderKey, utils.HTTPContentTypeHyperLogLog)
+			w := httptest.NewRecorder()
+			handle(w, r)
+			return w
+		}
+
+		w := queryHLL(handler.HandleAQL, "/query/aql")
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(w.Header().Get(utils.HTTPContentTypeHeaderKey)).Should(Equal(utils.HTTPContentTypeHyperLogLog))
+		results, errs, err := queryCom.ParseHLLQueryResults(w.Body.Bytes())
+		Ω(err).Should(BeNil())
+		Ω(results).Should(Equal([]queryCom.AQLQueryResult{nil}))
+		Ω(errs).Should(HaveLen(1))
+		Ω(errs[0].Error()).Should(Equal("not hll"))
+
+		w = queryHLL(handler.HandleAQLV2, "/v2/query/aql")
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(parse(w).Error.Message).Should(ContainSubstring("only supported by v1 responses"))
+	})
+
+	ginkgo.It("should report bucket completeness if requested", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			aql.Dimensions = []queryCom.Dimension{{TimeBucketizer: "hour"}}
+			collectTimeBuckets(ctx, aql, queryCom.AQLQueryResult{"2019-10-01 00:00": 1.0})
+			w.Write([]byte(`{"2019-10-01 00:00": 1}`))
+			return nil
+		}), config.CompressionConfig{})
+		// buckets are incomplete without data freshness from datanodes.
+		response := parse(query(handler.HandleAQLV2, "/v2/query/aql?bucketCompleteness=1", aqlBody))
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Metadata.BucketCompleteness).Should(Equal(map[string]bool{"2019-10-01 00:00": false}))
+
+		w := query(handler.HandleAQLV2, "/v2/query/aql", aqlBody)
+		Ω(w.Body.String()).ShouldNot(ContainSubstring("bucketCompleteness"))
+	})
+})
@@ -0,0 +1,99 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/utils"
+)
+
+// components of broker readiness checks.
+const (
+	topologyComponent  = "topology"
+	datanodesComponent = "datanodes"
+)
+
+// max number of shards listed in readiness failures.
+const maxShardsInReadinessFailure = 10
+
+// AddReadinessChecks adds readiness checks of broker components to the health checker.
+func AddReadinessChecks(checker *apiCom.HealthChecker, topo topology.Topology, cfg config.HealthConfig) {
+	checker.AddReadinessCheck(topologyComponent, func() error {
+		return checkTopologyMap(topo.Get())
+	})
+	checker.AddReadinessCheck(datanodesComponent, func() error {
+		return checkShardCoverage(topo.Get(), cfg.MinShardCoverage)
+	})
+}
+
+// checkTopologyMap checks whether the topology map is available with datanodes and shards to query.
+func checkTopologyMap(topoMap topology.Map) error {
+	if topoMap == nil {
+		return utils.StackError(nil, "topology map not available")
+	}
+	if topoMap.HostsLen() == 0 {
+		return utils.StackError(nil, "no datanodes in topology map")
+	}
+	if len(topoMap.ShardSet().AllIDs()) == 0 {
+		return utils.StackError(nil, "no shards in topology map")
+	}
+	return nil
+}
+
+// checkShardCoverage checks whether the fraction of shards with at least one available datanode is not
+// less than minCoverage, all shards are required if minCoverage is not positive.
+func checkShardCoverage(topoMap topology.Map, minCoverage float64) error {
+	if topoMap == nil {
+		return utils.StackError(nil, "topology map not available")
+	}
+	if minCoverage <= 0 || minCoverage > 1 {
+		minCoverage = 1
+	}
+
+	// leaving shards are still served until they are removed from the placement.
+	covered := make(map[uint32]bool)
+	for _, hostShardSet := range topoMap.HostShardSets() {
+		for _, s := range hostShardSet.ShardSet().All() {
+			if s.State() == m3Shard.Available || s.State() == m3Shard.Leaving {
+				covered[s.ID()] = true
+			}
+		}
+	}
+
+	shardIDs := topoMap.ShardSet().AllIDs()
+	var uncovered []uint32
+	for _, shardID := range shardIDs {
+		if !covered[shardID] {
+			uncovered = append(uncovered, shardID)
+		}
+	}
+	if len(uncovered) == 0 {
+		return nil
+	}
+
+	coverage := float64(len(shardIDs)-len(uncovered)) / float64(len(shardIDs))
+	if coverage >= minCoverage {
+		return nil
+	}
+	numUncovered := len(uncovered)
+	if numUncovered > maxShardsInReadinessFailure {
+		uncovered = uncovered[:maxShardsInReadinessFailure]
+	}
+	return utils.StackError(nil, "%d of %d shards have no available datanodes (e.g. %v), coverage %.2f below %.2f",
+		numUncovered, len(shardIDs), uncovered, coverage, minCoverage)
+}
@@ -0,0 +1,113 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker/config"
+	aresShard "github.com/uber/aresdb/cluster/shard"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMocks "github.com/uber/aresdb/cluster/topology/mocks"
+)
+
+var _ = ginkgo.Describe("broker readiness checks", func() {
+	host0 := topology.NewHost("instance0", "http://host0:9374")
+	host1 := topology.NewHost("instance1", "http://host1:9374")
+	allShards := aresShard.NewShardSet([]m3Shard.Shard{
+		m3Shard.NewShard(0),
+		m3Shard.NewShard(1),
+		m3Shard.NewShard(2),
+		m3Shard.NewShard(3),
+	})
+
+	newMap := func(hostShardSets ...topology.HostShardSet) topology.Map {
+		return topology.NewStaticMap(topology.NewStaticOptions().
+			SetReplicas(2).
+			SetHostShardSets(hostShardSets).
+			SetShardSet(allShards))
+	}
+
+	ginkgo.It("checkTopologyMap should work", func() {
+		Ω(checkTopologyMap(nil)).ShouldNot(BeNil())
+		Ω(checkTopologyMap(newMap())).ShouldNot(BeNil())
+
+		topoMap := topology.NewStaticMap(topology.NewStaticOptions().
+			SetReplicas(1).
+			SetHostShardSets([]topology.HostShardSet{
+				topology.NewHostShardSet(host0, aresShard.NewShardSet(nil)),
+			}).
+			SetShardSet(aresShard.NewShardSet(nil)))
+		Ω(checkTopologyMap(topoMap)).ShouldNot(BeNil())
+
+		Ω(checkTopologyMap(newMap(topology.NewHostShardSet(host0, allShards)))).Should(BeNil())
+	})
+
+	ginkgo.It("checkShardCoverage should work", func() {
+		Ω(checkShardCoverage(nil, 0)).ShouldNot(BeNil())
+
+		topoMap := newMap(
+			topology.NewHostShardSet(host0, aresShard.NewShardSet([]m3Shard.Shard{
+				m3Shard.NewShard(0).SetState(m3Shard.Available),
+				m3Shard.NewShard(1).SetState(m3Shard.Leaving),
+				m3Shard.NewShard(2).SetState(m3Shard.Initializing),
+			})),
+			topology.NewHostShardSet(host1, aresShard.NewShardSet([]m3Shard.Shard{
+				m3Shard.NewShard(0).SetState(m3Shard.Available),
+				m3Shard.NewShard(2).SetState(m3Shard.Available),
+			})),
+		)
+		Ω(checkShardCoverage(topoMap, 0)).ShouldNot(BeNil())
+		err := checkShardCoverage(topoMap, 0.8)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("1 of 4 shards have no available datanodes (e.g. [3]), coverage 0.75 below 0.80"))
+		Ω(checkShardCoverage(topoMap, 0.75)).Should(BeNil())
+
+		topoMap = newMap(
+			topology.NewHostShardSet(host0, aresShard.NewShardSet([]m3Shard.Shard{
+				m3Shard.NewShard(0).SetState(m3Shard.Available),
+				m3Shard.NewShard(1).SetState(m3Shard.Available),
+			})),
+			topology.NewHostShardSet(host1, aresShard.NewShardSet([]m3Shard.Shard{
+				m3Shard.NewShard(2).SetState(m3Shard.Available),
+				m3Shard.NewShard(3).SetState(m3Shard.Available),
+			})),
+		)
+		Ω(checkShardCoverage(topoMap, 0)).Should(BeNil())
+	})
+
+	ginkgo.It("AddReadinessChecks should work", func() {
+		topo := new(topoMocks.Topology)
+		topo.On("Get").Return(nil).Once()
+		topo.On("Get").Return(nil).Once()
+		topo.On("Get").Return(newMap(topology.NewHostShardSet(host0, aresShard.NewShardSet([]m3Shard.Shard{
+			m3Shard.NewShard(0).SetState(m3Shard.Available),
+			m3Shard.NewShard(1).SetState(m3Shard.Available),
+			m3Shard.NewShard(2).SetState(m3Shard.Available),
+		}))))
+
+		checker := apiCom.NewHealthChecker()
+		AddReadinessChecks(checker, topo, config.HealthConfig{MinShardCoverage: 0.5})
+		report := checker.CheckReadiness()
+		Ω(report.Ready).Should(BeFalse())
+		Ω(report.Failures).Should(HaveLen(2))
+		Ω(report.Failures[0].Component).Should(Equal(topologyComponent))
+		Ω(report.Failures[1].Component).Should(Equal(datanodesComponent))
+
+		Ω(checker.CheckReadiness()).Should(Equal(apiCom.ReadinessReport{Ready: true}))
+	})
+})
@@ -0,0 +1,187 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// defaultHedgeLatencyPercentile is the default percentile of recent latencies of a datanode to wait
+	// before hedging.
+	defaultHedgeLatencyPercentile = 95
+	// defaultHedgeLatencyWindow is the default number of recent latencies tracked per datanode.
+	defaultHedgeLatencyWindow = 100
+	// minHedgeLatencySamples is the min number of latencies of a datanode to hedge by its latency percentile.
+	minHedgeLatencySamples = 10
+)
+
+// hedger hedges queries of datanodes not responded in time to replicas owning the same shards, shared by
+// queries of the broker to track recent latencies of datanodes.
+type hedger struct {
+	sync.Mutex
+
+	delay      time.Duration
+	minDelay   time.Duration
+	percentile float64
+	window     int
+	// recent latencies of datanodes by host id.
+	latencies map[string]*latencyWindow
+}
+
+// latencyWindow is the ring buffer of recent latencies of a datanode.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+// newHedger creates the hedger by the config, nil if hedging is not enabled.
+func newHedger(cfg config.HedgeConfig) *hedger {
+	if !cfg.Enable {
+		return nil
+	}
+	h := &hedger{
+		delay:      time.Duration(cfg.DelayMillis) * time.Millisecond,
+		minDelay:   time.Duration(cfg.MinDelayMillis) * time.Millisecond,
+		percentile: cfg.LatencyPercentile,
+		window:     cfg.LatencyWindow,
+		latencies:  make(map[string]*latencyWindow),
+	}
+	if h.percentile <= 0 || h.percentile > 100 {
+		h.percentile = defaultHedgeLatencyPercentile
+	}
+	if h.window <= 0 {
+		h.window = defaultHedgeLatencyWindow
+	}
+	return h
+}
+
+// record tracks the latency of a query succeeded on the datanode.
+func (h *hedger) record(host string, latency time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	w := h.latencies[host]
+	if w == nil {
+		w = &latencyWindow{}
+		h.latencies[host] = w
+	}
+	if len(w.samples) < h.window {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % h.window
+}
+
+// hedgeDelay returns how long to wait for the datanode before hedging, either the configured delay or the
+// latency percentile of the datanode. Datanodes without enough latencies tracked are not hedged.
+func (h *hedger) hedgeDelay(host string) (time.Duration, bool) {
+	if h.delay > 0 {
+		return h.delay, true
+	}
+
+	h.Lock()
+	var samples []time.Duration
+	if w := h.latencies[host]; w != nil {
+		samples = append(samples, w.samples...)
+	}
+	h.Unlock()
+	if len(samples) < minHedgeLatencySamples {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	index := int(math.Ceil(h.percentile/100*float64(len(samples)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	delay := samples[index]
+	if delay < h.minDelay {
+		delay = h.minDelay
+	}
+	return delay, true
+}
+
+// hedgeResult is the result of a query of a datanode hedged.
+type hedgeResult struct {
+	value  interface{}
+	err    error
+	hedged bool
+}
+
+// call calls the primary host, and the first replica as well if the primary has not responded after the
+// hedge delay. The first succeeded result is taken and the other call is canceled, the error of the primary
+// is returned if both fail.
+func (h *hedger) call(ctx context.Context, primary topology.Host, replicas []topology.Host,
+	call func(ctx context.Context, host topology.Host) (interface{}, error)) (interface{}, error) {
+	delay, ok := h.hedgeDelay(primary.ID())
+	if len(replicas) == 0 || !ok {
+		return h.timedCall(ctx, primary, call)
+	}
+
+	// the call not taken is canceled once returned.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// buffered so that the call not taken does not block.
+	results := make(chan hedgeResult, 2)
+	run := func(host topology.Host, hedged bool) {
+		value, err := h.timedCall(ctx, host, call)
+		results <- hedgeResult{value: value, err: err, hedged: hedged}
+	}
+	go run(primary, false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var err error
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			utils.GetContextLogger(ctx).With("host", primary, "replica", replicas[0], "delay", delay).Debug("hedging query to replica")
+			utils.GetRootReporter().GetCounter(utils.DataNodeHedgesIssued).Inc(1)
+			pending++
+			go run(replicas[0], true)
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if res.hedged {
+					utils.GetRootReporter().GetCounter(utils.DataNodeHedgesWon).Inc(1)
+				}
+				return res.value, nil
+			}
+			if !res.hedged || err == nil {
+				err = res.err
+			}
+		}
+	}
+	return nil, err
+}
+
+// timedCall calls the host and tracks the latency if succeeded.
+func (h *hedger) timedCall(ctx context.Context, host topology.Host,
+	call func(ctx context.Context, host topology.Host) (interface{}, error)) (interface{}, error) {
+	start := utils.Now()
+	value, err := call(ctx, host)
+	if err == nil {
+		h.record(host.ID(), utils.Now().Sub(start))
+	}
+	return value, err
+}
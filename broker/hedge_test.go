@@ -0,0 +1,225 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/broker/config"
+	shardMock "github.com/uber/aresdb/cluster/shard/mocks"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("hedger", func() {
+	var mockTopo topoMock.Topology
+	var hosts []topology.Host
+
+	ginkgo.BeforeEach(func() {
+		// host0: 0,1
+		// host1: 0,1,2
+		// host2: 1,2
+		mockTopo = topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1, 2})
+		// hosts are not mocked since mocks record calls of hosts queried concurrently.
+		hosts = nil
+		for i := 0; i < 3; i++ {
+			hosts = append(hosts, topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i)))
+		}
+		mockMap.On("Hosts").Return([]topology.Host{hosts[0], hosts[1], hosts[2]})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{hosts[0], hosts[1]}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{hosts[0], hosts[1], hosts[2]}, nil)
+		mockMap.On("RouteShard", uint32(2)).Return([]topology.Host{hosts[1], hosts[2]}, nil)
+	})
+
+	counter := func(name string) int64 {
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		if c, exist := testScope.Snapshot().Counters()["test."+name+"+component=query"]; exist {
+			return c.Value()
+		}
+		return 0
+	}
+
+	ginkgo.It("should wait for datanodes by their latency percentiles", func() {
+		Ω(newHedger(config.HedgeConfig{})).Should(BeNil())
+
+		h := newHedger(config.HedgeConfig{Enable: true, DelayMillis: 50})
+		delay, ok := h.hedgeDelay("host0")
+		Ω(ok).Should(BeTrue())
+		Ω(delay).Should(Equal(50 * time.Millisecond))
+
+		h = newHedger(config.HedgeConfig{Enable: true, LatencyWindow: 20, MinDelayMillis: 5})
+		for i := 1; i < minHedgeLatencySamples; i++ {
+			h.record("host0", time.Duration(i)*time.Millisecond)
+		}
+		// not hedged without enough latencies.
+		_, ok = h.hedgeDelay("host0")
+		Ω(ok).Should(BeFalse())
+		for i := minHedgeLatencySamples; i <= 20; i++ {
+			h.record("host0", time.Duration(i)*time.Millisecond)
+		}
+		delay, ok = h.hedgeDelay("host0")
+		Ω(ok).Should(BeTrue())
+		Ω(delay).Should(Equal(19 * time.Millisecond))
+
+		// only recent latencies are tracked.
+		for i := 0; i < 20; i++ {
+			h.record("host0", time.Millisecond)
+		}
+		delay, _ = h.hedgeDelay("host0")
+		Ω(delay).Should(Equal(5 * time.Millisecond))
+		_, ok = h.hedgeDelay("host1")
+		Ω(ok).Should(BeFalse())
+	})
+
+	ginkgo.It("should take whichever host responds first", func() {
+		h := newHedger(config.HedgeConfig{Enable: true, DelayMillis: 10})
+		replicas := []topology.Host{hosts[1]}
+
+		// slow primary canceled once the replica responds.
+		canceled := make(chan struct{})
+		issued, won := counter("datanode_hedges_issued"), counter("datanode_hedges_won")
+		value, err := h.call(context.Background(), hosts[0], replicas, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			if host == hosts[0] {
+				<-ctx.Done()
+				close(canceled)
+				return nil, ctx.Err()
+			}
+			return host.ID(), nil
+		})
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal("host1"))
+		Eventually(canceled).Should(BeClosed())
+		Ω(counter("datanode_hedges_issued")).Should(Equal(issued + 1))
+		Ω(counter("datanode_hedges_won")).Should(Equal(won + 1))
+
+		// fast primary not hedged.
+		value, err = h.call(context.Background(), hosts[0], replicas, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			return host.ID(), nil
+		})
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal("host0"))
+		Ω(counter("datanode_hedges_issued")).Should(Equal(issued + 1))
+
+		// slow primary responding after the replica failed.
+		value, err = h.call(context.Background(), hosts[0], replicas, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			if host == hosts[0] {
+				time.Sleep(50 * time.Millisecond)
+				return host.ID(), nil
+			}
+			return nil, errors.New("replica failed")
+		})
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal("host0"))
+		Ω(counter("datanode_hedges_won")).Should(Equal(won + 1))
+
+		// errors of primaries are returned if both fail.
+		_, err = h.call(context.Background(), hosts[0], replicas, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			if host == hosts[0] {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return nil, fmt.Errorf("%s failed", host.ID())
+		})
+		Ω(err).Should(MatchError("host0 failed"))
+
+		// not hedged without replicas.
+		_, err = h.call(context.Background(), hosts[1], nil, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			time.Sleep(50 * time.Millisecond)
+			return nil, fmt.Errorf("%s failed", host.ID())
+		})
+		Ω(err).Should(MatchError("host1 failed"))
+		Ω(counter("datanode_hedges_issued")).Should(Equal(issued + 3))
+	})
+
+	ginkgo.It("should hedge scans of slow datanodes to replicas", func() {
+		h := newHedger(config.HedgeConfig{Enable: true, DelayMillis: 10})
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		// host0 is slow.
+		mockDatanodeCli.On("QueryRaw", mock.Anything, hosts[0], mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery) []byte {
+				<-ctx.Done()
+				return nil
+			},
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery) error {
+				return ctx.Err()
+			})
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery) []byte {
+				return []byte(fmt.Sprintf(`["%v"],`, query.Shards))
+			}, nil)
+		mockDatanodeCli.On("Query", mock.Anything, hosts[0], mock.Anything, false).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) queryCom.AQLQueryResult {
+				<-ctx.Done()
+				return nil
+			},
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) error {
+				return ctx.Err()
+			})
+		mockDatanodeCli.On("Query", mock.Anything, mock.Anything, mock.Anything, false).Return(
+			func(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) queryCom.AQLQueryResult {
+				return queryCom.AQLQueryResult{"1": float64(2)}
+			}, nil)
+
+		qc := &QueryContext{
+			AQLQuery: &queryCom.AQLQuery{
+				Table:      "table1",
+				Measures:   []queryCom.Measure{{Expr: "1"}},
+				Dimensions: []queryCom.Dimension{{Expr: "field1"}},
+				Limit:      -1,
+			},
+			IsNonAggregationQuery: true,
+			hedger:                h,
+		}
+		w := httptest.NewRecorder()
+		nonAggPlan, err := NewNonAggQueryPlan(qc, &mockTopo, &mockDatanodeCli, w)
+		Ω(err).Should(BeNil())
+		Ω(nonAggPlan.Execute(context.Background())).Should(BeNil())
+		// shard 0 of host0 is scanned by host1.
+		Ω(w.Body.String()).Should(ContainSubstring(`["[0]"]`))
+
+		mockSchemaReader := metaMocks.TableSchemaReader{}
+		mockSchemaReader.On("GetTable", "table1").Return(&metaCom.Table{Name: "table1"}, nil)
+		qc = NewQueryContext(&queryCom.AQLQuery{
+			Table:    "table1",
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+		}, httptest.NewRecorder())
+		qc.hedger = h
+		qc.Compile(&mockSchemaReader)
+		Ω(qc.Error).Should(BeNil())
+		aggPlan, err := NewAggQueryPlan(qc, &mockTopo, &mockDatanodeCli)
+		Ω(err).Should(BeNil())
+		result, err := aggPlan.Execute(context.Background())
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(queryCom.AQLQueryResult{"1": float64(6)}))
+	})
+})
@@ -0,0 +1,220 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	defaultHLLUnionMaxRequestBytes = 64 << 20
+)
+
+// HLLUnionHandler unions serialized hll query results, e.g. daily results of the same queries can be
+// unioned into weekly distinct counts without querying raw data again.
+type HLLUnionHandler struct {
+	maxRequestBytes int64
+}
+
+// NewHLLUnionHandler creates a new HLLUnionHandler
+func NewHLLUnionHandler(cfg config.HLLUnionConfig) HLLUnionHandler {
+	maxRequestBytes := cfg.MaxRequestBytes
+	if maxRequestBytes <= 0 {
+		maxRequestBytes = defaultHLLUnionMaxRequestBytes
+	}
+	return HLLUnionHandler{
+		maxRequestBytes: maxRequestBytes,
+	}
+}
+
+// Register registers http handlers.
+func (handler *HLLUnionHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/hll/union", utils.ApplyHTTPWrappers(handler.HandleHLLUnion, wrappers)).Methods(http.MethodPost)
+}
+
+// HLLUnionRequest represents the request to union hll query results. The body is multipart/form-data,
+// each part is a response body of the same hll queries with Accept set to application/hll.
+type HLLUnionRequest struct {
+	// Whether to return distinct counts instead of sketches in the standard dense format.
+	// in: query
+	Materialize int `query:"materialize,optional" json:"materialize"`
+}
+
+// HandleHLLUnion unions sketches of each query per group across inputs. Groups missing in some inputs
+// are unioned as empty sketches. Inputs are read as a stream and merged one query result at a time.
+func (handler *HLLUnionHandler) HandleHLLUnion(w http.ResponseWriter, r *http.Request) {
+	var unionRequest HLLUnionRequest
+	err := apiCom.ReadRequest(r, &unionRequest)
+	if err != nil {
+		apiCom.RespondWithBadRequest(w, err)
+		return
+	}
+
+	errRequestTooLarge := utils.APIError{
+		Code:    http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("request body exceeds the limit of %d bytes", handler.maxRequestBytes),
+	}
+	if r.ContentLength > handler.maxRequestBytes {
+		apiCom.RespondWithError(w, errRequestTooLarge)
+		return
+	}
+	body := &limitedReadCloser{ReadCloser: r.Body, remaining: handler.maxRequestBytes}
+	r.Body = body
+
+	var reader *multipart.Reader
+	var results []queryCom.AQLQueryResult
+	if reader, err = r.MultipartReader(); err == nil {
+		results, err = unionHLLQueryResults(reader)
+	}
+	if body.exceeded {
+		apiCom.RespondWithError(w, errRequestTooLarge)
+		return
+	}
+	if err != nil {
+		utils.GetLogger().With("error", err).Error("Failed to union hll query results")
+		apiCom.RespondWithBadRequest(w, err)
+		return
+	}
+
+	for i, result := range results {
+		if unionRequest.Materialize != 0 {
+			results[i] = queryCom.ComputeHLLResult(result)
+		} else if results[i], err = queryCom.ExportStandardHLLResult(result, 0, queryCom.DefaultStandardHLLRegisterWidth); err != nil {
+			apiCom.RespondWithError(w, err)
+			return
+		}
+	}
+	apiCom.RespondWithJSONObject(w, queryCom.AQLResponse{Results: results})
+}
+
+// unionHLLQueryResults unions query results of each part by query index, all parts must have results of
+// the same queries.
+func unionHLLQueryResults(reader *multipart.Reader) (results []queryCom.AQLQueryResult, err error) {
+	// depth of sketches by query index, -1 for results without any sketches.
+	var depths []int
+	var numInputs int
+	for ; ; numInputs++ {
+		var part *multipart.Part
+		if part, err = reader.NextPart(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, utils.StackError(err, "failed to read input %d", numInputs)
+		}
+
+		numQueries := 0
+		err = queryCom.ReadHLLQueryResults(part, func(index int, result queryCom.AQLQueryResult, queryErr error) error {
+			numQueries++
+			if queryErr != nil {
+				return utils.StackError(queryErr, "query %d failed", index)
+			}
+			depth, err := getHLLResultDepth(result)
+			if err != nil {
+				return utils.StackError(err, "invalid result of query %d", index)
+			}
+
+			if index == len(results) {
+				if numInputs > 0 {
+					return utils.StackError(nil, "more queries than previous inputs")
+				}
+				results = append(results, result)
+				depths = append(depths, depth)
+				return nil
+			}
+
+			if depth >= 0 && depths[index] >= 0 && depth != depths[index] {
+				return utils.StackError(nil, "dimensions of query %d do not match previous inputs: %d vs %d",
+					index, depth, depths[index])
+			}
+			if depth >= 0 {
+				depths[index] = depth
+			}
+			mergeCtx := newResultMergeContext(common.Hll)
+			results[index] = mergeCtx.run(results[index], result)
+			return mergeCtx.err
+		})
+		part.Close()
+		if err != nil {
+			return nil, utils.StackError(err, "failed to union input %d", numInputs)
+		}
+		if numQueries != len(results) {
+			return nil, utils.StackError(nil, "input %d has %d queries, expected %d", numInputs, numQueries, len(results))
+		}
+	}
+
+	if numInputs == 0 {
+		return nil, utils.StackError(nil, "no hll query results to union")
+	}
+	return results, nil
+}
+
+// getHLLResultDepth returns the number of dimensions of sketches in the result, or -1 if there is no
+// sketch in the result.
+func getHLLResultDepth(result interface{}) (int, error) {
+	switch r := result.(type) {
+	case queryCom.HLL:
+		return 0, nil
+	case queryCom.AQLQueryResult:
+		return getHLLResultDepth(map[string]interface{}(r))
+	case map[string]interface{}:
+		depth := -1
+		for _, v := range r {
+			childDepth, err := getHLLResultDepth(v)
+			if err != nil {
+				return 0, err
+			}
+			if childDepth < 0 {
+				continue
+			}
+			if depth >= 0 && depth != childDepth+1 {
+				return 0, utils.StackError(nil, "sketches with different number of dimensions")
+			}
+			depth = childDepth + 1
+		}
+		return depth, nil
+	default:
+		return 0, utils.StackError(nil, "unexpected value %v in hll result", r)
+	}
+}
+
+// limitedReadCloser fails reads beyond the limit, so that request bodies too large are rejected
+// without being read entirely.
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+// Read reads up to one byte beyond the limit to tell the end of body from exceeding the limit.
+func (r *limitedReadCloser) Read(p []byte) (n int, err error) {
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err = r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		r.exceeded = true
+		return n, utils.StackError(nil, "request body too large")
+	}
+	return
+}
@@ -0,0 +1,170 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = ginkgo.Describe("hll union handler", func() {
+	var oneQuery, twoQueries, failedQuery, emptyResult []byte
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		// the second query of the test data failed.
+		failedQuery, err = ioutil.ReadFile("../testing/data/query/hll_query_results")
+		Ω(err).Should(BeNil())
+		emptyResult, err = ioutil.ReadFile("../testing/data/query/hll_empty_results")
+		Ω(err).Should(BeNil())
+
+		// magic header (8 bytes) + size, error flag and padding (8 bytes) + result.
+		size := binary.LittleEndian.Uint32(failedQuery[8:])
+		oneQuery = failedQuery[:16+size]
+		twoQueries = append(append([]byte{}, oneQuery...), oneQuery[8:]...)
+	})
+
+	union := func(handler HLLUnionHandler, query string, inputs ...[]byte) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, input := range inputs {
+			part, err := writer.CreateFormFile("results", "results.hll")
+			Ω(err).Should(BeNil())
+			_, err = part.Write(input)
+			Ω(err).Should(BeNil())
+		}
+		Ω(writer.Close()).Should(BeNil())
+
+		r := httptest.NewRequest(http.MethodPost, "/query/hll/union"+query, body)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		handler.HandleHLLUnion(w, r)
+		return w
+	}
+
+	parse := func(data []byte) []queryCom.AQLQueryResult {
+		results, _, err := queryCom.ParseHLLQueryResults(data)
+		Ω(err).Should(BeNil())
+		return results
+	}
+
+	ginkgo.It("should union sketches", func() {
+		handler := NewHLLUnionHandler(config.HLLUnionConfig{})
+		expected, err := queryCom.ExportStandardHLLResult(parse(oneQuery)[0], 0, queryCom.DefaultStandardHLLRegisterWidth)
+		Ω(err).Should(BeNil())
+		expectedBytes, err := json.Marshal(queryCom.AQLResponse{Results: []queryCom.AQLQueryResult{expected}})
+		Ω(err).Should(BeNil())
+
+		w := union(handler, "", oneQuery, emptyResult, oneQuery)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.Bytes()).Should(MatchJSON(expectedBytes))
+
+		w = union(handler, "", twoQueries, twoQueries)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		expectedBytes, err = json.Marshal(queryCom.AQLResponse{Results: []queryCom.AQLQueryResult{expected, expected}})
+		Ω(err).Should(BeNil())
+		Ω(w.Body.Bytes()).Should(MatchJSON(expectedBytes))
+	})
+
+	ginkgo.It("should materialize distinct counts", func() {
+		handler := NewHLLUnionHandler(config.HLLUnionConfig{})
+		expectedBytes, err := json.Marshal(queryCom.AQLResponse{
+			Results: []queryCom.AQLQueryResult{queryCom.ComputeHLLResult(parse(oneQuery)[0])},
+		})
+		Ω(err).Should(BeNil())
+
+		w := union(handler, "?materialize=1", emptyResult, oneQuery)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.Bytes()).Should(MatchJSON(expectedBytes))
+	})
+
+	ginkgo.It("should reject invalid inputs", func() {
+		handler := NewHLLUnionHandler(config.HLLUnionConfig{})
+		for _, tc := range []struct {
+			inputs     [][]byte
+			errPattern string
+		}{
+			{nil, "no hll query results to union"},
+			{[][]byte{failedQuery}, "query 1 failed"},
+			{[][]byte{oneQuery, []byte("not hll")}, "failed to union input 1"},
+			{[][]byte{twoQueries, oneQuery}, "input 1 has 1 queries, expected 2"},
+			{[][]byte{oneQuery, twoQueries}, "more queries than previous inputs"},
+		} {
+			w := union(handler, "", tc.inputs...)
+			Ω(w.Code).Should(Equal(http.StatusBadRequest))
+			Ω(w.Body.String()).Should(ContainSubstring(tc.errPattern))
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/query/hll/union", bytes.NewReader(oneQuery))
+		w := httptest.NewRecorder()
+		handler.HandleHLLUnion(w, r)
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("should reject requests too large", func() {
+		handler := NewHLLUnionHandler(config.HLLUnionConfig{MaxRequestBytes: 1024})
+		w := union(handler, "", oneQuery)
+		Ω(w.Code).Should(Equal(http.StatusRequestEntityTooLarge))
+
+		// without content length.
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("results", "results.hll")
+		Ω(err).Should(BeNil())
+		part.Write(oneQuery)
+		writer.Close()
+		r := httptest.NewRequest(http.MethodPost, "/query/hll/union", body)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		r.ContentLength = -1
+		w = httptest.NewRecorder()
+		handler.HandleHLLUnion(w, r)
+		Ω(w.Code).Should(Equal(http.StatusRequestEntityTooLarge))
+
+		handler = NewHLLUnionHandler(config.HLLUnionConfig{MaxRequestBytes: int64(len(oneQuery)) * 2})
+		w = union(handler, "", oneQuery)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("getHLLResultDepth should work", func() {
+		depth, err := getHLLResultDepth(queryCom.AQLQueryResult{})
+		Ω(err).Should(BeNil())
+		Ω(depth).Should(Equal(-1))
+
+		depth, err = getHLLResultDepth(queryCom.AQLQueryResult{
+			"1": map[string]interface{}{},
+			"2": map[string]interface{}{"a": queryCom.HLL{}},
+		})
+		Ω(err).Should(BeNil())
+		Ω(depth).Should(Equal(2))
+
+		_, err = getHLLResultDepth(queryCom.AQLQueryResult{
+			"1": queryCom.HLL{},
+			"2": map[string]interface{}{"a": queryCom.HLL{}},
+		})
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = getHLLResultDepth(queryCom.AQLQueryResult{"1": 1.0})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,254 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/broker/util"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultHealthHalfLife      = 30 * time.Second
+	defaultHealthDegradedRatio = 0.1
+	defaultHealthDownRatio     = 0.9
+	defaultHealthDownFailures  = 3
+)
+
+// hostOutcomes are the outcomes of recent queries of a datanode, weighted by their decay.
+type hostOutcomes struct {
+	successes float64
+	failures  float64
+	// when the weights were last decayed.
+	updatedAt time.Time
+}
+
+// HostHealthEntry is the health of a datanode shown in the debug handler.
+type HostHealthEntry struct {
+	Host      string          `json:"host"`
+	Status    util.HostStatus `json:"status"`
+	Successes float64         `json:"successes"`
+	Failures  float64         `json:"failures"`
+}
+
+// HealthTracker tracks health of datanodes by outcomes of their queries, so that shards are assigned to the
+// healthiest replicas. Outcomes decay by the half life, datanodes are degraded or down by the ratio of recent
+// failed queries, and turn healthy again once their failures decayed, e.g. while not queried being down.
+type HealthTracker struct {
+	sync.Mutex
+
+	halfLife      time.Duration
+	degradedRatio float64
+	downRatio     float64
+	downFailures  float64
+	// outcomes by host id, datanodes without outcomes are healthy.
+	hosts map[string]*hostOutcomes
+}
+
+// NewHealthTracker creates the health tracker of datanodes, nil if not enabled.
+func NewHealthTracker(cfg config.HostHealthConfig) *HealthTracker {
+	if !cfg.Enable {
+		return nil
+	}
+	halfLife := time.Duration(cfg.HalfLifeMillis) * time.Millisecond
+	if halfLife <= 0 {
+		halfLife = defaultHealthHalfLife
+	}
+	degradedRatio := cfg.DegradedRatio
+	if degradedRatio <= 0 {
+		degradedRatio = defaultHealthDegradedRatio
+	}
+	downRatio := cfg.DownRatio
+	if downRatio <= 0 {
+		downRatio = defaultHealthDownRatio
+	}
+	downFailures := cfg.DownFailures
+	if downFailures <= 0 {
+		downFailures = defaultHealthDownFailures
+	}
+	return &HealthTracker{
+		halfLife:      halfLife,
+		degradedRatio: degradedRatio,
+		downRatio:     downRatio,
+		downFailures:  float64(downFailures),
+		hosts:         make(map[string]*hostOutcomes),
+	}
+}
+
+// Record records the outcome of a query of the datanode. Only failures of datanodes being unavailable count as
+// failures, queries failed by themselves, e.g. invalid queries, count as successes.
+func (t *HealthTracker) Record(hostID string, err error) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	outcomes := t.hosts[hostID]
+	if outcomes == nil {
+		outcomes = &hostOutcomes{updatedAt: utils.Now()}
+		t.hosts[hostID] = outcomes
+	}
+	t.decay(outcomes)
+	previous := t.status(outcomes)
+	if err == nil || !utils.GetErrorCodeInfo(utils.GetErrorCode(err)).Retriable {
+		outcomes.successes++
+	} else {
+		outcomes.failures++
+	}
+
+	if status := t.status(outcomes); status != previous {
+		utils.GetLogger().With(
+			"host", hostID,
+			"from", previous.String(),
+			"to", status.String()).Info("datanode health status changed")
+		utils.GetRootReporter().GetChildGauge(map[string]string{
+			"host": hostID,
+		}, utils.DataNodeHealthStatus).Update(float64(status))
+	}
+}
+
+// HostStatus returns the health status of the datanode, healthy if not tracked.
+func (t *HealthTracker) HostStatus(hostID string) util.HostStatus {
+	if t == nil {
+		return util.HostHealthy
+	}
+	t.Lock()
+	defer t.Unlock()
+	if outcomes := t.hosts[hostID]; outcomes != nil {
+		t.decay(outcomes)
+		return t.status(outcomes)
+	}
+	return util.HostHealthy
+}
+
+// Entries returns the health of datanodes of the topology map sorted by host id, with datanodes tracked but no
+// longer in the topology map.
+func (t *HealthTracker) Entries(topoMap topology.Map) []HostHealthEntry {
+	hostIDs := make(map[string]bool)
+	if topoMap != nil {
+		for _, host := range topoMap.Hosts() {
+			hostIDs[host.ID()] = true
+		}
+	}
+	if t != nil {
+		t.Lock()
+		defer t.Unlock()
+		for hostID := range t.hosts {
+			hostIDs[hostID] = true
+		}
+	}
+
+	entries := make([]HostHealthEntry, 0, len(hostIDs))
+	for hostID := range hostIDs {
+		entry := HostHealthEntry{Host: hostID, Status: util.HostHealthy}
+		if t != nil {
+			if outcomes := t.hosts[hostID]; outcomes != nil {
+				t.decay(outcomes)
+				entry.Status = t.status(outcomes)
+				entry.Successes, entry.Failures = outcomes.successes, outcomes.failures
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Host < entries[j].Host
+	})
+	return entries
+}
+
+// decay decays the outcomes by the time elapsed since last decayed, the lock must be held.
+func (t *HealthTracker) decay(outcomes *hostOutcomes) {
+	now := utils.Now()
+	elapsed := now.Sub(outcomes.updatedAt)
+	if elapsed <= 0 {
+		return
+	}
+	factor := math.Pow(0.5, float64(elapsed)/float64(t.halfLife))
+	outcomes.successes *= factor
+	outcomes.failures *= factor
+	outcomes.updatedAt = now
+}
+
+// status returns the health status of the outcomes, datanodes with less than a failure left after decay are
+// healthy. The lock must be held.
+func (t *HealthTracker) status(outcomes *hostOutcomes) util.HostStatus {
+	if outcomes.failures < 1 {
+		return util.HostHealthy
+	}
+	ratio := outcomes.failures / (outcomes.successes + outcomes.failures)
+	if ratio >= t.downRatio && outcomes.failures >= t.downFailures {
+		return util.HostDown
+	}
+	if ratio >= t.degradedRatio {
+		return util.HostDegraded
+	}
+	return util.HostHealthy
+}
+
+// healthTrackedClient records outcomes of queries of the datanode client to the health tracker.
+type healthTrackedClient struct {
+	dataCli.DataNodeQueryClient
+	tracker *HealthTracker
+}
+
+// NewHealthTrackedClient returns the datanode client recording outcomes of its queries to the health tracker,
+// the client is returned as is if the tracker is nil.
+func NewHealthTrackedClient(client dataCli.DataNodeQueryClient, tracker *HealthTracker) dataCli.DataNodeQueryClient {
+	if tracker == nil {
+		return client
+	}
+	return &healthTrackedClient{DataNodeQueryClient: client, tracker: tracker}
+}
+
+// record records the outcome of the query, see callOutcome.
+func (c *healthTrackedClient) record(ctx context.Context, host topology.Host, err error) {
+	if record, outcome := callOutcome(ctx, err); record {
+		c.tracker.Record(host.ID(), outcome)
+	}
+}
+
+func (c *healthTrackedClient) Query(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) (queryCom.AQLQueryResult, error) {
+	result, err := c.DataNodeQueryClient.Query(ctx, host, query, hll)
+	c.record(ctx, host, err)
+	return result, err
+}
+
+func (c *healthTrackedClient) QueryBatch(ctx context.Context, host topology.Host, queries []queryCom.AQLQuery) ([]queryCom.AQLQueryResult, []error, error) {
+	results, queryErrors, err := c.DataNodeQueryClient.QueryBatch(ctx, host, queries)
+	c.record(ctx, host, err)
+	return results, queryErrors, err
+}
+
+func (c *healthTrackedClient) QueryRaw(ctx context.Context, host topology.Host, query queryCom.AQLQuery) ([]byte, error) {
+	bs, err := c.DataNodeQueryClient.QueryRaw(ctx, host, query)
+	c.record(ctx, host, err)
+	return bs, err
+}
+
+func (c *healthTrackedClient) GetSchemaVersions(ctx context.Context, host topology.Host) (map[string]metaCom.TableSchemaVersion, error) {
+	versions, err := c.DataNodeQueryClient.GetSchemaVersions(ctx, host)
+	c.record(ctx, host, err)
+	return versions, err
+}
@@ -0,0 +1,150 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/broker/util"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	"github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("host health", func() {
+	var tracker *HealthTracker
+	unavailable := utils.NewCodedError(utils.ErrCodeUnavailable, nil, "connection refused")
+
+	ginkgo.BeforeEach(func() {
+		tracker = NewHealthTracker(config.HostHealthConfig{
+			Enable:         true,
+			HalfLifeMillis: 1000,
+			DegradedRatio:  0.2,
+			DownRatio:      0.8,
+			DownFailures:   2,
+		})
+		utils.SetCurrentTime(time.Unix(1000, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should not be created if not enabled", func() {
+		Ω(NewHealthTracker(config.HostHealthConfig{})).Should(BeNil())
+		var nilTracker *HealthTracker
+		nilTracker.Record("host0", unavailable)
+		Ω(nilTracker.HostStatus("host0")).Should(Equal(util.HostHealthy))
+		Ω(nilTracker.Entries(nil)).Should(BeEmpty())
+		client := &dataCliMock.DataNodeQueryClient{}
+		Ω(NewHealthTrackedClient(client, nil)).Should(BeIdenticalTo(client))
+	})
+
+	ginkgo.It("should track status by recent failures", func() {
+		Ω(tracker.HostStatus("host0")).Should(Equal(util.HostHealthy))
+		for i := 0; i < 4; i++ {
+			tracker.Record("host0", nil)
+		}
+		tracker.Record("host0", unavailable)
+		Ω(tracker.HostStatus("host0")).Should(Equal(util.HostDegraded))
+		// queries failed by themselves count as successes.
+		for i := 0; i < 5; i++ {
+			tracker.Record("host0", utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "invalid query"))
+		}
+		Ω(tracker.HostStatus("host0")).Should(Equal(util.HostHealthy))
+
+		// a single failure is not enough to be down.
+		tracker.Record("host1", unavailable)
+		Ω(tracker.HostStatus("host1")).Should(Equal(util.HostDegraded))
+		tracker.Record("host1", unavailable)
+		Ω(tracker.HostStatus("host1")).Should(Equal(util.HostDown))
+
+		// failures decay by the half life.
+		utils.SetCurrentTime(time.Unix(1001, 0))
+		Ω(tracker.HostStatus("host1")).Should(Equal(util.HostDegraded))
+		utils.SetCurrentTime(time.Unix(1002, 0))
+		Ω(tracker.HostStatus("host1")).Should(Equal(util.HostHealthy))
+	})
+
+	ginkgo.It("should record outcomes of datanode queries", func() {
+		host := topology.NewHost("host0", "host0:9374")
+		client := &dataCliMock.DataNodeQueryClient{}
+		client.On("Query", mock.Anything, host, mock.Anything, false).Return(nil, unavailable)
+		client.On("QueryRaw", mock.Anything, host, mock.Anything).Return(nil, unavailable)
+		client.On("QueryBatch", mock.Anything, host, mock.Anything).Return(nil, nil, nil)
+		client.On("GetSchemaVersions", mock.Anything, host).Return(nil, errors.New("unknown error"))
+		tracked := NewHealthTrackedClient(client, tracker)
+
+		_, err := tracked.Query(context.Background(), host, common.AQLQuery{}, false)
+		Ω(err).Should(Equal(unavailable))
+		tracked.QueryRaw(context.Background(), host, common.AQLQuery{})
+		Ω(tracker.HostStatus("host0")).Should(Equal(util.HostDown))
+		tracked.QueryBatch(context.Background(), host, nil)
+		// errors other than datanodes being unavailable count as successes.
+		tracked.GetSchemaVersions(context.Background(), host)
+		Ω(tracker.Entries(nil)).Should(Equal([]HostHealthEntry{
+			{Host: "host0", Status: util.HostDegraded, Successes: 2, Failures: 2},
+		}))
+
+		// canceled queries tell nothing about datanodes.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tracked.Query(ctx, host, common.AQLQuery{}, false)
+		Ω(tracker.Entries(nil)[0].Failures).Should(Equal(2.0))
+
+		// queries running out of their deadlines tell nothing about datanodes either.
+		queryCtx, cancelQuery := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancelQuery()
+		<-queryCtx.Done()
+		tracked.GetSchemaVersions(queryCtx, host)
+		Ω(tracker.Entries(nil)[0].Failures).Should(Equal(2.0))
+
+		// queries running out of the datanode timeout count as failures.
+		ctx, cancel = withCallTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		tracked.GetSchemaVersions(ctx, host)
+		Ω(tracker.Entries(nil)[0].Failures).Should(Equal(3.0))
+	})
+
+	ginkgo.It("should serve health of datanodes in debug handler", func() {
+		host0, host1 := topology.NewHost("host0", "host0:9374"), topology.NewHost("host1", "host1:9374")
+		mockMap := &topoMock.Map{}
+		mockMap.On("Hosts").Return([]topology.Host{host1, host0})
+		mockTopo := &topoMock.Topology{}
+		mockTopo.On("Get").Return(mockMap)
+		tracker.Record("host1", unavailable)
+		tracker.Record("host1", unavailable)
+
+		handler := NewDebugHandler(nil, nil, mockTopo, nil, nil, nil, tracker)
+		w := httptest.NewRecorder()
+		handler.GetHostHealth(w, httptest.NewRequest(http.MethodGet, "/debug/hosts/health", nil))
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(MatchJSON(`[
+			{"host": "host0", "status": "healthy", "successes": 0, "failures": 0},
+			{"host": "host1", "status": "down", "successes": 0, "failures": 2}
+		]`))
+	})
+})
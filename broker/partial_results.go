@@ -0,0 +1,136 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/utils"
+)
+
+// partialResults tracks datanodes failed by queries returning partial results, results of datanodes
+// succeeded are returned as long as any datanode succeeds.
+type partialResults struct {
+	sync.Mutex
+	// number of shards of the cluster, including shards skipped without hosts.
+	numShards int
+	// number of shards skipped without hosts.
+	numUnassigned int
+	// number of shards queried from hosts by host ids.
+	hostShards  map[string]int
+	failedHosts map[string]bool
+	hostErrors  []utils.HostError
+}
+
+// newPartialResults creates the tracker of datanodes failed by the query of the shard assignment.
+func newPartialResults(assignment map[topology.Host][]uint32, unassigned []uint32) *partialResults {
+	p := &partialResults{
+		numShards:     len(unassigned),
+		numUnassigned: len(unassigned),
+		hostShards:    make(map[string]int, len(assignment)),
+		failedHosts:   make(map[string]bool),
+	}
+	for host, shards := range assignment {
+		p.numShards += len(shards)
+		p.hostShards[host.ID()] += len(shards)
+	}
+	return p
+}
+
+// tolerate records datanodes failed by the error and tells whether the error is tolerated. Only failures
+// of datanodes are tolerated, schema mismatches are not, so that the query can be retried.
+func (p *partialResults) tolerate(err error) bool {
+	codedErr, ok := err.(*utils.CodedError)
+	if p == nil || !ok || codedErr.Code != utils.ErrCodeDataNodeFailure || len(codedErr.HostErrors) == 0 ||
+		isSchemaMismatch(err) {
+		return false
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, hostErr := range codedErr.HostErrors {
+		if !p.failedHosts[hostErr.Host] {
+			p.failedHosts[hostErr.Host] = true
+			p.hostErrors = append(p.hostErrors, hostErr)
+		}
+	}
+	return true
+}
+
+// errors returns errors of failed datanodes sorted by host, nil if none failed.
+func (p *partialResults) errors() *apiCom.PartialErrorsV2 {
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if len(p.hostErrors) == 0 {
+		return nil
+	}
+
+	hostErrors := append([]utils.HostError(nil), p.hostErrors...)
+	sort.Slice(hostErrors, func(i, j int) bool {
+		return hostErrors[i].Host < hostErrors[j].Host
+	})
+	covered := p.numShards - p.numUnassigned
+	for host := range p.failedHosts {
+		covered -= p.hostShards[host]
+	}
+	partialErrors := &apiCom.PartialErrorsV2{Hosts: hostErrors}
+	if p.numShards > 0 {
+		partialErrors.ShardCoverage = float64(covered) / float64(p.numShards)
+	}
+	return partialErrors
+}
+
+// partialErrorsWarning returns the warning of partial results without failed datanodes.
+func partialErrorsWarning(partialErrors *apiCom.PartialErrorsV2) string {
+	hosts := make([]string, len(partialErrors.Hosts))
+	for i, hostErr := range partialErrors.Hosts {
+		hosts[i] = hostErr.Host
+	}
+	return fmt.Sprintf("partial results without failed hosts %v, covering %.2f%% of shards", hosts,
+		partialErrors.ShardCoverage*100)
+}
+
+type partialErrorsKey struct{}
+
+// partialErrorsHolder holds errors of datanodes failed by the query returning partial results.
+type partialErrorsHolder struct {
+	errors *apiCom.PartialErrorsV2
+}
+
+// withPartialErrors returns a context to collect errors of datanodes failed by the query executed with it.
+func withPartialErrors(ctx context.Context) (context.Context, *partialErrorsHolder) {
+	holder := &partialErrorsHolder{}
+	return context.WithValue(ctx, partialErrorsKey{}, holder), holder
+}
+
+// collectPartialErrors collects errors of datanodes failed by the query into the context, and adds the
+// warning of partial results to the query.
+func collectPartialErrors(ctx context.Context, qc *QueryContext) {
+	partialErrors := qc.partial.errors()
+	if partialErrors == nil {
+		return
+	}
+	qc.Warnings = append(qc.Warnings, partialErrorsWarning(partialErrors))
+	if holder, ok := ctx.Value(partialErrorsKey{}).(*partialErrorsHolder); ok {
+		holder.errors = partialErrors
+	}
+}
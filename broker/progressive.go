@@ -0,0 +1,117 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+
+	apiCom "github.com/uber/aresdb/api/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// progressiveRecord is a newline delimited json record of progressive results.
+type progressiveRecord struct {
+	// whether the result is fully merged, only the last record of the response is complete.
+	Complete bool                    `json:"complete"`
+	Result   queryCom.AQLQueryResult `json:"result"`
+	// warnings of partial results, only in the complete record since headers are written with the first record.
+	Warnings []string `json:"warnings,omitempty"`
+	// error of the query failed after records are written, in the complete record without result.
+	Error *apiCom.QueryErrorV2 `json:"error,omitempty"`
+}
+
+// progressiveResults streams results of aggregation queries merged so far as newline delimited json records,
+// one record every interval results of datanodes merged, followed by the complete record of the fully merged
+// result, which is the same as the result of the query not streamed. Records of partial results are filled and
+// truncated the same way as the complete result, without changing the result being merged.
+type progressiveResults struct {
+	w        http.ResponseWriter
+	interval int
+	// fill of missing top level time buckets, nil if not filled.
+	fill *timeBucketFill
+	// truncates results of queries with limits sorted by the measure, nil if not needed.
+	topN *topNOption
+	// number of results of datanodes merged so far.
+	merged int
+	// whether any record is written, the query can not be responded with an error once written.
+	written bool
+}
+
+func newProgressiveResults(w http.ResponseWriter, interval int) *progressiveResults {
+	return &progressiveResults{
+		w:        w,
+		interval: interval,
+	}
+}
+
+// add counts the result of a datanode merged into the accumulated result, and writes the partial result every
+// interval results merged, unless no result remains and the complete record follows.
+func (p *progressiveResults) add(accumulated queryCom.AQLQueryResult, remaining int) error {
+	p.merged++
+	if p.merged%p.interval != 0 || remaining == 0 {
+		return nil
+	}
+	partial := accumulated
+	if p.fill != nil {
+		// buckets are filled in a copy of the top level, so that they are not merged with results to come.
+		partial = make(queryCom.AQLQueryResult, len(accumulated))
+		for key, value := range accumulated {
+			partial[key] = value
+		}
+		partial = p.fill.apply(partial)
+	}
+	if p.topN != nil {
+		partial = truncateTopN(partial, p.topN)
+	}
+	return p.write(progressiveRecord{Result: partial})
+}
+
+// complete writes the complete record of the fully merged result with warnings of partial results.
+func (p *progressiveResults) complete(result queryCom.AQLQueryResult, warnings []string) error {
+	return p.write(progressiveRecord{Complete: true, Result: result, Warnings: warnings})
+}
+
+// fail writes the complete record of the error of the query if any record is written, otherwise nothing is
+// written and the error is responded instead.
+func (p *progressiveResults) fail(err error) {
+	if !p.written {
+		return
+	}
+	p.write(progressiveRecord{Complete: true, Error: apiCom.NewQueryErrorV2(err)})
+}
+
+// write writes and flushes the record, with headers of newline delimited json before the first record.
+func (p *progressiveResults) write(record progressiveRecord) error {
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if !p.written {
+		p.written = true
+		header := p.w.Header()
+		header.Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeNDJSON)
+		header.Set(utils.HTTPAccelBufferingHeaderKey, "no")
+		p.w.WriteHeader(http.StatusOK)
+	}
+	if _, err = p.w.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	if flusher, ok := p.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
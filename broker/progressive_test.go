@@ -0,0 +1,91 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("progressive results", func() {
+	readRecords := func(w *httptest.ResponseRecorder) (records []progressiveRecord) {
+		for _, line := range strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n") {
+			var record progressiveRecord
+			Ω(json.Unmarshal([]byte(line), &record)).Should(BeNil())
+			records = append(records, record)
+		}
+		return
+	}
+
+	ginkgo.It("should write partial results every interval results merged", func() {
+		w := httptest.NewRecorder()
+		progress := newProgressiveResults(w, 2)
+		progress.fill = newTimeBucketFill(&queryCom.AQLQuery{
+			Dimensions:      []queryCom.Dimension{{TimeBucketizer: "hour"}},
+			TimeFilter:      queryCom.TimeFilter{From: "2019-10-01 01", To: "2019-10-01 02"},
+			FillTimeBuckets: true,
+		}, common.Count)
+
+		accumulated := queryCom.AQLQueryResult{"2019-10-01 01:00": 1.0}
+		Ω(progress.add(accumulated, 4)).Should(BeNil())
+		Ω(progress.written).Should(BeFalse())
+		Ω(progress.add(accumulated, 3)).Should(BeNil())
+		Ω(progress.written).Should(BeTrue())
+		// buckets are only filled in partial results.
+		Ω(accumulated).Should(HaveLen(1))
+		Ω(progress.add(accumulated, 2)).Should(BeNil())
+		// no partial result right before the complete record.
+		Ω(progress.add(accumulated, 0)).Should(BeNil())
+		Ω(progress.complete(queryCom.AQLQueryResult{"2019-10-01 01:00": 3.0}, []string{"partial"})).Should(BeNil())
+
+		Ω(w.Header().Get(utils.HTTPContentTypeHeaderKey)).Should(Equal(utils.HTTPContentTypeNDJSON))
+		Ω(w.Flushed).Should(BeTrue())
+		Ω(readRecords(w)).Should(Equal([]progressiveRecord{
+			{Result: queryCom.AQLQueryResult{"2019-10-01 01:00": 1.0, "2019-10-01 02:00": 0.0}},
+			{Complete: true, Result: queryCom.AQLQueryResult{"2019-10-01 01:00": 3.0}, Warnings: []string{"partial"}},
+		}))
+	})
+
+	ginkgo.It("should truncate partial results by limits", func() {
+		w := httptest.NewRecorder()
+		progress := newProgressiveResults(w, 1)
+		progress.topN = &topNOption{limit: 1, descending: true}
+		Ω(progress.add(queryCom.AQLQueryResult{"1": 1.0, "2": 2.0}, 1)).Should(BeNil())
+		Ω(readRecords(w)).Should(Equal([]progressiveRecord{{Result: queryCom.AQLQueryResult{"2": 2.0}}}))
+	})
+
+	ginkgo.It("should end with the error once records are written", func() {
+		w := httptest.NewRecorder()
+		progress := newProgressiveResults(w, 1)
+		progress.fail(utils.NewCodedError(utils.ErrCodeTimeout, nil, "timed out"))
+		Ω(w.Body.Len()).Should(BeZero())
+
+		Ω(progress.add(queryCom.AQLQueryResult{"1": 1.0}, 1)).Should(BeNil())
+		progress.fail(utils.NewCodedError(utils.ErrCodeTimeout, nil, "timed out"))
+		records := readRecords(w)
+		Ω(records).Should(HaveLen(2))
+		Ω(records[1].Complete).Should(BeTrue())
+		Ω(records[1].Result).Should(BeNil())
+		Ω(records[1].Error.Code).Should(Equal(utils.ErrCodeTimeout))
+		Ω(records[1].Error.Retriable).Should(BeTrue())
+	})
+})
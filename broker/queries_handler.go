@@ -0,0 +1,93 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	apiCom "github.com/uber/aresdb/api/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// RunningQueryInfo is a query running on broker listed for operators.
+type RunningQueryInfo struct {
+	ID            string    `json:"id"`
+	User          string    `json:"user"`
+	StartTime     time.Time `json:"startTime"`
+	ElapsedMillis int64     `json:"elapsedMillis"`
+	Phase         string    `json:"phase"`
+	// the aql query as submitted.
+	Query json.RawMessage `json:"query,omitempty"`
+}
+
+// QueriesHandler lists and cancels queries running on broker by ids assigned to them.
+type QueriesHandler struct {
+	registry *queryCom.QueryRegistry
+}
+
+// NewQueriesHandler creates a new QueriesHandler
+func NewQueriesHandler(registry *queryCom.QueryRegistry) QueriesHandler {
+	return QueriesHandler{registry: registry}
+}
+
+// Register registers http handlers.
+func (handler *QueriesHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/queries", utils.ApplyHTTPWrappers(handler.ListQueries, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/queries/{id}", utils.ApplyHTTPWrappers(handler.CancelQuery, wrappers)).Methods(http.MethodDelete)
+}
+
+// ListQueries lists running queries sorted by elapsed time, longest first.
+func (handler *QueriesHandler) ListQueries(w http.ResponseWriter, r *http.Request) {
+	running := handler.registry.List(false).Running
+	queries := make([]RunningQueryInfo, len(running))
+	for i, snapshot := range running {
+		queries[i] = newRunningQueryInfo(snapshot)
+	}
+	apiCom.RespondWithJSONObject(w, queries)
+}
+
+// CancelQuery cancels the running query of the id, the query fails with ErrCodeCanceled and its requests to
+// datanodes are canceled.
+func (handler *QueriesHandler) CancelQuery(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	query := handler.registry.Cancel(id)
+	if query == nil {
+		apiCom.RespondWithError(w, utils.APIError{
+			Code:    http.StatusNotFound,
+			Message: "query not running: " + id,
+		})
+		return
+	}
+	utils.GetLogger().With("id", id, "caller", utils.GetOrigin(r)).Info("Canceled query")
+	apiCom.RespondWithJSONObject(w, newRunningQueryInfo(query.Snapshot()))
+}
+
+func newRunningQueryInfo(snapshot queryCom.RunningQuerySnapshot) RunningQueryInfo {
+	info := RunningQueryInfo{
+		ID:            snapshot.ID,
+		User:          snapshot.Caller,
+		StartTime:     snapshot.StartTime,
+		ElapsedMillis: snapshot.ElapsedMillis,
+		Phase:         snapshot.Phase,
+	}
+	if snapshot.Query != "" {
+		info.Query = json.RawMessage(snapshot.Query)
+	}
+	return info
+}
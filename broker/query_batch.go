@@ -0,0 +1,284 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+type queryBatchKey struct{}
+
+// queryBatchValue is the batch of a query and the index of the query in the batch.
+type queryBatchValue struct {
+	batch *queryBatch
+	index int
+}
+
+// queryBatch shares requests to datanodes among aggregation queries of a batch request. First trials of scans
+// of all queries to the same datanode are sent in a single request, once every query of the batch has built
+// its plan or finished. Other queries to datanodes, e.g. retries, hedged and hll queries, are sent as is.
+type queryBatch struct {
+	sync.Mutex
+	// context of the batch request, batched requests to datanodes are sent with it.
+	ctx context.Context
+	// number of queries neither planned nor finished, batches are held until it drops to 0.
+	pending int
+	queries []batchQuery
+	// batches to send by host id.
+	hosts map[string]*hostBatch
+	// number of queries finished, the admission of the batch is released once all queries finished.
+	finished  int
+	admitOnce sync.Once
+	admitErr  error
+	release   func()
+}
+
+// batchQuery tracks queries to datanodes expected from the plan of a query in the batch.
+type batchQuery struct {
+	ready bool
+	// number of queries to datanodes expected and received by host id.
+	expected map[string]int
+	received map[string]int
+}
+
+// hostBatch is the queries to a datanode waiting to be sent together.
+type hostBatch struct {
+	host   topology.Host
+	client dataCli.DataNodeQueryClient
+	// number of queries expected before the batch is sent, including the received calls.
+	expected int
+	calls    []*batchedCall
+}
+
+// batchedCall is a query to a datanode waiting for the result of its batch.
+type batchedCall struct {
+	query  queryCom.AQLQuery
+	done   chan struct{}
+	result queryCom.AQLQueryResult
+	err    error
+}
+
+// newQueryBatch creates the batch of the number of queries, ctx is the context of the batch request.
+func newQueryBatch(ctx context.Context, numQueries int) *queryBatch {
+	b := &queryBatch{
+		ctx:     ctx,
+		pending: numQueries,
+		queries: make([]batchQuery, numQueries),
+		hosts:   make(map[string]*hostBatch),
+	}
+	for i := range b.queries {
+		b.queries[i].expected = make(map[string]int)
+		b.queries[i].received = make(map[string]int)
+	}
+	return b
+}
+
+// withQueryBatch returns the context of the query of the index in the batch.
+func withQueryBatch(ctx context.Context, batch *queryBatch, index int) context.Context {
+	return context.WithValue(ctx, queryBatchKey{}, queryBatchValue{batch: batch, index: index})
+}
+
+// getQueryBatch returns the batch of the query and the index of the query in it, nil if the query is not
+// part of a batch.
+func getQueryBatch(ctx context.Context) (*queryBatch, int) {
+	value, _ := ctx.Value(queryBatchKey{}).(queryBatchValue)
+	return value.batch, value.index
+}
+
+// client returns the client sending aggregation queries of client in batches, or client itself if there is
+// no batch.
+func (b *queryBatch) client(client dataCli.DataNodeQueryClient) dataCli.DataNodeQueryClient {
+	if b == nil {
+		return client
+	}
+	return &batchingClient{DataNodeQueryClient: client, batch: b}
+}
+
+// expect marks the query of the index ready, with queries to datanodes expected from first trials of scans of
+// the plan. Plans built again for retries are not expected.
+func (b *queryBatch) expect(index int, plan *AggQueryPlan) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	q := &b.queries[index]
+	if q.ready {
+		return
+	}
+	if plan != nil {
+		expectScans(plan.root, func(host topology.Host) {
+			q.expected[host.ID()]++
+			b.hostBatch(host).expected++
+		})
+	}
+	q.ready = true
+	b.pending--
+	b.flush()
+}
+
+// ready marks the query of the index ready without any queries to datanodes expected, e.g. non aggregation
+// queries.
+func (b *queryBatch) ready(index int) {
+	b.expect(index, nil)
+}
+
+// done releases queries to datanodes expected from the finished query but never received, e.g. scans canceled
+// by failures of the query.
+func (b *queryBatch) done(index int) {
+	b.Lock()
+	defer b.Unlock()
+	q := &b.queries[index]
+	for hostID, expected := range q.expected {
+		b.hosts[hostID].expected -= expected - q.received[hostID]
+		q.expected[hostID] = q.received[hostID]
+	}
+	if !q.ready {
+		q.ready = true
+		b.pending--
+	}
+	b.flush()
+	if b.finished++; b.finished == len(b.queries) && b.release != nil {
+		b.release()
+	}
+}
+
+// admit admits all queries of the batch as a single query by admit, which is called once by the first query
+// while other queries wait for its result. The admission is released once all queries finished.
+func (b *queryBatch) admit(admit func() (release func(), err error)) error {
+	b.admitOnce.Do(func() {
+		release, err := admit()
+		b.Lock()
+		b.release, b.admitErr = release, err
+		b.Unlock()
+	})
+	return b.admitErr
+}
+
+// query sends the query to the host with the batch if expected, otherwise by the client directly.
+func (b *queryBatch) query(ctx context.Context, client dataCli.DataNodeQueryClient, host topology.Host, query queryCom.AQLQuery) (queryCom.AQLQueryResult, error) {
+	_, index := getQueryBatch(ctx)
+	hostID := host.ID()
+	b.Lock()
+	q := &b.queries[index]
+	if q.received[hostID] >= q.expected[hostID] {
+		b.Unlock()
+		return client.Query(ctx, host, query, false)
+	}
+	q.received[hostID]++
+	call := &batchedCall{query: query, done: make(chan struct{})}
+	hb := b.hostBatch(host)
+	hb.client = client
+	hb.calls = append(hb.calls, call)
+	b.flush()
+	b.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// hostBatch returns the batch of the host, the lock must be held.
+func (b *queryBatch) hostBatch(host topology.Host) *hostBatch {
+	hb := b.hosts[host.ID()]
+	if hb == nil {
+		hb = &hostBatch{host: host}
+		b.hosts[host.ID()] = hb
+	}
+	return hb
+}
+
+// flush sends batches of hosts with all expected queries received once all queries are ready, the lock must
+// be held.
+func (b *queryBatch) flush() {
+	if b.pending > 0 {
+		return
+	}
+	for _, hb := range b.hosts {
+		if len(hb.calls) == 0 || len(hb.calls) < hb.expected {
+			continue
+		}
+		calls := hb.calls
+		hb.calls = nil
+		hb.expected -= len(calls)
+		go b.send(hb.host, hb.client, calls)
+	}
+}
+
+// send sends the calls to the host in a single request, failures of the request fail all calls.
+func (b *queryBatch) send(host topology.Host, client dataCli.DataNodeQueryClient, calls []*batchedCall) {
+	queries := make([]queryCom.AQLQuery, len(calls))
+	for i, call := range calls {
+		queries[i] = call.query
+	}
+	results, errs, err := client.QueryBatch(b.ctx, host, queries)
+	for i, call := range calls {
+		if err != nil {
+			call.err = err
+		} else {
+			if i < len(results) {
+				call.result = results[i]
+			}
+			if i < len(errs) {
+				call.err = errs[i]
+			}
+		}
+		close(call.done)
+	}
+}
+
+// expectScans calls expect with hosts queried by first trials of non hll scans of the plan node.
+func expectScans(node common.BlockingPlanNode, expect func(host topology.Host)) {
+	if scanNode, ok := node.(*BlockingScanNode); ok {
+		if scanNode.isHll() {
+			return
+		}
+		if scanNode.required == 0 {
+			expect(scanNode.host)
+			return
+		}
+		// consistent reads query all replicas at once.
+		for _, replica := range scanNode.replicas {
+			expect(replica)
+		}
+		return
+	}
+	for _, child := range node.Children() {
+		expectScans(child, expect)
+	}
+}
+
+// batchingClient is a DataNodeQueryClient sending non hll aggregation queries with the batch.
+type batchingClient struct {
+	dataCli.DataNodeQueryClient
+	batch *queryBatch
+}
+
+// Query sends the query with the batch, or directly for hll queries.
+func (c *batchingClient) Query(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) (queryCom.AQLQueryResult, error) {
+	if hll {
+		return c.DataNodeQueryClient.Query(ctx, host, query, hll)
+	}
+	return c.batch.query(ctx, c.DataNodeQueryClient, host, query)
+}
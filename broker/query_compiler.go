@@ -16,11 +16,14 @@ package broker
 
 import (
 	"fmt"
+	brokerCom "github.com/uber/aresdb/broker/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
@@ -34,17 +37,76 @@ type QueryContext struct {
 	Writer                http.ResponseWriter
 	Error                 error
 	MainTable             *metaCom.Table
+	// ids of hosts excluded from the query, e.g. hosts with stale schema.
+	ExcludedHosts map[string]bool
+	// warnings of partial results returned to the caller.
+	Warnings []string
+	// hll sketches are unioned and exported by broker instead of datanodes.
+	HLLSketch *common.HLLSketchOption
+	// duplicate rows of non aggregation queries across datanodes are dropped, paginated queries excluded.
+	DedupRows bool
+	// main table and join tables resolved by Compile.
+	tables []*metaCom.Table
+	// main table and join tables resolved by Compile by their aliases in the query.
+	tablesByAlias map[string]*metaCom.Table
+	// max number of distinct values of a bucket of countdistinct queries, 0 means the default.
+	maxDistinctValues int
+	// quantiles of percentile queries in order.
+	quantiles []float64
+	// max bytes of hashes of rows tracked to drop duplicate rows, 0 means the default.
+	maxDedupBytes int
+	// max bytes of rows of datanodes buffered by sorted non aggregation queries, 0 means the default.
+	maxSortBytes int
+	// whether results of datanodes succeeded are returned when other datanodes fail, the default of broker
+	// overridden by the query.
+	allowPartialResults bool
+	// datanodes failed by queries returning partial results, set with shard assignments.
+	partial *partialResults
+	// deadline of the query shared by retries, zero for no deadline.
+	deadline time.Time
+	// how long calls to datanodes can run, 0 means calls are only bounded by the query.
+	dataNodeTimeout time.Duration
+	// sorts of rows of non aggregation queries merged across datanodes by broker.
+	sorts []rowSort
+	// hedges queries of slow datanodes to replicas, nil if not enabled.
+	hedger *hedger
+	// key of results of the aggregation query in the result cache, empty if not cached.
+	resultCacheKey string
+	// seed of shard assignments spreading shards over replicas, 0 to assign shards in the order of the topology.
+	assignmentSeed int64
+	// consistency level of reads of shards, the default of broker overridden by the query.
+	consistency ConsistencyLevel
+	// how long scans failed with schema mismatches wait for datanodes to refresh schemas before retries.
+	schemaRetryDelay time.Duration
+	// fails queries of datanodes with open circuit breakers right away, nil if not enabled.
+	breaker *CircuitBreaker
+	// assigns shards to the healthiest replicas, nil if not enabled.
+	healthTracker *HealthTracker
+	// skips retries of failed scans once exhausted, nil if not enabled.
+	retryBudget *RetryBudget
+	// streams partial results of the aggregation query as they are merged, nil if not progressive.
+	progress *progressiveResults
 }
 
 // NewQueryContext creates new query context
 func NewQueryContext(aql *common.AQLQuery, w http.ResponseWriter) *QueryContext {
 	ctx := QueryContext{
-		AQLQuery: aql,
-		Writer:   w,
+		AQLQuery:  aql,
+		Writer:    w,
+		DedupRows: aql.DedupRows,
 	}
 	return &ctx
 }
 
+// tableVersions returns schema versions of the main table and join tables resolved by Compile.
+func (c *QueryContext) tableVersions() map[string]metaCom.TableSchemaVersion {
+	versions := make(map[string]metaCom.TableSchemaVersion)
+	for _, table := range c.tables {
+		versions[table.Name] = metaCom.TableSchemaVersion{Incarnation: table.Incarnation, Version: table.Version}
+	}
+	return versions
+}
+
 // Compile sql to AQL and extract information for routing
 func (c *QueryContext) Compile(schemaReader metaCom.TableSchemaReader) {
 
@@ -56,21 +118,225 @@ func (c *QueryContext) Compile(schemaReader metaCom.TableSchemaReader) {
 		c.Error = utils.StackError(err, fmt.Sprintf("err finding main table %s", mainTableName))
 		return
 	}
+	tablesByAlias := map[string]*metaCom.Table{mainTableName: c.MainTable}
+	c.tables = append(c.tables, c.MainTable)
 	// validate foreign table names
 	for _, join := range c.AQLQuery.Joins {
-		_, err = schemaReader.GetTable(join.Table)
+		var joinTable *metaCom.Table
+		joinTable, err = schemaReader.GetTable(join.Table)
 		if err != nil {
 			c.Error = utils.StackError(err, fmt.Sprintf("err finding join table %s", join.Table))
 			return
 		}
+		c.tables = append(c.tables, joinTable)
+		alias := join.Alias
+		if alias == "" {
+			alias = join.Table
+		}
+		tablesByAlias[alias] = joinTable
+	}
+
+	c.tablesByAlias = tablesByAlias
+
+	c.checkColumnAccess(tablesByAlias)
+	if c.Error != nil {
+		return
 	}
 
+	c.processTimeRange()
+
 	c.processMeasures()
 	c.processDimensions()
+	if c.Error != nil {
+		return
+	}
+
+	c.processHLLSketch()
+	if c.Error != nil {
+		return
+	}
 
+	c.processHLLBinary()
+	if c.Error != nil {
+		return
+	}
+
+	c.processPagination()
+	if c.Error != nil {
+		return
+	}
+
+	c.processSorts()
+	if c.Error != nil {
+		return
+	}
+
+	c.processPartialResults()
+	if c.Error != nil {
+		return
+	}
+
+	c.processProgressive()
 	return
 }
 
+// checkColumnAccess rejects the query listing all referenced columns the caller is not allowed to
+// query, the denied attempt is logged for auditing. Columns failed to be resolved are left to data
+// nodes to report.
+func (c *QueryContext) checkColumnAccess(tablesByAlias map[string]*metaCom.Table) {
+	var forbiddenColumns []string
+	checkColumn := func(identifier string) {
+		tableAlias, columnName := c.AQLQuery.Table, identifier
+		if segments := strings.SplitN(identifier, ".", 2); len(segments) == 2 {
+			tableAlias, columnName = segments[0], segments[1]
+		}
+		table := tablesByAlias[tableAlias]
+		if table == nil {
+			return
+		}
+		for _, column := range table.Columns {
+			if column.Deleted || !columnHasName(column, columnName) || column.IsAccessibleBy(c.AQLQuery.CallerRoles) {
+				continue
+			}
+			forbiddenColumn := fmt.Sprintf("%s.%s", table.Name, column.Name)
+			for _, existing := range forbiddenColumns {
+				if existing == forbiddenColumn {
+					return
+				}
+			}
+			forbiddenColumns = append(forbiddenColumns, forbiddenColumn)
+			return
+		}
+	}
+	checkExpr := func(sqlExpr string) {
+		parsedExpr, err := expr.ParseExpr(sqlExpr)
+		if err != nil {
+			return
+		}
+		expr.WalkFunc(parsedExpr, func(e expr.Expr) {
+			if varRef, ok := e.(*expr.VarRef); ok {
+				checkColumn(varRef.Val)
+			}
+		})
+	}
+
+	for _, join := range c.AQLQuery.Joins {
+		for _, cond := range join.Conditions {
+			checkExpr(cond)
+		}
+	}
+	for _, dim := range c.AQLQuery.Dimensions {
+		checkExpr(dim.Expr)
+	}
+	for _, measure := range c.AQLQuery.Measures {
+		checkExpr(measure.Expr)
+		for _, filter := range measure.Filters {
+			checkExpr(filter)
+		}
+	}
+	for _, filter := range c.AQLQuery.Filters {
+		checkExpr(filter)
+	}
+	if c.AQLQuery.TimeFilter.Column != "" {
+		checkColumn(c.AQLQuery.TimeFilter.Column)
+	}
+
+	if len(forbiddenColumns) == 0 {
+		return
+	}
+	utils.GetLogger().With(
+		"caller", c.AQLQuery.Caller,
+		"roles", c.AQLQuery.CallerRoles,
+		"table", c.AQLQuery.Table,
+		"forbiddenColumns", forbiddenColumns,
+	).Warn("Denied query of restricted columns")
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": c.AQLQuery.Table,
+	}, utils.QueryColumnAccessDenied).Inc(1)
+	c.Error = utils.StackError(nil, "not allowed to query columns: %s", strings.Join(forbiddenColumns, ", "))
+}
+
+// processTimeRange turns range predicates of sql queries on the time column of the fact table into the time
+// filter, like aql_time_filter does, so that sql and equivalent aql queries are planned the same. The first
+// '>=' predicate is the start and the first '<' predicate on an epoch is the end, others are left as row
+// filters like datanodes do. '<' predicates on time strings are kept as row filters since the time filter
+// includes the whole unit of its end, e.g. all of the day for '2019-10-03'.
+func (c *QueryContext) processTimeRange() {
+	if c.AQLQuery.SQLQuery == "" || c.AQLQuery.TimeFilter != (common.TimeFilter{}) || c.MainTable == nil ||
+		!c.MainTable.IsFactTable || len(c.MainTable.Columns) == 0 {
+		return
+	}
+	timeColumn := c.MainTable.Columns[0]
+	var timeFilter common.TimeFilter
+	var filters []string
+	for _, filter := range c.AQLQuery.Filters {
+		op, bound := c.parseTimeRangePredicate(filter, timeColumn)
+		switch {
+		case op == expr.GTE && timeFilter.From == "":
+			timeFilter.From = bound
+		case op == expr.LT && timeFilter.To == "":
+			timeFilter.To = bound
+		default:
+			filters = append(filters, filter)
+		}
+	}
+	if timeFilter.From == "" && timeFilter.To == "" {
+		return
+	}
+	timeFilter.Column = timeColumn.Name
+	c.AQLQuery.TimeFilter = timeFilter
+	c.AQLQuery.Filters = filters
+}
+
+// parseTimeRangePredicate returns the operator and the bound of the filter comparing the time column with a
+// number or string literal, expr.ILLEGAL if the filter is not such a predicate or it's a '<' predicate on a
+// string literal, which cannot be folded into the end of the time filter.
+func (c *QueryContext) parseTimeRangePredicate(filter string, timeColumn metaCom.Column) (op expr.Token, bound string) {
+	parsedExpr, err := expr.ParseExpr(filter)
+	if err != nil {
+		return
+	}
+	binary, ok := parsedExpr.(*expr.BinaryExpr)
+	if !ok || (binary.Op != expr.GTE && binary.Op != expr.LT) {
+		return
+	}
+	lhs, ok := binary.LHS.(*expr.VarRef)
+	if !ok {
+		return
+	}
+	columnName := strings.TrimPrefix(lhs.Val, c.AQLQuery.Table+".")
+	if !columnHasName(timeColumn, columnName) {
+		return
+	}
+	switch rhs := binary.RHS.(type) {
+	case *expr.NumberLiteral:
+		bound = rhs.String()
+	case *expr.StringLiteral:
+		if binary.Op == expr.LT {
+			return
+		}
+		bound = rhs.Val
+	}
+	if bound == "" {
+		return
+	}
+	return binary.Op, bound
+}
+
+// columnHasName checks whether the column is referenced by the name or its unexpired old names.
+func columnHasName(column metaCom.Column, name string) bool {
+	if column.Name == name {
+		return true
+	}
+	now := utils.Now().Unix()
+	for _, alias := range column.Aliases {
+		if alias.Name == name && !alias.IsExpired(now) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *QueryContext) processMeasures() {
 	var err error
 
@@ -83,19 +349,21 @@ func (c *QueryContext) processMeasures() {
 		}
 		c.AQLQuery.Measures[i] = measure
 	}
+	if len(c.AQLQuery.Measures) == 0 {
+		c.Error = utils.StackError(nil, "expect at least one measure per query")
+		return
+	}
 	utils.GetLogger().Debug(c.AQLQuery.Measures[0].ExprParsed)
 
-	// ony support 1 measure for now
-	if len(c.AQLQuery.Measures) != 1 {
-		c.Error = utils.StackError(nil, "expect one measure per query, but got %d",
-			len(c.AQLQuery.Measures))
+	if len(c.AQLQuery.Measures) > 1 {
+		c.processMultipleMeasures()
 		return
 	}
 
 	if _, ok := c.AQLQuery.Measures[0].ExprParsed.(*expr.NumberLiteral); ok {
 		c.IsNonAggregationQuery = true
-		// in case user forgot to provide limit
-		if c.AQLQuery.Limit == 0 {
+		// in case user forgot to provide limit, paginated queries are limited by pages instead.
+		if c.AQLQuery.Limit == 0 && c.AQLQuery.PageSize == 0 {
 			c.AQLQuery.Limit = nonAggregationQueryLimit
 		}
 		return
@@ -108,12 +376,309 @@ func (c *QueryContext) processMeasures() {
 		return
 	}
 
+	if brokerCom.CallNameToAggType[aggregate.Name] == brokerCom.Percentile {
+		c.processQuantiles(aggregate)
+		return
+	}
+
 	if len(aggregate.Args) != 1 {
 		c.Error = utils.StackError(nil,
 			"expect one parameter for aggregate function %s, but got %d",
 			aggregate.Name, len(aggregate.Args))
 		return
 	}
+
+	// distinct values are queried from datanodes as values of a dimension.
+	if _, ok := aggregate.Args[0].(*expr.Wildcard); ok && brokerCom.CallNameToAggType[aggregate.Name] == brokerCom.CountDistinct {
+		c.Error = utils.StackError(nil, "expect a column or expression for aggregate function %s, but got *", aggregate.Name)
+		return
+	}
+}
+
+// processQuantiles validates the percentile aggregation, whose first parameter is the column or expression
+// followed by quantiles in [0, 1].
+func (c *QueryContext) processQuantiles(aggregate *expr.Call) {
+	if len(aggregate.Args) < 2 {
+		c.Error = utils.StackError(nil,
+			"expect a column and quantiles for aggregate function %s, but got %d parameters",
+			aggregate.Name, len(aggregate.Args))
+		return
+	}
+	if _, ok := aggregate.Args[0].(*expr.Wildcard); ok {
+		c.Error = utils.StackError(nil, "expect a column or expression for aggregate function %s, but got *", aggregate.Name)
+		return
+	}
+	c.quantiles = make([]float64, len(aggregate.Args)-1)
+	for i, arg := range aggregate.Args[1:] {
+		literal, ok := arg.(*expr.NumberLiteral)
+		if !ok || literal.Val < 0 || literal.Val > 1 {
+			c.Error = utils.StackError(nil, "expect quantiles in [0, 1] for aggregate function %s, but got %s",
+				aggregate.Name, arg)
+			return
+		}
+		c.quantiles[i] = literal.Val
+	}
+}
+
+// processMultipleMeasures validates aggregation queries of multiple measures, which are queried from datanodes
+// one measure at a time and merged by broker as arrays of measure values in order. Only sum, count, min and
+// max are supported, since they are merged without splitting measures further.
+func (c *QueryContext) processMultipleMeasures() {
+	for _, measure := range c.AQLQuery.Measures {
+		aggregate, ok := measure.ExprParsed.(*expr.Call)
+		if !ok {
+			c.Error = utils.StackError(nil, "expect aggregate function for multiple measures, but got %s", measure.Expr)
+			return
+		}
+		if len(aggregate.Args) != 1 {
+			c.Error = utils.StackError(nil,
+				"expect one parameter for aggregate function %s, but got %d",
+				aggregate.Name, len(aggregate.Args))
+			return
+		}
+		agg, ok := brokerCom.CallNameToAggType[aggregate.Name]
+		if !ok || (agg != brokerCom.Sum && agg != brokerCom.Count && agg != brokerCom.Min && agg != brokerCom.Max) {
+			c.Error = utils.StackError(nil, "multiple measures only support sum, count, min and max, but got %s", measure.Expr)
+			return
+		}
+	}
+}
+
+// aggTypes returns aggregations of measures of the compiled aggregation query in order.
+func (c *QueryContext) aggTypes() []brokerCom.AggType {
+	aggTypes := make([]brokerCom.AggType, len(c.AQLQuery.Measures))
+	for i, measure := range c.AQLQuery.Measures {
+		aggTypes[i] = brokerCom.CallNameToAggType[measure.ExprParsed.(*expr.Call).Name]
+	}
+	return aggTypes
+}
+
+// processHLLSketch validates the hll sketch option and imports the sketches to union, the option is
+// removed from the query sent to datanodes.
+func (c *QueryContext) processHLLSketch() {
+	c.HLLSketch, c.AQLQuery.HLLSketch = c.AQLQuery.HLLSketch, nil
+	if c.HLLSketch == nil {
+		return
+	}
+
+	if c.IsNonAggregationQuery || c.AQLQuery.Measures[0].ExprParsed.(*expr.Call).Name != expr.HllCallName {
+		c.Error = utils.StackError(nil, "hll sketch is only supported by hll aggregation, but got %s",
+			c.AQLQuery.Measures[0].Expr)
+		return
+	}
+
+	if c.HLLSketch.Precision != 0 && (c.HLLSketch.Precision < common.MinStandardHLLPrecision ||
+		c.HLLSketch.Precision > metaCom.DefaultHLLPrecision) {
+		c.Error = utils.StackError(nil, "hll sketch precision %d not allowed, valid range: [%d, %d]",
+			c.HLLSketch.Precision, common.MinStandardHLLPrecision, metaCom.DefaultHLLPrecision)
+		return
+	}
+
+	if c.HLLSketch.RegisterWidth == 0 {
+		c.HLLSketch.RegisterWidth = common.DefaultStandardHLLRegisterWidth
+	} else if c.HLLSketch.RegisterWidth > common.MaxStandardHLLRegisterWidth {
+		c.Error = utils.StackError(nil, "hll sketch register width %d not allowed, valid range: [1, %d]",
+			c.HLLSketch.RegisterWidth, common.MaxStandardHLLRegisterWidth)
+		return
+	}
+
+	if c.HLLSketch.Union != nil {
+		var err error
+		if c.HLLSketch.Union, err = common.ImportStandardHLLResult(c.HLLSketch.Union); err != nil {
+			c.Error = utils.StackError(err, "invalid hll sketches to union")
+		}
+	}
+}
+
+// processHLLBinary rejects hll binary responses of queries other than hll aggregations, sketches are
+// returned as they are merged so they can not be exported by hll sketch options either.
+func (c *QueryContext) processHLLBinary() {
+	if !c.AQLQuery.HLLBinary {
+		return
+	}
+
+	if c.IsNonAggregationQuery || c.AQLQuery.Measures[0].ExprParsed.(*expr.Call).Name != expr.HllCallName {
+		c.Error = utils.StackError(nil, "hll binary responses are only supported by hll aggregation, but got %s",
+			c.AQLQuery.Measures[0].Expr)
+		return
+	}
+
+	if c.HLLSketch != nil {
+		c.Error = utils.StackError(nil, "hll binary responses are not supported with hll sketch options")
+	}
+}
+
+// processPagination validates pagination parameters of the query, offsets of datanodes are managed by
+// broker with cursors.
+// processPartialResults decides whether the query returns partial results, queries whose partial results
+// are misleading are rejected if partial results are requested by the query, or fall back to failing on
+// any datanode failure if allowed by the default of broker.
+func (c *QueryContext) processPartialResults() {
+	if c.AQLQuery.PartialResults != nil {
+		c.allowPartialResults = *c.AQLQuery.PartialResults
+	}
+	if !c.allowPartialResults {
+		return
+	}
+
+	var reason string
+	if c.AQLQuery.PageSize > 0 {
+		reason = "paginated queries, whose cursors resume from every datanode"
+	} else if !c.IsNonAggregationQuery {
+		for _, aggType := range c.aggTypes() {
+			switch aggType {
+			case brokerCom.Avg:
+				reason = "avg, whose sums and counts may miss different datanodes"
+			case brokerCom.CountDistinct:
+				reason = "exact countdistinct, use countdistincthll for approximate distinct counts"
+			}
+		}
+	}
+	if reason == "" {
+		return
+	}
+	c.allowPartialResults = false
+	if c.AQLQuery.PartialResults != nil {
+		c.Error = utils.StackError(nil, "partial results are not supported by %s", reason)
+	}
+}
+
+// processProgressive rejects progressive results of queries whose partial results can not be streamed as they
+// are merged, i.e. non aggregation queries and aggregations finalized once fully merged.
+func (c *QueryContext) processProgressive() {
+	if c.AQLQuery.ProgressiveInterval <= 0 {
+		return
+	}
+	if c.IsNonAggregationQuery {
+		c.Error = utils.StackError(nil, "progressive results are only supported by aggregation queries")
+		return
+	}
+	for _, aggType := range c.aggTypes() {
+		switch aggType {
+		case brokerCom.Sum, brokerCom.Count, brokerCom.Min, brokerCom.Max:
+		default:
+			c.Error = utils.StackError(nil, "progressive results are only supported by sum, count, min and max, "+
+				"but got %s", aggType)
+			return
+		}
+	}
+}
+
+func (c *QueryContext) processPagination() {
+	if c.AQLQuery.Offset != 0 {
+		switch {
+		case c.AQLQuery.Offset < 0:
+			c.Error = utils.StackError(nil, "offset must not be negative, but got %d", c.AQLQuery.Offset)
+		case !c.IsNonAggregationQuery:
+			c.Error = utils.StackError(nil, "offset is only supported by non aggregation queries")
+		case c.AQLQuery.PageSize != 0:
+			c.Error = utils.StackError(nil, "offset is not supported by paginated queries, use cursor to resume pages")
+		}
+		if c.Error != nil {
+			return
+		}
+	}
+
+	if c.AQLQuery.PageSize == 0 {
+		if c.AQLQuery.Cursor != "" {
+			c.Error = utils.StackError(nil, "cursor requires pageSize")
+		}
+		return
+	}
+
+	if !c.IsNonAggregationQuery {
+		c.Error = utils.StackError(nil, "pagination is only supported by non aggregation queries")
+		return
+	}
+
+	if c.AQLQuery.PageSize < 0 {
+		c.Error = utils.StackError(nil, "pageSize must be positive, but got %d", c.AQLQuery.PageSize)
+		return
+	}
+
+	if c.AQLQuery.Limit != 0 {
+		c.Error = utils.StackError(nil, "limit is not supported by paginated queries, but got %d", c.AQLQuery.Limit)
+	}
+}
+
+// processSorts resolves sorts of non aggregation queries to dimensions of rows, values of numeric columns
+// are compared as numbers. Sorts of aggregation queries are left to datanodes and result merging.
+func (c *QueryContext) processSorts() {
+	if !c.IsNonAggregationQuery || len(c.AQLQuery.Sorts) == 0 {
+		return
+	}
+
+	if c.AQLQuery.PageSize > 0 {
+		c.Error = utils.StackError(nil, "sorts are not supported by paginated queries")
+		return
+	}
+
+	for _, sortField := range c.AQLQuery.Sorts {
+		var descending bool
+		switch strings.ToLower(strings.TrimSpace(sortField.Order)) {
+		case "", "asc":
+		case "desc":
+			descending = true
+		default:
+			c.Error = utils.StackError(nil, "unknown sort order %s of field %s", sortField.Order, sortField.Name)
+			return
+		}
+
+		name := strings.TrimSpace(sortField.Name)
+		index := -1
+		for i, dim := range c.AQLQuery.Dimensions {
+			if name != "" && (name == dim.Alias || strings.EqualFold(name, strings.TrimSpace(dim.Expr))) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			c.Error = utils.StackError(nil, "sort field %s is not a dimension of the query", sortField.Name)
+			return
+		}
+
+		c.sorts = append(c.sorts, rowSort{
+			index:      index,
+			descending: descending,
+			numeric:    c.isNumericDimension(c.AQLQuery.Dimensions[index]),
+		})
+	}
+}
+
+// isNumericDimension checks whether values of the dimension are numbers, either numeric buckets or values
+// of numeric columns.
+func (c *QueryContext) isNumericDimension(dim common.Dimension) bool {
+	bucketizer := dim.NumericBucketizer
+	if bucketizer.BucketWidth != 0 || bucketizer.LogBase != 0 || len(bucketizer.ManualPartitions) > 0 {
+		return true
+	}
+	varRef, ok := dim.ExprParsed.(*expr.VarRef)
+	if !ok || dim.TimeBucketizer != "" {
+		return false
+	}
+
+	table, columnName := c.MainTable, varRef.Val
+	if segments := strings.SplitN(varRef.Val, ".", 2); len(segments) == 2 {
+		columnName = segments[1]
+		for i, join := range c.AQLQuery.Joins {
+			if segments[0] == join.Alias || (join.Alias == "" && segments[0] == join.Table) {
+				table = c.tables[i+1]
+				break
+			}
+		}
+	}
+	for _, column := range table.Columns {
+		if column.Deleted || !columnHasName(column, columnName) {
+			continue
+		}
+		switch column.Type {
+		case metaCom.Int8, metaCom.Uint8, metaCom.Int16, metaCom.Uint16, metaCom.Int32, metaCom.Uint32,
+			metaCom.Float32, metaCom.Int64:
+			return true
+		}
+		return false
+	}
+	return false
 }
 
 func (c *QueryContext) processDimensions() {
@@ -139,7 +704,8 @@ func (c *QueryContext) processDimensions() {
 func (c *QueryContext) getAllColumnsDimension() (columns []common.Dimension) {
 	// only main table columns wildcard match supported
 	for _, column := range c.MainTable.Columns {
-		if !column.Deleted && column.Type != metaCom.GeoShape {
+		if !column.Deleted && column.Type != metaCom.GeoShape && (!column.Deprecated || c.AQLQuery.IncludeDeprecatedColumns) &&
+			column.IsAccessibleBy(c.AQLQuery.CallerRoles) {
 			columns = append(columns, common.Dimension{
 				Expr: column.Name,
 			})
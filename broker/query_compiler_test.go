@@ -15,10 +15,12 @@
 package broker
 
 import (
+	"encoding/base64"
 	"errors"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	brokerCom "github.com/uber/aresdb/broker/common"
 	common2 "github.com/uber/aresdb/metastore/common"
 	metaMocks "github.com/uber/aresdb/metastore/mocks"
 	"github.com/uber/aresdb/query/common"
@@ -181,4 +183,423 @@ var _ = ginkgo.Describe("query compiler", func() {
 		qc.Compile(&mockMutator)
 		Ω(qc.Error).ShouldNot(BeNil())
 	})
+
+	ginkgo.It("should compile multiple measures of sum, count, min and max", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name:    "table1",
+			Columns: []common2.Column{{Name: "field1"}, {Name: "field2"}},
+		}, nil)
+		newQuery := func(measures ...string) *common.AQLQuery {
+			q := &common.AQLQuery{
+				Table:      "table1",
+				Dimensions: []common.Dimension{{Expr: "field1"}},
+			}
+			for _, measure := range measures {
+				q.Measures = append(q.Measures, common.Measure{Expr: measure})
+			}
+			return q
+		}
+
+		qc := NewQueryContext(newQuery("sum(field2)", "max(field2)", "min(field2)", "count(*)"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.IsNonAggregationQuery).Should(BeFalse())
+		Ω(qc.aggTypes()).Should(Equal([]brokerCom.AggType{brokerCom.Sum, brokerCom.Max, brokerCom.Min, brokerCom.Count}))
+
+		for _, measures := range [][]string{
+			{"sum(field2)", "avg(field2)"},
+			{"count(*)", "hll(field2)"},
+			{"count(*)", "1"},
+			{"count(*)", "sum(field1, field2)"},
+			{"count(*)", "countdistinct(field2)"},
+		} {
+			qc = NewQueryContext(newQuery(measures...), httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			Ω(qc.Error).ShouldNot(BeNil(), measures[1])
+		}
+
+		qc = NewQueryContext(newQuery("countDistinct(field2)"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.aggTypes()).Should(Equal([]brokerCom.AggType{brokerCom.CountDistinct}))
+
+		qc = NewQueryContext(newQuery("countdistinct(*)"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error.Error()).Should(ContainSubstring("expect a column or expression for aggregate function countdistinct"))
+
+		qc = NewQueryContext(newQuery("percentile(field2, 0.5, 0.99)"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.aggTypes()).Should(Equal([]brokerCom.AggType{brokerCom.Percentile}))
+		Ω(qc.quantiles).Should(Equal([]float64{0.5, 0.99}))
+
+		for _, measure := range []string{"percentile(field2)", "percentile(*, 0.5)", "percentile(field2, 1.5)", "percentile(field2, field1)"} {
+			qc = NewQueryContext(newQuery(measure), httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			Ω(qc.Error).ShouldNot(BeNil(), measure)
+		}
+	})
+
+	ginkgo.It("should reject columns the caller is not allowed to query", func() {
+		financeOnly := &common2.SchemaMetadata{AllowedRoles: []string{"finance"}}
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name: "table1",
+			Columns: []common2.Column{
+				{Name: "field1"},
+				{Name: "field2", Metadata: financeOnly},
+			},
+		}, nil)
+		mockMutator.On("GetTable", "table2").Return(&common2.Table{
+			Name: "table2",
+			Columns: []common2.Column{
+				{Name: "field1"},
+				{Name: "field3", Metadata: financeOnly},
+			},
+		}, nil)
+
+		newQuery := func(roles ...string) *common.AQLQuery {
+			return &common.AQLQuery{
+				Table: "table1",
+				Joins: []common.Join{
+					{Table: "table2", Alias: "t2", Conditions: []string{"t2.field1 = field1"}},
+				},
+				Dimensions: []common.Dimension{
+					{Expr: "field1"},
+				},
+				Measures: []common.Measure{
+					{Expr: "sum(field2 * 2)"},
+				},
+				Filters:     []string{"t2.field3 + 1 > 0"},
+				CallerRoles: roles,
+			}
+		}
+
+		qc := NewQueryContext(newQuery("analyst"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring("not allowed to query columns: table1.field2, table2.field3"))
+
+		qc = NewQueryContext(newQuery("finance"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+
+		qc = NewQueryContext(newQuery(common2.AdminRole), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+
+		// restricted columns are skipped by wildcard.
+		qc = NewQueryContext(&common.AQLQuery{
+			Table:      "table1",
+			Dimensions: []common.Dimension{{Expr: "*"}},
+			Measures:   []common.Measure{{Expr: "1"}},
+		}, httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.AQLQuery.Dimensions).Should(Equal([]common.Dimension{{Expr: "field1"}}))
+	})
+
+	ginkgo.It("should process hll sketch option", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name:    "table1",
+			Columns: []common2.Column{{Name: "field1"}, {Name: "field2"}},
+		}, nil)
+
+		h := common.HLL{DenseData: make([]byte, 1<<10), Precision: 10}
+		h.Set(1, 2)
+		sketch, err := h.EncodeStandard(5)
+		Ω(err).Should(BeNil())
+
+		newQuery := func(measure string, option common.HLLSketchOption) *common.AQLQuery {
+			return &common.AQLQuery{
+				Table:      "table1",
+				Dimensions: []common.Dimension{{Expr: "field1"}},
+				Measures:   []common.Measure{{Expr: measure}},
+				HLLSketch:  &option,
+			}
+		}
+
+		qc := NewQueryContext(newQuery("hll(field2)", common.HLLSketchOption{
+			Precision: 12,
+			Union: common.AQLQueryResult{
+				"1": base64.StdEncoding.EncodeToString(sketch),
+			},
+		}), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		// not sent to datanodes.
+		Ω(qc.AQLQuery.HLLSketch).Should(BeNil())
+		Ω(*qc.HLLSketch).Should(Equal(common.HLLSketchOption{
+			Precision:     12,
+			RegisterWidth: common.DefaultStandardHLLRegisterWidth,
+			Union:         common.AQLQueryResult{"1": h},
+		}))
+
+		for _, tc := range []struct {
+			measure    string
+			option     common.HLLSketchOption
+			errPattern string
+		}{
+			{"count(*)", common.HLLSketchOption{}, "only supported by hll aggregation"},
+			{"1", common.HLLSketchOption{}, "only supported by hll aggregation"},
+			{"hll(field2)", common.HLLSketchOption{Precision: 3}, "precision 3 not allowed"},
+			{"hll(field2)", common.HLLSketchOption{Precision: 15}, "precision 15 not allowed"},
+			{"hll(field2)", common.HLLSketchOption{RegisterWidth: 9}, "register width 9 not allowed"},
+			{"hll(field2)", common.HLLSketchOption{Union: common.AQLQueryResult{"1": "AAAA"}}, "invalid hll sketches"},
+		} {
+			qc = NewQueryContext(newQuery(tc.measure, tc.option), httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			Ω(qc.Error).ShouldNot(BeNil())
+			Ω(qc.Error.Error()).Should(ContainSubstring(tc.errPattern))
+		}
+	})
+
+	ginkgo.It("should validate pagination", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name:    "table1",
+			Columns: []common2.Column{{Name: "field1"}, {Name: "field2"}},
+		}, nil)
+
+		newQuery := func(measure string) *common.AQLQuery {
+			return &common.AQLQuery{
+				Table:      "table1",
+				Dimensions: []common.Dimension{{Expr: "field1"}},
+				Measures:   []common.Measure{{Expr: measure}},
+				PageSize:   10,
+			}
+		}
+
+		qc := NewQueryContext(newQuery("1"), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		// paginated queries are limited by pages.
+		Ω(qc.AQLQuery.Limit).Should(Equal(0))
+
+		// offsets of queries not paginated skip rows.
+		q := newQuery("1")
+		q.PageSize, q.Offset = 0, 10
+		qc = NewQueryContext(q, httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+
+		for _, tc := range []struct {
+			update     func(q *common.AQLQuery)
+			errPattern string
+		}{
+			{func(q *common.AQLQuery) { q.Measures[0].Expr = "count(*)" }, "only supported by non aggregation queries"},
+			{func(q *common.AQLQuery) { q.PageSize = -1 }, "pageSize must be positive"},
+			{func(q *common.AQLQuery) { q.Limit = 10 }, "limit is not supported by paginated queries"},
+			{func(q *common.AQLQuery) { q.PageSize, q.Cursor = 0, "cursor" }, "cursor requires pageSize"},
+			{func(q *common.AQLQuery) { q.Offset = 10 }, "offset is not supported by paginated queries"},
+			{func(q *common.AQLQuery) { q.PageSize, q.Offset = 0, -1 }, "offset must not be negative"},
+			{func(q *common.AQLQuery) { q.PageSize, q.Offset, q.Measures[0].Expr = 0, 10, "count(*)" }, "offset is only supported by non aggregation queries"},
+		} {
+			q := newQuery("1")
+			tc.update(q)
+			qc = NewQueryContext(q, httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			Ω(qc.Error).ShouldNot(BeNil())
+			Ω(qc.Error.Error()).Should(ContainSubstring(tc.errPattern))
+		}
+	})
+
+	ginkgo.It("should decide whether queries return partial results", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name:    "table1",
+			Columns: []common2.Column{{Name: "field1"}, {Name: "field2"}},
+		}, nil)
+
+		allow, deny := true, false
+		compile := func(measure string, pageSize int, partialResults *bool, defaultAllow bool) *QueryContext {
+			qc := NewQueryContext(&common.AQLQuery{
+				Table:          "table1",
+				Dimensions:     []common.Dimension{{Expr: "field1"}},
+				Measures:       []common.Measure{{Expr: measure}},
+				PageSize:       pageSize,
+				PartialResults: partialResults,
+			}, httptest.NewRecorder())
+			qc.allowPartialResults = defaultAllow
+			qc.Compile(&mockMutator)
+			return qc
+		}
+
+		qc := compile("sum(field2)", 0, nil, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.allowPartialResults).Should(BeFalse())
+		qc = compile("sum(field2)", 0, nil, true)
+		Ω(qc.allowPartialResults).Should(BeTrue())
+		qc = compile("sum(field2)", 0, &deny, true)
+		Ω(qc.allowPartialResults).Should(BeFalse())
+		qc = compile("1", 0, &allow, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.allowPartialResults).Should(BeTrue())
+
+		for _, tc := range []struct {
+			measure    string
+			pageSize   int
+			errPattern string
+		}{
+			{"avg(field2)", 0, "not supported by avg"},
+			{"countdistinct(field2)", 0, "not supported by exact countdistinct"},
+			{"1", 10, "not supported by paginated queries"},
+		} {
+			// fall back to failing on datanode failures by default of broker.
+			qc = compile(tc.measure, tc.pageSize, nil, true)
+			Ω(qc.Error).Should(BeNil())
+			Ω(qc.allowPartialResults).Should(BeFalse())
+
+			qc = compile(tc.measure, tc.pageSize, &allow, false)
+			Ω(qc.Error).ShouldNot(BeNil())
+			Ω(qc.Error.Error()).Should(ContainSubstring(tc.errPattern))
+		}
+	})
+
+	ginkgo.It("should reject progressive results of queries not merged incrementally", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name:    "table1",
+			Columns: []common2.Column{{Name: "field1"}, {Name: "field2"}},
+		}, nil)
+
+		compile := func(measures ...string) *QueryContext {
+			aql := &common.AQLQuery{
+				Table:               "table1",
+				Dimensions:          []common.Dimension{{Expr: "field1"}},
+				ProgressiveInterval: 2,
+			}
+			for _, measure := range measures {
+				aql.Measures = append(aql.Measures, common.Measure{Expr: measure})
+			}
+			qc := NewQueryContext(aql, httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			return qc
+		}
+
+		Ω(compile("sum(field2)").Error).Should(BeNil())
+		Ω(compile("count(*)", "max(field2)").Error).Should(BeNil())
+		for measure, errPattern := range map[string]string{
+			"avg(field2)":           "only supported by sum, count, min and max, but got avg",
+			"countdistinct(field2)": "only supported by sum, count, min and max, but got countdistinct",
+			"1":                     "only supported by aggregation queries",
+		} {
+			qc := compile(measure)
+			Ω(qc.Error).ShouldNot(BeNil())
+			Ω(qc.Error.Error()).Should(ContainSubstring(errPattern))
+		}
+	})
+
+	ginkgo.It("should resolve sorts of non aggregation queries", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "table1").Return(&common2.Table{
+			Name: "table1",
+			Columns: []common2.Column{
+				{Name: "field1", Type: common2.Int64},
+				{Name: "field2", Type: common2.BigEnum},
+			},
+		}, nil)
+		mockMutator.On("GetTable", "table2").Return(&common2.Table{
+			Name:    "table2",
+			Columns: []common2.Column{{Name: "field3", Type: common2.Float32}},
+		}, nil)
+
+		newQuery := func(measure string, sorts ...common.SortField) *common.AQLQuery {
+			return &common.AQLQuery{
+				Table: "table1",
+				Joins: []common.Join{{Table: "table2", Alias: "t2"}},
+				Dimensions: []common.Dimension{
+					{Expr: "field2", Alias: "f2"},
+					{Expr: "field1"},
+					{Expr: "t2.field3"},
+					{Expr: "field1 + 1"},
+					{Expr: "field1", Alias: "bucket", NumericBucketizer: common.NumericBucketizerDef{BucketWidth: 10}},
+				},
+				Measures: []common.Measure{{Expr: measure}},
+				Sorts:    sorts,
+			}
+		}
+
+		qc := NewQueryContext(newQuery("1",
+			common.SortField{Name: "f2", Order: "DESC"},
+			common.SortField{Name: "field1"},
+			common.SortField{Name: "t2.field3", Order: "asc"},
+			common.SortField{Name: "field1 + 1", Order: "desc"},
+			common.SortField{Name: "bucket"},
+		), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.sorts).Should(Equal([]rowSort{
+			{index: 0, descending: true},
+			{index: 1, numeric: true},
+			{index: 2, numeric: true},
+			{index: 3, descending: true},
+			// values of numeric buckets are numbers.
+			{index: 4, numeric: true},
+		}))
+
+		// sorts of aggregation queries are left to datanodes and result merging.
+		qc = NewQueryContext(newQuery("count(*)", common.SortField{Name: "count(*)"}), httptest.NewRecorder())
+		qc.Compile(&mockMutator)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.sorts).Should(BeNil())
+
+		for _, tc := range []struct {
+			update     func(q *common.AQLQuery)
+			errPattern string
+		}{
+			{func(q *common.AQLQuery) { q.Sorts[0].Name = "field3" }, "sort field field3 is not a dimension of the query"},
+			{func(q *common.AQLQuery) { q.Sorts[0].Order = "up" }, "unknown sort order up"},
+			{func(q *common.AQLQuery) { q.Limit, q.PageSize = 0, 10 }, "sorts are not supported by paginated queries"},
+		} {
+			q := newQuery("1", common.SortField{Name: "field1"})
+			tc.update(q)
+			qc = NewQueryContext(q, httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			Ω(qc.Error).ShouldNot(BeNil())
+			Ω(qc.Error.Error()).Should(ContainSubstring(tc.errPattern))
+		}
+	})
+
+	ginkgo.It("should turn range predicates of sql queries on the time column into the time filter", func() {
+		mockMutator := metaMocks.TableSchemaReader{}
+		mockMutator.On("GetTable", "trips").Return(&common2.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns:     []common2.Column{{Name: "request_at"}, {Name: "city_id"}},
+		}, nil)
+		mockMutator.On("GetTable", "cities").Return(&common2.Table{
+			Name:    "cities",
+			Columns: []common2.Column{{Name: "id"}},
+		}, nil)
+
+		compile := func(table, sql string, filters ...string) *common.AQLQuery {
+			qc := NewQueryContext(&common.AQLQuery{
+				Table:    table,
+				Measures: []common.Measure{{Expr: "count(*)"}},
+				Filters:  filters,
+				SQLQuery: sql,
+			}, httptest.NewRecorder())
+			qc.Compile(&mockMutator)
+			Ω(qc.Error).Should(BeNil())
+			return qc.AQLQuery
+		}
+
+		aql := compile("trips", "sql", "request_at >= 1570000000", "city_id = 1", "trips.request_at < 1570003000",
+			"request_at >= 1570000600", "request_at <= 1570003600", "request_at < city_id")
+		Ω(aql.TimeFilter).Should(Equal(common.TimeFilter{Column: "request_at", From: "1570000000", To: "1570003000"}))
+		Ω(aql.Filters).Should(Equal([]string{"city_id = 1", "request_at >= 1570000600", "request_at <= 1570003600",
+			"request_at < city_id"}))
+
+		// '<' predicates on time strings are kept as row filters as the time filter includes the whole day.
+		aql = compile("trips", "sql", "request_at >= '2019-10-01'", "trips.request_at < '2019-10-03'")
+		Ω(aql.TimeFilter).Should(Equal(common.TimeFilter{Column: "request_at", From: "2019-10-01"}))
+		Ω(aql.Filters).Should(Equal([]string{"trips.request_at < '2019-10-03'"}))
+
+		// aql queries, queries of dimension tables and queries with time filters are left as they are.
+		Ω(compile("trips", "", "request_at >= 1570000000").Filters).Should(HaveLen(1))
+		Ω(compile("cities", "sql", "id >= 1570000000").Filters).Should(HaveLen(1))
+	})
 })
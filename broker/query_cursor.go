@@ -0,0 +1,133 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultMaxPageSize  = 100000
+	defaultCursorTTLSec = 600
+)
+
+// queryCursor is the progress of a paginated non aggregation query, returned to clients as an opaque
+// string to request the next page.
+type queryCursor struct {
+	// fingerprint of the query, all pages must be requested with the same query.
+	Fingerprint string `json:"fp"`
+	// now of the first page in unix seconds, relative time filters of all pages are resolved against it
+	// so that pages scan the same frozen time range.
+	Now int64 `json:"now"`
+	// unix seconds the cursor expires at.
+	ExpiresAt int64 `json:"exp"`
+	// progress of datanodes ordered by host id.
+	Nodes []cursorNode `json:"nodes"`
+}
+
+// cursorNode is the progress of a paginated query on a datanode, offsets are only valid on the same
+// datanode with the same shards.
+type cursorNode struct {
+	Host   string   `json:"host"`
+	Shards []uint32 `json:"shards"`
+	// number of rows returned from the datanode by previous pages.
+	Offset int `json:"offset"`
+	// whether all rows of the datanode are returned.
+	Done bool `json:"done,omitempty"`
+}
+
+// queryFingerprint identifies the query of paginated requests, it must be computed before the query is
+// compiled.
+func queryFingerprint(aql *queryCom.AQLQuery) (string, error) {
+	bs, err := json.Marshal(aql)
+	if err != nil {
+		return "", utils.StackError(err, "failed to marshal query")
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// decodeQueryCursor decodes the cursor and validates it against the fingerprint of the query.
+func decodeQueryCursor(cursor string, fingerprint string) (*queryCursor, error) {
+	var c queryCursor
+	bs, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(bs, &c)
+	}
+	if err != nil {
+		return nil, utils.WithCode(utils.ErrCodeInvalidCursor, utils.StackError(err, "malformed cursor"))
+	}
+	if c.Fingerprint != fingerprint {
+		return nil, utils.WithCode(utils.ErrCodeInvalidCursor, utils.StackError(nil, "cursor was created for a different query"))
+	}
+	if utils.Now().Unix() >= c.ExpiresAt {
+		return nil, utils.WithCode(utils.ErrCodeInvalidCursor, utils.StackError(nil, "cursor expired"))
+	}
+	return &c, nil
+}
+
+// encode encodes the cursor as an opaque url safe string.
+func (c *queryCursor) encode() (string, error) {
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return "", utils.StackError(err, "failed to marshal cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// resume sets the progress of datanodes of the first page, or validates that datanodes and their shards
+// of the query are the same as when the cursor was created.
+func (c *queryCursor) resume(nodes []cursorNode) error {
+	if c.Nodes == nil {
+		c.Nodes = nodes
+		return nil
+	}
+	changed := len(c.Nodes) != len(nodes)
+	for i := 0; !changed && i < len(nodes); i++ {
+		changed = c.Nodes[i].Host != nodes[i].Host || !equalShards(c.Nodes[i].Shards, nodes[i].Shards)
+	}
+	if changed {
+		return utils.WithCode(utils.ErrCodeInvalidCursor, utils.StackError(nil, "shard assignment changed since the cursor was created"))
+	}
+	return nil
+}
+
+// done tells whether all rows of the query are returned.
+func (c *queryCursor) done() bool {
+	for _, node := range c.Nodes {
+		if !node.Done {
+			return false
+		}
+	}
+	return true
+}
+
+func equalShards(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
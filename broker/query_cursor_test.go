@@ -0,0 +1,76 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("query cursor", func() {
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should fingerprint queries", func() {
+		q := queryCom.AQLQuery{Table: "trips", Measures: []queryCom.Measure{{Expr: "1"}}}
+		fp1, err := queryFingerprint(&q)
+		Ω(err).Should(BeNil())
+
+		// pagination parameters are not part of the query.
+		q.PageSize, q.Cursor = 10, "cursor"
+		fp2, err := queryFingerprint(&q)
+		Ω(err).Should(BeNil())
+		Ω(fp2).Should(Equal(fp1))
+
+		q.Filters = []string{"status = 'completed'"}
+		fp3, err := queryFingerprint(&q)
+		Ω(err).Should(BeNil())
+		Ω(fp3).ShouldNot(Equal(fp1))
+	})
+
+	ginkgo.It("should encode and validate cursors", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		cursor := queryCursor{
+			Fingerprint: "fp",
+			Now:         900,
+			ExpiresAt:   1600,
+			Nodes:       []cursorNode{{Host: "host1", Shards: []uint32{0, 1}, Offset: 10}},
+		}
+		encoded, err := cursor.encode()
+		Ω(err).Should(BeNil())
+
+		decoded, err := decodeQueryCursor(encoded, "fp")
+		Ω(err).Should(BeNil())
+		Ω(*decoded).Should(Equal(cursor))
+
+		_, err = decodeQueryCursor("not a cursor", "fp")
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidCursor))
+		Ω(err.Error()).Should(ContainSubstring("malformed cursor"))
+
+		_, err = decodeQueryCursor(encoded, "another")
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidCursor))
+		Ω(err.Error()).Should(ContainSubstring("different query"))
+
+		utils.SetCurrentTime(time.Unix(1600, 0))
+		_, err = decodeQueryCursor(encoded, "fp")
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidCursor))
+		Ω(err.Error()).Should(ContainSubstring("cursor expired"))
+	})
+})
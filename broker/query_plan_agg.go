@@ -17,8 +17,8 @@ package broker
 import (
 	"context"
 	"fmt"
+	"github.com/opentracing/opentracing-go"
 	"github.com/uber/aresdb/broker/common"
-	"github.com/uber/aresdb/broker/util"
 	"github.com/uber/aresdb/cluster/topology"
 	dataCli "github.com/uber/aresdb/datanode/client"
 	queryCom "github.com/uber/aresdb/query/common"
@@ -26,6 +26,7 @@ import (
 	"github.com/uber/aresdb/utils"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -46,7 +47,17 @@ func (bpn *blockingPlanNodeImpl) Add(nodes ...common.BlockingPlanNode) {
 
 func NewMergeNode(agg common.AggType) common.MergeNode {
 	return &mergeNodeImpl{
-		aggType: agg,
+		aggType:  agg,
+		aggTypes: []common.AggType{agg},
+	}
+}
+
+// newMeasuresMergeNode creates the merge node of results of multiple measures, whose leaves are arrays of
+// measure values merged by aggregations of measures in order.
+func newMeasuresMergeNode(aggTypes []common.AggType) *mergeNodeImpl {
+	return &mergeNodeImpl{
+		aggType:  aggTypes[0],
+		aggTypes: aggTypes,
 	}
 }
 
@@ -54,6 +65,19 @@ type mergeNodeImpl struct {
 	blockingPlanNodeImpl
 	// MeasureType decides merge behaviour
 	aggType common.AggType
+	// aggregations of measures in order, only the aggType for single measures.
+	aggTypes []common.AggType
+	// fill of missing top level time buckets of the merged result, only set for the root node.
+	fill *timeBucketFill
+	// max number of distinct values of a bucket of CountDistinct, 0 means defaultMaxDistinctValues.
+	maxDistinctValues int
+	// quantiles of Percentile in order.
+	quantiles []float64
+	// tolerates failures of children querying datanodes as long as any child succeeds, only set for the root
+	// node of queries returning partial results.
+	partial *partialResults
+	// streams results merged so far, only set for the root node of progressive queries.
+	progress *progressiveResults
 }
 
 func (mn *mergeNodeImpl) AggType() common.AggType {
@@ -88,46 +112,243 @@ func (mn *mergeNodeImpl) Execute(ctx context.Context) (result queryCom.AQLQueryR
 		}
 	}
 
-	childrenResult := make([]queryCom.AQLQueryResult, nChildren)
-	nerrs := 0
-	wg := &sync.WaitGroup{}
+	// no datanodes to query when all shards are unassigned.
+	if nChildren == 0 {
+		result = queryCom.AQLQueryResult{}
+		return
+	}
+
+	runningQuery := queryCom.GetRunningQuery(ctx)
+	runningQuery.SetPhase(queryCom.QueryPhaseWaitingOnDataNodes)
+	childResults := make(chan childResult, nChildren)
 	for i, c := range mn.children {
-		wg.Add(1)
 		go func(i int, n common.BlockingPlanNode) {
-			defer wg.Done()
-			var res queryCom.AQLQueryResult
-			res, err = n.Execute(ctx)
-			if err != nil {
-				// err means downstream retry failed
-				utils.GetLogger().With(
-					"error", err,
-				).Error("child node failed")
-				nerrs++
-				return
-			}
-			childrenResult[i] = res
+			res, childErr := n.Execute(ctx)
+			childResults <- childResult{index: i, result: res, err: childErr}
 		}(i, c)
 	}
 
+	// results are merged as they arrive except for avg, which divides sums by counts of its two children.
+	var avgResults []queryCom.AQLQueryResult
+	if common.Avg == mn.aggType {
+		avgResults = make([]queryCom.AQLQueryResult, nChildren)
+	}
+	mergeCtx := getResultMergeContext(mn.aggTypes...)
+	defer putResultMergeContext(mergeCtx)
+	mergeCtx.fill = mn.fill
+	mergeCtx.maxDistinctValues = mn.maxDistinctValues
+	nerrs := 0
+	// failures of children tolerated for partial results.
+	ntolerated := 0
+	var hostErrors []utils.HostError
+	// hosts of children failed by the context, children return once the context is done.
+	var pendingHosts []string
+	ctxDone := false
+	// time merging results, excluding time waiting for datanodes between merges.
+	var mergeTime time.Duration
 	dataNodeWaitStart := utils.Now()
-	// TODO early merge before all results come back
-	wg.Wait()
+	for i := 0; i < nChildren; i++ {
+		res := <-childResults
+		if res.err != nil && ctx.Err() != nil {
+			ctxDone = true
+			pendingHosts = append(pendingHosts, timedOutHosts(res.err, mn.children[res.index])...)
+			continue
+		}
+		if res.err != nil {
+			// err means downstream retry failed
+			utils.GetContextLogger(ctx).With(
+				"error", res.err,
+			).Error("child node failed")
+			if codedErr, ok := res.err.(*utils.CodedError); ok {
+				hostErrors = append(hostErrors, codedErr.HostErrors...)
+			}
+			// the query fails if all children fail.
+			if ntolerated+1 < nChildren && mn.partial.tolerate(res.err) {
+				ntolerated++
+				continue
+			}
+			nerrs++
+			continue
+		}
+		// results are dropped once any child fails.
+		if nerrs > 0 || mergeCtx.err != nil {
+			continue
+		}
+		if avgResults != nil {
+			avgResults[res.index] = res.result
+			continue
+		}
+		mergeStart := utils.Now()
+		mergeCtx.add(res.result)
+		mergeTime += utils.Now().Sub(mergeStart)
+		if mn.progress != nil && mergeCtx.err == nil {
+			if err = mn.progress.add(mergeCtx.result, nChildren-i-1); err != nil {
+				return
+			}
+		}
+	}
 	utils.GetRootReporter().GetTimer(utils.TimeWaitedForDataNode).Record(utils.Now().Sub(dataNodeWaitStart))
 
+	if ctxDone {
+		err = queryContextError(ctx, pendingHosts)
+		return
+	}
 	if nerrs > 0 {
-		err = utils.StackError(nil, fmt.Sprintf("%d errors happened executing merge node", nerrs))
+		codedErr := utils.WithCode(utils.ErrCodeDataNodeFailure,
+			utils.StackError(nil, fmt.Sprintf("%d errors happened executing merge node", nerrs+ntolerated)))
+		codedErr.HostErrors = hostErrors
+		err = codedErr
+		return
+	}
+
+	runningQuery.SetPhase(queryCom.QueryPhaseMerging)
+	mergeStart := utils.Now()
+	defer func() {
+		if err == nil {
+			mn.reportMergeMetrics(mergeTime+utils.Now().Sub(mergeStart), result)
+		}
+	}()
+	if avgResults != nil {
+		if result, err = mergeAll(avgResults, mn.aggType); err != nil {
+			return
+		}
+	} else if result, err = mergeCtx.merged(); err != nil {
 		return
 	}
+	if common.Var == mn.aggType || common.Stddev == mn.aggType {
+		finalizeVariance(result, mn.aggType)
+	}
+	if common.CountDistinct == mn.aggType {
+		if _, err = finalizeCountDistinct(result, mn.maxDistinctValues); err != nil {
+			result = nil
+			return
+		}
+	}
+	if common.Percentile == mn.aggType {
+		finalizePercentile(result, mn.quantiles)
+	}
+	result = mergeCtx.fillTimeBuckets(result)
+	return
+}
+
+// reportMergeMetrics reports the time merging results of children and the number of buckets of the merged
+// result, tagged by the aggregation.
+func (mn *mergeNodeImpl) reportMergeMetrics(mergeTime time.Duration, result queryCom.AQLQueryResult) {
+	tags := map[string]string{"agg_type": mn.aggType.String()}
+	utils.GetRootReporter().GetChildTimer(tags, utils.ResultMergeTime).Record(mergeTime)
+	utils.GetRootReporter().GetChildCounter(tags, utils.ResultMergeBuckets).Inc(int64(countBuckets(map[string]interface{}(result))))
+}
+
+// childResult is the result of a child node sent to its parent merge node.
+type childResult struct {
+	index  int
+	result queryCom.AQLQueryResult
+	err    error
+}
+
+// varianceMomentsNode is a BlockingPlanNode computing variance moments of buckets of one datanode, from
+// results of its count, sum and sum of squares children.
+type varianceMomentsNode struct {
+	blockingPlanNodeImpl
+}
+
+func (vn *varianceMomentsNode) Execute(ctx context.Context) (result queryCom.AQLQueryResult, err error) {
+	if len(vn.children) != 3 {
+		err = utils.StackError(nil, "variance moments node should have 3 children")
+		return
+	}
+
+	childrenResult := make([]queryCom.AQLQueryResult, len(vn.children))
+	childrenErr := make([]error, len(vn.children))
+	wg := &sync.WaitGroup{}
+	for i, c := range vn.children {
+		wg.Add(1)
+		go func(i int, n common.BlockingPlanNode) {
+			defer wg.Done()
+			childrenResult[i], childrenErr[i] = n.Execute(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, childErr := range childrenErr {
+		if childErr != nil {
+			err = childErr
+			return
+		}
+	}
+	moments := buildVarianceMoments(map[string]interface{}(childrenResult[0]),
+		map[string]interface{}(childrenResult[1]), map[string]interface{}(childrenResult[2]))
+	result = queryCom.AQLQueryResult(moments.(map[string]interface{}))
+	return
+}
+
+// distinctValuesNode is a BlockingPlanNode building distinct values of buckets of one datanode, from the
+// result of its child counting rows by the counted column as the innermost dimension.
+type distinctValuesNode struct {
+	blockingPlanNodeImpl
+	// number of dimensions of the query excluding the counted column.
+	numDimensions int
+}
+
+func (dn *distinctValuesNode) Execute(ctx context.Context) (result queryCom.AQLQueryResult, err error) {
+	if len(dn.children) != 1 {
+		err = utils.StackError(nil, "distinct values node should have 1 child")
+		return
+	}
+	if result, err = dn.children[0].Execute(ctx); err != nil {
+		return
+	}
+	result = buildDistinctValues(result, dn.numDimensions)
+	return
+}
+
+// tdigestNode is a BlockingPlanNode importing base64 encoded t-digests of buckets of one datanode, from the
+// result of its child querying percentile partials.
+type tdigestNode struct {
+	blockingPlanNodeImpl
+}
+
+func (tn *tdigestNode) Execute(ctx context.Context) (result queryCom.AQLQueryResult, err error) {
+	if len(tn.children) != 1 {
+		err = utils.StackError(nil, "t-digest node should have 1 child")
+		return
+	}
+	if result, err = tn.children[0].Execute(ctx); err != nil {
+		return
+	}
+	return queryCom.ImportTDigestResult(result)
+}
+
+// measuresNode is a BlockingPlanNode combining results of measures of one datanode into arrays of measure
+// values, from results of its children querying one measure each.
+type measuresNode struct {
+	blockingPlanNodeImpl
+}
+
+func (mn *measuresNode) Execute(ctx context.Context) (result queryCom.AQLQueryResult, err error) {
+	childrenResult := make([]queryCom.AQLQueryResult, len(mn.children))
+	childrenErr := make([]error, len(mn.children))
+	wg := &sync.WaitGroup{}
+	for i, c := range mn.children {
+		wg.Add(1)
+		go func(i int, n common.BlockingPlanNode) {
+			defer wg.Done()
+			childrenResult[i], childrenErr[i] = n.Execute(ctx)
+		}(i, c)
+	}
+	wg.Wait()
 
-	result = childrenResult[0]
-	for i := 1; i < nChildren; i++ {
-		mergeCtx := newResultMergeContext(mn.aggType)
-		result = mergeCtx.run(result, childrenResult[i])
-		if mergeCtx.err != nil {
-			err = mergeCtx.err
+	for _, childErr := range childrenErr {
+		if childErr != nil {
+			err = childErr
 			return
 		}
 	}
+	values := make([]interface{}, len(childrenResult))
+	for i, childResult := range childrenResult {
+		values[i] = map[string]interface{}(childResult)
+	}
+	result = queryCom.AQLQueryResult(buildMeasureValues(values).(map[string]interface{}))
 	return
 }
 
@@ -138,31 +359,78 @@ type BlockingScanNode struct {
 	query          queryCom.AQLQuery
 	host           topology.Host
 	dataNodeClient dataCli.DataNodeQueryClient
+	// replicas owning the same shards as the host in the order of the topology, failed trials are retried
+	// on the next replica.
+	replicas []topology.Host
+	// hedges queries of the host to replicas, nil if not hedged.
+	hedger *hedger
+	// number of replicas required to respond by consistent reads of all replicas, the host is only the key
+	// of the shards of the replicas. 0 to fetch from the host failing over to replicas.
+	required int
+	// how long failed trials wait for datanodes to refresh schemas before retries on schema mismatches.
+	schemaRetryDelay time.Duration
+	// fails queries of datanodes with open circuit breakers right away, nil if not enabled.
+	breaker *CircuitBreaker
+	// skips retries once exhausted, nil if not enabled.
+	retryBudget *RetryBudget
+	// how long calls to datanodes can run, 0 means calls are only bounded by the query.
+	callTimeout time.Duration
 }
 
+// Execute fetches the result of the datanode with retries rotating through the host and its replicas,
+// errors of failed trials are reported for the host the shards are assigned to. Retries are skipped once the
+// retry budget is exhausted. Shards of consistent reads are fetched from all replicas instead.
 func (sn *BlockingScanNode) Execute(ctx context.Context) (result queryCom.AQLQueryResult, err error) {
-	isHll := common.CallNameToAggType[sn.query.Measures[0].ExprParsed.(*expr.Call).Name] == common.Hll
+	isHll := sn.isHll()
 
-	trial := 0
+	// datanodes queried by trials are traced by spans of the datanode client.
+	span, ctx := opentracing.StartSpanFromContext(ctx, "broker.scan")
+	start := utils.Now()
+	failures, trial := 0, 0
+	defer func() {
+		reportScanFailures(failures, err == nil)
+		recordScan(ctx, sn.host, start, trial)
+		span.SetTag("trials", trial)
+		finishSpan(span, err)
+	}()
+	if sn.required > 0 {
+		var value interface{}
+		value, failures, err = readReplicas(ctx, sn.replicas, sn.required, sn.queryFunc(isHll))
+		result, _ = value.(queryCom.AQLQueryResult)
+		return
+	}
+	sn.retryBudget.deposit()
 	for trial < rpcRetries {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		hosts := trialHosts(sn.host, sn.replicas, trial)
 		trial++
 
 		var fetchErr error
-		utils.GetLogger().With("host", sn.host, "query", sn.query).Debug("sending query to datanode")
-		result, fetchErr = sn.dataNodeClient.Query(ctx, sn.host, sn.query, isHll)
+		utils.GetContextLogger(ctx).With("host", hosts[0], "query", sn.query).Debug("sending query to datanode")
+		result, fetchErr = sn.fetch(ctx, hosts, isHll)
 		if fetchErr != nil {
-			utils.GetRootReporter().GetCounter(utils.DataNodeQueryFailures).Inc(1)
-			utils.GetLogger().With(
+			failures++
+			utils.GetContextLogger(ctx).With(
 				"error", fetchErr,
-				"host", sn.host,
+				"host", hosts[0],
 				"query", sn.query,
 				"trial", trial).Error("fetch from datanode failed")
-			err = utils.StackError(fetchErr, "fetch from datanode failed")
+			err = newDataNodeError(sn.host, fetchErr)
+			if trial < rpcRetries {
+				// the failure is returned right away once retries of the broker exhaust the budget.
+				if !sn.retryBudget.withdraw() {
+					utils.GetContextLogger(ctx).With("host", hosts[0], "trial", trial).Warn("retry budget exhausted, skipped retry of datanode")
+					break
+				}
+				waitForSchemaRefresh(ctx, fetchErr, sn.schemaRetryDelay)
+			}
 			continue
 		}
-		utils.GetLogger().With(
+		utils.GetContextLogger(ctx).With(
 			"trial", trial,
-			"host", sn.host).Info("fetch from datanode succeeded")
+			"host", hosts[0]).Info("fetch from datanode succeeded")
 		break
 	}
 	if result != nil {
@@ -171,9 +439,58 @@ func (sn *BlockingScanNode) Execute(ctx context.Context) (result queryCom.AQLQue
 	return
 }
 
+// isHll tells whether the datanode responds hll sketches of the query.
+func (sn *BlockingScanNode) isHll() bool {
+	return common.CallNameToAggType[sn.query.Measures[0].ExprParsed.(*expr.Call).Name] == common.Hll
+}
+
+// fetch queries the first of the hosts, hedged to the rest if enabled. Hosts with open circuit breakers fail
+// right away.
+func (sn *BlockingScanNode) fetch(ctx context.Context, hosts []topology.Host, isHll bool) (queryCom.AQLQueryResult, error) {
+	query := sn.queryFunc(isHll)
+	var value interface{}
+	var err error
+	if sn.hedger == nil {
+		value, err = query(ctx, hosts[0])
+	} else {
+		value, err = sn.hedger.call(ctx, hosts[0], hosts[1:], query)
+	}
+	result, _ := value.(queryCom.AQLQueryResult)
+	return result, err
+}
+
+// queryFunc returns the function querying a host unless its circuit breaker is open, within the datanode
+// timeout.
+func (sn *BlockingScanNode) queryFunc(isHll bool) func(ctx context.Context, host topology.Host) (interface{}, error) {
+	return func(ctx context.Context, host topology.Host) (interface{}, error) {
+		ctx, cancel := withCallTimeout(ctx, sn.callTimeout)
+		defer cancel()
+		return sn.breaker.call(ctx, host, func(ctx context.Context, host topology.Host) (interface{}, error) {
+			return sn.dataNodeClient.Query(ctx, host, sn.query, isHll)
+		})
+	}
+}
+
+// newDataNodeError creates the error of the failed query to the datanode, with the error of the host.
+func newDataNodeError(host topology.Host, fetchErr error) error {
+	code := utils.GetErrorCode(fetchErr)
+	err := utils.WithCode(utils.ErrCodeDataNodeFailure, utils.StackError(fetchErr, "fetch from datanode failed"))
+	err.HostErrors = []utils.HostError{{
+		Host:      host.ID(),
+		Code:      code,
+		Message:   utils.GetErrorMessage(fetchErr),
+		Retriable: utils.GetErrorCodeInfo(code).Retriable,
+	}}
+	return err
+}
+
 // AggQueryPlan is the plan for aggregate queries
 type AggQueryPlan struct {
 	root common.BlockingPlanNode
+	// deadline of the query, zero for no deadline.
+	deadline time.Time
+	// topN truncates merged results of queries with limits sorted by the measure, nil if not needed.
+	topN *topNOption
 }
 
 // NewAggQueryPlan creates a new agg query plan
@@ -181,34 +498,72 @@ func NewAggQueryPlan(qc *QueryContext, topo topology.Topology, client dataCli.Da
 	var root common.MergeNode
 
 	var assignments map[topology.Host][]uint32
-	assignments, err = util.CalculateShardAssignment(topo)
+	var replicas map[topology.Host][]topology.Host
+	var required int
+	assignments, replicas, required, err = assignScans(qc, topo)
 	if err != nil {
 		return
 	}
 
-	// compiler already checked that only 1 measure exists, which is a expr.Call
-	measure := qc.AQLQuery.Measures[0].ExprParsed.(*expr.Call)
-	agg := common.CallNameToAggType[measure.Name]
+	// compiler already checked that measures are expr.Calls of supported aggregations.
+	aggTypes := qc.aggTypes()
+	agg := aggTypes[0]
 	// TODO revisit how to implement AVG. maybe add rollingAvg to datanode so only 1 call per shard needed
-	switch agg {
-	case common.Avg:
+	switch {
+	case len(aggTypes) > 1:
+		root = buildMeasuresPlan(aggTypes, qc.AQLQuery, assignments, client)
+	case agg == common.Avg:
 		root = NewMergeNode(common.Avg)
 		sumQuery, countQuery := splitAvgQuery(*qc.AQLQuery)
 		root.Add(
 			buildSubPlan(common.Sum, &sumQuery, assignments, topo, client),
 			buildSubPlan(common.Count, &countQuery, assignments, topo, client))
+	case agg == common.Var || agg == common.Stddev:
+		root = buildVariancePlan(agg, qc.AQLQuery, assignments, client)
+	case agg == common.Percentile:
+		root = buildPercentilePlan(qc.AQLQuery, qc.quantiles, assignments, client)
+	case agg == common.CountDistinct:
+		root = buildCountDistinctPlan(qc.AQLQuery, qc.maxDistinctValues, assignments, client)
 	default:
 		root = buildSubPlan(agg, qc.AQLQuery, assignments, topo, client)
 	}
 
+	if mn, ok := root.(*mergeNodeImpl); ok {
+		mn.fill = newTimeBucketFill(qc.AQLQuery, aggTypes...)
+		mn.partial = qc.partial
+		mn.progress = qc.progress
+		if qc.progress != nil {
+			qc.progress.fill = mn.fill
+		}
+	}
+	setScanReplicas(root, replicas, required, qc)
 	plan = AggQueryPlan{
-		root: root,
+		root:     root,
+		deadline: qc.deadline,
+	}
+	// hll values are not comparable.
+	if agg != common.Hll {
+		plan.topN = getTopNOption(qc.AQLQuery)
+	}
+	if qc.progress != nil {
+		qc.progress.topN = plan.topN
 	}
 	return
 }
 
 func (ap *AggQueryPlan) Execute(ctx context.Context) (results queryCom.AQLQueryResult, err error) {
-	return ap.root.Execute(ctx)
+	span, ctx := opentracing.StartSpanFromContext(ctx, "broker.agg_query_plan")
+	defer func() {
+		finishSpan(span, err)
+	}()
+	// queries to datanodes still running are canceled once merged.
+	ctx, cancel := withQueryDeadline(ctx, ap.deadline)
+	defer cancel()
+	results, err = ap.root.Execute(ctx)
+	if err == nil && ap.topN != nil {
+		results = truncateTopN(results, ap.topN)
+	}
+	return
 }
 
 // splitAvgQuery to sum and count queries
@@ -239,19 +594,175 @@ func splitAvgQuery(q queryCom.AQLQuery) (sumq queryCom.AQLQuery, countq queryCom
 	return
 }
 
+// splitVarianceQuery to count, sum and sum of squares queries, values are squared in float to avoid
+// overflows of integer columns.
+func splitVarianceQuery(q queryCom.AQLQuery) (countq, sumq, sumSquaresq queryCom.AQLQuery) {
+	measure := q.Measures[0]
+	arg := measure.ExprParsed.(*expr.Call).Args[0].String()
+
+	withMeasure := func(measureExpr string) queryCom.AQLQuery {
+		newQ := q
+		newQ.Measures = []queryCom.Measure{
+			{
+				Alias:   measure.Alias,
+				Expr:    measureExpr,
+				Filters: measure.Filters,
+			},
+		}
+		newQ.Measures[0].ExprParsed, _ = expr.ParseExpr(measureExpr)
+		return newQ
+	}
+
+	countq = withMeasure("count(*)")
+	sumq = withMeasure(fmt.Sprintf("sum(%s)", arg))
+	sumSquaresq = withMeasure(fmt.Sprintf("sum(1.0 * (%s) * (%s))", arg, arg))
+	return
+}
+
+// buildVariancePlan builds the plan merging variance moments of datanodes, moments of each datanode are
+// computed from its count, sum and sum of squares results.
+func buildVariancePlan(agg common.AggType, q *queryCom.AQLQuery, assignments map[topology.Host][]uint32, client dataCli.DataNodeQueryClient) common.MergeNode {
+	countQuery, sumQuery, sumSquaresQuery := splitVarianceQuery(*q)
+	root := NewMergeNode(agg)
+	for host, shardIDs := range assignments {
+		if len(shardIDs) == 0 {
+			continue
+		}
+		moments := &varianceMomentsNode{}
+		moments.Add(
+			newScanNode(&countQuery, host, shardIDs, client),
+			newScanNode(&sumQuery, host, shardIDs, client),
+			newScanNode(&sumSquaresQuery, host, shardIDs, client))
+		root.Add(moments)
+	}
+	return root
+}
+
+// splitCountDistinctQuery to the count query with the counted column as the innermost dimension, whose keys
+// are distinct values of buckets. Sorts and limits apply to distinct counts and are left to the broker.
+func splitCountDistinctQuery(q queryCom.AQLQuery) queryCom.AQLQuery {
+	measure := q.Measures[0]
+	arg := measure.ExprParsed.(*expr.Call).Args[0].String()
+
+	countq := q
+	countq.Dimensions = append(append([]queryCom.Dimension(nil), q.Dimensions...), queryCom.Dimension{Expr: arg})
+	countq.Measures = []queryCom.Measure{
+		{
+			Alias:   measure.Alias,
+			Expr:    "count(*)",
+			Filters: measure.Filters,
+		},
+	}
+	countq.Measures[0].ExprParsed, _ = expr.ParseExpr(countq.Measures[0].Expr)
+	countq.Sorts = nil
+	countq.Limit = 0
+	return countq
+}
+
+// buildCountDistinctPlan builds the plan unioning distinct values of buckets of datanodes, the merged sets are
+// replaced by their sizes.
+func buildCountDistinctPlan(q *queryCom.AQLQuery, maxDistinctValues int, assignments map[topology.Host][]uint32, client dataCli.DataNodeQueryClient) common.MergeNode {
+	countQuery := splitCountDistinctQuery(*q)
+	root := &mergeNodeImpl{
+		aggType:           common.CountDistinct,
+		aggTypes:          []common.AggType{common.CountDistinct},
+		maxDistinctValues: maxDistinctValues,
+	}
+	for host, shardIDs := range assignments {
+		if len(shardIDs) == 0 {
+			continue
+		}
+		distinct := &distinctValuesNode{numDimensions: len(q.Dimensions)}
+		distinct.Add(newScanNode(&countQuery, host, shardIDs, client))
+		root.Add(distinct)
+	}
+	return root
+}
+
+// splitPercentileQuery to the query of t-digests of buckets. Sorts and limits apply to quantiles and are left
+// to the broker.
+func splitPercentileQuery(q queryCom.AQLQuery) queryCom.AQLQuery {
+	measure := q.Measures[0]
+	arg := measure.ExprParsed.(*expr.Call).Args[0].String()
+
+	digestq := q
+	digestq.Measures = []queryCom.Measure{
+		{
+			Alias:   measure.Alias,
+			Expr:    fmt.Sprintf("%s(%s)", expr.TDigestCallName, arg),
+			Filters: measure.Filters,
+		},
+	}
+	digestq.Measures[0].ExprParsed, _ = expr.ParseExpr(digestq.Measures[0].Expr)
+	digestq.Sorts = nil
+	digestq.Limit = 0
+	return digestq
+}
+
+// buildPercentilePlan builds the plan merging t-digests of buckets of datanodes, quantiles are extracted from
+// the merged digests.
+func buildPercentilePlan(q *queryCom.AQLQuery, quantiles []float64, assignments map[topology.Host][]uint32, client dataCli.DataNodeQueryClient) common.MergeNode {
+	digestQuery := splitPercentileQuery(*q)
+	root := &mergeNodeImpl{
+		aggType:   common.Percentile,
+		aggTypes:  []common.AggType{common.Percentile},
+		quantiles: quantiles,
+	}
+	for host, shardIDs := range assignments {
+		if len(shardIDs) == 0 {
+			continue
+		}
+		digests := &tdigestNode{}
+		digests.Add(newScanNode(&digestQuery, host, shardIDs, client))
+		root.Add(digests)
+	}
+	return root
+}
+
+// buildMeasuresPlan builds the plan merging results of multiple measures of datanodes, each measure is
+// queried separately and combined into arrays of measure values for each datanode.
+func buildMeasuresPlan(aggTypes []common.AggType, q *queryCom.AQLQuery, assignments map[topology.Host][]uint32, client dataCli.DataNodeQueryClient) common.MergeNode {
+	queries := make([]queryCom.AQLQuery, len(q.Measures))
+	for i, measure := range q.Measures {
+		queries[i] = *q
+		queries[i].Measures = []queryCom.Measure{measure}
+	}
+	root := newMeasuresMergeNode(aggTypes)
+	for host, shardIDs := range assignments {
+		if len(shardIDs) == 0 {
+			continue
+		}
+		measures := &measuresNode{}
+		for i := range queries {
+			measures.Add(newScanNode(&queries[i], host, shardIDs, client))
+		}
+		root.Add(measures)
+	}
+	return root
+}
+
 func buildSubPlan(agg common.AggType, q *queryCom.AQLQuery, assignments map[topology.Host][]uint32, topo topology.Topology, client dataCli.DataNodeQueryClient) common.MergeNode {
 	root := NewMergeNode(agg)
 	for host, shardIDs := range assignments {
-		// make deep copy
-		newQ := *q
-		for _, shard := range shardIDs {
-			newQ.Shards = append(newQ.Shards, int(shard))
+		// datanodes query all of their shards without shards in the query.
+		if len(shardIDs) == 0 {
+			continue
 		}
-		root.Add(&BlockingScanNode{
-			query:          newQ,
-			host:           host,
-			dataNodeClient: client,
-		})
+		root.Add(newScanNode(q, host, shardIDs, client))
 	}
 	return root
 }
+
+// newScanNode creates the scan node querying the shards of the host.
+func newScanNode(q *queryCom.AQLQuery, host topology.Host, shardIDs []uint32, client dataCli.DataNodeQueryClient) *BlockingScanNode {
+	// make deep copy
+	newQ := *q
+	for _, shard := range shardIDs {
+		newQ.Shards = append(newQ.Shards, int(shard))
+	}
+	return &BlockingScanNode{
+		query:          newQ,
+		host:           host,
+		dataNodeClient: client,
+	}
+}
@@ -18,6 +18,7 @@ import (
 	common2 "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
+	"time"
 )
 
 var _ = ginkgo.Describe("agg query plan", func() {
@@ -52,6 +53,72 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		}))
 	})
 
+	ginkgo.It("splitVarianceQuery should work", func() {
+		q := common2.AQLQuery{
+			Table: "foo",
+			Measures: []common2.Measure{
+				{Expr: "stddev(fare)", Filters: []string{"fare > 0"}},
+			},
+		}
+		q.Measures[0].ExprParsed, _ = expr.ParseExpr(q.Measures[0].Expr)
+
+		countq, sumq, sumSquaresq := splitVarianceQuery(q)
+		Ω(countq.Measures[0].Expr).Should(Equal("count(*)"))
+		Ω(sumq.Measures[0].Expr).Should(Equal("sum(fare)"))
+		Ω(sumSquaresq.Measures[0].Expr).Should(Equal("sum(1.0 * (fare) * (fare))"))
+		for _, sq := range []common2.AQLQuery{countq, sumq, sumSquaresq} {
+			Ω(sq.Table).Should(Equal("foo"))
+			Ω(sq.Measures[0].Filters).Should(Equal([]string{"fare > 0"}))
+			Ω(sq.Measures[0].ExprParsed).ShouldNot(BeNil())
+		}
+		Ω(q.Measures[0].Expr).Should(Equal("stddev(fare)"))
+	})
+
+	ginkgo.It("splitCountDistinctQuery should work", func() {
+		q := common2.AQLQuery{
+			Table: "foo",
+			Dimensions: []common2.Dimension{
+				{Expr: "city_id"},
+			},
+			Measures: []common2.Measure{
+				{Expr: "countdistinct(driver_uuid)", Filters: []string{"fare > 0"}},
+			},
+			Sorts: []common2.SortField{{Name: "countdistinct(driver_uuid)"}},
+			Limit: 10,
+		}
+		q.Measures[0].ExprParsed, _ = expr.ParseExpr(q.Measures[0].Expr)
+
+		countq := splitCountDistinctQuery(q)
+		Ω(countq.Dimensions).Should(Equal([]common2.Dimension{{Expr: "city_id"}, {Expr: "driver_uuid"}}))
+		Ω(countq.Measures[0].Expr).Should(Equal("count(*)"))
+		Ω(countq.Measures[0].Filters).Should(Equal([]string{"fare > 0"}))
+		Ω(countq.Measures[0].ExprParsed).ShouldNot(BeNil())
+		Ω(countq.Sorts).Should(BeNil())
+		Ω(countq.Limit).Should(Equal(0))
+		Ω(q.Dimensions).Should(HaveLen(1))
+		Ω(q.Measures[0].Expr).Should(Equal("countdistinct(driver_uuid)"))
+	})
+
+	ginkgo.It("splitPercentileQuery should work", func() {
+		q := common2.AQLQuery{
+			Table: "foo",
+			Measures: []common2.Measure{
+				{Expr: "percentile(fare, 0.5, 0.9)", Filters: []string{"fare > 0"}},
+			},
+			Sorts: []common2.SortField{{Name: "percentile(fare, 0.5, 0.9)"}},
+			Limit: 10,
+		}
+		q.Measures[0].ExprParsed, _ = expr.ParseExpr(q.Measures[0].Expr)
+
+		digestq := splitPercentileQuery(q)
+		Ω(digestq.Measures[0].Expr).Should(Equal("tdigest(fare)"))
+		Ω(digestq.Measures[0].Filters).Should(Equal([]string{"fare > 0"}))
+		Ω(digestq.Measures[0].ExprParsed).ShouldNot(BeNil())
+		Ω(digestq.Sorts).Should(BeNil())
+		Ω(digestq.Limit).Should(Equal(0))
+		Ω(q.Measures[0].Expr).Should(Equal("percentile(fare, 0.5, 0.9)"))
+	})
+
 	ginkgo.It("MergeNode should work", func() {
 		mockSumNode := mocks.MergeNode{}
 		mockCountNode := mocks.MergeNode{}
@@ -84,6 +151,34 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		}`))
 	})
 
+	ginkgo.It("MergeNode should report merge metrics", func() {
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		getBuckets := func() int64 {
+			counter, exist := testScope.Snapshot().Counters()["test.result_merge_buckets+agg_type=sum,component=query"]
+			if !exist {
+				return 0
+			}
+			return counter.Value()
+		}
+		buckets := getBuckets()
+
+		lhs, rhs := mocks.BlockingPlanNode{}, mocks.BlockingPlanNode{}
+		lhs.On("Execute", mock.Anything).Return(common2.AQLQueryResult{
+			"1": map[string]interface{}{"dim1": float64(1), "dim2": float64(1)},
+		}, nil)
+		rhs.On("Execute", mock.Anything).Return(common2.AQLQueryResult{
+			"1": map[string]interface{}{"dim1": float64(1)},
+			"2": map[string]interface{}{"dim1": float64(1)},
+		}, nil)
+		node := NewMergeNode(common.Sum)
+		node.Add(&lhs, &rhs)
+		_, err := node.Execute(context.TODO())
+		Ω(err).Should(BeNil())
+
+		Ω(getBuckets() - buckets).Should(BeEquivalentTo(3))
+		Ω(testScope.Snapshot().Timers()).Should(HaveKey("test.result_merge_time+agg_type=sum,component=query"))
+	})
+
 	ginkgo.It("MergeNode Execute should error", func() {
 		mockSumNode := mocks.MergeNode{}
 		mockCountNode := mocks.MergeNode{}
@@ -127,11 +222,12 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		mockShardSet := shardMock.ShardSet{}
 		mockTopo.On("Get").Return(&mockMap)
 		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
 		mockShardIds := []uint32{0, 1, 2, 3, 4, 5}
 		mockShardSet.On("AllIDs").Return(mockShardIds)
-		mockHost1 := &topoMock.Host{}
-		mockHost2 := &topoMock.Host{}
-		mockHost3 := &topoMock.Host{}
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockHost3 := topology.NewHost("host3", "host3:9374")
 		mockHosts := []topology.Host{
 			mockHost1,
 			mockHost2,
@@ -164,6 +260,28 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		Ω(sn2.query.Shards).Should(HaveLen(2))
 	})
 
+	ginkgo.It("AggQueryPlan should truncate top n buckets", func() {
+		q := common2.AQLQuery{
+			Table:    "table1",
+			Measures: []common2.Measure{{Alias: "trips", Expr: "count(*)"}},
+			Sorts:    []common2.SortField{{Name: "trips", Order: "desc"}},
+			Limit:    2,
+		}
+		mockNode := mocks.BlockingPlanNode{}
+		mockNode.On("Execute", mock.Anything).Return(common2.AQLQueryResult{
+			"1": map[string]interface{}{"foo": 3.0, "bar": 1.0},
+			"2": map[string]interface{}{"foo": 2.0},
+		}, nil)
+
+		plan := AggQueryPlan{root: &mockNode, topN: getTopNOption(&q)}
+		res, err := plan.Execute(context.TODO())
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(common2.AQLQueryResult{
+			"1": map[string]interface{}{"foo": 3.0},
+			"2": map[string]interface{}{"foo": 2.0},
+		}))
+	})
+
 	ginkgo.It("NewAggQueryPlan should work for avg query", func() {
 		q := common2.AQLQuery{
 			Table: "table1",
@@ -179,11 +297,12 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		mockShardSet := shardMock.ShardSet{}
 		mockTopo.On("Get").Return(&mockMap)
 		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
 		mockShardIds := []uint32{0, 1, 2, 3, 4, 5}
 		mockShardSet.On("AllIDs").Return(mockShardIds)
-		mockHost1 := &topoMock.Host{}
-		mockHost2 := &topoMock.Host{}
-		mockHost3 := &topoMock.Host{}
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockHost3 := topology.NewHost("host3", "host3:9374")
 		mockHosts := []topology.Host{
 			mockHost1,
 			mockHost2,
@@ -218,6 +337,135 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		Ω(countn.children).Should(HaveLen(len(mockHosts)))
 	})
 
+	ginkgo.It("AggQueryPlan should time out with hosts not responded", func() {
+		q := common2.AQLQuery{
+			Table: "table1",
+			Measures: []common2.Measure{
+				{Expr: "avg(*)", ExprParsed: &expr.Call{Name: "avg"}},
+			},
+		}
+		qc := QueryContext{
+			AQLQuery: &q,
+			deadline: time.Now().Add(50 * time.Millisecond),
+		}
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost2}, nil)
+
+		// host2 only responds once the context is done.
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("Query", mock.Anything, mockHost1, mock.Anything, mock.Anything).
+			Return(common2.AQLQueryResult{"foo": float64(1)}, nil)
+		mockDatanodeCli.On("Query", mock.Anything, mockHost2, mock.Anything, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query common2.AQLQuery, hll bool) common2.AQLQueryResult {
+				<-ctx.Done()
+				return nil
+			}, func(ctx context.Context, host topology.Host, query common2.AQLQuery, hll bool) error {
+				return ctx.Err()
+			})
+
+		plan, err := NewAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli)
+		Ω(err).Should(BeNil())
+		_, err = plan.Execute(context.TODO())
+		codedErr, ok := err.(*utils.CodedError)
+		Ω(ok).Should(BeTrue())
+		Ω(codedErr.Code).Should(Equal(utils.ErrCodeTimeout))
+		Ω(codedErr.HostErrors).Should(Equal([]utils.HostError{{
+			Host:      "host2",
+			Code:      utils.ErrCodeTimeout,
+			Message:   "no response before the query deadline",
+			Retriable: true,
+		}}))
+
+		// canceled queries are not timeouts.
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		qc.deadline = time.Time{}
+		plan, err = NewAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli)
+		Ω(err).Should(BeNil())
+		_, err = plan.Execute(ctx)
+		Ω(err).Should(Equal(context.Canceled))
+	})
+
+	ginkgo.It("NewAggQueryPlan should work for multiple measures", func() {
+		q := common2.AQLQuery{
+			Table: "table1",
+			Measures: []common2.Measure{
+				{Expr: "sum(fare)", ExprParsed: &expr.Call{Name: "sum"}},
+				{Expr: "max(fare)", ExprParsed: &expr.Call{Name: "max"}},
+			},
+		}
+		qc := QueryContext{
+			AQLQuery: &q,
+		}
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1, mockHost2}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost1, mockHost2}, nil)
+
+		plan, err := NewAggQueryPlan(&qc, &mockTopo, &dataCliMock.DataNodeQueryClient{})
+		Ω(err).Should(BeNil())
+		mn, ok := plan.root.(*mergeNodeImpl)
+		Ω(ok).Should(BeTrue())
+		Ω(mn.aggTypes).Should(Equal([]common.AggType{common.Sum, common.Max}))
+		Ω(mn.children).Should(HaveLen(2))
+		for _, child := range mn.children {
+			measures, ok := child.(*measuresNode)
+			Ω(ok).Should(BeTrue())
+			Ω(measures.children).Should(HaveLen(2))
+			for i, measureChild := range measures.children {
+				sn, ok := measureChild.(*BlockingScanNode)
+				Ω(ok).Should(BeTrue())
+				Ω(sn.query.Measures).Should(Equal([]common2.Measure{q.Measures[i]}))
+			}
+		}
+	})
+
+	ginkgo.It("measures MergeNode Execute should merge arrays of measures", func() {
+		newMeasuresNode := func(sum, max common2.AQLQueryResult) *measuresNode {
+			sumNode, maxNode := &mocks.BlockingPlanNode{}, &mocks.BlockingPlanNode{}
+			sumNode.On("Execute", mock.Anything).Return(sum, nil)
+			maxNode.On("Execute", mock.Anything).Return(max, nil)
+			node := &measuresNode{}
+			node.Add(sumNode, maxNode)
+			return node
+		}
+		root := newMeasuresMergeNode([]common.AggType{common.Sum, common.Max})
+		root.Add(
+			newMeasuresNode(common2.AQLQueryResult{"1": 2.0, "2": 1.0}, common2.AQLQueryResult{"1": 2.0, "2": 1.0}),
+			newMeasuresNode(common2.AQLQueryResult{"1": 3.0}, common2.AQLQueryResult{"1": 1.0}))
+		res, err := root.Execute(context.TODO())
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(common2.AQLQueryResult{
+			"1": []interface{}{5.0, 2.0},
+			"2": []interface{}{1.0, 1.0},
+		}))
+
+		failing := &mocks.BlockingPlanNode{}
+		failing.On("Execute", mock.Anything).Return(nil, errors.New("some error"))
+		node := &measuresNode{}
+		node.Add(failing)
+		_, err = node.Execute(context.TODO())
+		Ω(err.Error()).Should(ContainSubstring("some error"))
+	})
+
 	ginkgo.It("BlockingScanNode Execute should work happy path", func() {
 		q := common2.AQLQuery{
 			Measures: []common2.Measure{{ExprParsed: &expr.Call{Name: "count"}}},
@@ -226,9 +474,9 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		mockTopo := topoMock.Topology{}
 		mockMap := topoMock.Map{}
 		mockTopo.On("Get").Return(&mockMap)
-		mockHost1 := topoMock.Host{}
-		mockHost2 := topoMock.Host{}
-		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{&mockHost1, &mockHost2}, nil)
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1, mockHost2}, nil)
 
 		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
 
@@ -253,9 +501,9 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		mockTopo := topoMock.Topology{}
 		mockMap := topoMock.Map{}
 		mockTopo.On("Get").Return(&mockMap)
-		mockHost1 := topoMock.Host{}
-		mockHost2 := topoMock.Host{}
-		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{&mockHost1, &mockHost2}, nil)
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1, mockHost2}, nil)
 
 		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
 
@@ -280,9 +528,9 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		mockTopo := topoMock.Topology{}
 		mockMap := topoMock.Map{}
 		mockTopo.On("Get").Return(&mockMap)
-		mockHost1 := topoMock.Host{}
-		mockHost2 := topoMock.Host{}
-		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{&mockHost1, &mockHost2}, nil).Times(rpcRetries)
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1, mockHost2}, nil).Times(rpcRetries)
 
 		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
 
@@ -290,11 +538,16 @@ var _ = ginkgo.Describe("agg query plan", func() {
 
 		sn := BlockingScanNode{
 			query:          q,
+			host:           mockHost1,
 			dataNodeClient: &mockDatanodeCli,
 		}
 
 		_, err := sn.Execute(context.TODO())
 		Ω(err.Error()).Should(ContainSubstring("fetch from datanode failed"))
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(err.(*utils.CodedError).HostErrors).Should(Equal([]utils.HostError{
+			{Host: "host1", Code: utils.ErrCodeInternal, Message: "rpc error"},
+		}))
 	})
 
 	ginkgo.It("BlockingScanNode Execute should work after retry", func() {
@@ -305,9 +558,9 @@ var _ = ginkgo.Describe("agg query plan", func() {
 		mockTopo := topoMock.Topology{}
 		mockMap := topoMock.Map{}
 		mockTopo.On("Get").Return(&mockMap)
-		mockHost1 := topoMock.Host{}
-		mockHost2 := topoMock.Host{}
-		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{&mockHost1, &mockHost2}, nil).Times(rpcRetries)
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1, mockHost2}, nil).Times(rpcRetries)
 
 		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
 
@@ -317,6 +570,7 @@ var _ = ginkgo.Describe("agg query plan", func() {
 
 		sn := BlockingScanNode{
 			query:          q,
+			host:           mockHost1,
 			dataNodeClient: &mockDatanodeCli,
 		}
 
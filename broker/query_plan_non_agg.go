@@ -15,14 +15,20 @@
 package broker
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"github.com/uber/aresdb/broker/util"
+	"github.com/opentracing/opentracing-go"
+	apiCom "github.com/uber/aresdb/api/common"
 	"github.com/uber/aresdb/cluster/topology"
 	dataCli "github.com/uber/aresdb/datanode/client"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/utils"
+	"hash/fnv"
+	"io"
 	"net/http"
+	"sort"
+	"time"
 )
 
 // StreamingScanNode implements StreamingPlanNode
@@ -30,30 +36,79 @@ type StreamingScanNode struct {
 	query          queryCom.AQLQuery
 	host           topology.Host
 	dataNodeClient dataCli.DataNodeQueryClient
+	// replicas owning the same shards as the host in the order of the topology, failed trials are retried
+	// on the next replica.
+	replicas []topology.Host
+	// hedges queries of the host to replicas, nil if not hedged.
+	hedger *hedger
+	// number of replicas required to respond by consistent reads of all replicas, the host is only the key
+	// of the shards of the replicas. 0 to fetch from the host failing over to replicas.
+	required int
+	// how long failed trials wait for datanodes to refresh schemas before retries on schema mismatches.
+	schemaRetryDelay time.Duration
+	// fails queries of datanodes with open circuit breakers right away, nil if not enabled.
+	breaker *CircuitBreaker
+	// skips retries once exhausted, nil if not enabled.
+	retryBudget *RetryBudget
+	// how long calls to datanodes can run, 0 means calls are only bounded by the query.
+	callTimeout time.Duration
 }
 
+// Execute fetches rows of the datanode with retries rotating through the host and its replicas, retries
+// stop once the context is done, e.g. the client disconnected or the query timed out, with the error of the
+// context or once the retry budget is exhausted. Errors of failed trials are reported for the host the shards
+// are assigned to. Shards of consistent reads are fetched from all replicas instead.
 func (ssn *StreamingScanNode) Execute(ctx context.Context) (bs []byte, err error) {
-	trial := 0
+	// datanodes queried by trials are traced by spans of the datanode client.
+	span, ctx := opentracing.StartSpanFromContext(ctx, "broker.streaming_scan")
+	start := utils.Now()
+	failures, trial := 0, 0
+	defer func() {
+		reportScanFailures(failures, err == nil)
+		recordScan(ctx, ssn.host, start, trial)
+		span.SetTag("trials", trial)
+		span.SetTag("bytes", len(bs))
+		finishSpan(span, err)
+	}()
+	if ssn.required > 0 {
+		var value interface{}
+		value, failures, err = readReplicas(ctx, ssn.replicas, ssn.required, ssn.queryHost)
+		bs, _ = value.([]byte)
+		return
+	}
+	ssn.retryBudget.deposit()
 	for trial < rpcRetries {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		hosts := trialHosts(ssn.host, ssn.replicas, trial)
 		trial++
 
 		var fetchErr error
 
-		utils.GetLogger().With("host", ssn.host, "query", ssn.query).Debug("sending query to datanode")
-		bs, fetchErr = ssn.dataNodeClient.QueryRaw(ctx, ssn.host, ssn.query)
+		utils.GetContextLogger(ctx).With("host", hosts[0], "query", ssn.query).Debug("sending query to datanode")
+		bs, fetchErr = ssn.fetch(ctx, hosts)
 		if fetchErr != nil {
-			utils.GetRootReporter().GetCounter(utils.DataNodeQueryFailures).Inc(1)
-			utils.GetLogger().With(
+			failures++
+			utils.GetContextLogger(ctx).With(
 				"error", fetchErr,
-				"host", ssn.host,
+				"host", hosts[0],
 				"query", ssn.query,
 				"trial", trial).Error("fetch from datanode failed")
-			err = utils.StackError(fetchErr, "fetch from datanode failed")
+			err = newDataNodeError(ssn.host, fetchErr)
+			if trial < rpcRetries {
+				// the failure is returned right away once retries of the broker exhaust the budget.
+				if !ssn.retryBudget.withdraw() {
+					utils.GetContextLogger(ctx).With("host", hosts[0], "trial", trial).Warn("retry budget exhausted, skipped retry of datanode")
+					break
+				}
+				waitForSchemaRefresh(ctx, fetchErr, ssn.schemaRetryDelay)
+			}
 			continue
 		}
-		utils.GetLogger().With(
+		utils.GetContextLogger(ctx).With(
 			"trial", trial,
-			"host", ssn.host).Info("fetch from datanode succeeded")
+			"host", hosts[0]).Info("fetch from datanode succeeded")
 		break
 	}
 	if bs != nil {
@@ -62,44 +117,110 @@ func (ssn *StreamingScanNode) Execute(ctx context.Context) (bs []byte, err error
 	return
 }
 
-func NewNonAggQueryPlan(qc *QueryContext, topo topology.Topology, client dataCli.DataNodeQueryClient, w http.ResponseWriter) (plan NonAggQueryPlan, err error) {
-	headers := make([]string, len(qc.AQLQuery.Dimensions))
-	for i, dim := range qc.AQLQuery.Dimensions {
+// fetch queries the first of the hosts, hedged to the rest if enabled. Hosts with open circuit breakers fail
+// right away.
+func (ssn *StreamingScanNode) fetch(ctx context.Context, hosts []topology.Host) ([]byte, error) {
+	var value interface{}
+	var err error
+	if ssn.hedger == nil {
+		value, err = ssn.queryHost(ctx, hosts[0])
+	} else {
+		value, err = ssn.hedger.call(ctx, hosts[0], hosts[1:], ssn.queryHost)
+	}
+	bs, _ := value.([]byte)
+	return bs, err
+}
+
+// queryHost queries the host unless its circuit breaker is open, within the datanode timeout.
+func (ssn *StreamingScanNode) queryHost(ctx context.Context, host topology.Host) (interface{}, error) {
+	ctx, cancel := withCallTimeout(ctx, ssn.callTimeout)
+	defer cancel()
+	return ssn.breaker.call(ctx, host, func(ctx context.Context, host topology.Host) (interface{}, error) {
+		return ssn.dataNodeClient.QueryRaw(ctx, host, ssn.query)
+	})
+}
+
+// dimensionHeaders returns headers of non aggregation results, dimensions are named by their aliases, or
+// their expressions if not aliased.
+func dimensionHeaders(dimensions []queryCom.Dimension) []string {
+	headers := make([]string, len(dimensions))
+	for i, dim := range dimensions {
 		headers[i] = dim.Expr
+		if dim.Alias != "" {
+			headers[i] = dim.Alias
+		}
 	}
-	plan.headers = headers
+	return headers
+}
+
+func NewNonAggQueryPlan(qc *QueryContext, topo topology.Topology, client dataCli.DataNodeQueryClient, w http.ResponseWriter) (plan NonAggQueryPlan, err error) {
+	plan.headers = dimensionHeaders(qc.AQLQuery.Dimensions)
 	plan.w = w
-	plan.resultChan = make(chan streamingScanNoderesult)
 	plan.limit = qc.AQLQuery.Limit
+	plan.offset = qc.AQLQuery.Offset
+	if qc.DedupRows {
+		plan.dedup = newRowDedup(qc.maxDedupBytes)
+	}
 
 	var assignment map[topology.Host][]uint32
-	assignment, err = util.CalculateShardAssignment(topo)
+	var replicas map[topology.Host][]topology.Host
+	var required int
+	assignment, replicas, required, err = assignScans(qc, topo)
 	if err != nil {
 		return
 	}
+	plan.partial = qc.partial
+	plan.deadline = qc.deadline
+	plan.sorts = qc.sorts
+	plan.maxSortBytes = qc.maxSortBytes
+	if plan.maxSortBytes <= 0 {
+		plan.maxSortBytes = defaultMaxSortBytes
+	}
 
-	plan.nodes = make([]*StreamingScanNode, len(assignment))
-	i := 0
 	for host, shards := range assignment {
+		// datanodes query all of their shards without shards in the query.
+		if len(shards) == 0 {
+			continue
+		}
 		// make deep copy
 		q := *qc.AQLQuery
 		for _, shard := range shards {
 			q.Shards = append(q.Shards, int(shard))
 		}
-		plan.nodes[i] = &StreamingScanNode{
-			query:          q,
-			host:           host,
-			dataNodeClient: client,
+		// the offset applies to rows merged across datanodes, so every datanode returns rows up to the offset
+		// plus the limit.
+		q.Offset = 0
+		if q.Limit >= 0 {
+			q.Limit += plan.offset
 		}
-		i++
+		if len(plan.sorts) > 0 {
+			// datanodes do not sort rows yet, any row of a datanode may be within the limit once sorted. Rows
+			// buffered by broker are capped by maxSortBytes instead.
+			q.Limit = -1
+		}
+		plan.nodes = append(plan.nodes, &StreamingScanNode{
+			query:            q,
+			host:             host,
+			dataNodeClient:   client,
+			replicas:         replicas[host],
+			hedger:           qc.hedger,
+			required:         required,
+			schemaRetryDelay: qc.schemaRetryDelay,
+			breaker:          qc.breaker,
+			retryBudget:      qc.retryBudget,
+			callTimeout:      qc.dataNodeTimeout,
+		})
 	}
-
+	// buffered so that nodes finished after enough rows are flushed do not block.
+	plan.resultChan = make(chan streamingScanNoderesult, len(plan.nodes))
 	return
 }
 
 type streamingScanNoderesult struct {
-	data []byte
-	err  error
+	// index of the node in nodes of the plan.
+	index int
+	data  []byte
+	err   error
 }
 
 // NonAggQueryPlan implements QueryPlan
@@ -110,49 +231,94 @@ type NonAggQueryPlan struct {
 	nodes      []*StreamingScanNode
 	// number of rows needed
 	limit int
+	// number of leading rows skipped before flushing, not counted by the limit
+	offset int
+	// number of rows skipped by the offset
+	skipped int
 	// number of rows flushed
 	flushed int
+	// drops rows flushed from other datanodes, nil if not requested.
+	dedup *rowDedup
+	// tolerates failures of datanodes as long as any datanode succeeds, nil if partial results not allowed.
+	partial *partialResults
+	// deadline of the query, zero for no deadline.
+	deadline time.Time
+	// rows of all datanodes are buffered and merged by the sorts, nil to stream rows of datanodes in the
+	// order they respond.
+	sorts []rowSort
+	// max bytes of rows of datanodes buffered for the sorts, the query fails beyond it.
+	maxSortBytes int
+	// bytes of rows of datanodes buffered for the sorts.
+	sortedBytes int
 }
 
 func (nqp *NonAggQueryPlan) Execute(ctx context.Context) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "broker.non_agg_query_plan")
+	defer func() {
+		finishSpan(span, err)
+	}()
+	// queries to datanodes still running are canceled once the query is done.
+	ctx, cancel := withQueryDeadline(ctx, nqp.deadline)
+	defer cancel()
+
 	var headersBytes []byte
 	headersBytes, err = json.Marshal(nqp.headers)
 	if err != nil {
 		return
 	}
-	_, err = nqp.w.Write([]byte(`{"headers":`))
-	if err != nil {
-		return
-	}
-	_, err = nqp.w.Write(headersBytes)
-	if err != nil {
-		return
-	}
-	_, err = nqp.w.Write([]byte(`,"matrixData":[`))
-	if err != nil {
-		return
-	}
+	writer := &nonAggRowsWriter{w: nqp.w, headers: headersBytes}
+	defer func() {
+		if err != nil {
+			writer.writeError(err)
+		}
+	}()
 
-	for _, node := range nqp.nodes {
-		go func(n *StreamingScanNode) {
-			var bs []byte
-			bs, err = n.Execute(ctx)
-			utils.GetLogger().With("dataSize", len(bs), "error", err).Debug("sending result to result channel")
-			nqp.resultChan <- streamingScanNoderesult{
-				data: bs,
-				err:  err,
+	for i, node := range nqp.nodes {
+		go func(i int, n *StreamingScanNode) {
+			bs, nodeErr := n.Execute(ctx)
+			utils.GetContextLogger(ctx).With("dataSize", len(bs), "error", nodeErr).Debug("sending result to result channel")
+			// results are dropped once the query is done, since nobody reads them.
+			select {
+			case nqp.resultChan <- streamingScanNoderesult{
+				index: i,
+				data:  bs,
+				err:   nodeErr,
+			}:
+			case <-ctx.Done():
 			}
-		}(node)
+		}(i, node)
 	}
 
 	dataNodeWaitStart := utils.Now()
+	runningQuery := queryCom.GetRunningQuery(ctx)
+	runningQuery.SetPhase(queryCom.QueryPhaseWaitingOnDataNodes)
 
+	// failures of datanodes tolerated for partial results.
+	ntolerated := 0
+	// nodes finished by index, hosts of nodes not finished are reported once the deadline is exceeded.
+	finished := make([]bool, len(nqp.nodes))
+	// rows of datanodes sorted, merged once all datanodes finish.
+	var sorted []*sortedRows
 	for i := 0; i < len(nqp.nodes); i++ {
 		if nqp.getRowsWanted() == 0 {
-			utils.GetLogger().Debug("got enough rows, exiting")
+			// scans of datanodes not needed by the limit are canceled before finishing the response.
+			utils.GetContextLogger(ctx).With("pending", len(nqp.nodes)-i).Debug("got enough rows, canceling pending scans")
+			cancel()
 			break
 		}
-		res := <-nqp.resultChan
+		var res streamingScanNoderesult
+		select {
+		case res = <-nqp.resultChan:
+		case <-ctx.Done():
+			err = queryContextError(ctx, nqp.pendingHosts(finished))
+			return
+		}
+		if res.err != nil && ctx.Err() != nil {
+			// the datanode failed by the context rather than by itself.
+			err = queryContextError(ctx, nqp.pendingHosts(finished))
+			return
+		}
+		finished[res.index] = true
 
 		if i == 0 {
 			// only log time waited for the fastest datanode for now
@@ -160,53 +326,506 @@ func (nqp *NonAggQueryPlan) Execute(ctx context.Context) (err error) {
 		}
 
 		if res.err != nil {
+			// the query fails if all datanodes fail.
+			if ntolerated+1 < len(nqp.nodes) && nqp.partial.tolerate(res.err) {
+				ntolerated++
+				continue
+			}
 			err = res.err
 			return
 		}
+		if nqp.sorts != nil {
+			if nqp.sortedBytes+len(res.data) > nqp.maxSortBytes {
+				err = sortBytesExceededError(nqp.maxSortBytes)
+				return
+			}
+			var rows *sortedRows
+			if rows, err = nqp.sortRows(res.data); err != nil {
+				err = invalidRowsError(nqp.nodes[res.index].host.ID(), err)
+				return
+			}
+			sorted = append(sorted, rows)
+			continue
+		}
+		runningQuery.SetPhase(queryCom.QueryPhaseStreaming)
 		// write rows
-		if nqp.limit < 0 {
-			// when no limit, flush data directly
-			nqp.w.Write(res.data)
+		if nqp.limit < 0 && nqp.dedup == nil && nqp.skipped == nqp.offset {
+			// when no limit, flush data directly without the trailing comma left by datanodes.
+			err = writer.writeRows(bytes.TrimRight(bytes.TrimSpace(res.data), ","))
+			writer.flush()
 		} else {
-			// with limit, we have to deserialize
+			// with limit or dedup, we have to deserialize, only rows wanted by the limit unless rows are dropped
+			// by dedup.
 			serDeStart := utils.Now()
-
-			res.data = append([]byte("["), res.data[:]...)
-			res.data = append(res.data, byte(']'))
-			var resultData [][]interface{}
-			err = json.Unmarshal(res.data, &resultData)
-
+			maxRows := -1
+			if nqp.dedup == nil && nqp.limit >= 0 {
+				maxRows = nqp.offset - nqp.skipped + nqp.getRowsWanted()
+			}
+			var rows []json.RawMessage
+			rows, err = parseNonAggRows(res.data, maxRows)
 			if err != nil {
+				err = invalidRowsError(nqp.nodes[res.index].host.ID(), err)
 				return
 			}
-
-			if len(resultData) <= nqp.getRowsWanted() {
-				nqp.w.Write(res.data[1 : len(res.data)-1])
-				nqp.flushed += len(resultData)
-				utils.GetLogger().With("nrows", len(resultData)).Debug("flushed batch")
-			} else {
-				rowsToFlush := nqp.getRowsWanted()
-				dataToFlush := resultData[:rowsToFlush]
-				var bs []byte
-				bs, err = json.Marshal(dataToFlush)
-				if err != nil {
-					return
-				}
-				nqp.w.Write(bs[1 : len(bs)-1])
-				nqp.flushed += rowsToFlush
-				utils.GetLogger().With("nrows", rowsToFlush).Debug("flushed rows")
+			if nqp.dedup != nil {
+				rows = nqp.dedup.filter(rows)
+			}
+			skip := nqp.offset - nqp.skipped
+			if skip > len(rows) {
+				skip = len(rows)
+			}
+			nqp.skipped += skip
+			if nqp.limit >= 0 && len(rows)-skip > nqp.getRowsWanted() {
+				rows = rows[:skip+nqp.getRowsWanted()]
 			}
+			if nqp.dedup != nil {
+				// rows skipped are tracked too, so that their duplicates of other datanodes are not flushed.
+				nqp.dedup.track(rows)
+			}
+			rows = rows[skip:]
+			data := make([][]byte, len(rows))
+			for j, row := range rows {
+				data[j] = row
+			}
+			err = writer.writeRows(bytes.Join(data, []byte(`,`)))
+			writer.flush()
+			nqp.flushed += len(rows)
+			runningQuery.AddRows(len(rows))
+			utils.GetContextLogger(ctx).With("nrows", len(rows)).Debug("flushed rows")
 			utils.GetRootReporter().GetTimer(utils.TimeSerDeDataNodeResponse).Record(utils.Now().Sub(serDeStart))
 		}
-		if !(nqp.getRowsWanted() == 0) && i != len(nqp.nodes)-1 {
-			nqp.w.Write([]byte(`,`))
+		if err != nil {
+			return
 		}
 	}
 
-	_, err = nqp.w.Write([]byte(`]}`))
+	if nqp.sorts != nil {
+		runningQuery.SetPhase(queryCom.QueryPhaseStreaming)
+		limit := nqp.limit
+		if limit >= 0 {
+			limit += nqp.offset
+		}
+		err = mergeSortedRows(sorted, nqp.sorts, limit, func(row json.RawMessage) error {
+			if nqp.skipped < nqp.offset {
+				nqp.skipped++
+				return nil
+			}
+			nqp.flushed++
+			runningQuery.AddRows(1)
+			return writer.writeRows(row)
+		})
+		if err != nil {
+			return
+		}
+		writer.flush()
+		utils.GetContextLogger(ctx).With("nrows", nqp.flushed).Debug("flushed sorted rows")
+	}
+
+	if err = writer.closeRows(); err != nil {
+		return
+	}
+	// errors of failed datanodes are written after rows, since rows are flushed before all datanodes finish.
+	if partialErrors := nqp.partial.errors(); partialErrors != nil {
+		var errorsBytes []byte
+		if errorsBytes, err = json.Marshal(partialErrors); err != nil {
+			return
+		}
+		if _, err = nqp.w.Write([]byte(`,"` + queryCom.ErrorsKey + `":`)); err != nil {
+			return
+		}
+		if _, err = nqp.w.Write(errorsBytes); err != nil {
+			return
+		}
+	}
+	_, err = nqp.w.Write([]byte(`}`))
+	return
+}
+
+// nonAggRowsWriter writes rows of datanodes into matrixData of non aggregation results. Headers are written
+// with the first rows, so that nothing is written if the first datanode fails and the query can be retried.
+// Rows of datanodes are separated by commas, datanodes without rows are skipped.
+type nonAggRowsWriter struct {
+	w http.ResponseWriter
+	// headers in json.
+	headers       []byte
+	prefixWritten bool
+	rowsWritten   bool
+	rowsClosed    bool
+}
+
+// writePrefix writes the status, the headers and the start of matrixData if not written yet. Proxies are
+// told not to buffer the response, so that rows are streamed to clients.
+func (rw *nonAggRowsWriter) writePrefix() error {
+	if rw.prefixWritten {
+		return nil
+	}
+	rw.prefixWritten = true
+	header := rw.w.Header()
+	header.Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+	header.Set(utils.HTTPAccelBufferingHeaderKey, "no")
+	rw.w.WriteHeader(http.StatusOK)
+	if _, err := rw.w.Write([]byte(`{"headers":`)); err != nil {
+		return err
+	}
+	if _, err := rw.w.Write(rw.headers); err != nil {
+		return err
+	}
+	_, err := rw.w.Write([]byte(`,"matrixData":[`))
+	return err
+}
+
+// writeRows writes comma separated rows of a datanode, nothing is written for empty rows.
+func (rw *nonAggRowsWriter) writeRows(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := rw.writePrefix(); err != nil {
+		return err
+	}
+	if rw.rowsWritten {
+		if _, err := rw.w.Write([]byte(`,`)); err != nil {
+			return err
+		}
+	}
+	rw.rowsWritten = true
+	_, err := rw.w.Write(data)
+	return err
+}
+
+// closeRows writes the end of matrixData, with the prefix if not written yet.
+func (rw *nonAggRowsWriter) closeRows() error {
+	if err := rw.writePrefix(); err != nil {
+		return err
+	}
+	rw.rowsClosed = true
+	_, err := rw.w.Write([]byte(`]`))
+	return err
+}
+
+// writeError writes the error of the query failed after rows are streamed after the rows, so that the
+// response is still valid json and clients can tell it from results truncated. The error is written in the
+// same structure as errors of v2 responses, with its code, whether it is retriable and errors of datanodes.
+// Nothing is written if rows are not streamed yet, and the error is responded instead.
+func (rw *nonAggRowsWriter) writeError(err error) {
+	if !rw.prefixWritten {
+		return
+	}
+	errorBytes, marshalErr := json.Marshal(apiCom.NewQueryErrorV2(err))
+	if marshalErr != nil {
+		return
+	}
+	if !rw.rowsClosed {
+		rw.w.Write([]byte(`]`))
+	}
+	rw.w.Write([]byte(`,"` + queryCom.ErrorKey + `":`))
+	rw.w.Write(errorBytes)
+	rw.w.Write([]byte(`}`))
+	rw.flush()
+}
+
+// flush flushes rows written to the client on chunk boundaries, e.g. rows of a datanode, if supported by the
+// response, so that rows are streamed to clients by compressed responses as well.
+func (rw *nonAggRowsWriter) flush() {
+	if flusher, ok := rw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// sortRows deserializes and sorts all rows of a datanode, rows already returned by other datanodes are
+// dropped if dedup is requested. Only rows up to the offset plus the limit are kept, since rows after them
+// are never merged.
+func (nqp *NonAggQueryPlan) sortRows(data []byte) (*sortedRows, error) {
+	serDeStart := utils.Now()
+	rows, err := parseNonAggRows(data, -1)
+	if err != nil {
+		return nil, err
+	}
+	if nqp.dedup != nil {
+		rows = nqp.dedup.filter(rows)
+		nqp.dedup.track(rows)
+	}
+	sorted, err := newSortedRows(rows, nqp.sorts)
+	utils.GetRootReporter().GetTimer(utils.TimeSerDeDataNodeResponse).Record(utils.Now().Sub(serDeStart))
+	if err != nil {
+		return nil, err
+	}
+	if nqp.limit >= 0 {
+		sorted.truncate(nqp.offset + nqp.limit)
+	}
+	nqp.sortedBytes += sorted.bytes()
+	return sorted, nil
+}
+
+// sortBytesExceededError is the error of sorted queries buffering more rows of datanodes than maxBytes.
+func sortBytesExceededError(maxBytes int) error {
+	return utils.WithCode(utils.ErrCodeRequestTooLarge, utils.StackError(nil,
+		"rows of datanodes to sort exceed the memory cap of %d bytes of broker, narrow the query by filters or remove its sorts",
+		maxBytes))
+}
+
+// invalidRowsError is the error of rows of the datanode failed to parse.
+func invalidRowsError(hostID string, err error) error {
+	hostErr := utils.HostError{Host: hostID, Code: utils.ErrCodeInternal, Message: utils.GetErrorMessage(err)}
+	codedErr := utils.WithCode(utils.ErrCodeDataNodeFailure, utils.StackError(err, "invalid rows from datanode %s", hostID))
+	codedErr.HostErrors = []utils.HostError{hostErr}
+	return codedErr
+}
+
+// pendingHosts returns ids of hosts of the nodes not finished.
+func (nqp *NonAggQueryPlan) pendingHosts(finished []bool) (hosts []string) {
+	for i, node := range nqp.nodes {
+		if !finished[i] {
+			hosts = append(hosts, node.host.ID())
+		}
+	}
 	return
 }
 
 func (nqp *NonAggQueryPlan) getRowsWanted() int {
 	return nqp.limit - nqp.flushed
 }
+
+const (
+	// defaultMaxDedupBytes is the default max bytes of hashes of rows tracked by a query.
+	defaultMaxDedupBytes = 64 << 20
+	// defaultMaxSortBytes is the default max bytes of rows of datanodes buffered by a sorted query.
+	defaultMaxSortBytes = 256 << 20
+	// dedupBytesPerRow is the estimated bytes of the hash of a row in the set of hashes.
+	dedupBytesPerRow = 16
+)
+
+// rowDedup drops rows of datanodes already flushed from other datanodes, by 64 bit hashes of rows as
+// serialized by datanodes. Duplicate rows within the rows of a datanode are kept, since they are genuine
+// rows of the data. Dedup is disabled once hashes of flushed rows exceed the memory cap.
+type rowDedup struct {
+	hashes    map[uint64]struct{}
+	maxHashes int
+	disabled  bool
+}
+
+// newRowDedup creates the dedup of rows tracking hashes of rows up to maxBytes, 0 means defaultMaxDedupBytes.
+func newRowDedup(maxBytes int) *rowDedup {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxDedupBytes
+	}
+	return &rowDedup{
+		hashes:    make(map[uint64]struct{}),
+		maxHashes: maxBytes / dedupBytesPerRow,
+	}
+}
+
+// filter returns rows of a datanode not flushed from other datanodes, in place.
+func (d *rowDedup) filter(rows []json.RawMessage) []json.RawMessage {
+	if d.disabled || len(d.hashes) == 0 {
+		return rows
+	}
+	filtered := rows[:0]
+	for _, row := range rows {
+		if _, exist := d.hashes[hashRow(row)]; !exist {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+// track tracks hashes of flushed rows of a datanode.
+func (d *rowDedup) track(rows []json.RawMessage) {
+	if d.disabled {
+		return
+	}
+	for _, row := range rows {
+		d.hashes[hashRow(row)] = struct{}{}
+	}
+	if len(d.hashes) > d.maxHashes {
+		d.disabled, d.hashes = true, nil
+		utils.GetRootReporter().GetCounter(utils.NonAggDedupDisabled).Inc(1)
+		utils.GetLogger().With("maxHashes", d.maxHashes).Warn("Disabled dedup of rows exceeding memory cap")
+	}
+}
+
+// hashRow returns the 64 bit hash of the serialized row.
+func hashRow(row json.RawMessage) uint64 {
+	h := fnv.New64a()
+	h.Write(row)
+	return h.Sum64()
+}
+
+// NewPaginatedNonAggQueryPlan creates the plan of a page of the paginated non aggregation query, resumed
+// from the progress of datanodes in the cursor if any.
+func NewPaginatedNonAggQueryPlan(qc *QueryContext, topo topology.Topology, client dataCli.DataNodeQueryClient, w http.ResponseWriter, cursor *queryCursor) (plan PaginatedNonAggQueryPlan, err error) {
+	plan.headers = dimensionHeaders(qc.AQLQuery.Dimensions)
+	plan.w = w
+	plan.pageSize = qc.AQLQuery.PageSize
+	plan.cursor = cursor
+	plan.deadline = qc.deadline
+
+	var assignment map[topology.Host][]uint32
+	assignment, err = calculateShardAssignment(qc, topo)
+	if err != nil {
+		return
+	}
+	var replicas map[topology.Host][]topology.Host
+	if replicas, err = assignedReplicas(qc, topo, assignment); err != nil {
+		return
+	}
+
+	// datanodes are scanned in the order of host ids.
+	var hosts []topology.Host
+	for host, shards := range assignment {
+		if len(shards) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID() < hosts[j].ID() })
+
+	nodes := make([]cursorNode, len(hosts))
+	plan.nodes = make([]*StreamingScanNode, len(hosts))
+	for i, host := range hosts {
+		shards := assignment[host]
+		sort.Slice(shards, func(a, b int) bool { return shards[a] < shards[b] })
+		nodes[i] = cursorNode{Host: host.ID(), Shards: shards}
+
+		// make deep copy
+		q := *qc.AQLQuery
+		q.Shards = nil
+		for _, shard := range shards {
+			q.Shards = append(q.Shards, int(shard))
+		}
+		// pages of replicas resume from the same offsets, since rows of shards are scanned in the same order.
+		plan.nodes[i] = &StreamingScanNode{
+			query:            q,
+			host:             host,
+			dataNodeClient:   client,
+			replicas:         replicas[host],
+			hedger:           qc.hedger,
+			schemaRetryDelay: qc.schemaRetryDelay,
+			breaker:          qc.breaker,
+			retryBudget:      qc.retryBudget,
+			callTimeout:      qc.dataNodeTimeout,
+		}
+	}
+	err = plan.cursor.resume(nodes)
+	return
+}
+
+// PaginatedNonAggQueryPlan implements QueryPlan for a page of paginated non aggregation queries. Datanodes
+// are queried one after another from their offsets in the cursor, so that pages have no duplicates or gaps
+// as long as the queried data does not change.
+type PaginatedNonAggQueryPlan struct {
+	w        http.ResponseWriter
+	headers  []string
+	nodes    []*StreamingScanNode
+	pageSize int
+	cursor   *queryCursor
+	// deadline of the query, zero for no deadline.
+	deadline time.Time
+}
+
+// Execute writes the page with the cursor of the next page, which is omitted for the last page.
+func (plan *PaginatedNonAggQueryPlan) Execute(ctx context.Context) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "broker.paginated_query_plan")
+	defer func() {
+		finishSpan(span, err)
+	}()
+	ctx, cancel := withQueryDeadline(ctx, plan.deadline)
+	defer cancel()
+
+	var headersBytes []byte
+	headersBytes, err = json.Marshal(plan.headers)
+	if err != nil {
+		return
+	}
+	writer := &nonAggRowsWriter{w: plan.w, headers: headersBytes}
+	defer func() {
+		if err != nil {
+			writer.writeError(err)
+		}
+	}()
+
+	runningQuery := queryCom.GetRunningQuery(ctx)
+	rowsWanted := plan.pageSize
+	for i, node := range plan.nodes {
+		progress := &plan.cursor.Nodes[i]
+		if rowsWanted == 0 {
+			break
+		}
+		if progress.Done {
+			continue
+		}
+
+		node.query.Offset, node.query.Limit = progress.Offset, rowsWanted
+		runningQuery.SetPhase(queryCom.QueryPhaseWaitingOnDataNodes)
+		var bs []byte
+		bs, err = node.Execute(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = queryContextError(ctx, []string{progress.Host})
+			}
+			return
+		}
+		var rows []json.RawMessage
+		rows, err = parseNonAggRows(bs, rowsWanted)
+		if err != nil {
+			err = invalidRowsError(progress.Host, err)
+			return
+		}
+		runningQuery.SetPhase(queryCom.QueryPhaseStreaming)
+		runningQuery.AddRows(len(rows))
+		data := make([][]byte, len(rows))
+		for j, row := range rows {
+			data[j] = row
+		}
+		if err = writer.writeRows(bytes.Join(data, []byte(`,`))); err != nil {
+			return
+		}
+		writer.flush()
+		progress.Offset += len(rows)
+		progress.Done = len(rows) < rowsWanted
+		rowsWanted -= len(rows)
+	}
+	if err = writer.closeRows(); err != nil {
+		return
+	}
+
+	if !plan.cursor.done() {
+		var cursor string
+		cursor, err = plan.cursor.encode()
+		if err != nil {
+			return
+		}
+		var cursorBytes []byte
+		cursorBytes, err = json.Marshal(cursor)
+		if err != nil {
+			return
+		}
+		plan.w.Write([]byte(`,"` + queryCom.CursorKey + `":`))
+		plan.w.Write(cursorBytes)
+	}
+	_, err = plan.w.Write([]byte(`}`))
+	return
+}
+
+// parseNonAggRows parses up to maxRows comma separated rows of non aggregation queries returned by
+// datanodes, which are left with a trailing comma when datanodes run out of rows before the limit. Rows are
+// decoded one at a time and the rest of the payload is abandoned once maxRows rows are parsed, all rows are
+// parsed if maxRows is negative.
+func parseNonAggRows(bs []byte, maxRows int) (rows []json.RawMessage, err error) {
+	bs = bytes.TrimRight(bytes.TrimSpace(bs), ",")
+	if len(bs) == 0 || maxRows == 0 {
+		return
+	}
+	// rows are decoded as elements of an array without copying the payload into one.
+	decoder := json.NewDecoder(io.MultiReader(
+		bytes.NewReader([]byte(`[`)), bytes.NewReader(bs), bytes.NewReader([]byte(`]`))))
+	if _, err = decoder.Token(); err != nil {
+		return
+	}
+	for decoder.More() && (maxRows < 0 || len(rows) < maxRows) {
+		var row json.RawMessage
+		if err = decoder.Decode(&row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return
+}
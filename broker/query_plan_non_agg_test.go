@@ -16,6 +16,9 @@ package broker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
@@ -27,7 +30,11 @@ import (
 	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
 	"github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/utils"
+	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strings"
+	"time"
 )
 
 var _ = ginkgo.Describe("non agg query plan", func() {
@@ -55,11 +62,12 @@ var _ = ginkgo.Describe("non agg query plan", func() {
 		mockShardSet := shardMock.ShardSet{}
 		mockTopo.On("Get").Return(&mockMap)
 		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
 		mockShardIds := []uint32{0, 1, 2, 3, 4, 5}
 		mockShardSet.On("AllIDs").Return(mockShardIds)
-		mockHost1 := &topoMock.Host{}
-		mockHost2 := &topoMock.Host{}
-		mockHost3 := &topoMock.Host{}
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockHost3 := topology.NewHost("host3", "host3:9374")
 		mockHosts := []topology.Host{
 			mockHost1,
 			mockHost2,
@@ -108,4 +116,687 @@ var _ = ginkgo.Describe("non agg query plan", func() {
 		Ω(w.Body.String()).Should(Equal(`{"headers":["field1","field2"],"matrixData":[["foo","1"],["bar","2"],["foo","1"]]}`))
 
 	})
+
+	ginkgo.It("should paginate rows of datanodes", func() {
+		q := common.AQLQuery{
+			Table:      "table1",
+			Measures:   []common.Measure{{Expr: "1"}},
+			Dimensions: []common.Dimension{{Expr: "field1"}},
+			PageSize:   2,
+		}
+		qc := QueryContext{
+			AQLQuery:              &q,
+			IsNonAggregationQuery: true,
+		}
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		// hosts are scanned by the order of ids.
+		mockMap.On("Hosts").Return([]topology.Host{mockHost2, mockHost1})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost2}, nil)
+
+		rowsByHost := map[topology.Host][]string{
+			mockHost1: {`["a"]`, `["b"]`, `["c"]`},
+			mockHost2: {`["d"]`, `["e"]`},
+		}
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query common.AQLQuery) []byte {
+				rows := rowsByHost[host]
+				if query.Offset >= len(rows) {
+					return []byte{}
+				}
+				rows = rows[query.Offset:]
+				if len(rows) > query.Limit {
+					return []byte(strings.Join(rows[:query.Limit], ","))
+				}
+				// datanodes leave a trailing comma when running out of rows.
+				return []byte(strings.Join(rows, ",") + ",")
+			}, nil)
+
+		executePage := func(cursor *queryCursor) (rows [][]string, nextCursor *queryCursor) {
+			w := httptest.NewRecorder()
+			plan, err := NewPaginatedNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w, cursor)
+			Ω(err).Should(BeNil())
+			Ω(plan.Execute(context.TODO())).Should(BeNil())
+
+			var page struct {
+				Headers    []string   `json:"headers"`
+				MatrixData [][]string `json:"matrixData"`
+				Cursor     string     `json:"cursor"`
+			}
+			Ω(json.Unmarshal(w.Body.Bytes(), &page)).Should(BeNil())
+			Ω(page.Headers).Should(Equal([]string{"field1"}))
+			if page.Cursor != "" {
+				nextCursor, err = decodeQueryCursor(page.Cursor, "fp")
+				Ω(err).Should(BeNil())
+			}
+			return page.MatrixData, nextCursor
+		}
+
+		expiresAt := utils.Now().Unix() + 600
+		rows, cursor := executePage(&queryCursor{Fingerprint: "fp", ExpiresAt: expiresAt})
+		Ω(rows).Should(Equal([][]string{{"a"}, {"b"}}))
+		Ω(cursor.Nodes).Should(Equal([]cursorNode{
+			{Host: "host1", Shards: []uint32{0}, Offset: 2},
+			{Host: "host2", Shards: []uint32{1}},
+		}))
+
+		rows, cursor = executePage(cursor)
+		Ω(rows).Should(Equal([][]string{{"c"}, {"d"}}))
+		Ω(cursor.Nodes).Should(Equal([]cursorNode{
+			{Host: "host1", Shards: []uint32{0}, Offset: 3, Done: true},
+			{Host: "host2", Shards: []uint32{1}, Offset: 1},
+		}))
+
+		rows, cursor = executePage(cursor)
+		Ω(rows).Should(Equal([][]string{{"e"}}))
+		Ω(cursor).Should(BeNil())
+
+		// cursors are rejected when shards are assigned to different hosts.
+		_, err := NewPaginatedNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, httptest.NewRecorder(), &queryCursor{
+			Nodes: []cursorNode{{Host: "host1", Shards: []uint32{0, 1}}},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidCursor))
+		Ω(fmt.Sprint(err)).Should(ContainSubstring("shard assignment changed"))
+	})
+
+	ginkgo.It("should drop rows duplicated across datanodes if requested", func() {
+		q := common.AQLQuery{
+			Table:      "table1",
+			Measures:   []common.Measure{{Expr: "1"}},
+			Dimensions: []common.Dimension{{Expr: "field1"}},
+			Limit:      -1,
+		}
+		qc := QueryContext{AQLQuery: &q, IsNonAggregationQuery: true, DedupRows: true}
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		mockHost1, mockHost2 := topology.NewHost("host1", "host1:9374"), topology.NewHost("host2", "host2:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost2}, nil)
+
+		// both datanodes return rows of the shard moving between them, duplicate rows of a datanode are kept.
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		onShard := func(shard int) interface{} {
+			return mock.MatchedBy(func(q common.AQLQuery) bool { return q.Shards[0] == shard })
+		}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, onShard(0)).
+			Return([]byte(`["foo"],["bar"],["bar"],`), nil)
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, onShard(1)).
+			Return([]byte(`["foo"],["bar"],`), nil)
+
+		execute := func() string {
+			w := httptest.NewRecorder()
+			plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+			Ω(err).Should(BeNil())
+			Ω(plan.Execute(context.TODO())).Should(BeNil())
+			return w.Body.String()
+		}
+		Ω(execute()).Should(Or(
+			Equal(`{"headers":["field1"],"matrixData":[["foo"],["bar"],["bar"]]}`),
+			Equal(`{"headers":["field1"],"matrixData":[["foo"],["bar"]]}`)))
+
+		qc.AQLQuery.Limit = 1
+		Ω(execute()).Should(Equal(`{"headers":["field1"],"matrixData":[["foo"]]}`))
+
+		// rows are not deduped once hashes of rows exceed the memory cap.
+		qc.AQLQuery.Limit = -1
+		qc.maxDedupBytes = dedupBytesPerRow
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		Ω(execute()).Should(Or(
+			Equal(`{"headers":["field1"],"matrixData":[["foo"],["bar"],["bar"],["foo"],["bar"]]}`),
+			Equal(`{"headers":["field1"],"matrixData":[["foo"],["bar"],["foo"],["bar"],["bar"]]}`)))
+		Ω(testScope.Snapshot().Counters()).Should(HaveKey("test.non_agg_dedup_disabled+component=query"))
+
+		// without dedup, rows of all datanodes are returned.
+		qc.DedupRows = false
+		Ω(execute()).Should(HaveLen(len(`{"headers":["field1"],"matrixData":[["foo"],["bar"],["bar"],["foo"],["bar"]]}`)))
+	})
+
+	ginkgo.It("should parse rows of datanodes up to the rows wanted", func() {
+		payload := []byte(` ["a", 1],[ "b",null ] ,["c",{"d": 2}], `)
+		rows, err := parseNonAggRows(payload, -1)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([]json.RawMessage{
+			json.RawMessage(`["a", 1]`), json.RawMessage(`[ "b",null ]`), json.RawMessage(`["c",{"d": 2}]`)}))
+
+		rows, err = parseNonAggRows(payload, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(HaveLen(2))
+		rows, err = parseNonAggRows(payload, 0)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(BeEmpty())
+
+		// the rest of the payload is abandoned once enough rows are parsed.
+		rows, err = parseNonAggRows([]byte(`["a"],["b"],[truncated`), 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(HaveLen(2))
+		_, err = parseNonAggRows([]byte(`["a"],["b"],[truncated`), 3)
+		Ω(err).ShouldNot(BeNil())
+		_, err = parseNonAggRows([]byte(`["a"] ["b"]`), -1)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("should skip datanodes without rows", func() {
+		for _, payloads := range [][]string{
+			{``, `["a"]`, `["b"]`},
+			{`["a"]`, ``, `["b"]`},
+			{`["a"]`, `["b"]`, ``},
+		} {
+			w := httptest.NewRecorder()
+			writer := &nonAggRowsWriter{w: w, headers: []byte(`["field1"]`)}
+			for _, payload := range payloads {
+				Ω(writer.writeRows([]byte(payload))).Should(BeNil())
+			}
+			Ω(writer.writePrefix()).Should(BeNil())
+			Ω(w.Body.String()).Should(Equal(`{"headers":["field1"],"matrixData":[["a"],["b"]`))
+		}
+
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1, 2})
+		var mockHosts []topology.Host
+		for i := 0; i < 3; i++ {
+			host := topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i))
+			mockHosts = append(mockHosts, host)
+			mockMap.On("RouteShard", uint32(i)).Return([]topology.Host{host}, nil)
+		}
+		mockMap.On("Hosts").Return([]topology.Host{mockHosts[0], mockHosts[1], mockHosts[2]})
+
+		execute := func(limit int, payloads ...string) string {
+			mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+			for i, host := range mockHosts {
+				mockDatanodeCli.On("QueryRaw", mock.Anything, host, mock.Anything).Return([]byte(payloads[i]), nil)
+			}
+			qc := QueryContext{
+				AQLQuery: &common.AQLQuery{
+					Table:      "table1",
+					Measures:   []common.Measure{{Expr: "1"}},
+					Dimensions: []common.Dimension{{Expr: "field1"}},
+					Limit:      limit,
+				},
+				IsNonAggregationQuery: true,
+			}
+			w := httptest.NewRecorder()
+			plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+			Ω(err).Should(BeNil())
+			Ω(plan.Execute(context.Background())).Should(BeNil())
+			return w.Body.String()
+		}
+
+		// with and without limits, datanodes leave trailing commas.
+		for _, limit := range []int{-1, 10} {
+			Ω(execute(limit, ``, ` `, "\n")).Should(Equal(`{"headers":["field1"],"matrixData":[]}`))
+
+			var result struct {
+				MatrixData [][]string `json:"matrixData"`
+			}
+			Ω(json.Unmarshal([]byte(execute(limit, `["a"],`, ``, `["b"],["c"],`)), &result)).Should(BeNil())
+			Ω(result.MatrixData).Should(ConsistOf([]string{"a"}, []string{"b"}, []string{"c"}))
+		}
+	})
+
+	ginkgo.It("should stream rows with headers and write errors after rows", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		var mockHosts []topology.Host
+		for i := 0; i < 2; i++ {
+			host := topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i))
+			mockHosts = append(mockHosts, host)
+			mockMap.On("RouteShard", uint32(i)).Return([]topology.Host{host}, nil)
+		}
+		mockMap.On("Hosts").Return([]topology.Host{mockHosts[0], mockHosts[1]})
+
+		execute := func(err1 error) (*httptest.ResponseRecorder, error) {
+			mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+			mockDatanodeCli.On("QueryRaw", mock.Anything, mockHosts[0], mock.Anything).Return([]byte(`["a"],`), nil)
+			mockDatanodeCli.On("QueryRaw", mock.Anything, mockHosts[1], mock.Anything).Return(
+				func(ctx context.Context, host topology.Host, query common.AQLQuery) []byte {
+					// fails after rows of host0 are streamed.
+					time.Sleep(20 * time.Millisecond)
+					if err1 != nil {
+						return nil
+					}
+					return []byte(`["b"],`)
+				},
+				func(ctx context.Context, host topology.Host, query common.AQLQuery) error {
+					return err1
+				})
+			qc := QueryContext{
+				AQLQuery: &common.AQLQuery{
+					Table:      "table1",
+					Measures:   []common.Measure{{Expr: "1"}},
+					Dimensions: []common.Dimension{{Expr: "field1"}},
+					Limit:      -1,
+				},
+				IsNonAggregationQuery: true,
+			}
+			w := httptest.NewRecorder()
+			plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+			Ω(err).Should(BeNil())
+			return w, plan.Execute(context.Background())
+		}
+
+		w, err := execute(nil)
+		Ω(err).Should(BeNil())
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Flushed).Should(BeTrue())
+		Ω(w.Header().Get(utils.HTTPContentTypeHeaderKey)).Should(Equal(utils.HTTPContentTypeApplicationJson))
+		Ω(w.Header().Get(utils.HTTPAccelBufferingHeaderKey)).Should(Equal("no"))
+		Ω(w.Body.String()).Should(MatchJSON(`{"headers":["field1"],"matrixData":[["a"],["b"]]}`))
+
+		w, err = execute(errors.New("host1 failed"))
+		Ω(err).ShouldNot(BeNil())
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		var result map[string]interface{}
+		Ω(json.Unmarshal(w.Body.Bytes(), &result)).Should(BeNil())
+		Ω(result[common.MatrixDataKey]).Should(Equal([]interface{}{[]interface{}{"a"}}))
+		Ω(result[common.ErrorKey]).Should(HaveKeyWithValue("code", string(utils.ErrCodeDataNodeFailure)))
+		Ω(result[common.ErrorKey]).Should(HaveKeyWithValue("retriable", true))
+		Ω(result[common.ErrorKey]).Should(HaveKeyWithValue("message", ContainSubstring("host1 failed")))
+		Ω(result[common.ErrorKey]).Should(HaveKeyWithValue("hosts", ConsistOf(
+			HaveKeyWithValue("host", "host1"))))
+		Ω(result[common.ErrorKey]).ShouldNot(HaveKeyWithValue("message", ContainSubstring("goroutine")))
+	})
+
+	ginkgo.It("should sort and merge rows of datanodes", func() {
+		sorts := []rowSort{{index: 0, numeric: true}, {index: 1, descending: true}}
+		node1, err := newSortedRows([]json.RawMessage{
+			json.RawMessage(`["10","a"]`),
+			json.RawMessage(`["9","b"]`),
+			json.RawMessage(`[null,"c"]`),
+			json.RawMessage(`["9","c"]`),
+		}, sorts)
+		Ω(err).Should(BeNil())
+		// numbers are compared as numbers, nulls first.
+		Ω(node1.rows).Should(Equal([]json.RawMessage{
+			json.RawMessage(`[null,"c"]`),
+			json.RawMessage(`["9","c"]`),
+			json.RawMessage(`["9","b"]`),
+			json.RawMessage(`["10","a"]`),
+		}))
+		node2, err := newSortedRows([]json.RawMessage{
+			json.RawMessage(`["9.5",null]`),
+			json.RawMessage(`["9","c"]`),
+			json.RawMessage(`["n/a","d"]`),
+		}, sorts)
+		Ω(err).Should(BeNil())
+		_, err = newSortedRows([]json.RawMessage{json.RawMessage(`{}`)}, sorts)
+		Ω(err).ShouldNot(BeNil())
+
+		merge := func(limit int) (merged []string) {
+			Ω(mergeSortedRows([]*sortedRows{node1, {}, node2}, sorts, limit, func(row json.RawMessage) error {
+				merged = append(merged, string(row))
+				return nil
+			})).Should(BeNil())
+			return
+		}
+		// rows of the same keys are merged in the order of datanodes, numbers before other strings.
+		Ω(merge(-1)).Should(Equal([]string{
+			`[null,"c"]`, `["9","c"]`, `["9","c"]`, `["9","b"]`, `["9.5",null]`, `["10","a"]`, `["n/a","d"]`,
+		}))
+		Ω(merge(3)).Should(Equal([]string{`[null,"c"]`, `["9","c"]`, `["9","c"]`}))
+		Ω(merge(0)).Should(BeEmpty())
+
+		// string columns are compared as strings.
+		stringSorts := []rowSort{{index: 0}}
+		node3, err := newSortedRows([]json.RawMessage{json.RawMessage(`["9"]`), json.RawMessage(`["10"]`)}, stringSorts)
+		Ω(err).Should(BeNil())
+		Ω(node3.rows).Should(Equal([]json.RawMessage{json.RawMessage(`["10"]`), json.RawMessage(`["9"]`)}))
+	})
+
+	ginkgo.It("should merge rows of datanodes by sorts of the query", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		var mockHosts []topology.Host
+		for i := 0; i < 2; i++ {
+			host := topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i))
+			mockHosts = append(mockHosts, host)
+			mockMap.On("RouteShard", uint32(i)).Return([]topology.Host{host}, nil)
+		}
+		mockMap.On("Hosts").Return([]topology.Host{mockHosts[0], mockHosts[1]})
+
+		payloads := []string{`["3","x"],["1","y"],["12","z"],`, `["2","x"],[null,"y"],["4","x"],`}
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		for i, host := range mockHosts {
+			// datanodes return all rows for broker to sort.
+			mockDatanodeCli.On("QueryRaw", mock.Anything, host, mock.MatchedBy(func(q common.AQLQuery) bool {
+				return q.Limit == -1 && len(q.Sorts) == 2
+			})).Return([]byte(payloads[i]), nil)
+		}
+
+		qc := QueryContext{
+			AQLQuery: &common.AQLQuery{
+				Table:      "table1",
+				Measures:   []common.Measure{{Expr: "1"}},
+				Dimensions: []common.Dimension{{Expr: "field1"}, {Expr: "field2"}},
+				Sorts:      []common.SortField{{Name: "field2", Order: "desc"}, {Name: "field1"}},
+				Limit:      4,
+			},
+			IsNonAggregationQuery: true,
+			DedupRows:             true,
+			sorts:                 []rowSort{{index: 1, descending: true}, {index: 0, numeric: true}},
+		}
+		w := httptest.NewRecorder()
+		plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+		Ω(err).Should(BeNil())
+		Ω(plan.Execute(context.Background())).Should(BeNil())
+		Ω(w.Body.String()).Should(Equal(`{"headers":["field1","field2"],"matrixData":[["12","z"],[null,"y"],["1","y"],["2","x"]]}`))
+		Ω(plan.flushed).Should(Equal(4))
+	})
+
+	ginkgo.It("should cap rows of datanodes buffered by sorts", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		var mockHosts []topology.Host
+		for i := 0; i < 2; i++ {
+			host := topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i))
+			mockHosts = append(mockHosts, host)
+			mockMap.On("RouteShard", uint32(i)).Return([]topology.Host{host}, nil)
+		}
+		mockMap.On("Hosts").Return([]topology.Host{mockHosts[0], mockHosts[1]})
+
+		payloads := []string{`["3"],["1"],["12"],`, `["2"],["5"],["4"],`}
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		for i, host := range mockHosts {
+			mockDatanodeCli.On("QueryRaw", mock.Anything, host, mock.Anything).Return([]byte(payloads[i]), nil)
+		}
+		execute := func(limit, maxSortBytes int) (*NonAggQueryPlan, error) {
+			qc := QueryContext{
+				AQLQuery: &common.AQLQuery{
+					Table:      "table1",
+					Measures:   []common.Measure{{Expr: "1"}},
+					Dimensions: []common.Dimension{{Expr: "field1"}},
+					Limit:      limit,
+				},
+				IsNonAggregationQuery: true,
+				sorts:                 []rowSort{{index: 0, numeric: true}},
+				maxSortBytes:          maxSortBytes,
+			}
+			plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, httptest.NewRecorder())
+			Ω(err).Should(BeNil())
+			return &plan, plan.Execute(context.Background())
+		}
+
+		// only rows within the limit of each datanode are kept once sorted.
+		plan, err := execute(1, 0)
+		Ω(err).Should(BeNil())
+		Ω(plan.maxSortBytes).Should(Equal(defaultMaxSortBytes))
+		Ω(plan.sortedBytes).Should(Equal(len(`["1"]`) + len(`["2"]`)))
+
+		// the query fails once rows buffered exceed the cap.
+		_, err = execute(-1, 20)
+		Ω(err).ShouldNot(BeNil())
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeRequestTooLarge))
+		Ω(err.Error()).Should(ContainSubstring("exceed the memory cap of 20 bytes"))
+
+		plan, err = execute(-1, 40)
+		Ω(err).Should(BeNil())
+		Ω(plan.sortedBytes).Should(Equal(31))
+	})
+
+	ginkgo.It("should skip rows of datanodes by the offset", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1, 2})
+		var mockHosts []topology.Host
+		for i := 0; i < 3; i++ {
+			host := topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i))
+			mockHosts = append(mockHosts, host)
+			mockMap.On("RouteShard", uint32(i)).Return([]topology.Host{host}, nil)
+		}
+		mockMap.On("Hosts").Return([]topology.Host{mockHosts[0], mockHosts[1], mockHosts[2]})
+
+		payloads := []string{`["1"],["2"],["3"],`, ``, `["4"],["5"],["1"],`}
+		execute := func(limit, offset int, dedup bool, sorts []rowSort) [][]string {
+			mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+			for i, host := range mockHosts {
+				// datanodes return rows up to the offset plus the limit without skipping rows.
+				expectedLimit := limit + offset
+				if limit < 0 || sorts != nil {
+					expectedLimit = -1
+				}
+				mockDatanodeCli.On("QueryRaw", mock.Anything, host, mock.MatchedBy(func(q common.AQLQuery) bool {
+					return q.Offset == 0 && q.Limit == expectedLimit
+				})).Return([]byte(payloads[i]), nil)
+			}
+			qc := QueryContext{
+				AQLQuery: &common.AQLQuery{
+					Table:      "table1",
+					Measures:   []common.Measure{{Expr: "1"}},
+					Dimensions: []common.Dimension{{Expr: "field1"}},
+					Limit:      limit,
+					Offset:     offset,
+				},
+				IsNonAggregationQuery: true,
+				DedupRows:             dedup,
+				sorts:                 sorts,
+			}
+			if dedup {
+				qc.maxDedupBytes = defaultMaxDedupBytes
+			}
+			w := httptest.NewRecorder()
+			plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+			Ω(err).Should(BeNil())
+			Ω(plan.Execute(context.Background())).Should(BeNil())
+			var result struct {
+				MatrixData [][]string `json:"matrixData"`
+			}
+			Ω(json.Unmarshal(w.Body.Bytes(), &result)).Should(BeNil())
+			return result.MatrixData
+		}
+
+		for _, tc := range []struct {
+			limit, offset, expected int
+		}{
+			{-1, 0, 6}, {-1, 2, 4}, {-1, 6, 0}, {-1, 10, 0},
+			{2, 1, 2}, {3, 3, 3}, {3, 4, 2}, {10, 5, 1}, {2, 6, 0},
+		} {
+			Ω(execute(tc.limit, tc.offset, false, nil)).Should(HaveLen(tc.expected))
+		}
+
+		// rows skipped are counted after dropping duplicates across datanodes.
+		Ω(execute(-1, 2, true, nil)).Should(HaveLen(3))
+		Ω(execute(10, 4, true, nil)).Should(HaveLen(1))
+
+		// the offset skips the leading rows merged by sorts.
+		sorts := []rowSort{{index: 0, descending: true, numeric: true}}
+		Ω(execute(2, 1, false, sorts)).Should(Equal([][]string{{"4"}, {"3"}}))
+		Ω(execute(-1, 3, false, sorts)).Should(Equal([][]string{{"2"}, {"1"}, {"1"}}))
+		Ω(execute(3, 4, true, sorts)).Should(Equal([][]string{{"1"}}))
+	})
+
+	ginkgo.It("should name headers by aliases of dimensions", func() {
+		q := common.AQLQuery{
+			Table:    "table1",
+			Measures: []common.Measure{{Expr: "1"}},
+			Dimensions: []common.Dimension{
+				{Expr: "city_id", Alias: "city"},
+				{Expr: "fare"},
+				{Expr: "status", Alias: "trip_status"},
+			},
+			Limit: -1,
+		}
+		qc := QueryContext{
+			AQLQuery:              &q,
+			IsNonAggregationQuery: true,
+		}
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0})
+		mockHost := topology.NewHost("host1", "host1:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost}, nil)
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, mock.Anything).Return([]byte(`["1","2.5","completed"]`), nil)
+
+		w := httptest.NewRecorder()
+		plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+		Ω(err).Should(BeNil())
+		Ω(plan.Execute(context.TODO())).Should(BeNil())
+		Ω(w.Body.String()).Should(Equal(`{"headers":["city","fare","trip_status"],"matrixData":[["1","2.5","completed"]]}`))
+		// datanodes are still queried by expressions of dimensions.
+		Ω(plan.nodes[0].query.Dimensions).Should(Equal(q.Dimensions))
+
+		q.PageSize = 10
+		paginatedPlan, err := NewPaginatedNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, httptest.NewRecorder(),
+			&queryCursor{Fingerprint: "fp", ExpiresAt: utils.Now().Unix() + 600})
+		Ω(err).Should(BeNil())
+		Ω(paginatedPlan.headers).Should(Equal([]string{"city", "fare", "trip_status"}))
+	})
+
+	ginkgo.It("should cancel scans of datanodes once the limit is satisfied", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost2}, nil)
+
+		// host2 scans until canceled, host1 responds once host2 started.
+		started := make(chan struct{})
+		canceled := make(chan struct{})
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mockHost1, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query common.AQLQuery) []byte {
+				<-started
+				return []byte(`["foo"],["bar"]`)
+			}, nil)
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mockHost2, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query common.AQLQuery) []byte {
+				close(started)
+				<-ctx.Done()
+				close(canceled)
+				return nil
+			}, func(ctx context.Context, host topology.Host, query common.AQLQuery) error {
+				return ctx.Err()
+			})
+
+		qc := QueryContext{
+			AQLQuery: &common.AQLQuery{
+				Table:      "table1",
+				Measures:   []common.Measure{{Expr: "1"}},
+				Dimensions: []common.Dimension{{Expr: "field1"}},
+				Limit:      2,
+			},
+			IsNonAggregationQuery: true,
+		}
+		w := httptest.NewRecorder()
+		plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, w)
+		Ω(err).Should(BeNil())
+		Ω(plan.Execute(context.Background())).Should(BeNil())
+		Ω(w.Body.String()).Should(Equal(`{"headers":["field1"],"matrixData":[["foo"],["bar"]]}`))
+		Eventually(canceled).Should(BeClosed())
+	})
+
+	ginkgo.It("should stop executing once the context is done", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1})
+		mockHost1 := topology.NewHost("host1", "host1:9374")
+		mockHost2 := topology.NewHost("host2", "host2:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost2}, nil)
+
+		// datanodes not honoring the context until released.
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		mockDatanodeCli := dataCliMock.DataNodeQueryClient{}
+		mockDatanodeCli.On("QueryRaw", mock.Anything, mock.Anything, mock.Anything).Return(
+			func(ctx context.Context, host topology.Host, query common.AQLQuery) []byte {
+				started <- struct{}{}
+				<-release
+				return nil
+			}, func(ctx context.Context, host topology.Host, query common.AQLQuery) error {
+				return errors.New("released")
+			})
+
+		// no retries with cancelled contexts.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		node := &StreamingScanNode{query: common.AQLQuery{Table: "table1"}, host: mockHost1, dataNodeClient: &mockDatanodeCli}
+		bs, err := node.Execute(ctx)
+		Ω(bs).Should(BeNil())
+		Ω(err).Should(Equal(context.Canceled))
+		mockDatanodeCli.AssertNotCalled(ginkgo.GinkgoT(), "QueryRaw", mock.Anything, mock.Anything, mock.Anything)
+
+		numGoroutines := runtime.NumGoroutine()
+		qc := QueryContext{
+			AQLQuery: &common.AQLQuery{
+				Table:      "table1",
+				Measures:   []common.Measure{{Expr: "1"}},
+				Dimensions: []common.Dimension{{Expr: "field1"}},
+				Limit:      -1,
+			},
+			IsNonAggregationQuery: true,
+		}
+		plan, err := NewNonAggQueryPlan(&qc, &mockTopo, &mockDatanodeCli, httptest.NewRecorder())
+		Ω(err).Should(BeNil())
+		ctx, cancel = context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- plan.Execute(ctx)
+		}()
+		Eventually(started).Should(Receive())
+		Eventually(started).Should(Receive())
+		cancel()
+		Eventually(done).Should(Receive(Equal(context.Canceled)))
+
+		// nodes stop retrying once released, without results left to send.
+		close(release)
+		Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", numGoroutines))
+		mockDatanodeCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "QueryRaw", 2)
+	})
 })
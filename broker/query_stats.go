@@ -0,0 +1,365 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultQueryStatsMaxFingerprints   = 1000
+	defaultQueryStatsWindowSec         = 600
+	defaultQueryStatsTopN              = 10
+	defaultQueryStatsReportIntervalSec = 60
+	// number of slots of the sliding window, stats expire a slot at a time.
+	queryStatsWindowSlots = 10
+)
+
+// Metrics to sort query stats by.
+const (
+	QueryStatsSortByCount         = "count"
+	QueryStatsSortByErrors        = "errors"
+	QueryStatsSortByLatencyP50    = "latencyP50"
+	QueryStatsSortByLatencyP90    = "latencyP90"
+	QueryStatsSortByLatencyP99    = "latencyP99"
+	QueryStatsSortByRowsScanned   = "rowsScanned"
+	QueryStatsSortByBytesReturned = "bytesReturned"
+)
+
+// upper bounds in milliseconds of buckets of the latency histogram, the last bucket is unbounded.
+var queryLatencyBucketBounds = [...]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 60000, 120000, 300000}
+
+// QueryFingerprintStats is the rolling statistics of queries of a fingerprint over the sliding window.
+// Percentiles of latencies are upper bounds of the histogram buckets they fall in, capped by the max.
+type QueryFingerprintStats struct {
+	Fingerprint string `json:"fingerprint"`
+	Table       string `json:"table"`
+	// the normalized query, see queryCom.NormalizeQuery.
+	Query            string    `json:"query"`
+	Count            int64     `json:"count"`
+	Errors           int64     `json:"errors"`
+	LatencyP50Millis float64   `json:"latencyP50Millis"`
+	LatencyP90Millis float64   `json:"latencyP90Millis"`
+	LatencyP99Millis float64   `json:"latencyP99Millis"`
+	MaxLatencyMillis float64   `json:"maxLatencyMillis"`
+	RowsScanned      int64     `json:"rowsScanned"`
+	BytesReturned    int64     `json:"bytesReturned"`
+	LastSeen         time.Time `json:"lastSeen"`
+}
+
+// queryStatsSlot is the stats of a fingerprint in a slot of the sliding window.
+type queryStatsSlot struct {
+	// index of the slot since epoch, slots of stale epochs are reset before reuse.
+	epoch            int64
+	count            int64
+	errors           int64
+	rowsScanned      int64
+	bytesReturned    int64
+	maxLatencyMillis float64
+	latencies        [len(queryLatencyBucketBounds) + 1]int64
+}
+
+// queryStatsEntry is the stats of a fingerprint in the lru list.
+type queryStatsEntry struct {
+	fingerprint string
+	table       string
+	query       string
+	lastSeen    time.Time
+	// ring buffer of slots indexed by epoch.
+	slots [queryStatsWindowSlots]queryStatsSlot
+}
+
+// QueryStatsTracker keeps rolling statistics of queries by fingerprint over a sliding window, so that query
+// shapes dominating load can be found. Fingerprints are kept in a lru list, cold ones are evicted when
+// there are too many of them. All methods are no-op on nil.
+type QueryStatsTracker struct {
+	sync.Mutex
+
+	maxFingerprints int
+	slotSeconds     int64
+	topN            int
+	reportInterval  time.Duration
+	stopChan        chan struct{}
+
+	// most recently seen first.
+	lru     *list.List
+	entries map[string]*list.Element
+	// fingerprints with metrics reported by the last report, by fingerprint to their tables.
+	reported map[string]string
+}
+
+// queryStatsRecord records the stats of a query when it finishes.
+type queryStatsRecord struct {
+	tracker     *QueryStatsTracker
+	fingerprint string
+	table       string
+	query       string
+	start       time.Time
+}
+
+// NewQueryStatsTracker creates a new QueryStatsTracker.
+func NewQueryStatsTracker(cfg config.QueryStatsConfig) *QueryStatsTracker {
+	maxFingerprints := cfg.MaxFingerprints
+	if maxFingerprints <= 0 {
+		maxFingerprints = defaultQueryStatsMaxFingerprints
+	}
+	windowSec := cfg.WindowSec
+	if windowSec <= 0 {
+		windowSec = defaultQueryStatsWindowSec
+	}
+	slotSeconds := int64(windowSec / queryStatsWindowSlots)
+	if slotSeconds <= 0 {
+		slotSeconds = 1
+	}
+	topN := cfg.TopN
+	if topN <= 0 {
+		topN = defaultQueryStatsTopN
+	}
+	reportIntervalSec := cfg.ReportIntervalSec
+	if reportIntervalSec <= 0 {
+		reportIntervalSec = defaultQueryStatsReportIntervalSec
+	}
+	return &QueryStatsTracker{
+		maxFingerprints: maxFingerprints,
+		slotSeconds:     slotSeconds,
+		topN:            topN,
+		reportInterval:  time.Duration(reportIntervalSec) * time.Second,
+		stopChan:        make(chan struct{}),
+		lru:             list.New(),
+		entries:         make(map[string]*list.Element),
+		reported:        make(map[string]string),
+	}
+}
+
+// Run reports metrics of top fingerprints periodically until stopped.
+func (t *QueryStatsTracker) Run() {
+	if t == nil {
+		return
+	}
+	ticker := time.NewTicker(t.reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.reportTopMetrics()
+		case <-t.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops reporting metrics.
+func (t *QueryStatsTracker) Stop() {
+	if t != nil {
+		close(t.stopChan)
+	}
+}
+
+// start starts recording stats of the query, it must be called before the query is mutated by compilation.
+func (t *QueryStatsTracker) start(aql *queryCom.AQLQuery) *queryStatsRecord {
+	if t == nil {
+		return nil
+	}
+	query := queryCom.NormalizeQuery(aql)
+	return &queryStatsRecord{
+		tracker:     t,
+		fingerprint: queryCom.FingerprintNormalizedQuery(query),
+		table:       aql.Table,
+		query:       query,
+		start:       utils.Now(),
+	}
+}
+
+// finish records the stats of the finished query.
+func (r *queryStatsRecord) finish(rowsScanned, bytesReturned int64, err error) {
+	if r == nil {
+		return
+	}
+	now := utils.Now()
+	latencyMillis := float64(now.Sub(r.start)) / float64(time.Millisecond)
+	t := r.tracker
+
+	t.Lock()
+	defer t.Unlock()
+	var entry *queryStatsEntry
+	if element, ok := t.entries[r.fingerprint]; ok {
+		t.lru.MoveToFront(element)
+		entry = element.Value.(*queryStatsEntry)
+	} else {
+		entry = &queryStatsEntry{fingerprint: r.fingerprint, table: r.table, query: r.query}
+		t.entries[r.fingerprint] = t.lru.PushFront(entry)
+		for t.lru.Len() > t.maxFingerprints {
+			delete(t.entries, t.lru.Remove(t.lru.Back()).(*queryStatsEntry).fingerprint)
+		}
+	}
+	entry.lastSeen = now
+
+	epoch := now.Unix() / t.slotSeconds
+	slot := &entry.slots[epoch%queryStatsWindowSlots]
+	if slot.epoch != epoch {
+		*slot = queryStatsSlot{epoch: epoch}
+	}
+	slot.count++
+	if err != nil {
+		slot.errors++
+	}
+	slot.rowsScanned += rowsScanned
+	slot.bytesReturned += bytesReturned
+	if latencyMillis > slot.maxLatencyMillis {
+		slot.maxLatencyMillis = latencyMillis
+	}
+	slot.latencies[sort.SearchFloat64s(queryLatencyBucketBounds[:], latencyMillis)]++
+}
+
+// Top returns stats of the top n fingerprints seen in the sliding window sorted by the metric descending,
+// n <= 0 returns all of them.
+func (t *QueryStatsTracker) Top(sortBy string, n int) ([]QueryFingerprintStats, error) {
+	var less func(a, b *QueryFingerprintStats) bool
+	switch sortBy {
+	case QueryStatsSortByCount, "":
+		less = func(a, b *QueryFingerprintStats) bool { return a.Count < b.Count }
+	case QueryStatsSortByErrors:
+		less = func(a, b *QueryFingerprintStats) bool { return a.Errors < b.Errors }
+	case QueryStatsSortByLatencyP50:
+		less = func(a, b *QueryFingerprintStats) bool { return a.LatencyP50Millis < b.LatencyP50Millis }
+	case QueryStatsSortByLatencyP90:
+		less = func(a, b *QueryFingerprintStats) bool { return a.LatencyP90Millis < b.LatencyP90Millis }
+	case QueryStatsSortByLatencyP99:
+		less = func(a, b *QueryFingerprintStats) bool { return a.LatencyP99Millis < b.LatencyP99Millis }
+	case QueryStatsSortByRowsScanned:
+		less = func(a, b *QueryFingerprintStats) bool { return a.RowsScanned < b.RowsScanned }
+	case QueryStatsSortByBytesReturned:
+		less = func(a, b *QueryFingerprintStats) bool { return a.BytesReturned < b.BytesReturned }
+	default:
+		return nil, utils.StackError(nil, "unknown metric to sort query stats by: %s", sortBy)
+	}
+
+	stats := []QueryFingerprintStats{}
+	if t == nil {
+		return stats, nil
+	}
+	t.Lock()
+	epoch := utils.Now().Unix() / t.slotSeconds
+	for element := t.lru.Front(); element != nil; element = element.Next() {
+		if s, ok := element.Value.(*queryStatsEntry).aggregate(epoch); ok {
+			stats = append(stats, s)
+		}
+	}
+	t.Unlock()
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return less(&stats[j], &stats[i])
+	})
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats, nil
+}
+
+// aggregate returns stats of the fingerprint over slots in the sliding window ending at the epoch, false
+// if the fingerprint is not seen in the window.
+func (e *queryStatsEntry) aggregate(epoch int64) (stats QueryFingerprintStats, ok bool) {
+	stats = QueryFingerprintStats{
+		Fingerprint: e.fingerprint,
+		Table:       e.table,
+		Query:       e.query,
+		LastSeen:    e.lastSeen,
+	}
+	var latencies [len(queryLatencyBucketBounds) + 1]int64
+	for i := range e.slots {
+		slot := &e.slots[i]
+		if slot.count == 0 || slot.epoch <= epoch-queryStatsWindowSlots || slot.epoch > epoch {
+			continue
+		}
+		stats.Count += slot.count
+		stats.Errors += slot.errors
+		stats.RowsScanned += slot.rowsScanned
+		stats.BytesReturned += slot.bytesReturned
+		if slot.maxLatencyMillis > stats.MaxLatencyMillis {
+			stats.MaxLatencyMillis = slot.maxLatencyMillis
+		}
+		for j, count := range slot.latencies {
+			latencies[j] += count
+		}
+	}
+	if stats.Count == 0 {
+		return stats, false
+	}
+	stats.LatencyP50Millis = latencyPercentile(latencies[:], stats.Count, 0.5, stats.MaxLatencyMillis)
+	stats.LatencyP90Millis = latencyPercentile(latencies[:], stats.Count, 0.9, stats.MaxLatencyMillis)
+	stats.LatencyP99Millis = latencyPercentile(latencies[:], stats.Count, 0.99, stats.MaxLatencyMillis)
+	return stats, true
+}
+
+// latencyPercentile returns the upper bound of the histogram bucket the percentile falls in, capped by the
+// max latency.
+func latencyPercentile(latencies []int64, count int64, percentile float64, maxLatencyMillis float64) float64 {
+	rank := int64(percentile*float64(count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range latencies {
+		seen += n
+		if seen >= rank {
+			if i < len(queryLatencyBucketBounds) && queryLatencyBucketBounds[i] < maxLatencyMillis {
+				return queryLatencyBucketBounds[i]
+			}
+			break
+		}
+	}
+	return maxLatencyMillis
+}
+
+// reportTopMetrics reports metrics of the top fingerprints by count tagged by fingerprints. Metrics of
+// fingerprints falling out of the top are reset to 0, so that only the top fingerprints have non zero
+// metrics.
+func (t *QueryStatsTracker) reportTopMetrics() {
+	stats, _ := t.Top(QueryStatsSortByCount, t.topN)
+	reported := make(map[string]string, len(stats))
+	reporter := utils.GetRootReporter()
+	for _, s := range stats {
+		tags := map[string]string{"fingerprint": s.Fingerprint, "table": s.Table}
+		reporter.GetChildGauge(tags, utils.TopQueryCount).Update(float64(s.Count))
+		reporter.GetChildGauge(tags, utils.TopQueryErrors).Update(float64(s.Errors))
+		reporter.GetChildGauge(tags, utils.TopQueryLatencyP50).Update(s.LatencyP50Millis)
+		reporter.GetChildGauge(tags, utils.TopQueryLatencyP99).Update(s.LatencyP99Millis)
+		reporter.GetChildGauge(tags, utils.TopQueryRowsScanned).Update(float64(s.RowsScanned))
+		reporter.GetChildGauge(tags, utils.TopQueryBytesReturned).Update(float64(s.BytesReturned))
+		reported[s.Fingerprint] = s.Table
+	}
+
+	t.Lock()
+	previous := t.reported
+	t.reported = reported
+	t.Unlock()
+	for fingerprint, table := range previous {
+		if _, ok := reported[fingerprint]; ok {
+			continue
+		}
+		tags := map[string]string{"fingerprint": fingerprint, "table": table}
+		for _, metric := range []utils.MetricName{utils.TopQueryCount, utils.TopQueryErrors, utils.TopQueryLatencyP50,
+			utils.TopQueryLatencyP99, utils.TopQueryRowsScanned, utils.TopQueryBytesReturned} {
+			reporter.GetChildGauge(tags, metric).Update(0)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("query stats", func() {
+	var now time.Time
+	var tracker *QueryStatsTracker
+
+	query := func(table, filter string) *queryCom.AQLQuery {
+		return &queryCom.AQLQuery{
+			Table:    table,
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+			Filters:  []string{filter},
+		}
+	}
+
+	// run records a query taking the latency.
+	run := func(aql *queryCom.AQLQuery, latency time.Duration, rowsScanned, bytesReturned int64, err error) {
+		record := tracker.start(aql)
+		now = now.Add(latency)
+		record.finish(rowsScanned, bytesReturned, err)
+	}
+
+	top := func(sortBy string, n int) []QueryFingerprintStats {
+		stats, err := tracker.Top(sortBy, n)
+		Ω(err).Should(BeNil())
+		return stats
+	}
+
+	fingerprints := func(stats []QueryFingerprintStats) []string {
+		var fingerprints []string
+		for _, s := range stats {
+			fingerprints = append(fingerprints, s.Fingerprint)
+		}
+		return fingerprints
+	}
+
+	ginkgo.BeforeEach(func() {
+		now = time.Unix(1570000000, 0)
+		utils.SetClockImplementation(func() time.Time {
+			return now
+		})
+		tracker = NewQueryStatsTracker(config.QueryStatsConfig{MaxFingerprints: 2, WindowSec: 100, TopN: 1})
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should keep stats by fingerprint", func() {
+		fp1 := queryCom.QueryFingerprint(query("trips", "city_id = 1"))
+		fp2 := queryCom.QueryFingerprint(query("trips", "status = 'completed'"))
+		for i := 1; i <= 10; i++ {
+			run(query("trips", "city_id = 1"), time.Duration(i)*time.Millisecond, 100, 10, nil)
+		}
+		run(query("trips", "city_id = 2"), 2*time.Second, 100, 10, errors.New("failed"))
+		run(query("trips", "status = 'completed'"), 300*time.Millisecond, 5000, 1, nil)
+
+		stats := top(QueryStatsSortByCount, 0)
+		Ω(fingerprints(stats)).Should(Equal([]string{fp1, fp2}))
+		Ω(stats[0]).Should(Equal(QueryFingerprintStats{
+			Fingerprint:      fp1,
+			Table:            "trips",
+			Query:            queryCom.NormalizeQuery(query("trips", "city_id = 1")),
+			Count:            11,
+			Errors:           1,
+			LatencyP50Millis: 10,
+			LatencyP90Millis: 10,
+			LatencyP99Millis: 2000,
+			MaxLatencyMillis: 2000,
+			RowsScanned:      1100,
+			BytesReturned:    110,
+			LastSeen:         now.Add(-300 * time.Millisecond),
+		}))
+		// percentiles are capped by the max latency.
+		Ω(stats[1].LatencyP50Millis).Should(Equal(300.0))
+
+		Ω(fingerprints(top(QueryStatsSortByRowsScanned, 0))).Should(Equal([]string{fp2, fp1}))
+		Ω(fingerprints(top(QueryStatsSortByLatencyP50, 0))).Should(Equal([]string{fp2, fp1}))
+		Ω(fingerprints(top(QueryStatsSortByLatencyP99, 1))).Should(Equal([]string{fp1}))
+		_, err := tracker.Top("foo", 0)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("should expire stats out of the sliding window", func() {
+		run(query("trips", "city_id = 1"), time.Millisecond, 0, 0, nil)
+		now = now.Add(50 * time.Second)
+		run(query("trips", "city_id = 1"), time.Millisecond, 0, 0, nil)
+		Ω(top(QueryStatsSortByCount, 0)[0].Count).Should(BeEquivalentTo(2))
+
+		now = now.Add(60 * time.Second)
+		Ω(top(QueryStatsSortByCount, 0)[0].Count).Should(BeEquivalentTo(1))
+		now = now.Add(50 * time.Second)
+		Ω(top(QueryStatsSortByCount, 0)).Should(BeEmpty())
+	})
+
+	ginkgo.It("should evict least recently seen fingerprints", func() {
+		run(query("trips", "city_id = 1"), time.Millisecond, 0, 0, nil)
+		run(query("trips", "status = 'completed'"), time.Millisecond, 0, 0, nil)
+		run(query("trips", "city_id = 2"), time.Millisecond, 0, 0, nil)
+		run(query("cities", "city_id = 1"), time.Millisecond, 0, 0, nil)
+
+		stats := top(QueryStatsSortByCount, 0)
+		Ω(fingerprints(stats)).Should(ConsistOf(
+			queryCom.QueryFingerprint(query("trips", "city_id = 1")),
+			queryCom.QueryFingerprint(query("cities", "city_id = 1"))))
+	})
+
+	ginkgo.It("should report metrics of top fingerprints", func() {
+		// report into a scope of this test only since gauges of previous runs are kept in the shared one.
+		testScope := tally.NewTestScope("test", nil)
+		utils.Init(utils.GetConfig(), utils.GetLogger(), utils.GetQueryLogger(), testScope)
+		defer utils.Init(utils.GetConfig(), utils.GetLogger(), utils.GetQueryLogger(), tally.NewTestScope("test", nil))
+		getCountGauge := func(fingerprint string) float64 {
+			gauge, exist := testScope.Snapshot().Gauges()["test.top_query_count+component=query,fingerprint="+fingerprint+",table=trips"]
+			if !exist {
+				return -1
+			}
+			return gauge.Value()
+		}
+		fp1 := queryCom.QueryFingerprint(query("trips", "city_id = 1"))
+		fp2 := queryCom.QueryFingerprint(query("trips", "status = 'completed'"))
+
+		run(query("trips", "city_id = 1"), time.Millisecond, 0, 0, nil)
+		run(query("trips", "city_id = 2"), time.Millisecond, 0, 0, nil)
+		run(query("trips", "status = 'completed'"), time.Millisecond, 0, 0, nil)
+		tracker.reportTopMetrics()
+		Ω(getCountGauge(fp1)).Should(Equal(2.0))
+		Ω(getCountGauge(fp2)).Should(Equal(-1.0))
+
+		for i := 0; i < 3; i++ {
+			run(query("trips", "status = 'canceled'"), time.Millisecond, 0, 0, nil)
+		}
+		tracker.reportTopMetrics()
+		Ω(getCountGauge(fp1)).Should(Equal(0.0))
+		Ω(getCountGauge(fp2)).Should(Equal(4.0))
+	})
+
+	ginkgo.It("should serve top queries in debug handler", func() {
+		run(query("trips", "city_id = 1"), time.Millisecond, 0, 0, nil)
+		handler := NewDebugHandler(nil, nil, nil, nil, nil, tracker, nil)
+
+		w := httptest.NewRecorder()
+		handler.GetTopQueries(w, httptest.NewRequest(http.MethodGet, "/debug/queries/top?sort=errors&n=10", nil))
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(ContainSubstring(queryCom.QueryFingerprint(query("trips", "city_id = 1"))))
+
+		for _, url := range []string{"/debug/queries/top?sort=foo", "/debug/queries/top?n=foo"} {
+			w = httptest.NewRecorder()
+			handler.GetTopQueries(w, httptest.NewRequest(http.MethodGet, url, nil))
+			Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		}
+	})
+
+	ginkgo.It("should be no-op if not enabled", func() {
+		tracker = nil
+		run(query("trips", "city_id = 1"), time.Millisecond, 0, 0, nil)
+		Ω(top(QueryStatsSortByCount, 0)).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,101 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// withQueryDeadline returns the context of executing the query until the deadline, the context is only
+// cancelable by its parent if the deadline is zero.
+func withQueryDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// callParentKey is the key of the context of the query a call to a datanode is made for.
+type callParentKey struct{}
+
+// withCallTimeout returns the context of a call to a datanode timing out after the timeout, so that calls
+// timed out are told apart from calls of queries running out of their deadlines. The context is returned as
+// is if the timeout is zero.
+func withCallTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	return context.WithValue(callCtx, callParentKey{}, ctx), cancel
+}
+
+// callTimedOut tells whether the call to the datanode ran out of its own timeout while its query did not end.
+func callTimedOut(ctx context.Context) bool {
+	parent, ok := ctx.Value(callParentKey{}).(context.Context)
+	return ok && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil
+}
+
+// queryContextError returns the error of the query stopped by its context, a timeout error listing the
+// hosts not responded if the deadline is exceeded.
+func queryContextError(ctx context.Context, pendingHosts []string) error {
+	ctxErr := ctx.Err()
+	if ctxErr != context.DeadlineExceeded {
+		return ctxErr
+	}
+
+	hostSet := make(map[string]bool, len(pendingHosts))
+	for _, host := range pendingHosts {
+		hostSet[host] = true
+	}
+	hosts := make([]string, 0, len(hostSet))
+	for host := range hostSet {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	err := utils.NewCodedError(utils.ErrCodeTimeout, nil, "query timed out waiting on %d datanodes", len(hosts))
+	for _, host := range hosts {
+		err.HostErrors = append(err.HostErrors, utils.HostError{
+			Host:      host,
+			Code:      utils.ErrCodeTimeout,
+			Message:   "no response before the query deadline",
+			Retriable: utils.GetErrorCodeInfo(utils.ErrCodeTimeout).Retriable,
+		})
+	}
+	return err
+}
+
+// timedOutHosts returns ids of hosts not responded to the plan node failed by the context, either listed by
+// the timeout error of child merge nodes or all hosts queried by the plan node.
+func timedOutHosts(err error, node common.BlockingPlanNode) (hosts []string) {
+	if codedErr, ok := err.(*utils.CodedError); ok && codedErr.Code == utils.ErrCodeTimeout {
+		for _, hostErr := range codedErr.HostErrors {
+			hosts = append(hosts, hostErr.Host)
+		}
+		return
+	}
+	if scanNode, ok := node.(*BlockingScanNode); ok {
+		return []string{scanNode.host.ID()}
+	}
+	for _, child := range node.Children() {
+		hosts = append(hosts, timedOutHosts(nil, child)...)
+	}
+	return
+}
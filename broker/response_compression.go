@@ -0,0 +1,160 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/utils"
+)
+
+// gzipResponseWriter compresses the response by gzip once it reaches the min size, smaller responses are
+// written uncompressed when closed. Bytes are buffered until then, as well as the status code, so that the
+// content encoding header can still be set. Flushes are passed through once compressing, so that rows
+// streamed are flushed to the client on chunk boundaries.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level    int
+	minBytes int
+
+	// bytes buffered before compressing.
+	buffer []byte
+	// status code buffered before compressing, 0 if not written.
+	statusCode int
+	gz         *gzip.Writer
+	closed     bool
+}
+
+// compressResponse wraps the response writer to compress the response by the config if the request accepts
+// gzip, the returned function must be called to finish the response.
+func compressResponse(w http.ResponseWriter, r *http.Request, cfg config.CompressionConfig) (http.ResponseWriter, func()) {
+	if !cfg.Enable || !acceptsGzip(r) {
+		return w, func() {}
+	}
+	level := cfg.Level
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	gw := &gzipResponseWriter{ResponseWriter: w, level: level, minBytes: cfg.MinBytes}
+	// responses varying by accepted encodings are not mixed up by caches.
+	w.Header().Add("Vary", utils.HTTPAcceptEncodingHeaderKey)
+	return gw, func() {
+		if err := gw.Close(); err != nil {
+			utils.GetLogger().With("error", err).Warn("Failed to finish compressed response")
+		}
+	}
+}
+
+// acceptsGzip tells whether the request accepts gzip encoded responses, encodings of zero quality are not
+// accepted.
+func acceptsGzip(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get(utils.HTTPAcceptEncodingHeaderKey), ",") {
+		params := strings.Split(accepted, ";")
+		if strings.TrimSpace(params[0]) != utils.HTTPContentEncodingGzip {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// WriteHeader buffers the status code until the response is compressed or closed.
+func (gw *gzipResponseWriter) WriteHeader(statusCode int) {
+	if gw.gz != nil {
+		return
+	}
+	if gw.statusCode == 0 {
+		gw.statusCode = statusCode
+	}
+}
+
+// Write buffers the data until the response reaches the min size, and compresses it after.
+func (gw *gzipResponseWriter) Write(data []byte) (int, error) {
+	if gw.gz != nil {
+		return gw.gz.Write(data)
+	}
+	gw.buffer = append(gw.buffer, data...)
+	if len(gw.buffer) < gw.minBytes {
+		return len(data), nil
+	}
+	if err := gw.startCompression(); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush flushes compressed bytes to the client, responses not reaching the min size yet are kept buffered.
+func (gw *gzipResponseWriter) Flush() {
+	if gw.gz == nil {
+		return
+	}
+	if err := gw.gz.Flush(); err != nil {
+		return
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the response, responses not reaching the min size are written uncompressed.
+func (gw *gzipResponseWriter) Close() error {
+	if gw.closed {
+		return nil
+	}
+	gw.closed = true
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	if gw.statusCode != 0 {
+		gw.ResponseWriter.WriteHeader(gw.statusCode)
+	}
+	if len(gw.buffer) == 0 {
+		return nil
+	}
+	_, err := gw.ResponseWriter.Write(gw.buffer)
+	return err
+}
+
+// startCompression sets the content encoding header, writes the status code buffered and compresses bytes
+// buffered.
+func (gw *gzipResponseWriter) startCompression() error {
+	header := gw.Header()
+	header.Set(utils.HTTPContentEncodingHeaderKey, utils.HTTPContentEncodingGzip)
+	header.Del("Content-Length")
+	if gw.statusCode != 0 {
+		gw.ResponseWriter.WriteHeader(gw.statusCode)
+	}
+	gz, err := gzip.NewWriterLevel(gw.ResponseWriter, gw.level)
+	if err != nil {
+		return err
+	}
+	gw.gz = gz
+	buffer := gw.buffer
+	gw.buffer = nil
+	_, err = gz.Write(buffer)
+	return err
+}
@@ -0,0 +1,111 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("response compression", func() {
+	newRequest := func(acceptEncoding string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/query/aql", nil)
+		if acceptEncoding != "" {
+			r.Header.Set(utils.HTTPAcceptEncodingHeaderKey, acceptEncoding)
+		}
+		return r
+	}
+
+	decompress := func(bs []byte) string {
+		gz, err := gzip.NewReader(bytes.NewReader(bs))
+		Ω(err).Should(BeNil())
+		data, err := ioutil.ReadAll(gz)
+		Ω(err).Should(BeNil())
+		return string(data)
+	}
+
+	ginkgo.It("should tell requests accepting gzip", func() {
+		Ω(acceptsGzip(newRequest(""))).Should(BeFalse())
+		Ω(acceptsGzip(newRequest("gzip"))).Should(BeTrue())
+		Ω(acceptsGzip(newRequest("deflate, gzip;q=0.8"))).Should(BeTrue())
+		Ω(acceptsGzip(newRequest("deflate, br"))).Should(BeFalse())
+		Ω(acceptsGzip(newRequest("gzip;q=0"))).Should(BeFalse())
+	})
+
+	ginkgo.It("should compress responses reaching the min size", func() {
+		cfg := config.CompressionConfig{Enable: true, MinBytes: 10}
+
+		// not compressed if not enabled or not accepted.
+		w := httptest.NewRecorder()
+		rw, _ := compressResponse(w, newRequest("gzip"), config.CompressionConfig{})
+		Ω(rw).Should(BeIdenticalTo(w))
+		rw, _ = compressResponse(w, newRequest(""), cfg)
+		Ω(rw).Should(BeIdenticalTo(w))
+
+		// small responses are written uncompressed when finished.
+		w = httptest.NewRecorder()
+		rw, finish := compressResponse(w, newRequest("gzip"), cfg)
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{}`))
+		rw.(http.Flusher).Flush()
+		Ω(w.Flushed).Should(BeFalse())
+		Ω(w.Body.Len()).Should(BeZero())
+		finish()
+		Ω(w.Code).Should(Equal(http.StatusBadRequest))
+		Ω(w.Header().Get(utils.HTTPContentEncodingHeaderKey)).Should(BeEmpty())
+		Ω(w.Body.String()).Should(Equal(`{}`))
+
+		// chunks of large responses are flushed compressed.
+		w = httptest.NewRecorder()
+		rw, finish = compressResponse(w, newRequest("gzip"), cfg)
+		rw.Header().Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+		rw.Write([]byte(`{"matrixData":[`))
+		Ω(w.Header().Get(utils.HTTPContentEncodingHeaderKey)).Should(Equal(utils.HTTPContentEncodingGzip))
+		Ω(w.Header().Get("Vary")).Should(Equal(utils.HTTPAcceptEncodingHeaderKey))
+		rw.Write([]byte(`["1"]`))
+		rw.(http.Flusher).Flush()
+		Ω(w.Flushed).Should(BeTrue())
+		Ω(w.Body.Len()).ShouldNot(BeZero())
+		rw.Write([]byte(`]}`))
+		finish()
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(decompress(w.Body.Bytes())).Should(Equal(`{"matrixData":[["1"]]}`))
+	})
+
+	ginkgo.It("should compress query responses", func() {
+		handler := NewQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			w.Write([]byte(`{"headers":["trip_id"],"matrixData":[["1"],["2"]]}`))
+			return nil
+		}), config.CompressionConfig{Enable: true})
+
+		r := httptest.NewRequest(http.MethodPost, "/query/aql", bytes.NewBufferString(`{"query":{"table":"trips","measures":[{"sqlExpression":"1"}]}}`))
+		r.Header.Set(utils.HTTPAcceptEncodingHeaderKey, "gzip")
+		w := httptest.NewRecorder()
+		handler.HandleAQL(w, r)
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Header().Get(utils.HTTPContentEncodingHeaderKey)).Should(Equal(utils.HTTPContentEncodingGzip))
+		Ω(decompress(w.Body.Bytes())).Should(MatchJSON(`{"headers":["trip_id"],"matrixData":[["1"],["2"]]}`))
+	})
+})
@@ -0,0 +1,311 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultResultCacheMaxEntries = 1000
+	defaultResultCacheMaxBytes   = 100 << 20
+	defaultResultCacheTTLSec     = 10
+	defaultResultCacheBucketSec  = 60
+)
+
+// timeFilterUnits are units of relative time filter expressions by their names, e.g. this hour.
+var timeFilterUnits = map[string]string{
+	"year":         "y",
+	"quarter":      "q",
+	"month":        "M",
+	"week":         "w",
+	"day":          "d",
+	"hour":         "h",
+	"quarter-hour": "15m",
+	"minute":       "m",
+	"second":       "s",
+}
+
+// timeFilterUnitAlignments are what relative time filters are aligned to by their units. Calendar units of
+// days and above are aligned to local midnights, which are on quarter hours in all timezones.
+var timeFilterUnitAlignments = map[string]time.Duration{
+	"s":   time.Second,
+	"m":   time.Minute,
+	"15m": 15 * time.Minute,
+	"h":   time.Hour,
+	"d":   15 * time.Minute,
+	"w":   15 * time.Minute,
+	"M":   15 * time.Minute,
+	"q":   15 * time.Minute,
+	"y":   15 * time.Minute,
+}
+
+// resultCacheEntry is the result of a query in the lru list.
+type resultCacheEntry struct {
+	key       string
+	result    []byte
+	metadata  resultMetadata
+	expiresAt time.Time
+}
+
+// resultMetadata is the metadata of the result reported in responses, which is cached with the result since
+// datanodes are not queried for cached results.
+type resultMetadata struct {
+	// data freshness of the datanodes queried, 0 if unknown.
+	dataFreshness int64
+	// ends of top level time buckets by bucket keys, nil if not collected.
+	bucketEnds map[string]int64
+}
+
+// getResultMetadata returns the metadata of the result collected by the context.
+func getResultMetadata(ctx context.Context) (metadata resultMetadata) {
+	if dataNodeMetadata := dataCli.GetQueryMetadata(ctx); dataNodeMetadata != nil {
+		dataNodeMetadata.Lock()
+		metadata.dataFreshness = dataNodeMetadata.DataFreshness
+		dataNodeMetadata.Unlock()
+	}
+	if buckets, ok := ctx.Value(timeBucketsKey{}).(*timeBuckets); ok {
+		metadata.bucketEnds = buckets.ends
+	}
+	return
+}
+
+// restore reports the metadata of the cached result to the context as if datanodes were queried.
+func (m resultMetadata) restore(ctx context.Context) {
+	if dataNodeMetadata := dataCli.GetQueryMetadata(ctx); dataNodeMetadata != nil {
+		dataNodeMetadata.RecordDataFreshness(m.dataFreshness)
+	}
+	if buckets, ok := ctx.Value(timeBucketsKey{}).(*timeBuckets); ok {
+		buckets.ends = m.bucketEnds
+	}
+}
+
+// resultCache caches results of aggregation queries by their canonical forms, so that identical queries,
+// e.g. of dashboards refreshing every few seconds, are served without querying datanodes. Now of cached
+// queries is normalized to the start of its bucket, so that identical queries within a bucket share the
+// same result. Results expire after the ttl, and cold ones are evicted when there are too many of them.
+// All methods are no-op on nil.
+type resultCache struct {
+	sync.Mutex
+
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+	bucket     time.Duration
+
+	bytes int64
+	// most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// newResultCache creates the result cache by the config, nil if caching is not enabled.
+func newResultCache(cfg config.ResultCacheConfig) *resultCache {
+	if !cfg.Enable {
+		return nil
+	}
+	c := &resultCache{
+		maxEntries: cfg.MaxEntries,
+		maxBytes:   cfg.MaxBytes,
+		ttl:        time.Duration(cfg.TTLSec) * time.Second,
+		bucket:     time.Duration(cfg.BucketSec) * time.Second,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = defaultResultCacheMaxEntries
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultResultCacheMaxBytes
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultResultCacheTTLSec * time.Second
+	}
+	if c.bucket <= 0 {
+		c.bucket = defaultResultCacheBucketSec * time.Second
+	}
+	return c
+}
+
+// prepare returns the key of the compiled aggregation query in the cache, with now of the query normalized
+// to the start of its bucket unless set by the query. Queries with relative time filters finer than buckets
+// bypass the cache with empty keys.
+func (c *resultCache) prepare(aql *queryCom.AQLQuery) string {
+	if c == nil {
+		return ""
+	}
+	if aql.Now == 0 {
+		if !isTimeFilterCacheable(aql.TimeFilter, c.bucket) {
+			return ""
+		}
+		aql.Now = utils.Now().Truncate(c.bucket).Unix()
+	}
+	key, err := resultCacheKey(aql)
+	if err != nil {
+		utils.GetLogger().With("error", err, "table", aql.Table).Warn("Failed to compute result cache key")
+		return ""
+	}
+	return key
+}
+
+// get returns the result cached by the key with its metadata, nil if not cached or expired. Hits and misses
+// are counted for queries not bypassing the cache.
+func (c *resultCache) get(key string) ([]byte, resultMetadata) {
+	if c == nil || key == "" {
+		return nil, resultMetadata{}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*resultCacheEntry)
+		if utils.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(elem)
+			utils.GetRootReporter().GetCounter(utils.BrokerResultCacheHits).Inc(1)
+			return entry.result, entry.metadata
+		}
+		c.remove(elem)
+	}
+	utils.GetRootReporter().GetCounter(utils.BrokerResultCacheMisses).Inc(1)
+	return nil, resultMetadata{}
+}
+
+// put caches the result with its metadata by the key until the ttl, results larger than the max bytes are not
+// cached.
+func (c *resultCache) put(key string, result []byte, metadata resultMetadata) {
+	if c == nil || key == "" {
+		return
+	}
+	size := int64(len(key) + len(result))
+	if size > c.maxBytes {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&resultCacheEntry{
+		key:       key,
+		result:    result,
+		metadata:  metadata,
+		expiresAt: utils.Now().Add(c.ttl),
+	})
+	c.bytes += size
+	for c.lru.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the entry from the cache, must be called with the lock held.
+func (c *resultCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*resultCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.key) + len(entry.result))
+}
+
+// resultCacheKey returns the hash of the query normalized with literals kept, in which filters ANDed together
+// are sorted. Fields not part of the normalized query but shaping results are part of the key as well.
+func resultCacheKey(aql *queryCom.AQLQuery) (string, error) {
+	canonical := struct {
+		Query                    string                    `json:"query"`
+		Shards                   []int                     `json:"shards"`
+		Now                      int64                     `json:"now"`
+		Offset                   int                       `json:"offset"`
+		IncludeDeprecatedColumns bool                      `json:"includeDeprecatedColumns"`
+		HLLSketch                *queryCom.HLLSketchOption `json:"hllSketch"`
+		FillTimeBuckets          bool                      `json:"fillTimeBuckets"`
+		BucketCompleteness       bool                      `json:"bucketCompleteness"`
+	}{
+		Query:                    queryCom.NormalizeQueryWithLiterals(aql),
+		Shards:                   aql.Shards,
+		Now:                      aql.Now,
+		Offset:                   aql.Offset,
+		IncludeDeprecatedColumns: aql.IncludeDeprecatedColumns,
+		HLLSketch:                aql.HLLSketch,
+		FillTimeBuckets:          aql.FillTimeBuckets,
+		BucketCompleteness:       aql.BucketCompleteness,
+	}
+
+	bs, err := json.Marshal(canonical)
+	if err != nil {
+		return "", utils.StackError(err, "failed to marshal query")
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// isTimeFilterCacheable tells whether results of the time filter stay the same throughout a bucket of now
+// once now is normalized to the start of the bucket, which is the case if both bounds are aligned to
+// multiples of the bucket. Now itself is normalized to the bucket.
+func isTimeFilterCacheable(filter queryCom.TimeFilter, bucket time.Duration) bool {
+	for _, expression := range []string{filter.From, filter.To} {
+		alignment, ok := timeFilterAlignment(expression)
+		if !ok || alignment%bucket != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// timeFilterAlignment returns what the time filter expression relative to now is aligned to, e.g. a minute
+// for -30m, 0 for expressions not relative to now and now itself. ok is false for relative expressions of
+// unknown units.
+func timeFilterAlignment(expression string) (alignment time.Duration, ok bool) {
+	expression = strings.TrimSpace(expression)
+	switch expression {
+	case "", "now":
+		return 0, true
+	case "today", "yesterday":
+		return timeFilterUnitAlignments["d"], true
+	}
+	// timestamps.
+	if _, err := strconv.ParseInt(expression, 10, 64); err == nil {
+		return 0, true
+	}
+
+	segments := strings.Fields(expression)
+	switch {
+	case len(segments) == 2 && (segments[0] == "this" || segments[0] == "last"):
+		alignment, ok = timeFilterUnitAlignments[timeFilterUnits[segments[1]]]
+		return
+	case len(segments) == 3 && segments[2] == "ago":
+		if _, err := strconv.Atoi(segments[0]); err != nil {
+			return 0, false
+		}
+		alignment, ok = timeFilterUnitAlignments[timeFilterUnits[strings.TrimSuffix(segments[1], "s")]]
+		return
+	case len(segments) == 1 && len(expression) > 1:
+		// offsets, e.g. -7d.
+		if _, err := strconv.Atoi(expression[:len(expression)-1]); err == nil {
+			alignment, ok = timeFilterUnitAlignments[expression[len(expression)-1:]]
+			return
+		}
+	}
+	// dates and date times.
+	return 0, true
+}
@@ -0,0 +1,167 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("result cache", func() {
+	ginkgo.BeforeEach(func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	counter := func(name string) int64 {
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		if c, exist := testScope.Snapshot().Counters()["test."+name+"+component=query"]; exist {
+			return c.Value()
+		}
+		return 0
+	}
+
+	ginkgo.It("should tell time filters aligned to buckets", func() {
+		for _, tc := range []struct {
+			expression string
+			alignment  time.Duration
+			ok         bool
+		}{
+			{"", 0, true},
+			{"now", 0, true},
+			{"1540000000", 0, true},
+			{"2019-01-01", 0, true},
+			{"2019-01-01 10:15", 0, true},
+			{"today", 15 * time.Minute, true},
+			{"this hour", time.Hour, true},
+			{"last quarter-hour", 15 * time.Minute, true},
+			{"3 minutes ago", time.Minute, true},
+			{"-7d", 15 * time.Minute, true},
+			{"-30m", time.Minute, true},
+			{"-30s", time.Second, true},
+			{"this fortnight", 0, false},
+			{"2 fortnights ago", 0, false},
+		} {
+			alignment, ok := timeFilterAlignment(tc.expression)
+			Ω(ok).Should(Equal(tc.ok), tc.expression)
+			Ω(alignment).Should(Equal(tc.alignment), tc.expression)
+		}
+
+		Ω(isTimeFilterCacheable(queryCom.TimeFilter{From: "-1h", To: "now"}, time.Minute)).Should(BeTrue())
+		Ω(isTimeFilterCacheable(queryCom.TimeFilter{From: "-1h"}, time.Minute)).Should(BeTrue())
+		Ω(isTimeFilterCacheable(queryCom.TimeFilter{From: "-30m", To: "now"}, 5*time.Minute)).Should(BeFalse())
+		Ω(isTimeFilterCacheable(queryCom.TimeFilter{From: "-30s", To: "now"}, time.Minute)).Should(BeFalse())
+	})
+
+	ginkgo.It("should key queries by their canonical forms", func() {
+		Ω(newResultCache(config.ResultCacheConfig{})).Should(BeNil())
+		var nilCache *resultCache
+		Ω(nilCache.prepare(&queryCom.AQLQuery{})).Should(BeEmpty())
+
+		c := newResultCache(config.ResultCacheConfig{Enable: true})
+		newQuery := func(filters ...string) *queryCom.AQLQuery {
+			return &queryCom.AQLQuery{
+				Table:      "trips",
+				Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+				Filters:    filters,
+				TimeFilter: queryCom.TimeFilter{Column: "request_at", From: "-1h", To: "now"},
+			}
+		}
+		aql := newQuery("city_id = 1", "status = 'completed'")
+		key := c.prepare(aql)
+		Ω(key).ShouldNot(BeEmpty())
+		Ω(aql.Now).Should(Equal(int64(960)))
+		// filters are not reordered in the query.
+		Ω(aql.Filters).Should(Equal([]string{"city_id = 1", "status = 'completed'"}))
+		Ω(c.prepare(newQuery(" status = 'completed'", "city_id = 1"))).Should(Equal(key))
+		Ω(c.prepare(newQuery("city_id = 2", "status = 'completed'"))).ShouldNot(Equal(key))
+
+		aql = newQuery("city_id = 1", "status = 'completed'")
+		aql.FillTimeBuckets = true
+		Ω(c.prepare(aql)).ShouldNot(Equal(key))
+		aql = newQuery("city_id = 1", "status = 'completed'")
+		aql.Now = 100
+		Ω(c.prepare(aql)).ShouldNot(Equal(key))
+		Ω(aql.Now).Should(Equal(int64(100)))
+
+		aql = newQuery("city_id = 1", "status = 'completed'")
+		aql.BucketCompleteness = true
+		Ω(c.prepare(aql)).ShouldNot(Equal(key))
+		aql = newQuery("city_id = 1", "status = 'completed'")
+		aql.Limit = 10
+		Ω(c.prepare(aql)).ShouldNot(Equal(key))
+
+		// bypassed.
+		aql = newQuery()
+		aql.TimeFilter.From = "-30s"
+		Ω(c.prepare(aql)).Should(BeEmpty())
+		Ω(aql.Now).Should(BeZero())
+	})
+
+	ginkgo.It("should evict results by ttl, entries and bytes", func() {
+		var nilCache *resultCache
+		nilCache.put("a", []byte("1"), resultMetadata{})
+		result, _ := nilCache.get("a")
+		Ω(result).Should(BeNil())
+
+		c := newResultCache(config.ResultCacheConfig{Enable: true, MaxEntries: 2, MaxBytes: 10, TTLSec: 10})
+		get := func(key string) []byte {
+			result, _ := c.get(key)
+			return result
+		}
+		hits, misses := counter("broker_result_cache_hits"), counter("broker_result_cache_misses")
+		c.put("a", []byte("1"), resultMetadata{})
+		c.put("b", []byte("2"), resultMetadata{})
+		Ω(get("a")).Should(Equal([]byte("1")))
+		// b is the least recently used.
+		c.put("c", []byte("3"), resultMetadata{dataFreshness: 100})
+		Ω(get("b")).Should(BeNil())
+		result, metadata := c.get("c")
+		Ω(result).Should(Equal([]byte("3")))
+		Ω(metadata.dataFreshness).Should(Equal(int64(100)))
+		Ω(counter("broker_result_cache_hits")).Should(Equal(hits + 2))
+		Ω(counter("broker_result_cache_misses")).Should(Equal(misses + 1))
+
+		// bypassed queries are not counted.
+		Ω(get("")).Should(BeNil())
+		Ω(counter("broker_result_cache_misses")).Should(Equal(misses + 1))
+
+		// evicted by bytes.
+		c.put("d", []byte("12345678"), resultMetadata{})
+		Ω(get("a")).Should(BeNil())
+		Ω(get("c")).Should(BeNil())
+		Ω(get("d")).Should(Equal([]byte("12345678")))
+		Ω(c.bytes).Should(Equal(int64(9)))
+		// too large to cache.
+		c.put("e", []byte("1234567890"), resultMetadata{})
+		Ω(get("e")).Should(BeNil())
+		Ω(get("d")).ShouldNot(BeNil())
+
+		// expired.
+		utils.SetCurrentTime(time.Unix(1010, 0))
+		Ω(get("d")).Should(BeNil())
+		Ω(c.lru.Len()).Should(BeZero())
+		Ω(c.bytes).Should(BeZero())
+	})
+})
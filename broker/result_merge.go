@@ -19,50 +19,249 @@ import (
 	"github.com/uber/aresdb/broker/common"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/utils"
+	"math"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-func newResultMergeContext(aggType common.AggType) resultMergeContext {
-	return resultMergeContext{
-		agg:  aggType,
+// newResultMergeContext creates the context merging results of aggregations of measures in order, leaves of
+// results of multiple measures are arrays of measure values.
+func newResultMergeContext(aggTypes ...common.AggType) resultMergeContext {
+	c := resultMergeContext{
+		agg:  aggTypes[0],
 		path: []string{},
 	}
+	if len(aggTypes) > 1 {
+		c.aggs = aggTypes
+	}
+	return c
+}
+
+// resultMergeContextPool pools merge contexts across queries, so that their paths and buffers are reused
+// by merges of results with large numbers of buckets.
+var resultMergeContextPool = sync.Pool{
+	New: func() interface{} {
+		c := newResultMergeContext(common.Count)
+		return &c
+	},
 }
 
+// getResultMergeContext returns a merge context of the aggregation from the pool, it should be put back
+// with putResultMergeContext once the merge is done.
+func getResultMergeContext(aggTypes ...common.AggType) *resultMergeContext {
+	c := resultMergeContextPool.Get().(*resultMergeContext)
+	c.Reset(aggTypes[0])
+	if len(aggTypes) > 1 {
+		c.aggs = aggTypes
+	}
+	return c
+}
+
+// putResultMergeContext puts the merge context back to the pool, results merged by the context are not
+// referenced by the context afterwards.
+func putResultMergeContext(c *resultMergeContext) {
+	c.Reset(c.agg)
+	resultMergeContextPool.Put(c)
+}
+
+// maxMergeConflicts is the max number of type conflicts reported by a merge before it's aborted.
+const maxMergeConflicts = 10
+
 // resultMergeContext is the context for merging results
 // caller should check for err after calling
 type resultMergeContext struct {
-	agg    common.AggType
+	agg common.AggType
+	// aggregations of measures by index of array leaves, nil for results of single measures.
+	aggs   []common.AggType
 	parent queryCom.AQLQueryResult
 	path   []string
 	err    error
+	// type conflicts found so far, values of conflicting types are left out of merges.
+	conflicts []string
+	// buffers of values and maps of the same key by depth for mergeAll.
+	buffers    [][]interface{}
+	mapBuffers [][]map[string]interface{}
+	// result accumulated by add.
+	result queryCom.AQLQueryResult
+	// fill of missing top level time buckets of the merged result, nil if not filled.
+	fill *timeBucketFill
+	// max number of distinct values of a bucket of CountDistinct, 0 means defaultMaxDistinctValues.
+	maxDistinctValues int
+}
+
+// Reset clears the context to merge results of the aggregation, the path and buffers are kept to be reused
+// by the next merge without references to results merged before.
+func (c *resultMergeContext) Reset(agg common.AggType) {
+	for _, buffer := range c.buffers {
+		for i := range buffer {
+			buffer[i] = nil
+		}
+	}
+	for _, buffer := range c.mapBuffers {
+		for i := range buffer {
+			buffer[i] = nil
+		}
+	}
+	*c = resultMergeContext{
+		agg:        agg,
+		path:       c.path[:0],
+		conflicts:  c.conflicts[:0],
+		buffers:    c.buffers,
+		mapBuffers: c.mapBuffers,
+	}
+}
+
+// add merges the result into the accumulated result in place as results arrive, so that results can be
+// released once added. Results merged in any order are the same as merging them with mergeAll, except
+// for rounding errors of float sums. The context is not safe for concurrent use, caller should check
+// for err after calling.
+func (c *resultMergeContext) add(result queryCom.AQLQueryResult) {
+	if c.result == nil {
+		c.result = result
+		return
+	}
+	merged := c.mergeAllRecursive([]interface{}{map[string]interface{}(c.result), map[string]interface{}(result)})
+	if c.err != nil {
+		c.err = c.withPath(c.err)
+		return
+	}
+	c.result = queryCom.AQLQueryResult(merged.(map[string]interface{}))
+}
+
+// merged returns the result accumulated by add.
+func (c *resultMergeContext) merged() (queryCom.AQLQueryResult, error) {
+	c.reportConflicts()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.result == nil {
+		return queryCom.AQLQueryResult{}, nil
+	}
+	return c.result, nil
+}
+
+// fillTimeBuckets fills missing top level time buckets of the merged result in place if the context fills
+// time buckets, inner dimensions are not filled.
+func (c *resultMergeContext) fillTimeBuckets(result queryCom.AQLQueryResult) queryCom.AQLQueryResult {
+	if c.fill == nil {
+		return result
+	}
+	return c.fill.apply(result)
+}
+
+// withPath adds the path being merged to the error, errors with codes keep their codes, e.g. too many distinct
+// values of countdistinct.
+func (c *resultMergeContext) withPath(err error) error {
+	if codedErr, ok := err.(*utils.CodedError); ok {
+		wrapped := *codedErr
+		wrapped.Cause = utils.StackError(codedErr.Cause, "failed to merge results, path: %v", c.path)
+		return &wrapped
+	}
+	return utils.StackError(err, "failed to merge results, path: %v", c.path)
+}
+
+// mergeAll merges all results in a single pass per key, results are merged in place into the first
+// result having the key. Nulls are merged the same as merging results pairwise. For Avg, results must be
+// the sum and count results, leaves of results of multiple measures are merged by aggregations of measures.
+func mergeAll(results []queryCom.AQLQueryResult, aggTypes ...common.AggType) (queryCom.AQLQueryResult, error) {
+	if aggTypes[0] == common.Avg && len(results) != 2 {
+		return nil, utils.StackError(nil, "avg merge expects sum and count results, but got %d results", len(results))
+	}
+	if len(results) == 0 {
+		return queryCom.AQLQueryResult{}, nil
+	}
+
+	c := getResultMergeContext(aggTypes...)
+	defer putResultMergeContext(c)
+	values := make([]interface{}, len(results))
+	for i, result := range results {
+		values[i] = map[string]interface{}(result)
+	}
+	merged := c.mergeAllRecursive(values)
+	if c.err != nil {
+		return nil, c.withPath(c.err)
+	}
+	c.reportConflicts()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return queryCom.AQLQueryResult(merged.(map[string]interface{})), nil
 }
 
 // run merges results from rhs to lhs in place
 func (c *resultMergeContext) run(lhs, rhs queryCom.AQLQueryResult) queryCom.AQLQueryResult {
 	c.mergeResultsRecursive(map[string]interface{}(lhs), map[string]interface{}(rhs))
+	c.reportConflicts()
 	return lhs
 }
 
-func (c *resultMergeContext) mergeResultsRecursive(lhs, rhs interface{}) {
-	if lhs == nil && rhs == nil {
-		return
+// addConflict records the type conflict of values at the current path, the merge is aborted once
+// there are too many conflicts.
+func (c *resultMergeContext) addConflict(lhs, rhs interface{}) {
+	quoted := make([]string, len(c.path))
+	for i, key := range c.path {
+		quoted[i] = strconv.Quote(key)
+	}
+	c.conflicts = append(c.conflicts, fmt.Sprintf("merge type conflict at %s: %s vs %s",
+		strings.Join(quoted, "."), mergeValueTypeName(lhs), mergeValueTypeName(rhs)))
+	if len(c.conflicts) >= maxMergeConflicts {
+		c.err = utils.StackError(nil, "%s; aborted after %d conflicts", strings.Join(c.conflicts, "; "), len(c.conflicts))
 	}
+}
 
-	if lhs == nil {
-		if c.agg == common.Avg {
-			c.err = utils.StackError(nil, "error calculating avg: some dimension has only sum. path: %v", c.path)
+// reportConflicts sets the error of type conflicts found by the merge if not aborted.
+func (c *resultMergeContext) reportConflicts() {
+	if c.err == nil && len(c.conflicts) > 0 {
+		c.err = utils.StackError(nil, "%s", strings.Join(c.conflicts, "; "))
+	}
+}
+
+// mergeValueTypeName returns the name of the type of values in results for error messages.
+func mergeValueTypeName(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return "float"
+	case map[string]interface{}:
+		return "object"
+	case queryCom.HLL:
+		return "hll"
+	case varianceMoments:
+		return "variance moments"
+	case distinctValues:
+		return "distinct values"
+	case *queryCom.TDigest:
+		return "t-digest"
+	case []interface{}:
+		return "array"
+	}
+	return reflect.TypeOf(value).String()
+}
+
+// mergeResultsRecursive merges rhs into lhs. Nulls are buckets with null measures or missing from one
+// side, a null merged with a value is the value for all aggregations except Avg, where the null side
+// contributes zero count and the average is null. Nulls merged with nulls stay null.
+func (c *resultMergeContext) mergeResultsRecursive(lhs, rhs interface{}) {
+	if lhs == nil && rhs == nil {
+		if c.parent != nil {
+			c.parent[c.path[len(c.path)-1]] = nil
 		}
-		c.parent[c.path[len(c.path)-1]] = rhs
-		lhs = rhs
 		return
 	}
 
-	if rhs == nil {
+	if lhs == nil || rhs == nil {
+		value := lhs
+		if lhs == nil {
+			value = rhs
+		}
 		if c.agg == common.Avg {
-			c.err = utils.StackError(nil, "error calculating avg: some dimension has only count. path: %v", c.path)
+			// buckets with only sums or only counts are kept with null averages instead of failing the query,
+			// since their averages are unknown without the other half.
+			value = nullLeaves(value)
 		}
-		c.parent[c.path[len(c.path)-1]] = lhs
+		c.parent[c.path[len(c.path)-1]] = value
 		return
 	}
 
@@ -70,64 +269,95 @@ func (c *resultMergeContext) mergeResultsRecursive(lhs, rhs interface{}) {
 	rhsType := reflect.TypeOf(rhs)
 
 	if lhsType != rhsType {
-		c.err = utils.StackError(nil, fmt.Sprintf("error merging: different type lhs: %s vs. rhs: %s", lhsType, rhsType))
+		// lhs is kept.
+		c.addConflict(lhs, rhs)
 		return
 	}
 
 	switch l := lhs.(type) {
 	case float64:
 		r := rhs.(float64)
-		switch c.agg {
-		case common.Count, common.Sum:
-			l = l + r
-		case common.Max:
-			if r > l {
-				l = r
-			}
-		case common.Min:
-			if r < l {
-				l = r
+		if c.agg == common.Avg {
+			if r == 0 {
+				c.parent[c.path[len(c.path)-1]] = nil
+				return
 			}
-		case common.Avg:
-			l = l / r
+			c.parent[c.path[len(c.path)-1]] = l / r
+			return
+		}
+		c.parent[c.path[len(c.path)-1]] = mergeFloats(c.agg, l, r)
+	case []interface{}:
+		r := rhs.([]interface{})
+		if !c.checkMeasureValues(l) || !c.checkMeasureValues(r) {
+			return
+		}
+		for i, agg := range c.aggs {
+			l[i] = c.mergeMeasureValues(agg, l[i], r[i])
 		}
 		c.parent[c.path[len(c.path)-1]] = l
+	case varianceMoments:
+		if c.agg != common.Var && c.agg != common.Stddev {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: variance moments found for non variance aggregation: %d", c.agg))
+			return
+		}
+		c.parent[c.path[len(c.path)-1]] = l.merge(rhs.(varianceMoments))
+	case distinctValues:
+		if c.agg != common.CountDistinct {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: distinct values found for non count distinct aggregation: %d", c.agg))
+			return
+		}
+		if c.err = l.union(rhs.(distinctValues), c.maxDistinctValues); c.err != nil {
+			return
+		}
+		c.parent[c.path[len(c.path)-1]] = l
+	case *queryCom.TDigest:
+		if c.agg != common.Percentile {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: t-digest found for non percentile aggregation: %d", c.agg))
+			return
+		}
+		l.Merge(rhs.(*queryCom.TDigest))
+		c.parent[c.path[len(c.path)-1]] = l
 	case queryCom.HLL:
 		r := rhs.(queryCom.HLL)
 		if c.agg != common.Hll {
 			c.err = utils.StackError(nil, fmt.Sprintf("error merging: HLL value found for non Hll aggregation: %d", c.agg))
+			return
 		}
+		// registers are unioned for buckets in both results, buckets only in one result are copied
+		// through above.
 		l.Merge(r)
 		c.parent[c.path[len(c.path)-1]] = l
 	case map[string]interface{}:
 		r := rhs.(map[string]interface{})
+		// the path is truncated instead of restored to the previous slice, so that its grown capacity is
+		// reused by following keys.
+		depth := len(c.path)
 		for k, lv := range l {
-			prevPath := c.path
 			c.path = append(c.path, k)
 			prevParent := c.parent
 			c.parent = l
 
 			c.mergeResultsRecursive(lv, r[k])
 			if c.err != nil {
-				c.err = utils.StackError(c.err, "failed to merge results, path: %v", c.path)
+				c.err = c.withPath(c.err)
 				return
 			}
-			c.path = prevPath
+			c.path = c.path[:depth]
 			c.parent = prevParent
 		}
 		for k, rv := range r {
 			if _, exists := l[k]; !exists {
-				prevPath := c.path
 				c.path = append(c.path, k)
 				prevParent := c.parent
 				c.parent = l
 
-				c.mergeResultsRecursive(l[k], rv)
+				// values only in rhs are moved to lhs.
+				c.mergeResultsRecursive(nil, rv)
 				if c.err != nil {
-					c.err = utils.StackError(c.err, "failed to merge results, path: %v", c.path)
+					c.err = c.withPath(c.err)
 					return
 				}
-				c.path = prevPath
+				c.path = c.path[:depth]
 				c.parent = prevParent
 			}
 		}
@@ -139,3 +369,604 @@ func (c *resultMergeContext) mergeResultsRecursive(lhs, rhs interface{}) {
 		utils.GetLogger().Panic("unknown type ", reflect.TypeOf(lhs))
 	}
 }
+
+// mergeAllRecursive merges values of the same key from all results, nils are null values or values missing
+// from results.
+func (c *resultMergeContext) mergeAllRecursive(values []interface{}) interface{} {
+	firstIdx, numValues := -1, 0
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		if firstIdx < 0 {
+			firstIdx = i
+		} else if reflect.TypeOf(v) != reflect.TypeOf(values[firstIdx]) {
+			// values conflicting with the first value are left out.
+			c.addConflict(values[firstIdx], v)
+			if c.err != nil {
+				return nil
+			}
+			values[i] = nil
+			continue
+		}
+		numValues++
+	}
+	if firstIdx < 0 {
+		return nil
+	}
+	if numValues == 1 {
+		if c.agg == common.Avg {
+			// buckets with only sums or only counts are kept with null averages instead of failing the query,
+			// since their averages are unknown without the other half.
+			return nullLeaves(values[firstIdx])
+		}
+		// values only in one result are kept as is without boxing them again.
+		return values[firstIdx]
+	}
+
+	rest := values[firstIdx+1:]
+	switch l := values[firstIdx].(type) {
+	case float64:
+		if c.agg == common.Avg {
+			if rest[0].(float64) == 0 {
+				return nil
+			}
+			return l / rest[0].(float64)
+		}
+		for _, v := range rest {
+			if v != nil {
+				l = mergeFloats(c.agg, l, v.(float64))
+			}
+		}
+		return l
+	case []interface{}:
+		if !c.checkMeasureValues(l) {
+			return nil
+		}
+		for _, v := range rest {
+			if v == nil {
+				continue
+			}
+			r := v.([]interface{})
+			if !c.checkMeasureValues(r) {
+				return nil
+			}
+			for i, agg := range c.aggs {
+				l[i] = c.mergeMeasureValues(agg, l[i], r[i])
+			}
+		}
+		return l
+	case queryCom.HLL:
+		if c.agg != common.Hll {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: HLL value found for non Hll aggregation: %d", c.agg))
+			return nil
+		}
+		for _, v := range rest {
+			if v != nil {
+				l.Merge(v.(queryCom.HLL))
+			}
+		}
+		return l
+	case varianceMoments:
+		if c.agg != common.Var && c.agg != common.Stddev {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: variance moments found for non variance aggregation: %d", c.agg))
+			return nil
+		}
+		for _, v := range rest {
+			if v != nil {
+				l = l.merge(v.(varianceMoments))
+			}
+		}
+		return l
+	case distinctValues:
+		if c.agg != common.CountDistinct {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: distinct values found for non count distinct aggregation: %d", c.agg))
+			return nil
+		}
+		for _, v := range rest {
+			if v == nil {
+				continue
+			}
+			if c.err = l.union(v.(distinctValues), c.maxDistinctValues); c.err != nil {
+				return nil
+			}
+		}
+		return l
+	case *queryCom.TDigest:
+		if c.agg != common.Percentile {
+			c.err = utils.StackError(nil, fmt.Sprintf("error merging: t-digest found for non percentile aggregation: %d", c.agg))
+			return nil
+		}
+		for _, v := range rest {
+			if v != nil {
+				l.Merge(v.(*queryCom.TDigest))
+			}
+		}
+		return l
+	case map[string]interface{}:
+		return c.mergeAllMaps(values)
+	default:
+		// should not happen
+		utils.GetLogger().Panic("unknown type ", reflect.TypeOf(l))
+	}
+	return nil
+}
+
+// mergeAllMaps merges maps of the same key from all results into the first non nil map, by merging
+// values of each key across all maps once.
+func (c *resultMergeContext) mergeAllMaps(values []interface{}) interface{} {
+	// buffers are reused by maps of the same depth, since keys of a map are merged one at a time.
+	depth := len(c.path)
+	if len(c.buffers) <= depth {
+		c.buffers = append(c.buffers, nil)
+		c.mapBuffers = append(c.mapBuffers, nil)
+	}
+	if cap(c.buffers[depth]) < len(values) {
+		c.buffers[depth] = make([]interface{}, len(values))
+		c.mapBuffers[depth] = make([]map[string]interface{}, len(values))
+	}
+	buffer := c.buffers[depth][:len(values)]
+	maps := c.mapBuffers[depth][:len(values)]
+
+	outIdx := -1
+	for i, v := range values {
+		maps[i] = nil
+		if v != nil {
+			maps[i] = v.(map[string]interface{})
+			if outIdx < 0 && maps[i] != nil {
+				outIdx = i
+			}
+		}
+	}
+	out := map[string]interface{}{}
+	if outIdx >= 0 {
+		out = maps[outIdx]
+	}
+
+	mergeKey := func(k string) bool {
+		for i, m := range maps {
+			buffer[i] = m[k]
+		}
+		c.path = append(c.path, k)
+		merged := c.mergeAllRecursive(buffer)
+		if c.err != nil {
+			return false
+		}
+		c.path = c.path[:depth]
+		out[k] = merged
+		return true
+	}
+
+	// only existing keys are assigned while iterating the output map, keys missing from it are added
+	// while iterating other maps.
+	for k := range out {
+		if !mergeKey(k) {
+			return nil
+		}
+	}
+	for i := outIdx + 1; i < len(maps); i++ {
+		for k := range maps[i] {
+			if _, exists := out[k]; exists {
+				continue
+			}
+			if !mergeKey(k) {
+				return nil
+			}
+		}
+	}
+	return out
+}
+
+// mergeFloats merges values of buckets of count, sum, max and min aggregations.
+func mergeFloats(agg common.AggType, l, r float64) float64 {
+	switch agg {
+	case common.Count, common.Sum:
+		return l + r
+	case common.Max:
+		if r > l {
+			return r
+		}
+	case common.Min:
+		if r < l {
+			return r
+		}
+	}
+	return l
+}
+
+// checkMeasureValues checks that the array leaf has values of all measures in order.
+func (c *resultMergeContext) checkMeasureValues(values []interface{}) bool {
+	if c.aggs == nil {
+		c.err = utils.StackError(nil, "error merging: measure arrays found for single measure aggregation: %d", c.agg)
+		return false
+	}
+	if len(values) != len(c.aggs) {
+		c.err = utils.StackError(nil, "error merging: expect %d measure values, but got %d", len(c.aggs), len(values))
+		return false
+	}
+	return true
+}
+
+// mergeMeasureValues merges values of the measure of array leaves, nulls are merged the same as leaves of
+// single measures.
+func (c *resultMergeContext) mergeMeasureValues(agg common.AggType, lhs, rhs interface{}) interface{} {
+	if lhs == nil {
+		return rhs
+	}
+	if rhs == nil {
+		return lhs
+	}
+	l, lok := lhs.(float64)
+	r, rok := rhs.(float64)
+	if !lok || !rok {
+		// lhs is kept.
+		c.addConflict(lhs, rhs)
+		return lhs
+	}
+	return mergeFloats(agg, l, r)
+}
+
+// buildMeasureValues combines results of measures of the same query into arrays of measure values in order,
+// values of measures missing from buckets are nulls.
+func buildMeasureValues(values []interface{}) interface{} {
+	isMap := false
+	for _, v := range values {
+		if _, ok := v.(map[string]interface{}); ok {
+			isMap = true
+			break
+		}
+	}
+	if !isMap {
+		return append([]interface{}(nil), values...)
+	}
+
+	out := map[string]interface{}{}
+	children := make([]interface{}, len(values))
+	for _, v := range values {
+		m, _ := v.(map[string]interface{})
+		for k := range m {
+			if _, exists := out[k]; exists {
+				continue
+			}
+			for i, other := range values {
+				otherMap, _ := other.(map[string]interface{})
+				children[i] = otherMap[k]
+			}
+			out[k] = buildMeasureValues(children)
+		}
+	}
+	return out
+}
+
+// nullLeaves returns the result with all leaf values replaced by nulls.
+func nullLeaves(value interface{}) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for k, v := range m {
+		m[k] = nullLeaves(v)
+	}
+	return m
+}
+
+// varianceMoments is the partial result of variance aggregations of a bucket, as the (count, mean, M2)
+// triple where M2 is the sum of squared differences from the mean. Triples of different datanodes are
+// merged without subtracting large sums of squares, which loses precision when values are large relative
+// to their variances.
+type varianceMoments struct {
+	count float64
+	mean  float64
+	m2    float64
+}
+
+// newVarianceMoments creates the variance moments from the count, the sum and the sum of squares of values.
+func newVarianceMoments(count, sum, sumSquares float64) varianceMoments {
+	if count == 0 {
+		return varianceMoments{}
+	}
+	mean := sum / count
+	// guard against negative M2 of tiny variances due to rounding errors.
+	return varianceMoments{count: count, mean: mean, m2: math.Max(sumSquares-sum*mean, 0)}
+}
+
+// merge combines the moments of two disjoint sets of values with the parallel algorithm of Chan et al.
+func (m varianceMoments) merge(o varianceMoments) varianceMoments {
+	count := m.count + o.count
+	if count == 0 {
+		return varianceMoments{}
+	}
+	delta := o.mean - m.mean
+	return varianceMoments{
+		count: count,
+		mean:  m.mean + delta*o.count/count,
+		m2:    m.m2 + o.m2 + delta*delta*m.count*o.count/count,
+	}
+}
+
+// value returns the population variance, or the standard deviation for Stddev, nil for buckets without values.
+func (m varianceMoments) value(agg common.AggType) interface{} {
+	if m.count == 0 {
+		return nil
+	}
+	variance := m.m2 / m.count
+	if agg == common.Stddev {
+		return math.Sqrt(variance)
+	}
+	return variance
+}
+
+// buildVarianceMoments builds the variance moments of buckets from results of count, sum and sum of
+// squares queries of the same dimensions. Buckets missing sums have no values to contribute.
+func buildVarianceMoments(count, sum, sumSquares interface{}) interface{} {
+	switch c := count.(type) {
+	case float64:
+		s, _ := sum.(float64)
+		sq, _ := sumSquares.(float64)
+		return newVarianceMoments(c, s, sq)
+	case map[string]interface{}:
+		sumMap, _ := sum.(map[string]interface{})
+		sumSquaresMap, _ := sumSquares.(map[string]interface{})
+		for k, v := range c {
+			c[k] = buildVarianceMoments(v, sumMap[k], sumSquaresMap[k])
+		}
+		return c
+	}
+	return nil
+}
+
+// finalizeVariance replaces variance moments of the result by variances or standard deviations in place.
+func finalizeVariance(value interface{}, agg common.AggType) interface{} {
+	switch v := value.(type) {
+	case varianceMoments:
+		return v.value(agg)
+	case queryCom.AQLQueryResult:
+		finalizeVariance(map[string]interface{}(v), agg)
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = finalizeVariance(child, agg)
+		}
+	}
+	return value
+}
+
+// nullDimensionValue is the dimension value of nulls in results.
+const nullDimensionValue = "NULL"
+
+// defaultMaxDistinctValues is the default max number of distinct values of a bucket of CountDistinct.
+const defaultMaxDistinctValues = 100000
+
+// distinctValues is the partial result of CountDistinct of a bucket, as the set of distinct values of the
+// counted column. Nulls are not counted.
+type distinctValues map[string]struct{}
+
+// union adds values of the other set to the set in place, it fails once the set has more than max values.
+func (d distinctValues) union(o distinctValues, max int) error {
+	for v := range o {
+		d[v] = struct{}{}
+	}
+	return checkDistinctValues(len(d), max)
+}
+
+// checkDistinctValues returns the error if the number of distinct values of a bucket exceeds the max, 0 means
+// defaultMaxDistinctValues.
+func checkDistinctValues(n, max int) error {
+	if max <= 0 {
+		max = defaultMaxDistinctValues
+	}
+	if n > max {
+		return utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil,
+			"countdistinct found more than %d distinct values in a bucket, "+
+				"use countdistincthll for approximate distinct counts of high cardinality columns", max))
+	}
+	return nil
+}
+
+// buildDistinctValues builds distinct values of buckets from the result of the count query with the counted
+// column as the innermost dimension, keys of the innermost dimension are distinct values of their bucket
+// except for nulls. Results of queries without other dimensions are keyed by NULL like other results without
+// dimensions. Sizes of sets are checked by the merge, so that errors of oversized sets are reported by brokers
+// instead of as datanode failures.
+func buildDistinctValues(result queryCom.AQLQueryResult, numDimensions int) queryCom.AQLQueryResult {
+	if numDimensions == 0 {
+		return queryCom.AQLQueryResult{nullDimensionValue: newDistinctValues(map[string]interface{}(result))}
+	}
+	return queryCom.AQLQueryResult(buildDistinctValuesRecursive(map[string]interface{}(result), numDimensions).(map[string]interface{}))
+}
+
+func buildDistinctValuesRecursive(value interface{}, numDimensions int) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if numDimensions == 0 {
+		return newDistinctValues(m)
+	}
+	for k, v := range m {
+		m[k] = buildDistinctValuesRecursive(v, numDimensions-1)
+	}
+	return m
+}
+
+// newDistinctValues creates the distinct values from keys of the innermost dimension of a bucket.
+func newDistinctValues(counts map[string]interface{}) distinctValues {
+	values := make(distinctValues, len(counts))
+	for k := range counts {
+		if k != nullDimensionValue {
+			values[k] = struct{}{}
+		}
+	}
+	return values
+}
+
+// finalizeCountDistinct replaces distinct values of the result by their counts in place, it fails if any set
+// has more than max values, including sets of buckets only returned by one datanode.
+func finalizeCountDistinct(value interface{}, max int) (interface{}, error) {
+	switch v := value.(type) {
+	case distinctValues:
+		if err := checkDistinctValues(len(v), max); err != nil {
+			return nil, err
+		}
+		return float64(len(v)), nil
+	case queryCom.AQLQueryResult:
+		return finalizeCountDistinct(map[string]interface{}(v), max)
+	case map[string]interface{}:
+		for k, child := range v {
+			finalized, err := finalizeCountDistinct(child, max)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = finalized
+		}
+	}
+	return value, nil
+}
+
+// finalizePercentile replaces t-digests of the result by values of the quantiles in place, as arrays of values
+// for multiple quantiles. Buckets without values are nulls.
+func finalizePercentile(value interface{}, quantiles []float64) interface{} {
+	switch v := value.(type) {
+	case *queryCom.TDigest:
+		if v.Count() == 0 {
+			return nil
+		}
+		if len(quantiles) == 1 {
+			return v.Quantile(quantiles[0])
+		}
+		values := make([]interface{}, len(quantiles))
+		for i, q := range quantiles {
+			values[i] = v.Quantile(q)
+		}
+		return values
+	case queryCom.AQLQueryResult:
+		finalizePercentile(map[string]interface{}(v), quantiles)
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = finalizePercentile(child, quantiles)
+		}
+	}
+	return value
+}
+
+// countBuckets returns the number of leaves of the result, which are buckets of all dimensions.
+func countBuckets(value interface{}) int {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return 1
+	}
+	n := 0
+	for _, child := range m {
+		n += countBuckets(child)
+	}
+	return n
+}
+
+// topNOption is the top n option of aggregation queries with limits sorted by the measure.
+type topNOption struct {
+	limit      int
+	descending bool
+}
+
+// getTopNOption returns the top n option of the aggregation query if it has a limit and sorts by the
+// measure first, nil otherwise. Sorts by dimensions are not supported.
+func getTopNOption(aql *queryCom.AQLQuery) *topNOption {
+	if aql.Limit <= 0 || len(aql.Sorts) == 0 || len(aql.Measures) != 1 {
+		return nil
+	}
+	sortField, measure := aql.Sorts[0], aql.Measures[0]
+	name := strings.TrimSpace(sortField.Name)
+	if name == "" || (name != measure.Alias && !strings.EqualFold(name, strings.TrimSpace(measure.Expr))) {
+		return nil
+	}
+	return &topNOption{
+		limit:      aql.Limit,
+		descending: strings.EqualFold(sortField.Order, "desc"),
+	}
+}
+
+// resultLeaf is a measure value of the result with its dimension values.
+type resultLeaf struct {
+	path  []string
+	value interface{}
+}
+
+// truncateTopN keeps the top n buckets of the merged result by their measure values, since results of
+// all datanodes may have more buckets than the limit in total. Buckets of multiple dimensions are ranked
+// by their leaf values, nulls always rank last and ties are broken by dimension values in order.
+func truncateTopN(result queryCom.AQLQueryResult, option *topNOption) queryCom.AQLQueryResult {
+	var leaves []resultLeaf
+	collectLeaves(map[string]interface{}(result), nil, &leaves)
+	if len(leaves) <= option.limit {
+		return result
+	}
+
+	sort.Slice(leaves, func(i, j int) bool {
+		vi, iOK := leaves[i].value.(float64)
+		vj, jOK := leaves[j].value.(float64)
+		switch {
+		case iOK != jOK:
+			return iOK
+		case iOK && vi != vj:
+			if option.descending {
+				return vi > vj
+			}
+			return vi < vj
+		}
+		return comparePaths(leaves[i].path, leaves[j].path) < 0
+	})
+
+	truncated := queryCom.AQLQueryResult{}
+	for _, leaf := range leaves[:option.limit] {
+		node := map[string]interface{}(truncated)
+		for _, key := range leaf.path[:len(leaf.path)-1] {
+			child, ok := node[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[key] = child
+			}
+			node = child
+		}
+		node[leaf.path[len(leaf.path)-1]] = leaf.value
+	}
+	return truncated
+}
+
+// collectLeaves appends leaf values of the result with their paths of dimension values.
+func collectLeaves(value interface{}, path []string, leaves *[]resultLeaf) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		*leaves = append(*leaves, resultLeaf{path: path, value: value})
+		return
+	}
+	for k, v := range m {
+		childPath := make([]string, len(path)+1)
+		copy(childPath, path)
+		childPath[len(path)] = k
+		collectLeaves(v, childPath, leaves)
+	}
+}
+
+func comparePaths(lhs, rhs []string) int {
+	for i := 0; i < len(lhs) && i < len(rhs); i++ {
+		if c := strings.Compare(lhs[i], rhs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(lhs) - len(rhs)
+}
+
+// exportHLLSketches unions the hll result with the imported sketches, and exports sketches in the
+// standard dense format.
+func exportHLLSketches(result queryCom.AQLQueryResult, option *queryCom.HLLSketchOption) (queryCom.AQLQueryResult, error) {
+	if option.Union != nil {
+		if result == nil {
+			result = queryCom.AQLQueryResult{}
+		}
+		mergeCtx := newResultMergeContext(common.Hll)
+		result = mergeCtx.run(result, option.Union)
+		if mergeCtx.err != nil {
+			return nil, mergeCtx.err
+		}
+	}
+	return queryCom.ExportStandardHLLResult(result, option.Precision, option.RegisterWidth)
+}
@@ -20,7 +20,12 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/uber/aresdb/broker/common"
 	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
 	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
 )
 
 var _ = ginkgo.Describe("resultMerge", func() {
@@ -404,7 +409,7 @@ var _ = ginkgo.Describe("resultMerge", func() {
 		})
 	})
 
-	ginkgo.It("avg should error different shape", func() {
+	ginkgo.It("avg should work different shape", func() {
 		runTests([]resultMergeTestCase{
 			{
 				lhsBytes: []byte(`{
@@ -418,8 +423,13 @@ var _ = ginkgo.Describe("resultMerge", func() {
 						"bar": 1
 					}
 				}`),
-				agg:        common.Avg,
-				errPattern: "error calculating avg",
+				agg: common.Avg,
+				expected: []byte(`{
+					"1234": {
+						"foo": 2,
+						"bar": null
+					}
+				}`),
 			},
 			{
 				lhsBytes: []byte(`{}`),
@@ -429,22 +439,218 @@ var _ = ginkgo.Describe("resultMerge", func() {
 						"bar": 1
 					}
 				}`),
-				agg:        common.Avg,
-				errPattern: "error calculating avg",
+				agg: common.Avg,
+				expected: []byte(`{
+					"1234": {
+						"foo": null,
+						"bar": null
+					}
+				}`),
 			},
 			{
 				lhsBytes: []byte(`{
 					"1234": {
-						"foo": 123
+						"foo": 123,
+						"bar": null
+					}
+				}`),
+				rhsBytes: []byte(`{
+					"1234": {
+						"bar": 2
+					}
+				}`),
+				agg: common.Avg,
+				expected: []byte(`{
+					"1234": {
+						"foo": null,
+						"bar": null
+					}
+				}`),
+			},
+			{
+				lhsBytes: []byte(`{
+					"1234": {
+						"foo": 0
+					}
+				}`),
+				rhsBytes: []byte(`{
+					"1234": {
+						"foo": 0
+					}
+				}`),
+				agg: common.Avg,
+				expected: []byte(`{
+					"1234": {
+						"foo": null
+					}
+				}`),
+			},
+		})
+	})
+
+	ginkgo.It("avg should error incompatible shape", func() {
+		runTests([]resultMergeTestCase{
+			{
+				lhsBytes: []byte(`{
+					"1234": {
+						"foo": 2
+					}
+				}`),
+				rhsBytes: []byte(`{
+					"1234": {
+						"foo": {
+							"bar": 1
+						}
 					}
 				}`),
-				rhsBytes:   []byte(`{}`),
 				agg:        common.Avg,
-				errPattern: "error calculating avg",
+				errPattern: `merge type conflict at "1234"."foo": float vs object`,
 			},
 		})
 	})
 
+	ginkgo.It("should merge null leaves", func() {
+		var cases []resultMergeTestCase
+		for _, agg := range []common.AggType{common.Sum, common.Count, common.Min, common.Max} {
+			cases = append(cases,
+				// null on the left.
+				resultMergeTestCase{
+					lhsBytes: []byte(`{"1234": {"foo": null}}`),
+					rhsBytes: []byte(`{"1234": {"foo": 2}}`),
+					agg:      agg,
+					expected: []byte(`{"1234": {"foo": 2}}`),
+				},
+				// null on the right.
+				resultMergeTestCase{
+					lhsBytes: []byte(`{"1234": {"foo": 2}}`),
+					rhsBytes: []byte(`{"1234": {"foo": null}}`),
+					agg:      agg,
+					expected: []byte(`{"1234": {"foo": 2}}`),
+				},
+				// nulls on both sides.
+				resultMergeTestCase{
+					lhsBytes: []byte(`{"1234": {"foo": null}, "bar": null}`),
+					rhsBytes: []byte(`{"1234": {"foo": null}, "baz": null}`),
+					agg:      agg,
+					expected: []byte(`{"1234": {"foo": null}, "bar": null, "baz": null}`),
+				},
+			)
+		}
+		// null sums or counts contribute zero count to averages.
+		cases = append(cases,
+			resultMergeTestCase{
+				lhsBytes: []byte(`{"1234": {"foo": null}}`),
+				rhsBytes: []byte(`{"1234": {"foo": 2}}`),
+				agg:      common.Avg,
+				expected: []byte(`{"1234": {"foo": null}}`),
+			},
+			resultMergeTestCase{
+				lhsBytes: []byte(`{"1234": {"foo": 4}}`),
+				rhsBytes: []byte(`{"1234": {"foo": null}}`),
+				agg:      common.Avg,
+				expected: []byte(`{"1234": {"foo": null}}`),
+			},
+			resultMergeTestCase{
+				lhsBytes: []byte(`{"1234": {"foo": null}, "bar": null}`),
+				rhsBytes: []byte(`{"1234": {"foo": null}, "baz": null}`),
+				agg:      common.Avg,
+				expected: []byte(`{"1234": {"foo": null}, "bar": null, "baz": null}`),
+			},
+		)
+		runTests(cases)
+	})
+
+	ginkgo.It("should merge arrays of multiple measures", func() {
+		aggs := []common.AggType{common.Sum, common.Max, common.Min, common.Count}
+		runTests([]resultMergeTestCase{
+			{
+				lhsBytes: []byte(`{"1234": {"foo": [1, 2, 3, 4], "bar": [1, null, 1, 1]}}`),
+				rhsBytes: []byte(`{"1234": {"foo": [2, 1, 2, 1]}, "5678": {"bar": [null, null, null, 0]}}`),
+				aggs:     aggs,
+				expected: []byte(`{"1234": {"foo": [3, 2, 2, 5], "bar": [1, null, 1, 1]}, "5678": {"bar": [null, null, null, 0]}}`),
+			},
+			{
+				lhsBytes: []byte(`{"1234": [null, 2, 3, 4]}`),
+				rhsBytes: []byte(`{"1234": [2, null, 5, null]}`),
+				aggs:     aggs,
+				expected: []byte(`{"1234": [2, 2, 3, 4]}`),
+			},
+			{
+				lhsBytes:   []byte(`{"1234": [1, 2, 3, 4]}`),
+				rhsBytes:   []byte(`{"1234": [1, 2, 3]}`),
+				aggs:       aggs,
+				errPattern: "expect 4 measure values, but got 3",
+			},
+			{
+				lhsBytes:   []byte(`{"1234": [1, 2]}`),
+				rhsBytes:   []byte(`{"1234": [1, 2]}`),
+				agg:        common.Sum,
+				errPattern: "measure arrays found for single measure aggregation",
+			},
+			{
+				lhsBytes:   []byte(`{"1234": [1, 2, 3, 4]}`),
+				rhsBytes:   []byte(`{"1234": 1}`),
+				aggs:       aggs,
+				errPattern: `merge type conflict at "1234": array vs float`,
+			},
+		})
+	})
+
+	ginkgo.It("buildMeasureValues should combine results of measures", func() {
+		parse := func(s string) interface{} {
+			var result map[string]interface{}
+			Ω(json.Unmarshal([]byte(s), &result)).Should(BeNil())
+			return result
+		}
+		Ω(buildMeasureValues([]interface{}{
+			parse(`{"1": {"foo": 1, "bar": 2}, "2": {"foo": null}}`),
+			parse(`{"1": {"foo": 3}, "3": {"baz": 4}}`),
+		})).Should(Equal(parse(`{
+			"1": {"foo": [1, 3], "bar": [2, null]},
+			"2": {"foo": [null, null]},
+			"3": {"baz": [null, 4]}
+		}`)))
+		Ω(buildMeasureValues([]interface{}{parse(`{}`), parse(`{}`)})).Should(Equal(map[string]interface{}{}))
+	})
+
+	ginkgo.It("should report type conflicts with key paths", func() {
+		results := func(numCities int) (lhs, rhs queryCom.AQLQueryResult) {
+			lhs, rhs = queryCom.AQLQueryResult{}, queryCom.AQLQueryResult{}
+			for i := 0; i < numCities; i++ {
+				city := strconv.Itoa(i)
+				lhs[city] = map[string]interface{}{"SFO": 1.0, "LAX": 1.0}
+				rhs[city] = map[string]interface{}{"SFO": map[string]interface{}{"foo": 1.0}, "LAX": 2.0}
+			}
+			return
+		}
+
+		lhs, rhs := results(3)
+		ctx := newResultMergeContext(common.Sum)
+		ctx.run(lhs, rhs)
+		lhsAll, rhsAll := results(3)
+		_, err := mergeAll([]queryCom.AQLQueryResult{lhsAll, rhsAll}, common.Sum)
+		for _, err := range []error{ctx.err, err} {
+			Ω(err).ShouldNot(BeNil())
+			for i := 0; i < 3; i++ {
+				Ω(err.Error()).Should(ContainSubstring(`merge type conflict at "%d"."SFO": float vs object`, i))
+			}
+			Ω(err.Error()).ShouldNot(ContainSubstring("aborted"))
+		}
+		// values without conflicts are still merged.
+		Ω(lhs["0"].(map[string]interface{})["LAX"]).Should(Equal(3.0))
+
+		lhs, rhs = results(20)
+		ctx = newResultMergeContext(common.Sum)
+		ctx.run(lhs, rhs)
+		lhsAll, rhsAll = results(20)
+		_, err = mergeAll([]queryCom.AQLQueryResult{lhsAll, rhsAll}, common.Sum)
+		for _, err := range []error{ctx.err, err} {
+			Ω(err).ShouldNot(BeNil())
+			Ω(strings.Count(err.Error(), "merge type conflict at")).Should(Equal(maxMergeConflicts))
+			Ω(err.Error()).Should(ContainSubstring("aborted after 10 conflicts"))
+		}
+	})
+
 	ginkgo.It("hll should work same shape", func() {
 		data, err := ioutil.ReadFile("../testing/data/query/hll_query_results")
 		Ω(err).Should(BeNil())
@@ -455,22 +661,447 @@ var _ = ginkgo.Describe("resultMerge", func() {
 		Ω(ctx.err).Should(BeNil())
 		Ω(result).Should(Equal(lhs[0]))
 	})
+
+	ginkgo.It("hll should work different shape", func() {
+		// datanodes see different buckets of their shards: city 1 only in shard 0, city 3 only in shard 1,
+		// and city 2 in both with 20000 and 10000 distinct values, 25000 in total.
+		parse := func(file string) queryCom.AQLQueryResult {
+			data, err := ioutil.ReadFile("../testing/data/query/" + file)
+			Ω(err).Should(BeNil())
+			results, errs, err := queryCom.ParseHLLQueryResults(data)
+			Ω(err).Should(BeNil())
+			Ω(errs).Should(Equal([]error{nil}))
+			return results[0]
+		}
+		estimates := func(result queryCom.AQLQueryResult) map[string]float64 {
+			estimates := map[string]float64{}
+			for city, value := range queryCom.ComputeHLLResult(result) {
+				estimates[city] = value.(float64)
+			}
+			return estimates
+		}
+		shard0, shard1 := estimates(parse("hll_query_results_shard0")), estimates(parse("hll_query_results_shard1"))
+		Ω(shard0).Should(HaveLen(2))
+		Ω(shard1).Should(HaveLen(2))
+
+		ctx := newResultMergeContext(common.Hll)
+		pairwise := ctx.run(parse("hll_query_results_shard0"), parse("hll_query_results_shard1"))
+		Ω(ctx.err).Should(BeNil())
+		merged, err := mergeAll([]queryCom.AQLQueryResult{parse("hll_query_results_shard0"), parse("hll_query_results_shard1")}, common.Hll)
+		Ω(err).Should(BeNil())
+		Ω(merged).Should(Equal(pairwise))
+		// merge is commutative.
+		ctx = newResultMergeContext(common.Hll)
+		Ω(ctx.run(parse("hll_query_results_shard1"), parse("hll_query_results_shard0"))).Should(Equal(pairwise))
+		Ω(ctx.err).Should(BeNil())
+
+		for _, result := range []queryCom.AQLQueryResult{pairwise, merged} {
+			mergedEstimates := estimates(result)
+			Ω(mergedEstimates).Should(HaveLen(3))
+			// sketches of buckets in one side are copied through.
+			Ω(mergedEstimates["1"]).Should(Equal(shard0["1"]))
+			Ω(mergedEstimates["3"]).Should(Equal(shard1["3"]))
+			Ω(mergedEstimates["1"]).Should(BeNumerically("~", 1000, 30))
+			Ω(mergedEstimates["3"]).Should(BeNumerically("~", 500, 15))
+			// registers of buckets in both sides are unioned instead of adding up estimates.
+			Ω(shard0["2"]).Should(BeNumerically("~", 20000, 600))
+			Ω(shard1["2"]).Should(BeNumerically("~", 10000, 300))
+			Ω(mergedEstimates["2"]).Should(BeNumerically("~", 25000, 750))
+		}
+	})
+
+	ginkgo.It("hll should union imported sketches and export sketches", func() {
+		data, err := ioutil.ReadFile("../testing/data/query/hll_query_results")
+		Ω(err).Should(BeNil())
+		parse := func() queryCom.AQLQueryResult {
+			results, _, err := queryCom.ParseHLLQueryResults(data)
+			Ω(err).Should(BeNil())
+			return results[0]
+		}
+		expected, err := queryCom.ExportStandardHLLResult(parse(), 12, 5)
+		Ω(err).Should(BeNil())
+
+		union, err := queryCom.ExportStandardHLLResult(parse(), 0, 6)
+		Ω(err).Should(BeNil())
+		union, err = queryCom.ImportStandardHLLResult(union)
+		Ω(err).Should(BeNil())
+		option := &queryCom.HLLSketchOption{Precision: 12, RegisterWidth: 5, Union: union}
+		result, err := exportHLLSketches(parse(), option)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(expected))
+
+		// results without sketches from datanodes.
+		union, err = queryCom.ImportStandardHLLResult(union)
+		Ω(err).Should(BeNil())
+		option.Union = union
+		result, err = exportHLLSketches(nil, option)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(expected))
+
+		// without union.
+		result, err = exportHLLSketches(parse(), &queryCom.HLLSketchOption{Precision: 12, RegisterWidth: 5})
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(expected))
+
+		_, err = exportHLLSketches(parse(), &queryCom.HLLSketchOption{RegisterWidth: 5, Union: parse()})
+		Ω(err).Should(BeNil())
+		_, err = exportHLLSketches(parse(), &queryCom.HLLSketchOption{RegisterWidth: 5, Union: queryCom.AQLQueryResult{
+			"NULL": 1.0,
+		}})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("mergeAll should merge all results in one pass", func() {
+		parse := func(s string) queryCom.AQLQueryResult {
+			var result queryCom.AQLQueryResult
+			Ω(json.Unmarshal([]byte(s), &result)).Should(BeNil())
+			return result
+		}
+		results := func() []queryCom.AQLQueryResult {
+			return []queryCom.AQLQueryResult{
+				parse(`{"1": {"foo": 1, "bar": 2}}`),
+				nil,
+				parse(`{"1": {"foo": 3}, "2": {"bar": 4}}`),
+				parse(`{"2": {"bar": 5}, "3": {"foo": 6}}`),
+			}
+		}
+
+		for _, agg := range []common.AggType{common.Count, common.Sum, common.Max, common.Min} {
+			fold := queryCom.AQLQueryResult{}
+			for _, result := range results() {
+				ctx := newResultMergeContext(agg)
+				fold = ctx.run(fold, result)
+				Ω(ctx.err).Should(BeNil())
+			}
+			merged, err := mergeAll(results(), agg)
+			Ω(err).Should(BeNil())
+			Ω(merged).Should(Equal(fold))
+		}
+
+		// results merged as they arrive in any order are the same.
+		expected, err := mergeAll(results(), common.Sum)
+		Ω(err).Should(BeNil())
+		for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {1, 3, 0, 2}} {
+			rs := results()
+			ctx := newResultMergeContext(common.Sum)
+			for _, i := range order {
+				ctx.add(rs[i])
+			}
+			merged, err := ctx.merged()
+			Ω(err).Should(BeNil())
+			Ω(merged).Should(Equal(expected))
+		}
+		ctx := newResultMergeContext(common.Sum)
+		merged, err := ctx.merged()
+		Ω(err).Should(BeNil())
+		Ω(merged).Should(Equal(queryCom.AQLQueryResult{}))
+		ctx.add(parse(`{"1": 1}`))
+		ctx.add(parse(`{"1": {"foo": 1}}`))
+		_, err = ctx.merged()
+		Ω(err.Error()).Should(ContainSubstring(`merge type conflict at "1": float vs object`))
+
+		// null buckets of any result are kept.
+		merged, err = mergeAll(append(results(), parse(`{"2": {"baz": null}}`)), common.Sum)
+		Ω(err).Should(BeNil())
+		Ω(merged).Should(Equal(parse(`{"1": {"foo": 4, "bar": 2}, "2": {"bar": 9, "baz": null}, "3": {"foo": 6}}`)))
+
+		merged, err = mergeAll(nil, common.Sum)
+		Ω(err).Should(BeNil())
+		Ω(merged).Should(Equal(queryCom.AQLQueryResult{}))
+
+		_, err = mergeAll(results(), common.Avg)
+		Ω(err.Error()).Should(ContainSubstring("avg merge expects sum and count results, but got 4 results"))
+
+		_, err = mergeAll(append(results(), parse(`{"3": {"foo": {"bar": 1}}}`)), common.Sum)
+		Ω(err.Error()).Should(ContainSubstring(`merge type conflict at "3"."foo": float vs object`))
+	})
+
+	ginkgo.It("should reuse reset merge contexts", func() {
+		parse := func(s string) queryCom.AQLQueryResult {
+			var result queryCom.AQLQueryResult
+			Ω(json.Unmarshal([]byte(s), &result)).Should(BeNil())
+			return result
+		}
+		ctx := getResultMergeContext(common.Sum)
+		ctx.add(parse(`{"1": {"foo": 1}}`))
+		ctx.add(parse(`{"1": 2}`))
+		_, err := ctx.merged()
+		Ω(err.Error()).Should(ContainSubstring(`merge type conflict at "1": object vs float`))
+
+		// errors, conflicts and results of the previous merge are cleared, buffers are kept.
+		ctx.Reset(common.Max)
+		Ω(ctx.agg).Should(Equal(common.Max))
+		Ω(ctx.err).Should(BeNil())
+		Ω(ctx.conflicts).Should(BeEmpty())
+		Ω(ctx.result).Should(BeNil())
+		Ω(ctx.buffers).ShouldNot(BeEmpty())
+		for _, buffer := range ctx.buffers {
+			for _, value := range buffer {
+				Ω(value).Should(BeNil())
+			}
+		}
+
+		// buffers grow with the number of results.
+		for _, r := range []string{`{"1": {"foo": 1}}`, `{"1": {"foo": 3}}`, `{"1": {"bar": 2}}`} {
+			ctx.add(parse(r))
+		}
+		merged, err := ctx.merged()
+		Ω(err).Should(BeNil())
+		Ω(merged).Should(Equal(parse(`{"1": {"foo": 3, "bar": 2}}`)))
+		putResultMergeContext(ctx)
+		Ω(ctx.result).Should(BeNil())
+		merged, err = mergeAll([]queryCom.AQLQueryResult{parse(`{"1": 1}`), parse(`{"1": 2}`), parse(`{"2": 3}`)}, common.Sum)
+		Ω(err).Should(BeNil())
+		Ω(merged).Should(Equal(parse(`{"1": 3, "2": 3}`)))
+	})
+
+	ginkgo.It("getTopNOption should work", func() {
+		q := queryCom.AQLQuery{
+			Measures: []queryCom.Measure{{Alias: "trips", Expr: "count(*)"}},
+			Sorts:    []queryCom.SortField{{Name: "trips", Order: "DESC"}},
+			Limit:    10,
+		}
+		Ω(getTopNOption(&q)).Should(Equal(&topNOption{limit: 10, descending: true}))
+		q.Sorts[0] = queryCom.SortField{Name: "COUNT(*)"}
+		Ω(getTopNOption(&q)).Should(Equal(&topNOption{limit: 10}))
+
+		q.Sorts[0] = queryCom.SortField{Name: "city_id", Order: "desc"}
+		Ω(getTopNOption(&q)).Should(BeNil())
+		q.Sorts = nil
+		Ω(getTopNOption(&q)).Should(BeNil())
+		q.Sorts = []queryCom.SortField{{Name: "trips"}}
+		q.Limit = 0
+		Ω(getTopNOption(&q)).Should(BeNil())
+	})
+
+	ginkgo.It("truncateTopN should keep top n buckets of leaves", func() {
+		parse := func(s string) queryCom.AQLQueryResult {
+			var result queryCom.AQLQueryResult
+			Ω(json.Unmarshal([]byte(s), &result)).Should(BeNil())
+			return result
+		}
+		result := func() queryCom.AQLQueryResult {
+			return parse(`{
+				"1": {"completed": 5, "canceled": 1, "failed": null},
+				"2": {"completed": 3, "canceled": 5},
+				"3": {"completed": 2}
+			}`)
+		}
+
+		Ω(truncateTopN(result(), &topNOption{limit: 3, descending: true})).Should(Equal(parse(`{
+			"1": {"completed": 5},
+			"2": {"completed": 3, "canceled": 5}
+		}`)))
+		// ties are broken by dimension values.
+		Ω(truncateTopN(result(), &topNOption{limit: 1, descending: true})).Should(Equal(parse(`{
+			"1": {"completed": 5}
+		}`)))
+		// nulls rank last in both orders.
+		Ω(truncateTopN(result(), &topNOption{limit: 5})).Should(Equal(parse(`{
+			"1": {"completed": 5, "canceled": 1},
+			"2": {"completed": 3, "canceled": 5},
+			"3": {"completed": 2}
+		}`)))
+		Ω(truncateTopN(result(), &topNOption{limit: 6})).Should(Equal(result()))
+		Ω(truncateTopN(parse(`{"NULL": 1}`), &topNOption{limit: 1})).Should(Equal(parse(`{"NULL": 1}`)))
+	})
+
+	ginkgo.It("should merge variance moments of datanodes", func() {
+		values := [][]float64{{1, 2, 3}, {10, 20}, {5}, {}}
+		var all []float64
+		for _, vs := range values {
+			all = append(all, vs...)
+		}
+		mean, m2 := 0.0, 0.0
+		for _, v := range all {
+			mean += v / float64(len(all))
+		}
+		for _, v := range all {
+			m2 += (v - mean) * (v - mean)
+		}
+
+		// momentsOf returns moments of the bucket from count, sum and sum of squares results of the datanode.
+		momentsOf := func(vs []float64) queryCom.AQLQueryResult {
+			count, sum, sumSquares := 0.0, 0.0, 0.0
+			for _, v := range vs {
+				count++
+				sum += v
+				sumSquares += v * v
+			}
+			return queryCom.AQLQueryResult(buildVarianceMoments(
+				map[string]interface{}{"foo": map[string]interface{}{"1": count}},
+				map[string]interface{}{"foo": map[string]interface{}{"1": sum}},
+				map[string]interface{}{"foo": map[string]interface{}{"1": sumSquares}},
+			).(map[string]interface{}))
+		}
+
+		for _, agg := range []common.AggType{common.Var, common.Stddev} {
+			result := momentsOf(values[0])
+			for _, vs := range values[1:] {
+				ctx := newResultMergeContext(agg)
+				result = ctx.run(result, momentsOf(vs))
+				Ω(ctx.err).Should(BeNil())
+			}
+			// datanode without the bucket.
+			ctx := newResultMergeContext(agg)
+			result = ctx.run(result, queryCom.AQLQueryResult{})
+			Ω(ctx.err).Should(BeNil())
+
+			finalizeVariance(result, agg)
+			expected := m2 / float64(len(all))
+			if agg == common.Stddev {
+				expected = math.Sqrt(expected)
+			}
+			Ω(result["foo"].(map[string]interface{})["1"]).Should(BeNumerically("~", expected, 1e-9))
+		}
+
+		// buckets without values are nulls.
+		result := queryCom.AQLQueryResult{"foo": newVarianceMoments(0, 0, 0)}
+		finalizeVariance(result, common.Stddev)
+		Ω(result).Should(Equal(queryCom.AQLQueryResult{"foo": nil}))
+
+		ctx := newResultMergeContext(common.Sum)
+		ctx.run(queryCom.AQLQueryResult{"foo": newVarianceMoments(1, 1, 1)},
+			queryCom.AQLQueryResult{"foo": newVarianceMoments(1, 1, 1)})
+		Ω(ctx.err.Error()).Should(ContainSubstring("variance moments found for non variance aggregation"))
+	})
+
+	ginkgo.It("should union distinct values of datanodes", func() {
+		parse := func(s string) queryCom.AQLQueryResult {
+			var result queryCom.AQLQueryResult
+			Ω(json.Unmarshal([]byte(s), &result)).Should(BeNil())
+			return result
+		}
+		// results of datanodes counting rows by the counted column as the innermost dimension.
+		datanodeResults := func() []queryCom.AQLQueryResult {
+			return []queryCom.AQLQueryResult{
+				buildDistinctValues(parse(`{"sf": {"a": 1, "b": 2, "NULL": 3}, "la": {"x": 1}}`), 1),
+				// overlapping with the first datanode.
+				buildDistinctValues(parse(`{"sf": {"b": 1, "c": 1}, "la": {"x": 4}}`), 1),
+				// disjoint from other datanodes.
+				buildDistinctValues(parse(`{"sf": {"d": 1}, "ny": {"NULL": 2}}`), 1),
+			}
+		}
+		expected := parse(`{"sf": 4, "la": 1, "ny": 0}`)
+
+		results := datanodeResults()
+		result := results[0]
+		for _, r := range results[1:] {
+			ctx := newResultMergeContext(common.CountDistinct)
+			result = ctx.run(result, r)
+			Ω(ctx.err).Should(BeNil())
+		}
+		_, err := finalizeCountDistinct(result, 0)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(expected))
+
+		ctx := newResultMergeContext(common.CountDistinct)
+		for _, r := range datanodeResults() {
+			ctx.add(r)
+		}
+		result, err = ctx.merged()
+		Ω(err).Should(BeNil())
+		_, err = finalizeCountDistinct(result, 0)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(expected))
+
+		// results without dimensions are keyed by NULL.
+		result = buildDistinctValues(parse(`{"a": 1, "NULL": 1}`), 0)
+		_, err = finalizeCountDistinct(result, 0)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(parse(`{"NULL": 1}`)))
+
+		ctx = newResultMergeContext(common.Sum)
+		ctx.run(datanodeResults()[0], datanodeResults()[1])
+		Ω(ctx.err.Error()).Should(ContainSubstring("distinct values found for non count distinct aggregation"))
+	})
+
+	ginkgo.It("should merge t-digests of datanodes", func() {
+		// digestsOf returns results of datanodes of the values split by datanodes in turn.
+		digestsOf := func(values []float64, numDataNodes int) []queryCom.AQLQueryResult {
+			results := make([]queryCom.AQLQueryResult, numDataNodes)
+			for i := range results {
+				results[i] = queryCom.AQLQueryResult{"foo": queryCom.NewTDigest(queryCom.DefaultTDigestCompression)}
+			}
+			for i, v := range values {
+				results[i%numDataNodes]["foo"].(*queryCom.TDigest).Add(v, 1)
+			}
+			return results
+		}
+		values := make([]float64, 1000)
+		for i := range values {
+			values[i] = float64(i)
+		}
+
+		results := digestsOf(values, 3)
+		result := results[0]
+		for _, r := range results[1:] {
+			ctx := newResultMergeContext(common.Percentile)
+			result = ctx.run(result, r)
+			Ω(ctx.err).Should(BeNil())
+		}
+		finalizePercentile(result, []float64{0.5})
+		Ω(result["foo"]).Should(BeNumerically("~", 500, 10))
+
+		result, err := mergeAll(digestsOf(values, 4), common.Percentile)
+		Ω(err).Should(BeNil())
+		finalizePercentile(result, []float64{0, 0.9, 1})
+		Ω(result["foo"]).Should(HaveLen(3))
+		Ω(result["foo"].([]interface{})[0]).Should(Equal(0.0))
+		Ω(result["foo"].([]interface{})[1]).Should(BeNumerically("~", 900, 10))
+		Ω(result["foo"].([]interface{})[2]).Should(Equal(999.0))
+
+		// buckets without values are nulls.
+		result = queryCom.AQLQueryResult{"foo": queryCom.NewTDigest(queryCom.DefaultTDigestCompression)}
+		finalizePercentile(result, []float64{0.5})
+		Ω(result).Should(Equal(queryCom.AQLQueryResult{"foo": nil}))
+
+		ctx := newResultMergeContext(common.Sum)
+		ctx.run(digestsOf(values, 2)[0], digestsOf(values, 2)[1])
+		Ω(ctx.err.Error()).Should(ContainSubstring("t-digest found for non percentile aggregation"))
+	})
+
+	ginkgo.It("should fail count distinct of buckets with too many distinct values", func() {
+		lhs := queryCom.AQLQueryResult{"foo": distinctValues{"a": {}, "b": {}}}
+		rhs := queryCom.AQLQueryResult{"foo": distinctValues{"b": {}, "c": {}}}
+		ctx := newResultMergeContext(common.CountDistinct)
+		ctx.maxDistinctValues = 2
+		ctx.run(lhs, rhs)
+		Ω(ctx.err.Error()).Should(ContainSubstring("countdistinct found more than 2 distinct values in a bucket"))
+		Ω(ctx.err.Error()).Should(ContainSubstring("use countdistincthll"))
+		Ω(ctx.err.Error()).Should(ContainSubstring("failed to merge results, path: [foo]"))
+		Ω(utils.GetErrorCode(ctx.err)).Should(Equal(utils.ErrCodeInvalidQuery))
+
+		// sets of buckets only returned by one datanode are checked when finalized.
+		_, err := finalizeCountDistinct(queryCom.AQLQueryResult{"foo": distinctValues{"a": {}, "b": {}}}, 1)
+		Ω(err.Error()).Should(ContainSubstring("countdistinct found more than 1 distinct values in a bucket"))
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+
+		Ω(checkDistinctValues(defaultMaxDistinctValues, 0)).Should(BeNil())
+		Ω(checkDistinctValues(defaultMaxDistinctValues+1, 0)).ShouldNot(BeNil())
+	})
 })
 
 type resultMergeTestCase struct {
-	lhsBytes   []byte
-	rhsBytes   []byte
-	agg        common.AggType
+	lhsBytes []byte
+	rhsBytes []byte
+	agg      common.AggType
+	// aggregations of multiple measures, agg is ignored if set.
+	aggs       []common.AggType
 	expected   []byte
 	errPattern string
 }
 
 func runTests(cases []resultMergeTestCase) {
 	for _, tc := range cases {
+		aggTypes := []common.AggType{tc.agg}
+		if tc.aggs != nil {
+			aggTypes = tc.aggs
+		}
 		var lhs, rhs queryCom.AQLQueryResult
 		json.Unmarshal(tc.lhsBytes, &lhs)
 		json.Unmarshal(tc.rhsBytes, &rhs)
-		ctx := newResultMergeContext(tc.agg)
+		ctx := newResultMergeContext(aggTypes...)
 		result := ctx.run(lhs, rhs)
 		if "" == tc.errPattern {
 			Ω(ctx.err).Should(BeNil())
@@ -480,5 +1111,89 @@ func runTests(cases []resultMergeTestCase) {
 		} else {
 			Ω(ctx.err.Error()).Should(ContainSubstring(tc.errPattern))
 		}
+
+		// merging all results at once should be the same as merging them pairwise.
+		var lhsAll, rhsAll queryCom.AQLQueryResult
+		json.Unmarshal(tc.lhsBytes, &lhsAll)
+		json.Unmarshal(tc.rhsBytes, &rhsAll)
+		result, err := mergeAll([]queryCom.AQLQueryResult{lhsAll, rhsAll}, aggTypes...)
+		if "" == tc.errPattern {
+			Ω(err).Should(BeNil())
+			bs, err := json.Marshal(result)
+			Ω(err).Should(BeNil())
+			Ω(bs).Should(MatchJSON(tc.expected))
+		} else {
+			Ω(err.Error()).Should(ContainSubstring(tc.errPattern))
+		}
+	}
+}
+
+// newBenchmarkResults creates results of datanodes grouped by two dimensions, each datanode has half
+// of the buckets.
+func newBenchmarkResults(numResults, numBuckets int) []queryCom.AQLQueryResult {
+	const numCities = 100
+	results := make([]queryCom.AQLQueryResult, numResults)
+	for i := range results {
+		results[i] = queryCom.AQLQueryResult{}
+		for j := 0; j < numBuckets; j++ {
+			if (i+j)%2 != 0 {
+				continue
+			}
+			city := strconv.Itoa(j % numCities)
+			cityResult, ok := results[i][city].(map[string]interface{})
+			if !ok {
+				cityResult = map[string]interface{}{}
+				results[i][city] = cityResult
+			}
+			cityResult[strconv.Itoa(j/numCities)] = float64(i + j)
+		}
 	}
+	return results
+}
+
+func benchmarkResultMerge(b *testing.B, merge func(results []queryCom.AQLQueryResult)) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		// results are merged in place.
+		results := newBenchmarkResults(50, 100000)
+		b.StartTimer()
+		merge(results)
+	}
+}
+
+func BenchmarkResultMerge_PairwiseFold(b *testing.B) {
+	benchmarkResultMerge(b, func(results []queryCom.AQLQueryResult) {
+		result := results[0]
+		for _, r := range results[1:] {
+			ctx := newResultMergeContext(common.Sum)
+			result = ctx.run(result, r)
+		}
+	})
+}
+
+func BenchmarkResultMerge_MergeAll(b *testing.B) {
+	benchmarkResultMerge(b, func(results []queryCom.AQLQueryResult) {
+		mergeAll(results, common.Sum)
+	})
+}
+
+func BenchmarkResultMerge_Add(b *testing.B) {
+	benchmarkResultMerge(b, func(results []queryCom.AQLQueryResult) {
+		ctx := newResultMergeContext(common.Sum)
+		for _, r := range results {
+			ctx.add(r)
+		}
+		ctx.merged()
+	})
+}
+
+func BenchmarkResultMerge_AddPooled(b *testing.B) {
+	benchmarkResultMerge(b, func(results []queryCom.AQLQueryResult) {
+		ctx := getResultMergeContext(common.Sum)
+		for _, r := range results {
+			ctx.add(r)
+		}
+		ctx.merged()
+		putResultMergeContext(ctx)
+	})
 }
@@ -0,0 +1,122 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultRetryBudgetRatio  = 0.1
+	defaultRetryBudgetWindow = 10 * time.Second
+)
+
+// retryBudgetBucket is the deposits and withdrawals of a second of the window of the retry budget.
+type retryBudgetBucket struct {
+	// unix second of the bucket, buckets of seconds out of the window are reset once reused.
+	second      int64
+	deposits    float64
+	withdrawals float64
+}
+
+// RetryBudget caps retries of scans of datanodes across queries of the broker to a ratio of recent scans, so
+// that retries do not multiply the load on the remaining healthy datanodes when many datanodes fail at once.
+// Each scan deposits the ratio of a token and each retry withdraws a token, both expire after the window,
+// and a reserve of min retries per second over the window lets retries of low traffic through. Retries of
+// scans of both aggregation and non aggregation queries are skipped once the budget is exhausted.
+type RetryBudget struct {
+	sync.Mutex
+
+	ratio float64
+	// tokens available without deposits, min retries per second over the window.
+	reserve float64
+	// buckets of seconds of the window indexed by unix seconds modulo the window.
+	buckets []retryBudgetBucket
+}
+
+// NewRetryBudget creates the retry budget of scans of datanodes, nil if not enabled.
+func NewRetryBudget(cfg config.RetryBudgetConfig) *RetryBudget {
+	if !cfg.Enable {
+		return nil
+	}
+	ratio := cfg.Ratio
+	if ratio <= 0 {
+		ratio = defaultRetryBudgetRatio
+	}
+	window := time.Duration(cfg.WindowSec) * time.Second
+	if window <= 0 {
+		window = defaultRetryBudgetWindow
+	}
+	numBuckets := int(window / time.Second)
+	return &RetryBudget{
+		ratio:   ratio,
+		reserve: float64(cfg.MinRetriesPerSecond * numBuckets),
+		buckets: make([]retryBudgetBucket, numBuckets),
+	}
+}
+
+// deposit deposits the ratio of a token for a scan.
+func (b *RetryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.bucket(utils.Now().Unix()).deposits += b.ratio
+}
+
+// withdraw withdraws a token for a retry, false if the budget is exhausted and the retry is skipped.
+func (b *RetryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	now := utils.Now().Unix()
+	balance := b.balance(now)
+	if balance < 1 {
+		utils.GetRootReporter().GetCounter(utils.BrokerRetryBudgetExhausted).Inc(1)
+		return false
+	}
+	b.bucket(now).withdrawals++
+	utils.GetRootReporter().GetCounter(utils.BrokerRetryBudgetConsumed).Inc(1)
+	utils.GetRootReporter().GetGauge(utils.BrokerRetryBudgetBalance).Update(balance - 1)
+	return true
+}
+
+// balance returns tokens available at the second, the lock must be held.
+func (b *RetryBudget) balance(now int64) float64 {
+	balance := b.reserve
+	for _, bucket := range b.buckets {
+		if now-bucket.second < int64(len(b.buckets)) {
+			balance += bucket.deposits - bucket.withdrawals
+		}
+	}
+	return balance
+}
+
+// bucket returns the bucket of the second, reset if it was last used for a second out of the window, the lock
+// must be held.
+func (b *RetryBudget) bucket(now int64) *retryBudgetBucket {
+	bucket := &b.buckets[now%int64(len(b.buckets))]
+	if bucket.second != now {
+		*bucket = retryBudgetBucket{second: now}
+	}
+	return bucket
+}
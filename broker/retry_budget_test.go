@@ -0,0 +1,74 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("retry budget", func() {
+	ginkgo.BeforeEach(func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should not be created if not enabled", func() {
+		budget := NewRetryBudget(config.RetryBudgetConfig{})
+		Ω(budget).Should(BeNil())
+		// retries are not capped without the budget.
+		budget.deposit()
+		Ω(budget.withdraw()).Should(BeTrue())
+	})
+
+	ginkgo.It("should allow retries by the ratio of recent scans", func() {
+		budget := NewRetryBudget(config.RetryBudgetConfig{Enable: true, Ratio: 0.5, WindowSec: 2})
+		Ω(budget.withdraw()).Should(BeFalse())
+		for i := 0; i < 4; i++ {
+			budget.deposit()
+		}
+		Ω(budget.withdraw()).Should(BeTrue())
+		Ω(budget.withdraw()).Should(BeTrue())
+		Ω(budget.withdraw()).Should(BeFalse())
+
+		// deposits and withdrawals expire after the window.
+		utils.SetCurrentTime(time.Unix(1001, 0))
+		budget.deposit()
+		budget.deposit()
+		Ω(budget.withdraw()).Should(BeTrue())
+		utils.SetCurrentTime(time.Unix(1002, 0))
+		Ω(budget.withdraw()).Should(BeFalse())
+		utils.SetCurrentTime(time.Unix(1003, 0))
+		Ω(budget.withdraw()).Should(BeFalse())
+	})
+
+	ginkgo.It("should allow min retries per second without scans", func() {
+		budget := NewRetryBudget(config.RetryBudgetConfig{Enable: true, MinRetriesPerSecond: 1, WindowSec: 2})
+		Ω(budget.withdraw()).Should(BeTrue())
+		Ω(budget.withdraw()).Should(BeTrue())
+		Ω(budget.withdraw()).Should(BeFalse())
+		utils.SetCurrentTime(time.Unix(1001, 0))
+		Ω(budget.withdraw()).Should(BeFalse())
+		utils.SetCurrentTime(time.Unix(1002, 0))
+		Ω(budget.withdraw()).Should(BeTrue())
+	})
+})
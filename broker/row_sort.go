@@ -0,0 +1,228 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rowSort sorts rows of non aggregation queries by a dimension.
+type rowSort struct {
+	// index of the dimension in rows.
+	index      int
+	descending bool
+	// values are compared as numbers instead of strings.
+	numeric bool
+}
+
+// sortedRows are rows of a datanode sorted by the sorts of the query, with values of sort dimensions of
+// rows as sort keys.
+type sortedRows struct {
+	rows []json.RawMessage
+	keys [][]interface{}
+}
+
+// newSortedRows sorts rows of a datanode. Datanodes do not sort rows, the sort is stable so that rows of
+// the same keys keep the order of datanodes.
+func newSortedRows(rows []json.RawMessage, sorts []rowSort) (*sortedRows, error) {
+	sr := &sortedRows{rows: rows, keys: make([][]interface{}, len(rows))}
+	for i, row := range rows {
+		var values []interface{}
+		if err := json.Unmarshal(row, &values); err != nil {
+			return nil, err
+		}
+		sr.keys[i] = sortKey(values, sorts)
+	}
+	sort.Stable(sr.sorter(sorts))
+	return sr, nil
+}
+
+// truncate keeps the leading n rows, other rows are released.
+func (sr *sortedRows) truncate(n int) {
+	if n < len(sr.rows) {
+		sr.rows, sr.keys = sr.rows[:n:n], sr.keys[:n:n]
+	}
+}
+
+// bytes returns the bytes of rows as serialized by datanodes.
+func (sr *sortedRows) bytes() (n int) {
+	for _, row := range sr.rows {
+		n += len(row)
+	}
+	return
+}
+
+// sortKey returns values of sort dimensions of the row, either nil for nulls, float64 or string.
+func sortKey(values []interface{}, sorts []rowSort) []interface{} {
+	key := make([]interface{}, len(sorts))
+	for i, s := range sorts {
+		if s.index >= len(values) {
+			continue
+		}
+		switch value := values[s.index].(type) {
+		case string:
+			key[i] = value
+			if s.numeric {
+				// values not parsed as numbers are compared as strings after numbers.
+				if number, err := strconv.ParseFloat(value, 64); err == nil {
+					key[i] = number
+				}
+			}
+		case float64:
+			key[i] = value
+		case bool:
+			key[i] = strconv.FormatBool(value)
+		}
+	}
+	return key
+}
+
+// compareSortKeys compares sort keys of two rows by the sorts, nulls are the smallest values.
+func compareSortKeys(a, b []interface{}, sorts []rowSort) int {
+	for i, s := range sorts {
+		c := compareSortValues(a[i], b[i])
+		if s.descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareSortValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case nil:
+		if b == nil {
+			return 0
+		}
+		return -1
+	case float64:
+		switch b := b.(type) {
+		case nil:
+			return 1
+		case float64:
+			if a < b {
+				return -1
+			} else if a > b {
+				return 1
+			}
+			return 0
+		default:
+			return -1
+		}
+	case string:
+		switch b := b.(type) {
+		case string:
+			return strings.Compare(a, b)
+		default:
+			return 1
+		}
+	}
+	return 0
+}
+
+// sorter returns the sort.Interface of the rows by the sorts.
+func (sr *sortedRows) sorter(sorts []rowSort) sort.Interface {
+	return &sortedRowsSorter{sortedRows: sr, sorts: sorts}
+}
+
+type sortedRowsSorter struct {
+	*sortedRows
+	sorts []rowSort
+}
+
+func (s *sortedRowsSorter) Len() int {
+	return len(s.rows)
+}
+
+func (s *sortedRowsSorter) Less(i, j int) bool {
+	return compareSortKeys(s.keys[i], s.keys[j], s.sorts) < 0
+}
+
+func (s *sortedRowsSorter) Swap(i, j int) {
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// mergeSortedRows merges sorted rows of datanodes by the sorts with a k-way merge, up to limit rows or all
+// rows if limit is negative. Rows of the same keys are merged in the order of datanodes.
+func mergeSortedRows(nodes []*sortedRows, sorts []rowSort, limit int, write func(row json.RawMessage) error) error {
+	h := &rowsHeap{sorts: sorts}
+	for i, node := range nodes {
+		if len(node.rows) > 0 {
+			h.cursors = append(h.cursors, rowsCursor{node: i, rows: node})
+		}
+	}
+	heap.Init(h)
+	for n := 0; h.Len() > 0 && (limit < 0 || n < limit); n++ {
+		cursor := &h.cursors[0]
+		if err := write(cursor.rows.rows[cursor.pos]); err != nil {
+			return err
+		}
+		cursor.pos++
+		if cursor.pos == len(cursor.rows.rows) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return nil
+}
+
+// rowsCursor is the position of the next row of a datanode to merge.
+type rowsCursor struct {
+	// index of the datanode.
+	node int
+	rows *sortedRows
+	pos  int
+}
+
+// rowsHeap is the min heap of cursors of datanodes by their next rows.
+type rowsHeap struct {
+	cursors []rowsCursor
+	sorts   []rowSort
+}
+
+func (h *rowsHeap) Len() int {
+	return len(h.cursors)
+}
+
+func (h *rowsHeap) Less(i, j int) bool {
+	a, b := &h.cursors[i], &h.cursors[j]
+	if c := compareSortKeys(a.rows.keys[a.pos], b.rows.keys[b.pos], h.sorts); c != 0 {
+		return c < 0
+	}
+	return a.node < b.node
+}
+
+func (h *rowsHeap) Swap(i, j int) {
+	h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+func (h *rowsHeap) Push(x interface{}) {
+	h.cursors = append(h.cursors, x.(rowsCursor))
+}
+
+func (h *rowsHeap) Pop() interface{} {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
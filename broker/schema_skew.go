@@ -0,0 +1,200 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	dataCli "github.com/uber/aresdb/datanode/client"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// unknownColumnPattern matches columns in schema mismatch errors of datanodes.
+var unknownColumnPattern = regexp.MustCompile(`unknown column ([\w.]+)`)
+
+// waitForSchemaRefresh waits the delay before the scan failed by the error is retried if the datanode failed
+// for a schema mismatch, so that datanodes lagging behind schemas of broker get a chance to refresh schemas.
+// It returns early once the context is done.
+func waitForSchemaRefresh(ctx context.Context, fetchErr error, delay time.Duration) {
+	if delay <= 0 || utils.GetErrorCode(fetchErr) != utils.ErrCodeSchemaMismatch {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// schemaSkew is datanodes lagging behind the schema of the main table of broker, which fail queries of
+// columns added by rolling schema changes not picked up by them yet.
+type schemaSkew struct {
+	table         string
+	brokerVersion metaCom.TableSchemaVersion
+	// schema versions reported by lagging datanodes by host id.
+	hostVersions map[string]metaCom.TableSchemaVersion
+	// columns reported unknown by datanodes in order.
+	columns []string
+	// error of the query failed by lagging datanodes.
+	cause *utils.CodedError
+}
+
+// detectSchemaSkew returns the schema skew of datanodes failed the query with schema mismatches, nil if no
+// datanode reported a schema version behind the main table of broker, e.g. broker lags behind instead.
+func detectSchemaSkew(qc *QueryContext, err error, metadata *dataCli.QueryMetadata) *schemaSkew {
+	codedErr, ok := err.(*utils.CodedError)
+	if !ok || qc == nil || qc.MainTable == nil {
+		return nil
+	}
+	skew := &schemaSkew{
+		table:         qc.MainTable.Name,
+		brokerVersion: metaCom.TableSchemaVersion{Incarnation: qc.MainTable.Incarnation, Version: qc.MainTable.Version},
+		hostVersions:  make(map[string]metaCom.TableSchemaVersion),
+		cause:         codedErr,
+	}
+	for hostID, version := range metadata.SchemaVersions() {
+		if version.IsBehind(skew.brokerVersion, 0) {
+			skew.hostVersions[hostID] = version
+		}
+	}
+	if len(skew.hostVersions) == 0 {
+		return nil
+	}
+	for _, hostErr := range codedErr.HostErrors {
+		if hostErr.Code != utils.ErrCodeSchemaMismatch {
+			continue
+		}
+		for _, match := range unknownColumnPattern.FindAllStringSubmatch(hostErr.Message, -1) {
+			skew.addColumn(match[1])
+		}
+	}
+	return skew
+}
+
+func (s *schemaSkew) addColumn(column string) {
+	for _, existing := range s.columns {
+		if existing == column {
+			return
+		}
+	}
+	s.columns = append(s.columns, column)
+}
+
+// hosts describes lagging datanodes with their schema versions, sorted by host id.
+func (s *schemaSkew) hosts() string {
+	hosts := make([]string, 0, len(s.hostVersions))
+	for hostID, version := range s.hostVersions {
+		hosts = append(hosts, fmt.Sprintf("%s (%s)", hostID, version))
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ", ")
+}
+
+// error returns the error of the query failed by the schema skew, with the code and errors of hosts of the cause.
+func (s *schemaSkew) error() error {
+	err := utils.NewCodedError(s.cause.Code, s.cause,
+		"datanodes %s lag behind schema %s of table %s, retry later or set dropSkewedColumns to drop columns unknown to them",
+		s.hosts(), s.brokerVersion, s.table)
+	err.HostErrors = s.cause.HostErrors
+	return err
+}
+
+// warning returns the warning of results of the query without the columns unknown to lagging datanodes.
+func (s *schemaSkew) warning() string {
+	return fmt.Sprintf("dropped columns %s unknown to datanodes %s lagging behind schema %s of table %s",
+		strings.Join(s.columns, ", "), s.hosts(), s.brokerVersion, s.table)
+}
+
+// dropColumns returns a copy of the query without dimensions and measures referencing columns unknown to
+// lagging datanodes, false if the query can not be answered without them, e.g. they are filtered or joined on,
+// or no measure is left.
+func (s *schemaSkew) dropColumns(aql *queryCom.AQLQuery) (*queryCom.AQLQuery, bool) {
+	if len(s.columns) == 0 {
+		return nil, false
+	}
+	for _, join := range aql.Joins {
+		for _, cond := range join.Conditions {
+			if s.references(cond) {
+				return nil, false
+			}
+		}
+	}
+	for _, filter := range aql.Filters {
+		if s.references(filter) {
+			return nil, false
+		}
+	}
+	if aql.TimeFilter.Column != "" && s.isUnknown(aql.TimeFilter.Column) {
+		return nil, false
+	}
+
+	degraded := copyAQLQuery(aql)
+	degraded.Dimensions = degraded.Dimensions[:0]
+	for _, dim := range aql.Dimensions {
+		if !s.references(dim.Expr) {
+			degraded.Dimensions = append(degraded.Dimensions, dim)
+		}
+	}
+	degraded.Measures = degraded.Measures[:0]
+	for _, measure := range aql.Measures {
+		dropped := s.references(measure.Expr)
+		for _, filter := range measure.Filters {
+			dropped = dropped || s.references(filter)
+		}
+		if !dropped {
+			degraded.Measures = append(degraded.Measures, measure)
+		}
+	}
+	if len(degraded.Measures) == 0 || (len(degraded.Dimensions) == len(aql.Dimensions) &&
+		len(degraded.Measures) == len(aql.Measures)) {
+		return nil, false
+	}
+	return degraded, true
+}
+
+// references tells whether the expression references any column unknown to lagging datanodes.
+func (s *schemaSkew) references(sqlExpr string) (found bool) {
+	parsedExpr, err := expr.ParseExpr(sqlExpr)
+	if err != nil {
+		return false
+	}
+	expr.WalkFunc(parsedExpr, func(e expr.Expr) {
+		if varRef, ok := e.(*expr.VarRef); ok && s.isUnknown(varRef.Val) {
+			found = true
+		}
+	})
+	return
+}
+
+// isUnknown tells whether the column identifier, with or without the table alias, is unknown to lagging
+// datanodes. Datanodes report columns with or without table aliases, so columns are matched by name.
+func (s *schemaSkew) isUnknown(identifier string) bool {
+	name := identifier[strings.LastIndex(identifier, ".")+1:]
+	for _, column := range s.columns {
+		if column[strings.LastIndex(column, ".")+1:] == name {
+			return true
+		}
+	}
+	return false
+}
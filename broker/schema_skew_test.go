@@ -0,0 +1,115 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("schema skew", func() {
+	schemaMismatch := &utils.CodedError{
+		Code: utils.ErrCodeDataNodeFailure,
+		HostErrors: []utils.HostError{
+			{Host: "host1", Code: utils.ErrCodeSchemaMismatch, Message: "unknown column tip for table trips"},
+			{Host: "host2", Code: utils.ErrCodeUnavailable, Message: "unknown column fare"},
+		},
+	}
+	qc := &QueryContext{MainTable: &metaCom.Table{Name: "trips", Incarnation: 1, Version: 3}}
+
+	newMetadata := func(versions map[string]metaCom.TableSchemaVersion) *dataCli.QueryMetadata {
+		metadata := &dataCli.QueryMetadata{Hosts: make(map[string]*dataCli.HostQueryMetadata)}
+		for hostID, version := range versions {
+			version := version
+			metadata.Hosts[hostID] = &dataCli.HostQueryMetadata{SchemaVersion: &version}
+		}
+		return metadata
+	}
+
+	ginkgo.It("should detect datanodes lagging behind schemas of broker", func() {
+		skew := detectSchemaSkew(qc, schemaMismatch, newMetadata(map[string]metaCom.TableSchemaVersion{
+			"host0": {Incarnation: 1, Version: 3},
+			"host1": {Incarnation: 1, Version: 2},
+		}))
+		Ω(skew).ShouldNot(BeNil())
+		Ω(skew.hostVersions).Should(Equal(map[string]metaCom.TableSchemaVersion{"host1": {Incarnation: 1, Version: 2}}))
+		Ω(skew.columns).Should(Equal([]string{"tip"}))
+		Ω(skew.warning()).Should(Equal("dropped columns tip unknown to datanodes host1 (1.2) lagging behind schema 1.3 of table trips"))
+
+		err := skew.error().(*utils.CodedError)
+		Ω(err.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(err.Message).Should(HavePrefix("datanodes host1 (1.2) lag behind schema 1.3 of table trips"))
+		Ω(err.HostErrors).Should(Equal(schemaMismatch.HostErrors))
+
+		// broker lagging behind datanodes is not a skew.
+		Ω(detectSchemaSkew(qc, schemaMismatch, newMetadata(map[string]metaCom.TableSchemaVersion{
+			"host1": {Incarnation: 1, Version: 4},
+		}))).Should(BeNil())
+		Ω(detectSchemaSkew(qc, schemaMismatch, newMetadata(nil))).Should(BeNil())
+	})
+
+	ginkgo.It("should drop dimensions and measures of columns unknown to lagging datanodes", func() {
+		skew := &schemaSkew{columns: []string{"tip"}}
+		aql := &queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "city_id"}, {Expr: "trips.tip > 0"}},
+			Measures: []queryCom.Measure{
+				{Expr: "count(*)"},
+				{Expr: "sum(tip)"},
+				{Expr: "sum(fare)", Filters: []string{"tip > 1"}},
+			},
+		}
+		degraded, ok := skew.dropColumns(aql)
+		Ω(ok).Should(BeTrue())
+		Ω(degraded.Dimensions).Should(Equal([]queryCom.Dimension{{Expr: "city_id"}}))
+		Ω(degraded.Measures).Should(Equal([]queryCom.Measure{{Expr: "count(*)"}}))
+		Ω(aql.Dimensions).Should(HaveLen(2))
+		Ω(aql.Measures).Should(HaveLen(3))
+
+		// nothing to drop.
+		_, ok = skew.dropColumns(&queryCom.AQLQuery{Table: "trips", Measures: []queryCom.Measure{{Expr: "count(*)"}}})
+		Ω(ok).Should(BeFalse())
+		// no measure left.
+		_, ok = skew.dropColumns(&queryCom.AQLQuery{Table: "trips", Measures: []queryCom.Measure{{Expr: "sum(tip)"}}})
+		Ω(ok).Should(BeFalse())
+		// filtered on.
+		filtered := *aql
+		filtered.Filters = []string{"tip > 0"}
+		_, ok = skew.dropColumns(&filtered)
+		Ω(ok).Should(BeFalse())
+	})
+
+	ginkgo.It("should wait for datanodes to refresh schemas on schema mismatches only", func() {
+		start := time.Now()
+		waitForSchemaRefresh(context.Background(), utils.NewCodedError(utils.ErrCodeUnavailable, nil, "down"), time.Second)
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+
+		waitForSchemaRefresh(context.Background(), utils.NewCodedError(utils.ErrCodeSchemaMismatch, nil, "unknown column tip"), 10*time.Millisecond)
+		Ω(time.Since(start)).Should(BeNumerically(">=", 10*time.Millisecond))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start = time.Now()
+		waitForSchemaRefresh(ctx, utils.NewCodedError(utils.ErrCodeSchemaMismatch, nil, "unknown column tip"), time.Second)
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+})
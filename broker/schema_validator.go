@@ -0,0 +1,301 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	brokerCom "github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/broker/config"
+	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// max edit distance of column names suggested for unknown columns.
+const maxColumnSuggestionDistance = 2
+
+// SchemaValidator validates columns referenced by queries against schemas of broker before querying datanodes,
+// so that invalid queries fail once with the offending expression instead of on every datanode. Column
+// indexes of tables are cached, and invalidated on schema changes.
+type SchemaValidator struct {
+	sync.RWMutex
+
+	cfg config.SchemaValidationConfig
+	// column indexes by table name.
+	cache map[string]*columnIndex
+}
+
+// columnIndex is the index of columns of a version of a table by names and aliases.
+type columnIndex struct {
+	version metaCom.TableSchemaVersion
+	columns []metaCom.Column
+	// ids of columns not deleted by names and aliases, aliases may have expired since indexed.
+	byName  map[string]int
+	byAlias map[string]int
+}
+
+// NewSchemaValidator creates a new SchemaValidator
+func NewSchemaValidator(cfg config.SchemaValidationConfig) *SchemaValidator {
+	return &SchemaValidator{
+		cfg:   cfg,
+		cache: make(map[string]*columnIndex),
+	}
+}
+
+// OnSchemaChange invalidates the cached column index of the table.
+func (v *SchemaValidator) OnSchemaChange(table string) {
+	v.Lock()
+	defer v.Unlock()
+	if _, exist := v.cache[table]; exist {
+		delete(v.cache, table)
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"cause": cacheInvalidationCauseSchema,
+		}, utils.BrokerCacheInvalidations).Inc(1)
+	}
+}
+
+// Validate checks columns referenced by dimensions, measures, filters and join conditions of the compiled query
+// exist in tables of the query, and are of types supported by aggregations and comparisons referencing them.
+// Unknown columns fail with ErrCodeSchemaMismatch with suggestions of similar column names, so that queries are
+// retried once with refreshed schemas, other failures are invalid queries.
+func (v *SchemaValidator) Validate(qc *QueryContext) error {
+	if v == nil || !v.cfg.Enable {
+		return nil
+	}
+	indexes := make(map[string]*columnIndex, len(qc.tablesByAlias))
+	for alias, table := range qc.tablesByAlias {
+		indexes[alias] = v.getColumnIndex(table)
+	}
+
+	var err error
+	checkExpr := func(sqlExpr string) {
+		if err != nil {
+			return
+		}
+		// failures of parsing are reported by compilation of datanodes.
+		parsedExpr, parseErr := expr.ParseExpr(sqlExpr)
+		if parseErr != nil {
+			return
+		}
+		err = v.validateExpr(qc, indexes, sqlExpr, parsedExpr)
+	}
+
+	for _, join := range qc.AQLQuery.Joins {
+		for _, cond := range join.Conditions {
+			checkExpr(cond)
+		}
+	}
+	for _, dim := range qc.AQLQuery.Dimensions {
+		checkExpr(dim.Expr)
+	}
+	for _, measure := range qc.AQLQuery.Measures {
+		checkExpr(measure.Expr)
+		for _, filter := range measure.Filters {
+			checkExpr(filter)
+		}
+	}
+	for _, filter := range qc.AQLQuery.Filters {
+		checkExpr(filter)
+	}
+	if err == nil && qc.AQLQuery.TimeFilter.Column != "" {
+		_, err = resolveIndexedColumn(qc, indexes, qc.AQLQuery.TimeFilter.Column, "time filter")
+	}
+	return err
+}
+
+// validateExpr resolves all columns of the expression and checks types of columns aggregated or compared.
+func (v *SchemaValidator) validateExpr(qc *QueryContext, indexes map[string]*columnIndex, sqlExpr string, parsedExpr expr.Expr) (err error) {
+	expr.WalkFunc(parsedExpr, func(e expr.Expr) {
+		if err != nil {
+			return
+		}
+		switch e := e.(type) {
+		case *expr.VarRef:
+			_, err = resolveIndexedColumn(qc, indexes, e.Val, sqlExpr)
+		case *expr.Call:
+			if !isNumericAggregation(e.Name) || len(e.Args) == 0 {
+				return
+			}
+			varRef, ok := e.Args[0].(*expr.VarRef)
+			if !ok {
+				return
+			}
+			var column *metaCom.Column
+			if column, err = resolveIndexedColumn(qc, indexes, varRef.Val, sqlExpr); err != nil {
+				return
+			}
+			if dataType := memCom.DataTypeForColumn(*column); !isAggregatable(dataType) {
+				err = utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil,
+					"aggregate function %s does not support column %s of type %s in expression %s",
+					e.Name, varRef.Val, column.Type, sqlExpr))
+			}
+		case *expr.BinaryExpr:
+			if e.Op != expr.LT && e.Op != expr.LTE && e.Op != expr.GT && e.Op != expr.GTE {
+				return
+			}
+			_, isLHSStr := e.LHS.(*expr.StringLiteral)
+			_, isRHSStr := e.RHS.(*expr.StringLiteral)
+			if isLHSStr || isRHSStr {
+				err = utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil,
+					"string type only support EQ and NEQ operators in expression %s", sqlExpr))
+			}
+		}
+	})
+	return
+}
+
+// getColumnIndex returns the cached column index of the table, indexing the table if not cached or cached
+// with a different version.
+func (v *SchemaValidator) getColumnIndex(table *metaCom.Table) *columnIndex {
+	version := metaCom.TableSchemaVersion{Incarnation: table.Incarnation, Version: table.Version}
+	v.RLock()
+	index, exist := v.cache[table.Name]
+	v.RUnlock()
+	if exist && index.version == version {
+		return index
+	}
+
+	index = newColumnIndex(table)
+	v.Lock()
+	v.cache[table.Name] = index
+	v.Unlock()
+	return index
+}
+
+func newColumnIndex(table *metaCom.Table) *columnIndex {
+	index := &columnIndex{
+		version: metaCom.TableSchemaVersion{Incarnation: table.Incarnation, Version: table.Version},
+		columns: table.Columns,
+		byName:  make(map[string]int, len(table.Columns)),
+		byAlias: make(map[string]int),
+	}
+	for id, column := range table.Columns {
+		if column.Deleted {
+			continue
+		}
+		index.byName[column.Name] = id
+		for _, alias := range column.Aliases {
+			index.byAlias[alias.Name] = id
+		}
+	}
+	return index
+}
+
+// get returns the column by its name or unexpired aliases.
+func (index *columnIndex) get(name string) *metaCom.Column {
+	if id, exist := index.byName[name]; exist {
+		return &index.columns[id]
+	}
+	if id, exist := index.byAlias[name]; exist && columnHasName(index.columns[id], name) {
+		return &index.columns[id]
+	}
+	return nil
+}
+
+// suggest returns the column name closest to the unknown name within maxColumnSuggestionDistance, ignoring
+// cases, or empty if there is none.
+func (index *columnIndex) suggest(name string) string {
+	name = strings.ToLower(name)
+	suggestion, minDistance := "", maxColumnSuggestionDistance+1
+	for _, column := range index.columns {
+		if column.Deleted {
+			continue
+		}
+		// short names are within the max distance of any names.
+		if distance := editDistance(name, strings.ToLower(column.Name)); distance < minDistance && distance < len(name) {
+			suggestion, minDistance = column.Name, distance
+		}
+	}
+	return suggestion
+}
+
+// resolveIndexedColumn resolves the identifier of the column referenced by the expression of the query, in
+// the form of column or tableAlias.column.
+func resolveIndexedColumn(qc *QueryContext, indexes map[string]*columnIndex, identifier, sqlExpr string) (*metaCom.Column, error) {
+	tableAlias, columnName := qc.AQLQuery.Table, identifier
+	if segments := strings.SplitN(identifier, ".", 2); len(segments) == 2 {
+		tableAlias, columnName = segments[0], segments[1]
+	}
+	index := indexes[tableAlias]
+	if index == nil {
+		return nil, utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil,
+			"unknown table alias %s in expression %s", tableAlias, sqlExpr))
+	}
+	if column := index.get(columnName); column != nil {
+		return column, nil
+	}
+
+	message := fmt.Sprintf("unknown column %s of table %s in expression %s", columnName, qc.tablesByAlias[tableAlias].Name, sqlExpr)
+	if suggestion := index.suggest(columnName); suggestion != "" {
+		message = fmt.Sprintf("%s, did you mean %s?", message, suggestion)
+	}
+	return nil, utils.WithCode(utils.ErrCodeSchemaMismatch, utils.StackError(nil, "%s", message))
+}
+
+// isNumericAggregation returns whether the aggregate function computes over numeric values of its column.
+func isNumericAggregation(callName string) bool {
+	switch brokerCom.CallNameToAggType[callName] {
+	case brokerCom.Sum, brokerCom.Avg, brokerCom.Max, brokerCom.Min, brokerCom.Var, brokerCom.Stddev, brokerCom.Percentile:
+		return true
+	}
+	return false
+}
+
+// isAggregatable returns whether values of the data type can be aggregated numerically.
+func isAggregatable(dataType memCom.DataType) bool {
+	if memCom.IsArrayType(dataType) {
+		return false
+	}
+	switch dataType {
+	case memCom.UUID, memCom.GeoPoint, memCom.GeoShape, memCom.Unknown:
+		return false
+	}
+	return true
+}
+
+// editDistance returns the levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,172 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("schema validator", func() {
+	var schemaMutator *BrokerSchemaMutator
+	var validator *SchemaValidator
+
+	trips := metaCom.Table{
+		Name:        "trips",
+		Incarnation: 1,
+		Version:     1,
+		Columns: []metaCom.Column{
+			{Name: "request_at", Type: metaCom.Uint32},
+			{Name: "city_id", Type: metaCom.Uint16},
+			{Name: "fare", Type: metaCom.Float32},
+			{Name: "uuid", Type: metaCom.UUID},
+			{Name: "status", Type: metaCom.SmallEnum},
+			{Name: "surge", Type: metaCom.Float32, Deleted: true},
+		},
+	}
+	cities := metaCom.Table{
+		Name:        "cities",
+		Incarnation: 1,
+		Version:     1,
+		Columns: []metaCom.Column{
+			{Name: "id", Type: metaCom.Uint16},
+			{Name: "name", Type: metaCom.BigEnum},
+		},
+	}
+
+	validate := func(aql common.AQLQuery) error {
+		qc := NewQueryContext(&aql, nil)
+		qc.Compile(schemaMutator)
+		Ω(qc.Error).Should(BeNil())
+		return validator.Validate(qc)
+	}
+
+	ginkgo.BeforeEach(func() {
+		schemaMutator = NewBrokerSchemaMutator()
+		for _, table := range []metaCom.Table{trips, cities} {
+			tableCopy := table
+			Ω(schemaMutator.CreateTable(&tableCopy)).Should(BeNil())
+		}
+		validator = NewSchemaValidator(config.SchemaValidationConfig{Enable: true})
+		schemaMutator.RegisterChangeListener(validator.OnSchemaChange)
+	})
+
+	ginkgo.It("should pass queries of known columns", func() {
+		Ω(validate(common.AQLQuery{
+			Table:      "trips",
+			Joins:      []common.Join{{Table: "cities", Alias: "c", Conditions: []string{"city_id = c.id"}}},
+			Dimensions: []common.Dimension{{Expr: "c.name"}},
+			Measures:   []common.Measure{{Expr: "sum(fare)", Filters: []string{"status = 'completed'"}}},
+			Filters:    []string{"fare > 0"},
+			TimeFilter: common.TimeFilter{Column: "request_at", From: "-1d"},
+		})).Should(BeNil())
+	})
+
+	ginkgo.It("should reject unknown columns with suggestions", func() {
+		err := validate(common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "sum(fares)"}},
+		})
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeSchemaMismatch))
+		Ω(err.Error()).Should(ContainSubstring("unknown column fares of table trips in expression sum(fares), did you mean fare?"))
+
+		err = validate(common.AQLQuery{
+			Table:      "trips",
+			Joins:      []common.Join{{Table: "cities", Alias: "c", Conditions: []string{"city_id = c.id"}}},
+			Dimensions: []common.Dimension{{Expr: "c.Name"}},
+			Measures:   []common.Measure{{Expr: "count(*)"}},
+		})
+		Ω(err.Error()).Should(ContainSubstring("unknown column Name of table cities in expression c.Name, did you mean name?"))
+
+		// deleted columns are unknown, and far names are not suggested.
+		err = validate(common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "count(*)"}},
+			Filters:  []string{"surge > 1"},
+		})
+		Ω(err.Error()).Should(ContainSubstring("unknown column surge of table trips in expression surge > 1"))
+		Ω(err.Error()).ShouldNot(ContainSubstring("did you mean"))
+
+		err = validate(common.AQLQuery{
+			Table:      "trips",
+			Measures:   []common.Measure{{Expr: "count(*)"}},
+			TimeFilter: common.TimeFilter{Column: "requested_at", From: "-1d"},
+		})
+		Ω(err.Error()).Should(ContainSubstring("unknown column requested_at of table trips in expression time filter, did you mean request_at?"))
+	})
+
+	ginkgo.It("should reject unknown table aliases", func() {
+		err := validate(common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "count(*)"}},
+			Filters:  []string{"c.id = 1"},
+		})
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(err.Error()).Should(ContainSubstring("unknown table alias c in expression c.id = 1"))
+	})
+
+	ginkgo.It("should reject type mismatches", func() {
+		err := validate(common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "avg(uuid)"}},
+		})
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(err.Error()).Should(ContainSubstring("aggregate function avg does not support column uuid of type UUID in expression avg(uuid)"))
+
+		err = validate(common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "count(*)"}},
+			Filters:  []string{"status > 'completed'"},
+		})
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(err.Error()).Should(ContainSubstring("string type only support EQ and NEQ operators in expression status > 'completed'"))
+	})
+
+	ginkgo.It("should invalidate cached columns on schema changes", func() {
+		aql := common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "sum(tip)"}},
+		}
+		Ω(utils.GetErrorCode(validate(aql))).Should(Equal(utils.ErrCodeSchemaMismatch))
+		Ω(validator.cache).Should(HaveKey("trips"))
+
+		newTrips := trips
+		newTrips.Columns = append(append([]metaCom.Column{}, trips.Columns...), metaCom.Column{Name: "tip", Type: metaCom.Float32})
+		newTrips.Version = trips.Version + 1
+		Ω(schemaMutator.UpdateTable(newTrips)).Should(BeNil())
+		Ω(validator.cache).ShouldNot(HaveKey("trips"))
+		Ω(validate(aql)).Should(BeNil())
+	})
+
+	ginkgo.It("should not validate when disabled", func() {
+		validator = NewSchemaValidator(config.SchemaValidationConfig{})
+		Ω(validate(common.AQLQuery{
+			Table:    "trips",
+			Measures: []common.Measure{{Expr: "sum(fares)"}},
+		})).Should(BeNil())
+		Ω((*SchemaValidator)(nil).Validate(nil)).Should(BeNil())
+	})
+
+	ginkgo.It("editDistance should count edits between strings", func() {
+		Ω(editDistance("", "")).Should(Equal(0))
+		Ω(editDistance("fare", "fares")).Should(Equal(1))
+		Ω(editDistance("cityid", "city_id")).Should(Equal(1))
+		Ω(editDistance("kitten", "sitting")).Should(Equal(3))
+	})
+})
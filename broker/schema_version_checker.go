@@ -0,0 +1,260 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/broker/util"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	cacheInvalidationCauseSchema    = "schema"
+	cacheInvalidationCausePlacement = "placement"
+)
+
+// SchemaVersionCacheEntry is the cached schema versions of a datanode.
+type SchemaVersionCacheEntry struct {
+	Host      string    `json:"host"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	// schema and placement versions of broker when the entry was fetched.
+	SchemaVersion    int `json:"schemaVersion"`
+	PlacementVersion int `json:"placementVersion"`
+	// schema versions of the datanode by table name.
+	Versions map[string]metaCom.TableSchemaVersion `json:"versions"`
+}
+
+// SchemaVersionChecker finds datanodes whose schema of the queried table is behind broker.
+// Schema versions of datanodes are cached, and invalidated on schema and placement changes.
+type SchemaVersionChecker struct {
+	sync.RWMutex
+
+	cfg            config.SchemaVersionCheckConfig
+	topo           topology.Topology
+	dataNodeClient dataCli.DataNodeQueryClient
+	stopChan       chan struct{}
+
+	// bumped on every schema and placement change seen by broker.
+	schemaVersion    int
+	placementVersion int
+	// schema versions by host id.
+	cache map[string]SchemaVersionCacheEntry
+}
+
+// NewSchemaVersionChecker creates a new SchemaVersionChecker
+func NewSchemaVersionChecker(cfg config.SchemaVersionCheckConfig, topo topology.Topology, client dataCli.DataNodeQueryClient) *SchemaVersionChecker {
+	return &SchemaVersionChecker{
+		cfg:            cfg,
+		topo:           topo,
+		dataNodeClient: client,
+		stopChan:       make(chan struct{}),
+		cache:          make(map[string]SchemaVersionCacheEntry),
+	}
+}
+
+// Run watches placement changes to invalidate cached schema versions.
+func (c *SchemaVersionChecker) Run() {
+	if !c.cfg.Enable {
+		return
+	}
+	watch, err := c.topo.Watch()
+	if err != nil {
+		utils.GetLogger().With("error", err.Error()).Error("Failed to watch placement changes")
+		return
+	}
+	defer watch.Close()
+
+	for {
+		select {
+		case <-watch.C():
+			c.OnPlacementChange()
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops watching placement changes
+func (c *SchemaVersionChecker) Stop() {
+	close(c.stopChan)
+}
+
+// OnSchemaChange invalidates cached schema versions since datanodes are expected to pick up the change
+// of the table as well.
+func (c *SchemaVersionChecker) OnSchemaChange(table string) {
+	c.Lock()
+	defer c.Unlock()
+	c.schemaVersion++
+	c.invalidate(cacheInvalidationCauseSchema, func(entry SchemaVersionCacheEntry) bool {
+		return entry.SchemaVersion != c.schemaVersion
+	})
+}
+
+// OnPlacementChange invalidates cached schema versions fetched with a different placement.
+func (c *SchemaVersionChecker) OnPlacementChange() {
+	c.Lock()
+	defer c.Unlock()
+	c.placementVersion++
+	c.invalidate(cacheInvalidationCausePlacement, func(entry SchemaVersionCacheEntry) bool {
+		return entry.PlacementVersion != c.placementVersion
+	})
+}
+
+func (c *SchemaVersionChecker) invalidate(cause string, isStale func(entry SchemaVersionCacheEntry) bool) {
+	for hostID, entry := range c.cache {
+		if isStale(entry) {
+			delete(c.cache, hostID)
+			utils.GetRootReporter().GetChildCounter(map[string]string{
+				"cause": cause,
+			}, utils.BrokerCacheInvalidations).Inc(1)
+		}
+	}
+}
+
+// GetCacheEntries returns cached schema versions sorted by host id.
+func (c *SchemaVersionChecker) GetCacheEntries() []SchemaVersionCacheEntry {
+	c.RLock()
+	defer c.RUnlock()
+	entries := make([]SchemaVersionCacheEntry, 0, len(c.cache))
+	for _, entry := range c.cache {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Host < entries[j].Host
+	})
+	return entries
+}
+
+// Check sets hosts with stale schema of the main table to be excluded from the query, with warnings.
+// Hosts whose schema versions can not be fetched are not excluded.
+func (c *SchemaVersionChecker) Check(ctx context.Context, qc *QueryContext) {
+	if !c.cfg.Enable {
+		return
+	}
+	brokerVersion := metaCom.TableSchemaVersion{
+		Incarnation: qc.MainTable.Incarnation,
+		Version:     qc.MainTable.Version,
+	}
+	for _, host := range c.topo.Get().Hosts() {
+		versions, err := c.getSchemaVersions(ctx, host)
+		if err != nil {
+			utils.GetContextLogger(ctx).With("host", host.ID(), "error", err.Error()).Warn("Failed to get schema versions from datanode")
+			continue
+		}
+
+		var warning string
+		if version, exist := versions[qc.MainTable.Name]; !exist {
+			warning = fmt.Sprintf("host %s is excluded without schema of table %s", host.ID(), qc.MainTable.Name)
+		} else if version.IsBehind(brokerVersion, c.cfg.MaxVersionLag) {
+			warning = fmt.Sprintf("host %s is excluded with stale schema of table %s: %d.%d, expected %d.%d",
+				host.ID(), qc.MainTable.Name, version.Incarnation, version.Version, brokerVersion.Incarnation, brokerVersion.Version)
+		} else {
+			continue
+		}
+
+		utils.GetContextLogger(ctx).With("host", host.ID(), "table", qc.MainTable.Name).Warn("Excluded datanode with stale schema from query")
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"host":  host.ID(),
+			"table": qc.MainTable.Name,
+		}, utils.DataNodeSchemaStale).Inc(1)
+		if qc.ExcludedHosts == nil {
+			qc.ExcludedHosts = make(map[string]bool)
+		}
+		qc.ExcludedHosts[host.ID()] = true
+		qc.Warnings = append(qc.Warnings, warning)
+	}
+}
+
+func (c *SchemaVersionChecker) getSchemaVersions(ctx context.Context, host topology.Host) (map[string]metaCom.TableSchemaVersion, error) {
+	c.RLock()
+	entry, exist := c.cache[host.ID()]
+	schemaVersion, placementVersion := c.schemaVersion, c.placementVersion
+	c.RUnlock()
+	if exist && utils.Now().Sub(entry.FetchedAt) < time.Duration(c.cfg.CacheTTLSec)*time.Second {
+		return entry.Versions, nil
+	}
+
+	versions, err := c.dataNodeClient.GetSchemaVersions(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	// skip caching versions fetched before schema or placement changes.
+	if schemaVersion == c.schemaVersion && placementVersion == c.placementVersion {
+		c.cache[host.ID()] = SchemaVersionCacheEntry{
+			Host:             host.ID(),
+			FetchedAt:        utils.Now(),
+			SchemaVersion:    schemaVersion,
+			PlacementVersion: placementVersion,
+			Versions:         versions,
+		}
+	}
+	c.Unlock()
+	return versions, nil
+}
+
+// calculateShardAssignment maps shards to hosts not excluded from the query by the assignment seed, shards without
+// any hosts left fail the query unless partial results are allowed, where they are skipped with a partial results
+// warning. Shards are assigned to the healthiest replicas by the
+// health tracker, shards assigned to datanodes down without healthier replicas are logged and counted, they are
+// not query warnings since the query can still complete with them. Failed datanodes of
+// queries returning partial results are tracked against the assignment.
+func calculateShardAssignment(qc *QueryContext, topo topology.Topology) (assignment map[topology.Host][]uint32, err error) {
+	opts := util.AssignmentOptions{
+		ExcludedHosts: qc.ExcludedHosts,
+		Seed:          qc.assignmentSeed,
+	}
+	if qc.healthTracker != nil {
+		opts.Health = qc.healthTracker
+	}
+	var unassigned []uint32
+	assignment, unassigned, err = util.CalculateShardAssignmentWithOptions(topo, opts)
+	if err != nil {
+		err = utils.WithCode(utils.ErrCodeClusterDegraded, err)
+		return
+	}
+	if len(unassigned) > 0 {
+		if !qc.allowPartialResults {
+			err = utils.WithCode(utils.ErrCodeClusterDegraded, utils.StackError(nil,
+				"shards %v have no datanodes available", unassigned))
+			return
+		}
+		qc.Warnings = append(qc.Warnings, fmt.Sprintf("partial results without shards %v", unassigned))
+	}
+	if qc.healthTracker != nil {
+		for host, shardIDs := range assignment {
+			if len(shardIDs) > 0 && qc.healthTracker.HostStatus(host.ID()) == util.HostDown {
+				utils.GetLogger().With("host", host.ID(), "shards", shardIDs).
+					Warn("shards assigned to datanode down without healthy replicas")
+				utils.GetRootReporter().GetChildCounter(map[string]string{
+					"host": host.ID(),
+				}, utils.ShardsAssignedToDownDataNode).Inc(int64(len(shardIDs)))
+			}
+		}
+	}
+	if qc.allowPartialResults {
+		qc.partial = newPartialResults(assignment, unassigned)
+	}
+	return
+}
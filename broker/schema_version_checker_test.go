@@ -0,0 +1,174 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/broker/config"
+	shardMock "github.com/uber/aresdb/cluster/shard/mocks"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
+	common2 "github.com/uber/aresdb/common"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("schema version checker", func() {
+	utils.Init(common2.AresServerConfig{}, common2.NewLoggerFactory().GetDefaultLogger(), common2.NewLoggerFactory().GetDefaultLogger(), tally.NewTestScope("test", nil))
+
+	var mockTopo *topoMock.Topology
+	var mockHost1, mockHost2, mockHost3 topology.Host
+	var mockDatanodeCli *dataCliMock.DataNodeQueryClient
+	var qc *QueryContext
+
+	ginkgo.BeforeEach(func() {
+		mockTopo = &topoMock.Topology{}
+		mockMap := &topoMock.Map{}
+		mockShardSet := &shardMock.ShardSet{}
+		mockTopo.On("Get").Return(mockMap)
+		mockMap.On("ShardSet").Return(mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1, 2})
+		mockHost1 = topology.NewHost("host1", "host1:9374")
+		mockHost2 = topology.NewHost("host2", "host2:9374")
+		mockHost3 = topology.NewHost("host3", "host3:9374")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2, mockHost3})
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1, mockHost2}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost1, mockHost3}, nil)
+		mockMap.On("RouteShard", uint32(2)).Return([]topology.Host{mockHost2, mockHost3}, nil)
+
+		mockDatanodeCli = &dataCliMock.DataNodeQueryClient{}
+		qc = &QueryContext{
+			AQLQuery:  &common.AQLQuery{Table: "table1"},
+			MainTable: &metaCom.Table{Name: "table1", Incarnation: 1, Version: 5},
+		}
+	})
+
+	ginkgo.It("should exclude hosts with stale schema", func() {
+		mockDatanodeCli.On("GetSchemaVersions", mock.Anything, mockHost1).
+			Return(map[string]metaCom.TableSchemaVersion{"table1": {Incarnation: 1, Version: 4}}, nil).Once()
+		mockDatanodeCli.On("GetSchemaVersions", mock.Anything, mockHost2).
+			Return(map[string]metaCom.TableSchemaVersion{"table1": {Incarnation: 1, Version: 2}}, nil).Once()
+		mockDatanodeCli.On("GetSchemaVersions", mock.Anything, mockHost3).
+			Return(nil, errors.New("some error")).Once()
+
+		checker := NewSchemaVersionChecker(config.SchemaVersionCheckConfig{Enable: true, MaxVersionLag: 1, CacheTTLSec: 60}, mockTopo, mockDatanodeCli)
+		checker.Check(context.TODO(), qc)
+		Ω(qc.ExcludedHosts).Should(Equal(map[string]bool{"host2": true}))
+		Ω(qc.Warnings).Should(Equal([]string{"host host2 is excluded with stale schema of table table1: 1.2, expected 1.5"}))
+
+		// versions of host1 and host2 are cached, host3 is fetched again.
+		mockDatanodeCli.On("GetSchemaVersions", mock.Anything, mockHost3).
+			Return(map[string]metaCom.TableSchemaVersion{"table2": {Incarnation: 1, Version: 5}}, nil).Once()
+		qc.ExcludedHosts, qc.Warnings = nil, nil
+		checker.Check(context.TODO(), qc)
+		Ω(qc.ExcludedHosts).Should(Equal(map[string]bool{"host2": true, "host3": true}))
+		Ω(qc.Warnings).Should(HaveLen(2))
+		Ω(qc.Warnings[1]).Should(Equal("host host3 is excluded without schema of table table1"))
+		mockDatanodeCli.AssertExpectations(utils.TestingT)
+	})
+
+	ginkgo.It("should not check when disabled", func() {
+		checker := NewSchemaVersionChecker(config.SchemaVersionCheckConfig{}, mockTopo, mockDatanodeCli)
+		checker.Check(context.TODO(), qc)
+		Ω(qc.ExcludedHosts).Should(BeNil())
+		mockDatanodeCli.AssertNotCalled(utils.TestingT, "GetSchemaVersions", mock.Anything, mock.Anything)
+	})
+
+	ginkgo.It("calculateShardAssignment should fail with unassigned shards unless partial results are allowed", func() {
+		qc.ExcludedHosts = map[string]bool{"host2": true, "host3": true}
+		_, err := calculateShardAssignment(qc, mockTopo)
+		Ω(utils.GetErrorCode(err)).Should(Equal(utils.ErrCodeClusterDegraded))
+		Ω(qc.Warnings).Should(BeEmpty())
+	})
+
+	ginkgo.It("calculateShardAssignment should warn about unassigned shards", func() {
+		qc.ExcludedHosts = map[string]bool{"host2": true, "host3": true}
+		qc.allowPartialResults = true
+		assignment, err := calculateShardAssignment(qc, mockTopo)
+		Ω(err).Should(BeNil())
+		Ω(assignment).Should(Equal(map[topology.Host][]uint32{mockHost1: {0, 1}}))
+		Ω(qc.Warnings).Should(Equal([]string{"partial results without shards [2]"}))
+	})
+
+	ginkgo.It("calculateShardAssignment should prefer healthy replicas", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		defer utils.ResetClockImplementation()
+		tracker := NewHealthTracker(config.HostHealthConfig{Enable: true})
+		for i := 0; i < 3; i++ {
+			tracker.Record("host1", utils.NewCodedError(utils.ErrCodeUnavailable, nil, "connection refused"))
+		}
+		qc.ExcludedHosts = map[string]bool{"host3": true}
+		qc.healthTracker = tracker
+		assignment, err := calculateShardAssignment(qc, mockTopo)
+		Ω(err).Should(BeNil())
+		Ω(assignment).Should(Equal(map[topology.Host][]uint32{mockHost1: {1}, mockHost2: {0, 2}}))
+		// results are complete even with shards assigned to datanodes down.
+		Ω(qc.Warnings).Should(BeEmpty())
+	})
+
+	ginkgo.It("should invalidate cached versions on schema and placement changes", func() {
+		mockDatanodeCli.On("GetSchemaVersions", mock.Anything, mock.Anything).
+			Return(map[string]metaCom.TableSchemaVersion{"table1": {Incarnation: 1, Version: 5}}, nil)
+		checker := NewSchemaVersionChecker(config.SchemaVersionCheckConfig{Enable: true, CacheTTLSec: 60}, mockTopo, mockDatanodeCli)
+
+		checker.Check(context.TODO(), qc)
+		entries := checker.GetCacheEntries()
+		Ω(entries).Should(HaveLen(3))
+		Ω(entries[0].Host).Should(Equal("host1"))
+		Ω(entries[0].SchemaVersion).Should(Equal(0))
+		Ω(entries[0].PlacementVersion).Should(Equal(0))
+
+		checker.OnSchemaChange("table1")
+		Ω(checker.GetCacheEntries()).Should(BeEmpty())
+		checker.Check(context.TODO(), qc)
+		entries = checker.GetCacheEntries()
+		Ω(entries).Should(HaveLen(3))
+		Ω(entries[2].SchemaVersion).Should(Equal(1))
+
+		checker.OnPlacementChange()
+		Ω(checker.GetCacheEntries()).Should(BeEmpty())
+		checker.Check(context.TODO(), qc)
+		Ω(checker.GetCacheEntries()[1].PlacementVersion).Should(Equal(1))
+		mockDatanodeCli.AssertNumberOfCalls(utils.TestingT, "GetSchemaVersions", 9)
+	})
+
+	ginkgo.It("Run should invalidate cached versions on placement changes", func() {
+		mockDatanodeCli.On("GetSchemaVersions", mock.Anything, mock.Anything).
+			Return(map[string]metaCom.TableSchemaVersion{"table1": {Incarnation: 1, Version: 5}}, nil)
+		watchChan := make(chan struct{})
+		mockWatch := &topoMock.MapWatch{}
+		mockWatch.On("C").Return((<-chan struct{})(watchChan))
+		mockWatch.On("Close").Return()
+		mockTopo.On("Watch").Return(mockWatch, nil)
+		checker := NewSchemaVersionChecker(config.SchemaVersionCheckConfig{Enable: true, CacheTTLSec: 60}, mockTopo, mockDatanodeCli)
+		checker.Check(context.TODO(), qc)
+		Ω(checker.GetCacheEntries()).Should(HaveLen(3))
+
+		go checker.Run()
+		watchChan <- struct{}{}
+		Eventually(checker.GetCacheEntries).Should(BeEmpty())
+		checker.Stop()
+	})
+})
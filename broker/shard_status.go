@@ -0,0 +1,173 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+// timeout of fetching the status of a datanode for shard status.
+const hostStatusFetchTimeout = 2 * time.Second
+
+// ShardReplica is a host owning a shard with the state of the shard on the host.
+type ShardReplica struct {
+	Host  string `json:"host"`
+	State string `json:"state"`
+}
+
+// ShardStatus is the owning hosts of a shard.
+type ShardStatus struct {
+	ID       uint32         `json:"id"`
+	Replicas []ShardReplica `json:"replicas"`
+	// Primary is the host preferred to serve the shard, empty if no host owns the shard.
+	Primary string `json:"primary"`
+}
+
+// HostStatus is a datanode in the topology, with the status of the table on the datanode if requested.
+type HostStatus struct {
+	ID      string   `json:"id"`
+	Address string   `json:"address"`
+	Shards  []uint32 `json:"shards"`
+	// Reachable is set only if the table status is requested.
+	Reachable *bool  `json:"reachable,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// schema version of the table on the datanode, nil if the table is missing or the datanode is unreachable.
+	SchemaVersion *metaCom.TableSchemaVersion `json:"schemaVersion,omitempty"`
+	// SchemaBehind is whether the schema of the table on the datanode is behind broker.
+	SchemaBehind bool `json:"schemaBehind,omitempty"`
+}
+
+// ShardOwnership is the shard ownership of the topology map, with the status of the table on datanodes if requested.
+type ShardOwnership struct {
+	Replicas int           `json:"replicas"`
+	Table    string        `json:"table,omitempty"`
+	Shards   []ShardStatus `json:"shards"`
+	Hosts    []HostStatus  `json:"hosts"`
+	// BrokerSchemaVersion is the schema version of the table on broker.
+	BrokerSchemaVersion *metaCom.TableSchemaVersion `json:"brokerSchemaVersion,omitempty"`
+}
+
+// getShardOwnership renders the shard ownership of the topology map sorted by shard and host ids.
+func getShardOwnership(topoMap topology.Map) ShardOwnership {
+	ownership := ShardOwnership{
+		Replicas: topoMap.Replicas(),
+	}
+
+	replicasByShard := make(map[uint32][]ShardReplica)
+	for _, hostShardSet := range topoMap.HostShardSets() {
+		host := hostShardSet.Host()
+		hostStatus := HostStatus{
+			ID:      host.ID(),
+			Address: host.Address(),
+			Shards:  []uint32{},
+		}
+		for _, s := range hostShardSet.ShardSet().All() {
+			hostStatus.Shards = append(hostStatus.Shards, s.ID())
+			replicasByShard[s.ID()] = append(replicasByShard[s.ID()], ShardReplica{
+				Host:  host.ID(),
+				State: s.State().String(),
+			})
+		}
+		sort.Slice(hostStatus.Shards, func(i, j int) bool {
+			return hostStatus.Shards[i] < hostStatus.Shards[j]
+		})
+		ownership.Hosts = append(ownership.Hosts, hostStatus)
+	}
+	sort.Slice(ownership.Hosts, func(i, j int) bool {
+		return ownership.Hosts[i].ID < ownership.Hosts[j].ID
+	})
+
+	shardIDs := topoMap.ShardSet().AllIDs()
+	sort.Slice(shardIDs, func(i, j int) bool {
+		return shardIDs[i] < shardIDs[j]
+	})
+	for _, shardID := range shardIDs {
+		replicas := replicasByShard[shardID]
+		if replicas == nil {
+			replicas = []ShardReplica{}
+		}
+		sort.Slice(replicas, func(i, j int) bool {
+			return replicas[i].Host < replicas[j].Host
+		})
+		ownership.Shards = append(ownership.Shards, ShardStatus{
+			ID:       shardID,
+			Replicas: replicas,
+			Primary:  primaryReplica(replicas),
+		})
+	}
+	return ownership
+}
+
+// primaryReplica picks the first host of replicas sorted by host id, preferring available over leaving
+// over initializing shards, so that every broker picks the same host.
+func primaryReplica(replicas []ShardReplica) string {
+	for _, state := range []m3Shard.State{m3Shard.Available, m3Shard.Leaving, m3Shard.Initializing} {
+		for _, replica := range replicas {
+			if replica.State == state.String() {
+				return replica.Host
+			}
+		}
+	}
+	return ""
+}
+
+// fetchTableStatus fetches schema versions of the table from all hosts in parallel, hosts failed to
+// respond in time are marked as unreachable.
+func fetchTableStatus(ctx context.Context, ownership *ShardOwnership, topoMap topology.Map,
+	client dataCli.DataNodeQueryClient, table string, brokerVersion *metaCom.TableSchemaVersion) {
+	ownership.Table = table
+	ownership.BrokerSchemaVersion = brokerVersion
+
+	hosts := make(map[string]topology.Host)
+	for _, host := range topoMap.Hosts() {
+		hosts[host.ID()] = host
+	}
+
+	ctx, cancel := withCallTimeout(ctx, hostStatusFetchTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range ownership.Hosts {
+		hostStatus := &ownership.Hosts[i]
+		host, exist := hosts[hostStatus.ID]
+		if !exist {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			versions, err := client.GetSchemaVersions(ctx, host)
+			reachable := err == nil
+			hostStatus.Reachable = &reachable
+			if err != nil {
+				hostStatus.Error = err.Error()
+				return
+			}
+			if version, exist := versions[table]; exist {
+				hostStatus.SchemaVersion = &version
+				hostStatus.SchemaBehind = brokerVersion != nil && version.IsBehind(*brokerVersion, 0)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,122 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	aresShard "github.com/uber/aresdb/cluster/shard"
+	"github.com/uber/aresdb/cluster/topology"
+	topoMocks "github.com/uber/aresdb/cluster/topology/mocks"
+	dataCliMock "github.com/uber/aresdb/datanode/client/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+)
+
+var _ = ginkgo.Describe("shard status", func() {
+	host0 := topology.NewHost("instance0", "http://host0:9374")
+	host1 := topology.NewHost("instance1", "http://host1:9374")
+	topoMap := topology.NewStaticMap(topology.NewStaticOptions().
+		SetReplicas(2).
+		SetHostShardSets([]topology.HostShardSet{
+			topology.NewHostShardSet(host1, aresShard.NewShardSet([]m3Shard.Shard{
+				m3Shard.NewShard(0).SetState(m3Shard.Available),
+				m3Shard.NewShard(1).SetState(m3Shard.Available),
+			})),
+			topology.NewHostShardSet(host0, aresShard.NewShardSet([]m3Shard.Shard{
+				m3Shard.NewShard(0).SetState(m3Shard.Available),
+				m3Shard.NewShard(1).SetState(m3Shard.Initializing),
+			})),
+		}).
+		SetShardSet(aresShard.NewShardSet([]m3Shard.Shard{
+			m3Shard.NewShard(0),
+			m3Shard.NewShard(1),
+			m3Shard.NewShard(2),
+		})))
+
+	ginkgo.It("getShardOwnership should work", func() {
+		ownership := getShardOwnership(topoMap)
+		Ω(ownership.Replicas).Should(Equal(2))
+		Ω(ownership.Hosts).Should(HaveLen(2))
+		Ω(ownership.Hosts[0].ID).Should(Equal("instance0"))
+		Ω(ownership.Hosts[0].Shards).Should(Equal([]uint32{0, 1}))
+		Ω(ownership.Hosts[0].Reachable).Should(BeNil())
+
+		Ω(ownership.Shards).Should(HaveLen(3))
+		Ω(ownership.Shards[0].Primary).Should(Equal("instance0"))
+		Ω(ownership.Shards[1].Primary).Should(Equal("instance1"))
+		Ω(ownership.Shards[1].Replicas).Should(Equal([]ShardReplica{
+			{Host: "instance0", State: m3Shard.Initializing.String()},
+			{Host: "instance1", State: m3Shard.Available.String()},
+		}))
+		Ω(ownership.Shards[2].Primary).Should(BeEmpty())
+		Ω(ownership.Shards[2].Replicas).Should(BeEmpty())
+	})
+
+	ginkgo.It("fetchTableStatus should work", func() {
+		client := &dataCliMock.DataNodeQueryClient{}
+		client.On("GetSchemaVersions", mock.Anything, host0).
+			Return(map[string]metaCom.TableSchemaVersion{"table1": {Incarnation: 1, Version: 4}}, nil)
+		client.On("GetSchemaVersions", mock.Anything, host1).
+			Return(nil, errors.New("connection refused"))
+
+		ownership := getShardOwnership(topoMap)
+		fetchTableStatus(context.TODO(), &ownership, topoMap, client, "table1",
+			&metaCom.TableSchemaVersion{Incarnation: 1, Version: 5})
+		Ω(ownership.Table).Should(Equal("table1"))
+		Ω(*ownership.Hosts[0].Reachable).Should(BeTrue())
+		Ω(*ownership.Hosts[0].SchemaVersion).Should(Equal(metaCom.TableSchemaVersion{Incarnation: 1, Version: 4}))
+		Ω(ownership.Hosts[0].SchemaBehind).Should(BeTrue())
+		Ω(*ownership.Hosts[1].Reachable).Should(BeFalse())
+		Ω(ownership.Hosts[1].Error).Should(Equal("connection refused"))
+		Ω(ownership.Hosts[1].SchemaVersion).Should(BeNil())
+	})
+
+	ginkgo.It("GetShards should work", func() {
+		topo := &topoMocks.Topology{}
+		topo.On("Get").Return(nil).Once()
+		topo.On("Get").Return(topoMap)
+		tsr := &metaMocks.TableSchemaReader{}
+		tsr.On("GetTable", "table1").Return(&metaCom.Table{Name: "table1", Incarnation: 1, Version: 4}, nil)
+		client := &dataCliMock.DataNodeQueryClient{}
+		client.On("GetSchemaVersions", mock.Anything, mock.Anything).
+			Return(map[string]metaCom.TableSchemaVersion{"table1": {Incarnation: 1, Version: 4}}, nil)
+		handler := NewDebugHandler(nil, tsr, topo, client, nil, nil, nil)
+
+		w := httptest.NewRecorder()
+		handler.GetShards(w, httptest.NewRequest(http.MethodGet, "/debug/shards", nil))
+		Ω(w.Code).Should(Equal(http.StatusServiceUnavailable))
+
+		w = httptest.NewRecorder()
+		handler.GetShards(w, httptest.NewRequest(http.MethodGet, "/debug/shards?table=table1", nil))
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		var ownership ShardOwnership
+		Ω(json.Unmarshal(w.Body.Bytes(), &ownership)).Should(BeNil())
+		Ω(*ownership.BrokerSchemaVersion).Should(Equal(metaCom.TableSchemaVersion{Incarnation: 1, Version: 4}))
+		Ω(ownership.Hosts).Should(HaveLen(2))
+		for _, host := range ownership.Hosts {
+			Ω(*host.Reachable).Should(BeTrue())
+			Ω(host.SchemaBehind).Should(BeFalse())
+		}
+	})
+})
@@ -0,0 +1,536 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testharness runs broker queries end to end against a cluster of fake datanodes, which serve
+// in-memory rows sharded by the primary key hash used by ingestion, with injectable faults.
+package testharness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker"
+	"github.com/uber/aresdb/broker/config"
+	aresShard "github.com/uber/aresdb/cluster/shard"
+	"github.com/uber/aresdb/cluster/topology"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// ClusterConfig is the config of a fake cluster.
+type ClusterConfig struct {
+	// number of datanodes, 1 if not positive.
+	NumDataNodes int
+	// number of shards of all tables, 1 if not positive.
+	NumShards int
+	// number of datanodes owning each shard, 1 if not positive. Replicas of shard i are datanodes
+	// (i + r) % NumDataNodes for r in [0, Replicas).
+	Replicas int
+	Tables   []Table
+
+	SchemaVersionCheck config.SchemaVersionCheckConfig
+	SchemaValidation   config.SchemaValidationConfig
+	Pagination         config.PaginationConfig
+	QueryStats         config.QueryStatsConfig
+	CountDistinct      config.CountDistinctConfig
+	Dedup              config.DedupConfig
+	Sort               config.SortConfig
+	PartialResults     config.PartialResultsConfig
+	QueryTimeout       config.QueryTimeoutConfig
+	Hedge              config.HedgeConfig
+	ResultCache        config.ResultCacheConfig
+	Compression        config.CompressionConfig
+	ShardAssignment    config.ShardAssignmentConfig
+	Consistency        config.ConsistencyConfig
+	Admission          config.AdmissionConfig
+	SchemaSkew         config.SchemaSkewConfig
+	CircuitBreaker     config.CircuitBreakerConfig
+	HostHealth         config.HostHealthConfig
+	RetryBudget        config.RetryBudgetConfig
+}
+
+// Cluster is a broker serving the query api over fake datanodes with a static topology.
+type Cluster struct {
+	DataNodes     []*FakeDataNode
+	Topology      topology.Topology
+	SchemaMutator *broker.BrokerSchemaMutator
+	QueryStats    *broker.QueryStatsTracker
+
+	dataset *dataset
+	broker  *httptest.Server
+	client  http.Client
+}
+
+// NewCluster starts the datanodes and the broker of the cluster.
+func NewCluster(cfg ClusterConfig) (*Cluster, error) {
+	numDataNodes, numShards, replicas := cfg.NumDataNodes, cfg.NumShards, cfg.Replicas
+	if numDataNodes <= 0 {
+		numDataNodes = 1
+	}
+	if numShards <= 0 {
+		numShards = 1
+	}
+	if replicas <= 0 {
+		replicas = 1
+	}
+	if replicas > numDataNodes {
+		return nil, utils.StackError(nil, "%d replicas exceeds %d datanodes", replicas, numDataNodes)
+	}
+
+	ds, err := newDataset(cfg.Tables, numShards)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{dataset: ds}
+
+	shardsByNode := make([][]uint32, numDataNodes)
+	for shardID := 0; shardID < numShards; shardID++ {
+		for r := 0; r < replicas; r++ {
+			node := (shardID + r) % numDataNodes
+			shardsByNode[node] = append(shardsByNode[node], uint32(shardID))
+		}
+	}
+	hostShardSets := make([]topology.HostShardSet, numDataNodes)
+	for i, shards := range shardsByNode {
+		node := newFakeDataNode(fmt.Sprintf("datanode%d", i), ds, shards)
+		c.DataNodes = append(c.DataNodes, node)
+		hostShardSets[i] = topology.NewHostShardSet(node.Host(),
+			aresShard.NewShardSet(aresShard.NewShards(shards, m3Shard.Available)))
+	}
+	c.Topology = topology.NewStaticTopology(topology.NewStaticOptions().
+		SetReplicas(replicas).
+		SetHostShardSets(hostShardSets).
+		SetShardSet(aresShard.NewShardSet(aresShard.NewShards(ds.allShards(), m3Shard.Available))))
+
+	c.SchemaMutator = broker.NewBrokerSchemaMutator()
+	for _, table := range cfg.Tables {
+		schema := table.Schema
+		if err = c.SchemaMutator.CreateTable(&schema); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	healthTracker := broker.NewHealthTracker(cfg.HostHealth)
+	dataNodeClient := broker.NewHealthTrackedClient(dataCli.NewDataNodeQueryClient(), healthTracker)
+	schemaVersionChecker := broker.NewSchemaVersionChecker(cfg.SchemaVersionCheck, c.Topology, dataNodeClient)
+	c.SchemaMutator.RegisterChangeListener(schemaVersionChecker.OnSchemaChange)
+	schemaValidator := broker.NewSchemaValidator(cfg.SchemaValidation)
+	c.SchemaMutator.RegisterChangeListener(schemaValidator.OnSchemaChange)
+	c.QueryStats = broker.NewQueryStatsTracker(cfg.QueryStats)
+	registry := queryCom.NewQueryRegistry(queryCom.DefaultQueryHistorySize)
+	circuitBreaker := broker.NewCircuitBreaker(cfg.CircuitBreaker, c.Topology)
+	brokerCfg := config.BrokerConfig{
+		Pagination:      cfg.Pagination,
+		CountDistinct:   cfg.CountDistinct,
+		Dedup:           cfg.Dedup,
+		Sort:            cfg.Sort,
+		PartialResults:  cfg.PartialResults,
+		QueryTimeout:    cfg.QueryTimeout,
+		Hedge:           cfg.Hedge,
+		ResultCache:     cfg.ResultCache,
+		ShardAssignment: cfg.ShardAssignment,
+		Consistency:     cfg.Consistency,
+		Admission:       cfg.Admission,
+		SchemaSkew:      cfg.SchemaSkew,
+	}
+	exec := broker.NewQueryExecutor(c.SchemaMutator, c.Topology, dataNodeClient, broker.QueryExecutorOptions{
+		Config:               brokerCfg,
+		SchemaVersionChecker: schemaVersionChecker,
+		SchemaValidator:      schemaValidator,
+		Breaker:              circuitBreaker,
+		HealthTracker:        healthTracker,
+		RetryBudget:          broker.NewRetryBudget(cfg.RetryBudget),
+		Registry:             registry,
+		QueryStats:           c.QueryStats,
+	})
+
+	router := mux.NewRouter()
+	queryHandler := broker.NewQueryHandler(exec, cfg.Compression)
+	queryHandler.Register(router.PathPrefix("/query").Subrouter())
+	queryHandler.RegisterV2(router.PathPrefix("/v2/query").Subrouter())
+	queriesHandler := broker.NewQueriesHandler(registry)
+	queriesHandler.Register(router)
+	c.broker = httptest.NewServer(router)
+	return c, nil
+}
+
+// BrokerURL returns the url of the broker.
+func (c *Cluster) BrokerURL() string {
+	return c.broker.URL
+}
+
+// Close shuts down the broker and datanodes.
+func (c *Cluster) Close() {
+	if c.broker != nil {
+		c.broker.Close()
+	}
+	for _, node := range c.DataNodes {
+		node.Close()
+	}
+}
+
+// Response is the v2 response of a query to the broker.
+type Response struct {
+	StatusCode int
+	// result of the query if succeeded.
+	Result   queryCom.AQLQueryResult
+	Error    *apiCom.QueryErrorV2
+	Metadata apiCom.QueryMetadataV2
+	// whether the response was compressed by broker, responses are decompressed by the client transparently.
+	Compressed bool
+	// verbose metadata of the response, only for verbose queries.
+	Meta *apiCom.QueryMetaV2
+	// Retry-After header of queries rejected by broker.
+	RetryAfter string
+	// id assigned to the query by broker.
+	QueryID string
+}
+
+// Rows returns rows of the non aggregation query result, values are strings or nils.
+func (r *Response) Rows() [][]interface{} {
+	matrixData, _ := r.Result[queryCom.MatrixDataKey].([]interface{})
+	rows := make([][]interface{}, len(matrixData))
+	for i, row := range matrixData {
+		rows[i], _ = row.([]interface{})
+	}
+	return rows
+}
+
+// Cursor returns the cursor of the next page, empty for the last page.
+func (r *Response) Cursor() string {
+	cursor, _ := r.Result[queryCom.CursorKey].(string)
+	return cursor
+}
+
+// Query runs the query through the v2 aql api of the broker, errors of the query are returned in the
+// response, the error is only returned if the request failed.
+func (c *Cluster) Query(query queryCom.AQLQuery) (*Response, error) {
+	return c.QueryPage(query, 0, "")
+}
+
+// QueryVerbose runs the query like Query with stats of datanodes requested in the verbose metadata.
+func (c *Cluster) QueryVerbose(query queryCom.AQLQuery) (*Response, error) {
+	return c.query(query, 0, "", true)
+}
+
+// QueryPage runs the page of the paginated non aggregation query, the first page is requested
+// without cursor.
+func (c *Cluster) QueryPage(query queryCom.AQLQuery, pageSize int, cursor string) (*Response, error) {
+	return c.query(query, pageSize, cursor, false)
+}
+
+func (c *Cluster) query(query queryCom.AQLQuery, pageSize int, cursor string, verbose bool) (*Response, error) {
+	params := queryParams(query)
+	if verbose {
+		params.Set("verbose", "1")
+	}
+	if pageSize > 0 {
+		params.Set("pageSize", strconv.Itoa(pageSize))
+	}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	body, err := json.Marshal(broker.BrokerAQLRequestBody{Query: query})
+	if err != nil {
+		return nil, err
+	}
+	return c.post("/v2/query/aql", params, body)
+}
+
+// queryParams returns request parameters of the query set by broker from requests.
+func queryParams(query queryCom.AQLQuery) url.Values {
+	params := url.Values{}
+	if query.BucketCompleteness {
+		params.Set("bucketCompleteness", "1")
+	}
+	if query.FillTimeBuckets {
+		params.Set("fillTimeBuckets", "1")
+	}
+	if query.DedupRows {
+		params.Set("dedupRows", "1")
+	}
+	if query.DropSkewedColumns {
+		params.Set("dropSkewedColumns", "1")
+	}
+	if query.PartialResults != nil {
+		params.Set("partialResults", strconv.FormatBool(*query.PartialResults))
+	}
+	if query.TimeoutMillis > 0 {
+		params.Set("timeout", strconv.Itoa(query.TimeoutMillis))
+	}
+	if query.Consistency != "" {
+		params.Set("consistency", query.Consistency)
+	}
+	if query.Explain {
+		params.Set("explain", "1")
+	}
+	if query.ProgressiveInterval > 0 {
+		params.Set("progressive", strconv.Itoa(query.ProgressiveInterval))
+	}
+	return params
+}
+
+// ProgressiveResponse is the v1 response of a progressive aggregation query to the broker.
+type ProgressiveResponse struct {
+	StatusCode int
+	// records of results merged so far in order, the last record is complete, nil if the query failed
+	// before any record.
+	Records []ProgressiveRecord
+}
+
+// ProgressiveRecord is a newline delimited json record of progressive results.
+type ProgressiveRecord struct {
+	Complete bool                    `json:"complete"`
+	Result   queryCom.AQLQueryResult `json:"result"`
+	Warnings []string                `json:"warnings"`
+	Error    *apiCom.QueryErrorV2    `json:"error"`
+}
+
+// QueryProgressive runs the aggregation query through the v1 aql api of the broker, streaming results merged
+// every interval results of datanodes.
+func (c *Cluster) QueryProgressive(query queryCom.AQLQuery, interval int) (*ProgressiveResponse, error) {
+	query.ProgressiveInterval = interval
+	body, err := json.Marshal(broker.BrokerAQLRequestBody{Query: query})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Post(c.broker.URL+"/query/aql?"+queryParams(query).Encode(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	response := &ProgressiveResponse{StatusCode: res.StatusCode}
+	if res.StatusCode != http.StatusOK {
+		return response, nil
+	}
+	decoder := json.NewDecoder(res.Body)
+	for decoder.More() {
+		var record ProgressiveRecord
+		if err = decoder.Decode(&record); err != nil {
+			return nil, utils.StackError(err, "invalid record from broker")
+		}
+		response.Records = append(response.Records, record)
+	}
+	return response, nil
+}
+
+// QuerySQL runs the sql query through the v2 sql api of the broker like Query.
+func (c *Cluster) QuerySQL(sql string) (*Response, error) {
+	return c.querySQL(sql, url.Values{})
+}
+
+func (c *Cluster) querySQL(sql string, params url.Values) (*Response, error) {
+	body, err := json.Marshal(broker.BrokerSQLRequestBody{Query: sql})
+	if err != nil {
+		return nil, err
+	}
+	return c.post("/v2/query/sql", params, body)
+}
+
+// post posts the body of the query to the v2 api of the path of the broker.
+func (c *Cluster) post(path string, params url.Values, body []byte) (*Response, error) {
+	res, err := c.client.Post(c.broker.URL+path+"?"+params.Encode(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resBody struct {
+		Results  []queryCom.AQLQueryResult `json:"results"`
+		Error    *apiCom.QueryErrorV2      `json:"error"`
+		Metadata apiCom.QueryMetadataV2    `json:"metadata"`
+		Meta     *apiCom.QueryMetaV2       `json:"meta"`
+	}
+	if err = json.Unmarshal(bs, &resBody); err != nil {
+		return nil, utils.StackError(err, "invalid response from broker: %s", bs)
+	}
+	response := &Response{
+		StatusCode: res.StatusCode,
+		Error:      resBody.Error,
+		Metadata:   resBody.Metadata,
+		Compressed: res.Uncompressed,
+		Meta:       resBody.Meta,
+		RetryAfter: res.Header.Get(utils.HTTPRetryAfterHeaderKey),
+		QueryID:    res.Header.Get(utils.HTTPQueryIDHeaderKey),
+	}
+	if len(resBody.Results) > 0 {
+		response.Result = resBody.Results[0]
+	}
+	return response, nil
+}
+
+// BatchResponse is the v2 response of a batch of queries to the broker.
+type BatchResponse struct {
+	StatusCode int `json:"-"`
+	// results of the queries by query index, nil for failed queries.
+	Results []queryCom.AQLQueryResult `json:"results"`
+	// errors of the queries by query index, nil if all queries succeeded.
+	Errors   []*apiCom.QueryErrorV2 `json:"errors"`
+	Error    *apiCom.QueryErrorV2   `json:"error"`
+	Metadata apiCom.QueryMetadataV2 `json:"metadata"`
+}
+
+// QueryBatch runs the aggregation queries through the v2 aql batch api of the broker, errors of the queries
+// are returned in the response, the error is only returned if the request failed.
+func (c *Cluster) QueryBatch(queries []queryCom.AQLQuery) (*BatchResponse, error) {
+	body, err := json.Marshal(broker.BrokerAQLBatchRequestBody{Queries: queries})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Post(c.broker.URL+"/v2/query/aql/batch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &BatchResponse{StatusCode: res.StatusCode}
+	if err = json.Unmarshal(bs, response); err != nil {
+		return nil, utils.StackError(err, "invalid response from broker: %s", bs)
+	}
+	return response, nil
+}
+
+// Explain returns the plan of the query explained by the broker, the error of the query is returned as is.
+func (c *Cluster) Explain(query queryCom.AQLQuery) (*broker.QueryExplanation, error) {
+	query.Explain = true
+	return explanationOf(c.Query(query))
+}
+
+// ExplainSQL returns the plan of the sql query explained by the broker like Explain.
+func (c *Cluster) ExplainSQL(sql string) (*broker.QueryExplanation, error) {
+	return explanationOf(c.querySQL(sql, url.Values{"explain": {"1"}}))
+}
+
+func explanationOf(response *Response, err error) (*broker.QueryExplanation, error) {
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	bs, err := json.Marshal(response.Result)
+	if err != nil {
+		return nil, err
+	}
+	var explanation broker.QueryExplanation
+	err = json.Unmarshal(bs, &explanation)
+	return &explanation, err
+}
+
+// RunningQueries lists queries running on the broker.
+func (c *Cluster) RunningQueries() ([]broker.RunningQueryInfo, error) {
+	res, err := c.client.Get(c.broker.URL + "/queries")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var queries []broker.RunningQueryInfo
+	err = json.NewDecoder(res.Body).Decode(&queries)
+	return queries, err
+}
+
+// CancelQuery cancels the running query of the id, the status code of the response is returned.
+func (c *Cluster) CancelQuery(id string) (int, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.broker.URL+"/queries/"+id, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// QueryAllPages runs the paginated non aggregation query until the last page, rows of all pages are
+// returned with the number of pages.
+func (c *Cluster) QueryAllPages(query queryCom.AQLQuery, pageSize int) (rows [][]interface{}, numPages int, err error) {
+	var cursor string
+	for {
+		var response *Response
+		response, err = c.QueryPage(query, pageSize, cursor)
+		if err != nil {
+			return
+		}
+		if response.Error != nil {
+			err = response.Error
+			return
+		}
+		numPages++
+		rows = append(rows, response.Rows()...)
+		if cursor = response.Cursor(); cursor == "" {
+			return
+		}
+	}
+}
+
+// ExpectedResult evaluates the aggregation query over all rows of the table, as a single datanode
+// owning all shards would.
+func (c *Cluster) ExpectedResult(query queryCom.AQLQuery) (queryCom.AQLQueryResult, error) {
+	compiled, table, err := c.compile(query)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.nonAggregation {
+		return nil, utils.StackError(nil, "expect aggregation query")
+	}
+	return compiled.aggregate(table.rows(c.dataset.allShards())), nil
+}
+
+// ExpectedRows evaluates the non aggregation query over all rows of the table without limit, rows are
+// in the same format as Response.Rows.
+func (c *Cluster) ExpectedRows(query queryCom.AQLQuery) ([][]interface{}, error) {
+	compiled, table, err := c.compile(query)
+	if err != nil {
+		return nil, err
+	}
+	if !compiled.nonAggregation {
+		return nil, utils.StackError(nil, "expect non aggregation query")
+	}
+	return compiled.selectRows(table.rows(c.dataset.allShards())), nil
+}
+
+// DataFreshness returns the data freshness of the table reported by datanodes for queries of all shards.
+func (c *Cluster) DataFreshness(table string) int64 {
+	return c.dataset.tables[table].dataFreshness(c.dataset.allShards())
+}
+
+func (c *Cluster) compile(query queryCom.AQLQuery) (*compiledQuery, *datasetTable, error) {
+	table, exist := c.dataset.tables[query.Table]
+	if !exist {
+		return nil, nil, utils.StackError(nil, "unknown table %s", query.Table)
+	}
+	compiled, err := compileQuery(table, query)
+	return compiled, table, err
+}
@@ -0,0 +1,1100 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testharness
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/broker"
+	"github.com/uber/aresdb/broker/config"
+	"github.com/uber/aresdb/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// tripsTable returns the trips table with rows of cities and statuses, fares of trips of city 3 are nulls.
+func tripsTable(numRows int) Table {
+	table := Table{
+		Schema: metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "trip_id", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "status", Type: metaCom.SmallEnum},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+			PrimaryKeyColumns: []int{1},
+			Version:           1,
+		},
+	}
+	statuses := []string{"completed", "canceled"}
+	for i := 0; i < numRows; i++ {
+		row := Row{
+			"request_at": uint32(1570000000 + i*60),
+			"trip_id":    uint32(i),
+			"city_id":    uint16(i % 4),
+			"status":     statuses[i%3%2],
+		}
+		if i%4 != 3 {
+			row["fare"] = float64(i%10 + 1)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+var _ = ginkgo.Describe("fake cluster", func() {
+	utils.Init(common.AresServerConfig{}, common.NewLoggerFactory().GetDefaultLogger(), common.NewLoggerFactory().GetDefaultLogger(), tally.NewTestScope("test", nil))
+
+	var cluster *Cluster
+
+	newCluster := func(cfg ClusterConfig) *Cluster {
+		cfg.Tables = []Table{tripsTable(100)}
+		var err error
+		cluster, err = NewCluster(cfg)
+		Ω(err).Should(BeNil())
+		return cluster
+	}
+
+	ginkgo.AfterEach(func() {
+		if cluster != nil {
+			cluster.Close()
+			cluster = nil
+		}
+	})
+
+	countByCity := queryCom.AQLQuery{
+		Table:      "trips",
+		Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+		Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+	}
+
+	ginkgo.It("should shard rows by primary keys", func() {
+		newCluster(ClusterConfig{NumDataNodes: 3, NumShards: 6, Replicas: 2})
+		numRows := 0
+		for shardID, rows := range cluster.dataset.tables["trips"].shards {
+			numRows += len(rows)
+			for _, row := range rows {
+				Ω(shardOfRow(&cluster.dataset.tables["trips"].schema, row, 6)).Should(Equal(uint32(shardID)))
+			}
+		}
+		Ω(numRows).Should(Equal(100))
+		Ω(cluster.DataNodes[0].Shards()).Should(Equal([]uint32{0, 2, 3, 5}))
+		Ω(cluster.DataNodes[1].Shards()).Should(Equal([]uint32{0, 1, 3, 4}))
+		Ω(cluster.DataNodes[2].Shards()).Should(Equal([]uint32{1, 2, 4, 5}))
+
+		_, err := NewCluster(ClusterConfig{NumDataNodes: 1, Replicas: 2})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("should retry datanodes failed once", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4})
+		cluster.DataNodes[0].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		cluster.DataNodes[1].InjectFault(Fault{DropAfterBytes: 5, Times: 1})
+
+		expected, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+		Ω(cluster.DataNodes[0].Queries()).Should(HaveLen(2))
+		Ω(cluster.DataNodes[1].Queries()).Should(HaveLen(2))
+	})
+
+	ginkgo.It("should skip retries of failed datanodes once the retry budget is exhausted", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 2,
+			NumShards:    4,
+			RetryBudget:  config.RetryBudgetConfig{Enable: true, Ratio: 0.5, WindowSec: 60},
+		})
+		// scans of the query deposit a token.
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+
+		// scans of the query deposit another token, both failed scans are retried.
+		cluster.DataNodes[0].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		response, err = cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(cluster.DataNodes[0].Requests()).Should(Equal(3))
+		Ω(cluster.DataNodes[1].Requests()).Should(Equal(3))
+
+		// a single token is left for the two failed scans, the other one fails right away.
+		cluster.DataNodes[0].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		response, err = cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(response.Error.Hosts).Should(HaveLen(1))
+		Ω(cluster.DataNodes[0].Requests() + cluster.DataNodes[1].Requests()).Should(Equal(9))
+	})
+
+	ginkgo.It("should fail queries with host errors of failed datanodes", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusServiceUnavailable})
+
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusBadGateway))
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(response.Error.Hosts).Should(HaveLen(1))
+		Ω(response.Error.Hosts[0].Host).Should(Equal("datanode1"))
+		Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeUnavailable))
+		Ω(response.Error.Hosts[0].Retriable).Should(BeTrue())
+
+		// recovered after faults are cleared.
+		cluster.DataNodes[1].ClearFaults()
+		response, err = cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+	})
+
+	ginkgo.It("should fail queries when datanodes are killed mid-query", func() {
+		newCluster(ClusterConfig{NumDataNodes: 3, NumShards: 6})
+		cluster.DataNodes[2].InjectFault(Fault{Latency: 50 * time.Millisecond, Kill: true})
+
+		for _, query := range []queryCom.AQLQuery{countByCity, {
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}} {
+			response, err := cluster.Query(query)
+			Ω(err).Should(BeNil())
+			Ω(response.Error).ShouldNot(BeNil())
+			Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+			Ω(response.Error.Hosts[0].Host).Should(Equal("datanode2"))
+			Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeUnavailable))
+		}
+		// the retry is refused by the killed datanode.
+		Ω(cluster.DataNodes[2].Queries()).Should(HaveLen(1))
+	})
+
+	ginkgo.It("should return partial results of datanodes succeeded", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusServiceUnavailable})
+		numRows := 0
+		for _, shardID := range cluster.DataNodes[0].Shards() {
+			numRows += len(cluster.dataset.tables["trips"].shards[shardID])
+		}
+		allow := true
+		rowsQuery := queryCom.AQLQuery{
+			Table:          "trips",
+			Dimensions:     []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:       []queryCom.Measure{{Expr: "1"}},
+			Limit:          -1,
+			PartialResults: &allow,
+		}
+		countQuery := countByCity
+		countQuery.PartialResults = &allow
+
+		// all or nothing by default.
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+
+		expectPartial := func(response *Response) {
+			Ω(response.Error).Should(BeNil())
+			Ω(response.Metadata.Partial).Should(BeTrue())
+			Ω(response.Metadata.Warnings).Should(ContainElement(
+				"partial results without failed hosts [datanode1], covering 50.00% of shards"))
+			Ω(response.Metadata.Errors.ShardCoverage).Should(Equal(0.5))
+			Ω(response.Metadata.Errors.Hosts).Should(HaveLen(1))
+			Ω(response.Metadata.Errors.Hosts[0].Host).Should(Equal("datanode1"))
+			Ω(response.Metadata.Errors.Hosts[0].Code).Should(Equal(utils.ErrCodeUnavailable))
+		}
+
+		response, err = cluster.Query(countQuery)
+		Ω(err).Should(BeNil())
+		expectPartial(response)
+		count := 0.0
+		for _, value := range response.Result {
+			count += value.(float64)
+		}
+		Ω(count).Should(Equal(float64(numRows)))
+
+		response, err = cluster.Query(rowsQuery)
+		Ω(err).Should(BeNil())
+		expectPartial(response)
+		Ω(response.Rows()).Should(HaveLen(numRows))
+		Ω(response.Result).Should(HaveKey(queryCom.ErrorsKey))
+
+		// queries fail if all datanodes fail.
+		cluster.DataNodes[0].InjectFault(Fault{StatusCode: http.StatusServiceUnavailable})
+		for _, query := range []queryCom.AQLQuery{countQuery, rowsQuery} {
+			response, err = cluster.Query(query)
+			Ω(err).Should(BeNil())
+			Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+			Ω(response.Metadata.Errors).Should(BeNil())
+		}
+	})
+
+	ginkgo.It("should return partial results by default of broker", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4, PartialResults: config.PartialResultsConfig{Enable: true}})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusServiceUnavailable})
+
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Metadata.Errors.ShardCoverage).Should(Equal(0.5))
+
+		// overridden by the query.
+		deny := false
+		query := countByCity
+		query.PartialResults = &deny
+		response, err = cluster.Query(query)
+		Ω(err).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+
+		// queries not supporting partial results fail on datanode failures by default, and are rejected if
+		// partial results are requested.
+		query = queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "countdistinct(city_id)"}},
+		}
+		response, err = cluster.Query(query)
+		Ω(err).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		allow := true
+		query.PartialResults = &allow
+		response, err = cluster.Query(query)
+		Ω(err).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Error.Message).Should(ContainSubstring("partial results are not supported by exact countdistinct"))
+	})
+
+	ginkgo.It("should time out queries waiting on slow datanodes", func() {
+		newCluster(ClusterConfig{NumDataNodes: 3, NumShards: 3, QueryTimeout: config.QueryTimeoutConfig{DefaultTimeoutMillis: 5000}})
+		cluster.DataNodes[1].InjectFault(Fault{Latency: time.Minute})
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+		for _, query := range []queryCom.AQLQuery{countByCity, rowsQuery} {
+			query.TimeoutMillis = 50
+			start := time.Now()
+			response, err := cluster.Query(query)
+			Ω(err).Should(BeNil())
+			Ω(time.Since(start)).Should(BeNumerically("<", 5*time.Second))
+			Ω(response.StatusCode).Should(Equal(http.StatusRequestTimeout))
+			Ω(response.Error.Code).Should(Equal(utils.ErrCodeTimeout))
+			Ω(response.Error.Retriable).Should(BeTrue())
+			Ω(response.Error.Hosts).Should(HaveLen(1))
+			Ω(response.Error.Hosts[0].Host).Should(Equal("datanode1"))
+		}
+
+		// queries run up to the default timeout of broker.
+		cluster.DataNodes[1].ClearFaults()
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+	})
+
+	ginkgo.It("should cap concurrent queries with a wait queue", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 1,
+			NumShards:    1,
+			Admission:    config.AdmissionConfig{MaxConcurrentQueries: 1, MaxQueueDepth: 1, RetryAfterSec: 2},
+		})
+		cluster.DataNodes[0].InjectFault(Fault{Latency: 500 * time.Millisecond, Times: 1})
+
+		running := make(chan *Response)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			response, err := cluster.Query(countByCity)
+			Ω(err).Should(BeNil())
+			running <- response
+		}()
+		Eventually(cluster.DataNodes[0].Requests).Should(Equal(1))
+
+		// queued queries honor their deadlines while waiting.
+		query := countByCity
+		query.TimeoutMillis = 50
+		response, err := cluster.Query(query)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusRequestTimeout))
+		Ω(response.Error.Message).Should(ContainSubstring("waiting"))
+
+		queued := make(chan *Response)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			response, err := cluster.Query(countByCity)
+			Ω(err).Should(BeNil())
+			queued <- response
+		}()
+		// queries beyond the queue are rejected.
+		time.Sleep(100 * time.Millisecond)
+		response, err = cluster.Query(query)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusTooManyRequests))
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeTooManyRequests))
+		Ω(response.Error.Retriable).Should(BeTrue())
+		Ω(response.RetryAfter).Should(Equal("2"))
+
+		Ω((<-running).Error).Should(BeNil())
+		Ω((<-queued).Error).Should(BeNil())
+	})
+
+	ginkgo.It("should list and cancel running queries by ids", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 2})
+		cluster.DataNodes[1].InjectFault(Fault{Latency: time.Minute, Times: 1})
+
+		responses := make(chan *Response)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			response, err := cluster.Query(countByCity)
+			Ω(err).Should(BeNil())
+			responses <- response
+		}()
+		var queries []broker.RunningQueryInfo
+		Eventually(func() string {
+			var err error
+			queries, err = cluster.RunningQueries()
+			Ω(err).Should(BeNil())
+			if len(queries) != 1 {
+				return ""
+			}
+			return queries[0].Phase
+		}).Should(Equal(queryCom.QueryPhaseWaitingOnDataNodes))
+		id := queries[0].ID
+		Ω(queries[0].User).Should(Equal("UNKNOWN"))
+		Ω(queries[0].ElapsedMillis).Should(BeNumerically(">=", 0))
+		var query queryCom.AQLQuery
+		Ω(json.Unmarshal(queries[0].Query, &query)).Should(BeNil())
+		Ω(query.Measures).Should(Equal(countByCity.Measures))
+
+		status, err := cluster.CancelQuery(id)
+		Ω(err).Should(BeNil())
+		Ω(status).Should(Equal(http.StatusOK))
+		response := <-responses
+		Ω(response.QueryID).Should(Equal(id))
+		Ω(response.StatusCode).Should(Equal(utils.StatusQueryCanceled))
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeCanceled))
+		Eventually(cluster.DataNodes[1].CanceledRequests).Should(Equal(1))
+
+		queries, err = cluster.RunningQueries()
+		Ω(err).Should(BeNil())
+		Ω(queries).Should(BeEmpty())
+		status, err = cluster.CancelQuery(id)
+		Ω(err).Should(BeNil())
+		Ω(status).Should(Equal(http.StatusNotFound))
+
+		// ids of queries are returned in verbose metadata as well.
+		response, err = cluster.QueryVerbose(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.QueryID).ShouldNot(BeEmpty())
+		Ω(response.Meta.QueryID).Should(Equal(response.QueryID))
+	})
+
+	ginkgo.It("should hedge queries of slow datanodes to replicas", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 3,
+			NumShards:    3,
+			Replicas:     2,
+			QueryTimeout: config.QueryTimeoutConfig{DefaultTimeoutMillis: 5000},
+			Hedge:        config.HedgeConfig{Enable: true, DelayMillis: 20},
+		})
+		cluster.DataNodes[1].InjectFault(Fault{Latency: time.Minute})
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+
+		expectedResult, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		start := time.Now()
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(time.Since(start)).Should(BeNumerically("<", 5*time.Second))
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expectedResult))
+
+		expectedRows, err := cluster.ExpectedRows(rowsQuery)
+		Ω(err).Should(BeNil())
+		response, err = cluster.Query(rowsQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Rows()).Should(ConsistOf(expectedRows))
+	})
+
+	ginkgo.It("should retry queries of failed datanodes on replicas", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 3,
+			NumShards:    3,
+			Replicas:     2,
+		})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError})
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+
+		expectedResult, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expectedResult))
+
+		expectedRows, err := cluster.ExpectedRows(rowsQuery)
+		Ω(err).Should(BeNil())
+		response, err = cluster.Query(rowsQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Rows()).Should(ConsistOf(expectedRows))
+	})
+
+	ginkgo.It("should fail over right away from datanodes with open circuit breakers", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes:   3,
+			NumShards:      3,
+			Replicas:       2,
+			CircuitBreaker: config.CircuitBreakerConfig{Enable: true, FailureThreshold: 2, OpenDurationMillis: 50},
+		})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusServiceUnavailable})
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+		expectedResult, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		expectedRows, err := cluster.ExpectedRows(rowsQuery)
+		Ω(err).Should(BeNil())
+
+		// the breaker opens after consecutive failures retried on replicas.
+		for i := 0; i < 2; i++ {
+			response, err := cluster.Query(countByCity)
+			Ω(err).Should(BeNil())
+			Ω(response.Error).Should(BeNil())
+			Ω(response.Result).Should(Equal(expectedResult))
+		}
+		Ω(cluster.DataNodes[1].Requests()).Should(Equal(2))
+
+		// queries of the datanode fail over without being sent to it.
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Result).Should(Equal(expectedResult))
+		response, err = cluster.Query(rowsQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Rows()).Should(ConsistOf(expectedRows))
+		Ω(cluster.DataNodes[1].Requests()).Should(Equal(2))
+
+		// the probe closes the breaker once the datanode recovered.
+		cluster.DataNodes[1].ClearFaults()
+		time.Sleep(60 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			response, err = cluster.Query(countByCity)
+			Ω(err).Should(BeNil())
+			Ω(response.Result).Should(Equal(expectedResult))
+		}
+		Ω(cluster.DataNodes[1].Requests()).Should(Equal(4))
+	})
+
+	ginkgo.It("should spread shards of queries over replicas", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes:    3,
+			NumShards:       3,
+			Replicas:        3,
+			ShardAssignment: config.ShardAssignmentConfig{SpreadReplicas: true},
+		})
+		expectedResult, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		for i := 0; i < 20; i++ {
+			response, err := cluster.Query(countByCity)
+			Ω(err).Should(BeNil())
+			Ω(response.Error).Should(BeNil())
+			Ω(response.Result).Should(Equal(expectedResult))
+		}
+
+		// each datanode is assigned one shard per query, which varies across queries.
+		for _, node := range cluster.DataNodes {
+			queried := make(map[int]bool)
+			for _, query := range node.Queries() {
+				Ω(query.Shards).Should(HaveLen(1))
+				queried[query.Shards[0]] = true
+			}
+			Ω(len(queried)).Should(BeNumerically(">", 1))
+		}
+	})
+
+	ginkgo.It("should break down stats by datanodes of verbose queries", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 3,
+			NumShards:    3,
+			Replicas:     2,
+		})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Meta).Should(BeNil())
+
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		response, err = cluster.QueryVerbose(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Meta).ShouldNot(BeNil())
+		Ω(response.Meta.DataNodes).Should(HaveLen(3))
+		var rowsScanned int64
+		for i, stats := range response.Meta.DataNodes {
+			Ω(stats.Host).Should(Equal(cluster.DataNodes[i].Host().ID()))
+			rowsScanned += stats.RowsScanned
+		}
+		// the scan of datanode1 is retried on its replica.
+		Ω(response.Meta.DataNodes[1].Retries).Should(Equal(1))
+		Ω(response.Meta.DataNodes[1].Bytes).Should(BeZero())
+		Ω(response.Meta.DataNodes[2].Bytes).Should(BeNumerically(">", 0))
+		Ω(response.Meta.DataNodes[2].Queries).Should(Equal(2))
+		Ω(rowsScanned).Should(Equal(response.Metadata.Stats.RowsScanned))
+	})
+
+	ginkgo.It("should trace requests through query plans to datanodes", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 3,
+			NumShards:    3,
+			Replicas:     2,
+		})
+		tracer := mocktracer.New()
+		opentracing.SetGlobalTracer(tracer)
+		defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		// the request id is forwarded with queries of datanodes, including retries on replicas.
+		Ω(response.Metadata.RequestID).ShouldNot(BeEmpty())
+		Ω(cluster.DataNodes[1].RequestIDs()).Should(Equal([]string{response.Metadata.RequestID}))
+		Ω(cluster.DataNodes[2].RequestIDs()).Should(Equal([]string{response.Metadata.RequestID, response.Metadata.RequestID}))
+
+		operations := make(map[string]int)
+		spans := tracer.FinishedSpans()
+		for _, span := range spans {
+			operations[span.OperationName]++
+			Ω(span.SpanContext.TraceID).Should(Equal(spans[0].SpanContext.TraceID))
+		}
+		Ω(operations).Should(Equal(map[string]int{
+			"broker.request":        1,
+			"broker.agg_query_plan": 1,
+			"broker.scan":           3,
+			"datanode.query":        4,
+		}))
+	})
+
+	ginkgo.It("should read shards from replicas by consistency levels", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 3,
+			NumShards:    6,
+			Replicas:     3,
+			Consistency:  config.ConsistencyConfig{DefaultLevel: "quorum"},
+		})
+		cluster.DataNodes[1].InjectFault(Fault{StatusCode: http.StatusInternalServerError})
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+
+		// results of each shard are taken once from replicas responded.
+		expectedResult, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expectedResult))
+
+		expectedRows, err := cluster.ExpectedRows(rowsQuery)
+		Ω(err).Should(BeNil())
+		rowsQuery.Consistency = "one"
+		response, err = cluster.Query(rowsQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Rows()).Should(ConsistOf(expectedRows))
+
+		// a majority of replicas is required for quorum reads.
+		cluster.DataNodes[2].InjectFault(Fault{StatusCode: http.StatusInternalServerError})
+		response, err = cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		oneQuery := countByCity
+		oneQuery.Consistency = "one"
+		response, err = cluster.Query(oneQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expectedResult))
+		cluster.Close()
+
+		// consistent reads are rejected without replicated shards.
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 2})
+		response, err = cluster.Query(oneQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Error.Message).Should(ContainSubstring("replication factor"))
+	})
+
+	ginkgo.It("should share requests to datanodes among queries of batches", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4, Replicas: 1})
+		avgQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "status"}},
+			Measures:   []queryCom.Measure{{Expr: "avg(fare)", Filters: []string{"fare >= 0"}}},
+		}
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+		badQuery := queryCom.AQLQuery{
+			Table:    "unknown",
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+		}
+
+		response, err := cluster.QueryBatch([]queryCom.AQLQuery{countByCity, badQuery, avgQuery, rowsQuery})
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusOK))
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Results).Should(HaveLen(4))
+		Ω(response.Errors).Should(HaveLen(4))
+		for i, query := range []queryCom.AQLQuery{countByCity, avgQuery} {
+			expected, err := cluster.ExpectedResult(query)
+			Ω(err).Should(BeNil())
+			Ω(response.Results[i*2]).Should(Equal(expected))
+			Ω(response.Errors[i*2]).Should(BeNil())
+		}
+		Ω(response.Results[1]).Should(BeNil())
+		Ω(response.Errors[1].Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Errors[3]).Should(BeNil())
+		expectedRows, err := cluster.ExpectedRows(rowsQuery)
+		Ω(err).Should(BeNil())
+		rows := (&Response{Result: response.Results[3]}).Rows()
+		Ω(rows).Should(ConsistOf(expectedRows))
+
+		// count, sum and count of avg are batched into one request per datanode, besides the rows query.
+		for _, node := range cluster.DataNodes {
+			Ω(node.Queries()).Should(HaveLen(4))
+			Ω(node.Requests()).Should(Equal(2))
+		}
+
+		// failed batched queries are retried on their own.
+		cluster.DataNodes[0].InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 1})
+		response, err = cluster.QueryBatch([]queryCom.AQLQuery{countByCity, avgQuery})
+		Ω(err).Should(BeNil())
+		Ω(response.Errors).Should(BeNil())
+		Ω(response.Results).Should(HaveLen(2))
+		Ω(cluster.DataNodes[0].Requests()).Should(Equal(2 + 1 + 3))
+	})
+
+	ginkgo.It("should explain plans of queries without executing them", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4})
+		avgQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "status"}},
+			Measures:   []queryCom.Measure{{Expr: "avg(fare)"}},
+		}
+		explanation, err := cluster.Explain(avgQuery)
+		Ω(err).Should(BeNil())
+		Ω(explanation.Plan).Should(Equal("aggregation"))
+		Ω(explanation.Consistency).Should(Equal(broker.ConsistencyAll))
+		Ω(explanation.Root.Node).Should(Equal("merge"))
+		Ω(explanation.Root.AggTypes).Should(Equal([]string{"avg"}))
+		Ω(explanation.Root.Children).Should(HaveLen(2))
+		for i, measure := range []string{"sum(fare)", "count(*)"} {
+			merge := explanation.Root.Children[i]
+			Ω(merge.AggTypes).Should(Equal([]string{[]string{"sum", "count"}[i]}))
+			Ω(merge.Children).Should(HaveLen(2))
+			for j, scan := range merge.Children {
+				Ω(scan.Node).Should(Equal("scan"))
+				Ω(scan.Host).Should(Equal(cluster.DataNodes[j].Host().ID()))
+				Ω(scan.Shards).Should(ConsistOf(scan.Query.Shards))
+				Ω(scan.Query.Measures[0].Expr).Should(Equal(measure))
+			}
+		}
+
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      10,
+			Offset:     5,
+		}
+		explanation, err = cluster.Explain(rowsQuery)
+		Ω(err).Should(BeNil())
+		Ω(explanation.Plan).Should(Equal("nonAggregation"))
+		Ω(explanation.Root.Node).Should(Equal("streamMerge"))
+		Ω(explanation.Root.Children).Should(HaveLen(2))
+		// datanodes return rows up to the offset plus the limit, which is skipped by broker.
+		for _, scan := range explanation.Root.Children {
+			Ω(scan.Node).Should(Equal("streamingScan"))
+			Ω(scan.Query.Limit).Should(Equal(15))
+			Ω(scan.Query.Offset).Should(BeZero())
+		}
+
+		// invalid queries are not explained.
+		_, err = cluster.Explain(queryCom.AQLQuery{Table: "unknown", Measures: []queryCom.Measure{{Expr: "count(*)"}}})
+		Ω(err).ShouldNot(BeNil())
+
+		for _, node := range cluster.DataNodes {
+			Ω(node.Queries()).Should(BeEmpty())
+		}
+	})
+
+	ginkgo.It("should plan sql queries like equivalent aql queries", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4})
+		// sql queries sent to datanodes are the only differences of plans.
+		var withoutSQL func(node *broker.PlanNodeExplanation)
+		withoutSQL = func(node *broker.PlanNodeExplanation) {
+			if node.Query != nil {
+				Ω(node.Query.SQLQuery).ShouldNot(BeEmpty())
+				node.Query.SQLQuery = ""
+			}
+			for i := range node.Children {
+				withoutSQL(&node.Children[i])
+			}
+		}
+
+		completedByCity := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+			Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+			Filters:    []string{"status = 'completed'"},
+		}
+		completedByCitySQL := "SELECT city_id, count(*) FROM trips WHERE status = 'completed' GROUP BY city_id"
+		lastHour := completedByCity
+		lastHour.TimeFilter = queryCom.TimeFilter{Column: "request_at", From: "1570000000", To: "1570003600"}
+		recentTrips := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}, {Expr: "fare"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Filters:    []string{"city_id = 1"},
+			TimeFilter: queryCom.TimeFilter{Column: "request_at", From: "1570000000"},
+			Sorts:      []queryCom.SortField{{Name: "fare", Order: "DESC"}},
+			Limit:      10,
+		}
+
+		for aql, sql := range map[*queryCom.AQLQuery]string{
+			&completedByCity: completedByCitySQL,
+			&lastHour: `SELECT city_id, count(*) FROM trips
+				WHERE request_at >= 1570000000 AND status = 'completed' AND request_at < 1570003600
+				GROUP BY city_id`,
+			&recentTrips: `SELECT trip_id, fare FROM trips
+				WHERE city_id = 1 AND request_at >= 1570000000
+				ORDER BY fare DESC LIMIT 10`,
+		} {
+			expected, err := cluster.Explain(*aql)
+			Ω(err).Should(BeNil())
+			explanation, err := cluster.ExplainSQL(sql)
+			Ω(err).Should(BeNil())
+			withoutSQL(&explanation.Root)
+			Ω(explanation).Should(Equal(expected))
+		}
+
+		// results are the same as well, datanodes of the cluster do not support time filters.
+		expected, err := cluster.ExpectedResult(completedByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.QuerySQL(completedByCitySQL)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+
+		// parse errors are invalid queries with positions.
+		response, err = cluster.QuerySQL("SELECT count(*) FROM trips\nWHERE city_id IN (SELECT id FROM cities)")
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Error.Message).Should(ContainSubstring("inSubquery at (line:2, col:14) not supported yet"))
+		response, err = cluster.QuerySQL("SELECT count(*) FROM trips GROUP BY")
+		Ω(err).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(response.Error.Message).Should(ContainSubstring("syntax error at (line:1, col:35)"))
+	})
+
+	ginkgo.It("should compress large responses", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 2,
+			NumShards:    2,
+			Compression:  config.CompressionConfig{Enable: true, MinBytes: 1024},
+		})
+		rowsQuery := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}, {Expr: "status"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Limit:      -1,
+		}
+		expectedRows, err := cluster.ExpectedRows(rowsQuery)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(rowsQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Compressed).Should(BeTrue())
+		Ω(response.Rows()).Should(ConsistOf(expectedRows))
+
+		// small responses are not compressed.
+		countQuery := queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+		}
+		expectedResult, err := cluster.ExpectedResult(countQuery)
+		Ω(err).Should(BeNil())
+		response, err = cluster.Query(countQuery)
+		Ω(err).Should(BeNil())
+		Ω(response.Compressed).Should(BeFalse())
+		Ω(response.Result).Should(Equal(expectedResult))
+	})
+
+	ginkgo.It("should fail over to replicas of datanodes with stale schemas", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes:       2,
+			NumShards:          4,
+			Replicas:           2,
+			SchemaVersionCheck: config.SchemaVersionCheckConfig{Enable: true},
+		})
+		cluster.DataNodes[0].SetSchemaVersion("trips", metaCom.TableSchemaVersion{Version: 0})
+
+		expected, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+		Ω(response.Metadata.Warnings).Should(ConsistOf(ContainSubstring("host datanode0 is excluded with stale schema")))
+		Ω(cluster.DataNodes[0].Queries()).Should(BeEmpty())
+		Ω(cluster.DataNodes[1].Queries()).Should(HaveLen(1))
+		Ω(cluster.DataNodes[1].Queries()[0].Shards).Should(ConsistOf(0, 1, 2, 3))
+	})
+
+	ginkgo.It("should report schema mismatches and unsupported queries of datanodes", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 2})
+		cluster.SchemaMutator.UpdateTable(metaCom.Table{
+			Name:              "trips",
+			IsFactTable:       true,
+			Columns:           append(tripsTable(0).Schema.Columns, metaCom.Column{Name: "tip", Type: metaCom.Float32}),
+			PrimaryKeyColumns: []int{1},
+			Version:           2,
+		})
+
+		response, err := cluster.Query(queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "sum(tip)"}},
+		})
+		Ω(err).Should(BeNil())
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeSchemaMismatch))
+
+		response, err = cluster.Query(queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "count(*)"}},
+			Joins:    []queryCom.Join{{Table: "trips", Alias: "t", Conditions: []string{"t.trip_id = trips.trip_id"}}},
+		})
+		Ω(err).Should(BeNil())
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeNotImplemented))
+	})
+
+	ginkgo.It("should retry or degrade queries of datanodes lagging behind schema changes", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes: 2,
+			NumShards:    4,
+			SchemaSkew:   config.SchemaSkewConfig{RetryDelayMillis: 10},
+		})
+		countByCityStatus := queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "city_id"}, {Expr: "status"}},
+			Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+		}
+
+		// datanodes refreshed schemas before retries.
+		cluster.DataNodes[1].LagSchema(&SchemaLag{
+			Table:   "trips",
+			Version: metaCom.TableSchemaVersion{Version: 0},
+			Columns: []string{"status"},
+			Times:   1,
+		})
+		expected, err := cluster.ExpectedResult(countByCityStatus)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(countByCityStatus)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+		Ω(cluster.DataNodes[0].Requests()).Should(Equal(1))
+		Ω(cluster.DataNodes[1].Requests()).Should(Equal(2))
+
+		// datanodes still lagging fail the query naming them.
+		cluster.DataNodes[1].LagSchema(&SchemaLag{
+			Table:   "trips",
+			Version: metaCom.TableSchemaVersion{Version: 0},
+			Columns: []string{"status"},
+		})
+		response, err = cluster.Query(countByCityStatus)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(response.Error.Message).Should(ContainSubstring("datanodes datanode1 (0.0) lag behind schema 0.1 of table trips"))
+		Ω(response.Error.Hosts).Should(HaveLen(1))
+		Ω(response.Error.Hosts[0].Host).Should(Equal("datanode1"))
+		Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeSchemaMismatch))
+
+		// or drop columns unknown to them if requested.
+		degradable := countByCityStatus
+		degradable.DropSkewedColumns = true
+		expected, err = cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err = cluster.Query(degradable)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+		Ω(response.Metadata.Warnings).Should(ConsistOf(
+			"dropped columns status unknown to datanodes datanode1 (0.0) lagging behind schema 0.1 of table trips"))
+
+		// columns filtered on can not be dropped.
+		degradable.Filters = []string{"status = 'completed'"}
+		response, err = cluster.Query(degradable)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).ShouldNot(BeNil())
+		Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeSchemaMismatch))
+
+		cluster.DataNodes[1].LagSchema(nil)
+		response, err = cluster.Query(countByCityStatus)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+	})
+
+	ginkgo.It("should validate columns of queries before querying datanodes", func() {
+		newCluster(ClusterConfig{
+			NumDataNodes:     2,
+			NumShards:        2,
+			SchemaValidation: config.SchemaValidationConfig{Enable: true},
+		})
+
+		response, err := cluster.Query(queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "cityid"}},
+			Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+		})
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeSchemaMismatch))
+		Ω(response.Error.Message).Should(ContainSubstring("unknown column cityid of table trips in expression cityid, did you mean city_id?"))
+		Ω(response.Error.Hosts).Should(BeEmpty())
+		for _, node := range cluster.DataNodes {
+			Ω(node.Requests()).Should(Equal(0))
+		}
+
+		// queries of columns added are validated against the changed schema.
+		cluster.SchemaMutator.UpdateTable(metaCom.Table{
+			Name:              "trips",
+			IsFactTable:       true,
+			Columns:           append(tripsTable(0).Schema.Columns, metaCom.Column{Name: "tip", Type: metaCom.Float32}),
+			PrimaryKeyColumns: []int{1},
+			Version:           2,
+		})
+		response, err = cluster.Query(queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "sum(tip)"}},
+		})
+		Ω(err).Should(BeNil())
+		Ω(response.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(response.Error.Hosts[0].Code).Should(Equal(utils.ErrCodeSchemaMismatch))
+	})
+
+	ginkgo.It("should serve queries of datanodes with latency", func() {
+		newCluster(ClusterConfig{NumDataNodes: 2, NumShards: 4})
+		cluster.DataNodes[0].InjectFault(Fault{Latency: 20 * time.Millisecond})
+
+		expected, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+		Ω(response.Metadata.Stats.LatencyMillis).Should(BeNumerically(">=", 20))
+		Ω(response.Metadata.Stats.DataNodeQueries).Should(Equal(2))
+	})
+
+	ginkgo.It("should stream progressive results of aggregation queries merged so far", func() {
+		newCluster(ClusterConfig{NumDataNodes: 4, NumShards: 4})
+		// the last datanode responds after the others are merged.
+		cluster.DataNodes[3].InjectFault(Fault{Latency: 50 * time.Millisecond})
+
+		expected, err := cluster.ExpectedResult(countByCity)
+		Ω(err).Should(BeNil())
+		response, err := cluster.QueryProgressive(countByCity, 2)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusOK))
+		// a partial record once 2 datanodes are merged, the complete record follows the last datanode.
+		Ω(response.Records).Should(HaveLen(2))
+		Ω(response.Records[0].Complete).Should(BeFalse())
+		Ω(response.Records[0].Result).ShouldNot(BeEmpty())
+		for city, count := range response.Records[0].Result {
+			Ω(count).Should(BeNumerically("<=", expected[city]))
+		}
+		Ω(response.Records[1].Complete).Should(BeTrue())
+		Ω(response.Records[1].Result).Should(Equal(expected))
+		nonStreamed, err := cluster.Query(countByCity)
+		Ω(err).Should(BeNil())
+		Ω(response.Records[1].Result).Should(Equal(nonStreamed.Result))
+
+		// failures after partial records end the response with the error.
+		cluster.DataNodes[3].ClearFaults()
+		cluster.DataNodes[3].InjectFault(Fault{Latency: 50 * time.Millisecond, StatusCode: http.StatusServiceUnavailable})
+		response, err = cluster.QueryProgressive(countByCity, 1)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(http.StatusOK))
+		last := response.Records[len(response.Records)-1]
+		Ω(last.Complete).Should(BeTrue())
+		Ω(last.Result).Should(BeNil())
+		Ω(last.Error.Code).Should(Equal(utils.ErrCodeDataNodeFailure))
+		Ω(last.Error.Hosts).Should(HaveLen(1))
+		Ω(last.Error.Hosts[0].Host).Should(Equal("datanode3"))
+
+		// aggregations finalized once merged are not streamed.
+		response, err = cluster.QueryProgressive(queryCom.AQLQuery{
+			Table:    "trips",
+			Measures: []queryCom.Measure{{Expr: "avg(fare)"}},
+		}, 1)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).ShouldNot(Equal(http.StatusOK))
+		Ω(response.Records).Should(BeNil())
+	})
+})
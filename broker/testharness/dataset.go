@@ -0,0 +1,696 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testharness
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uber/aresdb/broker/common"
+	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// Row is a row of a table keyed by column names, missing columns are nulls. Values are go numbers,
+// strings or bools.
+type Row map[string]interface{}
+
+// Table is the schema and rows of a table served by fake datanodes.
+type Table struct {
+	Schema metaCom.Table
+	Rows   []Row
+}
+
+// dataset is the rows of tables by shard, shared by fake datanodes of a cluster.
+type dataset struct {
+	numShards int
+	tables    map[string]*datasetTable
+}
+
+type datasetTable struct {
+	schema metaCom.Table
+	// rows by shard id.
+	shards [][]Row
+}
+
+// newDataset shards rows of the tables by their primary keys the way ingestion clients do.
+func newDataset(tables []Table, numShards int) (*dataset, error) {
+	ds := &dataset{
+		numShards: numShards,
+		tables:    make(map[string]*datasetTable),
+	}
+	for _, table := range tables {
+		dsTable := &datasetTable{
+			schema: table.Schema,
+			shards: make([][]Row, numShards),
+		}
+		for _, row := range table.Rows {
+			shardID, err := shardOfRow(&table.Schema, row, numShards)
+			if err != nil {
+				return nil, err
+			}
+			dsTable.shards[shardID] = append(dsTable.shards[shardID], row)
+		}
+		ds.tables[table.Schema.Name] = dsTable
+	}
+	return ds, nil
+}
+
+// rows returns rows of the shards in the order of shard ids.
+func (t *datasetTable) rows(shardIDs []uint32) []Row {
+	sorted := append([]uint32(nil), shardIDs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var rows []Row
+	for _, shardID := range sorted {
+		if int(shardID) < len(t.shards) {
+			rows = append(rows, t.shards[shardID]...)
+		}
+	}
+	return rows
+}
+
+// dataFreshness returns the latest event time of the stalest shard with rows, the way datanodes report
+// freshness of fact tables by the time column, 0 if unknown.
+func (t *datasetTable) dataFreshness(shardIDs []uint32) (freshness int64) {
+	if !t.schema.IsFactTable {
+		return
+	}
+	timeColumn := t.schema.Columns[0].Name
+	for _, shardID := range shardIDs {
+		var latest int64
+		for _, row := range t.shards[shardID] {
+			if eventTime, ok := toFloat(row[timeColumn]); ok && int64(eventTime) > latest {
+				latest = int64(eventTime)
+			}
+		}
+		if latest > 0 && (freshness == 0 || latest < freshness) {
+			freshness = latest
+		}
+	}
+	return
+}
+
+// allShards returns ids of all shards of the dataset.
+func (ds *dataset) allShards() []uint32 {
+	shardIDs := make([]uint32, ds.numShards)
+	for i := range shardIDs {
+		shardIDs[i] = uint32(i)
+	}
+	return shardIDs
+}
+
+// shardOfRow returns the shard of the row by its primary key, enum columns are keyed by their cases.
+func shardOfRow(schema *metaCom.Table, row Row, numShards int) (uint32, error) {
+	var primaryKeyValues []memCom.DataValue
+	var enumBytes []byte
+	for _, columnID := range schema.PrimaryKeyColumns {
+		column := schema.Columns[columnID]
+		value := row[column.Name]
+		if value == nil {
+			return 0, utils.StackError(nil, "primary key column %s of table %s cannot be null", column.Name, schema.Name)
+		}
+		if column.IsEnumColumn() {
+			enumCase := fmt.Sprint(value)
+			if !column.CaseInsensitive {
+				enumCase = strings.ToLower(enumCase)
+			}
+			enumBytes = append(enumBytes, enumCase...)
+			continue
+		}
+		dataValue, err := memCom.ValueFromString(fmt.Sprint(value), memCom.DataTypeForColumn(column))
+		if err != nil {
+			return 0, utils.StackError(err, "invalid value %v of primary key column %s", value, column.Name)
+		}
+		primaryKeyValues = append(primaryKeyValues, dataValue)
+	}
+	key, err := memCom.GetPrimaryKeyBytes(primaryKeyValues, 0)
+	if err != nil {
+		return 0, err
+	}
+	key = append(key, enumBytes...)
+	return memCom.GetShardByPrimaryKey(key, uint32(numShards)), nil
+}
+
+// rowPredicate tells whether the row passes the filter.
+type rowPredicate func(row Row) bool
+
+// rowValue evaluates the expression over the row, nil for nulls.
+type rowValue func(row Row) interface{}
+
+// layouts of regular time buckets by bucket units, as formatted by datanodes.
+var timeBucketLayouts = map[string]string{
+	"m": "2006-01-02 15:04",
+	"h": "2006-01-02 15:00",
+	"d": "2006-01-02",
+}
+
+// timeBucket is the regular time bucket of a time dimension in utc.
+type timeBucket struct {
+	seconds int64
+	layout  string
+}
+
+// compiledQuery is the query compiled against the schema of the table, evaluated over rows the way
+// datanodes do. Only plain column dimensions, regular time buckets in utc, count, sum, avg, min, max,
+// var, stddev and tdigest of arithmetics of columns, countdistinct of columns, and comparisons of columns
+// with literals in filters are supported.
+type compiledQuery struct {
+	nonAggregation bool
+	aggType        common.AggType
+	// value aggregated by the measure, nil for count.
+	measureValue rowValue
+	dimensions   []string
+	// time buckets of dimensions, nil for plain column dimensions.
+	timeBuckets []*timeBucket
+	filters     []rowPredicate
+}
+
+// compileQuery compiles the query, unknown columns are schema mismatches, and unsupported features are
+// not implemented errors. Sorts are ignored like datanodes, rows are sorted by brokers.
+func compileQuery(table *datasetTable, query queryCom.AQLQuery) (*compiledQuery, error) {
+	switch {
+	case len(query.Joins) > 0:
+		return nil, notImplemented("joins")
+	case query.TimeFilter != (queryCom.TimeFilter{}):
+		return nil, notImplemented("time filters")
+	case len(query.Measures) != 1:
+		return nil, utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "expect one measure per query, but got %d", len(query.Measures))
+	}
+
+	q := &compiledQuery{}
+	measure := query.Measures[0]
+	measureExpr, err := expr.ParseExpr(measure.Expr)
+	if err != nil {
+		return nil, utils.NewCodedError(utils.ErrCodeInvalidQuery, err, "failed to parse measure %s", measure.Expr)
+	}
+	switch e := measureExpr.(type) {
+	case *expr.NumberLiteral:
+		q.nonAggregation = true
+	case *expr.Call:
+		name := strings.ToLower(e.Name)
+		aggType, ok := common.CallNameToAggType[name]
+		if name == expr.TDigestCallName {
+			// t-digests of buckets are partials of percentiles merged by brokers.
+			aggType, ok = common.Percentile, true
+		} else if aggType == common.Percentile {
+			ok = false
+		}
+		if !ok || aggType == common.Hll || len(e.Args) != 1 {
+			return nil, notImplemented("measure %s", measure.Expr)
+		}
+		q.aggType = aggType
+		switch arg := e.Args[0].(type) {
+		case *expr.Wildcard, *expr.NumberLiteral:
+			if aggType != common.Count {
+				return nil, notImplemented("measure %s", measure.Expr)
+			}
+		case *expr.VarRef:
+			if aggType != common.CountDistinct {
+				if q.measureValue, err = compileValue(table, arg); err != nil {
+					return nil, err
+				}
+				break
+			}
+			// distinct values are raw values of the column as formatted in dimensions.
+			column, err := resolveColumn(table, arg.Val)
+			if err != nil {
+				return nil, err
+			}
+			q.measureValue = func(row Row) interface{} { return row[column] }
+		default:
+			if aggType == common.CountDistinct {
+				return nil, notImplemented("measure %s", measure.Expr)
+			}
+			if q.measureValue, err = compileValue(table, arg); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, notImplemented("measure %s", measure.Expr)
+	}
+
+	for _, dim := range query.Dimensions {
+		bucketizer := dim.NumericBucketizer
+		if bucketizer.BucketWidth != 0 || bucketizer.LogBase != 0 || len(bucketizer.ManualPartitions) > 0 {
+			return nil, notImplemented("bucketized dimension %s", dim.Expr)
+		}
+		var bucket *timeBucket
+		if dim.IsTimeDimension() {
+			if bucket, err = compileTimeBucket(dim, query.Timezone); err != nil {
+				return nil, err
+			}
+			if dim.Expr == "" {
+				dim.Expr = table.schema.Columns[0].Name
+			}
+		}
+		dimExpr, err := expr.ParseExpr(dim.Expr)
+		if err != nil {
+			return nil, utils.NewCodedError(utils.ErrCodeInvalidQuery, err, "failed to parse dimension %s", dim.Expr)
+		}
+		varRef, ok := dimExpr.(*expr.VarRef)
+		if !ok {
+			return nil, notImplemented("dimension %s", dim.Expr)
+		}
+		column, err := resolveColumn(table, varRef.Val)
+		if err != nil {
+			return nil, err
+		}
+		q.dimensions = append(q.dimensions, column)
+		q.timeBuckets = append(q.timeBuckets, bucket)
+	}
+
+	// measure filters are applied as row filters since there is only one measure.
+	for _, filter := range append(append([]string(nil), query.Filters...), measure.Filters...) {
+		filterExpr, err := expr.ParseExpr(filter)
+		if err != nil {
+			return nil, utils.NewCodedError(utils.ErrCodeInvalidQuery, err, "failed to parse filter %s", filter)
+		}
+		predicate, err := compilePredicate(table, filterExpr)
+		if err != nil {
+			return nil, err
+		}
+		q.filters = append(q.filters, predicate)
+	}
+	return q, nil
+}
+
+// compileTimeBucket compiles the regular time bucket of the time dimension, in utc only.
+func compileTimeBucket(dim queryCom.Dimension, timezone string) (*timeBucket, error) {
+	if dim.TimeUnit != "" || (timezone != "" && timezone != "UTC") {
+		return nil, notImplemented("time dimension %s in time unit %s and timezone %s", dim.Expr, dim.TimeUnit, timezone)
+	}
+	bucketizer, err := queryCom.ParseRegularTimeBucketizer(dim.TimeBucketizer)
+	if err != nil {
+		return nil, notImplemented("time bucketizer %s", dim.TimeBucketizer)
+	}
+	return &timeBucket{
+		seconds: int64(bucketizer.Size * queryCom.BucketSizeToseconds[bucketizer.Unit]),
+		layout:  timeBucketLayouts[bucketizer.Unit],
+	}, nil
+}
+
+// dimensionValue returns the value of the dimension of the row as string, nil for nulls.
+func (q *compiledQuery) dimensionValue(row Row, i int) *string {
+	value := row[q.dimensions[i]]
+	if value == nil {
+		return nil
+	}
+	str := fmt.Sprint(value)
+	if bucket := q.timeBuckets[i]; bucket != nil {
+		seconds, _ := toFloat(value)
+		bucketStart := int64(seconds) - int64(seconds)%bucket.seconds
+		str = time.Unix(bucketStart, 0).UTC().Format(bucket.layout)
+	}
+	return &str
+}
+
+func notImplemented(format string, args ...interface{}) error {
+	return utils.NewCodedError(utils.ErrCodeNotImplemented, nil, "fake datanode does not support "+format, args...)
+}
+
+// resolveColumn returns the name of the column referenced by the identifier, with or without the table name.
+func resolveColumn(table *datasetTable, identifier string) (string, error) {
+	name := strings.TrimPrefix(identifier, table.schema.Name+".")
+	for _, column := range table.schema.Columns {
+		if column.Name == name && !column.Deleted {
+			return name, nil
+		}
+	}
+	return "", utils.NewCodedError(utils.ErrCodeSchemaMismatch, nil, "unknown column %s for table %s", identifier, table.schema.Name)
+}
+
+// compileValue compiles arithmetics of columns and number literals, results are float64s.
+func compileValue(table *datasetTable, e expr.Expr) (rowValue, error) {
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return compileValue(table, e.Expr)
+	case *expr.NumberLiteral:
+		return func(row Row) interface{} { return e.Val }, nil
+	case *expr.VarRef:
+		column, err := resolveColumn(table, e.Val)
+		if err != nil {
+			return nil, err
+		}
+		return func(row Row) interface{} {
+			if f, ok := toFloat(row[column]); ok {
+				return f
+			}
+			return nil
+		}, nil
+	case *expr.BinaryExpr:
+		if e.Op != expr.ADD && e.Op != expr.SUB && e.Op != expr.MUL {
+			break
+		}
+		lhs, err := compileValue(table, e.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := compileValue(table, e.RHS)
+		if err != nil {
+			return nil, err
+		}
+		op := e.Op
+		return func(row Row) interface{} {
+			l, r := lhs(row), rhs(row)
+			if l == nil || r == nil {
+				return nil
+			}
+			switch op {
+			case expr.ADD:
+				return l.(float64) + r.(float64)
+			case expr.SUB:
+				return l.(float64) - r.(float64)
+			}
+			return l.(float64) * r.(float64)
+		}, nil
+	}
+	return nil, notImplemented("measure expression %s", e)
+}
+
+func compilePredicate(table *datasetTable, e expr.Expr) (rowPredicate, error) {
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return compilePredicate(table, e.Expr)
+	case *expr.BinaryExpr:
+		switch e.Op {
+		case expr.AND, expr.OR:
+			lhs, err := compilePredicate(table, e.LHS)
+			if err != nil {
+				return nil, err
+			}
+			rhs, err := compilePredicate(table, e.RHS)
+			if err != nil {
+				return nil, err
+			}
+			if e.Op == expr.AND {
+				return func(row Row) bool { return lhs(row) && rhs(row) }, nil
+			}
+			return func(row Row) bool { return lhs(row) || rhs(row) }, nil
+		case expr.EQ, expr.NEQ, expr.LT, expr.LTE, expr.GT, expr.GTE:
+			op, varRef, literal := e.Op, e.LHS, e.RHS
+			if _, ok := varRef.(*expr.VarRef); !ok {
+				// literal on the left, e.g. 1 < fare.
+				op, varRef, literal = flipComparison(op), e.RHS, e.LHS
+			}
+			ref, ok := varRef.(*expr.VarRef)
+			if !ok {
+				break
+			}
+			value, ok := literalValue(literal)
+			if !ok {
+				break
+			}
+			column, err := resolveColumn(table, ref.Val)
+			if err != nil {
+				return nil, err
+			}
+			return func(row Row) bool {
+				cmp, ok := compareValues(row[column], value)
+				return ok && comparisonMatches(op, cmp)
+			}, nil
+		}
+	}
+	return nil, notImplemented("filter %s", e)
+}
+
+func flipComparison(op expr.Token) expr.Token {
+	switch op {
+	case expr.LT:
+		return expr.GT
+	case expr.LTE:
+		return expr.GTE
+	case expr.GT:
+		return expr.LT
+	case expr.GTE:
+		return expr.LTE
+	}
+	return op
+}
+
+func comparisonMatches(op expr.Token, cmp int) bool {
+	switch op {
+	case expr.EQ:
+		return cmp == 0
+	case expr.NEQ:
+		return cmp != 0
+	case expr.LT:
+		return cmp < 0
+	case expr.LTE:
+		return cmp <= 0
+	case expr.GT:
+		return cmp > 0
+	case expr.GTE:
+		return cmp >= 0
+	}
+	return false
+}
+
+func literalValue(e expr.Expr) (interface{}, bool) {
+	switch e := e.(type) {
+	case *expr.NumberLiteral:
+		return e.Val, true
+	case *expr.StringLiteral:
+		return e.Val, true
+	case *expr.BooleanLiteral:
+		return e.Val, true
+	}
+	return nil, false
+}
+
+// compareValues compares the value of a row with the literal, values not comparable including nulls
+// never pass filters.
+func compareValues(value, literal interface{}) (int, bool) {
+	if value == nil {
+		return 0, false
+	}
+	if f, ok := toFloat(value); ok {
+		l, ok := literal.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case f < l:
+			return -1, true
+		case f > l:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch v := value.(type) {
+	case string:
+		l, ok := literal.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(v, l), true
+	case bool:
+		l, ok := literal.(bool)
+		if !ok {
+			return 0, false
+		}
+		if v == l {
+			return 0, true
+		} else if l {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func (q *compiledQuery) matches(row Row) bool {
+	for _, filter := range q.filters {
+		if !filter(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregateGroup is the aggregation of rows with the same dimension values.
+type aggregateGroup struct {
+	dimValues []*string
+	count     int
+	// number of non null values of the measure column.
+	numValues     int
+	sum, min, max float64
+	// sum of squares of the measure column for variances.
+	sumSquares float64
+	// distinct non null values of the measure column for distinct counts.
+	distinct map[string]struct{}
+	// t-digest of the measure column for percentiles, nil for other aggregations.
+	digest *queryCom.TDigest
+}
+
+func (g *aggregateGroup) add(value interface{}) {
+	g.count++
+	if value != nil {
+		if g.distinct == nil {
+			g.distinct = make(map[string]struct{})
+		}
+		g.distinct[fmt.Sprint(value)] = struct{}{}
+	}
+	f, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	if g.numValues == 0 || f < g.min {
+		g.min = f
+	}
+	if g.numValues == 0 || f > g.max {
+		g.max = f
+	}
+	g.sum += f
+	g.sumSquares += f * f
+	if g.digest != nil {
+		g.digest.Add(f, 1)
+	}
+	g.numValues++
+}
+
+func (g *aggregateGroup) value(aggType common.AggType) *float64 {
+	var value float64
+	switch aggType {
+	case common.Count:
+		value = float64(g.count)
+	case common.Sum:
+		value = g.sum
+	case common.CountDistinct:
+		value = float64(len(g.distinct))
+	default:
+		if g.numValues == 0 {
+			return nil
+		}
+		switch aggType {
+		case common.Avg:
+			value = g.sum / float64(g.numValues)
+		case common.Min:
+			value = g.min
+		case common.Max:
+			value = g.max
+		case common.Var, common.Stddev:
+			// values of test datasets are small enough for variances from sums of squares.
+			mean := g.sum / float64(g.numValues)
+			value = math.Max(g.sumSquares/float64(g.numValues)-mean*mean, 0)
+			if aggType == common.Stddev {
+				value = math.Sqrt(value)
+			}
+		}
+	}
+	return &value
+}
+
+// aggregate returns the result of the aggregation query over the rows, results without dimensions
+// are keyed by NULL.
+func (q *compiledQuery) aggregate(rows []Row) queryCom.AQLQueryResult {
+	groups := make(map[string]*aggregateGroup)
+	var keys []string
+	for _, row := range rows {
+		if !q.matches(row) {
+			continue
+		}
+		dimValues := make([]*string, len(q.dimensions))
+		keyParts := make([]string, len(q.dimensions))
+		for i := range q.dimensions {
+			if dimValues[i] = q.dimensionValue(row, i); dimValues[i] != nil {
+				keyParts[i] = "v" + *dimValues[i]
+			}
+		}
+		key := strings.Join(keyParts, "\x00")
+		group, exist := groups[key]
+		if !exist {
+			group = &aggregateGroup{dimValues: dimValues}
+			if q.aggType == common.Percentile {
+				group.digest = queryCom.NewTDigest(queryCom.DefaultTDigestCompression)
+			}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		var value interface{}
+		if q.measureValue != nil {
+			value = q.measureValue(row)
+		}
+		group.add(value)
+	}
+
+	result := queryCom.AQLQueryResult{}
+	for _, key := range keys {
+		group := groups[key]
+		dimValues := group.dimValues
+		if len(dimValues) == 0 {
+			dimValues = []*string{nil}
+		}
+		if group.digest != nil {
+			result.SetTDigest(dimValues, group.digest)
+			continue
+		}
+		result.Set(dimValues, group.value(q.aggType))
+	}
+	return result
+}
+
+// selectRows returns dimension values of rows passing filters, as strings or nils.
+func (q *compiledQuery) selectRows(rows []Row) [][]interface{} {
+	var selected [][]interface{}
+	for _, row := range rows {
+		if !q.matches(row) {
+			continue
+		}
+		values := make([]interface{}, len(q.dimensions))
+		for i := range q.dimensions {
+			if value := q.dimensionValue(row, i); value != nil {
+				values[i] = *value
+			}
+		}
+		selected = append(selected, values)
+	}
+	return selected
+}
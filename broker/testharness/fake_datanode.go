@@ -0,0 +1,467 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testharness
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/cluster/topology"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// Fault is a fault injected into queries served by a FakeDataNode.
+type Fault struct {
+	// delay before serving the query.
+	Latency time.Duration
+	// responds the status code with a v2 error instead of results if not 0.
+	StatusCode int
+	// closes the connection after writing the number of bytes of the response if positive, the
+	// response is announced with its full length.
+	DropAfterBytes int
+	// kills the datanode while serving the query, see FakeDataNode.Kill.
+	Kill bool
+	// number of requests the fault is injected into, 0 means all requests until faults are cleared. Batched
+	// queries share the fault of their request.
+	Times int
+}
+
+// SchemaLag makes a FakeDataNode lag behind a rolling schema change of a table, queries of the columns added
+// by the change fail with schema mismatches like datanodes not refreshed schemas yet.
+type SchemaLag struct {
+	Table string
+	// schema version of the table reported by the datanode while lagging.
+	Version metaCom.TableSchemaVersion
+	// columns unknown to the datanode while lagging.
+	Columns []string
+	// number of query requests of the table the datanode lags for, 0 means until cleared. Batched queries
+	// share the lag of their request.
+	Times int
+}
+
+// FakeDataNode serves the datanode query api over rows of its shards in memory. Queries without
+// shards are served from all shards owned by the datanode like real datanodes.
+type FakeDataNode struct {
+	sync.Mutex
+
+	host    topology.Host
+	server  *httptest.Server
+	dataset *dataset
+	shards  []uint32
+
+	faults  []Fault
+	queries []queryCom.AQLQuery
+	// number of query requests received, batched queries share a request.
+	requests int
+	// request ids forwarded by broker with query requests.
+	requestIDs []string
+	// number of query requests canceled by broker while waiting on injected latency.
+	canceledRequests int
+	// schema versions overriding versions of tables in the dataset.
+	schemaVersions map[string]metaCom.TableSchemaVersion
+	// schema lag of the datanode, nil if not lagging.
+	schemaLag *SchemaLag
+	killed    bool
+	stopChan  chan struct{}
+}
+
+// newFakeDataNode starts the datanode owning the shards, the host id is required for the address
+// is only known after the server is started.
+func newFakeDataNode(id string, ds *dataset, shards []uint32) *FakeDataNode {
+	node := &FakeDataNode{
+		dataset:        ds,
+		shards:         shards,
+		schemaVersions: make(map[string]metaCom.TableSchemaVersion),
+		stopChan:       make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/query/aql", node.handleQuery)
+	mux.HandleFunc("/schema/versions", node.handleSchemaVersions)
+	node.server = httptest.NewServer(mux)
+	node.host = topology.NewHost(id, node.server.URL)
+	return node
+}
+
+// Host returns the host of the datanode in the topology.
+func (n *FakeDataNode) Host() topology.Host {
+	return n.host
+}
+
+// Shards returns ids of shards owned by the datanode.
+func (n *FakeDataNode) Shards() []uint32 {
+	return n.shards
+}
+
+// InjectFault injects the fault into following queries, after faults injected earlier are used up.
+func (n *FakeDataNode) InjectFault(fault Fault) {
+	n.Lock()
+	defer n.Unlock()
+	n.faults = append(n.faults, fault)
+}
+
+// ClearFaults removes all injected faults.
+func (n *FakeDataNode) ClearFaults() {
+	n.Lock()
+	defer n.Unlock()
+	n.faults = nil
+}
+
+// SetSchemaVersion sets the schema version of the table reported by the datanode, e.g. to be excluded
+// from queries by broker for stale schemas.
+func (n *FakeDataNode) SetSchemaVersion(table string, version metaCom.TableSchemaVersion) {
+	n.Lock()
+	defer n.Unlock()
+	n.schemaVersions[table] = version
+}
+
+// LagSchema makes the datanode lag behind the schema change of the table, nil to catch up.
+func (n *FakeDataNode) LagSchema(lag *SchemaLag) {
+	n.Lock()
+	defer n.Unlock()
+	n.schemaLag = lag
+}
+
+// Queries returns queries received by the datanode, including failed ones.
+func (n *FakeDataNode) Queries() []queryCom.AQLQuery {
+	n.Lock()
+	defer n.Unlock()
+	return append([]queryCom.AQLQuery(nil), n.queries...)
+}
+
+// Requests returns the number of query requests received by the datanode, batched queries are sent in a
+// single request.
+func (n *FakeDataNode) Requests() int {
+	n.Lock()
+	defer n.Unlock()
+	return n.requests
+}
+
+// RequestIDs returns request ids forwarded by broker with query requests in the order received.
+func (n *FakeDataNode) RequestIDs() []string {
+	n.Lock()
+	defer n.Unlock()
+	return append([]string(nil), n.requestIDs...)
+}
+
+// Kill closes all connections to the datanode and stops accepting new ones, queries in flight and
+// following queries fail to connect.
+func (n *FakeDataNode) Kill() {
+	n.Lock()
+	if n.killed {
+		n.Unlock()
+		return
+	}
+	n.killed = true
+	close(n.stopChan)
+	n.Unlock()
+
+	n.server.CloseClientConnections()
+	n.server.Listener.Close()
+}
+
+// Close shuts down the datanode.
+func (n *FakeDataNode) Close() {
+	n.Kill()
+	n.server.Close()
+}
+
+// CanceledRequests returns the number of query requests canceled by broker while waiting on injected latency.
+func (n *FakeDataNode) CanceledRequests() int {
+	n.Lock()
+	defer n.Unlock()
+	return n.canceledRequests
+}
+
+// nextFault records the request id and queries of a request and returns the fault to inject into the request.
+func (n *FakeDataNode) nextFault(requestID string, queries ...queryCom.AQLQuery) (fault Fault) {
+	n.Lock()
+	defer n.Unlock()
+	n.queries = append(n.queries, queries...)
+	n.requests++
+	n.requestIDs = append(n.requestIDs, requestID)
+	if len(n.faults) == 0 {
+		return
+	}
+	fault = n.faults[0]
+	if n.faults[0].Times > 0 {
+		n.faults[0].Times--
+		if n.faults[0].Times == 0 {
+			n.faults = n.faults[1:]
+		}
+	}
+	return
+}
+
+func (n *FakeDataNode) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Queries []queryCom.AQLQuery `json:"queries"`
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(bs, &body)
+	}
+	if err != nil || len(body.Queries) == 0 || r.URL.Query().Get("dataonly") != "1" {
+		apiCom.RespondWithV2Error(w, apiCom.QueryMetadataV2{},
+			utils.NewCodedError(utils.ErrCodeBadRequest, err, "expect queries with dataonly"))
+		return
+	}
+	query := body.Queries[0]
+
+	fault := n.nextFault(r.Header.Get(utils.HTTPRequestIDHeaderKey), body.Queries...)
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			n.Lock()
+			n.canceledRequests++
+			n.Unlock()
+			return
+		case <-n.stopChan:
+		}
+	}
+	if fault.Kill {
+		n.Kill()
+		return
+	}
+	if fault.StatusCode != 0 {
+		apiCom.RespondJSONObjectWithCode(w, fault.StatusCode, apiCom.QueryResponseV2{
+			Error: apiCom.NewQueryErrorV2(utils.NewCodedError(utils.ErrorCodeFromHTTPStatus(fault.StatusCode), nil,
+				"injected fault of datanode %s", n.host.ID())),
+		})
+		return
+	}
+	if r.Header.Get(utils.HTTPAcceptTypeHeaderKey) == utils.HTTPContentTypeHyperLogLog {
+		apiCom.RespondWithV2Error(w, apiCom.QueryMetadataV2{}, notImplemented("hll responses"))
+		return
+	}
+
+	metadata := apiCom.NewQueryMetadataV2(r)
+	table := n.schemaOf(query.Table)
+	if table != nil {
+		metadata.SchemaVersion = metaCom.TableSchemaVersion{Incarnation: table.schema.Incarnation, Version: table.schema.Version}.String()
+	}
+	if len(body.Queries) > 1 {
+		n.executeBatch(w, body.Queries, table, metadata)
+		return
+	}
+	bs, err = n.execute(table, query, &metadata)
+	if err != nil {
+		apiCom.RespondWithV2Error(w, metadata, err)
+		return
+	}
+	metadata.WriteHeaders(w)
+	if fault.DropAfterBytes > 0 && fault.DropAfterBytes < len(bs) {
+		n.writeAndDrop(w, bs, fault.DropAfterBytes)
+		return
+	}
+	w.Header().Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+	w.Write(bs)
+}
+
+// executeBatch responds results of the aggregation queries by query index in the v2 envelope, errors of failed
+// queries are reported by query index as well, with the most severe one as the error of the response. Queries
+// of the main table of the batch are executed against the table as known to the datanode for the request.
+func (n *FakeDataNode) executeBatch(w http.ResponseWriter, queries []queryCom.AQLQuery, table *datasetTable, metadata apiCom.QueryMetadataV2) {
+	response := apiCom.QueryResponseV2{
+		Results: make([]interface{}, len(queries)),
+		Errors:  make([]*apiCom.QueryErrorV2, len(queries)),
+	}
+	statusCode := http.StatusOK
+	for i, query := range queries {
+		queryMetadata := apiCom.QueryMetadataV2{}
+		queryTable := table
+		if query.Table != queries[0].Table {
+			queryTable = n.schemaOf(query.Table)
+		}
+		bs, err := n.execute(queryTable, query, &queryMetadata)
+		var result struct {
+			Results []json.RawMessage `json:"results"`
+		}
+		if err == nil && json.Unmarshal(bs, &result) != nil {
+			err = utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "non aggregation queries can not be batched")
+		}
+		if err != nil {
+			response.Errors[i] = apiCom.NewQueryErrorV2(err)
+			if code := utils.GetErrorCodeInfo(response.Errors[i].Code).HTTPStatus; code > statusCode {
+				statusCode = code
+				response.Error = response.Errors[i]
+			}
+			continue
+		}
+		response.Results[i] = result.Results[0]
+		metadata.UpdateDataFreshness(queryMetadata.DataFreshness)
+		metadata.Stats.RowsScanned += queryMetadata.Stats.RowsScanned
+	}
+	response.Metadata = metadata
+	apiCom.RespondV2(w, response)
+}
+
+// schemaOf returns the table as known to the datanode, without columns unknown to it while lagging behind
+// schema changes and with the schema version it reports, nil if the table does not exist. Each call consumes
+// a query request of the schema lag.
+func (n *FakeDataNode) schemaOf(name string) *datasetTable {
+	table, exist := n.dataset.tables[name]
+	if !exist {
+		return nil
+	}
+	n.Lock()
+	defer n.Unlock()
+	version, overridden := n.schemaVersions[name]
+	lag := n.schemaLag
+	if lag != nil && lag.Table == name {
+		if lag.Times > 0 {
+			lag.Times--
+			if lag.Times == 0 {
+				n.schemaLag = nil
+			}
+		}
+		version, overridden = lag.Version, true
+	} else {
+		lag = nil
+	}
+	if !overridden {
+		return table
+	}
+	lagging := *table
+	lagging.schema.Incarnation, lagging.schema.Version = version.Incarnation, version.Version
+	if lag != nil {
+		lagging.schema.Columns = make([]metaCom.Column, len(table.schema.Columns))
+		copy(lagging.schema.Columns, table.schema.Columns)
+		for i, column := range lagging.schema.Columns {
+			for _, unknown := range lag.Columns {
+				if column.Name == unknown {
+					lagging.schema.Columns[i].Deleted = true
+				}
+			}
+		}
+	}
+	return &lagging
+}
+
+// execute returns the response of the query against the table known to the datanode, in the v2 envelope for
+// aggregation queries, or comma separated rows for non aggregation queries. The data freshness of shards
+// queried is set into the metadata, with rows scanned for aggregation queries only, since non aggregation
+// queries are eager flushed.
+func (n *FakeDataNode) execute(table *datasetTable, query queryCom.AQLQuery, metadata *apiCom.QueryMetadataV2) (bs []byte, err error) {
+	if table == nil {
+		err = utils.NewCodedError(utils.ErrCodeSchemaMismatch, nil, "unknown main table %s", query.Table)
+		return
+	}
+	compiled, err := compileQuery(table, query)
+	if err != nil {
+		return
+	}
+
+	shards := n.shards
+	if len(query.Shards) > 0 {
+		shards = make([]uint32, len(query.Shards))
+		for i, shardID := range query.Shards {
+			if !n.ownsShard(uint32(shardID)) {
+				err = utils.NewCodedError(utils.ErrCodeShardNotOwned, nil,
+					"shard %d is not owned by datanode %s", shardID, n.host.ID())
+				return
+			}
+			shards[i] = uint32(shardID)
+		}
+	}
+	rows := table.rows(shards)
+	metadata.UpdateDataFreshness(table.dataFreshness(shards))
+
+	if !compiled.nonAggregation {
+		metadata.Stats.RowsScanned = int64(len(rows))
+		bs, err = json.Marshal(apiCom.QueryResponseV2{
+			Results: []interface{}{compiled.aggregate(rows)},
+		})
+		return
+	}
+
+	selected := compiled.selectRows(rows)
+	if query.Offset >= len(selected) {
+		selected = nil
+	} else {
+		selected = selected[query.Offset:]
+	}
+	limited := query.Limit >= 0 && len(selected) >= query.Limit
+	if limited {
+		selected = selected[:query.Limit]
+	}
+	var buffer bytes.Buffer
+	for i, row := range selected {
+		var rowBytes []byte
+		if rowBytes, err = json.Marshal(row); err != nil {
+			return
+		}
+		buffer.Write(rowBytes)
+		// datanodes leave a trailing comma when running out of rows before the limit.
+		if !limited || i < len(selected)-1 {
+			buffer.WriteByte(',')
+		}
+	}
+	bs = buffer.Bytes()
+	return
+}
+
+func (n *FakeDataNode) ownsShard(shardID uint32) bool {
+	for _, owned := range n.shards {
+		if owned == shardID {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAndDrop writes the first numBytes of the response announced with its full length, and closes
+// the connection.
+func (n *FakeDataNode) writeAndDrop(w http.ResponseWriter, bs []byte, numBytes int) {
+	w.Header().Set(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+	w.Header().Set("Content-Length", strconv.Itoa(len(bs)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(bs[:numBytes])
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	// the written bytes are flushed before the connection is hijacked.
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
+
+func (n *FakeDataNode) handleSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	n.Lock()
+	defer n.Unlock()
+	versions := make(map[string]metaCom.TableSchemaVersion)
+	for name, table := range n.dataset.tables {
+		versions[name] = metaCom.TableSchemaVersion{
+			Incarnation: table.schema.Incarnation,
+			Version:     table.schema.Version,
+		}
+	}
+	for name, version := range n.schemaVersions {
+		versions[name] = version
+	}
+	apiCom.Respond(w, versions)
+}
@@ -0,0 +1,283 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testharness
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+var _ = ginkgo.Describe("agg query plan end to end", func() {
+	var cluster *Cluster
+
+	ginkgo.AfterEach(func() {
+		cluster.Close()
+	})
+
+	// checkQuery checks the query returns the same result as querying all rows in one place.
+	checkQuery := func(query queryCom.AQLQuery) queryCom.AQLQueryResult {
+		expected, err := cluster.ExpectedResult(query)
+		Ω(err).Should(BeNil())
+		response, err := cluster.Query(query)
+		Ω(err).Should(BeNil())
+		Ω(response.Error).Should(BeNil())
+		Ω(response.Result).Should(Equal(expected))
+		return response.Result
+	}
+
+	for _, topo := range []ClusterConfig{
+		{NumDataNodes: 1, NumShards: 1},
+		{NumDataNodes: 3, NumShards: 6, Replicas: 2},
+		{NumDataNodes: 2, NumShards: 1, Replicas: 2},
+		{NumDataNodes: 4, NumShards: 3},
+	} {
+		cfg := topo
+		cfg.Tables = []Table{tripsTable(200)}
+
+		ginkgo.Context("with the topology", func() {
+			ginkgo.BeforeEach(func() {
+				var err error
+				cluster, err = NewCluster(cfg)
+				Ω(err).Should(BeNil())
+			})
+
+			ginkgo.It("should merge aggregations of datanodes", func() {
+				for _, measure := range []string{"count(*)", "sum(fare)", "min(fare)", "max(fare)"} {
+					checkQuery(queryCom.AQLQuery{
+						Table:      "trips",
+						Dimensions: []queryCom.Dimension{{Expr: "city_id"}, {Expr: "status"}},
+						Measures:   []queryCom.Measure{{Expr: measure}},
+					})
+				}
+				// broker averages sums over counts of all rows, rows with null fares are filtered out.
+				checkQuery(queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "city_id"}, {Expr: "status"}},
+					Measures:   []queryCom.Measure{{Expr: "avg(fare)", Filters: []string{"fare >= 0"}}},
+				})
+
+				result := checkQuery(queryCom.AQLQuery{
+					Table:    "trips",
+					Measures: []queryCom.Measure{{Expr: "count(*)"}},
+				})
+				Ω(result).Should(Equal(queryCom.AQLQueryResult{"NULL": 200.0}))
+			})
+
+			ginkgo.It("should merge variances of datanodes", func() {
+				// variances are merged from moments of datanodes, which may differ from a single node in
+				// rounding errors.
+				for _, measure := range []string{"var(fare)", "stddev(fare)"} {
+					query := queryCom.AQLQuery{
+						Table:      "trips",
+						Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+						Measures:   []queryCom.Measure{{Expr: measure, Filters: []string{"fare >= 0"}}},
+					}
+					expected, err := cluster.ExpectedResult(query)
+					Ω(err).Should(BeNil())
+					response, err := cluster.Query(query)
+					Ω(err).Should(BeNil())
+					Ω(response.Error).Should(BeNil())
+					Ω(expected).ShouldNot(BeEmpty())
+					Ω(response.Result).Should(HaveLen(len(expected)))
+					for city, value := range expected {
+						Ω(response.Result).Should(HaveKey(city))
+						Ω(response.Result[city]).Should(BeNumerically("~", value, 1e-6))
+					}
+				}
+			})
+
+			ginkgo.It("should merge distinct counts of datanodes", func() {
+				for _, measure := range []string{"countdistinct(fare)", "countdistinct(status)"} {
+					checkQuery(queryCom.AQLQuery{
+						Table:      "trips",
+						Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+						Measures:   []queryCom.Measure{{Expr: measure}},
+					})
+				}
+				result := checkQuery(queryCom.AQLQuery{
+					Table:    "trips",
+					Measures: []queryCom.Measure{{Expr: "countdistinct(fare)"}},
+				})
+				Ω(result).Should(Equal(queryCom.AQLQueryResult{"NULL": 10.0}))
+
+				cluster.Close()
+				limited := cfg
+				limited.CountDistinct.MaxValuesPerBucket = 5
+				var err error
+				cluster, err = NewCluster(limited)
+				Ω(err).Should(BeNil())
+				response, err := cluster.Query(queryCom.AQLQuery{
+					Table:    "trips",
+					Measures: []queryCom.Measure{{Expr: "countdistinct(fare)"}},
+				})
+				Ω(err).Should(BeNil())
+				Ω(response.Error).ShouldNot(BeNil())
+				Ω(response.Error.Message).Should(ContainSubstring("more than 5 distinct values"))
+			})
+
+			ginkgo.It("should merge percentiles of datanodes", func() {
+				// fares of rows by city, the same as querying all rows on a single node.
+				fares := map[string][]float64{}
+				for _, row := range cfg.Tables[0].Rows {
+					if fare, ok := row["fare"].(float64); ok {
+						city := fmt.Sprint(row["city_id"])
+						fares[city] = append(fares[city], fare)
+					}
+				}
+				quantiles := []float64{0.1, 0.5, 0.9}
+				response, err := cluster.Query(queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+					Measures:   []queryCom.Measure{{Expr: "percentile(fare, 0.1, 0.5, 0.9)"}},
+				})
+				Ω(err).Should(BeNil())
+				Ω(response.Error).Should(BeNil())
+				// fares of city 3 are all nulls.
+				Ω(response.Result).Should(HaveLen(len(fares) + 1))
+				Ω(response.Result).Should(HaveKeyWithValue("3", BeNil()))
+				for city, values := range fares {
+					sort.Float64s(values)
+					Ω(response.Result).Should(HaveKey(city))
+					Ω(response.Result[city]).Should(HaveLen(len(quantiles)))
+					for i, q := range quantiles {
+						value := response.Result[city].([]interface{})[i].(float64)
+						// ranks of the value are within the rank error of the digest, values may repeat.
+						below := sort.SearchFloat64s(values, value)
+						atOrBelow := sort.SearchFloat64s(values, math.Nextafter(value, math.Inf(1)))
+						Ω(q).Should(BeNumerically(">=", float64(below)/float64(len(values))-0.02), "city %s quantile %v", city, q)
+						Ω(q).Should(BeNumerically("<=", float64(atOrBelow)/float64(len(values))+0.02), "city %s quantile %v", city, q)
+					}
+				}
+
+				response, err = cluster.Query(queryCom.AQLQuery{
+					Table:    "trips",
+					Measures: []queryCom.Measure{{Expr: "percentile(fare, 0, 1)"}},
+				})
+				Ω(err).Should(BeNil())
+				Ω(response.Error).Should(BeNil())
+				Ω(response.Result).Should(Equal(queryCom.AQLQueryResult{"NULL": []interface{}{1.0, 10.0}}))
+			})
+
+			ginkgo.It("should merge multiple measures of datanodes", func() {
+				measures := []queryCom.Measure{{Expr: "sum(fare)"}, {Expr: "max(fare)"}, {Expr: "count(*)"}}
+				query := queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+					Measures:   measures,
+				}
+				response, err := cluster.Query(query)
+				Ω(err).Should(BeNil())
+				Ω(response.Error).Should(BeNil())
+
+				// values of each measure are the same as querying the measure alone.
+				for i, measure := range measures {
+					query.Measures = []queryCom.Measure{measure}
+					expected, err := cluster.ExpectedResult(query)
+					Ω(err).Should(BeNil())
+					Ω(expected).ShouldNot(BeEmpty())
+					Ω(response.Result).Should(HaveLen(len(expected)))
+					for city, value := range expected {
+						Ω(response.Result).Should(HaveKey(city))
+						Ω(response.Result[city]).Should(HaveLen(len(measures)))
+						if value == nil {
+							Ω(response.Result[city].([]interface{})[i]).Should(BeNil())
+						} else {
+							Ω(response.Result[city].([]interface{})[i]).Should(Equal(value))
+						}
+					}
+				}
+			})
+
+			ginkgo.It("should apply filters and measure filters", func() {
+				checkQuery(queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "city_id"}},
+					Measures: []queryCom.Measure{
+						{Expr: "sum(fare)", Filters: []string{"status = 'completed'"}},
+					},
+					Filters: []string{"fare >= 3 AND (city_id = 1 OR city_id = 2)"},
+				})
+
+				// nulls are grouped and max of nulls is null.
+				result := checkQuery(queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "fare"}},
+					Measures:   []queryCom.Measure{{Expr: "max(fare)"}},
+					Filters:    []string{"city_id > 2"},
+				})
+				Ω(result).Should(Equal(queryCom.AQLQueryResult{"NULL": nil}))
+			})
+
+			ginkgo.It("should report completeness of time buckets if requested", func() {
+				query := queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "request_at", TimeBucketizer: "hour"}, {Expr: "city_id"}},
+					Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+				}
+				response, err := cluster.Query(query)
+				Ω(err).Should(BeNil())
+				Ω(response.Metadata.BucketCompleteness).Should(BeNil())
+
+				query.BucketCompleteness = true
+				result := checkQuery(query)
+				response, err = cluster.Query(query)
+				Ω(err).Should(BeNil())
+				freshness := cluster.DataFreshness("trips")
+				Ω(response.Metadata.DataFreshness).Should(Equal(freshness))
+
+				completeness := response.Metadata.BucketCompleteness
+				Ω(completeness).Should(HaveLen(len(result)))
+				for key := range result {
+					start, err := time.Parse("2006-01-02 15:00", key)
+					Ω(err).Should(BeNil())
+					Ω(completeness[key]).Should(Equal(start.Add(time.Hour).Unix() <= freshness), key)
+				}
+				// the last hour is still filling.
+				Ω(completeness).Should(ContainElement(true))
+				Ω(completeness).Should(ContainElement(false))
+			})
+
+			ginkgo.It("should record stats of queries by fingerprint", func() {
+				for _, cityID := range []string{"1", "2"} {
+					query := queryCom.AQLQuery{
+						Table:      "trips",
+						Dimensions: []queryCom.Dimension{{Expr: "status"}},
+						Measures:   []queryCom.Measure{{Expr: "count(*)"}},
+						Filters:    []string{"city_id = " + cityID},
+					}
+					response, err := cluster.Query(query)
+					Ω(err).Should(BeNil())
+					// every shard is scanned once.
+					Ω(response.Metadata.Stats.RowsScanned).Should(BeEquivalentTo(200))
+				}
+
+				stats, err := cluster.QueryStats.Top(broker.QueryStatsSortByCount, 0)
+				Ω(err).Should(BeNil())
+				Ω(stats).Should(HaveLen(1))
+				Ω(stats[0].Count).Should(BeEquivalentTo(2))
+				Ω(stats[0].Errors).Should(BeEquivalentTo(0))
+				Ω(stats[0].RowsScanned).Should(BeEquivalentTo(400))
+				Ω(stats[0].BytesReturned).Should(BeNumerically(">", 0))
+			})
+		})
+	}
+})
@@ -0,0 +1,230 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testharness
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+var _ = ginkgo.Describe("non agg query plan end to end", func() {
+	var cluster *Cluster
+
+	ginkgo.AfterEach(func() {
+		cluster.Close()
+	})
+
+	tripsQuery := func(limit int, filters ...string) queryCom.AQLQuery {
+		return queryCom.AQLQuery{
+			Table:      "trips",
+			Dimensions: []queryCom.Dimension{{Expr: "trip_id"}, {Expr: "status"}, {Expr: "fare"}},
+			Measures:   []queryCom.Measure{{Expr: "1"}},
+			Filters:    filters,
+			Limit:      limit,
+		}
+	}
+
+	for _, topo := range []ClusterConfig{
+		{NumDataNodes: 1, NumShards: 1},
+		{NumDataNodes: 3, NumShards: 6, Replicas: 2},
+		{NumDataNodes: 2, NumShards: 1, Replicas: 2},
+		{NumDataNodes: 4, NumShards: 3},
+	} {
+		cfg := topo
+		cfg.Tables = []Table{tripsTable(50)}
+
+		ginkgo.Context("with the topology", func() {
+			ginkgo.BeforeEach(func() {
+				var err error
+				cluster, err = NewCluster(cfg)
+				Ω(err).Should(BeNil())
+			})
+
+			ginkgo.It("should return rows of all datanodes without limit", func() {
+				for _, query := range []queryCom.AQLQuery{tripsQuery(-1), tripsQuery(-1, "city_id = 3"), tripsQuery(-1, "trip_id > 1000")} {
+					expected, err := cluster.ExpectedRows(query)
+					Ω(err).Should(BeNil())
+					response, err := cluster.Query(query)
+					Ω(err).Should(BeNil())
+					Ω(response.Error).Should(BeNil())
+					Ω(response.Result[queryCom.HeadersKey]).Should(Equal([]interface{}{"trip_id", "status", "fare"}))
+					Ω(response.Rows()).Should(ConsistOf(expected))
+				}
+			})
+
+			ginkgo.It("should limit rows of datanodes", func() {
+				expected, err := cluster.ExpectedRows(tripsQuery(-1, "city_id != 0"))
+				Ω(err).Should(BeNil())
+				for _, limit := range []int{1, 10, 37, 100} {
+					response, err := cluster.Query(tripsQuery(limit, "city_id != 0"))
+					Ω(err).Should(BeNil())
+					Ω(response.Error).Should(BeNil())
+					rows := response.Rows()
+					if limit < len(expected) {
+						Ω(rows).Should(HaveLen(limit))
+					} else {
+						Ω(rows).Should(HaveLen(len(expected)))
+					}
+					for _, row := range rows {
+						Ω(expected).Should(ContainElement(row))
+					}
+				}
+
+				// all columns by default limit.
+				response, err := cluster.Query(queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "*"}},
+					Measures:   []queryCom.Measure{{Expr: "1"}},
+				})
+				Ω(err).Should(BeNil())
+				Ω(response.Result[queryCom.HeadersKey]).Should(HaveLen(5))
+				Ω(response.Rows()).Should(HaveLen(50))
+			})
+
+			ginkgo.It("should merge rows of datanodes by sorts", func() {
+				query := queryCom.AQLQuery{
+					Table:      "trips",
+					Dimensions: []queryCom.Dimension{{Expr: "trip_id"}, {Expr: "city_id", Alias: "city"}, {Expr: "fare"}},
+					Measures:   []queryCom.Measure{{Expr: "1"}},
+					Limit:      -1,
+				}
+				expected, err := cluster.ExpectedRows(query)
+				Ω(err).Should(BeNil())
+				number := func(value interface{}) float64 {
+					if value == nil {
+						return -1
+					}
+					f, err := strconv.ParseFloat(value.(string), 64)
+					Ω(err).Should(BeNil())
+					return f
+				}
+				// by city ascending, fare descending with nulls last and trip_id ascending.
+				sort.Slice(expected, func(i, j int) bool {
+					for k, descending := range []bool{false, true, false} {
+						index := []int{1, 2, 0}[k]
+						a, b := number(expected[i][index]), number(expected[j][index])
+						if a != b {
+							return (a < b) != descending
+						}
+					}
+					return false
+				})
+
+				query.Sorts = []queryCom.SortField{{Name: "city"}, {Name: "fare", Order: "desc"}, {Name: "trip_id", Order: "asc"}}
+				for _, limit := range []int{-1, 1, 10, 37} {
+					query.Limit = limit
+					response, err := cluster.Query(query)
+					Ω(err).Should(BeNil())
+					Ω(response.Error).Should(BeNil())
+					if limit < 0 {
+						Ω(response.Rows()).Should(Equal(expected))
+					} else {
+						Ω(response.Rows()).Should(Equal(expected[:limit]))
+					}
+				}
+			})
+
+			ginkgo.It("should skip rows of datanodes by the offset", func() {
+				expected, err := cluster.ExpectedRows(tripsQuery(-1, "city_id != 0"))
+				Ω(err).Should(BeNil())
+				for _, tc := range []struct{ limit, offset int }{{-1, 5}, {10, 0}, {10, 20}, {10, 35}, {100, 37}} {
+					query := tripsQuery(tc.limit, "city_id != 0")
+					query.Offset = tc.offset
+					response, err := cluster.Query(query)
+					Ω(err).Should(BeNil())
+					Ω(response.Error).Should(BeNil())
+					numRows := len(expected) - tc.offset
+					if numRows < 0 {
+						numRows = 0
+					}
+					if tc.limit >= 0 && tc.limit < numRows {
+						numRows = tc.limit
+					}
+					rows := response.Rows()
+					Ω(rows).Should(HaveLen(numRows))
+					for _, row := range rows {
+						Ω(expected).Should(ContainElement(row))
+					}
+				}
+
+				// pages of sorted rows.
+				query := tripsQuery(-1)
+				query.Sorts = []queryCom.SortField{{Name: "trip_id", Order: "desc"}}
+				var rows [][]interface{}
+				for offset := 0; offset < 50; offset += 15 {
+					query.Limit, query.Offset = 15, offset
+					response, err := cluster.Query(query)
+					Ω(err).Should(BeNil())
+					Ω(response.Error).Should(BeNil())
+					rows = append(rows, response.Rows()...)
+				}
+				Ω(rows).Should(HaveLen(50))
+				for i, row := range rows {
+					Ω(row[0]).Should(Equal(strconv.Itoa(49 - i)))
+				}
+			})
+
+			ginkgo.It("should paginate rows of datanodes", func() {
+				query := tripsQuery(0)
+				expected, err := cluster.ExpectedRows(query)
+				Ω(err).Should(BeNil())
+				for _, pageSize := range []int{1, 7, 50, 100} {
+					rows, numPages, err := cluster.QueryAllPages(query, pageSize)
+					Ω(err).Should(BeNil())
+					Ω(rows).Should(ConsistOf(expected))
+					Ω(numPages).Should(Equal(len(expected)/pageSize + 1))
+				}
+
+				// failed pages can be retried with the same cursor.
+				allRows, _, err := cluster.QueryAllPages(query, 20)
+				Ω(err).Should(BeNil())
+				response, err := cluster.QueryPage(query, 20, "")
+				Ω(err).Should(BeNil())
+				cursor := response.Cursor()
+				Ω(cursor).ShouldNot(BeEmpty())
+				for _, node := range cluster.DataNodes {
+					node.InjectFault(Fault{StatusCode: http.StatusInternalServerError, Times: 2})
+				}
+				failed, err := cluster.QueryPage(query, 20, cursor)
+				Ω(err).Should(BeNil())
+				Ω(failed.Error).ShouldNot(BeNil())
+				for _, node := range cluster.DataNodes {
+					node.ClearFaults()
+				}
+				next, err := cluster.QueryPage(query, 20, cursor)
+				Ω(err).Should(BeNil())
+				Ω(next.Error).Should(BeNil())
+				Ω(append(response.Rows(), next.Rows()...)).Should(Equal(allRows[:40]))
+			})
+
+			ginkgo.It("should retry datanodes dropping connections", func() {
+				// retried on the same datanode without replicas, otherwise on its replica.
+				cluster.DataNodes[0].InjectFault(Fault{DropAfterBytes: 10, Times: 1})
+				query := tripsQuery(-1)
+				expected, err := cluster.ExpectedRows(query)
+				Ω(err).Should(BeNil())
+				response, err := cluster.Query(query)
+				Ω(err).Should(BeNil())
+				Ω(response.Error).Should(BeNil())
+				Ω(response.Rows()).Should(ConsistOf(expected))
+			})
+		})
+	}
+})
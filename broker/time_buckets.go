@@ -0,0 +1,378 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/aresdb/broker/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// layouts of keys of regular time buckets by bucket units, see formatTimeDimension of query/common.
+var regularTimeBucketLayouts = map[string]string{
+	"m": "2006-01-02 15:04",
+	"h": "2006-01-02 15:00",
+	"d": "2006-01-02",
+}
+
+// calendar lengths of irregular time buckets in years, months and days, see formatTimeDimension of query/common.
+var irregularTimeBucketLengths = map[string][3]int{
+	"week":    {0, 0, 7},
+	"month":   {0, 1, 0},
+	"quarter": {0, 3, 0},
+	"year":    {1, 0, 0},
+}
+
+// number of seconds of time units of time dimensions.
+var timeUnitSeconds = map[string]int64{
+	"day":    queryCom.SecondsPerDay,
+	"hour":   queryCom.SecondsPerHour,
+	"minute": queryCom.SecondsPerMinute,
+}
+
+// maxFilledTimeBuckets is the max number of top level time buckets of results filled with missing buckets,
+// results spanning more buckets are not filled.
+const maxFilledTimeBuckets = 10000
+
+type timeBucketsKey struct{}
+
+// timeBuckets collects ends of top level time buckets of the aggregation query result by bucket keys, to tell
+// completeness of buckets with the data freshness of datanodes queried.
+type timeBuckets struct {
+	ends map[string]int64
+}
+
+// withTimeBuckets returns a context to collect time buckets of the query executed with it.
+func withTimeBuckets(ctx context.Context) (context.Context, *timeBuckets) {
+	buckets := &timeBuckets{}
+	return context.WithValue(ctx, timeBucketsKey{}, buckets), buckets
+}
+
+// collectTimeBuckets collects top level time buckets of the result into the context if requested by the query,
+// buckets are only collected for queries grouped by time buckets on the timeline first, in a fixed timezone.
+func collectTimeBuckets(ctx context.Context, aql *queryCom.AQLQuery, result queryCom.AQLQueryResult) {
+	buckets, ok := ctx.Value(timeBucketsKey{}).(*timeBuckets)
+	if !ok || !aql.BucketCompleteness || len(aql.Dimensions) == 0 {
+		return
+	}
+	loc, err := queryCom.ParseTimezone(aql.Timezone)
+	if err != nil {
+		// timezones by columns, e.g. timezone(city_id).
+		return
+	}
+	bucketEnd := timeBucketEndFunc(aql.Dimensions[0], loc)
+	if bucketEnd == nil {
+		return
+	}
+	buckets.ends = make(map[string]int64, len(result))
+	for key := range result {
+		if end, err := bucketEnd(key); err == nil {
+			buckets.ends[key] = end.Unix()
+		}
+	}
+}
+
+// completeness returns whether each bucket ends before the freshness by bucket keys, nil if no buckets are
+// collected. Buckets are incomplete if the freshness is unknown.
+func (b *timeBuckets) completeness(freshness int64) map[string]bool {
+	if b.ends == nil {
+		return nil
+	}
+	completeness := make(map[string]bool, len(b.ends))
+	for key, end := range b.ends {
+		completeness[key] = freshness > 0 && end <= freshness
+	}
+	return completeness
+}
+
+// timeBucketTimeline converts between keys and starts of buckets of a time dimension on the timeline.
+type timeBucketTimeline struct {
+	// start returns the start of the bucket by its key.
+	start func(key string) (time.Time, error)
+	// key returns the key of the bucket by its start.
+	key func(start time.Time) string
+	// offset returns the start of the bucket n buckets after the bucket starting at start.
+	offset func(start time.Time, n int) time.Time
+}
+
+// newTimeBucketTimeline returns the timeline of buckets of the time dimension, nil if buckets are not on the
+// timeline, e.g. recurring buckets like hour of day.
+func newTimeBucketTimeline(dim queryCom.Dimension, loc *time.Location) *timeBucketTimeline {
+	if dim.TimeBucketizer == "" {
+		return nil
+	}
+
+	timeline := &timeBucketTimeline{}
+	if bucketizer, err := queryCom.ParseRegularTimeBucketizer(dim.TimeBucketizer); err == nil {
+		layout := regularTimeBucketLayouts[bucketizer.Unit]
+		timeline.start = func(key string) (time.Time, error) {
+			// keys are formatted in wall clock of the timezone.
+			return time.ParseInLocation(layout, key, loc)
+		}
+		timeline.key = func(start time.Time) string {
+			return start.In(loc).Format(layout)
+		}
+		timeline.offset = func(start time.Time, n int) time.Time {
+			if bucketizer.Unit == "d" {
+				return start.AddDate(0, 0, n*bucketizer.Size)
+			}
+			return start.Add(time.Duration(n*bucketizer.Size*queryCom.BucketSizeToseconds[bucketizer.Unit]) * time.Second)
+		}
+	} else if length, ok := irregularTimeBucketLengths[dim.TimeBucketizer]; ok {
+		timeline.start = func(key string) (time.Time, error) {
+			seconds, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			// keys are unix seconds of wall clock of the timezone.
+			wallClock := time.Unix(seconds, 0).UTC()
+			return time.Date(wallClock.Year(), wallClock.Month(), wallClock.Day(), wallClock.Hour(),
+				wallClock.Minute(), wallClock.Second(), 0, loc), nil
+		}
+		timeline.key = func(start time.Time) string {
+			start = start.In(loc)
+			return strconv.FormatInt(time.Date(start.Year(), start.Month(), start.Day(), start.Hour(),
+				start.Minute(), start.Second(), 0, time.UTC).Unix(), 10)
+		}
+		timeline.offset = func(start time.Time, n int) time.Time {
+			return start.AddDate(n*length[0], n*length[1], n*length[2])
+		}
+	} else {
+		return nil
+	}
+
+	if dim.TimeUnit != "" {
+		// keys are bucket starts converted back to utc in the time unit.
+		timeline.start = func(key string) (time.Time, error) {
+			value, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if dim.TimeUnit == "millisecond" {
+				return time.Unix(value/1000, 0).In(loc), nil
+			}
+			if seconds, ok := timeUnitSeconds[dim.TimeUnit]; ok {
+				value *= seconds
+			}
+			return time.Unix(value, 0).In(loc), nil
+		}
+		timeline.key = func(start time.Time) string {
+			value := start.Unix()
+			if dim.TimeUnit == "millisecond" {
+				value *= 1000
+			} else if seconds, ok := timeUnitSeconds[dim.TimeUnit]; ok {
+				value /= seconds
+			}
+			return strconv.FormatInt(value, 10)
+		}
+	}
+	return timeline
+}
+
+// timeBucketEndFunc returns the function to get ends of buckets of the time dimension by bucket keys, nil if
+// buckets are not on the timeline, e.g. recurring buckets like hour of day.
+func timeBucketEndFunc(dim queryCom.Dimension, loc *time.Location) func(key string) (time.Time, error) {
+	timeline := newTimeBucketTimeline(dim, loc)
+	if timeline == nil {
+		return nil
+	}
+	return func(key string) (time.Time, error) {
+		start, err := timeline.start(key)
+		if err != nil {
+			return start, err
+		}
+		return timeline.offset(start, 1), nil
+	}
+}
+
+// timeBucketFill fills missing top level time buckets of merged aggregation results, so that results are
+// dense series of buckets within the time filter.
+type timeBucketFill struct {
+	timeline *timeBucketTimeline
+	// value of missing buckets of queries without inner dimensions, arrays of values of measures for
+	// queries of multiple measures.
+	value interface{}
+	// missing buckets of queries with inner dimensions are filled with empty buckets, inner dimensions are
+	// never filled.
+	innerDimensions bool
+	// bounds of the time filter, zero if unknown.
+	from, to time.Time
+}
+
+// newTimeBucketFill returns the fill of missing time buckets of results of the aggregation query if requested,
+// nil if buckets of the first dimension are not time buckets on the timeline in a fixed timezone. Missing
+// buckets are filled with 0 for count, sum and countdistinct, and null for other aggregations of measures.
+func newTimeBucketFill(aql *queryCom.AQLQuery, aggTypes ...common.AggType) *timeBucketFill {
+	if !aql.FillTimeBuckets || len(aql.Dimensions) == 0 || len(aggTypes) == 0 || common.Hll == aggTypes[0] {
+		return nil
+	}
+	loc, err := queryCom.ParseTimezone(aql.Timezone)
+	if err != nil {
+		// timezones by columns, e.g. timezone(city_id).
+		return nil
+	}
+	timeline := newTimeBucketTimeline(aql.Dimensions[0], loc)
+	if timeline == nil {
+		return nil
+	}
+
+	fill := &timeBucketFill{
+		timeline:        timeline,
+		innerDimensions: len(aql.Dimensions) > 1,
+	}
+	values := make([]interface{}, len(aggTypes))
+	for i, agg := range aggTypes {
+		if common.Count == agg || common.Sum == agg || common.CountDistinct == agg {
+			values[i] = 0.0
+		}
+	}
+	fill.value = values[0]
+	if len(values) > 1 {
+		fill.value = values
+	}
+
+	now := utils.Now().In(loc)
+	if aql.Now > 0 {
+		now = time.Unix(aql.Now, 0).In(loc)
+	}
+	if aql.TimeFilter.From != "" {
+		fill.from, _, _ = parseTimeFilterBound(aql.TimeFilter.From, now)
+		if aql.TimeFilter.To == "" {
+			fill.to = now
+		}
+	}
+	if aql.TimeFilter.To != "" {
+		_, fill.to, _ = parseTimeFilterBound(aql.TimeFilter.To, now)
+	}
+	return fill
+}
+
+// apply fills missing buckets of the result in place, between the first and the last bucket of the result
+// and extended to buckets overlapping with the time filter. Results without time buckets or spanning more
+// than maxFilledTimeBuckets buckets are not filled.
+func (f *timeBucketFill) apply(result queryCom.AQLQueryResult) queryCom.AQLQueryResult {
+	var first, last time.Time
+	found := false
+	for key := range result {
+		start, err := f.timeline.start(key)
+		if err != nil {
+			// e.g. NULL.
+			continue
+		}
+		if !found || start.Before(first) {
+			first = start
+		}
+		if !found || start.After(last) {
+			last = start
+		}
+		found = true
+	}
+	if !found {
+		return result
+	}
+
+	for n := 0; !f.from.IsZero() && first.After(f.from) && n < maxFilledTimeBuckets; n++ {
+		first = f.timeline.offset(first, -1)
+	}
+	for n := 0; !f.to.IsZero() && n < maxFilledTimeBuckets; n++ {
+		next := f.timeline.offset(last, 1)
+		if !next.Before(f.to) {
+			break
+		}
+		last = next
+	}
+
+	var keys []string
+	for start := first; !start.After(last); start = f.timeline.offset(start, 1) {
+		if len(keys) == maxFilledTimeBuckets {
+			return result
+		}
+		keys = append(keys, f.timeline.key(start))
+	}
+	for _, key := range keys {
+		if _, ok := result[key]; ok {
+			continue
+		}
+		if f.innerDimensions {
+			result[key] = map[string]interface{}{}
+		} else if values, ok := f.value.([]interface{}); ok {
+			result[key] = append([]interface{}(nil), values...)
+		} else {
+			result[key] = f.value
+		}
+	}
+	return result
+}
+
+// parseTimeFilterBound returns the start and end of the time filter expression in the timezone of now, for
+// expressions of now, unix timestamps, dates, date times and offsets in days, hours or minutes, e.g. -7d.
+// Other expressions are only understood by datanodes, ok is false for them.
+func parseTimeFilterBound(expression string, now time.Time) (start, end time.Time, ok bool) {
+	expression = strings.TrimSpace(expression)
+	loc := now.Location()
+	if expression == "now" {
+		return now, now, true
+	}
+
+	// numbers above 9999999 are timestamps in seconds, or milliseconds above 99999999999.
+	if value, err := strconv.ParseInt(expression, 10, 64); err == nil {
+		if value <= 9999999 {
+			return
+		}
+		if value > 99999999999 {
+			value /= 1000
+		}
+		start = time.Unix(value, 0).In(loc)
+		return start, start, true
+	}
+
+	if len(expression) < 2 {
+		return
+	}
+	if amount, err := strconv.Atoi(expression[:len(expression)-1]); err == nil {
+		switch expression[len(expression)-1] {
+		case 'd':
+			start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, amount)
+			return start, start.AddDate(0, 0, 1), true
+		case 'h':
+			start = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc).Add(time.Duration(amount) * time.Hour)
+			return start, start.Add(time.Hour), true
+		case 'm':
+			start = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, loc).Add(time.Duration(amount) * time.Minute)
+			return start, start.Add(time.Minute), true
+		}
+		return
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", expression, loc); err == nil {
+		return t, t.AddDate(0, 0, 1), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15", expression, loc); err == nil {
+		return t, t.Add(time.Hour), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", expression, loc); err == nil {
+		// times of quarter hours are quarter-hour long, see parseAbsoluteTime of query.
+		if t.Minute()%15 == 0 {
+			return t, t.Add(15 * time.Minute), true
+		}
+		return t, t.Add(time.Minute), true
+	}
+	return
+}
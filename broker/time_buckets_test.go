@@ -0,0 +1,182 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/common"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+var _ = ginkgo.Describe("time buckets", func() {
+	bucketEnd := func(dim queryCom.Dimension, timezone, key string) time.Time {
+		loc, err := queryCom.ParseTimezone(timezone)
+		Ω(err).Should(BeNil())
+		endFunc := timeBucketEndFunc(dim, loc)
+		Ω(endFunc).ShouldNot(BeNil())
+		end, err := endFunc(key)
+		Ω(err).Should(BeNil())
+		return end.UTC()
+	}
+
+	ginkgo.It("should get ends of time buckets by keys", func() {
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "hour"}, "", "2019-10-01 07:00")).
+			Should(Equal(time.Date(2019, 10, 1, 8, 0, 0, 0, time.UTC)))
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "15m"}, "-8:00", "2019-10-01 07:15")).
+			Should(Equal(time.Date(2019, 10, 1, 15, 30, 0, 0, time.UTC)))
+		// the day of the dst switch is 25 hours long.
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "day"}, "America/Los_Angeles", "2019-11-03")).
+			Should(Equal(time.Date(2019, 11, 4, 8, 0, 0, 0, time.UTC)))
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "month"}, "", "1569888000")).
+			Should(Equal(time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)))
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "week"}, "+8:00", "1569888000")).
+			Should(Equal(time.Date(2019, 10, 7, 16, 0, 0, 0, time.UTC)))
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "hour", TimeUnit: "millisecond"}, "", "1569913200000")).
+			Should(Equal(time.Date(2019, 10, 1, 8, 0, 0, 0, time.UTC)))
+		Ω(bucketEnd(queryCom.Dimension{TimeBucketizer: "day", TimeUnit: "day"}, "", "18170")).
+			Should(Equal(time.Date(2019, 10, 2, 0, 0, 0, 0, time.UTC)))
+
+		// recurring buckets and raw times are not on the timeline.
+		Ω(timeBucketEndFunc(queryCom.Dimension{TimeBucketizer: "hour of day"}, time.UTC)).Should(BeNil())
+		Ω(timeBucketEndFunc(queryCom.Dimension{TimeUnit: "second"}, time.UTC)).Should(BeNil())
+
+		_, err := timeBucketEndFunc(queryCom.Dimension{TimeBucketizer: "hour"}, time.UTC)("NULL")
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("should tell completeness of time buckets by data freshness", func() {
+		hourly := &queryCom.AQLQuery{
+			Dimensions:         []queryCom.Dimension{{TimeBucketizer: "hour"}, {Expr: "city_id"}},
+			BucketCompleteness: true,
+		}
+		result := queryCom.AQLQueryResult{
+			"2019-10-01 00:00": map[string]interface{}{"1": 1.0},
+			"2019-10-01 01:00": map[string]interface{}{"1": 2.0},
+			"NULL":             map[string]interface{}{"1": 3.0},
+		}
+
+		ctx, buckets := withTimeBuckets(context.Background())
+		collectTimeBuckets(ctx, hourly, result)
+		Ω(buckets.completeness(1569891600)).Should(Equal(map[string]bool{
+			"2019-10-01 00:00": true,
+			"2019-10-01 01:00": false,
+		}))
+		Ω(buckets.completeness(0)).Should(Equal(map[string]bool{
+			"2019-10-01 00:00": false,
+			"2019-10-01 01:00": false,
+		}))
+
+		// not collected unless requested for time buckets in fixed timezones.
+		for _, aql := range []*queryCom.AQLQuery{
+			{Dimensions: hourly.Dimensions},
+			{Dimensions: []queryCom.Dimension{{Expr: "city_id"}}, BucketCompleteness: true},
+			{Dimensions: hourly.Dimensions, Timezone: "timezone(city_id)", BucketCompleteness: true},
+		} {
+			ctx, buckets = withTimeBuckets(context.Background())
+			collectTimeBuckets(ctx, aql, result)
+			Ω(buckets.completeness(1569891600)).Should(BeNil())
+		}
+		collectTimeBuckets(context.Background(), hourly, result)
+	})
+
+	ginkgo.It("should fill missing time buckets within the time filter", func() {
+		hourly := &queryCom.AQLQuery{
+			Dimensions:      []queryCom.Dimension{{TimeBucketizer: "hour"}},
+			TimeFilter:      queryCom.TimeFilter{From: "2019-10-01 01", To: "2019-10-01 04"},
+			FillTimeBuckets: true,
+		}
+		fill := newTimeBucketFill(hourly, common.Count)
+		Ω(fill).ShouldNot(BeNil())
+		Ω(fill.apply(queryCom.AQLQueryResult{
+			"2019-10-01 02:00": 3.0,
+			"NULL":             1.0,
+		})).Should(Equal(queryCom.AQLQueryResult{
+			"2019-10-01 01:00": 0.0,
+			"2019-10-01 02:00": 3.0,
+			"2019-10-01 03:00": 0.0,
+			"2019-10-01 04:00": 0.0,
+			"NULL":             1.0,
+		}))
+
+		// gaps between buckets are filled without time filters, inner dimensions are not filled.
+		daily := &queryCom.AQLQuery{
+			Dimensions:      []queryCom.Dimension{{TimeBucketizer: "day"}, {Expr: "city_id"}},
+			Timezone:        "America/Los_Angeles",
+			FillTimeBuckets: true,
+		}
+		Ω(newTimeBucketFill(daily, common.Max).apply(queryCom.AQLQueryResult{
+			"2019-11-02": map[string]interface{}{"1": 1.0},
+			"2019-11-05": map[string]interface{}{"2": 2.0},
+		})).Should(Equal(queryCom.AQLQueryResult{
+			"2019-11-02": map[string]interface{}{"1": 1.0},
+			"2019-11-03": map[string]interface{}{},
+			"2019-11-04": map[string]interface{}{},
+			"2019-11-05": map[string]interface{}{"2": 2.0},
+		}))
+
+		monthly := &queryCom.AQLQuery{
+			Dimensions:      []queryCom.Dimension{{TimeBucketizer: "month"}},
+			TimeFilter:      queryCom.TimeFilter{From: "1567296000"},
+			Now:             1572566400,
+			FillTimeBuckets: true,
+		}
+		Ω(newTimeBucketFill(monthly, common.Avg).apply(queryCom.AQLQueryResult{
+			"1569888000": 2.0,
+		})).Should(Equal(queryCom.AQLQueryResult{
+			"1567296000": nil,
+			"1569888000": 2.0,
+		}))
+
+		// not filled unless requested for time buckets in fixed timezones.
+		for _, aql := range []*queryCom.AQLQuery{
+			{Dimensions: hourly.Dimensions},
+			{Dimensions: []queryCom.Dimension{{Expr: "city_id"}}, FillTimeBuckets: true},
+			{Dimensions: []queryCom.Dimension{{TimeBucketizer: "hour of day"}}, FillTimeBuckets: true},
+			{Dimensions: hourly.Dimensions, Timezone: "timezone(city_id)", FillTimeBuckets: true},
+		} {
+			Ω(newTimeBucketFill(aql, common.Count)).Should(BeNil())
+		}
+		Ω(newTimeBucketFill(hourly, common.Hll)).Should(BeNil())
+	})
+
+	ginkgo.It("should parse bounds of time filters", func() {
+		now := time.Date(2019, 10, 1, 7, 30, 15, 0, time.UTC)
+		for expression, bounds := range map[string][2]time.Time{
+			"now":              {now, now},
+			"1569888000":       {time.Unix(1569888000, 0).UTC(), time.Unix(1569888000, 0).UTC()},
+			"1569888000000":    {time.Unix(1569888000, 0).UTC(), time.Unix(1569888000, 0).UTC()},
+			"-1d":              {time.Date(2019, 9, 30, 0, 0, 0, 0, time.UTC), time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)},
+			"-2h":              {time.Date(2019, 10, 1, 5, 0, 0, 0, time.UTC), time.Date(2019, 10, 1, 6, 0, 0, 0, time.UTC)},
+			"0m":               {time.Date(2019, 10, 1, 7, 30, 0, 0, time.UTC), time.Date(2019, 10, 1, 7, 31, 0, 0, time.UTC)},
+			"2019-09-01":       {time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 9, 2, 0, 0, 0, 0, time.UTC)},
+			"2019-09-01 10":    {time.Date(2019, 9, 1, 10, 0, 0, 0, time.UTC), time.Date(2019, 9, 1, 11, 0, 0, 0, time.UTC)},
+			"2019-09-01 10:15": {time.Date(2019, 9, 1, 10, 15, 0, 0, time.UTC), time.Date(2019, 9, 1, 10, 30, 0, 0, time.UTC)},
+		} {
+			start, end, ok := parseTimeFilterBound(expression, now)
+			Ω(ok).Should(BeTrue(), expression)
+			Ω(start).Should(Equal(bounds[0]), expression)
+			Ω(end).Should(Equal(bounds[1]), expression)
+		}
+
+		for _, expression := range []string{"this quarter", "-1y", "2019-Q1", "", "12"} {
+			_, _, ok := parseTimeFilterBound(expression, now)
+			Ω(ok).Should(BeFalse(), expression)
+		}
+	})
+})
@@ -0,0 +1,47 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/aresdb/utils"
+)
+
+// startRequestSpan starts the span of the request continuing the trace of the caller if any, and returns the
+// context carrying the request id and the span, so that logs and spans of query plans and datanodes line up
+// with the request. Spans are reported to the global tracer, which is a noop unless registered.
+func startRequestSpan(ctx context.Context, r *http.Request, requestID string) (context.Context, opentracing.Span) {
+	tracer := opentracing.GlobalTracer()
+	// starts a new trace if the caller did not propagate one.
+	parent, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	span := tracer.StartSpan("broker.request", ext.RPCServerOption(parent))
+	ext.HTTPMethod.Set(span, r.Method)
+	ext.HTTPUrl.Set(span, r.URL.Path)
+	span.SetTag("requestID", requestID)
+	return opentracing.ContextWithSpan(utils.WithRequestID(ctx, requestID), span), span
+}
+
+// finishSpan finishes the span with the error of the operation if any.
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	span.Finish()
+}
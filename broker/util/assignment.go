@@ -16,41 +16,172 @@ package util
 
 import (
 	"fmt"
+	"math/rand"
+	"strings"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
 	"github.com/uber/aresdb/cluster/topology"
 	"github.com/uber/aresdb/utils"
 )
 
+// AssignmentOptions are the options of shard assignments.
+type AssignmentOptions struct {
+	// ids of hosts not assigned any shards.
+	ExcludedHosts map[string]bool
+	// Seed shuffles replicas of each shard before picking the least loaded one, so that shards of
+	// different queries spread over all replicas instead of the same hosts. Replicas are tried in
+	// the order of the topology if 0, which assigns shards deterministically.
+	Seed int64
+	// Health prefers the healthiest replicas of each shard if not nil, shards are still assigned to replicas
+	// down if no healthier replica exists.
+	Health HostHealth
+}
+
 // CalculateShardAssignment maps shards to hosts
 func CalculateShardAssignment(topo topology.Topology) (as map[topology.Host][]uint32, err error) {
+	as, _, err = CalculateShardAssignmentExcluding(topo, nil)
+	return
+}
+
+// CalculateShardAssignmentExcluding maps shards to hosts except excluded hosts by host ID, shards
+// without any other hosts to route to are returned as unassigned.
+func CalculateShardAssignmentExcluding(topo topology.Topology, excludedHosts map[string]bool) (as map[topology.Host][]uint32, unassigned []uint32, err error) {
+	return CalculateShardAssignmentWithOptions(topo, AssignmentOptions{ExcludedHosts: excludedHosts})
+}
+
+// CalculateShardAssignmentWithOptions maps each shard to the least loaded replica of the shard by the
+// options. Replicas with the shard not available in the topology map are skipped, leaving replicas are
+// only picked if no replica is available since they serve the shard until removed. Of the replicas left,
+// the healthiest ones are picked from by the health of the options. Shards without any replica to route
+// to are returned as unassigned.
+func CalculateShardAssignmentWithOptions(topo topology.Topology, opts AssignmentOptions) (as map[topology.Host][]uint32, unassigned []uint32, err error) {
 	m := topo.Get()
 	hosts := m.Hosts()
 	shardIDs := m.ShardSet().AllIDs()
+	states := m.ShardStates()
+
+	var random *rand.Rand
+	if opts.Seed != 0 {
+		random = rand.New(rand.NewSource(opts.Seed))
+	}
 
 	// initialize host map
 	as = make(map[topology.Host][]uint32)
 	for _, host := range hosts {
-		as[host] = []uint32{}
+		if !isExcluded(host, opts.ExcludedHosts) {
+			as[host] = []uint32{}
+		}
 	}
 
 	for _, shardID := range shardIDs {
-		var shardHosts []topology.Host
-		// get routable hosts for current shard
-		shardHosts, err = m.RouteShard(shardID)
+		var candidates []topology.Host
+		candidates, err = routableHosts(m, states, shardID, opts.ExcludedHosts)
 		if err != nil {
-			err = utils.StackError(err, fmt.Sprintf("failed to route shard %d", shardID))
 			return
 		}
+		if len(candidates) == 0 {
+			unassigned = append(unassigned, shardID)
+			continue
+		}
+		candidates = healthiestHosts(candidates, opts.Health)
+		if random != nil {
+			random.Shuffle(len(candidates), func(i, j int) {
+				candidates[i], candidates[j] = candidates[j], candidates[i]
+			})
+		}
+
 		// pick host with lowest load to route current shard
-		var pick topology.Host
-		minLoad := len(shardIDs) + 1
-		for _, shardHost := range shardHosts {
-			load := len(as[shardHost])
-			if load < minLoad {
-				minLoad = load
-				pick = shardHost
+		pick := candidates[0]
+		for _, candidate := range candidates[1:] {
+			if len(as[candidate]) < len(as[pick]) {
+				pick = candidate
 			}
 		}
 		as[pick] = append(as[pick], shardID)
 	}
 	return
 }
+
+// ShardGroup is a group of shards owned by the same replicas.
+type ShardGroup struct {
+	// replicas of the shards in the order of the topology.
+	Replicas []topology.Host
+	ShardIDs []uint32
+}
+
+// CalculateShardGroups groups shards by their replicas except excluded hosts, replicas are skipped like
+// CalculateShardAssignmentWithOptions. Shards without any replica to route to are returned as unassigned.
+// Groups are in the order of their first shards.
+func CalculateShardGroups(topo topology.Topology, excludedHosts map[string]bool) (groups []ShardGroup, unassigned []uint32, err error) {
+	m := topo.Get()
+	states := m.ShardStates()
+	groupIndexes := make(map[string]int)
+	for _, shardID := range m.ShardSet().AllIDs() {
+		var replicas []topology.Host
+		replicas, err = routableHosts(m, states, shardID, excludedHosts)
+		if err != nil {
+			return
+		}
+		if len(replicas) == 0 {
+			unassigned = append(unassigned, shardID)
+			continue
+		}
+		ids := make([]string, len(replicas))
+		for i, replica := range replicas {
+			ids[i] = replica.ID()
+		}
+		key := strings.Join(ids, ",")
+		index, exist := groupIndexes[key]
+		if !exist {
+			index = len(groups)
+			groupIndexes[key] = index
+			groups = append(groups, ShardGroup{Replicas: append([]topology.Host(nil), replicas...)})
+		}
+		groups[index].ShardIDs = append(groups[index].ShardIDs, shardID)
+	}
+	return
+}
+
+// AssignedShardCounts returns the number of shards assigned to each host of the shard assignment by
+// host ID, including hosts without any shards assigned.
+func AssignedShardCounts(as map[topology.Host][]uint32) map[string]int {
+	counts := make(map[string]int, len(as))
+	for host, shardIDs := range as {
+		counts[host.ID()] += len(shardIDs)
+	}
+	return counts
+}
+
+// routableHosts returns hosts the shard can be routed to, excluded hosts and hosts with the shard not available
+// skipped. Leaving hosts are only returned if no host is available since they serve the shard until removed.
+func routableHosts(m topology.Map, states topology.ShardStates, shardID uint32, excludedHosts map[string]bool) ([]topology.Host, error) {
+	shardHosts, err := m.RouteShard(shardID)
+	if err != nil {
+		return nil, utils.StackError(err, fmt.Sprintf("failed to route shard %d", shardID))
+	}
+	var available, leaving []topology.Host
+	for _, shardHost := range shardHosts {
+		if isExcluded(shardHost, excludedHosts) {
+			continue
+		}
+		// shards of hosts without states in the topology map are assumed available.
+		var hostState topology.HostShardState
+		known := false
+		if len(states) > 0 {
+			hostState, known = states[topology.ShardID(shardID)][topology.HostID(shardHost.ID())]
+		}
+		if !known || hostState.ShardState == m3Shard.Available {
+			available = append(available, shardHost)
+		} else if hostState.ShardState == m3Shard.Leaving {
+			leaving = append(leaving, shardHost)
+		}
+	}
+	if len(available) == 0 {
+		return leaving, nil
+	}
+	return available, nil
+}
+
+func isExcluded(host topology.Host, excludedHosts map[string]bool) bool {
+	return len(excludedHosts) > 0 && excludedHosts[host.ID()]
+}
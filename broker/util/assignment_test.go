@@ -15,13 +15,43 @@
 package util
 
 import (
+	"fmt"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	aresShard "github.com/uber/aresdb/cluster/shard"
 	shardMock "github.com/uber/aresdb/cluster/shard/mocks"
 	"github.com/uber/aresdb/cluster/topology"
 	topoMock "github.com/uber/aresdb/cluster/topology/mocks"
 )
 
+// staticTopology returns the static topology of hosts by the states of their shards, all shards of the
+// topology are routed to every host.
+func staticTopology(numShards int, hostStates ...map[uint32]m3Shard.State) topology.Topology {
+	var shardIDs []uint32
+	for i := 0; i < numShards; i++ {
+		shardIDs = append(shardIDs, uint32(i))
+	}
+	hostShardSets := make([]topology.HostShardSet, len(hostStates))
+	for i, states := range hostStates {
+		var shards []m3Shard.Shard
+		for _, shardID := range shardIDs {
+			state, ok := states[shardID]
+			if !ok {
+				state = m3Shard.Available
+			}
+			shards = append(shards, m3Shard.NewShard(shardID).SetState(state))
+		}
+		host := topology.NewHost(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d:9374", i))
+		hostShardSets[i] = topology.NewHostShardSet(host, aresShard.NewShardSet(shards))
+	}
+	return topology.NewStaticTopology(topology.NewStaticOptions().
+		SetReplicas(len(hostStates)).
+		SetHostShardSets(hostShardSets).
+		SetShardSet(aresShard.NewShardSet(aresShard.NewShards(shardIDs, m3Shard.Available))))
+}
+
 var _ = ginkgo.Describe("broker util", func() {
 	ginkgo.It("should work happy path", func() {
 		mockTopo := topoMock.Topology{}
@@ -29,6 +59,7 @@ var _ = ginkgo.Describe("broker util", func() {
 		mockShardSet := shardMock.ShardSet{}
 		mockTopo.On("Get").Return(&mockMap)
 		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
 		mockShardIds := []uint32{0, 1, 2, 3, 4, 5}
 		mockShardSet.On("AllIDs").Return(mockShardIds)
 		mockHost1 := &topoMock.Host{}
@@ -56,4 +87,146 @@ var _ = ginkgo.Describe("broker util", func() {
 		Ω(res[mockHost2]).Should(HaveLen(2))
 		Ω(res[mockHost3]).Should(HaveLen(2))
 	})
+
+	ginkgo.It("should exclude hosts", func() {
+		mockTopo := topoMock.Topology{}
+		mockMap := topoMock.Map{}
+		mockShardSet := shardMock.ShardSet{}
+		mockTopo.On("Get").Return(&mockMap)
+		mockMap.On("ShardSet").Return(&mockShardSet)
+		mockMap.On("ShardStates").Return(topology.ShardStates(nil))
+		mockShardSet.On("AllIDs").Return([]uint32{0, 1, 2})
+		mockHost1 := &topoMock.Host{}
+		mockHost2 := &topoMock.Host{}
+		mockHost1.On("ID").Return("host1")
+		mockHost2.On("ID").Return("host2")
+		mockMap.On("Hosts").Return([]topology.Host{mockHost1, mockHost2})
+		//host1: 0,1
+		//host2: 1,2
+		mockMap.On("RouteShard", uint32(0)).Return([]topology.Host{mockHost1}, nil)
+		mockMap.On("RouteShard", uint32(1)).Return([]topology.Host{mockHost1, mockHost2}, nil)
+		mockMap.On("RouteShard", uint32(2)).Return([]topology.Host{mockHost2}, nil)
+
+		res, unassigned, err := CalculateShardAssignmentExcluding(&mockTopo, map[string]bool{"host2": true})
+		Ω(err).Should(BeNil())
+		Ω(res).Should(HaveLen(1))
+		Ω(res[mockHost1]).Should(Equal([]uint32{0, 1}))
+		Ω(unassigned).Should(Equal([]uint32{2}))
+	})
+
+	ginkgo.It("should spread shards over replicas by seeds", func() {
+		topo := staticTopology(6, nil, nil, nil)
+
+		// replicas are picked in the order of the topology without seeds.
+		as, unassigned, err := CalculateShardAssignmentWithOptions(topo, AssignmentOptions{})
+		Ω(err).Should(BeNil())
+		Ω(unassigned).Should(BeEmpty())
+		Ω(AssignedShardCounts(as)).Should(Equal(map[string]int{"host0": 2, "host1": 2, "host2": 2}))
+		for host, shardIDs := range as {
+			switch host.ID() {
+			case "host0":
+				Ω(shardIDs).Should(Equal([]uint32{0, 3}))
+			case "host1":
+				Ω(shardIDs).Should(Equal([]uint32{1, 4}))
+			case "host2":
+				Ω(shardIDs).Should(Equal([]uint32{2, 5}))
+			}
+		}
+
+		// shards of the same seed are assigned to the same replicas, and spread over replicas across seeds.
+		assignedHosts := make(map[uint32]map[string]bool)
+		for seed := int64(1); seed <= 20; seed++ {
+			as, _, err = CalculateShardAssignmentWithOptions(topo, AssignmentOptions{Seed: seed})
+			Ω(err).Should(BeNil())
+			Ω(AssignedShardCounts(as)).Should(Equal(map[string]int{"host0": 2, "host1": 2, "host2": 2}))
+			again, _, err := CalculateShardAssignmentWithOptions(topo, AssignmentOptions{Seed: seed})
+			Ω(err).Should(BeNil())
+			Ω(again).Should(Equal(as))
+			for host, shardIDs := range as {
+				for _, shardID := range shardIDs {
+					if assignedHosts[shardID] == nil {
+						assignedHosts[shardID] = make(map[string]bool)
+					}
+					assignedHosts[shardID][host.ID()] = true
+				}
+			}
+		}
+		Ω(assignedHosts).Should(HaveLen(6))
+		for _, hosts := range assignedHosts {
+			Ω(hosts).Should(HaveLen(3))
+		}
+	})
+
+	ginkgo.It("should skip replicas of shards not available", func() {
+		topo := staticTopology(3,
+			map[uint32]m3Shard.State{0: m3Shard.Initializing, 1: m3Shard.Leaving, 2: m3Shard.Leaving},
+			map[uint32]m3Shard.State{0: m3Shard.Initializing, 1: m3Shard.Initializing, 2: m3Shard.Initializing},
+		)
+		as, unassigned, err := CalculateShardAssignmentWithOptions(topo, AssignmentOptions{Seed: 1})
+		Ω(err).Should(BeNil())
+		// leaving shards are served until removed when no replica is available.
+		Ω(unassigned).Should(Equal([]uint32{0}))
+		Ω(AssignedShardCounts(as)).Should(Equal(map[string]int{"host0": 2, "host1": 0}))
+	})
+
+	ginkgo.It("should group shards by replicas", func() {
+		topo := staticTopology(4,
+			map[uint32]m3Shard.State{0: m3Shard.Initializing},
+			map[uint32]m3Shard.State{3: m3Shard.Initializing},
+			nil,
+		)
+		groups, unassigned, err := CalculateShardGroups(topo, map[string]bool{"host2": true})
+		Ω(err).Should(BeNil())
+		Ω(unassigned).Should(BeEmpty())
+		Ω(groups).Should(HaveLen(3))
+		replicaIDs := func(group ShardGroup) []string {
+			var ids []string
+			for _, replica := range group.Replicas {
+				ids = append(ids, replica.ID())
+			}
+			return ids
+		}
+		Ω(replicaIDs(groups[0])).Should(Equal([]string{"host1"}))
+		Ω(groups[0].ShardIDs).Should(Equal([]uint32{0}))
+		Ω(replicaIDs(groups[1])).Should(Equal([]string{"host0", "host1"}))
+		Ω(groups[1].ShardIDs).Should(Equal([]uint32{1, 2}))
+		Ω(replicaIDs(groups[2])).Should(Equal([]string{"host0"}))
+		Ω(groups[2].ShardIDs).Should(Equal([]uint32{3}))
+
+		_, unassigned, err = CalculateShardGroups(topo, map[string]bool{"host0": true, "host1": true, "host2": true})
+		Ω(err).Should(BeNil())
+		Ω(unassigned).Should(Equal([]uint32{0, 1, 2, 3}))
+	})
+
+	ginkgo.It("should prefer the healthiest replicas", func() {
+		topo := staticTopology(4, nil, nil, nil)
+		as, unassigned, err := CalculateShardAssignmentWithOptions(topo, AssignmentOptions{
+			Health: testHostHealth{"host0": HostDown, "host1": HostDegraded},
+		})
+		Ω(err).Should(BeNil())
+		Ω(unassigned).Should(BeEmpty())
+		Ω(AssignedShardCounts(as)).Should(Equal(map[string]int{"host0": 0, "host1": 0, "host2": 4}))
+
+		as, _, err = CalculateShardAssignmentWithOptions(topo, AssignmentOptions{
+			Health: testHostHealth{"host0": HostDown, "host1": HostDegraded, "host2": HostDegraded},
+		})
+		Ω(err).Should(BeNil())
+		Ω(AssignedShardCounts(as)).Should(Equal(map[string]int{"host0": 0, "host1": 2, "host2": 2}))
+
+		// shards are still assigned to replicas down without healthier replicas.
+		as, unassigned, err = CalculateShardAssignmentWithOptions(topo, AssignmentOptions{
+			ExcludedHosts: map[string]bool{"host1": true, "host2": true},
+			Health:        testHostHealth{"host0": HostDown},
+		})
+		Ω(err).Should(BeNil())
+		Ω(unassigned).Should(BeEmpty())
+		Ω(AssignedShardCounts(as)).Should(Equal(map[string]int{"host0": 4}))
+	})
 })
+
+// testHostHealth is the health status of hosts by host id, hosts not in it are healthy.
+type testHostHealth map[string]HostStatus
+
+func (h testHostHealth) HostStatus(hostID string) HostStatus {
+	return h[hostID]
+}
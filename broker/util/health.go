@@ -0,0 +1,75 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"github.com/uber/aresdb/cluster/topology"
+)
+
+// HostStatus is the health status of a host, ordered from the healthiest.
+type HostStatus int
+
+const (
+	// HostHealthy hosts serve queries normally.
+	HostHealthy HostStatus = iota
+	// HostDegraded hosts fail some of recent queries.
+	HostDegraded
+	// HostDown hosts fail most of recent queries.
+	HostDown
+)
+
+func (s HostStatus) String() string {
+	switch s {
+	case HostDegraded:
+		return "degraded"
+	case HostDown:
+		return "down"
+	default:
+		return "healthy"
+	}
+}
+
+// MarshalJSON marshals the status as its name.
+func (s HostStatus) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+// HostHealth tells health status of hosts.
+type HostHealth interface {
+	// HostStatus returns the health status of the host by host id.
+	HostStatus(hostID string) HostStatus
+}
+
+// healthiestHosts returns the hosts with the healthiest status, hosts are returned as is without health.
+func healthiestHosts(hosts []topology.Host, health HostHealth) []topology.Host {
+	if health == nil || len(hosts) <= 1 {
+		return hosts
+	}
+	statuses := make([]HostStatus, len(hosts))
+	best := HostDown
+	for i, host := range hosts {
+		statuses[i] = health.HostStatus(host.ID())
+		if statuses[i] < best {
+			best = statuses[i]
+		}
+	}
+	healthiest := make([]topology.Host, 0, len(hosts))
+	for i, host := range hosts {
+		if statuses[i] == best {
+			healthiest = append(healthiest, host)
+		}
+	}
+	return healthiest
+}
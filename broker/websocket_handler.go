@@ -0,0 +1,474 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/broker/common"
+	"github.com/uber/aresdb/broker/config"
+	dataCli "github.com/uber/aresdb/datanode/client"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultWebSocketPingIntervalSec     = 30
+	defaultWebSocketMaxInFlightMessages = 16
+	defaultWebSocketMaxRowsPerMessage   = 1000
+)
+
+// types of messages sent to websocket clients.
+const (
+	wsMessageHeaders  = "headers"
+	wsMessageRows     = "rows"
+	wsMessageResult   = "result"
+	wsMessageComplete = "complete"
+	wsMessageError    = "error"
+)
+
+// nonAggResultPrefix is the beginning of results written by non aggregation query plans.
+var nonAggResultPrefix = []byte(`{"` + queryCom.HeadersKey + `":`)
+
+// WebSocketQueryHandler streams query results over websockets, for clients behind proxies that close
+// idle http responses of long running queries.
+//
+// The client sends the query as the first message {"query": {...}}, and acknowledges received messages
+// by sequence number with {"ack": seq}. The broker streams results of non aggregation queries as a
+// headers message followed by rows messages, and results of aggregation queries as a single result
+// message, then a complete message with the metadata of the query, or an error message. Closing the
+// connection cancels the query.
+type WebSocketQueryHandler struct {
+	exec           common.QueryExecutor
+	upgrader       websocket.Upgrader
+	pingInterval   time.Duration
+	maxInFlight    int64
+	rowsPerMessage int
+}
+
+// NewWebSocketQueryHandler creates a new WebSocketQueryHandler
+func NewWebSocketQueryHandler(executor common.QueryExecutor, cfg config.WebSocketConfig) WebSocketQueryHandler {
+	pingIntervalSec := cfg.PingIntervalSec
+	if pingIntervalSec <= 0 {
+		pingIntervalSec = defaultWebSocketPingIntervalSec
+	}
+	maxInFlight := cfg.MaxInFlightMessages
+	if maxInFlight <= 0 {
+		maxInFlight = defaultWebSocketMaxInFlightMessages
+	}
+	rowsPerMessage := cfg.MaxRowsPerMessage
+	if rowsPerMessage <= 0 {
+		rowsPerMessage = defaultWebSocketMaxRowsPerMessage
+	}
+	return WebSocketQueryHandler{
+		exec:           executor,
+		pingInterval:   time.Duration(pingIntervalSec) * time.Second,
+		maxInFlight:    int64(maxInFlight),
+		rowsPerMessage: rowsPerMessage,
+	}
+}
+
+// Register registers http handlers.
+func (handler *WebSocketQueryHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
+	router.HandleFunc("/stream", utils.ApplyHTTPWrappers(handler.HandleStream, wrappers)).Methods(http.MethodGet)
+}
+
+// wsClientMessage is a message sent by websocket clients, either the query or an acknowledgement.
+type wsClientMessage struct {
+	Query *queryCom.AQLQuery `json:"query,omitempty"`
+	// sequence number of the last received message.
+	Ack int64 `json:"ack,omitempty"`
+}
+
+// wsQueryMessage is a message sent to websocket clients.
+type wsQueryMessage struct {
+	Type string `json:"type"`
+	// sequence number of the message starting from 1, to be acknowledged by clients.
+	Seq      int64                   `json:"seq"`
+	Headers  []string                `json:"headers,omitempty"`
+	Rows     []json.RawMessage       `json:"rows,omitempty"`
+	Result   json.RawMessage         `json:"result,omitempty"`
+	Cursor   string                  `json:"cursor,omitempty"`
+	Metadata *apiCom.QueryMetadataV2 `json:"metadata,omitempty"`
+	Error    *apiCom.QueryErrorV2    `json:"error,omitempty"`
+}
+
+// HandleStream upgrades the request to a websocket and streams results of the query sent by the client.
+func (handler *WebSocketQueryHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	utils.GetRootReporter().GetCounter(utils.AQLQueryReceivedBroker).Inc(1)
+	conn, err := handler.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader already responded the error.
+		utils.GetLogger().With("error", err).Warn("failed to upgrade query stream")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newQueryStream(conn, cancel, handler.maxInFlight)
+
+	conn.SetReadDeadline(utils.Now().Add(2 * handler.pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(utils.Now().Add(2 * handler.pingInterval))
+	})
+
+	metadata := apiCom.NewQueryMetadataV2(r)
+	ctx, span := startRequestSpan(ctx, r, metadata.RequestID)
+	defer func() {
+		finishSpan(span, err)
+	}()
+	var message wsClientMessage
+	if err = conn.ReadJSON(&message); err != nil || message.Query == nil {
+		if err == nil {
+			err = utils.StackError(nil, "the first message must be the query")
+		}
+		stream.sendFinal(&wsQueryMessage{
+			Type:     wsMessageError,
+			Error:    apiCom.NewQueryErrorV2(utils.WithCode(utils.ErrCodeInvalidQuery, err)),
+			Metadata: &metadata,
+		})
+		stream.close()
+		return
+	}
+
+	go stream.readAcks(handler.pingInterval)
+	go stream.ping(handler.pingInterval)
+
+	aql := message.Query
+	aql.Caller, aql.CallerRoles = utils.GetOrigin(r), utils.GetCallerRoles(r)
+
+	start := utils.Now()
+	ctx, dataNodeMetadata := dataCli.WithQueryMetadata(ctx)
+	ctx, partialErrors := withPartialErrors(ctx)
+	writer := newStreamWriter(stream, handler.rowsPerMessage)
+	err = handler.exec.Execute(ctx, aql, writer)
+	if finishErr := writer.finish(); err == nil {
+		err = finishErr
+	}
+
+	duration := utils.Now().Sub(start)
+	utils.GetRootReporter().GetTimer(utils.QueryLatencyBroker).Record(duration)
+	metadata.UpdateDataFreshness(dataNodeMetadata.DataFreshness)
+	metadata.Stats.DataNodeQueries = dataNodeMetadata.NumQueries
+	metadata.Stats.RowsScanned = dataNodeMetadata.RowsScanned
+	metadata.SetLatency(start)
+	if err != nil {
+		utils.GetRootReporter().GetCounter(utils.QueryFailedBroker).Inc(1)
+		utils.GetContextLogger(ctx).With("error", err, "query", aql).Error("Error happened when streaming query")
+		stream.sendFinal(&wsQueryMessage{
+			Type:     wsMessageError,
+			Error:    apiCom.NewQueryErrorV2(err),
+			Metadata: &metadata,
+		})
+	} else {
+		utils.GetRootReporter().GetCounter(utils.QuerySucceededBroker).Inc(1)
+		if warnings := writer.Header().Get(utils.HTTPQueryWarningHeaderKey); warnings != "" {
+			metadata.Partial = true
+			metadata.Warnings = strings.Split(warnings, "; ")
+		}
+		metadata.Errors = partialErrors.errors
+		stream.sendFinal(&wsQueryMessage{
+			Type:     wsMessageComplete,
+			Cursor:   writer.cursor,
+			Metadata: &metadata,
+		})
+	}
+	stream.close()
+}
+
+// queryStream sends messages of a query to the websocket client, with at most maxInFlight messages not
+// acknowledged by the client.
+type queryStream struct {
+	conn        *websocket.Conn
+	cancel      context.CancelFunc
+	maxInFlight int64
+
+	sync.Mutex
+	cond   *sync.Cond
+	seq    int64
+	acked  int64
+	err    error
+	closed chan struct{}
+}
+
+func newQueryStream(conn *websocket.Conn, cancel context.CancelFunc, maxInFlight int64) *queryStream {
+	stream := &queryStream{
+		conn:        conn,
+		cancel:      cancel,
+		maxInFlight: maxInFlight,
+		closed:      make(chan struct{}),
+	}
+	stream.cond = sync.NewCond(stream)
+	return stream
+}
+
+// send sends the message after the number of in flight messages drops below the limit. It fails if the
+// client is gone.
+func (s *queryStream) send(message *wsQueryMessage) error {
+	s.Lock()
+	for s.err == nil && s.seq-s.acked >= s.maxInFlight {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		s.Unlock()
+		return s.err
+	}
+	s.seq++
+	message.Seq = s.seq
+	s.Unlock()
+
+	if err := s.conn.WriteJSON(message); err != nil {
+		s.fail(utils.StackError(err, "failed to send message to query stream"))
+		return err
+	}
+	return nil
+}
+
+// sendFinal sends the last message of the query regardless of in flight messages.
+func (s *queryStream) sendFinal(message *wsQueryMessage) {
+	s.Lock()
+	if s.err != nil {
+		s.Unlock()
+		return
+	}
+	s.seq++
+	message.Seq = s.seq
+	s.Unlock()
+	s.conn.WriteJSON(message)
+}
+
+// fail records the first error of the stream, cancels the query and wakes up blocked senders.
+func (s *queryStream) fail(err error) {
+	s.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.Unlock()
+	s.cancel()
+	s.cond.Broadcast()
+}
+
+// readAcks reads acknowledgements of the client until the connection is closed.
+func (s *queryStream) readAcks(pingInterval time.Duration) {
+	for {
+		var message wsClientMessage
+		if err := s.conn.ReadJSON(&message); err != nil {
+			s.fail(utils.StackError(err, "query stream closed by client"))
+			return
+		}
+		s.conn.SetReadDeadline(utils.Now().Add(2 * pingInterval))
+		s.Lock()
+		if message.Ack > s.acked {
+			s.acked = message.Ack
+		}
+		s.Unlock()
+		s.cond.Broadcast()
+	}
+}
+
+// ping pings the client periodically so that intermediaries do not close the idle connection.
+func (s *queryStream) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, utils.Now().Add(interval)); err != nil {
+				s.fail(utils.StackError(err, "failed to ping query stream"))
+				return
+			}
+		}
+	}
+}
+
+// close closes the stream normally.
+func (s *queryStream) close() {
+	close(s.closed)
+	s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), utils.Now().Add(time.Second))
+}
+
+// streamWriter is a http.ResponseWriter converting results written by query plans into messages.
+// Results of non aggregation queries are decoded while being written and sent as rows messages,
+// other results are buffered and sent as a single result message.
+type streamWriter struct {
+	stream         *queryStream
+	rowsPerMessage int
+	header         http.Header
+
+	// beginning of the result until it's known whether it's a non aggregation result.
+	prefix []byte
+	// result of aggregation queries.
+	buffer *bytes.Buffer
+	// pipe to the decoder of non aggregation results.
+	pipe    *io.PipeWriter
+	decoded chan error
+	cursor  string
+}
+
+func newStreamWriter(stream *queryStream, rowsPerMessage int) *streamWriter {
+	return &streamWriter{
+		stream:         stream,
+		rowsPerMessage: rowsPerMessage,
+		header:         http.Header{},
+	}
+}
+
+// Header returns the header of the response.
+func (sw *streamWriter) Header() http.Header {
+	return sw.header
+}
+
+// WriteHeader ignores status codes, which are decided by errors returned by executors.
+func (sw *streamWriter) WriteHeader(statusCode int) {
+}
+
+// Write writes the result to the decoder of non aggregation results or the buffer.
+func (sw *streamWriter) Write(data []byte) (int, error) {
+	if sw.pipe != nil {
+		return sw.pipe.Write(data)
+	}
+	if sw.buffer != nil {
+		return sw.buffer.Write(data)
+	}
+
+	sw.prefix = append(sw.prefix, data...)
+	if len(sw.prefix) < len(nonAggResultPrefix) && bytes.HasPrefix(nonAggResultPrefix, sw.prefix) {
+		return len(data), nil
+	}
+	prefix := sw.prefix
+	sw.prefix = nil
+	if bytes.HasPrefix(prefix, nonAggResultPrefix) {
+		reader, writer := io.Pipe()
+		sw.pipe, sw.decoded = writer, make(chan error, 1)
+		go func() {
+			err := sw.decodeNonAggResult(reader)
+			// unblock the plan if the result can not be sent.
+			reader.CloseWithError(err)
+			sw.decoded <- err
+		}()
+		if _, err := sw.pipe.Write(prefix); err != nil {
+			return 0, err
+		}
+	} else {
+		sw.buffer = bytes.NewBuffer(prefix)
+	}
+	return len(data), nil
+}
+
+// finish sends the rest of the result after the plan finished writing.
+func (sw *streamWriter) finish() error {
+	if sw.pipe != nil {
+		sw.pipe.Close()
+		return <-sw.decoded
+	}
+	if sw.buffer == nil {
+		sw.buffer = bytes.NewBuffer(sw.prefix)
+	}
+	if sw.buffer.Len() == 0 {
+		return nil
+	}
+	return sw.stream.send(&wsQueryMessage{
+		Type:   wsMessageResult,
+		Result: json.RawMessage(sw.buffer.Bytes()),
+	})
+}
+
+// decodeNonAggResult decodes the non aggregation result as it is written, rows are sent once there are
+// rowsPerMessage rows, or no more rows are written yet.
+func (sw *streamWriter) decodeNonAggResult(reader io.Reader) error {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return utils.StackError(err, "failed to decode query result")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return utils.StackError(err, "failed to decode query result")
+		}
+		switch token {
+		case queryCom.HeadersKey:
+			var headers []string
+			if err = decoder.Decode(&headers); err == nil {
+				err = sw.stream.send(&wsQueryMessage{Type: wsMessageHeaders, Headers: headers})
+			}
+		case queryCom.MatrixDataKey:
+			err = sw.decodeRows(decoder)
+		case queryCom.CursorKey:
+			err = decoder.Decode(&sw.cursor)
+		default:
+			var value json.RawMessage
+			err = decoder.Decode(&value)
+		}
+		if err != nil {
+			return utils.StackError(err, "failed to stream query result")
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+func (sw *streamWriter) decodeRows(decoder *json.Decoder) error {
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	var rows []json.RawMessage
+	for decoder.More() {
+		var row json.RawMessage
+		if err := decoder.Decode(&row); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		if len(rows) >= sw.rowsPerMessage || !hasBufferedRow(decoder) {
+			if err := sw.stream.send(&wsQueryMessage{Type: wsMessageRows, Rows: rows}); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+// hasBufferedRow tells whether the next row is already written, otherwise decoding it would wait for
+// the plan to write more rows.
+func hasBufferedRow(decoder *json.Decoder) bool {
+	buffered := decoder.Buffered().(io.ByteReader)
+	for {
+		b, err := buffered.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', ',':
+			continue
+		default:
+			return b != ']'
+		}
+	}
+}
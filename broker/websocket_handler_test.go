@@ -0,0 +1,219 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/broker/config"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("websocket query handler", func() {
+	aqlMessage := `{"query": {"table": "trips", "dimensions": [{"sqlExpression": "city_id"}]}}`
+
+	var server *httptest.Server
+	var conn *websocket.Conn
+	// handlers of hijacked connections are not waited by closing the server.
+	var handlers sync.WaitGroup
+
+	serve := func(handler WebSocketQueryHandler) *websocket.Conn {
+		router := mux.NewRouter()
+		handler.Register(router.PathPrefix("/query").Subrouter(), utils.WithMetricsFunc)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers.Add(1)
+			defer handlers.Done()
+			router.ServeHTTP(w, r)
+		}))
+		var err error
+		conn, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/query/stream", nil)
+		Ω(err).Should(BeNil())
+		return conn
+	}
+
+	receive := func(conn *websocket.Conn) wsQueryMessage {
+		var message wsQueryMessage
+		Ω(conn.ReadJSON(&message)).Should(BeNil())
+		return message
+	}
+
+	ack := func(conn *websocket.Conn, seq int64) {
+		Ω(conn.WriteJSON(wsClientMessage{Ack: seq})).Should(BeNil())
+	}
+
+	rows := func(message wsQueryMessage) string {
+		bs, err := json.Marshal(message.Rows)
+		Ω(err).Should(BeNil())
+		return string(bs)
+	}
+
+	ginkgo.AfterEach(func() {
+		conn.Close()
+		server.Close()
+		handlers.Wait()
+	})
+
+	ginkgo.It("should stream non aggregation results", func() {
+		conn := serve(NewWebSocketQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			Ω(aql.Table).Should(Equal("trips"))
+			w.Write([]byte(`{"headers":["city_id"],"matrixData":[`))
+			w.Write([]byte(`[1],[2],[3]`))
+			w.Write([]byte(`,[4]`))
+			w.Write([]byte(`],"cursor":"next"}`))
+			return nil
+		}), config.WebSocketConfig{MaxRowsPerMessage: 2}))
+		defer conn.Close()
+		Ω(conn.WriteMessage(websocket.TextMessage, []byte(aqlMessage))).Should(BeNil())
+
+		message := receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageHeaders))
+		Ω(message.Seq).Should(BeEquivalentTo(1))
+		Ω(message.Headers).Should(Equal([]string{"city_id"}))
+
+		message = receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageRows))
+		Ω(message.Seq).Should(BeEquivalentTo(2))
+		Ω(rows(message)).Should(Equal(`[[1],[2]]`))
+
+		// rows are sent without waiting for more rows.
+		message = receive(conn)
+		Ω(rows(message)).Should(Equal(`[[3]]`))
+		message = receive(conn)
+		Ω(rows(message)).Should(Equal(`[[4]]`))
+
+		message = receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageComplete))
+		Ω(message.Seq).Should(BeEquivalentTo(5))
+		Ω(message.Cursor).Should(Equal("next"))
+		Ω(message.Metadata).ShouldNot(BeNil())
+		Ω(message.Metadata.Partial).Should(BeFalse())
+	})
+
+	ginkgo.It("should send aggregation results and errors", func() {
+		conn := serve(NewWebSocketQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			w.Header().Set(utils.HTTPQueryWarningHeaderKey, "partial results without shards [1]")
+			w.Write([]byte(`{"1": 2}`))
+			return nil
+		}), config.WebSocketConfig{}))
+		defer conn.Close()
+		Ω(conn.WriteMessage(websocket.TextMessage, []byte(aqlMessage))).Should(BeNil())
+
+		message := receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageResult))
+		Ω(string(message.Result)).Should(MatchJSON(`{"1": 2}`))
+		message = receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageComplete))
+		Ω(message.Metadata.Partial).Should(BeTrue())
+		Ω(message.Metadata.Warnings).Should(Equal([]string{"partial results without shards [1]"}))
+		server.Close()
+
+		conn = serve(NewWebSocketQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			return utils.WithCode(utils.ErrCodeInvalidQuery, utils.StackError(nil, "unknown table trips"))
+		}), config.WebSocketConfig{}))
+		defer conn.Close()
+		Ω(conn.WriteMessage(websocket.TextMessage, []byte(aqlMessage))).Should(BeNil())
+		message = receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageError))
+		Ω(message.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(message.Error.Message).Should(ContainSubstring("unknown table trips"))
+		server.Close()
+
+		// the first message must be the query.
+		conn = serve(NewWebSocketQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			ginkgo.Fail("query should not be executed")
+			return nil
+		}), config.WebSocketConfig{}))
+		defer conn.Close()
+		ack(conn, 1)
+		message = receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageError))
+		Ω(message.Error.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+	})
+
+	ginkgo.It("should bound messages not acknowledged", func() {
+		done := make(chan struct{})
+		conn := serve(NewWebSocketQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			w.Write([]byte(`{"headers":["city_id"],"matrixData":[`))
+			w.Write([]byte(`[1]`))
+			w.Write([]byte(`,[2]`))
+			w.Write([]byte(`]}`))
+			close(done)
+			return nil
+		}), config.WebSocketConfig{MaxInFlightMessages: 1}))
+		defer conn.Close()
+		Ω(conn.WriteMessage(websocket.TextMessage, []byte(aqlMessage))).Should(BeNil())
+
+		message := receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageHeaders))
+		// the plan is blocked until messages are acknowledged.
+		Consistently(done, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		ack(conn, message.Seq)
+		message = receive(conn)
+		Ω(rows(message)).Should(Equal(`[[1]]`))
+		Consistently(done, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		ack(conn, message.Seq)
+		message = receive(conn)
+		Ω(rows(message)).Should(Equal(`[[2]]`))
+		Eventually(done).Should(BeClosed())
+		message = receive(conn)
+		Ω(message.Type).Should(Equal(wsMessageComplete))
+	})
+
+	ginkgo.It("should ping clients and cancel queries once clients are gone", func() {
+		cancelled := make(chan struct{})
+		handler := NewWebSocketQueryHandler(funcQueryExecutor(func(ctx context.Context, aql *queryCom.AQLQuery, w http.ResponseWriter) error {
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		}), config.WebSocketConfig{})
+		handler.pingInterval = 20 * time.Millisecond
+		conn := serve(handler)
+
+		pinged := make(chan struct{})
+		var once sync.Once
+		conn.SetPingHandler(func(data string) error {
+			once.Do(func() { close(pinged) })
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		Ω(conn.WriteMessage(websocket.TextMessage, []byte(aqlMessage))).Should(BeNil())
+		go func() {
+			// control messages are handled while reading.
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		Eventually(pinged).Should(BeClosed())
+		// answered pings keep the connection open.
+		Consistently(cancelled, 100*time.Millisecond).ShouldNot(BeClosed())
+		conn.Close()
+		Eventually(cancelled).Should(BeClosed())
+	})
+})
@@ -275,7 +275,9 @@ func (c *connector) dataPath(tableName string, shard int) string {
 	return fmt.Sprintf("http://%s/data/%s/%d", c.cfg.Address, tableName, shard)
 }
 
-func (u *UpsertBatchBuilderImpl) prepareEnumCases(tableName, columnName string, colIndex, columnID int, rows []Row, abandonRows map[int]struct{}, caseInsensitive bool, disableAutoExpand bool) error {
+// collectEnumCases returns the distinct enum cases of the column in rows, rows with non string enum
+// values are abandoned.
+func (u *UpsertBatchBuilderImpl) collectEnumCases(tableName string, colIndex, columnID int, rows []Row, abandonRows map[int]struct{}, caseInsensitive bool) []string {
 	enumCaseSet := make(map[string]struct{})
 	for rowIndex, row := range rows {
 		if _, exist := abandonRows[rowIndex]; exist {
@@ -294,7 +296,7 @@ func (u *UpsertBatchBuilderImpl) prepareEnumCases(tableName, columnName string,
 			enumCaseSet[enumCase] = struct{}{}
 		} else {
 			u.logger.With(
-				"name", "prepareEnumCases",
+				"name", "collectEnumCases",
 				"error", "Enum value should be string",
 				"table", tableName,
 				"columnID", columnID,
@@ -305,17 +307,11 @@ func (u *UpsertBatchBuilderImpl) prepareEnumCases(tableName, columnName string,
 		}
 	}
 
-	if len(enumCaseSet) > 0 {
-		enumCases := make([]string, 0, len(enumCaseSet))
-		for enumCase := range enumCaseSet {
-			enumCases = append(enumCases, enumCase)
-		}
-		err := u.schemaHandler.PrepareEnumCases(tableName, columnName, enumCases)
-		if err != nil {
-			return err
-		}
+	enumCases := make([]string, 0, len(enumCaseSet))
+	for enumCase := range enumCaseSet {
+		enumCases = append(enumCases, enumCase)
 	}
-	return nil
+	return enumCases
 }
 
 // PrepareUpsertBatch prepares the upsert batch for upsert,
@@ -332,6 +328,8 @@ func (u *UpsertBatchBuilderImpl) PrepareUpsertBatch(tableName string, columnName
 
 	// use abandonRows to record abandoned row index due to invalid data
 	abandonRows := make(map[int]struct{})
+	// enum cases of all enum columns are prepared at once.
+	enumCases := make(map[string][]string)
 
 	for colIndex, columnName := range columnNames {
 		columnID, exist := schema.ColumnDict[columnName]
@@ -359,12 +357,18 @@ func (u *UpsertBatchBuilderImpl) PrepareUpsertBatch(tableName string, columnName
 		}
 
 		if column.IsEnumColumn() {
-			if err = u.prepareEnumCases(tableName, columnName, colIndex, columnID, rows, abandonRows, column.CaseInsensitive, column.DisableAutoExpand); err != nil {
-				return nil, 0, err
+			if columnEnumCases := u.collectEnumCases(tableName, colIndex, columnID, rows, abandonRows, column.CaseInsensitive); len(columnEnumCases) > 0 {
+				enumCases[columnName] = columnEnumCases
 			}
 		}
 	}
 
+	if len(enumCases) > 0 {
+		if err = u.schemaHandler.PrepareEnumCasesBulk(tableName, enumCases); err != nil {
+			return nil, 0, err
+		}
+	}
+
 	for rowIndex, row := range rows {
 		if _, exist := abandonRows[rowIndex]; exist {
 			continue
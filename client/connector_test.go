@@ -93,6 +93,11 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 	// extendedEnumIDs
 	column2extendedEnumIDs := []int{2}
 
+	// whether the server supports extending enum cases in bulk, and requests extending enum cases.
+	var bulkEnumCasesSupported bool
+	var bulkEnumCasesRequests []metaCom.BulkEnumCases
+	var enumCasesRequests int
+
 	var insertBytes []byte
 	ginkgo.BeforeEach(func() {
 		testServer = httptest.NewUnstartedServer(
@@ -105,6 +110,23 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 					tableBytes, _ := json.Marshal(testTables["a"])
 					w.WriteHeader(http.StatusOK)
 					w.Write(tableBytes)
+				} else if strings.HasSuffix(r.URL.Path, "tables/a/enum-cases") && r.Method == http.MethodPost {
+					if !bulkEnumCasesSupported {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					var request metaCom.BulkEnumCases
+					json.NewDecoder(r.Body).Decode(&request)
+					bulkEnumCasesRequests = append(bulkEnumCasesRequests, request)
+					enumIDs := make(map[string][]int)
+					for column, enumCases := range request.EnumCases {
+						for i := range enumCases {
+							enumIDs[column] = append(enumIDs[column], column2extendedEnumIDs[0]+i)
+						}
+					}
+					enumIDBytes, _ := json.Marshal(enumIDs)
+					w.WriteHeader(http.StatusOK)
+					w.Write(enumIDBytes)
 				} else if strings.HasSuffix(r.URL.Path, "enum-cases") {
 					if r.Method == http.MethodGet {
 						column := string(re.FindSubmatch([]byte(r.URL.Path))[1])
@@ -116,6 +138,7 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 						w.WriteHeader(http.StatusOK)
 						w.Write(enumBytes)
 					} else if r.Method == http.MethodPost {
+						enumCasesRequests++
 						enumIDBytes, _ := json.Marshal(column2extendedEnumIDs)
 						w.WriteHeader(http.StatusOK)
 						w.Write(enumIDBytes)
@@ -132,6 +155,9 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 			}))
 		testServer.Start()
 		hostPort = testServer.Listener.Addr().String()
+		bulkEnumCasesSupported = true
+		bulkEnumCasesRequests = nil
+		enumCasesRequests = 0
 	})
 
 	ginkgo.AfterEach(func() {
@@ -259,6 +285,51 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 		Ω(n).Should(Equal(1))
 	})
 
+	ginkgo.It("PrepareEnumCasesBulk should extend enum cases not in cache at once", func() {
+		logger := zap.NewExample().Sugar()
+		rootScope, _, _ := common.NewNoopMetrics().NewRootScope()
+		schemaHandler := NewCachedSchemaHandler(logger, rootScope, NewHttpSchemaFetcher(http.Client{}, hostPort, rootScope))
+		Ω(schemaHandler.Start(0)).Should(BeNil())
+
+		err := schemaHandler.PrepareEnumCasesBulk("a", map[string][]string{
+			"col2": {"1", "2", "3"},
+			"col4": {"b"},
+		})
+		Ω(err).Should(BeNil())
+		Ω(bulkEnumCasesRequests).Should(Equal([]metaCom.BulkEnumCases{
+			{EnumCases: map[string][]string{"col2": {"2", "3"}}},
+		}))
+		Ω(schemaHandler.TranslateEnum("a", 3, "3", false)).Should(Equal(3))
+
+		// enum cases in cache or ignored for columns without auto expansion are not requested again.
+		err = schemaHandler.PrepareEnumCasesBulk("a", map[string][]string{
+			"col2": {"2", "3"},
+			"col4": {"b"},
+		})
+		Ω(err).Should(BeNil())
+		Ω(bulkEnumCasesRequests).Should(HaveLen(1))
+
+		// falls back to extending enum cases column by column.
+		bulkEnumCasesSupported = false
+		err = schemaHandler.PrepareEnumCasesBulk("a", map[string][]string{
+			"col2": {"4"},
+		})
+		Ω(err).Should(BeNil())
+		Ω(enumCasesRequests).Should(Equal(1))
+		Ω(schemaHandler.TranslateEnum("a", 3, "4", false)).Should(Equal(2))
+	})
+
+	ginkgo.It("splitEnumCases should work", func() {
+		Ω(splitEnumCases(map[string][]string{}, 2)).Should(BeEmpty())
+		Ω(splitEnumCases(map[string][]string{
+			"b": {"1", "2", "3"},
+			"a": {"x"},
+		}, 2)).Should(Equal([]map[string][]string{
+			{"a": {"x"}, "b": {"1"}},
+			{"b": {"2", "3"}},
+		}))
+	})
+
 	ginkgo.It("computeHLLValue should work", func() {
 		tests := [][]interface{}{
 			{memCom.UUID, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, uint32(329736)},
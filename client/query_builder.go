@@ -0,0 +1,230 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"time"
+)
+
+// QueryBuilder builds AQL queries fluently, e.g.
+//
+//	query, err := NewQueryBuilder("trips").
+//	    TimeDimension("day", "request_at", "day").
+//	    Dimension("city_id").
+//	    MeasureAs("trips", "count(*)").
+//	    Filter("status = 'completed'").
+//	    TimeRange("-7d", "now").
+//	    Build()
+type QueryBuilder struct {
+	query queryCom.AQLQuery
+}
+
+// NewQueryBuilder creates a QueryBuilder of the query on the table.
+func NewQueryBuilder(table string) *QueryBuilder {
+	return &QueryBuilder{
+		query: queryCom.AQLQuery{Table: table},
+	}
+}
+
+// Join joins the foreign table with the alias on the conditions.
+func (b *QueryBuilder) Join(table, alias string, conditions ...string) *QueryBuilder {
+	b.query.Joins = append(b.query.Joins, queryCom.Join{
+		Table:      table,
+		Alias:      alias,
+		Conditions: conditions,
+	})
+	return b
+}
+
+// Dimension groups by the expression, named by the expression in results.
+func (b *QueryBuilder) Dimension(expr string) *QueryBuilder {
+	return b.DimensionAs("", expr)
+}
+
+// DimensionAs groups by the expression named by the alias.
+func (b *QueryBuilder) DimensionAs(alias, expr string) *QueryBuilder {
+	b.query.Dimensions = append(b.query.Dimensions, queryCom.Dimension{
+		Alias: alias,
+		Expr:  expr,
+	})
+	return b
+}
+
+// TimeDimension groups by the time column bucketized by the time bucketizer (e.g. hour, day), the
+// designated time column of the main table is used if expr is empty.
+func (b *QueryBuilder) TimeDimension(alias, expr, timeBucketizer string) *QueryBuilder {
+	b.query.Dimensions = append(b.query.Dimensions, queryCom.Dimension{
+		Alias:          alias,
+		Expr:           expr,
+		TimeBucketizer: timeBucketizer,
+	})
+	return b
+}
+
+// Measure reports the aggregation expression, named by the expression in results.
+func (b *QueryBuilder) Measure(expr string, filters ...string) *QueryBuilder {
+	return b.MeasureAs("", expr, filters...)
+}
+
+// MeasureAs reports the aggregation expression named by the alias, with filters applied to the measure only.
+func (b *QueryBuilder) MeasureAs(alias, expr string, filters ...string) *QueryBuilder {
+	b.query.Measures = append(b.query.Measures, queryCom.Measure{
+		Alias:   alias,
+		Expr:    expr,
+		Filters: filters,
+	})
+	return b
+}
+
+// Filter adds row filters ANDed together for all measures.
+func (b *QueryBuilder) Filter(filters ...string) *QueryBuilder {
+	b.query.Filters = append(b.query.Filters, filters...)
+	return b
+}
+
+// TimeRange filters rows by the designated time column of the main table, from and to are both inclusive.
+func (b *QueryBuilder) TimeRange(from, to string) *QueryBuilder {
+	return b.TimeRangeOn("", from, to)
+}
+
+// TimeRangeOn filters rows by the time column, from and to are both inclusive.
+func (b *QueryBuilder) TimeRangeOn(column, from, to string) *QueryBuilder {
+	b.query.TimeFilter = queryCom.TimeFilter{
+		Column: column,
+		From:   from,
+		To:     to,
+	}
+	return b
+}
+
+// Timezone sets the timezone to convert timestamps to calendar time.
+func (b *QueryBuilder) Timezone(timezone string) *QueryBuilder {
+	b.query.Timezone = timezone
+	return b
+}
+
+// Now overrides now in unix seconds for relative time ranges.
+func (b *QueryBuilder) Now(now int64) *QueryBuilder {
+	b.query.Now = now
+	return b
+}
+
+// Sort sorts non aggregation results by the field in the order of either asc or desc, aggregation
+// results with limits are sorted by the measure if the field is the measure.
+func (b *QueryBuilder) Sort(name, order string) *QueryBuilder {
+	b.query.Sorts = append(b.query.Sorts, queryCom.SortField{
+		Name:  name,
+		Order: order,
+	})
+	return b
+}
+
+// Limit limits the number of rows of non aggregation results, or the number of buckets of aggregation
+// results sorted by the measure.
+func (b *QueryBuilder) Limit(limit int) *QueryBuilder {
+	b.query.Limit = limit
+	return b
+}
+
+// Offset skips the leading rows of non aggregation results, rows skipped are not counted by the limit.
+func (b *QueryBuilder) Offset(offset int) *QueryBuilder {
+	b.query.Offset = offset
+	return b
+}
+
+// Shards restricts the query to the shards.
+func (b *QueryBuilder) Shards(shards ...int) *QueryBuilder {
+	b.query.Shards = append(b.query.Shards, shards...)
+	return b
+}
+
+// IncludeDeprecatedColumns allows deprecated columns to be referenced.
+func (b *QueryBuilder) IncludeDeprecatedColumns() *QueryBuilder {
+	b.query.IncludeDeprecatedColumns = true
+	return b
+}
+
+// BucketCompleteness requests whether each time bucket of the first dimension is complete by data freshness,
+// reported in the metadata of aggregation results.
+func (b *QueryBuilder) BucketCompleteness() *QueryBuilder {
+	b.query.BucketCompleteness = true
+	return b
+}
+
+// FillTimeBuckets requests missing time buckets of the first dimension to be filled within the time filter,
+// with 0 for count and sum and null for other aggregations.
+func (b *QueryBuilder) FillTimeBuckets() *QueryBuilder {
+	b.query.FillTimeBuckets = true
+	return b
+}
+
+// DedupRows requests rows of non aggregation queries duplicated across datanodes to be dropped, e.g. rows of
+// shards moving between datanodes.
+func (b *QueryBuilder) DedupRows() *QueryBuilder {
+	b.query.DedupRows = true
+	return b
+}
+
+// PartialResults overrides whether results of datanodes succeeded are returned when other datanodes fail,
+// with errors of failed datanodes in the metadata of the result. Paginated, avg and countdistinct queries
+// are rejected if partial results are requested.
+func (b *QueryBuilder) PartialResults(allow bool) *QueryBuilder {
+	b.query.PartialResults = &allow
+	return b
+}
+
+// Timeout bounds how long the query runs in milliseconds, the query fails with the datanodes not responded
+// once it is exceeded. Queries without timeout run up to the default timeout of broker.
+func (b *QueryBuilder) Timeout(timeout time.Duration) *QueryBuilder {
+	b.query.TimeoutMillis = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	return b
+}
+
+// Build validates and returns a copy of the query.
+func (b *QueryBuilder) Build() (*queryCom.AQLQuery, error) {
+	if b.query.Table == "" {
+		return nil, utils.StackError(nil, "table of the query is required")
+	}
+	if b.query.TimeoutMillis < 0 {
+		return nil, utils.StackError(nil, "timeout of the query must not be negative")
+	}
+	if b.query.Offset < 0 {
+		return nil, utils.StackError(nil, "offset of the query must not be negative")
+	}
+	if len(b.query.Measures) == 0 {
+		return nil, utils.StackError(nil, "at least one measure is required, use count(*) or 1 for non aggregation queries")
+	}
+	for _, dim := range b.query.Dimensions {
+		if dim.Expr == "" && dim.TimeBucketizer == "" {
+			return nil, utils.StackError(nil, "expression of dimension %s is required", dim.Alias)
+		}
+	}
+	for _, measure := range b.query.Measures {
+		if measure.Expr == "" {
+			return nil, utils.StackError(nil, "expression of measure %s is required", measure.Alias)
+		}
+	}
+
+	query := b.query
+	query.Joins = append([]queryCom.Join(nil), b.query.Joins...)
+	query.Dimensions = append([]queryCom.Dimension(nil), b.query.Dimensions...)
+	query.Measures = append([]queryCom.Measure(nil), b.query.Measures...)
+	query.Filters = append([]string(nil), b.query.Filters...)
+	query.Sorts = append([]queryCom.SortField(nil), b.query.Sorts...)
+	query.Shards = append([]int(nil), b.query.Shards...)
+	return &query, nil
+}
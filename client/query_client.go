@@ -0,0 +1,261 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/uber-go/tally"
+	apiCom "github.com/uber/aresdb/api/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// default number of retries of retriable query failures.
+	defaultQueryMaxRetries = 2
+	// default backoff in milliseconds before the first retry, doubled for each following retry.
+	defaultQueryRetryBackoff = 100
+	// default max idle connections kept to the broker.
+	defaultMaxIdleConns = 16
+	callerHeader        = "RPC-Caller"
+)
+
+// QueryClient queries AresDB through the v2 query api of brokers.
+type QueryClient interface {
+	// Query runs the query and returns the result. Retriable failures are retried, and failures
+	// reported by the server are returned as *apiCom.QueryErrorV2.
+	Query(ctx context.Context, query *queryCom.AQLQuery) (*QueryResult, error)
+	// QueryPages runs the non aggregation query page by page of pageSize rows, and calls fn with rows
+	// of each page until the last page or fn returns an error.
+	QueryPages(ctx context.Context, query *queryCom.AQLQuery, pageSize int, fn func(rows *Rows) error) error
+}
+
+// QueryClientConfig holds the configurations for ares QueryClient.
+type QueryClientConfig struct {
+	// Address of the broker in the format of host:port.
+	Address string `yaml:"address" json:"address"`
+	// Timeout in seconds of each http call, if <= 0, will use default.
+	Timeout int `yaml:"timeout" json:"timeout"`
+	// MaxRetries is the number of retries of retriable failures, if < 0, will not retry.
+	MaxRetries int `yaml:"maxRetries" json:"maxRetries"`
+	// RetryBackoff in milliseconds before the first retry, doubled for each following retry.
+	// if <= 0, will use default
+	RetryBackoff int `yaml:"retryBackoff" json:"retryBackoff"`
+	// MaxIdleConns is the number of idle connections kept to the broker, if <= 0, will use default.
+	MaxIdleConns int `yaml:"maxIdleConns" json:"maxIdleConns"`
+	// Caller is sent as the Rpc-Caller header if set.
+	Caller string `yaml:"caller" json:"caller"`
+}
+
+// queryClient is the QueryClient implementation.
+type queryClient struct {
+	cfg         QueryClientConfig
+	httpClient  http.Client
+	logger      *zap.SugaredLogger
+	metricScope tally.Scope
+}
+
+// NewQueryClient returns a new ares QueryClient.
+func (cfg QueryClientConfig) NewQueryClient(logger *zap.SugaredLogger, metricScope tally.Scope) QueryClient {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRequestTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultQueryMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultQueryRetryBackoff
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+
+	// connections to the broker are pooled by the transport.
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &queryClient{
+		cfg: cfg,
+		httpClient: http.Client{
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+			Transport: transport,
+		},
+		logger:      logger,
+		metricScope: metricScope,
+	}
+}
+
+// queryResponse is the v2 query response with results to be decoded.
+type queryResponse struct {
+	Results  []queryCom.AQLQueryResult `json:"results"`
+	Error    *apiCom.QueryErrorV2      `json:"error"`
+	Metadata apiCom.QueryMetadataV2    `json:"metadata"`
+}
+
+// Query runs the query and returns the result.
+func (c *queryClient) Query(ctx context.Context, query *queryCom.AQLQuery) (*QueryResult, error) {
+	return c.query(ctx, query, 0, "")
+}
+
+// QueryPages runs the non aggregation query page by page.
+func (c *queryClient) QueryPages(ctx context.Context, query *queryCom.AQLQuery, pageSize int,
+	fn func(rows *Rows) error) error {
+	if pageSize <= 0 {
+		return utils.StackError(nil, "page size should be positive, got %d", pageSize)
+	}
+
+	var cursor string
+	for {
+		result, err := c.query(ctx, query, pageSize, cursor)
+		if err != nil {
+			return err
+		}
+		rows, err := result.NonAggRows()
+		if err != nil {
+			return err
+		}
+		if err = fn(rows); err != nil {
+			return err
+		}
+		if cursor = result.Cursor(); cursor == "" {
+			return nil
+		}
+	}
+}
+
+// query runs the query, retrying retriable failures with exponential backoff.
+func (c *queryClient) query(ctx context.Context, query *queryCom.AQLQuery, pageSize int, cursor string) (
+	*QueryResult, error) {
+	body, err := json.Marshal(map[string]interface{}{"query": query})
+	if err != nil {
+		return nil, utils.StackError(err, "failed to encode query")
+	}
+
+	backoff := time.Duration(c.cfg.RetryBackoff) * time.Millisecond
+	start := utils.Now()
+	for attempt := 0; ; attempt++ {
+		result, retriable, err := c.queryOnce(ctx, query, body, pageSize, cursor)
+		if err == nil {
+			c.metricScope.Counter("query_succeeded").Inc(1)
+			c.metricScope.Timer("query_latency").Record(utils.Now().Sub(start))
+			return result, nil
+		}
+		if !retriable || attempt >= c.cfg.MaxRetries {
+			c.metricScope.Counter("query_failed").Inc(1)
+			return nil, err
+		}
+
+		c.metricScope.Counter("query_retried").Inc(1)
+		c.logger.With("error", err, "table", query.Table, "attempt", attempt+1).Warn("Retrying query")
+		select {
+		case <-ctx.Done():
+			return nil, utils.StackError(ctx.Err(), "query cancelled while retrying: %s", err.Error())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// queryOnce sends the query to the broker, and returns whether the failure is retriable.
+func (c *queryClient) queryOnce(ctx context.Context, query *queryCom.AQLQuery, body []byte, pageSize int,
+	cursor string) (*QueryResult, bool, error) {
+	req, err := http.NewRequest(http.MethodPost, c.queryURL(query, pageSize, cursor), bytes.NewReader(body))
+	if err != nil {
+		return nil, false, utils.StackError(err, "failed to create query request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(utils.HTTPContentTypeHeaderKey, applicationJSONHeader)
+	if c.cfg.Caller != "" {
+		req.Header.Set(callerHeader, c.cfg.Caller)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// transport failures are retriable unless the context is done.
+		return nil, ctx.Err() == nil, utils.StackError(err, "failed to send query to %s", c.cfg.Address)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ctx.Err() == nil, utils.StackError(err, "failed to read query response from %s", c.cfg.Address)
+	}
+
+	var response queryResponse
+	if err = json.Unmarshal(respBody, &response); err != nil {
+		// e.g. errors from proxies in between, retry on server errors only.
+		return nil, resp.StatusCode >= http.StatusInternalServerError, utils.StackError(err,
+			"failed to decode query response of status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if response.Error != nil {
+		return nil, response.Error.Retriable, response.Error
+	}
+	if resp.StatusCode != http.StatusOK || len(response.Results) == 0 {
+		return nil, false, utils.StackError(nil, "unexpected query response of status %d: %s",
+			resp.StatusCode, string(respBody))
+	}
+	return &QueryResult{
+		Query:    query,
+		Result:   response.Results[0],
+		Metadata: response.Metadata,
+	}, false, nil
+}
+
+func (c *queryClient) queryURL(query *queryCom.AQLQuery, pageSize int, cursor string) string {
+	params := url.Values{}
+	if query.BucketCompleteness {
+		params.Set("bucketCompleteness", "1")
+	}
+	if query.FillTimeBuckets {
+		params.Set("fillTimeBuckets", "1")
+	}
+	if query.DedupRows {
+		params.Set("dedupRows", "1")
+	}
+	if query.PartialResults != nil {
+		params.Set("partialResults", strconv.FormatBool(*query.PartialResults))
+	}
+	if query.TimeoutMillis > 0 {
+		params.Set("timeout", strconv.Itoa(query.TimeoutMillis))
+	}
+	if pageSize > 0 {
+		params.Set("pageSize", strconv.Itoa(pageSize))
+	}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	queryURL := fmt.Sprintf("http://%s/v2/query/aql", c.cfg.Address)
+	if len(params) > 0 {
+		queryURL += "?" + params.Encode()
+	}
+	return queryURL
+}
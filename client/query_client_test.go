@@ -0,0 +1,338 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	apiCom "github.com/uber/aresdb/api/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"go.uber.org/zap"
+)
+
+// fakeBroker serves the v2 query api with the responses in order, the last response is repeated.
+type fakeBroker struct {
+	server    *httptest.Server
+	responses []string
+	codes     []int
+	requests  []*http.Request
+	queries   []queryCom.AQLQuery
+}
+
+func newFakeBroker() *fakeBroker {
+	broker := &fakeBroker{}
+	broker.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query queryCom.AQLQuery `json:"query"`
+		}
+		bs, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(bs, &body)
+		broker.queries = append(broker.queries, body.Query)
+		broker.requests = append(broker.requests, r)
+
+		i := len(broker.requests) - 1
+		if i >= len(broker.responses) {
+			i = len(broker.responses) - 1
+		}
+		w.WriteHeader(broker.codes[i])
+		w.Write([]byte(broker.responses[i]))
+	}))
+	return broker
+}
+
+func (b *fakeBroker) respond(code int, response string) *fakeBroker {
+	b.codes = append(b.codes, code)
+	b.responses = append(b.responses, response)
+	return b
+}
+
+func (b *fakeBroker) address() string {
+	return strings.TrimPrefix(b.server.URL, "http://")
+}
+
+var _ = ginkgo.Describe("AresDB query client", func() {
+	logger := zap.NewExample().Sugar()
+	var broker *fakeBroker
+
+	ginkgo.BeforeEach(func() {
+		broker = newFakeBroker()
+	})
+
+	ginkgo.AfterEach(func() {
+		broker.server.Close()
+	})
+
+	newClient := func() QueryClient {
+		return QueryClientConfig{
+			Address:      broker.address(),
+			RetryBackoff: 1,
+			Caller:       "test",
+		}.NewQueryClient(logger, tally.NoopScope)
+	}
+
+	ginkgo.It("QueryBuilder should work", func() {
+		_, err := NewQueryBuilder("").Measure("count(*)").Build()
+		Ω(err).ShouldNot(BeNil())
+		_, err = NewQueryBuilder("trips").Build()
+		Ω(err).ShouldNot(BeNil())
+		_, err = NewQueryBuilder("trips").Dimension("").Measure("count(*)").Build()
+		Ω(err).ShouldNot(BeNil())
+		_, err = NewQueryBuilder("trips").Measure("1").Offset(-1).Build()
+		Ω(err).ShouldNot(BeNil())
+
+		builder := NewQueryBuilder("trips").
+			TimeDimension("day", "request_at", "day").
+			DimensionAs("city", "city_id").
+			MeasureAs("trips", "count(*)", "status = 'completed'").
+			Filter("fare > 0").
+			TimeRange("-1d", "now").
+			Timezone("America/Los_Angeles")
+		query, err := builder.Build()
+		Ω(err).Should(BeNil())
+		Ω(*query).Should(Equal(queryCom.AQLQuery{
+			Table: "trips",
+			Dimensions: []queryCom.Dimension{
+				{Alias: "day", Expr: "request_at", TimeBucketizer: "day"},
+				{Alias: "city", Expr: "city_id"},
+			},
+			Measures: []queryCom.Measure{
+				{Alias: "trips", Expr: "count(*)", Filters: []string{"status = 'completed'"}},
+			},
+			Filters:    []string{"fare > 0"},
+			TimeFilter: queryCom.TimeFilter{From: "-1d", To: "now"},
+			Timezone:   "America/Los_Angeles",
+		}))
+
+		// built queries are not affected by the builder afterwards.
+		builder.Filter("city_id = 1")
+		Ω(query.Filters).Should(Equal([]string{"fare > 0"}))
+	})
+
+	ginkgo.It("Query should decode aggregation results", func() {
+		broker.respond(http.StatusOK, `{
+			"results": [{"2019-10-01": {"1": 3, "NULL": 1}, "2019-10-02": {"1": 5}}],
+			"metadata": {"requestID": "abc", "partial": true, "warnings": ["shard 1 missing"]}
+		}`)
+		query, err := NewQueryBuilder("trips").
+			TimeDimension("day", "", "day").
+			DimensionAs("city_id", "city_id").
+			MeasureAs("trips", "count(*)").
+			Build()
+		Ω(err).Should(BeNil())
+
+		result, err := newClient().Query(context.Background(), query)
+		Ω(err).Should(BeNil())
+		Ω(broker.queries[0]).Should(Equal(*query))
+		Ω(broker.requests[0].Header.Get("RPC-Caller")).Should(Equal("test"))
+		Ω(result.Metadata.RequestID).Should(Equal("abc"))
+		Ω(result.Metadata.Partial).Should(BeTrue())
+
+		rows, err := result.AggRows()
+		Ω(err).Should(BeNil())
+		Ω(rows.Columns).Should(Equal([]string{"day", "city_id", "trips"}))
+		Ω(rows.Values).Should(Equal([][]interface{}{
+			{"2019-10-01", "1", 3.0},
+			{"2019-10-01", nil, 1.0},
+			{"2019-10-02", "1", 5.0},
+		}))
+
+		type cityTrips struct {
+			Day    time.Time
+			CityID *int
+			Trips  int64
+			Ignore string `aresdb:"-"`
+		}
+		var decoded []cityTrips
+		Ω(rows.Decode(&decoded)).Should(BeNil())
+		one := 1
+		Ω(decoded).Should(Equal([]cityTrips{
+			{Day: time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC), CityID: &one, Trips: 3},
+			{Day: time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC), Trips: 1},
+			{Day: time.Date(2019, 10, 2, 0, 0, 0, 0, time.UTC), CityID: &one, Trips: 5},
+		}))
+
+		Ω(rows.Decode(decoded)).ShouldNot(BeNil())
+		var wrongType []struct {
+			Trips bool
+		}
+		Ω(rows.Decode(&wrongType)).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("Query should request bucket completeness", func() {
+		broker.respond(http.StatusOK, `{
+			"results": [{"2019-10-01 00:00": 3, "2019-10-01 01:00": 1}],
+			"metadata": {"requestID": "abc", "dataFreshness": 1569891700,
+				"bucketCompleteness": {"2019-10-01 00:00": true, "2019-10-01 01:00": false}}
+		}`)
+		query, err := NewQueryBuilder("trips").
+			TimeDimension("hour", "", "hour").
+			Measure("count(*)").
+			BucketCompleteness().
+			Build()
+		Ω(err).Should(BeNil())
+		Ω(query.BucketCompleteness).Should(BeTrue())
+
+		result, err := newClient().Query(context.Background(), query)
+		Ω(err).Should(BeNil())
+		Ω(broker.requests[0].URL.Query().Get("bucketCompleteness")).Should(Equal("1"))
+		Ω(result.Metadata.BucketCompleteness).Should(Equal(map[string]bool{
+			"2019-10-01 00:00": true,
+			"2019-10-01 01:00": false,
+		}))
+	})
+
+	ginkgo.It("Query should retry retriable errors", func() {
+		broker.respond(http.StatusServiceUnavailable,
+			`{"error": {"code": "RESOURCE_EXHAUSTED", "message": "no device", "retriable": true}}`).
+			respond(http.StatusBadGateway, `bad gateway`).
+			respond(http.StatusOK, `{"results": [{"NULL": 10}]}`)
+		query, err := NewQueryBuilder("trips").Measure("count(*)").Build()
+		Ω(err).Should(BeNil())
+
+		result, err := newClient().Query(context.Background(), query)
+		Ω(err).Should(BeNil())
+		Ω(broker.requests).Should(HaveLen(3))
+		rows, err := result.AggRows()
+		Ω(err).Should(BeNil())
+		Ω(rows.Values).Should(Equal([][]interface{}{{10.0}}))
+
+		// retries are capped.
+		broker.server.Close()
+		broker = newFakeBroker().respond(http.StatusServiceUnavailable,
+			`{"error": {"code": "UNAVAILABLE", "message": "shutting down", "retriable": true}}`)
+		_, err = newClient().Query(context.Background(), query)
+		Ω(err).ShouldNot(BeNil())
+		Ω(broker.requests).Should(HaveLen(defaultQueryMaxRetries + 1))
+	})
+
+	ginkgo.It("Query should return structured errors", func() {
+		broker.respond(http.StatusBadRequest,
+			`{"error": {"code": "INVALID_QUERY", "message": "unknown column foo", "retriable": false}}`)
+		query, err := NewQueryBuilder("trips").Measure("sum(foo)").Build()
+		Ω(err).Should(BeNil())
+
+		_, err = newClient().Query(context.Background(), query)
+		Ω(broker.requests).Should(HaveLen(1))
+		var queryErr *apiCom.QueryErrorV2
+		Ω(errors.As(err, &queryErr)).Should(BeTrue())
+		Ω(queryErr.Code).Should(Equal(utils.ErrCodeInvalidQuery))
+		Ω(queryErr.Message).Should(Equal("unknown column foo"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = newClient().Query(ctx, query)
+		Ω(err).ShouldNot(BeNil())
+		Ω(broker.requests).Should(HaveLen(1))
+	})
+
+	ginkgo.It("QueryPages should stream non aggregation rows", func() {
+		broker.respond(http.StatusOK, `{"results": [{
+				"headers": ["request_at", "city_id", "fare"],
+				"matrixData": [["1570000000", "1", "12.5"], ["1570000060", "NULL", "8"]],
+				"cursor": "page2"
+			}]}`).
+			respond(http.StatusOK, `{"results": [{
+				"headers": ["request_at", "city_id", "fare"],
+				"matrixData": [["1570000120", "2", "20"]]
+			}]}`)
+		query, err := NewQueryBuilder("trips").
+			Dimension("request_at").
+			Dimension("city_id").
+			Dimension("fare").
+			Measure("1").
+			Build()
+		Ω(err).Should(BeNil())
+
+		type trip struct {
+			RequestAt time.Time
+			City      uint32 `aresdb:"city_id"`
+			Fare      float64
+		}
+		var trips []*trip
+		var numPages int
+		err = newClient().QueryPages(context.Background(), query, 2, func(rows *Rows) error {
+			numPages++
+			return rows.Decode(&trips)
+		})
+		Ω(err).Should(BeNil())
+		Ω(numPages).Should(Equal(2))
+		Ω(broker.requests[0].URL.Query().Get("pageSize")).Should(Equal("2"))
+		Ω(broker.requests[0].URL.Query().Get("cursor")).Should(BeEmpty())
+		Ω(broker.requests[1].URL.Query().Get("cursor")).Should(Equal("page2"))
+		Ω(trips).Should(Equal([]*trip{
+			{RequestAt: time.Unix(1570000000, 0).UTC(), City: 1, Fare: 12.5},
+			{RequestAt: time.Unix(1570000060, 0).UTC(), Fare: 8},
+			{RequestAt: time.Unix(1570000120, 0).UTC(), City: 2, Fare: 20},
+		}))
+
+		err = newClient().QueryPages(context.Background(), query, 2, func(rows *Rows) error {
+			return errors.New("stop")
+		})
+		Ω(err).Should(Equal(errors.New("stop")))
+		Ω(newClient().QueryPages(context.Background(), query, 0, nil)).ShouldNot(BeNil())
+	})
+})
+
+func ExampleQueryClient() {
+	broker := newFakeBroker().respond(http.StatusOK, `{"results": [{"2019-10-01": {"1": 3, "2": 5}}]}`)
+	defer broker.server.Close()
+
+	query, err := NewQueryBuilder("trips").
+		TimeDimension("day", "", "day").
+		DimensionAs("city", "city_id").
+		MeasureAs("trips", "count(*)").
+		TimeRange("2019-10-01", "2019-10-01").
+		Build()
+	if err != nil {
+		panic(err)
+	}
+
+	client := QueryClientConfig{Address: broker.address()}.NewQueryClient(zap.NewNop().Sugar(), tally.NoopScope)
+	result, err := client.Query(context.Background(), query)
+	if err != nil {
+		panic(err)
+	}
+	rows, err := result.AggRows()
+	if err != nil {
+		panic(err)
+	}
+
+	var cityTrips []struct {
+		Day   string
+		City  int
+		Trips int
+	}
+	if err = rows.Decode(&cityTrips); err != nil {
+		panic(err)
+	}
+	for _, row := range cityTrips {
+		fmt.Println(row.Day, row.City, row.Trips)
+	}
+	// Output:
+	// 2019-10-01 1 3
+	// 2019-10-01 2 5
+}
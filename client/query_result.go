@@ -0,0 +1,337 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	apiCom "github.com/uber/aresdb/api/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// nullValue is how NULL dimension values are represented in query results.
+	nullValue = "NULL"
+	// structTagKey is the struct tag naming the column a field is decoded from, "-" to skip the field.
+	structTagKey = "aresdb"
+)
+
+// layouts of formatted time dimension values, besides unix seconds.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// QueryResult is the result of a query with the metadata of the response.
+type QueryResult struct {
+	Query    *queryCom.AQLQuery
+	Result   queryCom.AQLQueryResult
+	Metadata apiCom.QueryMetadataV2
+}
+
+// Rows are query results in rows. Values are strings, float64 for aggregation measures, or nil for NULL.
+type Rows struct {
+	Columns []string
+	Values  [][]interface{}
+}
+
+// AggRows flattens results of an aggregation query into rows of dimension values followed by the measure
+// value, sorted by dimension values. Columns are named by aliases of dimensions and the measure, or their
+// expressions if not aliased.
+func (r *QueryResult) AggRows() (*Rows, error) {
+	if len(r.Query.Measures) == 0 {
+		return nil, utils.StackError(nil, "query has no measure")
+	}
+
+	rows := &Rows{}
+	for _, dim := range r.Query.Dimensions {
+		rows.Columns = append(rows.Columns, columnName(dim.Alias, dim.Expr))
+	}
+	measure := r.Query.Measures[0]
+	rows.Columns = append(rows.Columns, columnName(measure.Alias, measure.Expr))
+
+	dimValues := make([]interface{}, len(r.Query.Dimensions))
+	if err := flattenAggResult(map[string]interface{}(r.Result), dimValues, 0, rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// NonAggRows returns results of a non aggregation query, columns are named by aliases of dimensions, or their
+// expressions if not aliased.
+func (r *QueryResult) NonAggRows() (*Rows, error) {
+	rows := &Rows{}
+	headers, ok := r.Result[queryCom.HeadersKey]
+	if !ok {
+		return rows, nil
+	}
+	if err := convertJSON(headers, &rows.Columns); err != nil {
+		return nil, utils.StackError(err, "invalid headers of non aggregation results")
+	}
+	if matrixData, ok := r.Result[queryCom.MatrixDataKey]; ok {
+		if err := convertJSON(matrixData, &rows.Values); err != nil {
+			return nil, utils.StackError(err, "invalid rows of non aggregation results")
+		}
+	}
+	for _, values := range rows.Values {
+		if len(values) != len(rows.Columns) {
+			return nil, utils.StackError(nil, "row has %d values, expects %d", len(values), len(rows.Columns))
+		}
+		for i, value := range values {
+			if value == nullValue {
+				values[i] = nil
+			}
+		}
+	}
+	return rows, nil
+}
+
+// Cursor returns the cursor of the next page of paginated non aggregation results, empty for the last page.
+func (r *QueryResult) Cursor() string {
+	cursor, _ := r.Result[queryCom.CursorKey].(string)
+	return cursor
+}
+
+func columnName(alias, expr string) string {
+	if alias != "" {
+		return alias
+	}
+	return expr
+}
+
+// flattenAggResult walks the nested results depth first, appending a row for each measure value.
+func flattenAggResult(node map[string]interface{}, dimValues []interface{}, depth int, rows *Rows) error {
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if len(dimValues) == 0 {
+			// results without dimensions are keyed by NULL.
+			rows.Values = append(rows.Values, []interface{}{node[key]})
+			continue
+		}
+		if key == nullValue {
+			dimValues[depth] = nil
+		} else {
+			dimValues[depth] = key
+		}
+		child := node[key]
+		if depth < len(dimValues)-1 {
+			childNode, ok := child.(map[string]interface{})
+			if !ok {
+				return utils.StackError(nil, "expects %d levels of dimensions, got %T at level %d",
+					len(dimValues), child, depth+1)
+			}
+			if err := flattenAggResult(childNode, dimValues, depth+1, rows); err != nil {
+				return err
+			}
+			continue
+		}
+		values := make([]interface{}, len(dimValues)+1)
+		copy(values, dimValues)
+		values[len(dimValues)] = child
+		rows.Values = append(rows.Values, values)
+	}
+	return nil
+}
+
+// Decode decodes rows into dest, which must be a pointer to a slice of structs or pointers to structs.
+// Columns are mapped to fields by the aresdb struct tag, or field names matched case insensitively
+// ignoring underscores. Columns without fields are ignored. Values are converted to the types of fields,
+// and NULL values are decoded as zero values or nil pointers.
+func (r *Rows) Decode(dest interface{}) error {
+	sliceValue := reflect.ValueOf(dest)
+	if sliceValue.Kind() != reflect.Ptr || sliceValue.Elem().Kind() != reflect.Slice {
+		return utils.StackError(nil, "expects pointer to slice, got %T", dest)
+	}
+	sliceValue = sliceValue.Elem()
+	elemType := sliceValue.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return utils.StackError(nil, "expects slice of structs, got %T", dest)
+	}
+
+	fieldIndexes := make([][]int, len(r.Columns))
+	fieldsByName := structFields(structType)
+	for i, column := range r.Columns {
+		fieldIndexes[i] = fieldsByName[normalizeName(column)]
+	}
+
+	for _, values := range r.Values {
+		structValue := reflect.New(structType).Elem()
+		for i, value := range values {
+			if i >= len(fieldIndexes) || fieldIndexes[i] == nil {
+				continue
+			}
+			if err := setValue(structValue.FieldByIndex(fieldIndexes[i]), value); err != nil {
+				return utils.StackError(err, "failed to decode column %s", r.Columns[i])
+			}
+		}
+		if elemType.Kind() == reflect.Ptr {
+			structValue = structValue.Addr()
+		}
+		sliceValue.Set(reflect.Append(sliceValue, structValue))
+	}
+	return nil
+}
+
+// structFields returns indexes of exported fields of the struct by normalized column names.
+func structFields(structType reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup(structTagKey); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		fields[normalizeName(name)] = field.Index
+	}
+	return fields
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// setValue converts the value to the type of the field and sets it.
+func setValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	if field.Kind() == reflect.Interface {
+		field.Set(reflect.ValueOf(value))
+		return nil
+	}
+	if field.Type() == timeType {
+		t, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case float64:
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		str = strconv.FormatBool(v)
+	case json.Number:
+		str = v.String()
+	default:
+		return utils.StackError(nil, "unsupported value %v of type %T", value, value)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 10, field.Type().Bits())
+		if err != nil {
+			// aggregation measures are floats even for integer columns.
+			f, floatErr := strconv.ParseFloat(str, 64)
+			if floatErr != nil || f != float64(int64(f)) {
+				return err
+			}
+			n = int64(f)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(str, 10, field.Type().Bits())
+		if err != nil {
+			f, floatErr := strconv.ParseFloat(str, 64)
+			if floatErr != nil || f < 0 || f != float64(uint64(f)) {
+				return err
+			}
+			n = uint64(f)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(str, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return utils.StackError(nil, "unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// parseTime parses unix seconds or formatted time dimension values in UTC.
+func parseTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case string:
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC(), nil
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, utils.StackError(nil, "invalid time value %v", value)
+}
+
+// convertJSON converts the decoded json value into out, e.g. []interface{} into []string.
+func convertJSON(value interface{}, out interface{}) error {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, out)
+}
@@ -17,9 +17,11 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,6 +33,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// ErrBulkEnumCasesNotSupported is returned by ExtendEnumCasesBulk if the server does not support
+// extending enum cases in bulk, callers should fall back to ExtendEnumCases
+var ErrBulkEnumCasesNotSupported = errors.New("server does not support extending enum cases in bulk")
+
 // SchemaFetcher is the interface for fetch schema and enums
 type SchemaFetcher interface {
 	// FetchAllSchemas fetches all schemas
@@ -41,6 +47,8 @@ type SchemaFetcher interface {
 	FetchAllEnums(tableName string, columnName string) ([]string, error)
 	// ExtendEnumCases extends enum cases to given table column
 	ExtendEnumCases(tableName, columnName string, enumCases []string) ([]int, error)
+	// ExtendEnumCasesBulk extends enum cases to multiple columns of given table at once
+	ExtendEnumCasesBulk(tableName string, enumCases map[string][]string) (map[string][]int, error)
 }
 
 // httpSchemaFetcher is a http based schema fetcher
@@ -69,6 +77,13 @@ type CachedSchemaHandler struct {
 	// map from table to columnID to default enum id. Initialized during bootstrap
 	// and will be set only if default value is non nil.
 	enumDefaultValueMappings map[string]map[int]int
+
+	// map from table to columnID to new enum cases ignored since enum auto expansion of the
+	// column is disabled, reset once enum cases of the table are fetched again.
+	ignoredEnumCases map[string]map[int]map[string]struct{}
+
+	// whether schemaFetcher falls back to extending enum cases column by column.
+	bulkEnumCasesNotSupported bool
 }
 
 // NewCachedSchemaHandler creates a new cached schema handler
@@ -81,6 +96,7 @@ func NewCachedSchemaHandler(logger *zap.SugaredLogger, scope tally.Scope, schama
 		schemas:                  make(map[string]*TableSchema),
 		enumMappings:             make(map[string]map[int]enumDict),
 		enumDefaultValueMappings: make(map[string]map[int]int),
+		ignoredEnumCases:         make(map[string]map[int]map[string]struct{}),
 	}
 }
 
@@ -184,62 +200,155 @@ func (cf *CachedSchemaHandler) FetchSchema(tableName string) (*TableSchema, erro
 
 // PrepareEnumCases prepares enum cases
 func (cf *CachedSchemaHandler) PrepareEnumCases(tableName, columnName string, enumCases []string) error {
-	newEnumCases := make([]string, 0, len(enumCases))
+	return cf.PrepareEnumCasesBulk(tableName, map[string][]string{columnName: enumCases})
+}
+
+// PrepareEnumCasesBulk prepares enum cases of multiple columns of the table, enum cases not in
+// cache are extended with as few requests as possible.
+func (cf *CachedSchemaHandler) PrepareEnumCasesBulk(tableName string, enumCases map[string][]string) error {
+	newEnumCases := make(map[string][]string)
 	cf.RLock()
 	schema, exist := cf.schemas[tableName]
 	if !exist {
 		cf.RUnlock()
 		return nil
 	}
-	columnID, exist := schema.ColumnDict[columnName]
-	if !exist {
-		cf.RUnlock()
-		return nil
-	}
 
-	caseInsensitive := schema.Table.Columns[columnID].CaseInsensitive
-	disableAutoExpand := schema.Table.Columns[columnID].DisableAutoExpand
-	for _, enumCase := range enumCases {
-		if _, exist := cf.enumMappings[tableName][columnID][enumCase]; !exist {
-			newEnumCases = append(newEnumCases, enumCase)
+	for columnName, columnEnumCases := range enumCases {
+		columnID, exist := schema.ColumnDict[columnName]
+		if !exist {
+			continue
+		}
+		column := schema.Table.Columns[columnID]
+		for _, enumCase := range columnEnumCases {
+			if _, exist := cf.enumMappings[tableName][columnID][enumCase]; exist {
+				continue
+			}
+			if _, ignored := cf.ignoredEnumCases[tableName][columnID][enumCase]; ignored {
+				continue
+			}
+			// columnName can be an alias of the column.
+			newEnumCases[column.Name] = append(newEnumCases[column.Name], enumCase)
 		}
 	}
 	cf.RUnlock()
 
-	if disableAutoExpand {
+	for columnName, columnEnumCases := range newEnumCases {
+		columnID := schema.ColumnDict[columnName]
+		column := schema.Table.Columns[columnID]
+		if !column.DisableAutoExpand {
+			continue
+		}
 		// It's recommended to set up elk or sentry logging to catch this error.
 		cf.logger.With(
 			"TableName", tableName,
 			"ColumnName", columnName,
 			"ColumnID", columnID,
-			"newEnumCasesSet", newEnumCases,
-			"caseInsensitive", caseInsensitive,
+			"newEnumCasesSet", columnEnumCases,
+			"caseInsensitive", column.CaseInsensitive,
 		).Error("Finding new enum cases during ingestion but enum auto expansion is disabled")
 		cf.metricScope.Tagged(
 			map[string]string{
 				"TableName": tableName,
 				"ColumnID":  strconv.Itoa(columnID),
 			},
-		).Counter("new_enum_cases_ignored").Inc(int64(len(newEnumCases)))
-		return nil
-	}
+		).Counter("new_enum_cases_ignored").Inc(int64(len(columnEnumCases)))
 
-	enumIDs, err := cf.schemaFetcher.ExtendEnumCases(tableName, columnName, newEnumCases)
-	if err != nil {
-		return err
+		cf.Lock()
+		if _, exist := cf.ignoredEnumCases[tableName]; !exist {
+			cf.ignoredEnumCases[tableName] = make(map[int]map[string]struct{})
+		}
+		if _, exist := cf.ignoredEnumCases[tableName][columnID]; !exist {
+			cf.ignoredEnumCases[tableName][columnID] = make(map[string]struct{})
+		}
+		for _, enumCase := range columnEnumCases {
+			cf.ignoredEnumCases[tableName][columnID][enumCase] = struct{}{}
+		}
+		cf.Unlock()
+		delete(newEnumCases, columnName)
 	}
 
-	cf.Lock()
-	for index, enumCase := range newEnumCases {
-		if caseInsensitive {
-			enumCase = strings.ToLower(enumCase)
+	for _, batch := range splitEnumCases(newEnumCases, metaCom.MaxBulkEnumCases) {
+		enumIDs, err := cf.extendEnumCases(tableName, batch)
+		if err != nil {
+			return err
 		}
-		cf.enumMappings[tableName][columnID][enumCase] = enumIDs[index]
+
+		cf.Lock()
+		for columnName, columnEnumCases := range batch {
+			columnID := schema.ColumnDict[columnName]
+			caseInsensitive := schema.Table.Columns[columnID].CaseInsensitive
+			for index, enumCase := range columnEnumCases {
+				if caseInsensitive {
+					enumCase = strings.ToLower(enumCase)
+				}
+				cf.enumMappings[tableName][columnID][enumCase] = enumIDs[columnName][index]
+			}
+		}
+		cf.Unlock()
 	}
-	cf.Unlock()
 	return nil
 }
 
+// extendEnumCases extends enum cases in bulk, or column by column if not supported by schemaFetcher.
+func (cf *CachedSchemaHandler) extendEnumCases(tableName string, enumCases map[string][]string) (map[string][]int, error) {
+	cf.RLock()
+	bulkEnumCasesNotSupported := cf.bulkEnumCasesNotSupported
+	cf.RUnlock()
+
+	if !bulkEnumCasesNotSupported {
+		enumIDs, err := cf.schemaFetcher.ExtendEnumCasesBulk(tableName, enumCases)
+		if err != ErrBulkEnumCasesNotSupported {
+			return enumIDs, err
+		}
+		cf.logger.Warn("Extending enum cases in bulk is not supported, falling back to extending column by column")
+		cf.Lock()
+		cf.bulkEnumCasesNotSupported = true
+		cf.Unlock()
+	}
+
+	enumIDs := make(map[string][]int, len(enumCases))
+	for columnName, columnEnumCases := range enumCases {
+		columnEnumIDs, err := cf.schemaFetcher.ExtendEnumCases(tableName, columnName, columnEnumCases)
+		if err != nil {
+			return nil, err
+		}
+		enumIDs[columnName] = columnEnumIDs
+	}
+	return enumIDs, nil
+}
+
+// splitEnumCases splits enum cases of columns into batches of at most maxBatchSize enum cases.
+func splitEnumCases(enumCases map[string][]string, maxBatchSize int) (batches []map[string][]string) {
+	columnNames := make([]string, 0, len(enumCases))
+	for columnName := range enumCases {
+		columnNames = append(columnNames, columnName)
+	}
+	sort.Strings(columnNames)
+
+	batch, batchSize := make(map[string][]string), 0
+	for _, columnName := range columnNames {
+		columnEnumCases := enumCases[columnName]
+		for len(columnEnumCases) > 0 {
+			if batchSize == maxBatchSize {
+				batches = append(batches, batch)
+				batch, batchSize = make(map[string][]string), 0
+			}
+			n := len(columnEnumCases)
+			if n > maxBatchSize-batchSize {
+				n = maxBatchSize - batchSize
+			}
+			batch[columnName] = columnEnumCases[:n]
+			batchSize += n
+			columnEnumCases = columnEnumCases[n:]
+		}
+	}
+	if batchSize > 0 {
+		batches = append(batches, batch)
+	}
+	return
+}
+
 func (cf *CachedSchemaHandler) fetchAndSetEnumCases(table *metaCom.Table) error {
 	enumMappings := make(map[int]enumDict)
 	enumDefaultValueMappings := make(map[int]int)
@@ -288,15 +397,23 @@ func (cf *CachedSchemaHandler) fetchAndSetEnumCases(table *metaCom.Table) error
 	cf.Lock()
 	cf.enumMappings[table.Name] = enumMappings
 	cf.enumDefaultValueMappings[table.Name] = enumDefaultValueMappings
+	delete(cf.ignoredEnumCases, table.Name)
 	cf.Unlock()
 	return nil
 }
 
 func (cf *CachedSchemaHandler) setTable(table *metaCom.Table) *TableSchema {
 	columnDict := make(map[string]int)
+	now := utils.Now().Unix()
 	for columnID, column := range table.Columns {
 		if !column.Deleted {
 			columnDict[column.Name] = columnID
+			// old names of renamed columns are still accepted until their aliases expire.
+			for _, alias := range column.Aliases {
+				if !alias.IsExpired(now) {
+					columnDict[alias.Name] = columnID
+				}
+			}
 		}
 	}
 
@@ -355,6 +472,25 @@ func (hf *httpSchemaFetcher) ExtendEnumCases(tableName, columnName string, enumC
 	return enumIDs, nil
 }
 
+func (hf *httpSchemaFetcher) ExtendEnumCasesBulk(tableName string, enumCases map[string][]string) (map[string][]int, error) {
+	requestBytes, err := json.Marshal(metaCom.BulkEnumCases{EnumCases: enumCases})
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal enum cases")
+	}
+
+	var enumIDs map[string][]int
+	resp, err := hf.httpClient.Post(hf.bulkEnumCasesPath(tableName), applicationJSONHeader, bytes.NewReader(requestBytes))
+	if err == nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) {
+		resp.Body.Close()
+		return nil, ErrBulkEnumCasesNotSupported
+	}
+	err = hf.readJSONResponse(resp, err, &enumIDs)
+	if err != nil {
+		return nil, err
+	}
+	return enumIDs, nil
+}
+
 func (hf *httpSchemaFetcher) FetchSchema(tableName string) (*metaCom.Table, error) {
 	var table metaCom.Table
 	resp, err := hf.httpClient.Get(hf.tablePath(tableName))
@@ -421,3 +557,7 @@ func (hf *httpSchemaFetcher) listTablesPath() string {
 func (hf *httpSchemaFetcher) enumDictPath(tableName, columnName string) string {
 	return fmt.Sprintf("%s/%s/columns/%s/enum-cases", hf.listTablesPath(), tableName, columnName)
 }
+
+func (hf *httpSchemaFetcher) bulkEnumCasesPath(tableName string) string {
+	return fmt.Sprintf("%s/enum-cases", hf.tablePath(tableName))
+}
@@ -25,6 +25,10 @@ type Transaction struct {
 	keys     []string
 	versions []int
 	values   []proto.Message
+
+	// values set regardless of their versions
+	unconditionalKeys   []string
+	unconditionalValues []proto.Message
 }
 
 // NewTransaction creates a new transaction
@@ -40,6 +44,13 @@ func (t *Transaction) AddKeyValue(key string, version int, value proto.Message)
 	return t
 }
 
+// SetKeyValue adds a tuple of (key, value) to the transaction, which is set regardless of its version
+func (t *Transaction) SetKeyValue(key string, value proto.Message) *Transaction {
+	t.unconditionalKeys = append(t.unconditionalKeys, key)
+	t.unconditionalValues = append(t.unconditionalValues, value)
+	return t
+}
+
 // WriteTo writes the transaction to the transaction store
 func (t *Transaction) WriteTo(store kv.TxnStore) error {
 	if len(t.keys) != len(t.versions) || len(t.versions) != len(t.values) {
@@ -56,6 +67,9 @@ func (t *Transaction) WriteTo(store kv.TxnStore) error {
 			SetValue(t.versions[i])
 		ops[i] = kv.NewSetOp(key, t.values[i])
 	}
+	for i, key := range t.unconditionalKeys {
+		ops = append(ops, kv.NewSetOp(key, t.unconditionalValues[i]))
+	}
 	_, err := store.Commit(conditions, ops)
 	return err
 }
@@ -22,14 +22,18 @@ package topology
 
 import (
 	"errors"
+	"fmt"
+	"github.com/m3db/m3/src/cluster/kv"
 	"github.com/m3db/m3/src/cluster/placement"
 	"github.com/m3db/m3/src/cluster/services"
 	"github.com/m3db/m3/src/cluster/shard"
+	xretry "github.com/m3db/m3/src/x/retry"
 	xwatch "github.com/m3db/m3/src/x/watch"
 	aresShard "github.com/uber/aresdb/cluster/shard"
 	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/utils"
 	"sync"
+	"time"
 )
 
 var (
@@ -38,6 +42,13 @@ var (
 	errMissingShard              = errors.New("shard is missing")
 	errNotEnoughReplicasForShard = errors.New("replicas of shard is less than expected")
 	errInvalidTopology           = errors.New("could not parse latest value from config service")
+	errPlacementNotReceived      = errors.New("placement is set but not received from config service")
+
+	// ErrPlacementNotSet is the cause of InitTimeoutError if no placement is set in the config service.
+	ErrPlacementNotSet = errors.New("no placement is set in config service")
+
+	// initProgressLogInterval is the interval of logs while waiting for the initial placement.
+	initProgressLogInterval = 10 * time.Second
 )
 
 type dynamicInitializer struct {
@@ -69,10 +80,41 @@ func (i *dynamicInitializer) Init() (Topology, error) {
 	return i.topo, nil
 }
 
+// TopologyIsSet tells whether a placement of the service has ever been set in the config service, without
+// waiting for it like Init does. Placements not set are not errors, only failures to query them are.
 func (i *dynamicInitializer) TopologyIsSet() (bool, error) {
+	svcs, err := i.opts.ConfigServiceClient().Services(nil)
+	if err != nil {
+		return false, err
+	}
+	return isPlacementSet(svcs, i.opts.ServiceID())
+}
+
+// isPlacementSet queries the config service for the placement of the service, instances are not filtered by
+// heartbeats as only the placement is checked.
+func isPlacementSet(svcs services.Services, serviceID services.ServiceID) (bool, error) {
+	_, err := svcs.Query(serviceID, services.NewQueryOptions().SetIncludeUnhealthy(true))
+	if err == kv.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
+// InitTimeoutError is the error of dynamic topologies not initialized within the timeout. Cause is
+// ErrPlacementNotSet if no placement is set in the config service, or the error querying the config
+// service, e.g. etcd is unreachable.
+type InitTimeoutError struct {
+	Timeout time.Duration
+	Cause   error
+}
+
+func (e *InitTimeoutError) Error() string {
+	return fmt.Sprintf("dynamic topology not initialized in %v: %v", e.Timeout, e.Cause)
+}
+
 type dynamicTopology struct {
 	sync.RWMutex
 
@@ -81,6 +123,9 @@ type dynamicTopology struct {
 	watch     services.Watch
 	watchable xwatch.Watchable
 	closed    bool
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	health    Health
 	logger    common.Logger
 }
 
@@ -97,10 +142,13 @@ func newDynamicTopology(opts DynamicOptions) (DynamicTopology, error) {
 	if err != nil {
 		return nil, err
 	}
-	<-watch.C()
+	if err = waitForInitialPlacement(services, watch, opts, logger); err != nil {
+		logger.With("err", err).Error("dynamic topology failed to initialize")
+		return nil, err
+	}
 	logger.Info("initial topology / placement value received")
 
-	m, err := getMapFromUpdate(watch.Get())
+	m, err := getMapFromUpdate(watch.Get(), opts.AllowPartialReplicas())
 	if err != nil {
 		logger.With("err", err).Error("dynamic topology received invalid initial value")
 		return nil, err
@@ -114,12 +162,49 @@ func newDynamicTopology(opts DynamicOptions) (DynamicTopology, error) {
 		services:  services,
 		watch:     watch,
 		watchable: watchable,
+		closeCh:   make(chan struct{}),
+		doneCh:    make(chan struct{}),
 		logger:    logger,
 	}
+	dt.setHealth(Healthy)
 	go dt.run()
 	return dt, nil
 }
 
+// waitForInitialPlacement waits for the initial placement from the watch, with progress logged periodically. The
+// watch is closed if the placement is not received within the init timeout, 0 waits indefinitely.
+func waitForInitialPlacement(svcs services.Services, watch services.Watch, opts DynamicOptions, logger common.Logger) error {
+	var timeout <-chan time.Time
+	if opts.InitTimeout() > 0 {
+		timer := time.NewTimer(opts.InitTimeout())
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(initProgressLogInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-watch.C():
+			return nil
+		case <-ticker.C:
+			logger.With("service", opts.ServiceID().String(), "waited", time.Since(start).String()).
+				Info("still waiting for dynamic topology initialization")
+		case <-timeout:
+			watch.Close()
+			cause := errPlacementNotReceived
+			isSet, err := isPlacementSet(svcs, opts.ServiceID())
+			if err != nil {
+				cause = err
+			} else if !isSet {
+				cause = ErrPlacementNotSet
+			}
+			return &InitTimeoutError{Timeout: opts.InitTimeout(), Cause: cause}
+		}
+	}
+}
+
 func (t *dynamicTopology) isClosed() bool {
 	t.RLock()
 	closed := t.closed
@@ -127,22 +212,108 @@ func (t *dynamicTopology) isClosed() bool {
 	return closed
 }
 
+func (t *dynamicTopology) getWatch() services.Watch {
+	t.RLock()
+	watch := t.watch
+	t.RUnlock()
+	return watch
+}
+
 func (t *dynamicTopology) run() {
+	defer close(t.doneCh)
+
+	// failed attempts to re-create the watch since the last update received.
+	attempts := 0
 	for !t.isClosed() {
-		if _, ok := <-t.watch.C(); !ok {
-			t.Close()
-			break
+		watch := t.getWatch()
+		var ok bool
+		select {
+		case _, ok = <-watch.C():
+		case <-t.closeCh:
+			return
+		}
+		if !ok {
+			// watch is closed by the config service, e.g. on etcd leader loss, the last map is served meanwhile.
+			t.logger.Warn("dynamic topology watch closed, re-creating watch")
+			attempts = t.rewatch(attempts)
+			continue
 		}
 
-		m, err := getMapFromUpdate(t.watch.Get())
+		attempts = 0
+		t.setHealth(Healthy)
+		m, err := getMapFromUpdate(watch.Get(), t.opts.AllowPartialReplicas())
 		if err != nil {
 			t.logger.With("err", err).Warn("dynamic topology received invalid update")
 			continue
 		}
+		// watchable is closed once closed.
+		if t.isClosed() {
+			return
+		}
 		t.watchable.Update(m)
 	}
 }
 
+// rewatch re-creates the watch with exponential backoff until it succeeds or the topology is closed, and returns
+// the number of failed attempts. Watches closed before any update count as failed attempts too, so the topology
+// is marked unhealthy after the max retries of watch retry options however the attempts fail.
+func (t *dynamicTopology) rewatch(attempts int) int {
+	retryOpts := t.opts.WatchRetryOptions()
+	for {
+		if attempts >= retryOpts.MaxRetries() && t.Health() == Healthy {
+			t.logger.With("attempts", attempts).Error("dynamic topology failed to re-create watch, marking unhealthy")
+			t.setHealth(Unhealthy)
+		}
+
+		backoff := time.Duration(xretry.BackoffNanos(attempts+1, retryOpts.Jitter(), retryOpts.BackoffFactor(),
+			retryOpts.InitialBackoff(), retryOpts.MaxBackoff(), retryOpts.RngFn()))
+		select {
+		case <-time.After(backoff):
+		case <-t.closeCh:
+			return attempts
+		}
+		if t.isClosed() {
+			return attempts
+		}
+
+		attempts++
+		watch, err := t.services.Watch(t.opts.ServiceID(), t.opts.QueryOptions())
+		if err != nil {
+			t.logger.With("err", err, "attempt", attempts).Warn("failed to re-create dynamic topology watch")
+			continue
+		}
+
+		t.Lock()
+		if t.closed {
+			t.Unlock()
+			watch.Close()
+			return attempts
+		}
+		t.watch = watch
+		t.Unlock()
+		t.logger.With("attempt", attempts).Info("dynamic topology watch re-created")
+		return attempts
+	}
+}
+
+func (t *dynamicTopology) Health() Health {
+	t.RLock()
+	defer t.RUnlock()
+	return t.health
+}
+
+func (t *dynamicTopology) setHealth(health Health) {
+	t.Lock()
+	t.health = health
+	t.Unlock()
+
+	var healthy float64
+	if health == Healthy {
+		healthy = 1
+	}
+	utils.GetRootReporter().GetGauge(utils.TopologyHealthy).Update(healthy)
+}
+
 func (t *dynamicTopology) Get() Map {
 	return t.watchable.Get().(Map)
 }
@@ -155,16 +326,20 @@ func (t *dynamicTopology) Watch() (MapWatch, error) {
 	return NewMapWatch(w), err
 }
 
+// Close closes the topology and blocks until the watch of the placement has stopped.
 func (t *dynamicTopology) Close() {
 	t.Lock()
-	defer t.Unlock()
-
 	if t.closed {
+		t.Unlock()
+		<-t.doneCh
 		return
 	}
-
 	t.closed = true
+	close(t.closeCh)
+	t.Unlock()
 
+	// watch and watchable are closed after run exits, so that neither is used once closed.
+	<-t.doneCh
 	t.watch.Close()
 	t.watchable.Close()
 }
@@ -182,8 +357,8 @@ func (t *dynamicTopology) MarkShardsAvailable(
 	return err
 }
 
-func getMapFromUpdate(service services.Service) (Map, error) {
-	to, err := getStaticOptions(service)
+func getMapFromUpdate(service services.Service, allowPartialReplicas bool) (Map, error) {
+	to, err := getStaticOptions(service, allowPartialReplicas)
 	if err != nil {
 		return nil, err
 	}
@@ -191,7 +366,7 @@ func getMapFromUpdate(service services.Service) (Map, error) {
 	return NewStaticMap(to), nil
 }
 
-func getStaticOptions(service services.Service) (StaticOptions, error) {
+func getStaticOptions(service services.Service, allowPartialReplicas bool) (StaticOptions, error) {
 	if service == nil || service.Replication() == nil || service.Sharding() == nil || service.Instances() == nil {
 		return nil, errInvalidService
 	}
@@ -199,7 +374,7 @@ func getStaticOptions(service services.Service) (StaticOptions, error) {
 	instances := service.Instances()
 	numShards := service.Sharding().NumShards()
 
-	allShardIDs, err := validateInstances(instances, replicas, numShards)
+	allShardIDs, err := validateInstances(instances, replicas, numShards, allowPartialReplicas)
 	if err != nil {
 		return nil, err
 	}
@@ -226,7 +401,9 @@ func getStaticOptions(service services.Service) (StaticOptions, error) {
 		SetHostShardSets(hostShardSets), nil
 }
 
-func validateInstances(instances []services.ServiceInstance, replicas, numShards int) ([]uint32, error) {
+// validateInstances returns IDs of all shards of the instances, shards missing some of their replicas are
+// only logged if partial replicas are allowed. Shards without any replica are always rejected.
+func validateInstances(instances []services.ServiceInstance, replicas, numShards int, allowPartialReplicas bool) ([]uint32, error) {
 	m := make(map[uint32]int)
 	for _, i := range instances {
 		if i.Shards() == nil {
@@ -240,10 +417,12 @@ func validateInstances(instances []services.ServiceInstance, replicas, numShards
 	for i := range s {
 		expectShard := uint32(i)
 		count, exist := m[expectShard]
-		if !exist {
+		if !exist || count == 0 {
 			return nil, errMissingShard
-		}
-		if count < replicas {
+		} else if allowPartialReplicas && count < replicas {
+			utils.GetLogger().With("shard", expectShard, "replicas", count, "expected", replicas).
+				Warn("dynamic topology accepted shard missing replicas")
+		} else if count < replicas {
 			return nil, errNotEnoughReplicasForShard
 		}
 		delete(m, expectShard)
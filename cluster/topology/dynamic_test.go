@@ -21,10 +21,13 @@
 package topology
 
 import (
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/m3db/m3/src/cluster/client"
+	"github.com/m3db/m3/src/cluster/kv"
 	"github.com/m3db/m3/src/cluster/services"
 	"github.com/m3db/m3/src/cluster/shard"
+	xretry "github.com/m3db/m3/src/x/retry"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap"
@@ -49,6 +52,148 @@ var _ = Describe("dynamic", func() {
 		topo.Close()
 	})
 
+	It("InitTimeout", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		defer ctrl.Finish()
+
+		opts := NewDynamicOptions().SetInitTimeout(10 * time.Millisecond)
+		mockCSServices := services.NewMockServices(ctrl)
+		mockCSServices.EXPECT().Watch(opts.ServiceID(), opts.QueryOptions()).
+			Return(newTestWatch(ctrl, 0, 0, 0, 0), nil).Times(3)
+		mockCSClient := client.NewMockClient(ctrl)
+		mockCSClient.EXPECT().Services(gomock.Any()).Return(mockCSServices, nil).Times(3)
+		opts = opts.SetConfigServiceClient(mockCSClient)
+
+		mockCSServices.EXPECT().Query(opts.ServiceID(), gomock.Any()).Return(nil, kv.ErrNotFound)
+		_, err := newDynamicTopology(opts)
+		Ω(err).Should(Equal(&InitTimeoutError{Timeout: 10 * time.Millisecond, Cause: ErrPlacementNotSet}))
+
+		etcdErr := errors.New("etcd unreachable")
+		mockCSServices.EXPECT().Query(opts.ServiceID(), gomock.Any()).Return(nil, etcdErr)
+		_, err = newDynamicTopology(opts)
+		Ω(err).Should(Equal(&InitTimeoutError{Timeout: 10 * time.Millisecond, Cause: etcdErr}))
+
+		mockCSServices.EXPECT().Query(opts.ServiceID(), gomock.Any()).Return(getMockService(ctrl), nil)
+		_, err = newDynamicTopology(opts)
+		Ω(err).Should(Equal(&InitTimeoutError{Timeout: 10 * time.Millisecond, Cause: errPlacementNotReceived}))
+	})
+
+	It("InitZeroTimeout", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		opts, w := testSetup(ctrl)
+		defer testFinish(ctrl, w)
+
+		// zero timeout waits for the initial placement indefinitely.
+		w.firstDelay = 50 * time.Millisecond
+		go w.run()
+		topo, err := newDynamicTopology(opts.SetInitTimeout(0))
+		Ω(err).Should(BeNil())
+		Ω(topo.Get().HostsLen()).Should(Equal(3))
+		topo.Close()
+	})
+
+	It("Rewatch", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		defer ctrl.Finish()
+
+		opts := NewDynamicOptions().SetWatchRetryOptions(xretry.NewOptions().
+			SetInitialBackoff(time.Millisecond).
+			SetMaxBackoff(5 * time.Millisecond).
+			SetMaxRetries(2).
+			SetJitter(false))
+		first := newTestWatch(ctrl, 0, 0, 1, 1)
+		second := newTestWatch(ctrl, 0, 0, 1, 1)
+		mockCSServices := services.NewMockServices(ctrl)
+		gomock.InOrder(
+			mockCSServices.EXPECT().Watch(opts.ServiceID(), opts.QueryOptions()).Return(first, nil),
+			mockCSServices.EXPECT().Watch(opts.ServiceID(), opts.QueryOptions()).
+				Return(nil, errors.New("etcd unreachable")).Times(2),
+			mockCSServices.EXPECT().Watch(opts.ServiceID(), opts.QueryOptions()).Return(second, nil),
+		)
+		mockCSClient := client.NewMockClient(ctrl)
+		mockCSClient.EXPECT().Services(gomock.Any()).Return(mockCSServices, nil)
+		opts = opts.SetConfigServiceClient(mockCSClient)
+
+		go first.update()
+		topo, err := newDynamicTopology(opts)
+		Ω(err).Should(BeNil())
+		defer topo.Close()
+		mw, err := topo.Watch()
+		Ω(err).Should(BeNil())
+		<-mw.C()
+
+		// the last map is served while the watch is re-created.
+		close(first.ch)
+		Ω(topo.Get().HostsLen()).Should(Equal(3))
+		go second.update()
+		<-mw.C()
+		Ω(mw.Get().HostsLen()).Should(Equal(3))
+		Ω(topo.Health()).Should(Equal(Healthy))
+	})
+
+	It("Unhealthy", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		defer ctrl.Finish()
+
+		opts := NewDynamicOptions().SetWatchRetryOptions(xretry.NewOptions().
+			SetInitialBackoff(time.Millisecond).
+			SetMaxBackoff(time.Millisecond).
+			SetMaxRetries(3))
+		w := newTestWatch(ctrl, 0, 0, 1, 1)
+		mockCSServices := services.NewMockServices(ctrl)
+		gomock.InOrder(
+			mockCSServices.EXPECT().Watch(opts.ServiceID(), opts.QueryOptions()).Return(w, nil),
+			mockCSServices.EXPECT().Watch(opts.ServiceID(), opts.QueryOptions()).
+				Return(nil, errors.New("etcd unreachable")).AnyTimes(),
+		)
+		mockCSClient := client.NewMockClient(ctrl)
+		mockCSClient.EXPECT().Services(gomock.Any()).Return(mockCSServices, nil)
+		opts = opts.SetConfigServiceClient(mockCSClient)
+
+		go w.run()
+		topo, err := newDynamicTopology(opts)
+		Ω(err).Should(BeNil())
+		defer topo.Close()
+		Ω(topo.Health()).Should(Equal(Healthy))
+
+		Eventually(topo.Health).Should(Equal(Unhealthy))
+		Ω(topo.Get().HostsLen()).Should(Equal(3))
+	})
+
+	It("CloseStress", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		defer ctrl.Finish()
+
+		for i := 0; i < 100; i++ {
+			opts, w := testSetup(ctrl)
+			w.data = getMockService(ctrl)
+			stop, done := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(done)
+				for {
+					select {
+					case w.ch <- struct{}{}:
+					case <-stop:
+						return
+					}
+				}
+			}()
+
+			topo, err := newDynamicTopology(opts)
+			Ω(err).Should(BeNil())
+			mw, err := topo.Watch()
+			Ω(err).Should(BeNil())
+			// updates race with closing of the topology.
+			if i%2 == 0 {
+				<-mw.C()
+			}
+			topo.Close()
+			Ω(topo.Get().HostsLen()).Should(Equal(3))
+			close(stop)
+			<-done
+		}
+	})
+
 	It("Back", func() {
 		ctrl := gomock.NewController(zap.NewNop().Sugar())
 		opts, w := testSetup(ctrl)
@@ -61,6 +206,7 @@ var _ = Describe("dynamic", func() {
 		mw, err := topo.Watch()
 		Ω(err).Should(BeNil())
 		Ω(mw.Get().HostsLen()).Should(Equal(3))
+		topo.Close()
 
 		opts, w = testSetup(ctrl)
 		close(w.ch)
@@ -77,6 +223,8 @@ var _ = Describe("dynamic", func() {
 		topo, err := newDynamicTopology(opts)
 		Ω(err).Should(BeNil())
 
+		defer topo.Close()
+
 		m := topo.Get()
 		Ω(m.HostsLen()).Should(Equal(3))
 	})
@@ -90,6 +238,8 @@ var _ = Describe("dynamic", func() {
 		topo, err := newDynamicTopology(opts)
 		Ω(err).Should(BeNil())
 
+		defer topo.Close()
+
 		tw, err := topo.Watch()
 		Ω(err).Should(BeNil())
 		<-tw.C()
@@ -102,15 +252,77 @@ var _ = Describe("dynamic", func() {
 		}
 	})
 
+	It("TopologyIsSet", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		defer ctrl.Finish()
+
+		opts := NewDynamicOptions()
+		mockCSServices := services.NewMockServices(ctrl)
+		mockCSClient := client.NewMockClient(ctrl)
+		mockCSClient.EXPECT().Services(gomock.Any()).Return(mockCSServices, nil).Times(3)
+		initializer := NewDynamicInitializer(opts.SetConfigServiceClient(mockCSClient))
+
+		mockCSServices.EXPECT().Query(opts.ServiceID(), gomock.Any()).Return(getMockService(ctrl), nil)
+		isSet, err := initializer.TopologyIsSet()
+		Ω(err).Should(BeNil())
+		Ω(isSet).Should(BeTrue())
+
+		mockCSServices.EXPECT().Query(opts.ServiceID(), gomock.Any()).Return(nil, kv.ErrNotFound)
+		isSet, err = initializer.TopologyIsSet()
+		Ω(err).Should(BeNil())
+		Ω(isSet).Should(BeFalse())
+
+		mockCSServices.EXPECT().Query(opts.ServiceID(), gomock.Any()).Return(nil, errors.New("etcd unreachable"))
+		isSet, err = initializer.TopologyIsSet()
+		Ω(err).Should(MatchError("etcd unreachable"))
+		Ω(isSet).Should(BeFalse())
+	})
+
+	It("AllowPartialReplicas", func() {
+		ctrl := gomock.NewController(zap.NewNop().Sugar())
+		defer ctrl.Finish()
+
+		// h1 replaces h2 with shard 1 initializing, shard 2 is missing a replica.
+		instances := goodInstances()
+		instances[0].SetShards(shard.NewShards([]shard.Shard{
+			shard.NewShard(0).SetState(shard.Available),
+			shard.NewShard(1).SetState(shard.Initializing),
+		}))
+		instances[1].SetShards(shard.NewShards([]shard.Shard{
+			shard.NewShard(1).SetState(shard.Leaving),
+		}))
+		instances[2].SetShards(shard.NewShards([]shard.Shard{
+			shard.NewShard(2).SetState(shard.Initializing),
+			shard.NewShard(0).SetState(shard.Available),
+		}))
+		mockService := services.NewMockService(ctrl)
+		mockReplication := services.NewMockServiceReplication(ctrl)
+		mockReplication.EXPECT().Replicas().Return(2).AnyTimes()
+		mockService.EXPECT().Replication().Return(mockReplication).AnyTimes()
+		mockSharding := services.NewMockServiceSharding(ctrl)
+		mockSharding.EXPECT().NumShards().Return(3).AnyTimes()
+		mockService.EXPECT().Sharding().Return(mockSharding).AnyTimes()
+		mockService.EXPECT().Instances().Return(instances).AnyTimes()
+
+		_, err := getMapFromUpdate(mockService, false)
+		Ω(err).Should(Equal(errNotEnoughReplicasForShard))
+
+		m, err := getMapFromUpdate(mockService, true)
+		Ω(err).Should(BeNil())
+		Ω(m.ShardStates()[1]["h1"].ShardState).Should(Equal(shard.Initializing))
+		Ω(m.ShardStates()[1]["h2"].ShardState).Should(Equal(shard.Leaving))
+		Ω(m.ShardStates()[2]).Should(HaveLen(1))
+	})
+
 	It("GetUniqueShardsAndReplicas", func() {
 		goodInstances := goodInstances()
 
-		shards, err := validateInstances(goodInstances, 2, 3)
+		shards, err := validateInstances(goodInstances, 2, 3, false)
 		Ω(err).Should(BeNil())
 		Ω(len(shards)).Should(Equal(3))
 
 		goodInstances[0].SetShards(nil)
-		_, err = validateInstances(goodInstances, 2, 3)
+		_, err = validateInstances(goodInstances, 2, 3, false)
 		Ω(err).Should(Equal(errInstanceHasNoShardsAssignment))
 
 		goodInstances[0].SetShards(shard.NewShards(
@@ -119,7 +331,7 @@ var _ = Describe("dynamic", func() {
 				shard.NewShard(1),
 				shard.NewShard(3),
 			}))
-		_, err = validateInstances(goodInstances, 2, 3)
+		_, err = validateInstances(goodInstances, 2, 3, false)
 		Ω(err).Should(Equal(errUnexpectedShard))
 
 		// got h1: 1, h2: 1, 2, h3 0,2, missing a replica for 1
@@ -127,7 +339,7 @@ var _ = Describe("dynamic", func() {
 			[]shard.Shard{
 				shard.NewShard(1),
 			}))
-		_, err = validateInstances(goodInstances, 2, 3)
+		_, err = validateInstances(goodInstances, 2, 3, false)
 		Ω(err).Should(Equal(errNotEnoughReplicasForShard))
 
 		goodInstances[0].SetShards(shard.NewShards(
@@ -138,9 +350,11 @@ var _ = Describe("dynamic", func() {
 			[]shard.Shard{
 				shard.NewShard(2),
 			}))
-		_, err = validateInstances(goodInstances, 2, 3)
+		_, err = validateInstances(goodInstances, 2, 3, false)
 		// got h1:0, h2: 2, h3 0,2, missing 1
 		Ω(err).Should(Equal(errMissingShard))
+		_, err = validateInstances(goodInstances, 2, 3, true)
+		Ω(err).Should(Equal(errMissingShard))
 	})
 })
 
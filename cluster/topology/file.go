@@ -0,0 +1,238 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package topology
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	xwatch "github.com/m3db/m3/src/x/watch"
+	aresShard "github.com/uber/aresdb/cluster/shard"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+	"gopkg.in/yaml.v2"
+)
+
+type fileInitializer struct {
+	opts FileOptions
+}
+
+// NewFileInitializer creates a topology initializer of the static configuration in a yaml file, the file is
+// polled for changes which are published to watches of the topology.
+func NewFileInitializer(opts FileOptions) Initializer {
+	return fileInitializer{opts}
+}
+
+func (i fileInitializer) Init() (Topology, error) {
+	if err := i.opts.Validate(); err != nil {
+		return nil, err
+	}
+	return newFileTopology(i.opts)
+}
+
+func (i fileInitializer) TopologyIsSet() (bool, error) {
+	_, err := os.Stat(i.opts.Path())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewStaticOptions creates validated static topology options of the configuration. Shards of hosts are
+// assigned round robin by replicas if no host has shards configured.
+func (c StaticConfiguration) NewStaticOptions() (StaticOptions, error) {
+	if c.Shards < 1 {
+		return nil, fmt.Errorf("invalid number of shards %d", c.Shards)
+	}
+	if len(c.Hosts) == 0 {
+		return nil, fmt.Errorf("no hosts configured")
+	}
+
+	assigned := false
+	for _, host := range c.Hosts {
+		assigned = assigned || len(host.Shards) > 0
+	}
+	shardIDsByHost := make([][]uint32, len(c.Hosts))
+	for i, host := range c.Hosts {
+		if host.HostID == "" || host.ListenAddress == "" {
+			return nil, fmt.Errorf("host %d is missing hostID or listenAddress", i)
+		}
+		for _, shardID := range host.Shards {
+			if int(shardID) >= c.Shards {
+				return nil, fmt.Errorf("host %s has shard %d out of %d shards", host.HostID, shardID, c.Shards)
+			}
+		}
+		shardIDsByHost[i] = host.Shards
+	}
+	if !assigned {
+		if c.Replicas > len(c.Hosts) {
+			return nil, fmt.Errorf("%d replicas is more than %d hosts", c.Replicas, len(c.Hosts))
+		}
+		for shardID := 0; shardID < c.Shards; shardID++ {
+			for replica := 0; replica < c.Replicas; replica++ {
+				i := (shardID + replica) % len(c.Hosts)
+				shardIDsByHost[i] = append(shardIDsByHost[i], uint32(shardID))
+			}
+		}
+	}
+
+	allShardIDs := make([]uint32, c.Shards)
+	for shardID := range allShardIDs {
+		allShardIDs[shardID] = uint32(shardID)
+	}
+	hostShardSets := make([]HostShardSet, len(c.Hosts))
+	for i, host := range c.Hosts {
+		shardSet := aresShard.NewShardSet(aresShard.NewShards(shardIDsByHost[i], m3Shard.Available))
+		hostShardSets[i] = NewHostShardSet(NewHost(host.HostID, host.ListenAddress), shardSet)
+	}
+
+	opts := NewStaticOptions().
+		SetShardSet(aresShard.NewShardSet(aresShard.NewShards(allShardIDs, m3Shard.Available))).
+		SetHostShardSets(hostShardSets).
+		SetReplicas(c.Replicas)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// readStaticConfiguration reads the static configuration in yaml from the file.
+func readStaticConfiguration(path string) (StaticOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg StaticConfiguration
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.NewStaticOptions()
+}
+
+type fileTopology struct {
+	sync.RWMutex
+
+	opts      FileOptions
+	modTime   time.Time
+	size      int64
+	watchable xwatch.Watchable
+	closed    bool
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	logger    common.Logger
+}
+
+func newFileTopology(opts FileOptions) (Topology, error) {
+	info, err := os.Stat(opts.Path())
+	if err != nil {
+		return nil, err
+	}
+	staticOpts, err := readStaticConfiguration(opts.Path())
+	if err != nil {
+		return nil, utils.StackError(err, "invalid topology file %s", opts.Path())
+	}
+
+	watchable := xwatch.NewWatchable()
+	watchable.Update(NewStaticMap(staticOpts))
+	t := &fileTopology{
+		opts:      opts,
+		modTime:   info.ModTime(),
+		size:      info.Size(),
+		watchable: watchable,
+		closeCh:   make(chan struct{}),
+		doneCh:    make(chan struct{}),
+		logger:    utils.GetLogger().With("path", opts.Path()),
+	}
+	go t.run()
+	return t, nil
+}
+
+func (t *fileTopology) run() {
+	defer close(t.doneCh)
+
+	ticker := time.NewTicker(t.opts.PollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.reload()
+		case <-t.closeCh:
+			return
+		}
+	}
+}
+
+// reload publishes the configuration of the file if it is modified since the last load, invalid configurations
+// are logged and the previous map is kept.
+func (t *fileTopology) reload() {
+	info, err := os.Stat(t.opts.Path())
+	if err != nil {
+		t.logger.With("err", err).Error("failed to stat topology file")
+		return
+	}
+	if info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return
+	}
+	t.modTime, t.size = info.ModTime(), info.Size()
+
+	staticOpts, err := readStaticConfiguration(t.opts.Path())
+	if err != nil {
+		t.logger.With("err", err).Error("invalid topology file, keeping previous topology")
+		return
+	}
+	t.watchable.Update(NewStaticMap(staticOpts))
+	t.logger.Info("topology file reloaded")
+}
+
+func (t *fileTopology) Get() Map {
+	return t.watchable.Get().(Map)
+}
+
+func (t *fileTopology) Watch() (MapWatch, error) {
+	_, w, err := t.watchable.Watch()
+	if err != nil {
+		return nil, err
+	}
+	return NewMapWatch(w), nil
+}
+
+// Close stops polling the file and blocks until the polling has stopped.
+func (t *fileTopology) Close() {
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		<-t.doneCh
+		return
+	}
+	t.closed = true
+	close(t.closeCh)
+	t.Unlock()
+
+	<-t.doneCh
+	t.watchable.Close()
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package topology
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("file", func() {
+	var dir, path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "topology")
+		Ω(err).Should(BeNil())
+		path = filepath.Join(dir, "topology.yaml")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("assigns shards round robin without shards of hosts", func() {
+		opts, err := StaticConfiguration{
+			Shards:   3,
+			Replicas: 2,
+			Hosts: []HostShardConfig{
+				{HostID: "h1", ListenAddress: "h1:9374"},
+				{HostID: "h2", ListenAddress: "h2:9374"},
+				{HostID: "h3", ListenAddress: "h3:9374"},
+			},
+		}.NewStaticOptions()
+		Ω(err).Should(BeNil())
+		m := NewStaticMap(opts)
+		Ω(m.HostsLen()).Should(Equal(3))
+		Ω(m.Replicas()).Should(Equal(2))
+		for _, hostShardSet := range m.HostShardSets() {
+			Ω(hostShardSet.ShardSet().AllIDs()).Should(HaveLen(2))
+		}
+	})
+
+	It("validates static configurations", func() {
+		hosts := []HostShardConfig{
+			{HostID: "h1", ListenAddress: "h1:9374", Shards: []uint32{0, 1}},
+			{HostID: "h2", ListenAddress: "h2:9374", Shards: []uint32{1}},
+		}
+		_, err := StaticConfiguration{Shards: 2, Replicas: 1, Hosts: hosts}.NewStaticOptions()
+		Ω(err).Should(BeNil())
+		_, err = StaticConfiguration{Shards: 2, Replicas: 2, Hosts: hosts}.NewStaticOptions()
+		Ω(err).ShouldNot(BeNil())
+		_, err = StaticConfiguration{Shards: 1, Replicas: 1, Hosts: hosts}.NewStaticOptions()
+		Ω(err).ShouldNot(BeNil())
+		_, err = StaticConfiguration{Shards: 0, Replicas: 1, Hosts: hosts}.NewStaticOptions()
+		Ω(err).ShouldNot(BeNil())
+		_, err = StaticConfiguration{Shards: 2, Replicas: 1}.NewStaticOptions()
+		Ω(err).ShouldNot(BeNil())
+		_, err = StaticConfiguration{Shards: 2, Replicas: 3, Hosts: []HostShardConfig{
+			{HostID: "h1", ListenAddress: "h1:9374"},
+		}}.NewStaticOptions()
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("file initializer and topology", func() {
+		initializer := NewFileInitializer(NewFileOptions().SetPath(path).SetPollInterval(10 * time.Millisecond))
+		isSet, err := initializer.TopologyIsSet()
+		Ω(err).Should(BeNil())
+		Ω(isSet).Should(BeFalse())
+		_, err = initializer.Init()
+		Ω(err).ShouldNot(BeNil())
+
+		Ω(ioutil.WriteFile(path, []byte(`
+shards: 2
+replicas: 1
+hosts:
+  - hostID: h1
+    listenAddress: h1:9374
+`), 0644)).Should(BeNil())
+		isSet, err = initializer.TopologyIsSet()
+		Ω(err).Should(BeNil())
+		Ω(isSet).Should(BeTrue())
+
+		topo, err := initializer.Init()
+		Ω(err).Should(BeNil())
+		defer topo.Close()
+		Ω(topo.Get().HostsLen()).Should(Equal(1))
+		w, err := topo.Watch()
+		Ω(err).Should(BeNil())
+		<-w.C()
+
+		Ω(ioutil.WriteFile(path, []byte(`
+shards: 2
+replicas: 2
+hosts:
+  - hostID: h1
+    listenAddress: h1:9374
+  - hostID: h2
+    listenAddress: h2:9374
+`), 0644)).Should(BeNil())
+		Eventually(w.C()).Should(Receive())
+		Ω(w.Get().HostsLen()).Should(Equal(2))
+		Ω(w.Get().Replicas()).Should(Equal(2))
+
+		// invalid configurations keep the previous map.
+		Ω(ioutil.WriteFile(path, []byte(`
+shards: 2
+replicas: 3
+hosts:
+  - hostID: h1
+    listenAddress: h1:9374
+`), 0644)).Should(BeNil())
+		Consistently(w.C(), 100*time.Millisecond).ShouldNot(Receive())
+		Ω(topo.Get().HostsLen()).Should(Equal(2))
+	})
+
+	It("validates file options", func() {
+		Ω(NewFileOptions().Validate()).Should(Equal(errNoTopologyFile))
+		Ω(NewFileOptions().SetPath(path).SetPollInterval(0).Validate()).Should(Equal(errInvalidPollInterval))
+		Ω(NewFileOptions().SetPath(path).Validate()).Should(BeNil())
+	})
+})
@@ -33,6 +33,7 @@ type staticMap struct {
 	hostsByShard      [][]Host
 	orderedHosts      []Host
 	replicas          int
+	shardStates       ShardStates
 }
 
 // NewStaticMap creates Map
@@ -46,14 +47,21 @@ func NewStaticMap(opts StaticOptions) Map {
 		hostsByShard:      make([][]Host, totalShards),
 		orderedHosts:      make([]Host, 0, len(hostShardSets)),
 		replicas:          opts.Replicas(),
+		shardStates:       make(ShardStates, totalShards),
 	}
 
 	for _, hostShardSet := range hostShardSets {
 		host := hostShardSet.Host()
 		staticMap.hostShardSetsByID[host.ID()] = hostShardSet
 		staticMap.orderedHosts = append(staticMap.orderedHosts, host)
-		for _, shard := range hostShardSet.ShardSet().AllIDs() {
-			staticMap.hostsByShard[shard] = append(staticMap.hostsByShard[shard], host)
+		for _, shard := range hostShardSet.ShardSet().All() {
+			staticMap.hostsByShard[shard.ID()] = append(staticMap.hostsByShard[shard.ID()], host)
+			hostStates, ok := staticMap.shardStates[ShardID(shard.ID())]
+			if !ok {
+				hostStates = make(map[HostID]HostShardState)
+				staticMap.shardStates[ShardID(shard.ID())] = hostStates
+			}
+			hostStates[HostID(host.ID())] = HostShardState{Host: host, ShardState: shard.State()}
 		}
 	}
 	return &staticMap
@@ -91,6 +99,10 @@ func (t *staticMap) Replicas() int {
 	return t.replicas
 }
 
+func (sm *staticMap) ShardStates() ShardStates {
+	return sm.shardStates
+}
+
 // mapWatch is the implementation of the interface MapWatch
 type mapWatch struct {
 	xwatch.Watch
@@ -4,14 +4,30 @@ package mocks
 import client "github.com/m3db/m3/src/cluster/client"
 import mock "github.com/stretchr/testify/mock"
 import services "github.com/m3db/m3/src/cluster/services"
+import time "time"
 import topology "github.com/uber/aresdb/cluster/topology"
 import utils "github.com/uber/aresdb/utils"
+import xretry "github.com/m3db/m3/src/x/retry"
 
 // DynamicOptions is an autogenerated mock type for the DynamicOptions type
 type DynamicOptions struct {
 	mock.Mock
 }
 
+// AllowPartialReplicas provides a mock function with given fields:
+func (_m *DynamicOptions) AllowPartialReplicas() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // ConfigServiceClient provides a mock function with given fields:
 func (_m *DynamicOptions) ConfigServiceClient() client.Client {
 	ret := _m.Called()
@@ -28,6 +44,20 @@ func (_m *DynamicOptions) ConfigServiceClient() client.Client {
 	return r0
 }
 
+// InitTimeout provides a mock function with given fields:
+func (_m *DynamicOptions) InitTimeout() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
 // InstrumentOptions provides a mock function with given fields:
 func (_m *DynamicOptions) InstrumentOptions() utils.Options {
 	ret := _m.Called()
@@ -92,6 +122,22 @@ func (_m *DynamicOptions) ServicesOverrideOptions() services.OverrideOptions {
 	return r0
 }
 
+// SetAllowPartialReplicas provides a mock function with given fields: value
+func (_m *DynamicOptions) SetAllowPartialReplicas(value bool) topology.DynamicOptions {
+	ret := _m.Called(value)
+
+	var r0 topology.DynamicOptions
+	if rf, ok := ret.Get(0).(func(bool) topology.DynamicOptions); ok {
+		r0 = rf(value)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(topology.DynamicOptions)
+		}
+	}
+
+	return r0
+}
+
 // SetConfigServiceClient provides a mock function with given fields: c
 func (_m *DynamicOptions) SetConfigServiceClient(c client.Client) topology.DynamicOptions {
 	ret := _m.Called(c)
@@ -108,6 +154,22 @@ func (_m *DynamicOptions) SetConfigServiceClient(c client.Client) topology.Dynam
 	return r0
 }
 
+// SetInitTimeout provides a mock function with given fields: value
+func (_m *DynamicOptions) SetInitTimeout(value time.Duration) topology.DynamicOptions {
+	ret := _m.Called(value)
+
+	var r0 topology.DynamicOptions
+	if rf, ok := ret.Get(0).(func(time.Duration) topology.DynamicOptions); ok {
+		r0 = rf(value)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(topology.DynamicOptions)
+		}
+	}
+
+	return r0
+}
+
 // SetInstrumentOptions provides a mock function with given fields: value
 func (_m *DynamicOptions) SetInstrumentOptions(value utils.Options) topology.DynamicOptions {
 	ret := _m.Called(value)
@@ -172,6 +234,22 @@ func (_m *DynamicOptions) SetServicesOverrideOptions(opts services.OverrideOptio
 	return r0
 }
 
+// SetWatchRetryOptions provides a mock function with given fields: value
+func (_m *DynamicOptions) SetWatchRetryOptions(value xretry.Options) topology.DynamicOptions {
+	ret := _m.Called(value)
+
+	var r0 topology.DynamicOptions
+	if rf, ok := ret.Get(0).(func(xretry.Options) topology.DynamicOptions); ok {
+		r0 = rf(value)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(topology.DynamicOptions)
+		}
+	}
+
+	return r0
+}
+
 // Validate provides a mock function with given fields:
 func (_m *DynamicOptions) Validate() error {
 	ret := _m.Called()
@@ -185,3 +263,19 @@ func (_m *DynamicOptions) Validate() error {
 
 	return r0
 }
+
+// WatchRetryOptions provides a mock function with given fields:
+func (_m *DynamicOptions) WatchRetryOptions() xretry.Options {
+	ret := _m.Called()
+
+	var r0 xretry.Options
+	if rf, ok := ret.Get(0).(func() xretry.Options); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(xretry.Options)
+		}
+	}
+
+	return r0
+}
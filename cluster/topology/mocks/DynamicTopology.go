@@ -30,6 +30,20 @@ func (_m *DynamicTopology) Get() topology.Map {
 	return r0
 }
 
+// Health provides a mock function with given fields:
+func (_m *DynamicTopology) Health() topology.Health {
+	ret := _m.Called()
+
+	var r0 topology.Health
+	if rf, ok := ret.Get(0).(func() topology.Health); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(topology.Health)
+	}
+
+	return r0
+}
+
 // MarkShardsAvailable provides a mock function with given fields: instanceID, shardIDs
 func (_m *DynamicTopology) MarkShardsAvailable(instanceID string, shardIDs ...uint32) error {
 	_va := make([]interface{}, len(shardIDs))
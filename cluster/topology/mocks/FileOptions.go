@@ -0,0 +1,85 @@
+// Code generated by mockery v1.0.0
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+import time "time"
+import topology "github.com/uber/aresdb/cluster/topology"
+
+// FileOptions is an autogenerated mock type for the FileOptions type
+type FileOptions struct {
+	mock.Mock
+}
+
+// Path provides a mock function with given fields:
+func (_m *FileOptions) Path() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// PollInterval provides a mock function with given fields:
+func (_m *FileOptions) PollInterval() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
+// SetPath provides a mock function with given fields: value
+func (_m *FileOptions) SetPath(value string) topology.FileOptions {
+	ret := _m.Called(value)
+
+	var r0 topology.FileOptions
+	if rf, ok := ret.Get(0).(func(string) topology.FileOptions); ok {
+		r0 = rf(value)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(topology.FileOptions)
+		}
+	}
+
+	return r0
+}
+
+// SetPollInterval provides a mock function with given fields: value
+func (_m *FileOptions) SetPollInterval(value time.Duration) topology.FileOptions {
+	ret := _m.Called(value)
+
+	var r0 topology.FileOptions
+	if rf, ok := ret.Get(0).(func(time.Duration) topology.FileOptions); ok {
+		r0 = rf(value)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(topology.FileOptions)
+		}
+	}
+
+	return r0
+}
+
+// Validate provides a mock function with given fields:
+func (_m *FileOptions) Validate() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
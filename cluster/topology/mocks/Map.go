@@ -131,3 +131,19 @@ func (_m *Map) ShardSet() shard.ShardSet {
 
 	return r0
 }
+
+// ShardStates provides a mock function with given fields:
+func (_m *Map) ShardStates() topology.ShardStates {
+	ret := _m.Called()
+
+	var r0 topology.ShardStates
+	if rf, ok := ret.Get(0).(func() topology.ShardStates); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(topology.ShardStates)
+		}
+	}
+
+	return r0
+}
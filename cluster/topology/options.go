@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"github.com/m3db/m3/src/cluster/client"
 	"github.com/m3db/m3/src/cluster/services"
+	xretry "github.com/m3db/m3/src/x/retry"
 	"github.com/uber/aresdb/cluster/shard"
 	"github.com/uber/aresdb/utils"
 	"time"
@@ -32,13 +33,21 @@ import (
 
 const (
 	defaultServiceName = "aresDB"
-	defaultInitTimeout = 0 // Wait indefinitely by default for topology
+	defaultInitTimeout = 30 * time.Second
 	defaultReplicas    = 3
+
+	defaultFilePollInterval = 10 * time.Second
+
+	defaultWatchMaxRetries     = 5
+	defaultWatchInitialBackoff = time.Second
+	defaultWatchMaxBackoff     = time.Minute
 )
 
 var (
 	errNoConfigServiceClient = errors.New("no config service client")
 	errInvalidReplicas       = errors.New("replicas must be equal to or greater than 1")
+	errNoTopologyFile        = errors.New("no topology file")
+	errInvalidPollInterval   = errors.New("poll interval must be positive")
 )
 
 // staticOptions is the implementation of the interface StaticOptions
@@ -93,6 +102,8 @@ type dynamicOptions struct {
 	queryOptions            services.QueryOptions
 	instrumentOptions       utils.Options
 	initTimeout             time.Duration
+	allowPartialReplicas    bool
+	watchRetryOptions       xretry.Options
 }
 
 // NewDynamicOptions creates a new set of dynamic topology options
@@ -103,6 +114,10 @@ func NewDynamicOptions() DynamicOptions {
 		queryOptions:            services.NewQueryOptions(),
 		instrumentOptions:       utils.NewOptions(),
 		initTimeout:             defaultInitTimeout,
+		watchRetryOptions: xretry.NewOptions().
+			SetMaxRetries(defaultWatchMaxRetries).
+			SetInitialBackoff(defaultWatchInitialBackoff).
+			SetMaxBackoff(defaultWatchMaxBackoff),
 	}
 }
 
@@ -181,6 +196,33 @@ func (o *dynamicOptions) InstrumentOptions() utils.Options {
 	return o.instrumentOptions
 }
 
+func (o *dynamicOptions) SetInitTimeout(value time.Duration) DynamicOptions {
+	o.initTimeout = value
+	return o
+}
+
+func (o *dynamicOptions) InitTimeout() time.Duration {
+	return o.initTimeout
+}
+
+func (o *dynamicOptions) SetWatchRetryOptions(value xretry.Options) DynamicOptions {
+	o.watchRetryOptions = value
+	return o
+}
+
+func (o *dynamicOptions) WatchRetryOptions() xretry.Options {
+	return o.watchRetryOptions
+}
+
+func (o *dynamicOptions) SetAllowPartialReplicas(value bool) DynamicOptions {
+	o.allowPartialReplicas = value
+	return o
+}
+
+func (o *dynamicOptions) AllowPartialReplicas() bool {
+	return o.allowPartialReplicas
+}
+
 func (o *dynamicOptions) Validate() error {
 	if o.ConfigServiceClient() == nil {
 		return errNoConfigServiceClient
@@ -188,3 +230,46 @@ func (o *dynamicOptions) Validate() error {
 
 	return nil
 }
+
+// fileOptions is the implementation of the interface FileOptions
+type fileOptions struct {
+	path         string
+	pollInterval time.Duration
+}
+
+// NewFileOptions creates a new set of file topology options
+func NewFileOptions() FileOptions {
+	return &fileOptions{
+		pollInterval: defaultFilePollInterval,
+	}
+}
+
+func (o *fileOptions) Validate() error {
+	if o.path == "" {
+		return errNoTopologyFile
+	}
+	if o.pollInterval <= 0 {
+		return errInvalidPollInterval
+	}
+	return nil
+}
+
+func (o *fileOptions) SetPath(value string) FileOptions {
+	opts := *o
+	opts.path = value
+	return &opts
+}
+
+func (o *fileOptions) Path() string {
+	return o.path
+}
+
+func (o *fileOptions) SetPollInterval(value time.Duration) FileOptions {
+	opts := *o
+	opts.pollInterval = value
+	return &opts
+}
+
+func (o *fileOptions) PollInterval() time.Duration {
+	return o.pollInterval
+}
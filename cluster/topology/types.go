@@ -25,8 +25,10 @@ import (
 	"github.com/m3db/m3/src/cluster/client"
 	"github.com/m3db/m3/src/cluster/services"
 	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	xretry "github.com/m3db/m3/src/x/retry"
 	"github.com/uber/aresdb/cluster/shard"
 	"github.com/uber/aresdb/utils"
+	"time"
 )
 
 var (
@@ -76,6 +78,9 @@ type Map interface {
 
 	// Replicas returns the number of replicas in the topology
 	Replicas() int
+
+	// ShardStates returns states of shards of hosts in the map by shard and host
+	ShardStates() ShardStates
 }
 
 type MapWatch interface {
@@ -120,6 +125,30 @@ type DynamicTopology interface {
 
 	// MarkShardsAvailable marks a shard with the state of initializing as available
 	MarkShardsAvailable(instanceID string, shardIDs ...uint32) error
+
+	// Health returns the health of watching the placement, the last known map is still served when unhealthy
+	Health() Health
+}
+
+// Health is the health of dynamic topologies
+type Health int
+
+const (
+	// Healthy means the placement is watched from the config service
+	Healthy Health = iota
+	// Unhealthy means the watch of the placement failed to be re-created after the max retries
+	Unhealthy
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Unhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
 }
 
 // StaticConfiguration is used for standing up M3DB with a static topology
@@ -133,6 +162,26 @@ type StaticConfiguration struct {
 type HostShardConfig struct {
 	HostID        string `yaml:"hostID"`
 	ListenAddress string `yaml:"listenAddress"`
+	// Shards are assigned round robin by replicas if no host has shards configured
+	Shards []uint32 `yaml:"shards"`
+}
+
+// FileOptions is a set of options for file based static topology
+type FileOptions interface {
+	// Validate validates the options
+	Validate() error
+
+	// SetPath sets the path of the yaml file of StaticConfiguration
+	SetPath(value string) FileOptions
+
+	// Path returns the path of the yaml file of StaticConfiguration
+	Path() string
+
+	// SetPollInterval sets the interval polling the file for changes
+	SetPollInterval(value time.Duration) FileOptions
+
+	// PollInterval returns the interval polling the file for changes
+	PollInterval() time.Duration
 }
 
 // StaticOptions is a set of options for static topology
@@ -193,6 +242,26 @@ type DynamicOptions interface {
 
 	// InstrumentOptions returns the instrumentation options
 	InstrumentOptions() utils.Options
+
+	// SetInitTimeout sets the timeout waiting for the initial placement, 0 waits indefinitely
+	SetInitTimeout(value time.Duration) DynamicOptions
+
+	// InitTimeout returns the timeout waiting for the initial placement
+	InitTimeout() time.Duration
+
+	// SetWatchRetryOptions sets the retry options re-creating the placement watch once closed, the topology is
+	// marked unhealthy after the max retries while the watch is retried indefinitely
+	SetWatchRetryOptions(value xretry.Options) DynamicOptions
+
+	// WatchRetryOptions returns the retry options re-creating the placement watch
+	WatchRetryOptions() xretry.Options
+
+	// SetAllowPartialReplicas sets whether placements with shards missing replicas are accepted, with
+	// states of shards preserved instead of marked available
+	SetAllowPartialReplicas(value bool) DynamicOptions
+
+	// AllowPartialReplicas returns whether placements with shards missing replicas are accepted
+	AllowPartialReplicas() bool
 }
 
 // ShardOwner represents an entity that owned shards
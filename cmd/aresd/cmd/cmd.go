@@ -23,6 +23,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/api"
+	apiCom "github.com/uber/aresdb/api/common"
 	"github.com/uber/aresdb/cgoutils"
 	"github.com/uber/aresdb/cluster/topology"
 	"github.com/uber/aresdb/common"
@@ -131,9 +132,13 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	}
 
 	// Create DiskStore.
-	diskStore := diskstore.NewLocalDiskStore(cfg.RootPath)
+	diskStore, err := diskstore.NewDiskStore(cfg.RootPath, cfg.DiskStore)
+	if err != nil {
+		logger.Panic(err)
+	}
 
 	// fetch schema from controller and start periodical job
+	var schemaDriftDetector *metastore.SchemaDriftDetector
 	if cfg.Cluster.Enable {
 		if cfg.Cluster.Namespace == "" {
 			logger.Fatal("Missing cluster name")
@@ -146,12 +151,19 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 			controllerClientCfg.Headers.Add(controllerCli.InstanceNameHeaderKey, cfg.Cluster.InstanceID)
 		}
 
-		controllerClient := controllerCli.NewControllerHTTPClient(controllerClientCfg.Address, time.Duration(controllerClientCfg.TimeoutSec)*time.Second, controllerClientCfg.Headers)
-		schemaFetchJob := metastore.NewSchemaFetchJob(5*60, metaStore, metastore.NewTableSchameValidator(), controllerClient, cfg.Cluster.Namespace, "")
+		controllerClient := controllerCli.NewControllerHTTPClientFromConfig(*controllerClientCfg)
+		schemaFetchJob := metastore.NewSchemaFetchJob(5*60, metaStore.WithActor(metastore.SchemaFetchActor), metastore.NewTableSchameValidator(), controllerClient, cfg.Cluster.Namespace, "")
 		// immediate initial fetch
 		schemaFetchJob.FetchSchema()
 		go schemaFetchJob.Run()
 
+		schemaDriftDetector = metastore.NewSchemaDriftDetector(10*60, metaStore, controllerClient, cfg.Cluster.Namespace)
+		schemaDriftDetector.SetResync(schemaFetchJob.RequestResync, controllerClientCfg.ResyncOnSchemaDrift)
+		go schemaDriftDetector.Run()
+	} else {
+		// deprecated columns are purged by whoever manages the schema, the controller in cluster mode.
+		columnPurgeJob := metastore.NewColumnPurgeJob(60*60, metaStore.WithActor(metastore.ColumnPurgeActor))
+		go columnPurgeJob.Run()
 	}
 
 	bootstrapToken := bootstrap.NewPeerDataNodeServer(metaStore, diskStore).(memCom.BootStrapToken)
@@ -161,8 +173,18 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 		utils.GetLogger().Fatal(err)
 	}
 
+	// Start disk space monitoring before recovery so that we don't fill up the disk while replaying redologs.
+	diskSpaceMonitor := diskstore.NewDiskSpaceMonitor(cfg.RootPath, cfg.DiskStore.Space)
+	if listener, ok := diskStore.(diskstore.DiskSpaceListener); ok {
+		diskSpaceMonitor.AddListener(listener.OnDiskSpaceLevelChange)
+	}
+	diskSpaceMonitor.Start()
+	diskstore.GetIOStats().Start(time.Duration(cfg.DiskStore.IOSummaryIntervalSeconds) * time.Second)
+
 	// Create MemStore.
-	memStore := memstore.NewMemStore(metaStore, diskStore, memstore.NewOptions(bootstrapToken, redoLogManagerMaster))
+	memStore := memstore.NewMemStore(metaStore, diskStore,
+		memstore.NewOptions(bootstrapToken, redoLogManagerMaster).SetDiskSpaceMonitor(diskSpaceMonitor).
+			SetArchiveWriteConcurrency(cfg.DiskStore.ArchiveWriteConcurrency))
 
 	// Read schema.
 	utils.GetLogger().Infof("Reading schema from local MetaStore %s", metaStorePath)
@@ -172,7 +194,10 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	}
 
 	// create schema handler
-	schemaHandler := api.NewSchemaHandler(metaStore)
+	schemaHandler := api.NewSchemaHandler(metaStore, cfg.Cluster.Namespace)
+	if schemaDriftDetector != nil {
+		schemaHandler.SetSchemaDriftDetector(schemaDriftDetector)
+	}
 
 	// create enum handler
 	enumHandler := api.NewEnumHandler(memStore, metaStore)
@@ -184,6 +209,8 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 
 	// create health check handler.
 	healthCheckHandler := api.NewHealthCheckHandler()
+	healthChecker := apiCom.NewHealthChecker()
+	healthChecker.AddReadinessCheck("disk", diskSpaceMonitor.CheckReadiness)
 
 	nodeModulesHandler := http.StripPrefix("/node_modules/", http.FileServer(http.Dir("./api/ui/node_modules/")))
 
@@ -227,12 +254,14 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	enumHandler.Register(router.PathPrefix("/schema").Subrouter(), httpWrappers...)
 	dataHandler.Register(router.PathPrefix("/data").Subrouter(), httpWrappers...)
 	queryHandler.Register(router.PathPrefix("/query").Subrouter(), httpWrappers...)
+	queryHandler.RegisterV2(router.PathPrefix("/v2/query").Subrouter(), httpWrappers...)
 
 	swaggerHandler := http.StripPrefix("/swagger/", http.FileServer(http.Dir("./api/ui/swagger/")))
 	router.PathPrefix("/swagger/").Handler(swaggerHandler)
 	router.PathPrefix("/node_modules/").Handler(nodeModulesHandler)
 	router.HandleFunc("/health", utils.WithMetricsFunc(healthCheckHandler.HealthCheck))
 	router.HandleFunc("/version", healthCheckHandler.Version)
+	healthChecker.Register(router, utils.WithMetricsFunc)
 
 	// Support CORS calls.
 	allowOrigins := handlers.AllowedOrigins([]string{"*"})
@@ -245,9 +274,11 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	go batchStatsReporter.Run()
 
 	utils.GetLogger().Infof("Starting HTTP server on port %d with max connection %d", cfg.Port, cfg.HTTP.MaxConnections)
-	utils.LimitServe(cfg.Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(router), cfg.HTTP)
+	utils.LimitServe(cfg.Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(router), cfg.HTTP, healthChecker.Shutdown)
 	batchStatsReporter.Stop()
 	redoLogManagerMaster.Stop()
+	diskSpaceMonitor.Stop()
+	diskstore.GetIOStats().Stop()
 }
 
 // start datanode in distributed mode
@@ -267,6 +298,7 @@ func startDataNode(cfg common.AresServerConfig, logger common.Logger, scope tall
 	dynamicOptions := topology.NewDynamicOptions().
 		SetConfigServiceClient(configServiceCli).
 		SetQueryOptions(services.NewQueryOptions().SetIncludeUnhealthy(true)).
+		SetAllowPartialReplicas(cfg.Cluster.AllowPartialReplicas).
 		SetServiceID(services.NewServiceID().
 			SetZone(etcdCfg.Zone).
 			SetName(etcdCfg.Service).
@@ -24,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	apiCom "github.com/uber/aresdb/api/common"
 	"github.com/uber/aresdb/broker"
 	"github.com/uber/aresdb/broker/config"
 	"github.com/uber/aresdb/cluster/topology"
@@ -32,9 +33,9 @@ import (
 	"github.com/uber/aresdb/controller/client"
 	dataNodeCli "github.com/uber/aresdb/datanode/client"
 	"github.com/uber/aresdb/metastore"
+	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/utils"
 	"go.uber.org/zap"
-	"time"
 )
 
 func Execute(setters ...cmd.Option) {
@@ -97,38 +98,84 @@ func start(cfg config.BrokerConfig, logger common.Logger, queryLogger common.Log
 	}
 
 	clusterName := cfg.Cluster.Namespace
-	controllerClient := client.NewControllerHTTPClient(controllerClientCfg.Address, time.Duration(controllerClientCfg.TimeoutSec)*time.Second, controllerClientCfg.Headers)
+	controllerClient := client.NewControllerHTTPClientFromConfig(*controllerClientCfg)
 	schemaMutator := broker.NewBrokerSchemaMutator()
-	schemaFetchJob := metastore.NewSchemaFetchJob(10, schemaMutator, metastore.NewTableSchameValidator(), controllerClient, clusterName, "")
-	schemaFetchJob.FetchSchema()
-	go schemaFetchJob.Run()
 
 	var topo topology.Topology
 
 	serviceName := utils.BrokerServiceName(clusterName)
 
-	cfg.Etcd.Service = serviceName
-	configServiceCli, err := cfg.Etcd.NewClient(
-		instrument.NewOptions().SetLogger(zap.NewExample()))
-	if err != nil {
-		logger.Fatal("Failed to create config service client,", err)
-	}
-	dynamicOptions := topology.NewDynamicOptions().SetConfigServiceClient(configServiceCli).SetServiceID(services.NewServiceID().SetZone(cfg.Etcd.Zone).SetName(serviceName).SetEnvironment(cfg.Etcd.Env))
-	topo, err = topology.NewDynamicInitializer(dynamicOptions).Init()
-	if err != nil {
-		logger.Fatal("Failed to initialize dynamic topology,", err)
+	if cfg.Cluster.TopologyFile != "" {
+		topo, err = topology.NewFileInitializer(topology.NewFileOptions().SetPath(cfg.Cluster.TopologyFile)).Init()
+		if err != nil {
+			logger.Fatal("Failed to initialize file topology,", err)
+		}
+	} else {
+		cfg.Etcd.Service = serviceName
+		configServiceCli, err := cfg.Etcd.NewClient(
+			instrument.NewOptions().SetLogger(zap.NewExample()))
+		if err != nil {
+			logger.Fatal("Failed to create config service client,", err)
+		}
+		dynamicOptions := topology.NewDynamicOptions().SetConfigServiceClient(configServiceCli).SetServiceID(services.NewServiceID().SetZone(cfg.Etcd.Zone).SetName(serviceName).SetEnvironment(cfg.Etcd.Env)).
+			SetAllowPartialReplicas(cfg.Cluster.AllowPartialReplicas)
+		topo, err = topology.NewDynamicInitializer(dynamicOptions).Init()
+		if err != nil {
+			logger.Fatal("Failed to initialize dynamic topology,", err)
+		}
 	}
 
+	healthTracker := broker.NewHealthTracker(cfg.HostHealth)
+	dataNodeQueryClient := broker.NewHealthTrackedClient(dataNodeCli.NewDataNodeQueryClient(), healthTracker)
+	schemaVersionChecker := broker.NewSchemaVersionChecker(cfg.SchemaVersionCheck, topo, dataNodeQueryClient)
+	schemaMutator.RegisterChangeListener(schemaVersionChecker.OnSchemaChange)
+	go schemaVersionChecker.Run()
+	schemaValidator := broker.NewSchemaValidator(cfg.SchemaValidation)
+	schemaMutator.RegisterChangeListener(schemaValidator.OnSchemaChange)
+
+	// fetch schema after listeners are registered to not miss any changes.
+	schemaFetchJob := metastore.NewSchemaFetchJob(10, schemaMutator, metastore.NewTableSchameValidator(), controllerClient, clusterName, "")
+	schemaFetchJob.FetchSchema()
+	go schemaFetchJob.Run()
+
 	// executor
-	exec := broker.NewQueryExecutor(schemaMutator, topo, dataNodeCli.NewDataNodeQueryClient())
+	queryRegistry := queryCom.NewQueryRegistry(queryCom.DefaultQueryHistorySize)
+	queryStats := broker.NewQueryStatsTracker(cfg.QueryStats)
+	go queryStats.Run()
+	circuitBreaker := broker.NewCircuitBreaker(cfg.CircuitBreaker, topo)
+	go circuitBreaker.Run()
+	exec := broker.NewQueryExecutor(schemaMutator, topo, dataNodeQueryClient, broker.QueryExecutorOptions{
+		Config:               cfg,
+		SchemaVersionChecker: schemaVersionChecker,
+		SchemaValidator:      schemaValidator,
+		SchemaRefresher:      schemaFetchJob,
+		Breaker:              circuitBreaker,
+		HealthTracker:        healthTracker,
+		RetryBudget:          broker.NewRetryBudget(cfg.RetryBudget),
+		Registry:             queryRegistry,
+		QueryStats:           queryStats,
+	})
 
 	// init handlers
-	queryHandler := broker.NewQueryHandler(exec)
+	queryHandler := broker.NewQueryHandler(exec, cfg.Compression)
+	queriesHandler := broker.NewQueriesHandler(queryRegistry)
+	debugHandler := broker.NewDebugHandler(schemaVersionChecker, schemaMutator, topo, dataNodeQueryClient, queryRegistry, queryStats, healthTracker)
+	hllUnionHandler := broker.NewHLLUnionHandler(cfg.HLLUnion)
+	webSocketQueryHandler := broker.NewWebSocketQueryHandler(exec, cfg.WebSocket)
+	healthChecker := apiCom.NewHealthChecker()
+	broker.AddReadinessChecks(healthChecker, topo, cfg.Health)
 
 	// start HTTP server
 	router := mux.NewRouter()
 	httpWrappers = append([]utils.HTTPHandlerWrapper{utils.WithMetricsFunc}, httpWrappers...)
-	queryHandler.Register(router.PathPrefix("/query").Subrouter(), httpWrappers...)
+	queryRouter := router.PathPrefix("/query").Subrouter()
+	queryHandler.Register(queryRouter, httpWrappers...)
+	hllUnionHandler.Register(queryRouter, httpWrappers...)
+	webSocketQueryHandler.Register(queryRouter, httpWrappers...)
+	queryHandler.RegisterV2(router.PathPrefix("/v2/query").Subrouter(), httpWrappers...)
+	queriesHandler.Register(router, httpWrappers...)
+	debugHandler.Register(router.PathPrefix("/debug").Subrouter(), httpWrappers...)
+	healthChecker.Register(router, utils.WithMetricsFunc)
 
 	// Support CORS calls.
 	allowOrigins := handlers.AllowedOrigins([]string{"*"})
@@ -136,7 +183,7 @@ func start(cfg config.BrokerConfig, logger common.Logger, queryLogger common.Log
 	allowMethods := handlers.AllowedMethods([]string{"GET", "PUT", "POST", "DELETE", "OPTIONS"})
 
 	utils.GetLogger().Infof("Starting HTTP server on port %d with max connection %d", cfg.Port, cfg.HTTP.MaxConnections)
-	utils.LimitServe(cfg.Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(router), cfg.HTTP)
+	utils.LimitServe(cfg.Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(router), cfg.HTTP, healthChecker.Shutdown)
 }
 
 // AddFlags adds flags to command
@@ -33,11 +33,73 @@ type QueryConfig struct {
 	DeviceChoosingTimeout int            `yaml:"device_choosing_timeout"`
 	TimezoneTable         TimezoneConfig `yaml:"timezone_table"`
 	EnableHashReduction   bool           `yaml:"enable_hash_reduction"`
+	// number of archive batches to read ahead while processing current batch, 0 disables prefetch.
+	ArchivePrefetchBatches int `yaml:"archive_prefetch_batches"`
+	// max number of concurrent vector party reads issued by prefetch, default to 32.
+	ArchivePrefetchMaxReads int `yaml:"archive_prefetch_max_reads"`
+}
+
+// DiskSpaceConfig is the static configuration for disk space monitoring of the disk store.
+// Each watermark can be specified either as percentage or absolute bytes of free space,
+// a watermark is reached when either of them is reached. A watermark with neither set
+// is disabled.
+type DiskSpaceConfig struct {
+	// below soft watermark an alert is sent and purge is accelerated.
+	SoftWatermarkPercent float64 `yaml:"soft_watermark_percent"`
+	SoftWatermarkBytes   int64   `yaml:"soft_watermark_bytes"`
+	// below hard watermark ingestion is rejected and archiving is paused.
+	HardWatermarkPercent float64 `yaml:"hard_watermark_percent"`
+	HardWatermarkBytes   int64   `yaml:"hard_watermark_bytes"`
+	// interval between two disk space checks, default to 10 seconds.
+	CheckIntervalSeconds int `yaml:"check_interval_seconds"`
+	// url to post alerts to when watermark level changes.
+	AlertWebhookURL string `yaml:"alert_webhook_url"`
+}
+
+// ObjectStoreConfig is the static configuration for object store backed disk store.
+type ObjectStoreConfig struct {
+	// bucket to store the snapshot and archive batch files.
+	Bucket string `yaml:"bucket"`
+	// key prefix of all objects written by this deployment.
+	Prefix string `yaml:"prefix"`
+	Region string `yaml:"region"`
+	// custom endpoint for S3 compatible object stores other than AWS S3.
+	Endpoint       string `yaml:"endpoint"`
+	ForcePathStyle bool   `yaml:"force_path_style"`
+	// files larger than part size will be uploaded with multipart upload.
+	PartSizeBytes int64 `yaml:"part_size_bytes"`
+	// size of local file cache for objects, default to 10GB.
+	CacheSizeBytes int64 `yaml:"cache_size_bytes"`
+	// max retries on transient errors.
+	MaxRetries int `yaml:"max_retries"`
 }
 
 // DiskStoreConfig is the static configuration for disk store.
 type DiskStoreConfig struct {
-	WriteSync bool `yaml:"write_sync"`
+	// type of disk store, either local (default) or object_store.
+	Type      string `yaml:"type"`
+	WriteSync bool   `yaml:"write_sync"`
+	// block size in bytes for per block checksums of vector party files, default to 4MB.
+	ChecksumBlockSize int `yaml:"checksum_block_size"`
+	// whether to memory map archive vector party files for read instead of copying them into memory.
+	MmapReads bool `yaml:"mmap_reads"`
+	// whether to write enum columns of archive batches with per batch dictionary encoding
+	// (vector party file format v2), which cannot be read by older versions.
+	EnumDictionaryEncoding bool `yaml:"enum_dictionary_encoding"`
+	// max number of column files written concurrently when persisting archive batches, shared by
+	// all table shards. Columns are written sequentially if not greater than 1.
+	ArchiveWriteConcurrency int `yaml:"archive_write_concurrency"`
+	// interval in seconds to log the tables with the most disk I/O, disabled if not positive.
+	IOSummaryIntervalSeconds int `yaml:"io_summary_interval_seconds"`
+	// interval in hours to verify files of each table shard against their checksums in background,
+	// disabled if not positive.
+	IntegrityCheckIntervalHours int `yaml:"integrity_check_interval_hours"`
+	// max bytes per second read by the background integrity check, not throttled if not positive.
+	IntegrityCheckBytesPerSec int64 `yaml:"integrity_check_bytes_per_sec"`
+	// object store config used when type is object_store.
+	ObjectStore ObjectStoreConfig `yaml:"object_store"`
+	// disk space watermarks.
+	Space DiskSpaceConfig `yaml:"space"`
 }
 
 // HTTPConfig is the static configuration for main http server (query and schema).
@@ -45,6 +107,8 @@ type HTTPConfig struct {
 	MaxConnections        int `yaml:"max_connections"`
 	ReadTimeOutInSeconds  int `yaml:"read_time_out_in_seconds"`
 	WriteTimeOutInSeconds int `yaml:"write_time_out_in_seconds"`
+	// seconds to keep serving after readiness fails on graceful shutdown before closing the listener.
+	ShutdownDrainSeconds int `yaml:"shutdown_drain_seconds"`
 }
 
 // ControllerConfig is the config for ares-controller client
@@ -52,6 +116,16 @@ type ControllerConfig struct {
 	Address    string      `yaml:"address"`
 	Headers    http.Header `yaml:"headers"`
 	TimeoutSec int         `yaml:"timeout"`
+	// Overall deadline of retrying a failed call, TimeoutSec applies to each attempt. 0 means the default.
+	RetryDeadlineSec int `yaml:"retry_deadline"`
+	// Max age of the cached response served when controller is unavailable. 0 means the default.
+	MaxStalenessSec int `yaml:"max_staleness"`
+	// Whether to watch change notifications controller writes into etcd to fetch changes immediately,
+	// polling is kept at a much longer interval as a fallback.
+	WatchNotifications bool `yaml:"watch_notifications"`
+	// Whether to resync schema from controller once local schema is found drifted from controller,
+	// drift is only reported otherwise.
+	ResyncOnSchemaDrift bool `yaml:"resync_on_schema_drift"`
 }
 
 // HeartbeatConfig is the config for timeout and check interval with etcd
@@ -85,6 +159,14 @@ type ClusterConfig struct {
 
 	// heartbeat config
 	HeartbeatConfig HeartbeatConfig `yaml:"heartbeat"`
+
+	// AllowPartialReplicas controls whether placements with shards missing replicas, e.g. during node
+	// replacements or resharding, are accepted by the topology with states of shards preserved
+	AllowPartialReplicas bool `yaml:"allow_partial_replicas"`
+
+	// TopologyFile is the yaml file of the static topology of datanodes used by brokers instead of the
+	// config service, changes of the file are picked up without restart
+	TopologyFile string `yaml:"topology_file"`
 }
 
 // local redolog config
@@ -17,14 +17,15 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/uber/aresdb/client"
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/controller/models"
 
 	metaCom "github.com/uber/aresdb/metastore/common"
@@ -36,23 +37,32 @@ const (
 	InstanceNameHeaderKey = "AresDB-InstanceName"
 )
 
+// ErrSchemaDeltaNotSupported is returned by GetSchemaDelta if the controller does not support schema delta,
+// clients should fall back to fetching all schemas
+var ErrSchemaDeltaNotSupported = errors.New("aresDB controller does not support schema delta")
+
 // ControllerClient defines methods to communicate with ares-controller
 type ControllerClient interface {
 	client.SchemaFetcher
 
 	GetSchemaHash(namespace string) (string, error)
 	GetAllSchema(namespace string) ([]metaCom.Table, error)
+	GetSchemaDelta(namespace string, deltaRequest models.SchemaDeltaRequest) (*models.SchemaDelta, error)
 	GetNamespaces() ([]string, error)
 	GetAssignmentHash(jobNamespace, instance string) (string, error)
 	GetAssignment(jobNamespace, instance string) (*models.IngestionAssignment, error)
 }
 
-// ControllerHTTPClient implements ControllerClient over http
+// ControllerHTTPClient implements ControllerClient over http. Calls failed due to controller unavailability
+// are retried, and GET calls fall back to the last successful response of the endpoint once retries are
+// exhausted, until the response exceeds the staleness limit.
 type ControllerHTTPClient struct {
-	c         *http.Client
-	address   string
-	headers   http.Header
-	namespace string
+	c           *http.Client
+	address     string
+	headers     http.Header
+	namespace   string
+	retryPolicy RetryPolicy
+	cache       *responseCache
 }
 
 // NewControllerHTTPClient returns new ControllerHTTPClient
@@ -61,9 +71,42 @@ func NewControllerHTTPClient(address string, timeoutSec time.Duration, headers h
 		c: &http.Client{
 			Timeout: timeoutSec,
 		},
-		address: address,
-		headers: headers,
+		address:     address,
+		headers:     headers,
+		retryPolicy: DefaultRetryPolicy(),
+		cache:       newResponseCache(defaultMaxStaleness),
+	}
+}
+
+// NewControllerHTTPClientFromConfig returns new ControllerHTTPClient with the retry deadline and staleness
+// limit from config, defaults are used if not set.
+func NewControllerHTTPClientFromConfig(cfg common.ControllerConfig) *ControllerHTTPClient {
+	c := NewControllerHTTPClient(cfg.Address, time.Duration(cfg.TimeoutSec)*time.Second, cfg.Headers)
+	if cfg.RetryDeadlineSec > 0 {
+		c.retryPolicy.Deadline = time.Duration(cfg.RetryDeadlineSec) * time.Second
 	}
+	if cfg.MaxStalenessSec > 0 {
+		c.SetMaxStaleness(time.Duration(cfg.MaxStalenessSec) * time.Second)
+	}
+	return c
+}
+
+// SetRetryPolicy sets the policy to retry failed calls
+func (c *ControllerHTTPClient) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetMaxStaleness sets the max age of cached responses served when controller is unavailable
+func (c *ControllerHTTPClient) SetMaxStaleness(maxStaleness time.Duration) {
+	c.cache.Lock()
+	c.cache.maxStaleness = maxStaleness
+	c.cache.Unlock()
+}
+
+// Staleness returns the age of the oldest cached response served in place of failed calls,
+// 0 means all responses are up to date with controller.
+func (c *ControllerHTTPClient) Staleness() time.Duration {
+	return c.cache.staleness()
 }
 
 // buildRequest builds an http.Request with headers.
@@ -88,25 +131,34 @@ func (c *ControllerHTTPClient) buildRequest(method, path string, body io.Reader)
 }
 
 func (c *ControllerHTTPClient) getResponse(request *http.Request) (respBytes []byte, err error) {
-	resp, err := c.c.Do(request)
-	if resp != nil {
-		defer resp.Body.Close()
-	}
+	endpoint := fmt.Sprintf("%s %s", request.Method, request.URL.Path)
+	resp, err := c.doWithRetry(request)
 	if err != nil {
-		return
+		if request.Method != http.MethodGet {
+			return
+		}
+		// serve the last successful response during controller outage.
+		var cacheErr error
+		if respBytes, cacheErr = c.cache.get(endpoint); cacheErr != nil {
+			err = utils.StackError(err, cacheErr.Error())
+			return
+		}
+		utils.GetLogger().With(
+			"endpoint", endpoint,
+			"error", err.Error(),
+		).Warn("Controller unavailable, serving cached response")
+		return respBytes, nil
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("aresDB controller return status: %d", resp.StatusCode)
+	if resp.statusCode != http.StatusOK {
+		err = fmt.Errorf("aresDB controller return status: %d", resp.statusCode)
 		return
 	}
 
-	respBytes, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		respBytes = nil
-		return
+	respBytes = resp.body
+	if request.Method == http.MethodGet {
+		c.cache.put(endpoint, respBytes)
 	}
-
 	return
 }
 
@@ -149,6 +201,43 @@ func (c *ControllerHTTPClient) GetAllSchema(namespace string) (tables []metaCom.
 	return
 }
 
+// GetSchemaDelta fetches tables whose schema hashes differ from the ones in the request,
+// returns nil delta if the namespace schema hash is unchanged
+func (c *ControllerHTTPClient) GetSchemaDelta(namespace string, deltaRequest models.SchemaDeltaRequest) (delta *models.SchemaDelta, err error) {
+	requestBytes, err := json.Marshal(deltaRequest)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal schema delta request")
+	}
+
+	request, err := c.buildRequest(http.MethodPost, fmt.Sprintf("/schema/%s/tables/delta", namespace), bytes.NewReader(requestBytes))
+	if err != nil {
+		return
+	}
+	request.Header.Add(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+
+	resp, err := c.doWithRetry(request)
+	if err != nil {
+		err = utils.StackError(err, "controller client error fetching schema delta")
+		return
+	}
+
+	switch resp.statusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrSchemaDeltaNotSupported
+	default:
+		return nil, fmt.Errorf("aresDB controller return status: %d", resp.statusCode)
+	}
+
+	delta = &models.SchemaDelta{}
+	if err = json.Unmarshal(resp.body, delta); err != nil {
+		return nil, utils.StackError(err, "controller client error decoding schema delta")
+	}
+	return
+}
+
 func (c *ControllerHTTPClient) GetNamespaces() (namespaces []string, err error) {
 	request, err := c.buildRequest(http.MethodGet, "/namespaces", nil)
 	if err != nil {
@@ -263,3 +352,37 @@ func (c *ControllerHTTPClient) ExtendEnumCases(tableName, columnName string, enu
 	return
 
 }
+
+// ExtendEnumCasesBulk extends enum cases to multiple columns of given table at once, returns
+// client.ErrBulkEnumCasesNotSupported if the controller does not support it
+func (c *ControllerHTTPClient) ExtendEnumCasesBulk(tableName string, enumCases map[string][]string) (enumIDs map[string][]int, err error) {
+	requestBytes, err := json.Marshal(metaCom.BulkEnumCases{EnumCases: enumCases})
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal enum cases")
+	}
+
+	request, err := c.buildRequest(http.MethodPost, fmt.Sprintf("schema/%s/tables/%s/enum-cases", c.namespace, tableName), bytes.NewReader(requestBytes))
+	if err != nil {
+		return
+	}
+	request.Header.Add(utils.HTTPContentTypeHeaderKey, utils.HTTPContentTypeApplicationJson)
+
+	resp, err := c.doWithRetry(request)
+	if err != nil {
+		err = utils.StackError(err, "controller client error extending enum cases for table: %s", tableName)
+		return
+	}
+
+	switch resp.statusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, client.ErrBulkEnumCasesNotSupported
+	default:
+		return nil, fmt.Errorf("aresDB controller return status: %d", resp.statusCode)
+	}
+
+	if err = json.Unmarshal(resp.body, &enumIDs); err != nil {
+		return nil, utils.StackError(err, "controller client error decoding enum ids")
+	}
+	return
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/controller/models"
 	"github.com/uber/aresdb/metastore/common"
 )
 
@@ -83,6 +84,23 @@ var _ = ginkgo.Describe("Controller", func() {
 		testRouter.HandleFunc("/schema/ns1/hash", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("123"))
 		})
+		testRouter.HandleFunc("/schema/ns1/tables/delta", func(w http.ResponseWriter, r *http.Request) {
+			var deltaRequest models.SchemaDeltaRequest
+			json.NewDecoder(r.Body).Decode(&deltaRequest)
+			if deltaRequest.Hash == "123" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			delta := models.SchemaDelta{Hash: "123"}
+			if deltaRequest.TableHashes[table.Name] != models.TableSchemaHash(tableBytes) {
+				delta.UpdatedTables = append(delta.UpdatedTables, table)
+			}
+			if _, ok := deltaRequest.TableHashes["test3"]; ok {
+				delta.DeletedTables = append(delta.DeletedTables, "test3")
+			}
+			b, _ := json.Marshal(delta)
+			w.Write(b)
+		}).Methods(http.MethodPost)
 		testRouter.HandleFunc("/assignment/ns1/hash/0", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("123"))
 		})
@@ -210,6 +228,33 @@ var _ = ginkgo.Describe("Controller", func() {
 		Ω(column2extendedEnumIDsGot).Should(Equal(column2extendedEnumIDs))
 	})
 
+	ginkgo.It("GetSchemaDelta should work", func() {
+		c := NewControllerHTTPClient(hostPort, 20*time.Second, headers)
+
+		delta, err := c.GetSchemaDelta("ns1", models.SchemaDeltaRequest{Hash: "123"})
+		Ω(err).Should(BeNil())
+		Ω(delta).Should(BeNil())
+
+		delta, err = c.GetSchemaDelta("ns1", models.SchemaDeltaRequest{
+			Hash: "456",
+			TableHashes: map[string]string{
+				"test1": "stale",
+				"test2": models.TableSchemaHash(tableBytes1),
+				"test3": "deleted",
+			},
+		})
+		Ω(err).Should(BeNil())
+		Ω(*delta).Should(Equal(models.SchemaDelta{
+			Hash:          "123",
+			UpdatedTables: []common.Table{table},
+			DeletedTables: []string{"test3"},
+		}))
+
+		// controller without schema delta support.
+		_, err = c.GetSchemaDelta("bad_ns", models.SchemaDeltaRequest{Hash: "456"})
+		Ω(err).Should(Equal(ErrSchemaDeltaNotSupported))
+	})
+
 	ginkgo.It("should fail with errors", func() {
 		c := NewControllerHTTPClient(hostPort, 2*time.Second, headers)
 		_, err := c.GetSchemaHash("bad_ns")
@@ -33,6 +33,29 @@ func (_m *ControllerClient) ExtendEnumCases(tableName string, columnName string,
 	return r0, r1
 }
 
+// ExtendEnumCasesBulk provides a mock function with given fields: tableName, enumCases
+func (_m *ControllerClient) ExtendEnumCasesBulk(tableName string, enumCases map[string][]string) (map[string][]int, error) {
+	ret := _m.Called(tableName, enumCases)
+
+	var r0 map[string][]int
+	if rf, ok := ret.Get(0).(func(string, map[string][]string) map[string][]int); ok {
+		r0 = rf(tableName, enumCases)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string][]int)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, map[string][]string) error); ok {
+		r1 = rf(tableName, enumCases)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FetchAllEnums provides a mock function with given fields: tableName, columnName
 func (_m *ControllerClient) FetchAllEnums(tableName string, columnName string) ([]string, error) {
 	ret := _m.Called(tableName, columnName)
@@ -192,6 +215,29 @@ func (_m *ControllerClient) GetNamespaces() ([]string, error) {
 	return r0, r1
 }
 
+// GetSchemaDelta provides a mock function with given fields: namespace, deltaRequest
+func (_m *ControllerClient) GetSchemaDelta(namespace string, deltaRequest models.SchemaDeltaRequest) (*models.SchemaDelta, error) {
+	ret := _m.Called(namespace, deltaRequest)
+
+	var r0 *models.SchemaDelta
+	if rf, ok := ret.Get(0).(func(string, models.SchemaDeltaRequest) *models.SchemaDelta); ok {
+		r0 = rf(namespace, deltaRequest)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.SchemaDelta)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, models.SchemaDeltaRequest) error); ok {
+		r1 = rf(namespace, deltaRequest)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSchemaHash provides a mock function with given fields: namespace
 func (_m *ControllerClient) GetSchemaHash(namespace string) (string, error) {
 	ret := _m.Called(namespace)
@@ -0,0 +1,111 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"time"
+
+	"github.com/m3db/m3/src/cluster/kv"
+	"github.com/uber/aresdb/utils"
+)
+
+// ChangeWatcher watches a change notification key controller writes into etcd for every schema or
+// assignment change of a namespace, so clients can fetch changes immediately instead of waiting for
+// the next poll. Clients are also notified whenever the watch is (re-)established, so changes missed
+// while disconnected are reconciled by fetching.
+type ChangeWatcher struct {
+	store           kv.Store
+	key             string
+	reconnectPolicy RetryPolicy
+	// notifications are coalesced as each of them triggers a full fetch.
+	notifications chan struct{}
+	done          chan struct{}
+}
+
+// NewChangeWatcher creates a ChangeWatcher on the notification key, e.g. utils.SchemaNotificationKey.
+func NewChangeWatcher(store kv.Store, key string) *ChangeWatcher {
+	return &ChangeWatcher{
+		store:           store,
+		key:             key,
+		reconnectPolicy: DefaultRetryPolicy(),
+		notifications:   make(chan struct{}, 1),
+		done:            make(chan struct{}),
+	}
+}
+
+// C returns the channel notified on changes.
+func (w *ChangeWatcher) C() <-chan struct{} {
+	return w.notifications
+}
+
+// Start starts watching in background until Close is called.
+func (w *ChangeWatcher) Start() {
+	go w.run()
+}
+
+// Close stops watching.
+func (w *ChangeWatcher) Close() {
+	close(w.done)
+}
+
+func (w *ChangeWatcher) run() {
+	for attempts := 1; ; attempts++ {
+		watch, err := w.store.Watch(w.key)
+		if err != nil {
+			utils.GetLogger().With(
+				"key", w.key,
+				"attempts", attempts,
+				"error", err.Error(),
+			).Warn("Failed to watch change notifications, retrying")
+			select {
+			case <-time.After(w.reconnectPolicy.backoff(attempts)):
+				continue
+			case <-w.done:
+				return
+			}
+		}
+
+		attempts = 0
+		// reconcile changes missed before the watch is established.
+		w.notify()
+		if !w.watch(watch) {
+			return
+		}
+		utils.GetLogger().With("key", w.key).Warn("Change notification watch closed, re-establishing")
+	}
+}
+
+// watch forwards notifications until the watch is closed, returns false if the watcher is closed.
+func (w *ChangeWatcher) watch(watch kv.ValueWatch) bool {
+	defer watch.Close()
+	for {
+		select {
+		case _, ok := <-watch.C():
+			if !ok {
+				return true
+			}
+			w.notify()
+		case <-w.done:
+			return false
+		}
+	}
+}
+
+func (w *ChangeWatcher) notify() {
+	select {
+	case w.notifications <- struct{}{}:
+	default:
+	}
+}
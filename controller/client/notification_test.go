@@ -0,0 +1,160 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/cluster/kv"
+	"github.com/m3db/m3/src/cluster/kv/mem"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pb "github.com/uber/aresdb/controller/generated/proto"
+	"github.com/uber/aresdb/utils"
+)
+
+// disconnectingStore fails watches while disconnected and allows closing established watches.
+type disconnectingStore struct {
+	kv.Store
+	sync.Mutex
+	disconnected bool
+	watches      int
+	closeCh      chan struct{}
+}
+
+func (s *disconnectingStore) Watch(key string) (kv.ValueWatch, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.disconnected {
+		return nil, errors.New("etcd unavailable")
+	}
+	watch, err := s.Store.Watch(key)
+	if err != nil {
+		return nil, err
+	}
+	s.watches++
+	s.closeCh = make(chan struct{})
+	return &disconnectingWatch{ValueWatch: watch, closeCh: s.closeCh}, nil
+}
+
+func (s *disconnectingStore) disconnect() {
+	s.Lock()
+	defer s.Unlock()
+	s.disconnected = true
+	close(s.closeCh)
+}
+
+func (s *disconnectingStore) reconnect() {
+	s.Lock()
+	defer s.Unlock()
+	s.disconnected = false
+}
+
+func (s *disconnectingStore) numWatches() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.watches
+}
+
+// disconnectingWatch closes its notification channel on disconnection.
+type disconnectingWatch struct {
+	kv.ValueWatch
+	closeCh chan struct{}
+	ch      chan struct{}
+}
+
+func (w *disconnectingWatch) C() <-chan struct{} {
+	if w.ch == nil {
+		w.ch = make(chan struct{})
+		go func() {
+			defer close(w.ch)
+			for {
+				select {
+				case <-w.ValueWatch.C():
+					w.ch <- struct{}{}
+				case <-w.closeCh:
+					return
+				}
+			}
+		}()
+	}
+	return w.ch
+}
+
+var _ = ginkgo.Describe("change watcher", func() {
+	key := utils.SchemaNotificationKey("ns1")
+	var store *disconnectingStore
+	var watcher *ChangeWatcher
+
+	expectNotification := func() {
+		Eventually(watcher.C(), time.Second).Should(Receive())
+	}
+
+	expectNoNotification := func() {
+		Consistently(watcher.C(), 50*time.Millisecond).ShouldNot(Receive())
+	}
+
+	ginkgo.BeforeEach(func() {
+		store = &disconnectingStore{Store: mem.NewStore()}
+		watcher = NewChangeWatcher(store, key)
+		watcher.reconnectPolicy = RetryPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+		}
+	})
+
+	ginkgo.AfterEach(func() {
+		watcher.Close()
+	})
+
+	ginkgo.It("should notify on changes", func() {
+		watcher.Start()
+		// reconcile once watch is established.
+		expectNotification()
+		expectNoNotification()
+
+		_, err := store.Set(key, &pb.EntityName{Name: "table1"})
+		Ω(err).Should(BeNil())
+		expectNotification()
+
+		// notifications are coalesced.
+		store.Set(key, &pb.EntityName{Name: "table2"})
+		store.Set(key, &pb.EntityName{Name: "table3"})
+		Eventually(func() int { return len(watcher.notifications) }, time.Second).Should(Equal(1))
+		expectNotification()
+		expectNoNotification()
+	})
+
+	ginkgo.It("should re-establish watch and reconcile after disconnection", func() {
+		store.disconnected = true
+		watcher.Start()
+		expectNoNotification()
+
+		store.reconnect()
+		expectNotification()
+		Ω(store.numWatches()).Should(Equal(1))
+
+		store.disconnect()
+		// changes while disconnected.
+		store.Set(key, &pb.EntityName{Name: "table1"})
+		expectNoNotification()
+
+		store.reconnect()
+		expectNotification()
+		Eventually(store.numWatches, time.Second).Should(Equal(2))
+	})
+})
@@ -0,0 +1,201 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+	defaultRetryDeadline       = 30 * time.Second
+	defaultMaxStaleness        = 30 * time.Minute
+)
+
+// RetryPolicy defines how failed controller calls are retried. Calls are retried on network errors
+// and 5xx responses with exponential backoff and jitter until the deadline.
+type RetryPolicy struct {
+	// Backoff before the first retry, doubled for each following retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Deadline of all attempts of a call, each attempt is also limited by the client timeout.
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used by controller clients by default.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Deadline:       defaultRetryDeadline,
+	}
+}
+
+// backoff returns the jittered backoff before the retry following given number of attempts.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	// equal jitter: keep half of the backoff and randomize the other half.
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// controllerResponse is a response of a controller call.
+type controllerResponse struct {
+	statusCode int
+	body       []byte
+}
+
+// isRetryableStatus tells whether the status code of a response indicates controller is unavailable.
+// 501 is returned for unsupported apis so it is not retried.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError && statusCode != http.StatusNotImplemented
+}
+
+// doWithRetry sends the request and retries on network errors and retryable status codes until the
+// deadline of the retry policy. Responses with other status codes are returned without error.
+func (c *ControllerHTTPClient) doWithRetry(request *http.Request) (response controllerResponse, err error) {
+	deadline := time.Now().Add(c.retryPolicy.Deadline)
+	for attempts := 1; ; attempts++ {
+		response, err = c.do(request)
+		if err == nil && !isRetryableStatus(response.statusCode) {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("aresDB controller return status: %d", response.statusCode)
+		}
+
+		backoff := c.retryPolicy.backoff(attempts)
+		if (request.Body != nil && request.GetBody == nil) || time.Now().Add(backoff).After(deadline) {
+			return
+		}
+
+		utils.GetLogger().With(
+			"path", request.URL.Path,
+			"attempts", attempts,
+			"error", err.Error(),
+		).Warn("Controller call failed, retrying")
+		utils.GetRootReporter().GetCounter(utils.ControllerClientRetries).Inc(1)
+		time.Sleep(backoff)
+
+		if request.GetBody != nil {
+			if request.Body, err = request.GetBody(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// do sends the request once and reads the response.
+func (c *ControllerHTTPClient) do(request *http.Request) (response controllerResponse, err error) {
+	resp, err := c.c.Do(request)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	response.statusCode = resp.StatusCode
+	response.body, err = ioutil.ReadAll(resp.Body)
+	return
+}
+
+// cachedResponse is the last successful response of an endpoint.
+type cachedResponse struct {
+	body      []byte
+	fetchedAt time.Time
+	// whether the response is being served in place of failed calls.
+	stale bool
+}
+
+// responseCache caches the last successful response per endpoint, which is served when
+// controller is unavailable until it is older than maxStaleness.
+type responseCache struct {
+	sync.Mutex
+	maxStaleness time.Duration
+	responses    map[string]*cachedResponse
+}
+
+func newResponseCache(maxStaleness time.Duration) *responseCache {
+	return &responseCache{
+		maxStaleness: maxStaleness,
+		responses:    make(map[string]*cachedResponse),
+	}
+}
+
+func (rc *responseCache) put(endpoint string, body []byte) {
+	rc.Lock()
+	rc.responses[endpoint] = &cachedResponse{
+		body:      body,
+		fetchedAt: utils.Now(),
+	}
+	rc.Unlock()
+	rc.reportStaleness()
+}
+
+// get returns the cached response of the endpoint and marks it stale, error is returned if there
+// is no cached response or the response exceeds the staleness limit.
+func (rc *responseCache) get(endpoint string) (body []byte, err error) {
+	rc.Lock()
+	response, ok := rc.responses[endpoint]
+	if !ok {
+		rc.Unlock()
+		return nil, fmt.Errorf("no cached response for %s", endpoint)
+	}
+	age := utils.Now().Sub(response.fetchedAt)
+	if age > rc.maxStaleness {
+		rc.Unlock()
+		return nil, fmt.Errorf("cached response for %s is stale for %v, exceeding limit %v", endpoint, age, rc.maxStaleness)
+	}
+	response.stale = true
+	body = response.body
+	rc.Unlock()
+
+	rc.reportStaleness()
+	return
+}
+
+// staleness returns the age of the oldest stale response being served.
+func (rc *responseCache) staleness() (staleness time.Duration) {
+	rc.Lock()
+	defer rc.Unlock()
+	now := utils.Now()
+	for _, response := range rc.responses {
+		if age := now.Sub(response.fetchedAt); response.stale && age > staleness {
+			staleness = age
+		}
+	}
+	return
+}
+
+func (rc *responseCache) reportStaleness() {
+	utils.GetRootReporter().GetGauge(utils.ControllerClientStaleness).Update(rc.staleness().Seconds())
+}
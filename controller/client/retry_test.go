@@ -0,0 +1,184 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("controller client retry", func() {
+	var testServer *httptest.Server
+	var c *ControllerHTTPClient
+	// number of following calls to fail with 503, negative means failing all calls.
+	var failures int32
+	var calls int32
+
+	flaky := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if atomic.LoadInt32(&failures) != 0 {
+				atomic.AddInt32(&failures, -1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	ginkgo.BeforeEach(func() {
+		atomic.StoreInt32(&failures, 0)
+		atomic.StoreInt32(&calls, 0)
+
+		testRouter := mux.NewRouter()
+		testRouter.HandleFunc("/schema/ns1/hash", flaky(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("123"))
+		}))
+		testRouter.HandleFunc("/schema/ns1/tables/test1/columns/col1/enum-cases", flaky(func(w http.ResponseWriter, r *http.Request) {
+			var enumCases []string
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &enumCases)
+			enumIDs := make([]int, len(enumCases))
+			for i := range enumIDs {
+				enumIDs[i] = i
+			}
+			b, _ := json.Marshal(enumIDs)
+			w.Write(b)
+		}))
+		testRouter.HandleFunc("/schema/ns1/tables/test2", flaky(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		testServer = httptest.NewServer(testRouter)
+
+		c = NewControllerHTTPClient(testServer.Listener.Addr().String(), time.Second, http.Header{})
+		c.SetNamespace("ns1")
+		c.SetRetryPolicy(RetryPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+			Deadline:       100 * time.Millisecond,
+		})
+		c.SetMaxStaleness(time.Minute)
+	})
+
+	ginkgo.AfterEach(func() {
+		testServer.Close()
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should recover from flaky controller", func() {
+		atomic.StoreInt32(&failures, 2)
+		hash, err := c.GetSchemaHash("ns1")
+		Ω(err).Should(BeNil())
+		Ω(hash).Should(Equal("123"))
+		Ω(atomic.LoadInt32(&calls)).Should(BeEquivalentTo(3))
+
+		// request body should be sent again for retries.
+		atomic.StoreInt32(&failures, 1)
+		enumIDs, err := c.ExtendEnumCases("test1", "col1", []string{"a", "b"})
+		Ω(err).Should(BeNil())
+		Ω(enumIDs).Should(Equal([]int{0, 1}))
+	})
+
+	ginkgo.It("should not retry client errors", func() {
+		_, err := c.FetchSchema("test2")
+		Ω(err).ShouldNot(BeNil())
+		Ω(atomic.LoadInt32(&calls)).Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("should give up after deadline", func() {
+		atomic.StoreInt32(&failures, -1)
+		start := time.Now()
+		_, err := c.GetSchemaHash("ns1")
+		Ω(err).ShouldNot(BeNil())
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+		Ω(atomic.LoadInt32(&calls)).Should(BeNumerically(">", 1))
+
+		_, err = c.ExtendEnumCases("test1", "col1", []string{"a"})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("should serve cached responses during outage until staleness limit", func() {
+		utils.SetCurrentTime(time.Unix(100, 0))
+		_, err := c.GetSchemaHash("ns1")
+		Ω(err).Should(BeNil())
+		Ω(c.Staleness()).Should(BeZero())
+
+		// controller outage.
+		atomic.StoreInt32(&failures, -1)
+		utils.SetCurrentTime(time.Unix(130, 0))
+		hash, err := c.GetSchemaHash("ns1")
+		Ω(err).Should(BeNil())
+		Ω(hash).Should(Equal("123"))
+		Ω(c.Staleness()).Should(Equal(30 * time.Second))
+
+		// non GET calls are not served from cache.
+		_, err = c.ExtendEnumCases("test1", "col1", []string{"a"})
+		Ω(err).ShouldNot(BeNil())
+
+		// exceeding staleness limit.
+		utils.SetCurrentTime(time.Unix(161, 0))
+		_, err = c.GetSchemaHash("ns1")
+		Ω(err).ShouldNot(BeNil())
+
+		// controller recovered.
+		atomic.StoreInt32(&failures, 0)
+		_, err = c.GetSchemaHash("ns1")
+		Ω(err).Should(BeNil())
+		Ω(c.Staleness()).Should(BeZero())
+	})
+
+	ginkgo.It("should serve cached responses when controller is unreachable", func() {
+		_, err := c.GetSchemaHash("ns1")
+		Ω(err).Should(BeNil())
+		testServer.Close()
+
+		hash, err := c.GetSchemaHash("ns1")
+		Ω(err).Should(BeNil())
+		Ω(hash).Should(Equal("123"))
+	})
+
+	ginkgo.It("backoff should grow exponentially with jitter", func() {
+		policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+		for attempts, expected := range []time.Duration{100, 100, 200, 400, 800, 1000, 1000} {
+			expected *= time.Millisecond
+			backoff := policy.backoff(attempts)
+			Ω(backoff).Should(BeNumerically(">=", expected/2))
+			Ω(backoff).Should(BeNumerically("<=", expected))
+		}
+	})
+
+	ginkgo.It("NewControllerHTTPClientFromConfig should work", func() {
+		cfg := common.ControllerConfig{Address: "localhost:6708", TimeoutSec: 1}
+		c = NewControllerHTTPClientFromConfig(cfg)
+		Ω(c.retryPolicy).Should(Equal(DefaultRetryPolicy()))
+		Ω(c.cache.maxStaleness).Should(Equal(defaultMaxStaleness))
+
+		cfg.RetryDeadlineSec = 5
+		cfg.MaxStalenessSec = 60
+		c = NewControllerHTTPClientFromConfig(cfg)
+		Ω(c.retryPolicy.Deadline).Should(Equal(5 * time.Second))
+		Ω(c.cache.maxStaleness).Should(Equal(time.Minute))
+	})
+})
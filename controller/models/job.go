@@ -49,6 +49,23 @@ type KafkaConfig struct {
 	MaxPollIntervalMs int    `json:"maxPollIntervalMs" yaml:"maxPollIntervalMs" default:"300000"`
 	SessionTimeoutNs  int    `json:"sessionTimeoutNs" yaml:"sessionTimeoutNs" default:"10000"`
 	ChannelBufferSize uint   `json:"channelBufferSize" yaml:"channelBufferSize" default:"256"`
+
+	// failover to the Kafka cluster the topic is mirrored to
+	SecondaryKafkaBroker string          `json:"secondaryKafkaBroker,omitempty" yaml:"secondaryKafkaBroker"`
+	Failover             *FailoverConfig `json:"failover,omitempty" yaml:"failover"`
+}
+
+// FailoverConfig is config of failover between the primary and secondary Kafka clusters
+type FailoverConfig struct {
+	// HealthCheckIntervalSec is the interval of Kafka cluster health checks
+	HealthCheckIntervalSec int `json:"healthCheckIntervalSec,omitempty" yaml:"healthCheckIntervalSec"`
+	// FailureThreshold is the number of consecutive failed health checks before switching clusters,
+	// also the number of consecutive successful checks of the primary before switching back
+	FailureThreshold int `json:"failureThreshold,omitempty" yaml:"failureThreshold"`
+	// OverlapWindowSec is how long before the switch the new cluster starts consuming from, to avoid gaps
+	OverlapWindowSec int `json:"overlapWindowSec,omitempty" yaml:"overlapWindowSec"`
+	// AutoSwitchback switches back to the primary cluster once it is healthy again
+	AutoSwitchback bool `json:"autoSwitchback,omitempty" yaml:"autoSwitchback"`
 }
 
 // JobConfig is job's config
@@ -58,6 +75,28 @@ type JobConfig struct {
 	NumShards       int         `json:"numShards,omitempty"`
 	AresTableConfig TableConfig `json:"aresTableConfig"`
 	StreamingConfig KafkaConfig `json:"streamConfig"`
+	// Transformations are applied in order to decoded messages before rows are built
+	Transformations []TransformationStep `json:"transformations,omitempty"`
+}
+
+// TransformationStep is a step of the transformation pipeline of a job
+type TransformationStep struct {
+	// Name identifies the step in errors, defaults to the type and position of the step
+	Name string `json:"name,omitempty"`
+	// Type is one of rename, default, cast, epochMillisToSeconds, concat and dropIf
+	Type string `json:"type"`
+	// Field is the field the step writes, or the field dropIf checks
+	Field string `json:"field"`
+	// From is the field renamed from
+	From string `json:"from,omitempty"`
+	// Value is the constant set by default, or the value compared by dropIf
+	Value string `json:"value,omitempty"`
+	// To is the type cast to, one of string, int, float and bool
+	To string `json:"to,omitempty"`
+	// Template is the concat template referencing fields as {field}
+	Template string `json:"template,omitempty"`
+	// Operator is the predicate of dropIf, one of eq, ne, exists and missing
+	Operator string `json:"operator,omitempty"`
 }
 
 // FailureHandler is kafka's failure handler
@@ -0,0 +1,45 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package models
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+// SchemaDeltaRequest describes the schema a client has for fetching schema delta from controller
+type SchemaDeltaRequest struct {
+	// Hash of the namespace schema the client synced last time
+	Hash string `json:"hash"`
+	// TableHashes maps table names to hashes of the table schemas the client has
+	TableHashes map[string]string `json:"tableHashes"`
+}
+
+// SchemaDelta is the schema difference between controller and the client
+type SchemaDelta struct {
+	// Hash of the namespace schema after applying the delta
+	Hash string `json:"hash"`
+	// UpdatedTables are tables missing or with different hashes in the request
+	UpdatedTables []metaCom.Table `json:"updatedTables"`
+	// DeletedTables are tables in the request but no longer exist
+	DeletedTables []string `json:"deletedTables"`
+}
+
+// TableSchemaHash returns the hash of a table schema in json used in SchemaDeltaRequest
+func TableSchemaHash(tableJSON []byte) string {
+	sum := md5.Sum(tableJSON)
+	return hex.EncodeToString(sum[:])
+}
@@ -69,6 +69,8 @@ type IngestionAssignmentMutator interface {
 type EnumMutator interface {
 	// ExtendEnumCases try to extend new enum cases to given column
 	ExtendEnumCases(namespace, table, column string, enumCases []string) ([]int, error)
+	// ExtendEnumCasesBulk extends new enum cases to multiple columns of the table at once
+	ExtendEnumCasesBulk(namespace, table string, enumCases map[string][]string) (map[string][]int, error)
 	// GetEnumCases get all enum cases for the given table column
 	GetEnumCases(namespace, table, column string) ([]string, error)
 }
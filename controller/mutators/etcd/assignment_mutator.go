@@ -91,10 +91,10 @@ func (j *ingestionAssignmentMutatorImpl) DeleteIngestionAssignment(namespace, na
 	}
 
 	entityConfig.Tomstoned = true
-	return kvstore.NewTransaction().
+	txn := kvstore.NewTransaction().
 		AddKeyValue(utils.JobAssignmentsListKey(namespace), entityListVersion, &entityList).
-		AddKeyValue(utils.JobAssignmentsKey(namespace, name), configVersion, &entityConfig).
-		WriteTo(j.etcdStore)
+		AddKeyValue(utils.JobAssignmentsKey(namespace, name), configVersion, &entityConfig)
+	return notifyChange(txn, utils.AssignmentNotificationKey(namespace), name).WriteTo(j.etcdStore)
 }
 
 // UpdateIngestionAssignment updates IngestionAssignment config
@@ -120,10 +120,10 @@ func (j *ingestionAssignmentMutatorImpl) UpdateIngestionAssignment(namespace str
 		return err
 	}
 
-	return kvstore.NewTransaction().
+	txn := kvstore.NewTransaction().
 		AddKeyValue(utils.JobAssignmentsListKey(namespace), entityListVersion, &entityList).
-		AddKeyValue(utils.JobAssignmentsKey(namespace, ingestionAssignment.Subscriber), configVersion, &entityConfig).
-		WriteTo(j.etcdStore)
+		AddKeyValue(utils.JobAssignmentsKey(namespace, ingestionAssignment.Subscriber), configVersion, &entityConfig)
+	return notifyChange(txn, utils.AssignmentNotificationKey(namespace), ingestionAssignment.Subscriber).WriteTo(j.etcdStore)
 }
 
 // AddIngestionAssignment adds a new IngestionAssignment
@@ -155,10 +155,10 @@ func (j *ingestionAssignmentMutatorImpl) AddIngestionAssignment(namespace string
 		return err
 	}
 
-	return kvstore.NewTransaction().
+	txn := kvstore.NewTransaction().
 		AddKeyValue(utils.JobAssignmentsListKey(namespace), entityListVersion, &entityList).
-		AddKeyValue(utils.JobAssignmentsKey(namespace, ingestionAssignment.Subscriber), configVersion, &entityConfig).
-		WriteTo(j.etcdStore)
+		AddKeyValue(utils.JobAssignmentsKey(namespace, ingestionAssignment.Subscriber), configVersion, &entityConfig)
+	return notifyChange(txn, utils.AssignmentNotificationKey(namespace), ingestionAssignment.Subscriber).WriteTo(j.etcdStore)
 }
 
 // GetHash returns hash that will be different if ingestionAssignment for subscriber changed
@@ -93,6 +93,13 @@ func TestIngestionAssignmentMutator(t *testing.T) {
 		err = ingestionAssignmentMutator.UpdateIngestionAssignment("ns1", testAssignment2)
 		assert.NoError(t, err)
 
+		// every change is notified.
+		var notification pb.EntityName
+		version, err := readValue(etcdStore, utils.AssignmentNotificationKey("ns1"), &notification)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+		assert.Equal(t, "sub1", notification.Name)
+
 		IngestionAssignment2, err := ingestionAssignmentMutator.GetIngestionAssignment("ns1", "sub1")
 		assert.NoError(t, err)
 		assert.Equal(t, testAssignment2, IngestionAssignment2)
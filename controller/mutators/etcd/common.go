@@ -15,6 +15,7 @@ package etcd
 
 import (
 	"fmt"
+	"github.com/uber/aresdb/cluster/kvstore"
 	"github.com/uber/aresdb/controller/mutators/common"
 
 	"github.com/golang/protobuf/proto"
@@ -50,6 +51,34 @@ func addEntity(entityList pb.EntityList, name string) (res pb.EntityList, incarn
 	return entityList, incarnation, false
 }
 
+// notifyChange sets the notification key in the transaction with name of the changed entity, clients
+// watching the key fetch changes immediately.
+func notifyChange(txn *kvstore.Transaction, notificationKey, name string) *kvstore.Transaction {
+	return txn.SetKeyValue(notificationKey, &pb.EntityName{
+		Name:          name,
+		LastUpdatedAt: utils.Now().UnixNano(),
+	})
+}
+
+// maxTxnAttempts is the max number of attempts of a read-modify-write transaction retried
+// when keys read were changed concurrently.
+const maxTxnAttempts = 5
+
+// retryOnConflict runs the read-modify-write change until its transaction does not fail with
+// kv.ErrConditionCheckFailed or max attempts are reached.
+func retryOnConflict(change func() error) (err error) {
+	for attempts := 1; ; attempts++ {
+		if err = change(); err != kv.ErrConditionCheckFailed {
+			return
+		}
+		utils.GetRootReporter().GetCounter(utils.SchemaVersionConflicts).Inc(1)
+		if attempts >= maxTxnAttempts {
+			return
+		}
+		utils.GetLogger().With("attempts", attempts).Warn("Transaction conflict, retrying on the latest values")
+	}
+}
+
 func readValue(etcdStore kv.TxnStore, key string, out proto.Message) (version int, err error) {
 	var value kv.Value
 	value, err = etcdStore.Get(key)
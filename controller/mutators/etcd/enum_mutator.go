@@ -24,6 +24,7 @@ import (
 
 	"github.com/m3db/m3/src/cluster/kv"
 	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 )
 
@@ -59,23 +60,57 @@ func (e *enumMutator) ExtendEnumCases(namespace, tableName, columnName string, e
 	if err != nil {
 		return nil, err
 	}
-	columnID := -1
+	columnID := getEnumColumnID(schema, columnName)
+	if columnID < 0 {
+		return nil, metastore.ErrColumnDoesNotExist
+	}
+	return e.extendEnumCases(namespace, schema, columnID, enumCases)
+}
+
+// ExtendEnumCasesBulk extends enum cases of multiple columns of the table, columns are all validated
+// before any of them is extended. Enum cases of each column are resolved atomically, and converge on
+// the same enum IDs with other controllers resolving them concurrently.
+func (e *enumMutator) ExtendEnumCasesBulk(namespace, tableName string, enumCases map[string][]string) (map[string][]int, error) {
+	schema, err := e.schemaMutator.GetTable(namespace, tableName)
+	if err != nil {
+		return nil, err
+	}
+	columnIDs := make(map[string]int, len(enumCases))
+	for columnName := range enumCases {
+		if columnIDs[columnName] = getEnumColumnID(schema, columnName); columnIDs[columnName] < 0 {
+			return nil, metastore.ErrColumnDoesNotExist
+		}
+	}
+
+	enumIDs := make(map[string][]int, len(enumCases))
+	for columnName, columnEnumCases := range enumCases {
+		if enumIDs[columnName], err = e.extendEnumCases(namespace, schema, columnIDs[columnName], columnEnumCases); err != nil {
+			return nil, err
+		}
+	}
+	return enumIDs, nil
+}
+
+// getEnumColumnID returns the ID of the enum column, or -1 if not found.
+func getEnumColumnID(schema *metaCom.Table, columnName string) int {
 	for id, column := range schema.Columns {
 		if column.Name == columnName && !column.Deleted && column.IsEnumColumn() {
-			columnID = id
-			break
+			return id
 		}
 	}
-	if columnID < 0 {
-		return nil, metastore.ErrColumnDoesNotExist
-	}
+	return -1
+}
+
+func (e *enumMutator) extendEnumCases(namespace string, schema *metaCom.Table, columnID int, enumCases []string) ([]int, error) {
+	tableName := schema.Name
+	column := &schema.Columns[columnID]
 
 	e.RLock()
 	enumCache, exist := e.enumCacheMap[getCacheKey(namespace, tableName, schema.Incarnation, columnID)]
 	if !exist {
 		e.RUnlock()
 		// fetch enum case from etcd and update cache
-		return e.extendEnumCase(namespace, tableName, schema.Incarnation, columnID, 0, enumCases)
+		return e.extendEnumCase(namespace, tableName, schema.Incarnation, columnID, column, 0, enumCases)
 	}
 
 	currentNodeID := enumCache.currentNodeID
@@ -95,7 +130,7 @@ func (e *enumMutator) ExtendEnumCases(namespace, tableName, columnName string, e
 
 	if len(newEnumCases) > 0 {
 		// fetch enum cases from etcd and update cache
-		missingIDs, err := e.extendEnumCase(namespace, tableName, schema.Incarnation, columnID, currentNodeID, newEnumCases)
+		missingIDs, err := e.extendEnumCase(namespace, tableName, schema.Incarnation, columnID, column, currentNodeID, newEnumCases)
 		if err != nil {
 			return nil, err
 		}
@@ -120,7 +155,16 @@ func getEnumID(nodeID int, innerID int) int {
 	return maxEnumCasePerNode*nodeID + innerID
 }
 
-func (e *enumMutator) extendEnumCase(namespace, tableName string, incarnation, columnID int, fromEnumNodeID int, newEnumCases []string) ([]int, error) {
+func (e *enumMutator) extendEnumCase(namespace, tableName string, incarnation, columnID int, column *metaCom.Column, fromEnumNodeID int, newEnumCases []string) (enumIDs []int, err error) {
+	// enum cases appended concurrently by other writers are picked up by retries.
+	err = retryOnConflict(func() error {
+		enumIDs, err = e.tryExtendEnumCase(namespace, tableName, incarnation, columnID, column, fromEnumNodeID, newEnumCases)
+		return err
+	})
+	return
+}
+
+func (e *enumMutator) tryExtendEnumCase(namespace, tableName string, incarnation, columnID int, column *metaCom.Column, fromEnumNodeID int, newEnumCases []string) ([]int, error) {
 	// track result resolvedEnumIDs
 	resolvedEnumIDs := make([]int, len(newEnumCases))
 	// newEnumCaseDict records the resolved resolvedEnumIDs for newEnumCases
@@ -176,27 +220,54 @@ func (e *enumMutator) extendEnumCase(namespace, tableName string, incarnation, c
 		updated = false
 	)
 
+	// enum cases before fromEnumNode are only in cache, including possibly the overflow case.
+	overflowEnumID, hasOverflowCase := newEnumCaseDict[metaCom.EnumOverflowCase]
+	if !hasOverflowCase {
+		overflowEnumID, hasOverflowCase = e.getCachedEnumID(getCacheKey(namespace, tableName, incarnation, columnID), metaCom.EnumOverflowCase)
+	}
+	numOverflowed := 0
+
 	for index, newCase := range newEnumCases {
 		if enumID, exist := newEnumCaseDict[newCase]; exist {
 			resolvedEnumIDs[index] = enumID
-		} else {
-			updated = true
-			// once last node is full, append the last finished node
-			if len(lastEnumNode.Cases) >= maxEnumCasePerNode {
-				// create new node
-				lastEnumNode = &proto.EnumCases{
-					Cases: make([]string, 0, maxEnumCasePerNode),
-				}
-				// advance lastEnumNodeID
-				lastEnumNodeID++
-				// create last node transaction
-				txn.AddKeyValue(utils.EnumNodeKey(namespace, tableName, incarnation, columnID, lastEnumNodeID), kv.UninitializedVersion, lastEnumNode)
-				enumNodeList.NumEnumNodes++
+			continue
+		}
+
+		numEnumCases := maxEnumCasePerNode*lastEnumNodeID + len(lastEnumNode.Cases)
+		if column.IsEnumCardinalityCapReached(numEnumCases, hasOverflowCase) {
+			numOverflowed++
+			if hasOverflowCase {
+				resolvedEnumIDs[index] = overflowEnumID
+				continue
 			}
-			enumID := getEnumID(lastEnumNodeID, len(lastEnumNode.Cases))
-			lastEnumNode.Cases = append(lastEnumNode.Cases, newCase)
-			resolvedEnumIDs[index] = enumID
-			newEnumCaseDict[newCase] = enumID
+			utils.GetLogger().With(
+				"namespace", namespace,
+				"table", tableName,
+				"column", column.Name,
+				"enumCardinalityCap", column.Config.EnumCardinalityCap,
+			).Error("Enum cardinality cap reached, new enum cases are mapped to the overflow case")
+			newCase = metaCom.EnumOverflowCase
+		}
+
+		updated = true
+		// once last node is full, append the last finished node
+		if len(lastEnumNode.Cases) >= maxEnumCasePerNode {
+			// create new node
+			lastEnumNode = &proto.EnumCases{
+				Cases: make([]string, 0, maxEnumCasePerNode),
+			}
+			// advance lastEnumNodeID
+			lastEnumNodeID++
+			// create last node transaction
+			txn.AddKeyValue(utils.EnumNodeKey(namespace, tableName, incarnation, columnID, lastEnumNodeID), kv.UninitializedVersion, lastEnumNode)
+			enumNodeList.NumEnumNodes++
+		}
+		enumID := getEnumID(lastEnumNodeID, len(lastEnumNode.Cases))
+		lastEnumNode.Cases = append(lastEnumNode.Cases, newCase)
+		resolvedEnumIDs[index] = enumID
+		newEnumCaseDict[newCase] = enumID
+		if newCase == metaCom.EnumOverflowCase {
+			overflowEnumID, hasOverflowCase = enumID, true
 		}
 	}
 
@@ -207,6 +278,13 @@ func (e *enumMutator) extendEnumCase(namespace, tableName string, incarnation, c
 			return nil, err
 		}
 	}
+
+	if numOverflowed > 0 {
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"table":      tableName,
+			"columnName": column.Name,
+		}, utils.EnumCasesOverflowed).Inc(int64(numOverflowed))
+	}
 	e.updateCache(getCacheKey(namespace, tableName, incarnation, columnID), lastEnumNodeID, newEnumCaseDict)
 	return resolvedEnumIDs, nil
 }
@@ -224,6 +302,13 @@ func (e *enumMutator) fetchEnumCases(key string) ([]string, int, error) {
 	return enumCases.Cases, v.Version(), nil
 }
 
+func (e *enumMutator) getCachedEnumID(cacheKey string, enumCase string) (int, bool) {
+	e.RLock()
+	defer e.RUnlock()
+	enumID, exist := e.enumCacheMap[cacheKey].enumCases[enumCase]
+	return enumID, exist
+}
+
 func (e *enumMutator) updateCache(cacheKey string, nodeID int, dict map[string]int) {
 	e.Lock()
 	cache, ok := e.enumCacheMap[cacheKey]
@@ -14,6 +14,7 @@
 package etcd
 
 import (
+	"github.com/m3db/m3/src/cluster/kv"
 	"github.com/m3db/m3/src/cluster/kv/mem"
 	"strconv"
 	"testing"
@@ -21,6 +22,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	pb "github.com/uber/aresdb/controller/generated/proto"
 	"github.com/uber/aresdb/controller/mutators/mocks"
+	"github.com/uber/aresdb/metastore"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 )
@@ -92,4 +94,140 @@ func TestEnumMutator(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Extend enum cases with enum cardinality cap", func(t *testing.T) {
+		// test setup
+		txnStore := mem.NewStore()
+
+		_, err := txnStore.Set(utils.EnumNodeListKey("ns1", "test", 0, 0), &pb.EnumNodeList{
+			NumEnumNodes: 1,
+		})
+		assert.NoError(t, err)
+		_, err = txnStore.Set(utils.EnumNodeKey("ns1", "test", 0, 0, 0), &pb.EnumCases{
+			Cases: []string{},
+		})
+		assert.NoError(t, err)
+
+		cappedTable := testTable
+		cappedTable.Columns = []metaCom.Column{testTable.Columns[0]}
+		cappedTable.Columns[0].Config.EnumCardinalityCap = 4
+		schemaMutator := &mocks.TableSchemaMutator{}
+		schemaMutator.On("GetTable", "ns1", "test").Return(&cappedTable, nil).Once()
+
+		// test
+		enumMutator := NewEnumMutator(txnStore, schemaMutator)
+		// one slot of the cap is kept for the overflow case.
+		enumIDs, err := enumMutator.ExtendEnumCases("ns1", "test", "c1", []string{"a", "b", "c", "d", "a", "e"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{0, 1, 2, 3, 0, 3}, enumIDs)
+
+		// cap raised.
+		raisedTable := cappedTable
+		raisedTable.Columns = []metaCom.Column{cappedTable.Columns[0]}
+		raisedTable.Columns[0].Config.EnumCardinalityCap = 6
+		schemaMutator.On("GetTable", "ns1", "test").Return(&raisedTable, nil)
+		enumIDs, err = enumMutator.ExtendEnumCases("ns1", "test", "c1", []string{"e", "f", "g", "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{4, 5, 3, 0}, enumIDs)
+
+		// enum cases are resolved the same without cache.
+		enumIDs, err = NewEnumMutator(txnStore, schemaMutator).ExtendEnumCases("ns1", "test", "c1", []string{"h", "f"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{3, 5}, enumIDs)
+
+		enumCases, err := enumMutator.GetEnumCases("ns1", "test", "c1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c", metaCom.EnumOverflowCase, "e", "f"}, enumCases)
+	})
+
+	t.Run("Extend enum cases should retry on concurrent appends", func(t *testing.T) {
+		memStore := mem.NewStore()
+		_, err := memStore.Set(utils.EnumNodeListKey("ns1", "test", 0, 0), &pb.EnumNodeList{
+			NumEnumNodes: 1,
+		})
+		assert.NoError(t, err)
+		_, err = memStore.Set(utils.EnumNodeKey("ns1", "test", 0, 0, 0), &pb.EnumCases{
+			Cases: []string{},
+		})
+		assert.NoError(t, err)
+
+		schemaMutator := &mocks.TableSchemaMutator{}
+		schemaMutator.On("GetTable", "ns1", "test").Return(&testTable, nil)
+		otherEnumMutator := NewEnumMutator(memStore, schemaMutator)
+
+		// another writer appends enum cases right before the first commit.
+		txnStore := &beforeCommitTxnStore{TxnStore: memStore, beforeCommit: func() {
+			enumIDs, err := otherEnumMutator.ExtendEnumCases("ns1", "test", "c1", []string{"a", "b"})
+			assert.NoError(t, err)
+			assert.Equal(t, []int{0, 1}, enumIDs)
+		}}
+		enumMutator := NewEnumMutator(txnStore, schemaMutator)
+		enumIDs, err := enumMutator.ExtendEnumCases("ns1", "test", "c1", []string{"c", "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2, 0}, enumIDs)
+
+		enumCases, err := enumMutator.GetEnumCases("ns1", "test", "c1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, enumCases)
+	})
+
+	t.Run("Extend enum cases in bulk", func(t *testing.T) {
+		txnStore := mem.NewStore()
+		for columnID := 0; columnID < 2; columnID++ {
+			_, err := txnStore.Set(utils.EnumNodeListKey("ns1", "test", 0, columnID), &pb.EnumNodeList{
+				NumEnumNodes: 1,
+			})
+			assert.NoError(t, err)
+			_, err = txnStore.Set(utils.EnumNodeKey("ns1", "test", 0, columnID, 0), &pb.EnumCases{
+				Cases: []string{},
+			})
+			assert.NoError(t, err)
+		}
+
+		bulkTable := testTable
+		bulkTable.Columns = []metaCom.Column{testTable.Columns[0], {Name: "c2", Type: metaCom.SmallEnum}, {Name: "c3", Type: metaCom.Int32}}
+		schemaMutator := &mocks.TableSchemaMutator{}
+		schemaMutator.On("GetTable", "ns1", "test").Return(&bulkTable, nil)
+
+		enumMutator := NewEnumMutator(txnStore, schemaMutator)
+		enumIDs, err := enumMutator.ExtendEnumCasesBulk("ns1", "test", map[string][]string{
+			"c1": {"a", "b"},
+			"c2": {"x"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string][]int{"c1": {0, 1}, "c2": {0}}, enumIDs)
+
+		// other controllers resolve the same enum IDs.
+		enumIDs, err = NewEnumMutator(txnStore, schemaMutator).ExtendEnumCasesBulk("ns1", "test", map[string][]string{
+			"c1": {"b", "c"},
+			"c2": {"y", "x"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string][]int{"c1": {1, 2}, "c2": {1, 0}}, enumIDs)
+
+		// no enum cases are added if any column is not an enum column.
+		_, err = enumMutator.ExtendEnumCasesBulk("ns1", "test", map[string][]string{
+			"c1": {"d"},
+			"c3": {"z"},
+		})
+		assert.Equal(t, metastore.ErrColumnDoesNotExist, err)
+		enumCases, err := enumMutator.GetEnumCases("ns1", "test", "c1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, enumCases)
+	})
+}
+
+// beforeCommitTxnStore runs beforeCommit once before the first commit.
+type beforeCommitTxnStore struct {
+	kv.TxnStore
+	beforeCommit func()
+}
+
+func (s *beforeCommitTxnStore) Commit(conditions []kv.Condition, ops []kv.Op) (kv.Response, error) {
+	if s.beforeCommit != nil {
+		beforeCommit := s.beforeCommit
+		s.beforeCommit = nil
+		beforeCommit()
+	}
+	return s.TxnStore.Commit(conditions, ops)
 }
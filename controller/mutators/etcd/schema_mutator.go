@@ -86,14 +86,20 @@ func (m *tableSchemaMutator) GetTable(namespace, name string) (table *metaCom.Ta
 	return table, err
 }
 
-func (m *tableSchemaMutator) CreateTable(namespace string, table *metaCom.Table, force bool) (err error) {
-	if !force {
-		validator := metastore.NewTableSchameValidator()
-		validator.SetNewTable(*table)
-		err = validator.Validate()
-		if err != nil {
-			return
-		}
+func (m *tableSchemaMutator) CreateTable(namespace string, table *metaCom.Table, force bool) error {
+	return schemaChangeError(retryOnConflict(func() error {
+		return m.createTable(namespace, table, force)
+	}))
+}
+
+func (m *tableSchemaMutator) createTable(namespace string, table *metaCom.Table, force bool) (err error) {
+	// force only overrides warnings from schema change rules.
+	validator := metastore.NewTableSchameValidator()
+	validator.SetNewTable(*table)
+	validator.SetForce(force)
+	err = validator.Validate()
+	if err != nil {
+		return
 	}
 
 	tableListProto, tableListVersion, err := readEntityList(m.txnStore, utils.SchemaListKey(namespace))
@@ -133,11 +139,17 @@ func (m *tableSchemaMutator) CreateTable(namespace string, table *metaCom.Table,
 
 	preCreateEnumNodes(txn, namespace, table, 0, len(table.Columns))
 
-	err = txn.WriteTo(m.txnStore)
+	err = notifyChange(txn, utils.SchemaNotificationKey(namespace), table.Name).WriteTo(m.txnStore)
 	return
 }
 
 func (m *tableSchemaMutator) DeleteTable(namespace, name string) error {
+	return schemaChangeError(retryOnConflict(func() error {
+		return m.deleteTable(namespace, name)
+	}))
+}
+
+func (m *tableSchemaMutator) deleteTable(namespace, name string) error {
 	tableListProto, tableListVersion, err := readEntityList(m.txnStore, utils.SchemaListKey(namespace))
 	if err != nil {
 		return err
@@ -165,10 +177,10 @@ func (m *tableSchemaMutator) DeleteTable(namespace, name string) error {
 		return err
 	}
 
-	err = kvstore.NewTransaction().
+	txn := kvstore.NewTransaction().
 		AddKeyValue(utils.SchemaListKey(namespace), tableListVersion, &tableListProto).
-		AddKeyValue(utils.SchemaKey(namespace, name), schemaVersion, &schemaProto).
-		WriteTo(m.txnStore)
+		AddKeyValue(utils.SchemaKey(namespace, name), schemaVersion, &schemaProto)
+	err = notifyChange(txn, utils.SchemaNotificationKey(namespace), name).WriteTo(m.txnStore)
 	if err != nil {
 		return err
 	}
@@ -207,7 +219,15 @@ func (m *tableSchemaMutator) deleteEnum(namespace string, table *metaCom.Table)
 	}
 }
 
-func (m *tableSchemaMutator) UpdateTable(namespace string, table metaCom.Table, force bool) (err error) {
+// UpdateTable updates the table, a non zero table.Version is the version the update is based on and
+// the update fails with metastore.ErrSchemaVersionConflict if the table was changed since then.
+func (m *tableSchemaMutator) UpdateTable(namespace string, table metaCom.Table, force bool) error {
+	return schemaChangeError(retryOnConflict(func() error {
+		return m.updateTable(namespace, table, force)
+	}))
+}
+
+func (m *tableSchemaMutator) updateTable(namespace string, table metaCom.Table, force bool) (err error) {
 	tableListProto, tableListVersion, err := readEntityList(m.txnStore, utils.SchemaListKey(namespace))
 	if err != nil {
 		return err
@@ -218,7 +238,7 @@ func (m *tableSchemaMutator) UpdateTable(namespace string, table metaCom.Table,
 		m.logger.With(
 			"table", table,
 		).Info("table not found for update, creating new table")
-		return m.CreateTable(namespace, &table, force)
+		return m.createTable(namespace, &table, force)
 	}
 
 	schemaProto, schemaVersion, err := m.readSchema(namespace, table.Name)
@@ -235,8 +255,14 @@ func (m *tableSchemaMutator) UpdateTable(namespace string, table metaCom.Table,
 		return
 	}
 
+	if table.Version != 0 && table.Version != oldTable.Version {
+		utils.GetRootReporter().GetCounter(utils.SchemaVersionConflicts).Inc(1)
+		return metastore.ErrSchemaVersionConflict
+	}
+
 	// always use old table's incarnation for update table operation will not modify incarnation
 	table.Incarnation = oldTable.Incarnation
+	table.Version = oldTable.Version + 1
 
 	// merge existing table and column level configs if not specified in the input
 	if (metaCom.TableConfig{}) == table.Config {
@@ -253,14 +279,14 @@ func (m *tableSchemaMutator) UpdateTable(namespace string, table metaCom.Table,
 		table.ArchivingSortColumns = oldTable.ArchivingSortColumns
 	}
 
-	if !force {
-		validator := metastore.NewTableSchameValidator()
-		validator.SetNewTable(table)
-		validator.SetOldTable(oldTable)
-		err = validator.Validate()
-		if err != nil {
-			return
-		}
+	// force only overrides warnings from schema change rules.
+	validator := metastore.NewTableSchameValidator()
+	validator.SetNewTable(table)
+	validator.SetOldTable(oldTable)
+	validator.SetForce(force)
+	err = validator.Validate()
+	if err != nil {
+		return
 	}
 
 	schemaProto.Tomstoned = false
@@ -275,7 +301,7 @@ func (m *tableSchemaMutator) UpdateTable(namespace string, table metaCom.Table,
 
 	// for new columns, pre-create enum nodes
 	preCreateEnumNodes(txn, namespace, &table, len(oldTable.Columns), len(table.Columns))
-	return txn.WriteTo(m.txnStore)
+	return notifyChange(txn, utils.SchemaNotificationKey(namespace), table.Name).WriteTo(m.txnStore)
 }
 
 func preCreateEnumNodes(txn *kvstore.Transaction, namespace string, table *metaCom.Table, startColumnID int, endColumnID int) {
@@ -293,6 +319,14 @@ func (m *tableSchemaMutator) GetHash(namespace string) (hash string, err error)
 	return getHash(m.txnStore, utils.SchemaListKey(namespace))
 }
 
+// schemaChangeError translates transaction conflicts remaining after retries to metastore.ErrSchemaVersionConflict.
+func schemaChangeError(err error) error {
+	if err == kv.ErrConditionCheckFailed {
+		return metastore.ErrSchemaVersionConflict
+	}
+	return err
+}
+
 func (m *tableSchemaMutator) readSchema(namespace string, name string) (schemaProto pb.EntityConfig, version int, err error) {
 	version, err = readValue(m.txnStore, utils.SchemaKey(namespace, name), &schemaProto)
 	if common.IsNonExist(err) {
@@ -14,6 +14,7 @@
 package etcd
 
 import (
+	"github.com/m3db/m3/src/cluster/kv"
 	"github.com/m3db/m3/src/cluster/kv/mem"
 	"testing"
 
@@ -127,6 +128,8 @@ func TestSchemaMutator(t *testing.T) {
 		expectedTable2.Config = defaultConfig
 		// default should not overwrite explicit config
 		expectedTable2.Config.BatchSize = 100
+		// schema version is bumped by every update
+		expectedTable2.Version = testTable.Version + 1
 		assert.Equal(t, expectedTable2, *table2)
 
 		err = schemaMutator.DeleteTable("ns1", "test1")
@@ -135,6 +138,13 @@ func TestSchemaMutator(t *testing.T) {
 		tbs, err = schemaMutator.ListTables("ns1")
 		assert.NoError(t, err)
 		assert.Empty(t, tbs)
+
+		// every change is notified.
+		var notification pb.EntityName
+		version, err := readValue(store, utils.SchemaNotificationKey("ns1"), &notification)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, version)
+		assert.Equal(t, "test1", notification.Name)
 	})
 
 	t.Run("reuse table should success", func(t *testing.T) {
@@ -234,4 +244,67 @@ func TestSchemaMutator(t *testing.T) {
 		err = schemaMutator.DeleteTable("ns2", "non-exist-table")
 		assert.EqualError(t, err, "Table does not exist")
 	})
+
+	t.Run("update should fail on version conflicts", func(t *testing.T) {
+		txnStore := mem.NewStore()
+		_, err := txnStore.Set(utils.SchemaListKey("ns1"), &pb.EntityList{})
+		assert.NoError(t, err)
+
+		schemaMutator := tableSchemaMutator{
+			txnStore: txnStore,
+			logger:   zap.NewExample().Sugar(),
+		}
+		err = schemaMutator.CreateTable("ns1", &testTable, false)
+		assert.NoError(t, err)
+
+		staleTable := testTable2
+		staleTable.Version = testTable.Version + 1
+		err = schemaMutator.UpdateTable("ns1", staleTable, false)
+		assert.Equal(t, metastore.ErrSchemaVersionConflict, err)
+
+		err = schemaMutator.UpdateTable("ns1", testTable2, false)
+		assert.NoError(t, err)
+		table, err := schemaMutator.GetTable("ns1", testTable.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, testTable.Version+1, table.Version)
+	})
+
+	t.Run("update should retry on concurrent changes", func(t *testing.T) {
+		txnStore := &conflictingTxnStore{TxnStore: mem.NewStore()}
+		_, err := txnStore.Set(utils.SchemaListKey("ns1"), &pb.EntityList{})
+		assert.NoError(t, err)
+
+		schemaMutator := tableSchemaMutator{
+			txnStore: txnStore,
+			logger:   zap.NewExample().Sugar(),
+		}
+		err = schemaMutator.CreateTable("ns1", &testTable, false)
+		assert.NoError(t, err)
+
+		// the first commit fails due to a concurrent change.
+		txnStore.conflicts, txnStore.commits = 1, 0
+		err = schemaMutator.UpdateTable("ns1", testTable2, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, txnStore.commits)
+
+		txnStore.conflicts = maxTxnAttempts
+		err = schemaMutator.UpdateTable("ns1", testTable2, false)
+		assert.Equal(t, metastore.ErrSchemaVersionConflict, err)
+	})
+}
+
+// conflictingTxnStore fails the first conflicts commits as if keys read were changed concurrently.
+type conflictingTxnStore struct {
+	kv.TxnStore
+	conflicts int
+	commits   int
+}
+
+func (s *conflictingTxnStore) Commit(conditions []kv.Condition, ops []kv.Op) (kv.Response, error) {
+	s.commits++
+	if s.conflicts > 0 {
+		s.conflicts--
+		return nil, kv.ErrConditionCheckFailed
+	}
+	return s.TxnStore.Commit(conditions, ops)
 }
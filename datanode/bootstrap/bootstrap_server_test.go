@@ -24,6 +24,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/test/bufconn"
 	"net"
+	"os"
 	"time"
 )
 
@@ -72,6 +73,7 @@ var _ = ginkgo.Describe("bootstrap server", func() {
 	ginkgo.AfterEach(func() {
 		testServer.Stop()
 		listener.Close()
+		os.RemoveAll("../../testing/data/bootstrap/metastore/.locks")
 	})
 
 	ginkgo.It("start session test", func() {
@@ -3,6 +3,7 @@ package mocks
 
 import common "github.com/uber/aresdb/query/common"
 import context "context"
+import metastorecommon "github.com/uber/aresdb/metastore/common"
 import mock "github.com/stretchr/testify/mock"
 import topology "github.com/uber/aresdb/cluster/topology"
 
@@ -11,6 +12,29 @@ type DataNodeQueryClient struct {
 	mock.Mock
 }
 
+// GetSchemaVersions provides a mock function with given fields: ctx, host
+func (_m *DataNodeQueryClient) GetSchemaVersions(ctx context.Context, host topology.Host) (map[string]metastorecommon.TableSchemaVersion, error) {
+	ret := _m.Called(ctx, host)
+
+	var r0 map[string]metastorecommon.TableSchemaVersion
+	if rf, ok := ret.Get(0).(func(context.Context, topology.Host) map[string]metastorecommon.TableSchemaVersion); ok {
+		r0 = rf(ctx, host)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]metastorecommon.TableSchemaVersion)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, topology.Host) error); ok {
+		r1 = rf(ctx, host)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Query provides a mock function with given fields: ctx, host, query, hll
 func (_m *DataNodeQueryClient) Query(ctx context.Context, host topology.Host, query common.AQLQuery, hll bool) (common.AQLQueryResult, error) {
 	ret := _m.Called(ctx, host, query, hll)
@@ -34,6 +58,38 @@ func (_m *DataNodeQueryClient) Query(ctx context.Context, host topology.Host, qu
 	return r0, r1
 }
 
+// QueryBatch provides a mock function with given fields: ctx, host, queries
+func (_m *DataNodeQueryClient) QueryBatch(ctx context.Context, host topology.Host, queries []common.AQLQuery) ([]common.AQLQueryResult, []error, error) {
+	ret := _m.Called(ctx, host, queries)
+
+	var r0 []common.AQLQueryResult
+	if rf, ok := ret.Get(0).(func(context.Context, topology.Host, []common.AQLQuery) []common.AQLQueryResult); ok {
+		r0 = rf(ctx, host, queries)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]common.AQLQueryResult)
+		}
+	}
+
+	var r1 []error
+	if rf, ok := ret.Get(1).(func(context.Context, topology.Host, []common.AQLQuery) []error); ok {
+		r1 = rf(ctx, host, queries)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]error)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, topology.Host, []common.AQLQuery) error); ok {
+		r2 = rf(ctx, host, queries)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // QueryRaw provides a mock function with given fields: ctx, host, query
 func (_m *DataNodeQueryClient) QueryRaw(ctx context.Context, host topology.Host, query common.AQLQuery) ([]byte, error) {
 	ret := _m.Called(ctx, host, query)
@@ -19,13 +19,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/pkg/errors"
+	apiCom "github.com/uber/aresdb/api/common"
 	"github.com/uber/aresdb/cluster/topology"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/utils"
 	. "io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 func NewDataNodeQueryClient() DataNodeQueryClient {
@@ -44,6 +53,126 @@ type aqlRequestBody struct {
 
 type aqlRespBody struct {
 	Results []queryCom.AQLQueryResult `json:"results"`
+	Errors  []*apiCom.QueryErrorV2    `json:"errors"`
+	Error   *apiCom.QueryErrorV2      `json:"error"`
+}
+
+type queryMetadataKey struct{}
+
+// QueryMetadata collects metadata of queries sent to datanodes for a request.
+type QueryMetadata struct {
+	sync.Mutex
+	// unix seconds of the latest event ingested into the stalest queried shard, 0 if unknown.
+	DataFreshness int64
+	// number of queries sent to datanodes.
+	NumQueries int
+	// number of rows scanned by datanodes, only counted for responses reporting it.
+	RowsScanned int64
+	// metadata of queries of each datanode by host id.
+	Hosts map[string]*HostQueryMetadata
+}
+
+// HostQueryMetadata is the metadata of queries sent to a datanode for a request.
+type HostQueryMetadata struct {
+	// number of queries sent to the datanode, including retries and hedged queries.
+	NumQueries int
+	// number of bytes of responses read from the datanode.
+	Bytes int64
+	// number of rows scanned by the datanode, only counted for responses reporting it.
+	RowsScanned int64
+	// max latency of scans of shards assigned to the datanode, including retries on replicas.
+	Latency time.Duration
+	// number of retries of scans of shards assigned to the datanode.
+	Retries int
+	// schema version of the main table the datanode last evaluated queries against, nil if not reported.
+	SchemaVersion *metaCom.TableSchemaVersion
+}
+
+// WithQueryMetadata returns a context to collect metadata of queries sent with it.
+func WithQueryMetadata(ctx context.Context) (context.Context, *QueryMetadata) {
+	metadata := &QueryMetadata{}
+	return context.WithValue(ctx, queryMetadataKey{}, metadata), metadata
+}
+
+// GetQueryMetadata returns the metadata collected by the context, nil if there is none.
+func GetQueryMetadata(ctx context.Context) *QueryMetadata {
+	metadata, _ := ctx.Value(queryMetadataKey{}).(*QueryMetadata)
+	return metadata
+}
+
+// RecordScan records the latency and the number of retries of a scan of shards assigned to the datanode.
+func (m *QueryMetadata) RecordScan(hostID string, latency time.Duration, retries int) {
+	m.Lock()
+	defer m.Unlock()
+	host := m.host(hostID)
+	if latency > host.Latency {
+		host.Latency = latency
+	}
+	host.Retries += retries
+}
+
+// host returns the metadata of the datanode, the lock must be held.
+func (m *QueryMetadata) host(hostID string) *HostQueryMetadata {
+	if m.Hosts == nil {
+		m.Hosts = make(map[string]*HostQueryMetadata)
+	}
+	host := m.Hosts[hostID]
+	if host == nil {
+		host = &HostQueryMetadata{}
+		m.Hosts[hostID] = host
+	}
+	return host
+}
+
+// record records the metadata of a datanode response.
+func (m *QueryMetadata) record(hostID string, res *http.Response) {
+	m.Lock()
+	defer m.Unlock()
+	m.NumQueries++
+	freshness, _ := strconv.ParseInt(res.Header.Get(utils.HTTPDataFreshnessHeaderKey), 10, 64)
+	m.updateDataFreshness(freshness)
+	rowsScanned, _ := strconv.ParseInt(res.Header.Get(utils.HTTPRowsScannedHeaderKey), 10, 64)
+	m.RowsScanned += rowsScanned
+	host := m.host(hostID)
+	host.NumQueries++
+	host.RowsScanned += rowsScanned
+	if schemaVersion, err := metaCom.ParseTableSchemaVersion(res.Header.Get(utils.HTTPSchemaVersionHeaderKey)); err == nil {
+		host.SchemaVersion = &schemaVersion
+	}
+}
+
+// RecordDataFreshness records the data freshness of results not queried from datanodes, e.g. cached ones.
+func (m *QueryMetadata) RecordDataFreshness(freshness int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.updateDataFreshness(freshness)
+}
+
+// updateDataFreshness keeps the stalest of known data freshness, the lock must be held.
+func (m *QueryMetadata) updateDataFreshness(freshness int64) {
+	if freshness > 0 && (m.DataFreshness == 0 || freshness < m.DataFreshness) {
+		m.DataFreshness = freshness
+	}
+}
+
+// SchemaVersions returns schema versions of the main table reported by datanodes by host id.
+func (m *QueryMetadata) SchemaVersions() map[string]metaCom.TableSchemaVersion {
+	m.Lock()
+	defer m.Unlock()
+	versions := make(map[string]metaCom.TableSchemaVersion)
+	for hostID, host := range m.Hosts {
+		if host.SchemaVersion != nil {
+			versions[hostID] = *host.SchemaVersion
+		}
+	}
+	return versions
+}
+
+// recordBytes records bytes of a response read from the datanode.
+func (m *QueryMetadata) recordBytes(hostID string, numBytes int) {
+	m.Lock()
+	defer m.Unlock()
+	m.host(hostID).Bytes += int64(numBytes)
 }
 
 func (dc *dataNodeQueryClientImpl) Query(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) (result queryCom.AQLQueryResult, err error) {
@@ -68,6 +197,10 @@ func (dc *dataNodeQueryClientImpl) Query(ctx context.Context, host topology.Host
 	} else {
 		var respBody aqlRespBody
 		err = json.Unmarshal(bs, &respBody)
+		if err == nil && respBody.Error != nil {
+			err = respBody.Error.ToCodedError()
+			return
+		}
 		if err != nil || len(respBody.Results) != 1 {
 			err = errors.New(fmt.Sprintf("invalid response from datanode, resp: %s", bs))
 			return
@@ -79,6 +212,40 @@ func (dc *dataNodeQueryClientImpl) Query(ctx context.Context, host topology.Host
 	return
 }
 
+// QueryBatch sends the queries to the datanode in a single request. Results and errors of the queries are
+// returned by query index, so that failed queries do not fail the others; err is only set if the request
+// failed as a whole.
+func (dc *dataNodeQueryClientImpl) QueryBatch(ctx context.Context, host topology.Host, queries []queryCom.AQLQuery) (results []queryCom.AQLQueryResult, errs []error, err error) {
+	var bs []byte
+	bs, err = dc.post(ctx, host, queries, false)
+	if err != nil {
+		return
+	}
+
+	var respBody aqlRespBody
+	err = json.Unmarshal(bs, &respBody)
+	if err == nil && len(respBody.Results) != len(queries) && respBody.Error != nil {
+		err = respBody.Error.ToCodedError()
+		return
+	}
+	if err != nil || len(respBody.Results) != len(queries) {
+		err = errors.New(fmt.Sprintf("invalid response from datanode, resp: %s", bs))
+		return
+	}
+	results = respBody.Results
+	errs = make([]error, len(queries))
+	for i := range queries {
+		if i < len(respBody.Errors) && respBody.Errors[i] != nil {
+			errs[i] = respBody.Errors[i].ToCodedError()
+		} else if len(queries) == 1 && respBody.Error != nil {
+			// errors by query index are left out of single query responses.
+			errs[i] = respBody.Error.ToCodedError()
+		}
+	}
+	utils.GetLogger().With("host", host, "queries", len(queries)).Debug("datanode query client QueryBatch succeeded")
+	return
+}
+
 func (dc *dataNodeQueryClientImpl) QueryRaw(ctx context.Context, host topology.Host, query queryCom.AQLQuery) (bs []byte, err error) {
 	bs, err = dc.queryRaw(ctx, host, query, false)
 	if err == nil {
@@ -88,19 +255,41 @@ func (dc *dataNodeQueryClientImpl) QueryRaw(ctx context.Context, host topology.H
 }
 
 func (dc *dataNodeQueryClientImpl) queryRaw(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) (bs []byte, err error) {
+	return dc.post(ctx, host, []queryCom.AQLQuery{query}, hll)
+}
+
+// post sends the queries to the datanode and reads the response body. Failed responses of multi-query
+// requests in the v2 envelope are read as well since they carry results of the succeeded queries.
+func (dc *dataNodeQueryClientImpl) post(ctx context.Context, host topology.Host, queries []queryCom.AQLQuery, hll bool) (bs []byte, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "datanode.query")
+	ext.SpanKindRPCClient.Set(span)
+	ext.HTTPUrl.Set(span, host.Address())
+	span.SetTag("queries", len(queries))
+	defer func() {
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.SetTag("error.message", err.Error())
+		}
+		span.Finish()
+	}()
+
 	var u *url.URL
 	u, err = url.Parse(host.Address())
 	if err != nil {
 		return
 	}
 	u.Scheme = "http"
-	u.Path = "/query/aql"
+	u.Path = "/v2/query/aql"
 	q := u.Query()
 	q.Set("dataonly", "1")
+	if deadline, ok := ctx.Deadline(); ok {
+		// datanodes stop waiting for devices once the deadline of the query is exceeded.
+		q.Set("timeout", strconv.Itoa(deviceChoosingTimeout(deadline)))
+	}
 	u.RawQuery = q.Encode()
 
 	aqlRequestBody := aqlRequestBody{
-		[]queryCom.AQLQuery{query},
+		queries,
 	}
 	var bodyBytes []byte
 	bodyBytes, err = json.Marshal(aqlRequestBody)
@@ -115,24 +304,124 @@ func (dc *dataNodeQueryClientImpl) queryRaw(ctx context.Context, host topology.H
 	if hll {
 		req.Header.Add(utils.HTTPAcceptTypeHeaderKey, utils.HTTPContentTypeHyperLogLog)
 	}
+	if len(queries[0].CallerRoles) > 0 {
+		// columns are access controlled by data nodes as well, queries of a request share the caller.
+		req.Header.Set(utils.HTTPCallerRoleHeaderKey, strings.Join(queries[0].CallerRoles, ","))
+	}
+	setTraceHeaders(ctx, req)
 
 	req = req.WithContext(ctx)
+	runningQuery := queryCom.GetRunningQuery(ctx)
+	if runningQuery != nil {
+		hostID := host.ID()
+		runningQuery.StartHostRequest(hostID)
+		defer runningQuery.EndHostRequest(hostID)
+	}
 	var res *http.Response
 	res, err = dc.client.Do(req)
 	if res != nil {
 		defer res.Body.Close()
 	}
 	if err != nil {
+		err = utils.WithCode(utils.ErrCodeUnavailable, err)
 		return
 	}
-	if res.StatusCode != http.StatusOK {
-		err = errors.New(fmt.Sprintf("got status code %d from datanode", res.StatusCode))
+	metadata := GetQueryMetadata(ctx)
+	if metadata != nil {
+		metadata.record(host.ID(), res)
+	}
+	if res.StatusCode != http.StatusOK && (len(queries) == 1 || !isJSONResponse(res)) {
+		err = readQueryError(res)
 		return
 	}
 	bs, err = ReadAll(res.Body)
 	if err != nil {
 		bs = nil
 	}
+	if metadata != nil {
+		metadata.recordBytes(host.ID(), len(bs))
+	}
+	runningQuery.AddBytes(len(bs))
+
+	return
+}
+
+// setTraceHeaders forwards the request id and the span of the context to the datanode, so that logs and traces
+// of the datanode line up with the request of broker.
+func setTraceHeaders(ctx context.Context, req *http.Request) {
+	if requestID := utils.RequestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(utils.HTTPRequestIDHeaderKey, requestID)
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	}
+}
+
+// deviceChoosingTimeout returns seconds left until the deadline, rounded up to at least one second since
+// non positive timeouts mean the default of datanodes.
+func deviceChoosingTimeout(deadline time.Time) int {
+	seconds := int(math.Ceil(time.Until(deadline).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// readQueryError reads the structured error of the failed v2 query response, or creates the error from
+// the status code if the response is not in the v2 envelope.
+func readQueryError(res *http.Response) error {
+	statusErr := utils.NewCodedError(utils.ErrorCodeFromHTTPStatus(res.StatusCode), nil,
+		"got status code %d from datanode", res.StatusCode)
+	if !isJSONResponse(res) {
+		return statusErr
+	}
+	var respBody aqlRespBody
+	bs, err := ReadAll(res.Body)
+	if err != nil || json.Unmarshal(bs, &respBody) != nil || respBody.Error == nil {
+		return statusErr
+	}
+	return respBody.Error.ToCodedError()
+}
+
+// isJSONResponse tells whether the response body is json.
+func isJSONResponse(res *http.Response) bool {
+	return strings.HasPrefix(res.Header.Get(utils.HTTPContentTypeHeaderKey), utils.HTTPContentTypeApplicationJson)
+}
+
+func (dc *dataNodeQueryClientImpl) GetSchemaVersions(ctx context.Context, host topology.Host) (versions map[string]metaCom.TableSchemaVersion, err error) {
+	var u *url.URL
+	u, err = url.Parse(host.Address())
+	if err != nil {
+		return
+	}
+	u.Scheme = "http"
+	u.Path = "/schema/versions"
 
+	var req *http.Request
+	req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return
+	}
+	setTraceHeaders(ctx, req)
+
+	req = req.WithContext(ctx)
+	var res *http.Response
+	res, err = dc.client.Do(req)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		err = errors.New(fmt.Sprintf("got status code %d from datanode", res.StatusCode))
+		return
+	}
+	var bs []byte
+	bs, err = ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(bs, &versions)
 	return
 }
@@ -16,12 +16,19 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	apiCom "github.com/uber/aresdb/api/common"
 	topoMocks "github.com/uber/aresdb/cluster/topology/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
 	"net/http"
 	"net/http/httptest"
+	"time"
 )
 
 var _ = ginkgo.Describe("datanode query client", func() {
@@ -36,6 +43,7 @@ var _ = ginkgo.Describe("datanode query client", func() {
 
 	ginkgo.It("should work happy path", func() {
 		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			Ω(req.URL.Path).Should(Equal("/v2/query/aql"))
 			aqlResponseGood := aqlRespBody{
 				Results: []common.AQLQueryResult{
 					aqlResult,
@@ -52,6 +60,15 @@ var _ = ginkgo.Describe("datanode query client", func() {
 		res, err := client.Query(context.TODO(), &mockHost, common.AQLQuery{}, false)
 		Ω(err).Should(BeNil())
 		Ω(res).Should(Equal(aqlResult))
+
+		// progress of the running query is updated.
+		mockHost.On("ID").Return("host1")
+		runningQuery := common.NewQueryRegistry(1).Register(&common.AQLQuery{}, "")
+		_, err = client.Query(common.WithRunningQuery(context.TODO(), runningQuery), &mockHost, common.AQLQuery{}, false)
+		Ω(err).Should(BeNil())
+		snapshot := runningQuery.Snapshot()
+		Ω(snapshot.Bytes).Should(BeNumerically(">", 0))
+		Ω(snapshot.OutstandingRequests).Should(BeEmpty())
 	})
 
 	ginkgo.It("should fail status code not ok", func() {
@@ -67,6 +84,142 @@ var _ = ginkgo.Describe("datanode query client", func() {
 		Ω(err.Error()).Should(ContainSubstring("got status code"))
 	})
 
+	ginkgo.It("should return structured errors of datanodes", func() {
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			apiCom.RespondWithV2Error(rw, apiCom.QueryMetadataV2{RequestID: "r1"},
+				utils.NewCodedError(utils.ErrCodeResourceExhausted, nil, "no device"))
+		}))
+		add := "http://" + server.Listener.Addr().String()
+		mockHost := topoMocks.Host{}
+		mockHost.On("Address").Return(add)
+
+		client := NewDataNodeQueryClient()
+		_, err := client.Query(context.TODO(), &mockHost, common.AQLQuery{}, false)
+		Ω(err).Should(Equal(&utils.CodedError{Code: utils.ErrCodeResourceExhausted, Message: "no device"}))
+	})
+
+	ginkgo.It("should send batched queries in a single request", func() {
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			var body aqlRequestBody
+			Ω(json.NewDecoder(req.Body).Decode(&body)).Should(BeNil())
+			if len(body.Queries) != 2 {
+				apiCom.RespondWithV2Error(rw, apiCom.QueryMetadataV2{},
+					utils.NewCodedError(utils.ErrCodeBadRequest, nil, "expect 2 queries"))
+				return
+			}
+			queryErr := apiCom.NewQueryErrorV2(utils.NewCodedError(utils.ErrCodeInvalidQuery, nil, "bad query"))
+			apiCom.RespondV2(rw, apiCom.QueryResponseV2{
+				Results: []interface{}{aqlResult, nil},
+				Errors:  []*apiCom.QueryErrorV2{nil, queryErr},
+				Error:   queryErr,
+			})
+		}))
+		add := "http://" + server.Listener.Addr().String()
+		mockHost := topoMocks.Host{}
+		mockHost.On("Address").Return(add)
+
+		client := NewDataNodeQueryClient()
+		results, errs, err := client.QueryBatch(context.TODO(), &mockHost, []common.AQLQuery{{}, {}})
+		Ω(err).Should(BeNil())
+		Ω(results).Should(Equal([]common.AQLQueryResult{aqlResult, nil}))
+		Ω(errs).Should(Equal([]error{nil, &utils.CodedError{Code: utils.ErrCodeInvalidQuery, Message: "bad query"}}))
+
+		// failures of the request fail all queries.
+		_, _, err = client.QueryBatch(context.TODO(), &mockHost, []common.AQLQuery{{}, {}, {}})
+		Ω(err).Should(Equal(&utils.CodedError{Code: utils.ErrCodeBadRequest, Message: "expect 2 queries"}))
+	})
+
+	ginkgo.It("should collect query metadata", func() {
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set(utils.HTTPDataFreshnessHeaderKey, req.URL.Query().Get("freshness"))
+			rw.Header().Set(utils.HTTPRowsScannedHeaderKey, "10")
+			rw.Header().Set(utils.HTTPSchemaVersionHeaderKey, req.URL.Query().Get("schema"))
+			rw.Write([]byte(`[]`))
+		}))
+		add := "http://" + server.Listener.Addr().String()
+		hosts := make([]*topoMocks.Host, 3)
+		schemaVersions := []string{"1.2", "1.1", ""}
+		for i, freshness := range []string{"200", "100", ""} {
+			hosts[i] = &topoMocks.Host{}
+			hosts[i].On("Address").Return(add + "?freshness=" + freshness + "&schema=" + schemaVersions[i])
+			hosts[i].On("ID").Return(fmt.Sprintf("host%d", i%2))
+		}
+
+		ctx, metadata := WithQueryMetadata(context.TODO())
+		client := NewDataNodeQueryClient()
+		for _, host := range hosts {
+			_, err := client.QueryRaw(ctx, host, common.AQLQuery{})
+			Ω(err).Should(BeNil())
+		}
+		Ω(metadata.NumQueries).Should(Equal(3))
+		Ω(metadata.DataFreshness).Should(BeEquivalentTo(100))
+		Ω(metadata.RowsScanned).Should(BeEquivalentTo(30))
+
+		metadata.RecordScan("host0", time.Second, 1)
+		metadata.RecordScan("host0", time.Millisecond, 0)
+		Ω(metadata.Hosts).Should(Equal(map[string]*HostQueryMetadata{
+			"host0": {NumQueries: 2, Bytes: 4, RowsScanned: 20, Latency: time.Second, Retries: 1,
+				SchemaVersion: &metaCom.TableSchemaVersion{Incarnation: 1, Version: 2}},
+			"host1": {NumQueries: 1, Bytes: 2, RowsScanned: 10,
+				SchemaVersion: &metaCom.TableSchemaVersion{Incarnation: 1, Version: 1}},
+		}))
+		Ω(metadata.SchemaVersions()).Should(Equal(map[string]metaCom.TableSchemaVersion{
+			"host0": {Incarnation: 1, Version: 2},
+			"host1": {Incarnation: 1, Version: 1},
+		}))
+	})
+
+	ginkgo.It("should pass the deadline of the query to datanodes", func() {
+		var timeouts []string
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			timeouts = append(timeouts, req.URL.Query().Get("timeout"))
+			rw.Write([]byte(`[]`))
+		}))
+		add := "http://" + server.Listener.Addr().String()
+		mockHost := topoMocks.Host{}
+		mockHost.On("Address").Return(add)
+
+		client := NewDataNodeQueryClient()
+		_, err := client.QueryRaw(context.TODO(), &mockHost, common.AQLQuery{})
+		Ω(err).Should(BeNil())
+		ctx, cancel := context.WithTimeout(context.TODO(), 2500*time.Millisecond)
+		defer cancel()
+		_, err = client.QueryRaw(ctx, &mockHost, common.AQLQuery{})
+		Ω(err).Should(BeNil())
+		Ω(timeouts).Should(Equal([]string{"", "3"}))
+	})
+
+	ginkgo.It("should forward the request id and the span of the query to datanodes", func() {
+		var requestIDs, traceIDs []string
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requestIDs = append(requestIDs, req.Header.Get(utils.HTTPRequestIDHeaderKey))
+			traceIDs = append(traceIDs, req.Header.Get("Mockpfx-Ids-Traceid"))
+			rw.Write([]byte(`[]`))
+		}))
+		add := "http://" + server.Listener.Addr().String()
+		mockHost := topoMocks.Host{}
+		mockHost.On("Address").Return(add)
+
+		tracer := mocktracer.New()
+		opentracing.SetGlobalTracer(tracer)
+		defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+		parent := tracer.StartSpan("broker.query")
+		ctx := opentracing.ContextWithSpan(utils.WithRequestID(context.TODO(), "request1"), parent)
+		client := NewDataNodeQueryClient()
+		_, err := client.QueryRaw(ctx, &mockHost, common.AQLQuery{})
+		Ω(err).Should(BeNil())
+		_, err = client.QueryRaw(context.TODO(), &mockHost, common.AQLQuery{})
+		Ω(err).Should(BeNil())
+		Ω(requestIDs).Should(Equal([]string{"request1", ""}))
+		Ω(traceIDs[0]).Should(Equal(fmt.Sprint(parent.Context().(mocktracer.MockSpanContext).TraceID)))
+
+		spans := tracer.FinishedSpans()
+		Ω(spans).Should(HaveLen(2))
+		Ω(spans[0].OperationName).Should(Equal("datanode.query"))
+		Ω(spans[0].ParentID).Should(Equal(parent.Context().(mocktracer.MockSpanContext).SpanID))
+		Ω(spans[1].ParentID).Should(BeZero())
+	})
+
 	ginkgo.It("should fail bad body", func() {
 		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 			aqlResponseBad := struct {
@@ -85,4 +238,23 @@ var _ = ginkgo.Describe("datanode query client", func() {
 		_, err := client.Query(context.TODO(), &mockHost, common.AQLQuery{}, false)
 		Ω(err.Error()).Should(ContainSubstring("invalid response from datanode"))
 	})
+
+	ginkgo.It("GetSchemaVersions should work", func() {
+		versions := map[string]metaCom.TableSchemaVersion{
+			"table1": {Incarnation: 1, Version: 2, Hash: "abc"},
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			Ω(req.URL.Path).Should(Equal("/schema/versions"))
+			bs, _ := json.Marshal(versions)
+			rw.Write(bs)
+		}))
+		add := "http://" + server.Listener.Addr().String()
+		mockHost := topoMocks.Host{}
+		mockHost.On("Address").Return(add)
+
+		client := NewDataNodeQueryClient()
+		res, err := client.GetSchemaVersions(context.TODO(), &mockHost)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(versions))
+	})
 })
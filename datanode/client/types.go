@@ -17,6 +17,7 @@ import (
 	"context"
 	"github.com/uber/aresdb/cluster/topology"
 	"github.com/uber/aresdb/datanode/generated/proto/rpc"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	queryCom "github.com/uber/aresdb/query/common"
 )
 
@@ -39,6 +40,10 @@ type PeerSource interface {
 type DataNodeQueryClient interface {
 	// used for agg query
 	Query(ctx context.Context, host topology.Host, query queryCom.AQLQuery, hll bool) (queryCom.AQLQueryResult, error)
+	// used for batched agg queries sharing a single request, results and errors are returned by query index
+	QueryBatch(ctx context.Context, host topology.Host, queries []queryCom.AQLQuery) ([]queryCom.AQLQueryResult, []error, error)
 	// used for non agg query, header is left out, only matrixData returned as raw bytes
 	QueryRaw(ctx context.Context, host topology.Host, query queryCom.AQLQuery) ([]byte, error)
+	// used to skip hosts with stale schema, returns schema versions by table name
+	GetSchemaVersions(ctx context.Context, host topology.Host) (map[string]metaCom.TableSchemaVersion, error)
 }
@@ -20,6 +20,7 @@ import (
 	"net/http/pprof"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/m3db/m3/src/x/instrument"
@@ -29,11 +30,13 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/m3db/m3/src/cluster/client"
 	"github.com/m3db/m3/src/cluster/placement"
 	"github.com/m3db/m3/src/cluster/services"
 	m3Shard "github.com/m3db/m3/src/cluster/shard"
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/api"
+	apiCom "github.com/uber/aresdb/api/common"
 	"github.com/uber/aresdb/cluster/shard"
 	"github.com/uber/aresdb/cluster/topology"
 	"github.com/uber/aresdb/common"
@@ -58,6 +61,7 @@ type dataNode struct {
 	hostID          string
 	startedAt       time.Time
 	shardSet        shard.ShardSet
+	clusterClient   client.Client
 	clusterServices services.Services
 
 	topo      topology.Topology
@@ -65,6 +69,8 @@ type dataNode struct {
 	memStore  memstore.MemStore
 	diskStore diskstore.DiskStore
 
+	diskSpaceMonitor *diskstore.DiskSpaceMonitor
+
 	opts     Options
 	logger   common.Logger
 	metrics  datanodeMetrics
@@ -75,10 +81,22 @@ type dataNode struct {
 	redoLogManagerMaster *redolog.RedoLogManagerMaster
 	grpcServer           *grpc.Server
 
-	mapWatch topology.MapWatch
-	close    chan struct{}
+	mapWatch            topology.MapWatch
+	schemaChangeWatcher *controllerCli.ChangeWatcher
+	close               chan struct{}
+	// set to 1 once schema is loaded from local metastore.
+	schemaLoaded int32
 }
 
+const (
+	// interval to fetch schema from controller.
+	schemaFetchIntervalInSeconds = 5 * 60
+	// interval to fetch schema from controller as a fallback of watching schema change notifications.
+	schemaFetchFallbackIntervalInSeconds = 60 * 60
+	// interval to compare local schema against controller.
+	schemaDriftDetectionIntervalInSeconds = 10 * 60
+)
+
 type datanodeHandlers struct {
 	schemaHandler      *api.SchemaHandler
 	enumHandler        *api.EnumHandler
@@ -88,6 +106,7 @@ type datanodeHandlers struct {
 	debugStaticHandler http.Handler
 	debugHandler       *api.DebugHandler
 	healthCheckHandler *api.HealthCheckHandler
+	healthChecker      *apiCom.HealthChecker
 	swaggerHandler     http.Handler
 }
 
@@ -113,7 +132,10 @@ func NewDataNode(
 	if err != nil {
 		return nil, utils.StackError(err, "failed to initialize local metastore")
 	}
-	diskStore := diskstore.NewLocalDiskStore(opts.ServerConfig().RootPath)
+	diskStore, err := diskstore.NewDiskStore(opts.ServerConfig().RootPath, opts.ServerConfig().DiskStore)
+	if err != nil {
+		return nil, utils.StackError(err, "failed to initialize disk store")
+	}
 
 	bootstrapServer := bootstrap.NewPeerDataNodeServer(metaStore, diskStore)
 	bootstrapToken := bootstrapServer.(memCom.BootStrapToken)
@@ -124,7 +146,14 @@ func NewDataNode(
 		return nil, utils.StackError(err, "failed to initialize redolog manager master")
 	}
 
-	memStore := memstore.NewMemStore(metaStore, diskStore, memstore.NewOptions(bootstrapToken, redoLogManagerMaster))
+	diskSpaceMonitor := diskstore.NewDiskSpaceMonitor(opts.ServerConfig().RootPath, opts.ServerConfig().DiskStore.Space)
+	if listener, ok := diskStore.(diskstore.DiskSpaceListener); ok {
+		diskSpaceMonitor.AddListener(listener.OnDiskSpaceLevelChange)
+	}
+
+	memStore := memstore.NewMemStore(metaStore, diskStore,
+		memstore.NewOptions(bootstrapToken, redoLogManagerMaster).SetDiskSpaceMonitor(diskSpaceMonitor).
+			SetArchiveWriteConcurrency(opts.ServerConfig().DiskStore.ArchiveWriteConcurrency))
 
 	grpcServer := grpc.NewServer()
 	rpc.RegisterPeerDataNodeServer(grpcServer, bootstrapServer)
@@ -136,6 +165,7 @@ func NewDataNode(
 		metaStore:            metaStore,
 		memStore:             memStore,
 		diskStore:            diskStore,
+		diskSpaceMonitor:     diskSpaceMonitor,
 		opts:                 opts,
 		logger:               logger,
 		metrics:              newDatanodeMetrics(scope),
@@ -146,11 +176,11 @@ func NewDataNode(
 	}
 	d.handlers = d.newHandlers()
 	d.bootstrapManager = NewBootstrapManager(d.hostID, memStore, opts, topo)
-	clusterClient, err := d.opts.ServerConfig().Cluster.Etcd.NewClient(instrument.NewOptions())
+	d.clusterClient, err = d.opts.ServerConfig().Cluster.Etcd.NewClient(instrument.NewOptions())
 	if err != nil {
 		return nil, utils.StackError(err, "failed to create etcd client")
 	}
-	d.clusterServices, err = clusterClient.Services(nil)
+	d.clusterServices, err = d.clusterClient.Services(nil)
 	if err != nil {
 		return nil, utils.StackError(err, "failed to create cluster services client")
 	}
@@ -169,6 +199,7 @@ func (d *dataNode) Open() error {
 	if err != nil {
 		return err
 	}
+	atomic.StoreInt32(&d.schemaLoaded, 1)
 
 	// 2. start debug server
 	go d.startDebugServer()
@@ -189,6 +220,8 @@ func (d *dataNode) Open() error {
 	}
 
 	d.memStore.GetHostMemoryManager().Start()
+	d.diskSpaceMonitor.Start()
+	diskstore.GetIOStats().Start(time.Duration(d.opts.ServerConfig().DiskStore.IOSummaryIntervalSeconds) * time.Second)
 
 	// 5. start scheduler
 	if !d.opts.ServerConfig().SchedulerOff {
@@ -225,22 +258,48 @@ func (d *dataNode) startSchemaWatch() {
 			controllerClientCfg.Headers.Add(controllerCli.InstanceNameHeaderKey, d.opts.ServerConfig().Cluster.InstanceID)
 		}
 
-		controllerClient := controllerCli.NewControllerHTTPClient(controllerClientCfg.Address, time.Duration(controllerClientCfg.TimeoutSec)*time.Second, controllerClientCfg.Headers)
-		schemaFetchJob := metastore.NewSchemaFetchJob(5*60, d.metaStore, metastore.NewTableSchameValidator(), controllerClient, d.opts.ServerConfig().Cluster.Namespace, "")
+		schemaFetchIntervalSeconds := schemaFetchIntervalInSeconds
+		if controllerClientCfg.WatchNotifications {
+			if kvStore, err := d.clusterClient.KV(); err != nil {
+				d.logger.With("error", err.Error()).Error("failed to watch schema change notifications, polling schema instead")
+			} else {
+				d.schemaChangeWatcher = controllerCli.NewChangeWatcher(kvStore, utils.SchemaNotificationKey(d.opts.ServerConfig().Cluster.Namespace))
+				schemaFetchIntervalSeconds = schemaFetchFallbackIntervalInSeconds
+			}
+		}
+
+		controllerClient := controllerCli.NewControllerHTTPClientFromConfig(*controllerClientCfg)
+		schemaFetchJob := metastore.NewSchemaFetchJob(schemaFetchIntervalSeconds, d.metaStore.WithActor(metastore.SchemaFetchActor), metastore.NewTableSchameValidator(), controllerClient, d.opts.ServerConfig().Cluster.Namespace, "")
 		// immediate initial fetch
 		schemaFetchJob.FetchSchema()
+		if d.schemaChangeWatcher != nil {
+			schemaFetchJob.SetChangeNotifications(d.schemaChangeWatcher.C())
+			d.schemaChangeWatcher.Start()
+		}
 		go schemaFetchJob.Run()
+
+		schemaDriftDetector := metastore.NewSchemaDriftDetector(schemaDriftDetectionIntervalInSeconds, d.metaStore, controllerClient, d.opts.ServerConfig().Cluster.Namespace)
+		schemaDriftDetector.SetResync(schemaFetchJob.RequestResync, controllerClientCfg.ResyncOnSchemaDrift)
+		d.handlers.schemaHandler.SetSchemaDriftDetector(schemaDriftDetector)
+		go schemaDriftDetector.Run()
 	}
 }
 
 func (d *dataNode) Close() {
+	d.handlers.healthChecker.Shutdown()
 	close(d.close)
 	if d.mapWatch != nil {
 		d.mapWatch.Close()
 		d.mapWatch = nil
 	}
+	if d.schemaChangeWatcher != nil {
+		d.schemaChangeWatcher.Close()
+		d.schemaChangeWatcher = nil
+	}
 	d.grpcServer.Stop()
 	d.redoLogManagerMaster.Stop()
+	d.diskSpaceMonitor.Stop()
+	diskstore.GetIOStats().Stop()
 }
 
 func (d *dataNode) startDebugServer() {
@@ -416,11 +475,13 @@ func (d *dataNode) Serve() {
 	d.handlers.enumHandler.Register(router.PathPrefix("/schema").Subrouter(), httpWrappers...)
 	d.handlers.dataHandler.Register(router.PathPrefix("/data").Subrouter(), httpWrappers...)
 	d.handlers.queryHandler.Register(router.PathPrefix("/query").Subrouter(), httpWrappers...)
+	d.handlers.queryHandler.RegisterV2(router.PathPrefix("/v2/query").Subrouter(), httpWrappers...)
 
 	router.PathPrefix("/swagger/").Handler(d.handlers.swaggerHandler)
 	router.PathPrefix("/node_modules/").Handler(d.handlers.nodeModuleHandler)
 	router.HandleFunc("/health", utils.WithMetricsFunc(d.handlers.healthCheckHandler.HealthCheck))
 	router.HandleFunc("/version", d.handlers.healthCheckHandler.Version)
+	d.handlers.healthChecker.Register(router, utils.WithMetricsFunc)
 
 	// Support CORS calls.
 	allowOrigins := handlers.AllowedOrigins([]string{"*"})
@@ -435,7 +496,8 @@ func (d *dataNode) Serve() {
 	go batchStatsReporter.Run()
 
 	d.opts.InstrumentOptions().Logger().Infof("Starting HTTP server on port %d with max connection %d", d.opts.ServerConfig().Port, d.opts.ServerConfig().HTTP.MaxConnections)
-	utils.LimitServe(d.opts.ServerConfig().Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(mixedHandler(d.grpcServer, router)), d.opts.ServerConfig().HTTP)
+	utils.LimitServe(d.opts.ServerConfig().Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(mixedHandler(d.grpcServer, router)), d.opts.ServerConfig().HTTP,
+		d.handlers.healthChecker.Shutdown)
 }
 
 func (d *dataNode) advertise() {
@@ -632,8 +694,10 @@ func newDatanodeMetrics(scope tally.Scope) datanodeMetrics {
 
 func (d *dataNode) newHandlers() datanodeHandlers {
 	healthCheckHandler := api.NewHealthCheckHandler()
+	healthChecker := apiCom.NewHealthChecker()
+	d.addReadinessChecks(healthChecker)
 	return datanodeHandlers{
-		schemaHandler:      api.NewSchemaHandler(d.metaStore),
+		schemaHandler:      api.NewSchemaHandler(d.metaStore, d.opts.ServerConfig().Cluster.Namespace),
 		enumHandler:        api.NewEnumHandler(d.memStore, d.metaStore),
 		queryHandler:       api.NewQueryHandler(d.memStore, d, d.opts.ServerConfig().Query),
 		dataHandler:        api.NewDataHandler(d.memStore),
@@ -641,6 +705,7 @@ func (d *dataNode) newHandlers() datanodeHandlers {
 		debugStaticHandler: http.StripPrefix("/static/", utils.NoCache(http.FileServer(http.Dir("./api/ui/debug/")))),
 		swaggerHandler:     http.StripPrefix("/swagger/", http.FileServer(http.Dir("./api/ui/swagger/"))),
 		healthCheckHandler: healthCheckHandler,
+		healthChecker:      healthChecker,
 		debugHandler:       api.NewDebugHandler(d.memStore, d.metaStore, d.handlers.queryHandler, healthCheckHandler, d),
 	}
 }
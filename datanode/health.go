@@ -0,0 +1,151 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datanode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/memstore"
+	"github.com/uber/aresdb/utils"
+)
+
+// components of datanode readiness checks.
+const (
+	schemaComponent    = "schema"
+	bootstrapComponent = "bootstrap"
+	shardsComponent    = "shards"
+	diskComponent      = "disk"
+)
+
+// max number of table shards listed in readiness failures.
+const maxTableShardsInReadinessFailure = 10
+
+// addReadinessChecks adds readiness checks of all datanode components to the health checker.
+func (d *dataNode) addReadinessChecks(checker *apiCom.HealthChecker) {
+	checker.AddReadinessCheck(schemaComponent, d.checkSchemaLoaded)
+	checker.AddReadinessCheck(bootstrapComponent, d.checkBootstrapped)
+	checker.AddReadinessCheck(shardsComponent, d.checkShardsAvailable)
+	checker.AddReadinessCheck(diskComponent, d.diskSpaceMonitor.CheckReadiness)
+}
+
+// checkSchemaLoaded checks whether schema is loaded from local metastore.
+func (d *dataNode) checkSchemaLoaded() error {
+	if atomic.LoadInt32(&d.schemaLoaded) == 0 {
+		return utils.StackError(nil, "schema not loaded")
+	}
+	return nil
+}
+
+// checkBootstrapped checks whether bootstrap (redolog recovery and peer copy) is done for all owned shards.
+func (d *dataNode) checkBootstrapped() error {
+	if !d.bootstrapManager.IsBootstrapped() {
+		return utils.StackError(nil, "bootstrap not finished")
+	}
+	d.RLock()
+	shardIDs := d.shardSet.AllIDs()
+	d.RUnlock()
+	return checkTableShardsBootstrapped(d.memStore, shardIDs)
+}
+
+// checkShardsAvailable checks whether all shards owned by the datanode in the placement are available.
+func (d *dataNode) checkShardsAvailable() error {
+	mapWatch := d.mapWatch
+	if mapWatch == nil {
+		return utils.StackError(nil, "topology not watched")
+	}
+	return checkHostShardsAvailable(mapWatch.Get(), d.hostID)
+}
+
+// checkTableShardsBootstrapped checks whether table shards of fact tables of the shards, and table shards of
+// dimension tables if any shard is owned, are bootstrapped.
+func checkTableShardsBootstrapped(memStore memstore.MemStore, shardIDs []uint32) error {
+	if len(shardIDs) == 0 {
+		return nil
+	}
+
+	var factTables, dimensionTables []string
+	memStore.RLock()
+	for table, schema := range memStore.GetSchemas() {
+		if schema.Schema.IsFactTable {
+			factTables = append(factTables, table)
+		} else {
+			dimensionTables = append(dimensionTables, table)
+		}
+	}
+	memStore.RUnlock()
+	sort.Strings(factTables)
+	sort.Strings(dimensionTables)
+
+	var notBootstrapped []string
+	checkTableShard := func(table string, shardID int) {
+		tableShard, err := memStore.GetTableShard(table, shardID)
+		if err != nil {
+			notBootstrapped = append(notBootstrapped, fmt.Sprintf("%s_%d", table, shardID))
+			return
+		}
+		if !tableShard.IsBootstrapped() {
+			notBootstrapped = append(notBootstrapped, fmt.Sprintf("%s_%d", table, shardID))
+		}
+		tableShard.Users.Done()
+	}
+
+	for _, shardID := range shardIDs {
+		for _, table := range factTables {
+			checkTableShard(table, int(shardID))
+		}
+	}
+	for _, table := range dimensionTables {
+		checkTableShard(table, 0)
+	}
+
+	if len(notBootstrapped) == 0 {
+		return nil
+	}
+	numNotBootstrapped := len(notBootstrapped)
+	if numNotBootstrapped > maxTableShardsInReadinessFailure {
+		notBootstrapped = append(notBootstrapped[:maxTableShardsInReadinessFailure], "...")
+	}
+	return utils.StackError(nil, "%d table shards not bootstrapped: %s", numNotBootstrapped,
+		strings.Join(notBootstrapped, ", "))
+}
+
+// checkHostShardsAvailable checks whether the host is in the placement and none of its shards is still
+// initializing.
+func checkHostShardsAvailable(topoMap topology.Map, hostID string) error {
+	if topoMap == nil {
+		return utils.StackError(nil, "topology not available")
+	}
+	hostShardSet, ok := topoMap.LookupHostShardSet(hostID)
+	if !ok {
+		return utils.StackError(nil, "host %s not found in placement", hostID)
+	}
+
+	var initializing []uint32
+	for _, s := range hostShardSet.ShardSet().All() {
+		if s.State() == m3Shard.Initializing {
+			initializing = append(initializing, s.ID())
+		}
+	}
+	if len(initializing) > 0 {
+		return utils.StackError(nil, "shards %v are initializing", initializing)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datanode
+
+import (
+	"errors"
+
+	m3Shard "github.com/m3db/m3/src/cluster/shard"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	aresShard "github.com/uber/aresdb/cluster/shard"
+	"github.com/uber/aresdb/cluster/topology"
+	"github.com/uber/aresdb/datanode/bootstrap"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("datanode readiness checks", func() {
+	ginkgo.It("checkSchemaLoaded should work", func() {
+		d := &dataNode{}
+		Ω(d.checkSchemaLoaded()).ShouldNot(BeNil())
+		d.schemaLoaded = 1
+		Ω(d.checkSchemaLoaded()).Should(BeNil())
+	})
+
+	ginkgo.It("checkTableShardsBootstrapped should work", func() {
+		newTableShard := func(state bootstrap.BootstrapState) *memstore.TableShard {
+			return &memstore.TableShard{BootstrapState: state}
+		}
+		tableShards := map[string]*memstore.TableShard{
+			"fact_0": newTableShard(bootstrap.Bootstrapped),
+			"fact_1": newTableShard(bootstrap.Bootstrapping),
+			"dim_0":  newTableShard(bootstrap.Bootstrapped),
+		}
+
+		memStore := new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memCom.TableSchema{
+			"fact": {Schema: metaCom.Table{Name: "fact", IsFactTable: true}},
+			"dim":  {Schema: metaCom.Table{Name: "dim"}},
+		})
+		for _, key := range []struct {
+			table   string
+			shardID int
+			name    string
+		}{{"fact", 0, "fact_0"}, {"fact", 1, "fact_1"}, {"dim", 0, "dim_0"}} {
+			tableShard := tableShards[key.name]
+			memStore.On("GetTableShard", key.table, key.shardID).Return(tableShard, nil).
+				Run(func(arguments mock.Arguments) {
+					tableShard.Users.Add(1)
+				})
+		}
+		memStore.On("GetTableShard", mock.Anything, mock.Anything).Return(nil, errors.New("table shard not found"))
+
+		Ω(checkTableShardsBootstrapped(memStore, nil)).Should(BeNil())
+		Ω(checkTableShardsBootstrapped(memStore, []uint32{0})).Should(BeNil())
+
+		err := checkTableShardsBootstrapped(memStore, []uint32{0, 1, 2})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("2 table shards not bootstrapped: fact_1, fact_2"))
+	})
+
+	ginkgo.It("checkHostShardsAvailable should work", func() {
+		host0 := topology.NewHost("instance0", "http://host0:9374")
+		host1 := topology.NewHost("instance1", "http://host1:9374")
+		topoMap := topology.NewStaticMap(topology.NewStaticOptions().
+			SetReplicas(1).
+			SetHostShardSets([]topology.HostShardSet{
+				topology.NewHostShardSet(host0, aresShard.NewShardSet([]m3Shard.Shard{
+					m3Shard.NewShard(0).SetState(m3Shard.Available),
+				})),
+				topology.NewHostShardSet(host1, aresShard.NewShardSet([]m3Shard.Shard{
+					m3Shard.NewShard(0).SetState(m3Shard.Initializing),
+					m3Shard.NewShard(1).SetState(m3Shard.Initializing),
+				})),
+			}).SetShardSet(aresShard.NewShardSet([]m3Shard.Shard{
+			m3Shard.NewShard(0),
+			m3Shard.NewShard(1),
+		})))
+
+		Ω(checkHostShardsAvailable(topoMap, "instance0")).Should(BeNil())
+
+		err := checkHostShardsAvailable(topoMap, "instance1")
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("shards [0 1] are initializing"))
+
+		err = checkHostShardsAvailable(topoMap, "instance2")
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("host instance2 not found in placement"))
+
+		Ω(checkHostShardsAvailable(nil, "instance0")).ShouldNot(BeNil())
+	})
+})
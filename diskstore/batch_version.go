@@ -0,0 +1,93 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// Archive batch versions are replaced in the following steps so that a crash at any step never
+// leaves metastore pointing at missing files:
+//   1. All column files of the new version are written and synced.
+//   2. The new version is committed by CommitBatchVersion, which durably writes the manifest of
+//      the version into the batch dir after making the column files durable.
+//   3. The new version is recorded in metastore, which flips the active version.
+//   4. Older versions are deleted.
+// Versions without manifest are either being written or written before manifest was introduced,
+// the latter are only valid if recorded in metastore.
+
+// batchVersionManifestFile is the name of the manifest file in the batch dir.
+const batchVersionManifestFile = "manifest.json"
+
+// BatchVersion identifies an archive batch version on disk.
+type BatchVersion struct {
+	BatchID int
+	Version uint32
+	SeqNum  uint32
+}
+
+// Before returns whether the batch version is older than the other version of the same batch.
+func (v BatchVersion) Before(other BatchVersion) bool {
+	return v.Version < other.Version || (v.Version == other.Version && v.SeqNum < other.SeqNum)
+}
+
+// BatchVersionManifest is the commit record of an archive batch version.
+type BatchVersionManifest struct {
+	// number of rows of the batch.
+	Size int `json:"size"`
+	// ids of columns with vector party files in the batch version.
+	Columns []int `json:"columns"`
+}
+
+// sortBatchVersions sorts batch versions by batch id and then from old to new.
+func sortBatchVersions(versions []BatchVersion) {
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].BatchID != versions[j].BatchID {
+			return versions[i].BatchID < versions[j].BatchID
+		}
+		return versions[i].Before(versions[j])
+	})
+}
+
+// parseBatchVersion parses the name of an archive batch dir. ok is false if the name is not a
+// valid batch dir name.
+func parseBatchVersion(name string) (version BatchVersion, ok bool) {
+	batchIDStr, batchVersion, seqNum, err := ParseBatchIDAndVersionName(name)
+	if err != nil {
+		return
+	}
+	batchIDTime, err := time.Parse(timeFormatForBatchID, batchIDStr)
+	if err != nil {
+		return
+	}
+	return BatchVersion{
+		BatchID: int(batchIDTime.Unix() / 86400),
+		Version: batchVersion,
+		SeqNum:  seqNum,
+	}, true
+}
+
+// unmarshalBatchVersionManifest unmarshals the manifest read from path.
+func unmarshalBatchVersionManifest(data []byte, path string) (*BatchVersionManifest, error) {
+	var manifest BatchVersionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, utils.StackError(err, "Failed to unmarshal batch version manifest: %s", path)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,101 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("BatchVersion", func() {
+	prefix := "/tmp/testBatchVersionSuite"
+	table := "myTable"
+	shard := 1
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	testDiskStore := func(ds DiskStore) {
+		for _, version := range []BatchVersion{
+			{BatchID: 17801, Version: 100},
+			{BatchID: 17800, Version: 100, SeqNum: 1},
+			{BatchID: 17800, Version: 100},
+			{BatchID: 17800, Version: 99, SeqNum: 2},
+		} {
+			writer, err := ds.OpenVectorPartyFileForWrite(table, 2, shard, version.BatchID, version.Version,
+				version.SeqNum)
+			Ω(err).Should(BeNil())
+			Ω(writer.Close()).Should(BeNil())
+		}
+
+		_, err := ds.ReadBatchVersionManifest(table, shard, 17800, 100, 1)
+		Ω(err).Should(Equal(os.ErrNotExist))
+
+		manifest := BatchVersionManifest{Size: 10, Columns: []int{2}}
+		Ω(ds.CommitBatchVersion(table, shard, 17800, 100, 1, manifest)).Should(BeNil())
+		committed, err := ds.ReadBatchVersionManifest(table, shard, 17800, 100, 1)
+		Ω(err).Should(BeNil())
+		Ω(*committed).Should(Equal(manifest))
+
+		// manifest is not a vector party file.
+		Ω(ds.ListArchiveBatchVectorPartyFiles(table, shard, 17800, 100, 1)).Should(Equal([]int{2}))
+
+		Ω(ds.ListBatchVersions(table, shard)).Should(Equal([]BatchVersion{
+			{BatchID: 17800, Version: 99, SeqNum: 2},
+			{BatchID: 17800, Version: 100},
+			{BatchID: 17800, Version: 100, SeqNum: 1},
+			{BatchID: 17801, Version: 100},
+		}))
+
+		Ω(ds.DeleteBatchVersion(table, shard, 17800, 100, 1)).Should(BeNil())
+		_, err = ds.ReadBatchVersionManifest(table, shard, 17800, 100, 1)
+		Ω(err).Should(Equal(os.ErrNotExist))
+		Ω(ds.ListBatchVersions(table, shard)).Should(HaveLen(3))
+	}
+
+	ginkgo.It("works for LocalDiskStore", func() {
+		os.RemoveAll(prefix)
+		ds := NewLocalDiskStore(prefix)
+		Ω(ds.ListBatchVersions(table, shard)).Should(BeEmpty())
+		testDiskStore(ds)
+	})
+
+	ginkgo.It("works for RemoteDiskStore", func() {
+		os.RemoveAll(prefix)
+		ds, err := NewRemoteDiskStore(prefix, common.DiskStoreConfig{
+			ObjectStore: common.ObjectStoreConfig{
+				Prefix:         "ares",
+				CacheSizeBytes: 1 << 20,
+			},
+		}, NewMemoryObjectStore())
+		Ω(err).Should(BeNil())
+		Ω(ds.ListBatchVersions(table, shard)).Should(BeEmpty())
+		testDiskStore(ds)
+	})
+
+	ginkgo.It("parses batch versions", func() {
+		version, ok := parseBatchVersion("2018-09-26_1499971253-3")
+		Ω(ok).Should(BeTrue())
+		Ω(version).Should(Equal(BatchVersion{BatchID: 17800, Version: 1499971253, SeqNum: 3}))
+		_, ok = parseBatchVersion("bad")
+		Ω(ok).Should(BeFalse())
+		_, err := unmarshalBatchVersionManifest([]byte("{"), "path")
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,325 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// ChecksumFileHeader is the magic header written into the beginning of each checksum file.
+const ChecksumFileHeader uint32 = 0xC5C32C01
+
+// defaultChecksumBlockSize is the block size used for per block checksums if not configured.
+const defaultChecksumBlockSize = 4 * 1024 * 1024
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError is returned when the content of a vector party file does not match
+// the checksum recorded when it was written.
+type CorruptionError struct {
+	// Path of the corrupted file.
+	Path string
+	// Offset of the first block that failed verification, -1 if the file size mismatches.
+	Offset int64
+	// Expected checksum recorded on write.
+	Expected uint32
+	// Actual checksum computed on read.
+	Actual uint32
+}
+
+func (e *CorruptionError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("corrupted file %s: file size does not match checksum file", e.Path)
+	}
+	return fmt.Sprintf("corrupted file %s: crc32c mismatch at offset %d, expected %#x, actual %#x",
+		e.Path, e.Offset, e.Expected, e.Actual)
+}
+
+// IsCorruptionError tells whether the error is caused by checksum verification failure.
+func IsCorruptionError(err error) bool {
+	_, ok := err.(*CorruptionError)
+	return ok
+}
+
+// fileChecksum stores the whole file checksum as well as checksums of each block.
+// On disk format (little endian):
+//
+//	[uint32 header][uint32 block size][uint64 file size][uint32 file crc][uint32 num blocks][uint32 block crc]...
+type fileChecksum struct {
+	blockSize uint32
+	fileSize  uint64
+	fileCRC   uint32
+	blockCRCs []uint32
+}
+
+func getChecksumFilePath(path string) string {
+	return path + checksumFileSuffix
+}
+
+// writeTmp writes the checksum into a temp file next to path and returns the temp file path,
+// caller is responsible for renaming it to path.
+func (c *fileChecksum) writeTmp(path string) (string, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", utils.StackError(err, "Failed to open checksum file %s for write", tmpPath)
+	}
+	bufWriter := bufio.NewWriter(f)
+	writer := utils.NewStreamDataWriter(bufWriter)
+	if err = writer.WriteUint32(ChecksumFileHeader); err == nil {
+		if err = writer.WriteUint32(c.blockSize); err == nil {
+			if err = writer.WriteUint64(c.fileSize); err == nil {
+				if err = writer.WriteUint32(c.fileCRC); err == nil {
+					err = writer.WriteUint32(uint32(len(c.blockCRCs)))
+				}
+			}
+		}
+	}
+	for i := 0; err == nil && i < len(c.blockCRCs); i++ {
+		err = writer.WriteUint32(c.blockCRCs[i])
+	}
+	if err == nil {
+		err = bufWriter.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", utils.StackError(err, "Failed to write checksum file %s", tmpPath)
+	}
+	return tmpPath, nil
+}
+
+// readFileChecksum reads the checksum file for the given data file. It returns nil
+// without error if the data file does not have a checksum file, e.g. written by old versions.
+func readFileChecksum(path string) (*fileChecksum, error) {
+	checksumFilePath := getChecksumFilePath(path)
+	f, err := os.Open(checksumFilePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to open checksum file %s", checksumFilePath)
+	}
+	defer f.Close()
+
+	reader := utils.NewStreamDataReader(bufio.NewReader(f))
+	header, err := reader.ReadUint32()
+	if err != nil || header != ChecksumFileHeader {
+		return nil, &CorruptionError{Path: checksumFilePath, Offset: -1}
+	}
+
+	c := &fileChecksum{}
+	var numBlocks uint32
+	if c.blockSize, err = reader.ReadUint32(); err == nil {
+		if c.fileSize, err = reader.ReadUint64(); err == nil {
+			if c.fileCRC, err = reader.ReadUint32(); err == nil {
+				numBlocks, err = reader.ReadUint32()
+			}
+		}
+	}
+	if err != nil {
+		return nil, &CorruptionError{Path: checksumFilePath, Offset: -1}
+	}
+
+	c.blockCRCs = make([]uint32, numBlocks)
+	for i := range c.blockCRCs {
+		if c.blockCRCs[i], err = reader.ReadUint32(); err != nil {
+			return nil, &CorruptionError{Path: checksumFilePath, Offset: -1}
+		}
+	}
+	return c, nil
+}
+
+// checksumWriter computes the file and block checksums while writing a vector party file
+// and persists them into the checksum file on close.
+type checksumWriter struct {
+	file      *os.File
+	path      string
+	blockSize int
+	fileCRC   hash.Hash32
+	blockCRC  hash.Hash32
+	// number of bytes written into current block.
+	blockFill int
+	checksum  fileChecksum
+}
+
+func newChecksumWriter(file *os.File, path string, blockSize int) *checksumWriter {
+	if blockSize <= 0 {
+		blockSize = defaultChecksumBlockSize
+	}
+	return &checksumWriter{
+		file:      file,
+		path:      path,
+		blockSize: blockSize,
+		fileCRC:   crc32.New(crc32cTable),
+		blockCRC:  crc32.New(crc32cTable),
+		checksum: fileChecksum{
+			blockSize: uint32(blockSize),
+		},
+	}
+}
+
+// Write implements io.Writer.
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.update(p[:n])
+	return n, err
+}
+
+func (w *checksumWriter) update(p []byte) {
+	w.fileCRC.Write(p)
+	w.checksum.fileSize += uint64(len(p))
+	for len(p) > 0 {
+		toWrite := w.blockSize - w.blockFill
+		if toWrite > len(p) {
+			toWrite = len(p)
+		}
+		w.blockCRC.Write(p[:toWrite])
+		w.blockFill += toWrite
+		p = p[toWrite:]
+		if w.blockFill == w.blockSize {
+			w.checksum.blockCRCs = append(w.checksum.blockCRCs, w.blockCRC.Sum32())
+			w.blockCRC.Reset()
+			w.blockFill = 0
+		}
+	}
+}
+
+// Close syncs and closes the underlying file and writes the checksum file. If the underlying file was
+// opened at a temp path, it's renamed to the path afterwards. Both files are durable once Close returns
+// without error.
+func (w *checksumWriter) Close() error {
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return utils.StackError(err, "Failed to sync %s", w.file.Name())
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if w.blockFill > 0 {
+		w.checksum.blockCRCs = append(w.checksum.blockCRCs, w.blockCRC.Sum32())
+	}
+	w.checksum.fileCRC = w.fileCRC.Sum32()
+	checksumFilePath := getChecksumFilePath(w.path)
+	tmpChecksumFilePath, err := w.checksum.writeTmp(checksumFilePath)
+	if err != nil {
+		return err
+	}
+	// The old checksum file is removed before the data file is replaced and the new one is renamed into
+	// place afterwards, so that even on crash a data file is never paired with the checksum of another
+	// version, at worst it's left without checksum.
+	if err = os.Remove(checksumFilePath); err != nil && !os.IsNotExist(err) {
+		os.Remove(tmpChecksumFilePath)
+		return utils.StackError(err, "Failed to remove checksum file %s", checksumFilePath)
+	}
+	if w.file.Name() != w.path {
+		if err = os.Rename(w.file.Name(), w.path); err != nil {
+			os.Remove(tmpChecksumFilePath)
+			return utils.StackError(err, "Failed to rename %s to %s", w.file.Name(), w.path)
+		}
+	}
+	if err = os.Rename(tmpChecksumFilePath, checksumFilePath); err != nil {
+		return utils.StackError(err, "Failed to rename checksum file %s to %s", tmpChecksumFilePath, checksumFilePath)
+	}
+	return nil
+}
+
+// verifyFile verifies the content of the file against its checksum file block by block.
+// limiter if not nil will be called with number of bytes read after each block to throttle the reads.
+// It returns the number of bytes verified.
+func verifyFile(path string, limiter func(bytes int)) (int64, error) {
+	verified, opened, err := verifyFileOnce(path, limiter)
+	if IsCorruptionError(err) && opened != nil {
+		// the checksum read may belong to a newer version of the data file which replaced the opened one
+		// concurrently, verify again in that case.
+		if current, statErr := os.Stat(path); statErr == nil && !os.SameFile(opened, current) {
+			verified, _, err = verifyFileOnce(path, limiter)
+		}
+	}
+	return verified, err
+}
+
+// verifyFileOnce verifies the file and returns the info of the data file opened for verification.
+func verifyFileOnce(path string, limiter func(bytes int)) (int64, os.FileInfo, error) {
+	// The data file is opened before reading the checksum file. As writers remove the old checksum file
+	// before replacing the data file, the checksum read is either of the opened data file, or of a newer
+	// version which replaced it.
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil, err
+	} else if err != nil {
+		return 0, nil, utils.StackError(err, "Failed to open file %s for verification", path)
+	}
+	defer f.Close()
+	opened, err := f.Stat()
+	if err != nil {
+		return 0, nil, utils.StackError(err, "Failed to stat file %s for verification", path)
+	}
+
+	checksum, err := readFileChecksum(path)
+	if err != nil || checksum == nil {
+		return 0, opened, err
+	}
+	verified, err := verifyOpenedFile(f, path, checksum, limiter)
+	return verified, opened, err
+}
+
+// verifyOpenedFile verifies the content of the opened file against the checksum.
+func verifyOpenedFile(f *os.File, path string, checksum *fileChecksum, limiter func(bytes int)) (int64, error) {
+
+	fileCRC := crc32.New(crc32cTable)
+	buffer := make([]byte, checksum.blockSize)
+	var offset int64
+	for i, expected := range checksum.blockCRCs {
+		n, err := io.ReadFull(f, buffer)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return offset, utils.StackError(err, "Failed to read file %s for verification", path)
+		}
+		// only the last block can be partial.
+		if n == 0 || (n < len(buffer) && i != len(checksum.blockCRCs)-1) {
+			return offset, &CorruptionError{Path: path, Offset: -1}
+		}
+		actual := crc32.Checksum(buffer[:n], crc32cTable)
+		if actual != expected {
+			return offset, &CorruptionError{Path: path, Offset: offset, Expected: expected, Actual: actual}
+		}
+		fileCRC.Write(buffer[:n])
+		offset += int64(n)
+		if limiter != nil {
+			limiter(n)
+		}
+	}
+
+	// make sure there is no extra data after the last block.
+	if n, _ := f.Read(buffer[:1]); n > 0 || uint64(offset) != checksum.fileSize {
+		return offset, &CorruptionError{Path: path, Offset: -1}
+	}
+
+	if actual := fileCRC.Sum32(); actual != checksum.fileCRC {
+		return offset, &CorruptionError{Path: path, Offset: 0, Expected: checksum.fileCRC, Actual: actual}
+	}
+	return offset, nil
+}
@@ -0,0 +1,165 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("checksum", func() {
+	prefix := "/tmp/testDiskStoreChecksumSuite"
+	table := "myTable"
+	shard := 1
+	batchID := 17800
+	batchVersion := uint32(1499971253)
+	columnID := 2
+
+	var l LocalDiskStore
+
+	writeFile := func(data []byte) string {
+		writer, err := l.OpenVectorPartyFileForWrite(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		_, err = writer.Write(data)
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+		return GetPathForTableArchiveBatchColumnFile(prefix, table, shard, daysSinceEpochToTimeStr(batchID),
+			batchVersion, 0, columnID)
+	}
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		os.MkdirAll(prefix, 0755)
+		l = LocalDiskStore{
+			rootPath: prefix,
+			diskStoreConfig: common.DiskStoreConfig{
+				ChecksumBlockSize: 4,
+			},
+		}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	ginkgo.It("writes checksum file and verifies on read", func() {
+		data := []byte("0123456789")
+		path := writeFile(data)
+
+		checksum, err := readFileChecksum(path)
+		Ω(err).Should(BeNil())
+		Ω(checksum.blockSize).Should(BeEquivalentTo(4))
+		Ω(checksum.fileSize).Should(BeEquivalentTo(10))
+		Ω(checksum.blockCRCs).Should(HaveLen(3))
+
+		reader, err := l.OpenVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		content, err := ioutil.ReadAll(reader)
+		Ω(err).Should(BeNil())
+		Ω(content).Should(Equal(data))
+		Ω(reader.Close()).Should(BeNil())
+
+		columns, err := l.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(Equal([]int{columnID}))
+	})
+
+	ginkgo.It("overwriting a file should truncate it", func() {
+		writeFile([]byte("0123456789"))
+		path := writeFile([]byte("abc"))
+		_, err := verifyFile(path, nil)
+		Ω(err).Should(BeNil())
+	})
+
+	ginkgo.It("files without checksum should be loaded", func() {
+		path := writeFile([]byte("0123456789"))
+		Ω(os.Remove(getChecksumFilePath(path))).Should(BeNil())
+		reader, err := l.OpenVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		reader.Close()
+	})
+
+	ginkgo.It("corrupted file should fail the load and be quarantined", func() {
+		path := writeFile([]byte("0123456789"))
+		Ω(ioutil.WriteFile(path, []byte("0123x56789"), 0644)).Should(BeNil())
+
+		_, err := l.OpenVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(IsCorruptionError(err)).Should(BeTrue())
+		Ω(err.(*CorruptionError).Offset).Should(BeEquivalentTo(4))
+
+		_, err = os.Stat(path)
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+		quarantined, _ := filepath.Glob(filepath.Join(GetPathForTableQuarantineDir(prefix, table, shard), "*"))
+		Ω(quarantined).Should(HaveLen(2))
+
+		_, err = l.OpenVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(Equal(os.ErrNotExist))
+	})
+
+	ginkgo.It("truncated file should be detected", func() {
+		path := writeFile([]byte("0123456789"))
+		Ω(ioutil.WriteFile(path, []byte("01234567"), 0644)).Should(BeNil())
+		_, err := verifyFile(path, nil)
+		Ω(IsCorruptionError(err)).Should(BeTrue())
+	})
+
+	ginkgo.It("VerifyTableShard should find corrupted files", func() {
+		writer, err := l.OpenSnapshotVectorPartyFileForWrite(table, shard, 1, 1, 0, 1)
+		Ω(err).Should(BeNil())
+		writer.Write([]byte("snapshot"))
+		Ω(writer.Close()).Should(BeNil())
+
+		numCorrupted, err := l.VerifyTableShard(table, shard, 0)
+		Ω(err).Should(BeNil())
+		Ω(numCorrupted).Should(Equal(0))
+
+		path := writeFile([]byte("0123456789"))
+		Ω(ioutil.WriteFile(path, []byte("9876543210"), 0644)).Should(BeNil())
+		numCorrupted, err = l.VerifyTableShard(table, shard, 1<<20)
+		Ω(err).Should(BeNil())
+		Ω(numCorrupted).Should(Equal(1))
+
+		numCorrupted, err = l.VerifyTableShard(table, shard, 0)
+		Ω(err).Should(BeNil())
+		Ω(numCorrupted).Should(Equal(0))
+	})
+
+	ginkgo.It("verification should retry if the data file is replaced concurrently", func() {
+		path := writeFile([]byte("0123456789"))
+		f, err := os.Open(path)
+		Ω(err).Should(BeNil())
+		defer f.Close()
+		opened, _ := f.Stat()
+
+		// new version is written after the old data file is opened.
+		writeFile([]byte("abcdefgh"))
+		checksum, err := readFileChecksum(path)
+		Ω(err).Should(BeNil())
+		_, err = verifyOpenedFile(f, path, checksum, nil)
+		Ω(IsCorruptionError(err)).Should(BeTrue())
+
+		current, _ := os.Stat(path)
+		Ω(os.SameFile(opened, current)).Should(BeFalse())
+		_, err = verifyFile(path, nil)
+		Ω(err).Should(BeNil())
+		_, err = os.Stat(getChecksumFilePath(path) + ".tmp")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	})
+})
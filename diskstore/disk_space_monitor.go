@@ -0,0 +1,290 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultDiskSpaceCheckInterval = 10 * time.Second
+	diskSpaceAlertTimeout         = 5 * time.Second
+)
+
+// DiskSpaceLevel is the watermark level of free disk space.
+type DiskSpaceLevel int
+
+const (
+	// DiskSpaceNormal means free space is above all watermarks.
+	DiskSpaceNormal DiskSpaceLevel = iota
+	// DiskSpaceLow means free space is below the soft watermark.
+	DiskSpaceLow
+	// DiskSpaceCritical means free space is below the hard watermark.
+	DiskSpaceCritical
+)
+
+// String returns the name of the level.
+func (l DiskSpaceLevel) String() string {
+	switch l {
+	case DiskSpaceLow:
+		return "low"
+	case DiskSpaceCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// DiskSpaceAlert is the payload posted to the alert webhook on watermark level changes.
+type DiskSpaceAlert struct {
+	Host          string `json:"host"`
+	Path          string `json:"path"`
+	Level         string `json:"level"`
+	PreviousLevel string `json:"previousLevel"`
+	FreeBytes     uint64 `json:"freeBytes"`
+	TotalBytes    uint64 `json:"totalBytes"`
+	Time          int64  `json:"time"`
+}
+
+// DiskQuotaAlert is the payload posted to the alert webhook on disk quota level changes of a table.
+type DiskQuotaAlert struct {
+	Host          string `json:"host"`
+	Table         string `json:"table"`
+	Level         string `json:"level"`
+	PreviousLevel string `json:"previousLevel"`
+	UsedBytes     int64  `json:"usedBytes"`
+	QuotaBytes    int64  `json:"quotaBytes"`
+	Time          int64  `json:"time"`
+}
+
+// EnumOverflowAlert is the payload posted to the alert webhook the first time new enum cases of
+// a column are mapped to the overflow case after reaching its enum cardinality cap.
+type EnumOverflowAlert struct {
+	Host               string `json:"host"`
+	Table              string `json:"table"`
+	Column             string `json:"column"`
+	EnumCardinalityCap int    `json:"enumCardinalityCap"`
+	Time               int64  `json:"time"`
+}
+
+// DiskSpaceMonitor periodically checks free space of the disk store root path against
+// the configured watermarks and notifies listeners on level changes. Levels are
+// re-evaluated on every check so recovery happens automatically when space frees up.
+type DiskSpaceMonitor struct {
+	sync.RWMutex
+	rootPath string
+	config   common.DiskSpaceConfig
+
+	level      DiskSpaceLevel
+	freeBytes  uint64
+	totalBytes uint64
+
+	listeners []func(level DiskSpaceLevel)
+
+	// for testing.
+	statfs     func(path string) (free, total uint64, err error)
+	httpClient *http.Client
+
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// NewDiskSpaceMonitor creates a DiskSpaceMonitor for the root path.
+func NewDiskSpaceMonitor(rootPath string, config common.DiskSpaceConfig) *DiskSpaceMonitor {
+	return &DiskSpaceMonitor{
+		rootPath:   rootPath,
+		config:     config,
+		statfs:     statfs,
+		httpClient: &http.Client{Timeout: diskSpaceAlertTimeout},
+	}
+}
+
+// AddListener registers a function to be called with the new level on level changes.
+// Listeners are called synchronously from the checking goroutine.
+func (m *DiskSpaceMonitor) AddListener(listener func(level DiskSpaceLevel)) {
+	m.Lock()
+	defer m.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Level returns the level as of the last check.
+func (m *DiskSpaceMonitor) Level() DiskSpaceLevel {
+	m.RLock()
+	defer m.RUnlock()
+	return m.level
+}
+
+// IsWriteProtected tells whether writes to the disk store should be rejected since
+// free space is below the hard watermark.
+func (m *DiskSpaceMonitor) IsWriteProtected() bool {
+	return m.Level() >= DiskSpaceCritical
+}
+
+// CheckReadiness returns an error when free space is below the hard watermark, so that the server is not
+// ready to serve.
+func (m *DiskSpaceMonitor) CheckReadiness() error {
+	if level := m.Level(); level >= DiskSpaceCritical {
+		return utils.StackError(nil, "free disk space of %s is %s, below the hard watermark", m.rootPath, level)
+	}
+	return nil
+}
+
+// Start does an initial check and starts checking periodically in background.
+func (m *DiskSpaceMonitor) Start() {
+	m.Check()
+
+	interval := defaultDiskSpaceCheckInterval
+	if m.config.CheckIntervalSeconds > 0 {
+		interval = time.Duration(m.config.CheckIntervalSeconds) * time.Second
+	}
+
+	m.stopChan = make(chan struct{})
+	m.doneChan = make(chan struct{})
+	go func() {
+		defer close(m.doneChan)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Check()
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background checking.
+func (m *DiskSpaceMonitor) Stop() {
+	if m.stopChan == nil {
+		return
+	}
+	close(m.stopChan)
+	<-m.doneChan
+	m.stopChan = nil
+}
+
+// Check checks free space, reports metrics and notifies listeners if the level changes.
+// If free space cannot be determined, the previous level is kept.
+func (m *DiskSpaceMonitor) Check() {
+	free, total, err := m.statfs(m.rootPath)
+	if err != nil {
+		utils.GetLogger().With("path", m.rootPath, "error", err.Error()).Error("Failed to check disk space")
+		return
+	}
+
+	level := m.evaluate(free, total)
+	utils.GetRootReporter().GetGauge(utils.DiskFreeBytes).Update(float64(free))
+	utils.GetRootReporter().GetGauge(utils.DiskSpaceWatermarkLevel).Update(float64(level))
+
+	m.Lock()
+	previousLevel := m.level
+	m.level, m.freeBytes, m.totalBytes = level, free, total
+	listeners := m.listeners
+	m.Unlock()
+
+	if level == previousLevel {
+		return
+	}
+
+	logger := utils.GetLogger().With("path", m.rootPath, "freeBytes", free, "totalBytes", total,
+		"level", level.String(), "previousLevel", previousLevel.String())
+	if level > previousLevel {
+		logger.Warn("Free disk space dropped below watermark")
+	} else {
+		logger.Info("Free disk space recovered")
+	}
+
+	for _, listener := range listeners {
+		listener(level)
+	}
+	m.sendAlert(DiskSpaceAlert{
+		Path:          m.rootPath,
+		Level:         level.String(),
+		PreviousLevel: previousLevel.String(),
+		FreeBytes:     free,
+		TotalBytes:    total,
+		Time:          utils.Now().Unix(),
+	})
+}
+
+// evaluate returns the level for the free and total bytes.
+func (m *DiskSpaceMonitor) evaluate(free, total uint64) DiskSpaceLevel {
+	if belowWatermark(free, total, m.config.HardWatermarkPercent, m.config.HardWatermarkBytes) {
+		return DiskSpaceCritical
+	}
+	if belowWatermark(free, total, m.config.SoftWatermarkPercent, m.config.SoftWatermarkBytes) {
+		return DiskSpaceLow
+	}
+	return DiskSpaceNormal
+}
+
+func belowWatermark(free, total uint64, percent float64, bytes int64) bool {
+	if bytes > 0 && free < uint64(bytes) {
+		return true
+	}
+	return percent > 0 && total > 0 && float64(free)*100 < percent*float64(total)
+}
+
+// sendAlert posts the alert to the webhook asynchronously if configured.
+func (m *DiskSpaceMonitor) sendAlert(alert DiskSpaceAlert) {
+	alert.Host, _ = os.Hostname()
+	m.postAlert(alert)
+}
+
+// SendDiskQuotaAlert posts the disk quota alert of a table to the webhook asynchronously if configured.
+func (m *DiskSpaceMonitor) SendDiskQuotaAlert(alert DiskQuotaAlert) {
+	alert.Host, _ = os.Hostname()
+	m.postAlert(alert)
+}
+
+// SendEnumOverflowAlert posts the enum overflow alert of a column to the webhook asynchronously if configured.
+func (m *DiskSpaceMonitor) SendEnumOverflowAlert(alert EnumOverflowAlert) {
+	alert.Host, _ = os.Hostname()
+	m.postAlert(alert)
+}
+
+func (m *DiskSpaceMonitor) postAlert(alert interface{}) {
+	if m.config.AlertWebhookURL == "" {
+		return
+	}
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+
+	go func() {
+		resp, err := m.httpClient.Post(m.config.AlertWebhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			utils.GetLogger().With("url", m.config.AlertWebhookURL, "error", err.Error()).
+				Error("Failed to send disk space alert")
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			utils.GetLogger().With("url", m.config.AlertWebhookURL, "status", resp.StatusCode).
+				Error("Failed to send disk space alert")
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("DiskSpaceMonitor", func() {
+	var free, total uint64
+	var statErr error
+
+	newMonitor := func(cfg common.DiskSpaceConfig) *DiskSpaceMonitor {
+		m := NewDiskSpaceMonitor("/tmp", cfg)
+		m.statfs = func(path string) (uint64, uint64, error) {
+			return free, total, statErr
+		}
+		return m
+	}
+
+	ginkgo.BeforeEach(func() {
+		free, total, statErr = 50, 100, nil
+	})
+
+	ginkgo.It("evaluates watermarks by percentage and bytes", func() {
+		m := newMonitor(common.DiskSpaceConfig{
+			SoftWatermarkPercent: 20,
+			HardWatermarkBytes:   5,
+		})
+		Ω(m.evaluate(50, 100)).Should(Equal(DiskSpaceNormal))
+		Ω(m.evaluate(19, 100)).Should(Equal(DiskSpaceLow))
+		Ω(m.evaluate(4, 100)).Should(Equal(DiskSpaceCritical))
+
+		// disabled watermarks.
+		m = newMonitor(common.DiskSpaceConfig{})
+		Ω(m.evaluate(0, 100)).Should(Equal(DiskSpaceNormal))
+	})
+
+	ginkgo.It("notifies listeners on level changes and recovers automatically", func() {
+		m := newMonitor(common.DiskSpaceConfig{
+			SoftWatermarkPercent: 20,
+			HardWatermarkPercent: 10,
+		})
+		var levels []DiskSpaceLevel
+		m.AddListener(func(level DiskSpaceLevel) {
+			levels = append(levels, level)
+		})
+
+		m.Check()
+		Ω(levels).Should(BeEmpty())
+
+		free = 15
+		m.Check()
+		Ω(m.Level()).Should(Equal(DiskSpaceLow))
+		Ω(m.IsWriteProtected()).Should(BeFalse())
+		Ω(m.CheckReadiness()).Should(BeNil())
+
+		free = 5
+		m.Check()
+		m.Check()
+		Ω(m.IsWriteProtected()).Should(BeTrue())
+		Ω(m.CheckReadiness()).ShouldNot(BeNil())
+
+		// keeps previous level if free space cannot be determined.
+		statErr = errors.New("statfs error")
+		m.Check()
+		Ω(m.IsWriteProtected()).Should(BeTrue())
+
+		free, statErr = 50, nil
+		m.Check()
+		Ω(m.IsWriteProtected()).Should(BeFalse())
+		Ω(levels).Should(Equal([]DiskSpaceLevel{DiskSpaceLow, DiskSpaceCritical, DiskSpaceNormal}))
+	})
+
+	ginkgo.It("posts alerts to webhook", func() {
+		alerts := make(chan DiskSpaceAlert, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var alert DiskSpaceAlert
+			json.NewDecoder(r.Body).Decode(&alert)
+			alerts <- alert
+		}))
+		defer server.Close()
+
+		m := newMonitor(common.DiskSpaceConfig{
+			SoftWatermarkPercent: 20,
+			AlertWebhookURL:      server.URL,
+		})
+		free = 10
+		m.Check()
+
+		var alert DiskSpaceAlert
+		Eventually(alerts).Should(Receive(&alert))
+		Ω(alert.Level).Should(Equal("low"))
+		Ω(alert.PreviousLevel).Should(Equal("normal"))
+		Ω(alert.FreeBytes).Should(Equal(uint64(10)))
+		Ω(alert.TotalBytes).Should(Equal(uint64(100)))
+	})
+
+	ginkgo.It("starts and stops", func() {
+		m := newMonitor(common.DiskSpaceConfig{HardWatermarkPercent: 10})
+		free = 5
+		m.Start()
+		Ω(m.IsWriteProtected()).Should(BeTrue())
+		m.Stop()
+		m.Stop()
+	})
+
+	ginkgo.It("RemoteDiskStore shrinks cache when disk space is low", func() {
+		prefix := "/tmp/testDiskSpaceMonitor"
+		defer os.RemoveAll(prefix)
+		ds, err := NewRemoteDiskStore(prefix, common.DiskStoreConfig{
+			ObjectStore: common.ObjectStoreConfig{CacheSizeBytes: 10},
+		}, NewMemoryObjectStore())
+		Ω(err).Should(BeNil())
+		r := ds.(*RemoteDiskStore)
+		r.cache.add("a", 4)
+		r.cache.add("b", 4)
+
+		r.OnDiskSpaceLevelChange(DiskSpaceNormal)
+		Ω(r.cache.lru.Len()).Should(Equal(2))
+		r.OnDiskSpaceLevelChange(DiskSpaceLow)
+		Ω(r.cache.entries).ShouldNot(HaveKey("a"))
+		Ω(r.cache.entries).Should(HaveKey("b"))
+	})
+})
@@ -0,0 +1,229 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// ErrDiskUsageNotSupported is returned by disk stores not keeping table shards on local disk.
+var ErrDiskUsageNotSupported = errors.New("disk usage not supported")
+
+// DiskUsageScanner is implemented by disk stores that keep files of table shards on local disk.
+type DiskUsageScanner interface {
+	// Returns the total bytes of redo logs, snapshots and archive batches of the table shard on local disk.
+	ScanTableShardDiskUsage(table string, shard int) (int64, error)
+}
+
+// ScanTableShardDiskUsage : Returns the total bytes of all files under the table shard directory.
+func (l LocalDiskStore) ScanTableShardDiskUsage(table string, shard int) (int64, error) {
+	var bytes int64
+	err := filepath.Walk(getPathForTableShard(l.rootPath, table, shard), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to scan disk usage of table %s shard %d", table, shard)
+	}
+	return bytes, nil
+}
+
+// ScanTableShardDiskUsage is not supported by RemoteDiskStore since snapshots and archive batches are
+// stored in the object store, so disk usage of its table shards are not tracked.
+func (r *RemoteDiskStore) ScanTableShardDiskUsage(table string, shard int) (int64, error) {
+	return 0, ErrDiskUsageNotSupported
+}
+
+// DiskUsage tracks bytes used on local disk by table shard. Usage of a table shard is initialized by
+// scanning its directory when the shard is loaded, increased by bytes written and rescanned lazily
+// after files are deleted, e.g. by archiving, purge or redo log cleanup.
+type DiskUsage struct {
+	sync.Mutex
+	// keyed by table then shard.
+	shards map[string]map[int]*shardDiskUsage
+}
+
+type shardDiskUsage struct {
+	bytes int64
+	// whether files were deleted since last scan.
+	stale bool
+	scan  func() (int64, error)
+}
+
+var diskUsage = NewDiskUsage()
+
+// GetDiskUsage returns the disk usage of table shards of disk stores created by NewDiskStore.
+func GetDiskUsage() *DiskUsage {
+	return diskUsage
+}
+
+// NewDiskUsage creates an empty DiskUsage.
+func NewDiskUsage() *DiskUsage {
+	return &DiskUsage{
+		shards: make(map[string]map[int]*shardDiskUsage),
+	}
+}
+
+// ScanTableShardDiskUsage starts tracking disk usage of the table shard if ds keeps files on local disk.
+// It's a no-op for other disk stores.
+func ScanTableShardDiskUsage(ds DiskStore, table string, shard int) error {
+	scanner, ok := ds.(DiskUsageScanner)
+	if !ok {
+		return nil
+	}
+	_, err := scanner.ScanTableShardDiskUsage(table, shard)
+	if err == ErrDiskUsageNotSupported {
+		return nil
+	}
+	return err
+}
+
+// track scans the table shard and tracks its usage from now on.
+func (u *DiskUsage) track(table string, shard int, scan func() (int64, error)) (int64, error) {
+	bytes, err := scan()
+	if err != nil {
+		return 0, err
+	}
+	u.Lock()
+	defer u.Unlock()
+	shards, ok := u.shards[table]
+	if !ok {
+		shards = make(map[int]*shardDiskUsage)
+		u.shards[table] = shards
+	}
+	shards[shard] = &shardDiskUsage{bytes: bytes, scan: scan}
+	updateDiskUsageGauge(table, shard, bytes)
+	return bytes, nil
+}
+
+// add adds bytes written to the table shard if it's tracked.
+func (u *DiskUsage) add(table string, shard int, bytes int64) {
+	u.Lock()
+	defer u.Unlock()
+	if usage := u.shards[table][shard]; usage != nil {
+		usage.bytes += bytes
+		updateDiskUsageGauge(table, shard, usage.bytes)
+	}
+}
+
+// invalidate marks the table shard to be rescanned since files were deleted.
+func (u *DiskUsage) invalidate(table string, shard int) {
+	u.Lock()
+	defer u.Unlock()
+	if usage := u.shards[table][shard]; usage != nil {
+		usage.stale = true
+	}
+}
+
+// deleteShard stops tracking the table shard.
+func (u *DiskUsage) deleteShard(table string, shard int) {
+	u.Lock()
+	defer u.Unlock()
+	delete(u.shards[table], shard)
+	if len(u.shards[table]) == 0 {
+		delete(u.shards, table)
+	}
+}
+
+// DeleteTable stops tracking all shards of the table, e.g. when the table is deleted.
+func (u *DiskUsage) DeleteTable(table string) {
+	u.Lock()
+	defer u.Unlock()
+	delete(u.shards, table)
+}
+
+// TableBytes returns the bytes used by tracked shards of the table, rescanning shards with files deleted
+// since last scan. Shards failed to rescan keep their previous usage.
+func (u *DiskUsage) TableBytes(table string) int64 {
+	u.Lock()
+	var stale []int
+	for shard, usage := range u.shards[table] {
+		if usage.stale {
+			stale = append(stale, shard)
+		}
+	}
+	u.Unlock()
+
+	for _, shard := range stale {
+		u.rescan(table, shard)
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	var bytes int64
+	for _, usage := range u.shards[table] {
+		bytes += usage.bytes
+	}
+	return bytes
+}
+
+// rescan rescans the table shard without holding the lock during the scan.
+func (u *DiskUsage) rescan(table string, shard int) {
+	u.Lock()
+	usage := u.shards[table][shard]
+	if usage == nil || !usage.stale {
+		u.Unlock()
+		return
+	}
+	usage.stale = false
+	scan := usage.scan
+	u.Unlock()
+
+	bytes, err := scan()
+	if err != nil {
+		utils.GetLogger().With("table", table, "shard", shard, "error", err.Error()).
+			Error("Failed to rescan disk usage")
+		u.invalidate(table, shard)
+		return
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	if usage = u.shards[table][shard]; usage != nil {
+		usage.bytes = bytes
+		updateDiskUsageGauge(table, shard, bytes)
+	}
+}
+
+// Snapshot returns the bytes used by table and shard as of last scan.
+func (u *DiskUsage) Snapshot() map[string]map[int]int64 {
+	u.Lock()
+	defer u.Unlock()
+	snapshot := make(map[string]map[int]int64, len(u.shards))
+	for table, shards := range u.shards {
+		snapshot[table] = make(map[int]int64, len(shards))
+		for shard, usage := range shards {
+			snapshot[table][shard] = usage.bytes
+		}
+	}
+	return snapshot
+}
+
+func updateDiskUsageGauge(table string, shard int, bytes int64) {
+	utils.GetReporter(table, shard).GetGauge(utils.DiskUsageBytes).Update(float64(bytes))
+}
@@ -0,0 +1,96 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"io"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("DiskUsage", func() {
+	prefix := "/tmp/testDiskStoreDiskUsageSuite"
+	table := "myTable"
+	shard := 1
+	batchID := 17800
+
+	var usage *DiskUsage
+	var ds DiskStore
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		os.MkdirAll(prefix, 0755)
+		usage = NewDiskUsage()
+		ds = NewInstrumentedDiskStore(LocalDiskStore{
+			rootPath:        prefix,
+			diskStoreConfig: common.DiskStoreConfig{},
+		}, NewIOStats(), usage)
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	writeVectorParty := func(batchVersion uint32, data string) {
+		writer, err := ds.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		_, err = io.WriteString(writer, data)
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+	}
+
+	ginkgo.It("tracks usage of scanned table shards only", func() {
+		writeVectorParty(1, "0123456789")
+		Ω(usage.TableBytes(table)).Should(BeZero())
+
+		Ω(ScanTableShardDiskUsage(ds, table, shard)).Should(BeNil())
+		scanned := usage.TableBytes(table)
+		// vector party files are followed by checksums.
+		Ω(scanned).Should(BeNumerically(">", 10))
+
+		writeVectorParty(2, "01234")
+		Ω(usage.TableBytes(table)).Should(Equal(scanned + 5))
+		Ω(usage.Snapshot()).Should(Equal(map[string]map[int]int64{table: {shard: scanned + 5}}))
+	})
+
+	ginkgo.It("rescans table shards after deletion", func() {
+		Ω(ScanTableShardDiskUsage(ds, table, shard)).Should(BeNil())
+		writeVectorParty(1, "0123456789")
+		writeVectorParty(2, "01234")
+		local := LocalDiskStore{rootPath: prefix}
+		before, err := local.ScanTableShardDiskUsage(table, shard)
+		Ω(err).Should(BeNil())
+
+		Ω(ds.DeleteBatchVersions(table, shard, batchID, 1, 0)).Should(BeNil())
+		after, err := local.ScanTableShardDiskUsage(table, shard)
+		Ω(err).Should(BeNil())
+		Ω(after).Should(BeNumerically("<", before))
+		Ω(usage.TableBytes(table)).Should(Equal(after))
+
+		Ω(ds.DeleteTableShard(table, shard)).Should(BeNil())
+		Ω(usage.TableBytes(table)).Should(BeZero())
+		Ω(usage.Snapshot()).Should(BeEmpty())
+	})
+
+	ginkgo.It("does not track disk stores not keeping files locally", func() {
+		Ω(ScanTableShardDiskUsage(&RemoteDiskStore{}, table, shard)).Should(BeNil())
+		remote := NewInstrumentedDiskStore(&RemoteDiskStore{}, NewIOStats(), usage)
+		Ω(ScanTableShardDiskUsage(remote, table, shard)).Should(BeNil())
+		Ω(usage.Snapshot()).Should(BeEmpty())
+	})
+})
@@ -17,9 +17,24 @@ package diskstore
 import (
 	"io"
 
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/utils"
 )
 
+// Supported disk store types.
+const (
+	// DiskStoreTypeLocal stores all files on local disk.
+	DiskStoreTypeLocal = "local"
+	// DiskStoreTypeObjectStore stores snapshot and archive batch files in S3 compatible object store.
+	DiskStoreTypeObjectStore = "object_store"
+)
+
+// DiskSpaceListener is implemented by disk stores that can release local disk space on demand,
+// e.g. by evicting cached files.
+type DiskSpaceListener interface {
+	OnDiskSpaceLevelChange(level DiskSpaceLevel)
+}
+
 // DiskStore defines the interface for reading/writing redo logs, snapshot files, and archived vector party files.
 type DiskStore interface {
 	// Table shard level operation
@@ -92,8 +107,50 @@ type DiskStore interface {
 	// Deletes all old batches with the specified batchID that have version lower than or equal to the specified batch
 	// version. All columns of those batches will be deleted.
 	DeleteBatchVersions(table string, shard, batchID int, batchVersion uint32, seqNum uint32) error
+	// Deletes the batch with the specified batchID, batch version and seq num only. All columns of the batch
+	// will be deleted.
+	DeleteBatchVersion(table string, shard, batchID int, batchVersion uint32, seqNum uint32) error
+	// Commits the batch version after all its column files are written by durably writing its manifest.
+	CommitBatchVersion(table string, shard, batchID int, batchVersion uint32, seqNum uint32,
+		manifest BatchVersionManifest) error
+	// Reads the manifest of the batch version. Returns os.ErrNotExist if the batch version is not committed.
+	ReadBatchVersionManifest(table string, shard, batchID int, batchVersion uint32,
+		seqNum uint32) (*BatchVersionManifest, error)
+	// Returns all archive batch versions on disk, committed or not, sorted by batch id and then from old to new.
+	ListBatchVersions(table string, shard int) ([]BatchVersion, error)
 	// Deletes all batches within range [batchIDStart, batchIDEnd)
 	DeleteBatches(table string, shard, batchIDStart, batchIDEnd int) (int, error)
 	// Deletes all batches of the specified column.
 	DeleteColumn(table string, column, shard int) error
+
+	// Integrity check.
+
+	// Verifies all snapshot and archived vector party files of a table shard against their checksums with reads
+	// throttled to bytesPerSec (no throttling if not positive). Corrupted files are quarantined.
+	// Returns the number of corrupted files found.
+	VerifyTableShard(table string, shard int, bytesPerSec int64) (int, error)
+}
+
+// NewDiskStore creates the DiskStore of the type specified in config.
+// I/O of the disk store is recorded into the stats returned by GetIOStats, and disk usage of its table
+// shards into the usage returned by GetDiskUsage.
+func NewDiskStore(rootPath string, cfg common.DiskStoreConfig) (DiskStore, error) {
+	switch cfg.Type {
+	case "", DiskStoreTypeLocal:
+		return NewInstrumentedDiskStore(LocalDiskStore{
+			rootPath:        rootPath,
+			diskStoreConfig: cfg,
+		}, ioStats, diskUsage), nil
+	case DiskStoreTypeObjectStore:
+		objectStore, err := NewS3ObjectStore(cfg.ObjectStore)
+		if err != nil {
+			return nil, err
+		}
+		ds, err := NewRemoteDiskStore(rootPath, cfg, objectStore)
+		if err != nil {
+			return nil, err
+		}
+		return NewInstrumentedDiskStore(ds, ioStats, diskUsage), nil
+	}
+	return nil, utils.StackError(nil, "Unknown disk store type: %s", cfg.Type)
 }
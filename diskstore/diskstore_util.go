@@ -27,6 +27,9 @@ const data string = "data"
 const redologs string = "redologs"
 const snapshots string = "snapshots"
 const archiveBatches string = "archiving_batches"
+const quarantine string = "quarantine"
+const checksumFileSuffix string = ".crc"
+const tmpFileSuffix string = ".tmp"
 
 // Utils for data hierarchy layout.
 // Following this wiki:
@@ -137,6 +140,19 @@ func GetPathForTableArchiveBatchColumnFile(prefix, table string, shardID int, ba
 	return filepath.Join(tableArchiveBatchDir, columnFileName)
 }
 
+// Quarantine Utils
+// Path on disk:
+//   {root_path}/data/{table_name}_{shard_id}/quarantine/{relative_path_with_underscores}
+//
+// Sample:
+//   /var/gForceDb/data/myTable_0/quarantine/archiving_batches_2017-07-19_1499971253_1.data
+
+// GetPathForTableQuarantineDir is used to get the directory to store corrupted files of a table shard.
+func GetPathForTableQuarantineDir(prefix, table string, shardID int) string {
+	tableShardPath := getPathForTableShard(prefix, table, shardID)
+	return filepath.Join(tableShardPath, quarantine)
+}
+
 // ParseBatchIDAndVersionName will parse a batchIDAndVersion into batchID and batchVersion+seqNum.
 func ParseBatchIDAndVersionName(batchIDAndVersion string) (string, uint32, uint32, error) {
 	var batchID string
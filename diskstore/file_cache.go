@@ -0,0 +1,183 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fileCacheEntry is an entry of fileCache. Each entry covers a data file and its checksum file.
+type fileCacheEntry struct {
+	key  string
+	size int64
+	// pins is the number of readers opening the file, pinned entries are not evicted.
+	pins int
+	// removed marks a pinned entry removed from the cache, its files are deleted when the last pin
+	// is released.
+	removed bool
+}
+
+// fileCache is a size bounded LRU cache of object files on local disk.
+type fileCache struct {
+	sync.Mutex
+	rootDir  string
+	capacity int64
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+}
+
+// newFileCache creates a file cache under rootDir. Files left by previous runs are removed
+// since we don't know whether they are still valid.
+func newFileCache(rootDir string, capacity int64) (*fileCache, error) {
+	if err := os.RemoveAll(rootDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileCache{
+		rootDir:  rootDir,
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}, nil
+}
+
+// path returns the local file path for the object key.
+func (c *fileCache) path(key string) string {
+	return filepath.Join(c.rootDir, filepath.FromSlash(key))
+}
+
+// pin returns whether the key is cached, marks it as recently used and pins it so that its files are
+// not evicted until unpin is called. Removed entries still pinned by other readers are not cached.
+func (c *fileCache) pin(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[key]
+	if !ok || element.Value.(*fileCacheEntry).removed {
+		return false
+	}
+	c.lru.MoveToFront(element)
+	element.Value.(*fileCacheEntry).pins++
+	return true
+}
+
+// unpin releases a pin of the key and deletes the files of the key if it was removed while pinned.
+func (c *fileCache) unpin(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*fileCacheEntry)
+		if entry.pins > 0 {
+			entry.pins--
+		}
+		if entry.pins == 0 && entry.removed {
+			c.removeElement(element)
+		}
+	}
+}
+
+// add adds the key with its file size into the cache and evicts least recently used
+// entries if the cache is over capacity.
+func (c *fileCache) add(key string, size int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.addEntry(key, size, 0)
+}
+
+// addPinned adds the key like add and pins it.
+func (c *fileCache) addPinned(key string, size int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.addEntry(key, size, 1)
+}
+
+func (c *fileCache) addEntry(key string, size int64, pins int) {
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*fileCacheEntry)
+		// keep pins of readers of the replaced file.
+		pins += entry.pins
+		c.size -= entry.size
+		c.lru.Remove(element)
+	}
+	element := c.lru.PushFront(&fileCacheEntry{key: key, size: size, pins: pins})
+	c.entries[key] = element
+	c.size += size
+	c.evict(c.capacity, element)
+}
+
+// shrink evicts least recently used entries not pinned until the cache size is no larger than size.
+func (c *fileCache) shrink(size int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.evict(size, nil)
+}
+
+// evict removes least recently used entries not pinned until the cache size is no larger than size,
+// the keep element is never removed.
+func (c *fileCache) evict(size int64, keep *list.Element) {
+	for element := c.lru.Back(); element != nil && c.size > size; {
+		prev := element.Prev()
+		if element != keep && element.Value.(*fileCacheEntry).pins == 0 {
+			c.removeElement(element)
+		}
+		element = prev
+	}
+}
+
+// remove removes the key from the cache and deletes its files. Files of a pinned key are deleted
+// when the last pin is released.
+func (c *fileCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.removeOrDefer(element)
+	}
+}
+
+// removePrefix removes all keys with the prefix from the cache like remove.
+func (c *fileCache) removePrefix(prefix string) {
+	c.Lock()
+	defer c.Unlock()
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeOrDefer(element)
+		}
+	}
+}
+
+func (c *fileCache) removeOrDefer(element *list.Element) {
+	if entry := element.Value.(*fileCacheEntry); entry.pins > 0 {
+		entry.removed = true
+		return
+	}
+	c.removeElement(element)
+}
+
+func (c *fileCache) removeElement(element *list.Element) {
+	entry := element.Value.(*fileCacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	// readers having the file opened can still finish reading after the unlink.
+	path := c.path(entry.key)
+	os.Remove(path)
+	os.Remove(getChecksumFilePath(path))
+}
@@ -0,0 +1,269 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"io"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// instrumentedDiskStore wraps file handles opened by a DiskStore to record disk I/O by table and
+// operation, and tracks disk usage of table shards.
+type instrumentedDiskStore struct {
+	DiskStore
+	stats *IOStats
+	usage *DiskUsage
+	// operation recorded for archive vector party file reads.
+	readOp IOOperation
+}
+
+// NewInstrumentedDiskStore wraps ds to record disk I/O of its files into stats and disk usage of table
+// shards into usage.
+func NewInstrumentedDiskStore(ds DiskStore, stats *IOStats, usage *DiskUsage) DiskStore {
+	return instrumentedDiskStore{
+		DiskStore: ds,
+		stats:     stats,
+		usage:     usage,
+		readOp:    IOQueryRead,
+	}
+}
+
+// WithIOReadOperation returns a disk store recording archive vector party file reads as op if ds
+// is instrumented, otherwise ds itself is returned.
+func WithIOReadOperation(ds DiskStore, op IOOperation) DiskStore {
+	if instrumented, ok := ds.(instrumentedDiskStore); ok {
+		instrumented.readOp = op
+		return instrumented
+	}
+	return ds
+}
+
+// OpenLogFileForReplay opens the specified log file for replay.
+func (d instrumentedDiskStore) OpenLogFileForReplay(table string, shard int,
+	creationTime int64) (utils.ReaderSeekerCloser, error) {
+	file, err := d.DiskStore.OpenLogFileForReplay(table, shard, creationTime)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedReadSeekCloser{
+		instrumentedReadCloser: instrumentedReadCloser{
+			ReadCloser: file,
+			recorder:   d.stats.newIORecorder(table, shard, IORedoLogRead),
+		},
+		seeker: file,
+	}, nil
+}
+
+// OpenLogFileForAppend opens/creates the specified log file for append.
+func (d instrumentedDiskStore) OpenLogFileForAppend(table string, shard int,
+	creationTime int64) (io.WriteCloser, error) {
+	file, err := d.DiskStore.OpenLogFileForAppend(table, shard, creationTime)
+	if err != nil {
+		return nil, err
+	}
+	return d.newWriteCloser(file, table, shard, IORedoLogAppend), nil
+}
+
+// OpenSnapshotVectorPartyFileForRead opens the snapshot vector party file for read.
+func (d instrumentedDiskStore) OpenSnapshotVectorPartyFileForRead(table string, shard int,
+	redoLogFile int64, offset uint32, batchID int, columnID int) (io.ReadCloser, error) {
+	file, err := d.DiskStore.OpenSnapshotVectorPartyFileForRead(table, shard, redoLogFile, offset, batchID, columnID)
+	if err != nil {
+		return nil, err
+	}
+	return newInstrumentedReadCloser(file, d.stats.newIORecorder(table, shard, IOSnapshotRead)), nil
+}
+
+// OpenSnapshotVectorPartyFileForWrite creates/truncates the snapshot column file for write.
+func (d instrumentedDiskStore) OpenSnapshotVectorPartyFileForWrite(table string, shard int,
+	redoLogFile int64, offset uint32, batchID int, columnID int) (io.WriteCloser, error) {
+	file, err := d.DiskStore.OpenSnapshotVectorPartyFileForWrite(table, shard, redoLogFile, offset, batchID, columnID)
+	if err != nil {
+		return nil, err
+	}
+	return d.newWriteCloser(file, table, shard, IOSnapshotWrite), nil
+}
+
+// OpenVectorPartyFileForRead opens the vector party file at the specified batchVersion for read.
+func (d instrumentedDiskStore) OpenVectorPartyFileForRead(table string, columnID int, shard, batchID int,
+	batchVersion uint32, seqNum uint32) (io.ReadCloser, error) {
+	file, err := d.DiskStore.OpenVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, seqNum)
+	if err != nil {
+		return nil, err
+	}
+	return newInstrumentedReadCloser(file, d.stats.newIORecorder(table, shard, d.readOp)), nil
+}
+
+// OpenVectorPartyFileForWrite creates/truncates the vector party file at the specified batchVersion
+// for write.
+func (d instrumentedDiskStore) OpenVectorPartyFileForWrite(table string, columnID int, shard, batchID int,
+	batchVersion uint32, seqNum uint32) (io.WriteCloser, error) {
+	file, err := d.DiskStore.OpenVectorPartyFileForWrite(table, columnID, shard, batchID, batchVersion, seqNum)
+	if err != nil {
+		return nil, err
+	}
+	return d.newWriteCloser(file, table, shard, IOArchiveWrite), nil
+}
+
+// MapVectorPartyFileForRead maps the vector party file if the underlying disk store supports mmap
+// reads. The whole file is recorded as read when mapped since page faults are not observable.
+func (d instrumentedDiskStore) MapVectorPartyFileForRead(table string, columnID int, shard, batchID int,
+	batchVersion uint32, seqNum uint32) (*MappedFile, error) {
+	mapper, ok := d.DiskStore.(VectorPartyFileMapper)
+	if !ok {
+		return nil, ErrMmapNotSupported
+	}
+	start := utils.Now()
+	mappedFile, err := mapper.MapVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, seqNum)
+	if err != nil {
+		return nil, err
+	}
+	d.stats.newIORecorder(table, shard, d.readOp).record(len(mappedFile.Bytes()), utils.Now().Sub(start))
+	return mappedFile, nil
+}
+
+// ScanTableShardDiskUsage scans the table shard and tracks its disk usage from now on if the
+// underlying disk store keeps files on local disk.
+func (d instrumentedDiskStore) ScanTableShardDiskUsage(table string, shard int) (int64, error) {
+	scanner, ok := d.DiskStore.(DiskUsageScanner)
+	if !ok {
+		return 0, ErrDiskUsageNotSupported
+	}
+	return d.usage.track(table, shard, func() (int64, error) {
+		return scanner.ScanTableShardDiskUsage(table, shard)
+	})
+}
+
+// DeleteTableShard deletes the table shard and stops tracking its disk usage.
+func (d instrumentedDiskStore) DeleteTableShard(table string, shard int) error {
+	err := d.DiskStore.DeleteTableShard(table, shard)
+	d.usage.deleteShard(table, shard)
+	return err
+}
+
+// DeleteLogFile deletes the log file and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) DeleteLogFile(table string, shard int, creationTime int64) error {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.DeleteLogFile(table, shard, creationTime)
+}
+
+// TruncateLogFile truncates the log file and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) TruncateLogFile(table string, shard int, creationTime int64, offset int64) error {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.TruncateLogFile(table, shard, creationTime, offset)
+}
+
+// DeleteSnapshot deletes old snapshots and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) DeleteSnapshot(table string, shard int, redoLogFile int64, offset uint32) error {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.DeleteSnapshot(table, shard, redoLogFile, offset)
+}
+
+// DeleteBatchVersions deletes old versions of the batch and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) DeleteBatchVersions(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) error {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.DeleteBatchVersions(table, shard, batchID, batchVersion, seqNum)
+}
+
+// DeleteBatchVersion deletes the batch version and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) DeleteBatchVersion(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) error {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.DeleteBatchVersion(table, shard, batchID, batchVersion, seqNum)
+}
+
+// DeleteBatches deletes batches within the range and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) DeleteBatches(table string, shard, batchIDStart, batchIDEnd int) (int, error) {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.DeleteBatches(table, shard, batchIDStart, batchIDEnd)
+}
+
+// DeleteColumn deletes all batches of the column and invalidates disk usage of the table shard.
+func (d instrumentedDiskStore) DeleteColumn(table string, column, shard int) error {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.DeleteColumn(table, column, shard)
+}
+
+// VerifyTableShard verifies files of the table shard and invalidates its disk usage since corrupted files
+// are quarantined.
+func (d instrumentedDiskStore) VerifyTableShard(table string, shard int, bytesPerSec int64) (int, error) {
+	defer d.usage.invalidate(table, shard)
+	return d.DiskStore.VerifyTableShard(table, shard, bytesPerSec)
+}
+
+// OnDiskSpaceLevelChange forwards disk space level changes to the underlying disk store.
+func (d instrumentedDiskStore) OnDiskSpaceLevelChange(level DiskSpaceLevel) {
+	if listener, ok := d.DiskStore.(DiskSpaceListener); ok {
+		listener.OnDiskSpaceLevelChange(level)
+	}
+}
+
+// instrumentedReadCloser records bytes and latency of each read.
+type instrumentedReadCloser struct {
+	io.ReadCloser
+	recorder *ioRecorder
+}
+
+func newInstrumentedReadCloser(reader io.ReadCloser, recorder *ioRecorder) io.ReadCloser {
+	return &instrumentedReadCloser{ReadCloser: reader, recorder: recorder}
+}
+
+func (r *instrumentedReadCloser) Read(p []byte) (int, error) {
+	start := utils.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.recorder.record(n, utils.Now().Sub(start))
+	return n, err
+}
+
+// instrumentedReadSeekCloser is an instrumentedReadCloser that can seek.
+type instrumentedReadSeekCloser struct {
+	instrumentedReadCloser
+	seeker io.Seeker
+}
+
+func (r *instrumentedReadSeekCloser) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// instrumentedWriteCloser records bytes and latency of each write, and adds bytes written to the disk
+// usage of the table shard.
+type instrumentedWriteCloser struct {
+	io.WriteCloser
+	recorder *ioRecorder
+	usage    *DiskUsage
+	table    string
+	shard    int
+}
+
+func (d instrumentedDiskStore) newWriteCloser(writer io.WriteCloser, table string, shard int,
+	op IOOperation) io.WriteCloser {
+	return &instrumentedWriteCloser{
+		WriteCloser: writer,
+		recorder:    d.stats.newIORecorder(table, shard, op),
+		usage:       d.usage,
+		table:       table,
+		shard:       shard,
+	}
+}
+
+func (w *instrumentedWriteCloser) Write(p []byte) (int, error) {
+	start := utils.Now()
+	n, err := w.WriteCloser.Write(p)
+	w.recorder.record(n, utils.Now().Sub(start))
+	w.usage.add(w.table, w.shard, int64(n))
+	return n, err
+}
@@ -0,0 +1,241 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/utils"
+)
+
+// IOOperation is the type of disk I/O operations used to break down I/O stats of tables.
+type IOOperation string
+
+const (
+	// IORedoLogAppend is appending upsert batches to redo logs.
+	IORedoLogAppend IOOperation = "redolog_append"
+	// IORedoLogRead is replaying redo logs on recovery.
+	IORedoLogRead IOOperation = "redolog_read"
+	// IOSnapshotWrite is writing snapshot files.
+	IOSnapshotWrite IOOperation = "snapshot_write"
+	// IOSnapshotRead is reading snapshot files on recovery.
+	IOSnapshotRead IOOperation = "snapshot_read"
+	// IOArchiveWrite is writing archive batch files on archiving and backfill.
+	IOArchiveWrite IOOperation = "archive_write"
+	// IOArchiveRead is reading archive batch files to merge on archiving.
+	IOArchiveRead IOOperation = "archive_read"
+	// IOBackfillRead is reading archive batch files to merge on backfill.
+	IOBackfillRead IOOperation = "backfill_read"
+	// IOQueryRead is reading archive batch files for queries, which is the default for archive batch reads.
+	IOQueryRead IOOperation = "query_read"
+	// IOExportRead is reading archive batch files to export them.
+	IOExportRead IOOperation = "export_read"
+)
+
+const (
+	// unknownIOTable is the table tag for I/O of tables without registered table shards, which keeps
+	// the cardinality of tags bounded to existing tables.
+	unknownIOTable = "unknown"
+	// number of tables in the periodic I/O summary log.
+	ioSummaryTopTables = 10
+	// tag for the IOOperation of I/O metrics.
+	ioMetricsTagOperation = "operation"
+)
+
+// IOCounters are the accumulated disk I/O of a table for an operation.
+type IOCounters struct {
+	Bytes        int64 `json:"bytes"`
+	Calls        int64 `json:"calls"`
+	LatencyNanos int64 `json:"latencyNanos"`
+}
+
+func (c *IOCounters) add(other IOCounters) {
+	c.Bytes += other.Bytes
+	c.Calls += other.Calls
+	c.LatencyNanos += other.LatencyNanos
+}
+
+// TableIOStats is the I/O summary of a table.
+type TableIOStats struct {
+	Table string `json:"table"`
+	IOCounters
+}
+
+// IOStats accumulates disk I/O by table and operation, and logs a summary of the tables with the most I/O
+// periodically.
+type IOStats struct {
+	sync.Mutex
+	// accumulated since start, keyed by table then operation.
+	total map[string]map[IOOperation]*IOCounters
+	// accumulated since last summary, keyed by table.
+	sinceSummary map[string]*IOCounters
+
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+var ioStats = NewIOStats()
+
+// GetIOStats returns the I/O stats of disk stores created by NewDiskStore.
+func GetIOStats() *IOStats {
+	return ioStats
+}
+
+// NewIOStats creates an empty IOStats.
+func NewIOStats() *IOStats {
+	return &IOStats{
+		total:        make(map[string]map[IOOperation]*IOCounters),
+		sinceSummary: make(map[string]*IOCounters),
+	}
+}
+
+// ioRecorder records I/O of a file handle of a table shard for an operation.
+type ioRecorder struct {
+	stats   *IOStats
+	table   string
+	op      IOOperation
+	bytes   tally.Counter
+	latency tally.Timer
+}
+
+// newIORecorder creates a recorder reporting to the table shard reporter, or to the root reporter
+// under unknownIOTable if the table shard is not registered.
+func (s *IOStats) newIORecorder(table string, shard int, op IOOperation) *ioRecorder {
+	reporter := utils.GetReporter(table, shard)
+	if reporter == utils.GetRootReporter() {
+		table = unknownIOTable
+	}
+	tags := map[string]string{ioMetricsTagOperation: string(op)}
+	return &ioRecorder{
+		stats:   s,
+		table:   table,
+		op:      op,
+		bytes:   reporter.GetChildCounter(tags, utils.DiskIOBytes),
+		latency: reporter.GetChildTimer(tags, utils.DiskIOLatency),
+	}
+}
+
+// record records one read or write call.
+func (r *ioRecorder) record(bytes int, latency time.Duration) {
+	r.bytes.Inc(int64(bytes))
+	r.latency.Record(latency)
+	r.stats.add(r.table, r.op, IOCounters{Bytes: int64(bytes), Calls: 1, LatencyNanos: int64(latency)})
+}
+
+func (s *IOStats) add(table string, op IOOperation, counters IOCounters) {
+	s.Lock()
+	defer s.Unlock()
+	countersByOp, ok := s.total[table]
+	if !ok {
+		countersByOp = make(map[IOOperation]*IOCounters)
+		s.total[table] = countersByOp
+	}
+	total, ok := countersByOp[op]
+	if !ok {
+		total = &IOCounters{}
+		countersByOp[op] = total
+	}
+	total.add(counters)
+
+	sinceSummary, ok := s.sinceSummary[table]
+	if !ok {
+		sinceSummary = &IOCounters{}
+		s.sinceSummary[table] = sinceSummary
+	}
+	sinceSummary.add(counters)
+}
+
+// Snapshot returns a copy of the I/O counters accumulated since start by table and operation.
+func (s *IOStats) Snapshot() map[string]map[IOOperation]IOCounters {
+	s.Lock()
+	defer s.Unlock()
+	snapshot := make(map[string]map[IOOperation]IOCounters, len(s.total))
+	for table, countersByOp := range s.total {
+		snapshot[table] = make(map[IOOperation]IOCounters, len(countersByOp))
+		for op, counters := range countersByOp {
+			snapshot[table][op] = *counters
+		}
+	}
+	return snapshot
+}
+
+// DeleteTable deletes the counters of the table, e.g. when the table is deleted.
+func (s *IOStats) DeleteTable(table string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.total, table)
+	delete(s.sinceSummary, table)
+}
+
+// TopTables returns up to n tables with the most bytes of I/O since last summary in descending order
+// and resets the counters since last summary.
+func (s *IOStats) TopTables(n int) []TableIOStats {
+	s.Lock()
+	tables := make([]TableIOStats, 0, len(s.sinceSummary))
+	for table, counters := range s.sinceSummary {
+		tables = append(tables, TableIOStats{Table: table, IOCounters: *counters})
+	}
+	s.sinceSummary = make(map[string]*IOCounters)
+	s.Unlock()
+
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Bytes != tables[j].Bytes {
+			return tables[i].Bytes > tables[j].Bytes
+		}
+		return tables[i].Table < tables[j].Table
+	})
+	if len(tables) > n {
+		tables = tables[:n]
+	}
+	return tables
+}
+
+// Start starts logging a summary of the tables with the most I/O every interval in background.
+func (s *IOStats) Start(interval time.Duration) {
+	if interval <= 0 || s.stopChan != nil {
+		return
+	}
+	s.stopChan = make(chan struct{})
+	s.doneChan = make(chan struct{})
+	go func() {
+		defer close(s.doneChan)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if tables := s.TopTables(ioSummaryTopTables); len(tables) > 0 {
+					utils.GetLogger().With("interval", interval.String(), "tables", tables).
+						Info("Top tables by disk I/O")
+				}
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic summary.
+func (s *IOStats) Stop() {
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	<-s.doneChan
+	s.stopChan = nil
+}
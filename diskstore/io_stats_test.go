@@ -0,0 +1,147 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("IOStats", func() {
+	prefix := "/tmp/testDiskStoreIOStatsSuite"
+	table := "myTable"
+	shard := 1
+	batchID := 17800
+	batchVersion := uint32(1499971253)
+
+	var stats *IOStats
+	var ds DiskStore
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		os.MkdirAll(prefix, 0755)
+		stats = NewIOStats()
+		ds = NewInstrumentedDiskStore(LocalDiskStore{
+			rootPath:        prefix,
+			diskStoreConfig: common.DiskStoreConfig{MmapReads: true},
+		}, stats, NewDiskUsage())
+		utils.AddTableShardReporter(table, shard)
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.DeleteTableShardReporter(table, shard)
+		os.RemoveAll(prefix)
+	})
+
+	writeVectorParty := func(columnID int, data string) {
+		writer, err := ds.OpenVectorPartyFileForWrite(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		_, err = io.WriteString(writer, data)
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+	}
+
+	ginkgo.It("records I/O by table and operation", func() {
+		writeVectorParty(1, "0123456789")
+
+		reader, err := ds.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		_, err = ioutil.ReadAll(reader)
+		Ω(err).Should(BeNil())
+		Ω(reader.Close()).Should(BeNil())
+
+		reader, err = WithIOReadOperation(ds, IOBackfillRead).
+			OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(reader, buf)
+		Ω(err).Should(BeNil())
+		Ω(reader.Close()).Should(BeNil())
+
+		mappedFile, err := WithIOReadOperation(ds, IOArchiveRead).(VectorPartyFileMapper).
+			MapVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		Ω(mappedFile.Close()).Should(BeNil())
+
+		snapshot := stats.Snapshot()
+		Ω(snapshot).Should(HaveLen(1))
+		Ω(snapshot[table][IOArchiveWrite].Bytes).Should(BeEquivalentTo(10))
+		Ω(snapshot[table][IOArchiveWrite].Calls).Should(BeEquivalentTo(1))
+		Ω(snapshot[table][IOQueryRead].Bytes).Should(BeEquivalentTo(10))
+		Ω(snapshot[table][IOBackfillRead].Bytes).Should(BeEquivalentTo(4))
+		Ω(snapshot[table][IOArchiveRead].Bytes).Should(BeEquivalentTo(10))
+		Ω(snapshot[table][IOArchiveRead].Calls).Should(BeEquivalentTo(1))
+
+		stats.DeleteTable(table)
+		Ω(stats.Snapshot()).Should(BeEmpty())
+	})
+
+	ginkgo.It("records redo log I/O and keeps replay files seekable", func() {
+		Ω(os.MkdirAll(GetPathForTableRedologs(prefix, table, shard), 0755)).Should(BeNil())
+		writer, err := ds.OpenLogFileForAppend(table, shard, 1)
+		Ω(err).Should(BeNil())
+		_, err = io.WriteString(writer, "abcdef")
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+
+		reader, err := ds.OpenLogFileForReplay(table, shard, 1)
+		Ω(err).Should(BeNil())
+		offset, err := reader.Seek(2, io.SeekStart)
+		Ω(err).Should(BeNil())
+		Ω(offset).Should(BeEquivalentTo(2))
+		data, err := ioutil.ReadAll(reader)
+		Ω(err).Should(BeNil())
+		Ω(string(data)).Should(Equal("cdef"))
+		Ω(reader.Close()).Should(BeNil())
+
+		snapshot := stats.Snapshot()
+		Ω(snapshot[table][IORedoLogAppend].Bytes).Should(BeEquivalentTo(6))
+		Ω(snapshot[table][IORedoLogRead].Bytes).Should(BeEquivalentTo(4))
+	})
+
+	ginkgo.It("records I/O of unregistered tables as unknown", func() {
+		utils.DeleteTableShardReporter(table, shard)
+		writeVectorParty(1, "0123")
+		snapshot := stats.Snapshot()
+		Ω(snapshot).Should(HaveLen(1))
+		Ω(snapshot[unknownIOTable][IOArchiveWrite].Bytes).Should(BeEquivalentTo(4))
+	})
+
+	ginkgo.It("returns top tables since last summary", func() {
+		stats.add("a", IOQueryRead, IOCounters{Bytes: 10, Calls: 1})
+		stats.add("b", IOQueryRead, IOCounters{Bytes: 30, Calls: 1})
+		stats.add("b", IOArchiveWrite, IOCounters{Bytes: 5, Calls: 2})
+		stats.add("c", IOQueryRead, IOCounters{Bytes: 20, Calls: 1})
+
+		Ω(stats.TopTables(2)).Should(Equal([]TableIOStats{
+			{Table: "b", IOCounters: IOCounters{Bytes: 35, Calls: 3}},
+			{Table: "c", IOCounters: IOCounters{Bytes: 20, Calls: 1}},
+		}))
+		Ω(stats.TopTables(2)).Should(BeEmpty())
+		Ω(stats.Snapshot()).Should(HaveLen(3))
+
+		stats.Start(time.Millisecond)
+		stats.Start(time.Millisecond)
+		stats.Stop()
+		stats.Stop()
+	})
+})
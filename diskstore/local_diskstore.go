@@ -15,6 +15,7 @@
 package diskstore
 
 import (
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"os"
@@ -184,6 +185,10 @@ func (l LocalDiskStore) readVectoryPartyFiles(dir string) (columnIDs[]int, err e
 	}
 
 	for _, f := range vpFiles {
+		if strings.HasSuffix(f.Name(), checksumFileSuffix) || strings.HasSuffix(f.Name(), tmpFileSuffix) ||
+			f.Name() == batchVersionManifestFile {
+			continue
+		}
 		matchedVectorPartyFilePattern, _ := regexp.MatchString("([0-9]+).data", f.Name())
 		if matchedVectorPartyFilePattern {
 			var columnID int64
@@ -216,6 +221,10 @@ func (l LocalDiskStore) OpenSnapshotVectorPartyFileForRead(table string, shard i
 	} else if err != nil {
 		return nil, utils.StackError(err, "Failed to open snapshot file: %s for read", snapshotFilePath)
 	}
+	if err = l.verifyFileForRead(table, shard, snapshotFilePath); err != nil {
+		f.Close()
+		return nil, err
+	}
 	return f, nil
 }
 
@@ -227,16 +236,11 @@ func (l LocalDiskStore) OpenSnapshotVectorPartyFileForWrite(table string, shard
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, utils.StackError(err, "Failed to make dirs for path: %s", dir)
 	}
-	mode := os.O_CREATE | os.O_WRONLY
-	if l.diskStoreConfig.WriteSync {
-		mode |= os.O_SYNC
-	}
-	f, err := os.OpenFile(snapshotFilePath, mode, 0644)
-	os.Truncate(snapshotFilePath, 0)
+	f, err := l.openTmpFileForWrite(snapshotFilePath)
 	if err != nil {
 		return nil, utils.StackError(err, "Failed to open snapshot file: %s for write", snapshotFilePath)
 	}
-	return f, nil
+	return newChecksumWriter(f, snapshotFilePath, l.diskStoreConfig.ChecksumBlockSize), nil
 }
 
 // DeleteSnapshot : Deletes snapshot directories **older than** the specified version (redolog file and offset).
@@ -310,6 +314,10 @@ func (l LocalDiskStore) OpenVectorPartyFileForRead(table string, columnID int, s
 	} else if err != nil {
 		return nil, utils.StackError(err, "Failed to open vector party file: %s for read", vectorPartyFilePath)
 	}
+	if err = l.verifyFileForRead(table, shard, vectorPartyFilePath); err != nil {
+		f.Close()
+		return nil, err
+	}
 	return f, nil
 }
 
@@ -324,16 +332,50 @@ func (l LocalDiskStore) OpenVectorPartyFileForWrite(table string, columnID int,
 	vectorPartyFilePath := GetPathForTableArchiveBatchColumnFile(l.rootPath, table, shard, batchIDTimeStr, batchVersion,
 		seqNum, columnID)
 
-	mode := os.O_CREATE | os.O_WRONLY
-	if l.diskStoreConfig.WriteSync {
-		mode |= os.O_SYNC
-	}
-
-	f, err := os.OpenFile(vectorPartyFilePath, mode, 0644)
+	f, err := l.openTmpFileForWrite(vectorPartyFilePath)
 	if err != nil {
 		return nil, utils.StackError(err, "Failed to open vector party file: %s for write", vectorPartyFilePath)
 	}
-	return f, nil
+	return newChecksumWriter(f, vectorPartyFilePath, l.diskStoreConfig.ChecksumBlockSize), nil
+}
+
+// MapVectorPartyFileForRead maps the vector party file at the specified batchVersion read only into memory.
+// Returns ErrMmapNotSupported if mmap reads are disabled or not supported on this platform, and
+// os.ErrNotExist if the file does not exist.
+func (l LocalDiskStore) MapVectorPartyFileForRead(table string, columnID int, shard, batchID int, batchVersion uint32,
+	seqNum uint32) (*MappedFile, error) {
+	if !l.diskStoreConfig.MmapReads {
+		return nil, ErrMmapNotSupported
+	}
+	batchIDTimeStr := daysSinceEpochToTimeStr(batchID)
+	vectorPartyFilePath := GetPathForTableArchiveBatchColumnFile(l.rootPath, table, shard, batchIDTimeStr, batchVersion,
+		seqNum, columnID)
+	if err := l.verifyFileForRead(table, shard, vectorPartyFilePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	mappedFile, err := mmapFile(vectorPartyFilePath)
+	if os.IsNotExist(err) {
+		return nil, os.ErrNotExist
+	} else if err == ErrMmapNotSupported {
+		return nil, err
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to map vector party file: %s for read", vectorPartyFilePath)
+	}
+	return mappedFile, nil
+}
+
+// openTmpFileForWrite opens a temp file next to the path for write. The temp file is renamed to
+// the path when the writer is closed so that existing readers and memory mappings of the
+// file are not affected by the rewrite.
+func (l LocalDiskStore) openTmpFileForWrite(path string) (*os.File, error) {
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if l.diskStoreConfig.WriteSync {
+		mode |= os.O_SYNC
+	}
+	return os.OpenFile(path+tmpFileSuffix, mode, 0644)
 }
 
 // DeleteBatchVersions deletes all old batches with the specified batchID that have version lower than or equal to
@@ -355,6 +397,81 @@ func (l LocalDiskStore) DeleteBatchVersions(table string, shard, batchID int, ba
 	return nil
 }
 
+// DeleteBatchVersion deletes the batch with the specified batchID, batch version and seq num.
+func (l LocalDiskStore) DeleteBatchVersion(table string, shard, batchID int, batchVersion uint32, seqNum uint32) error {
+	batchDirPath := GetPathForTableArchiveBatchDir(l.rootPath, table, shard, daysSinceEpochToTimeStr(batchID),
+		batchVersion, seqNum)
+	if err := os.RemoveAll(batchDirPath); err != nil {
+		return utils.StackError(err, "Failed to delete batch directory: %s", batchDirPath)
+	}
+	return nil
+}
+
+// CommitBatchVersion commits the batch version by durably writing its manifest into the batch dir. Column files
+// renamed into the batch dir are made durable before the manifest is written.
+func (l LocalDiskStore) CommitBatchVersion(table string, shard, batchID int, batchVersion uint32, seqNum uint32,
+	manifest BatchVersionManifest) error {
+	batchDir := GetPathForTableArchiveBatchDir(l.rootPath, table, shard, daysSinceEpochToTimeStr(batchID),
+		batchVersion, seqNum)
+	if err := os.MkdirAll(batchDir, 0755); err != nil {
+		return utils.StackError(err, "Failed to make dirs for path: %s", batchDir)
+	}
+	if err := syncDir(batchDir); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal batch version manifest")
+	}
+	manifestPath := filepath.Join(batchDir, batchVersionManifestFile)
+	if err = writeFileSync(manifestPath, data); err != nil {
+		return err
+	}
+	if err = syncDir(batchDir); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(batchDir))
+}
+
+// ReadBatchVersionManifest reads the manifest of the batch version. Returns os.ErrNotExist if the batch version
+// is not committed.
+func (l LocalDiskStore) ReadBatchVersionManifest(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) (*BatchVersionManifest, error) {
+	manifestPath := filepath.Join(GetPathForTableArchiveBatchDir(l.rootPath, table, shard,
+		daysSinceEpochToTimeStr(batchID), batchVersion, seqNum), batchVersionManifestFile)
+	data, err := ioutil.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to read batch version manifest: %s", manifestPath)
+	}
+	return unmarshalBatchVersionManifest(data, manifestPath)
+}
+
+// ListBatchVersions returns all archive batch versions on disk sorted by batch id and then from old to new.
+func (l LocalDiskStore) ListBatchVersions(table string, shard int) ([]BatchVersion, error) {
+	tableArchiveBatchRootDir := GetPathForTableArchiveBatchRootDir(l.rootPath, table, shard)
+	batchDirs, err := ioutil.ReadDir(tableArchiveBatchRootDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to list archive batch root dir: %s", tableArchiveBatchRootDir)
+	}
+
+	var versions []BatchVersion
+	for _, batchDir := range batchDirs {
+		if !batchDir.IsDir() {
+			continue
+		}
+		if version, ok := parseBatchVersion(batchDir.Name()); ok {
+			versions = append(versions, version)
+		}
+	}
+	sortBatchVersions(versions)
+	return versions, nil
+}
+
 // DeleteBatches : Deletes all batches within [batchIDStart, batchIDEnd)
 func (l LocalDiskStore) DeleteBatches(table string, shard, batchIDStart, batchIDEnd int) (int, error) {
 	batchIDStartTime := daysSinceEpochToTime(batchIDStart)
@@ -416,9 +533,133 @@ func (l LocalDiskStore) DeleteColumn(table string, columnID int, shard int) erro
 					).Warn("Failed to delete a vector party file")
 					continue
 				}
+				os.Remove(getChecksumFilePath(vectorPartyFilePath))
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyTableShard verifies all snapshot and archive batch files of a table shard against their checksums.
+// Reads are throttled to bytesPerSec if it's positive. Corrupted files are quarantined.
+// Returns number of corrupted files found.
+func (l LocalDiskStore) VerifyTableShard(table string, shard int, bytesPerSec int64) (int, error) {
+	var files []string
+	for _, dir := range []string{
+		GetPathForTableSnapshotDir(l.rootPath, table, shard),
+		GetPathForTableArchiveBatchRootDir(l.rootPath, table, shard),
+	} {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".data") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, utils.StackError(err, "Failed to list files under dir: %s", dir)
+		}
+	}
+
+	var limiter func(bytes int)
+	if bytesPerSec > 0 {
+		limiter = func(bytes int) {
+			time.Sleep(time.Duration(int64(bytes) * int64(time.Second) / bytesPerSec))
+		}
+	}
+
+	numCorrupted := 0
+	for _, path := range files {
+		if _, err := verifyFile(path, limiter); err != nil {
+			if !IsCorruptionError(err) {
+				if os.IsNotExist(err) {
+					// file got deleted during verification.
+					continue
+				}
+				return numCorrupted, err
 			}
+			numCorrupted++
+			l.quarantineFile(table, shard, path, err)
 		}
 	}
+	return numCorrupted, nil
+}
+
+// verifyFileForRead verifies the file before it's loaded and quarantines it if it's corrupted.
+func (l LocalDiskStore) verifyFileForRead(table string, shard int, path string) error {
+	_, err := verifyFile(path, nil)
+	if IsCorruptionError(err) {
+		l.quarantineFile(table, shard, path, err)
+	}
+	return err
+}
+
+// quarantineFile moves a corrupted file together with its checksum file to the quarantine dir of the table shard
+// so that it will not be loaded again.
+func (l LocalDiskStore) quarantineFile(table string, shard int, path string, cause error) {
+	utils.GetReporter(table, shard).GetCounter(utils.DiskFileCorrupt).Inc(1)
+	logger := utils.GetLogger().With("action", "quarantine", "table", table, "shard", shard, "file", path,
+		"error", cause.Error())
+
+	quarantineDir := GetPathForTableQuarantineDir(l.rootPath, table, shard)
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		logger.With("err", err).Error("Failed to make quarantine dir")
+		return
+	}
+
+	relPath, err := filepath.Rel(getPathForTableShard(l.rootPath, table, shard), path)
+	if err != nil {
+		relPath = filepath.Base(path)
+	}
+	quarantinePath := filepath.Join(quarantineDir, strings.Replace(relPath, string(filepath.Separator), "_", -1))
+	if err := os.Rename(path, quarantinePath); err != nil && !os.IsNotExist(err) {
+		logger.With("err", err).Error("Failed to quarantine corrupted file")
+		return
+	}
+	os.Rename(getChecksumFilePath(path), getChecksumFilePath(quarantinePath))
+	logger.Errorf("Quarantined corrupted file to %s", quarantinePath)
+}
+
+// syncDir syncs the dir so that entries created, renamed or removed in it are durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return utils.StackError(err, "Failed to open dir: %s for sync", dir)
+	}
+	defer f.Close()
+	if err = f.Sync(); err != nil {
+		return utils.StackError(err, "Failed to sync dir: %s", dir)
+	}
+	return nil
+}
+
+// writeFileSync writes data into a temp file, syncs it and renames it to path so that a crash never
+// leaves a partially written file at path.
+func writeFileSync(path string, data []byte) error {
+	tmpPath := path + tmpFileSuffix
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return utils.StackError(err, "Failed to open file: %s for write", tmpPath)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return utils.StackError(err, "Failed to write file: %s", path)
+	}
 	return nil
 }
 
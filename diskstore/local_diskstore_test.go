@@ -523,6 +523,27 @@ var _ = ginkgo.Describe("DiskStore", func() {
 		Ω(len(dirs)).Should(Equal(0))
 	})
 
+	ginkgo.It("Test DeleteBatchVersion for LocalDiskstore", func() {
+		l := NewLocalDiskStore(prefix)
+		batchIDSinceEpoch := 6742
+		for _, batchVersion := range []uint32{1, 2} {
+			writeCloser, err := l.OpenVectorPartyFileForWrite(table, 0, shard, batchIDSinceEpoch, batchVersion, 0)
+			Ω(err).Should(BeNil())
+			Ω(writeCloser.Close()).Should(BeNil())
+		}
+
+		Ω(l.DeleteBatchVersion(table, shard, batchIDSinceEpoch, 2, 0)).Should(BeNil())
+		columns, err := l.ListArchiveBatchVectorPartyFiles(table, shard, batchIDSinceEpoch, 2, 0)
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(BeEmpty())
+		columns, err = l.ListArchiveBatchVectorPartyFiles(table, shard, batchIDSinceEpoch, 1, 0)
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(Equal([]int{0}))
+
+		// deleting non existing batch version is a no-op.
+		Ω(l.DeleteBatchVersion(table, shard, batchIDSinceEpoch, 3, 0)).Should(BeNil())
+	})
+
 	ginkgo.It("Test DeleteBatches with batchIDCutoff for LocalDiskstore", func() {
 		l := NewLocalDiskStore(prefix)
 		// Setup directory
@@ -623,7 +644,8 @@ var _ = ginkgo.Describe("DiskStore", func() {
 				versionedBatchDir := GetPathForTableArchiveBatchDir(prefix, table, shard, batchID, versionID, seqNum)
 				columnFiles, err := ioutil.ReadDir(versionedBatchDir)
 				Ω(err).Should(BeNil())
-				Ω(len(columnFiles)).Should(Equal(10 - i - 1))
+				// each column file comes with a checksum file.
+				Ω(len(columnFiles)).Should(Equal(2 * (10 - i - 1)))
 				columnFilePath := GetPathForTableArchiveBatchColumnFile(prefix, table, shard, batchID, versionID, seqNum, columnID)
 				_, err = os.Stat(columnFilePath)
 				Ω(err).ShouldNot(BeNil())
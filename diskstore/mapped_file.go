@@ -0,0 +1,58 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import "errors"
+
+// ErrMmapNotSupported is returned when memory mapped reads are disabled or not supported.
+var ErrMmapNotSupported = errors.New("mmap reads not supported")
+
+// MappedFile is a file memory mapped read only. The mapping stays valid after the file is
+// deleted or replaced by rename, until the MappedFile is closed.
+type MappedFile struct {
+	data []byte
+	path string
+}
+
+// Path returns the path of the mapped file.
+func (m *MappedFile) Path() string {
+	return m.path
+}
+
+// Bytes returns the mapped content of the file, which must not be modified or accessed
+// after Close.
+func (m *MappedFile) Bytes() []byte {
+	return m.data
+}
+
+// Close unmaps the file.
+func (m *MappedFile) Close() error {
+	if m == nil || m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
+
+// VectorPartyFileMapper is implemented by disk stores supporting memory mapped reads of
+// archive vector party files.
+type VectorPartyFileMapper interface {
+	// Maps the vector party file at the specified batchVersion read only into memory.
+	// Returns ErrMmapNotSupported if mmap reads are not supported and os.ErrNotExist if
+	// the file does not exist.
+	MapVectorPartyFileForRead(table string, columnID int, shard, batchID int, batchVersion uint32,
+		seqNum uint32) (*MappedFile, error)
+}
@@ -0,0 +1,98 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("MappedFile", func() {
+	prefix := "/tmp/testDiskStoreMappedFileSuite"
+	table := "myTable"
+	shard := 1
+	batchID := 17800
+	batchVersion := uint32(1499971253)
+	columnID := 2
+
+	var l LocalDiskStore
+
+	writeFile := func(data []byte) {
+		writer, err := l.OpenVectorPartyFileForWrite(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		_, err = writer.Write(data)
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+	}
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		os.MkdirAll(prefix, 0755)
+		l = LocalDiskStore{
+			rootPath: prefix,
+			diskStoreConfig: common.DiskStoreConfig{
+				MmapReads: true,
+			},
+		}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	ginkgo.It("maps vector party files and keeps mapping valid after rewrite", func() {
+		writeFile([]byte("0123456789"))
+		mappedFile, err := l.MapVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		Ω(mappedFile.Bytes()).Should(Equal([]byte("0123456789")))
+
+		writeFile([]byte("abcdefghij"))
+		Ω(mappedFile.Bytes()).Should(Equal([]byte("0123456789")))
+
+		newMappedFile, err := l.MapVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		Ω(newMappedFile.Bytes()).Should(Equal([]byte("abcdefghij")))
+
+		Ω(mappedFile.Close()).Should(BeNil())
+		Ω(mappedFile.Close()).Should(BeNil())
+		Ω(newMappedFile.Close()).Should(BeNil())
+	})
+
+	ginkgo.It("maps empty files", func() {
+		writeFile([]byte{})
+		mappedFile, err := l.MapVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		Ω(mappedFile.Bytes()).Should(BeEmpty())
+		Ω(mappedFile.Close()).Should(BeNil())
+	})
+
+	ginkgo.It("returns os.ErrNotExist for missing files", func() {
+		_, err := l.MapVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(Equal(os.ErrNotExist))
+	})
+
+	ginkgo.It("returns ErrMmapNotSupported when disabled", func() {
+		writeFile([]byte("0123456789"))
+		l.diskStoreConfig.MmapReads = false
+		_, err := l.MapVectorPartyFileForRead(table, columnID, shard, batchID, batchVersion, 0)
+		Ω(err).Should(Equal(ErrMmapNotSupported))
+
+		var nilMappedFile *MappedFile
+		Ω(nilMappedFile.Close()).Should(BeNil())
+	})
+})
@@ -2,6 +2,7 @@
 
 package mocks
 
+import diskstore "github.com/uber/aresdb/diskstore"
 import io "io"
 import mock "github.com/stretchr/testify/mock"
 import utils "github.com/uber/aresdb/utils"
@@ -11,6 +12,34 @@ type DiskStore struct {
 	mock.Mock
 }
 
+// CommitBatchVersion provides a mock function with given fields: table, shard, batchID, batchVersion, seqNum, manifest
+func (_m *DiskStore) CommitBatchVersion(table string, shard int, batchID int, batchVersion uint32, seqNum uint32, manifest diskstore.BatchVersionManifest) error {
+	ret := _m.Called(table, shard, batchID, batchVersion, seqNum, manifest)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, int, uint32, uint32, diskstore.BatchVersionManifest) error); ok {
+		r0 = rf(table, shard, batchID, batchVersion, seqNum, manifest)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteBatchVersion provides a mock function with given fields: table, shard, batchID, batchVersion, seqNum
+func (_m *DiskStore) DeleteBatchVersion(table string, shard int, batchID int, batchVersion uint32, seqNum uint32) error {
+	ret := _m.Called(table, shard, batchID, batchVersion, seqNum)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, int, uint32, uint32) error); ok {
+		r0 = rf(table, shard, batchID, batchVersion, seqNum)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteBatchVersions provides a mock function with given fields: table, shard, batchID, batchVersion, seqNum
 func (_m *DiskStore) DeleteBatchVersions(table string, shard int, batchID int, batchVersion uint32, seqNum uint32) error {
 	ret := _m.Called(table, shard, batchID, batchVersion, seqNum)
@@ -125,6 +154,29 @@ func (_m *DiskStore) ListArchiveBatchVectorPartyFiles(table string, shard int, b
 	return r0, r1
 }
 
+// ListBatchVersions provides a mock function with given fields: table, shard
+func (_m *DiskStore) ListBatchVersions(table string, shard int) ([]diskstore.BatchVersion, error) {
+	ret := _m.Called(table, shard)
+
+	var r0 []diskstore.BatchVersion
+	if rf, ok := ret.Get(0).(func(string, int) []diskstore.BatchVersion); ok {
+		r0 = rf(table, shard)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]diskstore.BatchVersion)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(table, shard)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListLogFiles provides a mock function with given fields: table, shard
 func (_m *DiskStore) ListLogFiles(table string, shard int) ([]int64, error) {
 	ret := _m.Called(table, shard)
@@ -332,6 +384,29 @@ func (_m *DiskStore) OpenVectorPartyFileForWrite(table string, column int, shard
 	return r0, r1
 }
 
+// ReadBatchVersionManifest provides a mock function with given fields: table, shard, batchID, batchVersion, seqNum
+func (_m *DiskStore) ReadBatchVersionManifest(table string, shard int, batchID int, batchVersion uint32, seqNum uint32) (*diskstore.BatchVersionManifest, error) {
+	ret := _m.Called(table, shard, batchID, batchVersion, seqNum)
+
+	var r0 *diskstore.BatchVersionManifest
+	if rf, ok := ret.Get(0).(func(string, int, int, uint32, uint32) *diskstore.BatchVersionManifest); ok {
+		r0 = rf(table, shard, batchID, batchVersion, seqNum)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*diskstore.BatchVersionManifest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int, int, uint32, uint32) error); ok {
+		r1 = rf(table, shard, batchID, batchVersion, seqNum)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // TruncateLogFile provides a mock function with given fields: table, shard, creationTime, offset
 func (_m *DiskStore) TruncateLogFile(table string, shard int, creationTime int64, offset int64) error {
 	ret := _m.Called(table, shard, creationTime, offset)
@@ -345,3 +420,24 @@ func (_m *DiskStore) TruncateLogFile(table string, shard int, creationTime int64
 
 	return r0
 }
+
+// VerifyTableShard provides a mock function with given fields: table, shard, bytesPerSec
+func (_m *DiskStore) VerifyTableShard(table string, shard int, bytesPerSec int64) (int, error) {
+	ret := _m.Called(table, shard, bytesPerSec)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string, int, int64) int); ok {
+		r0 = rf(table, shard, bytesPerSec)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int, int64) error); ok {
+		r1 = rf(table, shard, bytesPerSec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
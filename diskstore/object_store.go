@@ -0,0 +1,100 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrObjectNotFound is returned by ObjectStore when the requested object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore defines the interface of a S3 compatible object store.
+type ObjectStore interface {
+	// Uploads the content of the reader as the object with the key. Large objects are uploaded in multiple parts.
+	Put(key string, reader io.ReadSeeker) error
+	// Opens the object with the key for read. Returns ErrObjectNotFound if the object does not exist.
+	Get(key string) (io.ReadCloser, error)
+	// Returns the sorted keys of all objects with the prefix.
+	List(prefix string) ([]string, error)
+	// Deletes objects with the keys, missing objects are ignored.
+	Delete(keys ...string) error
+}
+
+// MemoryObjectStore is an in memory implementation of ObjectStore, mainly for testing.
+type MemoryObjectStore struct {
+	sync.RWMutex
+	objects map[string][]byte
+}
+
+// NewMemoryObjectStore creates a new MemoryObjectStore.
+func NewMemoryObjectStore() *MemoryObjectStore {
+	return &MemoryObjectStore{
+		objects: make(map[string][]byte),
+	}
+}
+
+// Put uploads the content of the reader as the object with the key.
+func (s *MemoryObjectStore) Put(key string, reader io.ReadSeeker) error {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.objects[key] = content
+	s.Unlock()
+	return nil
+}
+
+// Get opens the object with the key for read.
+func (s *MemoryObjectStore) Get(key string) (io.ReadCloser, error) {
+	s.RLock()
+	content, ok := s.objects[key]
+	s.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// List returns the sorted keys of all objects with the prefix.
+func (s *MemoryObjectStore) List(prefix string) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete deletes objects with the keys.
+func (s *MemoryObjectStore) Delete(keys ...string) error {
+	s.Lock()
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	s.Unlock()
+	return nil
+}
@@ -0,0 +1,675 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	xerrors "github.com/m3db/m3/src/x/errors"
+	xretry "github.com/m3db/m3/src/x/retry"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// objectCache is the dir under root path to cache objects downloaded from object store.
+const objectCache string = "object_cache"
+
+// defaultObjectCacheSize is the default size of local object cache.
+const defaultObjectCacheSize int64 = 10 * 1024 * 1024 * 1024
+
+var vectorPartyFileNamePattern = regexp.MustCompile(`^([0-9]+)\.data$`)
+
+// RemoteDiskStore is the implementation of DiskStore that stores snapshot and archived vector party files
+// in an object store using the same logical layout as LocalDiskStore. Reads go through a local LRU file cache
+// and redo logs stay on local disk.
+type RemoteDiskStore struct {
+	// redo logs are handled by local disk store.
+	LocalDiskStore
+	objectStore ObjectStore
+	keyPrefix   string
+	cache       *fileCache
+	retrier     xretry.Retrier
+}
+
+// NewRemoteDiskStore creates a RemoteDiskStore with the object store.
+func NewRemoteDiskStore(rootPath string, cfg common.DiskStoreConfig, objectStore ObjectStore) (DiskStore, error) {
+	cacheSize := cfg.ObjectStore.CacheSizeBytes
+	if cacheSize <= 0 {
+		cacheSize = defaultObjectCacheSize
+	}
+	cache, err := newFileCache(filepath.Join(rootPath, objectCache), cacheSize)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to init object cache under %s", rootPath)
+	}
+
+	retryOptions := xretry.NewOptions()
+	if cfg.ObjectStore.MaxRetries > 0 {
+		retryOptions = retryOptions.SetMaxRetries(cfg.ObjectStore.MaxRetries)
+	}
+
+	return &RemoteDiskStore{
+		LocalDiskStore: LocalDiskStore{
+			rootPath:        rootPath,
+			diskStoreConfig: cfg,
+		},
+		objectStore: objectStore,
+		keyPrefix:   cfg.ObjectStore.Prefix,
+		cache:       cache,
+		retrier:     xretry.NewRetrier(retryOptions),
+	}, nil
+}
+
+// key returns the object key of a path relative to root path.
+func (r *RemoteDiskStore) key(relativePath string) string {
+	return path.Join(r.keyPrefix, filepath.ToSlash(relativePath))
+}
+
+// dirKey returns the key prefix of all objects under a dir relative to root path.
+func (r *RemoteDiskStore) dirKey(relativePath string) string {
+	return r.key(relativePath) + "/"
+}
+
+// Table shard level operation
+
+// DeleteTableShard : Completely wipe out a table shard.
+func (r *RemoteDiskStore) DeleteTableShard(table string, shard int) error {
+	if err := r.LocalDiskStore.DeleteTableShard(table, shard); err != nil {
+		return err
+	}
+	prefix := r.dirKey(getPathForTableShard("", table, shard))
+	keys, err := r.list(prefix)
+	if err != nil {
+		return err
+	}
+	r.cache.removePrefix(prefix)
+	return r.delete(keys...)
+}
+
+// Snapshot files.
+
+// ListSnapshotBatches : Returns the batch directories at the specified version.
+func (r *RemoteDiskStore) ListSnapshotBatches(table string, shard int,
+	redoLogFile int64, offset uint32) (batches []int, err error) {
+	dirs, err := r.listDirs(r.dirKey(GetPathForTableSnapshotDirPath("", table, shard, redoLogFile, offset)))
+	if err != nil {
+		return nil, err
+	}
+	for dir := range dirs {
+		batch, err := strconv.ParseInt(dir, 10, 32)
+		if err != nil {
+			utils.GetLogger().With("err", err, "batch_dir_name", dir).
+				Debug("Find invalid snapshot batch dir")
+			continue
+		}
+		batches = append(batches, int(batch))
+	}
+	sort.Ints(batches)
+	return batches, nil
+}
+
+// ListSnapshotVectorPartyFiles : Returns the vector party files under specific batch directory.
+func (r *RemoteDiskStore) ListSnapshotVectorPartyFiles(table string, shard int,
+	redoLogFile int64, offset uint32, batchID int) ([]int, error) {
+	return r.listVectorPartyFiles(r.dirKey(GetPathForTableSnapshotBatchDir("", table, shard, redoLogFile, offset,
+		batchID)))
+}
+
+// OpenSnapshotVectorPartyFileForRead : Opens the snapshot file for read at the specified version.
+func (r *RemoteDiskStore) OpenSnapshotVectorPartyFileForRead(table string, shard int,
+	redoLogFile int64, offset uint32, batchID int, columnID int) (io.ReadCloser, error) {
+	return r.openForRead(table, shard,
+		r.key(GetPathForTableSnapshotColumnFilePath("", table, shard, redoLogFile, offset, batchID, columnID)))
+}
+
+// OpenSnapshotVectorPartyFileForWrite : Creates/truncates the snapshot file for write at the specified version.
+func (r *RemoteDiskStore) OpenSnapshotVectorPartyFileForWrite(table string, shard int,
+	redoLogFile int64, offset uint32, batchID int, columnID int) (io.WriteCloser, error) {
+	return r.openForWrite(
+		r.key(GetPathForTableSnapshotColumnFilePath("", table, shard, redoLogFile, offset, batchID, columnID)))
+}
+
+// DeleteSnapshot : Deletes snapshot directories **older than** the specified version (redolog file and offset).
+func (r *RemoteDiskStore) DeleteSnapshot(table string, shard int, latestRedoLogFile int64, latestOffset uint32) error {
+	prefix := r.dirKey(GetPathForTableSnapshotDir("", table, shard))
+	dirs, err := r.listDirs(prefix)
+	if err != nil {
+		return err
+	}
+
+	var keysToDelete []string
+	for dir, keys := range dirs {
+		var redoLogFile int64
+		var offset uint32
+		if _, err := fmt.Sscanf(dir, "%d_%d", &redoLogFile, &offset); err != nil {
+			utils.GetLogger().Debugf("Failed to parse snapshot dir: %s, will continue", dir)
+			continue
+		}
+
+		if redoLogFile < latestRedoLogFile || (redoLogFile == latestRedoLogFile && offset < latestOffset) {
+			utils.GetLogger().With(
+				"action", "delete_snapshot",
+				"redoLog", latestRedoLogFile,
+				"offset", latestOffset).Infof("delete snapshot: %s", prefix+dir)
+			r.cache.removePrefix(prefix + dir + "/")
+			keysToDelete = append(keysToDelete, keys...)
+		}
+	}
+	return r.delete(keysToDelete...)
+}
+
+// Archived vector party files.
+
+// ListArchiveBatchVectorPartyFiles return all vp for one batch version/seq
+func (r *RemoteDiskStore) ListArchiveBatchVectorPartyFiles(table string, shard, batchID int,
+	batchVersion uint32, seqNum uint32) ([]int, error) {
+	return r.listVectorPartyFiles(r.dirKey(GetPathForTableArchiveBatchDir("", table, shard,
+		daysSinceEpochToTimeStr(batchID), batchVersion, seqNum)))
+}
+
+// OpenVectorPartyFileForRead : Opens the vector party file at the specified batchVersion for read.
+func (r *RemoteDiskStore) OpenVectorPartyFileForRead(table string, columnID int, shard, batchID int,
+	batchVersion uint32, seqNum uint32) (io.ReadCloser, error) {
+	return r.openForRead(table, shard, r.key(GetPathForTableArchiveBatchColumnFile("", table, shard,
+		daysSinceEpochToTimeStr(batchID), batchVersion, seqNum, columnID)))
+}
+
+// OpenVectorPartyFileForWrite : Creates/truncates the vector party file at the specified batchVersion for write.
+func (r *RemoteDiskStore) OpenVectorPartyFileForWrite(table string, columnID int, shard, batchID int,
+	batchVersion uint32, seqNum uint32) (io.WriteCloser, error) {
+	return r.openForWrite(r.key(GetPathForTableArchiveBatchColumnFile("", table, shard,
+		daysSinceEpochToTimeStr(batchID), batchVersion, seqNum, columnID)))
+}
+
+// DeleteBatchVersions deletes all old batches with the specified batchID that have version lower than or equal to
+// the specified batch  version. All columns of those batches will be deleted.
+func (r *RemoteDiskStore) DeleteBatchVersions(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) error {
+	batchIDTimeStr := daysSinceEpochToTimeStr(batchID)
+	prefix := r.dirKey(GetPathForTableArchiveBatchRootDir("", table, shard))
+	dirs, err := r.listDirs(prefix)
+	if err != nil {
+		return err
+	}
+
+	var keysToDelete []string
+	for dir, keys := range dirs {
+		oldBatchID, oldBatchVersion, oldSeqNum, err := ParseBatchIDAndVersionName(dir)
+		if err != nil || oldBatchID != batchIDTimeStr {
+			continue
+		}
+		if oldBatchVersion < batchVersion || (oldBatchVersion == batchVersion && oldSeqNum <= seqNum) {
+			r.cache.removePrefix(prefix + dir + "/")
+			keysToDelete = append(keysToDelete, keys...)
+		}
+	}
+	return r.delete(keysToDelete...)
+}
+
+// DeleteBatchVersion deletes the batch with the specified batchID, batch version and seq num.
+func (r *RemoteDiskStore) DeleteBatchVersion(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) error {
+	prefix := r.dirKey(GetPathForTableArchiveBatchDir("", table, shard, daysSinceEpochToTimeStr(batchID),
+		batchVersion, seqNum))
+	keys, err := r.list(prefix)
+	if err != nil {
+		return err
+	}
+	r.cache.removePrefix(prefix)
+	return r.delete(keys...)
+}
+
+// CommitBatchVersion commits the batch version by uploading its manifest. Column files are already durable in
+// object store once their writers are closed.
+func (r *RemoteDiskStore) CommitBatchVersion(table string, shard, batchID int, batchVersion uint32, seqNum uint32,
+	manifest BatchVersionManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal batch version manifest")
+	}
+	writer, err := r.openForWrite(r.batchVersionManifestKey(table, shard, batchID, batchVersion, seqNum))
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(data); err != nil {
+		writer.Close()
+		return utils.StackError(err, "Failed to write batch version manifest")
+	}
+	return writer.Close()
+}
+
+// ReadBatchVersionManifest reads the manifest of the batch version. Returns os.ErrNotExist if the batch version
+// is not committed.
+func (r *RemoteDiskStore) ReadBatchVersionManifest(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) (*BatchVersionManifest, error) {
+	key := r.batchVersionManifestKey(table, shard, batchID, batchVersion, seqNum)
+	reader, err := r.openForRead(table, shard, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to read batch version manifest: %s", key)
+	}
+	return unmarshalBatchVersionManifest(data, key)
+}
+
+// ListBatchVersions returns all archive batch versions in object store sorted by batch id and then from old to new.
+func (r *RemoteDiskStore) ListBatchVersions(table string, shard int) ([]BatchVersion, error) {
+	dirs, err := r.listDirs(r.dirKey(GetPathForTableArchiveBatchRootDir("", table, shard)))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []BatchVersion
+	for dir := range dirs {
+		if version, ok := parseBatchVersion(dir); ok {
+			versions = append(versions, version)
+		}
+	}
+	sortBatchVersions(versions)
+	return versions, nil
+}
+
+func (r *RemoteDiskStore) batchVersionManifestKey(table string, shard, batchID int, batchVersion uint32,
+	seqNum uint32) string {
+	return r.key(filepath.Join(GetPathForTableArchiveBatchDir("", table, shard, daysSinceEpochToTimeStr(batchID),
+		batchVersion, seqNum), batchVersionManifestFile))
+}
+
+// DeleteBatches : Deletes all batches within [batchIDStart, batchIDEnd)
+func (r *RemoteDiskStore) DeleteBatches(table string, shard, batchIDStart, batchIDEnd int) (int, error) {
+	batchIDStartTime := daysSinceEpochToTime(batchIDStart)
+	batchIDEndTime := daysSinceEpochToTime(batchIDEnd)
+	prefix := r.dirKey(GetPathForTableArchiveBatchRootDir("", table, shard))
+	dirs, err := r.listDirs(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	numBatches := 0
+	for dir, keys := range dirs {
+		batchID, _, _, _ := ParseBatchIDAndVersionName(dir)
+		batchIDTime, err := time.Parse(timeFormatForBatchID, batchID)
+		if err != nil {
+			utils.GetLogger().Debugf("Failed to parse batchID: %s to yyyy-MM-dd format time", batchID)
+			continue
+		}
+		batchIDTime = batchIDTime.UTC()
+		if !batchIDTime.Before(batchIDStartTime) && batchIDTime.Before(batchIDEndTime) {
+			r.cache.removePrefix(prefix + dir + "/")
+			if err := r.delete(keys...); err != nil {
+				utils.GetLogger().Debugf("Failed to delete archive batch dir: %s", prefix+dir)
+			} else {
+				numBatches++
+			}
+		}
+	}
+	return numBatches, nil
+}
+
+// DeleteColumn : Deletes all batches of the specified column.
+func (r *RemoteDiskStore) DeleteColumn(table string, columnID int, shard int) error {
+	keys, err := r.list(r.dirKey(GetPathForTableArchiveBatchRootDir("", table, shard)))
+	if err != nil {
+		return err
+	}
+
+	fileName := fmt.Sprintf("%d.data", columnID)
+	var keysToDelete []string
+	for _, key := range keys {
+		switch path.Base(key) {
+		case fileName:
+			r.cache.remove(key)
+			keysToDelete = append(keysToDelete, key)
+		case fileName + checksumFileSuffix:
+			keysToDelete = append(keysToDelete, key)
+		}
+	}
+
+	if err = r.delete(keysToDelete...); err != nil {
+		utils.GetLogger().With(
+			"table", table,
+			"column", columnID,
+			"err", err,
+		).Warn("Failed to delete vector party files")
+	}
+	return nil
+}
+
+// VerifyTableShard verifies all snapshot and archive batch objects of a table shard against their checksums.
+// Objects are downloaded into a temp dir instead of the cache so that verification does not evict hot data.
+func (r *RemoteDiskStore) VerifyTableShard(table string, shard int, bytesPerSec int64) (int, error) {
+	var keys []string
+	for _, dir := range []string{
+		GetPathForTableSnapshotDir("", table, shard),
+		GetPathForTableArchiveBatchRootDir("", table, shard),
+	} {
+		dirKeys, err := r.list(r.dirKey(dir))
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range dirKeys {
+			if strings.HasSuffix(key, ".data") {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir(r.cache.rootDir, "verify")
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to create temp dir for verification")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var limiter func(bytes int)
+	if bytesPerSec > 0 {
+		limiter = func(bytes int) {
+			time.Sleep(time.Duration(int64(bytes) * int64(time.Second) / bytesPerSec))
+		}
+	}
+
+	numCorrupted := 0
+	localPath := filepath.Join(tmpDir, "vp.data")
+	for _, key := range keys {
+		if _, err := r.download(key, localPath); err != nil {
+			if os.IsNotExist(err) {
+				// object got deleted during verification.
+				continue
+			}
+			return numCorrupted, err
+		}
+
+		_, err := verifyFile(localPath, limiter)
+		if IsCorruptionError(err) {
+			numCorrupted++
+			r.quarantineObject(table, shard, key, localPath, err)
+		} else if err != nil {
+			return numCorrupted, err
+		}
+	}
+	return numCorrupted, nil
+}
+
+// MapVectorPartyFileForRead is not supported since cached files can be evicted at any time.
+func (r *RemoteDiskStore) MapVectorPartyFileForRead(table string, columnID int, shard, batchID int,
+	batchVersion uint32, seqNum uint32) (*MappedFile, error) {
+	return nil, ErrMmapNotSupported
+}
+
+// OnDiskSpaceLevelChange releases local disk space by evicting half of the cache when free space
+// is low. Evicted files can always be downloaded again from the object store.
+func (r *RemoteDiskStore) OnDiskSpaceLevelChange(level DiskSpaceLevel) {
+	if level >= DiskSpaceLow {
+		r.cache.shrink(r.cache.capacity / 2)
+	}
+}
+
+// openForRead makes sure the object is in local cache, verifies it and opens the cached file for read.
+func (r *RemoteDiskStore) openForRead(table string, shard int, key string) (io.ReadCloser, error) {
+	localPath := r.cache.path(key)
+	// the entry is pinned until the file is opened, the opened file can still be read after eviction.
+	if !r.cache.pin(key) {
+		size, err := r.download(key, localPath)
+		if err != nil {
+			return nil, err
+		}
+		r.cache.addPinned(key, size)
+	}
+	defer r.cache.unpin(key)
+
+	if _, err := verifyFile(localPath, nil); err != nil {
+		if IsCorruptionError(err) {
+			r.quarantineObject(table, shard, key, localPath, err)
+			r.cache.remove(key)
+		}
+		return nil, err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to open cached file: %s for read", localPath)
+	}
+	return f, nil
+}
+
+// openForWrite creates the file in local cache for write. The file and its checksum file will be uploaded
+// to object store on close.
+func (r *RemoteDiskStore) openForWrite(key string) (io.WriteCloser, error) {
+	localPath := r.cache.path(key)
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, utils.StackError(err, "Failed to make dirs for path: %s", dir)
+	}
+
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if r.diskStoreConfig.WriteSync {
+		mode |= os.O_SYNC
+	}
+	f, err := os.OpenFile(localPath, mode, 0644)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to open file: %s for write", localPath)
+	}
+	return &objectWriter{
+		checksumWriter: newChecksumWriter(f, localPath, r.diskStoreConfig.ChecksumBlockSize),
+		diskStore:      r,
+		key:            key,
+	}, nil
+}
+
+// download downloads the object together with its checksum object into localPath.
+// Returns the total size of downloaded files, or os.ErrNotExist if the object does not exist.
+func (r *RemoteDiskStore) download(key, localPath string) (int64, error) {
+	size, err := r.downloadFile(key, localPath)
+	if err != nil {
+		return 0, err
+	}
+
+	checksumSize, err := r.downloadFile(getChecksumFilePath(key), getChecksumFilePath(localPath))
+	if os.IsNotExist(err) {
+		// files written by local disk store before checksum was introduced.
+		os.Remove(getChecksumFilePath(localPath))
+		return size, nil
+	}
+	return size + checksumSize, err
+}
+
+func (r *RemoteDiskStore) downloadFile(key, localPath string) (int64, error) {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, utils.StackError(err, "Failed to make dirs for path: %s", dir)
+	}
+
+	var size int64
+	err := r.retrier.Attempt(func() error {
+		reader, err := r.objectStore.Get(key)
+		if err == ErrObjectNotFound {
+			return xerrors.NewNonRetryableError(os.ErrNotExist)
+		} else if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		// download into a temp file first so that readers never see a partial file.
+		tmpFile, err := ioutil.TempFile(dir, filepath.Base(localPath))
+		if err != nil {
+			return xerrors.NewNonRetryableError(err)
+		}
+		size, err = io.Copy(tmpFile, reader)
+		if closeErr := tmpFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmpFile.Name(), localPath)
+		}
+		if err != nil {
+			os.Remove(tmpFile.Name())
+		}
+		return err
+	})
+
+	if err != nil {
+		err = xerrors.GetInnerNonRetryableError(err)
+		if err == os.ErrNotExist {
+			return 0, err
+		}
+		return 0, utils.StackError(err, "Failed to download object: %s", key)
+	}
+	return size, nil
+}
+
+// upload uploads the local file as the object with the key.
+func (r *RemoteDiskStore) upload(key, localPath string) error {
+	err := r.retrier.Attempt(func() error {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return xerrors.NewNonRetryableError(err)
+		}
+		defer f.Close()
+		return r.objectStore.Put(key, f)
+	})
+	if err != nil {
+		return utils.StackError(xerrors.GetInnerNonRetryableError(err), "Failed to upload object: %s", key)
+	}
+	return nil
+}
+
+func (r *RemoteDiskStore) list(prefix string) ([]string, error) {
+	var keys []string
+	err := r.retrier.Attempt(func() (err error) {
+		keys, err = r.objectStore.List(prefix)
+		return
+	})
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to list objects with prefix: %s", prefix)
+	}
+	return keys, nil
+}
+
+// listDirs lists objects under the prefix and groups them by the first level dir name.
+func (r *RemoteDiskStore) listDirs(prefix string) (map[string][]string, error) {
+	keys, err := r.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string][]string)
+	for _, key := range keys {
+		comps := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2)
+		if len(comps) == 2 {
+			dirs[comps[0]] = append(dirs[comps[0]], key)
+		}
+	}
+	return dirs, nil
+}
+
+func (r *RemoteDiskStore) listVectorPartyFiles(prefix string) (columnIDs []int, err error) {
+	keys, err := r.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if strings.HasSuffix(name, checksumFileSuffix) || name == batchVersionManifestFile {
+			continue
+		}
+		matches := vectorPartyFileNamePattern.FindStringSubmatch(name)
+		if matches == nil {
+			return nil, utils.StackError(nil, "Failed to parse file name: %s as "+
+				"valid vector party file name", name)
+		}
+		columnID, _ := strconv.Atoi(matches[1])
+		columnIDs = append(columnIDs, columnID)
+	}
+	sort.Ints(columnIDs)
+	return
+}
+
+func (r *RemoteDiskStore) delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	err := r.retrier.Attempt(func() error {
+		return r.objectStore.Delete(keys...)
+	})
+	if err != nil {
+		return utils.StackError(err, "Failed to delete %d objects", len(keys))
+	}
+	return nil
+}
+
+// quarantineObject moves a corrupted object together with its checksum object to the quarantine dir
+// of the table shard so that it will not be loaded again.
+func (r *RemoteDiskStore) quarantineObject(table string, shard int, key, localPath string, cause error) {
+	utils.GetReporter(table, shard).GetCounter(utils.DiskFileCorrupt).Inc(1)
+	logger := utils.GetLogger().With("action", "quarantine", "table", table, "shard", shard, "object", key,
+		"error", cause.Error())
+
+	tableShardPrefix := r.dirKey(getPathForTableShard("", table, shard))
+	quarantineKey := r.dirKey(GetPathForTableQuarantineDir("", table, shard)) +
+		strings.Replace(strings.TrimPrefix(key, tableShardPrefix), "/", "_", -1)
+
+	if err := r.upload(quarantineKey, localPath); err != nil {
+		logger.With("err", err).Error("Failed to quarantine corrupted object")
+		return
+	}
+	r.upload(getChecksumFilePath(quarantineKey), getChecksumFilePath(localPath))
+	if err := r.delete(key, getChecksumFilePath(key)); err != nil {
+		logger.With("err", err).Error("Failed to delete corrupted object")
+		return
+	}
+	logger.Errorf("Quarantined corrupted object to %s", quarantineKey)
+}
+
+// objectWriter writes the file into local cache and uploads it with its checksum file on close.
+type objectWriter struct {
+	*checksumWriter
+	diskStore *RemoteDiskStore
+	key       string
+}
+
+// Close closes the local file and uploads it to object store.
+func (w *objectWriter) Close() error {
+	err := w.checksumWriter.Close()
+	if err == nil {
+		// upload checksum first so that the data object is never visible without its checksum.
+		if err = w.diskStore.upload(getChecksumFilePath(w.key), getChecksumFilePath(w.path)); err == nil {
+			err = w.diskStore.upload(w.key, w.path)
+		}
+	}
+
+	if err != nil {
+		os.Remove(w.path)
+		os.Remove(getChecksumFilePath(w.path))
+		return err
+	}
+
+	size := int64(w.checksum.fileSize)
+	if info, statErr := os.Stat(getChecksumFilePath(w.path)); statErr == nil {
+		size += info.Size()
+	}
+	w.diskStore.cache.add(w.key, size)
+	return nil
+}
@@ -0,0 +1,287 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	xretry "github.com/m3db/m3/src/x/retry"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+// flakyObjectStore fails the first numFailures calls of each operation.
+type flakyObjectStore struct {
+	*MemoryObjectStore
+	numFailures int
+	calls       int
+}
+
+func (s *flakyObjectStore) fail() error {
+	s.calls++
+	if s.calls <= s.numFailures {
+		return errors.New("transient error")
+	}
+	return nil
+}
+
+func (s *flakyObjectStore) Put(key string, reader io.ReadSeeker) error {
+	if err := s.fail(); err != nil {
+		// consume the reader partially to make sure retries rewind it.
+		reader.Read(make([]byte, 1))
+		return err
+	}
+	return s.MemoryObjectStore.Put(key, reader)
+}
+
+func (s *flakyObjectStore) Get(key string) (io.ReadCloser, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.MemoryObjectStore.Get(key)
+}
+
+var _ = ginkgo.Describe("RemoteDiskStore", func() {
+	prefix := "/tmp/testRemoteDiskStoreSuite"
+	table := "myTable"
+	shard := 1
+	batchID := 17800
+	batchVersion := uint32(1499971253)
+
+	var objectStore *MemoryObjectStore
+	var r *RemoteDiskStore
+
+	writeData := func(writer io.WriteCloser, err error, data string) {
+		Ω(err).Should(BeNil())
+		_, err = writer.Write([]byte(data))
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+	}
+
+	writeFile := func(writer io.WriteCloser, err error) {
+		writeData(writer, err, "")
+	}
+
+	readFile := func(reader io.ReadCloser, err error) string {
+		Ω(err).Should(BeNil())
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		Ω(err).Should(BeNil())
+		return string(data)
+	}
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		objectStore = NewMemoryObjectStore()
+		ds, err := NewRemoteDiskStore(prefix, common.DiskStoreConfig{
+			ObjectStore: common.ObjectStoreConfig{
+				Prefix:         "ares",
+				CacheSizeBytes: 1 << 20,
+			},
+		}, objectStore)
+		Ω(err).Should(BeNil())
+		r = ds.(*RemoteDiskStore)
+		r.retrier = xretry.NewRetrier(xretry.NewOptions().SetInitialBackoff(time.Millisecond))
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	ginkgo.It("writes archive batch files as objects with the same layout", func() {
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0))
+		writeFile(r.OpenVectorPartyFileForWrite(table, 3, shard, batchID, batchVersion, 0))
+
+		keys, _ := objectStore.List("")
+		Ω(keys).Should(Equal([]string{
+			"ares/data/myTable_1/archiving_batches/2018-09-26_1499971253/1.data",
+			"ares/data/myTable_1/archiving_batches/2018-09-26_1499971253/1.data.crc",
+			"ares/data/myTable_1/archiving_batches/2018-09-26_1499971253/3.data",
+			"ares/data/myTable_1/archiving_batches/2018-09-26_1499971253/3.data.crc",
+		}))
+
+		columns, err := r.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion, 0)
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(Equal([]int{1, 3}))
+	})
+
+	ginkgo.It("reads through local cache", func() {
+		writer, err := r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0)
+		writeData(writer, err, "archive")
+		Ω(readFile(r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0))).Should(Equal("archive"))
+
+		// evicted from cache, should download from object store again.
+		r.cache.removePrefix("")
+		Ω(readFile(r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0))).Should(Equal("archive"))
+		Ω(r.cache.entries).Should(HaveKey(r.key(GetPathForTableArchiveBatchColumnFile("", table, shard, "2018-09-26",
+			batchVersion, 0, 1))))
+
+		_, err = r.OpenVectorPartyFileForRead(table, 2, shard, batchID, batchVersion, 0)
+		Ω(err).Should(Equal(os.ErrNotExist))
+	})
+
+	ginkgo.It("evicts least recently used files", func() {
+		r.cache.capacity = 1
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0))
+		writeFile(r.OpenVectorPartyFileForWrite(table, 2, shard, batchID, batchVersion, 0))
+		Ω(r.cache.lru.Len()).Should(Equal(1))
+		Ω(readFile(r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0))).Should(Equal(""))
+	})
+
+	ginkgo.It("does not evict pinned files", func() {
+		r.cache.capacity = 1
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0))
+		key := r.key(GetPathForTableArchiveBatchColumnFile("", table, shard, "2018-09-26", batchVersion, 0, 1))
+		Ω(r.cache.pin(key)).Should(BeTrue())
+		writeFile(r.OpenVectorPartyFileForWrite(table, 2, shard, batchID, batchVersion, 0))
+		Ω(r.cache.lru.Len()).Should(Equal(2))
+		_, err := os.Stat(r.cache.path(key))
+		Ω(err).Should(BeNil())
+
+		r.cache.unpin(key)
+		r.cache.shrink(0)
+		Ω(r.cache.lru.Len()).Should(Equal(0))
+	})
+
+	ginkgo.It("deletes removed files when they are unpinned", func() {
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0))
+		key := r.key(GetPathForTableArchiveBatchColumnFile("", table, shard, "2018-09-26", batchVersion, 0, 1))
+		Ω(r.cache.pin(key)).Should(BeTrue())
+		r.cache.removePrefix("")
+		_, err := os.Stat(r.cache.path(key))
+		Ω(err).Should(BeNil())
+		Ω(r.cache.pin(key)).Should(BeFalse())
+
+		r.cache.unpin(key)
+		Ω(r.cache.lru.Len()).Should(Equal(0))
+		_, err = os.Stat(r.cache.path(key))
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	ginkgo.It("keeps redologs on local disk", func() {
+		writeFile(r.OpenLogFileForAppend(table, shard, 1))
+		logs, err := r.ListLogFiles(table, shard)
+		Ω(err).Should(BeNil())
+		Ω(logs).Should(Equal([]int64{1}))
+		keys, _ := objectStore.List("")
+		Ω(keys).Should(BeEmpty())
+	})
+
+	ginkgo.It("snapshot operations should work", func() {
+		writeFile(r.OpenSnapshotVectorPartyFileForWrite(table, shard, 10, 5, 0, 1))
+		writeFile(r.OpenSnapshotVectorPartyFileForWrite(table, shard, 10, 5, 1, 1))
+		writeFile(r.OpenSnapshotVectorPartyFileForWrite(table, shard, 11, 1, 0, 2))
+
+		batches, err := r.ListSnapshotBatches(table, shard, 10, 5)
+		Ω(err).Should(BeNil())
+		Ω(batches).Should(Equal([]int{0, 1}))
+
+		columns, err := r.ListSnapshotVectorPartyFiles(table, shard, 11, 1, 0)
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(Equal([]int{2}))
+
+		Ω(r.DeleteSnapshot(table, shard, 11, 1)).Should(BeNil())
+		batches, err = r.ListSnapshotBatches(table, shard, 10, 5)
+		Ω(err).Should(BeNil())
+		Ω(batches).Should(BeEmpty())
+		_, err = r.OpenSnapshotVectorPartyFileForRead(table, shard, 10, 5, 0, 1)
+		Ω(err).Should(Equal(os.ErrNotExist))
+		Ω(readFile(r.OpenSnapshotVectorPartyFileForRead(table, shard, 11, 1, 0, 2))).Should(Equal(""))
+	})
+
+	ginkgo.It("archive batch deletions should work", func() {
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0))
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion+1, 0))
+		writeFile(r.OpenVectorPartyFileForWrite(table, 2, shard, batchID+1, batchVersion, 0))
+
+		Ω(r.DeleteBatchVersions(table, shard, batchID, batchVersion, 0)).Should(BeNil())
+		columns, _ := r.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion, 0)
+		Ω(columns).Should(BeEmpty())
+		columns, _ = r.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion+1, 0)
+		Ω(columns).Should(Equal([]int{1}))
+
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion+2, 0))
+		Ω(r.DeleteBatchVersion(table, shard, batchID, batchVersion+2, 0)).Should(BeNil())
+		columns, _ = r.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion+2, 0)
+		Ω(columns).Should(BeEmpty())
+		columns, _ = r.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion+1, 0)
+		Ω(columns).Should(Equal([]int{1}))
+
+		Ω(r.DeleteColumn(table, 1, shard)).Should(BeNil())
+		columns, _ = r.ListArchiveBatchVectorPartyFiles(table, shard, batchID, batchVersion+1, 0)
+		Ω(columns).Should(BeEmpty())
+
+		numBatches, err := r.DeleteBatches(table, shard, batchID, batchID+2)
+		Ω(err).Should(BeNil())
+		Ω(numBatches).Should(Equal(1))
+
+		Ω(r.DeleteTableShard(table, shard)).Should(BeNil())
+		keys, _ := objectStore.List("")
+		Ω(keys).Should(BeEmpty())
+	})
+
+	ginkgo.It("retries on transient errors", func() {
+		flaky := &flakyObjectStore{MemoryObjectStore: objectStore, numFailures: 2}
+		r.objectStore = flaky
+		writer, err := r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0)
+		writeData(writer, err, "archive")
+
+		r.cache.removePrefix("")
+		flaky.calls = 0
+		Ω(readFile(r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0))).Should(Equal("archive"))
+
+		flaky.calls = 0
+		flaky.numFailures = 10
+		r.cache.removePrefix("")
+		_, err = r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("verifies and quarantines corrupted objects", func() {
+		writeFile(r.OpenVectorPartyFileForWrite(table, 1, shard, batchID, batchVersion, 0))
+		numCorrupted, err := r.VerifyTableShard(table, shard, 0)
+		Ω(err).Should(BeNil())
+		Ω(numCorrupted).Should(Equal(0))
+
+		key := "ares/data/myTable_1/archiving_batches/2018-09-26_1499971253/1.data"
+		objectStore.objects[key] = []byte("corrupted")
+		r.cache.removePrefix("")
+		_, err = r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0)
+		Ω(IsCorruptionError(err)).Should(BeTrue())
+
+		keys, _ := objectStore.List("ares/data/myTable_1/quarantine/")
+		Ω(keys).Should(Equal([]string{
+			"ares/data/myTable_1/quarantine/archiving_batches_2018-09-26_1499971253_1.data",
+			"ares/data/myTable_1/quarantine/archiving_batches_2018-09-26_1499971253_1.data.crc",
+		}))
+		_, err = r.OpenVectorPartyFileForRead(table, 1, shard, batchID, batchVersion, 0)
+		Ω(err).Should(Equal(os.ErrNotExist))
+	})
+
+	ginkgo.It("NewDiskStore should create disk store by type", func() {
+		ds, err := NewDiskStore(prefix, common.DiskStoreConfig{})
+		Ω(err).Should(BeNil())
+		Ω(ds).Should(BeAssignableToTypeOf(instrumentedDiskStore{}))
+		Ω(ds.(instrumentedDiskStore).DiskStore).Should(BeAssignableToTypeOf(LocalDiskStore{}))
+
+		_, err = NewDiskStore(prefix, common.DiskStoreConfig{Type: "unknown"})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,139 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// maximum number of keys allowed in a single delete objects request.
+const s3MaxDeleteKeys = 1000
+
+// S3ObjectStore is the implementation of ObjectStore for S3 compatible object stores.
+type S3ObjectStore struct {
+	client   s3iface.S3API
+	uploader *s3manager.Uploader
+	bucket   string
+}
+
+// NewS3ObjectStore creates a S3ObjectStore. Credentials are resolved via the standard
+// provider chain: environment variables, shared credentials file and instance/task roles.
+func NewS3ObjectStore(cfg common.ObjectStoreConfig) (ObjectStore, error) {
+	awsConfig := aws.NewConfig()
+	if cfg.Region != "" {
+		awsConfig = awsConfig.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(cfg.Endpoint).WithS3ForcePathStyle(cfg.ForcePathStyle)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to create aws session")
+	}
+
+	client := s3.New(sess)
+	return &S3ObjectStore{
+		client: client,
+		uploader: s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+			if cfg.PartSizeBytes > 0 {
+				u.PartSize = cfg.PartSizeBytes
+			}
+		}),
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+// Put uploads the content of the reader as the object with the key. Objects larger than
+// part size are uploaded with multipart upload.
+func (s *S3ObjectStore) Put(key string, reader io.ReadSeeker) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   reader,
+	})
+	return err
+}
+
+// Get opens the object with the key for read.
+func (s *S3ObjectStore) Get(key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// List returns the sorted keys of all objects with the prefix.
+func (s *S3ObjectStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+// Delete deletes objects with the keys.
+func (s *S3ObjectStore) Delete(keys ...string) error {
+	for start := 0; start < len(keys); start += s3MaxDeleteKeys {
+		end := start + s3MaxDeleteKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		output, err := s.client.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			return utils.StackError(nil, "Failed to delete object %s: %s",
+				aws.StringValue(output.Errors[0].Key), aws.StringValue(output.Errors[0].Message))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// maxFinishedAdminJobs is the number of finished admin jobs kept for status lookup.
+const maxFinishedAdminJobs = 1000
+
+// conflictingJobTypes are the job types that must not be active on the same table shard
+// when a job of the key type is triggered.
+var conflictingJobTypes = map[common.JobType][]common.JobType{
+	common.ArchivingJobType: {common.ArchivingJobType, common.BackfillJobType, common.PurgeJobType},
+	common.BackfillJobType:  {common.BackfillJobType, common.ArchivingJobType},
+	common.SnapshotJobType:  {common.SnapshotJobType},
+	common.PurgeJobType:     {common.PurgeJobType, common.ArchivingJobType},
+}
+
+// AdminJobDetail represents status of a job triggered on demand by admin.
+type AdminJobDetail struct {
+	ID      string         `json:"id"`
+	Table   string         `json:"table"`
+	Shard   int            `json:"shard"`
+	JobType common.JobType `json:"jobType"`
+	// One of queued, running, succeeded and failed.
+	Status JobStatus `json:"status"`
+	Error  string    `json:"error,omitempty"`
+
+	SubmitTime time.Time `json:"submitTime"`
+	StartTime  time.Time `json:"startTime,omitempty"`
+	FinishTime time.Time `json:"finishTime,omitempty"`
+	// Duration of the run, excluding the time queued.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Number of records processed as reported by the job.
+	NumRecords int `json:"numRecords"`
+}
+
+// AdminJobManager submits jobs triggered on demand to the scheduler and tracks their status
+// by job id. Jobs conflicting with an active job on the same table shard are rejected.
+type AdminJobManager struct {
+	sync.RWMutex
+	memStore MemStore
+	// Prefix of job ids to tell apart ids of different processes.
+	idPrefix string
+	nextID   int64
+	jobs     map[string]*AdminJobDetail
+	// Job ids in submission order for evicting finished jobs.
+	jobIDs []string
+}
+
+// adminJob wraps the scheduler job to report its progress to AdminJobManager.
+type adminJob struct {
+	Job
+	id      string
+	table   string
+	shard   int
+	manager *AdminJobManager
+}
+
+// NewAdminJobManager creates a new AdminJobManager.
+func NewAdminJobManager(memStore MemStore) *AdminJobManager {
+	return &AdminJobManager{
+		memStore: memStore,
+		idPrefix: fmt.Sprintf("%x", utils.Now().UnixNano()),
+		jobs:     make(map[string]*AdminJobDetail),
+	}
+}
+
+// GetJob returns a copy of the detail of the job, false if the job is unknown.
+func (m *AdminJobManager) GetJob(id string) (AdminJobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	jobDetail, found := m.jobs[id]
+	if !found {
+		return AdminJobDetail{}, false
+	}
+	return *jobDetail, true
+}
+
+// GetJobs returns a copy of details of all known jobs in submission order.
+func (m *AdminJobManager) GetJobs() []AdminJobDetail {
+	m.RLock()
+	defer m.RUnlock()
+	jobDetails := make([]AdminJobDetail, 0, len(m.jobIDs))
+	for _, id := range m.jobIDs {
+		jobDetails = append(jobDetails, *m.jobs[id])
+	}
+	return jobDetails
+}
+
+func (m *AdminJobManager) reportJobDetail(id string, mutator func(jobDetail *AdminJobDetail)) {
+	m.Lock()
+	defer m.Unlock()
+	if jobDetail, found := m.jobs[id]; found {
+		mutator(jobDetail)
+	}
+}
+
+// Submit queues the job of the table shard to run in background and returns its detail. It
+// fails if the job type is disabled or a conflicting job is active on the same table shard,
+// either in the scheduler or triggered by admin.
+func (m *AdminJobManager) Submit(table string, shardID int, job Job) (AdminJobDetail, error) {
+	scheduler := m.memStore.GetScheduler()
+	jobType := job.JobType()
+	if !scheduler.IsJobTypeEnabled(jobType) {
+		return AdminJobDetail{}, utils.APIError{
+			Code:    http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("%s jobs are disabled", jobType),
+		}
+	}
+
+	m.Lock()
+	for _, conflictingType := range conflictingJobTypes[jobType] {
+		jobDetail, found := scheduler.GetJobDetail(table, shardID, conflictingType)
+		if found && (jobDetail.Status == JobRunning || jobDetail.Status == JobReady) {
+			m.Unlock()
+			return AdminJobDetail{}, utils.APIError{
+				Code: http.StatusConflict,
+				Message: fmt.Sprintf("Cannot start %s job: %s job of table %s shard %d is %s in scheduler",
+					jobType, conflictingType, table, shardID, jobDetail.Status),
+			}
+		}
+		for _, id := range m.jobIDs {
+			adminJobDetail := m.jobs[id]
+			if adminJobDetail.Table == table && adminJobDetail.Shard == shardID &&
+				adminJobDetail.JobType == conflictingType &&
+				(adminJobDetail.Status == JobQueued || adminJobDetail.Status == JobRunning) {
+				m.Unlock()
+				return AdminJobDetail{}, utils.APIError{
+					Code: http.StatusConflict,
+					Message: fmt.Sprintf("Cannot start %s job: %s job %s of table %s shard %d is %s",
+						jobType, conflictingType, id, table, shardID, adminJobDetail.Status),
+				}
+			}
+		}
+	}
+
+	m.nextID++
+	jobDetail := &AdminJobDetail{
+		ID:         fmt.Sprintf("%s-%d", m.idPrefix, m.nextID),
+		Table:      table,
+		Shard:      shardID,
+		JobType:    jobType,
+		Status:     JobQueued,
+		SubmitTime: utils.Now().UTC(),
+	}
+	m.jobs[jobDetail.ID] = jobDetail
+	m.jobIDs = append(m.jobIDs, jobDetail.ID)
+	m.evictFinishedJobs()
+	submitted := *jobDetail
+	m.Unlock()
+
+	go func() {
+		// Blocks until the scheduler executor picks up the job.
+		err, errChan := scheduler.SubmitJob(&adminJob{
+			Job:     job,
+			id:      submitted.ID,
+			table:   table,
+			shard:   shardID,
+			manager: m,
+		})
+		if err == nil {
+			err = <-errChan
+		} else {
+			m.finishJob(submitted.ID, err)
+		}
+		logger := utils.GetLogger().With("id", submitted.ID, "table", table, "shard", shardID, "jobType", jobType)
+		if err != nil {
+			logger.With("error", err.Error()).Error("Admin job failed")
+		} else {
+			logger.Info("Admin job succeeded")
+		}
+	}()
+	return submitted, nil
+}
+
+// finishJob marks the job as finished with the error.
+func (m *AdminJobManager) finishJob(id string, err error) {
+	m.reportJobDetail(id, func(jobDetail *AdminJobDetail) {
+		jobDetail.FinishTime = utils.Now().UTC()
+		if !jobDetail.StartTime.IsZero() {
+			jobDetail.Duration = jobDetail.FinishTime.Sub(jobDetail.StartTime)
+		}
+		if err != nil {
+			jobDetail.Status = JobFailed
+			jobDetail.Error = err.Error()
+		} else {
+			jobDetail.Status = JobSucceeded
+		}
+	})
+}
+
+// evictFinishedJobs removes the oldest finished jobs beyond maxFinishedAdminJobs. Caller
+// needs to hold the write lock.
+func (m *AdminJobManager) evictFinishedJobs() {
+	numFinished := 0
+	for _, id := range m.jobIDs {
+		if status := m.jobs[id].Status; status == JobSucceeded || status == JobFailed {
+			numFinished++
+		}
+	}
+
+	jobIDs := m.jobIDs[:0]
+	for _, id := range m.jobIDs {
+		if status := m.jobs[id].Status; numFinished > maxFinishedAdminJobs &&
+			(status == JobSucceeded || status == JobFailed) {
+			delete(m.jobs, id)
+			numFinished--
+			continue
+		}
+		jobIDs = append(jobIDs, id)
+	}
+	m.jobIDs = jobIDs
+}
+
+// Run marks the job as running, runs the wrapped job and reports its result. The number of
+// records processed is read from the scheduler job detail after a successful run, which is
+// not touched by other jobs since the scheduler runs one job at a time.
+func (j *adminJob) Run() error {
+	j.manager.reportJobDetail(j.id, func(jobDetail *AdminJobDetail) {
+		jobDetail.Status = JobRunning
+		jobDetail.StartTime = utils.Now().UTC()
+	})
+	err := j.Job.Run()
+	if err == nil {
+		if schedulerJobDetail, found := j.manager.memStore.GetScheduler().GetJobDetail(
+			j.table, j.shard, j.JobType()); found {
+			j.manager.reportJobDetail(j.id, func(jobDetail *AdminJobDetail) {
+				jobDetail.NumRecords = schedulerJobDetail.NumRecords
+			})
+		}
+	}
+	j.manager.finishJob(j.id, err)
+	return err
+}
+
+// String returns the string representation of the wrapped job with the admin job id.
+func (j *adminJob) String() string {
+	return fmt.Sprintf("%s (admin job %s)", j.Job.String(), j.id)
+}
@@ -0,0 +1,146 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+type adminTestJob struct {
+	jobType common.JobType
+	table   string
+	shardID int
+	jobFunc func() error
+}
+
+func (j *adminTestJob) Run() error {
+	return j.jobFunc()
+}
+
+func (j *adminTestJob) GetIdentifier() string {
+	return getIdentifier(j.table, j.shardID, j.jobType)
+}
+
+func (j *adminTestJob) String() string {
+	return j.GetIdentifier()
+}
+
+func (j *adminTestJob) JobType() common.JobType {
+	return j.jobType
+}
+
+var _ = ginkgo.Describe("admin jobs", func() {
+	var m *memStoreImpl
+	var scheduler *schedulerImpl
+	var manager *AdminJobManager
+
+	ginkgo.BeforeEach(func() {
+		m = GetFactory().NewMockMemStore()
+		scheduler = m.scheduler.(*schedulerImpl)
+		scheduler.Start()
+		manager = NewAdminJobManager(m)
+	})
+
+	ginkgo.AfterEach(func() {
+		scheduler.Stop()
+	})
+
+	ginkgo.It("should run jobs and report status", func() {
+		release := make(chan struct{})
+		job := &adminTestJob{jobType: common.ArchivingJobType, table: "t1", shardID: 0, jobFunc: func() error {
+			<-release
+			scheduler.reportJob(getIdentifier("t1", 0, common.ArchivingJobType), func(jobDetail *JobDetail) {
+				jobDetail.NumRecords = 10
+			})
+			return nil
+		}}
+		jobDetail, err := manager.Submit("t1", 0, job)
+		Ω(err).Should(BeNil())
+		Ω(jobDetail.ID).ShouldNot(BeEmpty())
+		Ω(jobDetail.Status).Should(Equal(JobQueued))
+
+		Eventually(func() JobStatus {
+			jobDetail, _ := manager.GetJob(jobDetail.ID)
+			return jobDetail.Status
+		}).Should(Equal(JobRunning))
+
+		// backfill on the same shard conflicts with the running archiving job.
+		_, err = manager.Submit("t1", 0, &adminTestJob{jobType: common.BackfillJobType, table: "t1", shardID: 0})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.(utils.APIError).Code).Should(Equal(http.StatusConflict))
+		Ω(err.Error()).Should(ContainSubstring("archiving job of table t1 shard 0 is running"))
+
+		close(release)
+		Eventually(func() JobStatus {
+			jobDetail, _ := manager.GetJob(jobDetail.ID)
+			return jobDetail.Status
+		}).Should(Equal(JobSucceeded))
+		jobDetail, _ = manager.GetJob(jobDetail.ID)
+		Ω(jobDetail.NumRecords).Should(Equal(10))
+		Ω(jobDetail.FinishTime.IsZero()).Should(BeFalse())
+
+		jobDetail, err = manager.Submit("t1", 0, &adminTestJob{jobType: common.BackfillJobType, table: "t1", shardID: 0,
+			jobFunc: func() error {
+				return errors.New("backfill failed")
+			}})
+		Ω(err).Should(BeNil())
+		Eventually(func() JobStatus {
+			jobDetail, _ := manager.GetJob(jobDetail.ID)
+			return jobDetail.Status
+		}).Should(Equal(JobFailed))
+		jobDetail, _ = manager.GetJob(jobDetail.ID)
+		Ω(jobDetail.Error).Should(Equal("backfill failed"))
+		Ω(manager.GetJobs()).Should(HaveLen(2))
+
+		_, found := manager.GetJob("unknown")
+		Ω(found).Should(BeFalse())
+	})
+
+	ginkgo.It("should reject jobs conflicting with scheduler", func() {
+		scheduler.reportJob(getIdentifier("t1", 0, common.BackfillJobType), func(jobDetail *JobDetail) {
+			jobDetail.Status = JobRunning
+		})
+		_, err := manager.Submit("t1", 0, &adminTestJob{jobType: common.ArchivingJobType, table: "t1", shardID: 0})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("backfill job of table t1 shard 0 is running in scheduler"))
+
+		// other shards and non conflicting job types are fine.
+		_, err = manager.Submit("t1", 1, &adminTestJob{jobType: common.ArchivingJobType, table: "t1", shardID: 1,
+			jobFunc: func() error { return nil }})
+		Ω(err).Should(BeNil())
+		_, err = manager.Submit("t1", 0, &adminTestJob{jobType: common.SnapshotJobType, table: "t1", shardID: 0,
+			jobFunc: func() error { return nil }})
+		Ω(err).Should(BeNil())
+
+		scheduler.EnableJobType(common.PurgeJobType, false)
+		_, err = manager.Submit("t1", 0, &adminTestJob{jobType: common.PurgeJobType, table: "t1", shardID: 0})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.(utils.APIError).Code).Should(Equal(http.StatusMethodNotAllowed))
+
+		Eventually(func() []JobStatus {
+			var statuses []JobStatus
+			for _, jobDetail := range manager.GetJobs() {
+				statuses = append(statuses, jobDetail.Status)
+			}
+			return statuses
+		}).Should(Equal([]JobStatus{JobSucceeded, JobSucceeded}))
+	})
+})
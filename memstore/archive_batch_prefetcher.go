@@ -0,0 +1,148 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"sync/atomic"
+
+	"github.com/uber/aresdb/memstore/common"
+)
+
+// ArchiveBatchPrefetcher issues read ahead of archive vector parties for batches that will be
+// processed in order, so that disk reads of upcoming batches overlap with the processing of the
+// current batch. Prefetched vector parties are pinned until the batch is processed or the
+// prefetcher is closed.
+//
+// The number of batches read ahead is bounded by maxBatches, and no more batches are read ahead
+// when pending vector party reads would exceed maxReads or host memory is over the limit.
+//
+// ArchiveBatchPrefetcher is not thread safe, it should be used by the query goroutine only.
+type ArchiveBatchPrefetcher struct {
+	version        *ArchiveStoreVersion
+	hostMemManager common.HostMemoryManager
+	columnIDs      []int
+	maxBatches     int
+	maxReads       int
+
+	// next batch to read ahead and end of batches (exclusive).
+	nextBatchID int32
+	endBatchID  int32
+
+	// pinned vector parties of batches read ahead, in batch id order.
+	prefetched map[int32][]common.ArchiveVectorParty
+	// number of vector party reads not finished yet, updated by waiting goroutines.
+	pendingReads int32
+
+	// Hits is the number of batches already read ahead when requested.
+	Hits int
+	// Misses is the number of non empty batches not read ahead when requested.
+	Misses int
+	// MaxPendingReads is the max number of pending vector party reads.
+	MaxPendingReads int
+}
+
+// NewArchiveBatchPrefetcher creates a prefetcher for batches [startBatchID, endBatchID) of the
+// archive store version. Caller must hold a user of the version until the prefetcher is closed.
+func NewArchiveBatchPrefetcher(version *ArchiveStoreVersion, hostMemManager common.HostMemoryManager,
+	startBatchID, endBatchID int32, columnIDs []int, maxBatches, maxReads int) *ArchiveBatchPrefetcher {
+	return &ArchiveBatchPrefetcher{
+		version:        version,
+		hostMemManager: hostMemManager,
+		columnIDs:      columnIDs,
+		maxBatches:     maxBatches,
+		maxReads:       maxReads,
+		nextBatchID:    startBatchID,
+		endBatchID:     endBatchID,
+		prefetched:     make(map[int32][]common.ArchiveVectorParty),
+	}
+}
+
+// Start should be called before processing batchID. It records whether the batch has been
+// read ahead and reads ahead the following batches.
+func (p *ArchiveBatchPrefetcher) Start(batchID int32) {
+	if _, ok := p.prefetched[batchID]; ok {
+		p.Hits++
+	} else if p.version.RequestBatch(batchID).Size > 0 {
+		p.Misses++
+	}
+
+	if p.nextBatchID <= batchID {
+		p.nextBatchID = batchID + 1
+	}
+	for p.nextBatchID < p.endBatchID && len(p.prefetched) < p.maxBatches {
+		pendingReads := int(atomic.LoadInt32(&p.pendingReads))
+		if pendingReads > 0 && pendingReads+len(p.columnIDs) > p.maxReads {
+			break
+		}
+		if p.hostMemManager != nil && p.hostMemManager.GetFreeSpace() <= 0 {
+			break
+		}
+		p.prefetch(p.nextBatchID)
+		p.nextBatchID++
+	}
+}
+
+// Done should be called after batchID is processed to release vector parties read ahead.
+func (p *ArchiveBatchPrefetcher) Done(batchID int32) {
+	vps, ok := p.prefetched[batchID]
+	if !ok {
+		return
+	}
+	delete(p.prefetched, batchID)
+	for _, vp := range vps {
+		vp.WaitForDiskLoad()
+		vp.Release()
+	}
+}
+
+// Close cancels outstanding read ahead. Pins are released asynchronously after pending reads
+// finish so that the caller is not blocked.
+func (p *ArchiveBatchPrefetcher) Close() {
+	p.nextBatchID = p.endBatchID
+	for batchID, vps := range p.prefetched {
+		delete(p.prefetched, batchID)
+		for _, vp := range vps {
+			go func(vp common.ArchiveVectorParty) {
+				vp.WaitForDiskLoad()
+				vp.Release()
+			}(vp)
+		}
+	}
+}
+
+// prefetch requests all vector parties of the batch, which starts loading them from disk
+// in background.
+func (p *ArchiveBatchPrefetcher) prefetch(batchID int32) {
+	batch := p.version.RequestBatch(batchID)
+	if batch.Size == 0 {
+		return
+	}
+
+	vps := make([]common.ArchiveVectorParty, 0, len(p.columnIDs))
+	for _, columnID := range p.columnIDs {
+		vp := batch.RequestVectorParty(columnID)
+		vps = append(vps, vp)
+
+		pendingReads := int(atomic.AddInt32(&p.pendingReads, 1))
+		if pendingReads > p.MaxPendingReads {
+			p.MaxPendingReads = pendingReads
+		}
+		go func() {
+			vp.WaitForDiskLoad()
+			atomic.AddInt32(&p.pendingReads, -1)
+		}()
+	}
+	p.prefetched[batchID] = vps
+}
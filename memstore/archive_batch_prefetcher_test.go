@@ -0,0 +1,159 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	diskMocks "github.com/uber/aresdb/diskstore/mocks"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+// slowDiskStore simulates reading vector party files from a cold disk.
+type slowDiskStore struct {
+	*diskMocks.DiskStore
+	readLatency time.Duration
+}
+
+func (s *slowDiskStore) OpenVectorPartyFileForRead(table string, column, shard, batchID int, batchVersion uint32,
+	seqNum uint32) (io.ReadCloser, error) {
+	time.Sleep(s.readLatency)
+	return nil, os.ErrNotExist
+}
+
+// createPrefetchTestArchiveStore creates an archive store version with numBatches batches with one column.
+func createPrefetchTestArchiveStore(numBatches int, hostMemManager memCom.HostMemoryManager,
+	readLatency time.Duration) *ArchiveStoreVersion {
+	shard := &TableShard{
+		Schema: &memCom.TableSchema{
+			Schema:            metaCom.Table{Name: "test"},
+			ValueTypeByColumn: []memCom.DataType{memCom.Uint32},
+			DefaultValues:     []*memCom.DataValue{&memCom.NullDataValue},
+		},
+		diskStore:         &slowDiskStore{DiskStore: &diskMocks.DiskStore{}, readLatency: readLatency},
+		HostMemoryManager: hostMemManager,
+	}
+	version := NewArchiveStoreVersion(100, shard)
+	for batchID := 0; batchID < numBatches; batchID++ {
+		version.Batches[int32(batchID)] = &ArchiveBatch{
+			Batch:   Batch{RWMutex: &sync.RWMutex{}},
+			Size:    10,
+			BatchID: int32(batchID),
+			Shard:   shard,
+		}
+	}
+	return version
+}
+
+// scanArchiveBatches reads column 0 of all batches in order like a query does.
+func scanArchiveBatches(version *ArchiveStoreVersion, numBatches int, prefetcher *ArchiveBatchPrefetcher,
+	processingLatency time.Duration) {
+	if prefetcher != nil {
+		defer prefetcher.Close()
+	}
+	for batchID := int32(0); batchID < int32(numBatches); batchID++ {
+		if prefetcher != nil {
+			prefetcher.Start(batchID)
+		}
+		vp := version.RequestBatch(batchID).RequestVectorParty(0)
+		vp.WaitForDiskLoad()
+		time.Sleep(processingLatency)
+		vp.Release()
+		if prefetcher != nil {
+			prefetcher.Done(batchID)
+		}
+	}
+}
+
+var _ = ginkgo.Describe("archive batch prefetcher", func() {
+	ginkgo.It("reads ahead batches and releases them", func() {
+		version := createPrefetchTestArchiveStore(5, &TestHostMemoryManager{}, 0)
+		version.Batches[2].Size = 0
+
+		prefetcher := NewArchiveBatchPrefetcher(version, &TestHostMemoryManager{}, 0, 5, []int{0}, 2, 32)
+		prefetcher.Start(0)
+		Ω(prefetcher.Misses).Should(Equal(1))
+		// batch 2 is empty so it is skipped.
+		Ω(prefetcher.prefetched).Should(HaveLen(2))
+		Ω(prefetcher.prefetched).Should(HaveKey(int32(1)))
+		Ω(prefetcher.prefetched).Should(HaveKey(int32(3)))
+		vp := version.Batches[1].Columns[0].(*archiveVectorParty)
+		Ω(vp.Pins).Should(Equal(1))
+		prefetcher.Done(0)
+
+		prefetcher.Start(1)
+		Ω(prefetcher.Hits).Should(Equal(1))
+		Ω(prefetcher.prefetched).Should(HaveLen(2))
+		Ω(prefetcher.prefetched).Should(HaveKey(int32(4)))
+		prefetcher.Done(1)
+		Ω(vp.Pins).Should(Equal(0))
+
+		prefetcher.Close()
+		Ω(prefetcher.prefetched).Should(BeEmpty())
+		Eventually(func() int {
+			version.Batches[3].RLock()
+			defer version.Batches[3].RUnlock()
+			return version.Batches[3].Columns[0].(*archiveVectorParty).Pins
+		}).Should(Equal(0))
+	})
+
+	ginkgo.It("stops reading ahead when over memory limit", func() {
+		hostMemManager := &memComMocks.HostMemoryManager{}
+		hostMemManager.On("GetFreeSpace").Return(int64(0))
+		version := createPrefetchTestArchiveStore(5, &TestHostMemoryManager{}, 0)
+		prefetcher := NewArchiveBatchPrefetcher(version, hostMemManager, 0, 5, []int{0}, 2, 32)
+		scanArchiveBatches(version, 5, prefetcher, 0)
+		Ω(prefetcher.Hits).Should(Equal(0))
+		Ω(prefetcher.Misses).Should(Equal(5))
+	})
+
+	ginkgo.It("bounds concurrent reads", func() {
+		version := createPrefetchTestArchiveStore(10, &TestHostMemoryManager{}, 10*time.Millisecond)
+		prefetcher := NewArchiveBatchPrefetcher(version, &TestHostMemoryManager{}, 0, 10, []int{0}, 8, 3)
+		scanArchiveBatches(version, 10, prefetcher, 0)
+		Ω(prefetcher.MaxPendingReads).Should(BeNumerically("<=", 3))
+		Ω(prefetcher.Hits + prefetcher.Misses).Should(Equal(10))
+	})
+})
+
+func benchmarkArchiveBatchScan(b *testing.B, maxBatches int) {
+	numBatches := 30
+	for i := 0; i < b.N; i++ {
+		// recreate batches so that every scan starts with a cold cache.
+		version := createPrefetchTestArchiveStore(numBatches, &TestHostMemoryManager{}, 2*time.Millisecond)
+		var prefetcher *ArchiveBatchPrefetcher
+		if maxBatches > 0 {
+			prefetcher = NewArchiveBatchPrefetcher(version, &TestHostMemoryManager{}, 0, int32(numBatches),
+				[]int{0}, maxBatches, 32)
+		}
+		scanArchiveBatches(version, numBatches, prefetcher, time.Millisecond)
+	}
+}
+
+func BenchmarkArchiveBatchScan_ColdNoPrefetch(b *testing.B) {
+	benchmarkArchiveBatchScan(b, 0)
+}
+
+func BenchmarkArchiveBatchScan_ColdPrefetch(b *testing.B) {
+	benchmarkArchiveBatchScan(b, 4)
+}
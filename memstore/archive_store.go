@@ -20,6 +20,7 @@ import (
 
 	"strconv"
 
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
 )
@@ -151,16 +152,66 @@ func (v *ArchiveStoreVersion) RequestBatch(batchID int32) *ArchiveBatch {
 }
 
 // WriteToDisk writes each column of a batch to disk. It happens on archiving
-// stage for merged archive batch so there is no need to lock it.
+// stage for merged archive batch so there is no need to lock it. Columns are written
+// concurrently if archive write workers are configured. All column files are synced to disk
+// and the batch version is committed on disk once it returns without error, otherwise files
+// already written are deleted. Callers must record the batch version in metastore before
+// deleting older versions, see diskstore.BatchVersion.
 func (b *ArchiveBatch) WriteToDisk() error {
-	for columnID, column := range b.Columns {
-		serializer := NewVectorPartyArchiveSerializer(
-			b.Shard.HostMemoryManager, b.Shard.diskStore, b.Shard.Schema.Schema.Name, b.Shard.ShardID, columnID, int(b.BatchID), b.Version, b.SeqNum)
-		if err := serializer.WriteVectorParty(column); err != nil {
-			return err
+	var err error
+	if workers := b.Shard.options.archiveWriteWorkers; workers != nil {
+		var wg sync.WaitGroup
+		errs := make([]error, len(b.Columns))
+		for columnID, column := range b.Columns {
+			columnID, column := columnID, column
+			wg.Add(1)
+			workers.Go(func() {
+				defer wg.Done()
+				errs[columnID] = b.writeColumnToDisk(columnID, column)
+			})
+		}
+		wg.Wait()
+		for _, columnErr := range errs {
+			if columnErr != nil {
+				err = columnErr
+				break
+			}
+		}
+	} else {
+		for columnID, column := range b.Columns {
+			if err = b.writeColumnToDisk(columnID, column); err != nil {
+				break
+			}
 		}
 	}
-	return nil
+
+	if err == nil {
+		manifest := diskstore.BatchVersionManifest{Size: b.Size, Columns: []int{}}
+		for columnID, column := range b.Columns {
+			if column != nil {
+				manifest.Columns = append(manifest.Columns, columnID)
+			}
+		}
+		err = b.Shard.diskStore.CommitBatchVersion(b.Shard.Schema.Schema.Name, b.Shard.ShardID,
+			int(b.BatchID), b.Version, b.SeqNum, manifest)
+	}
+
+	if err != nil {
+		if deleteErr := b.Shard.diskStore.DeleteBatchVersion(b.Shard.Schema.Schema.Name, b.Shard.ShardID,
+			int(b.BatchID), b.Version, b.SeqNum); deleteErr != nil {
+			utils.GetLogger().With("table", b.Shard.Schema.Schema.Name, "shard", b.Shard.ShardID,
+				"batch", b.BatchID, "version", b.Version, "seq", b.SeqNum, "error", deleteErr.Error()).
+				Error("Failed to delete partially written archive batch")
+		}
+	}
+	return err
+}
+
+// writeColumnToDisk writes a column of the batch to disk.
+func (b *ArchiveBatch) writeColumnToDisk(columnID int, column common.VectorParty) error {
+	serializer := NewVectorPartyArchiveSerializer(
+		b.Shard.HostMemoryManager, b.Shard.diskStore, b.Shard.Schema.Schema.Name, b.Shard.ShardID, columnID, int(b.BatchID), b.Version, b.SeqNum)
+	return serializer.WriteVectorParty(column)
 }
 
 // GetCurrentVersion returns current SortedVectorStoreVersion and does proper locking. It'v used by
@@ -197,6 +248,12 @@ func (v *ArchiveStoreVersion) MarshalJSON() ([]byte, error) {
 // Caller must call vp.WaitForDiskLoad() before using it,
 // and call vp.Release() afterwards.
 func (b *ArchiveBatch) RequestVectorParty(columnID int) common.ArchiveVectorParty {
+	return b.RequestVectorPartyForIO(columnID, diskstore.IOQueryRead)
+}
+
+// RequestVectorPartyForIO is the same as RequestVectorParty except that disk reads of the vector
+// party are recorded as the specified I/O operation.
+func (b *ArchiveBatch) RequestVectorPartyForIO(columnID int, op diskstore.IOOperation) common.ArchiveVectorParty {
 	b.Lock()
 	defer b.Unlock()
 
@@ -219,12 +276,15 @@ func (b *ArchiveBatch) RequestVectorParty(columnID int) common.ArchiveVectorPart
 	// columnID should always be smaller than len(ValueTypeByColumn).
 	dataType := b.Shard.Schema.ValueTypeByColumn[columnID]
 	defaultValue := b.Shard.Schema.DefaultValues[columnID]
-	vp = newArchiveVectorParty(b.Size, dataType, *defaultValue, b.RWMutex)
-	b.Columns[columnID] = vp
+	newVP := newArchiveVectorParty(b.Size, dataType, *defaultValue, b.RWMutex)
+	newVP.onCorruption = func(err error) {
+		b.Shard.handleCorruptedVectorParty(int(b.BatchID), columnID, err)
+	}
+	b.Columns[columnID] = newVP
 
-	archiveVP := vp.(common.ArchiveVectorParty)
+	archiveVP := common.ArchiveVectorParty(newVP)
 	archiveVP.Pin()
-	archiveVP.LoadFromDisk(b.Shard.HostMemoryManager, b.Shard.diskStore, b.Shard.Schema.Schema.Name, b.Shard.ShardID, columnID, int(b.BatchID), b.Version, b.SeqNum)
+	archiveVP.LoadFromDisk(b.Shard.HostMemoryManager, diskstore.WithIOReadOperation(b.Shard.diskStore, op), b.Shard.Schema.Schema.Name, b.Shard.ShardID, columnID, int(b.BatchID), b.Version, b.SeqNum)
 
 	return archiveVP
 }
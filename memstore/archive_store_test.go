@@ -15,6 +15,7 @@
 package memstore
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 
@@ -23,13 +24,90 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/diskstore"
 	diskStoreMocks "github.com/uber/aresdb/diskstore/mocks"
 	memCom "github.com/uber/aresdb/memstore/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
 	utilsMocks "github.com/uber/aresdb/utils/mocks"
+	"io"
 	"sync"
+	"testing"
+	"time"
 )
 
+// slowWriteDiskStore simulates writing vector party files to a disk with sync latency.
+type slowWriteDiskStore struct {
+	*diskStoreMocks.DiskStore
+	syncLatency time.Duration
+}
+
+type slowWriteCloser struct {
+	utils.ClosableBuffer
+	syncLatency time.Duration
+}
+
+func (w *slowWriteCloser) Close() error {
+	time.Sleep(w.syncLatency)
+	return nil
+}
+
+func (s *slowWriteDiskStore) OpenVectorPartyFileForWrite(table string, column, shard, batchID int, batchVersion uint32,
+	seqNum uint32) (io.WriteCloser, error) {
+	return &slowWriteCloser{ClosableBuffer: utils.ClosableBuffer{Buffer: &bytes.Buffer{}}, syncLatency: s.syncLatency}, nil
+}
+
+func (s *slowWriteDiskStore) CommitBatchVersion(table string, shard, batchID int, batchVersion uint32, seqNum uint32,
+	manifest diskstore.BatchVersionManifest) error {
+	time.Sleep(s.syncLatency)
+	return nil
+}
+
+// createWideArchiveBatch creates an archive batch with numColumns uint32 columns.
+func createWideArchiveBatch(table string, ds diskstore.DiskStore, numColumns int) *ArchiveBatch {
+	batch := &ArchiveBatch{
+		Batch:   Batch{RWMutex: &sync.RWMutex{}},
+		Version: 100,
+		Shard: &TableShard{
+			diskStore: ds,
+			Schema: &memCom.TableSchema{
+				Schema: metaCom.Table{
+					Name: table,
+				},
+			},
+		},
+	}
+	for columnID := 0; columnID < numColumns; columnID++ {
+		batch.Columns = append(batch.Columns, &archiveVectorParty{
+			cVectorParty: cVectorParty{
+				baseVectorParty: baseVectorParty{
+					dataType: memCom.Uint32}}})
+	}
+	return batch
+}
+
+func benchmarkWideArchiveBatchWrite(b *testing.B, concurrency int) {
+	batch := createWideArchiveBatch("table1", &slowWriteDiskStore{
+		DiskStore:   &diskStoreMocks.DiskStore{},
+		syncLatency: time.Millisecond,
+	}, 200)
+	batch.Shard.options = batch.Shard.options.SetArchiveWriteConcurrency(concurrency)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := batch.WriteToDisk(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWideArchiveBatchWrite_Sequential(b *testing.B) {
+	benchmarkWideArchiveBatchWrite(b, 1)
+}
+
+func BenchmarkWideArchiveBatchWrite_Concurrency8(b *testing.B) {
+	benchmarkWideArchiveBatchWrite(b, 8)
+}
+
 var _ = ginkgo.Describe("archive store", func() {
 	table := "table1"
 	var shardID, batchID int
@@ -80,7 +158,29 @@ var _ = ginkgo.Describe("archive store", func() {
 		ds.On("OpenVectorPartyFileForWrite",
 			table, mock.Anything, shardID,
 			batchID, cutoff, uint32(0)).Return(writer, nil)
+		ds.On("CommitBatchVersion", table, shardID, batchID, cutoff, uint32(0),
+			diskstore.BatchVersionManifest{Columns: []int{0, 1}}).Return(nil).Once()
 		Ω(archiveBatch.WriteToDisk()).Should(BeNil())
+		Ω(ds.AssertExpectations(utils.TestingT)).Should(BeTrue())
+	})
+
+	ginkgo.It("WriteToDisk should write columns concurrently and clean up on failure", func() {
+		ds := new(diskStoreMocks.DiskStore)
+		archiveBatch := createWideArchiveBatch(table, ds, 10)
+		archiveBatch.Shard.options = archiveBatch.Shard.options.SetArchiveWriteConcurrency(4)
+
+		writer := new(utilsMocks.WriteCloser)
+		writer.On("Write", mock.Anything).Return(0, nil)
+		writer.On("Close").Return(nil)
+		ds.On("OpenVectorPartyFileForWrite",
+			table, 3, 0, 0, cutoff, uint32(0)).Return(nil, errors.New("disk error"))
+		ds.On("OpenVectorPartyFileForWrite",
+			table, mock.Anything, 0, 0, cutoff, uint32(0)).Return(writer, nil)
+		ds.On("DeleteBatchVersion", table, 0, 0, cutoff, uint32(0)).Return(nil).Once()
+
+		Ω(archiveBatch.WriteToDisk()).ShouldNot(BeNil())
+		Ω(ds.AssertNumberOfCalls(utils.TestingT, "OpenVectorPartyFileForWrite", 10)).Should(BeTrue())
+		Ω(ds.AssertExpectations(utils.TestingT)).Should(BeTrue())
 	})
 
 	ginkgo.It("RequestVectorParty should work", func() {
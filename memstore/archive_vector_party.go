@@ -18,6 +18,7 @@ import (
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
+	"os"
 	"sync"
 	"unsafe"
 )
@@ -26,6 +27,24 @@ import (
 type archiveVectorParty struct {
 	cVectorParty
 	Pinnable
+	// onCorruption if set is called instead of panicking when the vector party file
+	// fails checksum verification on load, the load error is then returned by LoadError.
+	onCorruption func(err error)
+	// mappedFile is the memory mapped vector party file the vectors point into, if loaded
+	// with memory mapped reads.
+	mappedFile *diskstore.MappedFile
+}
+
+// SafeDestruct destructs all vectors of this vector party and unmaps the vector party file
+// if it was memory mapped.
+func (vp *archiveVectorParty) SafeDestruct() {
+	if vp != nil {
+		vp.cVectorParty.SafeDestruct()
+		if err := vp.mappedFile.Close(); err != nil {
+			utils.GetLogger().With("error", err.Error()).Error("Failed to unmap vector party file")
+		}
+		vp.mappedFile = nil
+	}
 }
 
 // Prune judges column mode first and sets the mode to vector party.
@@ -92,14 +111,47 @@ func (vp *archiveVectorParty) LoadFromDisk(hostMemManager common.HostMemoryManag
 	vp.Loader.Add(1)
 	go func() {
 		serializer := NewVectorPartyArchiveSerializer(hostMemManager, diskStore, table, shardID, columnID, batchID, batchVersion, seqNum)
-		err := serializer.ReadVectorParty(vp)
+		err := diskstore.ErrMmapNotSupported
+		if mapper, ok := diskStore.(diskstore.VectorPartyFileMapper); ok && !common.IsGoType(vp.dataType) {
+			err = vp.readMapped(mapper, serializer, table, shardID, columnID, batchID, batchVersion, seqNum)
+		}
+		if err == diskstore.ErrMmapNotSupported {
+			err = serializer.ReadVectorParty(vp)
+		}
 		if err != nil {
-			utils.GetLogger().Panic(err)
+			if !diskstore.IsCorruptionError(err) || vp.onCorruption == nil {
+				utils.GetLogger().Panic(err)
+			}
+			// Fail the load so that users will not read the empty vector party.
+			vp.loadErr = err
+			vp.onCorruption(err)
 		}
 		vp.Loader.Done()
 	}()
 }
 
+// readMapped memory maps the vector party file and points the vectors into the mapping.
+// It returns diskstore.ErrMmapNotSupported if the disk store does not support memory mapped reads.
+func (vp *archiveVectorParty) readMapped(mapper diskstore.VectorPartyFileMapper, serializer common.VectorPartySerializer,
+	table string, shardID, columnID, batchID int, batchVersion, seqNum uint32) error {
+	mappedFile, err := mapper.MapVectorPartyFileForRead(table, columnID, shardID, batchID, batchVersion, seqNum)
+	if err != nil {
+		if err == os.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	if err = vp.ReadMapped(mappedFile.Bytes(), serializer); err != nil || !vp.IsMapped() {
+		if corruptionErr, ok := err.(*diskstore.CorruptionError); ok {
+			corruptionErr.Path = mappedFile.Path()
+		}
+		mappedFile.Close()
+		return err
+	}
+	vp.mappedFile = mappedFile
+	return nil
+}
+
 // newArchiveVectorParty creates a archive store vector party,
 // archiveVectorParty use c allocated memory
 func newArchiveVectorParty(length int, dataType common.DataType, defaultValue common.DataValue, locker sync.Locker) *archiveVectorParty {
@@ -17,6 +17,7 @@ package memstore
 import (
 	"sort"
 
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
 )
@@ -389,9 +390,13 @@ func (shard *TableShard) createNewArchiveStoreVersion(cutoff uint32, reporter Ar
 		var requestedVPs []common.ArchiveVectorParty
 		// We need to load all columns into memory for archiving.
 		for columnID := 0; columnID < numColumns; columnID++ {
-			requestedVP := baseBatch.RequestVectorParty(columnID)
+			requestedVP := baseBatch.RequestVectorPartyForIO(columnID, diskstore.IOArchiveRead)
 			requestedVP.WaitForDiskLoad()
 			requestedVPs = append(requestedVPs, requestedVP)
+			if err = requestedVP.LoadError(); err != nil {
+				UnpinVectorParties(requestedVPs)
+				return
+			}
 		}
 
 		ctx := newMergeContext(baseBatch, patch, columnDeletions,
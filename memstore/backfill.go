@@ -17,6 +17,7 @@ package memstore
 import (
 	"sort"
 
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
 	"time"
@@ -140,9 +141,13 @@ func (shard *TableShard) createNewArchiveStoreVersionForBackfill(
 
 		var requestedVPs []common.ArchiveVectorParty
 		for columnID := 0; columnID < numColumns; columnID++ {
-			requestedVP := baseBatch.RequestVectorParty(columnID)
+			requestedVP := baseBatch.RequestVectorPartyForIO(columnID, diskstore.IOBackfillRead)
 			requestedVP.WaitForDiskLoad()
 			requestedVPs = append(requestedVPs, requestedVP)
+			if err = requestedVP.LoadError(); err != nil {
+				UnpinVectorParties(requestedVPs)
+				return
+			}
 		}
 
 		backfillCtx := newBackfillContext(baseBatch, patch, shard.Schema, columnDeletions, sortColumns,
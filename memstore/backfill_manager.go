@@ -79,6 +79,18 @@ func NewBackfillManager(tableName string, shard int, tableConfig metaCom.TableCo
 	return &backfillManager
 }
 
+// UpdateConfig applies updated table config and wakes up appenders waiting for buffer in case the
+// max buffer size is raised. Returns the change of max buffer size.
+func (r *BackfillManager) UpdateConfig(tableConfig metaCom.TableConfig) (maxBufferSizeChange int64) {
+	r.Lock()
+	maxBufferSizeChange = tableConfig.BackfillMaxBufferSize - r.MaxBufferSize
+	r.MaxBufferSize = tableConfig.BackfillMaxBufferSize
+	r.BackfillThresholdInBytes = tableConfig.BackfillThresholdInBytes
+	r.Unlock()
+	r.AppendCond.Broadcast()
+	return
+}
+
 // WaitForBackfillBufferAvailability blocks until backfill buffer is available
 func (r *BackfillManager) WaitForBackfillBufferAvailability() {
 	r.Lock()
@@ -85,6 +85,24 @@ var _ = ginkgo.Describe("backfill manager", func() {
 		bm.Destruct()
 	})
 
+	ginkgo.It("UpdateConfig should unblock waiting clients when buffer size is raised", func() {
+		bm := NewBackfillManager(table, 0, tableSchema.Schema.Config)
+		Ω(bm.Append(upsertBatch, 1, 10)).Should(BeTrue())
+		done := make(chan bool)
+		go func() {
+			bm.WaitForBackfillBufferAvailability()
+			close(done)
+		}()
+
+		newConfig := tableSchema.Schema.Config
+		newConfig.BackfillMaxBufferSize = 1 << 20
+		newConfig.BackfillThresholdInBytes = 1 << 10
+		Ω(bm.UpdateConfig(newConfig)).Should(BeEquivalentTo(1<<20 - 1))
+		Eventually(done).Should(BeClosed())
+		Ω(bm.BackfillThresholdInBytes).Should(BeEquivalentTo(1 << 10))
+		bm.Destruct()
+	})
+
 	ginkgo.It("ReadUpsertBatch should work ", func() {
 		bm := NewBackfillManager(table, 0, tableSchema.Schema.Config)
 		_ = bm.Append(upsertBatch, 1, 10)
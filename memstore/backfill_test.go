@@ -97,6 +97,9 @@ var _ = ginkgo.Describe("backfill", func() {
 		(m.diskStore).(*diskMocks.DiskStore).
 			On("OpenVectorPartyFileForWrite",
 				table, mock.Anything, shardID, 0, uint32(0), uint32(1)).Return(writer, nil)
+		(m.diskStore).(*diskMocks.DiskStore).
+			On("CommitBatchVersion",
+				table, shardID, 0, uint32(0), uint32(1), mock.Anything).Return(nil)
 		(m.metaStore).(*metaMocks.MetaStore).On(
 			"AddArchiveBatchVersion", table, shardID,
 			0, uint32(0), uint32(1), 6).Return(nil)
@@ -0,0 +1,175 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"math"
+	"os"
+
+	"github.com/uber/aresdb/diskstore"
+	"github.com/uber/aresdb/utils"
+)
+
+// RecoverArchiveBatchVersions finishes archive batch version replacements interrupted by crashes. It must be
+// called after LoadMetaData and before any archive batch is loaded. For each batch on disk:
+//   - the newest version committed on disk with all its column files is rolled forward by recording it in
+//     metastore if it's newer than all versions in metastore and not beyond the archiving cutoff.
+//   - versions older than the active version in metastore are orphans and deleted.
+//   - newer versions not recorded in metastore are rolled back by deleting them.
+func (shard *TableShard) RecoverArchiveBatchVersions() error {
+	if !shard.Schema.Schema.IsFactTable {
+		return nil
+	}
+
+	table := shard.Schema.Schema.Name
+	// peers may be copying files of this shard.
+	if !shard.options.bootstrapToken.AcquireToken(table, uint32(shard.ShardID)) {
+		return nil
+	}
+	defer shard.options.bootstrapToken.ReleaseToken(table, uint32(shard.ShardID))
+
+	versions, err := shard.diskStore.ListBatchVersions(table, shard.ShardID)
+	if err != nil {
+		return err
+	}
+
+	cutoff := shard.ArchiveStore.CurrentVersion.ArchivingCutoff
+	for start := 0; start < len(versions); {
+		end := start + 1
+		for end < len(versions) && versions[end].BatchID == versions[start].BatchID {
+			end++
+		}
+		if err = shard.recoverBatchVersions(versions[start:end], cutoff); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// recoverBatchVersions recovers versions of the same batch sorted from old to new.
+func (shard *TableShard) recoverBatchVersions(versions []diskstore.BatchVersion, cutoff uint32) error {
+	table := shard.Schema.Schema.Name
+	batchID := versions[0].BatchID
+	logger := utils.GetLogger().With("table", table, "shard", shard.ShardID, "batch", batchID)
+
+	latest, _, err := shard.getRecordedBatchVersion(batchID, math.MaxUint32)
+	if err != nil {
+		return err
+	}
+
+	newest := versions[len(versions)-1]
+	if latest.Before(newest) && newest.Version <= cutoff {
+		manifest, err := shard.getCompleteBatchVersionManifest(newest)
+		if err != nil {
+			return err
+		}
+		if manifest != nil {
+			if err = shard.metaStore.AddArchiveBatchVersion(table, shard.ShardID, batchID, newest.Version,
+				newest.SeqNum, manifest.Size); err != nil {
+				return err
+			}
+			logger.With("version", newest.Version, "seq", newest.SeqNum).
+				Info("Rolled forward committed archive batch version")
+		}
+	}
+
+	active, recorded, err := shard.getRecordedBatchVersion(batchID, cutoff)
+	if err != nil {
+		return err
+	}
+
+	activeFound := false
+	for _, version := range versions {
+		if version == active {
+			activeFound = true
+			continue
+		}
+
+		uncommitted := active.Before(version)
+		if uncommitted {
+			// versions beyond the archiving cutoff recorded by an archiving run not finished yet are kept.
+			newer, _, err := shard.getRecordedBatchVersion(batchID, version.Version)
+			if err != nil {
+				return err
+			}
+			if newer == version {
+				continue
+			}
+		}
+
+		if err = shard.diskStore.DeleteBatchVersion(table, shard.ShardID, batchID, version.Version,
+			version.SeqNum); err != nil {
+			return err
+		}
+		if uncommitted {
+			logger.With("version", version.Version, "seq", version.SeqNum).
+				Info("Rolled back uncommitted archive batch version")
+		} else {
+			logger.With("version", version.Version, "seq", version.SeqNum).
+				Info("Deleted obsolete archive batch version")
+		}
+	}
+
+	if recorded && !activeFound {
+		logger.With("version", active.Version, "seq", active.SeqNum).
+			Error("Active archive batch version is missing on disk")
+	}
+	return nil
+}
+
+// getRecordedBatchVersion returns the latest version of the batch recorded in metastore not beyond the cutoff.
+// recorded is false if there is no such version.
+func (shard *TableShard) getRecordedBatchVersion(batchID int, cutoff uint32) (
+	version diskstore.BatchVersion, recorded bool, err error) {
+	batchVersion, seqNum, size, err := shard.metaStore.GetArchiveBatchVersion(shard.Schema.Schema.Name,
+		shard.ShardID, batchID, cutoff)
+	if err != nil {
+		return
+	}
+	version = diskstore.BatchVersion{BatchID: batchID, Version: batchVersion, SeqNum: seqNum}
+	recorded = batchVersion != 0 || seqNum != 0 || size != 0
+	return
+}
+
+// getCompleteBatchVersionManifest returns the manifest of the batch version if it's committed with all column
+// files present, otherwise nil.
+func (shard *TableShard) getCompleteBatchVersionManifest(version diskstore.BatchVersion) (
+	*diskstore.BatchVersionManifest, error) {
+	table := shard.Schema.Schema.Name
+	manifest, err := shard.diskStore.ReadBatchVersionManifest(table, shard.ShardID, version.BatchID,
+		version.Version, version.SeqNum)
+	if err == os.ErrNotExist {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns, err := shard.diskStore.ListArchiveBatchVectorPartyFiles(table, shard.ShardID, version.BatchID,
+		version.Version, version.SeqNum)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[int]bool, len(columns))
+	for _, columnID := range columns {
+		present[columnID] = true
+	}
+	for _, columnID := range manifest.Columns {
+		if !present[columnID] {
+			return nil, nil
+		}
+	}
+	return manifest, nil
+}
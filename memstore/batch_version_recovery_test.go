@@ -0,0 +1,229 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"io"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/diskstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+// batchVersionMetaStore keeps archive batch versions in memory the same way as disk metastore.
+type batchVersionMetaStore struct {
+	metaCom.MetaStore
+	versions map[int][]diskstore.BatchVersion
+	sizes    map[diskstore.BatchVersion]int
+}
+
+func newBatchVersionMetaStore() *batchVersionMetaStore {
+	return &batchVersionMetaStore{
+		versions: make(map[int][]diskstore.BatchVersion),
+		sizes:    make(map[diskstore.BatchVersion]int),
+	}
+}
+
+func (m *batchVersionMetaStore) AddArchiveBatchVersion(table string, shard, batchID int, version uint32,
+	seqNum uint32, batchSize int) error {
+	batchVersion := diskstore.BatchVersion{BatchID: batchID, Version: version, SeqNum: seqNum}
+	m.versions[batchID] = append(m.versions[batchID], batchVersion)
+	m.sizes[batchVersion] = batchSize
+	return nil
+}
+
+func (m *batchVersionMetaStore) GetArchiveBatchVersion(table string, shard, batchID int, cutoff uint32) (
+	uint32, uint32, int, error) {
+	versions := m.versions[batchID]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Version <= cutoff {
+			return versions[i].Version, versions[i].SeqNum, m.sizes[versions[i]], nil
+		}
+	}
+	return 0, 0, 0, nil
+}
+
+var _ = ginkgo.Describe("archive batch version recovery", func() {
+	prefix := "/tmp/testBatchVersionRecovery"
+	table := "table1"
+	shardID := 0
+	batchID := 17800
+	var cutoff uint32 = 200
+	columns := []int{0, 2}
+
+	var diskStore diskstore.DiskStore
+	var metaStore *batchVersionMetaStore
+
+	writeColumn := func(version diskstore.BatchVersion, columnID int) {
+		writer, err := diskStore.OpenVectorPartyFileForWrite(table, columnID, shardID, batchID, version.Version,
+			version.SeqNum)
+		Ω(err).Should(BeNil())
+		_, err = io.WriteString(writer, "data")
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+	}
+
+	// replaceSteps returns the steps to replace the batch version from with to.
+	replaceSteps := func(from, to diskstore.BatchVersion) []func() {
+		steps := []func(){}
+		for _, columnID := range columns {
+			columnID := columnID
+			steps = append(steps, func() { writeColumn(to, columnID) })
+		}
+		return append(steps,
+			func() {
+				Ω(diskStore.CommitBatchVersion(table, shardID, batchID, to.Version, to.SeqNum,
+					diskstore.BatchVersionManifest{Size: 10, Columns: columns})).Should(BeNil())
+			},
+			func() {
+				Ω(metaStore.AddArchiveBatchVersion(table, shardID, batchID, to.Version, to.SeqNum, 10)).Should(BeNil())
+			},
+			func() {
+				Ω(diskStore.DeleteBatchVersions(table, shardID, batchID, from.Version, from.SeqNum)).Should(BeNil())
+			},
+		)
+	}
+
+	recoverShard := func(cutoff uint32) {
+		bootstrapToken := new(memComMocks.BootStrapToken)
+		bootstrapToken.On("AcquireToken", table, uint32(shardID)).Return(true)
+		bootstrapToken.On("ReleaseToken", table, uint32(shardID)).Return()
+		shard := &TableShard{
+			Schema: &memCom.TableSchema{
+				Schema: metaCom.Table{Name: table, IsFactTable: true},
+			},
+			ShardID:   shardID,
+			diskStore: diskStore,
+			metaStore: metaStore,
+			options:   NewOptions(bootstrapToken, nil),
+			ArchiveStore: &ArchiveStore{
+				CurrentVersion: NewArchiveStoreVersion(cutoff, nil),
+			},
+		}
+		Ω(shard.RecoverArchiveBatchVersions()).Should(BeNil())
+	}
+
+	// checkActiveVersion checks the active version has all column files and is the only version on disk.
+	checkActiveVersion := func(cutoff uint32, expected ...diskstore.BatchVersion) {
+		version, seqNum, size, err := metaStore.GetArchiveBatchVersion(table, shardID, batchID, cutoff)
+		Ω(err).Should(BeNil())
+		Ω(size).Should(Equal(10))
+		active := diskstore.BatchVersion{BatchID: batchID, Version: version, SeqNum: seqNum}
+		Ω(expected).Should(ContainElement(active))
+
+		for _, columnID := range columns {
+			reader, err := diskStore.OpenVectorPartyFileForRead(table, columnID, shardID, batchID, version, seqNum)
+			Ω(err).Should(BeNil())
+			Ω(reader.Close()).Should(BeNil())
+		}
+		Ω(diskStore.ListBatchVersions(table, shardID)).Should(Equal([]diskstore.BatchVersion{active}))
+	}
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		diskStore = diskstore.NewLocalDiskStore(prefix)
+		metaStore = newBatchVersionMetaStore()
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	ginkgo.It("recovers backfill replacements killed between each step", func() {
+		oldVersion := diskstore.BatchVersion{BatchID: batchID, Version: 100, SeqNum: 0}
+		newVersion := diskstore.BatchVersion{BatchID: batchID, Version: 100, SeqNum: 1}
+		numSteps := len(replaceSteps(oldVersion, newVersion))
+		for crashAfter := 0; crashAfter <= numSteps; crashAfter++ {
+			os.RemoveAll(prefix)
+			metaStore = newBatchVersionMetaStore()
+			for _, step := range replaceSteps(diskstore.BatchVersion{BatchID: batchID}, oldVersion) {
+				step()
+			}
+			for _, step := range replaceSteps(oldVersion, newVersion)[:crashAfter] {
+				step()
+			}
+
+			recoverShard(cutoff)
+			// committed versions are rolled forward.
+			if crashAfter > len(columns) {
+				checkActiveVersion(cutoff, newVersion)
+			} else {
+				checkActiveVersion(cutoff, oldVersion)
+			}
+
+			// recovery is idempotent.
+			recoverShard(cutoff)
+			if crashAfter > len(columns) {
+				checkActiveVersion(cutoff, newVersion)
+			} else {
+				checkActiveVersion(cutoff, oldVersion)
+			}
+		}
+	})
+
+	ginkgo.It("keeps versions recorded beyond archiving cutoff and rolls back unrecorded ones", func() {
+		oldVersion := diskstore.BatchVersion{BatchID: batchID, Version: 100, SeqNum: 0}
+		newVersion := diskstore.BatchVersion{BatchID: batchID, Version: 300, SeqNum: 0}
+		for _, step := range replaceSteps(diskstore.BatchVersion{BatchID: batchID}, oldVersion) {
+			step()
+		}
+		steps := replaceSteps(oldVersion, newVersion)
+		// killed after commit on disk, before recorded in metastore.
+		for _, step := range steps[:len(columns)+1] {
+			step()
+		}
+		recoverShard(cutoff)
+		checkActiveVersion(cutoff, oldVersion)
+
+		// killed after recorded in metastore, before archiving cutoff is updated.
+		for _, step := range steps[:len(columns)+2] {
+			step()
+		}
+		recoverShard(cutoff)
+		Ω(diskStore.ListBatchVersions(table, shardID)).Should(Equal([]diskstore.BatchVersion{oldVersion, newVersion}))
+
+		// archiving cutoff updated.
+		recoverShard(newVersion.Version)
+		checkActiveVersion(newVersion.Version, newVersion)
+	})
+
+	ginkgo.It("keeps active versions written without manifest", func() {
+		legacyVersion := diskstore.BatchVersion{BatchID: batchID, Version: 100, SeqNum: 0}
+		for _, columnID := range columns {
+			writeColumn(legacyVersion, columnID)
+		}
+		Ω(metaStore.AddArchiveBatchVersion(table, shardID, batchID, 100, 0, 10)).Should(BeNil())
+		recoverShard(cutoff)
+		checkActiveVersion(cutoff, legacyVersion)
+	})
+
+	ginkgo.It("does nothing without bootstrap token", func() {
+		bootstrapToken := new(memComMocks.BootStrapToken)
+		bootstrapToken.On("AcquireToken", mock.Anything, mock.Anything).Return(false)
+		shard := &TableShard{
+			Schema: &memCom.TableSchema{
+				Schema: metaCom.Table{Name: table, IsFactTable: true},
+			},
+			diskStore: diskStore,
+			options:   NewOptions(bootstrapToken, nil),
+		}
+		Ω(shard.RecoverArchiveBatchVersions()).Should(BeNil())
+	})
+})
@@ -27,6 +27,7 @@ import (
 	xretry "github.com/m3db/m3/src/x/retry"
 	xsync "github.com/m3db/m3/src/x/sync"
 	"github.com/uber/aresdb/cluster/topology"
+	aresdbCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/datanode/bootstrap"
 	"github.com/uber/aresdb/datanode/client"
 	"github.com/uber/aresdb/datanode/generated/proto/rpc"
@@ -45,6 +46,51 @@ func (shard *TableShard) IsDiskDataAvailable() bool {
 	return atomic.LoadUint32(&shard.needPeerCopy) != 1
 }
 
+// handleCorruptedVectorParty is called when an archived vector party file fails checksum verification.
+// The file has already been quarantined by the disk store, in distributed mode we will recover the table
+// shard by copying data from a peer replica on next bootstrap.
+func (shard *TableShard) handleCorruptedVectorParty(batchID, columnID int, err error) {
+	logger := utils.GetLogger().With(
+		"table", shard.Schema.Schema.Name,
+		"shard", shard.ShardID,
+		"batch", batchID,
+		"column", columnID,
+		"error", err.Error())
+	shard.recoverCorruptedDiskData(logger, "archive vector party file is corrupted")
+}
+
+// VerifyDiskData verifies all files of the table shard on disk against their checksums with reads
+// throttled to bytesPerSec, corrupted files are quarantined and recovered the same way as
+// handleCorruptedVectorParty. Returns number of corrupted files found.
+func (shard *TableShard) VerifyDiskData(bytesPerSec int64) (int, error) {
+	numCorrupted, err := shard.diskStore.VerifyTableShard(shard.Schema.Schema.Name, shard.ShardID, bytesPerSec)
+	if numCorrupted > 0 {
+		logger := utils.GetLogger().With(
+			"table", shard.Schema.Schema.Name,
+			"shard", shard.ShardID,
+			"numCorrupted", numCorrupted)
+		shard.recoverCorruptedDiskData(logger, "corrupted files found by integrity check")
+	}
+	return numCorrupted, err
+}
+
+// recoverCorruptedDiskData marks the table shard to copy data from a peer replica on next bootstrap
+// in distributed mode.
+func (shard *TableShard) recoverCorruptedDiskData(logger aresdbCommon.Logger, msg string) {
+	if !utils.GetConfig().Cluster.Distributed {
+		logger.Error(msg + ", no peer replica to recover from")
+		return
+	}
+
+	logger.Error(msg + ", will recover from peer replica")
+	atomic.StoreUint32(&shard.needPeerCopy, 1)
+	shard.bootstrapLock.Lock()
+	if shard.BootstrapState == bootstrap.Bootstrapped {
+		shard.BootstrapState = bootstrap.BootstrapNotStarted
+	}
+	shard.bootstrapLock.Unlock()
+}
+
 func (m *memStoreImpl) Bootstrap(
 	peerSource client.PeerSource,
 	origin string,
@@ -159,6 +205,12 @@ func (shard *TableShard) Bootstrap(
 		return err
 	}
 
+	// finish archive batch version replacements interrupted by last shutdown.
+	if err = shard.RecoverArchiveBatchVersions(); err != nil {
+		return err
+	}
+	shard.scanDiskUsage()
+
 	shard.BootstrapDetails.SetBootstrapStage(bootstrap.Preload)
 	// preload snapshot or archive batches into memory
 	if schema.IsFactTable {
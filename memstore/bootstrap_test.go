@@ -31,6 +31,7 @@ import (
 	datanodeMocks "github.com/uber/aresdb/datanode/client/mocks"
 	"github.com/uber/aresdb/datanode/generated/proto/rpc"
 	rpcMocks "github.com/uber/aresdb/datanode/generated/proto/rpc/mocks"
+	"github.com/uber/aresdb/diskstore"
 	diskMocks "github.com/uber/aresdb/diskstore/mocks"
 	memCom "github.com/uber/aresdb/memstore/common"
 	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
@@ -132,6 +133,7 @@ var _ = ginkgo.Describe("table shard bootstrap", func() {
 			metaStore.On("UpdateBackfillProgress", table, shardID, int64(redoFileID), uint32(redoFileOffset)).Return(nil).Once()
 			metaStore.On("GetBackfillProgressInfo", table, shardID).Return(int64(redoFileID), uint32(redoFileOffset), nil).Once()
 			diskStore.On("ListLogFiles", table, shardID).Return([]int64{}, nil).Once()
+			diskStore.On("ListBatchVersions", table, shardID).Return([]diskstore.BatchVersion{}, nil).Once()
 
 			shard := NewTableShard(&memCom.TableSchema{
 				Schema: metaCom.Table{
@@ -0,0 +1,169 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"sort"
+
+	"github.com/uber/aresdb/diskstore"
+	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// getColumnsToMaterialize returns IDs of non deleted columns with default value backfill enabled.
+// Caller needs to hold the schema read lock.
+func getColumnsToMaterialize(table *metaCom.Table) []int {
+	var columns []int
+	for columnID, column := range table.Columns {
+		if column.BackfillDefault && !column.Deleted {
+			columns = append(columns, columnID)
+		}
+	}
+	return columns
+}
+
+// MaterializeDefaultValues writes vector parties of columns added with default value backfill into
+// archive batches created before the columns were added. Until then those batches have no file for the
+// columns and queries read the default value from schema instead, so query results stay the same
+// during and after the rewrite. Batches already having files of all the columns are skipped, which makes
+// the job resume from where previous runs stopped.
+func (shard *TableShard) MaterializeDefaultValues(reporter ColumnMaterializeJobDetailReporter, jobKey string) error {
+	// Block column deletion
+	shard.columnDeletion.Lock()
+	defer shard.columnDeletion.Unlock()
+
+	shard.Schema.RLock()
+	columns := getColumnsToMaterialize(&shard.Schema.Schema)
+	numColumns := len(shard.Schema.ValueTypeByColumn)
+	shard.Schema.RUnlock()
+
+	version := shard.ArchiveStore.GetCurrentVersion()
+	version.RLock()
+	batchIDs := make([]int, 0, len(version.Batches))
+	for batchID := range version.Batches {
+		batchIDs = append(batchIDs, int(batchID))
+	}
+	version.RUnlock()
+	version.Users.Done()
+	sort.Ints(batchIDs)
+
+	reporter(jobKey, func(status *ColumnMaterializeJobDetail) {
+		status.Columns = columns
+		status.NumColumns = numColumns
+		status.NumMaterializedBatches = 0
+		status.Current = 0
+		status.Total = len(batchIDs)
+	})
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	for i, batchID := range batchIDs {
+		reporter(jobKey, func(status *ColumnMaterializeJobDetail) {
+			status.BatchID = batchID
+		})
+		materialized, err := shard.materializeBatchDefaultValues(int32(batchID), columns, numColumns)
+		if err != nil {
+			return err
+		}
+		reporter(jobKey, func(status *ColumnMaterializeJobDetail) {
+			if materialized {
+				status.NumMaterializedBatches++
+			}
+			status.Current = i + 1
+		})
+	}
+	return nil
+}
+
+// materializeBatchDefaultValues rewrites the archive batch as a new batch version with files of all columns
+// if any of the columns to materialize has no file. Vector parties of the current batch version are shared
+// with the new batch version since their values do not change. Returns whether the batch is rewritten.
+func (shard *TableShard) materializeBatchDefaultValues(batchID int32, columns []int, numColumns int) (bool, error) {
+	tableName := shard.Schema.Schema.Name
+	baseBatch := shard.ArchiveStore.CurrentVersion.RequestBatch(batchID)
+	if baseBatch.Size == 0 {
+		return false, nil
+	}
+
+	columnsOnDisk, err := shard.diskStore.ListArchiveBatchVectorPartyFiles(tableName, shard.ShardID, int(batchID),
+		baseBatch.Version, baseBatch.SeqNum)
+	if err != nil {
+		return false, err
+	}
+	var missing bool
+	for _, columnID := range columns {
+		if utils.IndexOfInt(columnsOnDisk, columnID) < 0 {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return false, nil
+	}
+
+	var requestedVPs []common.ArchiveVectorParty
+	for columnID := 0; columnID < numColumns; columnID++ {
+		requestedVP := baseBatch.RequestVectorPartyForIO(columnID, diskstore.IOBackfillRead)
+		requestedVP.WaitForDiskLoad()
+		requestedVPs = append(requestedVPs, requestedVP)
+		if err = requestedVP.LoadError(); err != nil {
+			UnpinVectorParties(requestedVPs)
+			return false, err
+		}
+	}
+	defer UnpinVectorParties(requestedVPs)
+
+	newBatch := baseBatch.Clone()
+	newBatch.SeqNum++
+	if err = newBatch.WriteToDisk(); err != nil {
+		return false, err
+	}
+	if err = shard.metaStore.AddArchiveBatchVersion(tableName, shard.ShardID, int(batchID), newBatch.Version,
+		newBatch.SeqNum, newBatch.Size); err != nil {
+		return false, err
+	}
+
+	oldVersion := shard.ArchiveStore.CurrentVersion
+	newVersion := NewArchiveStoreVersion(oldVersion.ArchivingCutoff, shard)
+	oldVersion.RLock()
+	for oldBatchID, oldBatch := range oldVersion.Batches {
+		newVersion.Batches[oldBatchID] = oldBatch
+	}
+	oldVersion.RUnlock()
+	newVersion.Batches[batchID] = newBatch
+
+	// switch to new version
+	shard.ArchiveStore.Lock()
+	shard.ArchiveStore.CurrentVersion = newVersion
+	shard.ArchiveStore.Unlock()
+	oldVersion.Users.Wait()
+
+	// Purge the old batch version on disk.
+	if shard.options.bootstrapToken.AcquireToken(tableName, uint32(shard.ShardID)) {
+		err = shard.diskStore.DeleteBatchVersions(tableName, shard.ShardID, int(batchID), baseBatch.Version,
+			baseBatch.SeqNum)
+		shard.options.bootstrapToken.ReleaseToken(tableName, uint32(shard.ShardID))
+		if err != nil {
+			return true, err
+		}
+	}
+
+	utils.GetLogger().With("table", tableName, "shard", shard.ShardID, "batch", batchID,
+		"version", newBatch.Version, "seq", newBatch.SeqNum).Info("Materialized default values of added columns")
+	return true, nil
+}
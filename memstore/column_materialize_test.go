@@ -0,0 +1,38 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("column materialize", func() {
+	ginkgo.It("getColumnsToMaterialize should work", func() {
+		defaultValue := "1"
+		table := metaCom.Table{
+			Columns: []metaCom.Column{
+				{Name: "c0", Type: metaCom.Uint32},
+				{Name: "c1", Type: metaCom.Uint32, DefaultValue: &defaultValue, BackfillDefault: true},
+				{Name: "c2", Type: metaCom.Uint32, DefaultValue: &defaultValue},
+				{Name: "c3", Type: metaCom.Uint32, DefaultValue: &defaultValue, BackfillDefault: true, Deleted: true},
+				{Name: "c4", Type: metaCom.Uint32, DefaultValue: &defaultValue, BackfillDefault: true},
+			},
+		}
+		Ω(getColumnsToMaterialize(&table)).Should(Equal([]int{1, 4}))
+		Ω(getColumnsToMaterialize(&metaCom.Table{})).Should(BeEmpty())
+	})
+})
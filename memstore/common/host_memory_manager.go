@@ -43,6 +43,8 @@ type HostMemoryManager interface {
 	ReportManagedObject(table string, shard, batchID, columnID int, bytes int64)
 	GetArchiveMemoryUsageByTableShard() (map[string]map[string]*ColumnMemoryUsage, error)
 	TriggerEviction()
+	// GetFreeSpace returns host memory left under the limit, negative if over the limit.
+	GetFreeSpace() int64
 	TriggerPreload(tableName string, columnID int,
 		oldPreloadingDays int, newPreloadingDays int)
 	Start()
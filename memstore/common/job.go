@@ -26,4 +26,12 @@ const (
 	SnapshotJobType JobType = "snapshot"
 	// PurgeJobType is the purge job type.
 	PurgeJobType JobType = "purge"
+	// ParquetExportJobType is the parquet export job type.
+	ParquetExportJobType JobType = "parquet_export"
+	// ParquetImportJobType is the parquet import job type.
+	ParquetImportJobType JobType = "parquet_import"
+	// ColumnMaterializeJobType is the job type materializing default values of added columns.
+	ColumnMaterializeJobType JobType = "column_materialize"
+	// IntegrityCheckJobType is the job type verifying files of table shards on disk against their checksums.
+	IntegrityCheckJobType JobType = "integrity_check"
 )
@@ -32,6 +32,20 @@ func (_m *HostMemoryManager) GetArchiveMemoryUsageByTableShard() (map[string]map
 	return r0, r1
 }
 
+// GetFreeSpace provides a mock function with given fields:
+func (_m *HostMemoryManager) GetFreeSpace() int64 {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	return r0
+}
+
 // ReportManagedObject provides a mock function with given fields: table, shard, batchID, columnID, bytes
 func (_m *HostMemoryManager) ReportManagedObject(table string, shard int, batchID int, columnID int, bytes int64) {
 	_m.Called(table, shard, batchID, columnID, bytes)
@@ -16,6 +16,7 @@ package common
 
 import (
 	"encoding/json"
+	"math"
 	"unsafe"
 
 	"github.com/uber/aresdb/utils"
@@ -115,3 +116,18 @@ func GetPrimaryKeyBytes(primaryKeyValues []DataValue, keyLength int) ([]byte, er
 	}
 	return key, nil
 }
+
+// GetShardByPrimaryKey returns the shard a row with the primary key bytes belongs to among
+// numShards shards. Ingestion clients route rows to shards with it.
+func GetShardByPrimaryKey(key []byte, numShards uint32) uint32 {
+	if len(key) == 0 || numShards <= 1 {
+		return 0
+	}
+	shardID := utils.Murmur3Sum32(unsafe.Pointer(&key[0]), len(key), 0) / (math.MaxUint32 / numShards)
+	// Hashes close to MaxUint32 may go beyond the last shard when numShards does not divide
+	// MaxUint32.
+	if shardID >= numShards {
+		shardID = numShards - 1
+	}
+	return shardID
+}
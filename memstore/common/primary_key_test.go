@@ -48,4 +48,19 @@ var _ = ginkgo.Describe("primary key", func() {
 		Ω(err).Should(BeNil())
 		Ω(key).Should(BeEquivalentTo([]byte{1, 0xB0, 0XA0, 0xF0, 0xE0, 0xD0, 0xC0}))
 	})
+
+	ginkgo.It("GetShardByPrimaryKey should work", func() {
+		Ω(GetShardByPrimaryKey(nil, 4)).Should(BeEquivalentTo(0))
+		Ω(GetShardByPrimaryKey([]byte{1, 2, 3}, 1)).Should(BeEquivalentTo(0))
+		counts := make([]int, 4)
+		for i := 0; i < 1000; i++ {
+			shardID := GetShardByPrimaryKey([]byte{byte(i), byte(i >> 8)}, 4)
+			Ω(shardID).Should(BeNumerically("<", 4))
+			Ω(GetShardByPrimaryKey([]byte{byte(i), byte(i >> 8)}, 4)).Should(Equal(shardID))
+			counts[shardID]++
+		}
+		for _, count := range counts {
+			Ω(count).Should(BeNumerically(">", 0))
+		}
+	})
 })
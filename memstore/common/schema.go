@@ -31,6 +31,8 @@ type TableSchema struct {
 	Schema metaCom.Table `json:"schema"`
 	// Maps from column names to their IDs. Mutable.
 	ColumnIDs map[string]int `json:"columnIDs"`
+	// Maps from aliases of renamed columns to their IDs including expired ones. Mutable.
+	AliasColumnIDs map[string]int `json:"aliasColumnIDs"`
 	// Maps from enum column names to their case dictionaries. Mutable.
 	EnumDicts map[string]EnumDict `json:"enumDicts"`
 	// DataType for each column ordered by column ID. Mutable.
@@ -56,17 +58,14 @@ type EnumDict struct {
 func NewTableSchema(table *metaCom.Table) *TableSchema {
 	tableSchema := &TableSchema{
 		Schema:                *table,
-		ColumnIDs:             make(map[string]int),
 		EnumDicts:             make(map[string]EnumDict),
 		ValueTypeByColumn:     make([]DataType, len(table.Columns)),
 		PrimaryKeyColumnTypes: make([]DataType, len(table.PrimaryKeyColumns)),
 		DefaultValues:         make([]*DataValue, len(table.Columns)),
 	}
 
+	tableSchema.setColumnIDs()
 	for id, column := range table.Columns {
-		if !column.Deleted {
-			tableSchema.ColumnIDs[column.Name] = id
-		}
 		tableSchema.ValueTypeByColumn[id] = DataTypeForColumn(column)
 	}
 
@@ -96,13 +95,8 @@ func (t *TableSchema) MarshalJSON() ([]byte, error) {
 // should acquire lock before calling.
 func (t *TableSchema) SetTable(table *metaCom.Table) {
 	t.Schema = *table
+	t.setColumnIDs()
 	for id, column := range table.Columns {
-		if !column.Deleted {
-			t.ColumnIDs[column.Name] = id
-		} else {
-			delete(t.ColumnIDs, column.Name)
-		}
-
 		if id >= len(t.ValueTypeByColumn) {
 			t.ValueTypeByColumn = append(t.ValueTypeByColumn, DataTypeForColumn(column))
 		}
@@ -113,6 +107,40 @@ func (t *TableSchema) SetTable(table *metaCom.Table) {
 	}
 }
 
+// setColumnIDs rebuilds ColumnIDs and AliasColumnIDs from the schema since columns can be
+// renamed and aliases can be removed.
+func (t *TableSchema) setColumnIDs() {
+	t.ColumnIDs = make(map[string]int, len(t.Schema.Columns))
+	t.AliasColumnIDs = make(map[string]int)
+	for id, column := range t.Schema.Columns {
+		if column.Deleted {
+			continue
+		}
+		t.ColumnIDs[column.Name] = id
+		for _, alias := range column.Aliases {
+			t.AliasColumnIDs[alias.Name] = id
+		}
+	}
+}
+
+// GetColumnID returns the ID of the column by its name or by an unexpired alias from renaming,
+// in which case isAlias is true. Caller should hold the schema read lock.
+func (t *TableSchema) GetColumnID(name string) (columnID int, isAlias bool, found bool) {
+	if columnID, found = t.ColumnIDs[name]; found {
+		return
+	}
+	if columnID, found = t.AliasColumnIDs[name]; !found {
+		return
+	}
+	now := utils.Now().Unix()
+	for _, alias := range t.Schema.Columns[columnID].Aliases {
+		if alias.Name == name && !alias.IsExpired(now) {
+			return columnID, true, true
+		}
+	}
+	return 0, false, false
+}
+
 // SetDefaultValue parses the default value string if present and sets to TableSchema.
 // Schema lock should be acquired and release by caller and enum dict should already be
 // created/update before this function.
@@ -231,6 +259,16 @@ func (t *TableSchema) GetColumnDeletions() []bool {
 	return deletedByColumn
 }
 
+// GetColumnDeprecations returns a boolean slice that indicates whether a column is deprecated and not
+// deleted yet. Callers need to hold a read lock.
+func (t *TableSchema) GetColumnDeprecations() []bool {
+	deprecatedByColumn := make([]bool, len(t.Schema.Columns))
+	for columnID, column := range t.Schema.Columns {
+		deprecatedByColumn[columnID] = column.Deprecated && !column.Deleted
+	}
+	return deprecatedByColumn
+}
+
 // GetColumnIfNonNilDefault returns a boolean slice that indicates whether a column has non nil default value. Callers
 // need to hold a read lock.
 func (t *TableSchema) GetColumnIfNonNilDefault() []bool {
@@ -0,0 +1,85 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("table schema", func() {
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("resolves columns by name and unexpired aliases", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		table := metaCom.Table{
+			Name: "t",
+			Columns: []metaCom.Column{
+				{Name: "c0", Type: metaCom.Uint32},
+				{Name: "c1", Type: metaCom.Uint32, Aliases: []metaCom.ColumnAlias{
+					{Name: "old1", ExpiresAt: 2000},
+					{Name: "older1", ExpiresAt: 1000},
+				}},
+				{Name: "c2", Type: metaCom.Uint32, Deleted: true, Aliases: []metaCom.ColumnAlias{
+					{Name: "old2", ExpiresAt: 2000},
+				}},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+		schema := NewTableSchema(&table)
+
+		columnID, isAlias, found := schema.GetColumnID("c1")
+		Ω(found).Should(BeTrue())
+		Ω(isAlias).Should(BeFalse())
+		Ω(columnID).Should(Equal(1))
+
+		columnID, isAlias, found = schema.GetColumnID("old1")
+		Ω(found).Should(BeTrue())
+		Ω(isAlias).Should(BeTrue())
+		Ω(columnID).Should(Equal(1))
+
+		_, _, found = schema.GetColumnID("older1")
+		Ω(found).Should(BeFalse())
+		_, _, found = schema.GetColumnID("old2")
+		Ω(found).Should(BeFalse())
+		_, _, found = schema.GetColumnID("c2")
+		Ω(found).Should(BeFalse())
+
+		// renaming c1 again drops its old name.
+		table.Columns = append([]metaCom.Column{}, table.Columns...)
+		table.Columns[1] = metaCom.Column{Name: "new1", Type: metaCom.Uint32, Aliases: []metaCom.ColumnAlias{
+			{Name: "c1", ExpiresAt: 2000},
+		}}
+		schema.SetTable(&table)
+		columnID, isAlias, found = schema.GetColumnID("c1")
+		Ω(found).Should(BeTrue())
+		Ω(isAlias).Should(BeTrue())
+		Ω(columnID).Should(Equal(1))
+		_, _, found = schema.GetColumnID("old1")
+		Ω(found).Should(BeFalse())
+
+		utils.SetCurrentTime(time.Unix(2000, 0))
+		_, _, found = schema.GetColumnID("c1")
+		Ω(found).Should(BeFalse())
+		_, _, found = schema.GetColumnID("new1")
+		Ω(found).Should(BeTrue())
+	})
+})
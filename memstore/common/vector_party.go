@@ -192,6 +192,9 @@ type ArchiveVectorParty interface {
 	LoadFromDisk(hostMemManager HostMemoryManager, diskStore diskstore.DiskStore, table string, shardID int, columnID, batchID int, batchVersion uint32, seqNum uint32)
 	// WaitForDiskLoad waits for vector party disk load to finish
 	WaitForDiskLoad()
+	// LoadError returns the error of the disk load, vector party is empty
+	// and should not be used if it's not nil
+	LoadError() error
 	// Prune prunes vector party based on column mode to clean memory if possible
 	Prune()
 
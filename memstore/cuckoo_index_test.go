@@ -18,6 +18,7 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	memCom "github.com/uber/aresdb/memstore/common"
+	"math"
 	"math/rand"
 	"testing"
 )
@@ -412,6 +413,9 @@ func (*TestHostMemoryManager) GetArchiveMemoryUsageByTableShard() (map[string]ma
 }
 func (*TestHostMemoryManager) TriggerEviction() {
 }
+func (*TestHostMemoryManager) GetFreeSpace() int64 {
+	return math.MaxInt64
+}
 func (*TestHostMemoryManager) TriggerPreload(tableName string, columnID int, oldPreloadingDays int, newPreloadingDays int) {
 }
 func (*TestHostMemoryManager) Start() {
@@ -0,0 +1,123 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/uber/aresdb/diskstore"
+	"github.com/uber/aresdb/utils"
+)
+
+// percentage of the disk quota above which a warning alert is fired if not configured for the table.
+const defaultDiskQuotaWarningPercent = 80
+
+// scanDiskUsage starts tracking disk usage of the shard so that disk quota of the table accounts files
+// from before the restart.
+func (shard *TableShard) scanDiskUsage() {
+	if err := diskstore.ScanTableShardDiskUsage(shard.diskStore, shard.Schema.Schema.Name, shard.ShardID); err != nil {
+		utils.GetLogger().With("table", shard.Schema.Schema.Name, "shard", shard.ShardID, "error", err.Error()).
+			Error("Failed to scan disk usage")
+	}
+}
+
+// evaluateDiskQuota returns the level of the disk usage against the quota.
+func evaluateDiskQuota(usedBytes, quotaBytes int64, warningPercent int) diskstore.DiskSpaceLevel {
+	if quotaBytes <= 0 {
+		return diskstore.DiskSpaceNormal
+	}
+	if usedBytes >= quotaBytes {
+		return diskstore.DiskSpaceCritical
+	}
+	if warningPercent <= 0 {
+		warningPercent = defaultDiskQuotaWarningPercent
+	}
+	if float64(usedBytes)*100 >= float64(warningPercent)*float64(quotaBytes) {
+		return diskstore.DiskSpaceLow
+	}
+	return diskstore.DiskSpaceNormal
+}
+
+// checkDiskQuota returns a quota error if disk usage of the table exceeds its quota. Level changes are
+// logged and posted to the alert webhook.
+func (m *memStoreImpl) checkDiskQuota(table string) error {
+	m.RLock()
+	schema := m.TableSchemas[table]
+	m.RUnlock()
+	if schema == nil {
+		return nil
+	}
+	schema.RLock()
+	quotaBytes := schema.Schema.Config.DiskQuotaBytes
+	warningPercent := schema.Schema.Config.DiskQuotaWarningPercent
+	schema.RUnlock()
+
+	var usedBytes int64
+	if quotaBytes > 0 {
+		usedBytes = diskstore.GetDiskUsage().TableBytes(table)
+	}
+	level := evaluateDiskQuota(usedBytes, quotaBytes, warningPercent)
+	m.updateDiskQuotaLevel(table, level, usedBytes, quotaBytes)
+
+	if level == diskstore.DiskSpaceCritical {
+		return utils.APIError{
+			Code: http.StatusTooManyRequests,
+			Message: fmt.Sprintf("Ingestion to table %s is paused since its disk usage %d bytes exceeds quota %d bytes",
+				table, usedBytes, quotaBytes),
+		}
+	}
+	return nil
+}
+
+func (m *memStoreImpl) updateDiskQuotaLevel(table string, level diskstore.DiskSpaceLevel, usedBytes,
+	quotaBytes int64) {
+	m.diskQuotaLock.Lock()
+	previousLevel := m.diskQuotaLevels[table]
+	if level == previousLevel {
+		m.diskQuotaLock.Unlock()
+		return
+	}
+	if m.diskQuotaLevels == nil {
+		m.diskQuotaLevels = make(map[string]diskstore.DiskSpaceLevel)
+	}
+	m.diskQuotaLevels[table] = level
+	m.diskQuotaLock.Unlock()
+
+	logger := utils.GetLogger().With("table", table, "usedBytes", usedBytes, "quotaBytes", quotaBytes,
+		"level", level.String(), "previousLevel", previousLevel.String())
+	if level > previousLevel {
+		logger.Warn("Disk usage of table rose above quota watermark")
+	} else {
+		logger.Info("Disk usage of table recovered")
+	}
+
+	if m.options.diskSpaceMonitor != nil {
+		m.options.diskSpaceMonitor.SendDiskQuotaAlert(diskstore.DiskQuotaAlert{
+			Table:         table,
+			Level:         level.String(),
+			PreviousLevel: previousLevel.String(),
+			UsedBytes:     usedBytes,
+			QuotaBytes:    quotaBytes,
+			Time:          utils.Now().Unix(),
+		})
+	}
+}
+
+func (m *memStoreImpl) deleteDiskQuotaLevel(table string) {
+	m.diskQuotaLock.Lock()
+	defer m.diskQuotaLock.Unlock()
+	delete(m.diskQuotaLevels, table)
+}
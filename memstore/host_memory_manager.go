@@ -259,6 +259,11 @@ func (h *hostMemoryManager) TriggerEviction() {
 	go func() { h.evictionJobChan <- struct{}{} }()
 }
 
+// GetFreeSpace returns host memory left under the limit, negative if over the limit.
+func (h *hostMemoryManager) GetFreeSpace() int64 {
+	return h.totalMemorySize - h.getUnmanagedSpaceUsage() - h.getManagedSpaceUsage()
+}
+
 func (h *hostMemoryManager) getUnmanagedSpaceUsage() int64 {
 	return atomic.LoadInt64(&h.unManagedMemorySize)
 }
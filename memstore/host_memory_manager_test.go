@@ -88,7 +88,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 			Return(nil, nil).Once()
 
 		serializer := &vectorPartyArchiveSerializer{
-			vectorPartyBaseSerializer{
+			vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 				table:             "test",
 				diskstore:         testDiskStore,
 				hostMemoryManager: testHostMemoryManager,
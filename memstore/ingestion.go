@@ -18,14 +18,28 @@ import (
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
 	"math"
+	"net/http"
 	"strconv"
 )
 
+// ErrDiskSpaceCritical is returned by HandleIngestion when free disk space is below the hard
+// watermark. Clients should back off and retry later.
+var ErrDiskSpaceCritical = utils.APIError{
+	Code:    http.StatusTooManyRequests,
+	Message: "Ingestion is paused since free disk space is below hard watermark",
+}
+
 // HandleIngestion logs an upsert batch and applies it to the in-memory store.
 func (m *memStoreImpl) HandleIngestion(table string, shardID int, upsertBatch *common.UpsertBatch) error {
 	if m.options.redoLogMaster.RedoLogConfig.DiskConfig.Disabled {
 		return utils.StackError(nil, "Local redolog file not enabled")
 	}
+	if m.options.isWriteProtected() {
+		return ErrDiskSpaceCritical
+	}
+	if err := m.checkDiskQuota(table); err != nil {
+		return err
+	}
 	shard, err := m.GetTableShard(table, shardID)
 	if err != nil {
 		return utils.StackError(nil, "Failed to get shard %d for table %s for upsert batch", shardID, table)
@@ -76,6 +90,7 @@ func (shard *TableShard) ApplyUpsertBatch(upsertBatch *common.UpsertBatch, redoL
 	shard.Schema.RLock()
 	valueTypeByColumn := shard.Schema.ValueTypeByColumn
 	columnDeletions := shard.Schema.GetColumnDeletions()
+	columnDeprecations := shard.Schema.GetColumnDeprecations()
 	allowMissingEventTime := shard.Schema.Schema.Config.AllowMissingEventTime
 	shard.Schema.RUnlock()
 	primaryKeyColumns := shard.Schema.GetPrimaryKeyColumns()
@@ -103,6 +118,11 @@ func (shard *TableShard) ApplyUpsertBatch(upsertBatch *common.UpsertBatch, redoL
 		if columnID == 0 && isFactTable {
 			eventTimeColumnIndex = i
 		}
+
+		// deprecated columns are still ingested until they are purged.
+		if columnDeprecations[columnID] {
+			utils.GetReporter(shard.Schema.Schema.Name, shard.ShardID).GetCounter(utils.DeprecatedColumnsIngested).Inc(1)
+		}
 	}
 
 	// For fact table ingestion, we will need to get the event time from the first column. we don't
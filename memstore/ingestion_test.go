@@ -17,9 +17,13 @@ package memstore
 import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	aresdbCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/redolog"
 	"github.com/uber/aresdb/utils"
+	"net/http"
+	"os"
 	"time"
 )
 
@@ -34,6 +38,56 @@ var _ = ginkgo.Describe("ingestion", func() {
 		Ω(shard.LiveStore.lastModifiedTimePerColumn).Should(BeNil())
 	})
 
+	ginkgo.It("rejects ingestion when disk space is critical", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{}, []int{}, 10, false, false, nil, CreateMockDiskStore())
+		monitor := diskstore.NewDiskSpaceMonitor("/tmp", aresdbCommon.DiskSpaceConfig{HardWatermarkPercent: 100})
+		monitor.Check()
+		memstore.options = memstore.options.SetDiskSpaceMonitor(monitor)
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := common.NewUpsertBatch(buffer)
+		err := memstore.HandleIngestion("abc", 0, upsertBatch)
+		Ω(err).Should(Equal(ErrDiskSpaceCritical))
+	})
+
+	ginkgo.It("rejects ingestion when disk quota of table is exceeded", func() {
+		rootPath := "/tmp/testIngestionDiskQuota"
+		os.RemoveAll(rootPath)
+		defer os.RemoveAll(rootPath)
+		defer diskstore.GetDiskUsage().DeleteTable("abc")
+		ds, err := diskstore.NewDiskStore(rootPath, aresdbCommon.DiskStoreConfig{})
+		Ω(err).Should(BeNil())
+		writer, err := ds.OpenLogFileForAppend("abc", 0, 1)
+		Ω(err).Should(BeNil())
+		_, err = writer.Write(make([]byte, 10))
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+		Ω(diskstore.ScanTableShardDiskUsage(ds, "abc", 0)).Should(BeNil())
+
+		memstore := createMemStore("abc", 0, []common.DataType{}, []int{}, 10, false, false, nil, CreateMockDiskStore())
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := common.NewUpsertBatch(buffer)
+		memstore.TableSchemas["abc"].Schema.Config.DiskQuotaBytes = 12
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+		Ω(memstore.diskQuotaLevels["abc"]).Should(Equal(diskstore.DiskSpaceLow))
+
+		memstore.TableSchemas["abc"].Schema.Config.DiskQuotaBytes = 10
+		err = memstore.HandleIngestion("abc", 0, upsertBatch)
+		Ω(err).Should(BeAssignableToTypeOf(utils.APIError{}))
+		Ω(err.(utils.APIError).Code).Should(Equal(http.StatusTooManyRequests))
+
+		memstore.TableSchemas["abc"].Schema.Config.DiskQuotaBytes = 0
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+		Ω(memstore.diskQuotaLevels["abc"]).Should(Equal(diskstore.DiskSpaceNormal))
+	})
+
+	ginkgo.It("evaluates disk quota levels", func() {
+		Ω(evaluateDiskQuota(100, 0, 0)).Should(Equal(diskstore.DiskSpaceNormal))
+		Ω(evaluateDiskQuota(79, 100, 0)).Should(Equal(diskstore.DiskSpaceNormal))
+		Ω(evaluateDiskQuota(80, 100, 0)).Should(Equal(diskstore.DiskSpaceLow))
+		Ω(evaluateDiskQuota(80, 100, 90)).Should(Equal(diskstore.DiskSpaceNormal))
+		Ω(evaluateDiskQuota(100, 100, 90)).Should(Equal(diskstore.DiskSpaceCritical))
+	})
+
 	ginkgo.It("returns error for unrecognized table", func() {
 		memstore := createMemStore("abc", 0, []common.DataType{}, []int{}, 10, false, false, nil, CreateMockDiskStore())
 		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
@@ -20,12 +20,15 @@ import (
 	"github.com/uber/aresdb/utils"
 	"strings"
 	"sync"
+	"time"
 )
 
 // JobManager is responsible for generating new jobs to run and manages job related stats.
 type jobManager interface {
 	generateJobs() []Job
 	getJobDetails() interface{}
+	// lookupJobDetail returns a copy of the common job detail of the key, false if not found.
+	lookupJobDetail(key string) (JobDetail, bool)
 	deleteTable(table string)
 	// mutator is guaranteed to be a functor by caller(scheduler).
 	reportJobDetail(key string, mutator jobDetailMutator)
@@ -52,8 +55,12 @@ func newArchiveJobManager(scheduler *schedulerImpl) jobManager {
 
 // generateJobs iterates each table shard from memStore and prepare list of archive jobs
 // to run. A job should start to run only when newCutoff - cutoff > interval, where
-// newCutoff = now - delay.
+// newCutoff = now - delay. No jobs are generated when free disk space is below hard watermark.
 func (m *archiveJobManager) generateJobs() []Job {
+	if m.memStore.options.isWriteProtected() {
+		return nil
+	}
+
 	m.memStore.RLock()
 	defer m.memStore.RUnlock()
 
@@ -96,6 +103,15 @@ func (m *archiveJobManager) getJobDetails() interface{} {
 	return m.jobDetails
 }
 
+func (m *archiveJobManager) lookupJobDetail(key string) (JobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.JobDetail, true
+	}
+	return JobDetail{}, false
+}
+
 func (m *archiveJobManager) reportJobDetail(key string, jobMutator jobDetailMutator) {
 	m.Lock()
 	defer m.Unlock()
@@ -187,6 +203,11 @@ func newBackfillJobManager(scheduler *schedulerImpl) jobManager {
 // generateJobs iterates each table shard from memStore and prepare list of backfill jobs
 // to run.
 func (m *backfillJobManager) generateJobs() []Job {
+	// backfill rewrites archive batches on disk, pause it together with archiving.
+	if m.memStore.options.isWriteProtected() {
+		return nil
+	}
+
 	m.memStore.RLock()
 	defer m.memStore.RUnlock()
 
@@ -241,6 +262,15 @@ func (m *backfillJobManager) getJobDetails() interface{} {
 	return m.jobDetails
 }
 
+func (m *backfillJobManager) lookupJobDetail(key string) (JobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.JobDetail, true
+	}
+	return JobDetail{}, false
+}
+
 func (m *backfillJobManager) reportJobDetail(key string, jobMutator jobDetailMutator) {
 	m.Lock()
 	defer m.Unlock()
@@ -369,6 +399,15 @@ func (m *snapshotJobManager) getJobDetails() interface{} {
 	return m.jobDetails
 }
 
+func (m *snapshotJobManager) lookupJobDetail(key string) (JobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.JobDetail, true
+	}
+	return JobDetail{}, false
+}
+
 // deleteTable deletes metadata for the table in snapshotJobManager.
 func (m *snapshotJobManager) deleteTable(table string) {
 	m.Lock()
@@ -455,8 +494,11 @@ func newPurgeJobManager(scheduler *schedulerImpl) jobManager {
 }
 
 // generateJobs iterates each table shard from memStore and prepare list of purge jobs
-// to run.
+// to run. When free disk space is below soft watermark, purge jobs are generated regardless
+// of the purge interval to release disk space sooner.
 func (m *purgeJobManager) generateJobs() []Job {
+	accelerated := m.memStore.options.isDiskSpaceLow()
+
 	m.memStore.RLock()
 	defer m.memStore.RUnlock()
 
@@ -469,7 +511,7 @@ func (m *purgeJobManager) generateJobs() []Job {
 			}
 			retentionDays := tableShard.Schema.Schema.Config.RecordRetentionInDays
 			key := getIdentifier(tableName, shardID, common.PurgeJobType)
-			if tableShard.ArchiveStore.PurgeManager.QualifyForPurge() &&
+			if (accelerated || tableShard.ArchiveStore.PurgeManager.QualifyForPurge()) &&
 				tableShard.Schema.Schema.IsFactTable && retentionDays > 0 {
 				batchCutOff := nowInDay - retentionDays
 				jobs = append(jobs, m.scheduler.NewPurgeJob(tableName, shardID, 0, nowInDay-retentionDays))
@@ -491,6 +533,15 @@ func (m *purgeJobManager) getJobDetails() interface{} {
 	return m.jobDetails
 }
 
+func (m *purgeJobManager) lookupJobDetail(key string) (JobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.JobDetail, true
+	}
+	return JobDetail{}, false
+}
+
 func (m *purgeJobManager) getJobDetail(key string) *PurgeJobDetail {
 	jobDetail, found := m.jobDetails[key]
 	if !found {
@@ -556,3 +607,296 @@ func (job *PurgeJob) String() string {
 func (job *PurgeJob) JobType() common.JobType {
 	return common.PurgeJobType
 }
+
+type columnMaterializeJobManager struct {
+	sync.RWMutex
+	// column materialize job details for different tables, shard. Key is {tableName}|{shardID}|column_materialize,
+	jobDetails map[string]*ColumnMaterializeJobDetail
+	memStore   *memStoreImpl
+	scheduler  *schedulerImpl
+}
+
+// newColumnMaterializeJobManager creates a new jobManager to manage column materialize jobs.
+func newColumnMaterializeJobManager(scheduler *schedulerImpl) jobManager {
+	return &columnMaterializeJobManager{
+		jobDetails: make(map[string]*ColumnMaterializeJobDetail),
+		memStore:   scheduler.memStore,
+		scheduler:  scheduler,
+	}
+}
+
+// generateJobs iterates each fact table shard from memStore and prepare list of jobs to materialize
+// default values of columns added with default value backfill. A job is generated for a table shard
+// after each restart and after columns are added since it last succeeded.
+func (m *columnMaterializeJobManager) generateJobs() []Job {
+	m.memStore.RLock()
+	defer m.memStore.RUnlock()
+
+	var jobs []Job
+	for tableName, shardMap := range m.memStore.TableShards {
+		for shardID, tableShard := range shardMap {
+			tableShard.Schema.RLock()
+			isFactTable := tableShard.Schema.Schema.IsFactTable
+			columns := getColumnsToMaterialize(&tableShard.Schema.Schema)
+			numColumns := len(tableShard.Schema.ValueTypeByColumn)
+			tableShard.Schema.RUnlock()
+			if !isFactTable || len(columns) == 0 || !tableShard.IsDiskDataAvailable() {
+				continue
+			}
+
+			key := getIdentifier(tableName, shardID, common.ColumnMaterializeJobType)
+			m.Lock()
+			jobDetail := m.getJobDetail(key)
+			materialized := jobDetail.Status == JobSucceeded && jobDetail.NumColumns == numColumns
+			if !materialized {
+				jobDetail.Status = JobReady
+			}
+			m.Unlock()
+			if !materialized {
+				jobs = append(jobs, m.scheduler.newColumnMaterializeJob(tableName, shardID))
+			}
+		}
+	}
+	return jobs
+}
+
+func (m *columnMaterializeJobManager) getJobDetails() interface{} {
+	m.RLock()
+	defer m.RUnlock()
+	return m.jobDetails
+}
+
+func (m *columnMaterializeJobManager) lookupJobDetail(key string) (JobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.JobDetail, true
+	}
+	return JobDetail{}, false
+}
+
+// caller needs to hold the write lock.
+func (m *columnMaterializeJobManager) getJobDetail(key string) *ColumnMaterializeJobDetail {
+	jobDetail, found := m.jobDetails[key]
+	if !found {
+		jobDetail = &ColumnMaterializeJobDetail{}
+		m.jobDetails[key] = jobDetail
+	}
+	return jobDetail
+}
+
+func (m *columnMaterializeJobManager) reportJobDetail(key string, jobMutator jobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	columnMaterializeJobDetail := m.getJobDetail(key)
+	jobDetail := &columnMaterializeJobDetail.JobDetail
+	jobMutator(jobDetail)
+}
+
+func (m *columnMaterializeJobManager) reportColumnMaterializeJobDetail(key string,
+	jobMutator ColumnMaterializeJobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobMutator(m.getJobDetail(key))
+}
+
+// deleteTable deletes metadata for the table in columnMaterializeJobManager.
+func (m *columnMaterializeJobManager) deleteTable(table string) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.jobDetails {
+		if strings.HasPrefix(key, table) {
+			delete(m.jobDetails, key)
+		}
+	}
+}
+
+// ColumnMaterializeJob defines the structure that a column materialize job needs.
+type ColumnMaterializeJob struct {
+	tableName string
+	shardID   int
+	memStore  MemStore
+	reporter  ColumnMaterializeJobDetailReporter
+}
+
+// Run materializes default values of columns into archive batches of the shard.
+func (job *ColumnMaterializeJob) Run() error {
+	shard, err := job.memStore.GetTableShard(job.tableName, job.shardID)
+	if err != nil {
+		return err
+	}
+	defer shard.Users.Done()
+	return shard.MaterializeDefaultValues(job.reporter, job.GetIdentifier())
+}
+
+// GetIdentifier returns a unique identifier of this job.
+func (job *ColumnMaterializeJob) GetIdentifier() string {
+	return getIdentifier(job.tableName, job.shardID, common.ColumnMaterializeJobType)
+}
+
+// String gives meaningful string representation for this job
+func (job *ColumnMaterializeJob) String() string {
+	return fmt.Sprintf("ColumnMaterializeJob<Table: %s, ShardID: %d>",
+		job.tableName, job.shardID)
+}
+
+// JobType return job type
+func (job *ColumnMaterializeJob) JobType() common.JobType {
+	return common.ColumnMaterializeJobType
+}
+
+type integrityCheckJobManager struct {
+	sync.RWMutex
+	// integrity check job details for different tables, shard. Key is {tableName}|{shardID}|integrity_check,
+	jobDetails map[string]*IntegrityCheckJobDetail
+	memStore   *memStoreImpl
+	scheduler  *schedulerImpl
+	// time the job manager is created, table shards are first checked one interval after it.
+	startTime time.Time
+}
+
+// newIntegrityCheckJobManager creates a new jobManager to manage integrity check jobs.
+func newIntegrityCheckJobManager(scheduler *schedulerImpl) jobManager {
+	return &integrityCheckJobManager{
+		jobDetails: make(map[string]*IntegrityCheckJobDetail),
+		memStore:   scheduler.memStore,
+		scheduler:  scheduler,
+		startTime:  utils.Now(),
+	}
+}
+
+// generateJobs iterates each table shard from memStore and prepare list of jobs to verify files of table
+// shards whose last check was at least diskstore.integrity_check_interval_hours ago.
+func (m *integrityCheckJobManager) generateJobs() []Job {
+	config := utils.GetConfig().DiskStore
+	if config.IntegrityCheckIntervalHours <= 0 {
+		return nil
+	}
+	interval := time.Duration(config.IntegrityCheckIntervalHours) * time.Hour
+	now := utils.Now()
+
+	m.memStore.RLock()
+	defer m.memStore.RUnlock()
+
+	var jobs []Job
+	for tableName, shardMap := range m.memStore.TableShards {
+		for shardID, tableShard := range shardMap {
+			// table shards waiting for peer copy will be replaced on bootstrap anyway.
+			if !tableShard.IsDiskDataAvailable() {
+				continue
+			}
+
+			key := getIdentifier(tableName, shardID, common.IntegrityCheckJobType)
+			m.Lock()
+			jobDetail := m.getJobDetail(key)
+			lastCheck := jobDetail.LastStartTime
+			if lastCheck.IsZero() {
+				lastCheck = m.startTime
+			}
+			due := now.Sub(lastCheck) >= interval
+			if due {
+				jobDetail.Status = JobReady
+				jobDetail.NextRun = now
+			} else {
+				jobDetail.NextRun = lastCheck.Add(interval)
+			}
+			m.Unlock()
+			if due {
+				jobs = append(jobs, m.scheduler.newIntegrityCheckJob(tableName, shardID, config.IntegrityCheckBytesPerSec))
+			}
+		}
+	}
+	return jobs
+}
+
+func (m *integrityCheckJobManager) getJobDetails() interface{} {
+	m.RLock()
+	defer m.RUnlock()
+	return m.jobDetails
+}
+
+func (m *integrityCheckJobManager) lookupJobDetail(key string) (JobDetail, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.JobDetail, true
+	}
+	return JobDetail{}, false
+}
+
+// caller needs to hold the write lock.
+func (m *integrityCheckJobManager) getJobDetail(key string) *IntegrityCheckJobDetail {
+	jobDetail, found := m.jobDetails[key]
+	if !found {
+		jobDetail = &IntegrityCheckJobDetail{}
+		m.jobDetails[key] = jobDetail
+	}
+	return jobDetail
+}
+
+func (m *integrityCheckJobManager) reportJobDetail(key string, jobMutator jobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobMutator(&m.getJobDetail(key).JobDetail)
+}
+
+func (m *integrityCheckJobManager) reportIntegrityCheckJobDetail(key string,
+	jobMutator IntegrityCheckJobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobMutator(m.getJobDetail(key))
+}
+
+// deleteTable deletes metadata for the table in integrityCheckJobManager.
+func (m *integrityCheckJobManager) deleteTable(table string) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.jobDetails {
+		if strings.HasPrefix(key, table) {
+			delete(m.jobDetails, key)
+		}
+	}
+}
+
+// IntegrityCheckJob defines the structure that an integrity check job needs.
+type IntegrityCheckJob struct {
+	tableName   string
+	shardID     int
+	bytesPerSec int64
+	memStore    MemStore
+	reporter    IntegrityCheckJobDetailReporter
+}
+
+// Run verifies files of the table shard on disk against their checksums.
+func (job *IntegrityCheckJob) Run() error {
+	shard, err := job.memStore.GetTableShard(job.tableName, job.shardID)
+	if err != nil {
+		return err
+	}
+	defer shard.Users.Done()
+	numCorrupted, err := shard.VerifyDiskData(job.bytesPerSec)
+	job.reporter(job.GetIdentifier(), func(status *IntegrityCheckJobDetail) {
+		status.NumCorruptedFiles = numCorrupted
+	})
+	if err != nil {
+		// scheduler panics on job failures, a failed check will be retried in next interval instead.
+		utils.GetLogger().With("job", job, "error", err).Error("Failed to verify table shard files")
+	}
+	return nil
+}
+
+// GetIdentifier returns a unique identifier of this job.
+func (job *IntegrityCheckJob) GetIdentifier() string {
+	return getIdentifier(job.tableName, job.shardID, common.IntegrityCheckJobType)
+}
+
+// String gives meaningful string representation for this job
+func (job *IntegrityCheckJob) String() string {
+	return fmt.Sprintf("IntegrityCheckJob<Table: %s, ShardID: %d>",
+		job.tableName, job.shardID)
+}
+
+// JobType return job type
+func (job *IntegrityCheckJob) JobType() common.JobType {
+	return common.IntegrityCheckJobType
+}
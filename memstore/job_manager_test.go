@@ -28,6 +28,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
 	sysmock "github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
 )
 
 var _ = ginkgo.Describe("job manager", func() {
@@ -486,6 +487,43 @@ var _ = ginkgo.Describe("job manager", func() {
 		scheduler.RUnlock()
 	})
 
+	ginkgo.It("Test prepareIntegrityCheckJobs", func() {
+		scheduler := newScheduler(m)
+		jobManager := scheduler.jobManagers[memCom.IntegrityCheckJobType]
+
+		// disabled by default.
+		Ω(jobManager.generateJobs()).Should(BeEmpty())
+
+		oldConfig := utils.GetConfig()
+		config := oldConfig
+		config.DiskStore.IntegrityCheckIntervalHours = 1
+		config.DiskStore.IntegrityCheckBytesPerSec = 100
+		utils.Init(config, utils.GetLogger(), utils.GetQueryLogger(), tally.NewTestScope("test", nil))
+		defer utils.Init(oldConfig, utils.GetLogger(), utils.GetQueryLogger(), tally.NewTestScope("test", nil))
+
+		// not due until one interval after start.
+		Ω(jobManager.generateJobs()).Should(BeEmpty())
+
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(int64(now)+3600, 0)
+		})
+		jobs := jobManager.generateJobs()
+		Ω(jobs).Should(HaveLen(4))
+		for _, job := range jobs {
+			Ω(job).Should(BeAssignableToTypeOf(&IntegrityCheckJob{}))
+			Ω(job.(*IntegrityCheckJob).bytesPerSec).Should(BeEquivalentTo(100))
+		}
+
+		// table shards checked recently are skipped.
+		jobManager.reportJobDetail(getIdentifier(table1, 1, memCom.IntegrityCheckJobType), func(jobDetail *JobDetail) {
+			jobDetail.LastStartTime = utils.Now()
+		})
+		Ω(jobManager.generateJobs()).Should(HaveLen(3))
+
+		scheduler.DeleteTable(table1, true)
+		Ω(jobManager.getJobDetails()).Should(HaveLen(2))
+	})
+
 	ginkgo.It("Test Purge job", func() {
 		purgeJob := PurgeJob{
 			tableName: tableName,
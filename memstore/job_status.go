@@ -43,6 +43,7 @@ type JobStatus string
 
 // List of JobStatus.
 const (
+	JobQueued    JobStatus = "queued"
 	JobWaiting   JobStatus = "waiting"
 	JobReady     JobStatus = "ready"
 	JobRunning   JobStatus = "running"
@@ -71,6 +72,26 @@ const (
 	PurgeComplete PurgeStage = "complete"
 )
 
+// ParquetExportStage represents different stages of a running parquet export job.
+type ParquetExportStage string
+
+// List of parquet export stages.
+const (
+	ParquetExportExport   ParquetExportStage = "export"
+	ParquetExportComplete ParquetExportStage = "complete"
+)
+
+// ParquetImportFileStatus represents the import status of a parquet file.
+type ParquetImportFileStatus string
+
+// List of parquet import file status.
+const (
+	ParquetImportPending   ParquetImportFileStatus = "pending"
+	ParquetImportRunning   ParquetImportFileStatus = "running"
+	ParquetImportSucceeded ParquetImportFileStatus = "succeeded"
+	ParquetImportFailed    ParquetImportFileStatus = "failed"
+)
+
 // ArchiveJobDetailMutator is the mutator functor to change ArchiveJobDetail.
 type ArchiveJobDetailMutator func(jobDetail *ArchiveJobDetail)
 
@@ -95,6 +116,30 @@ type SnapshotJobDetailReporter func(key string, mutator SnapshotJobDetailMutator
 // PurgeJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
 type PurgeJobDetailReporter func(key string, mutator PurgeJobDetailMutator)
 
+// ParquetExportJobDetailMutator is the mutator functor to change ParquetExportJobDetail.
+type ParquetExportJobDetailMutator func(jobDetail *ParquetExportJobDetail)
+
+// ParquetExportJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
+type ParquetExportJobDetailReporter func(key string, mutator ParquetExportJobDetailMutator)
+
+// ColumnMaterializeJobDetailMutator is the mutator functor to change ColumnMaterializeJobDetail.
+type ColumnMaterializeJobDetailMutator func(jobDetail *ColumnMaterializeJobDetail)
+
+// ColumnMaterializeJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
+type ColumnMaterializeJobDetailReporter func(key string, mutator ColumnMaterializeJobDetailMutator)
+
+// IntegrityCheckJobDetailMutator is the mutator functor to change IntegrityCheckJobDetail.
+type IntegrityCheckJobDetailMutator func(jobDetail *IntegrityCheckJobDetail)
+
+// IntegrityCheckJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
+type IntegrityCheckJobDetailReporter func(key string, mutator IntegrityCheckJobDetailMutator)
+
+// ParquetImportJobDetailMutator is the mutator functor to change ParquetImportJobDetail.
+type ParquetImportJobDetailMutator func(jobDetail *ParquetImportJobDetail)
+
+// ParquetImportJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
+type ParquetImportJobDetailReporter func(key string, mutator ParquetImportJobDetailMutator)
+
 // jobDetailMutator is the functor that change JobDetail.
 type jobDetailMutator func(jobDetail *JobDetail)
 
@@ -182,3 +227,69 @@ type PurgeJobDetail struct {
 	BatchIDStart int `json:"batchIDStart"`
 	BatchIDEnd   int `json:"batchIDEnd"`
 }
+
+// ParquetExportJobDetail represents parquet export job status of a table shard.
+type ParquetExportJobDetail struct {
+	JobDetail
+	// Stage of the job is running.
+	Stage ParquetExportStage `json:"stage"`
+	// Where files are exported to.
+	Destination  string `json:"destination"`
+	BatchIDStart int    `json:"batchIDStart"`
+	BatchIDEnd   int    `json:"batchIDEnd"`
+	// Current batch being exported.
+	BatchID int `json:"batchID"`
+	// Number of days exported by this run.
+	NumExportedDays int `json:"numExportedDays"`
+	// Number of days skipped since they were exported by previous runs.
+	NumSkippedDays int `json:"numSkippedDays"`
+	// Number of bytes of exported files.
+	NumBytes int64 `json:"numBytes"`
+}
+
+// ParquetImportFileDetail represents import progress of a parquet file.
+type ParquetImportFileDetail struct {
+	Path   string                  `json:"path"`
+	Status ParquetImportFileStatus `json:"status"`
+	// Error if the file failed to import.
+	Error string `json:"error,omitempty"`
+	// Number of row groups in the file and number of row groups imported.
+	NumRowGroups     int   `json:"numRowGroups"`
+	NumRowGroupsDone int   `json:"numRowGroupsDone"`
+	NumRows          int64 `json:"numRows"`
+	NumImportedRows  int64 `json:"numImportedRows"`
+	NumRejectedRows  int64 `json:"numRejectedRows"`
+	// First few reasons of rejected rows.
+	RejectedRowSamples []string `json:"rejectedRowSamples,omitempty"`
+}
+
+// ParquetImportJobDetail represents parquet import job status of a table.
+type ParquetImportJobDetail struct {
+	JobDetail
+	// Where files are imported from.
+	Source string `json:"source"`
+	// Whether rows are written into archive batches directly.
+	Archive bool                      `json:"archive"`
+	Files   []ParquetImportFileDetail `json:"files"`
+}
+
+// ColumnMaterializeJobDetail represents the status of materializing default values of added columns
+// into archive batches of a table shard.
+type ColumnMaterializeJobDetail struct {
+	JobDetail
+	// IDs of columns to materialize.
+	Columns []int `json:"columns"`
+	// Number of columns in the schema when the job started.
+	NumColumns int `json:"numColumns"`
+	// Current batch being checked.
+	BatchID int `json:"batchID"`
+	// Number of batches rewritten by this run. Batches already materialized by previous runs are skipped.
+	NumMaterializedBatches int `json:"numMaterializedBatches"`
+}
+
+// IntegrityCheckJobDetail represents the status of verifying files of a table shard on disk.
+type IntegrityCheckJobDetail struct {
+	JobDetail
+	// Number of corrupted files found by the last run.
+	NumCorruptedFiles int `json:"numCorruptedFiles"`
+}
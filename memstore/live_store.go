@@ -17,10 +17,12 @@ package memstore
 import (
 	"encoding/json"
 	"math"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/redolog"
 	"github.com/uber/aresdb/utils"
 )
@@ -130,6 +132,24 @@ func NewLiveStore(batchSize int, shard *TableShard) *LiveStore {
 	return ls
 }
 
+// ApplyTableConfig applies updated table config to the live store and its managers, the new batch
+// size only applies to batches created afterwards.
+func (s *LiveStore) ApplyTableConfig(tableConfig metaCom.TableConfig) {
+	s.Lock()
+	s.BatchSize = tableConfig.BatchSize
+	s.Unlock()
+
+	if s.BackfillManager != nil {
+		maxBufferSizeChange := s.BackfillManager.UpdateConfig(tableConfig)
+		s.HostMemoryManager.ReportUnmanagedSpaceUsageChange(
+			int64(maxBufferSizeChange * utils.GolangMemoryFootprintFactor))
+	}
+
+	if s.SnapshotManager != nil {
+		s.SnapshotManager.UpdateConfig(tableConfig)
+	}
+}
+
 // GetBatchIDs snapshots the batches and returns a list of batch ids for read
 // with the number of records in batchIDs[len()-1].
 func (s *LiveStore) GetBatchIDs() (batchIDs []int32, numRecordsInLastBatch int) {
@@ -140,6 +160,9 @@ func (s *LiveStore) GetBatchIDs() (batchIDs []int32, numRecordsInLastBatch int)
 			numRecordsInLastBatch = batch.Capacity
 		}
 	}
+	// batches are returned in the order of ids so that scans of unchanged batches are deterministic, e.g.
+	// for paginated queries.
+	sort.Slice(batchIDs, func(i, j int) bool { return batchIDs[i] < batchIDs[j] })
 	if s.LastReadRecord.Index > 0 {
 		batchIDs = append(batchIDs, s.LastReadRecord.BatchID)
 		numRecordsInLastBatch = int(s.LastReadRecord.Index)
@@ -213,6 +236,19 @@ func (s *LiveStore) AdvanceLastReadRecord() {
 	s.Unlock()
 }
 
+// GetLastModifiedTime returns the max event time ingested into any column, 0 if nothing is ingested.
+func (s *LiveStore) GetLastModifiedTime() uint32 {
+	s.WriterLock.RLock()
+	defer s.WriterLock.RUnlock()
+	var lastModifiedTime uint32
+	for _, t := range s.lastModifiedTimePerColumn {
+		if t > lastModifiedTime {
+			lastModifiedTime = t
+		}
+	}
+	return lastModifiedTime
+}
+
 // appendBatch appends a new batch. The batch is returned with its ID.
 func (s *LiveStore) appendBatch(batchID int32) *LiveBatch {
 	if s.Batches[batchID] != nil {
@@ -226,10 +262,11 @@ func (s *LiveStore) appendBatch(batchID int32) *LiveBatch {
 			RWMutex: &sync.RWMutex{},
 			Columns: make([]common.VectorParty, numColumns),
 		},
-		Capacity:  s.BatchSize,
 		liveStore: s,
 	}
 	s.Lock()
+	// batch size may be updated by table config changes.
+	batch.Capacity = s.BatchSize
 	s.Batches[batchID] = batch
 	s.Unlock()
 	return batch
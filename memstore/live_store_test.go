@@ -18,6 +18,7 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
 )
 
 var _ = ginkgo.Describe("live store", func() {
@@ -54,6 +55,38 @@ var _ = ginkgo.Describe("live store", func() {
 		Ω(b).Should(BeNil())
 	})
 
+	ginkgo.It("ApplyTableConfig should work", func() {
+		shard := &TableShard{
+			Schema: &common.TableSchema{
+				Schema: metaCom.Table{
+					Name:        "test",
+					IsFactTable: true,
+					Config:      metaCom.TableConfig{BatchSize: 10, BackfillMaxBufferSize: 100},
+				},
+				ValueTypeByColumn: []common.DataType{common.Uint32},
+				DefaultValues:     []*common.DataValue{&common.NullDataValue},
+			},
+			diskStore:         mockDiskStore,
+			HostMemoryManager: hostMemoryManager,
+			options:           m.options,
+		}
+		vs := NewLiveStore(10, shard)
+		b := vs.appendBatch(BaseBatchID)
+		Ω(b.Capacity).Should(Equal(10))
+
+		vs.ApplyTableConfig(metaCom.TableConfig{
+			BatchSize:                20,
+			BackfillMaxBufferSize:    200,
+			BackfillThresholdInBytes: 50,
+		})
+		Ω(vs.BackfillManager.MaxBufferSize).Should(BeEquivalentTo(200))
+		Ω(vs.BackfillManager.BackfillThresholdInBytes).Should(BeEquivalentTo(50))
+		// existing batches keep their capacity.
+		Ω(b.Capacity).Should(Equal(10))
+		b = vs.appendBatch(BaseBatchID + 1)
+		Ω(b.Capacity).Should(Equal(20))
+	})
+
 	ginkgo.It("provides live batches for reads and appends", func() {
 		shard := &TableShard{
 			Schema: &common.TableSchema{
@@ -107,7 +107,7 @@ var _ = ginkgo.Describe("live vector party", func() {
 
 	ginkgo.It("Write and Read of goLiveVectorParty should work", func() {
 		vpSerializer := &vectorPartyArchiveSerializer{
-			vectorPartyBaseSerializer{
+			vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 				hostMemoryManager: hostMemoryManager,
 			},
 		}
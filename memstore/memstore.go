@@ -103,6 +103,10 @@ type memStoreImpl struct {
 
 	// each MemStore should only have one scheduler instance.
 	scheduler Scheduler
+
+	// disk quota levels of tables as of last ingestion, protected by diskQuotaLock.
+	diskQuotaLock   sync.Mutex
+	diskQuotaLevels map[string]diskstore.DiskSpaceLevel
 }
 
 func getTableShardKey(tableName string, shardID int) string {
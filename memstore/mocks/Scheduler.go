@@ -33,6 +33,27 @@ func (_m *Scheduler) EnableJobType(jobType common.JobType, enable bool) {
 	_m.Called(jobType, enable)
 }
 
+// GetJobDetail provides a mock function with given fields: tableName, shardID, jobType
+func (_m *Scheduler) GetJobDetail(tableName string, shardID int, jobType common.JobType) (memstore.JobDetail, bool) {
+	ret := _m.Called(tableName, shardID, jobType)
+
+	var r0 memstore.JobDetail
+	if rf, ok := ret.Get(0).(func(string, int, common.JobType) memstore.JobDetail); ok {
+		r0 = rf(tableName, shardID, jobType)
+	} else {
+		r0 = ret.Get(0).(memstore.JobDetail)
+	}
+
+	var r1 bool
+	if rf, ok := ret.Get(1).(func(string, int, common.JobType) bool); ok {
+		r1 = rf(tableName, shardID, jobType)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	return r0, r1
+}
+
 // GetJobDetails provides a mock function with given fields: jobType
 func (_m *Scheduler) GetJobDetails(jobType common.JobType) interface{} {
 	ret := _m.Called(jobType)
@@ -15,6 +15,8 @@
 package memstore
 
 import (
+	xsync "github.com/m3db/m3/src/x/sync"
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/redolog"
 )
@@ -23,6 +25,10 @@ import (
 type Options struct {
 	bootstrapToken common.BootStrapToken
 	redoLogMaster  *redolog.RedoLogManagerMaster
+	// optional, ingestion and archiving are paused when disk space is critical.
+	diskSpaceMonitor *diskstore.DiskSpaceMonitor
+	// optional, shared by all table shards to write columns of archive batches concurrently.
+	archiveWriteWorkers xsync.WorkerPool
 }
 
 // NewOptions create new options instance
@@ -32,3 +38,31 @@ func NewOptions(bootstrapToken common.BootStrapToken, redoLogMaster *redolog.Red
 		redoLogMaster:  redoLogMaster,
 	}
 }
+
+// SetDiskSpaceMonitor sets the disk space monitor used for write protection.
+func (o Options) SetDiskSpaceMonitor(monitor *diskstore.DiskSpaceMonitor) Options {
+	o.diskSpaceMonitor = monitor
+	return o
+}
+
+// SetArchiveWriteConcurrency sets the max number of column files written concurrently when
+// persisting archive batches, shared by all table shards. Columns are written sequentially
+// if concurrency is not greater than 1.
+func (o Options) SetArchiveWriteConcurrency(concurrency int) Options {
+	o.archiveWriteWorkers = nil
+	if concurrency > 1 {
+		o.archiveWriteWorkers = xsync.NewWorkerPool(concurrency)
+		o.archiveWriteWorkers.Init()
+	}
+	return o
+}
+
+// isWriteProtected tells whether disk writes should be paused due to low disk space.
+func (o Options) isWriteProtected() bool {
+	return o.diskSpaceMonitor != nil && o.diskSpaceMonitor.IsWriteProtected()
+}
+
+// isDiskSpaceLow tells whether free disk space is below the soft watermark.
+func (o Options) isDiskSpaceLow() bool {
+	return o.diskSpaceMonitor != nil && o.diskSpaceMonitor.Level() >= diskstore.DiskSpaceLow
+}
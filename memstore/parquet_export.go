@@ -0,0 +1,493 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/diskstore"
+	"github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+	"github.com/uber/aresdb/utils/parquet"
+)
+
+// ParquetExportOptions specifies what to export.
+type ParquetExportOptions struct {
+	// Inclusive start and exclusive end of batch ids (days since epoch) to export.
+	BatchIDStart int
+	BatchIDEnd   int
+	// Columns to export, all columns are exported if empty.
+	Columns []string
+	// Exported bytes per second, not throttled if not positive.
+	BytesPerSec int64
+}
+
+// ExportDestination is where exported files are written into.
+type ExportDestination interface {
+	// Exists tells whether the file at the relative path has been written.
+	Exists(path string) (bool, error)
+	// Write writes the file at the relative path. The file is either fully written or not
+	// written at all.
+	Write(path string, data []byte) error
+	String() string
+}
+
+// localExportDestination writes exported files into a local directory.
+type localExportDestination struct {
+	dir string
+}
+
+// NewLocalExportDestination creates an ExportDestination writing into the local directory.
+func NewLocalExportDestination(dir string) ExportDestination {
+	return localExportDestination{dir: dir}
+}
+
+func (d localExportDestination) Exists(path string) (bool, error) {
+	_, err := os.Stat(filepath.Join(d.dir, path))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d localExportDestination) Write(path string, data []byte) error {
+	path = filepath.Join(d.dir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return utils.StackError(err, "Failed to create dir for %s", path)
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return utils.StackError(err, "Failed to write %s", tmpPath)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return utils.StackError(err, "Failed to rename %s", tmpPath)
+	}
+	return nil
+}
+
+func (d localExportDestination) String() string {
+	return d.dir
+}
+
+// objectStoreExportDestination writes exported files as objects under a key prefix.
+type objectStoreExportDestination struct {
+	objectStore diskstore.ObjectStore
+	prefix      string
+}
+
+// NewObjectStoreExportDestination creates an ExportDestination writing objects under the key prefix.
+func NewObjectStoreExportDestination(objectStore diskstore.ObjectStore, prefix string) ExportDestination {
+	return objectStoreExportDestination{objectStore: objectStore, prefix: prefix}
+}
+
+func (d objectStoreExportDestination) key(path string) string {
+	return strings.TrimSuffix(d.prefix, "/") + "/" + path
+}
+
+func (d objectStoreExportDestination) Exists(path string) (bool, error) {
+	key := d.key(path)
+	keys, err := d.objectStore.List(key)
+	if err != nil {
+		return false, utils.StackError(err, "Failed to list %s", key)
+	}
+	for _, k := range keys {
+		if k == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d objectStoreExportDestination) Write(path string, data []byte) error {
+	key := d.key(path)
+	if err := d.objectStore.Put(key, bytes.NewReader(data)); err != nil {
+		return utils.StackError(err, "Failed to put %s", key)
+	}
+	return nil
+}
+
+func (d objectStoreExportDestination) String() string {
+	return d.prefix
+}
+
+// parquetExportColumn is a column to export.
+type parquetExportColumn struct {
+	id       int
+	dataType common.DataType
+	// time column is exported as timestamp.
+	isTime bool
+	// enum cases for enum columns.
+	enumCases []string
+	column    parquet.Column
+}
+
+// parquetColumnForType maps the data type to parquet column type. Unsigned types are widened
+// to signed physical types since most readers do not support unsigned integers. Array and
+// geo shape columns cannot be exported.
+func parquetColumnForType(name string, dataType common.DataType, isTime bool) (parquet.Column, bool) {
+	column := parquet.Column{Name: name}
+	switch dataType {
+	case common.Bool:
+		column.Type = parquet.Boolean
+	case common.Int8:
+		column.Type, column.Logical = parquet.Int32, parquet.Int8
+	case common.Uint8:
+		column.Type, column.Logical = parquet.Int32, parquet.Uint8
+	case common.Int16:
+		column.Type, column.Logical = parquet.Int32, parquet.Int16
+	case common.Uint16:
+		column.Type, column.Logical = parquet.Int32, parquet.Uint16
+	case common.Int32:
+		column.Type = parquet.Int32
+	case common.Uint32:
+		column.Type = parquet.Int64
+		if isTime {
+			column.Logical = parquet.TimestampMillis
+		}
+	case common.Int64:
+		column.Type = parquet.Int64
+	case common.Float32:
+		column.Type = parquet.Float
+	case common.SmallEnum, common.BigEnum, common.UUID, common.GeoPoint:
+		column.Type, column.Logical = parquet.ByteArray, parquet.String
+	default:
+		return column, false
+	}
+	return column, true
+}
+
+// getParquetExportColumns resolves columns to export by names, all columns are exported if
+// names is empty.
+func (shard *TableShard) getParquetExportColumns(names []string) ([]parquetExportColumn, error) {
+	shard.Schema.RLock()
+	defer shard.Schema.RUnlock()
+
+	if !shard.Schema.Schema.IsFactTable {
+		return nil, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Table %s is not a fact table, only archive batches of fact tables can be exported", shard.Schema.Schema.Name),
+		}
+	}
+
+	if len(names) == 0 {
+		for _, column := range shard.Schema.Schema.Columns {
+			if !column.Deleted {
+				names = append(names, column.Name)
+			}
+		}
+	}
+
+	columns := make([]parquetExportColumn, 0, len(names))
+	for _, name := range names {
+		id, _, ok := shard.Schema.GetColumnID(name)
+		if !ok {
+			return nil, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unknown column %s", name),
+			}
+		}
+		column := parquetExportColumn{
+			id:       id,
+			dataType: shard.Schema.ValueTypeByColumn[id],
+			isTime:   id == 0,
+		}
+		column.column, ok = parquetColumnForType(name, column.dataType, column.isTime)
+		if !ok {
+			return nil, utils.APIError{
+				Code: http.StatusBadRequest,
+				Message: fmt.Sprintf("Column %s of type %s cannot be exported to parquet, exclude it from columns to export",
+					name, common.DataTypeName[column.dataType]),
+			}
+		}
+		if common.IsEnumType(column.dataType) {
+			column.enumCases = append([]string(nil), shard.Schema.EnumDicts[name].ReverseDict...)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// appendParquetValue converts the value of the column and appends it to the chunk. Enum values
+// are resolved to enum cases, unknown enum values are exported as nulls.
+func appendParquetValue(chunk *parquet.ColumnChunk, column parquetExportColumn, value common.DataValue) {
+	switch v := value.ConvertToHumanReadable(column.dataType).(type) {
+	case bool:
+		chunk.AppendBool(v)
+	case int8:
+		chunk.AppendInt32(int32(v))
+	case int16:
+		chunk.AppendInt32(int32(v))
+	case int32:
+		chunk.AppendInt32(v)
+	case uint8:
+		appendParquetEnumOrInt(chunk, column, int(v))
+	case uint16:
+		appendParquetEnumOrInt(chunk, column, int(v))
+	case uint32:
+		if column.isTime {
+			chunk.AppendInt64(int64(v) * 1000)
+		} else {
+			chunk.AppendInt64(int64(v))
+		}
+	case int64:
+		chunk.AppendInt64(v)
+	case float32:
+		chunk.AppendFloat(v)
+	case string:
+		chunk.AppendString(v)
+	default:
+		chunk.AppendNull()
+	}
+}
+
+func appendParquetEnumOrInt(chunk *parquet.ColumnChunk, column parquetExportColumn, v int) {
+	if !common.IsEnumType(column.dataType) {
+		chunk.AppendInt32(int32(v))
+	} else if v < len(column.enumCases) {
+		chunk.AppendString(column.enumCases[v])
+	} else {
+		chunk.AppendNull()
+	}
+}
+
+// getParquetExportPath returns the path of the file the batch is exported into, which is
+// partitioned by day in hive style.
+func getParquetExportPath(table string, shardID, batchID int) string {
+	day := time.Unix(int64(batchID)*86400, 0).UTC().Format("2006-01-02")
+	return fmt.Sprintf("%s/date=%s/part-%05d.parquet", table, day, shardID)
+}
+
+// ExportToParquet exports archive batches of the shard to parquet files in destination, one
+// file per day. Days already exported are skipped so that interrupted exports can be resumed
+// by exporting again. Each day is converted in memory before written.
+func (shard *TableShard) ExportToParquet(options ParquetExportOptions, destination ExportDestination,
+	jobKey string, reporter ParquetExportJobDetailReporter) error {
+	columns, err := shard.getParquetExportColumns(options.Columns)
+	if err != nil {
+		return err
+	}
+	parquetColumns := make([]parquet.Column, len(columns))
+	for i, column := range columns {
+		parquetColumns[i] = column.column
+	}
+
+	table := shard.Schema.Schema.Name
+	batchIDs, err := shard.metaStore.GetArchiveBatches(table, shard.ShardID, int32(options.BatchIDStart),
+		int32(options.BatchIDEnd-1))
+	if err != nil {
+		return err
+	}
+	sort.Ints(batchIDs)
+
+	reporter(jobKey, func(status *ParquetExportJobDetail) {
+		status.Stage = ParquetExportExport
+		status.Total = len(batchIDs)
+		status.Current = 0
+	})
+
+	for i, batchID := range batchIDs {
+		reporter(jobKey, func(status *ParquetExportJobDetail) {
+			status.Current = i
+			status.BatchID = batchID
+		})
+
+		path := getParquetExportPath(table, shard.ShardID, batchID)
+		exists, err := destination.Exists(path)
+		if err != nil {
+			return err
+		}
+		if exists {
+			reporter(jobKey, func(status *ParquetExportJobDetail) {
+				status.NumSkippedDays++
+			})
+			continue
+		}
+
+		data, numRows, err := shard.exportBatchToParquet(batchID, columns, parquetColumns)
+		if err != nil {
+			return err
+		}
+		if numRows == 0 {
+			continue
+		}
+		if err = destination.Write(path, data); err != nil {
+			return err
+		}
+		reporter(jobKey, func(status *ParquetExportJobDetail) {
+			status.NumExportedDays++
+			status.NumRecords += numRows
+			status.NumBytes += int64(len(data))
+		})
+
+		if options.BytesPerSec > 0 {
+			time.Sleep(time.Duration(int64(len(data)) * int64(time.Second) / options.BytesPerSec))
+		}
+	}
+
+	reporter(jobKey, func(status *ParquetExportJobDetail) {
+		status.Current = len(batchIDs)
+		status.Stage = ParquetExportComplete
+	})
+	return nil
+}
+
+// exportBatchToParquet converts the archive batch into a parquet file with a single row group.
+func (shard *TableShard) exportBatchToParquet(batchID int, columns []parquetExportColumn,
+	parquetColumns []parquet.Column) (data []byte, numRows int, err error) {
+	version := shard.ArchiveStore.GetCurrentVersion()
+	defer version.Users.Done()
+
+	batch := version.RequestBatch(int32(batchID))
+	if batch.Size == 0 {
+		return
+	}
+
+	var requestedVPs []common.ArchiveVectorParty
+	for _, column := range columns {
+		requestedVP := batch.RequestVectorPartyForIO(column.id, diskstore.IOExportRead)
+		requestedVP.WaitForDiskLoad()
+		requestedVPs = append(requestedVPs, requestedVP)
+		if err = requestedVP.LoadError(); err != nil {
+			UnpinVectorParties(requestedVPs)
+			return
+		}
+	}
+
+	chunks := make([]*parquet.ColumnChunk, len(columns))
+	for i, column := range columns {
+		chunks[i] = parquet.NewColumnChunk(column.column)
+		for row := 0; row < batch.Size; row++ {
+			appendParquetValue(chunks[i], column, requestedVPs[i].GetDataValueByRow(row))
+		}
+	}
+	UnpinVectorParties(requestedVPs)
+
+	var buf bytes.Buffer
+	writer := parquet.NewWriter(&buf, parquetColumns)
+	if err = writer.WriteRowGroup(chunks); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	return buf.Bytes(), batch.Size, nil
+}
+
+// ParquetExportManager runs parquet exports of table shards in background and tracks their
+// progress. At most one export runs for each table shard.
+type ParquetExportManager struct {
+	sync.RWMutex
+	memStore MemStore
+	// Key is {tableName}|{shardID}|parquet_export.
+	jobDetails map[string]*ParquetExportJobDetail
+}
+
+// NewParquetExportManager creates a new ParquetExportManager.
+func NewParquetExportManager(memStore MemStore) *ParquetExportManager {
+	return &ParquetExportManager{
+		memStore:   memStore,
+		jobDetails: make(map[string]*ParquetExportJobDetail),
+	}
+}
+
+func (m *ParquetExportManager) reportJobDetail(key string, mutator ParquetExportJobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobDetail, found := m.jobDetails[key]
+	if !found {
+		jobDetail = &ParquetExportJobDetail{}
+		m.jobDetails[key] = jobDetail
+	}
+	mutator(jobDetail)
+}
+
+// GetJobDetails returns a copy of details of all exports.
+func (m *ParquetExportManager) GetJobDetails() map[string]ParquetExportJobDetail {
+	m.RLock()
+	defer m.RUnlock()
+	jobDetails := make(map[string]ParquetExportJobDetail, len(m.jobDetails))
+	for key, jobDetail := range m.jobDetails {
+		jobDetails[key] = *jobDetail
+	}
+	return jobDetails
+}
+
+// StartExport validates the export and starts it in background. Progress and result are
+// reported in job details.
+func (m *ParquetExportManager) StartExport(table string, shardID int, options ParquetExportOptions,
+	destination ExportDestination) error {
+	shard, err := m.memStore.GetTableShard(table, shardID)
+	if err != nil {
+		return err
+	}
+	if _, err = shard.getParquetExportColumns(options.Columns); err != nil {
+		shard.Users.Done()
+		return err
+	}
+
+	jobKey := getIdentifier(table, shardID, common.ParquetExportJobType)
+	m.Lock()
+	jobDetail, found := m.jobDetails[jobKey]
+	if found && jobDetail.Status == JobRunning {
+		m.Unlock()
+		shard.Users.Done()
+		return utils.APIError{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("Parquet export of table %s shard %d is running", table, shardID),
+		}
+	}
+	m.jobDetails[jobKey] = &ParquetExportJobDetail{
+		JobDetail: JobDetail{
+			Status:        JobRunning,
+			LastStartTime: utils.Now().UTC(),
+		},
+		Destination:  destination.String(),
+		BatchIDStart: options.BatchIDStart,
+		BatchIDEnd:   options.BatchIDEnd,
+	}
+	m.Unlock()
+
+	go func() {
+		defer shard.Users.Done()
+		start := utils.Now()
+		err := shard.ExportToParquet(options, destination, jobKey, m.reportJobDetail)
+		m.reportJobDetail(jobKey, func(jobDetail *ParquetExportJobDetail) {
+			jobDetail.LastDuration = utils.Now().Sub(start)
+			jobDetail.LastRun = utils.Now().UTC()
+			jobDetail.LastError = err
+			if err != nil {
+				jobDetail.Status = JobFailed
+			} else {
+				jobDetail.Status = JobSucceeded
+			}
+		})
+		logger := utils.GetLogger().With("table", table, "shard", shardID, "destination", destination.String())
+		if err != nil {
+			logger.With("error", err.Error()).Error("Parquet export failed")
+		} else {
+			logger.Info("Parquet export succeeded")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,137 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/diskstore"
+	diskStoreMocks "github.com/uber/aresdb/diskstore/mocks"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/utils/parquet"
+)
+
+var _ = ginkgo.Describe("parquet export", func() {
+	prefix := "/tmp/testParquetExport"
+	testTable := "test"
+	testShardID := 0
+
+	var metaStore *metaStoreMocks.MetaStore
+	var tableShard *TableShard
+	var jobDetail *ParquetExportJobDetail
+
+	reporter := func(key string, mutator ParquetExportJobDetailMutator) {
+		mutator(jobDetail)
+	}
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		jobDetail = &ParquetExportJobDetail{}
+		tableSchema := memCom.NewTableSchema(&metaCom.Table{
+			Name:        testTable,
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "c0", Type: metaCom.Uint32},
+				{Name: "c1", Type: metaCom.Bool},
+				{Name: "c2", Type: metaCom.Float32},
+				{Name: "c3", Type: metaCom.ArrayInt16},
+			},
+		})
+		for id := range tableSchema.Schema.Columns {
+			tableSchema.SetDefaultValue(id)
+		}
+
+		metaStore = &metaStoreMocks.MetaStore{}
+		diskStore := &diskStoreMocks.DiskStore{}
+		hostMemoryManager := NewHostMemoryManager(&memStoreImpl{}, 1<<10)
+		tableShard = NewTableShard(tableSchema, metaStore, diskStore, hostMemoryManager, testShardID,
+			NewOptions(new(memComMocks.BootStrapToken), nil))
+
+		archiveBatch, err := GetFactory().ReadArchiveBatch("archiving/archiveBatch0")
+		Ω(err).Should(BeNil())
+		tableShard.ArchiveStore.CurrentVersion = NewArchiveStoreVersion(86400*2, tableShard)
+		tableShard.ArchiveStore.CurrentVersion.Batches = map[int32]*ArchiveBatch{
+			1: {
+				Batch:   *archiveBatch,
+				Size:    5,
+				BatchID: 1,
+				Shard:   tableShard,
+			},
+		}
+		metaStore.On("GetArchiveBatches", testTable, testShardID, int32(0), int32(1)).Return([]int{1}, nil)
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	ginkgo.It("maps data types to parquet columns", func() {
+		column, ok := parquetColumnForType("t", memCom.Uint32, true)
+		Ω(ok).Should(BeTrue())
+		Ω(column).Should(Equal(parquet.Column{Name: "t", Type: parquet.Int64, Logical: parquet.TimestampMillis}))
+		column, ok = parquetColumnForType("e", memCom.SmallEnum, false)
+		Ω(ok).Should(BeTrue())
+		Ω(column).Should(Equal(parquet.Column{Name: "e", Type: parquet.ByteArray, Logical: parquet.String}))
+		_, ok = parquetColumnForType("a", memCom.ArrayInt16, false)
+		Ω(ok).Should(BeFalse())
+		_, ok = parquetColumnForType("g", memCom.GeoShape, false)
+		Ω(ok).Should(BeFalse())
+	})
+
+	ginkgo.It("exports batches by day and resumes", func() {
+		options := ParquetExportOptions{BatchIDStart: 0, BatchIDEnd: 2, Columns: []string{"c0", "c1", "c2"}}
+		destination := NewLocalExportDestination(prefix)
+		Ω(tableShard.ExportToParquet(options, destination, "key", reporter)).Should(BeNil())
+		Ω(jobDetail.NumExportedDays).Should(Equal(1))
+		Ω(jobDetail.NumRecords).Should(Equal(5))
+		Ω(jobDetail.Stage).Should(Equal(ParquetExportComplete))
+
+		data, err := ioutil.ReadFile(prefix + "/test/date=1970-01-02/part-00000.parquet")
+		Ω(err).Should(BeNil())
+		Ω(string(data[:4])).Should(Equal("PAR1"))
+		Ω(jobDetail.NumBytes).Should(BeEquivalentTo(len(data)))
+
+		Ω(tableShard.ExportToParquet(options, destination, "key", reporter)).Should(BeNil())
+		Ω(jobDetail.NumExportedDays).Should(Equal(1))
+		Ω(jobDetail.NumSkippedDays).Should(Equal(1))
+	})
+
+	ginkgo.It("exports to object store", func() {
+		objectStore := diskstore.NewMemoryObjectStore()
+		options := ParquetExportOptions{BatchIDStart: 0, BatchIDEnd: 2, Columns: []string{"c0", "c2"}}
+		destination := NewObjectStoreExportDestination(objectStore, "exports/")
+		Ω(tableShard.ExportToParquet(options, destination, "key", reporter)).Should(BeNil())
+		Ω(objectStore.List("exports/")).Should(Equal([]string{"exports/test/date=1970-01-02/part-00000.parquet"}))
+		Ω(destination.Exists("test/date=1970-01-02/part-00000.parquet")).Should(BeTrue())
+	})
+
+	ginkgo.It("rejects columns cannot be exported", func() {
+		_, err := tableShard.getParquetExportColumns(nil)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("c3"))
+		_, err = tableShard.getParquetExportColumns([]string{"unknown"})
+		Ω(err).ShouldNot(BeNil())
+		columns, err := tableShard.getParquetExportColumns([]string{"c0", "c1"})
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(HaveLen(2))
+		Ω(columns[0].isTime).Should(BeTrue())
+	})
+})
@@ -0,0 +1,849 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/uber/aresdb/diskstore"
+	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+	"github.com/uber/aresdb/utils/parquet"
+)
+
+const (
+	// defaultParquetImportBatchSize is the default max number of rows in an upsert batch.
+	defaultParquetImportBatchSize = 10000
+	// maxRejectedRowSamples is the max number of rejected rows reported per file.
+	maxRejectedRowSamples = 10
+)
+
+// ParquetImportOptions specifies what and how to import.
+type ParquetImportOptions struct {
+	// Files to import, relative to the source.
+	Files []string
+	// Maps parquet column names to table column names. Parquet columns having the same names
+	// as table columns are imported if empty.
+	ColumnMapping map[string]string
+	// Number of shards of the table. Rows are routed to shards by primary key the same way as
+	// ingestion clients, rows of shards not owned by this instance are rejected.
+	NumShards int
+	// Whether to write rows into archive batches directly instead of ingesting them. Rows not
+	// older than the archiving cutoff of their shards are rejected.
+	Archive bool
+	// Max number of rows in an upsert batch.
+	BatchSize int
+}
+
+// ImportFile is a parquet file opened for import.
+type ImportFile interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// ImportSource is where imported files are read from.
+type ImportSource interface {
+	// Open opens the file at the relative path.
+	Open(path string) (ImportFile, error)
+	String() string
+}
+
+// localImportFile is a local file opened for import.
+type localImportFile struct {
+	*os.File
+	size int64
+}
+
+func (f localImportFile) Size() int64 {
+	return f.size
+}
+
+// localImportSource reads imported files from a local directory.
+type localImportSource struct {
+	dir string
+}
+
+// NewLocalImportSource creates an ImportSource reading files from the local directory.
+func NewLocalImportSource(dir string) ImportSource {
+	return localImportSource{dir: dir}
+}
+
+func (s localImportSource) Open(path string) (ImportFile, error) {
+	path = filepath.Join(s.dir, path)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to open %s", path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, utils.StackError(err, "Failed to stat %s", path)
+	}
+	return localImportFile{File: file, size: info.Size()}, nil
+}
+
+func (s localImportSource) String() string {
+	return s.dir
+}
+
+// memoryImportFile is a file read into memory.
+type memoryImportFile struct {
+	*bytes.Reader
+}
+
+func (f memoryImportFile) Close() error {
+	return nil
+}
+
+// objectStoreImportSource reads imported files from objects under a key prefix.
+type objectStoreImportSource struct {
+	objectStore diskstore.ObjectStore
+	prefix      string
+}
+
+// NewObjectStoreImportSource creates an ImportSource reading objects under the key prefix.
+// Objects cannot be read at random offsets so they are read into memory when opened.
+func NewObjectStoreImportSource(objectStore diskstore.ObjectStore, prefix string) ImportSource {
+	return objectStoreImportSource{objectStore: objectStore, prefix: prefix}
+}
+
+func (s objectStoreImportSource) Open(path string) (ImportFile, error) {
+	key := strings.TrimSuffix(s.prefix, "/") + "/" + path
+	reader, err := s.objectStore.Get(key)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to get %s", key)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to read %s", key)
+	}
+	return memoryImportFile{Reader: bytes.NewReader(data)}, nil
+}
+
+func (s objectStoreImportSource) String() string {
+	return s.prefix
+}
+
+// parquetImportColumn is a parquet column imported into a table column.
+type parquetImportColumn struct {
+	// index of the column in the parquet file.
+	index    int
+	id       int
+	name     string
+	dataType common.DataType
+	// time column is imported as seconds since epoch.
+	isTime            bool
+	caseInsensitive   bool
+	disableAutoExpand bool
+}
+
+// getParquetImportColumns resolves parquet columns to import into table columns, ordered by
+// table column ids. The time column of fact tables and primary key columns must be imported.
+func getParquetImportColumns(schema *common.TableSchema, parquetColumns []parquet.Column,
+	columnMapping map[string]string) ([]parquetImportColumn, error) {
+	schema.RLock()
+	defer schema.RUnlock()
+
+	parquetColumnIndexes := make(map[string]int, len(parquetColumns))
+	for i, column := range parquetColumns {
+		parquetColumnIndexes[column.Name] = i
+	}
+
+	if len(columnMapping) == 0 {
+		columnMapping = make(map[string]string)
+		for _, column := range parquetColumns {
+			if _, ok := schema.ColumnIDs[column.Name]; ok {
+				columnMapping[column.Name] = column.Name
+			}
+		}
+	}
+
+	var columns []parquetImportColumn
+	imported := make(map[int]bool)
+	for parquetName, name := range columnMapping {
+		index, ok := parquetColumnIndexes[parquetName]
+		if !ok {
+			return nil, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unknown parquet column %s", parquetName),
+			}
+		}
+		if parquetColumns[index].Logical == parquet.Decimal {
+			return nil, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Decimal parquet column %s is not supported", parquetName),
+			}
+		}
+		id, _, ok := schema.GetColumnID(name)
+		if !ok {
+			return nil, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unknown column %s", name),
+			}
+		}
+		if imported[id] {
+			return nil, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Column %s is mapped from multiple parquet columns", name),
+			}
+		}
+		imported[id] = true
+		column := schema.Schema.Columns[id]
+		columns = append(columns, parquetImportColumn{
+			index:             index,
+			id:                id,
+			name:              name,
+			dataType:          schema.ValueTypeByColumn[id],
+			isTime:            schema.Schema.IsFactTable && id == 0,
+			caseInsensitive:   column.CaseInsensitive,
+			disableAutoExpand: column.DisableAutoExpand,
+		})
+	}
+
+	if schema.Schema.IsFactTable && !imported[0] {
+		return nil, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Time column %s is not imported", schema.Schema.Columns[0].Name),
+		}
+	}
+	for _, id := range schema.Schema.PrimaryKeyColumns {
+		if !imported[id] {
+			return nil, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Primary key column %s is not imported", schema.Schema.Columns[id].Name),
+			}
+		}
+	}
+
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].id < columns[j].id
+	})
+	return columns, nil
+}
+
+// parquetImportShard is a shard rows are routed to.
+type parquetImportShard struct {
+	// whether the shard is owned by this instance.
+	owned bool
+	// archiving cutoff when the import starts.
+	archivingCutoff uint32
+	builder         *common.UpsertBatchBuilder
+}
+
+// parquetImporter imports parquet files into a table.
+type parquetImporter struct {
+	memStore  MemStore
+	metaStore metaCom.MetaStore
+	table     string
+	schema    *common.TableSchema
+	options   ParquetImportOptions
+	jobKey    string
+	reporter  ParquetImportJobDetailReporter
+	// enum cases to enum ids by column names, extended when new cases are imported.
+	enumDicts map[string]map[string]int
+	// checked shards by shard ids.
+	shards map[int]*parquetImportShard
+}
+
+func newParquetImporter(memStore MemStore, metaStore metaCom.MetaStore, schema *common.TableSchema,
+	options ParquetImportOptions, jobKey string, reporter ParquetImportJobDetailReporter) *parquetImporter {
+	importer := &parquetImporter{
+		memStore:  memStore,
+		metaStore: metaStore,
+		table:     schema.Schema.Name,
+		schema:    schema,
+		options:   options,
+		jobKey:    jobKey,
+		reporter:  reporter,
+		enumDicts: make(map[string]map[string]int),
+		shards:    make(map[int]*parquetImportShard),
+	}
+	schema.RLock()
+	for name, enumDict := range schema.EnumDicts {
+		dict := make(map[string]int, len(enumDict.Dict))
+		for enumCase, enumID := range enumDict.Dict {
+			dict[enumCase] = enumID
+		}
+		importer.enumDicts[name] = dict
+	}
+	schema.RUnlock()
+	return importer
+}
+
+// getShard returns the shard with the id, checking its ownership the first time.
+func (importer *parquetImporter) getShard(shardID int) *parquetImportShard {
+	if shard, ok := importer.shards[shardID]; ok {
+		return shard
+	}
+	shard := &parquetImportShard{}
+	if tableShard, err := importer.memStore.GetTableShard(importer.table, shardID); err == nil {
+		shard.owned = true
+		version := tableShard.ArchiveStore.GetCurrentVersion()
+		shard.archivingCutoff = version.ArchivingCutoff
+		version.Users.Done()
+		tableShard.Users.Done()
+	}
+	importer.shards[shardID] = shard
+	return shard
+}
+
+func (importer *parquetImporter) reportFile(file int, mutator func(fileDetail *ParquetImportFileDetail)) {
+	importer.reporter(importer.jobKey, func(jobDetail *ParquetImportJobDetail) {
+		mutator(&jobDetail.Files[file])
+	})
+}
+
+// importFiles imports files one by one. A file failing to import does not stop the import of
+// following files.
+func (importer *parquetImporter) importFiles(source ImportSource) error {
+	var numFailed int
+	for i, path := range importer.options.Files {
+		importer.reporter(importer.jobKey, func(jobDetail *ParquetImportJobDetail) {
+			jobDetail.Current = i
+		})
+		importer.reportFile(i, func(fileDetail *ParquetImportFileDetail) {
+			fileDetail.Status = ParquetImportRunning
+		})
+		err := importer.importFile(source, i, path)
+		importer.reportFile(i, func(fileDetail *ParquetImportFileDetail) {
+			if err != nil {
+				fileDetail.Status = ParquetImportFailed
+				fileDetail.Error = err.Error()
+			} else {
+				fileDetail.Status = ParquetImportSucceeded
+			}
+		})
+		if err != nil {
+			numFailed++
+			utils.GetLogger().With("table", importer.table, "file", path, "error", err.Error()).
+				Error("Failed to import parquet file")
+		}
+	}
+	importer.reporter(importer.jobKey, func(jobDetail *ParquetImportJobDetail) {
+		jobDetail.Current = len(importer.options.Files)
+	})
+	if numFailed > 0 {
+		return utils.StackError(nil, "Failed to import %d of %d files", numFailed, len(importer.options.Files))
+	}
+	return nil
+}
+
+// importFile imports a file row group by row group.
+func (importer *parquetImporter) importFile(source ImportSource, file int, path string) error {
+	f, err := source.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := parquet.NewReader(f, f.Size())
+	if err != nil {
+		return err
+	}
+	columns, err := getParquetImportColumns(importer.schema, reader.Columns(), importer.options.ColumnMapping)
+	if err != nil {
+		return err
+	}
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		indexes[i] = column.index
+	}
+
+	importer.reportFile(file, func(fileDetail *ParquetImportFileDetail) {
+		fileDetail.NumRowGroups = reader.NumRowGroups()
+		fileDetail.NumRows = reader.NumRows()
+	})
+
+	var rowOffset int
+	for rowGroup := 0; rowGroup < reader.NumRowGroups(); rowGroup++ {
+		values, err := reader.ReadRowGroup(rowGroup, indexes)
+		if err != nil {
+			return err
+		}
+		numRows := len(values[0])
+		importer.extendEnumDicts(columns, values)
+
+		numImported, err := importer.importRows(file, rowOffset, columns, values)
+		importer.reportFile(file, func(fileDetail *ParquetImportFileDetail) {
+			if err == nil {
+				fileDetail.NumRowGroupsDone = rowGroup + 1
+			}
+			fileDetail.NumImportedRows += int64(numImported)
+		})
+		if err != nil {
+			return err
+		}
+		rowOffset += numRows
+	}
+	return nil
+}
+
+// getEnumCase returns the enum case of the value of the enum column.
+func getEnumCase(column parquetImportColumn, value interface{}) string {
+	enumCase, ok := value.(string)
+	if !ok {
+		enumCase = fmt.Sprint(value)
+	}
+	if column.caseInsensitive {
+		enumCase = strings.ToLower(enumCase)
+	}
+	return enumCase
+}
+
+// extendEnumDicts adds enum cases not seen before to enum dicts. Rows with enum cases failed
+// to add are rejected later.
+func (importer *parquetImporter) extendEnumDicts(columns []parquetImportColumn, values [][]interface{}) {
+	for i, column := range columns {
+		if !common.IsEnumType(column.dataType) || column.disableAutoExpand {
+			continue
+		}
+		dict := importer.enumDicts[column.name]
+		if dict == nil {
+			dict = make(map[string]int)
+			importer.enumDicts[column.name] = dict
+		}
+
+		var newCases []string
+		seen := make(map[string]bool)
+		for _, value := range values[i] {
+			if value == nil {
+				continue
+			}
+			enumCase := getEnumCase(column, value)
+			if _, ok := dict[enumCase]; !ok && !seen[enumCase] {
+				seen[enumCase] = true
+				newCases = append(newCases, enumCase)
+			}
+		}
+		if len(newCases) == 0 {
+			continue
+		}
+
+		enumIDs, err := importer.metaStore.ExtendEnumDict(importer.table, column.name, newCases)
+		if err != nil {
+			utils.GetLogger().With("table", importer.table, "column", column.name, "error", err.Error()).
+				Error("Failed to extend enum dict")
+			continue
+		}
+		for j, enumCase := range newCases {
+			dict[enumCase] = enumIDs[j]
+		}
+	}
+}
+
+// convertRow converts values of a row into values of table columns and returns the shard the
+// row belongs to.
+func (importer *parquetImporter) convertRow(columns []parquetImportColumn, values [][]interface{},
+	row int) ([]interface{}, int, error) {
+	converted := make([]interface{}, len(columns))
+	for i, column := range columns {
+		value := values[i][row]
+		if value == nil {
+			if column.isTime {
+				return nil, 0, utils.StackError(nil, "Time column %s is null", column.name)
+			}
+			converted[i] = nil
+			continue
+		}
+
+		if common.IsEnumType(column.dataType) {
+			enumCase := getEnumCase(column, value)
+			enumID, ok := importer.enumDicts[column.name][enumCase]
+			if !ok {
+				return nil, 0, utils.StackError(nil, "Unknown enum case %q of column %s", enumCase, column.name)
+			}
+			value = enumID
+		} else if t, ok := value.(time.Time); ok {
+			value = t.Unix()
+		} else if s, ok := value.(string); ok && column.dataType == common.UUID && len(s) == 16 {
+			// Fixed length byte array of UUIDs.
+			value = []byte(s)
+		}
+
+		var err error
+		if converted[i], err = common.ConvertValueForType(column.dataType, value); err != nil {
+			return nil, 0, utils.StackError(err, "Invalid value of column %s", column.name)
+		}
+	}
+
+	shardID, err := importer.getShardID(columns, values, converted, row)
+	if err != nil {
+		return nil, 0, err
+	}
+	return converted, shardID, nil
+}
+
+// getShardID returns the shard of the row by its primary key. Primary key bytes are built the
+// same way as ingestion clients, where enum values are appended as case strings.
+func (importer *parquetImporter) getShardID(columns []parquetImportColumn, values [][]interface{},
+	converted []interface{}, row int) (int, error) {
+	if importer.options.NumShards <= 1 {
+		return 0, nil
+	}
+
+	columnIndexes := make(map[int]int, len(columns))
+	for i, column := range columns {
+		columnIndexes[column.id] = i
+	}
+
+	var primaryKeyValues []common.DataValue
+	var enumBytes []byte
+	for _, id := range importer.schema.Schema.PrimaryKeyColumns {
+		i := columnIndexes[id]
+		column := columns[i]
+		if converted[i] == nil {
+			return 0, utils.StackError(nil, "Primary key column %s is null", column.name)
+		}
+		if common.IsEnumType(column.dataType) {
+			enumCase, ok := values[i][row].(string)
+			if !ok {
+				enumCase = fmt.Sprint(values[i][row])
+			}
+			if !column.caseInsensitive {
+				enumCase = strings.ToLower(enumCase)
+			}
+			enumBytes = append(enumBytes, enumCase...)
+			continue
+		}
+		primaryKeyValues = append(primaryKeyValues, dataValueOf(column.dataType, converted[i]))
+	}
+
+	key, err := common.GetPrimaryKeyBytes(primaryKeyValues, importer.schema.PrimaryKeyBytes)
+	if err != nil {
+		return 0, err
+	}
+	key = append(key, enumBytes...)
+	return int(common.GetShardByPrimaryKey(key, uint32(importer.options.NumShards))), nil
+}
+
+// dataValueOf wraps a value converted by common.ConvertValueForType as a DataValue.
+func dataValueOf(dataType common.DataType, value interface{}) common.DataValue {
+	if b, ok := value.(bool); ok {
+		return common.DataValue{Valid: true, IsBool: true, BoolVal: b, DataType: dataType}
+	}
+	ptr := reflect.New(reflect.TypeOf(value))
+	ptr.Elem().Set(reflect.ValueOf(value))
+	return common.DataValue{Valid: true, DataType: dataType, OtherVal: unsafe.Pointer(ptr.Pointer())}
+}
+
+// importRows converts rows of a row group into upsert batches of their shards and applies them.
+// Rows failed to convert are rejected. Returns the number of rows imported.
+func (importer *parquetImporter) importRows(file, rowOffset int, columns []parquetImportColumn,
+	values [][]interface{}) (int, error) {
+	var numImported int
+	reject := func(row int, err error) {
+		importer.reportFile(file, func(fileDetail *ParquetImportFileDetail) {
+			fileDetail.NumRejectedRows++
+			if len(fileDetail.RejectedRowSamples) < maxRejectedRowSamples {
+				fileDetail.RejectedRowSamples = append(fileDetail.RejectedRowSamples,
+					fmt.Sprintf("row %d: %s", rowOffset+row, err.Error()))
+			}
+		})
+	}
+
+	var shardIDs []int
+	flush := func(shardID int, shard *parquetImportShard) error {
+		if shard.builder == nil || shard.builder.NumRows == 0 {
+			return nil
+		}
+		numRows := shard.builder.NumRows
+		if err := importer.applyUpsertBatch(shardID, shard.builder); err != nil {
+			return err
+		}
+		shard.builder.ResetRows()
+		numImported += numRows
+		return nil
+	}
+
+	for row := 0; row < len(values[0]); row++ {
+		converted, shardID, err := importer.convertRow(columns, values, row)
+		if err != nil {
+			reject(row, err)
+			continue
+		}
+
+		shard := importer.getShard(shardID)
+		if !shard.owned {
+			reject(row, utils.StackError(nil, "Shard %d is not owned by this instance", shardID))
+			continue
+		}
+		if importer.options.Archive {
+			if eventTime := converted[0].(uint32); eventTime >= shard.archivingCutoff {
+				reject(row, utils.StackError(nil, "Event time %d is not before archiving cutoff %d",
+					eventTime, shard.archivingCutoff))
+				continue
+			}
+		}
+
+		if shard.builder == nil {
+			shard.builder = common.NewUpsertBatchBuilder()
+			for _, column := range columns {
+				if err = shard.builder.AddColumn(column.id, column.dataType); err != nil {
+					return numImported, err
+				}
+			}
+			shardIDs = append(shardIDs, shardID)
+		}
+		builder := shard.builder
+		builder.AddRow()
+		for i, value := range converted {
+			if err = builder.SetValue(builder.NumRows-1, i, value); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			builder.RemoveRow()
+			reject(row, err)
+			continue
+		}
+
+		if builder.NumRows >= importer.options.BatchSize {
+			if err = flush(shardID, shard); err != nil {
+				return numImported, err
+			}
+		}
+	}
+
+	for _, shardID := range shardIDs {
+		shard := importer.shards[shardID]
+		if err := flush(shardID, shard); err != nil {
+			return numImported, err
+		}
+		shard.builder = nil
+	}
+	return numImported, nil
+}
+
+// applyUpsertBatch ingests the rows in builder into the shard, or writes them into archive
+// batches directly.
+func (importer *parquetImporter) applyUpsertBatch(shardID int, builder *common.UpsertBatchBuilder) error {
+	buffer, err := builder.ToByteArray()
+	if err != nil {
+		return err
+	}
+	upsertBatch, err := common.NewUpsertBatch(buffer)
+	if err != nil {
+		return err
+	}
+
+	if !importer.options.Archive {
+		return importer.memStore.HandleIngestion(importer.table, shardID, upsertBatch)
+	}
+
+	// Run as a job of the scheduler so that archive batches are not changed by archiving and
+	// backfill jobs at the same time.
+	scheduler := importer.memStore.GetScheduler()
+	err, errChan := scheduler.SubmitJob(&parquetImportArchiveJob{
+		memStore:     importer.memStore,
+		tableName:    importer.table,
+		shardID:      shardID,
+		upsertBatch:  upsertBatch,
+		importJobKey: importer.jobKey,
+	})
+	if err != nil {
+		return err
+	}
+	return <-errChan
+}
+
+// parquetImportArchiveJob merges imported rows into archive batches of a shard the same way as
+// backfill, bypassing the live store and redo logs.
+type parquetImportArchiveJob struct {
+	memStore     MemStore
+	tableName    string
+	shardID      int
+	upsertBatch  *common.UpsertBatch
+	importJobKey string
+}
+
+// Run implements Job interface.
+func (job *parquetImportArchiveJob) Run() error {
+	shard, err := job.memStore.GetTableShard(job.tableName, job.shardID)
+	if err != nil {
+		return err
+	}
+	defer shard.Users.Done()
+
+	// Progress is reported by the import job instead.
+	reporter := func(key string, mutator BackfillJobDetailMutator) {}
+	patches, err := createBackfillPatches([]*common.UpsertBatch{job.upsertBatch}, reporter, job.GetIdentifier())
+	if err != nil {
+		return err
+	}
+	return shard.createNewArchiveStoreVersionForBackfill(patches, reporter, job.GetIdentifier())
+}
+
+// GetIdentifier implements Job interface.
+func (job *parquetImportArchiveJob) GetIdentifier() string {
+	return getIdentifier(job.tableName, job.shardID, common.ParquetImportJobType)
+}
+
+// JobType implements Job interface.
+func (job *parquetImportArchiveJob) JobType() common.JobType {
+	return common.ParquetImportJobType
+}
+
+// String implements Job interface.
+func (job *parquetImportArchiveJob) String() string {
+	return fmt.Sprintf("ParquetImportArchiveJob<Table: %s, ShardID: %d, Rows: %d, Import: %s>",
+		job.tableName, job.shardID, job.upsertBatch.NumRows, job.importJobKey)
+}
+
+// ParquetImportManager runs parquet imports of tables in background and tracks their progress.
+// At most one import runs for each table.
+type ParquetImportManager struct {
+	sync.RWMutex
+	memStore  MemStore
+	metaStore metaCom.MetaStore
+	// Key is table name.
+	jobDetails map[string]*ParquetImportJobDetail
+}
+
+// NewParquetImportManager creates a new ParquetImportManager.
+func NewParquetImportManager(memStore MemStore, metaStore metaCom.MetaStore) *ParquetImportManager {
+	return &ParquetImportManager{
+		memStore:   memStore,
+		metaStore:  metaStore,
+		jobDetails: make(map[string]*ParquetImportJobDetail),
+	}
+}
+
+func (m *ParquetImportManager) reportJobDetail(key string, mutator ParquetImportJobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobDetail, found := m.jobDetails[key]
+	if !found {
+		jobDetail = &ParquetImportJobDetail{}
+		m.jobDetails[key] = jobDetail
+	}
+	mutator(jobDetail)
+}
+
+// GetJobDetails returns a copy of details of all imports.
+func (m *ParquetImportManager) GetJobDetails() map[string]ParquetImportJobDetail {
+	m.RLock()
+	defer m.RUnlock()
+	jobDetails := make(map[string]ParquetImportJobDetail, len(m.jobDetails))
+	for key, jobDetail := range m.jobDetails {
+		jobDetailCopy := *jobDetail
+		jobDetailCopy.Files = make([]ParquetImportFileDetail, len(jobDetail.Files))
+		for i, fileDetail := range jobDetail.Files {
+			fileDetail.RejectedRowSamples = append([]string(nil), fileDetail.RejectedRowSamples...)
+			jobDetailCopy.Files[i] = fileDetail
+		}
+		jobDetails[key] = jobDetailCopy
+	}
+	return jobDetails
+}
+
+// StartImport validates the import and starts it in background. Progress and result are
+// reported in job details.
+func (m *ParquetImportManager) StartImport(table string, options ParquetImportOptions, source ImportSource) error {
+	schema, err := m.memStore.GetSchema(table)
+	if err != nil {
+		return utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+	}
+	if len(options.Files) == 0 {
+		return utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "No files to import",
+		}
+	}
+	schema.RLock()
+	isFactTable := schema.Schema.IsFactTable
+	schema.RUnlock()
+	if options.Archive && !isFactTable {
+		return utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Table %s is not a fact table, rows cannot be imported into archive batches", table),
+		}
+	}
+	if options.NumShards <= 0 {
+		options.NumShards = 1
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultParquetImportBatchSize
+	}
+
+	jobKey := table
+	m.Lock()
+	jobDetail, found := m.jobDetails[jobKey]
+	if found && jobDetail.Status == JobRunning {
+		m.Unlock()
+		return utils.APIError{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("Parquet import of table %s is running", table),
+		}
+	}
+	jobDetail = &ParquetImportJobDetail{
+		JobDetail: JobDetail{
+			Status:        JobRunning,
+			LastStartTime: utils.Now().UTC(),
+			Total:         len(options.Files),
+		},
+		Source:  source.String(),
+		Archive: options.Archive,
+		Files:   make([]ParquetImportFileDetail, len(options.Files)),
+	}
+	for i, path := range options.Files {
+		jobDetail.Files[i] = ParquetImportFileDetail{Path: path, Status: ParquetImportPending}
+	}
+	m.jobDetails[jobKey] = jobDetail
+	m.Unlock()
+
+	go func() {
+		start := utils.Now()
+		importer := newParquetImporter(m.memStore, m.metaStore, schema, options, jobKey, m.reportJobDetail)
+		err := importer.importFiles(source)
+		m.reportJobDetail(jobKey, func(jobDetail *ParquetImportJobDetail) {
+			jobDetail.LastDuration = utils.Now().Sub(start)
+			jobDetail.LastRun = utils.Now().UTC()
+			jobDetail.LastError = err
+			for _, fileDetail := range jobDetail.Files {
+				jobDetail.NumRecords += int(fileDetail.NumImportedRows)
+			}
+			if err != nil {
+				jobDetail.Status = JobFailed
+			} else {
+				jobDetail.Status = JobSucceeded
+			}
+		})
+		logger := utils.GetLogger().With("table", table, "source", source.String())
+		if err != nil {
+			logger.With("error", err.Error()).Error("Parquet import failed")
+		} else {
+			logger.Info("Parquet import succeeded")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,194 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/diskstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/utils/parquet"
+)
+
+var _ = ginkgo.Describe("parquet import", func() {
+	prefix := "/tmp/testParquetImport"
+	testTable := "test"
+
+	parquetColumns := []parquet.Column{
+		{Name: "ts", Type: parquet.Int64, Logical: parquet.TimestampMillis},
+		{Name: "id", Type: parquet.Int32},
+		{Name: "city", Type: parquet.ByteArray, Logical: parquet.String},
+		{Name: "fare", Type: parquet.Double},
+		{Name: "unused", Type: parquet.Boolean},
+	}
+	columnMapping := map[string]string{"ts": "c0", "id": "c1", "city": "c2", "fare": "c3"}
+
+	var metaStore *metaStoreMocks.MetaStore
+	var memStore *memStoreImpl
+	var schema *memCom.TableSchema
+	var jobDetail *ParquetImportJobDetail
+
+	reporter := func(key string, mutator ParquetImportJobDetailMutator) {
+		mutator(jobDetail)
+	}
+
+	writeFile := func(name string) {
+		var buf bytes.Buffer
+		writer := parquet.NewWriter(&buf, parquetColumns)
+		chunks := make([]*parquet.ColumnChunk, len(parquetColumns))
+		for i, column := range parquetColumns {
+			chunks[i] = parquet.NewColumnChunk(column)
+		}
+		rows := []struct {
+			ts   int64
+			id   int32
+			city string
+		}{{1000, 1, "SF"}, {2000, 2, "nyc"}, {3000, 3, "sf"}, {-1, 4, "la"}, {5000, -5, "la"}}
+		for _, row := range rows {
+			if row.ts < 0 {
+				chunks[0].AppendNull()
+			} else {
+				chunks[0].AppendInt64(row.ts)
+			}
+			chunks[1].AppendInt32(row.id)
+			chunks[2].AppendString(row.city)
+			chunks[3].AppendDouble(1.5)
+			chunks[4].AppendBool(true)
+		}
+		Ω(writer.WriteRowGroup(chunks)).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+		Ω(os.MkdirAll(prefix, 0755)).Should(BeNil())
+		Ω(ioutil.WriteFile(prefix+"/"+name, buf.Bytes(), 0644)).Should(BeNil())
+	}
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		metaStore = &metaStoreMocks.MetaStore{}
+		memStore = createMemStore(testTable, 0, []memCom.DataType{memCom.Uint32, memCom.Uint32, memCom.SmallEnum,
+			memCom.Float32}, []int{1}, 10, true, false, metaStore, CreateMockDiskStore())
+
+		table := memStore.TableSchemas[testTable].Schema
+		table.Columns = []metaCom.Column{
+			{Name: "c0", Type: metaCom.Uint32},
+			{Name: "c1", Type: metaCom.Uint32},
+			{Name: "c2", Type: metaCom.SmallEnum, CaseInsensitive: true},
+			{Name: "c3", Type: metaCom.Float32},
+		}
+		schema = memCom.NewTableSchema(&table)
+		for id := range table.Columns {
+			schema.SetDefaultValue(id)
+		}
+		memStore.TableSchemas[testTable] = schema
+		memStore.TableShards[testTable][0].Schema = schema
+
+		jobDetail = &ParquetImportJobDetail{Files: make([]ParquetImportFileDetail, 1)}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(prefix)
+	})
+
+	ginkgo.It("resolves columns to import", func() {
+		columns, err := getParquetImportColumns(schema, parquetColumns, columnMapping)
+		Ω(err).Should(BeNil())
+		Ω(columns).Should(HaveLen(4))
+		Ω(columns[0].isTime).Should(BeTrue())
+		Ω(columns[2].index).Should(Equal(2))
+		Ω(columns[2].caseInsensitive).Should(BeTrue())
+
+		_, err = getParquetImportColumns(schema, parquetColumns, map[string]string{"id": "c1"})
+		Ω(err).ShouldNot(BeNil())
+		_, err = getParquetImportColumns(schema, parquetColumns, map[string]string{"ts": "c0"})
+		Ω(err).ShouldNot(BeNil())
+		_, err = getParquetImportColumns(schema, parquetColumns, map[string]string{"ts": "c0", "id": "c1", "x": "c2"})
+		Ω(err).ShouldNot(BeNil())
+		_, err = getParquetImportColumns(schema, parquetColumns, map[string]string{"ts": "c0", "id": "c1", "city": "c9"})
+		Ω(err).ShouldNot(BeNil())
+		_, err = getParquetImportColumns(schema, parquetColumns, map[string]string{"ts": "c0", "id": "c1", "fare": "c1"})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("imports rows and reports rejected rows", func() {
+		writeFile("a.parquet")
+		metaStore.On("ExtendEnumDict", testTable, "c2", []string{"sf", "nyc", "la"}).Return([]int{0, 1, 2}, nil)
+
+		options := ParquetImportOptions{Files: []string{"a.parquet"}, ColumnMapping: columnMapping, NumShards: 1,
+			BatchSize: 2}
+		importer := newParquetImporter(memStore, metaStore, schema, options, testTable, reporter)
+		Ω(importer.importFiles(NewLocalImportSource(prefix))).Should(BeNil())
+
+		fileDetail := jobDetail.Files[0]
+		Ω(fileDetail.Status).Should(Equal(ParquetImportSucceeded))
+		Ω(fileDetail.NumRows).Should(BeEquivalentTo(5))
+		Ω(fileDetail.NumRowGroupsDone).Should(Equal(1))
+		Ω(fileDetail.NumImportedRows).Should(BeEquivalentTo(3))
+		Ω(fileDetail.NumRejectedRows).Should(BeEquivalentTo(2))
+		Ω(fileDetail.RejectedRowSamples).Should(HaveLen(2))
+		Ω(fileDetail.RejectedRowSamples[0]).Should(ContainSubstring("row 3"))
+		Ω(fileDetail.RejectedRowSamples[1]).Should(ContainSubstring("row 4"))
+
+		shard, err := memStore.GetTableShard(testTable, 0)
+		Ω(err).Should(BeNil())
+		defer shard.Users.Done()
+		value, valid := ReadShardValue(shard, 0, []byte{2, 0, 0, 0})
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint32)(value)).Should(BeEquivalentTo(2))
+		value, valid = ReadShardValue(shard, 2, []byte{3, 0, 0, 0})
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint8)(value)).Should(BeEquivalentTo(0))
+		value, valid = ReadShardValue(shard, 2, []byte{2, 0, 0, 0})
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint8)(value)).Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("rejects rows of shards not owned and not older than archiving cutoff", func() {
+		writeFile("a.parquet")
+		metaStore.On("ExtendEnumDict", testTable, "c2", []string{"sf", "nyc", "la"}).Return([]int{0, 1, 2}, nil)
+
+		options := ParquetImportOptions{Files: []string{"a.parquet", "missing.parquet"}, ColumnMapping: columnMapping,
+			NumShards: 1000, Archive: true, BatchSize: 10}
+		jobDetail.Files = make([]ParquetImportFileDetail, 2)
+		importer := newParquetImporter(memStore, metaStore, schema, options, testTable, reporter)
+		Ω(importer.importFiles(NewLocalImportSource(prefix))).ShouldNot(BeNil())
+
+		Ω(jobDetail.Files[0].Status).Should(Equal(ParquetImportSucceeded))
+		Ω(jobDetail.Files[0].NumImportedRows).Should(BeEquivalentTo(0))
+		Ω(jobDetail.Files[0].NumRejectedRows).Should(BeEquivalentTo(5))
+		Ω(jobDetail.Files[1].Status).Should(Equal(ParquetImportFailed))
+		Ω(jobDetail.Files[1].Error).ShouldNot(BeEmpty())
+	})
+
+	ginkgo.It("reads files from object store", func() {
+		writeFile("a.parquet")
+		data, err := ioutil.ReadFile(prefix + "/a.parquet")
+		Ω(err).Should(BeNil())
+		objectStore := diskstore.NewMemoryObjectStore()
+		Ω(objectStore.Put("imports/a.parquet", bytes.NewReader(data))).Should(BeNil())
+
+		file, err := NewObjectStoreImportSource(objectStore, "imports/").Open("a.parquet")
+		Ω(err).Should(BeNil())
+		Ω(file.Size()).Should(BeEquivalentTo(len(data)))
+		reader, err := parquet.NewReader(file, file.Size())
+		Ω(err).Should(BeNil())
+		Ω(reader.Columns()).Should(Equal(parquetColumns))
+		Ω(file.Close()).Should(BeNil())
+	})
+})
@@ -308,6 +308,10 @@ func (m *memStoreImpl) LoadShard(schema *memcom.TableSchema, shard int, replayRe
 	if err != nil {
 		utils.GetLogger().Panic(err)
 	}
+	if err = tableShard.RecoverArchiveBatchVersions(); err != nil {
+		utils.GetLogger().Panic(err)
+	}
+	tableShard.scanDiskUsage()
 	if replayRedologs {
 		tableShard.PlayRedoLog()
 	}
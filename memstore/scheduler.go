@@ -47,6 +47,7 @@ type Scheduler interface {
 	SubmitJob(job Job) (error, chan error)
 	DeleteTable(table string, isFactTable bool)
 	GetJobDetails(jobType common.JobType) interface{}
+	GetJobDetail(tableName string, shardID int, jobType common.JobType) (JobDetail, bool)
 	NewBackfillJob(tableName string, shardID int) Job
 	NewArchivingJob(tableName string, shardID int, cutoff uint32) Job
 	NewSnapshotJob(tableName string, shardID int) Job
@@ -70,6 +71,8 @@ func newScheduler(m *memStoreImpl) *schedulerImpl {
 	s.jobManagers[common.BackfillJobType] = newBackfillJobManager(s)
 	s.jobManagers[common.SnapshotJobType] = newSnapshotJobManager(s)
 	s.jobManagers[common.PurgeJobType] = newPurgeJobManager(s)
+	s.jobManagers[common.ColumnMaterializeJobType] = newColumnMaterializeJobManager(s)
+	s.jobManagers[common.IntegrityCheckJobType] = newIntegrityCheckJobManager(s)
 	return s
 }
 
@@ -129,12 +132,23 @@ func (scheduler *schedulerImpl) GetJobDetails(jobType common.JobType) interface{
 	return nil
 }
 
+// GetJobDetail returns a copy of the common job detail of the job type for the table shard,
+// false if no such job is known to the scheduler.
+func (scheduler *schedulerImpl) GetJobDetail(tableName string, shardID int, jobType common.JobType) (JobDetail, bool) {
+	if jobManager, ok := scheduler.jobManagers[jobType]; ok {
+		return jobManager.lookupJobDetail(getIdentifier(tableName, shardID, jobType))
+	}
+	return JobDetail{}, false
+}
+
 // DeleteTable deletes the job details of a table given its name and whether it's a fact table.
 func (scheduler *schedulerImpl) DeleteTable(table string, isFactTable bool) {
+	scheduler.jobManagers[common.IntegrityCheckJobType].deleteTable(table)
 	if isFactTable {
 		scheduler.jobManagers[common.ArchivingJobType].deleteTable(table)
 		scheduler.jobManagers[common.BackfillJobType].deleteTable(table)
 		scheduler.jobManagers[common.PurgeJobType].deleteTable(table)
+		scheduler.jobManagers[common.ColumnMaterializeJobType].deleteTable(table)
 		return
 	}
 	scheduler.jobManagers[common.SnapshotJobType].deleteTable(table)
@@ -188,6 +202,29 @@ func (scheduler *schedulerImpl) NewPurgeJob(tableName string, shardID, batchIDSt
 	}
 }
 
+// newColumnMaterializeJob returns a new ColumnMaterializeJob.
+func (scheduler *schedulerImpl) newColumnMaterializeJob(tableName string, shardID int) Job {
+	return &ColumnMaterializeJob{
+		tableName: tableName,
+		shardID:   shardID,
+		memStore:  scheduler.memStore,
+		reporter: scheduler.jobManagers[common.ColumnMaterializeJobType].(*columnMaterializeJobManager).
+			reportColumnMaterializeJobDetail,
+	}
+}
+
+// newIntegrityCheckJob returns a new IntegrityCheckJob.
+func (scheduler *schedulerImpl) newIntegrityCheckJob(tableName string, shardID int, bytesPerSec int64) Job {
+	return &IntegrityCheckJob{
+		tableName:   tableName,
+		shardID:     shardID,
+		bytesPerSec: bytesPerSec,
+		memStore:    scheduler.memStore,
+		reporter: scheduler.jobManagers[common.IntegrityCheckJobType].(*integrityCheckJobManager).
+			reportIntegrityCheckJobDetail,
+	}
+}
+
 // Start starts the scheduler. It creates a new time.Timer every time to wait
 // at least schedulerInterval time instead of running at every tick so that we
 // will skip the tick if a single round takes more than one minute. This prevents
@@ -15,6 +15,7 @@
 package memstore
 
 import (
+	"github.com/uber/aresdb/diskstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/metastore"
 	metaCom "github.com/uber/aresdb/metastore/common"
@@ -126,6 +127,9 @@ func (m *memStoreImpl) applyTableList(newTableList []string) {
 			tableShards := m.TableShards[tableName]
 			delete(m.TableSchemas, tableName)
 			delete(m.TableShards, tableName)
+			diskstore.GetIOStats().DeleteTable(tableName)
+			diskstore.GetDiskUsage().DeleteTable(tableName)
+			m.deleteDiskQuotaLevel(tableName)
 			// only one table deletion at a time
 			m.Unlock()
 			for shardID, shard := range tableShards {
@@ -196,6 +200,7 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 
 	tableSchema.Lock()
 	oldColumns := tableSchema.Schema.Columns
+	oldConfig := tableSchema.Schema.Config
 	tableSchema.SetTable(newTable)
 
 	for columnID, column := range newTable.Columns {
@@ -232,6 +237,10 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 	}
 	tableSchema.Unlock()
 
+	if newTable.Config != oldConfig {
+		m.applyTableConfig(tableName, newTable.Config)
+	}
+
 	for _, columnID := range columnsToDelete {
 		var shards []*TableShard
 		m.RLock()
@@ -249,6 +258,23 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 	}
 }
 
+// applyTableConfig applies updated table config to live stores of all shards of the table, whose
+// managers keep their own copies of configs. Jobs read configs from table schema directly.
+func (m *memStoreImpl) applyTableConfig(tableName string, tableConfig metaCom.TableConfig) {
+	var shards []*TableShard
+	m.RLock()
+	for _, shard := range m.TableShards[tableName] {
+		shard.Users.Add(1)
+		shards = append(shards, shard)
+	}
+	m.RUnlock()
+
+	for _, shard := range shards {
+		shard.LiveStore.ApplyTableConfig(tableConfig)
+		shard.Users.Done()
+	}
+}
+
 // handleEnumDictChange handles enum dict change event from metaStore for specific table and column.
 func (m *memStoreImpl) handleEnumDictChange(tableName, columnName string, enumDictChangeEvents <-chan string, done chan<- struct{}) {
 	for newEnumCase := range enumDictChangeEvents {
@@ -273,8 +299,35 @@ func (m *memStoreImpl) applyEnumCase(tableName, columnName string, newEnumCase s
 		return
 	}
 
+	// cases replayed after the overflow case was appended do not alert again.
+	_, replayed := enumDict.Dict[newEnumCase]
 	enumDict.Dict[newEnumCase] = len(enumDict.ReverseDict)
 	enumDict.ReverseDict = append(enumDict.ReverseDict, newEnumCase)
 	tableSchema.EnumDicts[columnName] = enumDict
+	enumCardinalityCap := 0
+	if columnID, exist := tableSchema.ColumnIDs[columnName]; exist {
+		enumCardinalityCap = tableSchema.Schema.Columns[columnID].Config.EnumCardinalityCap
+	}
 	tableSchema.Unlock()
+
+	// the overflow case is only appended when the enum cardinality cap is reached for the first time.
+	if newEnumCase == metaCom.EnumOverflowCase && enumCardinalityCap > 0 && !replayed {
+		utils.GetLogger().With(
+			"table", tableName,
+			"column", columnName,
+			"enumCardinalityCap", enumCardinalityCap,
+		).Error("Enum cardinality cap reached, new enum cases are mapped to the overflow case")
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"table":      tableName,
+			"columnName": columnName,
+		}, utils.EnumCardinalityCapReached).Inc(1)
+		if m.options.diskSpaceMonitor != nil {
+			m.options.diskSpaceMonitor.SendEnumOverflowAlert(diskstore.EnumOverflowAlert{
+				Table:              tableName,
+				Column:             columnName,
+				EnumCardinalityCap: enumCardinalityCap,
+				Time:               utils.Now().Unix(),
+			})
+		}
+	}
 }
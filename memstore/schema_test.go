@@ -15,11 +15,15 @@
 package memstore
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/diskstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
 	"github.com/uber/aresdb/metastore"
@@ -212,6 +216,18 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		Ω(tableSchema.GetColumnDeletions()).Should(BeEquivalentTo([]bool{false, false, false}))
 	})
 
+	ginkgo.It("GetColumnDeprecations should work", func() {
+		deprecatedTable := testTable
+		deprecatedTable.Columns = append([]metaCom.Column{}, testTable.Columns...)
+		deprecatedTable.Columns[1].Deprecated = true
+		deprecatedTable.Columns[1].PurgeAt = 1000
+		tableSchema := memCom.NewTableSchema(&deprecatedTable)
+		Ω(tableSchema.GetColumnDeprecations()).Should(BeEquivalentTo([]bool{false, true, false}))
+
+		tableSchema = memCom.NewTableSchema(&testTable)
+		Ω(tableSchema.GetColumnDeprecations()).Should(BeEquivalentTo([]bool{false, false, false}))
+	})
+
 	ginkgo.It("SetEnumDict should work", func() {
 		tableSchema := memCom.NewTableSchema(&testTable)
 		tableSchema.CreateEnumDict(testColumn2.Name, testColumn2EnumCases)
@@ -542,6 +558,35 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		destroyTestMemstore(testMemstore)
 	})
 
+	ginkgo.It("applyEnumCase should alert once when enum cardinality cap is reached", func() {
+		alerts := make(chan diskstore.EnumOverflowAlert, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var alert diskstore.EnumOverflowAlert
+			json.NewDecoder(r.Body).Decode(&alert)
+			alerts <- alert
+		}))
+		defer server.Close()
+
+		testMemstore := getTestMemstore()
+		testMemstore.options = testMemstore.options.SetDiskSpaceMonitor(diskstore.NewDiskSpaceMonitor("/tmp",
+			common.DiskSpaceConfig{AlertWebhookURL: server.URL}))
+		tableSchema := testMemstore.TableSchemas[testTable.Name]
+		tableSchema.Schema.Columns = append([]metaCom.Column(nil), testTable.Columns...)
+		tableSchema.Schema.Columns[tableSchema.ColumnIDs[testColumn2.Name]].Config.EnumCardinalityCap = 5
+
+		testMemstore.applyEnumCase(testTable.Name, testColumn2.Name, "d")
+		testMemstore.applyEnumCase(testTable.Name, testColumn2.Name, metaCom.EnumOverflowCase)
+		testMemstore.applyEnumCase(testTable.Name, testColumn2.Name, metaCom.EnumOverflowCase)
+
+		var alert diskstore.EnumOverflowAlert
+		Eventually(alerts).Should(Receive(&alert))
+		Ω(alert.Table).Should(Equal(testTable.Name))
+		Ω(alert.Column).Should(Equal(testColumn2.Name))
+		Ω(alert.EnumCardinalityCap).Should(Equal(5))
+		Consistently(alerts).ShouldNot(Receive())
+		destroyTestMemstore(testMemstore)
+	})
+
 	ginkgo.It("handleEnumDictChange should work", func() {
 		testMemstore := getTestMemstore()
 
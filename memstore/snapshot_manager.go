@@ -19,6 +19,7 @@ import (
 
 	"encoding/json"
 	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 	"time"
 )
@@ -74,6 +75,14 @@ func NewSnapshotManager(shard *TableShard) *SnapshotManager {
 	}
 }
 
+// UpdateConfig applies updated table config.
+func (s *SnapshotManager) UpdateConfig(tableConfig metaCom.TableConfig) {
+	s.Lock()
+	defer s.Unlock()
+	s.SnapshotThreshold = tableConfig.SnapshotThreshold
+	s.SnapshotInterval = time.Duration(tableConfig.SnapshotIntervalMinutes) * time.Minute
+}
+
 // StartSnapshot returns current redo log file ,offset
 func (s *SnapshotManager) StartSnapshot() (int64, uint32, int, common.RecordID) {
 	s.RLock()
@@ -70,6 +70,15 @@ var _ = ginkgo.Describe("snapshot manager", func() {
 		Ω(snapshotManager.SnapshotThreshold).Should(Equal(shard.Schema.Schema.Config.SnapshotThreshold))
 	})
 
+	ginkgo.It("UpdateConfig should work", func() {
+		snapshotManager.UpdateConfig(metaCom.TableConfig{
+			SnapshotThreshold:       100,
+			SnapshotIntervalMinutes: 10,
+		})
+		Ω(snapshotManager.SnapshotThreshold).Should(Equal(100))
+		Ω(snapshotManager.SnapshotInterval).Should(Equal(10 * time.Minute))
+	})
+
 	ginkgo.It("StartSnapshot, ApplyUpsertBatch and then Done should work", func() {
 		redoLogFile, offset, numMutations, record := snapshotManager.StartSnapshot()
 		Ω(redoLogFile).Should(BeZero())
@@ -42,6 +42,9 @@ type Vector struct {
 	unitBits int
 	// Pointer to the vector buffer.
 	buffer uintptr
+	// Whether the buffer points into a memory mapped file, which is read only and not owned
+	// by the vector.
+	mapped bool
 
 	// **All following fields only works for live batch's vectors.**
 
@@ -71,6 +74,26 @@ func NewVector(dataType common.DataType, size int) *Vector {
 	}
 }
 
+// newMappedVector creates a vector backed by the memory mapped data, which must be at least
+// CalculateVectorBytes(dataType, size) bytes and stay mapped during the lifetime of the vector.
+func newMappedVector(dataType common.DataType, size int, data []byte) *Vector {
+	var buffer uintptr
+	// empty vectors have no backing memory.
+	if len(data) > 0 {
+		buffer = uintptr(unsafe.Pointer(&data[0]))
+	}
+	return &Vector{
+		DataType: dataType,
+		cmpFunc:  common.GetCompareFunc(dataType),
+		unitBits: common.DataTypeBits(dataType),
+		Size:     size,
+		Bytes:    CalculateVectorBytes(dataType, size),
+		buffer:   buffer,
+		mapped:   true,
+		minValue: math.MaxUint32,
+	}
+}
+
 // CalculateVectorBytes calculates bytes the vector will occupy given data type and size without actual allocation.
 func CalculateVectorBytes(dataType common.DataType, size int) int {
 	unitBits := common.DataTypeBits(dataType)
@@ -106,7 +129,7 @@ func CalculateVectorPartyBytes(dataType common.DataType, size int, hasNulls bool
 
 // SafeDestruct destructs this vector's storage space managed in C.
 func (v *Vector) SafeDestruct() {
-	if v != nil {
+	if v != nil && !v.mapped {
 		cgoutils.HostFree(unsafe.Pointer(v.buffer))
 	}
 }
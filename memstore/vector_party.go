@@ -15,7 +15,9 @@
 package memstore
 
 import (
+	"bytes"
 	"github.com/uber/aresdb/cgoutils"
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
 	"io"
@@ -449,48 +451,65 @@ func (vp *cVectorParty) SliceIndex(lowerBoundRow, upperBoundRow int) (startIndex
 // based on vector party mode. **This vector party should be from archive batch and already pruned.**
 func (vp *cVectorParty) Write(writer io.Writer) error {
 	dataWriter := utils.NewStreamDataWriter(writer)
-	if err := dataWriter.WriteUint32(VectorPartyHeader); err != nil {
+	if err := vp.writeHeader(&dataWriter, VectorPartyFormatV1); err != nil {
 		return err
 	}
 
-	if err := dataWriter.WriteInt32(int32(vp.length)); err != nil {
+	// Starting writing vectors.
+	// Stop writing since there are no vectors in this vp.
+	if vp.columnMode <= common.AllValuesDefault {
+		return nil
+	}
+
+	// Write value vector.
+	// Here we directly move data from c allocated memory into writer.
+	if err := dataWriter.Write(
+		cgoutils.MakeSliceFromCPtr(vp.values.buffer, vp.values.Bytes),
+	); err != nil {
 		return err
 	}
+	return vp.writeNullsAndCounts(&dataWriter)
+}
 
-	if err := dataWriter.WriteUint32(uint32(vp.dataType)); err != nil {
+// writeHeader writes the vector party file header with the format version.
+func (vp *cVectorParty) writeHeader(dataWriter *utils.StreamDataWriter, version uint16) error {
+	if err := dataWriter.WriteUint32(VectorPartyHeader); err != nil {
 		return err
 	}
 
-	if err := dataWriter.WriteInt32(int32(vp.nonDefaultValueCount)); err != nil {
+	if err := dataWriter.WriteInt32(int32(vp.length)); err != nil {
 		return err
 	}
 
-	columnMode := vp.columnMode
-	if err := dataWriter.WriteUint16(uint16(columnMode)); err != nil {
+	if err := dataWriter.WriteUint32(uint32(vp.dataType)); err != nil {
 		return err
 	}
 
-	// Write 6 bytes padding.
-	if err := dataWriter.SkipBytes(6); err != nil {
+	if err := dataWriter.WriteInt32(int32(vp.nonDefaultValueCount)); err != nil {
 		return err
 	}
 
-	// Starting writing vectors.
-	// Stop writing since there are no vectors in this vp.
-	if columnMode <= common.AllValuesDefault {
-		return nil
+	if err := dataWriter.WriteUint16(uint16(vp.columnMode)); err != nil {
+		return err
 	}
 
-	// Write value vector.
-	// Here we directly move data from c allocated memory into writer.
-	if err := dataWriter.Write(
-		cgoutils.MakeSliceFromCPtr(vp.values.buffer, vp.values.Bytes),
-	); err != nil {
+	// Files of the first format version are written with zero padding in place of the version
+	// so that they are identical to files written before the format was versioned.
+	if version == VectorPartyFormatV1 {
+		version = 0
+	}
+	if err := dataWriter.WriteUint16(version); err != nil {
 		return err
 	}
 
+	// Write 4 bytes padding.
+	return dataWriter.SkipBytes(4)
+}
+
+// writeNullsAndCounts writes the null vector and count vector based on vector party mode.
+func (vp *cVectorParty) writeNullsAndCounts(dataWriter *utils.StreamDataWriter) error {
 	// Stop writing since there are no more vectors in this vp.
-	if columnMode <= common.AllValuesPresent {
+	if vp.columnMode <= common.AllValuesPresent {
 		return nil
 	}
 
@@ -503,69 +522,145 @@ func (vp *cVectorParty) Write(writer io.Writer) error {
 	}
 
 	// Stop writing since there are no more vectors in this vp.
-	if columnMode <= common.HasNullVector {
+	if vp.columnMode <= common.HasNullVector {
 		return nil
 	}
 
 	// Write count vector.
 	// Here we directly move data from c allocated memory into writer.
-	if err := dataWriter.Write(
+	return dataWriter.Write(
 		cgoutils.MakeSliceFromCPtr(vp.counts.buffer, vp.counts.Bytes),
-	); err != nil {
-		return err
-	}
-
-	return nil
+	)
 }
 
 // Read reads a vector party from underlying reader. It first reads header from the reader and does
 // several sanity checks. Then it reads vectors based on vector party mode.
 func (vp *cVectorParty) Read(reader io.Reader, s common.VectorPartySerializer) error {
 	dataReader := utils.NewStreamDataReader(reader)
-	magicNumber, err := dataReader.ReadUint32()
+	version, err := vp.readHeader(&dataReader, s)
+	if err != nil {
+		return err
+	}
+	return vp.readVectors(&dataReader, version)
+}
+
+// ReadMapped reads a vector party from the memory mapped content of a vector party file. Vectors
+// point directly into data instead of being copied, so data must stay mapped until the vector party
+// is destructed. Dictionary encoded files are decoded into host memory instead, which can be told
+// by IsMapped.
+func (vp *cVectorParty) ReadMapped(data []byte, s common.VectorPartySerializer) error {
+	dataReader := utils.NewStreamDataReader(bytes.NewReader(data))
+	version, err := vp.readHeader(&dataReader, s)
+	if err != nil {
+		return err
+	}
+	if version != VectorPartyFormatV1 {
+		return vp.readVectors(&dataReader, version)
+	}
+	if vp.columnMode <= common.AllValuesDefault {
+		return nil
+	}
+
+	offset := vectorPartyHeaderBytes
+	mapVector := func(dataType common.DataType, size int) (*Vector, error) {
+		end := offset + CalculateVectorBytes(dataType, size)
+		if end > len(data) {
+			// the same error as files of sizes not matching their checksums read without mapping, the path
+			// is filled by the caller mapping the file.
+			return nil, &diskstore.CorruptionError{Offset: -1}
+		}
+		vector := newMappedVector(dataType, size, data[offset:end])
+		offset = end
+		return vector, nil
+	}
+
+	values, err := mapVector(vp.dataType, vp.length)
 	if err != nil {
 		return err
 	}
+	var nulls, counts *Vector
+	if vp.columnMode > common.AllValuesPresent {
+		if nulls, err = mapVector(common.Bool, vp.length); err != nil {
+			return err
+		}
+	}
+	if vp.columnMode > common.HasNullVector {
+		if counts, err = mapVector(common.Uint32, vp.length+1); err != nil {
+			return err
+		}
+	}
+	vp.values, vp.nulls, vp.counts = values, nulls, counts
+	return nil
+}
+
+// IsMapped tells whether the vectors of this vector party point into a memory mapped file.
+func (vp *cVectorParty) IsMapped() bool {
+	return vp.values != nil && vp.values.mapped
+}
+
+// readHeader reads the header of a vector party file into vp, checks whether vp can be read by the
+// serializer and reports memory usage of the vectors. It returns the format version of the file.
+func (vp *cVectorParty) readHeader(dataReader *utils.StreamDataReader, s common.VectorPartySerializer) (uint16, error) {
+	magicNumber, err := dataReader.ReadUint32()
+	if err != nil {
+		return 0, err
+	}
 
 	if magicNumber != VectorPartyHeader {
-		return utils.StackError(nil, "Magic number does not match, vector party file may be corrupted")
+		return 0, utils.StackError(nil, "Magic number does not match, vector party file may be corrupted")
 	}
 
 	rawLength, err := dataReader.ReadInt32()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	length := int(rawLength)
 
 	rawDataType, err := dataReader.ReadUint32()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	dataType, err := common.NewDataType(rawDataType)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	nonDefaultValueCount, err := dataReader.ReadInt32()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	m, err := dataReader.ReadUint16()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	columnMode := common.ColumnMode(m)
 	if columnMode >= common.MaxColumnMode {
-		return utils.StackError(nil, "Invalid mode %d", columnMode)
+		return 0, utils.StackError(nil, "Invalid mode %d", columnMode)
+	}
+
+	version, err := dataReader.ReadUint16()
+	if err != nil {
+		return 0, err
+	}
+	// Files written before the format was versioned have zero padding in place of the version.
+	if version == 0 {
+		version = VectorPartyFormatV1
+	}
+	if version > VectorPartyFormatV2 {
+		return 0, utils.StackError(nil, "Unsupported vector party file format version %d", version)
+	}
+	if version == VectorPartyFormatV2 && !common.IsEnumType(dataType) {
+		return 0, utils.StackError(nil, "Vector party file format version %d is not supported for data type %s",
+			version, common.DataTypeName[dataType])
 	}
 
 	// Read unused bytes
-	err = dataReader.SkipBytes(6)
+	err = dataReader.SkipBytes(4)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	vp.length = length
@@ -574,66 +669,78 @@ func (vp *cVectorParty) Read(reader io.Reader, s common.VectorPartySerializer) e
 	vp.columnMode = columnMode
 
 	if err = s.CheckVectorPartySerializable(vp); err != nil {
-		return err
+		return 0, err
 	}
 
-	bytes := CalculateVectorPartyBytes(vp.GetDataType(), vp.GetLength(),
+	vpBytes := CalculateVectorPartyBytes(vp.GetDataType(), vp.GetLength(),
 		columnMode == common.HasNullVector || columnMode == common.HasCountVector, columnMode == common.HasCountVector)
-	s.ReportVectorPartyMemoryUsage(int64(bytes))
+	s.ReportVectorPartyMemoryUsage(int64(vpBytes))
 
+	return version, nil
+}
+
+// readVectors reads vectors following the header into c allocated memory based on vector party mode.
+func (vp *cVectorParty) readVectors(dataReader *utils.StreamDataReader, version uint16) error {
 	// Stop reading since there are no vectors in this vp.
-	if columnMode <= common.AllValuesDefault {
+	if vp.columnMode <= common.AllValuesDefault {
 		return nil
 	}
 
 	// Read value vector.
-	valueVector := NewVector(dataType, length)
-	// Here we directly read from reader into the c allocated bytes.
-	if err = dataReader.Read(
-		cgoutils.MakeSliceFromCPtr(valueVector.buffer, valueVector.Bytes),
-	); err != nil {
-		valueVector.SafeDestruct()
+	var valueVector *Vector
+	var err error
+	if version == VectorPartyFormatV2 {
+		valueVector, err = readDictionaryEncodedVector(dataReader, vp.dataType, vp.length)
+	} else {
+		valueVector, err = readVector(dataReader, vp.dataType, vp.length)
+	}
+	if err != nil {
 		return err
 	}
-	vp.values = valueVector
 
 	// Stop reading since there are no more vectors in this vp.
-	if columnMode <= common.AllValuesPresent {
+	if vp.columnMode <= common.AllValuesPresent {
+		vp.values = valueVector
 		return nil
 	}
 
 	// Read null vector.
-	nullVector := NewVector(common.Bool, length)
-	// Here we directly read from reader into the c allocated bytes.
-	if err = dataReader.Read(
-		cgoutils.MakeSliceFromCPtr(nullVector.buffer, nullVector.Bytes),
-	); err != nil {
+	nullVector, err := readVector(dataReader, common.Bool, vp.length)
+	if err != nil {
 		valueVector.SafeDestruct()
-		nullVector.SafeDestruct()
 		return err
 	}
-	vp.nulls = nullVector
 
 	// Stop reading since there are no more vectors in this vp.
-	if columnMode <= common.HasNullVector {
+	if vp.columnMode <= common.HasNullVector {
+		vp.values, vp.nulls = valueVector, nullVector
 		return nil
 	}
 
 	// Read count vector.
-	countVector := NewVector(common.Uint32, length+1)
-	// Here we directly read from reader into the c allocated bytes.
-	if err = dataReader.Read(
-		cgoutils.MakeSliceFromCPtr(countVector.buffer, countVector.Bytes),
-	); err != nil {
+	countVector, err := readVector(dataReader, common.Uint32, vp.length+1)
+	if err != nil {
 		valueVector.SafeDestruct()
 		nullVector.SafeDestruct()
-		countVector.SafeDestruct()
 		return err
 	}
-	vp.counts = countVector
+	vp.values, vp.nulls, vp.counts = valueVector, nullVector, countVector
 	return nil
 }
 
+// readVector reads a vector of the data type and size from the reader.
+func readVector(dataReader *utils.StreamDataReader, dataType common.DataType, size int) (*Vector, error) {
+	vector := NewVector(dataType, size)
+	// Here we directly read from reader into the c allocated bytes.
+	if err := dataReader.Read(
+		cgoutils.MakeSliceFromCPtr(vector.buffer, vector.Bytes),
+	); err != nil {
+		vector.SafeDestruct()
+		return nil, err
+	}
+	return vector, nil
+}
+
 // GetHostVectorPartySlice implements GetHostVectorPartySlice in cVectorParty
 func (vp *cVectorParty) GetHostVectorPartySlice(startIndex, length int) common.HostVectorPartySlice {
 	endIndex := startIndex + length
@@ -677,6 +784,8 @@ type Pinnable struct {
 	Pins int
 	// For archive store only. The condition for pins to drop down to 0.
 	AllUsersDone *sync.Cond
+	// loadErr is the error of loading the vector party from disk, set before Loader is done.
+	loadErr error
 }
 
 // Release releases the vector party from the archive store
@@ -710,3 +819,9 @@ func (vp *Pinnable) WaitForUsers(blocking bool) bool {
 func (vp *Pinnable) WaitForDiskLoad() {
 	vp.Loader.Wait()
 }
+
+// LoadError returns the error of loading the vector party from disk,
+// caller must call WaitForDiskLoad before calling it.
+func (vp *Pinnable) LoadError() error {
+	return vp.loadErr
+}
@@ -0,0 +1,208 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"io"
+	"unsafe"
+
+	"github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// Values of enum vector parties in VectorPartyFormatV2 files are dictionary encoded. Enum ids present
+// in the batch are assigned dense local codes in the order they first appear, and the value vector
+// is replaced by:
+//   - uint32 number of enum ids in the dictionary.
+//   - uint8 bits per code, one of 0, 1, 2, 4, 8 and 16, followed by 3 bytes padding.
+//   - uint16 global enum id of each local code.
+//   - codes of all values bit packed starting from the least significant bit of each byte.
+// Null and count vectors follow as in VectorPartyFormatV1. Values are decoded back to global enum
+// ids on load so the in memory representation does not change.
+
+// dictionaryCodeBits are the supported number of bits per dictionary code.
+var dictionaryCodeBits = []uint{0, 1, 2, 4, 8, 16}
+
+// dictionaryEncodedWriter is implemented by vector parties that can be written with dictionary encoding.
+type dictionaryEncodedWriter interface {
+	WriteDictionaryEncoded(writer io.Writer) error
+}
+
+// WriteDictionaryEncoded writes the vector party with dictionary encoded values in VectorPartyFormatV2
+// if it is an enum vector party and the encoded values are smaller, otherwise it falls back to Write.
+func (vp *cVectorParty) WriteDictionaryEncoded(writer io.Writer) error {
+	if !common.IsEnumType(vp.dataType) || vp.columnMode <= common.AllValuesDefault {
+		return vp.Write(writer)
+	}
+
+	ids, codes := vp.buildEnumDictionary()
+	codeBits := getDictionaryCodeBits(len(ids))
+	if getDictionaryEncodedBytes(len(ids), codeBits, vp.length) >= vp.values.Bytes {
+		return vp.Write(writer)
+	}
+
+	dataWriter := utils.NewStreamDataWriter(writer)
+	if err := vp.writeHeader(&dataWriter, VectorPartyFormatV2); err != nil {
+		return err
+	}
+
+	if err := dataWriter.WriteUint32(uint32(len(ids))); err != nil {
+		return err
+	}
+
+	if err := dataWriter.WriteUint8(uint8(codeBits)); err != nil {
+		return err
+	}
+
+	// Write 3 bytes padding.
+	if err := dataWriter.SkipBytes(3); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := dataWriter.WriteUint16(id); err != nil {
+			return err
+		}
+	}
+
+	if err := dataWriter.Write(packDictionaryCodes(codes, codeBits)); err != nil {
+		return err
+	}
+	return vp.writeNullsAndCounts(&dataWriter)
+}
+
+// buildEnumDictionary returns enum ids present in the value vector in the order they first appear
+// and the local code of each value.
+func (vp *cVectorParty) buildEnumDictionary() (ids []uint16, codes []uint16) {
+	codeByID := make(map[uint16]uint16)
+	codes = make([]uint16, vp.length)
+	for i := 0; i < vp.length; i++ {
+		var id uint16
+		if vp.dataType == common.SmallEnum {
+			id = uint16(*(*uint8)(vp.values.GetValue(i)))
+		} else {
+			id = *(*uint16)(vp.values.GetValue(i))
+		}
+
+		code, ok := codeByID[id]
+		if !ok {
+			code = uint16(len(ids))
+			codeByID[id] = code
+			ids = append(ids, id)
+		}
+		codes[i] = code
+	}
+	return
+}
+
+// readDictionaryEncodedVector reads dictionary encoded values and decodes them into a vector of
+// global enum ids.
+func readDictionaryEncodedVector(dataReader *utils.StreamDataReader, dataType common.DataType, size int) (*Vector, error) {
+	dictionarySize, err := dataReader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	rawCodeBits, err := dataReader.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	codeBits := uint(rawCodeBits)
+	if codeBits > 16 || getDictionaryCodeBits(int(dictionarySize)) != codeBits {
+		return nil, utils.StackError(nil, "Invalid code bits %d for dictionary of size %d",
+			codeBits, dictionarySize)
+	}
+
+	// Read unused bytes
+	if err = dataReader.SkipBytes(3); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint16, dictionarySize)
+	for i := range ids {
+		if ids[i], err = dataReader.ReadUint16(); err != nil {
+			return nil, err
+		}
+	}
+
+	packedCodes := make([]byte, getPackedDictionaryCodeBytes(size, codeBits))
+	if err = dataReader.Read(packedCodes); err != nil {
+		return nil, err
+	}
+
+	vector := NewVector(dataType, size)
+	for i := 0; i < size; i++ {
+		code := unpackDictionaryCode(packedCodes, codeBits, i)
+		if int(code) >= len(ids) {
+			vector.SafeDestruct()
+			return nil, utils.StackError(nil, "Dictionary code %d out of bound %d", code, len(ids))
+		}
+		if dataType == common.SmallEnum {
+			id := uint8(ids[code])
+			vector.SetValue(i, unsafe.Pointer(&id))
+		} else {
+			vector.SetValue(i, unsafe.Pointer(&ids[code]))
+		}
+	}
+	return vector, nil
+}
+
+// getDictionaryCodeBits returns the least supported number of bits to encode codes of a dictionary.
+func getDictionaryCodeBits(dictionarySize int) uint {
+	for _, bits := range dictionaryCodeBits {
+		if dictionarySize <= 1<<bits {
+			return bits
+		}
+	}
+	return 16
+}
+
+// getPackedDictionaryCodeBytes returns number of bytes of size bit packed codes.
+func getPackedDictionaryCodeBytes(size int, codeBits uint) int {
+	return (size*int(codeBits) + 7) / 8
+}
+
+// getDictionaryEncodedBytes returns number of bytes of dictionary encoded values.
+func getDictionaryEncodedBytes(dictionarySize int, codeBits uint, size int) int {
+	return 8 + dictionarySize*2 + getPackedDictionaryCodeBytes(size, codeBits)
+}
+
+// packDictionaryCodes bit packs codes with codeBits bits each.
+func packDictionaryCodes(codes []uint16, codeBits uint) []byte {
+	packed := make([]byte, getPackedDictionaryCodeBytes(len(codes), codeBits))
+	for i, code := range codes {
+		if codeBits == 16 {
+			packed[2*i] = byte(code)
+			packed[2*i+1] = byte(code >> 8)
+		} else if codeBits > 0 {
+			bit := uint(i) * codeBits
+			packed[bit/8] |= byte(code << (bit % 8))
+		}
+	}
+	return packed
+}
+
+// unpackDictionaryCode returns the ith code of bit packed codes.
+func unpackDictionaryCode(packed []byte, codeBits uint, i int) uint16 {
+	switch codeBits {
+	case 0:
+		return 0
+	case 16:
+		return uint16(packed[2*i]) | uint16(packed[2*i+1])<<8
+	default:
+		bit := uint(i) * codeBits
+		return uint16(packed[bit/8]>>(bit%8)) & (1<<codeBits - 1)
+	}
+}
@@ -0,0 +1,176 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"sync"
+	"unsafe"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/diskstore/mocks"
+	"github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// createEnumVectorParty creates a pruned archive enum vector party with the value of each row
+// returned by getID, or null if getID returns a negative id.
+func createEnumVectorParty(dataType common.DataType, length int, getID func(row int) int) *archiveVectorParty {
+	vp := newArchiveVectorParty(length, dataType, common.NullDataValue, &sync.Mutex{})
+	vp.Allocate(false)
+	for row := 0; row < length; row++ {
+		id := getID(row)
+		value := common.DataValue{DataType: dataType, Valid: id >= 0}
+		id8, id16 := uint8(id), uint16(id)
+		if dataType == common.SmallEnum {
+			value.OtherVal = unsafe.Pointer(&id8)
+		} else {
+			value.OtherVal = unsafe.Pointer(&id16)
+		}
+		vp.SetDataValue(row, value, IncrementCount)
+	}
+	vp.Prune()
+	return vp
+}
+
+var _ = ginkgo.Describe("vector party dictionary encoding", func() {
+	var serializer *vectorPartyArchiveSerializer
+
+	ginkgo.BeforeEach(func() {
+		serializer = &vectorPartyArchiveSerializer{
+			vectorPartyBaseSerializer: vectorPartyBaseSerializer{
+				table:             "test",
+				diskstore:         new(mocks.DiskStore),
+				hostMemoryManager: NewHostMemoryManager(GetFactory().NewMockMemStore(), 1<<32),
+			},
+			dictionaryEncoding: true,
+		}
+	})
+
+	// writeAndRead writes vp with and without dictionary encoding, checks the dictionary
+	// encoded file reads back to the same vector party and returns sizes of both files.
+	writeAndRead := func(vp *archiveVectorParty) (v1Bytes, v2Bytes int, v2Version uint16) {
+		v1 := &bytes.Buffer{}
+		Ω(vp.Write(v1)).Should(BeNil())
+		v2 := &bytes.Buffer{}
+		Ω(vp.WriteDictionaryEncoded(v2)).Should(BeNil())
+
+		newVP := &cVectorParty{}
+		dataReader := utils.NewStreamDataReader(bytes.NewReader(v2.Bytes()))
+		version, err := newVP.readHeader(&dataReader, serializer)
+		Ω(err).Should(BeNil())
+		Ω(newVP.readVectors(&dataReader, version)).Should(BeNil())
+		Ω(vp.Equals(newVP)).Should(BeTrue())
+		newVP.SafeDestruct()
+
+		// mapped read decodes into host memory.
+		newVP = &cVectorParty{}
+		Ω(newVP.ReadMapped(v2.Bytes(), serializer)).Should(BeNil())
+		Ω(vp.Equals(newVP)).Should(BeTrue())
+		Ω(newVP.IsMapped()).Should(Equal(version == VectorPartyFormatV1))
+		newVP.SafeDestruct()
+		return v1.Len(), v2.Len(), version
+	}
+
+	ginkgo.It("encodes low cardinality big enums", func() {
+		vp := createEnumVectorParty(common.BigEnum, 10000, func(row int) int {
+			return 1000 + row%3
+		})
+		defer vp.SafeDestruct()
+		Ω(vp.GetMode()).Should(Equal(common.AllValuesPresent))
+
+		v1Bytes, v2Bytes, version := writeAndRead(vp)
+		Ω(version).Should(Equal(VectorPartyFormatV2))
+		// 2 bits per value instead of 16 bits.
+		Ω(v1Bytes).Should(Equal(24 + 20032))
+		Ω(v2Bytes).Should(Equal(24 + 8 + 3*2 + 2500))
+	})
+
+	ginkgo.It("encodes enums with nulls", func() {
+		vp := createEnumVectorParty(common.SmallEnum, 1000, func(row int) int {
+			if row%10 == 0 {
+				return -1
+			}
+			return row % 7
+		})
+		defer vp.SafeDestruct()
+		Ω(vp.GetMode()).Should(Equal(common.HasNullVector))
+
+		v1Bytes, v2Bytes, version := writeAndRead(vp)
+		Ω(version).Should(Equal(VectorPartyFormatV2))
+		Ω(v2Bytes).Should(BeNumerically("<", v1Bytes))
+
+		// single enum value takes no bits per value.
+		vp = createEnumVectorParty(common.SmallEnum, 1000, func(row int) int {
+			return 5
+		})
+		defer vp.SafeDestruct()
+		v1Bytes, v2Bytes, version = writeAndRead(vp)
+		Ω(version).Should(Equal(VectorPartyFormatV2))
+		Ω(v2Bytes).Should(Equal(24 + 8 + 2))
+		Ω(v1Bytes).Should(Equal(24 + 1024))
+	})
+
+	ginkgo.It("falls back to v1 for high cardinality enums", func() {
+		vp := createEnumVectorParty(common.SmallEnum, 1000, func(row int) int {
+			return row % 200
+		})
+		defer vp.SafeDestruct()
+		v1Bytes, v2Bytes, version := writeAndRead(vp)
+		Ω(version).Should(Equal(VectorPartyFormatV1))
+		Ω(v2Bytes).Should(Equal(v1Bytes))
+	})
+
+	ginkgo.It("uses dictionary encoding in archive serializer only when enabled", func() {
+		vp := createEnumVectorParty(common.BigEnum, 1000, func(row int) int {
+			return row % 2
+		})
+		defer vp.SafeDestruct()
+
+		buf := &bytes.Buffer{}
+		serializer.diskstore.(*mocks.DiskStore).On("OpenVectorPartyFileForWrite",
+			serializer.table, serializer.columnID, serializer.shard,
+			serializer.batchID, serializer.batchVersion, serializer.seqNum).
+			Return(&utils.ClosableBuffer{Buffer: buf}, nil)
+		Ω(serializer.WriteVectorParty(vp)).Should(BeNil())
+		encodedBytes := buf.Len()
+
+		buf.Reset()
+		serializer.dictionaryEncoding = false
+		Ω(serializer.WriteVectorParty(vp)).Should(BeNil())
+		Ω(encodedBytes).Should(BeNumerically("<", buf.Len()))
+	})
+
+	ginkgo.It("packs and unpacks codes", func() {
+		for _, codeBits := range dictionaryCodeBits {
+			codes := make([]uint16, 100)
+			for i := range codes {
+				if codeBits > 0 {
+					codes[i] = uint16(uint32(i*7) % (1 << codeBits))
+				}
+			}
+			packed := packDictionaryCodes(codes, codeBits)
+			Ω(packed).Should(HaveLen(getPackedDictionaryCodeBytes(len(codes), codeBits)))
+			for i, code := range codes {
+				Ω(unpackDictionaryCode(packed, codeBits, i)).Should(Equal(code))
+			}
+		}
+		Ω(getDictionaryCodeBits(1)).Should(BeEquivalentTo(0))
+		Ω(getDictionaryCodeBits(3)).Should(BeEquivalentTo(2))
+		Ω(getDictionaryCodeBits(256)).Should(BeEquivalentTo(8))
+		Ω(getDictionaryCodeBits(257)).Should(BeEquivalentTo(16))
+	})
+})
@@ -24,6 +24,17 @@ import (
 // VectorPartyHeader is the magic header written into the beginning of each vector party file.
 const VectorPartyHeader uint32 = 0xFADEFACE
 
+// vectorPartyHeaderBytes is the size of the vector party file header preceding the vectors.
+const vectorPartyHeaderBytes = 24
+
+const (
+	// VectorPartyFormatV1 is the vector party file format storing vectors as they are in memory.
+	VectorPartyFormatV1 uint16 = 1
+	// VectorPartyFormatV2 is the vector party file format storing values of enum columns as
+	// bit packed codes into a dictionary of enum ids present in the batch.
+	VectorPartyFormatV2 uint16 = 2
+)
+
 // VectorPartyBaseSerializer is the base class contains basic data to read/write VectorParty
 type vectorPartyBaseSerializer struct {
 	shard, columnID, batchID int
@@ -56,6 +67,8 @@ func (s *vectorPartyBaseSerializer) CheckVectorPartySerializable(vp common.Vecto
 // VectorPartyArchiveSerializer is the class to read/write archive VectorParty
 type vectorPartyArchiveSerializer struct {
 	vectorPartyBaseSerializer
+	// whether to write enum vector parties with dictionary encoding.
+	dictionaryEncoding bool
 }
 
 // VectorPartyArchiveSerializer is the class to read/write snapshot VectorParty
@@ -68,7 +81,7 @@ type vectorPartySnapshotSerializer struct {
 // NewVectorPartyArchiveSerializer returns a new VectorPartySerializer
 func NewVectorPartyArchiveSerializer(hostMemManager common.HostMemoryManager, diskStore diskstore.DiskStore, table string, shardID int, columnID int, batchID int, batchVersion uint32, seqNum uint32) common.VectorPartySerializer {
 	return &vectorPartyArchiveSerializer{
-		vectorPartyBaseSerializer{
+		vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 			table:             table,
 			shard:             shardID,
 			columnID:          columnID,
@@ -78,6 +91,7 @@ func NewVectorPartyArchiveSerializer(hostMemManager common.HostMemoryManager, di
 			diskstore:         diskStore,
 			hostMemoryManager: hostMemManager,
 		},
+		dictionaryEncoding: utils.GetConfig().DiskStore.EnumDictionaryEncoding,
 	}
 }
 
@@ -128,8 +142,16 @@ func (s *vectorPartyArchiveSerializer) WriteVectorParty(vp common.VectorParty) e
 	if err != nil {
 		return err
 	}
-	defer writerCloser.Close()
-	return vp.Write(writerCloser)
+	if dvp, ok := vp.(dictionaryEncodedWriter); ok && s.dictionaryEncoding {
+		err = dvp.WriteDictionaryEncoded(writerCloser)
+	} else {
+		err = vp.Write(writerCloser)
+	}
+	// the file is only durable once closed without error.
+	if closeErr := writerCloser.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // ReportVectorPartyMemoryUsage report memory usage according to underneath VectorParty property
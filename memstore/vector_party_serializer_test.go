@@ -18,6 +18,7 @@ import (
 	"io"
 
 	"bytes"
+	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/diskstore/mocks"
 	"github.com/uber/aresdb/memstore/common"
 	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
@@ -41,7 +42,7 @@ var _ = ginkgo.Describe("vector party serializer", func() {
 
 	ginkgo.BeforeEach(func() {
 		serializer = &vectorPartyArchiveSerializer{
-			vectorPartyBaseSerializer{
+			vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 				table:             "test",
 				diskstore:         new(mocks.DiskStore),
 				hostMemoryManager: hostMemoryManager,
@@ -144,6 +145,40 @@ var _ = ginkgo.Describe("vector party serializer", func() {
 		Ω(mode3Int8.Equals(newVP)).Should(BeTrue())
 	})
 
+	ginkgo.It("ReadMapped should work for all modes", func() {
+		for _, file := range []string{"serializer/mode0_int8", "serializer/mode1_bool",
+			"serializer/mode2_int8", "serializer/mode3_int8"} {
+			vp, err := GetFactory().ReadArchiveVectorParty(file, nil)
+			Ω(err).Should(BeNil())
+
+			buf.Reset()
+			Ω(serializer.WriteVectorParty(vp)).Should(BeNil())
+			data := append([]byte{}, buf.Bytes()...)
+
+			newVP := &cVectorParty{}
+			Ω(newVP.ReadMapped(data, serializer)).Should(BeNil())
+			Ω(vp.Equals(newVP)).Should(BeTrue())
+			if newVP.values != nil {
+				Ω(newVP.values.mapped).Should(BeTrue())
+			}
+			newVP.SafeDestruct()
+			vp.SafeDestruct()
+		}
+	})
+
+	ginkgo.It("ReadMapped should fail for truncated files", func() {
+		mode2Int8, err := GetFactory().ReadArchiveVectorParty("serializer/mode2_int8", nil)
+		Ω(err).Should(BeNil())
+		defer mode2Int8.SafeDestruct()
+
+		Ω(serializer.WriteVectorParty(mode2Int8)).Should(BeNil())
+		data := buf.Bytes()
+		newVP := &cVectorParty{}
+		err = newVP.ReadMapped(data[:len(data)-1], serializer)
+		Ω(diskstore.IsCorruptionError(err)).Should(BeTrue())
+		Ω(newVP.values).Should(BeNil())
+	})
+
 	ginkgo.AfterEach(func() {
 	})
 
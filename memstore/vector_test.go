@@ -487,4 +487,11 @@ var _ = ginkgo.Describe("vector", func() {
 		}
 		Ω(v1.Compare(v2)).Should(BeEquivalentTo(1))
 	})
+
+	ginkgo.It("newMappedVector should work with empty data", func() {
+		vector := newMappedVector(common.Uint32, 0, []byte{})
+		Ω(vector.Bytes).Should(Equal(0))
+		Ω(vector.buffer).Should(BeZero())
+		vector.SafeDestruct()
+	})
 })
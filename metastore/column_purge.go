@@ -0,0 +1,97 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"time"
+
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// ColumnPurgeActor is the actor recorded in schema history for deletions of deprecated columns.
+const ColumnPurgeActor = "columnPurge"
+
+// ColumnPurgeJob is a job that periodically deletes deprecated columns once their purge time is
+// reached, data of deleted columns is then purged from live store and archive batches by memstore.
+// It should only run where table schemas are managed locally instead of by controller.
+type ColumnPurgeJob struct {
+	intervalInSeconds int
+	schemaMutator     common.TableSchemaMutator
+	stopChan          chan struct{}
+}
+
+// NewColumnPurgeJob creates a new ColumnPurgeJob
+func NewColumnPurgeJob(intervalInSeconds int, schemaMutator common.TableSchemaMutator) *ColumnPurgeJob {
+	return &ColumnPurgeJob{
+		intervalInSeconds: intervalInSeconds,
+		schemaMutator:     schemaMutator,
+		stopChan:          make(chan struct{}),
+	}
+}
+
+// Run starts the scheduling
+func (j *ColumnPurgeJob) Run() {
+	tickChan := time.NewTicker(time.Second * time.Duration(j.intervalInSeconds)).C
+
+	for {
+		select {
+		case <-tickChan:
+			j.PurgeColumns()
+		case <-j.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the scheduling
+func (j *ColumnPurgeJob) Stop() {
+	close(j.stopChan)
+}
+
+// PurgeColumns deletes deprecated columns of all tables whose purge time is reached. Columns failed
+// to be deleted are retried in the next run.
+func (j *ColumnPurgeJob) PurgeColumns() {
+	tableNames, err := j.schemaMutator.ListTables()
+	if err != nil {
+		utils.GetLogger().With("error", err.Error()).Error("Failed to list tables for column purge")
+		return
+	}
+
+	now := utils.Now().Unix()
+	for _, tableName := range tableNames {
+		table, err := j.schemaMutator.GetTable(tableName)
+		if err != nil {
+			utils.GetLogger().With("table", tableName, "error", err.Error()).Error("Failed to get table for column purge")
+			continue
+		}
+
+		for _, column := range table.Columns {
+			if !column.IsPurgeDue(now) {
+				continue
+			}
+			// the deprecation may be extended concurrently, only delete the column as deprecated in table.
+			if err = j.schemaMutator.ExpectVersion(table.Version).DeleteColumn(tableName, column.Name); err != nil {
+				utils.GetLogger().With("table", tableName, "column", column.Name, "error", err.Error()).Error("Failed to purge deprecated column")
+				break
+			}
+			utils.GetLogger().With("table", tableName, "column", column.Name, "purgeAt", column.PurgeAt).Info("Purged deprecated column")
+			utils.GetRootReporter().GetChildCounter(map[string]string{
+				"table": tableName,
+			}, utils.DeprecatedColumnsPurged).Inc(1)
+			table.Version++
+		}
+	}
+}
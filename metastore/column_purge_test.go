@@ -0,0 +1,83 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("column purge", func() {
+	var dir string
+	var metaStore common.MetaStore
+
+	table := common.Table{
+		Name: "trips",
+		Columns: []common.Column{
+			{Name: "id", Type: common.Uint32},
+			{Name: "fare", Type: common.Float32},
+			{Name: "tips", Type: common.Float32},
+		},
+		PrimaryKeyColumns: []int{0},
+		Config:            DefaultTableConfig,
+	}
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "column_purge_test")
+		Ω(err).Should(BeNil())
+		metaStore, err = NewDiskMetaStore(dir)
+		Ω(err).Should(BeNil())
+
+		newTable := table
+		Ω(metaStore.CreateTable(&newTable)).Should(BeNil())
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("PurgeColumns should only delete deprecated columns due", func() {
+		Ω(metaStore.DeprecateColumn(table.Name, "fare", 1000)).Should(BeNil())
+		Ω(metaStore.DeprecateColumn(table.Name, "tips", 2000)).Should(BeNil())
+
+		job := NewColumnPurgeJob(60, metaStore.WithActor(ColumnPurgeActor))
+		utils.SetCurrentTime(time.Unix(999, 0))
+		job.PurgeColumns()
+		purgedTable, err := metaStore.GetTable(table.Name)
+		Ω(err).Should(BeNil())
+		Ω(purgedTable.Columns[1].Deleted).Should(BeFalse())
+		Ω(purgedTable.Columns[2].Deleted).Should(BeFalse())
+
+		utils.SetCurrentTime(time.Unix(1500, 0))
+		job.PurgeColumns()
+		purgedTable, err = metaStore.GetTable(table.Name)
+		Ω(err).Should(BeNil())
+		Ω(purgedTable.Columns[1].Deleted).Should(BeTrue())
+		Ω(purgedTable.Columns[2].Deleted).Should(BeFalse())
+
+		history, err := metaStore.GetSchemaHistory(table.Name)
+		Ω(err).Should(BeNil())
+		Ω(history[len(history)-1].Actor).Should(Equal(ColumnPurgeActor))
+		Ω(history[len(history)-1].Mutation).Should(Equal(common.SchemaMutationDeleteColumn))
+	})
+})
@@ -14,6 +14,8 @@
 
 package common
 
+import "fmt"
+
 // ColumnConfig defines the schema of a column config that can be mutated by
 // UpdateColumn API call.
 // swagger:model columnConfig
@@ -33,12 +35,44 @@ type ColumnConfig struct {
 	//     High number implies high priority.
 	PreloadingDays int   `json:"preloadingDays,omitempty"`
 	Priority       int64 `json:"priority,omitempty"`
+
+	// EnumCardinalityCap limits the number of enum cases of an enum column including
+	// the reserved overflow case. Once reached, new enum cases are mapped to
+	// EnumOverflowCase instead of being appended to the enum dict. 0 means no limit.
+	// The cap can only be raised once set.
+	EnumCardinalityCap int `json:"enumCardinalityCap,omitempty"`
+}
+
+// EnumOverflowCase is the reserved enum case that new enum cases are mapped to once
+// the enum cardinality cap of the column is reached.
+const EnumOverflowCase = "__OTHER__"
+
+// AdminRole is the caller role allowed to access all columns and force schema changes.
+const AdminRole = "admin"
+
+// MaxBulkEnumCases limits the total number of enum cases of all columns resolved by one bulk
+// enum cases request.
+const MaxBulkEnumCases = 10000
+
+// BulkEnumCases holds enum cases of multiple columns of a table by column name, for bulk enum
+// cases requests. Responses hold enum IDs by column name in the same order instead.
+// swagger:model bulkEnumCases
+type BulkEnumCases struct {
+	EnumCases map[string][]string `json:"enumCases"`
+}
+
+// Count returns the total number of enum cases of all columns.
+func (b BulkEnumCases) Count() (count int) {
+	for _, enumCases := range b.EnumCases {
+		count += len(enumCases)
+	}
+	return
 }
 
 // Column defines the schema of a column from MetaStore.
 // swagger:model column
 type Column struct {
-	// Immutable, columns cannot be renamed.
+	// Columns can only be renamed with the old name kept in Aliases.
 	Name string `json:"name"`
 	// Immutable, columns cannot have their types changed.
 	Type string `json:"type"`
@@ -50,6 +84,11 @@ type Column struct {
 	// should be stored in memstore.
 	DefaultValue *string `json:"defaultValue,omitempty"`
 
+	// Whether to materialize the default value into archive batches created before the column
+	// was added. Queries read the default value for those rows either way. Immutable, only
+	// applies to columns with a default value.
+	BackfillDefault bool `json:"backfillDefault,omitempty"`
+
 	// Whether to compare characters case insensitively for enum columns. It only matters
 	// for ingestion client as it's the place to concert enum strings to enum values.
 	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
@@ -61,14 +100,94 @@ type Column struct {
 	Config ColumnConfig `json:"config,omitempty"`
 
 	// HLLEnabled determines whether a column is enabled for hll cardinality estimation
-	// HLLConfig is immutable
+	// HLLConfig is immutable except precision
 	HLLConfig HLLConfig `json:"hllConfig,omitempty"`
+
+	// Previous names of the column, still accepted by queries and ingestion until they expire.
+	// Aliases can only be added by renaming the column and can be removed any time.
+	Aliases []ColumnAlias `json:"aliases,omitempty"`
+
+	// Deprecated columns are hidden from queries unless explicitly included and still accepted by
+	// ingestion with warnings, until they are deleted with their data purged at PurgeAt.
+	Deprecated bool `json:"deprecated,omitempty"`
+	// Unix time in seconds when the deprecated column is deleted.
+	PurgeAt int64 `json:"purgeAt,omitempty"`
+
+	// Descriptive metadata, mutable.
+	Metadata *SchemaMetadata `json:"metadata,omitempty"`
+}
+
+// IsPII checks whether the column is flagged to hold personally identifiable information.
+func (c *Column) IsPII() bool {
+	return c.Metadata != nil && c.Metadata.PII
+}
+
+// IsAccessibleBy checks whether the column can be queried by a caller of the roles. Columns without
+// allowed roles are accessible by everyone, and admin can access all columns.
+func (c *Column) IsAccessibleBy(roles []string) bool {
+	if c.Metadata == nil || len(c.Metadata.AllowedRoles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if role == AdminRole {
+			return true
+		}
+		for _, allowedRole := range c.Metadata.AllowedRoles {
+			if role == allowedRole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SchemaMetadata describes a table or column for data catalogs and access controls. It does not
+// affect how data is stored or ingested, so it can be changed any time.
+// swagger:model schemaMetadata
+type SchemaMetadata struct {
+	Description string `json:"description,omitempty"`
+	// Team or person owning the table or column.
+	Owner string   `json:"owner,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Whether the column holds personally identifiable information, which should be redacted
+	// or access controlled. Only meaningful for columns.
+	PII bool `json:"pii,omitempty"`
+	// Other metadata by key.
+	Properties map[string]string `json:"properties,omitempty"`
+	// Roles of callers allowed to query the column, the column is accessible by everyone if empty.
+	// Only meaningful for columns.
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+}
+
+// ColumnAlias is a previous name of a renamed column.
+// swagger:model columnAlias
+type ColumnAlias struct {
+	Name string `json:"name"`
+	// Unix time in seconds when the alias stops being accepted.
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// IsExpired checks whether the alias has expired at the given unix time in seconds.
+func (a ColumnAlias) IsExpired(now int64) bool {
+	return now >= a.ExpiresAt
 }
 
+// DefaultHLLPrecision is the precision of hll sketches built by the query engine, which is also
+// the highest precision allowed for columns.
+const DefaultHLLPrecision = 14
+
+// MinHLLPrecision is the lowest hll precision allowed for columns.
+const MinHLLPrecision = 10
+
 // HLLConfig defines hll configuration
 // swagger:model hllConfig
 type HLLConfig struct {
 	IsHLLColumn bool `json:"isHLLColumn,omitempty"`
+	// Number of register index bits of hll sketches for count distinct of the column, 0 means
+	// DefaultHLLPrecision. Lower precision trades accuracy for smaller sketches: the standard error
+	// is 1.04/sqrt(2^precision) while dense sketches take 2^precision bytes, i.e. ~3.3% and 1KB at 10,
+	// ~1.6% and 4KB at 12, ~0.8% and 16KB at 14. Changes only apply to sketches built afterwards.
+	Precision uint8 `json:"precision,omitempty"`
 }
 
 // TableConfig defines the table configurations that can be changed
@@ -121,6 +240,14 @@ type TableConfig struct {
 	SnapshotIntervalMinutes int `json:"snapshotIntervalMinutes,omitempty" validate:"min=1"`
 
 	AllowMissingEventTime bool `json:"allowMissingEventTime,omitempty"`
+
+	// Max bytes of redo logs, snapshots and archive batches of the table on local disk of each host.
+	// Ingestion is rejected when exceeded until retention or purge brings the usage back under.
+	// 0 means unlimited.
+	DiskQuotaBytes int64 `json:"diskQuotaBytes,omitempty" validate:"min=0"`
+
+	// Percentage of DiskQuotaBytes above which a warning alert is fired. 0 means the default.
+	DiskQuotaWarningPercent int `json:"diskQuotaWarningPercent,omitempty" validate:"min=0,max=100"`
 }
 
 // Table defines the schema and configurations of a table from MetaStore.
@@ -146,8 +273,206 @@ type Table struct {
 	// only used for controller managed schema in cluster setting
 	Incarnation int `json:"incarnation"`
 	// Version gets incremented every time when schema is updated
-	// only used for controller managed schema in cluster setting
 	Version int `json:"version"`
+
+	// Descriptive metadata, mutable.
+	Metadata *SchemaMetadata `json:"metadata,omitempty"`
+}
+
+// RedactColumns returns a copy of the table where columns not accessible by a caller of the roles
+// are replaced by deleted placeholders, so that IDs of other columns are kept.
+func (t Table) RedactColumns(roles []string) Table {
+	var columns []Column
+	for columnID, column := range t.Columns {
+		if column.Deleted || column.IsAccessibleBy(roles) {
+			continue
+		}
+		if columns == nil {
+			columns = append([]Column(nil), t.Columns...)
+		}
+		columns[columnID] = Column{Deleted: true}
+	}
+	if columns != nil {
+		t.Columns = columns
+	}
+	return t
+}
+
+// Schema mutations recorded in SchemaChange.
+const (
+	SchemaMutationCreateTable       = "createTable"
+	SchemaMutationUpdateTable       = "updateTable"
+	SchemaMutationUpdateTableConfig = "updateTableConfig"
+	SchemaMutationAddColumn         = "addColumn"
+	SchemaMutationUpdateColumn      = "updateColumn"
+	SchemaMutationDeleteColumn      = "deleteColumn"
+	SchemaMutationRenameColumn      = "renameColumn"
+	SchemaMutationDeleteColumnAlias = "deleteColumnAlias"
+	SchemaMutationDeprecateColumn   = "deprecateColumn"
+
+	SchemaMutationUpdateTableMetadata  = "updateTableMetadata"
+	SchemaMutationUpdateColumnMetadata = "updateColumnMetadata"
+)
+
+// SchemaChange records a mutation of table schema resulting in the version.
+// swagger:model schemaChange
+type SchemaChange struct {
+	Version int `json:"version"`
+	// Unix time in seconds when the change was made.
+	Timestamp int64 `json:"timestamp"`
+	// Who made the change, empty if unknown.
+	Actor    string `json:"actor,omitempty"`
+	Mutation string `json:"mutation"`
+	// Name of the column for column mutations.
+	Column string `json:"column,omitempty"`
+}
+
+// Types of SchemaFieldDiff.
+const (
+	SchemaFieldAdded   = "added"
+	SchemaFieldRemoved = "removed"
+	SchemaFieldChanged = "changed"
+)
+
+// SchemaFieldDiff is a difference of a field between two versions of table schema.
+// swagger:model schemaFieldDiff
+type SchemaFieldDiff struct {
+	// Path of the field in json, e.g. columns[1].config.priority.
+	Field    string      `json:"field"`
+	Type     string      `json:"type"`
+	OldValue interface{} `json:"oldValue,omitempty"`
+	NewValue interface{} `json:"newValue,omitempty"`
+}
+
+// Severities of SchemaViolation.
+const (
+	// Schema changes with errors are always rejected.
+	SchemaViolationError = "error"
+	// Schema changes with warnings are rejected unless forced.
+	SchemaViolationWarning = "warning"
+)
+
+// SchemaViolation is a violation of schema change rules found before the change is persisted.
+// swagger:model schemaViolation
+type SchemaViolation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Name of the violating column, empty for table level violations.
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// SchemaExportFormatVersion is the version of SchemaExport documents produced by this release, bumped
+// on incompatible changes of the document format.
+const SchemaExportFormatVersion = 1
+
+// SchemaExport is a document of the schemas, configs and enum dictionaries of all tables of a
+// namespace, used to mirror schemas from one cluster to another.
+// swagger:model schemaExport
+type SchemaExport struct {
+	FormatVersion int    `json:"formatVersion"`
+	Namespace     string `json:"namespace,omitempty"`
+	// Unix time in seconds when the document was exported.
+	ExportedAt int64         `json:"exportedAt"`
+	Tables     []TableExport `json:"tables"`
+}
+
+// TableExport is a table in SchemaExport.
+// swagger:model tableExport
+type TableExport struct {
+	// Schema of the table including table configs.
+	Table Table `json:"table"`
+	// Enum cases of enum columns by column name, ordered by enum ID.
+	EnumCases map[string][]string `json:"enumCases,omitempty"`
+}
+
+// Modes of schema import.
+const (
+	// Reports what would change without changing anything.
+	SchemaImportDryRun = "dryRun"
+	// Creates missing tables only, existing tables are skipped.
+	SchemaImportCreateOnly = "createOnly"
+	// Creates missing tables and updates existing tables.
+	SchemaImportUpsert = "upsert"
+)
+
+// How enum cases get their IDs in schema import.
+const (
+	// Enum cases get the same IDs as in the exported cluster, tables whose existing enum cases
+	// would get different IDs are not imported.
+	EnumIDsPreserve = "preserve"
+	// Missing enum cases are appended to existing ones and may get IDs different from the
+	// exported cluster.
+	EnumIDsReassign = "reassign"
+)
+
+// Actions of TableImportResult.
+const (
+	TableImportCreate    = "create"
+	TableImportUpdate    = "update"
+	TableImportUnchanged = "unchanged"
+	TableImportSkip      = "skip"
+	TableImportFail      = "fail"
+)
+
+// TableImportResult is the result of importing a table of SchemaExport.
+// swagger:model tableImportResult
+type TableImportResult struct {
+	Table  string `json:"table"`
+	Action string `json:"action"`
+	// Whether the action was applied, false for dry runs.
+	Applied bool `json:"applied"`
+	// Schema differences from the existing table for updates.
+	Diffs []SchemaFieldDiff `json:"diffs,omitempty"`
+	// Number of enum cases added by column name.
+	NewEnumCases map[string]int `json:"newEnumCases,omitempty"`
+	// Why the table failed to be imported.
+	Error string `json:"error,omitempty"`
+}
+
+// TableSchemaVersion identifies the schema of a table, to compare schemas of a table across hosts.
+// swagger:model tableSchemaVersion
+type TableSchemaVersion struct {
+	Incarnation int `json:"incarnation"`
+	Version     int `json:"version"`
+	// Hash of the schema in json, differs if schemas of the same version diverged.
+	Hash string `json:"hash,omitempty"`
+}
+
+// IsBehind checks whether the schema is older than the other by more than maxVersionLag versions.
+func (v TableSchemaVersion) IsBehind(other TableSchemaVersion, maxVersionLag int) bool {
+	if v.Incarnation != other.Incarnation {
+		return v.Incarnation < other.Incarnation
+	}
+	return other.Version-v.Version > maxVersionLag
+}
+
+// String formats the version as incarnation.version.
+func (v TableSchemaVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Incarnation, v.Version)
+}
+
+// ParseTableSchemaVersion parses the version formatted as incarnation.version.
+func ParseTableSchemaVersion(s string) (version TableSchemaVersion, err error) {
+	if _, err = fmt.Sscanf(s, "%d.%d", &version.Incarnation, &version.Version); err != nil {
+		err = fmt.Errorf("invalid schema version %s, expects incarnation.version", s)
+	}
+	return
+}
+
+// SchemaDrift is a table whose local schema differs from the schema in controller.
+// swagger:model schemaDrift
+type SchemaDrift struct {
+	Table string `json:"table"`
+	// Version of the local schema, nil if the table is missing locally.
+	Local *TableSchemaVersion `json:"local,omitempty"`
+	// Version of the schema in controller, nil if the table is deleted in controller.
+	Controller *TableSchemaVersion `json:"controller,omitempty"`
+}
+
+// IsPurgeDue checks whether the deprecated column should be deleted at the given unix time in seconds.
+func (c *Column) IsPurgeDue(now int64) bool {
+	return c.Deprecated && !c.Deleted && now >= c.PurgeAt
 }
 
 // IsEnumColumn checks whether a column is enum column
@@ -155,6 +480,19 @@ func (c *Column) IsEnumColumn() bool {
 	return c.Type == BigEnum || c.Type == SmallEnum
 }
 
+// IsEnumCardinalityCapReached checks whether a new enum case should be mapped to the overflow
+// case instead of being appended to the enum dict with numEnumCases cases, which contains the
+// overflow case if hasOverflowCase. A slot of the cap is always kept for the overflow case.
+func (c *Column) IsEnumCardinalityCapReached(numEnumCases int, hasOverflowCase bool) bool {
+	if c.Config.EnumCardinalityCap <= 0 {
+		return false
+	}
+	if hasOverflowCase {
+		numEnumCases--
+	}
+	return numEnumCases >= c.Config.EnumCardinalityCap-1
+}
+
 // IsOverwriteOnlyDataType checks whether a column is overwrite only
 func (c *Column) IsOverwriteOnlyDataType() bool {
 	switch c.Type {
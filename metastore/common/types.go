@@ -85,6 +85,18 @@ type MetaStore interface {
 	// Get ingestion checkpoint offset, used for kafka like streaming ingestion
 	GetRedoLogCheckpointOffset(table string, shard int) (int64, error)
 
+	// Returns the recorded schema changes of the table ordered by version.
+	GetSchemaHistory(table string) ([]SchemaChange, error)
+
+	// Returns the table schema as of the specified version.
+	GetTableAtVersion(table string, version int) (*Table, error)
+
+	// Returns a TableSchemaMutator recording actor in the schema history for changes made through it.
+	WithActor(actor string) TableSchemaMutator
+
+	// Like WithActor, but also overrides warnings from schema change rules for changes made through it.
+	ForceWithActor(actor string) TableSchemaMutator
+
 	TableSchemaWatchable
 	TableSchemaMutator
 }
@@ -116,11 +128,25 @@ type TableSchemaMutator interface {
 	DeleteTable(name string) error
 	UpdateTableConfig(table string, config TableConfig) error
 	UpdateTable(table Table) error
+	// Replaces the table metadata.
+	UpdateTableMetadata(table string, metadata SchemaMetadata) error
 
 	// A subset of newly added columns can be appended to the end of
 	// ArchivingSortColumns by adding their index in columns to archivingSortColumns
 	AddColumn(table string, column Column, appendToArchivingSortOrder bool) error
 	// Update column config.
 	UpdateColumn(table string, column string, config ColumnConfig) error
+	// Replaces the column metadata.
+	UpdateColumnMetadata(table string, column string, metadata SchemaMetadata) error
 	DeleteColumn(table string, column string) error
+	// Renames the column and keeps its old name as an alias until aliasExpiresAt in unix seconds.
+	RenameColumn(table string, column string, newName string, aliasExpiresAt int64) error
+	// Removes an alias of a renamed column.
+	DeleteColumnAlias(table string, column string, alias string) error
+	// Deprecates the column, which will be deleted once purgeAt in unix seconds is reached.
+	DeprecateColumn(table string, column string, purgeAt int64) error
+
+	// Returns a TableSchemaMutator whose changes to existing tables fail with ErrSchemaVersionConflict
+	// unless the table is at expectedVersion, for read-modify-write callers to retry on the latest schema.
+	ExpectVersion(expectedVersion int) TableSchemaMutator
 }
@@ -47,6 +47,9 @@ const (
 	DefaultMaxRedoLogSize                 = 1 << 30              // 1 GB
 )
 
+// locksDirName is the name of the directory of table lock files under the base path.
+const locksDirName = ".locks"
+
 // DefaultTableConfig represents default table config
 var DefaultTableConfig = common.TableConfig{
 	BatchSize:                DefaultBatchSize,
@@ -63,6 +66,17 @@ var DefaultTableConfig = common.TableConfig{
 	MaxRedoLogFileSize:       DefaultMaxRedoLogSize,
 }
 
+// EffectiveTableConfig returns the table config with unset configs filled by DefaultTableConfig,
+// which is the config tables are actually served with.
+func EffectiveTableConfig(config common.TableConfig) common.TableConfig {
+	effective := DefaultTableConfig
+	// unset configs are omitted in json so they are not overwritten.
+	if configBytes, err := json.Marshal(config); err == nil {
+		json.Unmarshal(configBytes, &effective)
+	}
+	return effective
+}
+
 // disk-based metastore implementation.
 // all validation of user input (eg. table/column name and table/column struct) will be pushed to api layer,
 // which is the earliest point of user input, all schemas inside system will be already valid,
@@ -118,6 +132,12 @@ type diskMetaStore struct {
 	// shardOwnershipDone is used for block waiting for the consumer to finish
 	// processing each ownership change event.
 	shardOwnershipDone <-chan struct{}
+
+	// lockFile acquires the exclusive file lock shared with other processes and returns the function releasing it.
+	// Table files are not locked across processes if nil.
+	lockFile func(path string) (unlock func() error, err error)
+	// rLockFile acquires the shared file lock for readers, it must be set together with lockFile.
+	rLockFile func(path string) (unlock func() error, err error)
 }
 
 // ListTables list existing table names
@@ -134,6 +154,13 @@ func (dm *diskMetaStore) GetTable(name string) (*common.Table, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Hold the shared table lock so that we never observe a schema file being
+	// rewritten by another process.
+	unlockTable, err := dm.rLockTable(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockTable()
 	return dm.readSchemaFile(name)
 }
 
@@ -439,7 +466,11 @@ func (dm *diskMetaStore) WatchShardOwnershipEvents() (events <-chan common.Shard
 // CreateTable creates a new Table,
 // returns
 // 	ErrTableAlreadyExist if table already exists
-func (dm *diskMetaStore) CreateTable(table *common.Table) (err error) {
+func (dm *diskMetaStore) CreateTable(table *common.Table) error {
+	return dm.createTableWith(schemaChangeOptions{}, table)
+}
+
+func (dm *diskMetaStore) createTableWith(opts schemaChangeOptions, table *common.Table) (err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
 
@@ -462,7 +493,7 @@ func (dm *diskMetaStore) CreateTable(table *common.Table) (err error) {
 		return ErrTableAlreadyExist
 	}
 
-	validator := NewTableSchameValidator()
+	validator := dm.newTableSchemaValidator(table.Name, opts.force)
 	validator.SetNewTable(*table)
 	err = validator.Validate()
 	if err != nil {
@@ -476,6 +507,7 @@ func (dm *diskMetaStore) CreateTable(table *common.Table) (err error) {
 	if err = dm.writeSchemaFile(table); err != nil {
 		return err
 	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationCreateTable, "")
 
 	// append enum case for enum column with default value
 	for _, column := range table.Columns {
@@ -493,7 +525,11 @@ func (dm *diskMetaStore) CreateTable(table *common.Table) (err error) {
 // UpdateTable update table configurations
 // return
 //  ErrTableDoesNotExist if table does not exist
-func (dm *diskMetaStore) UpdateTableConfig(tableName string, config common.TableConfig) (err error) {
+func (dm *diskMetaStore) UpdateTableConfig(tableName string, config common.TableConfig) error {
+	return dm.updateTableConfigWith(schemaChangeOptions{}, tableName, config)
+}
+
+func (dm *diskMetaStore) updateTableConfigWith(opts schemaChangeOptions, tableName string, config common.TableConfig) (err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
 
@@ -510,18 +546,85 @@ func (dm *diskMetaStore) UpdateTableConfig(tableName string, config common.Table
 		return err
 	}
 
-	table, err = dm.readSchemaFile(tableName)
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
 	if err != nil {
 		return err
 	}
 
+	// unset configs fall back to defaults, so validate the config tables will be served with.
+	if err = ValidateTableConfig(EffectiveTableConfig(config)); err != nil {
+		return err
+	}
+
+	oldTable := *table
 	table.Config = config
-	return dm.writeSchemaFile(table)
+	table.Version++
+	err = validateSchemaChange(PendingSchemaChange{
+		OldTable: &oldTable,
+		NewTable: table,
+	}, opts.force)
+	if err != nil {
+		return err
+	}
+
+	if err = dm.writeSchemaFile(table); err != nil {
+		return err
+	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationUpdateTableConfig, "")
+	return nil
+}
+
+// UpdateTableMetadata replaces table metadata. Metadata does not affect data of the table, so
+// no schema change rules apply.
+// return
+//  ErrTableDoesNotExist if table does not exist
+func (dm *diskMetaStore) UpdateTableMetadata(tableName string, metadata common.SchemaMetadata) error {
+	return dm.updateTableMetadataWith(schemaChangeOptions{}, tableName, metadata)
 }
 
-// UpdateTable updates table schema and config
-// table passed in should have been validated against existing table schema
-func (dm *diskMetaStore) UpdateTable(table common.Table) (err error) {
+func (dm *diskMetaStore) updateTableMetadataWith(opts schemaChangeOptions, tableName string, metadata common.SchemaMetadata) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	table.Metadata = &metadata
+	table.Version++
+	if err = dm.writeSchemaFile(table); err != nil {
+		return err
+	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationUpdateTableMetadata, "")
+	return nil
+}
+
+// UpdateTable updates table schema and config after validating against existing table schema
+// return
+// 	ErrIllegalSchemaVersion if table version is not greater than the existing one
+func (dm *diskMetaStore) UpdateTable(table common.Table) error {
+	return dm.updateTableWith(schemaChangeOptions{}, table)
+}
+
+func (dm *diskMetaStore) updateTableWith(opts schemaChangeOptions, table common.Table) (err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
 
@@ -534,14 +637,29 @@ func (dm *diskMetaStore) UpdateTable(table common.Table) (err error) {
 	}()
 
 	var existingTable *common.Table
-	existingTable, err = dm.readSchemaFile(table.Name)
+	var unlockTable func()
+	existingTable, unlockTable, err = dm.readSchemaFileForUpdate(opts, table.Name)
+	defer unlockTable()
 	if err != nil {
 		return
 	}
 
+	// keep the version chain of schema history.
+	if table.Version <= existingTable.Version {
+		return ErrIllegalSchemaVersion
+	}
+
+	validator := dm.newTableSchemaValidator(table.Name, opts.force)
+	validator.SetOldTable(*existingTable)
+	validator.SetNewTable(table)
+	if err = validator.Validate(); err != nil {
+		return err
+	}
+
 	if err = dm.writeSchemaFile(&table); err != nil {
 		return err
 	}
+	dm.writeSchemaHistory(&table, opts.actor, common.SchemaMutationUpdateTable, "")
 
 	// append enum case for enum column with default value for new columns
 	for i := len(existingTable.Columns); i < len(table.Columns); i++ {
@@ -584,6 +702,14 @@ func (dm *diskMetaStore) DeleteTable(tableName string) (err error) {
 		return ErrTableDoesNotExist
 	}
 
+	// wait for other processes to finish their read-modify-write of the table files.
+	var unlockTable func()
+	if unlockTable, err = dm.lockTable(tableName); err != nil {
+		return err
+	}
+	defer unlockTable()
+
+	// the lock file is kept since other processes may be waiting on it.
 	if err = dm.removeTable(tableName); err != nil {
 		return err
 	}
@@ -595,7 +721,11 @@ func (dm *diskMetaStore) DeleteTable(tableName string) (err error) {
 // returns
 // 	ErrTableDoesNotExist if table does not exist
 // 	ErrColumnAlreadyExist if column already exists
-func (dm *diskMetaStore) AddColumn(tableName string, column common.Column, appendToArchivingSortOrder bool) (err error) {
+func (dm *diskMetaStore) AddColumn(tableName string, column common.Column, appendToArchivingSortOrder bool) error {
+	return dm.addColumnWith(schemaChangeOptions{}, tableName, column, appendToArchivingSortOrder)
+}
+
+func (dm *diskMetaStore) addColumnWith(opts schemaChangeOptions, tableName string, column common.Column, appendToArchivingSortOrder bool) (err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
 
@@ -612,17 +742,30 @@ func (dm *diskMetaStore) AddColumn(tableName string, column common.Column, appen
 		return err
 	}
 
-	if table, err = dm.readSchemaFile(tableName); err != nil {
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+	if err = dm.addColumn(table, column, appendToArchivingSortOrder, opts.force); err != nil {
 		return err
 	}
-	return dm.addColumn(table, column, appendToArchivingSortOrder)
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationAddColumn, column.Name)
+	return nil
 }
 
 // UpdateColumn deletes a column.
 // return
 // 	ErrTableDoesNotExist if table does not exist.
 // 	ErrColumnDoesNotExist if column does not exist.
-func (dm *diskMetaStore) UpdateColumn(tableName string, columnName string, config common.ColumnConfig) (err error) {
+// 	ErrInvalidEnumCardinalityCap if enum cardinality cap is invalid for the column.
+// 	ErrDecreaseEnumCardinalityCap if enum cardinality cap is lowered or removed.
+func (dm *diskMetaStore) UpdateColumn(tableName string, columnName string, config common.ColumnConfig) error {
+	return dm.updateColumnWith(schemaChangeOptions{}, tableName, columnName, config)
+}
+
+func (dm *diskMetaStore) updateColumnWith(opts schemaChangeOptions, tableName string, columnName string, config common.ColumnConfig) (err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
 
@@ -639,18 +782,148 @@ func (dm *diskMetaStore) UpdateColumn(tableName string, columnName string, confi
 		return err
 	}
 
-	if table, err = dm.readSchemaFile(tableName); err != nil {
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	if err = dm.updateColumn(table, columnName, config, opts.force); err != nil {
 		return err
 	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationUpdateColumn, columnName)
+	return nil
+}
 
-	return dm.updateColumn(table, columnName, config)
+// UpdateColumnMetadata replaces column metadata. Metadata does not affect data of the column,
+// so no schema change rules apply.
+// return
+// 	ErrTableDoesNotExist if table does not exist.
+// 	ErrColumnDoesNotExist if column does not exist.
+func (dm *diskMetaStore) UpdateColumnMetadata(tableName string, columnName string, metadata common.SchemaMetadata) error {
+	return dm.updateColumnMetadataWith(schemaChangeOptions{}, tableName, columnName, metadata)
+}
+
+func (dm *diskMetaStore) updateColumnMetadataWith(opts schemaChangeOptions, tableName string, columnName string, metadata common.SchemaMetadata) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	if err = dm.updateColumnMetadata(table, columnName, metadata); err != nil {
+		return err
+	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationUpdateColumnMetadata, columnName)
+	return nil
 }
 
 // DeleteColumn deletes a column
 // return
 // 	ErrTableDoesNotExist if table not exist
 // 	ErrColumnDoesNotExist if column not exist
-func (dm *diskMetaStore) DeleteColumn(tableName string, columnName string) (err error) {
+func (dm *diskMetaStore) DeleteColumn(tableName string, columnName string) error {
+	return dm.deleteColumnWith(schemaChangeOptions{}, tableName, columnName)
+}
+
+func (dm *diskMetaStore) deleteColumnWith(opts schemaChangeOptions, tableName string, columnName string) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	if err = dm.removeColumn(table, columnName, opts.force); err != nil {
+		return err
+	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationDeleteColumn, columnName)
+	return nil
+}
+
+// RenameColumn renames a column and keeps its old name as an alias until aliasExpiresAt in unix seconds.
+// return
+// 	ErrTableDoesNotExist if table not exist
+// 	ErrColumnDoesNotExist if column not exist
+func (dm *diskMetaStore) RenameColumn(tableName string, columnName string, newName string, aliasExpiresAt int64) error {
+	return dm.renameColumnWith(schemaChangeOptions{}, tableName, columnName, newName, aliasExpiresAt)
+}
+
+func (dm *diskMetaStore) renameColumnWith(opts schemaChangeOptions, tableName string, columnName string, newName string, aliasExpiresAt int64) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	if err = dm.renameColumn(table, columnName, newName, aliasExpiresAt, opts.force); err != nil {
+		return err
+	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationRenameColumn, newName)
+	return nil
+}
+
+// DeleteColumnAlias removes an alias of a renamed column so that the alias is no longer accepted.
+// return
+// 	ErrTableDoesNotExist if table not exist
+// 	ErrColumnDoesNotExist if column not exist
+// 	ErrColumnAliasDoesNotExist if alias not exist
+func (dm *diskMetaStore) DeleteColumnAlias(tableName string, columnName string, alias string) error {
+	return dm.deleteColumnAliasWith(schemaChangeOptions{}, tableName, columnName, alias)
+}
+
+func (dm *diskMetaStore) deleteColumnAliasWith(opts schemaChangeOptions, tableName string, columnName string, alias string) (err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
 
@@ -667,11 +940,59 @@ func (dm *diskMetaStore) DeleteColumn(tableName string, columnName string) (err
 		return err
 	}
 
-	if table, err = dm.readSchemaFile(tableName); err != nil {
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	if err = dm.removeColumnAlias(table, columnName, alias, opts.force); err != nil {
 		return err
 	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationDeleteColumnAlias, columnName)
+	return nil
+}
+
+// DeprecateColumn deprecates a column, which is deleted with its data purged once purgeAt in unix
+// seconds is reached. Deprecating a deprecated column again changes its purge time.
+// return
+// 	ErrTableDoesNotExist if table not exist
+// 	ErrColumnDoesNotExist if column not exist
+// 	ErrIllegalColumnDeprecation if column cannot be deleted
+func (dm *diskMetaStore) DeprecateColumn(tableName string, columnName string, purgeAt int64) error {
+	return dm.deprecateColumnWith(schemaChangeOptions{}, tableName, columnName, purgeAt)
+}
+
+func (dm *diskMetaStore) deprecateColumnWith(opts schemaChangeOptions, tableName string, columnName string, purgeAt int64) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
 
-	return dm.removeColumn(table, columnName)
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	var unlockTable func()
+	table, unlockTable, err = dm.readSchemaFileForUpdate(opts, tableName)
+	defer unlockTable()
+	if err != nil {
+		return err
+	}
+
+	if err = dm.deprecateColumn(table, columnName, purgeAt, opts.force); err != nil {
+		return err
+	}
+	dm.writeSchemaHistory(table, opts.actor, common.SchemaMutationDeprecateColumn, columnName)
+	return nil
 }
 
 // ExtendEnumDict extends enum cases for given table column
@@ -696,10 +1017,17 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 		}
 	}()
 
-	if err = dm.enumColumnExists(table, column); err != nil {
+	var enumColumn *common.Column
+	if enumColumn, err = dm.getEnumColumn(table, column); err != nil {
 		return nil, err
 	}
 
+	var unlockTable func()
+	if unlockTable, err = dm.lockTable(table); err != nil {
+		return nil, err
+	}
+	defer unlockTable()
+
 	existingCases, err = dm.readEnumFile(table, column)
 	if err != nil {
 		return nil, err
@@ -711,17 +1039,32 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 	}
 
 	newEnumID := len(existingCases)
+	numOverflowed := 0
 
 	enumIDs = make([]int, len(enumCases))
 	for index, newCase := range enumCases {
 		if enumID, exist := enumDict[newCase]; exist {
 			enumIDs[index] = enumID
-		} else {
-			enumDict[newCase] = newEnumID
-			newEnumCases = append(newEnumCases, newCase)
-			enumIDs[index] = newEnumID
-			newEnumID++
+			continue
+		}
+
+		_, hasOverflowCase := enumDict[common.EnumOverflowCase]
+		if enumColumn.IsEnumCardinalityCapReached(newEnumID, hasOverflowCase) {
+			numOverflowed++
+			if !hasOverflowCase {
+				logEnumCardinalityCapReached(table, column, enumColumn.Config.EnumCardinalityCap)
+			}
+			newCase = common.EnumOverflowCase
+			if enumID, exist := enumDict[newCase]; exist {
+				enumIDs[index] = enumID
+				continue
+			}
 		}
+
+		enumDict[newCase] = newEnumID
+		newEnumCases = append(newEnumCases, newCase)
+		enumIDs[index] = newEnumID
+		newEnumID++
 	}
 
 	if err = dm.writeEnumFile(table, column, newEnumCases); err != nil {
@@ -733,9 +1076,26 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 		"columnName": column,
 	}, utils.NumberOfEnumCasesPerColumn).Update(float64(newEnumID))
 
+	if numOverflowed > 0 {
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"table":      table,
+			"columnName": column,
+		}, utils.EnumCasesOverflowed).Inc(int64(numOverflowed))
+	}
+
 	return enumIDs, nil
 }
 
+// logEnumCardinalityCapReached logs the first time new enum cases of the column are mapped to
+// the overflow case.
+func logEnumCardinalityCapReached(table, column string, enumCardinalityCap int) {
+	utils.GetLogger().With(
+		"table", table,
+		"column", column,
+		"enumCardinalityCap", enumCardinalityCap,
+	).Error("Enum cardinality cap reached, new enum cases are mapped to the overflow case")
+}
+
 // PurgeArchiveBatches deletes the archive batches' metadata with batchID within [batchIDStart, batchIDEnd)
 func (dm *diskMetaStore) PurgeArchiveBatches(tableName string, shard, batchIDStart, batchIDEnd int) error {
 	dm.Lock()
@@ -811,13 +1171,17 @@ func (dm *diskMetaStore) writeArchiveBatchVersionWithMode(tableName string, shar
 			batchID,
 		)
 	}
-	defer writer.Close()
 
 	if seqNum > 0 {
 		_, err = io.WriteString(writer, fmt.Sprintf("%d-%d,%d\n", version, seqNum, batchSize))
 	} else {
 		_, err = io.WriteString(writer, fmt.Sprintf("%d,%d\n", version, batchSize))
 	}
+	// the batch version flips the active version of the batch, so it must be durable before older versions
+	// are deleted.
+	if closeErr := syncAndClose(writer); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return utils.StackError(err, "Failed to write to batch version file, table: %s, shard: %d, batch: %d",
 			tableName,
@@ -972,9 +1336,12 @@ func (dm *diskMetaStore) listTables() ([]string, error) {
 	if err != nil {
 		return nil, utils.StackError(err, "Failed to list tables")
 	}
-	tableNames := make([]string, len(tableDirs))
-	for id, tableDir := range tableDirs {
-		tableNames[id] = tableDir.Name()
+	tableNames := make([]string, 0, len(tableDirs))
+	for _, tableDir := range tableDirs {
+		if tableDir.Name() == locksDirName {
+			continue
+		}
+		tableNames = append(tableNames, tableDir.Name())
 	}
 	return tableNames, nil
 }
@@ -1001,8 +1368,8 @@ func (dm *diskMetaStore) removeTable(tableName string) error {
 	return nil
 }
 
-func (dm *diskMetaStore) addColumn(table *common.Table, column common.Column, appendToArchivingSortOrder bool) error {
-	validator := NewTableSchameValidator()
+func (dm *diskMetaStore) addColumn(table *common.Table, column common.Column, appendToArchivingSortOrder bool, force bool) error {
+	validator := dm.newTableSchemaValidator(table.Name, force)
 	validator.SetOldTable(*table)
 
 	newColumnID := len(table.Columns)
@@ -1010,6 +1377,7 @@ func (dm *diskMetaStore) addColumn(table *common.Table, column common.Column, ap
 	if appendToArchivingSortOrder {
 		table.ArchivingSortColumns = append(table.ArchivingSortColumns, newColumnID)
 	}
+	table.Version++
 	validator.SetNewTable(*table)
 	err := validator.Validate()
 	if err != nil {
@@ -1028,7 +1396,11 @@ func (dm *diskMetaStore) addColumn(table *common.Table, column common.Column, ap
 	return nil
 }
 
-func (dm *diskMetaStore) updateColumn(table *common.Table, columnName string, config common.ColumnConfig) (err error) {
+func (dm *diskMetaStore) updateColumn(table *common.Table, columnName string, config common.ColumnConfig, force bool) (err error) {
+	validator := dm.newTableSchemaValidator(table.Name, force)
+	validator.SetOldTable(*table)
+	// copy columns to keep the old table intact.
+	table.Columns = append([]common.Column{}, table.Columns...)
 	for id, column := range table.Columns {
 		if column.Name == columnName {
 			if column.Deleted {
@@ -1036,15 +1408,116 @@ func (dm *diskMetaStore) updateColumn(table *common.Table, columnName string, co
 				// with different column id.
 				continue
 			}
+			if config.EnumCardinalityCap < 0 || (config.EnumCardinalityCap > 0 && !column.IsEnumColumn()) {
+				return ErrInvalidEnumCardinalityCap
+			}
+			if err = ValidateEnumCardinalityCapUpdate(column.Config, config); err != nil {
+				return err
+			}
 			column.Config = config
 			table.Columns[id] = column
+			table.Version++
+			validator.SetNewTable(*table)
+			if err = validator.Validate(); err != nil {
+				return err
+			}
+			return dm.writeSchemaFile(table)
+		}
+	}
+	return ErrColumnDoesNotExist
+}
+
+func (dm *diskMetaStore) renameColumn(table *common.Table, columnName, newName string, aliasExpiresAt int64, force bool) error {
+	validator := dm.newTableSchemaValidator(table.Name, force)
+	validator.SetOldTable(*table)
+
+	newTable := *table
+	newTable.Columns = make([]common.Column, len(table.Columns))
+	copy(newTable.Columns, table.Columns)
+	for id, column := range newTable.Columns {
+		if column.Name == columnName && !column.Deleted {
+			column.Name = newName
+			column.Aliases = append(append([]common.ColumnAlias{}, column.Aliases...), common.ColumnAlias{
+				Name:      columnName,
+				ExpiresAt: aliasExpiresAt,
+			})
+			newTable.Columns[id] = column
+			newTable.Version++
+			validator.SetNewTable(newTable)
+			if err := validator.Validate(); err != nil {
+				return err
+			}
+			*table = newTable
 			return dm.writeSchemaFile(table)
 		}
 	}
 	return ErrColumnDoesNotExist
 }
 
-func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string) error {
+func (dm *diskMetaStore) removeColumnAlias(table *common.Table, columnName, alias string, force bool) error {
+	validator := dm.newTableSchemaValidator(table.Name, force)
+	validator.SetOldTable(*table)
+	// copy columns to keep the old table intact.
+	table.Columns = append([]common.Column{}, table.Columns...)
+	for id, column := range table.Columns {
+		if column.Name == columnName && !column.Deleted {
+			for i, columnAlias := range column.Aliases {
+				if columnAlias.Name == alias {
+					column.Aliases = append(append([]common.ColumnAlias{}, column.Aliases[:i]...), column.Aliases[i+1:]...)
+					table.Columns[id] = column
+					table.Version++
+					validator.SetNewTable(*table)
+					if err := validator.Validate(); err != nil {
+						return err
+					}
+					return dm.writeSchemaFile(table)
+				}
+			}
+			return ErrColumnAliasDoesNotExist
+		}
+	}
+	return ErrColumnDoesNotExist
+}
+
+func (dm *diskMetaStore) updateColumnMetadata(table *common.Table, columnName string, metadata common.SchemaMetadata) error {
+	// copy columns to keep the old table intact.
+	table.Columns = append([]common.Column{}, table.Columns...)
+	for id, column := range table.Columns {
+		if column.Name == columnName && !column.Deleted {
+			column.Metadata = &metadata
+			table.Columns[id] = column
+			table.Version++
+			return dm.writeSchemaFile(table)
+		}
+	}
+	return ErrColumnDoesNotExist
+}
+
+func (dm *diskMetaStore) deprecateColumn(table *common.Table, columnName string, purgeAt int64, force bool) error {
+	validator := dm.newTableSchemaValidator(table.Name, force)
+	validator.SetOldTable(*table)
+	// copy columns to keep the old table intact.
+	table.Columns = append([]common.Column{}, table.Columns...)
+	for id, column := range table.Columns {
+		if column.Name == columnName && !column.Deleted {
+			column.Deprecated, column.PurgeAt = true, purgeAt
+			table.Columns[id] = column
+			table.Version++
+			validator.SetNewTable(*table)
+			if err := validator.Validate(); err != nil {
+				return err
+			}
+			return dm.writeSchemaFile(table)
+		}
+	}
+	return ErrColumnDoesNotExist
+}
+
+func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string, force bool) error {
+	validator := dm.newTableSchemaValidator(table.Name, force)
+	validator.SetOldTable(*table)
+	// copy columns to keep the old table intact.
+	table.Columns = append([]common.Column{}, table.Columns...)
 	for id, column := range table.Columns {
 		if column.Name == columnName {
 			if column.Deleted {
@@ -1064,6 +1537,11 @@ func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string) er
 
 			column.Deleted = true
 			table.Columns[id] = column
+			table.Version++
+			validator.SetNewTable(*table)
+			if err := validator.Validate(); err != nil {
+				return err
+			}
 			if err := dm.writeSchemaFile(table); err != nil {
 				return err
 			}
@@ -1078,6 +1556,16 @@ func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string) er
 	return ErrColumnDoesNotExist
 }
 
+// newTableSchemaValidator returns a TableSchemaValidator checking enum cases against enum dicts of the table.
+func (dm *diskMetaStore) newTableSchemaValidator(tableName string, force bool) TableSchemaValidator {
+	return &tableSchemaValidatorImpl{
+		force: force,
+		getEnumCases: func(columnName string) ([]string, error) {
+			return dm.readEnumFile(tableName, columnName)
+		},
+	}
+}
+
 func (dm *diskMetaStore) getTableDirPath(tableName string) string {
 	return filepath.Join(dm.basePath, tableName)
 }
@@ -1094,6 +1582,16 @@ func (dm *diskMetaStore) getSchemaFilePath(tableName string) string {
 	return filepath.Join(dm.getTableDirPath(tableName), "schema")
 }
 
+// getLocksDirPath returns the directory of table lock files, which is kept outside of table directories so
+// that locks outlive tables deleted while other processes are waiting on them.
+func (dm *diskMetaStore) getLocksDirPath() string {
+	return filepath.Join(dm.basePath, locksDirName)
+}
+
+func (dm *diskMetaStore) getTableLockFilePath(tableName string) string {
+	return filepath.Join(dm.getLocksDirPath(), tableName)
+}
+
 func (dm *diskMetaStore) getShardsDirPath(tableName string) string {
 	return filepath.Join(dm.getTableDirPath(tableName), "shards")
 }
@@ -1181,7 +1679,10 @@ func (dm *diskMetaStore) writeEnumFile(tableName, columnName string, enumCases [
 // readSchemaFile reads the schema file for given table.
 func (dm *diskMetaStore) readSchemaFile(tableName string) (*common.Table, error) {
 	jsonBytes, err := dm.ReadFile(dm.getSchemaFilePath(tableName))
-	if err != nil {
+	if os.IsNotExist(err) {
+		// the table may be deleted by another process before its lock is acquired.
+		return nil, ErrTableDoesNotExist
+	} else if err != nil {
 		return nil, utils.StackError(
 			err,
 			"Failed to read schema file, table: %s",
@@ -1203,6 +1704,48 @@ func (dm *diskMetaStore) readSchemaFile(tableName string) (*common.Table, error)
 	return &table, nil
 }
 
+// readSchemaFileForUpdate locks the table and reads its schema for read-modify-write, returns
+// ErrSchemaVersionConflict if the table is not at the version expected by opts. The returned unlock
+// function should be called once the change is written, even if error is returned.
+func (dm *diskMetaStore) readSchemaFileForUpdate(opts schemaChangeOptions, tableName string) (table *common.Table, unlock func(), err error) {
+	if unlock, err = dm.lockTable(tableName); err != nil {
+		return nil, func() {}, err
+	}
+
+	if table, err = dm.readSchemaFile(tableName); err == nil {
+		err = opts.checkTableVersion(table)
+	}
+	return
+}
+
+// lockTable acquires the file lock of the table, which serializes read-modify-write of table files
+// across processes sharing the metastore directory. Returns the function releasing the lock.
+func (dm *diskMetaStore) lockTable(tableName string) (unlock func(), err error) {
+	return dm.acquireTableLock(tableName, dm.lockFile)
+}
+
+// rLockTable acquires the shared file lock of the table for readers of table files.
+func (dm *diskMetaStore) rLockTable(tableName string) (unlock func(), err error) {
+	return dm.acquireTableLock(tableName, dm.rLockFile)
+}
+
+func (dm *diskMetaStore) acquireTableLock(tableName string,
+	lockFile func(path string) (unlock func() error, err error)) (unlock func(), err error) {
+	if lockFile == nil {
+		return func() {}, nil
+	}
+
+	release, err := lockFile(dm.getTableLockFilePath(tableName))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := release(); err != nil {
+			utils.GetLogger().With("table", tableName, "error", err.Error()).Error("Failed to release table lock")
+		}
+	}, nil
+}
+
 // writeSchemaFile reads the schema file for given table.
 func (dm *diskMetaStore) writeSchemaFile(table *common.Table) error {
 	tableSchemaBytes, err := json.MarshalIndent(table, "", "  ")
@@ -1330,9 +1873,25 @@ func (dm *diskMetaStore) writeArchivingCutoff(file string, version uint32) error
 	if err != nil {
 		return utils.StackError(err, "Failed to open version file %s for write", file)
 	}
-	defer writer.Close()
 
 	_, err = io.WriteString(writer, fmt.Sprintf("%d", version))
+	if closeErr := syncAndClose(writer); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncAndClose syncs the writer if it's backed by a file and closes it.
+func syncAndClose(writer io.WriteCloser) error {
+	var err error
+	if syncer, ok := writer.(interface {
+		Sync() error
+	}); ok {
+		err = syncer.Sync()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
 	return err
 }
 
@@ -1411,19 +1970,19 @@ func (dm *diskMetaStore) tableExists(tableName string) error {
 	return nil
 }
 
-// enumColumnExists checks whether column exists and it is a enum column,
-// return ErrTableDoesNotExist, ErrColumnDoesNotExist, ErrNotEnumColumn.
-func (dm *diskMetaStore) enumColumnExists(tableName string, columnName string) error {
+// getEnumColumn returns the column if it exists and it is a enum column,
+// otherwise returns the error same as enumColumnExists.
+func (dm *diskMetaStore) getEnumColumn(tableName string, columnName string) (*common.Column, error) {
 	if err := dm.tableExists(tableName); err != nil {
-		return err
+		return nil, err
 	}
 
 	table, err := dm.readSchemaFile(tableName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, column := range table.Columns {
+	for id, column := range table.Columns {
 		if column.Name == columnName {
 			if column.Deleted {
 				// continue since column name can be reused
@@ -1432,13 +1991,20 @@ func (dm *diskMetaStore) enumColumnExists(tableName string, columnName string) e
 			}
 
 			if !column.IsEnumColumn() {
-				return ErrNotEnumColumn
+				return nil, ErrNotEnumColumn
 			}
 
-			return nil
+			return &table.Columns[id], nil
 		}
 	}
-	return ErrColumnDoesNotExist
+	return nil, ErrColumnDoesNotExist
+}
+
+// enumColumnExists checks whether column exists and it is a enum column,
+// return ErrTableDoesNotExist, ErrColumnDoesNotExist, ErrNotEnumColumn.
+func (dm *diskMetaStore) enumColumnExists(tableName string, columnName string) error {
+	_, err := dm.getEnumColumn(tableName, columnName)
+	return err
 }
 
 // NewDiskMetaStore creates a new disk based metastore
@@ -1449,6 +2015,8 @@ func NewDiskMetaStore(basePath string) (common.MetaStore, error) {
 		writeLock:        sync.Mutex{},
 		enumDictWatchers: make(map[string]map[string]chan<- string),
 		enumDictDone:     make(map[string]map[string]<-chan struct{}),
+		lockFile:         utils.LockFile,
+		rLockFile:        utils.RLockFile,
 	}
 	err := metaStore.MkdirAll(basePath, 0755)
 	if err != nil {
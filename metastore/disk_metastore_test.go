@@ -18,10 +18,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
 	"github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/testing"
 	"github.com/uber/aresdb/utils"
@@ -162,6 +165,8 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	mockFileSystem.On("Stat", "base/c/schema").Return(&mocks.FileInfo{}, nil)
 	mockFileSystem.On("Stat", "base/read_fail/schema").Return(&mocks.FileInfo{}, nil)
 	mockFileSystem.On("Stat", "base/unknown/schema").Return(nil, os.ErrNotExist)
+	mockFileSystem.On("Stat", "base/deleted/schema").Return(&mocks.FileInfo{}, nil)
+	mockFileSystem.On("ReadFile", "base/deleted/schema").Return(nil, os.ErrNotExist)
 	mockFileSystem.On("Stat", "base/error/schema").Return(nil, os.ErrPermission)
 	mockFileSystem.On("Stat", "base/error_shard/schema").Return(nil, nil)
 
@@ -220,6 +225,14 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	mockFileSystem.On("MkdirAll", "base/c/shards/0", os.FileMode(0755)).Return(nil)
 	mockFileSystem.On("MkdirAll", "base/d/shards/0", os.FileMode(0755)).Return(os.ErrPermission)
 
+	historyWriterCloser := &testing.TestReadWriteCloser{}
+	isSchemaHistoryFile := mock.MatchedBy(func(path string) bool {
+		return strings.HasPrefix(path, "base/a/history/") || strings.HasPrefix(path, "base/c/history/")
+	})
+	mockFileSystem.On("MkdirAll", "base/a/history", os.FileMode(0755)).Return(nil)
+	mockFileSystem.On("MkdirAll", "base/c/history", os.FileMode(0755)).Return(nil)
+	mockFileSystem.On("OpenFileForWrite", isSchemaHistoryFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(historyWriterCloser, nil)
+
 	mockFileSystem.On("RemoveAll", "base/b").Return(nil)
 	mockFileSystem.On("Remove", "base/a/enums/column4").Return(nil)
 
@@ -236,6 +249,7 @@ var _ = ginkgo.Describe("disk metastore", func() {
 
 	ginkgo.BeforeEach(func() {
 		mockWriterCloser.Reset()
+		historyWriterCloser.Reset()
 	})
 
 	ginkgo.It("ListTables", func() {
@@ -253,6 +267,27 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(*table).Should(Equal(testTableA))
 		_, err = diskMetaStore.GetTable("unknown")
 		Ω(err).Should(Equal(ErrTableDoesNotExist))
+		// deleted by another process after checked.
+		_, err = diskMetaStore.GetTable("deleted")
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+	})
+
+	ginkgo.It("GetTable and DeleteTable should take table file locks", func() {
+		diskMetaStore := createDiskMetastore("base")
+		var locks []string
+		lockFile := func(kind string) func(path string) (func() error, error) {
+			return func(path string) (func() error, error) {
+				locks = append(locks, kind+":"+path)
+				return func() error { return nil }, nil
+			}
+		}
+		diskMetaStore.lockFile = lockFile("exclusive")
+		diskMetaStore.rLockFile = lockFile("shared")
+
+		_, err := diskMetaStore.GetTable("a")
+		Ω(err).Should(BeNil())
+		Ω(diskMetaStore.DeleteTable(testTableB.Name)).Should(BeNil())
+		Ω(locks).Should(Equal([]string{"shared:base/.locks/a", "exclusive:base/.locks/b"}))
 	})
 
 	ginkgo.It("GetEnumDict", func() {
@@ -495,6 +530,77 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(newTableA.Name).Should(Equal(testTableA.Name))
 		Ω(newTableA.Columns).Should(Equal([]common.Column{testColumn0, testColumn1, testColumn3, testColumn4, testColumn5, testColumn2}))
 		Ω(newTableA.ArchivingSortColumns).Should(Equal([]int{2, 5}))
+		Ω(newTableA.Version).Should(Equal(testTableA.Version + 1))
+	})
+
+	ginkgo.It("should reject changes based on stale versions", func() {
+		diskMetaStore := createDiskMetastore("base")
+		err := diskMetaStore.ExpectVersion(testTableA.Version+1).AddColumn(testTableA.Name, testColumn2, true)
+		Ω(err).Should(Equal(ErrSchemaVersionConflict))
+
+		err = diskMetaStore.WithActor("user1").ExpectVersion(testTableA.Version-1).UpdateTableConfig(testTableA.Name, common.TableConfig{})
+		Ω(err).Should(Equal(ErrSchemaVersionConflict))
+
+		err = diskMetaStore.ExpectVersion(testTableA.Version).AddColumn(testTableA.Name, testColumn2, true)
+		Ω(err).Should(BeNil())
+		var newTableA common.Table
+		json.Unmarshal(mockWriterCloser.Bytes(), &newTableA)
+		Ω(newTableA.Version).Should(Equal(testTableA.Version + 1))
+	})
+
+	ginkgo.It("should not lose updates from concurrent writers", func() {
+		basePath := "/tmp/ares_testdir_concurrent"
+		defer os.RemoveAll(basePath)
+		// two metastores simulate processes sharing the directory.
+		metaStore1, err := NewDiskMetaStore(basePath)
+		Ω(err).Should(BeNil())
+		metaStore2, err := NewDiskMetaStore(basePath)
+		Ω(err).Should(BeNil())
+
+		table := common.Table{
+			Name:              "concurrent",
+			Columns:           []common.Column{{Name: "col0", Type: common.Uint32}},
+			PrimaryKeyColumns: []int{0},
+			Config:            DefaultTableConfig,
+		}
+		Ω(metaStore1.CreateTable(&table)).Should(BeNil())
+
+		// stale writes are rejected.
+		staleTable := table
+		staleTable.Version = table.Version + 2
+		Ω(metaStore1.ExpectVersion(table.Version + 1).UpdateTable(staleTable)).Should(Equal(ErrSchemaVersionConflict))
+
+		numWriters := 4
+		var wg sync.WaitGroup
+		for i := 0; i < numWriters; i++ {
+			metaStore := metaStore1
+			if i%2 == 1 {
+				metaStore = metaStore2
+			}
+			column := common.Column{Name: fmt.Sprintf("writer%d", i), Type: common.Uint32}
+			wg.Add(1)
+			go func() {
+				defer ginkgo.GinkgoRecover()
+				defer wg.Done()
+				err := RetryOnVersionConflict(func() error {
+					latestTable, err := metaStore.GetTable(table.Name)
+					if err != nil {
+						return err
+					}
+					expectedVersion := latestTable.Version
+					latestTable.Columns = append(latestTable.Columns, column)
+					latestTable.Version++
+					return metaStore.ExpectVersion(expectedVersion).UpdateTable(*latestTable)
+				})
+				Ω(err).Should(BeNil())
+			}()
+		}
+		wg.Wait()
+
+		newTable, err := metaStore1.GetTable(table.Name)
+		Ω(err).Should(BeNil())
+		Ω(newTable.Version).Should(Equal(table.Version + numWriters))
+		Ω(newTable.Columns).Should(HaveLen(numWriters + 1))
 	})
 
 	ginkgo.It("AddEnumColumnWithDefaultValue", func() {
@@ -557,6 +663,74 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		}
 	})
 
+	ginkgo.It("RenameColumn", func() {
+		diskMetaStore := createDiskMetastore("base")
+		err := diskMetaStore.RenameColumn("unknown", testColumn3.Name, "column3New", 1000)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		err = diskMetaStore.RenameColumn(testTableA.Name, testColumn5.Name, "column5New", 1000)
+		Ω(err).Should(Equal(ErrColumnDoesNotExist))
+
+		err = diskMetaStore.RenameColumn(testTableA.Name, testColumn1.Name, "column1New", 1000)
+		Ω(err).Should(Equal(ErrRenameEnumColumn))
+
+		err = diskMetaStore.RenameColumn(testTableA.Name, testColumn3.Name, testColumn0.Name, 1000)
+		Ω(err).Should(Equal(ErrDuplicatedColumnName))
+
+		err = diskMetaStore.RenameColumn(testTableA.Name, testColumn3.Name, "column3New", 1000)
+		Ω(err).Should(BeNil())
+
+		var newTableA common.Table
+		json.Unmarshal(mockWriterCloser.Bytes(), &newTableA)
+		Ω(newTableA.Columns[2].Name).Should(Equal("column3New"))
+		Ω(newTableA.Columns[2].Aliases).Should(Equal([]common.ColumnAlias{{Name: testColumn3.Name, ExpiresAt: 1000}}))
+		Ω(newTableA.Version).Should(Equal(testTableA.Version + 1))
+	})
+
+	ginkgo.It("DeleteColumnAlias", func() {
+		diskMetaStore := createDiskMetastore("base")
+		err := diskMetaStore.DeleteColumnAlias("unknown", testColumn3.Name, "alias")
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		err = diskMetaStore.DeleteColumnAlias(testTableA.Name, "unknown", "alias")
+		Ω(err).Should(Equal(ErrColumnDoesNotExist))
+
+		err = diskMetaStore.DeleteColumnAlias(testTableA.Name, testColumn3.Name, "alias")
+		Ω(err).Should(Equal(ErrColumnAliasDoesNotExist))
+	})
+
+	ginkgo.It("DeprecateColumn", func() {
+		diskMetaStore := createDiskMetastore("base")
+		err := diskMetaStore.DeprecateColumn("unknown", testColumn4.Name, 1000)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		err = diskMetaStore.DeprecateColumn(testTableA.Name, testColumn5.Name, 1000)
+		Ω(err).Should(Equal(ErrColumnDoesNotExist))
+
+		err = diskMetaStore.DeprecateColumn(testTableA.Name, testColumn0.Name, 1000)
+		Ω(err).Should(Equal(ErrIllegalColumnDeprecation))
+
+		err = diskMetaStore.DeprecateColumn(testTableA.Name, testColumn1.Name, 1000)
+		Ω(err).Should(Equal(ErrIllegalColumnDeprecation))
+
+		err = diskMetaStore.DeprecateColumn(testTableA.Name, testColumn3.Name, 1000)
+		Ω(err).Should(Equal(ErrIllegalColumnDeprecation))
+
+		err = diskMetaStore.DeprecateColumn(testTableA.Name, testColumn4.Name, 0)
+		Ω(err).Should(Equal(ErrIllegalColumnDeprecation))
+
+		err = diskMetaStore.DeprecateColumn(testTableA.Name, testColumn4.Name, 1000)
+		Ω(err).Should(BeNil())
+
+		var newTableA common.Table
+		json.Unmarshal(mockWriterCloser.Bytes(), &newTableA)
+		Ω(newTableA.Columns[3].Deprecated).Should(BeTrue())
+		Ω(newTableA.Columns[3].PurgeAt).Should(BeEquivalentTo(1000))
+		Ω(newTableA.Columns[3].IsPurgeDue(999)).Should(BeFalse())
+		Ω(newTableA.Columns[3].IsPurgeDue(1000)).Should(BeTrue())
+		Ω(newTableA.Version).Should(Equal(testTableA.Version + 1))
+	})
+
 	ginkgo.It("UpdateColumn", func() {
 		diskMetaStore := createDiskMetastore("base")
 		err := diskMetaStore.UpdateColumn("unknown", testColumn1.Name, testColumnConfig1)
@@ -600,6 +774,47 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(enumIDs).Should(Equal([]int{2, 3}))
 	})
 
+	ginkgo.It("ExtendEnumDict with enum cardinality cap", func() {
+		cappedColumn := testColumn1
+		cappedColumn.Config.EnumCardinalityCap = 4
+		testTableE := common.Table{
+			Name:              "e",
+			Columns:           []common.Column{cappedColumn},
+			PrimaryKeyColumns: []int{0},
+			Config:            DefaultTableConfig,
+		}
+		testTableEBytes, _ := json.MarshalIndent(testTableE, "", "  ")
+
+		fileSystem := &mocks.FileSystem{}
+		fileSystem.On("Stat", "base/e/schema").Return(&mocks.FileInfo{}, nil)
+		fileSystem.On("ReadFile", "base/e/schema").Return(testTableEBytes, nil)
+		fileSystem.On("ReadFile", "base/e/enums/column1").Return([]byte(fmt.Sprintf("foo%sbar", common.EnumDelimiter)), nil)
+		fileSystem.On("MkdirAll", "base/e/enums", os.FileMode(0755)).Return(nil)
+		fileSystem.On("OpenFileForWrite", "base/e/enums/column1", os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil)
+		diskMetaStore := createDiskMetastore("base")
+		diskMetaStore.FileSystem = fileSystem
+
+		// one slot of the cap is kept for the overflow case.
+		enumIDs, err := diskMetaStore.ExtendEnumDict(testTableE.Name, cappedColumn.Name, []string{"hello", "world", "foo", "again"})
+		Ω(err).Should(BeNil())
+		Ω(enumIDs).Should(Equal([]int{2, 3, 0, 3}))
+		Ω(mockWriterCloser.Bytes()).Should(Equal([]byte(fmt.Sprintf("hello%s%s%s",
+			common.EnumDelimiter, common.EnumOverflowCase, common.EnumDelimiter))))
+
+		config := cappedColumn.Config
+		config.EnumCardinalityCap = 3
+		err = diskMetaStore.UpdateColumn(testTableE.Name, cappedColumn.Name, config)
+		Ω(err).Should(Equal(ErrDecreaseEnumCardinalityCap))
+		config.EnumCardinalityCap = 0
+		err = diskMetaStore.UpdateColumn(testTableE.Name, cappedColumn.Name, config)
+		Ω(err).Should(Equal(ErrDecreaseEnumCardinalityCap))
+
+		config = testColumnConfig1
+		config.EnumCardinalityCap = 4
+		err = createDiskMetastore("base").UpdateColumn(testTableA.Name, testColumn0.Name, config)
+		Ω(err).Should(Equal(ErrInvalidEnumCardinalityCap))
+	})
+
 	ginkgo.It("AddArchiveBatchVersion: seqNum is 0", func() {
 		diskMetaStore := createDiskMetastore("base")
 		// seqNum is 0
@@ -640,10 +855,19 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	ginkgo.It("UpdataTable", func() {
 		diskMetaStore := createDiskMetastore("base")
 
-		// should work without watchers
+		// should not overwrite the same or newer version
 		err := diskMetaStore.UpdateTable(testTableC)
+		Ω(err).Should(Equal(ErrIllegalSchemaVersion))
+		Ω(mockWriterCloser.Bytes()).Should(BeEmpty())
+
+		newTableC := testTableC
+		newTableC.Version++
+		newTableCBytes, _ := json.MarshalIndent(newTableC, "", "  ")
+
+		// should work without watchers
+		err = diskMetaStore.UpdateTable(newTableC)
 		Ω(err).Should(BeNil())
-		Ω(mockWriterCloser.Bytes()).Should(Equal(testTableCBytes))
+		Ω(mockWriterCloser.Bytes()).Should(Equal(newTableCBytes))
 		mockWriterCloser.Reset()
 
 		// watch schema change
@@ -655,12 +879,55 @@ var _ = ginkgo.Describe("disk metastore", func() {
 			done <- struct{}{}
 		}()
 
-		err = diskMetaStore.UpdateTable(testTableC)
+		err = diskMetaStore.UpdateTable(newTableC)
 		Ω(err).Should(BeNil())
-		Ω(mockWriterCloser.Bytes()).Should(Equal(testTableCBytes))
+		Ω(mockWriterCloser.Bytes()).Should(Equal(newTableCBytes))
 
 		// watcher should got the change before UpdataTable return
-		Ω(*schemaEvent).Should(Equal(testTableC))
+		Ω(*schemaEvent).Should(Equal(newTableC))
+	})
+
+	ginkgo.It("UpdateTableMetadata", func() {
+		diskMetaStore := createDiskMetastore("base")
+		metadata := common.SchemaMetadata{
+			Description: "trips of riders",
+			Owner:       "marketplace",
+			Tags:        []string{"core"},
+			Properties:  map[string]string{"tier": "1"},
+		}
+		err := diskMetaStore.UpdateTableMetadata("unknown", metadata)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		err = diskMetaStore.UpdateTableMetadata(testTableA.Name, metadata)
+		Ω(err).Should(BeNil())
+
+		var newTable common.Table
+		err = json.Unmarshal(mockWriterCloser.Bytes(), &newTable)
+		Ω(err).Should(BeNil())
+		Ω(*newTable.Metadata).Should(Equal(metadata))
+		Ω(newTable.Columns).Should(Equal(testTableA.Columns))
+		Ω(newTable.Version).Should(Equal(testTableA.Version + 1))
+	})
+
+	ginkgo.It("UpdateColumnMetadata", func() {
+		diskMetaStore := createDiskMetastore("base")
+		metadata := common.SchemaMetadata{Description: "rider email", PII: true}
+		err := diskMetaStore.UpdateColumnMetadata("unknown", testColumn3.Name, metadata)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		err = diskMetaStore.UpdateColumnMetadata(testTableA.Name, testColumn5.Name, metadata)
+		Ω(err).Should(Equal(ErrColumnDoesNotExist))
+
+		err = diskMetaStore.UpdateColumnMetadata(testTableA.Name, testColumn3.Name, metadata)
+		Ω(err).Should(BeNil())
+
+		var newTable common.Table
+		err = json.Unmarshal(mockWriterCloser.Bytes(), &newTable)
+		Ω(err).Should(BeNil())
+		Ω(*newTable.Columns[2].Metadata).Should(Equal(metadata))
+		Ω(newTable.Columns[2].IsPII()).Should(BeTrue())
+		Ω(newTable.Columns[3].IsPII()).Should(BeFalse())
+		Ω(newTable.Version).Should(Equal(testTableA.Version + 1))
 	})
 
 	ginkgo.It("UpdateTableConfig", func() {
@@ -682,6 +949,18 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		err = json.Unmarshal(mockWriterCloser.Bytes(), &newTable)
 		Ω(err).Should(BeNil())
 		Ω(newTable.Config).Should(Equal(updateConfig))
+
+		// retention shorter than the default archiving delay.
+		err = diskMetaStore.UpdateTableConfig(testTableA.Name, common.TableConfig{
+			RecordRetentionInDays: 1,
+			ArchivingDelayMinutes: 2 * 24 * 60,
+		})
+		Ω(err).Should(Equal(ErrRetentionShorterThanArchivingDelay))
+
+		err = diskMetaStore.UpdateTableConfig(testTableA.Name, common.TableConfig{
+			BackfillThresholdInBytes: DefaultBackfillMaxBufferSize + 1,
+		})
+		Ω(err).Should(Equal(ErrBackfillThresholdExceedsMaxBufferSize))
 	})
 
 	ginkgo.It("PurgeArchiveBatches", func() {
@@ -798,6 +1077,25 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(err).ShouldNot(BeNil())
 	})
 
+	ginkgo.It("should keep table lock files out of tables", func() {
+		basePath := "/tmp/ares_testlocks"
+		defer os.RemoveAll(basePath)
+		metaStore, err := NewDiskMetaStore(basePath)
+		Ω(err).Should(BeNil())
+		diskMetaStore := metaStore.(*diskMetaStore)
+
+		unlock, err := diskMetaStore.lockTable("a")
+		Ω(err).Should(BeNil())
+		unlock()
+		_, err = os.Stat(filepath.Join(basePath, ".locks", "a"))
+		Ω(err).Should(BeNil())
+		tables, err := diskMetaStore.ListTables()
+		Ω(err).Should(BeNil())
+		Ω(tables).Should(BeEmpty())
+		_, err = diskMetaStore.GetTable("a")
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+	})
+
 	ginkgo.It("NewDiskMetaStore", func() {
 		diskMetaStore, err := NewDiskMetaStore("/tmp/ares_testdir")
 		Ω(err).Should(BeNil())
@@ -77,4 +77,34 @@ var (
 	ErrHLLColumnDoesNotAllowDefaultValue = errors.New("hll column does not allow default value")
 	ErrInvalidTableBatchSize             = errors.New("Table batch size should be larger than zero")
 	ErrInvalidPrimaryKeyBucketSize       = errors.New("Table primary key bucket size should be larger than zero")
+	// ErrIllegalColumnAlias indicates column aliases not from renaming or conflicting with other names
+	ErrIllegalColumnAlias = errors.New("Illegal column alias")
+	// ErrRenameEnumColumn indicates enum columns cannot be renamed since enum cases are stored by column name
+	ErrRenameEnumColumn = errors.New("Enum column cannot be renamed")
+	// ErrColumnAliasDoesNotExist indicates column alias does not exist
+	ErrColumnAliasDoesNotExist = errors.New("Column alias does not exist")
+	// ErrBackfillDefaultWithoutDefaultValue indicates default value backfill requested for column without default value
+	ErrBackfillDefaultWithoutDefaultValue = errors.New("Backfilling default value requires a default value")
+	// ErrSchemaVersionDoesNotExist indicates the schema version of the table is not recorded
+	ErrSchemaVersionDoesNotExist = errors.New("Schema version does not exist")
+	// ErrInvalidEnumCardinalityCap indicates negative enum cardinality cap or cap set for non enum column
+	ErrInvalidEnumCardinalityCap = errors.New("Enum cardinality cap should be non negative and only set for enum columns")
+	// ErrDecreaseEnumCardinalityCap indicates attempt to lower or remove the enum cardinality cap of a column
+	ErrDecreaseEnumCardinalityCap = errors.New("Enum cardinality cap can only be raised")
+	// ErrRetentionShorterThanArchivingDelay indicates records would be out of retention before being archived
+	ErrRetentionShorterThanArchivingDelay = errors.New("Record retention should not be shorter than archiving delay")
+	// ErrBackfillThresholdExceedsMaxBufferSize indicates backfill would never be triggered before the buffer is full
+	ErrBackfillThresholdExceedsMaxBufferSize = errors.New("Backfill threshold should not exceed backfill max buffer size")
+	// ErrSchemaVersionConflict indicates the table was changed concurrently since the version the change was based on
+	ErrSchemaVersionConflict = errors.New("Table schema was changed concurrently, please retry on the latest version")
+	// ErrUnsupportedSchemaExportFormat indicates the schema export document is of a newer or unknown format
+	ErrUnsupportedSchemaExportFormat = errors.New("Unsupported schema export format version")
+	// ErrInvalidSchemaImportMode indicates unknown schema import mode or enum ID mode
+	ErrInvalidSchemaImportMode = errors.New("Invalid schema import mode or enum ID mode")
+	// ErrIllegalColumnDeprecation indicates deprecation of columns that cannot be deleted or without purge time
+	ErrIllegalColumnDeprecation = errors.New("Time, primary key and archiving sort columns cannot be deprecated, deprecated columns require purge time")
+	// ErrEnumIDsNotPreservable indicates existing enum cases would get IDs different from the exported ones
+	ErrEnumIDsNotPreservable = errors.New("Existing enum cases conflict with exported enum IDs")
+	// ErrSchemaFetchJobStopped indicates schema refresh requested after the schema fetch job is stopped
+	ErrSchemaFetchJobStopped = errors.New("Schema fetch job is stopped")
 )
@@ -52,6 +52,20 @@ func (_m *TableSchemaMutator) DeleteColumn(table string, column string) error {
 	return r0
 }
 
+// DeleteColumnAlias provides a mock function with given fields: table, column, alias
+func (_m *TableSchemaMutator) DeleteColumnAlias(table string, column string, alias string) error {
+	ret := _m.Called(table, column, alias)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(table, column, alias)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteTable provides a mock function with given fields: name
 func (_m *TableSchemaMutator) DeleteTable(name string) error {
 	ret := _m.Called(name)
@@ -66,6 +80,36 @@ func (_m *TableSchemaMutator) DeleteTable(name string) error {
 	return r0
 }
 
+// DeprecateColumn provides a mock function with given fields: table, column, purgeAt
+func (_m *TableSchemaMutator) DeprecateColumn(table string, column string, purgeAt int64) error {
+	ret := _m.Called(table, column, purgeAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, int64) error); ok {
+		r0 = rf(table, column, purgeAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExpectVersion provides a mock function with given fields: expectedVersion
+func (_m *TableSchemaMutator) ExpectVersion(expectedVersion int) common.TableSchemaMutator {
+	ret := _m.Called(expectedVersion)
+
+	var r0 common.TableSchemaMutator
+	if rf, ok := ret.Get(0).(func(int) common.TableSchemaMutator); ok {
+		r0 = rf(expectedVersion)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(common.TableSchemaMutator)
+		}
+	}
+
+	return r0
+}
+
 // GetTable provides a mock function with given fields: name
 func (_m *TableSchemaMutator) GetTable(name string) (*common.Table, error) {
 	ret := _m.Called(name)
@@ -112,6 +156,20 @@ func (_m *TableSchemaMutator) ListTables() ([]string, error) {
 	return r0, r1
 }
 
+// RenameColumn provides a mock function with given fields: table, column, newName, aliasExpiresAt
+func (_m *TableSchemaMutator) RenameColumn(table string, column string, newName string, aliasExpiresAt int64) error {
+	ret := _m.Called(table, column, newName, aliasExpiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string, int64) error); ok {
+		r0 = rf(table, column, newName, aliasExpiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateColumn provides a mock function with given fields: table, column, config
 func (_m *TableSchemaMutator) UpdateColumn(table string, column string, config common.ColumnConfig) error {
 	ret := _m.Called(table, column, config)
@@ -126,6 +184,20 @@ func (_m *TableSchemaMutator) UpdateColumn(table string, column string, config c
 	return r0
 }
 
+// UpdateColumnMetadata provides a mock function with given fields: table, column, metadata
+func (_m *TableSchemaMutator) UpdateColumnMetadata(table string, column string, metadata common.SchemaMetadata) error {
+	ret := _m.Called(table, column, metadata)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, common.SchemaMetadata) error); ok {
+		r0 = rf(table, column, metadata)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateTable provides a mock function with given fields: table
 func (_m *TableSchemaMutator) UpdateTable(table common.Table) error {
 	ret := _m.Called(table)
@@ -153,3 +225,17 @@ func (_m *TableSchemaMutator) UpdateTableConfig(table string, config common.Tabl
 
 	return r0
 }
+
+// UpdateTableMetadata provides a mock function with given fields: table, metadata
+func (_m *TableSchemaMutator) UpdateTableMetadata(table string, metadata common.SchemaMetadata) error {
+	ret := _m.Called(table, metadata)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, common.SchemaMetadata) error); ok {
+		r0 = rf(table, metadata)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
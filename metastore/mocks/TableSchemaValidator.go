@@ -10,6 +10,11 @@ type TableSchemaValidator struct {
 	mock.Mock
 }
 
+// SetForce provides a mock function with given fields: force
+func (_m *TableSchemaValidator) SetForce(force bool) {
+	_m.Called(force)
+}
+
 // SetNewTable provides a mock function with given fields: table
 func (_m *TableSchemaValidator) SetNewTable(table common.Table) {
 	_m.Called(table)
@@ -66,6 +66,20 @@ func (_m *MetaStore) DeleteColumn(table string, column string) error {
 	return r0
 }
 
+// DeleteColumnAlias provides a mock function with given fields: table, column, alias
+func (_m *MetaStore) DeleteColumnAlias(table string, column string, alias string) error {
+	ret := _m.Called(table, column, alias)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(table, column, alias)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteTable provides a mock function with given fields: name
 func (_m *MetaStore) DeleteTable(name string) error {
 	ret := _m.Called(name)
@@ -80,6 +94,36 @@ func (_m *MetaStore) DeleteTable(name string) error {
 	return r0
 }
 
+// DeprecateColumn provides a mock function with given fields: table, column, purgeAt
+func (_m *MetaStore) DeprecateColumn(table string, column string, purgeAt int64) error {
+	ret := _m.Called(table, column, purgeAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, int64) error); ok {
+		r0 = rf(table, column, purgeAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExpectVersion provides a mock function with given fields: expectedVersion
+func (_m *MetaStore) ExpectVersion(expectedVersion int) common.TableSchemaMutator {
+	ret := _m.Called(expectedVersion)
+
+	var r0 common.TableSchemaMutator
+	if rf, ok := ret.Get(0).(func(int) common.TableSchemaMutator); ok {
+		r0 = rf(expectedVersion)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(common.TableSchemaMutator)
+		}
+	}
+
+	return r0
+}
+
 // ExtendEnumDict provides a mock function with given fields: table, column, enumCases
 func (_m *MetaStore) ExtendEnumDict(table string, column string, enumCases []string) ([]int, error) {
 	ret := _m.Called(table, column, enumCases)
@@ -103,6 +147,22 @@ func (_m *MetaStore) ExtendEnumDict(table string, column string, enumCases []str
 	return r0, r1
 }
 
+// ForceWithActor provides a mock function with given fields: actor
+func (_m *MetaStore) ForceWithActor(actor string) common.TableSchemaMutator {
+	ret := _m.Called(actor)
+
+	var r0 common.TableSchemaMutator
+	if rf, ok := ret.Get(0).(func(string) common.TableSchemaMutator); ok {
+		r0 = rf(actor)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(common.TableSchemaMutator)
+		}
+	}
+
+	return r0
+}
+
 // GetArchiveBatchVersion provides a mock function with given fields: table, shard, batchID, cutoff
 func (_m *MetaStore) GetArchiveBatchVersion(table string, shard int, batchID int, cutoff uint32) (uint32, uint32, int, error) {
 	ret := _m.Called(table, shard, batchID, cutoff)
@@ -275,6 +335,29 @@ func (_m *MetaStore) GetRedoLogCommitOffset(table string, shard int) (int64, err
 	return r0, r1
 }
 
+// GetSchemaHistory provides a mock function with given fields: table
+func (_m *MetaStore) GetSchemaHistory(table string) ([]common.SchemaChange, error) {
+	ret := _m.Called(table)
+
+	var r0 []common.SchemaChange
+	if rf, ok := ret.Get(0).(func(string) []common.SchemaChange); ok {
+		r0 = rf(table)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]common.SchemaChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(table)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSnapshotProgress provides a mock function with given fields: table, shard
 func (_m *MetaStore) GetSnapshotProgress(table string, shard int) (int64, uint32, int32, uint32, error) {
 	ret := _m.Called(table, shard)
@@ -340,6 +423,29 @@ func (_m *MetaStore) GetTable(name string) (*common.Table, error) {
 	return r0, r1
 }
 
+// GetTableAtVersion provides a mock function with given fields: table, version
+func (_m *MetaStore) GetTableAtVersion(table string, version int) (*common.Table, error) {
+	ret := _m.Called(table, version)
+
+	var r0 *common.Table
+	if rf, ok := ret.Get(0).(func(string, int) *common.Table); ok {
+		r0 = rf(table, version)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Table)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(table, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListTables provides a mock function with given fields:
 func (_m *MetaStore) ListTables() ([]string, error) {
 	ret := _m.Called()
@@ -391,6 +497,20 @@ func (_m *MetaStore) PurgeArchiveBatches(table string, shard int, batchIDStart i
 	return r0
 }
 
+// RenameColumn provides a mock function with given fields: table, column, newName, aliasExpiresAt
+func (_m *MetaStore) RenameColumn(table string, column string, newName string, aliasExpiresAt int64) error {
+	ret := _m.Called(table, column, newName, aliasExpiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string, int64) error); ok {
+		r0 = rf(table, column, newName, aliasExpiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateArchivingCutoff provides a mock function with given fields: table, shard, cutoff
 func (_m *MetaStore) UpdateArchivingCutoff(table string, shard int, cutoff uint32) error {
 	ret := _m.Called(table, shard, cutoff)
@@ -433,6 +553,20 @@ func (_m *MetaStore) UpdateColumn(table string, column string, config common.Col
 	return r0
 }
 
+// UpdateColumnMetadata provides a mock function with given fields: table, column, metadata
+func (_m *MetaStore) UpdateColumnMetadata(table string, column string, metadata common.SchemaMetadata) error {
+	ret := _m.Called(table, column, metadata)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, common.SchemaMetadata) error); ok {
+		r0 = rf(table, column, metadata)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateRedoLogCheckpointOffset provides a mock function with given fields: table, shard, offset
 func (_m *MetaStore) UpdateRedoLogCheckpointOffset(table string, shard int, offset int64) error {
 	ret := _m.Called(table, shard, offset)
@@ -503,6 +637,20 @@ func (_m *MetaStore) UpdateTableConfig(table string, config common.TableConfig)
 	return r0
 }
 
+// UpdateTableMetadata provides a mock function with given fields: table, metadata
+func (_m *MetaStore) UpdateTableMetadata(table string, metadata common.SchemaMetadata) error {
+	ret := _m.Called(table, metadata)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, common.SchemaMetadata) error); ok {
+		r0 = rf(table, metadata)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // WatchEnumDictEvents provides a mock function with given fields: table, column, startCase
 func (_m *MetaStore) WatchEnumDictEvents(table string, column string, startCase int) (<-chan string, chan<- struct{}, error) {
 	ret := _m.Called(table, column, startCase)
@@ -630,3 +778,19 @@ func (_m *MetaStore) WatchTableSchemaEvents() (<-chan *common.Table, chan<- stru
 
 	return r0, r1, r2
 }
+
+// WithActor provides a mock function with given fields: actor
+func (_m *MetaStore) WithActor(actor string) common.TableSchemaMutator {
+	ret := _m.Called(actor)
+
+	var r0 common.TableSchemaMutator
+	if rf, ok := ret.Get(0).(func(string) common.TableSchemaMutator); ok {
+		r0 = rf(actor)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(common.TableSchemaMutator)
+		}
+	}
+
+	return r0
+}
@@ -0,0 +1,107 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// DiffTables compares two versions of a table schema field by field. Fields are identified by
+// their json paths, e.g. columns[1].config.priority, and reported ordered by path.
+func DiffTables(oldTable, newTable *common.Table) ([]common.SchemaFieldDiff, error) {
+	oldFields, err := flattenTable(oldTable)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flattenTable(newTable)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := []common.SchemaFieldDiff{}
+	for field, oldValue := range oldFields {
+		newValue, exist := newFields[field]
+		if !exist {
+			diffs = append(diffs, common.SchemaFieldDiff{
+				Field:    field,
+				Type:     common.SchemaFieldRemoved,
+				OldValue: oldValue,
+			})
+		} else if !reflect.DeepEqual(oldValue, newValue) {
+			diffs = append(diffs, common.SchemaFieldDiff{
+				Field:    field,
+				Type:     common.SchemaFieldChanged,
+				OldValue: oldValue,
+				NewValue: newValue,
+			})
+		}
+	}
+	for field, newValue := range newFields {
+		if _, exist := oldFields[field]; !exist {
+			diffs = append(diffs, common.SchemaFieldDiff{
+				Field:    field,
+				Type:     common.SchemaFieldAdded,
+				NewValue: newValue,
+			})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Field < diffs[j].Field
+	})
+	return diffs, nil
+}
+
+// flattenTable converts the table to a map from json paths of leaf fields to their values.
+func flattenTable(table *common.Table) (map[string]interface{}, error) {
+	tableBytes, err := json.Marshal(table)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal table %s", table.Name)
+	}
+
+	var value interface{}
+	if err = json.Unmarshal(tableBytes, &value); err != nil {
+		return nil, utils.StackError(err, "Failed to unmarshal table %s", table.Name)
+	}
+
+	fields := make(map[string]interface{})
+	flattenJSONValue("", value, fields)
+	return fields, nil
+}
+
+func flattenJSONValue(path string, value interface{}, fields map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			flattenJSONValue(childPath, child, fields)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenJSONValue(fmt.Sprintf("%s[%d]", path, i), child, fields)
+		}
+	default:
+		fields[path] = v
+	}
+}
@@ -0,0 +1,184 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	controllerCli "github.com/uber/aresdb/controller/client"
+	"github.com/uber/aresdb/controller/models"
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// SchemaDriftDetector is a job that periodically compares local table schemas against controller,
+// tables whose schemas differ are reported with the schema_drift gauge set.
+type SchemaDriftDetector struct {
+	sync.RWMutex
+
+	clusterName       string
+	intervalInSeconds int
+	schemaReader      common.TableSchemaReader
+	controllerClient  controllerCli.ControllerClient
+	stopChan          chan struct{}
+	// resyncs schema from controller, nil to only report drift.
+	resync func()
+	// whether to resync schema once drift is detected by Run.
+	resyncOnDrift bool
+
+	// tables with schema_drift gauge reported, to reset the gauge once the drift is gone.
+	driftedTables map[string]bool
+}
+
+// NewSchemaDriftDetector creates a new SchemaDriftDetector
+func NewSchemaDriftDetector(intervalInSeconds int, schemaReader common.TableSchemaReader, controllerClient controllerCli.ControllerClient, clusterName string) *SchemaDriftDetector {
+	return &SchemaDriftDetector{
+		clusterName:       clusterName,
+		intervalInSeconds: intervalInSeconds,
+		schemaReader:      schemaReader,
+		controllerClient:  controllerClient,
+		stopChan:          make(chan struct{}),
+		driftedTables:     make(map[string]bool),
+	}
+}
+
+// SetResync sets the function to resync schema from controller, e.g. SchemaFetchJob.RequestResync.
+// Schema is resynced once drift is detected by Run if resyncOnDrift, otherwise only if requested by
+// callers of DetectDrift. It should be called before Run.
+func (d *SchemaDriftDetector) SetResync(resync func(), resyncOnDrift bool) {
+	d.resync = resync
+	d.resyncOnDrift = resyncOnDrift
+}
+
+// Run starts the scheduling
+func (d *SchemaDriftDetector) Run() {
+	tickChan := time.NewTicker(time.Second * time.Duration(d.intervalInSeconds)).C
+
+	for {
+		select {
+		case <-tickChan:
+			d.DetectDrift(d.resyncOnDrift)
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the scheduling
+func (d *SchemaDriftDetector) Stop() {
+	close(d.stopChan)
+}
+
+// DetectDrift compares local table schemas against controller and returns tables with different
+// schemas sorted by table name, schema is resynced from controller if resync is requested and set.
+func (d *SchemaDriftDetector) DetectDrift(resync bool) ([]common.SchemaDrift, error) {
+	controllerTables, err := d.controllerClient.GetAllSchema(d.clusterName)
+	if err != nil {
+		utils.GetLogger().With("error", err.Error()).Error("Failed to get schemas from controller for drift detection")
+		return nil, err
+	}
+	localVersions, err := GetTableSchemaVersions(d.schemaReader)
+	if err != nil {
+		utils.GetLogger().With("error", err.Error()).Error("Failed to get local schemas for drift detection")
+		return nil, err
+	}
+
+	drifts := []common.SchemaDrift{}
+	for _, table := range controllerTables {
+		controllerVersion, err := GetTableSchemaVersion(&table)
+		if err != nil {
+			return nil, err
+		}
+		localVersion, exist := localVersions[table.Name]
+		delete(localVersions, table.Name)
+		if !exist {
+			drifts = append(drifts, common.SchemaDrift{Table: table.Name, Controller: &controllerVersion})
+		} else if localVersion != controllerVersion {
+			drifts = append(drifts, common.SchemaDrift{Table: table.Name, Local: &localVersion, Controller: &controllerVersion})
+		}
+	}
+	// remaining tables are deleted in controller.
+	for tableName := range localVersions {
+		localVersion := localVersions[tableName]
+		drifts = append(drifts, common.SchemaDrift{Table: tableName, Local: &localVersion})
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Table < drifts[j].Table
+	})
+
+	d.reportDrifts(drifts)
+	if len(drifts) > 0 && resync && d.resync != nil {
+		d.resync()
+	}
+	return drifts, nil
+}
+
+func (d *SchemaDriftDetector) reportDrifts(drifts []common.SchemaDrift) {
+	d.Lock()
+	defer d.Unlock()
+
+	driftedTables := make(map[string]bool, len(drifts))
+	for _, drift := range drifts {
+		driftedTables[drift.Table] = true
+		utils.GetLogger().With("table", drift.Table, "local", drift.Local, "controller", drift.Controller).
+			Warn("Local schema drifted from controller")
+		utils.GetRootReporter().GetChildGauge(map[string]string{
+			"table": drift.Table,
+		}, utils.SchemaDrift).Update(1)
+	}
+	for tableName := range d.driftedTables {
+		if !driftedTables[tableName] {
+			utils.GetRootReporter().GetChildGauge(map[string]string{
+				"table": tableName,
+			}, utils.SchemaDrift).Update(0)
+		}
+	}
+	d.driftedTables = driftedTables
+}
+
+// GetTableSchemaVersions returns versions of all table schemas by table name.
+func GetTableSchemaVersions(schemaReader common.TableSchemaReader) (map[string]common.TableSchemaVersion, error) {
+	tableNames, err := schemaReader.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]common.TableSchemaVersion, len(tableNames))
+	for _, tableName := range tableNames {
+		table, err := schemaReader.GetTable(tableName)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to get table %s", tableName)
+		}
+		if versions[tableName], err = GetTableSchemaVersion(table); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// GetTableSchemaVersion returns the version of the table schema, hashed the same way as for schema delta.
+func GetTableSchemaVersion(table *common.Table) (common.TableSchemaVersion, error) {
+	tableBytes, err := json.Marshal(table)
+	if err != nil {
+		return common.TableSchemaVersion{}, utils.StackError(err, "Failed to marshal table %s", table.Name)
+	}
+	return common.TableSchemaVersion{
+		Incarnation: table.Incarnation,
+		Version:     table.Version,
+		Hash:        models.TableSchemaHash(tableBytes),
+	}, nil
+}
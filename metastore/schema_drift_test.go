@@ -0,0 +1,145 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	controllerMocks "github.com/uber/aresdb/controller/client/mocks"
+	"github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("schema drift detector", func() {
+	var detector *SchemaDriftDetector
+	var mockSchemaReader metaMocks.TableSchemaReader
+	var mockControllerCli controllerMocks.ControllerClient
+
+	newTable := func(name string, version int) common.Table {
+		return common.Table{
+			Name: name,
+			Columns: []common.Column{
+				{Name: "col1", Type: "Int32"},
+			},
+			Version: version,
+		}
+	}
+
+	// returns -1 if the gauge is not reported.
+	getDriftGauge := func(table string) float64 {
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		gauge, exist := testScope.Snapshot().Gauges()["test.schema_drift+component=metastore,table="+table]
+		if !exist {
+			return -1
+		}
+		return gauge.Value()
+	}
+
+	ginkgo.BeforeEach(func() {
+		mockSchemaReader = metaMocks.TableSchemaReader{}
+		mockControllerCli = controllerMocks.ControllerClient{}
+		detector = NewSchemaDriftDetector(1, &mockSchemaReader, &mockControllerCli, "cluster1")
+	})
+
+	ginkgo.It("should detect tables with different schemas", func() {
+		table1, table2, table2Old, table3, table4 :=
+			newTable("table1", 1), newTable("table2", 3), newTable("table2", 1), newTable("table3", 1), newTable("table4", 1)
+		// table1 is unchanged, table2 is stale, table3 is missing locally and table4 is deleted in controller.
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{table1, table2, table3}, nil).Once()
+		mockSchemaReader.On("ListTables").Return([]string{"table1", "table2", "table4"}, nil).Once()
+		mockSchemaReader.On("GetTable", "table1").Return(&table1, nil).Once()
+		mockSchemaReader.On("GetTable", "table2").Return(&table2Old, nil).Once()
+		mockSchemaReader.On("GetTable", "table4").Return(&table4, nil).Once()
+
+		resynced := 0
+		detector.SetResync(func() {
+			resynced++
+		}, false)
+		drifts, err := detector.DetectDrift(true)
+		Ω(err).Should(BeNil())
+		Ω(drifts).Should(HaveLen(3))
+		Ω(drifts[0].Table).Should(Equal("table2"))
+		Ω(drifts[0].Local.Version).Should(Equal(1))
+		Ω(drifts[0].Controller.Version).Should(Equal(3))
+		Ω(drifts[1].Table).Should(Equal("table3"))
+		Ω(drifts[1].Local).Should(BeNil())
+		Ω(drifts[1].Controller.Version).Should(Equal(1))
+		Ω(drifts[2].Table).Should(Equal("table4"))
+		Ω(drifts[2].Local.Version).Should(Equal(1))
+		Ω(drifts[2].Controller).Should(BeNil())
+		Ω(resynced).Should(Equal(1))
+		Ω(getDriftGauge("table2")).Should(Equal(1.0))
+		Ω(getDriftGauge("table1")).Should(Equal(-1.0))
+
+		// drift is gone after resync.
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{table1, table2}, nil).Once()
+		mockSchemaReader.On("ListTables").Return([]string{"table1", "table2"}, nil).Once()
+		mockSchemaReader.On("GetTable", "table1").Return(&table1, nil).Once()
+		mockSchemaReader.On("GetTable", "table2").Return(&table2, nil).Once()
+		drifts, err = detector.DetectDrift(true)
+		Ω(err).Should(BeNil())
+		Ω(drifts).Should(BeEmpty())
+		Ω(resynced).Should(Equal(1))
+		Ω(getDriftGauge("table2")).Should(Equal(0.0))
+	})
+
+	ginkgo.It("should detect schemas diverged with the same version", func() {
+		table1, table1Diverged := newTable("table1", 1), newTable("table1", 1)
+		table1Diverged.Columns = append(table1Diverged.Columns, common.Column{Name: "col2", Type: "Int32"})
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{table1}, nil).Once()
+		mockSchemaReader.On("ListTables").Return([]string{"table1"}, nil).Once()
+		mockSchemaReader.On("GetTable", "table1").Return(&table1Diverged, nil).Once()
+
+		// resync is not requested.
+		detector.SetResync(func() {
+			ginkgo.Fail("should not resync")
+		}, false)
+		drifts, err := detector.DetectDrift(false)
+		Ω(err).Should(BeNil())
+		Ω(drifts).Should(HaveLen(1))
+		Ω(drifts[0].Local.Version).Should(Equal(drifts[0].Controller.Version))
+		Ω(drifts[0].Local.Hash).ShouldNot(Equal(drifts[0].Controller.Hash))
+	})
+
+	ginkgo.It("should return errors", func() {
+		someError := errors.New("some error")
+		mockControllerCli.On("GetAllSchema", "cluster1").Return(nil, someError).Once()
+		_, err := detector.DetectDrift(false)
+		Ω(err).Should(Equal(someError))
+
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{}, nil).Once()
+		mockSchemaReader.On("ListTables").Return(nil, someError).Once()
+		_, err = detector.DetectDrift(false)
+		Ω(err).Should(Equal(someError))
+	})
+
+	ginkgo.It("run and stop should work", func() {
+		go detector.Run()
+		detector.Stop()
+	})
+
+	ginkgo.It("TableSchemaVersion IsBehind should work", func() {
+		version := common.TableSchemaVersion{Incarnation: 1, Version: 5}
+		Ω(version.IsBehind(common.TableSchemaVersion{Incarnation: 1, Version: 5}, 0)).Should(BeFalse())
+		Ω(version.IsBehind(common.TableSchemaVersion{Incarnation: 1, Version: 6}, 0)).Should(BeTrue())
+		Ω(version.IsBehind(common.TableSchemaVersion{Incarnation: 1, Version: 6}, 1)).Should(BeFalse())
+		Ω(version.IsBehind(common.TableSchemaVersion{Incarnation: 2, Version: 0}, 10)).Should(BeTrue())
+		Ω(version.IsBehind(common.TableSchemaVersion{Incarnation: 0, Version: 10}, 0)).Should(BeFalse())
+	})
+})
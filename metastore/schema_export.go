@@ -0,0 +1,276 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"sort"
+
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// SchemaImportOptions controls how ImportSchemas applies a SchemaExport document.
+type SchemaImportOptions struct {
+	// One of SchemaImportDryRun, SchemaImportCreateOnly and SchemaImportUpsert.
+	Mode string
+	// One of EnumIDsPreserve and EnumIDsReassign.
+	EnumIDs string
+	// Whether warnings from schema change rules are overridden, should match the schema mutator.
+	Force bool
+}
+
+// ExportSchemas exports the schemas, configs and enum dictionaries of all tables in the metastore.
+func ExportSchemas(metaStore common.MetaStore, namespace string) (*common.SchemaExport, error) {
+	tableNames, err := metaStore.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tableNames)
+
+	export := &common.SchemaExport{
+		FormatVersion: common.SchemaExportFormatVersion,
+		Namespace:     namespace,
+		ExportedAt:    utils.Now().Unix(),
+		Tables:        make([]common.TableExport, 0, len(tableNames)),
+	}
+	for _, tableName := range tableNames {
+		table, err := metaStore.GetTable(tableName)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to get table %s", tableName)
+		}
+
+		tableExport := common.TableExport{Table: *table}
+		for _, column := range table.Columns {
+			if column.Deleted || !column.IsEnumColumn() {
+				continue
+			}
+			enumCases, err := metaStore.GetEnumDict(tableName, column.Name)
+			if err != nil {
+				return nil, utils.StackError(err, "Failed to get enum cases of table %s column %s", tableName, column.Name)
+			}
+			if tableExport.EnumCases == nil {
+				tableExport.EnumCases = make(map[string][]string)
+			}
+			tableExport.EnumCases[column.Name] = enumCases
+		}
+		export.Tables = append(export.Tables, tableExport)
+	}
+	return export, nil
+}
+
+// ImportSchemas imports tables of the SchemaExport document through schemaMutator, which validates
+// them the same way as other schema changes. Tables are imported independently, a table failing to
+// be imported is reported in its result and does not stop others.
+func ImportSchemas(metaStore common.MetaStore, schemaMutator common.TableSchemaMutator,
+	export common.SchemaExport, opts SchemaImportOptions) ([]common.TableImportResult, error) {
+	if export.FormatVersion <= 0 || export.FormatVersion > common.SchemaExportFormatVersion {
+		return nil, ErrUnsupportedSchemaExportFormat
+	}
+	switch opts.Mode {
+	case common.SchemaImportDryRun, common.SchemaImportCreateOnly, common.SchemaImportUpsert:
+	default:
+		return nil, ErrInvalidSchemaImportMode
+	}
+	if opts.EnumIDs != common.EnumIDsPreserve && opts.EnumIDs != common.EnumIDsReassign {
+		return nil, ErrInvalidSchemaImportMode
+	}
+
+	results := make([]common.TableImportResult, 0, len(export.Tables))
+	for _, tableExport := range export.Tables {
+		result := importTable(metaStore, schemaMutator, tableExport, opts)
+		if result.Error != "" {
+			utils.GetLogger().With("table", result.Table, "error", result.Error).Warn("Failed to import table")
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func importTable(metaStore common.MetaStore, schemaMutator common.TableSchemaMutator,
+	tableExport common.TableExport, opts SchemaImportOptions) (result common.TableImportResult) {
+	table := tableExport.Table
+	result.Table = table.Name
+
+	fail := func(err error) common.TableImportResult {
+		result.Action, result.Applied, result.Error = common.TableImportFail, false, err.Error()
+		return result
+	}
+
+	existingTable, err := metaStore.GetTable(table.Name)
+	if err == ErrTableDoesNotExist {
+		existingTable, err = nil, nil
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if existingTable == nil {
+		result.Action = common.TableImportCreate
+		// schema history of the table starts over in this cluster.
+		table.Incarnation, table.Version = 0, 0
+	} else {
+		// compare against the existing table ignoring fields maintained by this cluster.
+		table.Incarnation, table.Version = existingTable.Incarnation, existingTable.Version
+		diffs, err := DiffTables(existingTable, &table)
+		if err != nil {
+			return fail(err)
+		}
+		switch {
+		case opts.Mode == common.SchemaImportCreateOnly:
+			result.Action = common.TableImportSkip
+			return result
+		case len(diffs) == 0:
+			result.Action = common.TableImportUnchanged
+		default:
+			result.Action, result.Diffs = common.TableImportUpdate, diffs
+			table.Version = existingTable.Version + 1
+		}
+	}
+
+	newEnumCases, err := planEnumCases(metaStore, existingTable, table, tableExport.EnumCases, opts.EnumIDs)
+	if err != nil {
+		return fail(err)
+	}
+	for columnName, enumCases := range newEnumCases {
+		if result.NewEnumCases == nil {
+			result.NewEnumCases = make(map[string]int)
+		}
+		result.NewEnumCases[columnName] = len(enumCases)
+	}
+
+	if opts.Mode == common.SchemaImportDryRun {
+		if result.Action != common.TableImportUnchanged {
+			validator := &tableSchemaValidatorImpl{
+				force: opts.Force,
+				getEnumCases: func(columnName string) ([]string, error) {
+					return metaStore.GetEnumDict(table.Name, columnName)
+				},
+			}
+			if existingTable != nil {
+				validator.SetOldTable(*existingTable)
+			}
+			validator.SetNewTable(table)
+			if err = validator.Validate(); err != nil {
+				return fail(err)
+			}
+		}
+		return result
+	}
+
+	switch result.Action {
+	case common.TableImportCreate:
+		err = schemaMutator.CreateTable(&table)
+	case common.TableImportUpdate:
+		err = schemaMutator.ExpectVersion(existingTable.Version).UpdateTable(table)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	for columnName, enumCases := range newEnumCases {
+		if err = extendEnumCases(metaStore, table.Name, columnName, enumCases, opts.EnumIDs); err != nil {
+			return fail(utils.StackError(err, "Failed to import enum cases of column %s", columnName))
+		}
+	}
+	result.Applied = true
+	return result
+}
+
+// planEnumCases returns the exported enum cases to append by column name. With EnumIDsPreserve,
+// enum cases the column will have after the schema change must be a prefix of the exported ones.
+func planEnumCases(metaStore common.MetaStore, existingTable *common.Table, table common.Table,
+	exportedEnumCases map[string][]string, enumIDs string) (map[string][]string, error) {
+	existingColumns := make(map[string]bool)
+	if existingTable != nil {
+		for _, column := range existingTable.Columns {
+			if !column.Deleted && column.IsEnumColumn() {
+				existingColumns[column.Name] = true
+			}
+		}
+	}
+
+	newEnumCases := make(map[string][]string)
+	for _, column := range table.Columns {
+		enumCases := exportedEnumCases[column.Name]
+		if column.Deleted || !column.IsEnumColumn() || len(enumCases) == 0 {
+			continue
+		}
+
+		var existingCases []string
+		if existingColumns[column.Name] {
+			var err error
+			if existingCases, err = metaStore.GetEnumDict(table.Name, column.Name); err != nil {
+				return nil, err
+			}
+		} else if column.DefaultValue != nil {
+			// default value of new enum columns is added as the first enum case.
+			existingCases = []string{*column.DefaultValue}
+		}
+
+		var missingCases []string
+		if enumIDs == common.EnumIDsPreserve {
+			if len(existingCases) > len(enumCases) {
+				return nil, ErrEnumIDsNotPreservable
+			}
+			for i, enumCase := range existingCases {
+				if enumCases[i] != enumCase {
+					return nil, ErrEnumIDsNotPreservable
+				}
+			}
+			missingCases = enumCases[len(existingCases):]
+		} else {
+			existingCaseSet := make(map[string]struct{}, len(existingCases))
+			for _, enumCase := range existingCases {
+				existingCaseSet[enumCase] = struct{}{}
+			}
+			for _, enumCase := range enumCases {
+				if _, exist := existingCaseSet[enumCase]; !exist {
+					missingCases = append(missingCases, enumCase)
+				}
+			}
+		}
+
+		if len(missingCases) > 0 {
+			newEnumCases[column.Name] = missingCases
+		}
+	}
+	return newEnumCases, nil
+}
+
+// extendEnumCases appends enum cases to the column, with EnumIDsPreserve the enum cases have to be
+// appended right after the existing ones without cases appended concurrently.
+func extendEnumCases(metaStore common.MetaStore, tableName, columnName string, enumCases []string, enumIDs string) error {
+	var firstEnumID int
+	if enumIDs == common.EnumIDsPreserve {
+		existingCases, err := metaStore.GetEnumDict(tableName, columnName)
+		if err != nil {
+			return err
+		}
+		firstEnumID = len(existingCases)
+	}
+
+	ids, err := metaStore.ExtendEnumDict(tableName, columnName, enumCases)
+	if err != nil {
+		return err
+	}
+	if enumIDs == common.EnumIDsPreserve {
+		for i, id := range ids {
+			if id != firstEnumID+i {
+				return ErrEnumIDsNotPreservable
+			}
+		}
+	}
+	return nil
+}
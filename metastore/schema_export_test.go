@@ -0,0 +1,159 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("schema export", func() {
+	var dir string
+	var source, target common.MetaStore
+
+	table := common.Table{
+		Name: "trips",
+		Columns: []common.Column{
+			{Name: "id", Type: common.Uint32},
+			{Name: "city", Type: common.SmallEnum},
+		},
+		PrimaryKeyColumns: []int{0},
+		Config:            DefaultTableConfig,
+	}
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "schema_export_test")
+		Ω(err).Should(BeNil())
+		source, err = NewDiskMetaStore(dir + "/source")
+		Ω(err).Should(BeNil())
+		target, err = NewDiskMetaStore(dir + "/target")
+		Ω(err).Should(BeNil())
+
+		sourceTable := table
+		Ω(source.CreateTable(&sourceTable)).Should(BeNil())
+		_, err = source.ExtendEnumDict(table.Name, "city", []string{"sf", "nyc"})
+		Ω(err).Should(BeNil())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("ExportSchemas should export tables with enum cases", func() {
+		export, err := ExportSchemas(source, "ns1")
+		Ω(err).Should(BeNil())
+		Ω(export.FormatVersion).Should(Equal(common.SchemaExportFormatVersion))
+		Ω(export.Namespace).Should(Equal("ns1"))
+		Ω(export.Tables).Should(HaveLen(1))
+		Ω(export.Tables[0].Table.Name).Should(Equal(table.Name))
+		Ω(export.Tables[0].EnumCases).Should(Equal(map[string][]string{"city": {"sf", "nyc"}}))
+	})
+
+	ginkgo.It("ImportSchemas should report without changes in dry run", func() {
+		export, err := ExportSchemas(source, "ns1")
+		Ω(err).Should(BeNil())
+
+		results, err := ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportDryRun, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results).Should(Equal([]common.TableImportResult{
+			{Table: table.Name, Action: common.TableImportCreate, NewEnumCases: map[string]int{"city": 2}},
+		}))
+		tables, err := target.ListTables()
+		Ω(err).Should(BeNil())
+		Ω(tables).Should(BeEmpty())
+
+		// invalid tables are reported the same way as other schema changes.
+		export.Tables[0].Table.PrimaryKeyColumns = nil
+		results, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportDryRun, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results[0].Action).Should(Equal(common.TableImportFail))
+		Ω(results[0].Error).Should(Equal(ErrMissingPrimaryKey.Error()))
+	})
+
+	ginkgo.It("ImportSchemas should create and update tables preserving enum IDs", func() {
+		export, err := ExportSchemas(source, "ns1")
+		Ω(err).Should(BeNil())
+
+		results, err := ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results).Should(Equal([]common.TableImportResult{
+			{Table: table.Name, Action: common.TableImportCreate, Applied: true, NewEnumCases: map[string]int{"city": 2}},
+		}))
+		enumCases, err := target.GetEnumDict(table.Name, "city")
+		Ω(err).Should(BeNil())
+		Ω(enumCases).Should(Equal([]string{"sf", "nyc"}))
+
+		// importing again changes nothing.
+		results, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results).Should(Equal([]common.TableImportResult{
+			{Table: table.Name, Action: common.TableImportUnchanged, Applied: true},
+		}))
+
+		// existing tables are skipped in create only mode.
+		export.Tables[0].Table.Config.BatchSize = 100
+		export.Tables[0].EnumCases["city"] = append(export.Tables[0].EnumCases["city"], "la")
+		results, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportCreateOnly, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results[0].Action).Should(Equal(common.TableImportSkip))
+
+		results, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results[0].Action).Should(Equal(common.TableImportUpdate))
+		Ω(results[0].Diffs).Should(HaveLen(1))
+		Ω(results[0].NewEnumCases).Should(Equal(map[string]int{"city": 1}))
+		updatedTable, err := target.GetTable(table.Name)
+		Ω(err).Should(BeNil())
+		Ω(updatedTable.Config.BatchSize).Should(Equal(100))
+		Ω(updatedTable.Version).Should(Equal(1))
+		enumCases, err = target.GetEnumDict(table.Name, "city")
+		Ω(err).Should(BeNil())
+		Ω(enumCases).Should(Equal([]string{"sf", "nyc", "la"}))
+	})
+
+	ginkgo.It("ImportSchemas should only reassign enum IDs when chosen", func() {
+		targetTable := table
+		Ω(target.CreateTable(&targetTable)).Should(BeNil())
+		_, err := target.ExtendEnumDict(table.Name, "city", []string{"nyc"})
+		Ω(err).Should(BeNil())
+
+		export, err := ExportSchemas(source, "ns1")
+		Ω(err).Should(BeNil())
+
+		results, err := ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert, EnumIDs: common.EnumIDsPreserve})
+		Ω(err).Should(BeNil())
+		Ω(results[0].Action).Should(Equal(common.TableImportFail))
+		Ω(results[0].Error).Should(Equal(ErrEnumIDsNotPreservable.Error()))
+
+		results, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert, EnumIDs: common.EnumIDsReassign})
+		Ω(err).Should(BeNil())
+		Ω(results[0].Action).Should(Equal(common.TableImportUnchanged))
+		Ω(results[0].NewEnumCases).Should(Equal(map[string]int{"city": 1}))
+		enumCases, err := target.GetEnumDict(table.Name, "city")
+		Ω(err).Should(BeNil())
+		Ω(enumCases).Should(Equal([]string{"nyc", "sf"}))
+
+		_, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert})
+		Ω(err).Should(Equal(ErrInvalidSchemaImportMode))
+		export.FormatVersion = common.SchemaExportFormatVersion + 1
+		_, err = ImportSchemas(target, target, *export, SchemaImportOptions{Mode: common.SchemaImportUpsert, EnumIDs: common.EnumIDsReassign})
+		Ω(err).Should(Equal(ErrUnsupportedSchemaExportFormat))
+	})
+})
@@ -15,7 +15,10 @@
 package metastore
 
 import (
+	"context"
+	"encoding/json"
 	controllerCli "github.com/uber/aresdb/controller/client"
+	"github.com/uber/aresdb/controller/models"
 	"github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 	"reflect"
@@ -31,8 +34,19 @@ type SchemaFetchJob struct {
 	schemaValidator   TableSchemaValidator
 	controllerClient  controllerCli.ControllerClient
 	stopChan          chan struct{}
+	// set once controller responds schema delta is not supported, all schemas will be fetched afterwards.
+	schemaDeltaUnsupported bool
+	// schema change notifications pushed by controller, nil if only polling.
+	changeNotifications <-chan struct{}
+	// requests to fetch all schemas regardless of the schema hash.
+	resyncChan chan struct{}
+	// requests to fetch schema synchronously, closed once the schema is fetched.
+	refreshChan chan chan struct{}
 }
 
+// SchemaFetchActor is the actor recorded in schema history for schema changes synced from controller.
+const SchemaFetchActor = "controller"
+
 // NewSchemaFetchJob creates a new SchemaFetchJob
 func NewSchemaFetchJob(intervalInSeconds int, schemaMutator common.TableSchemaMutator, schemaValidator TableSchemaValidator, controllerClient controllerCli.ControllerClient, clusterName, initialHash string) *SchemaFetchJob {
 	return &SchemaFetchJob{
@@ -42,10 +56,18 @@ func NewSchemaFetchJob(intervalInSeconds int, schemaMutator common.TableSchemaMu
 		schemaMutator:     schemaMutator,
 		schemaValidator:   schemaValidator,
 		stopChan:          make(chan struct{}),
+		resyncChan:        make(chan struct{}, 1),
+		refreshChan:       make(chan chan struct{}),
 		controllerClient:  controllerClient,
 	}
 }
 
+// SetChangeNotifications sets the channel notified on schema changes, which triggers fetching schema
+// immediately. Schema is still fetched at the interval as a fallback. It should be called before Run.
+func (j *SchemaFetchJob) SetChangeNotifications(notifications <-chan struct{}) {
+	j.changeNotifications = notifications
+}
+
 // Run starts the scheduling
 func (j *SchemaFetchJob) Run() {
 	tickChan := time.NewTicker(time.Second * time.Duration(j.intervalInSeconds)).C
@@ -54,6 +76,13 @@ func (j *SchemaFetchJob) Run() {
 		select {
 		case <-tickChan:
 			j.FetchSchema()
+		case <-j.changeNotifications:
+			j.FetchSchema()
+		case <-j.resyncChan:
+			j.resync()
+		case done := <-j.refreshChan:
+			j.FetchSchema()
+			close(done)
 		case <-j.stopChan:
 			return
 		}
@@ -65,27 +94,148 @@ func (j *SchemaFetchJob) Stop() {
 	close(j.stopChan)
 }
 
+// RequestResync requests fetching schema from controller regardless of the schema hash last synced,
+// e.g. when local schema drifted from controller. The schema is fetched asynchronously by Run.
+func (j *SchemaFetchJob) RequestResync() {
+	select {
+	case j.resyncChan <- struct{}{}:
+	default:
+		// a resync is already pending.
+	}
+}
+
+// RefreshSchema fetches schema changes from controller and waits until they are applied, e.g. when queries
+// fail since local schema is behind. The schema is fetched by Run to not race with scheduled fetches, so Run
+// must be running.
+func (j *SchemaFetchJob) RefreshSchema(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case j.refreshChan <- done:
+	case <-j.stopChan:
+		return ErrSchemaFetchJobStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (j *SchemaFetchJob) resync() {
+	utils.GetLogger().Info("Resyncing schema from controller")
+	j.hash = ""
+	j.FetchSchema()
+}
+
+// FetchSchema fetches schema changes from controller and applies them. Only tables with changed schema are
+// fetched if controller supports schema delta, otherwise all schemas are fetched once the schema hash changes.
 func (j *SchemaFetchJob) FetchSchema() {
+	var err error
+	if !j.schemaDeltaUnsupported {
+		err = j.fetchSchemaDelta()
+		if err == controllerCli.ErrSchemaDeltaNotSupported {
+			utils.GetLogger().Info("Controller does not support schema delta, fall back to fetching all schemas")
+			j.schemaDeltaUnsupported = true
+		}
+	}
+	if j.schemaDeltaUnsupported {
+		err = j.fetchAllSchemas()
+	}
+	if err != nil {
+		// errors already reported.
+		return
+	}
+	utils.GetLogger().Info("Succeeded to run schema fetch job")
+	utils.GetRootReporter().GetCounter(utils.SchemaFetchSuccess).Inc(1)
+}
+
+func (j *SchemaFetchJob) fetchAllSchemas() error {
 	newHash, err := j.controllerClient.GetSchemaHash(j.clusterName)
 	if err != nil {
 		reportError(err, "hash")
-		return
+		return err
 	}
 	if newHash != j.hash {
 		newSchemas, err := j.controllerClient.GetAllSchema(j.clusterName)
 		if err != nil {
 			reportError(err, "allSchema")
-			return
+			return err
 		}
 		err = j.applySchemaChange(newSchemas)
 		if err != nil {
 			// errors already reported, just return without updating hash
-			return
+			return err
 		}
 		j.hash = newHash
+		utils.GetRootReporter().GetGauge(utils.SchemaFetchTablesUpdated).Update(float64(len(newSchemas)))
 	}
-	utils.GetLogger().Info("Succeeded to run schema fetch job")
-	utils.GetRootReporter().GetCounter(utils.SchemaFetchSuccess).Inc(1)
+	return nil
+}
+
+// fetchSchemaDelta sends hashes of local table schemas to controller and only applies the tables changed.
+func (j *SchemaFetchJob) fetchSchemaDelta() error {
+	tableNames, err := j.schemaMutator.ListTables()
+	if err != nil {
+		reportError(err, "listTables")
+		return err
+	}
+
+	deltaRequest := models.SchemaDeltaRequest{
+		Hash:        j.hash,
+		TableHashes: make(map[string]string, len(tableNames)),
+	}
+	tableSizes := make(map[string]int, len(tableNames))
+	for _, tableName := range tableNames {
+		table, err := j.schemaMutator.GetTable(tableName)
+		if err != nil {
+			reportError(err, tableName)
+			return err
+		}
+		tableBytes, err := json.Marshal(table)
+		if err != nil {
+			reportError(err, tableName)
+			return err
+		}
+		deltaRequest.TableHashes[tableName] = models.TableSchemaHash(tableBytes)
+		tableSizes[tableName] = len(tableBytes)
+	}
+
+	delta, err := j.controllerClient.GetSchemaDelta(j.clusterName, deltaRequest)
+	if err != nil {
+		if err != controllerCli.ErrSchemaDeltaNotSupported {
+			reportError(err, "schemaDelta")
+		}
+		return err
+	}
+
+	var tablesUpdated int
+	if delta != nil {
+		if err = j.applySchemaDelta(*delta); err != nil {
+			// errors already reported, just return without updating hash
+			return err
+		}
+		j.hash = delta.Hash
+		tablesUpdated = len(delta.UpdatedTables) + len(delta.DeletedTables)
+		for _, table := range delta.UpdatedTables {
+			delete(tableSizes, table.Name)
+		}
+		for _, tableName := range delta.DeletedTables {
+			delete(tableSizes, tableName)
+		}
+	}
+
+	// schemas of unchanged tables are not transferred.
+	var bytesSaved int
+	for _, size := range tableSizes {
+		bytesSaved += size
+	}
+	utils.GetRootReporter().GetCounter(utils.SchemaFetchBytesSaved).Inc(int64(bytesSaved))
+	utils.GetRootReporter().GetGauge(utils.SchemaFetchTablesUpdated).Update(float64(tablesUpdated))
+	return nil
 }
 
 func (j *SchemaFetchJob) applySchemaChange(tables []common.Table) (err error) {
@@ -100,76 +250,139 @@ func (j *SchemaFetchJob) applySchemaChange(tables []common.Table) (err error) {
 	}
 
 	for _, table := range tables {
-		if _, exist := oldTablesMap[table.Name]; !exist {
-			// found new table
-			err = j.schemaMutator.CreateTable(&table)
-			if err != nil {
-				reportError(err, table.Name)
-				continue
-			}
-			utils.GetRootReporter().GetCounter(utils.SchemaCreationCount).Inc(1)
-			utils.GetLogger().With("table", table.Name).Debug("added new table")
-		} else {
+		_, exist := oldTablesMap[table.Name]
+		if exist {
 			oldTablesMap[table.Name] = false
-			var oldTable *common.Table
-			oldTable, err = j.schemaMutator.GetTable(table.Name)
-			if err != nil {
-				reportError(err, table.Name)
-				continue
-			}
-			if oldTable.Incarnation < table.Incarnation {
-				// found new table incarnation, delete previous table and data
-				// then create new table
-				err := j.schemaMutator.DeleteTable(table.Name)
-				if err != nil {
-					reportError(err, table.Name)
-					continue
-				}
-				utils.GetRootReporter().GetCounter(utils.SchemaDeletionCount).Inc(1)
-				utils.GetLogger().With("table", table.Name).Debug("deleted table")
-				err = j.schemaMutator.CreateTable(&table)
-				if err != nil {
-					reportError(err, table.Name)
-					continue
-				}
-				utils.GetRootReporter().GetCounter(utils.SchemaCreationCount).Inc(1)
-				utils.GetLogger().With("table", table.Name).Debug("recreated table")
-
-			} else if oldTable.Incarnation == table.Incarnation && !reflect.DeepEqual(&table, oldTable) {
-				// found table update
-				j.schemaValidator.SetNewTable(table)
-				j.schemaValidator.SetOldTable(*oldTable)
-				err = j.schemaValidator.Validate()
-				if err != nil {
-					reportError(err, table.Name)
-					continue
-				}
-				err = j.schemaMutator.UpdateTable(table)
-				if err != nil {
-					reportError(err, table.Name)
-					continue
-				}
-				utils.GetRootReporter().GetCounter(utils.SchemaUpdateCount).Inc(1)
-				utils.GetLogger().With("table", table.Name).Debug("updated table")
-			}
+		}
+		if tableErr := j.applyTableChange(table, exist); tableErr != nil {
+			err = tableErr
 		}
 	}
 
 	for oldTableName, notAddressed := range oldTablesMap {
 		if notAddressed {
 			// found table deletion
-			err = j.schemaMutator.DeleteTable(oldTableName)
-			if err != nil {
-				reportError(err, oldTableName)
-				continue
+			if tableErr := j.deleteTable(oldTableName); tableErr != nil {
+				err = tableErr
 			}
-			utils.GetRootReporter().GetCounter(utils.SchemaDeletionCount).Inc(1)
 		}
 	}
 
 	return
 }
 
+func (j *SchemaFetchJob) applySchemaDelta(delta models.SchemaDelta) (err error) {
+	oldTables, err := j.schemaMutator.ListTables()
+	if err != nil {
+		reportError(err, "listTables")
+		return
+	}
+
+	for _, table := range delta.UpdatedTables {
+		exist := utils.IndexOfStr(oldTables, table.Name) >= 0
+		if tableErr := j.applyTableChange(table, exist); tableErr != nil {
+			err = tableErr
+		}
+	}
+
+	for _, tableName := range delta.DeletedTables {
+		if utils.IndexOfStr(oldTables, tableName) < 0 {
+			continue
+		}
+		if tableErr := j.deleteTable(tableName); tableErr != nil {
+			err = tableErr
+		}
+	}
+	return
+}
+
+// applyTableChange creates, recreates or updates the table, errors are reported.
+func (j *SchemaFetchJob) applyTableChange(table common.Table, exist bool) (err error) {
+	if !exist {
+		// found new table
+		err = j.schemaMutator.CreateTable(&table)
+		if err != nil {
+			reportError(err, table.Name)
+			return
+		}
+		utils.GetRootReporter().GetCounter(utils.SchemaCreationCount).Inc(1)
+		utils.GetLogger().With("table", table.Name).Debug("added new table")
+		return
+	}
+
+	var oldTable *common.Table
+	oldTable, err = j.schemaMutator.GetTable(table.Name)
+	if err != nil {
+		reportError(err, table.Name)
+		return
+	}
+	if oldTable.Incarnation < table.Incarnation {
+		// found new table incarnation, delete previous table and data
+		// then create new table
+		err = j.schemaMutator.DeleteTable(table.Name)
+		if err != nil {
+			reportError(err, table.Name)
+			return
+		}
+		utils.GetRootReporter().GetCounter(utils.SchemaDeletionCount).Inc(1)
+		utils.GetLogger().With("table", table.Name).Debug("deleted table")
+		err = j.schemaMutator.CreateTable(&table)
+		if err != nil {
+			reportError(err, table.Name)
+			return
+		}
+		utils.GetRootReporter().GetCounter(utils.SchemaCreationCount).Inc(1)
+		utils.GetLogger().With("table", table.Name).Debug("recreated table")
+
+	} else if oldTable.Incarnation == table.Incarnation && !reflect.DeepEqual(&table, oldTable) {
+		// local schema changes made concurrently are detected by version, the update is validated
+		// again on the latest schema.
+		latestTable := oldTable
+		err = RetryOnVersionConflict(func() (err error) {
+			if latestTable == nil {
+				// read the latest schema for retries.
+				if latestTable, err = j.schemaMutator.GetTable(table.Name); err != nil {
+					return
+				}
+			}
+			err = j.updateTable(table, *latestTable)
+			latestTable = nil
+			return
+		})
+		if err != nil {
+			reportError(err, table.Name)
+			return
+		}
+		utils.GetRootReporter().GetCounter(utils.SchemaUpdateCount).Inc(1)
+		utils.GetLogger().With("table", table.Name).Debug("updated table")
+	}
+	return
+}
+
+// updateTable updates the table unless it is changed since oldTable was read.
+func (j *SchemaFetchJob) updateTable(table, oldTable common.Table) error {
+	// stale schema from controller should not overwrite newer schema version
+	if table.Version <= oldTable.Version {
+		return ErrIllegalSchemaVersion
+	}
+	j.schemaValidator.SetNewTable(table)
+	j.schemaValidator.SetOldTable(oldTable)
+	if err := j.schemaValidator.Validate(); err != nil {
+		return err
+	}
+	return j.schemaMutator.ExpectVersion(oldTable.Version).UpdateTable(table)
+}
+
+func (j *SchemaFetchJob) deleteTable(tableName string) error {
+	err := j.schemaMutator.DeleteTable(tableName)
+	if err != nil {
+		reportError(err, tableName)
+		return err
+	}
+	utils.GetRootReporter().GetCounter(utils.SchemaDeletionCount).Inc(1)
+	return nil
+}
+
 func reportError(err error, extraInfo string) {
 	utils.GetRootReporter().GetCounter(utils.SchemaFetchFailure).Inc(1)
 	utils.GetLogger().With("extraInfo", extraInfo).Error(utils.StackError(err, "err running schema fetch job"))
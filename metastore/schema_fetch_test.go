@@ -1,10 +1,14 @@
 package metastore
 
 import (
+	"context"
 	"errors"
 	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	controllerCli "github.com/uber/aresdb/controller/client"
 	controllerMocks "github.com/uber/aresdb/controller/client/mocks"
+	"github.com/uber/aresdb/controller/models"
 	"github.com/uber/aresdb/metastore/common"
 	metaMocks "github.com/uber/aresdb/metastore/mocks"
 )
@@ -63,8 +67,11 @@ var _ = ginkgo.Describe("schema fetch job", func() {
 		mockSchemaMutator = metaMocks.TableSchemaMutator{}
 		mockSchemaValidator = metaMocks.TableSchemaValidator{}
 		mockControllerCli = controllerMocks.ControllerClient{}
+		mockSchemaMutator.On("ExpectVersion", mock.Anything).Return(&mockSchemaMutator)
 
 		job = NewSchemaFetchJob(1, &mockSchemaMutator, &mockSchemaValidator, &mockControllerCli, "cluster1", "123")
+		// specs below fetch all schemas unless in schema delta context.
+		job.schemaDeltaUnsupported = true
 	})
 
 	ginkgo.It("should work with no schema changes", func() {
@@ -90,11 +97,96 @@ var _ = ginkgo.Describe("schema fetch job", func() {
 		job.FetchSchema()
 	})
 
+	ginkgo.It("should not apply stale schema versions", func() {
+		staleTable2 := testTable2m
+		staleTable2.Version = testTable2.Version
+		staleTable2.Config.BatchSize = 1
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{staleTable2, testTable3}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil).Once()
+		job.FetchSchema()
+		mockSchemaMutator.AssertNotCalled(ginkgo.GinkgoT(), "UpdateTable", mock.Anything)
+	})
+
+	ginkgo.It("should retry updates on version conflicts", func() {
+		// testTable2 is changed locally before the update.
+		localTable2 := testTable2
+		localTable2.Version = testTable2.Version + 1
+		newTable2 := testTable2m
+		newTable2.Version = localTable2.Version + 1
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{newTable2}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&localTable2, nil).Once()
+		mockSchemaMutator.On("UpdateTable", newTable2).Return(ErrSchemaVersionConflict).Once()
+		mockSchemaMutator.On("UpdateTable", newTable2).Return(nil).Once()
+		mockSchemaValidator.On("SetNewTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("SetOldTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("Validate").Return(nil)
+		job.FetchSchema()
+		mockSchemaMutator.AssertCalled(ginkgo.GinkgoT(), "ExpectVersion", testTable2.Version)
+		mockSchemaMutator.AssertCalled(ginkgo.GinkgoT(), "ExpectVersion", localTable2.Version)
+		mockSchemaMutator.AssertNumberOfCalls(ginkgo.GinkgoT(), "UpdateTable", 2)
+		Ω(job.hash).Should(Equal("456"))
+	})
+
 	ginkgo.It("run and stop should work", func() {
 		go job.Run()
 		job.Stop()
 	})
 
+	ginkgo.It("should fetch schema on change notifications", func() {
+		notifications := make(chan struct{})
+		job.SetChangeNotifications(notifications)
+		// no fetch by polling.
+		job.intervalInSeconds = 3600
+		fetched := make(chan struct{}, 1)
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil).Run(func(args mock.Arguments) {
+			fetched <- struct{}{}
+		})
+		go job.Run()
+		defer job.Stop()
+		notifications <- struct{}{}
+		Eventually(fetched).Should(Receive())
+	})
+
+	ginkgo.It("should fetch all schemas on resync requests", func() {
+		// no fetch by polling.
+		job.intervalInSeconds = 3600
+		fetched := make(chan struct{}, 1)
+		// schema hash is reset so all schemas are fetched even if the hash is unchanged.
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil)
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{}, nil).Run(func(args mock.Arguments) {
+			fetched <- struct{}{}
+		})
+		mockSchemaMutator.On("ListTables").Return([]string{}, nil)
+		go job.Run()
+		defer job.Stop()
+		job.RequestResync()
+		Eventually(fetched).Should(Receive())
+	})
+
+	ginkgo.It("should fetch schema synchronously on refresh requests", func() {
+		// no fetch by polling.
+		job.intervalInSeconds = 3600
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{}, nil).Once()
+		mockSchemaMutator.On("CreateTable", &testTable1).Return(nil).Once()
+		go job.Run()
+		Ω(job.RefreshSchema(context.Background())).Should(BeNil())
+		mockSchemaMutator.AssertCalled(ginkgo.GinkgoT(), "CreateTable", &testTable1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		job.Stop()
+		Ω(job.RefreshSchema(ctx)).ShouldNot(BeNil())
+		Ω(job.RefreshSchema(context.Background())).Should(Equal(ErrSchemaFetchJobStopped))
+	})
+
 	ginkgo.It("should report errors", func() {
 		someError := errors.New("some error")
 
@@ -130,4 +222,68 @@ var _ = ginkgo.Describe("schema fetch job", func() {
 		mockSchemaMutator.On("DeleteTable", "testTable4").Return(someError).Once()
 		job.FetchSchema()
 	})
+
+	ginkgo.Context("with schema delta", func() {
+		ginkgo.BeforeEach(func() {
+			job.schemaDeltaUnsupported = false
+			mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil)
+			mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil)
+			mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil)
+			mockSchemaMutator.On("GetTable", "testTable4").Return(&common.Table{Name: "testTable4"}, nil)
+		})
+
+		ginkgo.It("should work with no schema changes", func() {
+			mockControllerCli.On("GetSchemaDelta", "cluster1", mock.Anything).Return(nil, nil).Once()
+			job.FetchSchema()
+			Ω(job.hash).Should(Equal("123"))
+			mockControllerCli.AssertNotCalled(ginkgo.GinkgoT(), "GetAllSchema", mock.Anything)
+		})
+
+		ginkgo.It("should apply schema delta", func() {
+			mockControllerCli.On("GetSchemaDelta", "cluster1", mock.MatchedBy(func(deltaRequest models.SchemaDeltaRequest) bool {
+				return deltaRequest.Hash == "123" && len(deltaRequest.TableHashes) == 3
+			})).Return(&models.SchemaDelta{
+				Hash:          "456",
+				UpdatedTables: []common.Table{testTable1, testTable2m},
+				DeletedTables: []string{"testTable4", "testTable5"},
+			}, nil).Once()
+			mockSchemaMutator.On("CreateTable", mock.Anything).Return(nil).Once()
+			mockSchemaMutator.On("UpdateTable", testTable2m).Return(nil).Once()
+			mockSchemaMutator.On("DeleteTable", "testTable4").Return(nil).Once()
+			mockSchemaValidator.On("SetNewTable", mock.Anything).Return(nil)
+			mockSchemaValidator.On("SetOldTable", mock.Anything).Return(nil)
+			mockSchemaValidator.On("Validate").Return(nil)
+			job.FetchSchema()
+			Ω(job.hash).Should(Equal("456"))
+			mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+			mockSchemaMutator.AssertNotCalled(ginkgo.GinkgoT(), "DeleteTable", "testTable5")
+		})
+
+		ginkgo.It("should fall back to fetching all schemas", func() {
+			mockControllerCli.On("GetSchemaDelta", "cluster1", mock.Anything).Return(nil, controllerCli.ErrSchemaDeltaNotSupported).Once()
+			mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil).Twice()
+			job.FetchSchema()
+			Ω(job.schemaDeltaUnsupported).Should(BeTrue())
+
+			// schema delta is not requested again.
+			job.FetchSchema()
+			mockControllerCli.AssertNumberOfCalls(ginkgo.GinkgoT(), "GetSchemaDelta", 1)
+		})
+
+		ginkgo.It("should report errors", func() {
+			someError := errors.New("some error")
+			mockControllerCli.On("GetSchemaDelta", "cluster1", mock.Anything).Return(nil, someError).Once()
+			job.FetchSchema()
+			Ω(job.hash).Should(Equal("123"))
+			Ω(job.schemaDeltaUnsupported).Should(BeFalse())
+
+			mockControllerCli.On("GetSchemaDelta", "cluster1", mock.Anything).Return(&models.SchemaDelta{
+				Hash:          "456",
+				DeletedTables: []string{"testTable4"},
+			}, nil).Once()
+			mockSchemaMutator.On("DeleteTable", "testTable4").Return(someError).Once()
+			job.FetchSchema()
+			Ω(job.hash).Should(Equal("123"))
+		})
+	})
 })
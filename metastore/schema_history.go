@@ -0,0 +1,299 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// schemaHistoryEntry is the content of a schema history file, which records the change
+// resulting in the version together with the full table schema of the version.
+// sample:
+// 	/root_path/metastore/{$table}/history/{$version}
+type schemaHistoryEntry struct {
+	common.SchemaChange
+	Table common.Table `json:"table"`
+}
+
+// schemaChangeOptions are options of schema changes made through schemaChangeMutator.
+type schemaChangeOptions struct {
+	// actor recorded in schema history.
+	actor string
+	// whether warnings from schema change rules are overridden.
+	force bool
+	// whether changes require existing tables to be at expectedVersion.
+	checkVersion    bool
+	expectedVersion int
+}
+
+// maxSchemaChangeAttempts is the max number of attempts of a read-modify-write schema change
+// retried on version conflicts.
+const maxSchemaChangeAttempts = 5
+
+// checkTableVersion returns ErrSchemaVersionConflict if the existing table is not at the expected version.
+func (opts schemaChangeOptions) checkTableVersion(table *common.Table) error {
+	if opts.checkVersion && table.Version != opts.expectedVersion {
+		utils.GetRootReporter().GetCounter(utils.SchemaVersionConflicts).Inc(1)
+		return ErrSchemaVersionConflict
+	}
+	return nil
+}
+
+// RetryOnVersionConflict runs the read-modify-write schema change, which should read the latest
+// schema and make the change through ExpectVersion, until it does not fail with ErrSchemaVersionConflict
+// or max attempts are reached.
+func RetryOnVersionConflict(change func() error) (err error) {
+	for attempts := 1; ; attempts++ {
+		if err = change(); err != ErrSchemaVersionConflict || attempts >= maxSchemaChangeAttempts {
+			return
+		}
+		utils.GetLogger().With("attempts", attempts).Warn("Schema version conflict, retrying on the latest schema")
+	}
+}
+
+// schemaChangeMutator is the TableSchemaMutator applying schemaChangeOptions to schema changes made through it.
+type schemaChangeMutator struct {
+	*diskMetaStore
+	opts schemaChangeOptions
+}
+
+// WithActor returns a TableSchemaMutator recording actor in the schema history for changes
+// made through it.
+func (dm *diskMetaStore) WithActor(actor string) common.TableSchemaMutator {
+	return schemaChangeMutator{diskMetaStore: dm, opts: schemaChangeOptions{actor: actor}}
+}
+
+// ForceWithActor is like WithActor, but also overrides warnings from schema change rules
+// for changes made through it.
+func (dm *diskMetaStore) ForceWithActor(actor string) common.TableSchemaMutator {
+	return schemaChangeMutator{diskMetaStore: dm, opts: schemaChangeOptions{actor: actor, force: true}}
+}
+
+// ExpectVersion returns a TableSchemaMutator whose changes to existing tables fail with
+// ErrSchemaVersionConflict unless the table is at expectedVersion.
+func (dm *diskMetaStore) ExpectVersion(expectedVersion int) common.TableSchemaMutator {
+	return schemaChangeMutator{diskMetaStore: dm}.ExpectVersion(expectedVersion)
+}
+
+// ExpectVersion is like diskMetaStore.ExpectVersion and keeps other options of the mutator.
+func (m schemaChangeMutator) ExpectVersion(expectedVersion int) common.TableSchemaMutator {
+	m.opts.checkVersion, m.opts.expectedVersion = true, expectedVersion
+	return m
+}
+
+// CreateTable creates a new Table.
+func (m schemaChangeMutator) CreateTable(table *common.Table) error {
+	return m.createTableWith(m.opts, table)
+}
+
+// UpdateTableConfig updates table configurations.
+func (m schemaChangeMutator) UpdateTableConfig(tableName string, config common.TableConfig) error {
+	return m.updateTableConfigWith(m.opts, tableName, config)
+}
+
+// UpdateTable updates table schema and config.
+func (m schemaChangeMutator) UpdateTable(table common.Table) error {
+	return m.updateTableWith(m.opts, table)
+}
+
+// UpdateTableMetadata replaces table metadata.
+func (m schemaChangeMutator) UpdateTableMetadata(tableName string, metadata common.SchemaMetadata) error {
+	return m.updateTableMetadataWith(m.opts, tableName, metadata)
+}
+
+// AddColumn adds a new column.
+func (m schemaChangeMutator) AddColumn(tableName string, column common.Column, appendToArchivingSortOrder bool) error {
+	return m.addColumnWith(m.opts, tableName, column, appendToArchivingSortOrder)
+}
+
+// UpdateColumn updates column config.
+func (m schemaChangeMutator) UpdateColumn(tableName string, columnName string, config common.ColumnConfig) error {
+	return m.updateColumnWith(m.opts, tableName, columnName, config)
+}
+
+// UpdateColumnMetadata replaces column metadata.
+func (m schemaChangeMutator) UpdateColumnMetadata(tableName string, columnName string, metadata common.SchemaMetadata) error {
+	return m.updateColumnMetadataWith(m.opts, tableName, columnName, metadata)
+}
+
+// DeleteColumn deletes a column.
+func (m schemaChangeMutator) DeleteColumn(tableName string, columnName string) error {
+	return m.deleteColumnWith(m.opts, tableName, columnName)
+}
+
+// RenameColumn renames a column and keeps its old name as an alias.
+func (m schemaChangeMutator) RenameColumn(tableName string, columnName string, newName string, aliasExpiresAt int64) error {
+	return m.renameColumnWith(m.opts, tableName, columnName, newName, aliasExpiresAt)
+}
+
+// DeleteColumnAlias removes an alias of a renamed column.
+func (m schemaChangeMutator) DeleteColumnAlias(tableName string, columnName string, alias string) error {
+	return m.deleteColumnAliasWith(m.opts, tableName, columnName, alias)
+}
+
+// DeprecateColumn deprecates a column until it's deleted at purgeAt.
+func (m schemaChangeMutator) DeprecateColumn(tableName string, columnName string, purgeAt int64) error {
+	return m.deprecateColumnWith(m.opts, tableName, columnName, purgeAt)
+}
+
+// GetSchemaHistory returns the recorded schema changes of the table ordered by version.
+// Tables created before schema history was recorded only have changes made afterwards.
+// return
+// 	ErrTableDoesNotExist if table does not exist
+func (dm *diskMetaStore) GetSchemaHistory(tableName string) ([]common.SchemaChange, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+
+	if err := dm.tableExists(tableName); err != nil {
+		return nil, err
+	}
+
+	historyFiles, err := dm.ReadDir(dm.getSchemaHistoryDirPath(tableName))
+	if os.IsNotExist(err) {
+		return []common.SchemaChange{}, nil
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to read schema history dir, table: %s", tableName)
+	}
+
+	changes := make([]common.SchemaChange, 0, len(historyFiles))
+	for _, historyFile := range historyFiles {
+		version, err := strconv.Atoi(historyFile.Name())
+		if err != nil {
+			// skip unknown files.
+			continue
+		}
+		entry, err := dm.readSchemaHistoryFile(tableName, version)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, entry.SchemaChange)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Version < changes[j].Version
+	})
+	return changes, nil
+}
+
+// GetTableAtVersion returns the table schema as of the specified version.
+// return
+// 	ErrTableDoesNotExist if table does not exist
+// 	ErrSchemaVersionDoesNotExist if the version is not recorded in schema history
+func (dm *diskMetaStore) GetTableAtVersion(tableName string, version int) (*common.Table, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+
+	if err := dm.tableExists(tableName); err != nil {
+		return nil, err
+	}
+
+	entry, err := dm.readSchemaHistoryFile(tableName, version)
+	if err == nil {
+		return &entry.Table, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// current version of tables created before schema history was recorded.
+	table, err := dm.readSchemaFile(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if table.Version != version {
+		return nil, ErrSchemaVersionDoesNotExist
+	}
+	return table, nil
+}
+
+// writeSchemaHistory records the change resulting in the current version of the table. Failures
+// are only logged since the schema change itself is already persisted.
+func (dm *diskMetaStore) writeSchemaHistory(table *common.Table, actor, mutation, column string) {
+	entry := schemaHistoryEntry{
+		SchemaChange: common.SchemaChange{
+			Version:   table.Version,
+			Timestamp: utils.Now().Unix(),
+			Actor:     actor,
+			Mutation:  mutation,
+			Column:    column,
+		},
+		Table: *table,
+	}
+
+	if err := dm.writeSchemaHistoryFile(entry); err != nil {
+		utils.GetLogger().With(
+			"table", table.Name,
+			"version", table.Version,
+			"mutation", mutation,
+			"error", err.Error(),
+		).Error("Failed to record schema history")
+	}
+}
+
+func (dm *diskMetaStore) writeSchemaHistoryFile(entry schemaHistoryEntry) error {
+	entryBytes, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal schema history")
+	}
+
+	if err = dm.MkdirAll(dm.getSchemaHistoryDirPath(entry.Table.Name), 0755); err != nil {
+		return utils.StackError(err, "Failed to create schema history directory")
+	}
+
+	writer, err := dm.OpenFileForWrite(
+		dm.getSchemaHistoryFilePath(entry.Table.Name, entry.Version),
+		os.O_WRONLY|os.O_TRUNC|os.O_CREATE,
+		0644,
+	)
+	if err != nil {
+		return utils.StackError(err, "Failed to open schema history file for write, table: %s, version: %d",
+			entry.Table.Name, entry.Version)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(entryBytes)
+	return err
+}
+
+// readSchemaHistoryFile reads the schema history file of the version, the error
+// satisfies os.IsNotExist if the version is not recorded.
+func (dm *diskMetaStore) readSchemaHistoryFile(tableName string, version int) (*schemaHistoryEntry, error) {
+	entryBytes, err := dm.ReadFile(dm.getSchemaHistoryFilePath(tableName, version))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry schemaHistoryEntry
+	entry.Table.Config = DefaultTableConfig
+	if err = json.Unmarshal(entryBytes, &entry); err != nil {
+		return nil, utils.StackError(err, "Failed to unmarshal schema history, table: %s, version: %d",
+			tableName, version)
+	}
+	return &entry, nil
+}
+
+func (dm *diskMetaStore) getSchemaHistoryDirPath(tableName string) string {
+	return filepath.Join(dm.getTableDirPath(tableName), "history")
+}
+
+func (dm *diskMetaStore) getSchemaHistoryFilePath(tableName string, version int) string {
+	return filepath.Join(dm.getSchemaHistoryDirPath(tableName), strconv.Itoa(version))
+}
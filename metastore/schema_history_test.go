@@ -0,0 +1,138 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("schema history", func() {
+	var basePath string
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		basePath, err = ioutil.TempDir("", "schema_history")
+		Ω(err).Should(BeNil())
+		utils.SetCurrentTime(time.Unix(100, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(basePath)
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("should record and read schema history", func() {
+		metaStore, err := NewDiskMetaStore(basePath)
+		Ω(err).Should(BeNil())
+
+		table := common.Table{
+			Name: "dim",
+			Columns: []common.Column{
+				{Name: "id", Type: common.UUID},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config:            DefaultTableConfig,
+		}
+		Ω(metaStore.WithActor("alice").CreateTable(&table)).Should(BeNil())
+		Ω(metaStore.WithActor("bob").AddColumn("dim", common.Column{Name: "name", Type: common.Uint32}, false)).Should(BeNil())
+		Ω(metaStore.UpdateColumn("dim", "name", common.ColumnConfig{Priority: 1})).Should(BeNil())
+
+		history, err := metaStore.GetSchemaHistory("dim")
+		Ω(err).Should(BeNil())
+		Ω(history).Should(Equal([]common.SchemaChange{
+			{Version: 0, Timestamp: 100, Actor: "alice", Mutation: common.SchemaMutationCreateTable},
+			{Version: 1, Timestamp: 100, Actor: "bob", Mutation: common.SchemaMutationAddColumn, Column: "name"},
+			{Version: 2, Timestamp: 100, Mutation: common.SchemaMutationUpdateColumn, Column: "name"},
+		}))
+
+		oldTable, err := metaStore.GetTableAtVersion("dim", 1)
+		Ω(err).Should(BeNil())
+		Ω(oldTable.Version).Should(Equal(1))
+		Ω(oldTable.Columns).Should(HaveLen(2))
+		Ω(oldTable.Columns[1].Config.Priority).Should(BeEquivalentTo(0))
+
+		_, err = metaStore.GetTableAtVersion("dim", 3)
+		Ω(err).Should(Equal(ErrSchemaVersionDoesNotExist))
+		_, err = metaStore.GetTableAtVersion("unknown", 1)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		// stale version should not overwrite the version chain.
+		newTable, err := metaStore.GetTable("dim")
+		Ω(err).Should(BeNil())
+		newTable.Version = 1
+		Ω(metaStore.UpdateTable(*newTable)).Should(Equal(ErrIllegalSchemaVersion))
+	})
+
+	ginkgo.It("should read current version of tables without schema history", func() {
+		metaStore, err := NewDiskMetaStore(basePath)
+		Ω(err).Should(BeNil())
+
+		table := common.Table{
+			Name: "dim",
+			Columns: []common.Column{
+				{Name: "id", Type: common.UUID},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config:            DefaultTableConfig,
+			Version:           3,
+		}
+		Ω(metaStore.CreateTable(&table)).Should(BeNil())
+		Ω(os.RemoveAll(metaStore.(*diskMetaStore).getSchemaHistoryDirPath("dim"))).Should(BeNil())
+
+		history, err := metaStore.GetSchemaHistory("dim")
+		Ω(err).Should(BeNil())
+		Ω(history).Should(BeEmpty())
+
+		currentTable, err := metaStore.GetTableAtVersion("dim", 3)
+		Ω(err).Should(BeNil())
+		Ω(*currentTable).Should(Equal(table))
+	})
+
+	ginkgo.It("DiffTables should work", func() {
+		oldTable := common.Table{
+			Name: "dim",
+			Columns: []common.Column{
+				{Name: "id", Type: common.UUID},
+				{Name: "name", Type: common.Uint32, Config: common.ColumnConfig{Priority: 1}},
+			},
+			PrimaryKeyColumns: []int{0},
+			Version:           1,
+		}
+		newTable := oldTable
+		newTable.Columns = []common.Column{
+			oldTable.Columns[0],
+			{Name: "name", Type: common.Uint32, Deleted: true},
+			{Name: "city", Type: common.SmallEnum},
+		}
+		newTable.Version = 2
+
+		diffs, err := DiffTables(&oldTable, &newTable)
+		Ω(err).Should(BeNil())
+		Ω(diffs).Should(Equal([]common.SchemaFieldDiff{
+			{Field: "columns[1].config.priority", Type: common.SchemaFieldRemoved, OldValue: float64(1)},
+			{Field: "columns[1].deleted", Type: common.SchemaFieldAdded, NewValue: true},
+			{Field: "columns[2].name", Type: common.SchemaFieldAdded, NewValue: "city"},
+			{Field: "columns[2].type", Type: common.SchemaFieldAdded, NewValue: common.SmallEnum},
+			{Field: "version", Type: common.SchemaFieldChanged, OldValue: float64(1), NewValue: float64(2)},
+		}))
+	})
+})
@@ -0,0 +1,205 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// Names of built-in schema change rules.
+const (
+	SchemaRuleColumnTypeChange        = "columnTypeChange"
+	SchemaRulePrimaryKeyColumnRemoval = "primaryKeyColumnRemoval"
+	SchemaRuleEnumDefaultValue        = "enumDefaultValue"
+)
+
+// PendingSchemaChange is a schema change checked by schema change rules before it is persisted.
+type PendingSchemaChange struct {
+	// OldTable is nil for table creation.
+	OldTable *common.Table
+	NewTable *common.Table
+	// GetEnumCases returns existing enum cases of the column, nil if enum dicts are not available.
+	GetEnumCases func(columnName string) ([]string, error)
+}
+
+// SchemaChangeRule checks a pending schema change and returns the violations found.
+type SchemaChangeRule func(change PendingSchemaChange) []common.SchemaViolation
+
+var (
+	schemaChangeRulesLock sync.RWMutex
+	// deployment specific rules registered via RegisterSchemaChangeRule.
+	schemaChangeRules        []SchemaChangeRule
+	builtinSchemaChangeRules = []SchemaChangeRule{
+		checkColumnTypeChange,
+		checkPrimaryKeyColumnRemoval,
+		checkEnumDefaultValue,
+	}
+)
+
+// RegisterSchemaChangeRule registers a deployment specific rule, which will be evaluated after
+// built-in rules for every schema change. It should be called before serving schema changes.
+func RegisterSchemaChangeRule(rule SchemaChangeRule) {
+	schemaChangeRulesLock.Lock()
+	defer schemaChangeRulesLock.Unlock()
+	schemaChangeRules = append(schemaChangeRules, rule)
+}
+
+// CheckSchemaChange evaluates built-in and registered rules against the schema change and returns
+// all violations found.
+func CheckSchemaChange(change PendingSchemaChange) []common.SchemaViolation {
+	var violations []common.SchemaViolation
+	for _, rule := range builtinSchemaChangeRules {
+		violations = append(violations, rule(change)...)
+	}
+
+	schemaChangeRulesLock.RLock()
+	defer schemaChangeRulesLock.RUnlock()
+	for _, rule := range schemaChangeRules {
+		violations = append(violations, rule(change)...)
+	}
+	return violations
+}
+
+// SchemaViolationsError is returned when a schema change is rejected by schema change rules.
+type SchemaViolationsError struct {
+	Violations []common.SchemaViolation `json:"violations"`
+}
+
+func (e *SchemaViolationsError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = fmt.Sprintf("[%s] %s: %s", violation.Severity, violation.Rule, violation.Message)
+	}
+	return fmt.Sprintf("Schema change rejected: %s", strings.Join(messages, "; "))
+}
+
+// validateSchemaChange evaluates schema change rules and returns SchemaViolationsError if any violation
+// is an error, or any violation is a warning and the change is not forced.
+func validateSchemaChange(change PendingSchemaChange, force bool) error {
+	violations := CheckSchemaChange(change)
+	for _, violation := range violations {
+		if violation.Severity == common.SchemaViolationError || !force {
+			return &SchemaViolationsError{Violations: violations}
+		}
+	}
+
+	if len(violations) > 0 {
+		utils.GetLogger().With(
+			"table", change.NewTable.Name,
+			"violations", violations,
+		).Warn("Schema change warnings overridden by force")
+	}
+	return nil
+}
+
+// checkColumnTypeChange rejects type changes of existing columns, which would reinterpret existing data.
+func checkColumnTypeChange(change PendingSchemaChange) (violations []common.SchemaViolation) {
+	if change.OldTable == nil {
+		return
+	}
+
+	for columnID, oldColumn := range change.OldTable.Columns {
+		if oldColumn.Deleted || columnID >= len(change.NewTable.Columns) {
+			continue
+		}
+		newColumn := change.NewTable.Columns[columnID]
+		if newColumn.Type != oldColumn.Type {
+			violations = append(violations, common.SchemaViolation{
+				Rule:     SchemaRuleColumnTypeChange,
+				Severity: common.SchemaViolationError,
+				Column:   oldColumn.Name,
+				Message: fmt.Sprintf("type of column %s cannot be changed from %s to %s, existing data would be reinterpreted",
+					oldColumn.Name, oldColumn.Type, newColumn.Type),
+			})
+		}
+	}
+	return
+}
+
+// checkPrimaryKeyColumnRemoval rejects removing columns from primary key or deleting primary key columns.
+func checkPrimaryKeyColumnRemoval(change PendingSchemaChange) (violations []common.SchemaViolation) {
+	if change.OldTable == nil {
+		return
+	}
+
+	for _, columnID := range change.OldTable.PrimaryKeyColumns {
+		if columnID >= len(change.OldTable.Columns) {
+			continue
+		}
+		columnName := change.OldTable.Columns[columnID].Name
+		if utils.IndexOfInt(change.NewTable.PrimaryKeyColumns, columnID) < 0 ||
+			columnID >= len(change.NewTable.Columns) ||
+			change.NewTable.Columns[columnID].Deleted {
+			violations = append(violations, common.SchemaViolation{
+				Rule:     SchemaRulePrimaryKeyColumnRemoval,
+				Severity: common.SchemaViolationError,
+				Column:   columnName,
+				Message:  fmt.Sprintf("primary key column %s cannot be removed", columnName),
+			})
+		}
+	}
+	return
+}
+
+// checkEnumDefaultValue requires default values of existing enum columns to be existing enum cases. Default
+// values of new enum columns become their first enum case so they only cannot be the enum overflow case.
+func checkEnumDefaultValue(change PendingSchemaChange) (violations []common.SchemaViolation) {
+	for columnID, column := range change.NewTable.Columns {
+		if column.Deleted || !column.IsEnumColumn() || column.DefaultValue == nil {
+			continue
+		}
+
+		defaultValue := *column.DefaultValue
+		if defaultValue == common.EnumOverflowCase {
+			violations = append(violations, common.SchemaViolation{
+				Rule:     SchemaRuleEnumDefaultValue,
+				Severity: common.SchemaViolationError,
+				Column:   column.Name,
+				Message:  fmt.Sprintf("default value of column %s cannot be the enum overflow case %s", column.Name, defaultValue),
+			})
+			continue
+		}
+
+		newColumn := change.OldTable == nil || columnID >= len(change.OldTable.Columns) ||
+			change.OldTable.Columns[columnID].Deleted
+		if newColumn || change.GetEnumCases == nil {
+			continue
+		}
+
+		enumCases, err := change.GetEnumCases(column.Name)
+		if err != nil {
+			utils.GetLogger().With(
+				"table", change.NewTable.Name,
+				"column", column.Name,
+				"error", err.Error(),
+			).Warn("Failed to read enum cases for validating default value")
+			continue
+		}
+		if utils.IndexOfStr(enumCases, defaultValue) < 0 {
+			violations = append(violations, common.SchemaViolation{
+				Rule:     SchemaRuleEnumDefaultValue,
+				Severity: common.SchemaViolationError,
+				Column:   column.Name,
+				Message:  fmt.Sprintf("default value %s of column %s is not an existing enum case", defaultValue, column.Name),
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,150 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("schema change rules", func() {
+	defaultValue := "foo"
+	overflowCase := common.EnumOverflowCase
+
+	var oldTable common.Table
+	var registeredRules []SchemaChangeRule
+
+	ginkgo.BeforeEach(func() {
+		oldTable = common.Table{
+			Name: "t",
+			Columns: []common.Column{
+				{Name: "id", Type: common.UUID},
+				{Name: "city", Type: common.SmallEnum, DefaultValue: &defaultValue},
+				{Name: "value", Type: common.Uint32},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config:            DefaultTableConfig,
+		}
+		registeredRules = schemaChangeRules
+	})
+
+	ginkgo.AfterEach(func() {
+		schemaChangeRules = registeredRules
+	})
+
+	copyTable := func(table common.Table) common.Table {
+		table.Columns = append([]common.Column{}, table.Columns...)
+		table.PrimaryKeyColumns = append([]int{}, table.PrimaryKeyColumns...)
+		table.Version++
+		return table
+	}
+
+	ginkgo.It("should report column type changes", func() {
+		newTable := copyTable(oldTable)
+		newTable.Columns[2].Type = common.Int64
+		Ω(CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable})).Should(Equal([]common.SchemaViolation{
+			{
+				Rule:     SchemaRuleColumnTypeChange,
+				Severity: common.SchemaViolationError,
+				Column:   "value",
+				Message:  "type of column value cannot be changed from Uint32 to Int64, existing data would be reinterpreted",
+			},
+		}))
+
+		// type changes of deleted columns are not reported.
+		oldTable.Columns[2].Deleted = true
+		newTable.Columns[2].Deleted = true
+		Ω(CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable})).Should(BeEmpty())
+	})
+
+	ginkgo.It("should report primary key column removals", func() {
+		newTable := copyTable(oldTable)
+		newTable.PrimaryKeyColumns = []int{2}
+		violations := CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable})
+		Ω(violations).Should(HaveLen(1))
+		Ω(violations[0].Rule).Should(Equal(SchemaRulePrimaryKeyColumnRemoval))
+		Ω(violations[0].Column).Should(Equal("id"))
+
+		newTable = copyTable(oldTable)
+		newTable.Columns[0].Deleted = true
+		violations = CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable})
+		Ω(violations).Should(HaveLen(1))
+		Ω(violations[0].Rule).Should(Equal(SchemaRulePrimaryKeyColumnRemoval))
+	})
+
+	ginkgo.It("should report enum default values not being existing cases", func() {
+		enumCases := []string{"foo"}
+		getEnumCases := func(columnName string) ([]string, error) {
+			return enumCases, nil
+		}
+
+		newTable := copyTable(oldTable)
+		Ω(CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable, GetEnumCases: getEnumCases})).Should(BeEmpty())
+
+		enumCases = []string{"bar"}
+		violations := CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable, GetEnumCases: getEnumCases})
+		Ω(violations).Should(HaveLen(1))
+		Ω(violations[0].Rule).Should(Equal(SchemaRuleEnumDefaultValue))
+		Ω(violations[0].Column).Should(Equal("city"))
+
+		// enum cases not available.
+		getEnumCases = func(columnName string) ([]string, error) {
+			return nil, errors.New("failed to read enum cases")
+		}
+		Ω(CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable, GetEnumCases: getEnumCases})).Should(BeEmpty())
+
+		// new enum column with default value of overflow case.
+		newTable.Columns = append(newTable.Columns, common.Column{Name: "country", Type: common.SmallEnum, DefaultValue: &overflowCase})
+		violations = CheckSchemaChange(PendingSchemaChange{OldTable: &oldTable, NewTable: &newTable})
+		Ω(violations).Should(HaveLen(1))
+		Ω(violations[0].Rule).Should(Equal(SchemaRuleEnumDefaultValue))
+		Ω(violations[0].Column).Should(Equal("country"))
+	})
+
+	ginkgo.It("validator should evaluate registered rules and only override warnings by force", func() {
+		severity := common.SchemaViolationWarning
+		RegisterSchemaChangeRule(func(change PendingSchemaChange) []common.SchemaViolation {
+			if change.OldTable != nil && len(change.NewTable.Columns) > 2 && change.NewTable.Columns[2].Deleted {
+				return []common.SchemaViolation{
+					{Rule: "dashboardColumn", Severity: severity, Column: "value", Message: "column value is used by dashboards"},
+				}
+			}
+			return nil
+		})
+
+		newTable := copyTable(oldTable)
+		newTable.Columns[2].Deleted = true
+
+		validator := NewTableSchameValidator()
+		validator.SetOldTable(oldTable)
+		validator.SetNewTable(newTable)
+		err := validator.Validate()
+		Ω(err).Should(Equal(&SchemaViolationsError{
+			Violations: []common.SchemaViolation{
+				{Rule: "dashboardColumn", Severity: severity, Column: "value", Message: "column value is used by dashboards"},
+			},
+		}))
+		Ω(err.Error()).Should(Equal("Schema change rejected: [warning] dashboardColumn: column value is used by dashboards"))
+
+		validator.SetForce(true)
+		Ω(validator.Validate()).Should(BeNil())
+
+		severity = common.SchemaViolationError
+		Ω(validator.Validate()).ShouldNot(BeNil())
+	})
+})
@@ -27,6 +27,8 @@ import (
 type TableSchemaValidator interface {
 	SetOldTable(table common.Table)
 	SetNewTable(table common.Table)
+	// SetForce sets whether warnings from schema change rules are overridden, errors can never be overridden.
+	SetForce(force bool)
 	Validate() error
 }
 
@@ -38,6 +40,9 @@ func NewTableSchameValidator() TableSchemaValidator {
 type tableSchemaValidatorImpl struct {
 	newTable *common.Table
 	oldTable *common.Table
+	force    bool
+	// optional, used by schema change rules checking enum cases.
+	getEnumCases func(columnName string) ([]string, error)
 }
 
 func (v *tableSchemaValidatorImpl) SetOldTable(table common.Table) {
@@ -48,7 +53,20 @@ func (v *tableSchemaValidatorImpl) SetNewTable(table common.Table) {
 	v.newTable = &table
 }
 
+func (v *tableSchemaValidatorImpl) SetForce(force bool) {
+	v.force = force
+}
+
 func (v tableSchemaValidatorImpl) Validate() (err error) {
+	err = validateSchemaChange(PendingSchemaChange{
+		OldTable:     v.oldTable,
+		NewTable:     v.newTable,
+		GetEnumCases: v.getEnumCases,
+	}, v.force)
+	if err != nil {
+		return err
+	}
+
 	if v.oldTable == nil {
 		return v.validateIndividualSchema(v.newTable, true)
 	}
@@ -63,6 +81,11 @@ func validateColumnHLLConfig(c common.Column) error {
 				c.Type, common.Uint32, common.Int32, common.Int64, common.UUID)
 		}
 	}
+	if c.HLLConfig.Precision != 0 &&
+		(c.HLLConfig.Precision < common.MinHLLPrecision || c.HLLConfig.Precision > common.DefaultHLLPrecision) {
+		return fmt.Errorf("hll precision %d not allowed, valid range: [%d, %d]",
+			c.HLLConfig.Precision, common.MinHLLPrecision, common.DefaultHLLPrecision)
+	}
 	return nil
 }
 
@@ -77,6 +100,8 @@ func validateColumnHLLConfig(c common.Column) error {
 //	column name cannot duplicate
 //  check hll cannot be enabled on time column
 //  check column configs
+//  default value backfill requires default value
+//  column aliases do not duplicate column names
 func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table, creation bool) (err error) {
 	var colIdDedup []bool
 
@@ -91,6 +116,13 @@ func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table,
 		}
 		colNameDedup[column.Name] = true
 
+		for _, alias := range column.Aliases {
+			if creation || column.Deleted || colNameDedup[alias.Name] {
+				return ErrIllegalColumnAlias
+			}
+			colNameDedup[alias.Name] = true
+		}
+
 		// validate data type
 		if dataType := memCom.DataTypeFromString(column.Type); dataType == memCom.Unknown {
 			return ErrInvalidDataType
@@ -121,6 +153,18 @@ func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table,
 			if err != nil {
 				return err
 			}
+		} else if column.BackfillDefault {
+			return ErrBackfillDefaultWithoutDefaultValue
+		}
+
+		if column.Config.EnumCardinalityCap < 0 || (column.Config.EnumCardinalityCap > 0 && !column.IsEnumColumn()) {
+			return ErrInvalidEnumCardinalityCap
+		}
+
+		// deprecated columns will be deleted.
+		if column.Deprecated && !column.Deleted && ((table.IsFactTable && columnID == 0) || column.PurgeAt <= 0 ||
+			utils.IndexOfInt(table.PrimaryKeyColumns, columnID) >= 0 || utils.IndexOfInt(table.ArchivingSortColumns, columnID) >= 0) {
+			return ErrIllegalColumnDeprecation
 		}
 	}
 	if nonDeletedColumnsCount == 0 {
@@ -145,8 +189,8 @@ func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table,
 		colIdDedup[colId] = true
 	}
 
-	if err := validator.Validate(table.Config); err != nil {
-		return utils.StackError(err, "invalid table config")
+	if err := ValidateTableConfig(table.Config); err != nil {
+		return err
 	}
 
 	if table.IsFactTable {
@@ -175,6 +219,7 @@ func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table,
 //	check updates on columns and sort columns are valid
 //  check allowMissingEventTime cannot be changed from true to false
 //  check hllConfig cannot be changed
+//  check enum cardinality cap can only be raised
 func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *common.Table) (err error) {
 	if err := v.validateIndividualSchema(newTable, false); err != nil {
 		return err
@@ -208,16 +253,28 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 				return ErrReusingColumnIDNotAllowed
 			}
 		}
+		if err = validateColumnRename(oldCol, newCol); err != nil {
+			return err
+		}
+		if err = ValidateEnumCardinalityCapUpdate(oldCol.Config, newCol.Config); err != nil {
+			return err
+		}
 		// check that no column configs are modified, even for deleted columns
-		if oldCol.Name != newCol.Name ||
-			oldCol.Type != newCol.Type ||
+		if oldCol.Type != newCol.Type ||
 			!reflect.DeepEqual(oldCol.DefaultValue, newCol.DefaultValue) ||
+			oldCol.BackfillDefault != newCol.BackfillDefault ||
 			oldCol.CaseInsensitive != newCol.CaseInsensitive ||
 			oldCol.DisableAutoExpand != newCol.DisableAutoExpand ||
-			oldCol.HLLConfig != newCol.HLLConfig {
+			oldCol.HLLConfig.IsHLLColumn != newCol.HLLConfig.IsHLLColumn {
 			return ErrSchemaUpdateNotAllowed
 		}
 	}
+	// new columns cannot have aliases
+	for ; i < len(newTable.Columns); i++ {
+		if len(newTable.Columns[i].Aliases) > 0 {
+			return ErrIllegalColumnAlias
+		}
+	}
 	// end validate columns
 
 	// primary key columns
@@ -246,6 +303,33 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 	return
 }
 
+// ValidateTableConfig validates table configs individually and the constraints between them,
+// unset configs are not checked against other configs.
+func ValidateTableConfig(config common.TableConfig) error {
+	if err := validator.Validate(config); err != nil {
+		return utils.StackError(err, "invalid table config")
+	}
+
+	if config.RecordRetentionInDays > 0 &&
+		int64(config.RecordRetentionInDays)*24*60 < int64(config.ArchivingDelayMinutes) {
+		return ErrRetentionShorterThanArchivingDelay
+	}
+
+	if config.BackfillMaxBufferSize > 0 && config.BackfillThresholdInBytes > config.BackfillMaxBufferSize {
+		return ErrBackfillThresholdExceedsMaxBufferSize
+	}
+	return nil
+}
+
+// ValidateEnumCardinalityCapUpdate validates the enum cardinality cap is not lowered or removed
+// once set since existing enum cases beyond a lower cap cannot be removed from the enum dict.
+func ValidateEnumCardinalityCapUpdate(oldConfig, newConfig common.ColumnConfig) error {
+	if oldConfig.EnumCardinalityCap > 0 && newConfig.EnumCardinalityCap < oldConfig.EnumCardinalityCap {
+		return ErrDecreaseEnumCardinalityCap
+	}
+	return nil
+}
+
 // ValidateDefaultValue validates default value against data type
 func ValidateDefaultValue(valueStr, dataTypeStr string) (err error) {
 	dataType := memCom.DataTypeFromString(dataTypeStr)
@@ -261,3 +345,37 @@ func ValidateDefaultValue(valueStr, dataTypeStr string) (err error) {
 	}
 	return err
 }
+
+// validateColumnRename checks that the column is only renamed with its old name kept as an alias,
+// and aliases are only added by renaming.
+func validateColumnRename(oldCol, newCol common.Column) error {
+	renamed := oldCol.Name != newCol.Name
+	if renamed && (oldCol.Deleted || newCol.Deleted) {
+		return ErrSchemaUpdateNotAllowed
+	}
+	if renamed && newCol.IsEnumColumn() {
+		return ErrRenameEnumColumn
+	}
+
+	var hasOldName bool
+	for _, alias := range newCol.Aliases {
+		if renamed && alias.Name == oldCol.Name {
+			hasOldName = true
+			continue
+		}
+		var found bool
+		for _, oldAlias := range oldCol.Aliases {
+			if oldAlias.Name == alias.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrIllegalColumnAlias
+		}
+	}
+	if renamed && !hasOldName {
+		return ErrSchemaUpdateNotAllowed
+	}
+	return nil
+}
@@ -509,6 +509,170 @@ var _ = ginkgo.Describe("Validator", func() {
 		Ω(err).Should(Equal(ErrIllegalChangeSortColumn))
 	})
 
+	ginkgo.It("should validate column rename", func() {
+		oldTable := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "Uint32",
+				},
+				{
+					Name: "col3",
+					Type: "SmallEnum",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			IsFactTable:       true,
+			Config:            DefaultTableConfig,
+		}
+		renamed := func(mutate func(columns []common.Column)) common.Table {
+			newTable := oldTable
+			newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+			mutate(newTable.Columns)
+			return newTable
+		}
+		validate := func(newTable common.Table) error {
+			validator := NewTableSchameValidator()
+			validator.SetNewTable(newTable)
+			validator.SetOldTable(oldTable)
+			return validator.Validate()
+		}
+
+		// rename without keeping old name.
+		Ω(validate(renamed(func(columns []common.Column) {
+			columns[1].Name = "col2New"
+		}))).Should(Equal(ErrSchemaUpdateNotAllowed))
+		// rename enum column.
+		Ω(validate(renamed(func(columns []common.Column) {
+			columns[2].Name = "col3New"
+			columns[2].Aliases = []common.ColumnAlias{{Name: "col3", ExpiresAt: 1}}
+		}))).Should(Equal(ErrRenameEnumColumn))
+		// alias not from renaming.
+		Ω(validate(renamed(func(columns []common.Column) {
+			columns[1].Aliases = []common.ColumnAlias{{Name: "other", ExpiresAt: 1}}
+		}))).Should(Equal(ErrIllegalColumnAlias))
+		// alias conflicting with other column.
+		Ω(validate(renamed(func(columns []common.Column) {
+			columns[1].Name = "col2New"
+			columns[1].Aliases = []common.ColumnAlias{{Name: "col2", ExpiresAt: 1}}
+			columns[0].Name = "col2"
+			columns[0].Aliases = []common.ColumnAlias{{Name: "col1", ExpiresAt: 1}}
+		}))).Should(Equal(ErrIllegalColumnAlias))
+
+		// rename primary key column.
+		newTable := renamed(func(columns []common.Column) {
+			columns[0].Name = "col1New"
+			columns[0].Aliases = []common.ColumnAlias{{Name: "col1", ExpiresAt: 1}}
+		})
+		Ω(validate(newTable)).Should(BeNil())
+
+		// aliases can be removed.
+		oldTable = newTable
+		Ω(validate(renamed(func(columns []common.Column) {
+			columns[0].Aliases = nil
+		}))).Should(BeNil())
+	})
+
+	ginkgo.It("should validate default value backfill", func() {
+		dv := "foo"
+		oldTable := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			IsFactTable:       true,
+			Config:            DefaultTableConfig,
+		}
+		newTable := oldTable
+		newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+		newTable.Columns = append(newTable.Columns, common.Column{
+			Name:            "col2",
+			Type:            "SmallEnum",
+			BackfillDefault: true,
+		})
+
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(newTable)
+		validator.SetOldTable(oldTable)
+		Ω(validator.Validate()).Should(Equal(ErrBackfillDefaultWithoutDefaultValue))
+
+		newTable.Columns[1].DefaultValue = &dv
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(BeNil())
+
+		// backfill flag is immutable.
+		oldTable = newTable
+		newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+		newTable.Columns[1].BackfillDefault = false
+		validator.SetNewTable(newTable)
+		validator.SetOldTable(oldTable)
+		Ω(validator.Validate()).Should(Equal(ErrSchemaUpdateNotAllowed))
+	})
+
+	ginkgo.It("should validate enum cardinality cap", func() {
+		oldTable := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "SmallEnum",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			IsFactTable:       true,
+			Config:            DefaultTableConfig,
+		}
+		newTable := oldTable
+		newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+		newTable.Columns[0].Config.EnumCardinalityCap = 10
+
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(newTable)
+		validator.SetOldTable(oldTable)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidEnumCardinalityCap))
+
+		newTable.Columns[0].Config.EnumCardinalityCap = 0
+		newTable.Columns[1].Config.EnumCardinalityCap = -1
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidEnumCardinalityCap))
+
+		// cap can be set and raised.
+		newTable.Columns[1].Config.EnumCardinalityCap = 10
+		newTable.Version++
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(BeNil())
+
+		oldTable = newTable
+		newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+		newTable.Columns[1].Config.EnumCardinalityCap = 20
+		newTable.Version++
+		validator.SetNewTable(newTable)
+		validator.SetOldTable(oldTable)
+		Ω(validator.Validate()).Should(BeNil())
+
+		// but not lowered or removed.
+		newTable.Columns[1].Config.EnumCardinalityCap = 5
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrDecreaseEnumCardinalityCap))
+
+		newTable.Columns[1].Config.EnumCardinalityCap = 0
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrDecreaseEnumCardinalityCap))
+	})
+
 	ginkgo.It("ValidateDefaultValue should work", func() {
 		Ω(ValidateDefaultValue("trues", common.Bool)).ShouldNot(BeNil())
 		Ω(ValidateDefaultValue("true", common.Bool)).Should(BeNil())
@@ -725,6 +889,77 @@ var _ = ginkgo.Describe("Validator", func() {
 		validator.SetNewTable(table2)
 		err = validator.Validate()
 		Ω(err).Should(Equal(ErrTimeColumnDoesNotAllowHLLConfig))
+
+		table3 := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "Uint32",
+					HLLConfig: common.HLLConfig{
+						Precision: 16,
+					},
+				},
+			},
+			PrimaryKeyColumns: []int{1},
+			IsFactTable:       true,
+			Version:           0,
+			Config:            DefaultTableConfig,
+		}
+
+		validator = NewTableSchameValidator()
+		validator.SetNewTable(table3)
+		err = validator.Validate()
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("hll precision 16 not allowed, valid range: [10, 14]"))
+	})
+
+	ginkgo.It("should allow changing hll precision", func() {
+		oldTable := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "Uint32",
+					HLLConfig: common.HLLConfig{
+						IsHLLColumn: true,
+					},
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			Version:           0,
+			Config:            DefaultTableConfig,
+		}
+		newTable := oldTable
+		newTable.Version = 1
+		newTable.Columns = []common.Column{
+			oldTable.Columns[0],
+			{
+				Name: "col2",
+				Type: "Uint32",
+				HLLConfig: common.HLLConfig{
+					IsHLLColumn: true,
+					Precision:   12,
+				},
+			},
+		}
+
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(newTable)
+		validator.SetOldTable(oldTable)
+		Ω(validator.Validate()).Should(BeNil())
+
+		newTable.Columns[1].HLLConfig.IsHLLColumn = false
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrSchemaUpdateNotAllowed))
 	})
 
 	ginkgo.It("should fail when table config is invalid", func() {
@@ -750,4 +985,33 @@ var _ = ginkgo.Describe("Validator", func() {
 		err := validator.Validate()
 		Ω(err).ShouldNot(BeNil())
 	})
+
+	ginkgo.It("ValidateTableConfig should check constraints between configs", func() {
+		Ω(ValidateTableConfig(DefaultTableConfig)).Should(BeNil())
+
+		config := DefaultTableConfig
+		config.RecordRetentionInDays = 1
+		config.ArchivingDelayMinutes = 24*60 + 1
+		Ω(ValidateTableConfig(config)).Should(Equal(ErrRetentionShorterThanArchivingDelay))
+		config.ArchivingDelayMinutes = 24 * 60
+		Ω(ValidateTableConfig(config)).Should(BeNil())
+
+		config.BackfillThresholdInBytes = config.BackfillMaxBufferSize + 1
+		Ω(ValidateTableConfig(config)).Should(Equal(ErrBackfillThresholdExceedsMaxBufferSize))
+
+		config = DefaultTableConfig
+		config.BatchSize = 0
+		Ω(ValidateTableConfig(config)).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("EffectiveTableConfig should fill unset configs with defaults", func() {
+		effective := EffectiveTableConfig(common.TableConfig{
+			BatchSize:             10,
+			RecordRetentionInDays: 30,
+		})
+		expected := DefaultTableConfig
+		expected.BatchSize = 10
+		expected.RecordRetentionInDays = 30
+		Ω(effective).Should(Equal(expected))
+	})
 })
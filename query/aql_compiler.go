@@ -30,6 +30,7 @@ import (
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
 	"strconv"
+	"time"
 )
 
 // DataTypeToExprType maps data type from the column schema format to
@@ -85,6 +86,12 @@ func (qc *AQLQueryContext) Compile(tableSchemaReader memCom.TableSchemaReader, s
 		return
 	}
 
+	// Reject the query if the caller is not allowed to query any of the referenced columns.
+	qc.checkColumnAccess()
+	if qc.Error != nil {
+		return
+	}
+
 	// Process join conditions first to collect information about geo join.
 	qc.processJoinConditions()
 	if qc.Error != nil {
@@ -474,7 +481,7 @@ func (qc *AQLQueryContext) processTimezone() {
 			})
 		}
 	} else {
-		loc, err := parseTimezone(qc.Query.Timezone)
+		loc, err := common.ParseTimezone(qc.Query.Timezone)
 		if err != nil {
 			qc.Error = utils.StackError(err, "timezone Failed to parse: %s", qc.Query.Timezone)
 			return
@@ -494,7 +501,7 @@ func (qc *AQLQueryContext) readSchema(tableSchemaReader memCom.TableSchemaReader
 	// Main table.
 	schema := tableSchemaReader.GetSchemas()[qc.Query.Table]
 	if schema == nil {
-		qc.Error = utils.StackError(nil, "unknown main table %s", qc.Query.Table)
+		qc.Error = utils.WithCode(utils.ErrCodeSchemaMismatch, utils.StackError(nil, "unknown main table %s", qc.Query.Table))
 		return
 	}
 	qc.TableSchemaByName[qc.Query.Table] = schema
@@ -507,6 +514,17 @@ func (qc *AQLQueryContext) readSchema(tableSchemaReader memCom.TableSchemaReader
 	if len(qc.Query.Shards) == 0 {
 		qc.TableScanners[0].Shards = shardOwner.GetOwnedShards()
 	} else {
+		ownedShards := make(map[int]bool)
+		for _, shardID := range shardOwner.GetOwnedShards() {
+			ownedShards[shardID] = true
+		}
+		for _, shardID := range qc.Query.Shards {
+			if !ownedShards[shardID] {
+				qc.Error = utils.WithCode(utils.ErrCodeShardNotOwned, utils.StackError(nil,
+					"shard %d is not owned by this instance", shardID))
+				return
+			}
+		}
 		qc.TableScanners[0].Shards = qc.Query.Shards
 	}
 
@@ -521,7 +539,7 @@ func (qc *AQLQueryContext) readSchema(tableSchemaReader memCom.TableSchemaReader
 	for i, join := range qc.Query.Joins {
 		schema = tableSchemaReader.GetSchemas()[join.Table]
 		if schema == nil {
-			qc.Error = utils.StackError(nil, "unknown join table %s", join.Table)
+			qc.Error = utils.WithCode(utils.ErrCodeSchemaMismatch, utils.StackError(nil, "unknown join table %s", join.Table))
 			return
 		}
 
@@ -581,15 +599,83 @@ func (qc *AQLQueryContext) resolveColumn(identifier string) (int, int, error) {
 		return 0, 0, utils.StackError(nil, "unknown table alias %s", tableAlias)
 	}
 
-	columnID, exists := qc.TableScanners[tableID].Schema.ColumnIDs[column]
+	schema := qc.TableScanners[tableID].Schema
+	columnID, isAlias, exists := schema.GetColumnID(column)
 	if !exists {
-		return 0, 0, utils.StackError(nil, "unknown column %s for table alias %s",
-			column, tableAlias)
+		return 0, 0, utils.WithCode(utils.ErrCodeSchemaMismatch, utils.StackError(nil,
+			"unknown column %s for table alias %s", column, tableAlias))
+	}
+	if isAlias {
+		qc.addColumnAliasWarning(schema, column, columnID)
+	}
+
+	columnSchema := schema.Schema.Columns[columnID]
+	if !columnSchema.IsAccessibleBy(qc.Query.CallerRoles) {
+		// keep resolving the query to report all forbidden columns at once.
+		qc.addForbiddenColumn(schema.Schema.Name, columnSchema.Name)
+		return tableID, columnID, nil
+	}
+
+	if columnSchema.Deprecated && !columnSchema.Deleted {
+		purgeAt := time.Unix(columnSchema.PurgeAt, 0).UTC().Format(time.RFC3339)
+		if !qc.Query.IncludeDeprecatedColumns {
+			return 0, 0, utils.StackError(nil, "column %s of table %s is deprecated and will be purged at %s, "+
+				"set includeDeprecatedColumns to query it", columnSchema.Name, schema.Schema.Name, purgeAt)
+		}
+		qc.addWarning(fmt.Sprintf("column %s of table %s is deprecated and will be purged at %s",
+			columnSchema.Name, schema.Schema.Name, purgeAt))
 	}
 
 	return tableID, columnID, nil
 }
 
+// addForbiddenColumn records a referenced column the caller is not allowed to query.
+func (qc *AQLQueryContext) addForbiddenColumn(table, column string) {
+	forbiddenColumn := fmt.Sprintf("%s.%s", table, column)
+	for _, existing := range qc.forbiddenColumns {
+		if existing == forbiddenColumn {
+			return
+		}
+	}
+	qc.forbiddenColumns = append(qc.forbiddenColumns, forbiddenColumn)
+}
+
+// checkColumnAccess fails the query listing all forbidden columns referenced, the denied attempt is
+// logged for auditing.
+func (qc *AQLQueryContext) checkColumnAccess() {
+	if len(qc.forbiddenColumns) == 0 {
+		return
+	}
+	utils.GetLogger().With(
+		"caller", qc.Query.Caller,
+		"roles", qc.Query.CallerRoles,
+		"table", qc.Query.Table,
+		"forbiddenColumns", qc.forbiddenColumns,
+	).Warn("Denied query of restricted columns")
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": qc.Query.Table,
+	}, utils.QueryColumnAccessDenied).Inc(1)
+	qc.Error = utils.StackError(nil, "not allowed to query columns: %s",
+		strings.Join(qc.forbiddenColumns, ", "))
+}
+
+// addColumnAliasWarning warns the use of an old name of a renamed column since it's only accepted
+// until the alias expires.
+func (qc *AQLQueryContext) addColumnAliasWarning(schema *memCom.TableSchema, alias string, columnID int) {
+	qc.addWarning(fmt.Sprintf("column %s of table %s is deprecated, use %s instead",
+		alias, schema.Schema.Name, schema.Schema.Columns[columnID].Name))
+}
+
+// addWarning adds the warning to the query unless it's already added.
+func (qc *AQLQueryContext) addWarning(warning string) {
+	for _, existing := range qc.Warnings {
+		if existing == warning {
+			return
+		}
+	}
+	qc.Warnings = append(qc.Warnings, warning)
+}
+
 // cast returns an expression that casts the input to the desired type.
 // The returned expression AST will be used directly for VM instruction
 // generation of the desired types.
@@ -647,8 +733,8 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 		}
 		column := qc.TableScanners[tableID].Schema.Schema.Columns[columnID]
 		if column.Deleted {
-			qc.Error = utils.StackError(nil, "column %s of table %s has been deleted",
-				column.Name, qc.TableScanners[tableID].Schema.Schema.Name)
+			qc.Error = utils.WithCode(utils.ErrCodeSchemaMismatch, utils.StackError(nil,
+				"column %s of table %s has been deleted", column.Name, qc.TableScanners[tableID].Schema.Schema.Name))
 			return expression
 		}
 		dataType := qc.TableScanners[tableID].Schema.ValueTypeByColumn[columnID]
@@ -854,12 +940,12 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 				qc.Error = utils.StackError(nil, "3rd argument of convert_tz must be a string")
 				break
 			}
-			fromTz, err := parseTimezone(fromTzStringExpr.Val)
+			fromTz, err := common.ParseTimezone(fromTzStringExpr.Val)
 			if err != nil {
 				qc.Error = utils.StackError(err, "failed to rewrite convert_tz")
 				break
 			}
-			toTz, err := parseTimezone(toTzStringExpr.Val)
+			toTz, err := common.ParseTimezone(toTzStringExpr.Val)
 			if err != nil {
 				qc.Error = utils.StackError(err, "failed to rewrite convert_tz")
 				break
@@ -1586,12 +1672,21 @@ func (qc *AQLQueryContext) processTimeFilter() {
 	found := false
 	if qc.Query.TimeFilter.Column != "" {
 		// Validate column existence and type.
-		timeColumnID, found = qc.TableScanners[0].Schema.ColumnIDs[qc.Query.TimeFilter.Column]
+		var isAlias bool
+		timeColumnID, isAlias, found = qc.TableScanners[0].Schema.GetColumnID(qc.Query.TimeFilter.Column)
 		if !found {
 			qc.Error = utils.StackError(nil, "unknown time filter column %s",
 				qc.Query.TimeFilter.Column)
 			return
 		}
+		if isAlias {
+			qc.addColumnAliasWarning(qc.TableScanners[0].Schema, qc.Query.TimeFilter.Column, timeColumnID)
+		}
+		if timeColumn := qc.TableScanners[0].Schema.Schema.Columns[timeColumnID]; !timeColumn.IsAccessibleBy(qc.Query.CallerRoles) {
+			qc.addForbiddenColumn(qc.Query.Table, timeColumn.Name)
+			qc.checkColumnAccess()
+			return
+		}
 		timeColumnType := qc.TableScanners[0].Schema.ValueTypeByColumn[timeColumnID]
 		if timeColumnType != memCom.Uint32 {
 			qc.Error = utils.StackError(nil,
@@ -1700,6 +1795,14 @@ func (qc *AQLQueryContext) processMeasure() {
 		if qc.Query.Limit == 0 {
 			qc.Query.Limit = nonAggregationQueryLimit
 		}
+		if qc.Query.Offset < 0 {
+			qc.Error = utils.StackError(nil, "offset must not be negative, but got %d", qc.Query.Offset)
+		}
+		return
+	}
+
+	if qc.Query.Offset != 0 {
+		qc.Error = utils.StackError(nil, "offset is only supported by non aggregation queries")
 		return
 	}
 
@@ -1782,6 +1885,7 @@ func (qc *AQLQueryContext) processMeasure() {
 		}
 	case expr.HllCallName:
 		qc.OOPK.AggregateType = C.AGGR_HLL
+		qc.OOPK.HLLPrecision = qc.getHLLPrecision(qc.OOPK.Measure)
 	default:
 		qc.Error = utils.StackError(nil,
 			"unsupported aggregate function: %s", aggregate.Name)
@@ -1789,10 +1893,23 @@ func (qc *AQLQueryContext) processMeasure() {
 	}
 }
 
+// getHLLPrecision returns the hll precision configured for the column of the hll measure.
+func (qc *AQLQueryContext) getHLLPrecision(measure expr.Expr) uint8 {
+	if unaryExpr, ok := measure.(*expr.UnaryExpr); ok && unaryExpr.Op == expr.GET_HLL_VALUE {
+		measure = unaryExpr.Expr
+	}
+	colRef, ok := measure.(*expr.VarRef)
+	if !ok {
+		return 0
+	}
+	return qc.TableScanners[colRef.TableID].Schema.Schema.Columns[colRef.ColumnID].HLLConfig.Precision
+}
+
 func (qc *AQLQueryContext) getAllColumnsDimension() (columns []common.Dimension) {
 	// only main table columns wildcard match supported
 	for _, column := range qc.TableScanners[0].Schema.Schema.Columns {
-		if !column.Deleted && column.Type != metaCom.GeoShape {
+		if !column.Deleted && column.Type != metaCom.GeoShape && (!column.Deprecated || qc.Query.IncludeDeprecatedColumns) &&
+			column.IsAccessibleBy(qc.Query.CallerRoles) {
 			columns = append(columns, common.Dimension{
 				ExprParsed: &expr.VarRef{Val: column.Name},
 				Expr:       column.Name,
@@ -203,6 +203,16 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		qc.readSchema(store, topology.NewStaticShardOwner([]int{0}))
 		Ω(qc.Error).ShouldNot(BeNil())
 		qc.releaseSchema()
+
+		qc = &AQLQueryContext{
+			Query: &queryCom.AQLQuery{
+				Table:  "trips",
+				Shards: []int{0, 1},
+			},
+		}
+		qc.readSchema(store, topology.NewStaticShardOwner([]int{0}))
+		Ω(utils.GetErrorCode(qc.Error)).Should(Equal(utils.ErrCodeShardNotOwned))
+		qc.releaseSchema()
 	})
 
 	ginkgo.It("numerical operations on column over 4 bytes long not supported", func() {
@@ -700,6 +710,82 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		Ω(qc.Error).ShouldNot(BeNil())
 	})
 
+	ginkgo.It("rejects columns the caller is not allowed to query", func() {
+		financeOnly := &metaCom.SchemaMetadata{AllowedRoles: []string{"finance"}}
+		table := metaCom.Table{
+			Name: "trips",
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32, Metadata: financeOnly},
+				{Name: "tip", Type: metaCom.Float32, Metadata: financeOnly},
+			},
+		}
+		schema := memCom.NewTableSchema(&table)
+
+		newQueryContext := func(roles ...string) *AQLQueryContext {
+			qc := &AQLQueryContext{
+				TableIDByAlias: map[string]int{
+					"trips": 0,
+				},
+				TableScanners: []*TableScanner{
+					{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+				},
+			}
+			// restricted columns are only referenced inside expressions.
+			qc.Query = &queryCom.AQLQuery{
+				Table: "trips",
+				Dimensions: []queryCom.Dimension{
+					{Expr: "city_id"},
+				},
+				Measures: []queryCom.Measure{
+					{Expr: "sum(fare * 2 + 1)", Filters: []string{"city_id = 1"}},
+				},
+				Filters: []string{
+					"case when tip > 0 then 1 else 0 end = 1",
+				},
+				CallerRoles: roles,
+			}
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil())
+			qc.resolveTypes()
+			Ω(qc.Error).Should(BeNil())
+			qc.checkColumnAccess()
+			return qc
+		}
+
+		qc := newQueryContext("analyst")
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring("not allowed to query columns: trips.fare, trips.tip"))
+
+		Ω(newQueryContext("analyst", "finance").Error).Should(BeNil())
+		Ω(newQueryContext(metaCom.AdminRole).Error).Should(BeNil())
+
+		// restricted columns are skipped by wildcard.
+		qc = &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &queryCom.AQLQuery{
+			Table: "trips",
+			Measures: []queryCom.Measure{
+				{Expr: "1"},
+			},
+			Dimensions: []queryCom.Dimension{
+				{Expr: "*"},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.Dimensions).Should(HaveLen(2))
+		Ω(qc.Query.Dimensions[0].Expr).Should(Equal("request_at"))
+		Ω(qc.Query.Dimensions[1].Expr).Should(Equal("city_id"))
+	})
+
 	ginkgo.It("matches prefilters", func() {
 		schema := &memCom.TableSchema{
 			ValueTypeByColumn: []memCom.DataType{
@@ -1864,7 +1950,7 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 			IsFactTable: true,
 			Columns: []metaCom.Column{
 				{Name: "request_at", Type: metaCom.Uint32},
-				{Name: "client_uuid_hll", Type: metaCom.UUID, HLLConfig: metaCom.HLLConfig{IsHLLColumn: true}},
+				{Name: "client_uuid_hll", Type: metaCom.UUID, HLLConfig: metaCom.HLLConfig{IsHLLColumn: true, Precision: 12}},
 			},
 		}
 		tripsSchema := memCom.NewTableSchema(&table)
@@ -1898,6 +1984,9 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		qc.resolveTypes()
 		Ω(qc.Error).Should(BeNil())
 		Ω(qc.Query.Measures[0].ExprParsed.String()).Should(Equal("hll(GET_HLL_VALUE(request_at))"))
+		qc.processMeasure()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.HLLPrecision).Should(BeZero())
 
 		qc.Query = &queryCom.AQLQuery{
 			Table: "trips",
@@ -1915,6 +2004,9 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		qc.resolveTypes()
 		Ω(qc.Error).Should(BeNil())
 		Ω(qc.Query.Measures[0].ExprParsed.String()).Should(Equal("hll(client_uuid_hll)"))
+		qc.processMeasure()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.HLLPrecision).Should(Equal(uint8(12)))
 
 		qc.Query = &queryCom.AQLQuery{
 			Table: "trips",
@@ -262,6 +262,8 @@ type OOPKContext struct {
 	Measure       expr.Expr                `json:"measure"`
 	MeasureBytes  int                      `json:"measureBytes"`
 	AggregateType C.enum_AggregateFunction `json:"aggregate"`
+	// Precision configured for the column of hll measure, 0 for default precision.
+	HLLPrecision uint8 `json:"hllPrecision,omitempty"`
 
 	// Storage for current batch.
 	currentBatch oopkBatchContext
@@ -285,6 +287,8 @@ type OOPKContext struct {
 	// hllDimRegIDCountD stores regID count for each dim in device memory.
 	hllDimRegIDCountD devicePointer
 	ResultSize        int `json:"resultSize"`
+	// Number of rows of batches scanned by the query.
+	RowsScanned int `json:"rowsScanned"`
 
 	// For reporting purpose only.
 	DeviceMemoryRequirement int           `json:"deviceMem"`
@@ -365,6 +369,10 @@ type AQLQueryContext struct {
 	Prefilters []int `json:"prefilters,omitempty"`
 
 	Error error `json:"error,omitempty"`
+	// Non fatal issues of the query to return to the client, e.g. use of deprecated column names.
+	Warnings []string `json:"warnings,omitempty"`
+	// Referenced columns the caller is not allowed to query, as table.column.
+	forbiddenColumns []string
 
 	Device int `json:"device"`
 
@@ -398,6 +406,7 @@ type AQLQueryContext struct {
 	IsNonAggregationQuery      bool
 	DataOnly                   bool
 	numberOfRowsWritten        int
+	numberOfRowsSkipped        int
 	maxBatchSizeAfterPrefilter int
 
 	// for eager flush query result
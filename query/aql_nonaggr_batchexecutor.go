@@ -111,7 +111,8 @@ func (e *NonAggrBatchExecutorImpl) getNumberOfRecordsNeeded() (needed int) {
 		needed = -1
 		return
 	}
-	needed = e.qc.Query.Limit - e.qc.numberOfRowsWritten
+	// rows skipped by offset are written but not flushed to results.
+	needed = e.qc.Query.Limit + e.qc.Query.Offset - e.qc.numberOfRowsWritten
 	if needed < 0 {
 		needed = 0
 	}
@@ -88,6 +88,12 @@ func (qc *AQLQueryContext) flushResultBuffer() {
 	}
 
 	for i := 0; i < oopkContext.ResultSize; i++ {
+		// leading rows of non aggregation queries are skipped by offset.
+		if qc.IsNonAggregationQuery && qc.numberOfRowsSkipped < qc.Query.Offset {
+			qc.numberOfRowsSkipped++
+			continue
+		}
+
 		dimReadingStart := utils.Now()
 		for dimIndex := range oopkContext.Dimensions {
 			offsets := dimOffsets[dimIndex]
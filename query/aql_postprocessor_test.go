@@ -499,4 +499,42 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 		ctx.flushResultBuffer()
 		Ω(w.Body.String()).Should(Equal(`["3.2"],["3.2"]`))
 	})
+
+	ginkgo.It("skips rows by offset for non agg queries", func() {
+		w := httptest.NewRecorder()
+		ctx := &AQLQueryContext{
+			Query: &queryCom.AQLQuery{
+				Dimensions: []queryCom.Dimension{
+					{Expr: "someField"},
+				},
+				Offset: 2,
+			},
+			IsNonAggregationQuery: true,
+			ResponseWriter:        w,
+		}
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+			},
+			DimRowBytes:        2,
+			NumDimsPerDimWidth: queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 1},
+			DimensionVectorIndex: []int{
+				0,
+			},
+			ResultSize:       3,
+			dimensionVectorH: unsafe.Pointer(&[]uint8{1, 2, 3, 1, 1, 1}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		ctx.initResultFlushContext()
+		ctx.flushResultBuffer()
+		Ω(w.Body.String()).Should(Equal(`["3"],`))
+
+		ctx.OOPK.done = true
+		ctx.flushResultBuffer()
+		Ω(w.Body.String()).Should(Equal(`["3"],["1"],["2"],["3"]`))
+	})
 })
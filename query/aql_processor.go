@@ -31,6 +31,8 @@ import (
 
 const (
 	hllQueryRequiredMemoryInMB = 10 * 1024
+	// default max concurrent vector party reads for archive batch prefetch.
+	defaultArchivePrefetchMaxReads = 32
 )
 
 // batchTransferExecutor defines the type of the functor to transfer a live batch or a archive batch
@@ -217,10 +219,18 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 	// Process archive batches.
 	if archiveStore != nil && (qc.fromTime == nil || cutoff > uint32(qc.fromTime.Time.Unix())) {
 		scanner := qc.TableScanners[0]
+		prefetcher := qc.newArchiveBatchPrefetcher(memStore, archiveStore)
+		if prefetcher != nil {
+			// cancels outstanding prefetches when the limit short-circuits or the query fails.
+			defer qc.closeArchiveBatchPrefetcher(prefetcher, shardID)
+		}
 		for batchID := scanner.ArchiveBatchIDStart; batchID < scanner.ArchiveBatchIDEnd; batchID++ {
 			if qc.OOPK.done {
 				break
 			}
+			if prefetcher != nil {
+				prefetcher.Start(int32(batchID))
+			}
 			archiveBatch := archiveStore.RequestBatch(int32(batchID))
 			if archiveBatch.Size == 0 {
 				qc.OOPK.ArchiveBatchStats.NumBatchSkipped++
@@ -234,6 +244,9 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 				qc.transferArchiveBatch(archiveBatch, isFirstOrLast),
 				qc.archiveBatchCustomFilterExecutor(isFirstOrLast),
 				previousBatchExecutor, false)
+			if prefetcher != nil {
+				prefetcher.Done(int32(batchID))
+			}
 			archiveRecordsProcessed += archiveBatch.Size
 			archiveBatchProcessed++
 			qc.cudaStreams[0], qc.cudaStreams[1] = qc.cudaStreams[1], qc.cudaStreams[0]
@@ -432,8 +445,9 @@ func (qc *AQLQueryContext) prepareForeignTable(memStore memstore.MemStore, joinT
 		for i, columnID := range qc.TableScanners[joinTableID+1].Columns {
 			usage := qc.TableScanners[joinTableID+1].ColumnUsages[columnID]
 			if usage&(columnUsedByAllBatches|columnUsedByLiveBatches) != 0 {
-				sourceVP := batch.Columns[columnID]
+				sourceVP := batch.GetVectorParty(columnID)
 				if sourceVP == nil {
+					deviceBatches[batchIndex][i] = defaultDeviceColumn(qc.TableScanners[joinTableID+1].Schema, columnID, size)
 					continue
 				}
 
@@ -514,8 +528,9 @@ func (qc *AQLQueryContext) transferLiveBatch(batch *memstore.LiveBatch, size int
 				if firstColumn < 0 {
 					firstColumn = i
 				}
-				sourceVP := batch.Columns[columnID]
+				sourceVP := batch.GetVectorParty(columnID)
 				if sourceVP == nil {
+					deviceColumns[i] = defaultDeviceColumn(qc.TableScanners[0].Schema, columnID, size)
 					continue
 				}
 
@@ -558,6 +573,46 @@ func (qc *AQLQueryContext) liveBatchCustomFilterExecutor(cutoff uint32) customFi
 	}
 }
 
+// newArchiveBatchPrefetcher creates a prefetcher to read ahead archive batches to be processed by this query,
+// returns nil if prefetch is disabled.
+func (qc *AQLQueryContext) newArchiveBatchPrefetcher(memStore memstore.MemStore,
+	archiveStore *memstore.ArchiveStoreVersion) *memstore.ArchiveBatchPrefetcher {
+	queryConfig := utils.GetConfig().Query
+	scanner := qc.TableScanners[0]
+	if queryConfig.ArchivePrefetchBatches <= 0 || scanner.ArchiveBatchIDEnd-scanner.ArchiveBatchIDStart <= 1 {
+		return nil
+	}
+
+	var columnIDs []int
+	for _, columnID := range scanner.Columns {
+		if scanner.ColumnUsages[columnID]&(columnUsedByAllBatches|columnUsedByPrefilter) != 0 {
+			columnIDs = append(columnIDs, columnID)
+		}
+	}
+	if len(columnIDs) == 0 {
+		return nil
+	}
+
+	maxReads := queryConfig.ArchivePrefetchMaxReads
+	if maxReads <= 0 {
+		maxReads = defaultArchivePrefetchMaxReads
+	}
+	return memstore.NewArchiveBatchPrefetcher(archiveStore, memStore.GetHostMemoryManager(),
+		int32(scanner.ArchiveBatchIDStart), int32(scanner.ArchiveBatchIDEnd), columnIDs,
+		queryConfig.ArchivePrefetchBatches, maxReads)
+}
+
+// closeArchiveBatchPrefetcher cancels outstanding prefetches and reports prefetch stats.
+func (qc *AQLQueryContext) closeArchiveBatchPrefetcher(prefetcher *memstore.ArchiveBatchPrefetcher, shardID int) {
+	prefetcher.Close()
+	qc.OOPK.ArchiveBatchStats.NumPrefetchHits += prefetcher.Hits
+	qc.OOPK.ArchiveBatchStats.NumPrefetchMisses += prefetcher.Misses
+	reporter := utils.GetReporter(qc.Query.Table, shardID)
+	reporter.GetCounter(utils.QueryArchivePrefetchHits).Inc(int64(prefetcher.Hits))
+	reporter.GetCounter(utils.QueryArchivePrefetchMisses).Inc(int64(prefetcher.Misses))
+	reporter.GetGauge(utils.QueryArchivePrefetchReads).Update(float64(prefetcher.MaxPendingReads))
+}
+
 // transferArchiveBatch returns the functor to transfer an archive batch to device memory. We will need to release
 // hostColumns after transfer completes.
 func (qc *AQLQueryContext) transferArchiveBatch(batch *memstore.ArchiveBatch,
@@ -585,6 +640,17 @@ func (qc *AQLQueryContext) transferArchiveBatch(batch *memstore.ArchiveBatch,
 				// Request/pin column from disk and wait.
 				vp := batch.RequestVectorParty(columnID)
 				vp.WaitForDiskLoad()
+				if err := vp.LoadError(); err != nil {
+					// release columns of this batch requested so far before failing the query.
+					vp.Release()
+					for j := i + 1; j < len(hostVPs); j++ {
+						if hostVPs[j] != nil {
+							hostVPs[j].(memCom.ArchiveVectorParty).Release()
+							deviceFreeAndSetNil(&deviceSlices[j].basePtr)
+						}
+					}
+					panic(utils.StackError(err, "failed to load column %d of archive batch %d", columnID, batch.BatchID))
+				}
 
 				// prefilter slicing
 				startRow, endRow, hostSlices[i] = qc.prefilterSlice(vp, prefilterIndex, startRow, endRow)
@@ -916,6 +982,7 @@ func (qc *AQLQueryContext) processBatch(
 
 	// no prefilter slicing in livebatch, startRow is always 0
 	qc.OOPK.currentBatch.size = batchSize
+	qc.OOPK.RowsScanned += batchSize
 	qc.OOPK.currentBatch.sizeAfterPreFilter = sizeAfterPreFilter
 	qc.OOPK.currentBatch.prepareForFiltering(deviceSlices, firstColumn, startRow, stream)
 
@@ -1058,7 +1125,7 @@ func (qc *AQLQueryContext) calculateMemoryRequirement(memStore memstore.MemStore
 func (qc *AQLQueryContext) estimateLiveBatchMemoryUsage(batch *memstore.LiveBatch) int {
 	columnMemUsage := 0
 	for _, columnID := range qc.TableScanners[0].Columns {
-		sourceVP := batch.Columns[columnID]
+		sourceVP := batch.GetVectorParty(columnID)
 		if sourceVP == nil {
 			continue
 		}
@@ -1097,6 +1164,10 @@ func (qc *AQLQueryContext) estimateArchiveBatchMemoryUsage(batch *memstore.Archi
 		// TODO(cdavid): only read metadata when estimate query memory requirement.
 		sourceVP := batch.RequestVectorParty(columnID)
 		sourceVP.WaitForDiskLoad()
+		if err := sourceVP.LoadError(); err != nil {
+			sourceVP.Release()
+			panic(utils.StackError(err, "failed to load column %d of archive batch %d", columnID, batch.BatchID))
+		}
 
 		if usage&matchedColumnUsages != 0 || usage&columnUsedByPrefilter != 0 {
 			startRow, endRow, hostSlice = qc.prefilterSlice(sourceVP, prefilterIndex, startRow, endRow)
@@ -1157,8 +1228,8 @@ func (qc *AQLQueryContext) estimateMemUsageForBatch(firstColumnSize, columnMemUs
 	// 8. Dimension vector memory usage (input + output)
 	if qc.IsNonAggregationQuery {
 		maxRowsPerBatch := maxSizeAfterPreFilter
-		if qc.Query.Limit < maxRowsPerBatch {
-			maxRowsPerBatch = qc.Query.Limit
+		if qc.Query.Limit+qc.Query.Offset < maxRowsPerBatch {
+			maxRowsPerBatch = qc.Query.Limit + qc.Query.Offset
 		}
 		memUsage += maxRowsPerBatch * qc.OOPK.DimRowBytes * 2
 	} else {
@@ -1288,7 +1359,7 @@ func (qc *AQLQueryContext) calculateForeignTableMemUsage(memStore memstore.MemSt
 			for _, columnID := range qc.TableScanners[joinTableID+1].Columns {
 				usage := qc.TableScanners[joinTableID+1].ColumnUsages[columnID]
 				if usage&(columnUsedByAllBatches|columnUsedByLiveBatches) != 0 {
-					sourceVP := batch.Columns[columnID]
+					sourceVP := batch.GetVectorParty(columnID)
 					if sourceVP == nil {
 						continue
 					}
@@ -1363,6 +1434,18 @@ func copyHostToDevice(vps memCom.HostVectorPartySlice, deviceVPSlice deviceVecto
 	return
 }
 
+// defaultDeviceColumn returns a device column without any vector so that all its rows read the
+// default value of the column, e.g. a column added after the live batch was created.
+func defaultDeviceColumn(schema *memCom.TableSchema, columnID, size int) deviceVectorPartySlice {
+	schema.RLock()
+	defer schema.RUnlock()
+	return deviceVectorPartySlice{
+		length:       size,
+		valueType:    schema.ValueTypeByColumn[columnID],
+		defaultValue: *schema.DefaultValues[columnID],
+	}
+}
+
 func hostToDeviceColumn(hostColumn memCom.HostVectorPartySlice, device int) deviceVectorPartySlice {
 	deviceColumn := deviceVectorPartySlice{
 		length:          hostColumn.Length,
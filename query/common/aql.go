@@ -92,6 +92,21 @@ type SortField struct {
 	Order string `json:"order"`
 }
 
+// HLLSketchOption specifies returning hll sketches in the standard dense format for hll queries.
+type HLLSketchOption struct {
+	// Precision of returned sketches, sketches of higher precisions are folded to it.
+	// 0 to keep the precisions of sketches.
+	Precision uint8 `json:"precision,omitempty"`
+
+	// Register width in bits of returned sketches, registers overflowing the width are saturated.
+	// 0 for DefaultStandardHLLRegisterWidth.
+	RegisterWidth uint8 `json:"registerWidth,omitempty"`
+
+	// Sketches in the standard dense format (base64 encoded) to union with the query results,
+	// nested by dimension values the same way as the query results.
+	Union AQLQueryResult `json:"union,omitempty"`
+}
+
 // AQLQuery specifies the query on top of tables.
 type AQLQuery struct {
 	// Name of the main table.
@@ -142,10 +157,72 @@ type AQLQuery struct {
 	// Limit is the max number of rows need to be return, and only used for non-aggregation
 	Limit int `json:"limit,omitempty"`
 
+	// Offset is the number of leading rows skipped by non-aggregation queries, skipped by broker across rows of
+	// all datanodes and not counted by the limit. Offsets of datanodes are set by broker for paginated queries,
+	// rows are scanned by shard and batch order so that offsets are stable for unchanged data.
+	Offset int `json:"offset,omitempty"`
+
 	Sorts []SortField `json:"sorts,omitempty" yaml:"sorts"`
 
 	// SQLQuery
 	SQLQuery string `json:"sql,omitempty"`
+
+	// Whether deprecated columns can be referenced, they are hidden from queries by default.
+	IncludeDeprecatedColumns bool `json:"includeDeprecatedColumns,omitempty"`
+
+	// Returns hll sketches in the standard dense format instead of AresDB sketches, handled by broker.
+	HLLSketch *HLLSketchOption `json:"hllSketch,omitempty"`
+
+	// Caller and its roles from the auth layer, columns not accessible by the roles cannot be referenced.
+	// They are set by the server from request headers instead of the query body.
+	Caller      string   `json:"-"`
+	CallerRoles []string `json:"-"`
+
+	// Pagination of non-aggregation queries handled by broker, set from request parameters. Results are
+	// returned in pages of PageSize rows, pages after the first one are requested with the cursor returned
+	// by the previous page.
+	PageSize int    `json:"-"`
+	Cursor   string `json:"-"`
+
+	// Whether completeness of top level time buckets of aggregation queries is reported in the response
+	// metadata by broker, set from request parameters.
+	BucketCompleteness bool `json:"-"`
+
+	// Whether missing top level time buckets of aggregation queries are filled by broker to return dense
+	// series of buckets within the time filter, set from request parameters.
+	FillTimeBuckets bool `json:"-"`
+
+	// Whether duplicate rows of non aggregation queries across datanodes are dropped by broker, e.g. rows of
+	// shards queried from multiple datanodes during topology transitions, set from request parameters.
+	DedupRows bool `json:"-"`
+
+	// Whether dimensions and measures of columns unknown to datanodes lagging behind schemas of broker are
+	// dropped by broker instead of failing the query, set from request parameters.
+	DropSkewedColumns bool `json:"-"`
+
+	// Whether results of datanodes succeeded are returned when other datanodes fail, nil means the default
+	// of broker, set from request parameters.
+	PartialResults *bool `json:"-"`
+
+	// Milliseconds the query can run before broker gives up on datanodes not responded, 0 means the default
+	// of broker, set from request parameters.
+	TimeoutMillis int `json:"-"`
+
+	// Consistency level of reads of shards (one, quorum or all), empty means the default of broker, set from
+	// request parameters.
+	Consistency string `json:"-"`
+
+	// Whether the plan of the query is returned by broker instead of executing it, set from request
+	// parameters.
+	Explain bool `json:"-"`
+
+	// Number of results of datanodes merged between partial results of aggregation queries streamed by
+	// broker, 0 means results are only returned once fully merged, set from request parameters.
+	ProgressiveInterval int `json:"-"`
+
+	// Whether hll sketches of hll aggregation queries are returned by broker in the binary format of datanode
+	// responses (application/hll) instead of json, set from the Accept header of requests.
+	HLLBinary bool `json:"-"`
 }
 
 func (d Dimension) IsTimeDimension() bool {
@@ -161,5 +238,6 @@ type AQLRequest struct {
 type AQLResponse struct {
 	Results      []AQLQueryResult `json:"results"`
 	Errors       []error          `json:"errors,omitempty"`
+	Warnings     [][]string       `json:"warnings,omitempty"`
 	QueryContext []string         `json:"context,omitempty"`
 }
@@ -17,6 +17,14 @@ package common
 const (
 	MatrixDataKey = "matrixData"
 	HeadersKey    = "headers"
+	// CursorKey is the key of the cursor of the next page of paginated non aggregation query results.
+	CursorKey = "cursor"
+	// ErrorsKey is the key of errors of datanodes failed by non aggregation queries returning partial results.
+	ErrorsKey = "errors"
+	// ErrorKey is the key of the error of non aggregation queries failed after rows are streamed, written
+	// after the rows streamed so that responses are still valid json. The error is an object with the code,
+	// the message, whether it is retriable and errors of hosts, the same as errors of v2 responses.
+	ErrorKey = "error"
 )
 
 // AQLQueryResult represents final result of one AQL query
@@ -89,6 +97,32 @@ func (r AQLQueryResult) SetHLL(dimValues []*string, hll HLL) {
 	}
 }
 
+// SetTDigest sets the t-digest to be the leaf of the nested map, nil digests are set as nulls.
+func (r AQLQueryResult) SetTDigest(dimValues []*string, digest *TDigest) {
+	null := "NULL"
+	var current map[string]interface{} = r
+	for i, dimValue := range dimValues {
+		if dimValue == nil {
+			dimValue = &null
+		}
+
+		if i == len(dimValues)-1 {
+			if digest == nil {
+				current[*dimValue] = nil
+			} else {
+				current[*dimValue] = digest
+			}
+		} else {
+			child := current[*dimValue]
+			if child == nil {
+				child = make(map[string]interface{})
+				current[*dimValue] = child
+			}
+			current = child.(map[string]interface{})
+		}
+	}
+}
+
 // =====  Time series result methods end =====
 
 // =====  Non aggregate query result methods start =====
@@ -21,8 +21,11 @@ import (
 
 	"github.com/pkg/errors"
 	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"io"
+	"io/ioutil"
 	"math"
+	"math/bits"
 	"sort"
 	"unsafe"
 )
@@ -34,10 +37,12 @@ const (
 	HLLDataHeader uint32 = 0xACED0102
 	// EnumDelimiter is the delimiter to delimit enum cases.
 	EnumDelimiter = "\u0000\n"
-	// DenseDataLength is the length of hll dense data in bytes.
-	DenseDataLength = 1 << 14 // 16kb
-	// DenseThreshold is the thresold to convert sparse value to dense value.
+	// DenseDataLength is the length of hll dense data of default precision in bytes.
+	DenseDataLength = 1 << metaCom.DefaultHLLPrecision // 16kb
+	// DenseThreshold is the thresold to convert sparse value to dense value of default precision.
 	DenseThreshold = DenseDataLength / 4
+	// hllPrecisionOffset is the offset of hll precision in the header of a hll query result.
+	hllPrecisionOffset = 1 + len(DimCountsPerDimWidth{})
 )
 
 // HLLData stores fields for serialize and deserialize an hyperloglog query result when client sets Content-Accept
@@ -48,7 +53,7 @@ const (
 //	-----------query result 0-------------------
 //	 <header>
 //	 [uint32] query result 0 size [uint8] error or result [3 bytes padding]
-//	 [uint8] num_enum_columns [uint8] bytes per dim ... [padding for 8 bytes]
+//	 [uint8] num_enum_columns [uint8] bytes per dim ... [uint8] hll precision [padding for 8 bytes]
 //	 [uint32] result_size [uint32] raw_dim_values_vector_length
 //	 [uint8] dim_index_0... [uint8] dim_index_n [padding for 8 bytes]
 //	 [uint32] data_type_0...[uint32] data_type_n [padding for 8 bytes]
@@ -77,6 +82,8 @@ type HLLData struct {
 	// map from column id => enum cases. It will
 	// only include columns used in dimensions.
 	EnumDicts map[int][]string
+	// Precision of hll sketches, 0 for default precision.
+	Precision uint8
 }
 
 // CalculateSizes returns the header size and total size of used by this hll data.
@@ -85,6 +92,8 @@ func (data *HLLData) CalculateSizes() (uint32, int64) {
 	var headerSize = 1
 	// Dims per width (1 byte * numDims)
 	headerSize += len(data.NumDimsPerDimWidth)
+	// hll precision (1 byte)
+	headerSize++
 	// padding for 8 bytes
 	headerSize = utils.AlignOffset(headerSize, 8)
 	// result size (4 bytes) + raw_dim_values_vector_length (4 bytes)
@@ -116,6 +125,96 @@ func (data *HLLData) CalculateSizes() (uint32, int64) {
 	return uint32(headerSize), totalSize
 }
 
+// SerializeHeader serializes the HLL header into the buffer
+//	-----------query result 0-------------------
+//	 <header>
+//	 [uint8] num_enum_columns [uint8] bytes per dim ... [uint8] hll precision [padding for 8 bytes]
+//	 [uint32] result_size [uint32] raw_dim_values_vector_length
+//	 [uint8] dim_index_0... [uint8] dim_index_n [padding for 8 bytes]
+//	 [uint32] data_type_0...[uint32] data_type_n [padding for 8 bytes]
+//
+//	 <enum cases 0>
+//	 [uint32_t] number of bytes of enum cases [uint16] column_index [2 bytes: padding]
+//	 <enum values 0> delimited by "\u0000\n" [padding for 8 bytes]
+//
+// 	 <end of header>
+func (data *HLLData) SerializeHeader(buffer []byte) error {
+	writer := utils.NewBufferWriter(buffer)
+
+	// num_enum_columns
+	if err := writer.AppendUint8(uint8(len(data.EnumDicts))); err != nil {
+		return err
+	}
+
+	// bytes per dim
+	if err := writer.Append([]byte(data.NumDimsPerDimWidth[:])); err != nil {
+		return err
+	}
+
+	// hll precision
+	if err := writer.AppendUint8(data.Precision); err != nil {
+		return err
+	}
+	writer.AlignBytes(8)
+
+	// result_size
+	if err := writer.AppendUint32(data.ResultSize); err != nil {
+		return err
+	}
+
+	// raw_dim_values_vector_length
+	if err := writer.AppendUint32(data.PaddedRawDimValuesVectorLength); err != nil {
+		return err
+	}
+
+	// dim_indexes
+	for _, dimIndex := range data.DimIndexes {
+		if err := writer.AppendUint8(uint8(dimIndex)); err != nil {
+			return err
+		}
+	}
+	writer.AlignBytes(8)
+
+	// data_types
+	for _, dataType := range data.DataTypes {
+		if err := writer.AppendUint32(uint32(dataType)); err != nil {
+			return err
+		}
+	}
+	writer.AlignBytes(8)
+
+	// Write enum cases.
+	for columnID, enumCases := range data.EnumDicts {
+		enumCasesBytes := CalculateEnumCasesBytes(enumCases)
+		if err := writer.AppendUint32(enumCasesBytes); err != nil {
+			return err
+		}
+
+		if err := writer.AppendUint16(uint16(columnID)); err != nil {
+			return err
+		}
+
+		// padding
+		writer.SkipBytes(2)
+
+		var enumCaseBytesWritten uint32
+		for _, enumCase := range enumCases {
+			if err := writer.Append([]byte(enumCase)); err != nil {
+				return err
+			}
+
+			if err := writer.Append([]byte(EnumDelimiter)); err != nil {
+				return err
+			}
+
+			enumCaseBytesWritten += uint32(len(enumCase)) + 2
+		}
+
+		writer.SkipBytes(int(enumCasesBytes - enumCaseBytesWritten))
+	}
+	return nil
+}
+
 // CalculateEnumCasesBytes calculates how many bytes the enum case values will occupy including 8 bytes alignment.
 func CalculateEnumCasesBytes(enumCases []string) uint32 {
 	var size uint32
@@ -142,10 +241,25 @@ type HLL struct {
 	SparseData       []HLLRegister // Unsorted registers.
 	DenseData        []byte        // Rho by register index.
 	NonZeroRegisters uint16
+	// Number of register index bits, 0 for default precision.
+	Precision uint8
+}
+
+func (hll *HLL) precision() uint8 {
+	if hll.Precision == 0 {
+		return metaCom.DefaultHLLPrecision
+	}
+	return hll.Precision
 }
 
 // Merge merges (using max(rho)) the other HLL (sparse or dense) into this one (will be converted to dense).
+// HLLs of different precisions are merged at the lower precision.
 func (hll *HLL) Merge(other HLL) {
+	if other.precision() < hll.precision() {
+		hll.Fold(other.precision())
+	} else if other.precision() > hll.precision() {
+		other.Fold(hll.precision())
+	}
 	hll.ConvertToDense()
 	for _, register := range other.SparseData {
 		oldRho := hll.DenseData[register.Index]
@@ -173,7 +287,7 @@ func (hll *HLL) ConvertToDense() {
 		return
 	}
 
-	hll.DenseData = make([]byte, 1<<hllP)
+	hll.DenseData = make([]byte, 1<<hll.precision())
 	for _, register := range hll.SparseData {
 		hll.DenseData[register.Index] = register.Rho
 	}
@@ -182,7 +296,7 @@ func (hll *HLL) ConvertToDense() {
 
 // ConvertToSparse try converting the hll to sparse format if it turns out to be cheaper.
 func (hll *HLL) ConvertToSparse() bool {
-	if hll.NonZeroRegisters*4 >= 1<<hllP {
+	if hll.NonZeroRegisters*4 >= 1<<hll.precision() {
 		return false
 	}
 	if hll.SparseData != nil {
@@ -209,11 +323,62 @@ func (hll *HLL) Set(index uint16, rho byte) {
 
 	hll.SparseData = append(hll.SparseData, HLLRegister{index, rho})
 
-	if hll.NonZeroRegisters*4 >= 1<<hllP {
+	if hll.NonZeroRegisters*4 >= 1<<hll.precision() {
 		hll.ConvertToDense()
 	}
 }
 
+// Fold folds the HLL to a lower precision, registers sharing the lower index bits are merged into one.
+// The register index is taken from the lowest bits of the hash and rho from the bits above, so folded
+// registers are the same as if they were built at the lower precision. Noop for higher precisions.
+func (hll *HLL) Fold(precision uint8) {
+	from := hll.precision()
+	if precision >= from {
+		return
+	}
+
+	isSparse := len(hll.DenseData) == 0
+	folded := HLL{
+		DenseData: make([]byte, 1<<precision),
+		Precision: precision,
+	}
+	foldRegister := func(index uint16, rho byte) {
+		if rho == 0 {
+			return
+		}
+		index, rho = foldHLLRegister(uint32(index), rho, from, precision)
+		if folded.DenseData[index] == 0 {
+			folded.NonZeroRegisters++
+		}
+		if folded.DenseData[index] < rho {
+			folded.DenseData[index] = rho
+		}
+	}
+	for _, register := range hll.SparseData {
+		foldRegister(register.Index, register.Rho)
+	}
+	for index, rho := range hll.DenseData {
+		foldRegister(uint16(index), rho)
+	}
+	if isSparse {
+		folded.ConvertToSparse()
+	}
+	*hll = folded
+}
+
+// foldHLLRegister returns the register index and rho of a non empty register at the lower precision,
+// index bits moved out of the register index are the lowest bits of rho now.
+func foldHLLRegister(index uint32, rho byte, from, to uint8) (uint16, byte) {
+	if highBits := index >> to; highBits != 0 {
+		rho = byte(bits.TrailingZeros32(highBits)) + 1
+	} else if rho < math.MaxUint8-(from-to) {
+		rho += from - to
+	} else {
+		rho = math.MaxUint8
+	}
+	return uint16(index & (1<<to - 1)), rho
+}
+
 func parseOldTimeseriesHLLResult(buffer []byte) (AQLQueryResult, error) {
 	// empty result buffer
 	if len(buffer) == 0 {
@@ -350,147 +515,214 @@ func parseOldTimeseriesHLLResult(buffer []byte) (AQLQueryResult, error) {
 		}
 
 		count := *(*uint16)(memAccess(countVector, int(2*i)))
-		hll := readHLL(hllVector, count, &currentOffset)
+		hll := readHLL(hllVector, count, &currentOffset, 0)
 		result.SetHLL(dimValues, hll)
 	}
 
 	return result, nil
 }
 
-func parseTimeseriesHLLResult(buffer []byte) (AQLQueryResult, error) {
-	// empty result buffer
-	if len(buffer) == 0 {
-		return AQLQueryResult{}, nil
-	}
+// hllDataHeader is the parsed header of a serialized hll query result.
+type hllDataHeader struct {
+	numDimsPerDimWidth             DimCountsPerDimWidth
+	precision                      uint8
+	resultSize                     uint32
+	paddedRawDimValuesVectorLength uint32
+	dimIndexes                     []uint8
+	dataTypes                      []memCom.DataType
+	enumDicts                      map[int][]string
+	// size of the header in bytes.
+	size uint32
+}
 
+// readHLLDataHeader reads the header of a serialized hll query result.
+func readHLLDataHeader(buffer []byte) (header hllDataHeader, err error) {
 	reader := utils.NewStreamDataReader(bytes.NewBuffer(buffer))
 	numEnumColumns, err := reader.ReadUint8()
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	var numDimsPerDimWidth DimCountsPerDimWidth
-	err = reader.Read([]byte(numDimsPerDimWidth[:]))
+	err = reader.Read([]byte(header.numDimsPerDimWidth[:]))
 	if err != nil {
-		return AQLQueryResult{}, nil
+		return
 	}
 
 	totalDims := 0
-	for _, dimCount := range numDimsPerDimWidth {
+	for _, dimCount := range header.numDimsPerDimWidth {
 		totalDims += int(dimCount)
 	}
 
+	// hll precision is written in padding, which is 0 in results of older versions.
+	if header.precision, err = reader.ReadUint8(); err != nil {
+		return
+	}
+
 	err = reader.ReadPadding(int(reader.GetBytesRead()), 8)
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	resultSize, err := reader.ReadUint32()
-	if err != nil {
-		return nil, err
+	if header.resultSize, err = reader.ReadUint32(); err != nil {
+		return
 	}
 
-	paddedRawDimValuesVectorLength, err := reader.ReadUint32()
-	if err != nil {
-		return nil, err
+	if header.paddedRawDimValuesVectorLength, err = reader.ReadUint32(); err != nil {
+		return
 	}
 
-	dimIndexes := make([]uint8, totalDims)
-	for i := range dimIndexes {
-		dimIndexes[i], err = reader.ReadUint8()
+	header.dimIndexes = make([]uint8, totalDims)
+	for i := range header.dimIndexes {
+		header.dimIndexes[i], err = reader.ReadUint8()
 		if err != nil {
-			return nil, err
+			return
 		}
 	}
 
 	if err = reader.ReadPadding(int(totalDims), 8); err != nil {
-		return nil, err
+		return
 	}
 
-	dataTypes := make([]memCom.DataType, totalDims)
+	header.dataTypes = make([]memCom.DataType, totalDims)
 
-	for i := range dataTypes {
-		rawDataType, err := reader.ReadUint32()
-		if err != nil {
-			return nil, err
+	for i := range header.dataTypes {
+		var rawDataType uint32
+		if rawDataType, err = reader.ReadUint32(); err != nil {
+			return
 		}
 
-		dataType, err := memCom.NewDataType(rawDataType)
-		if err != nil {
-			return nil, err
+		if header.dataTypes[i], err = memCom.NewDataType(rawDataType); err != nil {
+			return
 		}
-
-		dataTypes[i] = dataType
 	}
 
 	if err = reader.ReadPadding(int(totalDims)*4, 8); err != nil {
-		return nil, err
+		return
 	}
 
-	enumDicts := make(map[int][]string)
+	header.enumDicts = make(map[int][]string)
 	var i uint8
 	for ; i < numEnumColumns; i++ {
-		enumCasesBytes, err := reader.ReadUint32()
-		if err != nil {
-			return nil, err
+		var enumCasesBytes uint32
+		if enumCasesBytes, err = reader.ReadUint32(); err != nil {
+			return
 		}
 
-		columnID, err := reader.ReadUint16()
-		if err != nil {
-			return nil, err
+		var columnID uint16
+		if columnID, err = reader.ReadUint16(); err != nil {
+			return
 		}
 		reader.SkipBytes(2)
 		rawEnumCases := make([]byte, enumCasesBytes)
 		if err = reader.Read(rawEnumCases); err != nil {
-			return nil, err
+			return
 		}
 
 		enumCases := strings.Split(string(rawEnumCases), EnumDelimiter)
 
 		// remove last empty element.
 		enumCases = enumCases[:len(enumCases)-1]
-		enumDicts[int(columnID)] = enumCases
+		header.enumDicts[int(columnID)] = enumCases
 	}
 
-	headerSize := reader.GetBytesRead()
+	header.size = reader.GetBytesRead()
+	return
+}
+
+func parseTimeseriesHLLResult(buffer []byte) (AQLQueryResult, error) {
+	// empty result buffer
+	if len(buffer) == 0 {
+		return AQLQueryResult{}, nil
+	}
+
+	header, err := readHLLDataHeader(buffer)
+	if err != nil {
+		return nil, err
+	}
+	totalDims := len(header.dimIndexes)
 
 	result := make(AQLQueryResult)
 
-	paddedCountLength := uint32(2*resultSize+7) / 8 * 8
+	paddedCountLength := uint32(2*header.resultSize+7) / 8 * 8
 
-	dimValuesVector := unsafe.Pointer(&buffer[headerSize])
+	dimValuesVector := unsafe.Pointer(&buffer[header.size])
 
-	countVector := unsafe.Pointer(&buffer[headerSize+paddedRawDimValuesVectorLength])
+	countVector := unsafe.Pointer(&buffer[header.size+header.paddedRawDimValuesVectorLength])
 
-	hllVector := unsafe.Pointer(&buffer[headerSize+paddedRawDimValuesVectorLength+paddedCountLength])
+	hllVector := unsafe.Pointer(&buffer[header.size+header.paddedRawDimValuesVectorLength+paddedCountLength])
 
 	dimOffsets := make([][2]int, totalDims)
 	dimValues := make([]*string, totalDims)
 
 	for i := 0; i < totalDims; i++ {
-		dimIndex := int(dimIndexes[i])
-		valueOffset, nullOffset := GetDimensionStartOffsets(numDimsPerDimWidth, dimIndex, int(resultSize))
+		dimIndex := int(header.dimIndexes[i])
+		valueOffset, nullOffset := GetDimensionStartOffsets(header.numDimsPerDimWidth, dimIndex, int(header.resultSize))
 		dimOffsets[i] = [2]int{valueOffset, nullOffset}
 	}
 
 	var currentOffset int64
 
-	for i := 0; i < int(resultSize); i++ {
+	for i := 0; i < int(header.resultSize); i++ {
 		for dimIndex := 0; dimIndex < totalDims; dimIndex++ {
 			offsets := dimOffsets[dimIndex]
 			valueOffset, nullOffset := offsets[0], offsets[1]
 			valuePtr, nullPtr := memAccess(dimValuesVector, valueOffset), memAccess(dimValuesVector, nullOffset)
-			dimValues[dimIndex] = ReadDimension(valuePtr, nullPtr, i, dataTypes[dimIndex], enumDicts[dimIndex], nil, nil)
+			dimValues[dimIndex] = ReadDimension(valuePtr, nullPtr, i, header.dataTypes[dimIndex], header.enumDicts[dimIndex], nil, nil)
 		}
 
 		count := *(*uint16)(memAccess(countVector, int(2*i)))
-		hll := readHLL(hllVector, count, &currentOffset)
+		hll := readHLL(hllVector, count, &currentOffset, header.precision)
 		result.SetHLL(dimValues, hll)
 	}
 
 	return result, nil
 }
 
+// FoldHLLResult folds hll sketches of a serialized hll query result of default precision to the lower
+// precision, and returns the serialized result with the precision set in header.
+func FoldHLLResult(buffer []byte, precision uint8) ([]byte, error) {
+	if len(buffer) == 0 || precision == 0 || precision >= metaCom.DefaultHLLPrecision {
+		return buffer, nil
+	}
+
+	header, err := readHLLDataHeader(buffer)
+	if err != nil {
+		return nil, err
+	}
+	if header.precision != 0 {
+		return nil, utils.StackError(nil, "hll result of precision %d can not be folded again", header.precision)
+	}
+
+	countsOffset := header.size + header.paddedRawDimValuesVectorLength
+	hllOffset := countsOffset + uint32(2*header.resultSize+7)/8*8
+	counts := (*[math.MaxInt32 / 2]uint16)(unsafe.Pointer(&buffer[countsOffset]))[:header.resultSize:header.resultSize]
+
+	var hllVector unsafe.Pointer
+	if int(hllOffset) < len(buffer) {
+		hllVector = unsafe.Pointer(&buffer[hllOffset])
+	}
+	var folded []byte
+	var currentOffset int64
+	for i, count := range counts {
+		hll := readHLL(hllVector, count, &currentOffset, 0)
+		hll.Fold(precision)
+		counts[i] = hll.NonZeroRegisters
+		if hll.ConvertToSparse() {
+			for _, register := range hll.SparseData {
+				value := uint32(register.Rho)<<16 | uint32(register.Index)
+				folded = append(folded, (*(*[4]byte)(unsafe.Pointer(&value)))[:]...)
+			}
+		} else {
+			folded = append(folded, hll.DenseData...)
+		}
+	}
+	// padding for 8 bytes.
+	folded = append(folded, make([]byte, (8-len(folded)%8)%8)...)
+
+	buffer[hllPrecisionOffset] = precision
+	return append(buffer[:hllOffset], folded...), nil
+}
+
 // ComputeHLLResult computes hll result
 func ComputeHLLResult(result AQLQueryResult) AQLQueryResult {
 	return computeHLLResultRecursive(result).(AQLQueryResult)
@@ -535,12 +767,16 @@ func memAccess(p unsafe.Pointer, offset int) unsafe.Pointer {
 	return unsafe.Pointer(uintptr(p) + uintptr(offset))
 }
 
-// readHLL reads the HLL struct from the raw buffer and returns next offset
-func readHLL(hllVector unsafe.Pointer, count uint16, currentOffset *int64) HLL {
+// readHLL reads the HLL struct of the precision (0 for default) from the raw buffer and returns next offset
+func readHLL(hllVector unsafe.Pointer, count uint16, currentOffset *int64, precision uint8) HLL {
 	var sparseData []HLLRegister
 	var nonZeroRegisters uint16
 	var denseData []byte
-	if count < DenseThreshold {
+	denseDataLength := DenseDataLength
+	if precision != 0 {
+		denseDataLength = 1 << precision
+	}
+	if int(count) < denseDataLength/4 {
 		var i uint16
 		sparseData = make([]HLLRegister, 0, count)
 		for ; i < count; i++ {
@@ -555,8 +791,8 @@ func readHLL(hllVector unsafe.Pointer, count uint16, currentOffset *int64) HLL {
 		}
 		nonZeroRegisters = count
 	} else {
-		denseData = (*(*[DenseDataLength]byte)((memAccess(hllVector, int(*currentOffset)))))[:]
-		*currentOffset += DenseDataLength
+		denseData = (*(*[DenseDataLength]byte)((memAccess(hllVector, int(*currentOffset)))))[:denseDataLength:denseDataLength]
+		*currentOffset += int64(denseDataLength)
 		for _, b := range denseData {
 			if b != 0 {
 				nonZeroRegisters++
@@ -568,12 +804,25 @@ func readHLL(hllVector unsafe.Pointer, count uint16, currentOffset *int64) HLL {
 		DenseData:        denseData,
 		SparseData:       sparseData,
 		NonZeroRegisters: nonZeroRegisters,
+		Precision:        precision,
 	}
 }
 
 // ParseHLLQueryResults will parse the response body into a slice of query results and a slice of errors.
 func ParseHLLQueryResults(data []byte) (queryResults []AQLQueryResult, queryErrors []error, err error) {
-	reader := utils.NewStreamDataReader(bytes.NewBuffer(data))
+	err = ReadHLLQueryResults(bytes.NewBuffer(data), func(index int, result AQLQueryResult, queryErr error) error {
+		queryResults = append(queryResults, result)
+		queryErrors = append(queryErrors, queryErr)
+		return nil
+	})
+	return
+}
+
+// ReadHLLQueryResults reads the response body from the reader and calls the callback with the result
+// or error of each query in order. Only one query result is held in memory at a time, so large response
+// bodies can be processed as a stream.
+func ReadHLLQueryResults(r io.Reader, callback func(index int, result AQLQueryResult, queryErr error) error) (err error) {
+	reader := utils.NewStreamDataReader(r)
 
 	var magicHeader uint32
 	magicHeader, err = reader.ReadUint32()
@@ -592,29 +841,38 @@ func ParseHLLQueryResults(data []byte) (queryResults []AQLQueryResult, queryErro
 	var size uint32
 	var isErr uint8
 
-	for size, err = reader.ReadUint32(); err == nil; size, err = reader.ReadUint32() {
+	for index := 0; ; index++ {
+		if size, err = reader.ReadUint32(); err != nil {
+			break
+		}
+
 		if isErr, err = reader.ReadUint8(); err != nil {
 			return
 		}
 
 		reader.SkipBytes(3)
 
-		bs := make([]byte, size)
-		err = reader.Read(bs)
-		if err != nil {
+		// buffer grows with the data read instead of being allocated by the size in the body upfront.
+		var bs []byte
+		if bs, err = ioutil.ReadAll(io.LimitReader(r, int64(size))); err != nil {
+			return
+		}
+		if len(bs) != int(size) {
+			err = io.EOF
 			break
 		}
 
 		if isErr != 0 {
-			queryErrors = append(queryErrors, errors.New(string(bs)))
-			queryResults = append(queryResults, nil)
+			err = callback(index, nil, errors.New(string(bs)))
 		} else {
 			var res AQLQueryResult
 			if res, err = NewTimeSeriesHLLResult(bs, magicHeader); err != nil {
 				return
 			}
-			queryResults = append(queryResults, res)
-			queryErrors = append(queryErrors, nil)
+			err = callback(index, res, nil)
+		}
+		if err != nil {
+			return
 		}
 	}
 
@@ -657,9 +915,10 @@ func getEstimateBias(estimate float64) float64 {
 }
 
 // Decode decodes the HLL from cache cache.
-// Interprets as dense or sparse format based on len(data).
+// Interprets as dense or sparse format based on len(data), Precision must be set before decoding
+// HLLs of non default precision.
 func (hll *HLL) Decode(data []byte) {
-	if len(data) == 1<<hllP {
+	if len(data) == 1<<hll.precision() {
 		hll.DenseData = data
 		hll.SparseData = nil
 		hll.NonZeroRegisters = 0
@@ -682,7 +941,7 @@ func (hll *HLL) Decode(data []byte) {
 }
 
 // Encode encodes the HLL for cache storage.
-// Dense format will have a length of 1<<hllP.
+// Dense format will have a length of 1<<Precision.
 // Sparse format will have a smaller length
 func (hll *HLL) Encode() []byte {
 	if len(hll.DenseData) != 0 {
@@ -700,7 +959,8 @@ func (hll *HLL) Encode() []byte {
 // Compute computes the result of the HLL.
 func (hll *HLL) Compute() float64 {
 	nonZeroRegisters := float64(hll.NonZeroRegisters)
-	m := float64(uint64(1) << hllP)
+	precision := hll.precision()
+	m := float64(uint64(1) << precision)
 
 	// Sum of reciproclas of rhos
 	var sumOfReciprocals float64
@@ -719,6 +979,15 @@ func (hll *HLL) Compute() float64 {
 	alpha := 0.7213 / (1 + 1.079/m)
 	estimate := alpha * m * m / sumOfReciprocals
 
+	if precision != metaCom.DefaultHLLPrecision {
+		// No bias correction data for lower precisions, use linear counting for small cardinalities
+		// as the original hyperloglog.
+		if estimate <= 2.5*m && nonZeroRegisters < m {
+			estimate = m * math.Log(m/(m-nonZeroRegisters))
+		}
+		return float64(uint64(estimate))
+	}
+
 	// Bias correction.
 	if estimate <= 5.0*m {
 		estimate -= getEstimateBias(estimate)
@@ -749,8 +1018,6 @@ func (b hllBiasesByDistances) Less(i, j int) bool {
 
 // threshold and bias data taken from google's bias correction data set:
 // https://docs.google.com/document/d/1gyjfMHy43U9OWBXxfaeG-3MjGzejW1dlpyMwEYAAWEI/view?fullscreen#
-var hllP byte = 14
-
 var hllThreshold = 15500.0
 
 // precision 14
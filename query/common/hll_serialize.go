@@ -0,0 +1,221 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"unsafe"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// hllResultRow is a sketch of the hll query result with the values of its dimensions, nil for nulls.
+type hllResultRow struct {
+	dimValues []*string
+	hll       HLL
+}
+
+// SerializeHLLQueryResults serializes results and errors of queries by query index in the format of
+// application/hll responses, i.e. the reverse of ParseHLLQueryResults. Queries with errors are
+// serialized as errors, results of them are ignored.
+func SerializeHLLQueryResults(results []AQLQueryResult, queryErrors []error) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := utils.NewStreamDataWriter(&buffer)
+	if err := writer.WriteUint32(HLLDataHeader); err != nil {
+		return nil, err
+	}
+	if err := writer.SkipBytes(4); err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		var bs []byte
+		var isErr uint8
+		if i < len(queryErrors) && queryErrors[i] != nil {
+			bs, isErr = []byte(queryErrors[i].Error()), 1
+		} else {
+			var err error
+			if bs, err = SerializeHLLResult(result); err != nil {
+				return nil, utils.StackError(err, "failed to serialize result of query %d", i)
+			}
+		}
+
+		if err := writer.WriteUint32(uint32(len(bs))); err != nil {
+			return nil, err
+		}
+		if err := writer.WriteUint8(isErr); err != nil {
+			return nil, err
+		}
+		if err := writer.SkipBytes(3); err != nil {
+			return nil, err
+		}
+		if err := writer.Write(bs); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// SerializeHLLResult serializes the hll query result into the HLLData format of datanodes, so that results
+// merged from multiple datanodes can be consumed by clients of datanode responses as they are. Dimension
+// values are already formatted in the result, so all dimensions are serialized as enum columns of their
+// formatted values. Sketches are folded to the lowest precision in the result.
+func SerializeHLLResult(result AQLQueryResult) ([]byte, error) {
+	var rows []hllResultRow
+	if err := collectHLLResultRows(map[string]interface{}(result), nil, &rows); err != nil {
+		return nil, err
+	}
+	// empty results are serialized as empty buffers.
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	numDims := len(rows[0].dimValues)
+	if numDims > math.MaxUint8 {
+		return nil, utils.StackError(nil, "too many dimensions to serialize: %d", numDims)
+	}
+	precision := uint8(metaCom.DefaultHLLPrecision)
+	for _, row := range rows {
+		if len(row.dimValues) != numDims {
+			return nil, utils.StackError(nil, "sketches with different number of dimensions")
+		}
+		if row.hll.precision() < precision {
+			precision = row.hll.precision()
+		}
+	}
+
+	data := HLLData{
+		ResultSize: uint32(len(rows)),
+		DimIndexes: make([]int, numDims),
+		DataTypes:  make([]memCom.DataType, numDims),
+		EnumDicts:  make(map[int][]string, numDims),
+	}
+	if precision != metaCom.DefaultHLLPrecision {
+		data.Precision = precision
+	}
+	// enum ids of dimension values by dimension.
+	enumIDs := make([]map[string]uint16, numDims)
+	for dim := 0; dim < numDims; dim++ {
+		data.DimIndexes[dim] = dim
+		data.DataTypes[dim] = memCom.BigEnum
+		data.EnumDicts[dim] = []string{}
+		enumIDs[dim] = make(map[string]uint16)
+	}
+	data.NumDimsPerDimWidth[len(data.NumDimsPerDimWidth)-2] = uint8(numDims)
+	valueBytes := memCom.DataTypeBytes(memCom.BigEnum)
+	data.PaddedRawDimValuesVectorLength = uint32(utils.AlignOffset((valueBytes+1)*numDims*len(rows), 8))
+
+	counts := make([]uint16, len(rows))
+	var hllVector []byte
+	for i, row := range rows {
+		for dim, value := range row.dimValues {
+			if value == nil {
+				continue
+			}
+			if _, ok := enumIDs[dim][*value]; !ok {
+				if len(data.EnumDicts[dim]) > math.MaxUint16 {
+					return nil, utils.StackError(nil, "too many values of dimension %d to serialize", dim)
+				}
+				enumIDs[dim][*value] = uint16(len(data.EnumDicts[dim]))
+				data.EnumDicts[dim] = append(data.EnumDicts[dim], *value)
+			}
+		}
+
+		hll := row.hll
+		hll.Fold(precision)
+		counts[i] = hll.NonZeroRegisters
+		if hll.ConvertToSparse() {
+			for _, register := range hll.SparseData {
+				value := uint32(register.Rho)<<16 | uint32(register.Index)
+				hllVector = append(hllVector, (*(*[4]byte)(unsafe.Pointer(&value)))[:]...)
+			}
+		} else {
+			hll.ConvertToDense()
+			hllVector = append(hllVector, hll.DenseData...)
+		}
+	}
+	data.PaddedHLLVectorLength = int64(utils.AlignOffset(len(hllVector), 8))
+
+	headerSize, totalSize := data.CalculateSizes()
+	buffer := make([]byte, totalSize)
+	if err := data.SerializeHeader(buffer); err != nil {
+		return nil, err
+	}
+
+	writer := utils.NewBufferWriter(buffer)
+	for dim := 0; dim < numDims; dim++ {
+		valueOffset, nullOffset := GetDimensionStartOffsets(data.NumDimsPerDimWidth, dim, len(rows))
+		valueOffset += int(headerSize)
+		nullOffset += int(headerSize)
+		for i, row := range rows {
+			value := row.dimValues[dim]
+			if value == nil {
+				continue
+			}
+			if err := writer.WriteUint16(enumIDs[dim][*value], valueOffset+i*valueBytes); err != nil {
+				return nil, err
+			}
+			if err := writer.WriteUint8(1, nullOffset+i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	countsOffset := int(headerSize + data.PaddedRawDimValuesVectorLength)
+	for i, count := range counts {
+		if err := writer.WriteUint16(count, countsOffset+2*i); err != nil {
+			return nil, err
+		}
+	}
+	copy(buffer[countsOffset+utils.AlignOffset(2*len(rows), 8):], hllVector)
+	return buffer, nil
+}
+
+// collectHLLResultRows collects sketches of the result in the order of dimension values, dimension values of
+// "NULL" are nulls as set by AQLQueryResult.SetHLL.
+func collectHLLResultRows(value interface{}, dimValues []*string, rows *[]hllResultRow) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case HLL:
+		*rows = append(*rows, hllResultRow{
+			dimValues: append([]*string(nil), dimValues...),
+			hll:       v,
+		})
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			var dimValue *string
+			if key != "NULL" {
+				dimValue = &keys[i]
+			}
+			if err := collectHLLResultRows(v[key], append(dimValues, dimValue), rows); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return utils.StackError(nil, "unexpected value %v in hll result", v)
+	}
+}
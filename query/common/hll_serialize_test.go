@@ -0,0 +1,97 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"io/ioutil"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("hll serialization", func() {
+	ginkgo.It("round trips hll query results", func() {
+		data, err := ioutil.ReadFile("../../testing/data/query/hll_query_results")
+		Ω(err).Should(BeNil())
+		results, errs, err := ParseHLLQueryResults(data)
+		Ω(err).Should(BeNil())
+
+		serialized, err := SerializeHLLQueryResults(results, errs)
+		Ω(err).Should(BeNil())
+		roundTripped, roundTrippedErrs, err := ParseHLLQueryResults(serialized)
+		Ω(err).Should(BeNil())
+		Ω(roundTripped).Should(HaveLen(2))
+		// sketches with few registers are serialized in the sparse format, registers are the same.
+		Ω(convertHLLResultToDense(roundTripped[0])).Should(Equal(convertHLLResultToDense(results[0])))
+		Ω(roundTripped[1]).Should(BeNil())
+		Ω(roundTrippedErrs).Should(HaveLen(2))
+		Ω(roundTrippedErrs[0]).Should(BeNil())
+		Ω(roundTrippedErrs[1].Error()).Should(Equal("test"))
+	})
+
+	ginkgo.It("serializes empty results", func() {
+		serialized, err := SerializeHLLQueryResults([]AQLQueryResult{{}}, nil)
+		Ω(err).Should(BeNil())
+		results, errs, err := ParseHLLQueryResults(serialized)
+		Ω(err).Should(BeNil())
+		Ω(results).Should(Equal([]AQLQueryResult{{}}))
+		Ω(errs).Should(Equal([]error{nil}))
+	})
+
+	ginkgo.It("folds sketches to the lowest precision of the result", func() {
+		lower := buildTestHLL(getTestHashes(0, 5000), 12)
+		higher := buildTestHLL(getTestHashes(5000, 10000), 14)
+		result := AQLQueryResult{}
+		a, b := "a", "b"
+		result.SetHLL([]*string{&a, nil}, lower)
+		result.SetHLL([]*string{&b, &b}, higher)
+
+		bs, err := SerializeHLLResult(result)
+		Ω(err).Should(BeNil())
+		parsed, err := NewTimeSeriesHLLResult(bs, HLLDataHeader)
+		Ω(err).Should(BeNil())
+
+		higher.Fold(12)
+		Ω(parsed).Should(Equal(AQLQueryResult{
+			"a": map[string]interface{}{"NULL": lower},
+			"b": map[string]interface{}{"b": higher},
+		}))
+	})
+
+	ginkgo.It("fails results with sketches of different number of dimensions", func() {
+		_, err := SerializeHLLResult(AQLQueryResult{
+			"a": HLL{},
+			"b": map[string]interface{}{"c": HLL{}},
+		})
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = SerializeHLLResult(AQLQueryResult{"a": 1.0})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
+
+// convertHLLResultToDense converts sketches of the result to the dense format in place.
+func convertHLLResultToDense(result map[string]interface{}) map[string]interface{} {
+	for key, value := range result {
+		switch v := value.(type) {
+		case HLL:
+			v.ConvertToDense()
+			result[key] = v
+		case map[string]interface{}:
+			convertHLLResultToDense(v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,250 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/base64"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// Standard dense format of hll sketches for exchanging sketches with other systems. It follows the
+// storage spec (v1) of postgresql-hll and java-hll:
+//
+//	[4 bits] version (1) [4 bits] type (1: empty, 4: dense)
+//	[3 bits] register width - 1 [5 bits] precision (log2m)
+//	[1 bit] padding [1 bit] sparse enabled [6 bits] explicit cutoff, written as 0 and ignored on read
+//	<registers>, dense type only
+//	2^precision registers of register width bits each, register 0 first, packed from the most
+//	significant bit of each byte, padded with 0 bits to whole bytes.
+//
+// The register index is the lowest precision bits of the hash, register value is 0 for empty registers,
+// otherwise 1 + number of trailing zeros of the hash bits above the index bits, capped at the max
+// value of the register width. This is the same as rho of AresDB sketches, so sketches built by other
+// systems with the same 64 bit hash (murmur3, seed 0) can be unioned with AresDB sketches.
+const (
+	// StandardHLLVersion is the version of the standard dense format.
+	StandardHLLVersion = 1
+	// StandardHLLTypeEmpty is the type of sketches without any non empty registers.
+	StandardHLLTypeEmpty = 1
+	// StandardHLLTypeDense is the type of sketches with all registers.
+	StandardHLLTypeDense = 4
+	// DefaultStandardHLLRegisterWidth is the register width in bits for exporting sketches,
+	// large enough for any rho of AresDB sketches.
+	DefaultStandardHLLRegisterWidth = 6
+	// MaxStandardHLLRegisterWidth is the max register width in bits.
+	MaxStandardHLLRegisterWidth = 8
+	// MinStandardHLLPrecision is the min precision of the standard dense format.
+	MinStandardHLLPrecision = 4
+
+	standardHLLHeaderSize = 3
+)
+
+// EncodeStandard encodes the HLL in the standard dense format of the register width in bits.
+// Registers overflowing the register width are saturated.
+func (hll *HLL) EncodeStandard(registerWidth uint8) ([]byte, error) {
+	if registerWidth == 0 || registerWidth > MaxStandardHLLRegisterWidth {
+		return nil, utils.StackError(nil, "hll register width %d not allowed, valid range: [1, %d]",
+			registerWidth, MaxStandardHLLRegisterWidth)
+	}
+
+	precision := hll.precision()
+	header := []byte{0, (registerWidth-1)<<5 | precision, 0}
+	if hll.NonZeroRegisters == 0 {
+		header[0] = StandardHLLVersion<<4 | StandardHLLTypeEmpty
+		return header, nil
+	}
+	header[0] = StandardHLLVersion<<4 | StandardHLLTypeDense
+
+	data := make([]byte, standardHLLHeaderSize+((1<<precision)*int(registerWidth)+7)/8)
+	copy(data, header)
+	registers := data[standardHLLHeaderSize:]
+	maxRho := byte(1<<registerWidth - 1)
+	setRegister := func(index uint16, rho byte) {
+		if rho > maxRho {
+			rho = maxRho
+		}
+		offset := int(index) * int(registerWidth)
+		for bit := int(registerWidth) - 1; bit >= 0; bit-- {
+			if rho&(1<<uint(bit)) != 0 {
+				registers[offset/8] |= 0x80 >> uint(offset%8)
+			}
+			offset++
+		}
+	}
+	for _, register := range hll.SparseData {
+		setRegister(register.Index, register.Rho)
+	}
+	for index, rho := range hll.DenseData {
+		setRegister(uint16(index), rho)
+	}
+	return data, nil
+}
+
+// DecodeStandardHLL decodes the HLL from the standard dense format. Sketches of higher precisions
+// than the default precision are folded to the default precision.
+func DecodeStandardHLL(data []byte) (hll HLL, err error) {
+	if len(data) < standardHLLHeaderSize {
+		err = utils.StackError(nil, "invalid hll sketch of %d bytes", len(data))
+		return
+	}
+	if version := data[0] >> 4; version != StandardHLLVersion {
+		err = utils.StackError(nil, "hll sketch version %d not supported", version)
+		return
+	}
+
+	registerWidth := data[1]>>5 + 1
+	from := data[1] & 0x1f
+	if from < MinStandardHLLPrecision {
+		err = utils.StackError(nil, "hll sketch precision %d not allowed, min precision: %d",
+			from, MinStandardHLLPrecision)
+		return
+	}
+	precision := from
+	if precision > metaCom.DefaultHLLPrecision {
+		precision = metaCom.DefaultHLLPrecision
+	}
+	if precision != metaCom.DefaultHLLPrecision {
+		hll.Precision = precision
+	}
+
+	switch sketchType := data[0] & 0xf; sketchType {
+	case StandardHLLTypeEmpty:
+		return
+	case StandardHLLTypeDense:
+	default:
+		err = utils.StackError(nil, "hll sketch type %d not supported, only empty and dense sketches are supported",
+			sketchType)
+		return
+	}
+
+	numRegisters := uint64(1) << from
+	registers := data[standardHLLHeaderSize:]
+	if expected := (numRegisters*uint64(registerWidth) + 7) / 8; uint64(len(registers)) != expected {
+		err = utils.StackError(nil, "invalid dense hll sketch of %d registers bytes, expected %d bytes",
+			len(registers), expected)
+		return
+	}
+
+	hll.DenseData = make([]byte, 1<<precision)
+	var offset uint64
+	for index := uint64(0); index < numRegisters; index++ {
+		var rho byte
+		for bit := uint8(0); bit < registerWidth; bit++ {
+			rho = rho<<1 | (registers[offset/8]>>(7-offset%8))&1
+			offset++
+		}
+		if rho == 0 {
+			continue
+		}
+
+		foldedIndex := uint16(index)
+		if from != precision {
+			foldedIndex, rho = foldHLLRegister(uint32(index), rho, from, precision)
+		}
+		if hll.DenseData[foldedIndex] == 0 {
+			hll.NonZeroRegisters++
+		}
+		if hll.DenseData[foldedIndex] < rho {
+			hll.DenseData[foldedIndex] = rho
+		}
+	}
+	return
+}
+
+// ExportStandardHLLResult replaces hll sketches of the result with sketches base64 encoded in the
+// standard dense format of the register width. Sketches of higher precisions are folded to the
+// precision, 0 to keep the precisions of sketches.
+func ExportStandardHLLResult(result AQLQueryResult, precision, registerWidth uint8) (AQLQueryResult, error) {
+	exported, err := exportStandardHLLResultRecursive(result, precision, registerWidth)
+	if err != nil {
+		return nil, err
+	}
+	return exported.(AQLQueryResult), nil
+}
+
+// exportStandardHLLResultRecursive exports hll sketches in place
+func exportStandardHLLResultRecursive(result interface{}, precision, registerWidth uint8) (interface{}, error) {
+	var err error
+	switch r := result.(type) {
+	case AQLQueryResult:
+		for k, v := range r {
+			if r[k], err = exportStandardHLLResultRecursive(v, precision, registerWidth); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	case map[string]interface{}:
+		for k, v := range r {
+			if r[k], err = exportStandardHLLResultRecursive(v, precision, registerWidth); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	case HLL:
+		if precision != 0 {
+			r.Fold(precision)
+		}
+		var data []byte
+		if data, err = r.EncodeStandard(registerWidth); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(data), nil
+	default:
+		// return original for all other types
+		return r, nil
+	}
+}
+
+// ImportStandardHLLResult replaces base64 encoded sketches in the standard dense format of the result
+// (e.g. unmarshalled from json) with hll sketches, so that it can be merged with hll query results.
+func ImportStandardHLLResult(result AQLQueryResult) (AQLQueryResult, error) {
+	imported, err := importStandardHLLResultRecursive(result)
+	if err != nil {
+		return nil, err
+	}
+	return imported.(AQLQueryResult), nil
+}
+
+// importStandardHLLResultRecursive imports hll sketches in place
+func importStandardHLLResultRecursive(result interface{}) (interface{}, error) {
+	var err error
+	switch r := result.(type) {
+	case AQLQueryResult:
+		for k, v := range r {
+			if r[k], err = importStandardHLLResultRecursive(v); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	case map[string]interface{}:
+		for k, v := range r {
+			if r[k], err = importStandardHLLResultRecursive(v); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	case string:
+		data, err := base64.StdEncoding.DecodeString(r)
+		if err != nil {
+			return nil, utils.StackError(err, "invalid base64 encoded hll sketch")
+		}
+		return DecodeStandardHLL(data)
+	case HLL:
+		return r, nil
+	default:
+		return nil, utils.StackError(nil, "unexpected hll sketch %v", r)
+	}
+}
@@ -0,0 +1,201 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"math/rand"
+)
+
+var _ = ginkgo.Describe("standard hll", func() {
+	ginkgo.It("EncodeStandard should follow the standard dense layout", func() {
+		h := HLL{DenseData: make([]byte, 16), Precision: 4}
+		h.Set(0, 1)
+		h.Set(1, 31)
+		h.Set(15, 3)
+		data, err := h.EncodeStandard(5)
+		Ω(err).Should(BeNil())
+		// 16 registers of 5 bits: 00001 11111 00000 ... 00011
+		Ω(data).Should(Equal([]byte{
+			0x14, 0x84, 0x00,
+			0x0f, 0xc0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03,
+		}))
+
+		h = HLL{}
+		data, err = h.EncodeStandard(5)
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal([]byte{0x11, 0x8e, 0x00}))
+
+		_, err = h.EncodeStandard(0)
+		Ω(err).ShouldNot(BeNil())
+		_, err = h.EncodeStandard(9)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("round trips all precisions and register widths", func() {
+		r := rand.New(rand.NewSource(0))
+		for precision := uint8(MinStandardHLLPrecision); precision <= 14; precision++ {
+			for registerWidth := uint8(1); registerWidth <= MaxStandardHLLRegisterWidth; registerWidth++ {
+				h := HLL{DenseData: make([]byte, 1<<precision)}
+				if precision != 14 {
+					h.Precision = precision
+				}
+				for index := range h.DenseData {
+					h.DenseData[index] = byte(r.Intn(1 << registerWidth))
+					if h.DenseData[index] != 0 {
+						h.NonZeroRegisters++
+					}
+				}
+				data, err := h.EncodeStandard(registerWidth)
+				Ω(err).Should(BeNil())
+				decoded, err := DecodeStandardHLL(data)
+				Ω(err).Should(BeNil())
+				Ω(decoded).Should(Equal(h), "precision %d, register width %d", precision, registerWidth)
+			}
+		}
+	})
+
+	ginkgo.It("round trips sparse and empty hlls", func() {
+		h := buildTestHLL(getTestHashes(0, 100), 14)
+		expected := h
+		expected.DenseData = append([]byte{}, h.DenseData...)
+		Ω(h.ConvertToSparse()).Should(BeTrue())
+		data, err := h.EncodeStandard(DefaultStandardHLLRegisterWidth)
+		Ω(err).Should(BeNil())
+		decoded, err := DecodeStandardHLL(data)
+		Ω(err).Should(BeNil())
+		Ω(decoded).Should(Equal(expected))
+
+		h = HLL{Precision: 10}
+		data, err = h.EncodeStandard(DefaultStandardHLLRegisterWidth)
+		Ω(err).Should(BeNil())
+		Ω(data).Should(HaveLen(3))
+		decoded, err = DecodeStandardHLL(data)
+		Ω(err).Should(BeNil())
+		Ω(decoded).Should(Equal(h))
+	})
+
+	ginkgo.It("saturates registers overflowing the register width", func() {
+		h := buildTestHLL(getTestHashes(0, 100000), 14)
+		data, err := h.EncodeStandard(2)
+		Ω(err).Should(BeNil())
+		decoded, err := DecodeStandardHLL(data)
+		Ω(err).Should(BeNil())
+		Ω(decoded.NonZeroRegisters).Should(Equal(h.NonZeroRegisters))
+		for index, rho := range h.DenseData {
+			if rho > 3 {
+				rho = 3
+			}
+			Ω(decoded.DenseData[index]).Should(Equal(rho))
+		}
+	})
+
+	ginkgo.It("folds sketches of higher precisions to the default precision", func() {
+		hashes := getTestHashes(0, 10000)
+		h := buildTestHLL(hashes, 16)
+		data, err := h.EncodeStandard(DefaultStandardHLLRegisterWidth)
+		Ω(err).Should(BeNil())
+		Ω(data[1] & 0x1f).Should(BeEquivalentTo(16))
+		decoded, err := DecodeStandardHLL(data)
+		Ω(err).Should(BeNil())
+		Ω(decoded).Should(Equal(buildTestHLL(hashes, 14)))
+
+		// unions with sketches of lower precisions at the lower precision.
+		h = buildTestHLL(getTestHashes(0, 5000), 16)
+		data, err = h.EncodeStandard(DefaultStandardHLLRegisterWidth)
+		Ω(err).Should(BeNil())
+		decoded, err = DecodeStandardHLL(data)
+		Ω(err).Should(BeNil())
+		merged := buildTestHLL(getTestHashes(5000, 10000), 12)
+		merged.Merge(decoded)
+		Ω(merged).Should(Equal(buildTestHLL(hashes, 12)))
+	})
+
+	ginkgo.It("DecodeStandardHLL should fail on invalid sketches", func() {
+		h := buildTestHLL(getTestHashes(0, 100), 10)
+		valid, err := h.EncodeStandard(5)
+		Ω(err).Should(BeNil())
+		_, err = DecodeStandardHLL(valid)
+		Ω(err).Should(BeNil())
+
+		for _, data := range [][]byte{
+			nil,
+			{0x14, 0x8a},
+			// version.
+			append([]byte{0x24}, valid[1:]...),
+			// explicit and sparse types.
+			append([]byte{0x12}, valid[1:]...),
+			append([]byte{0x13}, valid[1:]...),
+			// precision.
+			{0x11, 0x83, 0x00},
+			// length.
+			valid[:len(valid)-1],
+			append(valid, 0),
+		} {
+			_, err = DecodeStandardHLL(data)
+			Ω(err).ShouldNot(BeNil())
+		}
+	})
+
+	ginkgo.It("exports and imports hll results", func() {
+		h1 := buildTestHLL(getTestHashes(0, 1000), 14)
+		h2 := buildTestHLL(getTestHashes(1000, 1010), 14)
+		Ω(h2.ConvertToSparse()).Should(BeTrue())
+		result := AQLQueryResult{
+			"1": map[string]interface{}{
+				"a": h1,
+				"b": h2,
+			},
+			"NULL": map[string]interface{}{
+				"a": HLL{},
+			},
+		}
+
+		exported, err := ExportStandardHLLResult(result, 12, 5)
+		Ω(err).Should(BeNil())
+		data, err := base64.StdEncoding.DecodeString(exported["1"].(map[string]interface{})["a"].(string))
+		Ω(err).Should(BeNil())
+		Ω(data[:2]).Should(Equal([]byte{0x14, 0x8c}))
+
+		bs, err := json.Marshal(exported)
+		Ω(err).Should(BeNil())
+		var unmarshalled AQLQueryResult
+		Ω(json.Unmarshal(bs, &unmarshalled)).Should(BeNil())
+		imported, err := ImportStandardHLLResult(unmarshalled)
+		Ω(err).Should(BeNil())
+
+		h1 = buildTestHLL(getTestHashes(0, 1000), 12)
+		h2 = buildTestHLL(getTestHashes(1000, 1010), 12)
+		Ω(imported).Should(Equal(AQLQueryResult{
+			"1": map[string]interface{}{
+				"a": h1,
+				"b": h2,
+			},
+			"NULL": map[string]interface{}{
+				"a": HLL{Precision: 12},
+			},
+		}))
+
+		_, err = ImportStandardHLLResult(AQLQueryResult{"1": "not base64"})
+		Ω(err).ShouldNot(BeNil())
+		_, err = ImportStandardHLLResult(AQLQueryResult{"1": 1.0})
+		Ω(err).ShouldNot(BeNil())
+		_, err = ExportStandardHLLResult(AQLQueryResult{"1": h1}, 0, 9)
+		Ω(err).ShouldNot(BeNil())
+	})
+})
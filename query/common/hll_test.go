@@ -19,7 +19,10 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
 	"io/ioutil"
+	"math"
 	"unsafe"
 )
 
@@ -66,35 +69,35 @@ var _ = ginkgo.Describe("hll", func() {
 		var currentOffset int64
 		var hllData HLL
 		// Sparse
-		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[0], &currentOffset)
+		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[0], &currentOffset, 0)
 		Ω(currentOffset).Should(BeEquivalentTo(12))
 		Ω(hllData.SparseData).ShouldNot(BeNil())
 		Ω(hllData.DenseData).Should(BeNil())
 		Ω(hllData.NonZeroRegisters).Should(BeEquivalentTo(3))
 
 		// Dense
-		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[1], &currentOffset)
+		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[1], &currentOffset, 0)
 		Ω(currentOffset).Should(BeEquivalentTo(12 + DenseDataLength))
 		Ω(hllData.SparseData).Should(BeNil())
 		Ω(hllData.DenseData).ShouldNot(BeNil())
 		Ω(hllData.NonZeroRegisters).Should(BeEquivalentTo(2))
 
 		// Sparse
-		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[2], &currentOffset)
+		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[2], &currentOffset, 0)
 		Ω(currentOffset).Should(BeEquivalentTo(28 + DenseDataLength))
 		Ω(hllData.SparseData).ShouldNot(BeNil())
 		Ω(hllData.DenseData).Should(BeNil())
 		Ω(hllData.NonZeroRegisters).Should(BeEquivalentTo(4))
 
 		// Dense
-		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[3], &currentOffset)
+		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[3], &currentOffset, 0)
 		Ω(currentOffset).Should(BeEquivalentTo(28 + 2*DenseDataLength))
 		Ω(hllData.SparseData).Should(BeNil())
 		Ω(hllData.DenseData).ShouldNot(BeNil())
 		Ω(hllData.NonZeroRegisters).Should(BeEquivalentTo(0))
 
 		// Sparse
-		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[4], &currentOffset)
+		hllData = readHLL(unsafe.Pointer(&hllVector[0]), counts[4], &currentOffset, 0)
 		Ω(currentOffset).Should(BeEquivalentTo(48 + 2*DenseDataLength))
 		Ω(hllData.SparseData).ShouldNot(BeNil())
 		Ω(hllData.DenseData).Should(BeNil())
@@ -198,7 +201,7 @@ var _ = ginkgo.Describe("hll", func() {
 		h2.Decode(h1.Encode())
 		Ω(h2).Should(Equal(h1))
 
-		hllDenseData := make([]byte, 1<<hllP)
+		hllDenseData := make([]byte, DenseDataLength)
 		hllDenseData[100] = 1
 		hllDenseData[200] = 2
 		h1 = HLL{
@@ -235,4 +238,117 @@ var _ = ginkgo.Describe("hll", func() {
 		Ω(h.DenseData[4300]).Should(Equal(byte(0)))
 		Ω(h.NonZeroRegisters).Should(Equal(uint16(4101)))
 	})
+
+	ginkgo.It("folds to lower precision as if built at lower precision", func() {
+		hashes := getTestHashes(0, 10000)
+		for _, precision := range []uint8{10, 12} {
+			h := buildTestHLL(hashes, 14)
+			h.Fold(precision)
+			expected := buildTestHLL(hashes, precision)
+			Ω(h.Precision).Should(Equal(precision))
+			Ω(h.NonZeroRegisters).Should(Equal(expected.NonZeroRegisters))
+			Ω(h.DenseData).Should(Equal(expected.DenseData))
+		}
+
+		// sparse stays sparse.
+		h := buildTestHLL(getTestHashes(0, 10), 14)
+		Ω(h.ConvertToSparse()).Should(BeTrue())
+		h.Fold(12)
+		Ω(h.DenseData).Should(BeNil())
+		Ω(h.SparseData).Should(HaveLen(10))
+
+		// noop for higher precision.
+		h.Fold(14)
+		Ω(h.Precision).Should(BeEquivalentTo(12))
+	})
+
+	ginkgo.It("merges hlls of different precisions at the lower precision", func() {
+		h1 := buildTestHLL(getTestHashes(0, 5000), 14)
+		h2 := buildTestHLL(getTestHashes(5000, 10000), 12)
+		h1.Merge(h2)
+		Ω(h1.Precision).Should(BeEquivalentTo(12))
+		Ω(h1.DenseData).Should(Equal(buildTestHLL(getTestHashes(0, 10000), 12).DenseData))
+
+		h1 = buildTestHLL(getTestHashes(0, 5000), 12)
+		h2 = buildTestHLL(getTestHashes(5000, 10000), 14)
+		h2DenseData := append([]byte{}, h2.DenseData...)
+		h1.Merge(h2)
+		Ω(h1.Precision).Should(BeEquivalentTo(12))
+		Ω(h1.DenseData).Should(Equal(buildTestHLL(getTestHashes(0, 10000), 12).DenseData))
+		// the merged hll is not changed.
+		Ω(h2.DenseData).Should(Equal(h2DenseData))
+	})
+
+	ginkgo.It("trades accuracy for size with lower precisions", func() {
+		// standard error is 1.04/sqrt(2^precision): 3.25% at 10, 1.63% at 12 and 0.81% at 14.
+		for _, cardinality := range []int{1000, 100000} {
+			h := buildTestHLL(getTestHashes(0, cardinality), 14)
+			for _, precision := range []uint8{14, 12, 10} {
+				h.Fold(precision)
+				standardError := 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+				Ω(math.Abs(h.Compute()-float64(cardinality)) / float64(cardinality)).Should(BeNumerically("<", 3*standardError))
+				Ω(h.Encode()).Should(HaveLen(1 << precision))
+			}
+		}
+	})
+
+	ginkgo.It("FoldHLLResult should work", func() {
+		data, err := ioutil.ReadFile("../../testing/data/query/hll")
+		Ω(err).Should(BeNil())
+		expected, err := NewTimeSeriesHLLResult(data, HLLDataHeader)
+		Ω(err).Should(BeNil())
+
+		folded, err := FoldHLLResult(append([]byte{}, data...), 12)
+		Ω(err).Should(BeNil())
+		Ω(len(folded) % 8).Should(Equal(0))
+		res, err := NewTimeSeriesHLLResult(folded, HLLDataHeader)
+		Ω(err).Should(BeNil())
+		for _, path := range [][]string{{"NULL", "NULL", "NULL"}, {"1", "c", "2"}, {"4294967295", "d", "514"}} {
+			expectedHLL := expected[path[0]].(map[string]interface{})[path[1]].(map[string]interface{})[path[2]].(HLL)
+			expectedHLL.Fold(12)
+			expectedHLL.ConvertToDense()
+			foldedHLL := res[path[0]].(map[string]interface{})[path[1]].(map[string]interface{})[path[2]].(HLL)
+			Ω(foldedHLL.Precision).Should(BeEquivalentTo(12))
+			foldedHLL.ConvertToDense()
+			Ω(foldedHLL).Should(Equal(expectedHLL))
+		}
+
+		_, err = FoldHLLResult(folded, 10)
+		Ω(err).ShouldNot(BeNil())
+
+		unfolded, err := FoldHLLResult(data, 14)
+		Ω(err).Should(BeNil())
+		Ω(unfolded).Should(Equal(data))
+	})
 })
+
+// getTestHashes returns hashes of values in [from, to).
+func getTestHashes(from, to int) []uint64 {
+	hashes := make([]uint64, 0, to-from)
+	for i := int64(from); i < int64(to); i++ {
+		hashes = append(hashes, utils.Murmur3Sum64(unsafe.Pointer(&i), 8, 0))
+	}
+	return hashes
+}
+
+// buildTestHLL builds a dense hll of the precision the same way as query engine.
+func buildTestHLL(hashes []uint64, precision uint8) HLL {
+	h := HLL{DenseData: make([]byte, 1<<precision)}
+	if precision != metaCom.DefaultHLLPrecision {
+		h.Precision = precision
+	}
+	for _, hash := range hashes {
+		index := uint16(hash & (1<<precision - 1))
+		rho := byte(1)
+		for uint(rho)+uint(precision) <= 64 && hash&(1<<(uint(rho)-1+uint(precision))) == 0 {
+			rho++
+		}
+		if h.DenseData[index] == 0 {
+			h.NonZeroRegisters++
+		}
+		if h.DenseData[index] < rho {
+			h.DenseData[index] = rho
+		}
+	}
+	return h
+}
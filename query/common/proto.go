@@ -0,0 +1,408 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"math"
+
+	queryProto "github.com/uber/aresdb/query/generated/proto"
+	"github.com/uber/aresdb/utils"
+)
+
+// AQLQueryToProto converts the query to its protobuf message. Caller and caller roles are not converted
+// since they are set from request headers.
+func AQLQueryToProto(q *AQLQuery) (*queryProto.AQLQuery, error) {
+	p := &queryProto.AQLQuery{
+		Table:                    q.Table,
+		RowFilters:               q.Filters,
+		Timezone:                 q.Timezone,
+		Now:                      q.Now,
+		Limit:                    int64(q.Limit),
+		Offset:                   int64(q.Offset),
+		Sql:                      q.SQLQuery,
+		IncludeDeprecatedColumns: q.IncludeDeprecatedColumns,
+	}
+	for _, shard := range q.Shards {
+		p.Shards = append(p.Shards, int64(shard))
+	}
+	for _, join := range q.Joins {
+		p.Joins = append(p.Joins, &queryProto.Join{
+			Table:      join.Table,
+			Alias:      join.Alias,
+			Conditions: join.Conditions,
+		})
+	}
+	p.Dimensions = dimensionsToProto(q.Dimensions)
+	p.Measures = measuresToProto(q.Measures)
+	if q.TimeFilter != (TimeFilter{}) {
+		p.TimeFilter = &queryProto.TimeFilter{
+			Column: q.TimeFilter.Column,
+			From:   q.TimeFilter.From,
+			To:     q.TimeFilter.To,
+		}
+	}
+	p.SupportingDimensions = dimensionsToProto(q.SupportingDimensions)
+	p.SupportingMeasures = measuresToProto(q.SupportingMeasures)
+	for _, sort := range q.Sorts {
+		p.Sorts = append(p.Sorts, &queryProto.SortField{
+			Name:  sort.Name,
+			Order: sort.Order,
+		})
+	}
+	if q.HLLSketch != nil {
+		p.HllSketch = &queryProto.HLLSketchOption{
+			Precision:     uint32(q.HLLSketch.Precision),
+			RegisterWidth: uint32(q.HLLSketch.RegisterWidth),
+		}
+		if q.HLLSketch.Union != nil {
+			union, err := resultMapToProto(q.HLLSketch.Union, "hllSketch.union")
+			if err != nil {
+				return nil, err
+			}
+			p.HllSketch.Union = union
+		}
+	}
+	return p, nil
+}
+
+// AQLQueryFromProto converts the protobuf message to the query.
+func AQLQueryFromProto(p *queryProto.AQLQuery) (*AQLQuery, error) {
+	q := &AQLQuery{
+		Table:                    p.Table,
+		Filters:                  p.RowFilters,
+		Timezone:                 p.Timezone,
+		Now:                      p.Now,
+		SQLQuery:                 p.Sql,
+		Limit:                    int(p.Limit),
+		Offset:                   int(p.Offset),
+		IncludeDeprecatedColumns: p.IncludeDeprecatedColumns,
+	}
+	for _, shard := range p.Shards {
+		q.Shards = append(q.Shards, int(shard))
+	}
+	for _, join := range p.Joins {
+		q.Joins = append(q.Joins, Join{
+			Table:      join.Table,
+			Alias:      join.Alias,
+			Conditions: join.Conditions,
+		})
+	}
+	q.Dimensions = dimensionsFromProto(p.Dimensions)
+	q.Measures = measuresFromProto(p.Measures)
+	if p.TimeFilter != nil {
+		q.TimeFilter = TimeFilter{
+			Column: p.TimeFilter.Column,
+			From:   p.TimeFilter.From,
+			To:     p.TimeFilter.To,
+		}
+	}
+	q.SupportingDimensions = dimensionsFromProto(p.SupportingDimensions)
+	q.SupportingMeasures = measuresFromProto(p.SupportingMeasures)
+	for _, sort := range p.Sorts {
+		q.Sorts = append(q.Sorts, SortField{
+			Name:  sort.Name,
+			Order: sort.Order,
+		})
+	}
+	if p.HllSketch != nil {
+		if p.HllSketch.Precision > math.MaxUint8 {
+			return nil, utils.StackError(nil, "hllSketch.precision %d out of range", p.HllSketch.Precision)
+		}
+		if p.HllSketch.RegisterWidth > math.MaxUint8 {
+			return nil, utils.StackError(nil, "hllSketch.registerWidth %d out of range", p.HllSketch.RegisterWidth)
+		}
+		q.HLLSketch = &HLLSketchOption{
+			Precision:     uint8(p.HllSketch.Precision),
+			RegisterWidth: uint8(p.HllSketch.RegisterWidth),
+		}
+		if p.HllSketch.Union != nil {
+			union, err := resultMapFromProto(p.HllSketch.Union, "hllSketch.union")
+			if err != nil {
+				return nil, err
+			}
+			q.HLLSketch.Union = union
+		}
+	}
+	return q, nil
+}
+
+func dimensionsToProto(dimensions []Dimension) (p []*queryProto.Dimension) {
+	for _, dim := range dimensions {
+		pDim := &queryProto.Dimension{
+			Alias:          dim.Alias,
+			SqlExpression:  dim.Expr,
+			TimeBucketizer: dim.TimeBucketizer,
+			TimeUnit:       dim.TimeUnit,
+		}
+		bucketizer := dim.NumericBucketizer
+		if bucketizer.BucketWidth != 0 || bucketizer.LogBase != 0 || len(bucketizer.ManualPartitions) != 0 {
+			pDim.NumericBucketizer = &queryProto.NumericBucketizer{
+				BucketWidth:      bucketizer.BucketWidth,
+				LogBase:          bucketizer.LogBase,
+				ManualPartitions: bucketizer.ManualPartitions,
+			}
+		}
+		p = append(p, pDim)
+	}
+	return
+}
+
+func dimensionsFromProto(p []*queryProto.Dimension) (dimensions []Dimension) {
+	for _, pDim := range p {
+		dim := Dimension{
+			Alias:          pDim.Alias,
+			Expr:           pDim.SqlExpression,
+			TimeBucketizer: pDim.TimeBucketizer,
+			TimeUnit:       pDim.TimeUnit,
+		}
+		if pDim.NumericBucketizer != nil {
+			dim.NumericBucketizer = NumericBucketizerDef{
+				BucketWidth:      pDim.NumericBucketizer.BucketWidth,
+				LogBase:          pDim.NumericBucketizer.LogBase,
+				ManualPartitions: pDim.NumericBucketizer.ManualPartitions,
+			}
+		}
+		dimensions = append(dimensions, dim)
+	}
+	return
+}
+
+func measuresToProto(measures []Measure) (p []*queryProto.Measure) {
+	for _, measure := range measures {
+		p = append(p, &queryProto.Measure{
+			Alias:         measure.Alias,
+			SqlExpression: measure.Expr,
+			RowFilters:    measure.Filters,
+		})
+	}
+	return
+}
+
+func measuresFromProto(p []*queryProto.Measure) (measures []Measure) {
+	for _, pMeasure := range p {
+		measures = append(measures, Measure{
+			Alias:   pMeasure.Alias,
+			Expr:    pMeasure.SqlExpression,
+			Filters: pMeasure.RowFilters,
+		})
+	}
+	return
+}
+
+// AQLQueryResultToProto converts the query result to its protobuf message, results with headers are
+// converted as non aggregate query results.
+func AQLQueryResultToProto(result AQLQueryResult) (*queryProto.QueryResult, error) {
+	if _, ok := result[HeadersKey]; ok {
+		nonAgg, err := nonAggResultToProto(result)
+		if err != nil {
+			return nil, err
+		}
+		return &queryProto.QueryResult{Result: &queryProto.QueryResult_NonAggregate{NonAggregate: nonAgg}}, nil
+	}
+	agg, err := resultMapToProto(result, "result")
+	if err != nil {
+		return nil, err
+	}
+	return &queryProto.QueryResult{Result: &queryProto.QueryResult_Aggregate{Aggregate: agg}}, nil
+}
+
+// AQLQueryResultFromProto converts the protobuf message to the query result.
+func AQLQueryResultFromProto(p *queryProto.QueryResult) (AQLQueryResult, error) {
+	switch r := p.Result.(type) {
+	case *queryProto.QueryResult_Aggregate:
+		return resultMapFromProto(r.Aggregate, "result")
+	case *queryProto.QueryResult_NonAggregate:
+		return nonAggResultFromProto(r.NonAggregate)
+	default:
+		return AQLQueryResult{}, nil
+	}
+}
+
+// resultMapToProto converts nested maps of aggregate query results, field is the path of the map for errors.
+func resultMapToProto(m map[string]interface{}, field string) (*queryProto.ResultMap, error) {
+	p := &queryProto.ResultMap{Entries: make(map[string]*queryProto.ResultNode, len(m))}
+	for key, value := range m {
+		var node queryProto.ResultNode
+		switch v := value.(type) {
+		case nil:
+			node.Value = &queryProto.ResultNode_NullMeasure{NullMeasure: true}
+		case float64:
+			node.Value = &queryProto.ResultNode_Measure{Measure: v}
+		case string:
+			node.Value = &queryProto.ResultNode_Sketch{Sketch: v}
+		case HLL:
+			node.Value = &queryProto.ResultNode_Hll{Hll: hllToProto(&v)}
+		case *HLL:
+			node.Value = &queryProto.ResultNode_Hll{Hll: hllToProto(v)}
+		case map[string]interface{}:
+			children, err := resultMapToProto(v, field+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			node.Value = &queryProto.ResultNode_Children{Children: children}
+		case AQLQueryResult:
+			children, err := resultMapToProto(v, field+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			node.Value = &queryProto.ResultNode_Children{Children: children}
+		default:
+			return nil, utils.StackError(nil, "%s.%s: unsupported value type %T", field, key, value)
+		}
+		p.Entries[key] = &node
+	}
+	return p, nil
+}
+
+// resultMapFromProto converts nested maps of aggregate query results, field is the path of the map for errors.
+func resultMapFromProto(p *queryProto.ResultMap, field string) (AQLQueryResult, error) {
+	m := make(AQLQueryResult, len(p.Entries))
+	for key, node := range p.Entries {
+		if node == nil {
+			return nil, utils.StackError(nil, "%s.%s: missing value", field, key)
+		}
+		switch v := node.Value.(type) {
+		case *queryProto.ResultNode_NullMeasure:
+			m[key] = nil
+		case *queryProto.ResultNode_Measure:
+			m[key] = v.Measure
+		case *queryProto.ResultNode_Sketch:
+			m[key] = v.Sketch
+		case *queryProto.ResultNode_Hll:
+			if v.Hll.Precision > math.MaxUint8 {
+				return nil, utils.StackError(nil, "%s.%s: hll precision %d out of range", field, key, v.Hll.Precision)
+			}
+			hll := HLL{Precision: uint8(v.Hll.Precision)}
+			hll.Decode(v.Hll.Data)
+			m[key] = hll
+		case *queryProto.ResultNode_Children:
+			children, err := resultMapFromProto(v.Children, field+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = map[string]interface{}(children)
+		default:
+			return nil, utils.StackError(nil, "%s.%s: missing value", field, key)
+		}
+	}
+	return m, nil
+}
+
+func hllToProto(hll *HLL) *queryProto.HLL {
+	return &queryProto.HLL{
+		Data:      hll.Encode(),
+		Precision: uint32(hll.Precision),
+	}
+}
+
+// nonAggResultToProto converts non aggregate query results, with headers and rows as set by SetHeaders and
+// Append, or decoded from json.
+func nonAggResultToProto(result AQLQueryResult) (*queryProto.NonAggregateResult, error) {
+	p := &queryProto.NonAggregateResult{}
+	switch headers := result[HeadersKey].(type) {
+	case []string:
+		p.Headers = headers
+	case []interface{}:
+		for i, header := range headers {
+			s, ok := header.(string)
+			if !ok {
+				return nil, utils.StackError(nil, "%s[%d]: unsupported value type %T", HeadersKey, i, header)
+			}
+			p.Headers = append(p.Headers, s)
+		}
+	case nil:
+	default:
+		return nil, utils.StackError(nil, "%s: unsupported value type %T", HeadersKey, headers)
+	}
+
+	switch cursor := result[CursorKey].(type) {
+	case string:
+		p.Cursor = cursor
+	case nil:
+	default:
+		return nil, utils.StackError(nil, "%s: unsupported value type %T", CursorKey, cursor)
+	}
+
+	var rows [][]interface{}
+	switch matrixData := result[MatrixDataKey].(type) {
+	case [][]interface{}:
+		rows = matrixData
+	case []interface{}:
+		for i, row := range matrixData {
+			values, ok := row.([]interface{})
+			if !ok {
+				return nil, utils.StackError(nil, "%s[%d]: unsupported value type %T", MatrixDataKey, i, row)
+			}
+			rows = append(rows, values)
+		}
+	case nil:
+	default:
+		return nil, utils.StackError(nil, "%s: unsupported value type %T", MatrixDataKey, matrixData)
+	}
+
+	for i, row := range rows {
+		pRow := &queryProto.Row{Values: make([]*queryProto.Value, len(row))}
+		for j, value := range row {
+			var pValue queryProto.Value
+			switch v := value.(type) {
+			case nil:
+				pValue.Kind = &queryProto.Value_NullValue{NullValue: true}
+			case float64:
+				pValue.Kind = &queryProto.Value_NumberValue{NumberValue: v}
+			case string:
+				pValue.Kind = &queryProto.Value_StringValue{StringValue: v}
+			case bool:
+				pValue.Kind = &queryProto.Value_BoolValue{BoolValue: v}
+			default:
+				return nil, utils.StackError(nil, "%s[%d][%d]: unsupported value type %T", MatrixDataKey, i, j, value)
+			}
+			pRow.Values[j] = &pValue
+		}
+		p.MatrixData = append(p.MatrixData, pRow)
+	}
+	return p, nil
+}
+
+func nonAggResultFromProto(p *queryProto.NonAggregateResult) (AQLQueryResult, error) {
+	result := AQLQueryResult{}
+	headers := p.Headers
+	if headers == nil {
+		headers = []string{}
+	}
+	result.SetHeaders(headers)
+	rows := make([][]interface{}, len(p.MatrixData))
+	for i, pRow := range p.MatrixData {
+		rows[i] = make([]interface{}, len(pRow.GetValues()))
+		for j, pValue := range pRow.GetValues() {
+			switch v := pValue.GetKind().(type) {
+			case *queryProto.Value_NullValue:
+				rows[i][j] = nil
+			case *queryProto.Value_NumberValue:
+				rows[i][j] = v.NumberValue
+			case *queryProto.Value_StringValue:
+				rows[i][j] = v.StringValue
+			case *queryProto.Value_BoolValue:
+				rows[i][j] = v.BoolValue
+			default:
+				return nil, utils.StackError(nil, "%s[%d][%d]: missing value", MatrixDataKey, i, j)
+			}
+		}
+	}
+	result[MatrixDataKey] = rows
+	if p.Cursor != "" {
+		result[CursorKey] = p.Cursor
+	}
+	return result, nil
+}
@@ -0,0 +1,187 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	queryProto "github.com/uber/aresdb/query/generated/proto"
+)
+
+var _ = ginkgo.Describe("proto conversion", func() {
+	ginkgo.It("AQLQuery should round trip through proto", func() {
+		queryJSON := `{
+			"table": "trips",
+			"shards": [0, 1],
+			"joins": [{"table": "cities", "alias": "c", "conditions": ["c.id = city_id"]}],
+			"dimensions": [
+				{"alias": "day", "sqlExpression": "request_at", "timeBucketizer": "day", "timeUnit": "second"},
+				{"sqlExpression": "fare", "numericBucketizer": {"bucketWidth": 10}},
+				{"sqlExpression": "distance", "numericBucketizer": {"manualPartitions": [1, 5, 10]}}
+			],
+			"measures": [{"alias": "trips", "sqlExpression": "count(*)", "rowFilters": ["status = 'completed'"]}],
+			"rowFilters": ["city_id = 1"],
+			"timeFilter": {"column": "request_at", "from": "-1d", "to": "now"},
+			"supportingDimensions": [{"sqlExpression": "city_id"}],
+			"supportingMeasures": [{"sqlExpression": "sum(fare)"}],
+			"timezone": "America/Los_Angeles",
+			"now": 1540000000,
+			"limit": 100,
+			"offset": 200,
+			"sorts": [{"name": "trips", "order": "desc"}],
+			"sql": "select count(*) from trips",
+			"includeDeprecatedColumns": true,
+			"hllSketch": {"precision": 12, "registerWidth": 6, "union": {"1": {"2": 3}, "4": "sketch"}}
+		}`
+		var query AQLQuery
+		Ω(json.Unmarshal([]byte(queryJSON), &query)).Should(BeNil())
+
+		p, err := AQLQueryToProto(&query)
+		Ω(err).Should(BeNil())
+		Ω(p.Dimensions[1].NumericBucketizer.BucketWidth).Should(Equal(10.0))
+		Ω(p.HllSketch.Union.Entries["4"].GetSketch()).Should(Equal("sketch"))
+
+		// through the wire format.
+		data, err := proto.Marshal(p)
+		Ω(err).Should(BeNil())
+		var decoded queryProto.AQLQuery
+		Ω(proto.Unmarshal(data, &decoded)).Should(BeNil())
+
+		converted, err := AQLQueryFromProto(&decoded)
+		Ω(err).Should(BeNil())
+		Ω(*converted).Should(Equal(query))
+
+		// minimal query.
+		query = AQLQuery{Table: "trips", Measures: []Measure{{Expr: "count(*)"}}}
+		p, err = AQLQueryToProto(&query)
+		Ω(err).Should(BeNil())
+		Ω(p.TimeFilter).Should(BeNil())
+		Ω(p.HllSketch).Should(BeNil())
+		converted, err = AQLQueryFromProto(p)
+		Ω(err).Should(BeNil())
+		Ω(*converted).Should(Equal(query))
+	})
+
+	ginkgo.It("AQLQuery conversion should report unsupported fields", func() {
+		_, err := AQLQueryFromProto(&queryProto.AQLQuery{
+			HllSketch: &queryProto.HLLSketchOption{Precision: 256},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("hllSketch.precision"))
+
+		_, err = AQLQueryFromProto(&queryProto.AQLQuery{
+			HllSketch: &queryProto.HLLSketchOption{RegisterWidth: 256},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("hllSketch.registerWidth"))
+
+		_, err = AQLQueryFromProto(&queryProto.AQLQuery{
+			HllSketch: &queryProto.HLLSketchOption{Union: &queryProto.ResultMap{
+				Entries: map[string]*queryProto.ResultNode{"1": {}},
+			}},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("hllSketch.union.1"))
+
+		_, err = AQLQueryToProto(&AQLQuery{HLLSketch: &HLLSketchOption{
+			Union: AQLQueryResult{"1": []int{1}},
+		}})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("hllSketch.union.1"))
+	})
+
+	ginkgo.It("aggregate results should round trip through proto", func() {
+		var result AQLQueryResult
+		Ω(json.Unmarshal([]byte(`{"1": {"2": 3, "NULL": null}, "4": "sketch"}`), &result)).Should(BeNil())
+		result["5"] = map[string]interface{}{
+			"6": HLL{SparseData: []HLLRegister{{Index: 1, Rho: 2}}, NonZeroRegisters: 1},
+			"7": HLL{DenseData: make([]byte, 1<<8), Precision: 8},
+		}
+
+		p, err := AQLQueryResultToProto(result)
+		Ω(err).Should(BeNil())
+		Ω(p.GetAggregate()).ShouldNot(BeNil())
+
+		data, err := proto.Marshal(p)
+		Ω(err).Should(BeNil())
+		var decoded queryProto.QueryResult
+		Ω(proto.Unmarshal(data, &decoded)).Should(BeNil())
+
+		converted, err := AQLQueryResultFromProto(&decoded)
+		Ω(err).Should(BeNil())
+		Ω(converted).Should(Equal(result))
+	})
+
+	ginkgo.It("non aggregate results should round trip through proto", func() {
+		var result AQLQueryResult
+		Ω(json.Unmarshal([]byte(`{"headers": ["a", "b", "c"], "matrixData": [[1, "x", null], [2, "y", true]], "cursor": "next"}`), &result)).Should(BeNil())
+
+		p, err := AQLQueryResultToProto(result)
+		Ω(err).Should(BeNil())
+		Ω(p.GetNonAggregate().Headers).Should(Equal([]string{"a", "b", "c"}))
+		Ω(p.GetNonAggregate().Cursor).Should(Equal("next"))
+
+		converted, err := AQLQueryResultFromProto(p)
+		Ω(err).Should(BeNil())
+		Ω(converted).Should(Equal(AQLQueryResult{
+			HeadersKey: []string{"a", "b", "c"},
+			MatrixDataKey: [][]interface{}{
+				{1.0, "x", nil},
+				{2.0, "y", true},
+			},
+			CursorKey: "next",
+		}))
+
+		// results built by SetHeaders and Append.
+		result = AQLQueryResult{}
+		result.SetHeaders([]string{"a", "b"})
+		x := "x"
+		result.Append([]*string{&x, nil})
+		p, err = AQLQueryResultToProto(result)
+		Ω(err).Should(BeNil())
+		converted, err = AQLQueryResultFromProto(p)
+		Ω(err).Should(BeNil())
+		Ω(converted).Should(Equal(result))
+	})
+
+	ginkgo.It("result conversion should report unsupported values", func() {
+		_, err := AQLQueryResultToProto(AQLQueryResult{"1": map[string]interface{}{"2": 1}})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("result.1.2"))
+
+		_, err = AQLQueryResultToProto(AQLQueryResult{
+			HeadersKey:    []string{"a"},
+			MatrixDataKey: [][]interface{}{{[]int{}}},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("matrixData[0][0]"))
+
+		converted, err := AQLQueryResultFromProto(&queryProto.QueryResult{})
+		Ω(err).Should(BeNil())
+		Ω(converted).Should(BeEmpty())
+
+		_, err = AQLQueryResultFromProto(&queryProto.QueryResult{Result: &queryProto.QueryResult_NonAggregate{
+			NonAggregate: &queryProto.NonAggregateResult{
+				Headers:    []string{"a"},
+				MatrixData: []*queryProto.Row{{Values: []*queryProto.Value{{}}}},
+			},
+		}})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("matrixData[0][0]"))
+	})
+})
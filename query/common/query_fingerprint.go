@@ -0,0 +1,202 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/query/expr"
+)
+
+// literalPlaceholder replaces literal values in normalized queries.
+const literalPlaceholder = "?"
+
+// normalizedQuery is the shape of a query with literal values stripped, filters sorted and whitespaces
+// canonicalized. Dimensions, measures and sorts keep their orders since they shape the results.
+type normalizedQuery struct {
+	Table                string              `json:"table"`
+	Joins                []normalizedJoin    `json:"joins,omitempty"`
+	Dimensions           []Dimension         `json:"dimensions,omitempty"`
+	Measures             []normalizedMeasure `json:"measures,omitempty"`
+	Filters              []string            `json:"filters,omitempty"`
+	TimeFilter           TimeFilter          `json:"timeFilter"`
+	SupportingDimensions []Dimension         `json:"supportingDimensions,omitempty"`
+	SupportingMeasures   []normalizedMeasure `json:"supportingMeasures,omitempty"`
+	Timezone             string              `json:"timezone,omitempty"`
+	Limit                string              `json:"limit,omitempty"`
+	Sorts                []SortField         `json:"sorts,omitempty"`
+	HLLSketch            bool                `json:"hllSketch,omitempty"`
+}
+
+type normalizedJoin struct {
+	Table      string   `json:"table"`
+	Alias      string   `json:"alias"`
+	Conditions []string `json:"conditions"`
+}
+
+type normalizedMeasure struct {
+	Alias   string   `json:"alias,omitempty"`
+	Expr    string   `json:"expr"`
+	Filters []string `json:"filters,omitempty"`
+}
+
+// NormalizeQuery returns the normalized form of the query identifying its shape: literal values are replaced
+// by placeholders, filters are sorted and whitespaces are canonicalized, so that queries differing only by
+// filter values or formatting share the same normalized form. Pagination, shards, now and the caller are not
+// part of the shape. It is shared by anything keying on query shapes, e.g. query stats, so that they agree
+// on query identities.
+func NormalizeQuery(aql *AQLQuery) string {
+	return normalizeQuery(aql, false)
+}
+
+// NormalizeQueryWithLiterals returns the normalized form of the query as NormalizeQuery does but with literal
+// values kept, so that it identifies results of the query rather than its shape, e.g. for result caches.
+func NormalizeQueryWithLiterals(aql *AQLQuery) string {
+	return normalizeQuery(aql, true)
+}
+
+func normalizeQuery(aql *AQLQuery, keepLiterals bool) string {
+	normalized := normalizedQuery{
+		Table:                aql.Table,
+		Dimensions:           normalizeDimensions(aql.Dimensions, keepLiterals),
+		Measures:             normalizeMeasures(aql.Measures, keepLiterals),
+		Filters:              normalizeFilters(aql.Filters, keepLiterals),
+		TimeFilter:           TimeFilter{Column: aql.TimeFilter.Column},
+		SupportingDimensions: normalizeDimensions(aql.SupportingDimensions, keepLiterals),
+		SupportingMeasures:   normalizeMeasures(aql.SupportingMeasures, keepLiterals),
+		Sorts:                aql.Sorts,
+		HLLSketch:            aql.HLLSketch != nil,
+	}
+	for _, join := range aql.Joins {
+		normalized.Joins = append(normalized.Joins, normalizedJoin{
+			Table:      join.Table,
+			Alias:      join.Alias,
+			Conditions: normalizeFilters(join.Conditions, keepLiterals),
+		})
+	}
+	normalized.TimeFilter.From = normalizeLiteral(strings.TrimSpace(aql.TimeFilter.From), keepLiterals)
+	normalized.TimeFilter.To = normalizeLiteral(strings.TrimSpace(aql.TimeFilter.To), keepLiterals)
+	// timezones by columns (e.g. timezone(city_id)) are part of the shape, fixed timezones are literals.
+	if _, err := ParseTimezone(aql.Timezone); err == nil && aql.Timezone != "" {
+		normalized.Timezone = normalizeLiteral(aql.Timezone, keepLiterals)
+	} else {
+		normalized.Timezone = normalizeExpr(aql.Timezone, keepLiterals)
+	}
+	if aql.Limit != 0 {
+		normalized.Limit = normalizeLiteral(strconv.Itoa(aql.Limit), keepLiterals)
+	}
+
+	// fields are marshalled in declaration order and there are no maps, so the json is stable.
+	bs, _ := json.Marshal(normalized)
+	return string(bs)
+}
+
+// QueryFingerprint returns the fingerprint of the shape of the query, as the hash of NormalizeQuery.
+func QueryFingerprint(aql *AQLQuery) string {
+	return FingerprintNormalizedQuery(NormalizeQuery(aql))
+}
+
+// FingerprintNormalizedQuery returns the fingerprint of the query normalized by NormalizeQuery, for callers
+// needing both the normalized query and its fingerprint.
+func FingerprintNormalizedQuery(normalized string) string {
+	sum := sha1.Sum([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// normalizeLiteral returns the placeholder of the literal value unless literals are kept.
+func normalizeLiteral(value string, keepLiterals bool) string {
+	if value == "" || keepLiterals {
+		return value
+	}
+	return literalPlaceholder
+}
+
+func normalizeDimensions(dimensions []Dimension, keepLiterals bool) []Dimension {
+	if len(dimensions) == 0 {
+		return nil
+	}
+	normalized := make([]Dimension, len(dimensions))
+	for i, dim := range dimensions {
+		normalized[i] = Dimension{
+			Alias:             dim.Alias,
+			Expr:              normalizeExpr(dim.Expr, keepLiterals),
+			TimeBucketizer:    dim.TimeBucketizer,
+			TimeUnit:          dim.TimeUnit,
+			NumericBucketizer: dim.NumericBucketizer,
+		}
+	}
+	return normalized
+}
+
+func normalizeMeasures(measures []Measure, keepLiterals bool) []normalizedMeasure {
+	if len(measures) == 0 {
+		return nil
+	}
+	normalized := make([]normalizedMeasure, len(measures))
+	for i, measure := range measures {
+		normalized[i] = normalizedMeasure{
+			Alias:   measure.Alias,
+			Expr:    normalizeExpr(measure.Expr, keepLiterals),
+			Filters: normalizeFilters(measure.Filters, keepLiterals),
+		}
+	}
+	return normalized
+}
+
+// normalizeFilters normalizes the filters ANDed together, which are sorted since their order does not matter.
+func normalizeFilters(filters []string, keepLiterals bool) []string {
+	if len(filters) == 0 {
+		return nil
+	}
+	normalized := make([]string, len(filters))
+	for i, filter := range filters {
+		normalized[i] = normalizeExpr(filter, keepLiterals)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// normalizeExpr replaces literals of the expression by placeholders unless literals are kept, lists of IN
+// and NOT IN are replaced by a single placeholder regardless of their lengths. Expressions failing to parse
+// only get their whitespaces canonicalized.
+func normalizeExpr(s string, keepLiterals bool) string {
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	e, err := expr.ParseExpr(s)
+	if err != nil {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	if keepLiterals {
+		return e.String()
+	}
+	e = expr.RewriteFunc(e, func(e expr.Expr) expr.Expr {
+		switch e := e.(type) {
+		case *expr.NumberLiteral, *expr.StringLiteral, *expr.BooleanLiteral, *expr.GeopointLiteral:
+			return &expr.NumberLiteral{Expr: literalPlaceholder}
+		case *expr.BinaryExpr:
+			if list, ok := e.RHS.(*expr.Call); ok && list.Name == "" && (e.Op == expr.IN || e.Op == expr.NOT_IN) {
+				e.RHS = &expr.Call{Args: []expr.Expr{&expr.NumberLiteral{Expr: literalPlaceholder}}}
+			}
+		}
+		return e
+	})
+	return e.String()
+}
@@ -0,0 +1,94 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("query fingerprint", func() {
+	ginkgo.It("normalizeExpr should strip literals and canonicalize whitespaces", func() {
+		Ω(normalizeExpr("city_id  =  1", false)).Should(Equal("city_id = ?"))
+		Ω(normalizeExpr("status IN ('completed', 'canceled', 'failed')", false)).Should(Equal("status IN (?)"))
+		Ω(normalizeExpr("status NOT IN ('completed')", false)).Should(Equal("status NOT IN (?)"))
+		Ω(normalizeExpr("fare > 10.5 AND is_first = true", false)).Should(Equal("fare > ? AND is_first = ?"))
+		Ω(normalizeExpr("count(*)", false)).Should(Equal("count(*)"))
+		Ω(normalizeExpr("", false)).Should(Equal(""))
+		// unparsable expressions keep their literals.
+		Ω(normalizeExpr("city_id  = = 1", false)).Should(Equal("city_id = = 1"))
+		Ω(normalizeExpr("status IN ('completed',  'canceled')", true)).Should(Equal("status IN ('completed', 'canceled')"))
+	})
+
+	ginkgo.It("QueryFingerprint should identify query shapes", func() {
+		query := func(cityID, from, filter string) *AQLQuery {
+			return &AQLQuery{
+				Table:      "trips",
+				Dimensions: []Dimension{{Expr: "request_at", TimeBucketizer: "day"}},
+				Measures:   []Measure{{Expr: "count(*)"}},
+				Filters:    []string{"city_id = " + cityID, filter},
+				TimeFilter: TimeFilter{Column: "request_at", From: from, To: "now"},
+				Timezone:   "America/Los_Angeles",
+			}
+		}
+
+		q1 := query("1", "-1d", "status = 'completed'")
+		q2 := query("2", "-7d", "status='canceled'")
+		q2.Filters[0], q2.Filters[1] = q2.Filters[1], q2.Filters[0]
+		q2.Timezone = "GMT"
+		q2.Now = 1570000000
+		q2.PageSize = 100
+		q2.Caller = "test"
+		Ω(QueryFingerprint(q1)).Should(Equal(QueryFingerprint(q2)))
+		Ω(NormalizeQuery(q1)).Should(Equal(NormalizeQuery(q2)))
+		Ω(NormalizeQuery(q1)).Should(ContainSubstring(`"filters":["city_id = ?","status = ?"]`))
+
+		for _, q := range []*AQLQuery{
+			query("1", "-1d", "status != 'completed'"),
+			query("1", "", "status = 'completed'"),
+			{Table: "trips", Measures: []Measure{{Expr: "count(*)"}}},
+		} {
+			Ω(QueryFingerprint(q)).ShouldNot(Equal(QueryFingerprint(q1)))
+		}
+
+		q3 := query("1", "-1d", "status = 'completed'")
+		q3.Timezone = "timezone(city_id)"
+		Ω(QueryFingerprint(q3)).ShouldNot(Equal(QueryFingerprint(q1)))
+		q3.Dimensions[0].TimeBucketizer = "hour"
+		Ω(QueryFingerprint(q3)).ShouldNot(Equal(QueryFingerprint(q1)))
+	})
+
+	ginkgo.It("NormalizeQueryWithLiterals should identify query results", func() {
+		query := func(filters ...string) *AQLQuery {
+			return &AQLQuery{
+				Table:      "trips",
+				Measures:   []Measure{{Expr: "count(*)"}},
+				Filters:    filters,
+				TimeFilter: TimeFilter{Column: "request_at", From: "-1d", To: "now"},
+				Limit:      10,
+			}
+		}
+		q1 := query("city_id = 1", "status = 'completed'")
+		Ω(NormalizeQueryWithLiterals(q1)).Should(Equal(NormalizeQueryWithLiterals(query("status='completed'", " city_id  =  1"))))
+		Ω(NormalizeQueryWithLiterals(q1)).Should(ContainSubstring(`"filters":["city_id = 1","status = 'completed'"]`))
+		Ω(NormalizeQueryWithLiterals(q1)).ShouldNot(Equal(NormalizeQueryWithLiterals(query("city_id = 2", "status = 'completed'"))))
+		q2 := query("city_id = 1", "status = 'completed'")
+		q2.Limit = 20
+		Ω(NormalizeQueryWithLiterals(q1)).ShouldNot(Equal(NormalizeQueryWithLiterals(q2)))
+		q2 = query("city_id = 1", "status = 'completed'")
+		q2.TimeFilter.From = "-7d"
+		Ω(NormalizeQueryWithLiterals(q1)).ShouldNot(Equal(NormalizeQueryWithLiterals(q2)))
+	})
+})
@@ -0,0 +1,308 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/uber/aresdb/utils"
+)
+
+// Phases of running queries.
+const (
+	QueryPhaseCompiling = "compiling"
+	// broker phases.
+	QueryPhaseQueued             = "queued"
+	QueryPhaseWaitingOnDataNodes = "waitingOnDatanodes"
+	QueryPhaseMerging            = "merging"
+	QueryPhaseStreaming          = "streaming"
+	// datanode phases.
+	QueryPhaseWaitingForDevice = "waitingForDevice"
+	QueryPhaseProcessing       = "processing"
+
+	QueryPhaseFinished = "finished"
+)
+
+// number of query ids generated without random uuids.
+var fallbackQueryIDs uint64
+
+// DefaultQueryHistorySize is the default number of recently finished queries kept by QueryRegistry.
+const DefaultQueryHistorySize = 100
+
+type runningQueryKey struct{}
+
+// RunningQuery tracks the progress of a query while it's executing. Progress is updated with atomics so
+// that it's cheap to update in the query path and to snapshot for listing. All methods are no-op on nil.
+type RunningQuery struct {
+	id        string
+	query     string
+	caller    string
+	startTime time.Time
+
+	phase atomic.Value
+	// rows and bytes returned so far, and memory charged to the query.
+	rows   int64
+	bytes  int64
+	memory int64
+	// number of outstanding requests by host id, values are *int64.
+	hostRequests sync.Map
+	// context.CancelFunc canceling the context of the query, not set if the query is not cancelable.
+	cancel   atomic.Value
+	canceled int32
+}
+
+// RunningQuerySnapshot is the progress of a running or finished query at a point of time.
+type RunningQuerySnapshot struct {
+	ID        string    `json:"id"`
+	Query     string    `json:"query"`
+	Caller    string    `json:"caller"`
+	StartTime time.Time `json:"startTime"`
+	// ElapsedMillis is the time the query has been running, or ran if finished.
+	ElapsedMillis int64  `json:"elapsedMillis"`
+	Phase         string `json:"phase"`
+	// OutstandingRequests is the number of requests waiting on each host.
+	OutstandingRequests map[string]int64 `json:"outstandingRequests,omitempty"`
+	Rows                int64            `json:"rows"`
+	Bytes               int64            `json:"bytes"`
+	MemoryBytes         int64            `json:"memoryBytes"`
+	Error               string           `json:"error,omitempty"`
+}
+
+// RunningQueries lists running queries and recently finished queries.
+type RunningQueries struct {
+	Running  []RunningQuerySnapshot `json:"running"`
+	Finished []RunningQuerySnapshot `json:"finished"`
+}
+
+// WithRunningQuery returns a context carrying the running query to update its progress.
+func WithRunningQuery(ctx context.Context, query *RunningQuery) context.Context {
+	return context.WithValue(ctx, runningQueryKey{}, query)
+}
+
+// GetRunningQuery returns the running query carried by the context, nil if there is none.
+func GetRunningQuery(ctx context.Context) *RunningQuery {
+	query, _ := ctx.Value(runningQueryKey{}).(*RunningQuery)
+	return query
+}
+
+// ID returns the id of the query.
+func (q *RunningQuery) ID() string {
+	if q == nil {
+		return ""
+	}
+	return q.id
+}
+
+// SetPhase sets the current phase of the query.
+func (q *RunningQuery) SetPhase(phase string) {
+	if q != nil {
+		q.phase.Store(phase)
+	}
+}
+
+// AddRows adds rows returned by the query.
+func (q *RunningQuery) AddRows(rows int) {
+	if q != nil {
+		atomic.AddInt64(&q.rows, int64(rows))
+	}
+}
+
+// AddBytes adds bytes returned by the query.
+func (q *RunningQuery) AddBytes(bytes int) {
+	if q != nil {
+		atomic.AddInt64(&q.bytes, int64(bytes))
+	}
+}
+
+// SetMemory sets the memory charged to the query.
+func (q *RunningQuery) SetMemory(bytes int) {
+	if q != nil {
+		atomic.StoreInt64(&q.memory, int64(bytes))
+	}
+}
+
+// StartHostRequest records a request sent to the host.
+func (q *RunningQuery) StartHostRequest(host string) {
+	if q != nil {
+		outstanding, _ := q.hostRequests.LoadOrStore(host, new(int64))
+		atomic.AddInt64(outstanding.(*int64), 1)
+	}
+}
+
+// EndHostRequest records a request to the host is done.
+func (q *RunningQuery) EndHostRequest(host string) {
+	if q != nil {
+		if outstanding, ok := q.hostRequests.Load(host); ok {
+			atomic.AddInt64(outstanding.(*int64), -1)
+		}
+	}
+}
+
+// SetCancel sets the function canceling the context of the query, which makes the query cancelable by
+// QueryRegistry.Cancel.
+func (q *RunningQuery) SetCancel(cancel context.CancelFunc) {
+	if q != nil {
+		q.cancel.Store(cancel)
+	}
+}
+
+// Canceled tells whether the query was canceled by QueryRegistry.Cancel.
+func (q *RunningQuery) Canceled() bool {
+	return q != nil && atomic.LoadInt32(&q.canceled) == 1
+}
+
+// Snapshot returns the current progress of the query.
+func (q *RunningQuery) Snapshot() RunningQuerySnapshot {
+	snapshot := RunningQuerySnapshot{
+		ID:            q.id,
+		Query:         q.query,
+		Caller:        q.caller,
+		StartTime:     q.startTime,
+		ElapsedMillis: utils.Now().Sub(q.startTime).Nanoseconds() / int64(time.Millisecond),
+		Rows:          atomic.LoadInt64(&q.rows),
+		Bytes:         atomic.LoadInt64(&q.bytes),
+		MemoryBytes:   atomic.LoadInt64(&q.memory),
+	}
+	snapshot.Phase, _ = q.phase.Load().(string)
+	q.hostRequests.Range(func(host, outstanding interface{}) bool {
+		if n := atomic.LoadInt64(outstanding.(*int64)); n > 0 {
+			if snapshot.OutstandingRequests == nil {
+				snapshot.OutstandingRequests = make(map[string]int64)
+			}
+			snapshot.OutstandingRequests[host.(string)] = n
+		}
+		return true
+	})
+	return snapshot
+}
+
+// QueryRegistry tracks queries running on the server, and keeps the recently finished queries
+// in a ring buffer.
+type QueryRegistry struct {
+	// running queries by id.
+	running sync.Map
+
+	sync.Mutex
+	// ring buffer of recently finished queries, next is the position to write the next one.
+	finished []RunningQuerySnapshot
+	next     int
+	full     bool
+}
+
+// NewQueryRegistry creates a QueryRegistry keeping historySize recently finished queries.
+func NewQueryRegistry(historySize int) *QueryRegistry {
+	if historySize <= 0 {
+		historySize = DefaultQueryHistorySize
+	}
+	return &QueryRegistry{
+		finished: make([]RunningQuerySnapshot, historySize),
+	}
+}
+
+// Register registers the query as running with the caller under a random uuid, the query must be finished by
+// Finish.
+func (r *QueryRegistry) Register(aql *AQLQuery, caller string) *RunningQuery {
+	var query string
+	if bs, err := json.Marshal(aql); err == nil {
+		query = string(bs)
+	}
+	q := &RunningQuery{
+		id:        newQueryID(),
+		query:     query,
+		caller:    caller,
+		startTime: utils.Now(),
+	}
+	q.SetPhase(QueryPhaseCompiling)
+	r.running.Store(q.id, q)
+	return q
+}
+
+// Finish removes the query from running queries and keeps it as recently finished.
+func (r *QueryRegistry) Finish(q *RunningQuery, err error) {
+	r.running.Delete(q.id)
+	q.SetPhase(QueryPhaseFinished)
+	snapshot := q.Snapshot()
+	if err != nil {
+		snapshot.Error = err.Error()
+	}
+
+	r.Lock()
+	r.finished[r.next] = snapshot
+	r.next = (r.next + 1) % len(r.finished)
+	r.full = r.full || r.next == 0
+	r.Unlock()
+}
+
+// Cancel cancels the running query of the id, nil if the query is not running or not cancelable.
+func (r *QueryRegistry) Cancel(id string) *RunningQuery {
+	value, ok := r.running.Load(id)
+	if !ok {
+		return nil
+	}
+	q := value.(*RunningQuery)
+	cancel, _ := q.cancel.Load().(context.CancelFunc)
+	if cancel == nil {
+		return nil
+	}
+	atomic.StoreInt32(&q.canceled, 1)
+	cancel()
+	return q
+}
+
+// List returns running queries sorted by elapsed time, longest first unless ascending, and recently
+// finished queries with the latest first.
+func (r *QueryRegistry) List(ascending bool) RunningQueries {
+	queries := RunningQueries{
+		Running:  []RunningQuerySnapshot{},
+		Finished: []RunningQuerySnapshot{},
+	}
+	r.running.Range(func(_, q interface{}) bool {
+		queries.Running = append(queries.Running, q.(*RunningQuery).Snapshot())
+		return true
+	})
+	sort.Slice(queries.Running, func(i, j int) bool {
+		if ascending {
+			return queries.Running[i].StartTime.After(queries.Running[j].StartTime)
+		}
+		return queries.Running[i].StartTime.Before(queries.Running[j].StartTime)
+	})
+
+	r.Lock()
+	numFinished := r.next
+	if r.full {
+		numFinished = len(r.finished)
+	}
+	for i := 1; i <= numFinished; i++ {
+		queries.Finished = append(queries.Finished, r.finished[(r.next-i+len(r.finished))%len(r.finished)])
+	}
+	r.Unlock()
+	return queries
+}
+
+// newQueryID returns a random uuid, or a unique id by time if random uuids fail.
+func newQueryID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Sprintf("%x-%x", utils.Now().UnixNano(), atomic.AddUint64(&fallbackQueryIDs, 1))
+	}
+	return id.String()
+}
@@ -0,0 +1,119 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("running queries", func() {
+	now := time.Unix(1570000000, 0)
+
+	ginkgo.BeforeEach(func() {
+		utils.SetClockImplementation(func() time.Time {
+			return now
+		})
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("RunningQuery should track progress", func() {
+		registry := NewQueryRegistry(2)
+		q := registry.Register(&AQLQuery{Table: "trips", Caller: "caller1"}, "caller1")
+		Ω(q.ID()).ShouldNot(BeEmpty())
+
+		ctx := WithRunningQuery(context.Background(), q)
+		Ω(GetRunningQuery(ctx)).Should(Equal(q))
+		Ω(GetRunningQuery(context.Background())).Should(BeNil())
+
+		q.SetPhase(QueryPhaseWaitingOnDataNodes)
+		q.StartHostRequest("host1")
+		q.StartHostRequest("host1")
+		q.StartHostRequest("host2")
+		q.EndHostRequest("host2")
+		q.AddRows(10)
+		q.AddRows(5)
+		q.AddBytes(100)
+		q.SetMemory(1024)
+
+		now = now.Add(time.Second)
+		snapshot := q.Snapshot()
+		Ω(snapshot.ID).Should(Equal(q.ID()))
+		Ω(snapshot.Query).Should(ContainSubstring(`"table":"trips"`))
+		Ω(snapshot.Query).ShouldNot(ContainSubstring("caller1"))
+		Ω(snapshot.Caller).Should(Equal("caller1"))
+		Ω(snapshot.ElapsedMillis).Should(Equal(int64(1000)))
+		Ω(snapshot.Phase).Should(Equal(QueryPhaseWaitingOnDataNodes))
+		Ω(snapshot.OutstandingRequests).Should(Equal(map[string]int64{"host1": 2}))
+		Ω(snapshot.Rows).Should(Equal(int64(15)))
+		Ω(snapshot.Bytes).Should(Equal(int64(100)))
+		Ω(snapshot.MemoryBytes).Should(Equal(int64(1024)))
+
+		// methods are no-op on nil.
+		var nilQuery *RunningQuery
+		nilQuery.SetPhase(QueryPhaseMerging)
+		nilQuery.AddRows(1)
+		nilQuery.StartHostRequest("host1")
+		Ω(nilQuery.ID()).Should(BeEmpty())
+	})
+
+	ginkgo.It("QueryRegistry should list running and finished queries", func() {
+		registry := NewQueryRegistry(2)
+		Ω(registry.List(false)).Should(Equal(RunningQueries{
+			Running:  []RunningQuerySnapshot{},
+			Finished: []RunningQuerySnapshot{},
+		}))
+
+		var queries []*RunningQuery
+		for i := 0; i < 4; i++ {
+			queries = append(queries, registry.Register(&AQLQuery{}, "caller"))
+			now = now.Add(time.Second)
+		}
+
+		listing := registry.List(false)
+		Ω(listing.Running).Should(HaveLen(4))
+		Ω(listing.Running[0].ID).Should(Equal(queries[0].ID()))
+		Ω(listing.Running[0].ElapsedMillis).Should(Equal(int64(4000)))
+		Ω(listing.Running[3].ID).Should(Equal(queries[3].ID()))
+		listing = registry.List(true)
+		Ω(listing.Running[0].ID).Should(Equal(queries[3].ID()))
+
+		registry.Finish(queries[1], nil)
+		listing = registry.List(false)
+		Ω(listing.Running).Should(HaveLen(3))
+		Ω(listing.Finished).Should(HaveLen(1))
+		Ω(listing.Finished[0].ID).Should(Equal(queries[1].ID()))
+		Ω(listing.Finished[0].Phase).Should(Equal(QueryPhaseFinished))
+
+		registry.Finish(queries[0], errors.New("failed"))
+		registry.Finish(queries[3], nil)
+		listing = registry.List(false)
+		Ω(listing.Running).Should(HaveLen(1))
+		Ω(listing.Running[0].ID).Should(Equal(queries[2].ID()))
+		// only the latest 2 finished queries are kept.
+		Ω(listing.Finished).Should(HaveLen(2))
+		Ω(listing.Finished[0].ID).Should(Equal(queries[3].ID()))
+		Ω(listing.Finished[1].ID).Should(Equal(queries[0].ID()))
+		Ω(listing.Finished[1].Error).Should(Equal("failed"))
+	})
+})
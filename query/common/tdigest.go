@@ -0,0 +1,277 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// Serialized format of t-digests, little endian:
+//
+//	[1 byte] version (1)
+//	[8 bytes] compression, float64
+//	[8 bytes] min value, float64
+//	[8 bytes] max value, float64
+//	[4 bytes] number of centroids, uint32
+//	<centroids>, sorted by mean
+//	[8 bytes] mean, float64 [8 bytes] weight, float64
+//
+// Digests are base64 encoded as leaves of json results of percentile partials returned by datanodes.
+const (
+	// TDigestVersion is the version of the serialized format.
+	TDigestVersion = 1
+	// DefaultTDigestCompression is the compression of digests built by datanodes, digests keep about
+	// compression / 2 centroids, with rank errors of quantiles within about 1 / compression.
+	DefaultTDigestCompression = 100
+
+	tdigestHeaderSize   = 29
+	tdigestCentroidSize = 16
+)
+
+// tdigestCentroid is a cluster of values of the digest.
+type tdigestCentroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest is the merging t-digest of Dunning for approximate quantiles, digests of disjoint sets of values
+// are merged into the digest of the union of the sets.
+type TDigest struct {
+	compression float64
+	// compressed centroids sorted by mean.
+	centroids []tdigestCentroid
+	// centroids added or merged since the last compression.
+	buffer   []tdigestCentroid
+	count    float64
+	min, max float64
+}
+
+// NewTDigest creates an empty digest of the compression.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Count returns the total weight of values added to the digest.
+func (d *TDigest) Count() float64 {
+	return d.count
+}
+
+// Add adds the value of the weight to the digest.
+func (d *TDigest) Add(value, weight float64) {
+	if weight <= 0 || math.IsNaN(value) {
+		return
+	}
+	d.add(tdigestCentroid{mean: value, weight: weight}, value, value)
+}
+
+func (d *TDigest) add(c tdigestCentroid, min, max float64) {
+	d.buffer = append(d.buffer, c)
+	d.count += c.weight
+	d.min = math.Min(d.min, min)
+	d.max = math.Max(d.max, max)
+	if len(d.buffer) > 5*int(d.compression) {
+		d.compress()
+	}
+}
+
+// Merge merges centroids of the other digest into the digest, the compression of the digest is kept.
+func (d *TDigest) Merge(other *TDigest) {
+	other.compress()
+	for _, c := range other.centroids {
+		d.add(c, other.min, other.max)
+	}
+}
+
+// scale is the k1 scale function of the compression, centroids are limited to one unit of the scale, which
+// keeps centroids near the tails small.
+func (d *TDigest) scale(q float64) float64 {
+	return d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// scaleInverse returns the quantile of the scale.
+func (d *TDigest) scaleInverse(k float64) float64 {
+	if k >= d.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/d.compression) + 1) / 2
+}
+
+// compress merges buffered centroids with compressed centroids, neighbouring centroids are merged while the
+// merged centroid stays within one unit of the scale.
+func (d *TDigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := append(d.centroids, d.buffer...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+
+	compressed := make([]tdigestCentroid, 0, len(d.centroids)+1)
+	current := all[0]
+	weightSoFar := 0.0
+	limit := d.count * d.scaleInverse(d.scale(0)+1)
+	for _, c := range all[1:] {
+		if weightSoFar+current.weight+c.weight <= limit {
+			current.weight += c.weight
+			current.mean += (c.mean - current.mean) * c.weight / current.weight
+			continue
+		}
+		compressed = append(compressed, current)
+		weightSoFar += current.weight
+		limit = d.count * d.scaleInverse(d.scale(weightSoFar/d.count)+1)
+		current = c
+	}
+	d.centroids = append(compressed, current)
+	d.buffer = d.buffer[:0]
+}
+
+// Quantile returns the approximate value of the quantile in [0, 1], NaN for empty digests. Values are
+// interpolated between centers of centroids, and between the min and max values and the first and last
+// centroids.
+func (d *TDigest) Quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return d.min
+	}
+	if q >= 1 {
+		return d.max
+	}
+
+	index := q * d.count
+	first := d.centroids[0]
+	if index < first.weight/2 {
+		return d.min + index/(first.weight/2)*(first.mean-d.min)
+	}
+	weightSoFar := first.weight / 2
+	for i := 0; i < len(d.centroids)-1; i++ {
+		left, right := d.centroids[i], d.centroids[i+1]
+		delta := (left.weight + right.weight) / 2
+		if weightSoFar+delta > index {
+			return left.mean + (index-weightSoFar)/delta*(right.mean-left.mean)
+		}
+		weightSoFar += delta
+	}
+	last := d.centroids[len(d.centroids)-1]
+	return last.mean + math.Min((index-weightSoFar)/(last.weight/2), 1)*(d.max-last.mean)
+}
+
+// Encode serializes the compressed digest.
+func (d *TDigest) Encode() []byte {
+	d.compress()
+	data := make([]byte, tdigestHeaderSize+len(d.centroids)*tdigestCentroidSize)
+	data[0] = TDigestVersion
+	binary.LittleEndian.PutUint64(data[1:], math.Float64bits(d.compression))
+	binary.LittleEndian.PutUint64(data[9:], math.Float64bits(d.min))
+	binary.LittleEndian.PutUint64(data[17:], math.Float64bits(d.max))
+	binary.LittleEndian.PutUint32(data[25:], uint32(len(d.centroids)))
+	offset := tdigestHeaderSize
+	for _, c := range d.centroids {
+		binary.LittleEndian.PutUint64(data[offset:], math.Float64bits(c.mean))
+		binary.LittleEndian.PutUint64(data[offset+8:], math.Float64bits(c.weight))
+		offset += tdigestCentroidSize
+	}
+	return data
+}
+
+// DecodeTDigest deserializes the digest encoded by Encode.
+func DecodeTDigest(data []byte) (*TDigest, error) {
+	if len(data) < tdigestHeaderSize {
+		return nil, utils.StackError(nil, "invalid t-digest of %d bytes", len(data))
+	}
+	if data[0] != TDigestVersion {
+		return nil, utils.StackError(nil, "t-digest version %d not supported", data[0])
+	}
+	d := NewTDigest(math.Float64frombits(binary.LittleEndian.Uint64(data[1:])))
+	if d.compression < 1 || math.IsNaN(d.compression) {
+		return nil, utils.StackError(nil, "invalid t-digest compression %v", d.compression)
+	}
+	numCentroids := int(binary.LittleEndian.Uint32(data[25:]))
+	if expected := tdigestHeaderSize + numCentroids*tdigestCentroidSize; len(data) != expected {
+		return nil, utils.StackError(nil, "invalid t-digest of %d bytes, expected %d bytes", len(data), expected)
+	}
+	if numCentroids == 0 {
+		return d, nil
+	}
+
+	d.min = math.Float64frombits(binary.LittleEndian.Uint64(data[9:]))
+	d.max = math.Float64frombits(binary.LittleEndian.Uint64(data[17:]))
+	d.centroids = make([]tdigestCentroid, numCentroids)
+	offset := tdigestHeaderSize
+	for i := range d.centroids {
+		c := tdigestCentroid{
+			mean:   math.Float64frombits(binary.LittleEndian.Uint64(data[offset:])),
+			weight: math.Float64frombits(binary.LittleEndian.Uint64(data[offset+8:])),
+		}
+		if c.weight <= 0 || math.IsNaN(c.mean) || (i > 0 && c.mean < d.centroids[i-1].mean) {
+			return nil, utils.StackError(nil, "invalid t-digest centroid %d of mean %v and weight %v", i, c.mean, c.weight)
+		}
+		d.centroids[i] = c
+		d.count += c.weight
+		offset += tdigestCentroidSize
+	}
+	return d, nil
+}
+
+// MarshalJSON marshals the digest as the base64 encoded string of the serialized digest.
+func (d *TDigest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.StdEncoding.EncodeToString(d.Encode()))
+}
+
+// ImportTDigestResult replaces base64 encoded digests of the result (e.g. unmarshalled from json) with
+// digests in place, so that they can be merged. Nulls are kept for buckets without values.
+func ImportTDigestResult(result AQLQueryResult) (AQLQueryResult, error) {
+	if _, err := importTDigestResultRecursive(map[string]interface{}(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// importTDigestResultRecursive imports digests in place
+func importTDigestResultRecursive(result interface{}) (interface{}, error) {
+	var err error
+	switch r := result.(type) {
+	case map[string]interface{}:
+		for k, v := range r {
+			if r[k], err = importTDigestResultRecursive(v); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	case string:
+		data, err := base64.StdEncoding.DecodeString(r)
+		if err != nil {
+			return nil, utils.StackError(err, "invalid base64 encoded t-digest")
+		}
+		return DecodeTDigest(data)
+	case *TDigest, nil:
+		return r, nil
+	default:
+		return nil, utils.StackError(nil, "unexpected t-digest %v", r)
+	}
+}
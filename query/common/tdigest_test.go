@@ -0,0 +1,144 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("t-digest", func() {
+	quantiles := []float64{0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999}
+
+	// rankOf returns the fraction of sorted values less than or equal to the value.
+	rankOf := func(sorted []float64, value float64) float64 {
+		return float64(sort.SearchFloat64s(sorted, math.Nextafter(value, math.Inf(1)))) / float64(len(sorted))
+	}
+
+	ginkgo.It("should estimate quantiles within rank errors", func() {
+		r := rand.New(rand.NewSource(0))
+		values := make([]float64, 100000)
+		d := NewTDigest(DefaultTDigestCompression)
+		for i := range values {
+			values[i] = r.ExpFloat64()
+			d.Add(values[i], 1)
+		}
+		sort.Float64s(values)
+		Ω(d.Count()).Should(Equal(float64(len(values))))
+		for _, q := range quantiles {
+			Ω(rankOf(values, d.Quantile(q))).Should(BeNumerically("~", q, 0.01), "quantile %v", q)
+		}
+		Ω(d.Quantile(0)).Should(Equal(values[0]))
+		Ω(d.Quantile(1)).Should(Equal(values[len(values)-1]))
+		Ω(len(d.centroids)).Should(BeNumerically("<=", DefaultTDigestCompression))
+	})
+
+	ginkgo.It("should merge digests of disjoint values like a single digest", func() {
+		r := rand.New(rand.NewSource(1))
+		var values []float64
+		single := NewTDigest(DefaultTDigestCompression)
+		merged := NewTDigest(DefaultTDigestCompression)
+		// parts of different distributions and sizes.
+		for part := 0; part < 8; part++ {
+			d := NewTDigest(DefaultTDigestCompression)
+			for i := 0; i < 1000*(part+1); i++ {
+				v := r.NormFloat64()*float64(part+1) + float64(part*3)
+				values = append(values, v)
+				single.Add(v, 1)
+				d.Add(v, 1)
+			}
+			merged.Merge(d)
+		}
+		sort.Float64s(values)
+		Ω(merged.Count()).Should(Equal(single.Count()))
+		for _, q := range quantiles {
+			Ω(rankOf(values, merged.Quantile(q))).Should(BeNumerically("~", q, 0.01), "quantile %v", q)
+			Ω(rankOf(values, merged.Quantile(q))).Should(BeNumerically("~", rankOf(values, single.Quantile(q)), 0.01))
+		}
+	})
+
+	ginkgo.It("should work for small and empty digests", func() {
+		d := NewTDigest(DefaultTDigestCompression)
+		Ω(math.IsNaN(d.Quantile(0.5))).Should(BeTrue())
+		d.Add(3, 1)
+		Ω(d.Quantile(0.5)).Should(Equal(3.0))
+		d.Add(1, 1)
+		d.Add(2, 1)
+		Ω(d.Quantile(0.5)).Should(Equal(2.0))
+		Ω(d.Quantile(0)).Should(Equal(1.0))
+		Ω(d.Quantile(1)).Should(Equal(3.0))
+
+		// merging empty digests is a no-op.
+		d.Merge(NewTDigest(DefaultTDigestCompression))
+		Ω(d.Count()).Should(Equal(3.0))
+	})
+
+	ginkgo.It("should round trip encoded digests", func() {
+		d := NewTDigest(DefaultTDigestCompression)
+		for i := 0; i < 1000; i++ {
+			d.Add(float64(i%97), 1)
+		}
+		decoded, err := DecodeTDigest(d.Encode())
+		Ω(err).Should(BeNil())
+		Ω(decoded.Count()).Should(Equal(d.Count()))
+		for _, q := range quantiles {
+			Ω(decoded.Quantile(q)).Should(Equal(d.Quantile(q)))
+		}
+
+		decoded, err = DecodeTDigest(NewTDigest(DefaultTDigestCompression).Encode())
+		Ω(err).Should(BeNil())
+		Ω(decoded.Count()).Should(Equal(0.0))
+
+		data := d.Encode()
+		_, err = DecodeTDigest(data[:len(data)-1])
+		Ω(err).ShouldNot(BeNil())
+		_, err = DecodeTDigest(data[:10])
+		Ω(err).ShouldNot(BeNil())
+		data[0] = 2
+		_, err = DecodeTDigest(data)
+		Ω(err.Error()).Should(ContainSubstring("t-digest version 2 not supported"))
+	})
+
+	ginkgo.It("ImportTDigestResult should import base64 encoded digests", func() {
+		d := NewTDigest(DefaultTDigestCompression)
+		d.Add(1, 1)
+		d.Add(5, 1)
+		result := AQLQueryResult{}
+		city := "1"
+		result.SetTDigest([]*string{&city, nil}, d)
+		result.SetTDigest([]*string{&city, &city}, nil)
+		bs, err := json.Marshal(result)
+		Ω(err).Should(BeNil())
+
+		var unmarshalled AQLQueryResult
+		Ω(json.Unmarshal(bs, &unmarshalled)).Should(BeNil())
+		imported, err := ImportTDigestResult(unmarshalled)
+		Ω(err).Should(BeNil())
+		digest := imported["1"].(map[string]interface{})["NULL"].(*TDigest)
+		Ω(digest.Count()).Should(Equal(2.0))
+		Ω(digest.Quantile(1)).Should(Equal(5.0))
+		Ω(imported["1"].(map[string]interface{})["1"]).Should(BeNil())
+
+		_, err = ImportTDigestResult(AQLQueryResult{"1": "not base64"})
+		Ω(err).ShouldNot(BeNil())
+		_, err = ImportTDigestResult(AQLQueryResult{"1": 1.0})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
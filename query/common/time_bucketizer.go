@@ -135,3 +135,23 @@ func parseSize(s, unit string) (int, error) {
 	}
 	return 0, utils.StackError(nil, fmt.Sprintf(parseErrorString, s), fmt.Sprintf("invalid bucket Size for %s", unit))
 }
+
+// ParseTimezone parses the fixed timezone of queries, either as offsets like -8:00 or names like
+// America/Los_Angeles.
+func ParseTimezone(timezone string) (*time.Location, error) {
+	segments := strings.Split(timezone, ":")
+	hours, err := strconv.Atoi(segments[0])
+	if err == nil {
+		minutes := 0
+		if len(segments) > 1 {
+			minutes, err = strconv.Atoi(segments[1])
+		}
+		if err == nil {
+			if hours < 0 {
+				minutes = -minutes
+			}
+			return time.FixedZone(timezone, hours*60*60+minutes*60), nil
+		}
+	}
+	return time.LoadLocation(timezone)
+}
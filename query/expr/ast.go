@@ -71,6 +71,18 @@ const (
 	MinCallName              = "min"
 	SumCallName              = "sum"
 	AvgCallName              = "avg"
+	// var and stddev aggregation functions compute population variances and standard deviations, they are
+	// only supported by brokers.
+	VarCallName    = "var"
+	StddevCallName = "stddev"
+	// countdistinct aggregation function computes exact distinct counts of low cardinality columns, it is
+	// only supported by brokers.
+	CountDistinctCallName = "countdistinct"
+	// percentile aggregation function computes approximate quantiles from t-digests of datanodes, e.g.
+	// percentile(fare, 0.5, 0.9), it is only supported by brokers.
+	PercentileCallName = "percentile"
+	// tdigest aggregation function returns base64 encoded t-digests of buckets as partials of percentiles.
+	TDigestCallName = "tdigest"
 )
 
 func (t Type) String() string {
@@ -106,7 +106,7 @@ func (qc *AQLQueryContext) SerializeHLL(dataTypes []memCom.DataType,
 
 	headerSize, totalSize := builder.CalculateSizes()
 	builder.buffer = make([]byte, totalSize)
-	if err := builder.SerializeHeader(); err != nil {
+	if err := builder.SerializeHeader(builder.buffer); err != nil {
 		return nil, err
 	}
 
@@ -165,90 +165,6 @@ func (qc *AQLQueryContext) SerializeHLL(dataTypes []memCom.DataType,
 		}
 	}
 
-	return builder.buffer, nil
-}
-
-// SerializeHeader serialize HLL header
-//	-----------query result 0-------------------
-//	 <header>
-//	 [uint8] num_enum_columns [uint8] bytes per dim ... [padding for 8 bytes]
-//	 [uint32] result_size [uint32] raw_dim_values_vector_length
-//	 [uint8] dim_index_0... [uint8] dim_index_n [padding for 8 bytes]
-//	 [uint32] data_type_0...[uint32] data_type_n [padding for 8 bytes]
-//
-//	 <enum cases 0>
-//	 [uint32_t] number of bytes of enum cases [uint16] column_index [2 bytes: padding]
-//	 <enum values 0> delimited by "\u0000\n" [padding for 8 bytes]
-//
-// 	 <end of header>
-func (builder *HLLDataWriter) SerializeHeader() error {
-	writer := utils.NewBufferWriter(builder.buffer)
-
-	// num_enum_columns
-	if err := writer.AppendUint8(uint8(len(builder.EnumDicts))); err != nil {
-		return err
-	}
-
-	// bytes per dim
-	if err := writer.Append([]byte(builder.NumDimsPerDimWidth[:])); err != nil {
-		return err
-	}
-	writer.AlignBytes(8)
-
-	// result_size
-	if err := writer.AppendUint32(builder.ResultSize); err != nil {
-		return err
-	}
-
-	// raw_dim_values_vector_length
-	if err := writer.AppendUint32(builder.PaddedRawDimValuesVectorLength); err != nil {
-		return err
-	}
-
-	// dim_indexes
-	for _, dimIndex := range builder.DimIndexes {
-		if err := writer.AppendUint8(uint8(dimIndex)); err != nil {
-			return err
-		}
-	}
-	writer.AlignBytes(8)
-
-	// data_types
-	for _, dataType := range builder.DataTypes {
-		if err := writer.AppendUint32(uint32(dataType)); err != nil {
-			return err
-		}
-	}
-	writer.AlignBytes(8)
-
-	// Write enum cases.
-	for columnID, enumCases := range builder.EnumDicts {
-		enumCasesBytes := queryCom.CalculateEnumCasesBytes(enumCases)
-		if err := writer.AppendUint32(enumCasesBytes); err != nil {
-			return err
-		}
-
-		if err := writer.AppendUint16(uint16(columnID)); err != nil {
-			return err
-		}
-
-		// padding
-		writer.SkipBytes(2)
-
-		var enumCaseBytesWritten uint32
-		for _, enumCase := range enumCases {
-			if err := writer.Append([]byte(enumCase)); err != nil {
-				return err
-			}
-
-			if err := writer.Append([]byte(queryCom.EnumDelimiter)); err != nil {
-				return err
-			}
-
-			enumCaseBytesWritten += uint32(len(enumCase)) + 2
-		}
-
-		writer.SkipBytes(int(enumCasesBytes - enumCaseBytesWritten))
-	}
-	return nil
+	// sketches are built in default precision on device.
+	return queryCom.FoldHLLResult(builder.buffer, qc.OOPK.HLLPrecision)
 }
@@ -1949,6 +1949,20 @@ func (v *ASTBuilder) hasORInPath(node *antlr.BaseParserRuleContext) tree.Logical
 	return op
 }
 
+// syntaxErrorListener keeps the first syntax error of the sql with its position.
+type syntaxErrorListener struct {
+	*antlr.DefaultErrorListener
+	err error
+}
+
+// SyntaxError records the syntax error at the line and column of the offending token.
+func (l *syntaxErrorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int,
+	msg string, e antlr.RecognitionException) {
+	if l.err == nil {
+		l.err = fmt.Errorf("syntax error at (line:%d, col:%d): %s", line, column, msg)
+	}
+}
+
 // Parse parses input sql
 func Parse(sql string, logger common.Logger) (aql *queryCom.AQLQuery, err error) {
 	defer func() {
@@ -1964,16 +1978,36 @@ func Parse(sql string, logger common.Logger) (aql *queryCom.AQLQuery, err error)
 	// Setup the input sql
 	is := util.NewCaseChangingStream(antlr.NewInputStream(sql), true)
 
-	// Create the Lexer
+	// Create the Lexer, syntax errors are reported instead of printed to the console
+	errorListener := &syntaxErrorListener{}
 	lexer := antlrgen.NewSqlBaseLexer(is)
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(errorListener)
 	stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
 
 	// Create the Parser
 	p := antlrgen.NewSqlBaseParser(stream)
+	p.RemoveErrorListeners()
+	p.AddErrorListener(errorListener)
 
 	// Finally parse the sql
 	p.GetInterpreter().SetPredictionMode(antlr.PredictionModeSLL)
 	parseTree, ok := p.Query().(*antlrgen.QueryContext)
+	if errorListener.err == nil {
+		// the query may only be followed by semicolons.
+		token := stream.LT(1)
+		for token.GetText() == ";" {
+			stream.Consume()
+			token = stream.LT(1)
+		}
+		if token.GetTokenType() != antlr.TokenEOF {
+			errorListener.SyntaxError(p, token, token.GetLine(), token.GetColumn(),
+				fmt.Sprintf("extraneous input '%s' after the query", token.GetText()), nil)
+		}
+	}
+	if errorListener.err != nil {
+		return nil, errorListener.err
+	}
 	if !ok {
 		err = fmt.Errorf("not a query")
 		return nil, err
@@ -2007,6 +2041,17 @@ func Parse(sql string, logger common.Logger) (aql *queryCom.AQLQuery, err error)
 		return
 	}
 
+	// projected dimensions of agg queries are not measures.
+	if len(aql.Dimensions) > 0 {
+		measures := aql.Measures[:0]
+		for _, measure := range aql.Measures {
+			if !isProjectedDimension(measure, aql.Dimensions) {
+				measures = append(measures, measure)
+			}
+		}
+		aql.Measures = measures
+	}
+
 	// non agg query overwrite
 	if len(aql.Dimensions) == 0 {
 		if v.aggFuncExists {
@@ -2025,3 +2070,13 @@ func Parse(sql string, logger common.Logger) (aql *queryCom.AQLQuery, err error)
 
 	return
 }
+
+// isProjectedDimension tells whether the measure selected is one of the dimensions grouped by.
+func isProjectedDimension(measure queryCom.Measure, dimensions []queryCom.Dimension) bool {
+	for _, dimension := range dimensions {
+		if measure.Expr == dimension.Expr && measure.Alias == dimension.Alias {
+			return true
+		}
+	}
+	return false
+}
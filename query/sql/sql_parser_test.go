@@ -78,7 +78,7 @@ var _ = ginkgo.Describe("SQL Parser", func() {
 		}
 		res := queryCom.AQLQuery{
 			Table:      "trips",
-			Measures:   []queryCom.Measure{{Alias: "trip_status", Expr: "status"}, {Expr: "count(*)"}},
+			Measures:   []queryCom.Measure{{Expr: "count(*)"}},
 			Dimensions: []queryCom.Dimension{{Alias: "trip_status", Expr: "status"}},
 		}
 		runTest(sqls, res, logger)
@@ -406,7 +406,7 @@ var _ = ginkgo.Describe("SQL Parser", func() {
 		sqls := []string{
 			`WITH m1 (Requested) AS
 				(With m (Requested) AS 
-					SELECT count(*) AS Requested FROM trips
+					(SELECT count(*) AS Requested FROM trips)
 				SELECT Requested FROM m)
 			SELECT Requested FROM m1;`,
 		}
@@ -446,7 +446,7 @@ var _ = ginkgo.Describe("SQL Parser", func() {
 		for _, sql := range sqls {
 			actual, err := Parse(sql, logger)
 			Ω(err).ShouldNot(BeNil())
-			Ω(err.Error()).Should(Equal("missing with query body at (line:2, col:3)"))
+			Ω(err.Error()).Should(HavePrefix("syntax error at (line:2, col:3): extraneous input 'SELECT'"))
 			Ω(actual).Should(BeNil())
 		}
 	})
@@ -520,15 +520,50 @@ var _ = ginkgo.Describe("SQL Parser", func() {
 		for _, sql := range sqls {
 			actual, err := Parse(sql, logger)
 			Ω(err).ShouldNot(BeNil())
-			Ω(err.Error()).Should(Equal("missing queryNoWith body at (line:1, col:0)"))
+			Ω(err.Error()).Should(HavePrefix("syntax error at (line:1, col:0): mismatched input '<EOF>'"))
 			Ω(actual).Should(BeNil())
 		}
 	})
 
+	ginkgo.It("Syntax errors should report positions", func() {
+		errors := map[string]string{
+			`SELEC fare FROM trips`: "syntax error at (line:1, col:0): mismatched input 'SELEC'",
+			`SELECT count(*) FROM trips
+			WHERE fare >
+			GROUP BY city_id`: "syntax error at (line:3, col:3): no viable alternative at input",
+			`SELECT fare FROM trips
+			WHERE city_id in (1,2,3) fare > 1`: "syntax error at (line:2, col:28): extraneous input 'fare' after the query",
+			`SELECT fare FROM trips WHERE fare > 1;;`: "",
+		}
+		for sql, expected := range errors {
+			actual, err := Parse(sql, logger)
+			if expected == "" {
+				Ω(err).Should(BeNil())
+				continue
+			}
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(HavePrefix(expected))
+			Ω(actual).Should(BeNil())
+		}
+	})
+
+	ginkgo.It("Unsupported constructs should report positions", func() {
+		errors := map[string]string{
+			`SELECT fare FROM trips
+			WHERE city_id IN (SELECT city_id FROM cities)`: "inSubquery at (line:2, col:17) not supported yet",
+			`SELECT fare FROM trips RIGHT JOIN cities c ON c.id = city_id`: "join type RIGHT not supported yet at (line:1, col:17)",
+		}
+		for sql, expected := range errors {
+			_, err := Parse(sql, logger)
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(Equal(expected))
+		}
+	})
+
 	ginkgo.It("In operator should work", func() {
 		sqls := []string{
 			`SELECT fare FROM trips 
-			WHERE city_id in (1,2,3) fare > m1.avg_fare;`,
+			WHERE city_id in (1,2,3);`,
 		}
 		res := queryCom.AQLQuery{
 			Table: "trips",
@@ -117,6 +117,10 @@ type oopkQueryStats struct {
 	// if its min or max value does not pass main table filters or time filters.
 	NumBatchSkipped int `json:"numBatchSkipped"`
 
+	// Archive batches read ahead before being processed, and non empty ones that were not.
+	NumPrefetchHits   int `json:"numPrefetchHits,omitempty"`
+	NumPrefetchMisses int `json:"numPrefetchMisses,omitempty"`
+
 	// Stats for input data transferred via PCIe.
 	BytesTransferred int `json:"tranBytes"`
 	NumTransferCalls int `json:"tranCalls"`
@@ -224,6 +228,9 @@ func (stats *oopkQueryStats) writeToLog() {
 		utils.GetQueryLogger().Infof("Total timing: %f", stats.TotalTiming)
 		utils.GetQueryLogger().Infof("Num batches: %d", stats.NumBatches)
 		utils.GetQueryLogger().Infof("Num batches skipped: %d", stats.NumBatchSkipped)
+		if stats.NumPrefetchHits+stats.NumPrefetchMisses > 0 {
+			utils.GetQueryLogger().Infof("Num prefetch hits: %d, misses: %d", stats.NumPrefetchHits, stats.NumPrefetchMisses)
+		}
 		// Create tabular output.
 		summary := utils.WriteTable(stats)
 		utils.GetQueryLogger().Info("\n" + summary)
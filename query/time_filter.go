@@ -66,24 +66,6 @@ func adjustMidnight(t time.Time) time.Time {
 	return t
 }
 
-func parseTimezone(timezone string) (*time.Location, error) {
-	segments := strings.Split(timezone, ":")
-	hours, err := strconv.Atoi(segments[0])
-	if err == nil {
-		minutes := 0
-		if len(segments) > 1 {
-			minutes, err = strconv.Atoi(segments[1])
-		}
-		if err == nil {
-			if hours < 0 {
-				minutes = -minutes
-			}
-			return time.FixedZone(timezone, hours*60*60+minutes*60), nil
-		}
-	}
-	return time.LoadLocation(timezone)
-}
-
 // GetCurrentCalendarUnit returns the start and end of the calendar unit for base.
 func GetCurrentCalendarUnit(base time.Time, unit string) (start, end time.Time, err error) {
 	return applyTimeOffset(base, 0, unit)
@@ -0,0 +1,484 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultFailureThreshold    = 3
+	defaultOverlapWindow       = time.Minute
+	// maxIdempotencyKeys caps idempotency keys of recent messages kept for deduplication, the oldest keys
+	// are dropped first.
+	maxIdempotencyKeys = 1 << 20
+)
+
+// NewClusterConsumer creates the consumer of the Kafka cluster. The consumer starts from offsets of startTime
+// if it is not zero, and from the committed offsets otherwise.
+type NewClusterConsumer func(brokers string, startTime time.Time) (Consumer, error)
+
+// CheckClusterHealth returns an error if the Kafka cluster is not available.
+type CheckClusterHealth func(brokers string) error
+
+// Failover is implemented by consumers able to switch between Kafka clusters.
+type Failover interface {
+	// SwitchCluster switches consumption to the secondary Kafka cluster if toSecondary is true,
+	// and back to the primary Kafka cluster otherwise.
+	SwitchCluster(toSecondary bool) error
+	// OnSecondary returns whether the secondary Kafka cluster is being consumed.
+	OnSecondary() bool
+}
+
+// FailoverConfig is config of FailoverConsumer
+type FailoverConfig struct {
+	Primary             string
+	Secondary           string
+	HealthCheckInterval time.Duration
+	FailureThreshold    int
+	OverlapWindow       time.Duration
+	AutoSwitchback      bool
+}
+
+// FailoverConsumer consumes from the primary Kafka cluster and switches to the secondary Kafka cluster the topic
+// is mirrored to once the primary fails consecutive health checks. The new cluster starts from offsets of the
+// switch time minus the overlap window so that no message is missed, messages consumed twice are dropped by
+// their ingestion idempotency keys.
+type FailoverConsumer struct {
+	sync.Mutex
+
+	config      FailoverConfig
+	newConsumer NewClusterConsumer
+	checkHealth CheckClusterHealth
+	logger      *zap.Logger
+	scope       tally.Scope
+
+	// active is the consumer of the cluster being consumed
+	active      Consumer
+	onSecondary bool
+
+	// health check states, only accessed by the run loop
+	primaryFailures  int
+	primarySuccesses int
+	failingSince     time.Time
+	// pinned is set once switched to the secondary cluster manually, which disables auto switchback
+	pinned bool
+	// idempotency keys of messages forwarded from the active cluster and of messages forwarded from the
+	// cluster switched away from, only accessed by the run loop
+	recentKeys  *idempotencyKeys
+	overlapKeys *idempotencyKeys
+
+	msgCh      chan Message
+	errCh      chan error
+	switchReqs chan switchRequest
+	shutdown   chan struct{}
+	closeCh    chan struct{}
+	closed     bool
+}
+
+// idempotencyKeys keeps idempotency keys of messages in the order they are forwarded.
+type idempotencyKeys struct {
+	// counts is the number of recorded occurrences of each key not consumed by remove yet
+	counts map[uint64]int
+	// removed is the number of occurrences of each key consumed by remove but still in keys
+	removed map[uint64]int
+	keys    []forwardedKey
+}
+
+type forwardedKey struct {
+	key uint64
+	at  time.Time
+}
+
+type switchRequest struct {
+	toSecondary bool
+	result      chan error
+}
+
+// failoverMessage keeps the consumer the message is read from so that it is committed to the right cluster
+type failoverMessage struct {
+	Message
+
+	failover *FailoverConsumer
+	consumer Consumer
+}
+
+// NewFailoverConsumer creates a FailoverConsumer consuming from the primary cluster.
+func NewFailoverConsumer(config FailoverConfig, newConsumer NewClusterConsumer, checkHealth CheckClusterHealth,
+	bufferSize int, logger *zap.Logger, scope tally.Scope) (*FailoverConsumer, error) {
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.OverlapWindow <= 0 {
+		config.OverlapWindow = defaultOverlapWindow
+	}
+
+	primary, err := newConsumer(config.Primary, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &FailoverConsumer{
+		config:      config,
+		newConsumer: newConsumer,
+		checkHealth: checkHealth,
+		logger: logger.With(
+			zap.String("primary", config.Primary),
+			zap.String("secondary", config.Secondary)),
+		scope:       scope,
+		active:      primary,
+		recentKeys:  newIdempotencyKeys(),
+		overlapKeys: newIdempotencyKeys(),
+		msgCh:       make(chan Message, bufferSize),
+		errCh:       make(chan error, bufferSize),
+		switchReqs:  make(chan switchRequest),
+		shutdown:    make(chan struct{}),
+		closeCh:     make(chan struct{}),
+	}
+	c.scope.Gauge("failover.onSecondary").Update(0)
+
+	go c.run(time.NewTicker(config.HealthCheckInterval))
+	return c, nil
+}
+
+// Name returns the name of this consumer group.
+func (c *FailoverConsumer) Name() string {
+	return c.getActive().Name()
+}
+
+// Topics returns the names of the topics being consumed.
+func (c *FailoverConsumer) Topics() []string {
+	return c.getActive().Topics()
+}
+
+// Errors returns a channel of errors of the active cluster.
+func (c *FailoverConsumer) Errors() <-chan error {
+	return c.errCh
+}
+
+// Closed returns a channel that unblocks when the consumer successfully shuts
+// down.
+func (c *FailoverConsumer) Closed() <-chan struct{} {
+	return c.closeCh
+}
+
+// Messages returns a channel of messages of the active cluster.
+func (c *FailoverConsumer) Messages() <-chan Message {
+	return c.msgCh
+}
+
+// CommitUpTo commits the message to the cluster it is read from. Messages read from the cluster switched away
+// from are not committed, they are consumed again from the overlap window when switching back.
+func (c *FailoverConsumer) CommitUpTo(msg Message) error {
+	fm, ok := msg.(*failoverMessage)
+	if !ok {
+		return c.getActive().CommitUpTo(msg)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if fm.consumer != c.active || c.closed {
+		return fmt.Errorf("message of topic %s partition %d offset %d is read before switching Kafka cluster",
+			msg.Topic(), msg.Partition(), msg.Offset())
+	}
+	return fm.consumer.CommitUpTo(fm.Message)
+}
+
+// Close closes the consumer of the active cluster.
+func (c *FailoverConsumer) Close() error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return fmt.Errorf("Close attempted again on failover consumer of %s", c.config.Primary)
+	}
+	c.closed = true
+	close(c.shutdown)
+	active := c.active
+	c.Unlock()
+
+	err := active.Close()
+	close(c.closeCh)
+	return err
+}
+
+// SwitchCluster switches consumption to the secondary Kafka cluster if toSecondary is true,
+// and back to the primary Kafka cluster otherwise.
+func (c *FailoverConsumer) SwitchCluster(toSecondary bool) error {
+	req := switchRequest{
+		toSecondary: toSecondary,
+		result:      make(chan error, 1),
+	}
+	select {
+	case c.switchReqs <- req:
+		return <-req.result
+	case <-c.shutdown:
+		return fmt.Errorf("failover consumer of %s is closed", c.config.Primary)
+	}
+}
+
+// OnSecondary returns whether the secondary Kafka cluster is being consumed.
+func (c *FailoverConsumer) OnSecondary() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.onSecondary
+}
+
+func (c *FailoverConsumer) getActive() Consumer {
+	c.Lock()
+	defer c.Unlock()
+	return c.active
+}
+
+// run forwards messages and errors of the active consumer and switches clusters. Switches only happen in
+// this goroutine so that nothing is read from the consumer being closed.
+func (c *FailoverConsumer) run(ticker *time.Ticker) {
+	defer ticker.Stop()
+	for {
+		active := c.getActive()
+		select {
+		case msg, ok := <-active.Messages():
+			if !ok {
+				// the consumer stopped delivering messages on its own, close the failover consumer so that
+				// the processor restarts.
+				c.logger.Warn("Kafka consumer messages closed, closing failover consumer")
+				go c.Close()
+				return
+			}
+			if msg == nil {
+				continue
+			}
+			key := idempotencyKey(msg)
+			if c.overlapKeys.remove(key) {
+				// already forwarded from the cluster switched away from.
+				c.scope.Counter("failover.duplicates").Inc(1)
+				continue
+			}
+			c.recentKeys.add(key, time.Now())
+			select {
+			case c.msgCh <- &failoverMessage{Message: msg, failover: c, consumer: active}:
+			case <-c.shutdown:
+				return
+			}
+		case err, ok := <-active.Errors():
+			if !ok {
+				c.logger.Warn("Kafka consumer errors closed, closing failover consumer")
+				go c.Close()
+				return
+			}
+			select {
+			case c.errCh <- err:
+			case <-c.shutdown:
+				return
+			}
+		case <-active.Closed():
+			// the consumer is closed on its own, close the failover consumer so that the processor restarts.
+			c.logger.Warn("Kafka consumer closed, closing failover consumer")
+			go c.Close()
+			return
+		case req := <-c.switchReqs:
+			err := c.switchCluster(req.toSecondary, "manual", time.Now())
+			if err == nil {
+				c.pinned = req.toSecondary
+			}
+			req.result <- err
+		case <-ticker.C:
+			c.checkClusters()
+		case <-c.shutdown:
+			return
+		}
+	}
+}
+
+// checkClusters checks the health of the primary cluster, switches to the secondary cluster once the primary
+// fails consecutive checks and switches back once the primary passes consecutive checks if configured.
+func (c *FailoverConsumer) checkClusters() {
+	now := time.Now()
+	c.pruneKeys(now)
+	if err := c.checkHealth(c.config.Primary); err != nil {
+		c.logger.Warn("Kafka cluster health check failed", zap.String("cluster", c.config.Primary), zap.Error(err))
+		c.scope.Tagged(map[string]string{"kafkaCluster": "primary"}).Counter("failover.healthCheck.failures").Inc(1)
+		if c.primaryFailures == 0 {
+			c.failingSince = now
+		}
+		c.primaryFailures++
+		c.primarySuccesses = 0
+		if !c.OnSecondary() && c.primaryFailures >= c.config.FailureThreshold {
+			if err := c.checkHealth(c.config.Secondary); err != nil {
+				c.logger.Error("Secondary Kafka cluster unhealthy, not switching",
+					zap.String("cluster", c.config.Secondary), zap.Error(err))
+				c.scope.Tagged(map[string]string{"kafkaCluster": "secondary"}).Counter("failover.healthCheck.failures").Inc(1)
+				return
+			}
+			// start from when the primary began failing as messages may have been missed since then.
+			c.switchCluster(true, "primary unhealthy", c.failingSince)
+		}
+		return
+	}
+
+	c.primaryFailures = 0
+	c.primarySuccesses++
+	if c.OnSecondary() && c.config.AutoSwitchback && !c.pinned && c.primarySuccesses >= c.config.FailureThreshold {
+		c.switchCluster(false, "primary recovered", now)
+	}
+}
+
+// switchCluster replaces the active consumer with the consumer of the other cluster starting from the overlap
+// window before since.
+func (c *FailoverConsumer) switchCluster(toSecondary bool, reason string, since time.Time) error {
+	from, to, toName := c.config.Primary, c.config.Secondary, "secondary"
+	if !toSecondary {
+		from, to, toName = c.config.Secondary, c.config.Primary, "primary"
+	}
+	if c.OnSecondary() == toSecondary {
+		c.logger.Info("Kafka cluster already consumed", zap.String("cluster", to), zap.String("reason", reason))
+		return nil
+	}
+
+	startTime := since.Add(-c.config.OverlapWindow)
+	next, err := c.newConsumer(to, startTime)
+	if err != nil {
+		c.logger.Error("Failed to switch Kafka cluster",
+			zap.String("from", from),
+			zap.String("to", to),
+			zap.String("reason", reason),
+			zap.Error(err))
+		c.scope.Tagged(map[string]string{"kafkaCluster": toName}).Counter("failover.errors").Inc(1)
+		return err
+	}
+
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		next.Close()
+		return fmt.Errorf("failover consumer of %s is closed", c.config.Primary)
+	}
+	prev := c.active
+	c.active = next
+	c.onSecondary = toSecondary
+	c.Unlock()
+	// messages of the overlap window forwarded from the previous cluster are dropped once consumed again.
+	c.overlapKeys, c.recentKeys = c.recentKeys, newIdempotencyKeys()
+	if err := prev.Close(); err != nil {
+		c.logger.Warn("Failed to close Kafka consumer", zap.String("cluster", from), zap.Error(err))
+	}
+
+	c.logger.Info("Switched Kafka cluster",
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.String("reason", reason),
+		zap.Time("startTime", startTime))
+	c.scope.Tagged(map[string]string{"kafkaCluster": toName, "reason": reason}).Counter("failover.switches").Inc(1)
+	if toSecondary {
+		c.scope.Gauge("failover.onSecondary").Update(1)
+	} else {
+		c.scope.Gauge("failover.onSecondary").Update(0)
+	}
+	return nil
+}
+
+// pruneKeys drops idempotency keys of messages forwarded before the earliest time a cluster switched to may
+// start consuming from.
+func (c *FailoverConsumer) pruneKeys(now time.Time) {
+	retention := c.config.OverlapWindow + time.Duration(c.config.FailureThreshold)*c.config.HealthCheckInterval
+	before := now.Add(-retention)
+	c.recentKeys.prune(before)
+	c.overlapKeys.prune(before)
+}
+
+// idempotencyKey returns the ingestion idempotency key of the message, which is the same for copies of the
+// message mirrored to other Kafka clusters where partitions and offsets may differ. Messages do not carry
+// producer supplied idempotency keys, so the key is deliberately a hash of the topic, key and value of the
+// message instead: identical messages produced within the overlap window are treated as duplicates, and a
+// hash collision may drop a distinct message in the overlap window after a switch.
+func idempotencyKey(msg Message) uint64 {
+	h := fnv.New64a()
+	var length [4]byte
+	for _, field := range [][]byte{[]byte(msg.Topic()), msg.Key(), msg.Value()} {
+		binary.LittleEndian.PutUint32(length[:], uint32(len(field)))
+		h.Write(length[:])
+		h.Write(field)
+	}
+	return h.Sum64()
+}
+
+func newIdempotencyKeys() *idempotencyKeys {
+	return &idempotencyKeys{counts: make(map[uint64]int), removed: make(map[uint64]int)}
+}
+
+// add records the key of a message forwarded at the time.
+func (k *idempotencyKeys) add(key uint64, at time.Time) {
+	if len(k.keys) >= maxIdempotencyKeys {
+		k.drop(1)
+	}
+	k.keys = append(k.keys, forwardedKey{key: key, at: at})
+	k.counts[key]++
+}
+
+// remove consumes the oldest recorded occurrence of the key, returns false if the key is not recorded.
+func (k *idempotencyKeys) remove(key uint64) bool {
+	if k.counts[key] == 0 {
+		return false
+	}
+	decrement(k.counts, key)
+	k.removed[key]++
+	return true
+}
+
+// prune drops keys of messages forwarded before the time.
+func (k *idempotencyKeys) prune(before time.Time) {
+	n := 0
+	for n < len(k.keys) && k.keys[n].at.Before(before) {
+		n++
+	}
+	k.drop(n)
+}
+
+// drop drops the oldest n keys. Since remove consumes the oldest occurrences first, a dropped key with
+// occurrences consumed by remove is one of them and is not counted again.
+func (k *idempotencyKeys) drop(n int) {
+	for _, fk := range k.keys[:n] {
+		if k.removed[fk.key] > 0 {
+			decrement(k.removed, fk.key)
+		} else {
+			decrement(k.counts, fk.key)
+		}
+	}
+	k.keys = k.keys[n:]
+}
+
+// decrement decrements the count of the key and deletes the key once its count drops to zero.
+func decrement(counts map[uint64]int, key uint64) {
+	if count := counts[key]; count <= 1 {
+		delete(counts, key)
+	} else {
+		counts[key] = count - 1
+	}
+}
+
+// Ack commits the message through the failover consumer.
+func (m *failoverMessage) Ack() {
+	m.failover.CommitUpTo(m)
+}
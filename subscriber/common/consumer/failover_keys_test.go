@@ -0,0 +1,54 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("idempotency keys", func() {
+	It("should not drop occurrences of keys consumed by remove twice", func() {
+		keys := newIdempotencyKeys()
+		now := time.Now()
+		keys.add(1, now)
+		keys.add(1, now.Add(time.Second))
+		Ω(keys.remove(1)).Should(BeTrue())
+
+		// the oldest occurrence is the one already consumed.
+		keys.prune(now.Add(time.Millisecond))
+		Ω(keys.remove(1)).Should(BeTrue())
+		Ω(keys.remove(1)).Should(BeFalse())
+		Ω(keys.counts).Should(BeEmpty())
+		Ω(keys.removed).Should(HaveLen(1))
+
+		keys.prune(now.Add(2 * time.Second))
+		Ω(keys.keys).Should(BeEmpty())
+		Ω(keys.removed).Should(BeEmpty())
+	})
+
+	It("should drop occurrences of keys not consumed by remove", func() {
+		keys := newIdempotencyKeys()
+		now := time.Now()
+		keys.add(1, now)
+		keys.add(1, now.Add(time.Second))
+
+		keys.prune(now.Add(time.Millisecond))
+		Ω(keys.remove(1)).Should(BeTrue())
+		Ω(keys.remove(1)).Should(BeFalse())
+	})
+})
@@ -0,0 +1,244 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/subscriber/common/consumer"
+	"go.uber.org/zap"
+)
+
+type fakeMessage struct {
+	offset int64
+	value  string
+}
+
+func (m *fakeMessage) Key() []byte      { return nil }
+func (m *fakeMessage) Value() []byte    { return []byte(m.value) }
+func (m *fakeMessage) Topic() string    { return "topic" }
+func (m *fakeMessage) Partition() int32 { return 0 }
+func (m *fakeMessage) Offset() int64    { return m.offset }
+func (m *fakeMessage) Ack()             {}
+func (m *fakeMessage) Nack()            {}
+func (m *fakeMessage) Cluster() string  { return "" }
+
+type fakeConsumer struct {
+	sync.Mutex
+
+	brokers   string
+	startTime time.Time
+	msgCh     chan consumer.Message
+	errCh     chan error
+	closeCh   chan struct{}
+	closed    bool
+	committed []int64
+}
+
+func newFakeConsumer(brokers string, startTime time.Time) *fakeConsumer {
+	return &fakeConsumer{
+		brokers:   brokers,
+		startTime: startTime,
+		msgCh:     make(chan consumer.Message),
+		errCh:     make(chan error),
+		closeCh:   make(chan struct{}),
+	}
+}
+
+func (c *fakeConsumer) Name() string                      { return "group" }
+func (c *fakeConsumer) Topics() []string                  { return []string{"topic"} }
+func (c *fakeConsumer) Errors() <-chan error              { return c.errCh }
+func (c *fakeConsumer) Closed() <-chan struct{}           { return c.closeCh }
+func (c *fakeConsumer) Messages() <-chan consumer.Message { return c.msgCh }
+
+func (c *fakeConsumer) CommitUpTo(msg consumer.Message) error {
+	c.Lock()
+	defer c.Unlock()
+	c.committed = append(c.committed, msg.Offset())
+	return nil
+}
+
+func (c *fakeConsumer) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConsumer) isClosed() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.closed
+}
+
+var _ = Describe("failover consumer", func() {
+	var consumers chan *fakeConsumer
+	var healthy map[string]bool
+	var lock sync.Mutex
+
+	newConsumer := func(brokers string, startTime time.Time) (consumer.Consumer, error) {
+		c := newFakeConsumer(brokers, startTime)
+		lock.Lock()
+		created := consumers
+		lock.Unlock()
+		created <- c
+		return c, nil
+	}
+
+	checkHealth := func(brokers string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if !healthy[brokers] {
+			return errors.New("no leader")
+		}
+		return nil
+	}
+
+	setHealthy := func(brokers string, h bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		healthy[brokers] = h
+	}
+
+	newFailoverConsumer := func(autoSwitchback bool) *consumer.FailoverConsumer {
+		c, err := consumer.NewFailoverConsumer(consumer.FailoverConfig{
+			Primary:             "primary:9092",
+			Secondary:           "secondary:9092",
+			HealthCheckInterval: 10 * time.Millisecond,
+			FailureThreshold:    2,
+			OverlapWindow:       time.Minute,
+			AutoSwitchback:      autoSwitchback,
+		}, newConsumer, checkHealth, 1, zap.NewNop(), tally.NoopScope)
+		Ω(err).Should(BeNil())
+		return c
+	}
+
+	BeforeEach(func() {
+		// consumers of previous specs may still be checking health.
+		lock.Lock()
+		defer lock.Unlock()
+		consumers = make(chan *fakeConsumer, 4)
+		healthy = map[string]bool{"primary:9092": true, "secondary:9092": true}
+	})
+
+	It("should fail over to the secondary cluster and switch back", func() {
+		c := newFailoverConsumer(true)
+		defer c.Close()
+		primary := <-consumers
+		Ω(primary.brokers).Should(Equal("primary:9092"))
+		Ω(primary.startTime.IsZero()).Should(BeTrue())
+
+		primary.msgCh <- &fakeMessage{offset: 1, value: "a"}
+		msg := <-c.Messages()
+		Ω(c.CommitUpTo(msg)).Should(BeNil())
+		Ω(primary.committed).Should(Equal([]int64{1}))
+
+		failingSince := time.Now()
+		setHealthy("primary:9092", false)
+		var secondary *fakeConsumer
+		Eventually(consumers).Should(Receive(&secondary))
+		Ω(secondary.brokers).Should(Equal("secondary:9092"))
+		// the secondary starts from the overlap window before the primary began failing.
+		Ω(secondary.startTime).Should(BeTemporally("~", failingSince.Add(-time.Minute), 50*time.Millisecond))
+		Eventually(primary.isClosed).Should(BeTrue())
+		Ω(c.OnSecondary()).Should(BeTrue())
+
+		// messages read before the switch are not committed to the secondary.
+		Ω(c.CommitUpTo(msg)).ShouldNot(BeNil())
+		secondary.msgCh <- &fakeMessage{offset: 10, value: "b"}
+		msg = <-c.Messages()
+		Ω(msg.Offset()).Should(BeEquivalentTo(10))
+		Ω(c.CommitUpTo(msg)).Should(BeNil())
+		Ω(secondary.committed).Should(Equal([]int64{10}))
+
+		setHealthy("primary:9092", true)
+		Eventually(consumers).Should(Receive(&primary))
+		Ω(primary.brokers).Should(Equal("primary:9092"))
+		Ω(primary.startTime.IsZero()).Should(BeFalse())
+		Eventually(c.OnSecondary).Should(BeFalse())
+	})
+
+	It("should not fail over to an unhealthy secondary cluster", func() {
+		c := newFailoverConsumer(true)
+		defer c.Close()
+		<-consumers
+
+		setHealthy("primary:9092", false)
+		setHealthy("secondary:9092", false)
+		Consistently(consumers, 100*time.Millisecond).ShouldNot(Receive())
+		Ω(c.OnSecondary()).Should(BeFalse())
+	})
+
+	It("should drop messages of the overlap window consumed again", func() {
+		c := newFailoverConsumer(false)
+		defer c.Close()
+		primary := <-consumers
+
+		for i, value := range []string{"a", "b"} {
+			primary.msgCh <- &fakeMessage{offset: int64(i), value: value}
+			<-c.Messages()
+		}
+
+		Ω(c.SwitchCluster(true)).Should(BeNil())
+		secondary := <-consumers
+		go func() {
+			for i, value := range []string{"a", "b", "b", "c"} {
+				secondary.msgCh <- &fakeMessage{offset: int64(10 + i), value: value}
+			}
+		}()
+		var msg consumer.Message
+		Eventually(c.Messages()).Should(Receive(&msg))
+		Ω(msg.Offset()).Should(BeEquivalentTo(12))
+		Eventually(c.Messages()).Should(Receive(&msg))
+		Ω(msg.Offset()).Should(BeEquivalentTo(13))
+	})
+
+	It("should close once the Kafka consumer stops delivering messages", func() {
+		c := newFailoverConsumer(false)
+		primary := <-consumers
+
+		close(primary.msgCh)
+		Eventually(c.Closed()).Should(BeClosed())
+		Ω(primary.isClosed()).Should(BeTrue())
+	})
+
+	It("should switch clusters manually", func() {
+		c := newFailoverConsumer(true)
+		<-consumers
+
+		Ω(c.SwitchCluster(true)).Should(BeNil())
+		secondary := <-consumers
+		Ω(secondary.brokers).Should(Equal("secondary:9092"))
+		Ω(c.OnSecondary()).Should(BeTrue())
+		// manual switch disables auto switchback.
+		Consistently(consumers, 100*time.Millisecond).ShouldNot(Receive())
+
+		Ω(c.SwitchCluster(false)).Should(BeNil())
+		primary := <-consumers
+		Ω(primary.brokers).Should(Equal("primary:9092"))
+		Ω(c.OnSecondary()).Should(BeFalse())
+
+		Ω(c.Close()).Should(BeNil())
+		Eventually(c.Closed()).Should(BeClosed())
+		Ω(primary.isClosed()).Should(BeTrue())
+		Ω(c.Close()).ShouldNot(BeNil())
+		Ω(c.SwitchCluster(true)).ShouldNot(BeNil())
+	})
+})
@@ -16,13 +16,17 @@ package kafka
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/uber/aresdb/subscriber/common/consumer"
 
 	"strconv"
 
+	"github.com/Shopify/sarama"
 	kafkaConfluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/uber/aresdb/controller/models"
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/subscriber/common/rules"
 	"github.com/uber/aresdb/subscriber/config"
@@ -56,14 +60,59 @@ type KafkaMessage struct {
 	ClusterName string
 }
 
+const (
+	// healthCheckTimeout is the timeout of Kafka cluster health checks
+	healthCheckTimeout = 5 * time.Second
+	// offsetsForTimesTimeoutMs is the timeout of looking up offsets of the start time
+	offsetsForTimesTimeoutMs = 10000
+)
+
 // NewKafkaConsumer creates kafka consumer by using https://github.com/confluentinc/confluent-kafka-go.
+// If the secondary Kafka cluster is configured, the consumer fails over to the secondary cluster once the
+// primary cluster is unhealthy.
 func NewKafkaConsumer(jobConfig *rules.JobConfig, serviceConfig config.ServiceConfig) (consumer.Consumer, error) {
+	streamingConfig := jobConfig.StreamingConfig
+	if streamingConfig.SecondaryKafkaBroker == "" {
+		return newKafkaConsumer(jobConfig, serviceConfig, streamingConfig.KafkaBroker, time.Time{})
+	}
+
+	failoverConfig := models.FailoverConfig{}
+	if streamingConfig.Failover != nil {
+		failoverConfig = *streamingConfig.Failover
+	}
+	c, err := consumer.NewFailoverConsumer(consumer.FailoverConfig{
+		Primary:             streamingConfig.KafkaBroker,
+		Secondary:           streamingConfig.SecondaryKafkaBroker,
+		HealthCheckInterval: time.Duration(failoverConfig.HealthCheckIntervalSec) * time.Second,
+		FailureThreshold:    failoverConfig.FailureThreshold,
+		OverlapWindow:       time.Duration(failoverConfig.OverlapWindowSec) * time.Second,
+		AutoSwitchback:      failoverConfig.AutoSwitchback,
+	}, func(brokers string, startTime time.Time) (consumer.Consumer, error) {
+		return newKafkaConsumer(jobConfig, serviceConfig, brokers, startTime)
+	}, func(brokers string) error {
+		return CheckClusterHealth(brokers, streamingConfig.Topic)
+	}, int(streamingConfig.ChannelBufferSize),
+		serviceConfig.Logger.With(zap.String("job", jobConfig.Name), zap.String("topic", streamingConfig.Topic)),
+		serviceConfig.Scope.Tagged(map[string]string{
+			"job":         jobConfig.Name,
+			"aresCluster": jobConfig.AresTableConfig.Cluster,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newKafkaConsumer creates kafka consumer of the brokers, it starts from offsets of startTime if it is not zero
+// and from committed offsets otherwise.
+func newKafkaConsumer(jobConfig *rules.JobConfig, serviceConfig config.ServiceConfig, brokers string,
+	startTime time.Time) (consumer.Consumer, error) {
 	offsetReset := "earliest"
 	if jobConfig.StreamingConfig.LatestOffset {
 		offsetReset = "latest"
 	}
 	cfg := kafkaConfluent.ConfigMap{
-		"bootstrap.servers":               jobConfig.StreamingConfig.KafkaBroker,
+		"bootstrap.servers":               brokers,
 		"group.id":                        GetConsumerGroupName(serviceConfig.Environment.Deployment, jobConfig.Name, jobConfig.AresTableConfig.Cluster),
 		"max.poll.interval.ms":            jobConfig.StreamingConfig.MaxPollIntervalMs,
 		"session.timeout.ms":              jobConfig.StreamingConfig.SessionTimeoutNs,
@@ -74,7 +123,8 @@ func NewKafkaConsumer(jobConfig *rules.JobConfig, serviceConfig config.ServiceCo
 	}
 	serviceConfig.Logger.Info("Kafka consumer",
 		zap.String("job", jobConfig.Name),
-		zap.String("broker", jobConfig.StreamingConfig.KafkaBroker),
+		zap.String("broker", brokers),
+		zap.Time("startTime", startTime),
 		zap.Any("config", cfg))
 
 	c, err := kafkaConfluent.NewConsumer(&cfg)
@@ -82,18 +132,22 @@ func NewKafkaConsumer(jobConfig *rules.JobConfig, serviceConfig config.ServiceCo
 		return nil, utils.StackError(err, "Unable to initialize Kafka consumer")
 	}
 
-	err = c.Subscribe(jobConfig.StreamingConfig.Topic, nil)
+	var rebalanceCb kafkaConfluent.RebalanceCb
+	if !startTime.IsZero() {
+		rebalanceCb = assignFromTime(startTime, serviceConfig.Logger)
+	}
+	err = c.Subscribe(jobConfig.StreamingConfig.Topic, rebalanceCb)
 	if err != nil {
 		return nil, utils.StackError(err, fmt.Sprintf("Unable to subscribe to topic: %s", jobConfig.StreamingConfig.Topic))
 	}
 
 	logger := serviceConfig.Logger.With(
-		zap.String("kafkaBroker", jobConfig.StreamingConfig.KafkaBroker),
+		zap.String("kafkaBroker", brokers),
 		zap.String("topic", jobConfig.StreamingConfig.Topic),
 	)
 
 	scope := serviceConfig.Scope.Tagged(map[string]string{
-		"broker": jobConfig.StreamingConfig.KafkaBroker,
+		"broker": brokers,
 	})
 
 	kc := KafkaConsumer{
@@ -111,6 +165,77 @@ func NewKafkaConsumer(jobConfig *rules.JobConfig, serviceConfig config.ServiceCo
 	return &kc, nil
 }
 
+// assignFromTime returns the rebalance callback starting partitions from offsets of startTime when they are
+// assigned for the first time, partitions assigned again start from committed offsets.
+func assignFromTime(startTime time.Time, logger *zap.Logger) kafkaConfluent.RebalanceCb {
+	var lock sync.Mutex
+	started := make(map[int32]bool)
+	return func(c *kafkaConfluent.Consumer, event kafkaConfluent.Event) error {
+		switch e := event.(type) {
+		case kafkaConfluent.AssignedPartitions:
+			lock.Lock()
+			defer lock.Unlock()
+			var times []kafkaConfluent.TopicPartition
+			for _, partition := range e.Partitions {
+				if !started[partition.Partition] {
+					partition.Offset = kafkaConfluent.Offset(startTime.UnixNano() / int64(time.Millisecond))
+					times = append(times, partition)
+				}
+			}
+			offsets := make(map[int32]kafkaConfluent.Offset)
+			if len(times) > 0 {
+				timeOffsets, err := c.OffsetsForTimes(times, offsetsForTimesTimeoutMs)
+				if err != nil {
+					logger.Error("Failed to get offsets of start time, starting from committed offsets",
+						zap.Time("startTime", startTime), zap.Error(err))
+				}
+				for _, partition := range timeOffsets {
+					offsets[partition.Partition] = partition.Offset
+				}
+			}
+
+			partitions := make([]kafkaConfluent.TopicPartition, len(e.Partitions))
+			for i, partition := range e.Partitions {
+				if offset, ok := offsets[partition.Partition]; ok {
+					partition.Offset = offset
+				}
+				started[partition.Partition] = true
+				partitions[i] = partition
+			}
+			logger.Info("Assigned partitions", zap.Time("startTime", startTime), zap.Any("partitions", partitions))
+			return c.Assign(partitions)
+		case kafkaConfluent.RevokedPartitions:
+			return c.Unassign()
+		}
+		return nil
+	}
+}
+
+// CheckClusterHealth returns an error if metadata of the topic can't be fetched from the Kafka cluster or
+// any partition of the topic has no leader.
+func CheckClusterHealth(brokers, topic string) error {
+	cfg := sarama.NewConfig()
+	cfg.Net.DialTimeout = healthCheckTimeout
+	cfg.Net.ReadTimeout = healthCheckTimeout
+	cfg.Metadata.Retry.Max = 0
+	client, err := sarama.NewClient(strings.Split(brokers, ","), cfg)
+	if err != nil {
+		return utils.StackError(err, "Unable to connect to Kafka cluster %s", brokers)
+	}
+	defer client.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return utils.StackError(err, "Unable to get partitions of topic %s", topic)
+	}
+	for _, partition := range partitions {
+		if _, err := client.Leader(topic, partition); err != nil {
+			return utils.StackError(err, "No leader of topic %s partition %d", topic, partition)
+		}
+	}
+	return nil
+}
+
 // Name returns the name of this consumer group.
 func (c *KafkaConsumer) Name() string {
 	return c.ConfigMap["group.id"].(string)
@@ -0,0 +1,130 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"github.com/uber/aresdb/subscriber/common/consumer"
+	"github.com/uber/aresdb/subscriber/config"
+)
+
+const (
+	// ErrorClassDecode is the error class of messages failed to be decoded
+	ErrorClassDecode = "decode"
+	// ErrorClassParse is the error class of messages failed to be parsed into rows
+	ErrorClassParse = "parse"
+	// ErrorClassSave is the error class of rows failed to be saved to the sink
+	ErrorClassSave = "save"
+	// ErrorClassTransform is the error class of messages failed in the transformation pipeline of the job
+	ErrorClassTransform = "transform"
+)
+
+// Message is a dead letter, the original Kafka message failed to be ingested with the failure metadata
+type Message struct {
+	Job       string `json:"job"`
+	Cluster   string `json:"aresCluster"`
+	Table     string `json:"table"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       []byte `json:"key,omitempty"`
+	Value     []byte `json:"value"`
+	// Error is the error of the last attempt
+	Error      string `json:"error"`
+	ErrorClass string `json:"errorClass"`
+	Attempts   int    `json:"attempts"`
+	// Timestamp is the time in milliseconds the message is sent to the dead letter queue
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Queue is the dead letter queue of messages failed to be ingested
+type Queue interface {
+	// Publish sends the messages to the dead letter queue
+	Publish(messages []Message) error
+	// Redrive passes messages of the job and ares cluster in the queue to redrive in order, until all messages
+	// are redriven or redrive fails. Messages redriven successfully are not redriven again, it returns the number
+	// of messages redriven successfully.
+	Redrive(job, cluster string, redrive func(Message) error) (int, error)
+	// Close releases resources of the queue
+	Close() error
+}
+
+// NewQueue creates the dead letter queue, it returns nil if the dead letter queue is disabled.
+// Messages are sent to the Kafka topic if configured, or written to the spill directory otherwise.
+func NewQueue(serviceConfig config.ServiceConfig) (Queue, error) {
+	cfg := serviceConfig.DeadLetterConfig
+	if cfg.MaxAttempts <= 0 {
+		return nil, nil
+	}
+	if cfg.Topic != "" {
+		queue, err := NewKafkaQueue(cfg, serviceConfig)
+		if err != nil {
+			return nil, err
+		}
+		return queue, nil
+	}
+	queue, err := NewSpillQueue(cfg.SpillDir)
+	if err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// consumerMessage replays a dead letter as a message of its original topic
+type consumerMessage struct {
+	message Message
+}
+
+// NewConsumerMessage creates the message of the original topic of the dead letter
+func NewConsumerMessage(message Message) consumer.Message {
+	return &consumerMessage{message: message}
+}
+
+// Key is a mutable reference to the message's key.
+func (m *consumerMessage) Key() []byte {
+	return m.message.Key
+}
+
+// Value is a mutable reference to the message's value.
+func (m *consumerMessage) Value() []byte {
+	return m.message.Value
+}
+
+// Topic is the topic from which the message was read.
+func (m *consumerMessage) Topic() string {
+	return m.message.Topic
+}
+
+// Partition is the ID of the partition from which the message was read.
+func (m *consumerMessage) Partition() int32 {
+	return m.message.Partition
+}
+
+// Offset is the message's offset.
+func (m *consumerMessage) Offset() int64 {
+	return m.message.Offset
+}
+
+// Ack is a no-op, redriven messages are removed from the queue by the queue
+func (m *consumerMessage) Ack() {
+}
+
+// Nack is a no-op, redriven messages are removed from the queue by the queue
+func (m *consumerMessage) Nack() {
+}
+
+// Cluster is the message's originated cluster.
+func (m *consumerMessage) Cluster() string {
+	return m.message.Cluster
+}
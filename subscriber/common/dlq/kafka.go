@@ -0,0 +1,189 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/uber/aresdb/subscriber/config"
+	"github.com/uber/aresdb/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// redriveGroupPrefix is the prefix of consumer groups keeping redrive progress of jobs
+	redriveGroupPrefix = "ares-subscriber-dlq-redrive"
+	// redriveFetchTimeout is the max duration to wait for the next message while redriving
+	redriveFetchTimeout = 30 * time.Second
+)
+
+// KafkaQueue produces dead letters to a Kafka topic, keyed by job. Redrive progress is committed per job and
+// ares cluster as offsets of a consumer group.
+type KafkaQueue struct {
+	topic    string
+	client   sarama.Client
+	producer sarama.SyncProducer
+	logger   *zap.Logger
+}
+
+// NewKafkaQueue creates a KafkaQueue
+func NewKafkaQueue(cfg config.DeadLetterConfig, serviceConfig config.ServiceConfig) (*KafkaQueue, error) {
+	addresses := strings.Split(cfg.KafkaProducerConfig.Brokers, ",")
+	serviceConfig.Logger.Info("Dead letter queue", zap.String("topic", cfg.Topic), zap.Any("brokers", addresses))
+
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+	if cfg.KafkaProducerConfig.RetryMax > 0 {
+		saramaCfg.Producer.Retry.Max = cfg.KafkaProducerConfig.RetryMax
+	}
+	if cfg.KafkaProducerConfig.TimeoutInSec > 0 {
+		saramaCfg.Producer.Timeout = time.Second * time.Duration(cfg.KafkaProducerConfig.TimeoutInSec)
+	}
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	client, err := sarama.NewClient(addresses, saramaCfg)
+	if err != nil {
+		return nil, utils.StackError(err, "Unable to initialize Kafka client of dead letter queue")
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, utils.StackError(err, "Unable to initialize Kafka producer of dead letter queue")
+	}
+	return &KafkaQueue{
+		topic:    cfg.Topic,
+		client:   client,
+		producer: producer,
+		logger:   serviceConfig.Logger,
+	}, nil
+}
+
+// Publish produces the messages to the dead letter topic
+func (q *KafkaQueue) Publish(messages []Message) error {
+	msgs := make([]*sarama.ProducerMessage, 0, len(messages))
+	for _, message := range messages {
+		bs, err := json.Marshal(message)
+		if err != nil {
+			return utils.StackError(err, "Failed to marshal dead letter")
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic: q.topic,
+			Key:   sarama.StringEncoder(message.Job),
+			Value: sarama.ByteEncoder(bs),
+		})
+	}
+	if err := q.producer.SendMessages(msgs); err != nil {
+		return utils.StackError(err, "Failed to publish dead letters to topic %s", q.topic)
+	}
+	return nil
+}
+
+// Redrive redrives messages of the job and ares cluster produced to the dead letter topic since the last redrive,
+// up to the latest message when redrive starts.
+func (q *KafkaQueue) Redrive(job, cluster string, redrive func(Message) error) (redriven int, err error) {
+	partitions, err := q.client.Partitions(q.topic)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to get partitions of topic %s", q.topic)
+	}
+	consumer, err := sarama.NewConsumerFromClient(q.client)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to create consumer of topic %s", q.topic)
+	}
+	defer consumer.Close()
+	offsetManager, err := sarama.NewOffsetManagerFromClient(fmt.Sprintf("%s-%s-%s", redriveGroupPrefix, job, cluster), q.client)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to create offset manager of topic %s", q.topic)
+	}
+	// closing the offset manager commits offsets marked.
+	defer offsetManager.Close()
+
+	for _, partition := range partitions {
+		var n int
+		n, err = q.redrivePartition(consumer, offsetManager, partition, job, cluster, redrive)
+		redriven += n
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (q *KafkaQueue) redrivePartition(consumer sarama.Consumer, offsetManager sarama.OffsetManager, partition int32,
+	job, cluster string, redrive func(Message) error) (redriven int, err error) {
+	newest, err := q.client.GetOffset(q.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to get newest offset of topic %s partition %d", q.topic, partition)
+	}
+	oldest, err := q.client.GetOffset(q.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to get oldest offset of topic %s partition %d", q.topic, partition)
+	}
+	partitionOffsetManager, err := offsetManager.ManagePartition(q.topic, partition)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to get redrive offset of topic %s partition %d", q.topic, partition)
+	}
+	defer partitionOffsetManager.Close()
+
+	next, _ := partitionOffsetManager.NextOffset()
+	if next < oldest {
+		next = oldest
+	}
+	if next >= newest {
+		return 0, nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(q.topic, partition, next)
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to consume topic %s partition %d", q.topic, partition)
+	}
+	defer partitionConsumer.Close()
+
+	timer := time.NewTimer(redriveFetchTimeout)
+	defer timer.Stop()
+	for next < newest {
+		timer.Reset(redriveFetchTimeout)
+		select {
+		case msg := <-partitionConsumer.Messages():
+			var message Message
+			if err = json.Unmarshal(msg.Value, &message); err != nil {
+				// not a dead letter, skip it.
+				q.logger.Warn("Skipped malformed dead letter",
+					zap.Int32("partition", partition), zap.Int64("offset", msg.Offset), zap.Error(err))
+			} else if message.Job == job && message.Cluster == cluster {
+				if err = redrive(message); err != nil {
+					return
+				}
+				redriven++
+			}
+			next = msg.Offset + 1
+			partitionOffsetManager.MarkOffset(next, "")
+		case <-timer.C:
+			return redriven, utils.StackError(nil, "Timed out fetching dead letters of topic %s partition %d at offset %d",
+				q.topic, partition, next)
+		}
+	}
+	return redriven, nil
+}
+
+// Close closes the producer and the client
+func (q *KafkaQueue) Close() error {
+	q.producer.Close()
+	return q.client.Close()
+}
@@ -0,0 +1,129 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/Shopify/sarama"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/subscriber/config"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("kafka queue", func() {
+	topic := "ares-dlq"
+	group := redriveGroupPrefix + "-job1-cluster1"
+	serviceConfig := config.ServiceConfig{
+		Logger: zap.NewNop(),
+		DeadLetterConfig: config.DeadLetterConfig{
+			MaxAttempts: 3,
+			Topic:       topic,
+			KafkaProducerConfig: config.KafkaProducerConfig{
+				TimeoutInSec: 1,
+			},
+		},
+	}
+
+	var broker *sarama.MockBroker
+
+	encode := func(message Message) sarama.Encoder {
+		bs, err := json.Marshal(message)
+		Ω(err).Should(BeNil())
+		return sarama.ByteEncoder(bs)
+	}
+
+	BeforeEach(func() {
+		t := serviceConfig.Logger.Sugar()
+		broker = sarama.NewMockBroker(t, 1)
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader(topic, 0, broker.BrokerID()),
+			"ProduceRequest": sarama.NewMockProduceResponse(t),
+			"OffsetRequest": sarama.NewMockOffsetResponse(t).
+				SetOffset(topic, 0, sarama.OffsetOldest, 0).
+				SetOffset(topic, 0, sarama.OffsetNewest, 3),
+			"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(t).
+				SetCoordinator(group, broker),
+			"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+				SetCoordinator(sarama.CoordinatorGroup, group, broker),
+			"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+				SetOffset(group, topic, 0, -1, "", sarama.ErrNoError),
+			"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+			"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+				SetMessage(topic, 0, 0, encode(Message{Job: "job1", Cluster: "cluster1", Offset: 10})).
+				SetMessage(topic, 0, 1, encode(Message{Job: "job2", Cluster: "cluster1", Offset: 11})).
+				SetMessage(topic, 0, 2, encode(Message{Job: "job1", Cluster: "cluster1", Offset: 12})).
+				SetHighWaterMark(topic, 0, 3),
+		})
+		serviceConfig.DeadLetterConfig.KafkaProducerConfig.Brokers = broker.Addr()
+	})
+
+	AfterEach(func() {
+		broker.Close()
+	})
+
+	It("should publish and redrive messages", func() {
+		queue, err := NewQueue(serviceConfig)
+		Ω(err).Should(BeNil())
+		defer queue.Close()
+
+		Ω(queue.Publish([]Message{{Job: "job1", Cluster: "cluster1"}})).Should(BeNil())
+
+		var redriven []int64
+		n, err := queue.Redrive("job1", "cluster1", func(message Message) error {
+			if message.Offset == 12 {
+				return errors.New("still failing")
+			}
+			redriven = append(redriven, message.Offset)
+			return nil
+		})
+		Ω(err).Should(MatchError("still failing"))
+		Ω(n).Should(Equal(1))
+		Ω(redriven).Should(Equal([]int64{10}))
+
+		// redrive resumes from the committed offset.
+		t := serviceConfig.Logger.Sugar()
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader(topic, 0, broker.BrokerID()),
+			"OffsetRequest": sarama.NewMockOffsetResponse(t).
+				SetOffset(topic, 0, sarama.OffsetOldest, 0).
+				SetOffset(topic, 0, sarama.OffsetNewest, 3),
+			"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(t).
+				SetCoordinator(group, broker),
+			"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+				SetCoordinator(sarama.CoordinatorGroup, group, broker),
+			"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+				SetOffset(group, topic, 0, 2, "", sarama.ErrNoError),
+			"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+			"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+				SetMessage(topic, 0, 2, encode(Message{Job: "job1", Cluster: "cluster1", Offset: 12})).
+				SetHighWaterMark(topic, 0, 3),
+		})
+		redriven = nil
+		n, err = queue.Redrive("job1", "cluster1", func(message Message) error {
+			redriven = append(redriven, message.Offset)
+			return nil
+		})
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(1))
+		Ω(redriven).Should(Equal([]int64{12}))
+	})
+})
@@ -0,0 +1,145 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// SpillQueue writes dead letters to local files, one file of json lines per job and ares cluster
+type SpillQueue struct {
+	sync.Mutex
+	dir string
+}
+
+// NewSpillQueue creates a SpillQueue writing to dir
+func NewSpillQueue(dir string) (*SpillQueue, error) {
+	if dir == "" {
+		return nil, utils.StackError(nil, "dead letter queue requires either a topic or a spill directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, utils.StackError(err, "Failed to create dead letter spill directory %s", dir)
+	}
+	return &SpillQueue{dir: dir}, nil
+}
+
+// Publish appends the messages to files of their jobs
+func (q *SpillQueue) Publish(messages []Message) error {
+	q.Lock()
+	defer q.Unlock()
+
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, message := range messages {
+		path := q.path(message.Job, message.Cluster)
+		file, ok := files[path]
+		if !ok {
+			var err error
+			file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return utils.StackError(err, "Failed to open dead letter file %s", path)
+			}
+			files[path] = file
+		}
+		bs, err := json.Marshal(message)
+		if err != nil {
+			return utils.StackError(err, "Failed to marshal dead letter")
+		}
+		if _, err = file.Write(append(bs, '\n')); err != nil {
+			return utils.StackError(err, "Failed to write dead letter file %s", path)
+		}
+	}
+	for path, file := range files {
+		if err := file.Sync(); err != nil {
+			return utils.StackError(err, "Failed to sync dead letter file %s", path)
+		}
+	}
+	return nil
+}
+
+// Redrive redrives messages in the file of the job and ares cluster, messages not redriven are kept in the file.
+func (q *SpillQueue) Redrive(job, cluster string, redrive func(Message) error) (redriven int, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	path := q.path(job, cluster)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, utils.StackError(err, "Failed to read dead letter file %s", path)
+	}
+
+	var remaining []byte
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		if remaining == nil {
+			var message Message
+			if err = json.Unmarshal(line, &message); err != nil {
+				err = utils.StackError(err, "Failed to unmarshal dead letter in %s", path)
+			} else {
+				err = redrive(message)
+			}
+			if err == nil {
+				redriven++
+				continue
+			}
+			remaining = []byte{}
+		}
+		remaining = append(append(remaining, line...), '\n')
+	}
+
+	if redriven == 0 {
+		return 0, err
+	}
+	if len(remaining) == 0 {
+		if removeErr := os.Remove(path); removeErr != nil {
+			return redriven, utils.StackError(removeErr, "Failed to remove dead letter file %s", path)
+		}
+		return redriven, err
+	}
+	tmpPath := path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmpPath, remaining, 0644); writeErr != nil {
+		return redriven, utils.StackError(writeErr, "Failed to write dead letter file %s", tmpPath)
+	}
+	if renameErr := os.Rename(tmpPath, path); renameErr != nil {
+		return redriven, utils.StackError(renameErr, "Failed to rename dead letter file %s", tmpPath)
+	}
+	return redriven, err
+}
+
+// Close is a no-op, files are closed after each write
+func (q *SpillQueue) Close() error {
+	return nil
+}
+
+func (q *SpillQueue) path(job, cluster string) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%s_%s.dlq", url.PathEscape(job), url.PathEscape(cluster)))
+}
@@ -0,0 +1,110 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/subscriber/config"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("spill queue", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "dlq")
+		Ω(err).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("NewQueue", func() {
+		serviceConfig := config.ServiceConfig{Logger: zap.NewNop()}
+		queue, err := NewQueue(serviceConfig)
+		Ω(err).Should(BeNil())
+		Ω(queue).Should(BeNil())
+
+		serviceConfig.DeadLetterConfig.MaxAttempts = 3
+		_, err = NewQueue(serviceConfig)
+		Ω(err).ShouldNot(BeNil())
+
+		serviceConfig.DeadLetterConfig.SpillDir = dir
+		queue, err = NewQueue(serviceConfig)
+		Ω(err).Should(BeNil())
+		Ω(queue).Should(BeAssignableToTypeOf(&SpillQueue{}))
+	})
+
+	It("should publish and redrive messages", func() {
+		queue, err := NewSpillQueue(dir)
+		Ω(err).Should(BeNil())
+		defer queue.Close()
+
+		messages := []Message{
+			{Job: "job1", Cluster: "cluster1", Offset: 1, Value: []byte(`{"a": 1}`), ErrorClass: ErrorClassParse},
+			{Job: "job2", Cluster: "cluster1", Offset: 2, Value: []byte(`{"a": 2}`)},
+			{Job: "job1", Cluster: "cluster1", Offset: 3, Value: []byte(`{"a": 3}`)},
+			{Job: "job1", Cluster: "cluster1", Offset: 4, Value: []byte(`{"a": 4}`)},
+		}
+		Ω(queue.Publish(messages)).Should(BeNil())
+
+		var redriven []Message
+		n, err := queue.Redrive("job1", "cluster1", func(message Message) error {
+			if message.Offset == 3 {
+				return errors.New("still failing")
+			}
+			redriven = append(redriven, message)
+			return nil
+		})
+		Ω(err).Should(MatchError("still failing"))
+		Ω(n).Should(Equal(1))
+		Ω(redriven).Should(Equal(messages[:1]))
+
+		// messages failed to be redriven are kept in order.
+		redriven = nil
+		n, err = queue.Redrive("job1", "cluster1", func(message Message) error {
+			redriven = append(redriven, message)
+			return nil
+		})
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(2))
+		Ω(redriven).Should(Equal(messages[2:]))
+
+		n, err = queue.Redrive("job1", "cluster1", func(message Message) error {
+			return errors.New("nothing to redrive")
+		})
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+
+		redriven = nil
+		n, err = queue.Redrive("job2", "cluster1", func(message Message) error {
+			redriven = append(redriven, message)
+			return nil
+		})
+		Ω(err).Should(BeNil())
+		Ω(redriven).Should(Equal(messages[1:2]))
+
+		message := NewConsumerMessage(messages[0])
+		Ω(message.Offset()).Should(BeEquivalentTo(1))
+		Ω(message.Value()).Should(Equal(messages[0].Value))
+	})
+})
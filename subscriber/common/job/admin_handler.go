@@ -0,0 +1,120 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	apiCom "github.com/uber/aresdb/api/common"
+	"github.com/uber/aresdb/utils"
+	"go.uber.org/zap"
+)
+
+// AdminHandler serves admin requests of the subscriber
+type AdminHandler struct {
+	controller *Controller
+}
+
+// RedriveResponse is the response of dead letter redrive
+type RedriveResponse struct {
+	Redriven int `json:"redriven"`
+}
+
+// NewAdminHandler creates AdminHandler
+func NewAdminHandler(controller *Controller) *AdminHandler {
+	return &AdminHandler{
+		controller: controller,
+	}
+}
+
+// Register registers http handlers.
+func (handler *AdminHandler) Register(router *mux.Router) {
+	router.HandleFunc("/dlq/{job}/{cluster}/redrive", handler.RedriveDeadLetters).Methods(http.MethodPost)
+	router.HandleFunc("/kafka/{job}/{cluster}/switch/{target}", handler.SwitchKafkaCluster).Methods(http.MethodPost)
+}
+
+// getDriver returns the driver of the job and ares cluster in the request, or responds not found.
+func (handler *AdminHandler) getDriver(w http.ResponseWriter, r *http.Request) *Driver {
+	vars := mux.Vars(r)
+	jobName, cluster := vars["job"], vars["cluster"]
+
+	handler.controller.RLock()
+	driver := handler.controller.Drivers[jobName][cluster]
+	handler.controller.RUnlock()
+	if driver == nil {
+		apiCom.RespondWithError(w, utils.APIError{
+			Code:    http.StatusNotFound,
+			Message: fmt.Sprintf("job %s not running for cluster %s", jobName, cluster),
+		})
+	}
+	return driver
+}
+
+// RedriveDeadLetters re-ingests messages of the job and ares cluster in the dead letter queue
+func (handler *AdminHandler) RedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	driver := handler.getDriver(w, r)
+	if driver == nil {
+		return
+	}
+
+	redriven, err := driver.RedriveDeadLetters()
+	if err != nil {
+		apiCom.RespondWithError(w, utils.APIError{
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("redrove %d messages before failure: %s", redriven, err.Error()),
+			Cause:   err,
+		})
+		return
+	}
+	apiCom.RespondWithJSONObject(w, RedriveResponse{Redriven: redriven})
+}
+
+// SwitchKafkaCluster switches the job to consume from the primary or secondary Kafka cluster
+func (handler *AdminHandler) SwitchKafkaCluster(w http.ResponseWriter, r *http.Request) {
+	target := mux.Vars(r)["target"]
+	if target != "primary" && target != "secondary" {
+		apiCom.RespondWithBadRequest(w, utils.StackError(nil, "unknown Kafka cluster %s, expect primary or secondary", target))
+		return
+	}
+	driver := handler.getDriver(w, r)
+	if driver == nil {
+		return
+	}
+
+	if err := driver.SwitchKafkaCluster(target == "secondary"); err != nil {
+		apiCom.RespondWithError(w, err)
+		return
+	}
+	apiCom.RespondWithJSONObject(w, nil)
+}
+
+// StartAdminServer serves admin requests on the backend port if configured
+func StartAdminServer(c *Controller) {
+	if c.serviceConfig.BackendPort <= 0 {
+		return
+	}
+
+	router := mux.NewRouter()
+	NewAdminHandler(c).Register(router)
+	addr := fmt.Sprintf(":%d", c.serviceConfig.BackendPort)
+	c.serviceConfig.Logger.Info("Start admin server", zap.String("address", addr))
+	go func() {
+		if err := http.ListenAndServe(addr, router); err != nil {
+			c.serviceConfig.Logger.Error("Admin server stopped", zap.Error(err))
+		}
+	}()
+}
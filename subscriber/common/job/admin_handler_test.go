@@ -0,0 +1,115 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/subscriber/common/dlq"
+	"github.com/uber/aresdb/subscriber/config"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("admin handler", func() {
+	serviceConfig := config.ServiceConfig{
+		Logger: zap.NewNop(),
+		Scope:  tally.NoopScope,
+	}
+
+	var testServer *httptest.Server
+	var controller *Controller
+
+	BeforeEach(func() {
+		controller = &Controller{
+			serviceConfig: serviceConfig,
+			Drivers:       Drivers{},
+		}
+		router := mux.NewRouter()
+		NewAdminHandler(controller).Register(router)
+		testServer = httptest.NewServer(router)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	post := func(path string) (int, string) {
+		resp, err := http.Post(testServer.URL+path, "application/json", nil)
+		Ω(err).Should(BeNil())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		return resp.StatusCode, string(body)
+	}
+
+	It("should return not found for jobs not running", func() {
+		code, _ := post("/dlq/job1/dev01/redrive")
+		Ω(code).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should redrive dead letters of the job", func() {
+		dir, err := ioutil.TempDir("", "dlq")
+		Ω(err).Should(BeNil())
+		defer os.RemoveAll(dir)
+		deadLetters, err := dlq.NewSpillQueue(dir)
+		Ω(err).Should(BeNil())
+		processor := &StreamingProcessor{context: &ProcessorContext{}}
+		controller.Drivers["job1"] = map[string]*Driver{
+			"dev01": {
+				JobName:       "job1",
+				AresCluster:   "dev01",
+				serviceConfig: serviceConfig,
+				processors:    []Processor{processor},
+				deadLetters:   deadLetters,
+			},
+		}
+
+		code, body := post("/dlq/job1/dev01/redrive")
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(body).Should(MatchJSON(`{"redriven": 0}`))
+
+		// dead letter queue disabled.
+		controller.Drivers["job1"]["dev01"].deadLetters = nil
+		code, body = post("/dlq/job1/dev01/redrive")
+		Ω(code).Should(Equal(http.StatusInternalServerError))
+		Ω(body).Should(ContainSubstring("Dead letter queue is not enabled"))
+	})
+
+	It("should switch Kafka clusters of the job", func() {
+		code, _ := post("/kafka/job1/dev01/switch/tertiary")
+		Ω(code).Should(Equal(http.StatusBadRequest))
+		code, _ = post("/kafka/job1/dev01/switch/secondary")
+		Ω(code).Should(Equal(http.StatusNotFound))
+
+		controller.Drivers["job1"] = map[string]*Driver{
+			"dev01": {
+				JobName:       "job1",
+				AresCluster:   "dev01",
+				serviceConfig: serviceConfig,
+				processors:    []Processor{&StreamingProcessor{context: &ProcessorContext{}}},
+			},
+		}
+		code, body := post("/kafka/job1/dev01/switch/secondary")
+		Ω(code).Should(Equal(http.StatusInternalServerError))
+		Ω(body).Should(ContainSubstring("No secondary Kafka cluster configured"))
+	})
+})
@@ -30,8 +30,10 @@ import (
 	"github.com/m3db/m3/src/cluster/services"
 	"github.com/m3db/m3/src/x/instrument"
 	controllerCli "github.com/uber/aresdb/controller/client"
+	"github.com/uber/aresdb/subscriber/common/dlq"
 	"github.com/uber/aresdb/subscriber/common/rules"
 	"github.com/uber/aresdb/subscriber/config"
+	"github.com/uber/aresdb/utils"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
@@ -41,7 +43,7 @@ var Module = fx.Options(
 	fx.Provide(
 		NewController,
 	),
-	fx.Invoke(StartController),
+	fx.Invoke(StartController, StartAdminServer),
 )
 
 // Params defines the base objects for jobConfigs.
@@ -93,6 +95,10 @@ type Controller struct {
 	consumerInitFunc NewConsumer
 	// decoderInitFunc is func of NewDecoder
 	decoderInitFunc NewDecoder
+	// assignmentChangeWatcher watches assignment change notifications, nil if only polling
+	assignmentChangeWatcher *controllerCli.ChangeWatcher
+	// deadLetters is the dead letter queue of messages failed to be ingested, nil if disabled
+	deadLetters dlq.Queue
 }
 
 // ZKNodeSubscriber defines the information stored in ZKNode subscriber
@@ -115,7 +121,12 @@ func NewController(params Params) *Controller {
 		})
 	aresControllerClient.SetNamespace(config.ActiveJobNameSpace)
 
-	drivers, err := NewDrivers(params, aresControllerClient)
+	deadLetters, err := dlq.NewQueue(params.ServiceConfig)
+	if err != nil {
+		params.ServiceConfig.Logger.Panic("Failed to create dead letter queue", zap.Error(err))
+	}
+
+	drivers, err := NewDrivers(params, aresControllerClient, deadLetters)
 	if err != nil {
 		params.ServiceConfig.Logger.Panic("Failed to NewDrivers", zap.Error(err))
 	}
@@ -137,11 +148,20 @@ func NewController(params Params) *Controller {
 		sinkInitFunc:         params.SinkInitFunc,
 		consumerInitFunc:     params.ConsumerInitFunc,
 		decoderInitFunc:      params.DecoderInitFunc,
+		deadLetters:          deadLetters,
 	}
 
 	if params.ServiceConfig.ControllerConfig.Enable {
 		params.ServiceConfig.Logger.Info("aresDB Controller is enabled")
 
+		if params.ServiceConfig.ControllerConfig.WatchNotifications {
+			controller.assignmentChangeWatcher, err = newAssignmentChangeWatcher(params)
+			if err != nil {
+				params.ServiceConfig.Logger.Error("Failed to watch assignment change notifications, polling instead",
+					zap.Error(err))
+			}
+		}
+
 		if params.ServiceConfig.HeartbeatConfig.Enabled {
 			params.ServiceConfig.Logger.Info("heartbeat config",
 				zap.Any("heartbeat", *params.ServiceConfig.HeartbeatConfig))
@@ -202,6 +222,28 @@ func connectEtcdServices(params Params) (services.Services, error) {
 	return servicesClient, nil
 }
 
+// newAssignmentChangeWatcher creates the watcher of assignment change notifications controller writes
+// into etcd, it must be created before connectEtcdServices which changes the etcd environment.
+func newAssignmentChangeWatcher(params Params) (*controllerCli.ChangeWatcher, error) {
+	if params.ServiceConfig.EtcdConfig == nil {
+		return nil, fmt.Errorf("etcd config is required for watching assignment change notifications")
+	}
+
+	iopts := instrument.NewOptions().
+		SetLogger(params.ServiceConfig.Logger).
+		SetMetricsScope(params.ServiceConfig.Scope)
+	csClient, err := params.ServiceConfig.EtcdConfig.NewClient(iopts)
+	if err != nil {
+		return nil, err
+	}
+
+	kvStore, err := csClient.KV()
+	if err != nil {
+		return nil, err
+	}
+	return controllerCli.NewChangeWatcher(kvStore, utils.AssignmentNotificationKey(config.ActiveJobNameSpace)), nil
+}
+
 func registerHeartBeatService(params Params, servicesClient services.Services) error {
 	sid := services.NewServiceID().
 		SetEnvironment(params.ServiceConfig.EtcdConfig.Env).
@@ -501,7 +543,7 @@ func (c *Controller) startDriver(
 
 	// 2. create a new driver
 	driver, err :=
-		NewDriver(clonedJobConfig, c.serviceConfig, c.aresControllerClient, NewStreamingProcessor, c.sinkInitFunc, c.consumerInitFunc, c.decoderInitFunc)
+		NewDriver(clonedJobConfig, c.serviceConfig, c.aresControllerClient, NewStreamingProcessor, c.sinkInitFunc, c.consumerInitFunc, c.decoderInitFunc, c.deadLetters)
 	if err != nil {
 		c.serviceConfig.Logger.Error("Failed to create driver",
 			zap.String("job", jobConfig.Name),
@@ -525,15 +567,33 @@ func StartController(c *Controller) {
 	}
 
 	c.serviceConfig.Logger.Info("Start Controller")
-	ticks := time.Tick(time.Duration(c.serviceConfig.ControllerConfig.RefreshInterval) * time.Minute)
+	refreshInterval := c.serviceConfig.ControllerConfig.RefreshInterval
+	var notifications <-chan struct{}
+	if c.assignmentChangeWatcher != nil {
+		// sync up on assignment change notifications, polling is kept as a fallback.
+		if c.serviceConfig.ControllerConfig.FallbackRefreshInterval > refreshInterval {
+			refreshInterval = c.serviceConfig.ControllerConfig.FallbackRefreshInterval
+		}
+		notifications = c.assignmentChangeWatcher.C()
+		c.assignmentChangeWatcher.Start()
+	}
+
+	ticks := time.Tick(time.Duration(refreshInterval) * time.Minute)
 	go func() {
 		for {
 			select {
 			case <-ticks:
-				c.serviceConfig.Logger.Info("Start sync up with aresDB controller")
-				c.SyncUpJobConfigs()
-				c.serviceConfig.Logger.Info("Done sync up with aresDB controller")
+				c.syncUpWithController()
+			case <-notifications:
+				c.serviceConfig.Logger.Info("Received assignment change notification")
+				c.syncUpWithController()
 			}
 		}
 	}()
 }
+
+func (c *Controller) syncUpWithController() {
+	c.serviceConfig.Logger.Info("Start sync up with aresDB controller")
+	c.SyncUpJobConfigs()
+	c.serviceConfig.Logger.Info("Done sync up with aresDB controller")
+}
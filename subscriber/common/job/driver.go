@@ -24,10 +24,13 @@ import (
 	"time"
 
 	controllerCli "github.com/uber/aresdb/controller/client"
+	"github.com/uber/aresdb/subscriber/common/consumer"
+	"github.com/uber/aresdb/subscriber/common/dlq"
 
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/subscriber/common/rules"
 	"github.com/uber/aresdb/subscriber/config"
+	"github.com/uber/aresdb/utils"
 	"go.uber.org/zap"
 )
 
@@ -70,15 +73,16 @@ type Driver struct {
 	sinkInitFunc         NewSink
 	consumerInitFunc     NewConsumer
 	decoderInitFunc      NewDecoder
+	deadLetters          dlq.Queue
 }
 
 // NewProcessor is the type of function each processor that implements Processor should provide for initialization
 // This function implementation should always return a new instance of the processor
 type NewProcessor func(id int, jobConfig *rules.JobConfig, aresControllerClient controllerCli.ControllerClient, sinkInitFunc NewSink, consumerInitFunc NewConsumer, decoderInitFunc NewDecoder,
-	errors chan ProcessorError, msgSizes chan int64, serviceConfig config.ServiceConfig) (Processor, error)
+	errors chan ProcessorError, msgSizes chan int64, serviceConfig config.ServiceConfig, deadLetters dlq.Queue) (Processor, error)
 
 // NewDrivers return Drivers
-func NewDrivers(params Params, aresControllerClient controllerCli.ControllerClient) (Drivers, error) {
+func NewDrivers(params Params, aresControllerClient controllerCli.ControllerClient, deadLetters dlq.Queue) (Drivers, error) {
 	drivers := make(Drivers)
 
 	// iterate local job configs
@@ -87,7 +91,7 @@ func NewDrivers(params Params, aresControllerClient controllerCli.ControllerClie
 		aresClusterDriverTable := make(map[string]*Driver)
 		for aresCluster, jobConfig := range jobAresConfig {
 			driver, err := NewDriver(jobConfig, params.ServiceConfig, aresControllerClient, NewStreamingProcessor, params.SinkInitFunc,
-				params.ConsumerInitFunc, params.DecoderInitFunc)
+				params.ConsumerInitFunc, params.DecoderInitFunc, deadLetters)
 			if err != nil {
 				params.ServiceConfig.Logger.Error("Failed to create driver",
 					zap.String("job", jobName),
@@ -109,7 +113,7 @@ func NewDrivers(params Params, aresControllerClient controllerCli.ControllerClie
 // NewDriver will return a new Driver instance to start a ingest job
 func NewDriver(
 	jobConfig *rules.JobConfig, serviceConfig config.ServiceConfig, aresControllerClient controllerCli.ControllerClient, processorInitFunc NewProcessor, sinkInitFunc NewSink,
-	consumerInitFunc NewConsumer, decoderInitFunc NewDecoder) (*Driver, error) {
+	consumerInitFunc NewConsumer, decoderInitFunc NewDecoder, deadLetters dlq.Queue) (*Driver, error) {
 	return &Driver{
 		Topic:                jobConfig.StreamingConfig.Topic,
 		JobName:              jobConfig.Name,
@@ -140,6 +144,7 @@ func NewDriver(
 		sinkInitFunc:         sinkInitFunc,
 		consumerInitFunc:     consumerInitFunc,
 		decoderInitFunc:      decoderInitFunc,
+		deadLetters:          deadLetters,
 	}, nil
 }
 
@@ -181,7 +186,7 @@ func (d *Driver) AddProcessor() (err error) {
 	ID := int(d.processorCounter)
 
 	processor, err := d.processorInitFunc(ID, d.jobConfig, d.aresControllerClient, d.sinkInitFunc, d.consumerInitFunc, d.decoderInitFunc,
-		d.errors, d.processorMsgSizes, d.serviceConfig)
+		d.errors, d.processorMsgSizes, d.serviceConfig, d.deadLetters)
 	if err != nil {
 		d.serviceConfig.Logger.Error("Failed to initialize Processor",
 			zap.String("job", d.JobName),
@@ -493,3 +498,55 @@ func (d *Driver) Stop() {
 	}
 	d.waitGroup.Wait()
 }
+
+// RedriveDeadLetters re-ingests messages of the job in the dead letter queue using a running processor,
+// it returns the number of messages redriven.
+func (d *Driver) RedriveDeadLetters() (int, error) {
+	if d.deadLetters == nil {
+		return 0, utils.StackError(nil, "Dead letter queue is not enabled")
+	}
+
+	var processor *StreamingProcessor
+	d.RLock()
+	for _, p := range d.processors {
+		if sp, ok := p.(*StreamingProcessor); ok && !sp.GetContext().Stopped {
+			processor = sp
+			break
+		}
+	}
+	d.RUnlock()
+	if processor == nil {
+		return 0, utils.StackError(nil, "No running processor for job %s cluster %s", d.JobName, d.AresCluster)
+	}
+
+	d.serviceConfig.Logger.Info("Redriving dead letters",
+		zap.String("job", d.JobName),
+		zap.String("cluster", d.AresCluster))
+	return d.deadLetters.Redrive(d.JobName, d.AresCluster, processor.redrive)
+}
+
+// SwitchKafkaCluster switches processors of the job to consume from the secondary Kafka cluster if toSecondary
+// is true, and back to the primary Kafka cluster otherwise.
+func (d *Driver) SwitchKafkaCluster(toSecondary bool) error {
+	d.RLock()
+	defer d.RUnlock()
+
+	d.serviceConfig.Logger.Info("Switching Kafka cluster",
+		zap.String("job", d.JobName),
+		zap.String("cluster", d.AresCluster),
+		zap.Bool("toSecondary", toSecondary))
+	for _, p := range d.processors {
+		sp, ok := p.(*StreamingProcessor)
+		if !ok || sp.GetContext().Stopped {
+			continue
+		}
+		failover, ok := sp.highLevelConsumer.(consumer.Failover)
+		if !ok {
+			return utils.StackError(nil, "No secondary Kafka cluster configured for job %s", d.JobName)
+		}
+		if err := failover.SwitchCluster(toSecondary); err != nil {
+			return utils.StackError(err, "Failed to switch Kafka cluster of processor %d", sp.GetID())
+		}
+	}
+	return nil
+}
@@ -186,7 +186,7 @@ var _ = Describe("driver", func() {
 
 	It("NewDriver", func() {
 		driver, err := NewDriver(jobConfig, serviceConfig, nil, NewStreamingProcessor, sink.NewAresDatabase,
-			kafka.NewKafkaConsumer, message.NewDefaultDecoder)
+			kafka.NewKafkaConsumer, message.NewDefaultDecoder, nil)
 		Ω(driver).ShouldNot(BeNil())
 		Ω(err).Should(BeNil())
 
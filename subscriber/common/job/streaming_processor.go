@@ -26,6 +26,7 @@ import (
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/client"
 	"github.com/uber/aresdb/subscriber/common/consumer"
+	"github.com/uber/aresdb/subscriber/common/dlq"
 	"github.com/uber/aresdb/subscriber/common/message"
 	"github.com/uber/aresdb/subscriber/common/rules"
 	"github.com/uber/aresdb/subscriber/common/tools"
@@ -60,6 +61,7 @@ type StreamingProcessor struct {
 	highLevelConsumer    consumer.Consumer
 	consumerInitFunc     NewConsumer
 	parser               *message.Parser
+	pipeline             *rules.Pipeline
 	decoder              message.Decoder
 	batcher              *tools.Batcher
 	msgSizes             chan int64
@@ -67,11 +69,12 @@ type StreamingProcessor struct {
 	close                chan bool
 	errors               chan ProcessorError
 	failureHandler       FailureHandler
+	deadLetters          dlq.Queue
 }
 
 // NewStreamingProcessor returns Processor to consume, process and save data to db.
 func NewStreamingProcessor(id int, jobConfig *rules.JobConfig, aresControllerClient controllerCli.ControllerClient, sinkInitFunc NewSink, consumerInitFunc NewConsumer, decoderInitFunc NewDecoder,
-	errors chan ProcessorError, msgSizes chan int64, serviceConfig config.ServiceConfig) (Processor, error) {
+	errors chan ProcessorError, msgSizes chan int64, serviceConfig config.ServiceConfig, deadLetters dlq.Queue) (Processor, error) {
 	cluster := jobConfig.AresTableConfig.Cluster
 	// Initialize downstream DB
 	db, err := initSink(jobConfig, serviceConfig, aresControllerClient, sinkInitFunc)
@@ -98,6 +101,14 @@ func NewStreamingProcessor(id int, jobConfig *rules.JobConfig, aresControllerCli
 				jobConfig.Name, cluster))
 	}
 
+	// Compile the transformation pipeline of the job
+	pipeline, err := rules.CompilePipeline(jobConfig.Transformations)
+	if err != nil {
+		return nil, utils.StackError(err,
+			fmt.Sprintf("Unable to compile transformation pipeline for job: %s, cluster: %s",
+				jobConfig.Name, cluster))
+	}
+
 	// Initialize message parser
 	parser := message.NewParser(jobConfig, serviceConfig)
 
@@ -118,10 +129,12 @@ func NewStreamingProcessor(id int, jobConfig *rules.JobConfig, aresControllerCli
 		consumerInitFunc:     consumerInitFunc,
 		msgSizes:             msgSizes,
 		parser:               parser,
+		pipeline:             pipeline,
 		decoder:              decoder,
 		shutdown:             make(chan bool),
 		close:                make(chan bool),
 		errors:               errors,
+		deadLetters:          deadLetters,
 		context: &ProcessorContext{
 			StartTime: time.Now(),
 			Errors: processorErrors{
@@ -337,10 +350,13 @@ func (s *StreamingProcessor) Run() {
 
 				// decode message and add to batcher for parse and save
 				message, err := s.decodeMessage(msg)
-				message.MsgInSubTS = msgInSubTS
 				if err == nil {
+					message.MsgInSubTS = msgInSubTS
 					s.batcher.Add(message, time.Now())
 					s.reportMessageAge(message)
+				} else if s.deadLetters != nil {
+					s.publishDeadLetters(dlq.ErrorClassDecode,
+						[]dlq.Message{s.newDeadLetter(msg, dlq.ErrorClassDecode, err, 1)})
 				}
 			} else {
 				s.scope.Counter("errors.kafka.nilMessages").Inc(1)
@@ -407,35 +423,86 @@ func (s *StreamingProcessor) saveToDestination(batch []interface{}, destination
 	s.scope.Gauge("batcherBatchSize").Update(float64(len(batch)))
 
 	rows := []client.Row{}
+	msgs := []*message.Message{}
+	var deadLetters []dlq.Message
 	for _, b := range batch {
-		msg := b.(*message.Message).DecodedMessage[message.MsgPrefix].(map[string]interface{})
-		if s.parser.IsMessageValid(msg, destination) != nil {
-			s.serviceConfig.Logger.Debug("Invalid message", zap.Any("msg", msg))
+		msg := b.(*message.Message)
+		decoded := msg.DecodedMessage[message.MsgPrefix].(map[string]interface{})
+		keep, err := s.transform(decoded)
+		if err != nil {
+			s.context.Lock()
+			s.context.FailedMessages++
+			s.context.LastUpdated = time.Now()
+			s.context.Unlock()
+			if s.deadLetters != nil {
+				s.publishDeadLetters(dlq.ErrorClassTransform,
+					[]dlq.Message{s.newDeadLetter(msg.RawMessage, dlq.ErrorClassTransform, err, 1)})
+			}
+			continue
+		}
+		if !keep {
 			continue
 		}
-		row, err := s.parser.ParseMessage(msg, destination)
-		if err == nil &&
-			s.parser.CheckPrimaryKeys(destination, row) == nil &&
-			s.parser.CheckTimeColumnExistence(
-				s.jobConfig.AresTableConfig.Table, s.jobConfig.GetColumnDict(), destination, row) == nil {
+		if s.parser.IsMessageValid(decoded, destination) != nil {
+			s.serviceConfig.Logger.Debug("Invalid message", zap.Any("msg", decoded))
+			continue
+		}
+		row, err := s.parseRow(decoded, destination)
+		if err == nil {
 			rows = append(rows, row)
+			msgs = append(msgs, msg)
 		} else {
 			s.context.Lock()
 			s.context.FailedMessages++
 			s.context.LastUpdated = time.Now()
 			s.context.Unlock()
+			if s.deadLetters != nil {
+				deadLetters = append(deadLetters, s.newDeadLetter(msg.RawMessage, dlq.ErrorClassParse, err, 1))
+			}
 		}
 	}
+	if len(deadLetters) > 0 {
+		s.publishDeadLetters(dlq.ErrorClassParse, deadLetters)
+	}
 
 	size := len(batch)
 	if size > 0 {
 		s.scope.Timer("lag.ingestion").Record(time.Now().Sub(batch[size-1].(*message.Message).MsgInSubTS))
-		s.writeRow(rows, destination)
+		s.writeRow(rows, msgs, destination)
 	}
 
 }
 
-func (s *StreamingProcessor) writeRow(rows []client.Row, destination sink.Destination) {
+// transform applies the transformation pipeline of the job to the decoded message,
+// it returns false if the message is dropped by the pipeline.
+func (s *StreamingProcessor) transform(msg map[string]interface{}) (bool, error) {
+	keep, err := s.pipeline.Apply(msg)
+	if err != nil {
+		s.serviceConfig.Logger.Error("Unable to transform message",
+			zap.String("job", s.jobConfig.Name),
+			zap.String("cluster", s.cluster),
+			zap.Error(err))
+		s.scope.Counter("errors.messageTransform").Inc(1)
+	} else if !keep {
+		s.scope.Counter("message.dropped").Inc(1)
+	}
+	return keep, err
+}
+
+// parseRow parses the decoded message into a row of the destination and validates the row
+func (s *StreamingProcessor) parseRow(msg map[string]interface{}, destination sink.Destination) (client.Row, error) {
+	row, err := s.parser.ParseMessage(msg, destination)
+	if err == nil {
+		err = s.parser.CheckPrimaryKeys(destination, row)
+	}
+	if err == nil {
+		err = s.parser.CheckTimeColumnExistence(
+			s.jobConfig.AresTableConfig.Table, s.jobConfig.GetColumnDict(), destination, row)
+	}
+	return row, err
+}
+
+func (s *StreamingProcessor) writeRow(rows []client.Row, msgs []*message.Message, destination sink.Destination) {
 	err := s.sink.Save(destination, rows)
 	if err != nil {
 		s.serviceConfig.Logger.Error(
@@ -444,7 +511,9 @@ func (s *StreamingProcessor) writeRow(rows []client.Row, destination sink.Destin
 			zap.String("cluster", s.cluster),
 			zap.String("name", message.GetFuncName()),
 			zap.Error(err))
-		if s.failureHandler != nil {
+		if s.deadLetters != nil {
+			err = s.saveOrDeadLetter(destination, rows, msgs, err)
+		} else if s.failureHandler != nil {
 			err = s.failureHandler.HandleFailure(destination, rows)
 		}
 		if err != nil {
@@ -466,6 +535,108 @@ func (s *StreamingProcessor) writeRow(rows []client.Row, destination sink.Destin
 	s.context.Unlock()
 }
 
+// saveOrDeadLetter retries saving rows of the failed batch one by one, rows still failing after all attempts are
+// sent to the dead letter queue so that the partition advances.
+func (s *StreamingProcessor) saveOrDeadLetter(
+	destination sink.Destination, rows []client.Row, msgs []*message.Message, batchErr error) error {
+	cfg := s.serviceConfig.DeadLetterConfig
+	errs := make([]error, len(rows))
+	pending := make([]int, len(rows))
+	for i := range rows {
+		errs[i] = batchErr
+		pending[i] = i
+	}
+
+	// the batch save is the first attempt.
+	for attempt := 2; attempt <= cfg.MaxAttempts && len(pending) > 0; attempt++ {
+		time.Sleep(time.Duration(cfg.RetryIntervalInMS) * time.Millisecond)
+		s.scope.Counter("dlq.retries").Inc(1)
+		failed := pending[:0]
+		for _, i := range pending {
+			if errs[i] = s.sink.Save(destination, rows[i:i+1]); errs[i] != nil {
+				failed = append(failed, i)
+			}
+		}
+		pending = failed
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	deadLetters := make([]dlq.Message, 0, len(pending))
+	for _, i := range pending {
+		deadLetters = append(deadLetters,
+			s.newDeadLetter(msgs[i].RawMessage, dlq.ErrorClassSave, errs[i], cfg.MaxAttempts))
+	}
+	if err := s.publishDeadLetters(dlq.ErrorClassSave, deadLetters); err != nil {
+		return err
+	}
+	s.context.Lock()
+	s.context.FailedMessages += int64(len(pending))
+	s.context.Unlock()
+	return nil
+}
+
+// newDeadLetter creates the dead letter of the message failed to be ingested
+func (s *StreamingProcessor) newDeadLetter(msg consumer.Message, errorClass string, err error, attempts int) dlq.Message {
+	return dlq.Message{
+		Job:        s.jobConfig.Name,
+		Cluster:    s.cluster,
+		Table:      s.jobConfig.AresTableConfig.Table.Name,
+		Topic:      msg.Topic(),
+		Partition:  msg.Partition(),
+		Offset:     msg.Offset(),
+		Key:        msg.Key(),
+		Value:      msg.Value(),
+		Error:      err.Error(),
+		ErrorClass: errorClass,
+		Attempts:   attempts,
+		Timestamp:  time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// publishDeadLetters sends the dead letters to the dead letter queue
+func (s *StreamingProcessor) publishDeadLetters(errorClass string, deadLetters []dlq.Message) error {
+	scope := s.scope.Tagged(map[string]string{
+		"table":      s.jobConfig.AresTableConfig.Table.Name,
+		"errorClass": errorClass,
+	})
+	if err := s.deadLetters.Publish(deadLetters); err != nil {
+		s.serviceConfig.Logger.Error("Unable to publish dead letters",
+			zap.String("job", s.jobConfig.Name),
+			zap.String("cluster", s.cluster),
+			zap.String("errorClass", errorClass),
+			zap.Error(err))
+		scope.Counter("errors.dlq.publish").Inc(1)
+		return err
+	}
+	scope.Counter("dlq.published").Inc(int64(len(deadLetters)))
+	return nil
+}
+
+// redrive re-ingests the dead letter, it fails if the message still can't be ingested.
+func (s *StreamingProcessor) redrive(deadLetter dlq.Message) error {
+	msg, err := s.decoder.DecodeMsg(dlq.NewConsumerMessage(deadLetter))
+	if err != nil {
+		return utils.StackError(err, "Failed to decode dead letter at offset %d", deadLetter.Offset)
+	}
+	destination := s.parser.Destination
+	decoded := msg.DecodedMessage[message.MsgPrefix].(map[string]interface{})
+	keep, err := s.transform(decoded)
+	if err != nil {
+		return utils.StackError(err, "Failed to transform dead letter at offset %d", deadLetter.Offset)
+	}
+	if !keep || s.parser.IsMessageValid(decoded, destination) != nil {
+		// filtered out by the job, nothing to ingest.
+		return nil
+	}
+	row, err := s.parseRow(decoded, destination)
+	if err != nil {
+		return utils.StackError(err, "Failed to parse dead letter at offset %d", deadLetter.Offset)
+	}
+	return s.sink.Save(destination, []client.Row{row})
+}
+
 // saveToDB will parse and save given batches
 func (s *StreamingProcessor) saveToDB(batches chan []interface{}, wg *sync.WaitGroup) {
 	for batch := range batches {
@@ -30,9 +30,11 @@ import (
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/client"
 	"github.com/uber/aresdb/client/mocks"
+	"github.com/uber/aresdb/controller/models"
 	memCom "github.com/uber/aresdb/memstore/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	kafka2 "github.com/uber/aresdb/subscriber/common/consumer/kafka"
+	"github.com/uber/aresdb/subscriber/common/dlq"
 	"github.com/uber/aresdb/subscriber/common/message"
 	"github.com/uber/aresdb/subscriber/common/rules"
 	"github.com/uber/aresdb/subscriber/common/sink"
@@ -237,7 +239,7 @@ var _ = Describe("streaming_processor", func() {
 	})
 	It("NewStreamingProcessor", func() {
 		p, err := NewStreamingProcessor(1, jobConfig, nil, sink.NewAresDatabase, kafka2.NewKafkaConsumer, message.NewDefaultDecoder,
-			make(chan ProcessorError), make(chan int64), serviceConfig)
+			make(chan ProcessorError), make(chan int64), serviceConfig, nil)
 		Ω(p).Should(BeNil())
 		Ω(err).ShouldNot(BeNil())
 
@@ -249,7 +251,7 @@ var _ = Describe("streaming_processor", func() {
 			"dev01": sinkConfig,
 		}
 		p, err = NewStreamingProcessor(1, jobConfig, nil, sink.NewAresDatabase, kafka2.NewKafkaConsumer, message.NewDefaultDecoder,
-			make(chan ProcessorError), make(chan int64), serviceConfig)
+			make(chan ProcessorError), make(chan int64), serviceConfig, nil)
 		Ω(p).ShouldNot(BeNil())
 		Ω(p.(*StreamingProcessor).highLevelConsumer).ShouldNot(BeNil())
 		Ω(p.(*StreamingProcessor).sink).ShouldNot(BeNil())
@@ -324,6 +326,75 @@ var _ = Describe("streaming_processor", func() {
 		go p.Run()
 		p.Stop()
 	})
+	It("applies the transformation pipeline before parsing rows", func() {
+		pipeline, err := rules.CompilePipeline([]models.TransformationStep{
+			{Type: rules.StepRename, From: "id", Field: "c1"},
+			{Type: rules.StepDropIf, Field: "env", Operator: "eq", Value: "test"},
+			{Type: rules.StepDefault, Field: "c2", Value: "none"},
+			{Name: "toSeconds", Type: rules.StepEpochMillisToSeconds, Field: "c3"},
+		})
+		Ω(err).Should(BeNil())
+
+		dir, err := ioutil.TempDir("", "dlq")
+		Ω(err).Should(BeNil())
+		defer os.RemoveAll(dir)
+		deadLetters, err := dlq.NewSpillQueue(dir)
+		Ω(err).Should(BeNil())
+
+		connector := mocks.Connector{}
+		parser := message.NewParser(jobConfig, serviceConfig)
+		parser.Transformations = map[string]*rules.TransformationConfig{
+			"c1": {},
+			"c2": {},
+			"c3": {},
+		}
+		p := &StreamingProcessor{
+			jobConfig:     jobConfig,
+			cluster:       "dev01",
+			serviceConfig: serviceConfig,
+			scope:         tally.NoopScope,
+			parser:        parser,
+			pipeline:      pipeline,
+			deadLetters:   deadLetters,
+			sink: &sink.AresDatabase{
+				ServiceConfig: serviceConfig,
+				Scope:         tally.NoopScope,
+				ClusterName:   "dev01",
+				Connector:     &connector,
+				JobConfig:     jobConfig,
+			},
+			context: &ProcessorContext{},
+		}
+
+		newMessage := func(decoded map[string]interface{}) *message.Message {
+			return &message.Message{
+				MsgInSubTS:     time.Now(),
+				MsgMetaDataTS:  time.Now(),
+				RawMessage:     msg,
+				DecodedMessage: map[string]interface{}{"msg": decoded},
+			}
+		}
+		batch := []interface{}{
+			newMessage(map[string]interface{}{"id": "v11", "c3": 1570000000123.0}),
+			newMessage(map[string]interface{}{"id": "v21", "c2": "v22", "env": "test"}),
+			newMessage(map[string]interface{}{"id": "v31", "c2": "v32", "c3": "abc"}),
+		}
+		connector.On("Insert", table, columnNames,
+			[]client.Row{{"v11", "none", int64(1570000000)}}).Return(1, nil)
+		p.saveToDestination(batch, destination)
+		connector.AssertExpectations(GinkgoT())
+		Ω(p.context.FailedMessages).Should(Equal(int64(1)))
+
+		var dead []dlq.Message
+		_, err = deadLetters.Redrive(jobConfig.Name, "dev01", func(deadLetter dlq.Message) error {
+			dead = append(dead, deadLetter)
+			return nil
+		})
+		Ω(err).Should(BeNil())
+		Ω(dead).Should(HaveLen(1))
+		Ω(dead[0].ErrorClass).Should(Equal(dlq.ErrorClassTransform))
+		Ω(dead[0].Error).Should(ContainSubstring("toSeconds"))
+	})
 	It("HandleFailure", func() {
 		failureHandler := initFailureHandler(serviceConfig, jobConfig, aresDB)
 		failureHandler.(*RetryFailureHandler).interval = 1
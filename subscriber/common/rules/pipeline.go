@@ -0,0 +1,323 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/controller/models"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// StepRename moves the value of From to Field
+	StepRename = "rename"
+	// StepDefault sets Field to Value if it is missing or null
+	StepDefault = "default"
+	// StepCast converts Field to the type To
+	StepCast = "cast"
+	// StepEpochMillisToSeconds converts Field from epoch milliseconds to epoch seconds
+	StepEpochMillisToSeconds = "epochMillisToSeconds"
+	// StepConcat sets Field to Template with {field} references replaced by field values
+	StepConcat = "concat"
+	// StepDropIf drops the message if Field matches Operator and Value
+	StepDropIf = "dropIf"
+)
+
+var templateFieldRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// stepFunc transforms the message in place, it returns false if the message should be dropped.
+type stepFunc func(msg map[string]interface{}) (bool, error)
+
+// pipelineStep is a compiled transformation step
+type pipelineStep struct {
+	name  string
+	apply stepFunc
+}
+
+// Pipeline is the compiled transformation pipeline of a job
+type Pipeline struct {
+	steps []pipelineStep
+}
+
+// TransformationError is the error of a failed transformation step
+type TransformationError struct {
+	// Step is the name of the failed step
+	Step  string
+	Cause error
+}
+
+// Error implements error interface
+func (e TransformationError) Error() string {
+	return fmt.Sprintf("transformation step %s failed: %s", e.Step, e.Cause.Error())
+}
+
+// CompilePipeline compiles the transformation steps into a pipeline, it returns nil if there is no step.
+func CompilePipeline(steps []models.TransformationStep) (*Pipeline, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	pipeline := &Pipeline{steps: make([]pipelineStep, 0, len(steps))}
+	for i, step := range steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("%s#%d", step.Type, i)
+		}
+		apply, err := compileStep(step)
+		if err != nil {
+			return nil, utils.StackError(err, "Invalid transformation step %s", name)
+		}
+		pipeline.steps = append(pipeline.steps, pipelineStep{name: name, apply: apply})
+	}
+	return pipeline, nil
+}
+
+// Apply transforms the decoded message in place, it returns false if the message should be dropped.
+// Errors are TransformationError naming the failed step.
+func (p *Pipeline) Apply(msg map[string]interface{}) (bool, error) {
+	if p == nil {
+		return true, nil
+	}
+	for _, step := range p.steps {
+		keep, err := step.apply(msg)
+		if err != nil {
+			return false, TransformationError{Step: step.name, Cause: err}
+		}
+		if !keep {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compileStep(step models.TransformationStep) (stepFunc, error) {
+	if step.Field == "" {
+		return nil, utils.StackError(nil, "field is required")
+	}
+
+	switch step.Type {
+	case StepRename:
+		if step.From == "" {
+			return nil, utils.StackError(nil, "from is required")
+		}
+		return renameStep(step.From, step.Field), nil
+	case StepDefault:
+		return defaultStep(step.Field, step.Value), nil
+	case StepCast:
+		return castStep(step.Field, step.To)
+	case StepEpochMillisToSeconds:
+		return epochMillisToSecondsStep(step.Field), nil
+	case StepConcat:
+		return concatStep(step.Field, step.Template)
+	case StepDropIf:
+		return dropIfStep(step.Field, step.Operator, step.Value)
+	}
+	return nil, utils.StackError(nil, "unknown transformation type %s", step.Type)
+}
+
+func renameStep(from, to string) stepFunc {
+	return func(msg map[string]interface{}) (bool, error) {
+		if value, ok := msg[from]; ok {
+			delete(msg, from)
+			msg[to] = value
+		}
+		return true, nil
+	}
+}
+
+func defaultStep(field, value string) stepFunc {
+	return func(msg map[string]interface{}) (bool, error) {
+		if msg[field] == nil {
+			msg[field] = value
+		}
+		return true, nil
+	}
+}
+
+func castStep(field, to string) (stepFunc, error) {
+	var cast func(value interface{}) (interface{}, error)
+	switch to {
+	case "string":
+		cast = func(value interface{}) (interface{}, error) {
+			return toString(value), nil
+		}
+	case "int":
+		cast = func(value interface{}) (interface{}, error) {
+			return toInt(value)
+		}
+	case "float":
+		cast = func(value interface{}) (interface{}, error) {
+			return toFloat(value)
+		}
+	case "bool":
+		cast = func(value interface{}) (interface{}, error) {
+			switch v := value.(type) {
+			case bool:
+				return v, nil
+			case string:
+				return strconv.ParseBool(v)
+			}
+			f, err := toFloat(value)
+			return f != 0, err
+		}
+	default:
+		return nil, utils.StackError(nil, "unknown cast type %s", to)
+	}
+
+	return func(msg map[string]interface{}) (bool, error) {
+		value := msg[field]
+		if value == nil {
+			return true, nil
+		}
+		casted, err := cast(value)
+		if err != nil {
+			return false, err
+		}
+		msg[field] = casted
+		return true, nil
+	}, nil
+}
+
+func epochMillisToSecondsStep(field string) stepFunc {
+	return func(msg map[string]interface{}) (bool, error) {
+		value := msg[field]
+		if value == nil {
+			return true, nil
+		}
+		millis, err := toInt(value)
+		if err != nil {
+			return false, err
+		}
+		msg[field] = millis / 1000
+		return true, nil
+	}
+}
+
+func concatStep(field, template string) (stepFunc, error) {
+	if template == "" {
+		return nil, utils.StackError(nil, "template is required")
+	}
+
+	// split the template into literals and field references once, literals are at even positions.
+	var parts []string
+	last := 0
+	for _, match := range templateFieldRegex.FindAllStringSubmatchIndex(template, -1) {
+		parts = append(parts, template[last:match[0]], template[match[2]:match[3]])
+		last = match[1]
+	}
+	parts = append(parts, template[last:])
+
+	return func(msg map[string]interface{}) (bool, error) {
+		var sb strings.Builder
+		for i, part := range parts {
+			if i%2 == 0 {
+				sb.WriteString(part)
+			} else if value := msg[part]; value != nil {
+				sb.WriteString(toString(value))
+			}
+		}
+		msg[field] = sb.String()
+		return true, nil
+	}, nil
+}
+
+func dropIfStep(field, operator, value string) (stepFunc, error) {
+	var drop func(v interface{}, ok bool) bool
+	switch operator {
+	case "eq":
+		drop = func(v interface{}, ok bool) bool { return ok && v != nil && toString(v) == value }
+	case "ne":
+		drop = func(v interface{}, ok bool) bool { return !ok || v == nil || toString(v) != value }
+	case "exists":
+		drop = func(v interface{}, ok bool) bool { return ok && v != nil }
+	case "missing":
+		drop = func(v interface{}, ok bool) bool { return !ok || v == nil }
+	default:
+		return nil, utils.StackError(nil, "unknown dropIf operator %s", operator)
+	}
+
+	return func(msg map[string]interface{}) (bool, error) {
+		v, ok := msg[field]
+		return !drop(v, ok), nil
+	}, nil
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func toInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		return int64(f), err
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, utils.StackError(nil, "%q is not a number", v)
+		}
+		return int64(f), nil
+	}
+	return 0, utils.StackError(nil, "%v of type %T is not a number", value, value)
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, utils.StackError(nil, "%q is not a number", v)
+		}
+		return f, nil
+	}
+	return 0, utils.StackError(nil, "%v of type %T is not a number", value, value)
+}
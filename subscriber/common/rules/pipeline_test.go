@@ -0,0 +1,184 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/controller/models"
+)
+
+var _ = Describe("Pipeline", func() {
+	apply := func(step models.TransformationStep, msg map[string]interface{}) (bool, error) {
+		pipeline, err := CompilePipeline([]models.TransformationStep{step})
+		Ω(err).Should(BeNil())
+		return pipeline.Apply(msg)
+	}
+
+	It("CompilePipeline", func() {
+		pipeline, err := CompilePipeline(nil)
+		Ω(err).Should(BeNil())
+		Ω(pipeline).Should(BeNil())
+		keep, err := pipeline.Apply(map[string]interface{}{})
+		Ω(err).Should(BeNil())
+		Ω(keep).Should(BeTrue())
+
+		for _, step := range []models.TransformationStep{
+			{Type: StepRename, Field: "a"},
+			{Type: StepRename, From: "a"},
+			{Type: StepCast, Field: "a", To: "date"},
+			{Type: StepConcat, Field: "a"},
+			{Type: StepDropIf, Field: "a", Operator: "gt"},
+			{Type: "unknown", Field: "a"},
+		} {
+			_, err = CompilePipeline([]models.TransformationStep{step})
+			Ω(err).ShouldNot(BeNil())
+		}
+	})
+
+	It("rename", func() {
+		msg := map[string]interface{}{"a": 1}
+		keep, err := apply(models.TransformationStep{Type: StepRename, From: "a", Field: "b"}, msg)
+		Ω(err).Should(BeNil())
+		Ω(keep).Should(BeTrue())
+		Ω(msg).Should(Equal(map[string]interface{}{"b": 1}))
+
+		msg = map[string]interface{}{"c": 1}
+		keep, err = apply(models.TransformationStep{Type: StepRename, From: "a", Field: "b"}, msg)
+		Ω(err).Should(BeNil())
+		Ω(keep).Should(BeTrue())
+		Ω(msg).Should(Equal(map[string]interface{}{"c": 1}))
+	})
+
+	It("default", func() {
+		msg := map[string]interface{}{"a": nil, "b": "x"}
+		step := models.TransformationStep{Type: StepDefault, Field: "a", Value: "y"}
+		_, err := apply(step, msg)
+		Ω(err).Should(BeNil())
+		step.Field = "b"
+		_, err = apply(step, msg)
+		Ω(err).Should(BeNil())
+		step.Field = "c"
+		_, err = apply(step, msg)
+		Ω(err).Should(BeNil())
+		Ω(msg).Should(Equal(map[string]interface{}{"a": "y", "b": "x", "c": "y"}))
+	})
+
+	It("cast", func() {
+		msg := map[string]interface{}{
+			"s": 1.5,
+			"i": "42",
+			"f": json.Number("2.5"),
+			"b": "true",
+			"n": 0.0,
+		}
+		for field, to := range map[string]string{"s": "string", "i": "int", "f": "float", "b": "bool", "n": "bool"} {
+			_, err := apply(models.TransformationStep{Type: StepCast, Field: field, To: to}, msg)
+			Ω(err).Should(BeNil())
+		}
+		Ω(msg).Should(Equal(map[string]interface{}{
+			"s": "1.5",
+			"i": int64(42),
+			"f": 2.5,
+			"b": true,
+			"n": false,
+		}))
+
+		_, err := apply(models.TransformationStep{Type: StepCast, Field: "x", To: "int"}, msg)
+		Ω(err).Should(BeNil())
+		Ω(msg).ShouldNot(HaveKey("x"))
+
+		msg["x"] = "abc"
+		_, err = apply(models.TransformationStep{Name: "castX", Type: StepCast, Field: "x", To: "int"}, msg)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.(TransformationError).Step).Should(Equal("castX"))
+	})
+
+	It("epochMillisToSeconds", func() {
+		msg := map[string]interface{}{"ts": 1570000000123.0, "str": "1570000000999"}
+		step := models.TransformationStep{Type: StepEpochMillisToSeconds, Field: "ts"}
+		_, err := apply(step, msg)
+		Ω(err).Should(BeNil())
+		step.Field = "str"
+		_, err = apply(step, msg)
+		Ω(err).Should(BeNil())
+		Ω(msg).Should(Equal(map[string]interface{}{"ts": int64(1570000000), "str": int64(1570000000)}))
+
+		msg["ts"] = true
+		_, err = apply(models.TransformationStep{Type: StepEpochMillisToSeconds, Field: "ts"}, msg)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.(TransformationError).Step).Should(Equal("epochMillisToSeconds#0"))
+	})
+
+	It("concat", func() {
+		msg := map[string]interface{}{"city": "sf", "id": 12.0}
+		step := models.TransformationStep{Type: StepConcat, Field: "key", Template: "{city}-{id}-{missing}!"}
+		_, err := apply(step, msg)
+		Ω(err).Should(BeNil())
+		Ω(msg["key"]).Should(Equal("sf-12-!"))
+	})
+
+	It("dropIf", func() {
+		msg := map[string]interface{}{"status": "test", "count": 1.0}
+		for _, tc := range []struct {
+			step models.TransformationStep
+			keep bool
+		}{
+			{models.TransformationStep{Field: "status", Operator: "eq", Value: "test"}, false},
+			{models.TransformationStep{Field: "count", Operator: "eq", Value: "1"}, false},
+			{models.TransformationStep{Field: "status", Operator: "eq", Value: "prod"}, true},
+			{models.TransformationStep{Field: "status", Operator: "ne", Value: "prod"}, false},
+			{models.TransformationStep{Field: "missing", Operator: "ne", Value: "prod"}, false},
+			{models.TransformationStep{Field: "status", Operator: "exists"}, false},
+			{models.TransformationStep{Field: "missing", Operator: "exists"}, true},
+			{models.TransformationStep{Field: "missing", Operator: "missing"}, false},
+			{models.TransformationStep{Field: "status", Operator: "missing"}, true},
+		} {
+			tc.step.Type = StepDropIf
+			keep, err := apply(tc.step, msg)
+			Ω(err).Should(BeNil())
+			Ω(keep).Should(Equal(tc.keep))
+		}
+	})
+
+	It("applies steps in order", func() {
+		pipeline, err := CompilePipeline([]models.TransformationStep{
+			{Type: StepRename, From: "event_time_ms", Field: "event_time"},
+			{Type: StepEpochMillisToSeconds, Field: "event_time"},
+			{Type: StepDropIf, Field: "event_time", Operator: "missing"},
+			{Type: StepDefault, Field: "city", Value: "unknown"},
+			{Type: StepConcat, Field: "key", Template: "{city}:{event_time}"},
+		})
+		Ω(err).Should(BeNil())
+
+		msg := map[string]interface{}{"event_time_ms": 1570000000123.0}
+		keep, err := pipeline.Apply(msg)
+		Ω(err).Should(BeNil())
+		Ω(keep).Should(BeTrue())
+		Ω(msg).Should(Equal(map[string]interface{}{
+			"event_time": int64(1570000000),
+			"city":       "unknown",
+			"key":        "unknown:1570000000",
+		}))
+
+		msg = map[string]interface{}{"city": "sf"}
+		keep, err = pipeline.Apply(msg)
+		Ω(err).Should(BeNil())
+		Ω(keep).Should(BeFalse())
+		Ω(msg).ShouldNot(HaveKey("key"))
+	})
+})
@@ -83,6 +83,7 @@ type ServiceConfig struct {
 	ZooKeeperConfig    ZooKeeperConfig       `yaml:"zookeeper"`
 	EtcdConfig         *etcd.Configuration   `yaml:"etcd"`
 	HeartbeatConfig    *HeartBeatConfig      `yaml:"heartbeat"`
+	DeadLetterConfig   DeadLetterConfig      `yaml:"deadLetter"`
 }
 
 // HeartBeatConfig represents heartbeat config
@@ -93,6 +94,21 @@ type HeartBeatConfig struct {
 	CheckInterval int  `yaml:"checkInterval"`
 }
 
+// DeadLetterConfig defines the dead letter queue of records failed to be ingested
+type DeadLetterConfig struct {
+	// MaxAttempts is the number of attempts to save a record before it's sent to the dead letter queue,
+	// the dead letter queue is disabled if it's 0
+	MaxAttempts int `yaml:"maxAttempts"`
+	// RetryIntervalInMS is the interval between attempts to save records
+	RetryIntervalInMS int `yaml:"retryIntervalInMS" default:"1000"`
+	// Topic is the Kafka topic of the dead letter queue
+	Topic string `yaml:"topic"`
+	// KafkaProducerConfig defines Kafka producer config of the dead letter queue topic
+	KafkaProducerConfig KafkaProducerConfig `yaml:"kafkaProducer"`
+	// SpillDir is the local directory dead letters are written to if no topic is configured
+	SpillDir string `yaml:"spillDir"`
+}
+
 // SinkMode defines the subscriber sink mode
 type SinkMode int
 
@@ -150,6 +166,10 @@ type ControllerConfig struct {
 	Timeout int `yaml:"timeout" default:"30"`
 	// RefreshInterval is the interval to sync up with aresDB controller in minutes
 	RefreshInterval int `yaml:"refreshInterval" default:"10"`
+	// WatchNotifications defines whether to watch assignment change notifications in etcd to sync up immediately
+	WatchNotifications bool `yaml:"watchNotifications" default:"false"`
+	// FallbackRefreshInterval is the interval to sync up with aresDB controller in minutes when watching notifications
+	FallbackRefreshInterval int `yaml:"fallbackRefreshInterval" default:"60"`
 	// ServiceName is aresDB controller name
 	ServiceName string `yaml:"serviceName" default:"ares-controller"`
 }
@@ -15,6 +15,8 @@
 package utils
 
 import (
+	"context"
+
 	"github.com/spf13/viper"
 	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/common"
@@ -60,6 +62,14 @@ func GetLogger() common.Logger {
 	return logger
 }
 
+// GetContextLogger returns the logger with the request id of the context if any.
+func GetContextLogger(ctx context.Context) common.Logger {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return logger.With("requestID", requestID)
+	}
+	return logger
+}
+
 // GetQueryLogger returns the logger for query.
 func GetQueryLogger() common.Logger {
 	return queryLogger
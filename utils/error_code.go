@@ -0,0 +1,226 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrorCode is the machine readable code of errors returned to clients.
+type ErrorCode string
+
+// Error codes registered by default.
+const (
+	// ErrCodeBadRequest means the request is malformed, e.g. invalid json or missing parameters.
+	ErrCodeBadRequest ErrorCode = "BAD_REQUEST"
+	// ErrCodeInvalidQuery means the query cannot be compiled, e.g. unknown tables or columns.
+	ErrCodeInvalidQuery ErrorCode = "INVALID_QUERY"
+	// ErrCodeSchemaMismatch means the query references tables or columns unknown to the schema of the server,
+	// either a genuine invalid query, or the query is compiled against a different schema version.
+	ErrCodeSchemaMismatch ErrorCode = "SCHEMA_MISMATCH"
+	// ErrCodeInvalidCursor means the pagination cursor is malformed, expired or no longer valid for the query
+	// or the cluster, pagination needs to restart from the first page.
+	ErrCodeInvalidCursor ErrorCode = "INVALID_CURSOR"
+	// ErrCodeForbidden means the caller is not allowed to access the data.
+	ErrCodeForbidden ErrorCode = "FORBIDDEN"
+	// ErrCodeRequestTooLarge means the request exceeds the size limit.
+	ErrCodeRequestTooLarge ErrorCode = "REQUEST_TOO_LARGE"
+	// ErrCodeTooManyRequests means the server is serving too many requests to accept more now.
+	ErrCodeTooManyRequests ErrorCode = "TOO_MANY_REQUESTS"
+	// ErrCodeResourceExhausted means there is no resource (e.g. device memory) to run the query now.
+	ErrCodeResourceExhausted ErrorCode = "RESOURCE_EXHAUSTED"
+	// ErrCodeClusterDegraded means some shards have no datanodes to serve them.
+	ErrCodeClusterDegraded ErrorCode = "CLUSTER_DEGRADED"
+	// ErrCodeDataNodeFailure means queries to some datanodes failed.
+	ErrCodeDataNodeFailure ErrorCode = "DATANODE_FAILURE"
+	// ErrCodeShardNotOwned means the query is sent to a datanode not owning its shards, e.g. routed by the
+	// topology of broker lagging behind shard movements, other replicas of the shards can be tried instead.
+	ErrCodeShardNotOwned ErrorCode = "SHARD_NOT_OWNED"
+	// ErrCodeTimeout means the query did not finish before its deadline, e.g. waiting on slow datanodes.
+	ErrCodeTimeout ErrorCode = "TIMEOUT"
+	// ErrCodeCanceled means the query was canceled by operators while running.
+	ErrCodeCanceled ErrorCode = "CANCELED"
+	// ErrCodeUnavailable means the server is not able to serve the request now.
+	ErrCodeUnavailable ErrorCode = "UNAVAILABLE"
+	// ErrCodeNotImplemented means the request is not supported.
+	ErrCodeNotImplemented ErrorCode = "NOT_IMPLEMENTED"
+	// ErrCodeInternal means an unexpected error of the server.
+	ErrCodeInternal ErrorCode = "INTERNAL"
+)
+
+// StatusQueryCanceled is the http status of canceled queries, following the non standard 499 status of
+// requests closed before responded.
+const StatusQueryCanceled = 499
+
+// ErrorCodeInfo is the registered information of an error code.
+type ErrorCodeInfo struct {
+	Code ErrorCode
+	// http status code of responses with the error code.
+	HTTPStatus int
+	// whether clients can retry the same request later.
+	Retriable bool
+}
+
+var (
+	errorCodeRegistryLock sync.RWMutex
+	errorCodeRegistry     = map[ErrorCode]ErrorCodeInfo{}
+)
+
+func init() {
+	for _, info := range []ErrorCodeInfo{
+		{ErrCodeBadRequest, http.StatusBadRequest, false},
+		{ErrCodeInvalidQuery, http.StatusBadRequest, false},
+		{ErrCodeSchemaMismatch, http.StatusBadRequest, false},
+		{ErrCodeInvalidCursor, http.StatusBadRequest, false},
+		{ErrCodeForbidden, http.StatusForbidden, false},
+		{ErrCodeRequestTooLarge, http.StatusRequestEntityTooLarge, false},
+		{ErrCodeTooManyRequests, http.StatusTooManyRequests, true},
+		{ErrCodeResourceExhausted, http.StatusServiceUnavailable, true},
+		{ErrCodeClusterDegraded, http.StatusServiceUnavailable, true},
+		{ErrCodeDataNodeFailure, http.StatusBadGateway, true},
+		{ErrCodeShardNotOwned, http.StatusMisdirectedRequest, true},
+		{ErrCodeTimeout, http.StatusRequestTimeout, true},
+		{ErrCodeCanceled, StatusQueryCanceled, false},
+		{ErrCodeUnavailable, http.StatusServiceUnavailable, true},
+		{ErrCodeNotImplemented, http.StatusNotImplemented, false},
+		{ErrCodeInternal, http.StatusInternalServerError, false},
+	} {
+		RegisterErrorCode(info)
+	}
+}
+
+// RegisterErrorCode registers the error code, overwriting the existing registration of the same code.
+func RegisterErrorCode(info ErrorCodeInfo) {
+	errorCodeRegistryLock.Lock()
+	defer errorCodeRegistryLock.Unlock()
+	errorCodeRegistry[info.Code] = info
+}
+
+// GetErrorCodeInfo returns the registered information of the error code, unknown codes are treated as
+// ErrCodeInternal.
+func GetErrorCodeInfo(code ErrorCode) ErrorCodeInfo {
+	errorCodeRegistryLock.RLock()
+	defer errorCodeRegistryLock.RUnlock()
+	if info, ok := errorCodeRegistry[code]; ok {
+		return info
+	}
+	info := errorCodeRegistry[ErrCodeInternal]
+	info.Code = code
+	return info
+}
+
+// ErrorCodeFromHTTPStatus returns the error code for errors reported only with http status codes.
+func ErrorCodeFromHTTPStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusRequestEntityTooLarge:
+		return ErrCodeRequestTooLarge
+	case http.StatusTooManyRequests:
+		return ErrCodeTooManyRequests
+	case http.StatusMisdirectedRequest:
+		return ErrCodeShardNotOwned
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	case http.StatusNotImplemented:
+		return ErrCodeNotImplemented
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// HostError is the error of the request to a host.
+type HostError struct {
+	Host      string    `json:"host"`
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Retriable bool      `json:"retriable"`
+}
+
+// CodedError is an error with a registered error code, and errors of individual hosts for errors
+// of requests fanned out to multiple hosts.
+type CodedError struct {
+	Code ErrorCode
+	// human readable message, the message of the cause is used if empty.
+	Message    string
+	Cause      error
+	HostErrors []HostError
+}
+
+// NewCodedError creates a CodedError of the code, with an optional cause.
+func NewCodedError(code ErrorCode, cause error, message string, args ...interface{}) *CodedError {
+	return &CodedError{
+		Code:    code,
+		Message: fmt.Sprintf(message, args...),
+		Cause:   cause,
+	}
+}
+
+// WithCode attaches the code to the error without changing its message.
+func WithCode(code ErrorCode, err error) *CodedError {
+	return &CodedError{Code: code, Cause: err}
+}
+
+func (e *CodedError) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	if e.Message == "" {
+		return e.Cause.Error()
+	}
+	return fmt.Sprintf("%s\n%s", e.Message, e.Cause.Error())
+}
+
+// GetErrorCode returns the code of the error, APIErrors are coded by their http status codes, and all
+// other errors are internal errors.
+func GetErrorCode(err error) ErrorCode {
+	switch e := err.(type) {
+	case *CodedError:
+		return e.Code
+	case APIError:
+		return ErrorCodeFromHTTPStatus(e.Code)
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// GetErrorMessage returns the human readable message of the error without stack traces.
+func GetErrorMessage(err error) string {
+	switch e := err.(type) {
+	case *CodedError:
+		if e.Message != "" || e.Cause == nil {
+			return e.Message
+		}
+		return GetErrorMessage(e.Cause)
+	case *StackedError:
+		messages := make([]string, 0, len(e.Messages))
+		for i := len(e.Messages) - 1; i >= 0; i-- {
+			messages = append(messages, e.Messages[i])
+		}
+		return strings.Join(messages, ": ")
+	case APIError:
+		if e.Cause == nil {
+			return e.Message
+		}
+		return fmt.Sprintf("%s: %s", e.Message, GetErrorMessage(e.Cause))
+	default:
+		return err.Error()
+	}
+}
@@ -0,0 +1,62 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("error codes", func() {
+	ginkgo.It("GetErrorCodeInfo should work", func() {
+		Ω(GetErrorCodeInfo(ErrCodeClusterDegraded)).Should(Equal(ErrorCodeInfo{
+			ErrCodeClusterDegraded, http.StatusServiceUnavailable, true,
+		}))
+		Ω(GetErrorCodeInfo("UNKNOWN")).Should(Equal(ErrorCodeInfo{
+			"UNKNOWN", http.StatusInternalServerError, false,
+		}))
+
+		RegisterErrorCode(ErrorCodeInfo{"QUOTA_EXCEEDED", http.StatusTooManyRequests, true})
+		Ω(GetErrorCodeInfo("QUOTA_EXCEEDED").HTTPStatus).Should(Equal(http.StatusTooManyRequests))
+	})
+
+	ginkgo.It("GetErrorCode should work", func() {
+		Ω(GetErrorCode(WithCode(ErrCodeInvalidQuery, errors.New("bad")))).Should(Equal(ErrCodeInvalidQuery))
+		Ω(GetErrorCode(APIError{Code: http.StatusBadRequest})).Should(Equal(ErrCodeBadRequest))
+		Ω(GetErrorCode(APIError{Code: http.StatusServiceUnavailable})).Should(Equal(ErrCodeUnavailable))
+		Ω(GetErrorCode(APIError{Code: http.StatusMisdirectedRequest})).Should(Equal(ErrCodeShardNotOwned))
+		Ω(GetErrorCode(errors.New("bad"))).Should(Equal(ErrCodeInternal))
+	})
+
+	ginkgo.It("CodedError should keep messages", func() {
+		cause := StackError(nil, "unknown table %s", "trips")
+		err := WithCode(ErrCodeInvalidQuery, cause)
+		Ω(err.Error()).Should(Equal(cause.Error()))
+		Ω(GetErrorMessage(err)).Should(Equal("unknown table trips"))
+
+		StackError(cause, "failed to compile")
+		Ω(GetErrorMessage(err)).Should(Equal("failed to compile: unknown table trips"))
+
+		err = NewCodedError(ErrCodeClusterDegraded, nil, "%d shards unavailable", 2)
+		Ω(err.Error()).Should(Equal("2 shards unavailable"))
+		Ω(GetErrorMessage(err)).Should(Equal("2 shards unavailable"))
+
+		Ω(GetErrorMessage(APIError{Message: "bad request", Cause: errors.New("invalid json")})).
+			Should(Equal("bad request: invalid json"))
+	})
+})
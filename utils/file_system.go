@@ -18,6 +18,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 // FileSystem is a file system interface
@@ -74,3 +75,36 @@ func (OSFileSystem) RemoveAll(path string) error {
 func (OSFileSystem) Stat(path string) (os.FileInfo, error) {
 	return os.Stat(path)
 }
+
+// LockFile acquires the exclusive advisory lock of the file, which is created with its parent directories if
+// not exists. It blocks
+// until the lock is acquired, returns the function to release the lock.
+func LockFile(path string) (unlock func() error, err error) {
+	return lockFile(path, false)
+}
+
+// RLockFile acquires the shared advisory lock of the file, which is created with its parent directories if
+// not exists. Shared
+// locks can be held by multiple holders at the same time but exclude the exclusive lock. It blocks
+// until the lock is acquired, returns the function to release the lock.
+func RLockFile(path string) (unlock func() error, err error) {
+	return lockFile(path, true)
+}
+
+func lockFile(path string, shared bool) (unlock func() error, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, StackError(err, "Failed to create directory of lock file %s", path)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, StackError(err, "Failed to open lock file %s", path)
+	}
+
+	if err = flock(file, shared); err != nil {
+		file.Close()
+		return nil, StackError(err, "Failed to lock file %s", path)
+	}
+
+	// the lock is released once the file is closed.
+	return file.Close, nil
+}
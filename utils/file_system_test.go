@@ -0,0 +1,64 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("file system", func() {
+	var dir string
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "file_system_test")
+		Ω(err).Should(BeNil())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("LockFile should block until the lock is released", func() {
+		lockFile := filepath.Join(dir, "lock")
+		unlock, err := LockFile(lockFile)
+		Ω(err).Should(BeNil())
+
+		locked := make(chan struct{})
+		go func() {
+			unlock2, err := LockFile(lockFile)
+			Ω(err).Should(BeNil())
+			close(locked)
+			unlock2()
+		}()
+
+		Consistently(locked, 50*time.Millisecond).ShouldNot(BeClosed())
+		Ω(unlock()).Should(BeNil())
+		Eventually(locked, time.Second).Should(BeClosed())
+
+		// directories of lock files are created as well.
+		unlock, err = LockFile(filepath.Join(dir, "nonexist", "lock"))
+		Ω(err).Should(BeNil())
+		Ω(unlock()).Should(BeNil())
+		_, err = LockFile(filepath.Join(lockFile, "lock"))
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -15,6 +15,10 @@
 package utils
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/uber/aresdb/common"
 	"golang.org/x/net/http2"
@@ -22,7 +26,11 @@ import (
 	"golang.org/x/net/netutil"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -35,6 +43,37 @@ const (
 	HTTPContentTypeUpsertBatch = "application/upsert-data"
 	// HTTPContentTypeHyperLogLog defines the hyperloglog query result content type.
 	HTTPContentTypeHyperLogLog = "application/hll"
+	// HTTPContentTypeProtobuf defines the protobuf query request and response content type.
+	HTTPContentTypeProtobuf = "application/x-protobuf"
+	// HTTPContentTypeNDJSON defines the content type of newline delimited json, e.g. progressive query results.
+	HTTPContentTypeNDJSON = "application/x-ndjson"
+	// HTTPCallerRoleHeaderKey defines the header of comma separated roles of the caller set by the auth layer.
+	HTTPCallerRoleHeaderKey = "Rpc-Caller-Role"
+	// HTTPQueryWarningHeaderKey defines the header of warnings for partial query results.
+	HTTPQueryWarningHeaderKey = "X-Query-Warning"
+	// HTTPRequestIDHeaderKey defines the header of the request id returned in v2 query responses.
+	HTTPRequestIDHeaderKey = "X-Request-Id"
+	// HTTPTraceParentHeaderKey defines the header of the w3c trace context of the caller.
+	HTTPTraceParentHeaderKey = "Traceparent"
+	// HTTPDataFreshnessHeaderKey defines the header of data freshness in unix seconds of v2 query responses.
+	HTTPDataFreshnessHeaderKey = "X-Data-Freshness"
+	// HTTPRowsScannedHeaderKey defines the header of the number of rows scanned of v2 query responses.
+	HTTPRowsScannedHeaderKey = "X-Rows-Scanned"
+	// HTTPSchemaVersionHeaderKey defines the header of the schema version of the main table datanodes
+	// evaluated v2 queries against, in incarnation.version.
+	HTTPSchemaVersionHeaderKey = "X-Schema-Version"
+	// HTTPAcceptEncodingHeaderKey defines the header of encodings accepted by the client.
+	HTTPAcceptEncodingHeaderKey = "Accept-Encoding"
+	// HTTPContentEncodingHeaderKey defines the header of the encoding of the response body.
+	HTTPContentEncodingHeaderKey = "Content-Encoding"
+	// HTTPContentEncodingGzip defines the gzip content encoding.
+	HTTPContentEncodingGzip = "gzip"
+	// HTTPAccelBufferingHeaderKey defines the header telling proxies whether to buffer the response.
+	HTTPAccelBufferingHeaderKey = "X-Accel-Buffering"
+	// HTTPQueryIDHeaderKey defines the header of the id assigned to the query by broker, for canceling it.
+	HTTPQueryIDHeaderKey = "X-Query-Id"
+	// HTTPRetryAfterHeaderKey defines the header of seconds to wait before retrying rejected requests.
+	HTTPRetryAfterHeaderKey = "Retry-After"
 )
 
 // HTTPHandlerWrapper wraps context aware httpHandler
@@ -94,6 +133,16 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Hijack lets the handler take over the connection, e.g. for websockets.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
 // WithMetricsFunc will send stats like latency, rps and returning status code after the http handler finishes.
 // It has to be applied to the actual handler function who serves the http request.
 func WithMetricsFunc(h http.HandlerFunc) http.HandlerFunc {
@@ -130,6 +179,45 @@ func GetOrigin(r *http.Request) string {
 	return origin
 }
 
+// GetCallerRoles returns the roles of the caller of the request.
+func GetCallerRoles(r *http.Request) (roles []string) {
+	for _, role := range strings.Split(r.Header.Get(HTTPCallerRoleHeaderKey), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return
+}
+
+// GetRequestID returns the request id from the request id header, or the trace id of the w3c trace context of
+// the caller, otherwise a generated one, for correlating logs of the request across services.
+func GetRequestID(r *http.Request) string {
+	if requestID := r.Header.Get(HTTPRequestIDHeaderKey); requestID != "" {
+		return requestID
+	}
+	// traceparent is version-traceid-parentid-flags.
+	if fields := strings.Split(r.Header.Get(HTTPTraceParentHeaderKey), "-"); len(fields) == 4 && len(fields[1]) == 32 {
+		return fields[1]
+	}
+	bs := make([]byte, 16)
+	rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request id, which is logged by loggers of the context and
+// forwarded to services called with the context.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request id carried by the context, empty if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
 // NoopHTTPWrapper does nothing; used for testing
 func NoopHTTPWrapper(h http.HandlerFunc) http.HandlerFunc {
 	return h
@@ -145,7 +233,10 @@ func ApplyHTTPWrappers(handler http.HandlerFunc, wrappers []HTTPHandlerWrapper)
 }
 
 // LimitServe will start a http server on the port with the handler and at most maxConnection concurrent connections.
-func LimitServe(port int, handler http.Handler, httpCfg common.HTTPConfig) {
+// On SIGTERM or SIGINT, onShutdown functions are called first (e.g. to fail readiness checks), the server keeps
+// serving for the drain period so that load balancers can stop routing requests to it, then stops accepting new
+// connections and returns after in flight requests are finished.
+func LimitServe(port int, handler http.Handler, httpCfg common.HTTPConfig, onShutdown ...func()) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		GetLogger().Fatal(err)
@@ -158,5 +249,35 @@ func LimitServe(port int, handler http.Handler, httpCfg common.HTTPConfig) {
 		WriteTimeout: time.Duration(httpCfg.WriteTimeOutInSeconds) * time.Second,
 		Handler:      h2c.NewHandler(handler, &http2.Server{}),
 	}
-	GetLogger().Fatal(server.Serve(listener))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErrors <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-serveErrors:
+		GetLogger().Fatal(err)
+	case sig := <-signals:
+		GetLogger().Infof("Received signal %s, shutting down HTTP server on port %d", sig, port)
+	}
+
+	for _, f := range onShutdown {
+		f()
+	}
+	time.Sleep(time.Duration(httpCfg.ShutdownDrainSeconds) * time.Second)
+
+	ctx := context.Background()
+	if server.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, server.WriteTimeout)
+		defer cancel()
+	}
+	if err = server.Shutdown(ctx); err != nil {
+		GetLogger().With("error", err.Error()).Error("Failed to shut down HTTP server gracefully")
+	}
 }
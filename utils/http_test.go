@@ -15,6 +15,7 @@
 package utils
 
 import (
+	"context"
 	"net/http"
 
 	"net/http/httptest"
@@ -74,4 +75,28 @@ var _ = ginkgo.Describe("http", func() {
 		r.Header.Set("RPC-Caller", "test2")
 		Ω(GetOrigin(r)).Should(Equal("test2"))
 	})
+
+	ginkgo.It("GetCallerRoles should work", func() {
+		r := &http.Request{Header: make(http.Header)}
+		Ω(GetCallerRoles(r)).Should(BeEmpty())
+
+		r.Header.Set(HTTPCallerRoleHeaderKey, "analyst, admin,")
+		Ω(GetCallerRoles(r)).Should(Equal([]string{"analyst", "admin"}))
+	})
+
+	ginkgo.It("GetRequestID should work", func() {
+		r := &http.Request{Header: make(http.Header)}
+		Ω(GetRequestID(r)).Should(HaveLen(32))
+		Ω(GetRequestID(r)).ShouldNot(Equal(GetRequestID(r)))
+
+		r.Header.Set(HTTPTraceParentHeaderKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		Ω(GetRequestID(r)).Should(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+
+		r.Header.Set(HTTPRequestIDHeaderKey, "request1")
+		Ω(GetRequestID(r)).Should(Equal("request1"))
+
+		ctx := WithRequestID(context.Background(), "request1")
+		Ω(RequestIDFromContext(ctx)).Should(Equal("request1"))
+		Ω(RequestIDFromContext(context.Background())).Should(BeEmpty())
+	})
 })
@@ -81,6 +81,16 @@ func InstanceKey(namespace, name string) string {
 	return path.Join(InstanceListKey(namespace), name)
 }
 
+// SchemaNotificationKey builds key for notifying schema changes of namespace
+func SchemaNotificationKey(namespace string) string {
+	return path.Join(NamespaceKey(namespace), "notifications", "schema")
+}
+
+// AssignmentNotificationKey builds key for notifying job assignment changes of namespace
+func AssignmentNotificationKey(namespace string) string {
+	return path.Join(NamespaceKey(namespace), "notifications", "job_assignments")
+}
+
 // EnumNodeListKey builds the key for enum node list
 func EnumNodeListKey(namespace, table string, incarnation, columnID int) string {
 	return path.Join(NamespaceKey(namespace), "enum_cases", table, strconv.Itoa(incarnation), strconv.Itoa(columnID))
@@ -52,9 +52,21 @@ const (
 	BackfillTimingTotal
 	BatchSize
 	BatchSizeReportTime
+	ControllerClientRetries
+	ControllerClientStaleness
 	CurrentRedologCreationTime
 	CurrentRedologSize
+	DeprecatedColumnsIngested
+	DeprecatedColumnsPurged
+	DiskFileCorrupt
+	DiskFreeBytes
+	DiskIOBytes
+	DiskIOLatency
+	DiskSpaceWatermarkLevel
+	DiskUsageBytes
 	DuplicateRecordRatio
+	EnumCardinalityCapReached
+	EnumCasesOverflowed
 	EstimatedDeviceMemory
 	HTTPHandlerCall
 	HTTPHandlerLatency
@@ -76,7 +88,11 @@ const (
 	PurgedBatches
 	QueryArchiveBatchProcessed
 	QueryArchiveBytesTransferred
+	QueryArchivePrefetchHits
+	QueryArchivePrefetchMisses
+	QueryArchivePrefetchReads
 	QueryArchiveRecordsProcessed
+	QueryColumnAccessDenied
 	QueryDimReadLatency
 	QueryFailed
 	QueryLatency
@@ -102,9 +118,13 @@ const (
 	RedoLogFileCorrupt
 	SchemaCreationCount
 	SchemaDeletionCount
+	SchemaDrift
+	SchemaFetchBytesSaved
 	SchemaFetchFailure
 	SchemaFetchSuccess
+	SchemaFetchTablesUpdated
 	SchemaUpdateCount
+	SchemaVersionConflicts
 	SizeOfRedologs
 	SnapshotCount
 	SnapshotTimingBuildIndex
@@ -126,8 +146,40 @@ const (
 	QueryLatencyBroker
 	SQLParsingLatencyBroker
 	DataNodeQueryFailures
+	DataNodeHedgesIssued
+	DataNodeHedgesWon
+	BrokerResultCacheHits
+	BrokerResultCacheMisses
+	DataNodeScanLatency
+	DataNodeScanBytes
+	DataNodeScanRowsScanned
+	DataNodeScanRetries
+	DataNodeSchemaStale
+	BrokerCacheInvalidations
+	SchemaMismatchRetries
+	SchemaMismatchRetriesSkipped
+	SchemaSkewDegradedQueries
+	DataNodeCircuitBreakerState
+	DataNodeCircuitBreakerRejections
+	DataNodeHealthStatus
+	ShardsAssignedToDownDataNode
+	BrokerRetryBudgetConsumed
+	BrokerRetryBudgetExhausted
+	BrokerRetryBudgetBalance
 	TimeWaitedForDataNode
 	TimeSerDeDataNodeResponse
+	ResultMergeTime
+	ResultMergeBuckets
+	NonAggDedupDisabled
+	TopQueryCount
+	TopQueryErrors
+	TopQueryLatencyP50
+	TopQueryLatencyP99
+	TopQueryRowsScanned
+	TopQueryBytesReturned
+	BrokerAdmissionWait
+	BrokerQueriesRejected
+	TopologyHealthy
 
 	MetricNamesSentinel
 )
@@ -210,7 +262,12 @@ const (
 	scopeNameNumberOfRedologs                = "number_of_redologs"
 	scopeNameSizeOfRedologs                  = "size_of_redologs"
 	scopeNameNumberOfEnumCasesPerColumn      = "number_of_enum_cases"
+	scopeNameEnumCasesOverflowed             = "enum_cases_overflowed"
+	scopeNameEnumCardinalityCapReached       = "enum_cardinality_cap_reached"
+	scopeNameDeprecatedColumnsIngested       = "deprecated_columns_ingested"
+	scopeNameDeprecatedColumnsPurged         = "deprecated_columns_purged"
 	scopeNameQueryFailed                     = "query_failed"
+	scopeNameQueryColumnAccessDenied         = "query_column_access_denied"
 	scopeNameQuerySucceeded                  = "query_succeeded"
 	scopeNameQueryLatency                    = "query_latency"
 	scopeNameQueryDimReadLatency             = "query_dim_read_latency"
@@ -221,9 +278,18 @@ const (
 	scopeNameQueryBatchProcessed             = "batch_processed"
 	scopeNameQueryBytesTransferred           = "bytes_transferred"
 	scopeNameQueryRowsReturned               = "rows_returned"
+	scopeNameQueryArchivePrefetchHits        = "archive_prefetch_hits"
+	scopeNameQueryArchivePrefetchMisses      = "archive_prefetch_misses"
+	scopeNameQueryArchivePrefetchReads       = "archive_prefetch_reads"
 	scopeNameRecordsOutOfRetention           = "records_out_of_retention"
 	scopeNameTimezoneLookupTableCreationTime = "timezone_lookup_table_creation_time"
 	scopeNameRedoLogFileCorrupt              = "redo_log_file_corrupt"
+	scopeNameDiskFileCorrupt                 = "disk_file_corrupt"
+	scopeNameDiskFreeBytes                   = "disk_free_bytes"
+	scopeNameDiskIOBytes                     = "disk_io_bytes"
+	scopeNameDiskIOLatency                   = "disk_io_latency"
+	scopeNameDiskSpaceWatermarkLevel         = "disk_space_watermark_level"
+	scopeNameDiskUsageBytes                  = "disk_usage_bytes"
 	scopeNameMemoryOverflow                  = "memory_overflow"
 	scopeNameRawVPBytesFetched               = "raw_vp_bytes_fetched"
 	scopeNameRawVPFetchBytesPerSec           = "raw_vp_fetch_bytes_per_sec"
@@ -238,10 +304,16 @@ const (
 	scopeNameBatchSizeReportTime             = "batch_size_report_time"
 	scopeNameSchemaFetchSuccess              = "schema_fetch_success"
 	scopeNameSchemaFetchFailure              = "schema_fetch_failure"
+	scopeNameSchemaFetchBytesSaved           = "schema_fetch_bytes_saved"
+	scopeNameSchemaFetchTablesUpdated        = "schema_fetch_tables_updated"
+	scopeNameSchemaDrift                     = "schema_drift"
 	scopeNameSchemaUpdateCount               = "schema_updates"
 	scopeNameSchemaDeletionCount             = "schema_deletions"
 	scopeNameSchemaCreationCount             = "schema_creations"
+	scopeNameSchemaVersionConflicts          = "schema_version_conflicts"
 	scopeNameJobFailuresCount                = "job_failures_count"
+	scopeNameControllerClientRetries         = "controller_client_retries"
+	scopeNameControllerClientStaleness       = "controller_client_staleness_sec"
 
 	// broker metrics
 	scopeNameAQLQueryReceivedBroker    = "aql_query_received_broker"
@@ -251,8 +323,40 @@ const (
 	scopeNameQueryLatencyBroker        = "query_latency_broker"
 	scopeNameSQLParsingLatencyBroker   = "sql_parsing_latency_broker"
 	scopeNameDataNodeQueryFailures     = "datanode_query_failures"
+	scopeNameDataNodeHedgesIssued      = "datanode_hedges_issued"
+	scopeNameDataNodeHedgesWon         = "datanode_hedges_won"
+	scopeNameBrokerResultCacheHits     = "broker_result_cache_hits"
+	scopeNameBrokerResultCacheMisses   = "broker_result_cache_misses"
+	scopeNameDataNodeScanLatency       = "datanode_scan_latency"
+	scopeNameDataNodeScanBytes         = "datanode_scan_bytes"
+	scopeNameDataNodeScanRowsScanned   = "datanode_scan_rows_scanned"
+	scopeNameDataNodeScanRetries       = "datanode_scan_retries"
+	scopeNameDataNodeSchemaStale       = "datanode_schema_stale"
+	scopeNameBrokerCacheInvalidations  = "broker_cache_invalidations"
+	scopeNameSchemaMismatchRetries     = "schema_mismatch_retries"
+	scopeNameSchemaMismatchSkipped     = "schema_mismatch_retries_skipped"
+	scopeNameSchemaSkewDegraded        = "schema_skew_degraded_queries"
+	scopeNameCircuitBreakerState       = "datanode_circuit_breaker_state"
+	scopeNameDataNodeHealthStatus      = "datanode_health_status"
+	scopeNameShardsAssignedToDownHost  = "shards_assigned_to_down_datanode"
+	scopeNameCircuitBreakerRejections  = "datanode_circuit_breaker_rejections"
+	scopeNameRetryBudgetConsumed       = "broker_retry_budget_consumed"
+	scopeNameRetryBudgetExhausted      = "broker_retry_budget_exhausted"
+	scopeNameRetryBudgetBalance        = "broker_retry_budget_balance"
 	scopeNameTimeWaitedForDataNode     = "time_waited_for_datanodes"
 	scopeNameTimeSerDeDataNodeResponse = "time_serde_response"
+	scopeNameResultMergeTime           = "result_merge_time"
+	scopeNameResultMergeBuckets        = "result_merge_buckets"
+	scopeNameNonAggDedupDisabled       = "non_agg_dedup_disabled"
+	scopeNameTopQueryCount             = "top_query_count"
+	scopeNameTopQueryErrors            = "top_query_errors"
+	scopeNameTopQueryLatencyP50        = "top_query_latency_p50_ms"
+	scopeNameTopQueryLatencyP99        = "top_query_latency_p99_ms"
+	scopeNameTopQueryRowsScanned       = "top_query_rows_scanned"
+	scopeNameTopQueryBytesReturned     = "top_query_bytes_returned"
+	scopeNameBrokerAdmissionWait       = "broker_admission_wait"
+	scopeNameBrokerQueriesRejected     = "broker_queries_rejected"
+	scopeNameTopologyHealthy           = "topology_healthy"
 )
 
 // Metric tag names
@@ -274,12 +378,14 @@ const (
 
 // Metric component tag values
 const (
-	metricsComponentMemStore  = "memstore"
-	metricsComponentAPI       = "api"
-	metricsComponentDiskStore = "diskstore"
-	metricsComponentMetaStore = "metastore"
-	metricsComponentQuery     = "query"
-	metricsComponentStats     = "stats"
+	metricsComponentMemStore   = "memstore"
+	metricsComponentAPI        = "api"
+	metricsComponentDiskStore  = "diskstore"
+	metricsComponentMetaStore  = "metastore"
+	metricsComponentQuery      = "query"
+	metricsComponentStats      = "stats"
+	metricsComponentController = "controller_client"
+	metricsComponentCluster    = "cluster"
 )
 
 // Metric operation tag values
@@ -648,6 +754,48 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentDiskStore,
 		},
 	},
+	DiskFileCorrupt: {
+		name:       scopeNameDiskFileCorrupt,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	DiskFreeBytes: {
+		name:       scopeNameDiskFreeBytes,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	DiskIOBytes: {
+		name:       scopeNameDiskIOBytes,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	DiskIOLatency: {
+		name:       scopeNameDiskIOLatency,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	DiskSpaceWatermarkLevel: {
+		name:       scopeNameDiskSpaceWatermarkLevel,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	DiskUsageBytes: {
+		name:       scopeNameDiskUsageBytes,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
 	NumberOfRedologs: {
 		name:       scopeNameNumberOfRedologs,
 		metricType: Gauge,
@@ -669,6 +817,35 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMetaStore,
 		},
 	},
+	EnumCardinalityCapReached: {
+		name:       scopeNameEnumCardinalityCapReached,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	EnumCasesOverflowed: {
+		name:       scopeNameEnumCasesOverflowed,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	DeprecatedColumnsIngested: {
+		name:       scopeNameDeprecatedColumnsIngested,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	DeprecatedColumnsPurged: {
+		name:       scopeNameDeprecatedColumnsPurged,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
 	QueryFailed: {
 		name:       scopeNameQueryFailed,
 		metricType: Counter,
@@ -676,6 +853,13 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentQuery,
 		},
 	},
+	QueryColumnAccessDenied: {
+		name:       scopeNameQueryColumnAccessDenied,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
 	QuerySucceeded: {
 		name:       scopeNameQuerySucceeded,
 		metricType: Counter,
@@ -766,6 +950,27 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagStore:     metricsStoreArchive,
 		},
 	},
+	QueryArchivePrefetchHits: {
+		name:       scopeNameQueryArchivePrefetchHits,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QueryArchivePrefetchMisses: {
+		name:       scopeNameQueryArchivePrefetchMisses,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QueryArchivePrefetchReads: {
+		name:       scopeNameQueryArchivePrefetchReads,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
 	QueryRowsReturned: {
 		name:       scopeNameQueryRowsReturned,
 		metricType: Counter,
@@ -941,6 +1146,41 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMetaStore,
 		},
 	},
+	ControllerClientRetries: {
+		name:       scopeNameControllerClientRetries,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentController,
+		},
+	},
+	ControllerClientStaleness: {
+		name:       scopeNameControllerClientStaleness,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentController,
+		},
+	},
+	SchemaFetchBytesSaved: {
+		name:       scopeNameSchemaFetchBytesSaved,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	SchemaFetchTablesUpdated: {
+		name:       scopeNameSchemaFetchTablesUpdated,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	SchemaDrift: {
+		name:       scopeNameSchemaDrift,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
 	SchemaUpdateCount: {
 		name:       scopeNameSchemaUpdateCount,
 		metricType: Counter,
@@ -948,6 +1188,13 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMetaStore,
 		},
 	},
+	SchemaVersionConflicts: {
+		name:       scopeNameSchemaVersionConflicts,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
 	SchemaDeletionCount: {
 		name:       scopeNameSchemaDeletionCount,
 		metricType: Counter,
@@ -1024,6 +1271,146 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentQuery,
 		},
 	},
+	DataNodeHedgesIssued: {
+		name:       scopeNameDataNodeHedgesIssued,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeHedgesWon: {
+		name:       scopeNameDataNodeHedgesWon,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerResultCacheHits: {
+		name:       scopeNameBrokerResultCacheHits,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerResultCacheMisses: {
+		name:       scopeNameBrokerResultCacheMisses,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeScanLatency: {
+		name:       scopeNameDataNodeScanLatency,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeScanBytes: {
+		name:       scopeNameDataNodeScanBytes,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeScanRowsScanned: {
+		name:       scopeNameDataNodeScanRowsScanned,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeScanRetries: {
+		name:       scopeNameDataNodeScanRetries,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeSchemaStale: {
+		name:       scopeNameDataNodeSchemaStale,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerCacheInvalidations: {
+		name:       scopeNameBrokerCacheInvalidations,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	SchemaMismatchRetries: {
+		name:       scopeNameSchemaMismatchRetries,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	SchemaMismatchRetriesSkipped: {
+		name:       scopeNameSchemaMismatchSkipped,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	SchemaSkewDegradedQueries: {
+		name:       scopeNameSchemaSkewDegraded,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeCircuitBreakerState: {
+		name:       scopeNameCircuitBreakerState,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeHealthStatus: {
+		name:       scopeNameDataNodeHealthStatus,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	ShardsAssignedToDownDataNode: {
+		name:       scopeNameShardsAssignedToDownHost,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DataNodeCircuitBreakerRejections: {
+		name:       scopeNameCircuitBreakerRejections,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerRetryBudgetConsumed: {
+		name:       scopeNameRetryBudgetConsumed,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerRetryBudgetExhausted: {
+		name:       scopeNameRetryBudgetExhausted,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerRetryBudgetBalance: {
+		name:       scopeNameRetryBudgetBalance,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
 	TimeWaitedForDataNode: {
 		name:       scopeNameTimeWaitedForDataNode,
 		metricType: Timer,
@@ -1038,6 +1425,90 @@ var metricDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentQuery,
 		},
 	},
+	ResultMergeTime: {
+		name:       scopeNameResultMergeTime,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	ResultMergeBuckets: {
+		name:       scopeNameResultMergeBuckets,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	NonAggDedupDisabled: {
+		name:       scopeNameNonAggDedupDisabled,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopQueryCount: {
+		name:       scopeNameTopQueryCount,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopQueryErrors: {
+		name:       scopeNameTopQueryErrors,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopQueryLatencyP50: {
+		name:       scopeNameTopQueryLatencyP50,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopQueryLatencyP99: {
+		name:       scopeNameTopQueryLatencyP99,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopQueryRowsScanned: {
+		name:       scopeNameTopQueryRowsScanned,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopQueryBytesReturned: {
+		name:       scopeNameTopQueryBytesReturned,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerAdmissionWait: {
+		name:       scopeNameBrokerAdmissionWait,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	BrokerQueriesRejected: {
+		name:       scopeNameBrokerQueriesRejected,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TopologyHealthy: {
+		name:       scopeNameTopologyHealthy,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentCluster,
+		},
+	},
 }
 
 func (def *metricDefinition) init(rootScope tally.Scope) {
@@ -0,0 +1,591 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/uber/aresdb/utils"
+)
+
+// julianDayOfEpoch is the julian day of 1970-01-01, used to decode int96 timestamps.
+const julianDayOfEpoch = 2440588
+
+// columnSchema is a column with the information needed to decode its values.
+type columnSchema struct {
+	Column
+	required   bool
+	typeLength int
+}
+
+// columnChunkLocation is where the pages of a column chunk are in the file.
+type columnChunkLocation struct {
+	offset    int64
+	size      int64
+	codec     int32
+	numValues int64
+}
+
+// Reader reads flat parquet files row group by row group. Nested and repeated columns are not
+// supported.
+type Reader struct {
+	reader    io.ReaderAt
+	columns   []columnSchema
+	rowGroups [][]columnChunkLocation
+	numRows   []int64
+}
+
+// NewReader reads the metadata of a parquet file of the size.
+func NewReader(reader io.ReaderAt, size int64) (*Reader, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, utils.StackError(nil, "File of %d bytes is too small to be a parquet file", size)
+	}
+	var tail [8]byte
+	if _, err := reader.ReadAt(tail[:], size-8); err != nil {
+		return nil, utils.StackError(err, "Failed to read parquet footer")
+	}
+	if string(tail[4:]) != magic {
+		return nil, utils.StackError(nil, "Not a parquet file")
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerSize > size-8-int64(len(magic)) {
+		return nil, utils.StackError(nil, "Invalid parquet footer size %d", footerSize)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := reader.ReadAt(footer, size-8-footerSize); err != nil {
+		return nil, utils.StackError(err, "Failed to read parquet footer")
+	}
+
+	t := thriftReader{buf: footer}
+	metaData, err := t.strct()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{reader: reader}
+	if err = r.readSchema(metaData.list(2)); err != nil {
+		return nil, err
+	}
+
+	for _, rg := range metaData.list(4) {
+		rowGroup, _ := rg.(thriftFields)
+		chunks := rowGroup.list(1)
+		if len(chunks) != len(r.columns) {
+			return nil, utils.StackError(nil, "Row group has %d column chunks, expect %d",
+				len(chunks), len(r.columns))
+		}
+		locations := make([]columnChunkLocation, len(chunks))
+		for i, c := range chunks {
+			chunk, _ := c.(thriftFields)
+			if chunk.str(1) != "" {
+				return nil, utils.StackError(nil, "Column chunks in external files are not supported")
+			}
+			columnMetaData := chunk.strct(3)
+			if columnMetaData == nil {
+				return nil, utils.StackError(nil, "Column chunk of %s has no metadata", r.columns[i].Name)
+			}
+			offset := columnMetaData.i64(9)
+			if dictOffset := columnMetaData.i64(11); dictOffset > 0 && dictOffset < offset {
+				offset = dictOffset
+			}
+			locations[i] = columnChunkLocation{
+				offset:    offset,
+				size:      columnMetaData.i64(7),
+				codec:     int32(columnMetaData.i64(4)),
+				numValues: columnMetaData.i64(5),
+			}
+			if offset < 0 || locations[i].size < 0 || offset+locations[i].size > size {
+				return nil, utils.StackError(nil, "Column chunk of %s is out of the file", r.columns[i].Name)
+			}
+		}
+		r.rowGroups = append(r.rowGroups, locations)
+		r.numRows = append(r.numRows, rowGroup.i64(3))
+	}
+	return r, nil
+}
+
+// readSchema reads the flattened schema tree, which must have only leaves under the root.
+func (r *Reader) readSchema(elements []interface{}) error {
+	if len(elements) == 0 {
+		return utils.StackError(nil, "Parquet file has no schema")
+	}
+	for _, e := range elements[1:] {
+		element, _ := e.(thriftFields)
+		name := element.str(4)
+		if element.i64(5) > 0 || !element.has(1) {
+			return utils.StackError(nil, "Nested column %s is not supported", name)
+		}
+		repetition := int32(element.i64(3))
+		if repetition != repetitionRequired && repetition != repetitionOptional {
+			return utils.StackError(nil, "Repeated column %s is not supported", name)
+		}
+		column := columnSchema{
+			Column: Column{
+				Name: name,
+				Type: Type(element.i64(1)),
+			},
+			required:   repetition == repetitionRequired,
+			typeLength: int(element.i64(2)),
+		}
+		if element.has(6) {
+			column.Logical = logicalTypesByConvertedType[element.i64(6)]
+		}
+		if logicalType := element.strct(10); logicalType != nil {
+			column.Logical = readLogicalType(logicalType, column.Logical)
+		}
+		r.columns = append(r.columns, column)
+	}
+	return nil
+}
+
+// readLogicalType reads the LogicalType union, which takes precedence over the converted type.
+func readLogicalType(logicalType thriftFields, convertedType LogicalType) LogicalType {
+	switch {
+	case logicalType.has(1), logicalType.has(4), logicalType.has(12):
+		return String
+	case logicalType.has(5):
+		return Decimal
+	case logicalType.has(6):
+		return Date
+	case logicalType.has(8):
+		unit := logicalType.strct(8).strct(2)
+		switch {
+		case unit.has(1):
+			return TimestampMillis
+		case unit.has(2):
+			return TimestampMicros
+		case unit.has(3):
+			return TimestampNanos
+		}
+	case logicalType.has(10):
+		intType := logicalType.strct(10)
+		signed := intType.boolean(2, true)
+		switch bitWidth := intType.i64(1); {
+		case bitWidth == 8 && signed:
+			return Int8
+		case bitWidth == 16 && signed:
+			return Int16
+		case bitWidth == 8:
+			return Uint8
+		case bitWidth == 16:
+			return Uint16
+		case bitWidth == 32 && !signed:
+			return Uint32
+		case bitWidth == 64 && !signed:
+			return Uint64
+		}
+		return NoLogicalType
+	}
+	return convertedType
+}
+
+// Columns returns the columns of the file.
+func (r *Reader) Columns() []Column {
+	columns := make([]Column, len(r.columns))
+	for i, column := range r.columns {
+		columns[i] = column.Column
+	}
+	return columns
+}
+
+// NumRowGroups returns the number of row groups in the file.
+func (r *Reader) NumRowGroups() int {
+	return len(r.rowGroups)
+}
+
+// NumRows returns the number of rows in the file.
+func (r *Reader) NumRows() int64 {
+	var numRows int64
+	for _, n := range r.numRows {
+		numRows += n
+	}
+	return numRows
+}
+
+// ReadRowGroup reads values of the columns (by index) in the row group. Values are returned
+// per column with nil for nulls. Values are decoded as:
+//
+//	Boolean: bool
+//	Int32: int32, uint32 for unsigned logical types and time.Time for Date
+//	Int64: int64, uint64 for Uint64 and time.Time for timestamps
+//	Int96: time.Time
+//	Float: float32
+//	Double: float64
+//	ByteArray, FixedLenByteArray: string
+func (r *Reader) ReadRowGroup(rowGroup int, columns []int) ([][]interface{}, error) {
+	if rowGroup < 0 || rowGroup >= len(r.rowGroups) {
+		return nil, utils.StackError(nil, "Row group %d out of range [0, %d)", rowGroup, len(r.rowGroups))
+	}
+	values := make([][]interface{}, len(columns))
+	for i, column := range columns {
+		if column < 0 || column >= len(r.columns) {
+			return nil, utils.StackError(nil, "Column %d out of range [0, %d)", column, len(r.columns))
+		}
+		var err error
+		values[i], err = r.readColumnChunk(r.columns[column], r.rowGroups[rowGroup][column])
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to read column %s of row group %d",
+				r.columns[column].Name, rowGroup)
+		}
+		if int64(len(values[i])) != r.numRows[rowGroup] {
+			return nil, utils.StackError(nil, "Column %s of row group %d has %d values, expect %d",
+				r.columns[column].Name, rowGroup, len(values[i]), r.numRows[rowGroup])
+		}
+	}
+	return values, nil
+}
+
+// readColumnChunk decodes all pages of a column chunk.
+func (r *Reader) readColumnChunk(column columnSchema, location columnChunkLocation) ([]interface{}, error) {
+	data := make([]byte, location.size)
+	if _, err := r.reader.ReadAt(data, location.offset); err != nil {
+		return nil, err
+	}
+
+	var dictionary []interface{}
+	values := make([]interface{}, 0, location.numValues)
+	t := thriftReader{buf: data}
+	for int64(len(values)) < location.numValues {
+		if t.pos >= len(data) {
+			return nil, utils.StackError(nil, "Expect %d values, got %d", location.numValues, len(values))
+		}
+		header, err := t.strct()
+		if err != nil {
+			return nil, err
+		}
+		pageSize := int(header.i64(3))
+		if pageSize < 0 || t.pos+pageSize > len(data) {
+			return nil, utils.StackError(nil, "Invalid page size %d", pageSize)
+		}
+		page := data[t.pos : t.pos+pageSize]
+		t.pos += pageSize
+
+		switch int32(header.i64(1)) {
+		case pageTypeDictionary:
+			if page, err = decompress(page, location.codec); err != nil {
+				return nil, err
+			}
+			dictHeader := header.strct(7)
+			dictionary = make([]interface{}, dictHeader.i64(1))
+			if _, err = decodePlain(column, page, dictionary, len(dictionary)); err != nil {
+				return nil, err
+			}
+		case pageTypeData:
+			dataHeader := header.strct(5)
+			if page, err = decompress(page, location.codec); err != nil {
+				return nil, err
+			}
+			numValues := int(dataHeader.i64(1))
+			validity := allValid
+			if !column.required {
+				if len(page) < 4 {
+					return nil, utils.StackError(nil, "Missing definition levels")
+				}
+				length := int(binary.LittleEndian.Uint32(page))
+				if length < 0 || 4+length > len(page) {
+					return nil, utils.StackError(nil, "Invalid definition levels length %d", length)
+				}
+				if validity, err = decodeLevels(page[4:4+length], numValues); err != nil {
+					return nil, err
+				}
+				page = page[4+length:]
+			}
+			if values, err = decodePage(column, int32(dataHeader.i64(2)), page, numValues, validity,
+				dictionary, values); err != nil {
+				return nil, err
+			}
+		case pageTypeDataV2:
+			dataHeader := header.strct(8)
+			numValues := int(dataHeader.i64(1))
+			defLength := int(dataHeader.i64(5))
+			repLength := int(dataHeader.i64(6))
+			if defLength < 0 || repLength < 0 || defLength+repLength > len(page) {
+				return nil, utils.StackError(nil, "Invalid levels length")
+			}
+			validity := allValid
+			if !column.required {
+				if validity, err = decodeLevels(page[repLength:repLength+defLength], numValues); err != nil {
+					return nil, err
+				}
+			}
+			page = page[repLength+defLength:]
+			if dataHeader.boolean(7, true) {
+				if page, err = decompress(page, location.codec); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = decodePage(column, int32(dataHeader.i64(4)), page, numValues, validity,
+				dictionary, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// allValid is used as validity of required columns.
+func allValid(int) bool {
+	return true
+}
+
+// decompress decompresses a page with the codec.
+func decompress(page []byte, codec int32) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return page, nil
+	case codecSnappy:
+		decoded, err := snappy.Decode(nil, page)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to decompress snappy page")
+		}
+		return decoded, nil
+	case codecGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to decompress gzip page")
+		}
+		defer gzipReader.Close()
+		decoded, err := ioutil.ReadAll(gzipReader)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to decompress gzip page")
+		}
+		return decoded, nil
+	}
+	return nil, utils.StackError(nil, "Compression codec %d is not supported", codec)
+}
+
+// decodeLevels decodes definition levels of flat columns and returns the validity of values.
+func decodeLevels(data []byte, numValues int) (func(int) bool, error) {
+	levels, err := decodeHybrid(data, 1, numValues)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to decode definition levels")
+	}
+	return func(i int) bool {
+		return levels[i] != 0
+	}, nil
+}
+
+// decodeHybrid decodes numValues values of the bit width encoded with the RLE/bit-packing
+// hybrid encoding.
+func decodeHybrid(data []byte, bitWidth uint, numValues int) ([]uint32, error) {
+	values := make([]uint32, 0, numValues)
+	byteWidth := int(bitWidth+7) / 8
+	pos := 0
+	for len(values) < numValues {
+		header, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return nil, utils.StackError(nil, "Invalid run header")
+		}
+		pos += n
+		if header&1 == 1 {
+			// Bit packed run of groups of 8 values.
+			count := int(header>>1) * 8
+			numBytes := count * int(bitWidth) / 8
+			if pos+numBytes > len(data) {
+				return nil, utils.StackError(nil, "Bit packed run exceeds data")
+			}
+			for i := 0; i < count && len(values) < numValues; i++ {
+				var v uint32
+				for b := uint(0); b < bitWidth; b++ {
+					bit := uint(i)*bitWidth + b
+					v |= uint32(data[pos+int(bit/8)]>>(bit%8)&1) << b
+				}
+				values = append(values, v)
+			}
+			pos += numBytes
+		} else {
+			count := int(header >> 1)
+			if pos+byteWidth > len(data) {
+				return nil, utils.StackError(nil, "RLE run exceeds data")
+			}
+			var v uint32
+			for b := 0; b < byteWidth; b++ {
+				v |= uint32(data[pos+b]) << (8 * uint(b))
+			}
+			pos += byteWidth
+			for i := 0; i < count && len(values) < numValues; i++ {
+				values = append(values, v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// decodePage decodes non null values of a data page and appends numValues values to values.
+func decodePage(column columnSchema, encoding int32, data []byte, numValues int, validity func(int) bool,
+	dictionary []interface{}, values []interface{}) ([]interface{}, error) {
+	numNonNulls := 0
+	for i := 0; i < numValues; i++ {
+		if validity(i) {
+			numNonNulls++
+		}
+	}
+	nonNulls := make([]interface{}, numNonNulls)
+
+	switch encoding {
+	case encodingPlain:
+		if _, err := decodePlain(column, data, nonNulls, numNonNulls); err != nil {
+			return nil, err
+		}
+	case encodingPlainDictionary, encodingRLEDictionary:
+		if numNonNulls > 0 {
+			if len(data) == 0 {
+				return nil, utils.StackError(nil, "Missing dictionary indices")
+			}
+			indices, err := decodeHybrid(data[1:], uint(data[0]), numNonNulls)
+			if err != nil {
+				return nil, utils.StackError(err, "Failed to decode dictionary indices")
+			}
+			for i, index := range indices {
+				if int(index) >= len(dictionary) {
+					return nil, utils.StackError(nil, "Dictionary index %d out of range [0, %d)", index, len(dictionary))
+				}
+				nonNulls[i] = dictionary[index]
+			}
+		}
+	case encodingRLE:
+		if column.Type != Boolean {
+			return nil, utils.StackError(nil, "RLE encoding of %d type is not supported", column.Type)
+		}
+		if len(data) < 4 {
+			return nil, utils.StackError(nil, "Missing RLE length")
+		}
+		bits, err := decodeHybrid(data[4:], 1, numNonNulls)
+		if err != nil {
+			return nil, err
+		}
+		for i, bit := range bits {
+			nonNulls[i] = bit != 0
+		}
+	default:
+		return nil, utils.StackError(nil, "Encoding %d is not supported", encoding)
+	}
+
+	next := 0
+	for i := 0; i < numValues; i++ {
+		if validity(i) {
+			values = append(values, nonNulls[next])
+			next++
+		} else {
+			values = append(values, nil)
+		}
+	}
+	return values, nil
+}
+
+// decodePlain decodes numValues PLAIN encoded values into values and returns the number of bytes
+// consumed.
+func decodePlain(column columnSchema, data []byte, values []interface{}, numValues int) (int, error) {
+	pos := 0
+	need := func(n int) error {
+		if pos+n > len(data) {
+			return utils.StackError(nil, "Unexpected end of page")
+		}
+		return nil
+	}
+	for i := 0; i < numValues; i++ {
+		switch column.Type {
+		case Boolean:
+			if err := need((i+8)/8 - pos); err != nil {
+				return 0, err
+			}
+			values[i] = data[i/8]>>uint(i%8)&1 != 0
+			pos = (i + 8) / 8
+		case Int32:
+			if err := need(4); err != nil {
+				return 0, err
+			}
+			values[i] = convertInt32(int32(binary.LittleEndian.Uint32(data[pos:])), column.Logical)
+			pos += 4
+		case Int64:
+			if err := need(8); err != nil {
+				return 0, err
+			}
+			values[i] = convertInt64(int64(binary.LittleEndian.Uint64(data[pos:])), column.Logical)
+			pos += 8
+		case Int96:
+			if err := need(12); err != nil {
+				return 0, err
+			}
+			nanos := int64(binary.LittleEndian.Uint64(data[pos:]))
+			days := int64(binary.LittleEndian.Uint32(data[pos+8:])) - julianDayOfEpoch
+			values[i] = time.Unix(days*86400, nanos).UTC()
+			pos += 12
+		case Float:
+			if err := need(4); err != nil {
+				return 0, err
+			}
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+		case Double:
+			if err := need(8); err != nil {
+				return 0, err
+			}
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[pos:]))
+			pos += 8
+		case ByteArray:
+			if err := need(4); err != nil {
+				return 0, err
+			}
+			length := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if err := need(length); err != nil {
+				return 0, err
+			}
+			values[i] = string(data[pos : pos+length])
+			pos += length
+		case FixedLenByteArray:
+			if err := need(column.typeLength); err != nil {
+				return 0, err
+			}
+			values[i] = string(data[pos : pos+column.typeLength])
+			pos += column.typeLength
+		default:
+			return 0, utils.StackError(nil, "Unknown type %d", column.Type)
+		}
+	}
+	return pos, nil
+}
+
+func convertInt32(v int32, logical LogicalType) interface{} {
+	switch logical {
+	case Uint8, Uint16, Uint32:
+		return uint32(v)
+	case Date:
+		return time.Unix(int64(v)*86400, 0).UTC()
+	}
+	return v
+}
+
+func convertInt64(v int64, logical LogicalType) interface{} {
+	switch logical {
+	case Uint64:
+		return uint64(v)
+	case TimestampMillis:
+		return time.Unix(v/1000, v%1000*int64(time.Millisecond)).UTC()
+	case TimestampMicros:
+		return time.Unix(v/1000000, v%1000000*int64(time.Microsecond)).UTC()
+	case TimestampNanos:
+		return time.Unix(0, v).UTC()
+	}
+	return v
+}
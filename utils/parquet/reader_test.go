@@ -0,0 +1,224 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/golang/snappy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reader", func() {
+	It("decodes thrift compact protocol", func() {
+		var t thriftWriter
+		t.beginStruct(0)
+		t.i32Field(1, -1)
+		t.i64Field(20, 1)
+		t.boolField(21, true)
+		t.listField(22, thriftI32, 2)
+		t.i32Elem(3)
+		t.i32Elem(4)
+		t.beginStruct(23)
+		t.stringField(1, "ab")
+		t.endStruct()
+		t.i32Field(24, 1)
+		t.endStruct()
+
+		r := thriftReader{buf: t.buf.Bytes()}
+		fields, err := r.strct()
+		Ω(err).Should(BeNil())
+		Ω(fields.i64(1)).Should(BeEquivalentTo(-1))
+		Ω(fields.i64(20)).Should(BeEquivalentTo(1))
+		Ω(fields.boolean(21, false)).Should(BeTrue())
+		Ω(fields.list(22)).Should(Equal([]interface{}{int64(3), int64(4)}))
+		Ω(fields.strct(23).str(1)).Should(Equal("ab"))
+		Ω(fields.i64(24)).Should(BeEquivalentTo(1))
+		Ω(r.pos).Should(Equal(len(r.buf)))
+
+		r = thriftReader{buf: t.buf.Bytes()[:5]}
+		_, err = r.strct()
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("decodes RLE/bit-packing hybrid", func() {
+		// RLE run of 3 values of 5 followed by a bit packed run of 8 values.
+		values, err := decodeHybrid([]byte{0x06, 0x05, 0x03, 0x88, 0xc6, 0xfa}, 3, 10)
+		Ω(err).Should(BeNil())
+		Ω(values).Should(Equal([]uint32{5, 5, 5, 0, 1, 2, 3, 4, 5, 6}))
+
+		_, err = decodeHybrid([]byte{0x06}, 3, 10)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("decodes int96 timestamps", func() {
+		data := make([]byte, 12)
+		binary.LittleEndian.PutUint64(data, uint64(time.Hour+time.Millisecond))
+		binary.LittleEndian.PutUint32(data[8:], julianDayOfEpoch+1)
+		values := make([]interface{}, 1)
+		n, err := decodePlain(columnSchema{Column: Column{Type: Int96}}, data, values, 1)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(12))
+		Ω(values[0]).Should(Equal(time.Unix(86400+3600, int64(time.Millisecond)).UTC()))
+	})
+
+	It("reads files written by writer", func() {
+		columns := []Column{
+			{Name: "ts", Type: Int64, Logical: TimestampMillis},
+			{Name: "name", Type: ByteArray, Logical: String},
+			{Name: "flag", Type: Boolean},
+			{Name: "small", Type: Int32, Logical: Uint16},
+			{Name: "ratio", Type: Double},
+		}
+		var buf bytes.Buffer
+		writer := NewWriter(&buf, columns)
+		for i := 0; i < 2; i++ {
+			chunks := make([]*ColumnChunk, len(columns))
+			for j, column := range columns {
+				chunks[j] = NewColumnChunk(column)
+			}
+			chunks[0].AppendInt64(1500)
+			chunks[0].AppendInt64(2000)
+			chunks[1].AppendString("a")
+			chunks[1].AppendNull()
+			chunks[2].AppendNull()
+			chunks[2].AppendBool(true)
+			chunks[3].AppendInt32(65535)
+			chunks[3].AppendInt32(int32(i))
+			chunks[4].AppendDouble(0.5)
+			chunks[4].AppendDouble(-1)
+			Ω(writer.WriteRowGroup(chunks)).Should(BeNil())
+		}
+		Ω(writer.Close()).Should(BeNil())
+
+		reader, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Ω(err).Should(BeNil())
+		Ω(reader.Columns()).Should(Equal(columns))
+		Ω(reader.NumRowGroups()).Should(Equal(2))
+		Ω(reader.NumRows()).Should(BeEquivalentTo(4))
+
+		values, err := reader.ReadRowGroup(1, []int{4, 0, 1, 2, 3})
+		Ω(err).Should(BeNil())
+		Ω(values).Should(Equal([][]interface{}{
+			{0.5, -1.0},
+			{time.Unix(1, int64(500*time.Millisecond)).UTC(), time.Unix(2, 0).UTC()},
+			{"a", nil},
+			{nil, true},
+			{uint32(65535), uint32(1)},
+		}))
+
+		_, err = reader.ReadRowGroup(2, []int{0})
+		Ω(err).ShouldNot(BeNil())
+		_, err = reader.ReadRowGroup(0, []int{5})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("reads dictionary encoded snappy compressed pages", func() {
+		var dict bytes.Buffer
+		for _, s := range []string{"x", "y"} {
+			binary.Write(&dict, binary.LittleEndian, uint32(len(s)))
+			dict.WriteString(s)
+		}
+		dictPage := snappy.Encode(nil, dict.Bytes())
+		// Bit width 1 followed by a bit packed run of indices 1, 0, 1.
+		dataPage := snappy.Encode(nil, []byte{0x01, 0x03, 0x05})
+
+		var file bytes.Buffer
+		file.WriteString(magic)
+		var header thriftWriter
+		header.beginStruct(0)
+		header.i32Field(1, pageTypeDictionary)
+		header.i32Field(2, int32(dict.Len()))
+		header.i32Field(3, int32(len(dictPage)))
+		header.beginStruct(7)
+		header.i32Field(1, 2)
+		header.i32Field(2, encodingPlainDictionary)
+		header.endStruct()
+		header.endStruct()
+		header.buf.Write(dictPage)
+
+		header.beginStruct(0)
+		header.i32Field(1, pageTypeDataV2)
+		header.i32Field(2, 3)
+		header.i32Field(3, int32(len(dataPage)))
+		header.beginStruct(8)
+		header.i32Field(1, 3)
+		header.i32Field(2, 0)
+		header.i32Field(3, 3)
+		header.i32Field(4, encodingRLEDictionary)
+		header.i32Field(5, 0)
+		header.i32Field(6, 0)
+		header.endStruct()
+		header.endStruct()
+		header.buf.Write(dataPage)
+		chunkSize := header.buf.Len()
+		file.Write(header.buf.Bytes())
+
+		var t thriftWriter
+		t.beginStruct(0)
+		t.i32Field(1, 1)
+		t.listField(2, thriftStruct, 2)
+		t.beginStruct(0)
+		t.stringField(4, "schema")
+		t.i32Field(5, 1)
+		t.endStruct()
+		t.beginStruct(0)
+		t.i32Field(1, int32(ByteArray))
+		t.i32Field(3, repetitionRequired)
+		t.stringField(4, "name")
+		t.beginStruct(10)
+		t.beginStruct(1)
+		t.endStruct()
+		t.endStruct()
+		t.endStruct()
+		t.i64Field(3, 3)
+		t.listField(4, thriftStruct, 1)
+		t.beginStruct(0)
+		t.listField(1, thriftStruct, 1)
+		t.beginStruct(0)
+		t.i64Field(2, 4)
+		t.beginStruct(3)
+		t.i32Field(1, int32(ByteArray))
+		t.i32Field(4, codecSnappy)
+		t.i64Field(5, 3)
+		t.i64Field(7, int64(chunkSize))
+		t.i64Field(9, 4)
+		t.endStruct()
+		t.endStruct()
+		t.i64Field(3, 3)
+		t.endStruct()
+		t.endStruct()
+		file.Write(t.buf.Bytes())
+		binary.Write(&file, binary.LittleEndian, uint32(t.buf.Len()))
+		file.WriteString(magic)
+
+		reader, err := NewReader(bytes.NewReader(file.Bytes()), int64(file.Len()))
+		Ω(err).Should(BeNil())
+		Ω(reader.Columns()).Should(Equal([]Column{{Name: "name", Type: ByteArray, Logical: String}}))
+		values, err := reader.ReadRowGroup(0, []int{0})
+		Ω(err).Should(BeNil())
+		Ω(values).Should(Equal([][]interface{}{{"y", "x", "y"}}))
+	})
+
+	It("rejects invalid files", func() {
+		_, err := NewReader(bytes.NewReader([]byte("PAR1PAR1PAR1")), 12)
+		Ω(err).ShouldNot(BeNil())
+		_, err = NewReader(bytes.NewReader([]byte("not a parquet file")), 18)
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,115 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+// Type is the physical type of parquet values.
+type Type int32
+
+// List of physical types, values are defined by the parquet format.
+const (
+	Boolean           Type = 0
+	Int32             Type = 1
+	Int64             Type = 2
+	Int96             Type = 3
+	Float             Type = 4
+	Double            Type = 5
+	ByteArray         Type = 6
+	FixedLenByteArray Type = 7
+)
+
+// LogicalType tells readers how to interpret values of a physical type.
+type LogicalType int
+
+// List of logical types supported.
+const (
+	// NoLogicalType means values are interpreted as the physical type.
+	NoLogicalType LogicalType = iota
+	// String annotates UTF8 byte arrays.
+	String
+	// TimestampMillis annotates int64 milliseconds since epoch in UTC.
+	TimestampMillis
+	// Int8 annotates int32 values in int8 range.
+	Int8
+	// Int16 annotates int32 values in int16 range.
+	Int16
+	// Uint8 annotates int32 values in uint8 range.
+	Uint8
+	// Uint16 annotates int32 values in uint16 range.
+	Uint16
+	// Uint32 annotates int32 values interpreted as uint32.
+	Uint32
+	// Uint64 annotates int64 values interpreted as uint64.
+	Uint64
+	// TimestampMicros annotates int64 microseconds since epoch in UTC.
+	TimestampMicros
+	// TimestampNanos annotates int64 nanoseconds since epoch in UTC.
+	TimestampNanos
+	// Date annotates int32 days since epoch.
+	Date
+	// Decimal annotates scaled decimals, which are not supported by aresdb.
+	Decimal
+)
+
+// convertedTypes maps logical types to converted types defined by the parquet format, which are
+// understood by most readers.
+var convertedTypes = map[LogicalType]int32{
+	String:          0,
+	TimestampMillis: 9,
+	Uint8:           11,
+	Uint16:          12,
+	Int8:            15,
+	Int16:           16,
+}
+
+// logicalTypesByConvertedType maps converted types to logical types when reading. Converted
+// types not listed are read as the physical type.
+var logicalTypesByConvertedType = map[int64]LogicalType{
+	0:  String,
+	4:  String,
+	5:  Decimal,
+	6:  Date,
+	9:  TimestampMillis,
+	10: TimestampMicros,
+	11: Uint8,
+	12: Uint16,
+	13: Uint32,
+	14: Uint64,
+	15: Int8,
+	16: Int16,
+	19: String,
+}
+
+// Encodings and codecs defined by the parquet format.
+const (
+	encodingPlain           int32 = 0
+	encodingPlainDictionary int32 = 2
+	encodingRLE             int32 = 3
+	encodingRLEDictionary   int32 = 8
+	codecUncompressed       int32 = 0
+	codecSnappy             int32 = 1
+	codecGzip               int32 = 2
+	pageTypeData            int32 = 0
+	pageTypeDictionary      int32 = 2
+	pageTypeDataV2          int32 = 3
+	repetitionRequired      int32 = 0
+	repetitionOptional      int32 = 1
+)
+
+// Column describes a column in a parquet file. All columns are optional (nullable) and flat.
+type Column struct {
+	Name    string
+	Type    Type
+	Logical LogicalType
+}
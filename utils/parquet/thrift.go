@@ -0,0 +1,317 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// Types of the thrift compact protocol parquet metadata is serialized with.
+const (
+	thriftStop      byte = 0
+	thriftBoolTrue  byte = 1
+	thriftBoolFalse byte = 2
+	thriftI32       byte = 5
+	thriftI64       byte = 6
+	thriftBinary    byte = 8
+	thriftList      byte = 9
+	thriftStruct    byte = 12
+)
+
+// thriftWriter serializes thrift structs with the compact protocol.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// id of the last field written in the current struct.
+	lastFieldID int16
+	// last field ids of the enclosing structs.
+	lastFieldIDs []int16
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.buf.Write(buf[:n])
+}
+
+func (w *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	if delta := id - w.lastFieldID; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		w.varint(zigzag(int64(id)))
+	}
+	w.lastFieldID = id
+}
+
+func (w *thriftWriter) i32Field(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64Field(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		w.fieldHeader(id, thriftBoolTrue)
+	} else {
+		w.fieldHeader(id, thriftBoolFalse)
+	}
+}
+
+func (w *thriftWriter) binary(v []byte) {
+	w.varint(uint64(len(v)))
+	w.buf.Write(v)
+}
+
+func (w *thriftWriter) stringField(id int16, v string) {
+	w.fieldHeader(id, thriftBinary)
+	w.binary([]byte(v))
+}
+
+// listField writes the header of a list field, elements are written by the caller afterwards.
+func (w *thriftWriter) listField(id int16, elemType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *thriftWriter) i32Elem(v int32) {
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) stringElem(v string) {
+	w.binary([]byte(v))
+}
+
+// beginStruct starts a struct, either a field if id is positive or a list element otherwise.
+func (w *thriftWriter) beginStruct(id int16) {
+	if id > 0 {
+		w.fieldHeader(id, thriftStruct)
+	}
+	w.lastFieldIDs = append(w.lastFieldIDs, w.lastFieldID)
+	w.lastFieldID = 0
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf.WriteByte(thriftStop)
+	w.lastFieldID = w.lastFieldIDs[len(w.lastFieldIDs)-1]
+	w.lastFieldIDs = w.lastFieldIDs[:len(w.lastFieldIDs)-1]
+}
+
+// Types only skipped when reading.
+const (
+	thriftByte   byte = 3
+	thriftI16    byte = 4
+	thriftDouble byte = 7
+	thriftSet    byte = 10
+	thriftMap    byte = 11
+)
+
+// thriftFields holds fields of a decoded thrift struct by field id. Integers are decoded as
+// int64, binaries as []byte, lists and sets as []interface{} and structs as thriftFields.
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) i64(id int16) int64 {
+	v, _ := f[id].(int64)
+	return v
+}
+
+func (f thriftFields) has(id int16) bool {
+	_, ok := f[id]
+	return ok
+}
+
+func (f thriftFields) boolean(id int16, defaultValue bool) bool {
+	if v, ok := f[id].(bool); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (f thriftFields) str(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+func (f thriftFields) list(id int16) []interface{} {
+	v, _ := f[id].([]interface{})
+	return v
+}
+
+func (f thriftFields) strct(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+// thriftReader decodes thrift structs serialized with the compact protocol without knowing
+// their definitions.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) errorf(message string, args ...interface{}) error {
+	return utils.StackError(nil, "Failed to decode parquet metadata at %d: "+message,
+		append([]interface{}{r.pos}, args...)...)
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, r.errorf("unexpected end of data")
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, r.errorf("invalid varint")
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) zigzagVarint() (int64, error) {
+	v, err := r.varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+// value decodes a value of the type.
+func (r *thriftReader) value(valueType byte) (interface{}, error) {
+	switch valueType {
+	case thriftBoolTrue, thriftBoolFalse:
+		// Booleans in lists are encoded as a byte.
+		b, err := r.byte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzagVarint()
+	case thriftDouble:
+		if r.pos+8 > len(r.buf) {
+			return nil, r.errorf("unexpected end of data")
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case thriftBinary:
+		length, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(r.buf)-r.pos) < length {
+			return nil, r.errorf("unexpected end of data")
+		}
+		v := r.buf[r.pos : r.pos+int(length)]
+		r.pos += int(length)
+		return v, nil
+	case thriftList, thriftSet:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.varint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			return nil, r.errorf("invalid list size %d", size)
+		}
+		elems := make([]interface{}, size)
+		for i := range elems {
+			if elems[i], err = r.value(header & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	case thriftMap:
+		size, err := r.varint()
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		types, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < size; i++ {
+			if _, err = r.value(types >> 4); err != nil {
+				return nil, err
+			}
+			if _, err = r.value(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		// Maps are not used by the fields we read.
+		return nil, nil
+	case thriftStruct:
+		return r.strct()
+	}
+	return nil, r.errorf("unknown type %d", valueType)
+}
+
+// strct decodes a struct.
+func (r *thriftReader) strct() (thriftFields, error) {
+	fields := thriftFields{}
+	var lastFieldID int16
+	for {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == thriftStop {
+			return fields, nil
+		}
+		fieldType := header & 0x0f
+		if delta := int16(header >> 4); delta != 0 {
+			lastFieldID += delta
+		} else {
+			id, err := r.zigzagVarint()
+			if err != nil {
+				return nil, err
+			}
+			lastFieldID = int16(id)
+		}
+
+		switch fieldType {
+		case thriftBoolTrue, thriftBoolFalse:
+			// Booleans fields are encoded in the field type.
+			fields[lastFieldID] = fieldType == thriftBoolTrue
+		default:
+			if fields[lastFieldID], err = r.value(fieldType); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
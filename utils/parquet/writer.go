@@ -0,0 +1,295 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// magic is written at the beginning and the end of parquet files.
+const magic = "PAR1"
+
+// createdBy is recorded in the file metadata.
+const createdBy = "aresdb"
+
+// ColumnChunk buffers values of a column in a row group. Values are PLAIN encoded
+// and nulls are stored as RLE encoded definition levels.
+type ColumnChunk struct {
+	column Column
+	// number of values including nulls.
+	numValues int
+	// validity of each value, bit packed with LSB first.
+	validity []byte
+	values   bytes.Buffer
+	// number of booleans bit packed into the last byte of values.
+	numBoolBits uint
+}
+
+// NewColumnChunk creates an empty chunk for the column.
+func NewColumnChunk(column Column) *ColumnChunk {
+	return &ColumnChunk{column: column}
+}
+
+// NumValues returns the number of values appended including nulls.
+func (c *ColumnChunk) NumValues() int {
+	return c.numValues
+}
+
+func (c *ColumnChunk) appendValidity(valid bool) {
+	if c.numValues%8 == 0 {
+		c.validity = append(c.validity, 0)
+	}
+	if valid {
+		c.validity[len(c.validity)-1] |= 1 << uint(c.numValues%8)
+	}
+	c.numValues++
+}
+
+// AppendNull appends a null.
+func (c *ColumnChunk) AppendNull() {
+	c.appendValidity(false)
+}
+
+// AppendBool appends a value to a boolean column.
+func (c *ColumnChunk) AppendBool(v bool) {
+	c.appendValidity(true)
+	if c.numBoolBits%8 == 0 {
+		c.values.WriteByte(0)
+	}
+	if v {
+		c.values.Bytes()[c.values.Len()-1] |= 1 << (c.numBoolBits % 8)
+	}
+	c.numBoolBits++
+}
+
+// AppendInt32 appends a value to an int32 column.
+func (c *ColumnChunk) AppendInt32(v int32) {
+	c.appendValidity(true)
+	binary.Write(&c.values, binary.LittleEndian, v)
+}
+
+// AppendInt64 appends a value to an int64 column.
+func (c *ColumnChunk) AppendInt64(v int64) {
+	c.appendValidity(true)
+	binary.Write(&c.values, binary.LittleEndian, v)
+}
+
+// AppendFloat appends a value to a float column.
+func (c *ColumnChunk) AppendFloat(v float32) {
+	c.appendValidity(true)
+	binary.Write(&c.values, binary.LittleEndian, math.Float32bits(v))
+}
+
+// AppendDouble appends a value to a double column.
+func (c *ColumnChunk) AppendDouble(v float64) {
+	c.appendValidity(true)
+	binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+}
+
+// AppendString appends a value to a byte array column.
+func (c *ColumnChunk) AppendString(v string) {
+	c.appendValidity(true)
+	binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+	c.values.WriteString(v)
+}
+
+// definitionLevels returns the definition levels encoded with the RLE/bit-packing hybrid
+// encoding prefixed by its length. All values are written as a single bit packed run.
+func (c *ColumnChunk) definitionLevels() []byte {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(len(c.validity))<<1|1)
+	levels := make([]byte, 4, 4+n+len(c.validity))
+	binary.LittleEndian.PutUint32(levels, uint32(n+len(c.validity)))
+	levels = append(levels, header[:n]...)
+	return append(levels, c.validity...)
+}
+
+// columnChunkMetaData is the metadata of a column chunk written.
+type columnChunkMetaData struct {
+	column         Column
+	numValues      int64
+	totalSize      int64
+	dataPageOffset int64
+}
+
+// rowGroupMetaData is the metadata of a row group written.
+type rowGroupMetaData struct {
+	numRows int64
+	columns []columnChunkMetaData
+}
+
+// Writer writes row groups into a parquet file. Values are written uncompressed with one
+// data page per column chunk.
+type Writer struct {
+	writer    io.Writer
+	columns   []Column
+	offset    int64
+	rowGroups []rowGroupMetaData
+	numRows   int64
+}
+
+// NewWriter creates a writer writing a parquet file with the columns into writer.
+func NewWriter(writer io.Writer, columns []Column) *Writer {
+	return &Writer{
+		writer:  writer,
+		columns: columns,
+	}
+}
+
+func (w *Writer) write(data []byte) error {
+	if w.offset == 0 {
+		if _, err := io.WriteString(w.writer, magic); err != nil {
+			return utils.StackError(err, "Failed to write parquet header")
+		}
+		w.offset = int64(len(magic))
+	}
+	n, err := w.writer.Write(data)
+	w.offset += int64(n)
+	if err != nil {
+		return utils.StackError(err, "Failed to write parquet file")
+	}
+	return nil
+}
+
+// WriteRowGroup writes chunks of all columns with the same number of values as a row group.
+func (w *Writer) WriteRowGroup(chunks []*ColumnChunk) error {
+	if len(chunks) != len(w.columns) {
+		return utils.StackError(nil, "Expect %d column chunks, got %d", len(w.columns), len(chunks))
+	}
+	numRows := 0
+	if len(chunks) > 0 {
+		numRows = chunks[0].numValues
+	}
+
+	for i, chunk := range chunks {
+		if chunk.numValues != numRows {
+			return utils.StackError(nil, "Column %s has %d values, expect %d",
+				w.columns[i].Name, chunk.numValues, numRows)
+		}
+	}
+
+	rowGroup := rowGroupMetaData{numRows: int64(numRows)}
+	for i, chunk := range chunks {
+		levels := chunk.definitionLevels()
+		pageSize := len(levels) + chunk.values.Len()
+		var header thriftWriter
+		header.beginStruct(0)
+		header.i32Field(1, pageTypeData)
+		header.i32Field(2, int32(pageSize))
+		header.i32Field(3, int32(pageSize))
+		header.beginStruct(5)
+		header.i32Field(1, int32(chunk.numValues))
+		header.i32Field(2, encodingPlain)
+		header.i32Field(3, encodingRLE)
+		header.i32Field(4, encodingRLE)
+		header.endStruct()
+		header.endStruct()
+
+		metaData := columnChunkMetaData{
+			column:         w.columns[i],
+			numValues:      int64(chunk.numValues),
+			totalSize:      int64(header.buf.Len() + pageSize),
+			dataPageOffset: w.offset,
+		}
+		if metaData.dataPageOffset == 0 {
+			metaData.dataPageOffset = int64(len(magic))
+		}
+		for _, data := range [][]byte{header.buf.Bytes(), levels, chunk.values.Bytes()} {
+			if err := w.write(data); err != nil {
+				return err
+			}
+		}
+		rowGroup.columns = append(rowGroup.columns, metaData)
+	}
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	w.numRows += int64(numRows)
+	return nil
+}
+
+// Close writes the file metadata. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	footer := w.fileMetaData()
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, data := range [][]byte{footer, length[:], []byte(magic)} {
+		if err := w.write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileMetaData serializes the FileMetaData struct of the parquet format.
+func (w *Writer) fileMetaData() []byte {
+	var t thriftWriter
+	t.beginStruct(0)
+	t.i32Field(1, 1)
+
+	t.listField(2, thriftStruct, len(w.columns)+1)
+	t.beginStruct(0)
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(w.columns)))
+	t.endStruct()
+	for _, column := range w.columns {
+		t.beginStruct(0)
+		t.i32Field(1, int32(column.Type))
+		t.i32Field(3, repetitionOptional)
+		t.stringField(4, column.Name)
+		if convertedType, ok := convertedTypes[column.Logical]; ok {
+			t.i32Field(6, convertedType)
+		}
+		t.endStruct()
+	}
+
+	t.i64Field(3, w.numRows)
+
+	t.listField(4, thriftStruct, len(w.rowGroups))
+	for _, rowGroup := range w.rowGroups {
+		var totalSize int64
+		t.beginStruct(0)
+		t.listField(1, thriftStruct, len(rowGroup.columns))
+		for _, chunk := range rowGroup.columns {
+			totalSize += chunk.totalSize
+			t.beginStruct(0)
+			t.i64Field(2, chunk.dataPageOffset)
+			t.beginStruct(3)
+			t.i32Field(1, int32(chunk.column.Type))
+			t.listField(2, thriftI32, 2)
+			t.i32Elem(encodingPlain)
+			t.i32Elem(encodingRLE)
+			t.listField(3, thriftBinary, 1)
+			t.stringElem(chunk.column.Name)
+			t.i32Field(4, codecUncompressed)
+			t.i64Field(5, chunk.numValues)
+			t.i64Field(6, chunk.totalSize)
+			t.i64Field(7, chunk.totalSize)
+			t.i64Field(9, chunk.dataPageOffset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64Field(2, totalSize)
+		t.i64Field(3, rowGroup.numRows)
+		t.endStruct()
+	}
+
+	t.stringField(6, createdBy)
+	t.endStruct()
+	return t.buf.Bytes()
+}
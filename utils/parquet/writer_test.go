@@ -0,0 +1,104 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	It("serializes thrift compact protocol", func() {
+		var t thriftWriter
+		t.beginStruct(0)
+		t.i32Field(1, -1)
+		t.i64Field(20, 1)
+		t.boolField(21, true)
+		t.listField(22, thriftI32, 20)
+		t.beginStruct(23)
+		t.stringField(1, "ab")
+		t.endStruct()
+		t.i32Field(24, 1)
+		t.endStruct()
+		Ω(t.buf.Bytes()).Should(Equal([]byte{
+			0x15, 0x01,
+			0x06, 0x28, 0x02,
+			0x11,
+			0x19, 0xf5, 0x14,
+			0x1c, 0x18, 0x02, 'a', 'b', 0x00,
+			0x15, 0x02,
+			0x00,
+		}))
+	})
+
+	It("encodes values and definition levels", func() {
+		chunk := NewColumnChunk(Column{Name: "b", Type: Boolean})
+		chunk.AppendBool(true)
+		chunk.AppendNull()
+		chunk.AppendBool(false)
+		chunk.AppendBool(true)
+		Ω(chunk.NumValues()).Should(Equal(4))
+		Ω(chunk.values.Bytes()).Should(Equal([]byte{0x05}))
+		Ω(chunk.definitionLevels()).Should(Equal([]byte{2, 0, 0, 0, 0x03, 0x0d}))
+
+		chunk = NewColumnChunk(Column{Name: "s", Type: ByteArray, Logical: String})
+		chunk.AppendString("ab")
+		chunk.AppendInt32(1)
+		Ω(chunk.values.Bytes()).Should(Equal([]byte{2, 0, 0, 0, 'a', 'b', 1, 0, 0, 0}))
+	})
+
+	It("writes parquet files", func() {
+		columns := []Column{
+			{Name: "ts", Type: Int64, Logical: TimestampMillis},
+			{Name: "name", Type: ByteArray, Logical: String},
+		}
+		var buf bytes.Buffer
+		writer := NewWriter(&buf, columns)
+		for i := 0; i < 2; i++ {
+			ts, name := NewColumnChunk(columns[0]), NewColumnChunk(columns[1])
+			ts.AppendInt64(1000)
+			ts.AppendInt64(2000)
+			name.AppendString("a")
+			name.AppendNull()
+			Ω(writer.WriteRowGroup([]*ColumnChunk{ts, name})).Should(BeNil())
+		}
+		Ω(writer.WriteRowGroup([]*ColumnChunk{NewColumnChunk(columns[0])})).ShouldNot(BeNil())
+		mismatched := NewColumnChunk(columns[1])
+		mismatched.AppendNull()
+		Ω(writer.WriteRowGroup([]*ColumnChunk{NewColumnChunk(columns[0]), mismatched})).ShouldNot(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+
+		data := buf.Bytes()
+		Ω(string(data[:4])).Should(Equal(magic))
+		Ω(string(data[len(data)-4:])).Should(Equal(magic))
+		footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+		footer := data[len(data)-8-footerLength : len(data)-8]
+		Ω(footer).Should(Equal(writer.fileMetaData()))
+		Ω(writer.rowGroups).Should(HaveLen(2))
+		Ω(writer.numRows).Should(BeEquivalentTo(4))
+		Ω(writer.rowGroups[0].columns[0].dataPageOffset).Should(BeEquivalentTo(4))
+		offset := int64(4)
+		for _, rowGroup := range writer.rowGroups {
+			for _, chunk := range rowGroup.columns {
+				Ω(chunk.dataPageOffset).Should(Equal(offset))
+				offset += chunk.totalSize
+			}
+		}
+		Ω(offset).Should(BeEquivalentTo(len(data) - 8 - footerLength))
+	})
+})